@@ -3,6 +3,7 @@ package client
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -23,9 +24,15 @@ const (
 )
 
 const (
+	openEvent        = "open"
 	maxResponseBytes = 4096
 )
 
+var (
+	subscribeRetryDelayMin = time.Second // Delay before reconnecting, doubled after every failed attempt
+	subscribeRetryDelayMax = time.Minute
+)
+
 var (
 	topicRegex = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`) // Same as in server/server.go
 )
@@ -40,17 +47,23 @@ type Client struct {
 
 // Message is a struct that represents a ntfy message
 type Message struct { // TODO combine with server.message
-	ID         string
-	Event      string
-	Time       int64
-	Topic      string
-	Message    string
-	Title      string
-	Priority   int
-	Tags       []string
-	Click      string
-	Icon       string
-	Attachment *Attachment
+	ID            string
+	Event         string
+	Time          int64
+	Topic         string
+	Message       string
+	Title         string
+	Priority      int
+	Tags          []string
+	Click         string
+	Icon          string
+	Sound         string
+	Attachment    *Attachment
+	Actions       []*Action
+	InReplyTo     string    `json:"in_reply_to"`
+	Location      *Location `json:"location"`
+	Hostname      string    `json:"hostname"`
+	CorrelationID string    `json:"correlation_id"`
 
 	// Additional fields
 	TopicURL       string
@@ -58,6 +71,12 @@ type Message struct { // TODO combine with server.message
 	Raw            string
 }
 
+// Location represents the geo location of a message
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
 // Attachment represents a message attachment
 type Attachment struct {
 	Name    string `json:"name"`
@@ -68,10 +87,23 @@ type Attachment struct {
 	Owner   string `json:"-"` // IP address of uploader, used for rate limiting
 }
 
+// Action represents a user action attached to a message, see https://ntfy.sh/docs/publish/#action-buttons
+type Action struct {
+	ID      string            `json:"id"`
+	Action  string            `json:"action"` // "view", "broadcast", or "http"
+	Label   string            `json:"label"`
+	Clear   bool              `json:"clear"`
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
 type subscription struct {
 	ID       string
 	topicURL string
 	cancel   context.CancelFunc
+	since    string // Last message ID (or connection time) received, used to resume after reconnecting
 }
 
 // New creates a new Client using a given Config
@@ -98,7 +130,7 @@ func (c *Client) Publish(topic, message string, options ...PublishOption) (*Mess
 // To pass title, priority and tags, check out WithTitle, WithPriority, WithTagsList, WithDelay, WithNoCache,
 // WithNoFirebase, and the generic WithHeader.
 func (c *Client) PublishReader(topic string, body io.Reader, options ...PublishOption) (*Message, error) {
-	topicURL, err := c.expandTopicURL(topic)
+	topicURL, err := c.ExpandTopicURL(topic)
 	if err != nil {
 		return nil, err
 	}
@@ -106,6 +138,39 @@ func (c *Client) PublishReader(topic string, body io.Reader, options ...PublishO
 	if err != nil {
 		return nil, err
 	}
+	return c.publish(req, topicURL, options...)
+}
+
+// PublishJSON publishes a message given as JSON object (see https://ntfy.sh/docs/publish/#publish-as-json). If the
+// object does not contain a "topic" field, the message is sent to the given topic. Options are passed as headers, so
+// they serve as defaults for the fields that are not set in the JSON object.
+//
+// A topic can be either a full URL (e.g. https://myhost.lan/mytopic), a short URL which is then prepended https://
+// (e.g. myhost.lan -> https://myhost.lan), or a short name which is expanded using the default host in the
+// config (e.g. mytopic -> https://ntfy.sh/mytopic).
+func (c *Client) PublishJSON(topic string, message map[string]any, options ...PublishOption) (*Message, error) {
+	topicURL, err := c.ExpandTopicURL(topic)
+	if err != nil {
+		return nil, err
+	}
+	baseURL := topicURL[:strings.LastIndex(topicURL, "/")]
+	if t, ok := message["topic"].(string); ok && t != "" {
+		topicURL = fmt.Sprintf("%s/%s", baseURL, t)
+	} else {
+		message["topic"] = topicURL[len(baseURL)+1:]
+	}
+	b, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", baseURL+"/", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return c.publish(req, topicURL, options...)
+}
+
+func (c *Client) publish(req *http.Request, topicURL string, options ...PublishOption) (*Message, error) {
 	for _, option := range options {
 		if err := option(req); err != nil {
 			return nil, err
@@ -141,7 +206,7 @@ func (c *Client) PublishReader(topic string, body io.Reader, options ...PublishO
 // By default, all messages will be returned, but you can change this behavior using a SubscribeOption.
 // See WithSince, WithSinceAll, WithSinceUnixTime, WithScheduled, and the generic WithQueryParam.
 func (c *Client) Poll(topic string, options ...SubscribeOption) ([]*Message, error) {
-	topicURL, err := c.expandTopicURL(topic)
+	topicURL, err := c.ExpandTopicURL(topic)
 	if err != nil {
 		return nil, err
 	}
@@ -152,7 +217,7 @@ func (c *Client) Poll(topic string, options ...SubscribeOption) ([]*Message, err
 	log.Debug("%s Polling from topic", util.ShortTopicURL(topicURL))
 	options = append(options, WithPoll())
 	go func() {
-		err := performSubscribeRequest(ctx, msgChan, topicURL, "", options...)
+		err := performSubscribeRequest(ctx, msgChan, topicURL, "", nil, options...)
 		close(msgChan)
 		errChan <- err
 	}()
@@ -182,7 +247,7 @@ func (c *Client) Poll(topic string, options ...SubscribeOption) ([]*Message, err
 //	  fmt.Printf("New message: %s", m.Message)
 //	}
 func (c *Client) Subscribe(topic string, options ...SubscribeOption) (string, error) {
-	topicURL, err := c.expandTopicURL(topic)
+	topicURL, err := c.ExpandTopicURL(topic)
 	if err != nil {
 		return "", err
 	}
@@ -191,12 +256,13 @@ func (c *Client) Subscribe(topic string, options ...SubscribeOption) (string, er
 	subscriptionID := util.RandomString(10)
 	log.Debug("%s Subscribing to topic", util.ShortTopicURL(topicURL))
 	ctx, cancel := context.WithCancel(context.Background())
-	c.subscriptions[subscriptionID] = &subscription{
+	sub := &subscription{
 		ID:       subscriptionID,
 		topicURL: topicURL,
 		cancel:   cancel,
 	}
-	go handleSubscribeConnLoop(ctx, c.Messages, topicURL, subscriptionID, options...)
+	c.subscriptions[subscriptionID] = sub
+	go handleSubscribeConnLoop(ctx, c.Messages, sub, options...)
 	return subscriptionID, nil
 }
 
@@ -213,7 +279,9 @@ func (c *Client) Unsubscribe(subscriptionID string) {
 	sub.cancel()
 }
 
-func (c *Client) expandTopicURL(topic string) (string, error) {
+// ExpandTopicURL returns the full topic URL for the given topic. A topic can be either a full URL, a short URL
+// which is then prepended https://, or a short name which is expanded using the default host in the config.
+func (c *Client) ExpandTopicURL(topic string) (string, error) {
 	if strings.HasPrefix(topic, "http://") || strings.HasPrefix(topic, "https://") {
 		return topic, nil
 	} else if strings.Contains(topic, "/") {
@@ -225,23 +293,46 @@ func (c *Client) expandTopicURL(topic string) (string, error) {
 	return fmt.Sprintf("%s/%s", c.config.DefaultHost, topic), nil
 }
 
-func handleSubscribeConnLoop(ctx context.Context, msgChan chan *Message, topicURL, subcriptionID string, options ...SubscribeOption) {
+// handleSubscribeConnLoop keeps the connection of a single subscription open, until it is unsubscribed. Every
+// subscription has its own reconnect state: After a connection is lost, it resumes from the last received message
+// (using since=), and reconnects with an exponential backoff while the server cannot be reached.
+func handleSubscribeConnLoop(ctx context.Context, msgChan chan *Message, sub *subscription, options ...SubscribeOption) {
+	delay := subscribeRetryDelayMin
 	for {
-		// TODO The retry logic is crude and may lose messages. It should record the last message like the
-		//      Android client, use since=, and do incremental backoff too
-		if err := performSubscribeRequest(ctx, msgChan, topicURL, subcriptionID, options...); err != nil {
-			log.Warn("%s Connection failed: %s", util.ShortTopicURL(topicURL), err.Error())
+		connOptions := options
+		if sub.since != "" {
+			connOptions = append(append(make([]SubscribeOption, 0), options...), withSinceOverride(sub.since))
 		}
+		if err := performSubscribeRequest(ctx, msgChan, sub.topicURL, sub.ID, sub, connOptions...); err != nil {
+			log.Warn("%s Connection failed: %s", util.ShortTopicURL(sub.topicURL), err.Error())
+		} else {
+			delay = subscribeRetryDelayMin // Connection was established, so the server is reachable again
+		}
+		log.Debug("%s Reconnecting in %s", util.ShortTopicURL(sub.topicURL), delay)
 		select {
 		case <-ctx.Done():
-			log.Info("%s Connection exited", util.ShortTopicURL(topicURL))
+			log.Info("%s Connection exited", util.ShortTopicURL(sub.topicURL))
 			return
-		case <-time.After(10 * time.Second): // TODO Add incremental backoff
+		case <-time.After(delay):
 		}
+		delay = min(2*delay, subscribeRetryDelayMax)
 	}
 }
 
-func performSubscribeRequest(ctx context.Context, msgChan chan *Message, topicURL string, subscriptionID string, options ...SubscribeOption) error {
+// withSinceOverride replaces the since= query parameter passed via options (if any), so that
+// a subscription resumes where it left off after reconnecting
+func withSinceOverride(since string) SubscribeOption {
+	return func(r *http.Request) error {
+		q := r.URL.Query()
+		q.Set("since", since)
+		r.URL.RawQuery = q.Encode()
+		return nil
+	}
+}
+
+// performSubscribeRequest performs a single subscribe (or poll) request, and sends received messages to msgChan.
+// If sub is not nil, the last received message ID is recorded in it.
+func performSubscribeRequest(ctx context.Context, msgChan chan *Message, topicURL string, subscriptionID string, sub *subscription, options ...SubscribeOption) error {
 	streamURL := fmt.Sprintf("%s/json", topicURL)
 	log.Debug("%s Listening to %s", util.ShortTopicURL(topicURL), streamURL)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
@@ -273,6 +364,11 @@ func performSubscribeRequest(ctx context.Context, msgChan chan *Message, topicUR
 			return err
 		}
 		log.Trace("%s Message received: %s", util.ShortTopicURL(topicURL), messageJSON)
+		if sub != nil && m.Event == MessageEvent {
+			sub.since = m.ID
+		} else if sub != nil && m.Event == openEvent && sub.since == "" {
+			sub.since = fmt.Sprintf("%d", m.Time) // No message yet, resume from the time the connection was opened
+		}
 		if m.Event == MessageEvent {
 			msgChan <- m
 		}
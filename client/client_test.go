@@ -6,7 +6,10 @@ import (
 	"heckel.io/ntfy/v2/client"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/test"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -101,6 +104,61 @@ func TestClient_Publish_Poll(t *testing.T) {
 	require.Equal(t, "some delayed message", messages[1].Message)
 }
 
+func TestClient_PublishJSON(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	c := client.New(newTestConfig(port))
+
+	msg, err := c.PublishJSON("mytopic", map[string]any{"message": "some message", "tags": []string{"tag1"}}, client.WithTitle("default title"))
+	require.Nil(t, err)
+	require.Equal(t, "mytopic", msg.Topic)
+	require.Equal(t, "some message", msg.Message)
+	require.Equal(t, "default title", msg.Title)
+	require.Equal(t, []string{"tag1"}, msg.Tags)
+
+	msg, err = c.PublishJSON("mytopic", map[string]any{"topic": "othertopic", "message": "other message", "title": "my title"}, client.WithTitle("default title"))
+	require.Nil(t, err)
+	require.Equal(t, "othertopic", msg.Topic)
+	require.Equal(t, "my title", msg.Title)
+
+	messages, err := c.Poll("othertopic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "other message", messages[0].Message)
+}
+
+func TestClient_Subscribe_Reconnect_Since(t *testing.T) {
+	var requests atomic.Int32
+	sinceChan := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sinceChan <- r.URL.Query().Get("since")
+		if requests.Add(1) == 1 {
+			w.Write([]byte(`{"id":"open1","time":123,"event":"open","topic":"mytopic"}` + "\n"))
+			w.Write([]byte(`{"id":"msg1","time":124,"event":"message","topic":"mytopic","message":"first"}` + "\n"))
+			return // Closes the connection
+		}
+		w.Write([]byte(`{"id":"msg2","time":125,"event":"message","topic":"mytopic","message":"second"}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	c := client.New(client.NewConfig())
+	subscriptionID, err := c.Subscribe(server.URL+"/mytopic", client.WithSinceAll())
+	require.Nil(t, err)
+	for _, expected := range []string{"first", "second"} {
+		select {
+		case m := <-c.Messages:
+			require.Equal(t, expected, m.Message)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %s not received", expected)
+		}
+	}
+	c.Unsubscribe(subscriptionID)
+	require.Equal(t, "all", <-sinceChan)
+	require.Equal(t, "msg1", <-sinceChan)
+}
+
 func newTestConfig(port int) *client.Config {
 	c := client.NewConfig()
 	c.DefaultHost = fmt.Sprintf("http://127.0.0.1:%d", port)
@@ -22,12 +22,21 @@ type Config struct {
 
 // Subscribe is the struct for a Subscription within Config
 type Subscribe struct {
+	Host     string            `yaml:"host"`
 	Topic    string            `yaml:"topic"`
 	User     *string           `yaml:"user"`
 	Password *string           `yaml:"password"`
 	Token    *string           `yaml:"token"`
 	Command  string            `yaml:"command"`
 	If       map[string]string `yaml:"if"`
+	Download *Download         `yaml:"download"`
+}
+
+// Download defines which attachments of a subscription are downloaded automatically, and to which directory
+type Download struct {
+	Dir     string   `yaml:"dir"`
+	Types   []string `yaml:"types"`    // MIME types, e.g. image/* or application/pdf (all types if empty)
+	MaxSize string   `yaml:"max-size"` // e.g. 10M (no limit if empty)
 }
 
 // NewConfig creates a new Config struct for a Client
@@ -138,3 +138,24 @@ subscribe:
 	require.Nil(t, conf.Subscribe[0].Password)
 	require.Nil(t, conf.Subscribe[0].Token)
 }
+
+func TestConfig_Load_Download(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "client.yml")
+	require.Nil(t, os.WriteFile(filename, []byte(`
+subscribe:
+  - topic: scans
+    download:
+      dir: /srv/inbox
+      types: [image/*, application/pdf]
+      max-size: 20M
+  - topic: no-download
+`), 0600))
+
+	conf, err := client.LoadConfig(filename)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(conf.Subscribe))
+	require.Equal(t, "/srv/inbox", conf.Subscribe[0].Download.Dir)
+	require.Equal(t, []string{"image/*", "application/pdf"}, conf.Subscribe[0].Download.Types)
+	require.Equal(t, "20M", conf.Subscribe[0].Download.MaxSize)
+	require.Nil(t, conf.Subscribe[1].Download)
+}
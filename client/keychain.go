@@ -0,0 +1,87 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// KeychainPrefix marks credentials in the config file that are stored in the OS keychain,
+	// e.g. "default-token: keychain:ntfy.sh/default-token"
+	KeychainPrefix = "keychain:"
+
+	keychainService = "ntfy"
+)
+
+var (
+	// ErrKeychainUnavailable is returned if there is no keychain on this system (e.g. secret-tool is not installed)
+	ErrKeychainUnavailable = errors.New("OS keychain not available")
+
+	// ErrKeychainNotFound is returned if there is no secret with the given name in the keychain
+	ErrKeychainNotFound = errors.New("secret not found in OS keychain")
+
+	keychainNameRegex = regexp.MustCompile(`^[-_.:/@A-Za-z0-9]{1,128}$`)
+)
+
+// Keychain stores secrets in the OS keychain (Keychain on macOS, libsecret on Linux, Windows Credential Manager)
+type Keychain interface {
+	Get(name string) (string, error)
+	Set(name, secret string) error
+	Delete(name string) error
+}
+
+// NewKeychain returns the keychain of the current OS
+func NewKeychain() Keychain {
+	return newOSKeychain()
+}
+
+// KeychainReference returns the name of the secret, if the value references a secret in the keychain
+func KeychainReference(value string) (name string, ok bool) {
+	if !strings.HasPrefix(value, KeychainPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(value, KeychainPrefix), true
+}
+
+// ValidKeychainName returns true if the name can be used as the name of a secret in the keychain
+func ValidKeychainName(name string) bool {
+	return keychainNameRegex.MatchString(name)
+}
+
+// ResolveKeychainSecrets replaces the credentials in the config that reference a secret in the keychain with the
+// secret itself. If k is nil, i.e. the keychain is disabled, an error is returned for the first reference.
+func (c *Config) ResolveKeychainSecrets(k Keychain) error {
+	for _, value := range c.credentials() {
+		name, ok := KeychainReference(*value)
+		if !ok {
+			continue
+		} else if k == nil {
+			return fmt.Errorf("config references secret %s in the OS keychain, but the keychain is disabled", name)
+		}
+		secret, err := k.Get(name)
+		if err != nil {
+			return fmt.Errorf("cannot read secret %s from the OS keychain: %w", name, err)
+		}
+		*value = secret
+	}
+	return nil
+}
+
+// credentials returns pointers to all passwords and tokens in the config
+func (c *Config) credentials() []*string {
+	values := []*string{&c.DefaultToken}
+	if c.DefaultPassword != nil {
+		values = append(values, c.DefaultPassword)
+	}
+	for i := range c.Subscribe {
+		if c.Subscribe[i].Password != nil {
+			values = append(values, c.Subscribe[i].Password)
+		}
+		if c.Subscribe[i].Token != nil {
+			values = append(values, c.Subscribe[i].Token)
+		}
+	}
+	return values
+}
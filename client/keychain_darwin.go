@@ -0,0 +1,67 @@
+package client
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	keychainSecurityNotFoundExitCode = 44
+)
+
+// darwinKeychain stores secrets in the macOS login keychain, using the "security" command
+type darwinKeychain struct{}
+
+func newOSKeychain() Keychain {
+	return &darwinKeychain{}
+}
+
+func (k *darwinKeychain) Get(name string) (string, error) {
+	if !ValidKeychainName(name) {
+		return "", fmt.Errorf("invalid secret name %s", name)
+	}
+	out, err := k.run(nil, "find-generic-password", "-s", keychainService, "-a", name, "-w")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (k *darwinKeychain) Set(name, secret string) error {
+	if !ValidKeychainName(name) {
+		return fmt.Errorf("invalid secret name %s", name)
+	}
+	// The secret is passed hex-encoded via stdin (interactive mode), so it does not show up in the process list
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n", keychainService, name, hex.EncodeToString([]byte(secret)))
+	_, err := k.run(strings.NewReader(command), "-i")
+	return err
+}
+
+func (k *darwinKeychain) Delete(name string) error {
+	if !ValidKeychainName(name) {
+		return fmt.Errorf("invalid secret name %s", name)
+	}
+	_, err := k.run(nil, "delete-generic-password", "-s", keychainService, "-a", name)
+	return err
+}
+
+func (k *darwinKeychain) run(stdin *strings.Reader, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, ErrKeychainUnavailable
+	}
+	cmd := exec.Command("security", args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == keychainSecurityNotFoundExitCode {
+		return nil, ErrKeychainNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("security %s failed: %w", args[0], err)
+	}
+	return out, nil
+}
@@ -0,0 +1,72 @@
+//go:build linux || dragonfly || freebsd || netbsd || openbsd
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// secretServiceKeychain stores secrets via the Secret Service API (GNOME Keyring, KWallet, ...), using
+// the "secret-tool" command from libsecret
+type secretServiceKeychain struct{}
+
+func newOSKeychain() Keychain {
+	return &secretServiceKeychain{}
+}
+
+func (k *secretServiceKeychain) Get(name string) (string, error) {
+	if !ValidKeychainName(name) {
+		return "", fmt.Errorf("invalid secret name %s", name)
+	}
+	out, err := k.run(nil, "lookup", "service", keychainService, "account", name)
+	if err != nil {
+		return "", err
+	} else if len(out) == 0 {
+		return "", ErrKeychainNotFound
+	}
+	return string(out), nil
+}
+
+func (k *secretServiceKeychain) Set(name, secret string) error {
+	if !ValidKeychainName(name) {
+		return fmt.Errorf("invalid secret name %s", name)
+	}
+	// The secret is passed via stdin, so it does not show up in the process list
+	_, err := k.run(strings.NewReader(secret), "store", "--label", fmt.Sprintf("ntfy: %s", name), "service", keychainService, "account", name)
+	return err
+}
+
+func (k *secretServiceKeychain) Delete(name string) error {
+	if !ValidKeychainName(name) {
+		return fmt.Errorf("invalid secret name %s", name)
+	}
+	if _, err := k.Get(name); err != nil {
+		return err // secret-tool does not fail if the secret does not exist
+	}
+	_, err := k.run(nil, "clear", "service", keychainService, "account", name)
+	return err
+}
+
+func (k *secretServiceKeychain) run(stdin *strings.Reader, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil, ErrKeychainUnavailable
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stderr = &stderr
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && args[0] == "lookup" && stderr.Len() == 0 {
+		return nil, ErrKeychainNotFound // secret-tool exits with 1 and no output if the secret does not exist
+	} else if err != nil {
+		return nil, fmt.Errorf("secret-tool %s failed: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
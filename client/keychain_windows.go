@@ -0,0 +1,114 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = syscall.Errno(1168)
+)
+
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredWriteW = advapi32.NewProc("CredWriteW")
+	procCredDelete = advapi32.NewProc("CredDeleteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+// credential is the CREDENTIALW struct, see https://learn.microsoft.com/en-us/windows/win32/api/wincred/ns-wincred-credentialw
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// windowsKeychain stores secrets as generic credentials in the Windows Credential Manager
+type windowsKeychain struct{}
+
+func newOSKeychain() Keychain {
+	return &windowsKeychain{}
+}
+
+func (k *windowsKeychain) Get(name string) (string, error) {
+	target, err := k.target(name)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	if r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); r == 0 {
+		return "", k.error(err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func (k *windowsKeychain) Set(name, secret string) error {
+	target, err := k.target(name)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	blob := []byte(secret)
+	cred := &credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+	if r, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(cred)), 0); r == 0 {
+		return k.error(err)
+	}
+	return nil
+}
+
+func (k *windowsKeychain) Delete(name string) error {
+	target, err := k.target(name)
+	if err != nil {
+		return err
+	}
+	if r, _, err := procCredDelete.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); r == 0 {
+		return k.error(err)
+	}
+	return nil
+}
+
+func (k *windowsKeychain) target(name string) (*uint16, error) {
+	if !ValidKeychainName(name) {
+		return nil, fmt.Errorf("invalid secret name %s", name)
+	} else if err := advapi32.Load(); err != nil {
+		return nil, ErrKeychainUnavailable
+	}
+	return syscall.UTF16PtrFromString(fmt.Sprintf("%s:%s", keychainService, name))
+}
+
+func (k *windowsKeychain) error(err error) error {
+	if errors.Is(err, errorNotFound) {
+		return ErrKeychainNotFound
+	}
+	return fmt.Errorf("credential manager: %w", err)
+}
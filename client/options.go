@@ -61,6 +61,33 @@ func WithIcon(icon string) PublishOption {
 	return WithHeader("X-Icon", icon)
 }
 
+// WithSound makes the notification play the sound with the given name
+func WithSound(sound string) PublishOption {
+	return WithHeader("X-Sound", sound)
+}
+
+// WithInReplyTo marks the message as a reply to the message with the given ID, see
+// https://ntfy.sh/docs/publish/#threads for details.
+func WithInReplyTo(messageID string) PublishOption {
+	return WithHeader("X-In-Reply-To", messageID)
+}
+
+// WithLocation sets the geo location the message refers to (e.g. of a tracked vehicle), in the
+// format "latitude,longitude", e.g. "52.52,13.405"
+func WithLocation(location string) PublishOption {
+	return WithHeader("X-Location", location)
+}
+
+// WithHostname sets the host the message originates from
+func WithHostname(hostname string) PublishOption {
+	return WithHeader("X-Hostname", hostname)
+}
+
+// WithCorrelationID relates the message to other messages with the same correlation ID, e.g. an incident ID
+func WithCorrelationID(correlationID string) PublishOption {
+	return WithHeader("X-Correlation-ID", correlationID)
+}
+
 // WithActions adds custom user actions to the notification. The value can be either a JSON array or the
 // simple format definition. See https://ntfy.sh/docs/publish/#action-buttons for details.
 func WithActions(value string) PublishOption {
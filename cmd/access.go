@@ -31,6 +31,41 @@ var cmdAccess = &cli.Command{
 	Before:    initConfigFileInputSourceFunc("config", flagsAccess, initLogFunc),
 	Action:    execUserAccess,
 	Category:  categoryServer,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "export",
+			Usage:     "Exports the access control list as YAML or JSON",
+			UsageText: "ntfy access export [--format=yaml|json] [FILE]",
+			Action:    execAccessExport,
+			Flags:     flagsAuthExport,
+			Description: `Exports all access control entries (user or group, topic pattern and permission) as YAML or JSON.
+
+The export can be used for backups, for reviewing the access control list, or to replicate it to a
+standby server via 'ntfy access import'. If FILE is not given, the export is written to stdout.
+
+Examples:
+  ntfy access export                         # Print the access control list as YAML
+  ntfy access export --format=json acl.json  # Write the access control list to acl.json
+`,
+		},
+		{
+			Name:      "import",
+			Usage:     "Imports access control entries from a YAML or JSON file created by 'ntfy access export'",
+			UsageText: "ntfy access import [FILE]",
+			Action:    execAccessImport,
+			Description: `Imports access control entries from a YAML or JSON file created by 'ntfy access export'.
+
+Entries are added, or updated if an entry for the same user or group and topic pattern already exists.
+Entries that are not in the file are left untouched. Users and groups must exist before access can be
+granted to them, so import users first (see 'ntfy user import'). If FILE is not given, the entries are
+read from stdin.
+
+Examples:
+  ntfy access import acl.yml                           # Import access control entries from acl.yml
+  ntfy access export | ssh standby ntfy access import  # Replicate the access control list to a standby server
+`,
+		},
+	},
 	Description: `Manage the access control list for the ntfy server.
 
 This is a server-only command. It directly manages the user.db as defined in the server config
@@ -44,6 +79,7 @@ Usage:
   ntfy access                            # Shows access control list (alias: 'ntfy user list')
   ntfy access USERNAME                   # Shows access control entries for USERNAME
   ntfy access USERNAME TOPIC PERMISSION  # Allow/deny access for USERNAME to TOPIC
+  ntfy access export|import [FILE]       # Export/import the access control list as YAML or JSON
 
 Arguments:
   USERNAME     an existing user, as created with 'ntfy user add', or "everyone"/"*"
@@ -53,6 +89,8 @@ Arguments:
                - read-write (alias: rw) 
                - read-only (aliases: read, ro)
                - write-only (aliases: write, wo)
+               - anon-read-auth-write (alias: public-read), only for "everyone":
+                 anyone can read, but only authenticated users can write
                - deny (alias: none)
 
 Examples:
@@ -64,6 +102,8 @@ Examples:
   ntfy access --reset                # Reset entire access control list
   ntfy access --reset phil           # Reset all access for user phil
   ntfy access --reset phil mytopic   # Reset access for user phil and topic mytopic
+  ntfy access export acl.yml         # Export the access control list to acl.yml
+  ntfy access import acl.yml         # Import access control entries from acl.yml
 `,
 }
 
@@ -97,12 +137,14 @@ func execUserAccess(c *cli.Context) error {
 }
 
 func changeAccess(c *cli.Context, manager *user.Manager, username string, topic string, perms string) error {
-	if !util.Contains([]string{"", "read-write", "rw", "read-only", "read", "ro", "write-only", "write", "wo", "none", "deny"}, perms) {
-		return errors.New("permission must be one of: read-write, read-only, write-only, or deny (or the aliases: read, ro, write, wo, none)")
+	if !util.Contains([]string{"", "read-write", "rw", "read-only", "read", "ro", "write-only", "write", "wo", "anon-read-auth-write", "public-read", "none", "deny"}, perms) {
+		return errors.New("permission must be one of: read-write, read-only, write-only, anon-read-auth-write, or deny (or the aliases: read, ro, write, wo, public-read, none)")
 	}
 	permission, err := user.ParsePermission(perms)
 	if err != nil {
 		return err
+	} else if permission.IsAuthWrite() && username != user.Everyone {
+		return errors.New("permission anon-read-auth-write can only be granted to everyone")
 	}
 	u, err := manager.User(username)
 	if err == user.ErrUserNotFound {
@@ -115,6 +157,8 @@ func changeAccess(c *cli.Context, manager *user.Manager, username string, topic
 	}
 	if permission.IsReadWrite() {
 		fmt.Fprintf(c.App.ErrWriter, "granted read-write access to topic %s\n\n", topic)
+	} else if permission.IsAuthWrite() {
+		fmt.Fprintf(c.App.ErrWriter, "granted read access (write for authenticated users only) to topic %s\n\n", topic)
 	} else if permission.IsRead() {
 		fmt.Fprintf(c.App.ErrWriter, "granted read-only access to topic %s\n\n", topic)
 	} else if permission.IsWrite() {
@@ -193,13 +237,19 @@ func showUsers(c *cli.Context, manager *user.Manager, users []*user.User) error
 		if u.Tier != nil {
 			tier = u.Tier.Name
 		}
-		fmt.Fprintf(c.App.ErrWriter, "user %s (role: %s, tier: %s)\n", u.Name, u.Role, tier)
+		if u.Disabled {
+			fmt.Fprintf(c.App.ErrWriter, "user %s (role: %s, tier: %s, disabled)\n", u.Name, u.Role, tier)
+		} else {
+			fmt.Fprintf(c.App.ErrWriter, "user %s (role: %s, tier: %s)\n", u.Name, u.Role, tier)
+		}
 		if u.Role == user.RoleAdmin {
 			fmt.Fprintf(c.App.ErrWriter, "- read-write access to all topics (admin role)\n")
 		} else if len(grants) > 0 {
 			for _, grant := range grants {
 				if grant.Allow.IsReadWrite() {
 					fmt.Fprintf(c.App.ErrWriter, "- read-write access to topic %s\n", grant.TopicPattern)
+				} else if grant.Allow.IsAuthWrite() {
+					fmt.Fprintf(c.App.ErrWriter, "- read access (write for authenticated users only) to topic %s\n", grant.TopicPattern)
 				} else if grant.Allow.IsRead() {
 					fmt.Fprintf(c.App.ErrWriter, "- read-only access to topic %s\n", grant.TopicPattern)
 				} else if grant.Allow.IsWrite() {
@@ -215,6 +265,8 @@ func showUsers(c *cli.Context, manager *user.Manager, users []*user.User) error
 			access := manager.DefaultAccess()
 			if access.IsReadWrite() {
 				fmt.Fprintln(c.App.ErrWriter, "- read-write access to all (other) topics (server config)")
+			} else if access.IsAuthWrite() {
+				fmt.Fprintln(c.App.ErrWriter, "- read access (write for authenticated users only) to all (other) topics (server config)")
 			} else if access.IsRead() {
 				fmt.Fprintln(c.App.ErrWriter, "- read-only access to all (other) topics (server config)")
 			} else if access.IsWrite() {
@@ -2,10 +2,13 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
 	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"io"
 	"os"
 	"regexp"
 )
@@ -25,6 +28,10 @@ var flagsDefault = []cli.Flag{
 	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "log-level-overrides", Aliases: []string{"log_level_overrides"}, EnvVars: []string{"NTFY_LOG_LEVEL_OVERRIDES"}, Usage: "set log level overrides"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "log-format", Aliases: []string{"log_format"}, Value: log.TextFormat.String(), EnvVars: []string{"NTFY_LOG_FORMAT"}, Usage: "set log format"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "log-file", Aliases: []string{"log_file"}, EnvVars: []string{"NTFY_LOG_FILE"}, Usage: "set log file, default is STDOUT"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "log-file-max-size", Aliases: []string{"log_file_max_size"}, EnvVars: []string{"NTFY_LOG_FILE_MAX_SIZE"}, Usage: "rotate log file if it exceeds this size (e.g. 100M), default is no size-based rotation"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "log-file-rotate-interval", Aliases: []string{"log_file_rotate_interval"}, EnvVars: []string{"NTFY_LOG_FILE_ROTATE_INTERVAL"}, Usage: "rotate log file at this interval (e.g. 24h), default is no time-based rotation"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "log-file-max-backups", Aliases: []string{"log_file_max_backups"}, EnvVars: []string{"NTFY_LOG_FILE_MAX_BACKUPS"}, Value: 7, Usage: "number of rotated log files to keep, 0 to keep all"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "log-file-compress", Aliases: []string{"log_file_compress"}, EnvVars: []string{"NTFY_LOG_FILE_COMPRESS"}, Usage: "gzip-compress rotated log files"}),
 }
 
 var (
@@ -39,6 +46,7 @@ func New() *cli.App {
 		UsageText:              "ntfy [OPTION..]",
 		HideVersion:            true,
 		UseShortOptionHandling: true,
+		EnableBashCompletion:   true,
 		Reader:                 os.Stdin,
 		Writer:                 os.Stdout,
 		ErrWriter:              os.Stderr,
@@ -64,7 +72,7 @@ func initLogFunc(c *cli.Context) error {
 	}
 	logFile := c.String("log-file")
 	if logFile != "" {
-		w, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		w, err := openLogFile(c, logFile)
 		if err != nil {
 			return err
 		}
@@ -73,6 +81,34 @@ func initLogFunc(c *cli.Context) error {
 	return nil
 }
 
+func openLogFile(c *cli.Context, logFile string) (io.Writer, error) {
+	logFileMaxSizeStr := c.String("log-file-max-size")
+	logFileRotateInterval := c.Duration("log-file-rotate-interval")
+	logFileMaxBackups := c.Int("log-file-max-backups")
+	logFileCompress := c.Bool("log-file-compress")
+	var logFileMaxSize int64
+	if logFileMaxSizeStr != "" {
+		var err error
+		logFileMaxSize, err = util.ParseSize(logFileMaxSizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log-file-max-size: %w", err)
+		}
+	}
+	if logFileRotateInterval < 0 {
+		return nil, errors.New("if set, log-file-rotate-interval must be a positive duration")
+	} else if logFileMaxBackups < 0 {
+		return nil, errors.New("log-file-max-backups must be zero or a positive number")
+	}
+	if logFileMaxSize == 0 && logFileRotateInterval == 0 {
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	return log.NewRotatingFile(logFile, logFileMaxSize, logFileRotateInterval, logFileMaxBackups, logFileCompress)
+}
+
 func applyLogLevelOverrides(rawOverrides []string) error {
 	for _, override := range rawOverrides {
 		m := logLevelOverrideRegex.FindStringSubmatch(override)
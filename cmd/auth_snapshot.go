@@ -0,0 +1,419 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/netip"
+	"os"
+	"time"
+)
+
+const (
+	authSnapshotFormatYAML = "yaml"
+	authSnapshotFormatJSON = "json"
+)
+
+// authSnapshot is the declarative format written by 'ntfy user export' and 'ntfy access export', and read by
+// the respective import commands. Since YAML is a superset of JSON, both formats can be imported.
+type authSnapshot struct {
+	Users  []*authSnapshotUser  `yaml:"users,omitempty" json:"users,omitempty"`
+	Groups []*authSnapshotGroup `yaml:"groups,omitempty" json:"groups,omitempty"`
+	Access []*authSnapshotGrant `yaml:"access,omitempty" json:"access,omitempty"`
+}
+
+type authSnapshotUser struct {
+	Name     string               `yaml:"name" json:"name"`
+	Hash     string               `yaml:"hash" json:"hash"` // bcrypt password hash
+	Role     string               `yaml:"role" json:"role"`
+	Tier     string               `yaml:"tier,omitempty" json:"tier,omitempty"` // Tier code
+	Disabled bool                 `yaml:"disabled,omitempty" json:"disabled,omitempty"`
+	Tokens   []*authSnapshotToken `yaml:"tokens,omitempty" json:"tokens,omitempty"`
+}
+
+type authSnapshotToken struct {
+	Token      string   `yaml:"token" json:"token"`
+	Label      string   `yaml:"label,omitempty" json:"label,omitempty"`
+	Expires    int64    `yaml:"expires,omitempty" json:"expires,omitempty"` // Unix timestamp, or 0 if the token never expires
+	AllowedIPs []string `yaml:"allowed_ips,omitempty" json:"allowed_ips,omitempty"`
+}
+
+type authSnapshotGroup struct {
+	Name    string   `yaml:"name" json:"name"`
+	Members []string `yaml:"members,omitempty" json:"members,omitempty"` // Usernames
+}
+
+type authSnapshotGrant struct {
+	User       string `yaml:"user,omitempty" json:"user,omitempty"`   // Username, or "everyone"; empty for group entries
+	Group      string `yaml:"group,omitempty" json:"group,omitempty"` // Group name; empty for user entries
+	Topic      string `yaml:"topic" json:"topic"`
+	Permission string `yaml:"permission" json:"permission"`
+}
+
+var flagsAuthExport = []cli.Flag{
+	&cli.StringFlag{Name: "format", Aliases: []string{"f"}, Value: authSnapshotFormatYAML, Usage: "output format (yaml or json)"},
+}
+
+func execUserExport(c *cli.Context) error {
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	users, err := manager.Users()
+	if err != nil {
+		return err
+	}
+	snapshot := &authSnapshot{
+		Users: make([]*authSnapshotUser, 0),
+	}
+	for _, u := range users {
+		if u.Name == user.Everyone {
+			continue
+		}
+		entry := &authSnapshotUser{
+			Name:     u.Name,
+			Hash:     u.Hash,
+			Role:     string(u.Role),
+			Disabled: u.Disabled,
+		}
+		if u.Tier != nil {
+			entry.Tier = u.Tier.Code
+		}
+		tokens, err := manager.Tokens(u.ID)
+		if err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			if t.Impersonator != "" {
+				continue // Short-lived, and bound to the admin's session
+			}
+			token := &authSnapshotToken{
+				Token: t.Value,
+				Label: t.Label,
+			}
+			if t.Expires.Unix() > 0 {
+				token.Expires = t.Expires.Unix()
+			}
+			for _, prefix := range t.AllowedIPs {
+				token.AllowedIPs = append(token.AllowedIPs, prefix.String())
+			}
+			entry.Tokens = append(entry.Tokens, token)
+		}
+		snapshot.Users = append(snapshot.Users, entry)
+	}
+	groups, err := manager.Groups()
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		snapshot.Groups = append(snapshot.Groups, &authSnapshotGroup{
+			Name:    g.Name,
+			Members: g.Members,
+		})
+	}
+	return writeAuthSnapshot(c, snapshot)
+}
+
+func execUserImport(c *cli.Context) error {
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	snapshot, err := readAuthSnapshot(c)
+	if err != nil {
+		return err
+	}
+	for _, entry := range snapshot.Users {
+		if err := importUser(c, manager, entry); err != nil {
+			return fmt.Errorf("cannot import user %s: %w", entry.Name, err)
+		}
+	}
+	for _, entry := range snapshot.Groups {
+		if err := importGroup(c, manager, entry); err != nil {
+			return fmt.Errorf("cannot import group %s: %w", entry.Name, err)
+		}
+	}
+	fmt.Fprintf(c.App.ErrWriter, "imported %d user(s)\n", len(snapshot.Users))
+	if len(snapshot.Groups) > 0 {
+		fmt.Fprintf(c.App.ErrWriter, "imported %d group(s)\n", len(snapshot.Groups))
+	}
+	return nil
+}
+
+func importUser(c *cli.Context, manager *user.Manager, entry *authSnapshotUser) error {
+	role := user.Role(entry.Role)
+	if !user.AllowedUsername(entry.Name) || entry.Name == userEveryone {
+		return errors.New("invalid username")
+	} else if !user.AllowedRole(role) {
+		return fmt.Errorf("invalid role %s", entry.Role)
+	}
+	u, err := manager.User(entry.Name)
+	if err == user.ErrUserNotFound {
+		if err := manager.AddUserWithHash(entry.Name, entry.Hash, role); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.ErrWriter, "user %s added with role %s\n", entry.Name, role)
+		u, err = manager.User(entry.Name)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else {
+		if err := manager.ChangePasswordHash(entry.Name, entry.Hash); err != nil {
+			return err
+		}
+		if u.Role != role {
+			if err := manager.ChangeRole(entry.Name, role); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(c.App.ErrWriter, "user %s updated with role %s\n", entry.Name, role)
+	}
+	if entry.Tier != "" && (u.Tier == nil || u.Tier.Code != entry.Tier) {
+		if err := manager.ChangeTier(entry.Name, entry.Tier); err != nil {
+			return err
+		}
+	} else if entry.Tier == "" && u.Tier != nil {
+		if err := manager.ResetTier(entry.Name); err != nil {
+			return err
+		}
+	}
+	if u.Disabled != entry.Disabled {
+		if err := manager.ChangeDisabled(entry.Name, entry.Disabled); err != nil {
+			return err
+		}
+	}
+	for _, token := range entry.Tokens {
+		if err := importToken(manager, entry.Name, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func importToken(manager *user.Manager, username string, entry *authSnapshotToken) error {
+	token := &user.Token{
+		Value:   entry.Token,
+		Label:   entry.Label,
+		Expires: time.Unix(entry.Expires, 0),
+	}
+	for _, s := range entry.AllowedIPs {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("invalid allowed IP range %s", s)
+		}
+		token.AllowedIPs = append(token.AllowedIPs, prefix)
+	}
+	if err := manager.ImportToken(username, token); err == user.ErrInvalidArgument {
+		return errors.New("invalid token")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// importGroup creates the group if it does not exist, and changes its members to match the snapshot
+func importGroup(c *cli.Context, manager *user.Manager, entry *authSnapshotGroup) error {
+	if !user.AllowedGroup(entry.Name) {
+		return errors.New("invalid group name")
+	}
+	g, err := manager.Group(entry.Name)
+	if err == user.ErrGroupNotFound {
+		if err := manager.AddGroup(entry.Name); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.ErrWriter, "group %s added\n", entry.Name)
+		g = &user.Group{Name: entry.Name}
+	} else if err != nil {
+		return err
+	} else {
+		fmt.Fprintf(c.App.ErrWriter, "group %s updated\n", entry.Name)
+	}
+	add, remove := make([]string, 0), make([]string, 0)
+	for _, member := range entry.Members {
+		if !util.Contains(g.Members, member) {
+			add = append(add, member)
+		}
+	}
+	for _, member := range g.Members {
+		if !util.Contains(entry.Members, member) {
+			remove = append(remove, member)
+		}
+	}
+	if len(add) > 0 {
+		if err := manager.AddGroupMembers(entry.Name, add...); err == user.ErrUserNotFound {
+			return errors.New("member does not exist, import users first")
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		if err := manager.RemoveGroupMembers(entry.Name, remove...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func execAccessExport(c *cli.Context) error {
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	users, err := manager.Users()
+	if err != nil {
+		return err
+	}
+	snapshot := &authSnapshot{
+		Access: make([]*authSnapshotGrant, 0),
+	}
+	for _, u := range users {
+		grants, err := manager.Grants(u.Name)
+		if err != nil {
+			return err
+		}
+		username := u.Name
+		if username == user.Everyone {
+			username = userEveryone
+		}
+		for _, grant := range grants {
+			snapshot.Access = append(snapshot.Access, &authSnapshotGrant{
+				User:       username,
+				Topic:      grant.TopicPattern,
+				Permission: grant.Allow.String(),
+			})
+		}
+	}
+	groups, err := manager.Groups()
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		for _, grant := range g.Grants {
+			snapshot.Access = append(snapshot.Access, &authSnapshotGrant{
+				Group:      g.Name,
+				Topic:      grant.TopicPattern,
+				Permission: grant.Allow.String(),
+			})
+		}
+	}
+	return writeAuthSnapshot(c, snapshot)
+}
+
+func execAccessImport(c *cli.Context) error {
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	snapshot, err := readAuthSnapshot(c)
+	if err != nil {
+		return err
+	}
+	for _, entry := range snapshot.Access {
+		if entry.Group != "" {
+			if err := importGroupGrant(manager, entry); err != nil {
+				return fmt.Errorf("cannot import access entry for group %s and topic %s: %w", entry.Group, entry.Topic, err)
+			}
+		} else if err := importGrant(manager, entry); err != nil {
+			return fmt.Errorf("cannot import access entry for user %s and topic %s: %w", entry.User, entry.Topic, err)
+		}
+	}
+	fmt.Fprintf(c.App.ErrWriter, "imported %d access control entries\n", len(snapshot.Access))
+	return nil
+}
+
+func importGrant(manager *user.Manager, entry *authSnapshotGrant) error {
+	username := entry.User
+	if username == userEveryone {
+		username = user.Everyone
+	}
+	permission, err := user.ParsePermission(entry.Permission)
+	if err != nil {
+		return fmt.Errorf("invalid permission %s", entry.Permission)
+	} else if permission.IsAuthWrite() && username != user.Everyone {
+		return errors.New("permission anon-read-auth-write can only be granted to everyone")
+	} else if !user.AllowedTopicPattern(entry.Topic) {
+		return errors.New("invalid topic pattern")
+	}
+	u, err := manager.User(username)
+	if err == user.ErrUserNotFound {
+		return errors.New("user does not exist, import users first")
+	} else if err != nil {
+		return err
+	} else if u.Role == user.RoleAdmin {
+		return errors.New("user is an admin user, access control entries have no effect")
+	}
+	return manager.AllowAccess(username, entry.Topic, permission)
+}
+
+func importGroupGrant(manager *user.Manager, entry *authSnapshotGrant) error {
+	permission, err := user.ParsePermission(entry.Permission)
+	if err != nil {
+		return fmt.Errorf("invalid permission %s", entry.Permission)
+	} else if entry.User != "" {
+		return errors.New("entry cannot have both a user and a group")
+	} else if permission.IsAuthWrite() {
+		return errors.New("permission anon-read-auth-write can only be granted to everyone")
+	} else if !user.AllowedTopicPattern(entry.Topic) {
+		return errors.New("invalid topic pattern")
+	}
+	if err := manager.AllowGroupAccess(entry.Group, entry.Topic, permission); err == user.ErrGroupNotFound {
+		return errors.New("group does not exist, import users first")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeAuthSnapshot writes the snapshot to the file given as the first argument, or to stdout if no argument
+// is given. The file is created with restrictive permissions, since it contains password hashes.
+func writeAuthSnapshot(c *cli.Context, snapshot *authSnapshot) error {
+	var b []byte
+	var err error
+	switch format := c.String("format"); format {
+	case authSnapshotFormatYAML:
+		b, err = yaml.Marshal(snapshot)
+	case authSnapshotFormatJSON:
+		b, err = json.MarshalIndent(snapshot, "", "  ")
+		b = append(b, '\n')
+	default:
+		return fmt.Errorf("invalid format %s, must be %s or %s", format, authSnapshotFormatYAML, authSnapshotFormatJSON)
+	}
+	if err != nil {
+		return err
+	}
+	filename := c.Args().Get(0)
+	if filename == "" || filename == "-" {
+		_, err := c.App.Writer.Write(b)
+		return err
+	}
+	return os.WriteFile(filename, b, 0600)
+}
+
+// readAuthSnapshot reads a snapshot from the file given as the first argument, or from stdin if no argument is given
+func readAuthSnapshot(c *cli.Context) (*authSnapshot, error) {
+	var b []byte
+	var err error
+	filename := c.Args().Get(0)
+	if filename == "" || filename == "-" {
+		b, err = io.ReadAll(c.App.Reader)
+	} else {
+		b, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshot authSnapshot
+	if err := yaml.UnmarshalStrict(b, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/test"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestCLI_User_Access_ExportImport(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("philpass\nphilpass\nbenpass\nbenpass")
+	require.Nil(t, runUserCommand(app, conf, "add", "--role=admin", "phil"))
+	require.Nil(t, runUserCommand(app, conf, "add", "ben"))
+	require.Nil(t, runTierCommand(app, conf, "add", "pro"))
+	require.Nil(t, runUserCommand(app, conf, "change-tier", "ben", "pro"))
+	require.Nil(t, runAccessCommand(app, conf, "ben", "announcements", "rw"))
+	require.Nil(t, runAccessCommand(app, conf, "everyone", "announcements", "read"))
+
+	usersFile := filepath.Join(t.TempDir(), "users.yml")
+	accessFile := filepath.Join(t.TempDir(), "access.yml")
+	app, _, _, _ = newTestApp()
+	require.Nil(t, runUserCommand(app, conf, "export", usersFile))
+	require.Nil(t, runAccessCommand(app, conf, "export", accessFile))
+
+	stat, err := os.Stat(usersFile)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+	users, err := os.ReadFile(usersFile)
+	require.Nil(t, err)
+	require.Contains(t, string(users), "- name: phil\n  hash: $2a$")
+	require.Contains(t, string(users), "  role: user\n  tier: pro\n")
+	access, err := os.ReadFile(accessFile)
+	require.Nil(t, err)
+	require.Equal(t, `access:
+- user: ben
+  topic: announcements
+  permission: read-write
+- user: everyone
+  topic: announcements
+  permission: read-only
+`, string(access))
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, runAccessCommand(app, conf))
+	expected := stderr.String()
+
+	// Import into standby server
+	s2, conf2, port2 := newTestServerWithAuth(t)
+	defer test.StopServer(t, s2, port2)
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runTierCommand(app, conf2, "add", "pro"))
+	require.Nil(t, runUserCommand(app, conf2, "import", usersFile))
+	require.Nil(t, runAccessCommand(app, conf2, "import", accessFile))
+	require.Contains(t, stderr.String(), "user phil added with role admin\n")
+	require.Contains(t, stderr.String(), "imported 2 user(s)\n")
+	require.Contains(t, stderr.String(), "imported 2 access control entries\n")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runAccessCommand(app, conf2))
+	require.Equal(t, expected, stderr.String())
+
+	// Password hashes were copied
+	app, _, _, _ = newTestApp()
+	require.Nil(t, app.Run([]string{
+		"ntfy",
+		"publish",
+		"-u", "ben:benpass",
+		fmt.Sprintf("http://127.0.0.1:%d/announcements", port2),
+	}))
+
+	// Importing again updates existing users
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runUserCommand(app, conf2, "import", usersFile))
+	require.Contains(t, stderr.String(), "user ben updated with role user\n")
+}
+
+func TestCLI_User_Access_ExportImport_TokensGroups(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("philpass\nphilpass\nbenpass\nbenpass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+	require.Nil(t, runUserCommand(app, conf, "add", "ben"))
+	require.Nil(t, runGroupCommand(app, conf, "add", "ops"))
+	require.Nil(t, runGroupCommand(app, conf, "add-member", "ops", "phil", "ben"))
+	require.Nil(t, runGroupCommand(app, conf, "access", "ops", "alerts*", "rw"))
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "add", "--label", "backup", "--allowed-ip", "10.0.1.0/24", "ben"))
+	token := regexp.MustCompile(`tk_\w+`).FindString(stderr.String())
+	require.NotEmpty(t, token)
+
+	usersFile := filepath.Join(t.TempDir(), "users.yml")
+	accessFile := filepath.Join(t.TempDir(), "access.yml")
+	app, _, _, _ = newTestApp()
+	require.Nil(t, runUserCommand(app, conf, "export", usersFile))
+	require.Nil(t, runAccessCommand(app, conf, "export", accessFile))
+
+	users, err := os.ReadFile(usersFile)
+	require.Nil(t, err)
+	require.Contains(t, string(users), fmt.Sprintf("  tokens:\n  - token: %s\n    label: backup\n    allowed_ips:\n    - 10.0.1.0/24\n", token))
+	require.Contains(t, string(users), "groups:\n- name: ops\n  members:\n  - ben\n  - phil\n")
+	access, err := os.ReadFile(accessFile)
+	require.Nil(t, err)
+	require.Equal(t, `access:
+- group: ops
+  topic: alerts*
+  permission: read-write
+`, string(access))
+
+	// Import into standby server, with an existing group with a different member
+	s2, conf2, port2 := newTestServerWithAuth(t)
+	defer test.StopServer(t, s2, port2)
+
+	app, stdin, _, _ = newTestApp()
+	stdin.WriteString("joepass\njoepass")
+	require.Nil(t, runUserCommand(app, conf2, "add", "joe"))
+	require.Nil(t, runGroupCommand(app, conf2, "add", "ops"))
+	require.Nil(t, runGroupCommand(app, conf2, "add-member", "ops", "joe"))
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runUserCommand(app, conf2, "import", usersFile))
+	require.Nil(t, runAccessCommand(app, conf2, "import", accessFile))
+	require.Contains(t, stderr.String(), "group ops updated\n")
+	require.Contains(t, stderr.String(), "imported 1 group(s)\n")
+	require.Contains(t, stderr.String(), "imported 1 access control entries\n")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf2, "list"))
+	require.Contains(t, stderr.String(), "- member: ben\n- member: phil\n")
+	require.NotContains(t, stderr.String(), "- member: joe")
+	require.Contains(t, stderr.String(), "- read-write access to topic alerts*")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runTokenCommand(app, conf2, "list", "ben"))
+	require.Contains(t, stderr.String(), fmt.Sprintf("- %s (backup), never expires, only allowed from 10.0.1.0/24", token))
+}
+
+func TestCLI_User_ExportImport_JSON_Stdin(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("benpass\nbenpass")
+	require.Nil(t, runUserCommand(app, conf, "add", "ben"))
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, runUserCommand(app, conf, "export", "--format=json"))
+	require.Contains(t, stdout.String(), `"name": "ben"`)
+
+	s2, conf2, port2 := newTestServerWithAuth(t)
+	defer test.StopServer(t, s2, port2)
+
+	app, stdin, _, stderr := newTestApp()
+	stdin.WriteString(stdout.String())
+	require.Nil(t, runUserCommand(app, conf2, "import"))
+	require.Contains(t, stderr.String(), "user ben added with role user\n")
+}
+
+func TestCLI_User_Access_Import_Invalid(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("users:\n- name: ben\n  hash: notahash\n  role: user\n")
+	require.EqualError(t, runUserCommand(app, conf, "import"), "cannot import user ben: invalid argument")
+
+	app, stdin, _, _ = newTestApp()
+	stdin.WriteString("access:\n- user: nobody\n  topic: mytopic\n  permission: rw\n")
+	require.EqualError(t, runAccessCommand(app, conf, "import"), "cannot import access entry for user nobody and topic mytopic: user does not exist, import users first")
+
+	app, stdin, _, _ = newTestApp()
+	stdin.WriteString("access:\n- group: nogroup\n  topic: mytopic\n  permission: rw\n")
+	require.EqualError(t, runAccessCommand(app, conf, "import"), "cannot import access entry for group nogroup and topic mytopic: group does not exist, import users first")
+
+	app, stdin, _, _ = newTestApp()
+	stdin.WriteString("users:\n- name: ben\n  hash: $2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C\n  role: user\n  tokens:\n  - token: notatoken\n")
+	require.EqualError(t, runUserCommand(app, conf, "import"), "cannot import user ben: invalid token")
+
+	app, stdin, _, _ = newTestApp()
+	stdin.WriteString("users:\n- name: ben\n  password: secret\n")
+	require.ErrorContains(t, runUserCommand(app, conf, "import"), "invalid snapshot")
+}
@@ -0,0 +1,531 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	commands = append(commands, cmdBench)
+}
+
+const (
+	benchMessagePrefix     = "ntfy-bench"
+	benchMessageSizeMin    = 64   // Enough to fit the message header, see benchMessage
+	benchMessageSizeMax    = 4096 // Larger messages are turned into attachments by the server
+	benchSubscribeTimeout  = 10 * time.Second
+	benchMaxErrorsReported = 5
+)
+
+var flagsBench = append(
+	append([]cli.Flag{}, flagsDefault...),
+	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG"}, Usage: "client config file"},
+	flagNoKeychain,
+	&cli.StringFlag{Name: "user", Aliases: []string{"u"}, EnvVars: []string{"NTFY_USER"}, Usage: "username[:password] used to auth against the server"},
+	&cli.StringFlag{Name: "token", Aliases: []string{"k"}, EnvVars: []string{"NTFY_TOKEN"}, Usage: "access token used to auth against the server"},
+	&cli.IntFlag{Name: "publishers", Aliases: []string{"P"}, Value: 1, Usage: "number of concurrent publisher connections"},
+	&cli.IntFlag{Name: "subscribers", Aliases: []string{"S"}, Value: 1, Usage: "number of concurrent subscriber connections"},
+	&cli.Float64Flag{Name: "rate", Aliases: []string{"r"}, Value: 10, Usage: "total number of messages published per second"},
+	&cli.IntFlag{Name: "size", Aliases: []string{"s"}, Value: 100, Usage: "message size in bytes"},
+	&cli.DurationFlag{Name: "duration", Aliases: []string{"D"}, Value: 10 * time.Second, Usage: "duration of the publishing phase"},
+	&cli.DurationFlag{Name: "timeout", Value: 5 * time.Second, Usage: "publish request timeout, and time to wait for outstanding messages"},
+	&cli.BoolFlag{Name: "no-cache", Aliases: []string{"no_cache", "C"}, Usage: "do not cache messages server-side"},
+	&cli.BoolFlag{Name: "json", Aliases: []string{"j"}, Usage: "print results as JSON"},
+)
+
+var cmdBench = &cli.Command{
+	Name:      "bench",
+	Usage:     "Run a publish/subscribe load test against a ntfy server",
+	UsageText: "ntfy bench [OPTIONS..] TOPIC",
+	Action:    execBench,
+	Category:  categoryClient,
+	Flags:     flagsBench,
+	Before:    initLogFunc,
+	Description: `Run a load test against a ntfy server, and report publish and delivery latency
+percentiles, throughput and error rates.
+
+The command opens the given number of subscriber connections to the topic, and then publishes
+messages at the given rate across all publisher connections for the given duration. Every
+message carries the time it was sent, so that the end-to-end delivery latency can be measured
+by the subscribers. Publish requests that cannot be started on time because all publishers
+are busy are counted as skipped.
+
+Be aware that the server's rate limits (e.g. visitor-request-limit-burst) apply to the load
+test as well, so you may want to raise them or exempt the benchmarking host (visitor-request-
+limit-exempt-hosts) on the server. Only run this against servers you operate yourself.
+
+Examples:
+  ntfy bench mytopic                                      # 10 msg/s for 10s with 1 publisher and 1 subscriber
+  ntfy bench -P 10 -S 100 -r 200 ntfy.example.com/bench   # 10 publishers, 100 subscribers, 200 msg/s
+  ntfy bench -s 4096 -D 1m --no-cache mytopic             # 4 KB messages for 1 minute, not cached
+  ntfy bench -S 0 -r 1000 --json mytopic                  # Publish only, print results as JSON
+
+` + clientCommandDescriptionSuffix,
+}
+
+type benchOptions struct {
+	TopicURL    string
+	Publishers  int
+	Subscribers int
+	Rate        float64
+	Size        int
+	Duration    time.Duration
+	Timeout     time.Duration
+	Options     []client.RequestOption
+}
+
+// benchReport is the result of a load test run, printed as text or JSON (--json)
+type benchReport struct {
+	Topic       string        `json:"topic"`
+	Publishers  int           `json:"publishers"`
+	Subscribers int           `json:"subscribers"`
+	Rate        float64       `json:"rate"`
+	Size        int           `json:"size"`
+	Duration    float64       `json:"duration"` // Seconds
+	Publish     *benchSummary `json:"publish"`
+	Delivery    *benchSummary `json:"delivery,omitempty"`
+}
+
+// benchSummary summarizes the publish or delivery side of a load test run
+type benchSummary struct {
+	Total      int64          `json:"total"` // Sent messages (publish), or expected messages (delivery)
+	Succeeded  int64          `json:"succeeded"`
+	Failed     int64          `json:"failed"`
+	Skipped    int64          `json:"skipped,omitempty"`
+	ErrorRate  float64        `json:"error_rate"` // Percent
+	Throughput float64        `json:"throughput"` // Messages per second
+	Latency    *benchLatency  `json:"latency,omitempty"`
+	Errors     map[string]int `json:"errors,omitempty"`
+}
+
+// benchLatency contains latency statistics, all values in milliseconds
+type benchLatency struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// benchRecorder collects latencies and errors from multiple goroutines
+type benchRecorder struct {
+	latencies []time.Duration
+	errors    map[string]int
+	mu        sync.Mutex
+}
+
+func newBenchRecorder() *benchRecorder {
+	return &benchRecorder{
+		latencies: make([]time.Duration, 0),
+		errors:    make(map[string]int),
+	}
+}
+
+func (r *benchRecorder) Success(latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies = append(r.latencies, latency)
+}
+
+func (r *benchRecorder) Error(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors[err.Error()]++
+}
+
+func (r *benchRecorder) Summary(total int64, elapsed time.Duration) *benchSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary := &benchSummary{
+		Total:     total,
+		Succeeded: int64(len(r.latencies)),
+		Failed:    max(total-int64(len(r.latencies)), 0), // Subscribers may see more messages than expected, if a publish request timed out
+		Latency:   newBenchLatency(r.latencies),
+	}
+	if total > 0 {
+		summary.ErrorRate = float64(summary.Failed) * 100 / float64(total)
+	}
+	if elapsed > 0 {
+		summary.Throughput = float64(summary.Succeeded) / elapsed.Seconds()
+	}
+	if len(r.errors) > 0 {
+		summary.Errors = r.errors
+	}
+	return summary
+}
+
+func execBench(c *cli.Context) error {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	user := c.String("user")
+	token := c.String("token")
+	opts := &benchOptions{
+		Publishers:  c.Int("publishers"),
+		Subscribers: c.Int("subscribers"),
+		Rate:        c.Float64("rate"),
+		Size:        c.Int("size"),
+		Duration:    c.Duration("duration"),
+		Timeout:     c.Duration("timeout"),
+		Options:     []client.RequestOption{client.WithNoFirebase()},
+	}
+
+	// Checks
+	if c.NArg() != 1 {
+		return errors.New("must specify topic, type 'ntfy bench --help' for help")
+	} else if user != "" && token != "" {
+		return errors.New("cannot set both --user and --token")
+	} else if opts.Publishers < 1 {
+		return errors.New("--publishers must be at least 1")
+	} else if opts.Subscribers < 0 {
+		return errors.New("--subscribers must not be negative")
+	} else if opts.Rate <= 0 {
+		return errors.New("--rate must be greater than 0")
+	} else if opts.Size < benchMessageSizeMin || opts.Size > benchMessageSizeMax {
+		return fmt.Errorf("--size must be between %d and %d bytes", benchMessageSizeMin, benchMessageSizeMax)
+	} else if opts.Duration <= 0 || opts.Timeout <= 0 {
+		return errors.New("--duration and --timeout must be greater than 0")
+	}
+
+	// Do the things
+	opts.TopicURL, err = client.New(conf).ExpandTopicURL(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	if c.Bool("no-cache") {
+		opts.Options = append(opts.Options, client.WithNoCache())
+	}
+	if token != "" {
+		opts.Options = append(opts.Options, client.WithBearerAuth(token))
+	} else if user != "" {
+		var pass string
+		parts := strings.SplitN(user, ":", 2)
+		if len(parts) == 2 {
+			user = parts[0]
+			pass = parts[1]
+		} else {
+			fmt.Fprint(c.App.ErrWriter, "Enter Password: ")
+			p, err := util.ReadPassword(c.App.Reader)
+			if err != nil {
+				return err
+			}
+			pass = string(p)
+			fmt.Fprintf(c.App.ErrWriter, "\r%s\r", strings.Repeat(" ", 20))
+		}
+		opts.Options = append(opts.Options, client.WithBasicAuth(user, pass))
+	} else if conf.DefaultToken != "" {
+		opts.Options = append(opts.Options, client.WithBearerAuth(conf.DefaultToken))
+	} else if conf.DefaultUser != "" && conf.DefaultPassword != nil {
+		opts.Options = append(opts.Options, client.WithBasicAuth(conf.DefaultUser, *conf.DefaultPassword))
+	}
+	if !c.Bool("json") {
+		fmt.Fprintf(c.App.ErrWriter, "Benchmarking %s with %d publisher(s) and %d subscriber(s), %.1f msg/s, %d byte(s) per message, for %s ...\n",
+			util.ShortTopicURL(opts.TopicURL), opts.Publishers, opts.Subscribers, opts.Rate, opts.Size, opts.Duration)
+	}
+	report, err := runBench(opts)
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.App.Writer, string(b))
+		return nil
+	}
+	printBenchReport(c.App.Writer, report)
+	return nil
+}
+
+// runBench connects all subscribers, then publishes messages at the requested rate, and finally waits
+// for the subscribers to receive all outstanding messages (or for the timeout to pass).
+func runBench(opts *benchOptions) (*benchReport, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runID := util.RandomString(10)
+	httpClient := &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        opts.Publishers,
+			MaxIdleConnsPerHost: opts.Publishers,
+			IdleConnTimeout:     30 * time.Second,
+		},
+	}
+
+	// Connect subscribers, and wait for all of them to be ready
+	var received atomic.Int64
+	delivery := newBenchRecorder()
+	subscribersReady := make(chan error, opts.Subscribers)
+	for i := 0; i < opts.Subscribers; i++ {
+		go benchSubscribe(ctx, opts, runID, delivery, &received, subscribersReady)
+	}
+	for i := 0; i < opts.Subscribers; i++ {
+		if err := <-subscribersReady; err != nil {
+			return nil, fmt.Errorf("cannot connect subscriber: %w", err)
+		}
+	}
+
+	// Publish messages at the given rate, skipping messages if all publishers are busy
+	var sent, skipped, published atomic.Int64
+	publish := newBenchRecorder()
+	jobs := make(chan int64, opts.Publishers)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Publishers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := range jobs {
+				start := time.Now()
+				if err := benchPublish(httpClient, opts, benchMessage(runID, seq, start, opts.Size)); err != nil {
+					log.Debug("Publishing message %d failed: %s", seq, err.Error())
+					publish.Error(err)
+					continue
+				}
+				publish.Success(time.Since(start))
+				published.Add(1)
+			}
+		}()
+	}
+	started := time.Now()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+	deadline := time.After(opts.Duration)
+	var seq int64
+publishing:
+	for {
+		select {
+		case <-deadline:
+			break publishing
+		case <-ticker.C:
+			select {
+			case jobs <- seq:
+				sent.Add(1)
+			default:
+				skipped.Add(1)
+			}
+			seq++
+		}
+	}
+	ticker.Stop()
+	close(jobs)
+	wg.Wait()
+	publishElapsed := time.Since(started)
+
+	// Wait for outstanding messages
+	expected := published.Load() * int64(opts.Subscribers)
+	waitUntil := time.Now().Add(opts.Timeout)
+	for received.Load() < expected && time.Now().Before(waitUntil) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	report := &benchReport{
+		Topic:       opts.TopicURL,
+		Publishers:  opts.Publishers,
+		Subscribers: opts.Subscribers,
+		Rate:        opts.Rate,
+		Size:        opts.Size,
+		Duration:    opts.Duration.Seconds(),
+		Publish:     publish.Summary(sent.Load(), publishElapsed),
+	}
+	report.Publish.Skipped = skipped.Load()
+	if opts.Subscribers > 0 {
+		report.Delivery = delivery.Summary(expected, publishElapsed)
+	}
+	return report, nil
+}
+
+func benchPublish(httpClient *http.Client, opts *benchOptions, message string) error {
+	req, err := http.NewRequest(http.MethodPost, opts.TopicURL, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	for _, option := range opts.Options {
+		if err := option(req); err != nil {
+			return err
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
+
+// benchSubscribe opens a JSON stream to the topic, and records the delivery latency of all messages
+// of this run. Once the stream is open, it reports back via the ready channel.
+func benchSubscribe(ctx context.Context, opts *benchOptions, runID string, delivery *benchRecorder, received *atomic.Int64, ready chan<- error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/json", opts.TopicURL), nil)
+	if err != nil {
+		ready <- err
+		return
+	}
+	for _, option := range opts.Options {
+		if err := option(req); err != nil {
+			ready <- err
+			return
+		}
+	}
+	timer := time.AfterFunc(benchSubscribeTimeout, func() {
+		ready <- errors.New("timeout waiting for subscription to open")
+	})
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if timer.Stop() {
+			ready <- err
+		}
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if timer.Stop() {
+			ready <- fmt.Errorf("HTTP %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		}
+		return
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var m client.Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			continue
+		}
+		if m.Event == "open" {
+			if timer.Stop() {
+				ready <- nil
+			}
+			continue
+		} else if m.Event != client.MessageEvent {
+			continue
+		}
+		sent, ok := parseBenchMessage(runID, m.Message)
+		if !ok {
+			continue
+		}
+		delivery.Success(time.Since(sent))
+		received.Add(1)
+	}
+}
+
+// benchMessage creates a message body containing the run ID, the sequence number and the time the message was
+// sent, padded to the given size
+func benchMessage(runID string, seq int64, sent time.Time, size int) string {
+	message := fmt.Sprintf("%s %s %d %d ", benchMessagePrefix, runID, seq, sent.UnixNano())
+	if len(message) < size {
+		message += strings.Repeat("x", size-len(message))
+	}
+	return message
+}
+
+// parseBenchMessage returns the time the message was sent, if it belongs to the given run
+func parseBenchMessage(runID, message string) (time.Time, bool) {
+	fields := strings.Fields(message)
+	if len(fields) < 4 || fields[0] != benchMessagePrefix || fields[1] != runID {
+		return time.Time{}, false
+	}
+	sent, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, sent), true
+}
+
+func newBenchLatency(latencies []time.Duration) *benchLatency {
+	if len(latencies) == 0 {
+		return nil
+	}
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, l := range sorted {
+		sum += l
+	}
+	return &benchLatency{
+		Min: benchMillis(sorted[0]),
+		Avg: benchMillis(sum / time.Duration(len(sorted))),
+		P50: benchMillis(benchPercentile(sorted, 50)),
+		P90: benchMillis(benchPercentile(sorted, 90)),
+		P95: benchMillis(benchPercentile(sorted, 95)),
+		P99: benchMillis(benchPercentile(sorted, 99)),
+		Max: benchMillis(sorted[len(sorted)-1]),
+	}
+}
+
+// benchPercentile returns the p-th percentile of the sorted latencies, using the nearest-rank method
+func benchPercentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	} else if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func benchMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func printBenchReport(w io.Writer, report *benchReport) {
+	fmt.Fprintln(w, "Publish:")
+	fmt.Fprintf(w, "  Messages:   %d sent, %d succeeded, %d failed (%.2f%% errors), %d skipped\n",
+		report.Publish.Total, report.Publish.Succeeded, report.Publish.Failed, report.Publish.ErrorRate, report.Publish.Skipped)
+	fmt.Fprintf(w, "  Throughput: %.1f msg/s\n", report.Publish.Throughput)
+	printBenchLatency(w, report.Publish.Latency)
+	printBenchErrors(w, report.Publish.Errors)
+	if report.Delivery != nil {
+		fmt.Fprintln(w, "Delivery:")
+		fmt.Fprintf(w, "  Messages:   %d of %d received (%.2f%% lost)\n",
+			report.Delivery.Succeeded, report.Delivery.Total, report.Delivery.ErrorRate)
+		fmt.Fprintf(w, "  Throughput: %.1f msg/s\n", report.Delivery.Throughput)
+		printBenchLatency(w, report.Delivery.Latency)
+	}
+}
+
+func printBenchLatency(w io.Writer, l *benchLatency) {
+	if l == nil {
+		return
+	}
+	fmt.Fprintf(w, "  Latency:    min %.1fms, avg %.1fms, p50 %.1fms, p90 %.1fms, p95 %.1fms, p99 %.1fms, max %.1fms\n",
+		l.Min, l.Avg, l.P50, l.P90, l.P95, l.P99, l.Max)
+}
+
+func printBenchErrors(w io.Writer, errs map[string]int) {
+	messages := make([]string, 0, len(errs))
+	for message := range errs {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool { return errs[messages[i]] > errs[messages[j]] })
+	for i, message := range messages {
+		if i == benchMaxErrorsReported {
+			fmt.Fprintf(w, "  ...         %d more error type(s)\n", len(messages)-i)
+			break
+		}
+		fmt.Fprintf(w, "  Error:      %dx %s\n", errs[message], message)
+	}
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/test"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCLI_Bench(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/benchtopic", port)
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "bench", "--json", "-P", "2", "-S", "3", "-r", "20", "-s", "200", "-D", "1s", topic}))
+	var report benchReport
+	require.Nil(t, json.Unmarshal(stdout.Bytes(), &report))
+	require.Equal(t, topic, report.Topic)
+	require.Equal(t, 2, report.Publishers)
+	require.Equal(t, 3, report.Subscribers)
+	require.Equal(t, 200, report.Size)
+	require.True(t, report.Publish.Total > 10)
+	require.Equal(t, report.Publish.Total, report.Publish.Succeeded)
+	require.Equal(t, int64(0), report.Publish.Failed)
+	require.NotNil(t, report.Publish.Latency)
+	require.Equal(t, report.Publish.Succeeded*3, report.Delivery.Total)
+	require.Equal(t, report.Delivery.Total, report.Delivery.Succeeded)
+	require.Equal(t, float64(0), report.Delivery.ErrorRate)
+	require.True(t, report.Delivery.Latency.P50 <= report.Delivery.Latency.P99)
+
+	app2, _, stdout2, _ := newTestApp()
+	require.Nil(t, app2.Run([]string{"ntfy", "bench", "-S", "0", "-r", "10", "-D", "500ms", topic}))
+	require.Contains(t, stdout2.String(), "Publish:\n  Messages:")
+	require.Contains(t, stdout2.String(), "Latency:    min")
+	require.NotContains(t, stdout2.String(), "Delivery:")
+}
+
+func TestCLI_Bench_Errors(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/benchtopic", port)
+
+	app, _, _, _ := newTestApp()
+	require.ErrorContains(t, app.Run([]string{"ntfy", "bench"}), "must specify topic")
+	require.ErrorContains(t, app.Run([]string{"ntfy", "bench", "-s", "10000", topic}), "--size must be between 64 and 4096 bytes")
+	require.ErrorContains(t, app.Run([]string{"ntfy", "bench", "-P", "0", topic}), "--publishers must be at least 1")
+	require.ErrorContains(t, app.Run([]string{"ntfy", "bench", "-u", "phil:pass", "-k", "tk_abc", topic}), "cannot set both --user and --token")
+}
+
+func TestBenchMessage(t *testing.T) {
+	now := time.Now()
+	message := benchMessage("abc", 12, now, 100)
+	require.Equal(t, 100, len(message))
+	require.True(t, strings.HasPrefix(message, fmt.Sprintf("ntfy-bench abc 12 %d x", now.UnixNano())))
+
+	sent, ok := parseBenchMessage("abc", message)
+	require.True(t, ok)
+	require.Equal(t, now.UnixNano(), sent.UnixNano())
+
+	_, ok = parseBenchMessage("other-run", message)
+	require.False(t, ok)
+	_, ok = parseBenchMessage("abc", "some other message")
+	require.False(t, ok)
+}
+
+func TestBenchLatency(t *testing.T) {
+	latencies := make([]time.Duration, 0)
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	l := newBenchLatency(latencies)
+	require.Equal(t, float64(1), l.Min)
+	require.Equal(t, 50.5, l.Avg)
+	require.Equal(t, float64(50), l.P50)
+	require.Equal(t, float64(90), l.P90)
+	require.Equal(t, float64(99), l.P99)
+	require.Equal(t, float64(100), l.Max)
+	require.Equal(t, 100*time.Millisecond, latencies[0]) // Input is not modified
+	require.Nil(t, newBenchLatency(nil))
+}
@@ -0,0 +1,128 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/util"
+	"time"
+)
+
+func init() {
+	commands = append(commands, cmdCache)
+}
+
+var flagsCachePrune = []cli.Flag{
+	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG_FILE"}, Value: defaultServerConfigFile, DefaultText: defaultServerConfigFile, Usage: "config file"},
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-file", Aliases: []string{"cache_file", "C"}, EnvVars: []string{"NTFY_CACHE_FILE"}, Usage: "cache file used for message caching"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-cache-dir", Aliases: []string{"attachment_cache_dir"}, EnvVars: []string{"NTFY_ATTACHMENT_CACHE_DIR"}, Usage: "cache directory for attached files"}),
+	&cli.StringFlag{Name: "topic", Aliases: []string{"t"}, Required: true, Usage: "topic to delete messages from"},
+	&cli.StringFlag{Name: "before", Aliases: []string{"b"}, Usage: "only delete messages published before this time, e.g. 2024-01-01, 7d or a unix timestamp (default: all messages)"},
+}
+
+var cmdCache = &cli.Command{
+	Name:      "cache",
+	Usage:     "Manage the message cache",
+	UsageText: "ntfy cache [migrate|prune] ...",
+	Before:    initLogFunc,
+	Category:  categoryServer,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "migrate",
+			Usage:     "Copies messages from one message cache to another",
+			UsageText: "ntfy cache migrate --from URL --to URL",
+			Action:    execCacheMigrate,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "from", Required: true, Usage: "source message cache, e.g. sqlite:///var/cache/ntfy/cache.db"},
+				&cli.StringFlag{Name: "to", Required: true, Usage: "target message cache, e.g. sqlite:///var/cache/ntfy/cache-new.db"},
+				&cli.IntFlag{Name: "batch-size", Value: server.DefaultCacheMigrateBatchSize, Usage: "number of messages copied per transaction"},
+			},
+			Description: `Copies all messages (including attachment metadata and reactions), abuse reports,
+blocked topics, banned IPs and the stats history from one message cache to another.
+
+Both caches are upgraded to the current schema version before copying, just like "ntfy serve"
+would do. The target cache is created if it does not exist. Messages that already exist in
+the target cache are skipped, so an interrupted migration can be resumed by simply running
+the command again. Attachment files themselves are not copied, since they are stored in the
+attachment cache directory.
+
+Stop the ntfy server before migrating, and point "cache-file" to the target cache afterwards.
+Caches are given as URLs. The only supported backend is currently "sqlite" (which is also used
+for "cache-snapshot-file"); a plain filename is treated as a SQLite cache.
+
+Examples:
+  ntfy cache migrate --from sqlite:///var/cache/ntfy/cache.db --to sqlite:///mnt/ssd/ntfy/cache.db
+  ntfy cache migrate --from cache.db --to snapshot.db`,
+		},
+		{
+			Name:      "prune",
+			Usage:     "Deletes the messages of a topic from the message cache",
+			UsageText: "ntfy cache prune --topic TOPIC [--before TIME]",
+			Action:    execCachePrune,
+			Flags:     flagsCachePrune,
+			Before:    initConfigFileInputSourceFunc("config", flagsCachePrune, nil),
+			Description: `Deletes all messages of a topic published before the given time (or all messages of
+the topic if --before is not set) from the message cache, along with their attachments.
+
+This is meant for cleaning up after sensitive data (e.g. passwords or API keys) was accidentally
+published to a topic. The "cache-file" and "attachment-cache-dir" options are read from the
+server config file, if they are not passed explicitly. If the server is running, you may also
+use the admin API instead (POST /v1/messages/prune), which does the same thing.
+
+Note that messages that were already delivered to subscribers cannot be deleted from their devices.
+
+Examples:
+  ntfy cache prune --topic mytopic                        # Delete all messages of topic mytopic
+  ntfy cache prune --topic mytopic --before 2024-01-01    # Delete messages published before Jan 1, 2024 (UTC)
+  ntfy cache prune --topic mytopic --before 7d            # Delete messages older than 7 days`,
+		},
+	},
+}
+
+func execCacheMigrate(c *cli.Context) error {
+	from, to := c.String("from"), c.String("to")
+	batchSize := c.Int("batch-size")
+	if batchSize <= 0 {
+		return errors.New("batch-size must be a positive number")
+	}
+	var copied, skipped int64
+	err := server.MigrateCache(from, to, batchSize, func(p *server.CacheMigrateProgress) {
+		copied, skipped = p.Copied, p.Skipped
+		fmt.Fprintf(c.App.ErrWriter, "Processed %d of %d message(s): %d copied, %d already existed\n", p.Copied+p.Skipped, p.Total, p.Copied, p.Skipped)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "Migrated message cache from %s to %s: %d message(s) copied, %d already existed\n", from, to, copied, skipped)
+	return nil
+}
+
+func execCachePrune(c *cli.Context) error {
+	cacheFile, attachmentCacheDir := c.String("cache-file"), c.String("attachment-cache-dir")
+	topic := c.String("topic")
+	if cacheFile == "" {
+		return errors.New("option cache-file not set; message caching is not configured for this server")
+	}
+	var before time.Time
+	if c.String("before") != "" {
+		t, err := util.ParsePastTime(c.String("before"), time.Now())
+		if err != nil {
+			return fmt.Errorf("invalid time %s", c.String("before"))
+		}
+		before = t
+	}
+	deleted, err := server.PruneCache(cacheFile, attachmentCacheDir, topic, before)
+	if err != nil {
+		return err
+	}
+	if before.IsZero() {
+		fmt.Fprintf(c.App.ErrWriter, "Deleted %d message(s) of topic %s\n", deleted, topic)
+	} else {
+		fmt.Fprintf(c.App.ErrWriter, "Deleted %d message(s) of topic %s published before %s\n", deleted, topic, before.Format(time.RFC3339))
+	}
+	return nil
+}
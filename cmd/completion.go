@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/client"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands = append(commands, cmdCompletion)
+}
+
+const (
+	completionFlag    = "--generate-bash-completion"
+	completionTimeout = 2 * time.Second // Completion must not block the shell for long
+)
+
+var (
+	completionPriorities = []string{"min", "low", "default", "high", "max", "urgent"}
+	completionTags       = []string{"+1", "-1", "warning", "rotating_light", "skull", "tada", "white_check_mark", "heavy_check_mark", "x", "loudspeaker", "partying_face", "computer", "floppy_disk"}
+)
+
+// completionScripts are the shell scripts that call "ntfy ... <current word> --generate-bash-completion"
+// to get the completion candidates. Unlike urfave/cli's default scripts, the current word is always passed
+// (even if empty), so that completeClientCommand can tell the current and the previous word apart. Partial
+// flags (e.g. --pri) are passed after "--", since urfave/cli fails to parse unknown flags during completion.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for ntfy, generated by "ntfy completion bash"
+_ntfy_completion() {
+  local cur words opts
+  COMPREPLY=()
+  cur="${COMP_WORDS[COMP_CWORD]}"
+  words=("${COMP_WORDS[@]:0:$COMP_CWORD}")
+  if [[ "${cur}" == -* ]]; then
+    opts=$("${words[@]}" -- "${cur}" --generate-bash-completion 2>/dev/null)
+  else
+    opts=$("${words[@]}" "${cur}" --generate-bash-completion 2>/dev/null)
+  fi
+  local IFS=$'\n'
+  COMPREPLY=($(compgen -W "${opts}" -- "${cur}"))
+  return 0
+}
+complete -o bashdefault -o default -F _ntfy_completion ntfy
+`,
+	"zsh": `#compdef ntfy
+# zsh completion for ntfy, generated by "ntfy completion zsh"
+_ntfy_completion() {
+  local -a opts
+  if [[ "${words[-1]}" == -* ]]; then
+    opts=("${(@f)$(${words[@]:0:#words[@]-1} -- "${words[-1]}" --generate-bash-completion 2>/dev/null)}")
+  else
+    opts=("${(@f)$(${words[@]:0:#words[@]-1} "${words[-1]}" --generate-bash-completion 2>/dev/null)}")
+  fi
+  if [[ "${opts[1]}" != "" ]]; then
+    compadd -Q -S '' -- "${opts[@]}"
+  else
+    _files
+  fi
+}
+compdef _ntfy_completion ntfy
+`,
+}
+
+var cmdCompletion = &cli.Command{
+	Name:      "completion",
+	Usage:     "Print the shell completion script for bash or zsh",
+	UsageText: "ntfy completion (bash|zsh)",
+	Action:    execCompletion,
+	Category:  categoryClient,
+	Description: `Print the shell completion script for bash or zsh.
+
+Commands, subcommands and flags are completed for all commands. For "ntfy publish" and
+"ntfy subscribe", topic names are completed from the topics in the client config, as well as
+the reserved topics and synced subscriptions of the account on the default host. Priorities
+(--priority) and tags (--tags) are completed as well.
+
+Examples:
+  source <(ntfy completion bash)                                 # Enable completion in the current bash shell
+  ntfy completion bash | sudo tee /etc/bash_completion.d/ntfy    # Install bash completion for all users
+  ntfy completion zsh > "${fpath[1]}/_ntfy"                      # Install zsh completion`,
+}
+
+func execCompletion(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("must specify shell (bash or zsh), type 'ntfy completion --help' for help")
+	}
+	script, ok := completionScripts[c.Args().Get(0)]
+	if !ok {
+		return fmt.Errorf("unsupported shell %s, only bash and zsh are supported", c.Args().Get(0))
+	}
+	_, err := fmt.Fprint(c.App.Writer, script)
+	return err
+}
+
+// completeClientCommand is the BashComplete function for the publish and subscribe commands. It completes
+// flag names and values (priorities, tags), as well as topic names for the first argument.
+func completeClientCommand(c *cli.Context) {
+	cur, prev := completionWords(os.Args)
+	var candidates []string
+	if strings.HasPrefix(cur, "-") && strings.Contains(cur, "=") {
+		name, value, _ := strings.Cut(cur, "=")
+		for _, candidate := range completionFlagValues(c, strings.TrimLeft(name, "-"), value) {
+			candidates = append(candidates, name+"="+candidate)
+		}
+	} else if strings.HasPrefix(cur, "-") {
+		cli.DefaultCompleteWithFlags(c.Command)(c)
+		return
+	} else if completionFlagTakesValue(c.Command.Flags, prev) {
+		candidates = completionFlagValues(c, strings.TrimLeft(prev, "-"), cur)
+	} else if c.NArg() == 0 { // The current word is the command itself, e.g. "ntfy pub<TAB>"
+		candidates = []string{cur}
+	} else if c.NArg() == 1 { // The current word is the first argument (topic)
+		candidates = completionTopics(c)
+	}
+	for _, candidate := range candidates {
+		fmt.Fprintln(c.App.Writer, candidate)
+	}
+}
+
+// completionWords returns the current (possibly empty) and the previous word from the command line,
+// as passed by the completion scripts, e.g. "ntfy pub -p hi --generate-bash-completion"
+func completionWords(args []string) (cur string, prev string) {
+	if len(args) > 0 && args[len(args)-1] == completionFlag {
+		args = args[:len(args)-1]
+	}
+	if len(args) > 0 {
+		cur = args[len(args)-1]
+	}
+	if len(args) > 1 {
+		prev = args[len(args)-2]
+	}
+	if cur == "=" && len(args) > 1 { // bash splits "--priority=" into "--priority" and "="
+		cur, prev = "", args[len(args)-2]
+	} else if (prev == "=" || prev == "--") && len(args) > 2 {
+		prev = args[len(args)-3]
+	}
+	return
+}
+
+// completionFlagTakesValue returns true if the word is one of the given flags, and the flag takes a value
+func completionFlagTakesValue(flags []cli.Flag, word string) bool {
+	if !strings.HasPrefix(word, "-") || strings.Contains(word, "=") {
+		return false
+	}
+	name := strings.TrimLeft(word, "-")
+	for _, flag := range flags {
+		for _, n := range flag.Names() {
+			if n == name {
+				_, isBool := flag.(*cli.BoolFlag)
+				return !isBool
+			}
+		}
+	}
+	return false
+}
+
+// completionFlagValues returns the possible values for the flag with the given name. For comma-separated
+// tags, the candidates contain the already typed tags as prefix.
+func completionFlagValues(c *cli.Context, name, value string) []string {
+	switch name {
+	case "priority", "p":
+		return completionPriorities
+	case "tags", "tag", "T":
+		var typed string
+		if i := strings.LastIndex(value, ","); i != -1 {
+			typed = value[:i+1]
+		}
+		tags := append([]string{}, completionTags...)
+		if account := completionAccount(c); account != nil {
+			for _, icon := range account.TagIcons {
+				tags = append(tags, icon.Tag)
+			}
+		}
+		candidates := make([]string, 0)
+		for _, tag := range completionUnique(tags) {
+			if !strings.Contains(","+typed, ","+tag+",") {
+				candidates = append(candidates, typed+tag)
+			}
+		}
+		return candidates
+	}
+	return nil
+}
+
+// completionTopics returns the topics from the client config, as well as the reserved topics and the
+// synced subscriptions of the account on the default host. Topics on other hosts are returned as short URL.
+func completionTopics(c *cli.Context) []string {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return nil
+	}
+	topics := make([]string, 0)
+	for _, s := range conf.Subscribe {
+		if strings.Contains(s.Topic, "/") {
+			topics = append(topics, completionShortTopic(conf, "", s.Topic))
+		} else {
+			topics = append(topics, completionShortTopic(conf, strings.TrimSuffix(s.Host, "/"), s.Topic))
+		}
+	}
+	if account := completionAccount(c); account != nil {
+		for _, r := range account.Reservations {
+			topics = append(topics, r.Topic)
+		}
+		for _, s := range account.Subscriptions {
+			topics = append(topics, completionShortTopic(conf, s.BaseURL, s.Topic))
+		}
+	}
+	return completionUnique(topics)
+}
+
+func completionShortTopic(conf *client.Config, baseURL, topic string) string {
+	if baseURL != "" && baseURL != conf.DefaultHost {
+		topic = fmt.Sprintf("%s/%s", baseURL, topic)
+	}
+	return strings.TrimPrefix(topic, "https://")
+}
+
+type completionAccountResponse struct {
+	Reservations []struct {
+		Topic string `json:"topic"`
+	} `json:"reservations"`
+	Subscriptions []struct {
+		BaseURL string `json:"base_url"`
+		Topic   string `json:"topic"`
+	} `json:"subscriptions"`
+	TagIcons []struct {
+		Tag string `json:"tag"`
+	} `json:"tag_icons"`
+}
+
+// completionAccount fetches the account of the user on the default host, using the credentials from the command
+// line or the client config. It returns nil if the account cannot be fetched, since completion is best effort.
+func completionAccount(c *cli.Context) *completionAccountResponse {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/account", conf.DefaultHost), nil)
+	if err != nil {
+		return nil
+	}
+	user, token := c.String("user"), c.String("token")
+	if token != "" {
+		_ = client.WithBearerAuth(token)(req)
+	} else if u, pass, ok := strings.Cut(user, ":"); ok {
+		_ = client.WithBasicAuth(u, pass)(req)
+	} else if conf.DefaultToken != "" {
+		_ = client.WithBearerAuth(conf.DefaultToken)(req)
+	} else if conf.DefaultUser != "" && conf.DefaultPassword != nil {
+		_ = client.WithBasicAuth(conf.DefaultUser, *conf.DefaultPassword)(req)
+	}
+	resp, err := (&http.Client{Timeout: completionTimeout}).Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var account completionAccountResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&account); err != nil {
+		return nil
+	}
+	return &account
+}
+
+func completionUnique(values []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0)
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCLI_Completion_Script(t *testing.T) {
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "completion", "bash"}))
+	require.Contains(t, stdout.String(), "complete -o bashdefault -o default -F _ntfy_completion ntfy")
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "completion", "zsh"}))
+	require.True(t, strings.HasPrefix(stdout.String(), "#compdef ntfy\n"))
+
+	app, _, _, _ = newTestApp()
+	require.Equal(t, "unsupported shell fish, only bash and zsh are supported", app.Run([]string{"ntfy", "completion", "fish"}).Error())
+}
+
+func TestCLI_Completion_Publish_Subscribe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/account", r.URL.Path)
+		require.Equal(t, "Bearer tk_mytoken", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"username":"phil","reservations":[{"topic":"alerts"},{"topic":"backups"}],` +
+			`"subscriptions":[{"base_url":"` + "http://" + r.Host + `","topic":"alerts"},{"base_url":"https://other.example.com","topic":"news"}],` +
+			`"tag_icons":[{"tag":"jenkins","url":"/v1/tags/jenkins/icon"}]}`))
+	}))
+	defer server.Close()
+	configFile := filepath.Join(t.TempDir(), "client.yml")
+	require.Nil(t, os.WriteFile(configFile, []byte(fmt.Sprintf(`
+default-host: %s
+default-token: tk_mytoken
+subscribe:
+  - topic: fromconfig
+`, server.URL)), 0600))
+
+	// Topics: client config, reservations, and synced subscriptions
+	topics := runCompletion(t, "ntfy", "publish", "--config", configFile, "")
+	require.Equal(t, []string{"alerts", "backups", "fromconfig", "other.example.com/news"}, topics)
+	topics = runCompletion(t, "ntfy", "sub", "--config", configFile, "ba")
+	require.Equal(t, []string{"alerts", "backups", "fromconfig", "other.example.com/news"}, topics) // The shell filters
+	require.Empty(t, runCompletion(t, "ntfy", "pub", "--config", configFile, "alerts", ""))
+	require.Equal(t, []string{"pub"}, runCompletion(t, "ntfy", "pub"))
+
+	// Priorities and tags
+	require.Equal(t, completionPriorities, runCompletion(t, "ntfy", "pub", "--config", configFile, "-p", ""))
+	require.Equal(t, completionPriorities, runCompletion(t, "ntfy", "pub", "--config", configFile, "--priority", "=", "hi"))
+	require.Contains(t, runCompletion(t, "ntfy", "pub", "--config", configFile, "--priority=h"), "--priority=high")
+	tags := runCompletion(t, "ntfy", "pub", "--config", configFile, "alerts", "--tags", "warning,")
+	require.Contains(t, tags, "warning,jenkins")
+	require.Contains(t, tags, "warning,skull")
+	require.NotContains(t, tags, "warning,warning")
+
+	// Flags
+	flags := runCompletion(t, "ntfy", "pub", "--config", configFile, "--pri")
+	require.Equal(t, []string{"--priority"}, flags)
+
+	// Other flag values are left to the shell (e.g. files)
+	require.Empty(t, runCompletion(t, "ntfy", "pub", "--config", configFile, "--file", ""))
+}
+
+func TestCompletionWords(t *testing.T) {
+	cur, prev := completionWords([]string{"ntfy", "pub", "-p", "hi", "--generate-bash-completion"})
+	require.Equal(t, "hi", cur)
+	require.Equal(t, "-p", prev)
+
+	cur, prev = completionWords([]string{"ntfy", "pub", "--priority", "=", "--generate-bash-completion"})
+	require.Equal(t, "", cur)
+	require.Equal(t, "--priority", prev)
+
+	cur, prev = completionWords([]string{"ntfy", "--generate-bash-completion"})
+	require.Equal(t, "ntfy", cur)
+	require.Equal(t, "", prev)
+}
+
+// runCompletion runs the completion for the given command line (the last argument being the current word), and
+// returns the completion candidates. The os.Args are replaced, since urfave/cli and completeClientCommand use them.
+func runCompletion(t *testing.T, args ...string) []string {
+	if cur := args[len(args)-1]; strings.HasPrefix(cur, "-") { // Like the completion scripts
+		args = append(append(args[:len(args)-1], "--"), cur)
+	}
+	args = append(args, "--generate-bash-completion")
+	osArgs := os.Args
+	os.Args = args
+	defer func() { os.Args = osArgs }()
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run(args))
+	output := strings.TrimSpace(stdout.String())
+	if output == "" {
+		return nil
+	}
+	return strings.Split(output, "\n")
+}
@@ -0,0 +1,334 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func init() {
+	commands = append(commands, cmdGroup)
+}
+
+var (
+	flagsGroup = append([]cli.Flag{}, flagsUser...)
+)
+
+var cmdGroup = &cli.Command{
+	Name:      "group",
+	Usage:     "Manage/show groups",
+	UsageText: "ntfy group [list|add|remove|add-member|remove-member|access] ...",
+	Flags:     flagsGroup,
+	Before:    initConfigFileInputSourceFunc("config", flagsUser, initLogFunc),
+	Category:  categoryServer,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "add",
+			Aliases:   []string{"a"},
+			Usage:     "Adds a new group",
+			UsageText: "ntfy group add [OPTIONS] NAME",
+			Action:    execGroupAdd,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "ignore-exists", Usage: "if the group already exists, perform no action and exit"},
+			},
+			Description: `Add a new, empty group to the ntfy user database.
+
+This is a server-only command. It directly reads from user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Example:
+  ntfy group add ops
+`,
+		},
+		{
+			Name:      "remove",
+			Aliases:   []string{"del", "rm"},
+			Usage:     "Removes a group",
+			UsageText: "ntfy group remove NAME",
+			Action:    execGroupDel,
+			Description: `Remove a group from the ntfy user database.
+
+All memberships and access control entries of the group are removed as well. Reservations
+shared with the group remain with their owners.
+
+This is a server-only command. It directly reads from user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Example:
+  ntfy group del ops
+`,
+		},
+		{
+			Name:      "add-member",
+			Aliases:   []string{"am"},
+			Usage:     "Adds users to a group",
+			UsageText: "ntfy group add-member NAME USERNAME...",
+			Action:    execGroupAddMember,
+			Description: `Add one or more existing users to a group.
+
+This is a server-only command. It directly reads from user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Example:
+  ntfy group add-member ops phil ben
+`,
+		},
+		{
+			Name:      "remove-member",
+			Aliases:   []string{"rm-member", "rmm"},
+			Usage:     "Removes users from a group",
+			UsageText: "ntfy group remove-member NAME USERNAME...",
+			Action:    execGroupRemoveMember,
+			Description: `Remove one or more users from a group.
+
+This is a server-only command. It directly reads from user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Example:
+  ntfy group remove-member ops ben
+`,
+		},
+		{
+			Name:      "access",
+			Usage:     "Grant/revoke group access to a topic",
+			UsageText: "ntfy group access [--reset] NAME [TOPIC [PERMISSION]]",
+			Action:    execGroupAccess,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "reset", Aliases: []string{"r"}, Usage: "reset access for group (and topic)"},
+			},
+			Description: `Grant or revoke access to a topic for all members of a group.
+
+Group access control entries apply to all members of the group. If a user has a user-specific
+entry for a topic (see 'ntfy access'), it takes precedence over the group's entry. Group entries
+take precedence over entries for everyone.
+
+This is a server-only command. It directly reads from user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Arguments:
+  NAME         an existing group, as created with 'ntfy group add'
+  TOPIC        name of a topic with optional wildcards, e.g. "mytopic*"
+  PERMISSION   one of the following:
+               - read-write (alias: rw)
+               - read-only (aliases: read, ro)
+               - write-only (aliases: write, wo)
+               - deny (alias: none)
+
+Examples:
+  ntfy group access ops "alerts*" rw      # Allow read-write access to topics "alerts..." for group ops
+  ntfy group access ops status ro         # Allow read-only access to topic status for group ops
+  ntfy group access --reset ops status    # Reset access for group ops and topic status
+  ntfy group access --reset ops           # Reset all access for group ops
+`,
+		},
+		{
+			Name:    "list",
+			Aliases: []string{"l"},
+			Usage:   "Shows a list of groups",
+			Action:  execGroupList,
+			Description: `Shows a list of all groups, their members and access control entries.
+
+This is a server-only command. It directly reads from user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+`,
+		},
+	},
+	Description: `Manage groups of the ntfy server.
+
+The command allows you to add/remove groups, manage group membership, and grant access to topics
+to all members of a group at once. Topic owners can also share their reservations with groups
+they are a member of.
+
+This is a server-only command. It directly manages the user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Examples:
+  ntfy group add ops                      # Add group "ops"
+  ntfy group add-member ops phil ben      # Add users phil and ben to group ops
+  ntfy group access ops "alerts*" rw      # Allow read-write access to topics "alerts..." for group ops
+  ntfy group list                         # Show all groups
+  ntfy group del ops                      # Delete group ops
+`,
+}
+
+func execGroupAdd(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return errors.New("group name expected, type 'ntfy group add --help' for help")
+	} else if !user.AllowedGroup(name) {
+		return errors.New("group name must consist only of characters A-Z, a-z, 0-9, '.', '-' and '_'")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if err := manager.AddGroup(name); err == user.ErrGroupExists {
+		if c.Bool("ignore-exists") {
+			fmt.Fprintf(c.App.ErrWriter, "group %s already exists (exited successfully)\n", name)
+			return nil
+		}
+		return fmt.Errorf("group %s already exists", name)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "group %s added\n", name)
+	return nil
+}
+
+func execGroupDel(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return errors.New("group name expected, type 'ntfy group del --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if err := manager.RemoveGroup(name); err == user.ErrGroupNotFound {
+		return fmt.Errorf("group %s does not exist", name)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "group %s removed\n", name)
+	return nil
+}
+
+func execGroupAddMember(c *cli.Context) error {
+	return changeGroupMembers(c, true)
+}
+
+func execGroupRemoveMember(c *cli.Context) error {
+	return changeGroupMembers(c, false)
+}
+
+func changeGroupMembers(c *cli.Context, add bool) error {
+	name := c.Args().Get(0)
+	usernames := c.Args().Tail()
+	if name == "" || len(usernames) == 0 {
+		return errors.New("group name and username(s) expected, type 'ntfy group --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if add {
+		err = manager.AddGroupMembers(name, usernames...)
+	} else {
+		err = manager.RemoveGroupMembers(name, usernames...)
+	}
+	if err == user.ErrGroupNotFound {
+		return fmt.Errorf("group %s does not exist", name)
+	} else if err == user.ErrUserNotFound {
+		return errors.New("user does not exist")
+	} else if err != nil {
+		return err
+	}
+	if add {
+		fmt.Fprintf(c.App.ErrWriter, "added user(s) to group %s\n\n", name)
+	} else {
+		fmt.Fprintf(c.App.ErrWriter, "removed user(s) from group %s\n\n", name)
+	}
+	return showGroup(c, manager, name)
+}
+
+func execGroupAccess(c *cli.Context) error {
+	if c.NArg() > 3 {
+		return errors.New("too many arguments, please check 'ntfy group access --help' for usage details")
+	}
+	name := c.Args().Get(0)
+	topic := c.Args().Get(1)
+	perms := c.Args().Get(2)
+	if name == "" {
+		return errors.New("group name expected, type 'ntfy group access --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("reset") {
+		if perms != "" {
+			return errors.New("too many arguments, please check 'ntfy group access --help' for usage details")
+		}
+		if err := manager.ResetGroupAccess(name, topic); err == user.ErrGroupNotFound {
+			return fmt.Errorf("group %s does not exist", name)
+		} else if err != nil {
+			return err
+		}
+		if topic == "" {
+			fmt.Fprintf(c.App.ErrWriter, "reset access for group %s\n\n", name)
+		} else {
+			fmt.Fprintf(c.App.ErrWriter, "reset access for group %s and topic %s\n\n", name, topic)
+		}
+		return showGroup(c, manager, name)
+	} else if topic == "" || perms == "" {
+		return errors.New("invalid syntax, please check 'ntfy group access --help' for usage details")
+	}
+	if !util.Contains([]string{"read-write", "rw", "read-only", "read", "ro", "write-only", "write", "wo", "none", "deny"}, perms) {
+		return errors.New("permission must be one of: read-write, read-only, write-only, or deny (or the aliases: read, ro, write, wo, none)")
+	}
+	permission, err := user.ParsePermission(perms)
+	if err != nil {
+		return err
+	}
+	if err := manager.AllowGroupAccess(name, topic, permission); err == user.ErrGroupNotFound {
+		return fmt.Errorf("group %s does not exist", name)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "changed access to topic %s for group %s\n\n", topic, name)
+	return showGroup(c, manager, name)
+}
+
+func execGroupList(c *cli.Context) error {
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	groups, err := manager.Groups()
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		printGroup(c, group)
+	}
+	return nil
+}
+
+func showGroup(c *cli.Context, manager *user.Manager, name string) error {
+	group, err := manager.Group(name)
+	if err != nil {
+		return err
+	}
+	printGroup(c, group)
+	return nil
+}
+
+func printGroup(c *cli.Context, group *user.Group) {
+	fmt.Fprintf(c.App.ErrWriter, "group %s (id: %s)\n", group.Name, group.ID)
+	if len(group.Members) > 0 {
+		for _, username := range group.Members {
+			fmt.Fprintf(c.App.ErrWriter, "- member: %s\n", username)
+		}
+	} else {
+		fmt.Fprintf(c.App.ErrWriter, "- no members\n")
+	}
+	if len(group.Grants) > 0 {
+		for _, grant := range group.Grants {
+			if grant.Allow.IsReadWrite() {
+				fmt.Fprintf(c.App.ErrWriter, "- read-write access to topic %s\n", grant.TopicPattern)
+			} else if grant.Allow.IsRead() {
+				fmt.Fprintf(c.App.ErrWriter, "- read-only access to topic %s\n", grant.TopicPattern)
+			} else if grant.Allow.IsWrite() {
+				fmt.Fprintf(c.App.ErrWriter, "- write-only access to topic %s\n", grant.TopicPattern)
+			} else {
+				fmt.Fprintf(c.App.ErrWriter, "- no access to topic %s\n", grant.TopicPattern)
+			}
+		}
+	} else {
+		fmt.Fprintf(c.App.ErrWriter, "- no topic-specific permissions\n")
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/test"
+	"testing"
+)
+
+func TestCLI_Group_AddMembersAccessDelete(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+	app, stdin, _, _ = newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "ben"))
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "add", "ops"))
+	require.Contains(t, stderr.String(), "group ops added")
+
+	err := runGroupCommand(app, conf, "add", "ops")
+	require.NotNil(t, err)
+	require.Equal(t, "group ops already exists", err.Error())
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "add-member", "ops", "phil", "ben"))
+	require.Contains(t, stderr.String(), "added user(s) to group ops")
+	require.Contains(t, stderr.String(), "- member: ben\n- member: phil")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "access", "ops", "alerts*", "rw"))
+	require.Contains(t, stderr.String(), "- read-write access to topic alerts*")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "remove-member", "ops", "ben"))
+	require.Nil(t, runGroupCommand(app, conf, "list"))
+	require.Contains(t, stderr.String(), "group ops (id: gr_")
+	require.NotContains(t, stderr.String(), "- member: ben")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "access", "--reset", "ops"))
+	require.Contains(t, stderr.String(), "reset access for group ops")
+	require.Contains(t, stderr.String(), "- no topic-specific permissions")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "remove", "ops"))
+	require.Contains(t, stderr.String(), "group ops removed")
+
+	err = runGroupCommand(app, conf, "remove", "ops")
+	require.NotNil(t, err)
+	require.Equal(t, "group ops does not exist", err.Error())
+}
+
+func runGroupCommand(app *cli.App, conf *server.Config, args ...string) error {
+	userArgs := []string{
+		"ntfy",
+		"--log-level=ERROR",
+		"group",
+		"--config=" + conf.File, // Dummy config file to avoid lookups of real file
+		"--auth-file=" + conf.AuthFile,
+		"--auth-default-access=" + conf.AuthDefault.String(),
+	}
+	return app.Run(append(userArgs, args...))
+}
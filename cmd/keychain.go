@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/util"
+	"os"
+	"strings"
+)
+
+func init() {
+	commands = append(commands, cmdKeychain)
+}
+
+var newKeychain = client.NewKeychain // Replaced in tests
+
+var flagNoKeychain = &cli.BoolFlag{Name: "no-keychain", Aliases: []string{"no_keychain"}, EnvVars: []string{"NTFY_NO_KEYCHAIN"}, Usage: "do not read credentials from the OS keychain"}
+
+var cmdKeychain = &cli.Command{
+	Name:      "keychain",
+	Usage:     "Store client credentials in the OS keychain",
+	UsageText: "ntfy keychain [set|remove|migrate] ...",
+	Category:  categoryClient,
+	Before:    initLogFunc,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "set",
+			Aliases:   []string{"add"},
+			Usage:     "Store a password or token in the OS keychain",
+			UsageText: "ntfy keychain set NAME",
+			Action:    execKeychainSet,
+			Description: `Store a password or token in the OS keychain.
+
+The secret is read from stdin (or prompted for). It can then be referenced in the client config
+as "keychain:NAME" anywhere a password or token is expected.
+
+Example:
+  ntfy keychain set ntfy.sh/default-token    # Then use "default-token: keychain:ntfy.sh/default-token"`,
+		},
+		{
+			Name:      "remove",
+			Aliases:   []string{"del", "rm"},
+			Usage:     "Remove a password or token from the OS keychain",
+			UsageText: "ntfy keychain remove NAME",
+			Action:    execKeychainRemove,
+		},
+		{
+			Name:      "migrate",
+			Usage:     "Move the passwords and tokens in the client config to the OS keychain",
+			UsageText: "ntfy keychain migrate [--config=FILE]",
+			Action:    execKeychainMigrate,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "config", Aliases: []string{"c"}, Usage: "client config file"},
+			},
+			Description: `Move the passwords and tokens in the client config to the OS keychain.
+
+All passwords and tokens in the client config (default-password, default-token, and the password
+and token of the subscriptions) are stored in the OS keychain, and replaced with a reference
+("keychain:NAME") in the config file. The secrets are named after the host and topic they are
+used for, e.g. "ntfy.sh/default-token" or "ntfy.sh/mytopic/password".
+
+Example:
+  ntfy keychain migrate                         # Migrate the default client config
+  ntfy keychain migrate --config=client.yml     # Migrate an alternate client config`,
+		},
+	},
+	Description: `Store the passwords and tokens used by the client commands in the OS keychain (Keychain on macOS,
+the Secret Service via libsecret's secret-tool on Linux, Credential Manager on Windows), instead of
+in plaintext in the client config file.
+
+In the client config, secrets in the keychain are referenced as "keychain:NAME", e.g.
+"default-token: keychain:ntfy.sh/default-token". They are read whenever a client command loads the
+config. On systems without a keychain, pass --no-keychain (or set NTFY_NO_KEYCHAIN=1) to the client
+commands, and keep the credentials in the config file.
+
+Examples:
+  ntfy keychain migrate                        # Move all credentials from client.yml to the keychain
+  ntfy keychain set ntfy.sh/default-token      # Store a token in the keychain
+  ntfy keychain rm ntfy.sh/default-token       # Remove a token from the keychain
+
+` + clientCommandDescriptionSuffix,
+}
+
+func execKeychainSet(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return errors.New("must specify name, type 'ntfy keychain set --help' for help")
+	} else if !client.ValidKeychainName(name) {
+		return fmt.Errorf("invalid name %s, only letters, numbers and -_.:/@ are allowed", name)
+	}
+	fmt.Fprint(c.App.ErrWriter, "Enter password or token: ")
+	secret, err := util.ReadPassword(c.App.Reader)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "\r%s\r", strings.Repeat(" ", 30))
+	if err := newKeychain().Set(name, string(secret)); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "secret %s stored in keychain, use it in the client config as \"%s%s\"\n", name, client.KeychainPrefix, name)
+	return nil
+}
+
+func execKeychainRemove(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return errors.New("must specify name, type 'ntfy keychain remove --help' for help")
+	}
+	if err := newKeychain().Delete(name); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "secret %s removed from keychain\n", name)
+	return nil
+}
+
+func execKeychainMigrate(c *cli.Context) error {
+	filename := c.String("config")
+	if filename == "" {
+		filename = defaultClientConfigFile()
+	}
+	conf, err := client.LoadConfig(filename)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node // Unlike the config struct, the node tree retains comments when the file is written back
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	} else if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("nothing to migrate in %s", filename)
+	}
+	k := newKeychain()
+	migrated := 0
+	migrate := func(node *yaml.Node, name string) error {
+		if node == nil || node.Kind != yaml.ScalarNode || node.Value == "" {
+			return nil // Empty credentials are used to disable auth for a subscription
+		} else if _, ok := client.KeychainReference(node.Value); ok {
+			return nil
+		}
+		if err := k.Set(name, node.Value); err != nil {
+			return fmt.Errorf("cannot store %s in keychain: %w", name, err)
+		}
+		node.Value = client.KeychainPrefix + name
+		migrated++
+		fmt.Fprintf(c.App.ErrWriter, "moved %s to keychain\n", name)
+		return nil
+	}
+	root := doc.Content[0]
+	host := util.ShortTopicURL(conf.DefaultHost)
+	if err := migrate(yamlMappingValue(root, "default-password"), host+"/default-password"); err != nil {
+		return err
+	}
+	if err := migrate(yamlMappingValue(root, "default-token"), host+"/default-token"); err != nil {
+		return err
+	}
+	if subscribe := yamlMappingValue(root, "subscribe"); subscribe != nil && subscribe.Kind == yaml.SequenceNode {
+		cl := client.New(conf)
+		for i, node := range subscribe.Content {
+			if i >= len(conf.Subscribe) {
+				break
+			}
+			topicURL, err := cl.ExpandTopicURL(subscribeTopic(conf.Subscribe[i]))
+			if err != nil {
+				return err
+			}
+			topic := util.ShortTopicURL(topicURL)
+			if err := migrate(yamlMappingValue(node, "password"), topic+"/password"); err != nil {
+				return err
+			}
+			if err := migrate(yamlMappingValue(node, "token"), topic+"/token"); err != nil {
+				return err
+			}
+		}
+	}
+	if migrated == 0 {
+		fmt.Fprintf(c.App.ErrWriter, "no passwords or tokens to migrate in %s\n", filename)
+		return nil
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filename, buf.Bytes()); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "%d secret(s) moved to keychain, updated %s\n", migrated, filename)
+	return nil
+}
+
+// yamlMappingValue returns the value node for the given key in a mapping node, or nil if it does not exist
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file with the given content, retaining its permissions
+func writeFileAtomic(filename string, b []byte) error {
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	tmpFile := filename + ".tmp"
+	if err := os.WriteFile(tmpFile, b, stat.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, filename)
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/client"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCLI_Keychain_Set_Remove(t *testing.T) {
+	keychain := newTestKeychain(t)
+
+	app, stdin, _, stderr := newTestApp()
+	stdin.WriteString("tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2\n")
+	require.Nil(t, app.Run([]string{"ntfy", "keychain", "set", "ntfy.sh/default-token"}))
+	require.Contains(t, stderr.String(), `use it in the client config as "keychain:ntfy.sh/default-token"`)
+	require.Equal(t, "tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2", keychain["ntfy.sh/default-token"])
+
+	app, _, _, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "keychain", "rm", "ntfy.sh/default-token"}))
+	require.Empty(t, keychain)
+	require.Equal(t, client.ErrKeychainNotFound, app.Run([]string{"ntfy", "keychain", "rm", "ntfy.sh/default-token"}))
+	require.Equal(t, "invalid name my secret, only letters, numbers and -_.:/@ are allowed", app.Run([]string{"ntfy", "keychain", "set", "my secret"}).Error())
+}
+
+func TestCLI_Keychain_Migrate_Subscribe(t *testing.T) {
+	keychain := newTestKeychain(t)
+	message := `{"id":"RXIQBFaieLVr","time":124,"expires":1124,"event":"message","topic":"mytopic","message":"triggered"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/public/json" {
+			require.Equal(t, "", r.Header.Get("Authorization"))
+			return
+		}
+		require.Equal(t, "/mytopic/json", r.URL.Path)
+		require.Equal(t, "Basic cGhpbGlwcDpteXBhc3M=", r.Header.Get("Authorization"))
+		w.Write([]byte(message))
+	}))
+	defer server.Close()
+
+	filename := filepath.Join(t.TempDir(), "client.yml")
+	require.Nil(t, os.WriteFile(filename, []byte(fmt.Sprintf(`# My client config
+default-host: %s
+default-token: tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2 # The default token
+subscribe:
+  - topic: mytopic
+    user: philipp
+    password: mypass
+  - topic: public
+    token: ""
+`, server.URL)), 0600))
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "keychain", "migrate", "--config", filename}))
+	require.Contains(t, stderr.String(), "2 secret(s) moved to keychain")
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	require.Equal(t, "tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2", keychain[host+"/default-token"])
+	require.Equal(t, "mypass", keychain[host+"/mytopic/password"])
+	b, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	require.Contains(t, string(b), "# My client config")
+	require.Contains(t, string(b), "default-token: keychain:"+host+"/default-token # The default token")
+	require.Contains(t, string(b), "password: keychain:"+host+"/mytopic/password")
+	require.Contains(t, string(b), `token: ""`)
+	require.NotContains(t, string(b), "mypass")
+	stat, err := os.Stat(filename)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	// Credentials are read from the keychain
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--from-config", "--config=" + filename}))
+	require.Equal(t, message, strings.TrimSpace(stdout.String()))
+
+	// Migrating again does nothing
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "keychain", "migrate", "--config", filename}))
+	require.Contains(t, stderr.String(), "no passwords or tokens to migrate")
+
+	// Keychain can be disabled
+	app, _, _, _ = newTestApp()
+	err = app.Run([]string{"ntfy", "subscribe", "--poll", "--from-config", "--no-keychain", "--config=" + filename})
+	require.Equal(t, "config references secret "+host+"/default-token in the OS keychain, but the keychain is disabled", err.Error())
+}
+
+func TestCLI_Keychain_Publish_Not_Found(t *testing.T) {
+	newTestKeychain(t)
+	filename := filepath.Join(t.TempDir(), "client.yml")
+	require.Nil(t, os.WriteFile(filename, []byte("default-token: keychain:ntfy.sh/default-token\n"), 0600))
+
+	app, _, _, _ := newTestApp()
+	err := app.Run([]string{"ntfy", "publish", "--config=" + filename, "mytopic", "some message"})
+	require.Equal(t, "cannot read secret ntfy.sh/default-token from the OS keychain: secret not found in OS keychain", err.Error())
+}
+
+// testKeychain is an in-memory client.Keychain, since the OS keychain is not available in tests
+type testKeychain map[string]string
+
+func newTestKeychain(t *testing.T) testKeychain {
+	keychain := make(testKeychain)
+	newKeychainOrig := newKeychain
+	newKeychain = func() client.Keychain { return keychain }
+	t.Cleanup(func() { newKeychain = newKeychainOrig })
+	return keychain
+}
+
+func (k testKeychain) Get(name string) (string, error) {
+	secret, ok := k[name]
+	if !ok {
+		return "", client.ErrKeychainNotFound
+	}
+	return secret, nil
+}
+
+func (k testKeychain) Set(name, secret string) error {
+	k[name] = secret
+	return nil
+}
+
+func (k testKeychain) Delete(name string) error {
+	if _, ok := k[name]; !ok {
+		return client.ErrKeychainNotFound
+	}
+	delete(k, name)
+	return nil
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"fmt"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/log"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	notifyImageSizeLimit  = 2 * 1024 * 1024 // Attachments larger than this are not shown as thumbnail
+	notifyIconSizeLimit   = 256 * 1024
+	notifyCleanupDelay    = 30 * time.Second // Time after which downloaded images are removed, once the notification was shown
+	notifyDownloadTimeout = 10 * time.Second
+	notifyActionDefault   = "default" // Action key for a click on the notification itself
+)
+
+var (
+	// checkDesktopNotifyFunc and showDesktopNotificationFunc are overridden in tests
+	checkDesktopNotifyFunc      = checkDesktopNotify
+	showDesktopNotificationFunc = showDesktopNotification
+)
+
+// desktopNotification is a native desktop notification, created from a message
+type desktopNotification struct {
+	ID       string
+	Title    string
+	Message  string
+	Priority int
+	Click    string           // URL to open if the notification is clicked
+	Icon     string           // Path of the downloaded icon, if any
+	Image    string           // Path of the downloaded attachment thumbnail, if any
+	Actions  []*client.Action // Only "view" and "http" actions, others cannot be performed on the desktop
+}
+
+// notifyDesktop raises a desktop notification for the message. Failures are logged, but do not stop the subscription.
+func notifyDesktop(m *client.Message) {
+	log.Debug("%s Showing desktop notification", logMessagePrefix(m))
+	n := newDesktopNotification(m)
+	if err := showDesktopNotificationFunc(n); err != nil {
+		log.Warn("%s Desktop notification failed: %s", logMessagePrefix(m), err.Error())
+		n.cleanup()
+	}
+}
+
+func newDesktopNotification(m *client.Message) *desktopNotification {
+	n := &desktopNotification{
+		ID:       m.ID,
+		Title:    m.Title,
+		Message:  m.Message,
+		Priority: m.Priority,
+		Click:    m.Click,
+		Actions:  make([]*client.Action, 0),
+	}
+	if n.Title == "" {
+		n.Title = m.Topic
+	}
+	if n.Priority == 0 {
+		n.Priority = 3
+	}
+	for _, action := range m.Actions {
+		if action.Action == "view" || action.Action == "http" {
+			n.Actions = append(n.Actions, action)
+		}
+	}
+	if m.Attachment != nil {
+		if n.Message == "" {
+			n.Message = fmt.Sprintf("You received a file: %s", m.Attachment.Name)
+		}
+		if n.Click == "" {
+			n.Click = m.Attachment.URL
+		}
+		if strings.HasPrefix(m.Attachment.Type, "image/") && m.Attachment.Size <= notifyImageSizeLimit {
+			image, err := downloadNotificationFile(m.Attachment.URL, m.ID+"-image"+filepath.Ext(m.Attachment.Name), notifyImageSizeLimit)
+			if err != nil {
+				log.Debug("%s Cannot download attachment thumbnail: %s", logMessagePrefix(m), err.Error())
+			}
+			n.Image = image
+		}
+	}
+	if m.Icon != "" {
+		icon, err := downloadNotificationFile(m.Icon, m.ID+"-icon"+filepath.Ext(m.Icon), notifyIconSizeLimit)
+		if err != nil {
+			log.Debug("%s Cannot download icon: %s", logMessagePrefix(m), err.Error())
+		}
+		n.Icon = icon
+	}
+	return n
+}
+
+// downloadNotificationFile downloads a file to the temp dir, so that it can be passed to the notification tool
+func downloadNotificationFile(url, name string, limit int64) (string, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "", fmt.Errorf("invalid URL %s", url)
+	}
+	httpClient := &http.Client{Timeout: notifyDownloadTimeout}
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response %s", resp.Status)
+	} else if resp.ContentLength > limit {
+		return "", fmt.Errorf("file too large")
+	}
+	filename := filepath.Join(os.TempDir(), "ntfy-notify-"+name)
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	n, err := io.Copy(file, io.LimitReader(resp.Body, limit+1))
+	if err != nil || n > limit {
+		os.Remove(filename)
+		if err == nil {
+			err = fmt.Errorf("file too large")
+		}
+		return "", err
+	}
+	return filename, nil
+}
+
+// showDesktopNotification runs the OS-specific notification command in the background. If the notification
+// tool reports that the notification or one of its action buttons was clicked, the click is handled via activate.
+func showDesktopNotification(n *desktopNotification) error {
+	cmd := desktopNotifyCommand(n)
+	log.Trace("Running desktop notification command: %s", strings.Join(cmd.Args, " "))
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Debug("Desktop notification command failed: %s", err.Error())
+		}
+		n.activate(strings.TrimSpace(stdout.String()))
+		time.AfterFunc(notifyCleanupDelay, n.cleanup)
+	}()
+	return nil
+}
+
+// activate handles a click on the notification (key "default"), or on one of its actions (key is the action index)
+func (n *desktopNotification) activate(key string) {
+	if key == "" {
+		return
+	} else if key == notifyActionDefault {
+		if n.Click != "" {
+			openURL(n.Click)
+		}
+		return
+	}
+	index, err := strconv.Atoi(key)
+	if err != nil || index < 0 || index >= len(n.Actions) {
+		return
+	}
+	action := n.Actions[index]
+	switch action.Action {
+	case "view":
+		openURL(action.URL)
+	case "http":
+		if err := performHTTPAction(action); err != nil {
+			log.Warn("HTTP action %s failed: %s", action.URL, err.Error())
+		}
+	}
+}
+
+func (n *desktopNotification) cleanup() {
+	for _, filename := range []string{n.Icon, n.Image} {
+		if filename != "" {
+			os.Remove(filename)
+		}
+	}
+}
+
+func performHTTPAction(action *client.Action) error {
+	method := action.Method
+	if method == "" {
+		method = http.MethodPost // Same default as in the apps
+	}
+	req, err := http.NewRequest(method, action.URL, strings.NewReader(action.Body))
+	if err != nil {
+		return err
+	}
+	for k, v := range action.Headers {
+		req.Header.Set(k, v)
+	}
+	httpClient := &http.Client{Timeout: notifyDownloadTimeout}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return nil
+}
+
+func openURL(url string) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "mailto:") {
+		log.Warn("Not opening URL %s, only http(s) and mailto links are supported", url)
+		return
+	}
+	cmd := openURLCommand(url)
+	if err := cmd.Start(); err != nil {
+		log.Warn("Cannot open URL %s: %s", url, err.Error())
+		return
+	}
+	go cmd.Wait()
+}
+
+// checkCommandExists returns an error mentioning the purpose of the command, if it is not in the PATH
+func checkCommandExists(command, purpose string) error {
+	if _, err := exec.LookPath(command); err != nil {
+		return fmt.Errorf("--notify requires '%s' to %s, but it was not found in PATH", command, purpose)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func checkDesktopNotify() error {
+	return checkCommandExists("osascript", "show notifications")
+}
+
+// desktopNotifyCommand returns a terminal-notifier command for the notification, if it is installed, since it
+// supports click URLs and images. Otherwise, the notification is shown via AppleScript, without those features.
+// Action buttons are not supported on macOS.
+func desktopNotifyCommand(n *desktopNotification) *exec.Cmd {
+	if _, err := exec.LookPath("terminal-notifier"); err == nil {
+		args := []string{"-title", n.Title, "-message", n.Message, "-group", n.ID}
+		if n.Click != "" {
+			args = append(args, "-open", n.Click)
+		}
+		if n.Icon != "" {
+			args = append(args, "-appIcon", n.Icon)
+		}
+		if n.Image != "" {
+			args = append(args, "-contentImage", n.Image)
+		}
+		if n.Priority >= 4 {
+			args = append(args, "-sound", "default")
+		}
+		return exec.Command("terminal-notifier", args...)
+	}
+	script := fmt.Sprintf(`display notification "%s" with title "%s"`, escapeAppleScript(n.Message), escapeAppleScript(n.Title))
+	if n.Priority >= 4 {
+		script += ` sound name "default"`
+	}
+	return exec.Command("osascript", "-e", script)
+}
+
+func escapeAppleScript(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+func openURLCommand(url string) *exec.Cmd {
+	return exec.Command("open", url)
+}
+
+// promptCommand returns a command that asks the user for a line of text, and prints it to stdout
+func promptCommand(text string) (*exec.Cmd, error) {
+	script := fmt.Sprintf(`text returned of (display dialog "%s" default answer "" with title "ntfy")`, escapeAppleScript(text))
+	return exec.Command("osascript", "-e", script), nil
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/test"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCLI_Subscribe_Notify_Poll(t *testing.T) {
+	conf := server.NewConfig()
+	conf.BaseURL = "http://127.0.0.1"
+	s, port := test.StartServerWithConfig(t, conf)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+	notifications := stubDesktopNotify(t)
+
+	cl := client.New(client.NewConfig())
+	_, err := cl.PublishReader(topic, strings.NewReader("\x89PNG\r\n\x1a\n some image"),
+		client.WithFilename("cat.png"),
+		client.WithMessage("look at this"),
+		client.WithPriority("5"),
+		client.WithActions("view, Open docs, https://docs.ntfy.sh; broadcast, Take picture; http, Close door, https://api.example.com/door"))
+	require.Nil(t, err)
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--notify", topic}))
+	require.Equal(t, "", stdout.String())
+	require.Equal(t, 1, len(*notifications))
+
+	n := (*notifications)[0]
+	require.Equal(t, "mytopic", n.Title)
+	require.Equal(t, "look at this", n.Message)
+	require.Equal(t, 5, n.Priority)
+	require.True(t, strings.HasSuffix(n.Click, ".png"))
+	require.Equal(t, 2, len(n.Actions))
+	require.Equal(t, "Open docs", n.Actions[0].Label)
+	require.Equal(t, "Close door", n.Actions[1].Label)
+}
+
+func TestDesktopNotification_Image(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("some image"))
+	}))
+	defer ts.Close()
+
+	n := newDesktopNotification(&client.Message{
+		ID:    "abc",
+		Topic: "mytopic",
+		Attachment: &client.Attachment{
+			Name: "cat.png",
+			Type: "image/png",
+			Size: 10,
+			URL:  ts.URL + "/file/abc.png",
+		},
+	})
+	require.Equal(t, "You received a file: cat.png", n.Message)
+	require.Equal(t, ts.URL+"/file/abc.png", n.Click)
+	b, err := os.ReadFile(n.Image)
+	require.Nil(t, err)
+	require.Equal(t, "some image", string(b))
+	n.cleanup()
+	require.NoFileExists(t, n.Image)
+}
+
+func TestCLI_Subscribe_Notify_WithCommand(t *testing.T) {
+	stubDesktopNotify(t)
+	app, _, _, _ := newTestApp()
+	err := app.Run([]string{"ntfy", "subscribe", "--notify", "mytopic", "echo hi"})
+	require.Equal(t, "cannot set both a command and --notify", err.Error())
+}
+
+func TestDesktopNotification_ActivateHTTPAction(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- r
+		bodies <- string(body)
+	}))
+	defer ts.Close()
+
+	n := newDesktopNotification(&client.Message{
+		ID:    "abc",
+		Topic: "mytopic",
+		Actions: []*client.Action{
+			{Action: "http", Label: "Close door", URL: ts.URL + "/door", Method: "PUT", Body: "close", Headers: map[string]string{"Authorization": "Bearer secret"}},
+		},
+	})
+	n.activate("1") // Out of range, ignored
+	n.activate("0")
+	r := <-requests
+	require.Equal(t, "PUT", r.Method)
+	require.Equal(t, "/door", r.URL.Path)
+	require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+	require.Equal(t, "close", <-bodies)
+}
+
+func TestDesktopNotifyCommand(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("notify-send is only used on Linux")
+	}
+	cmd := desktopNotifyCommand(&desktopNotification{
+		Title:    "Backup",
+		Message:  "-- done",
+		Priority: 5,
+		Click:    "https://example.com",
+		Image:    "/tmp/image.png",
+		Actions:  []*client.Action{{Action: "view", Label: "Logs", URL: "https://example.com/logs"}},
+	})
+	require.Equal(t, []string{
+		"notify-send",
+		"--app-name=ntfy",
+		"--urgency=critical",
+		"--hint=string:image-path:/tmp/image.png",
+		"--action=default=Open",
+		"--action=0=Logs",
+		"--wait",
+		"--",
+		"Backup",
+		"-- done",
+	}, cmd.Args)
+}
+
+func stubDesktopNotify(t *testing.T) *[]*desktopNotification {
+	notifications := make([]*desktopNotification, 0)
+	oldCheck, oldShow := checkDesktopNotifyFunc, showDesktopNotificationFunc
+	checkDesktopNotifyFunc = func() error { return nil }
+	showDesktopNotificationFunc = func(n *desktopNotification) error {
+		notifications = append(notifications, n)
+		return nil
+	}
+	t.Cleanup(func() {
+		checkDesktopNotifyFunc, showDesktopNotificationFunc = oldCheck, oldShow
+	})
+	return &notifications
+}
@@ -0,0 +1,62 @@
+//go:build linux || dragonfly || freebsd || netbsd || openbsd
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+func checkDesktopNotify() error {
+	return checkCommandExists("notify-send", "show notifications via D-Bus (e.g. package libnotify-bin)")
+}
+
+// desktopNotifyCommand returns a notify-send command for the notification. If the notification has a click URL or
+// actions, the command waits until the notification is closed, and prints the key of the clicked action (this
+// requires libnotify 0.7.9 or later).
+func desktopNotifyCommand(n *desktopNotification) *exec.Cmd {
+	args := []string{"--app-name=ntfy", "--urgency=" + notifyUrgency(n.Priority)}
+	if n.Icon != "" {
+		args = append(args, "--icon="+n.Icon)
+	}
+	if n.Image != "" {
+		args = append(args, "--hint=string:image-path:"+n.Image)
+	}
+	if n.Click != "" {
+		args = append(args, fmt.Sprintf("--action=%s=Open", notifyActionDefault))
+	}
+	for i, action := range n.Actions {
+		args = append(args, fmt.Sprintf("--action=%d=%s", i, action.Label))
+	}
+	if n.Click != "" || len(n.Actions) > 0 {
+		args = append(args, "--wait")
+	}
+	args = append(args, "--", n.Title, n.Message)
+	return exec.Command("notify-send", args...)
+}
+
+func notifyUrgency(priority int) string {
+	switch {
+	case priority <= 2:
+		return "low"
+	case priority == 5:
+		return "critical"
+	default:
+		return "normal"
+	}
+}
+
+func openURLCommand(url string) *exec.Cmd {
+	return exec.Command("xdg-open", url)
+}
+
+// promptCommand returns a command that asks the user for a line of text, and prints it to stdout
+func promptCommand(text string) (*exec.Cmd, error) {
+	if _, err := exec.LookPath("zenity"); err == nil {
+		return exec.Command("zenity", "--entry", "--title=ntfy", "--text="+text), nil
+	} else if _, err := exec.LookPath("kdialog"); err == nil {
+		return exec.Command("kdialog", "--title", "ntfy", "--inputbox", text), nil
+	}
+	return nil, errors.New("publishing from the tray requires 'zenity' or 'kdialog'")
+}
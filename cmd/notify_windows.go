@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// notifyAppID is the app ID of PowerShell, since toasts of unregistered app IDs are not shown
+const notifyAppID = `{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe`
+
+const notifyToastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+[Windows.Data.Xml.Dom.XmlDocument, Windows.Data.Xml.Dom.XmlDocument, ContentType = WindowsRuntime] | Out-Null
+$xml = New-Object Windows.Data.Xml.Dom.XmlDocument
+$xml.LoadXml('%s')
+$toast = [Windows.UI.Notifications.ToastNotification]::new($xml)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('%s').Show($toast)`
+
+func checkDesktopNotify() error {
+	return checkCommandExists("powershell.exe", "show toast notifications")
+}
+
+// desktopNotifyCommand returns a PowerShell command that shows a toast notification. Clicks and "view" actions
+// open their URL via protocol activation; "http" actions are not supported, since they would require a registered app.
+func desktopNotifyCommand(n *desktopNotification) *exec.Cmd {
+	var toast strings.Builder
+	toast.WriteString(`<toast`)
+	if n.Click != "" {
+		fmt.Fprintf(&toast, ` activationType="protocol" launch="%s"`, escapeXML(n.Click))
+	}
+	if n.Priority == 5 {
+		toast.WriteString(` scenario="urgent"`)
+	}
+	toast.WriteString(`><visual><binding template="ToastGeneric">`)
+	fmt.Fprintf(&toast, `<text>%s</text><text>%s</text>`, escapeXML(n.Title), escapeXML(n.Message))
+	if n.Icon != "" {
+		fmt.Fprintf(&toast, `<image placement="appLogoOverride" src="%s"/>`, escapeXML(n.Icon))
+	}
+	if n.Image != "" {
+		fmt.Fprintf(&toast, `<image src="%s"/>`, escapeXML(n.Image))
+	}
+	toast.WriteString(`</binding></visual><actions>`)
+	for _, action := range n.Actions {
+		if action.Action == "view" {
+			fmt.Fprintf(&toast, `<action content="%s" activationType="protocol" arguments="%s"/>`, escapeXML(action.Label), escapeXML(action.URL))
+		}
+	}
+	toast.WriteString(`</actions></toast>`)
+	script := fmt.Sprintf(notifyToastScript, strings.ReplaceAll(toast.String(), "'", "''"), notifyAppID)
+	return exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", script)
+}
+
+func escapeXML(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func openURLCommand(url string) *exec.Cmd {
+	return exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+}
+
+// promptCommand returns a command that asks the user for a line of text, and prints it to stdout
+func promptCommand(text string) (*exec.Cmd, error) {
+	script := fmt.Sprintf(`Add-Type -AssemblyName Microsoft.VisualBasic; [Microsoft.VisualBasic.Interaction]::InputBox('%s', 'ntfy')`, strings.ReplaceAll(text, "'", "''"))
+	return exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", script), nil
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/urfave/cli/v2"
@@ -19,9 +21,14 @@ func init() {
 	commands = append(commands, cmdPublish)
 }
 
+const (
+	publishNDJSONLineLimit = 1024 * 1024 // Max length of a line with --stdin-ndjson
+)
+
 var flagsPublish = append(
 	append([]cli.Flag{}, flagsDefault...),
 	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG"}, Usage: "client config file"},
+	flagNoKeychain,
 	&cli.StringFlag{Name: "title", Aliases: []string{"t"}, EnvVars: []string{"NTFY_TITLE"}, Usage: "message title"},
 	&cli.StringFlag{Name: "message", Aliases: []string{"m"}, EnvVars: []string{"NTFY_MESSAGE"}, Usage: "message body"},
 	&cli.StringFlag{Name: "priority", Aliases: []string{"p"}, EnvVars: []string{"NTFY_PRIORITY"}, Usage: "priority of the message (1=min, 2=low, 3=default, 4=high, 5=max)"},
@@ -29,6 +36,11 @@ var flagsPublish = append(
 	&cli.StringFlag{Name: "delay", Aliases: []string{"at", "in", "D"}, EnvVars: []string{"NTFY_DELAY"}, Usage: "delay/schedule message"},
 	&cli.StringFlag{Name: "click", Aliases: []string{"U"}, EnvVars: []string{"NTFY_CLICK"}, Usage: "URL to open when notification is clicked"},
 	&cli.StringFlag{Name: "icon", Aliases: []string{"i"}, EnvVars: []string{"NTFY_ICON"}, Usage: "URL to use as notification icon"},
+	&cli.StringFlag{Name: "sound", EnvVars: []string{"NTFY_SOUND"}, Usage: "name of the notification sound to play"},
+	&cli.StringFlag{Name: "in-reply-to", Aliases: []string{"in_reply_to", "reply"}, EnvVars: []string{"NTFY_IN_REPLY_TO"}, Usage: "ID of the message this message is a reply to"},
+	&cli.StringFlag{Name: "location", Aliases: []string{"loc"}, EnvVars: []string{"NTFY_LOCATION"}, Usage: "geo location the message refers to, as latitude,longitude"},
+	&cli.StringFlag{Name: "hostname", EnvVars: []string{"NTFY_HOSTNAME"}, Usage: "host the message originates from"},
+	&cli.StringFlag{Name: "correlation-id", Aliases: []string{"correlation_id"}, EnvVars: []string{"NTFY_CORRELATION_ID"}, Usage: "ID that relates the message to other messages, e.g. an incident ID"},
 	&cli.StringFlag{Name: "actions", Aliases: []string{"A"}, EnvVars: []string{"NTFY_ACTIONS"}, Usage: "actions JSON array or simple definition"},
 	&cli.StringFlag{Name: "attach", Aliases: []string{"a"}, EnvVars: []string{"NTFY_ATTACH"}, Usage: "URL to send as an external attachment"},
 	&cli.BoolFlag{Name: "markdown", Aliases: []string{"md"}, EnvVars: []string{"NTFY_MARKDOWN"}, Usage: "Message is formatted as Markdown"},
@@ -41,6 +53,7 @@ var flagsPublish = append(
 	&cli.BoolFlag{Name: "wait-cmd", Aliases: []string{"wait_cmd", "cmd", "done"}, EnvVars: []string{"NTFY_WAIT_CMD"}, Usage: "run command and wait until it finishes before publishing"},
 	&cli.BoolFlag{Name: "no-cache", Aliases: []string{"no_cache", "C"}, EnvVars: []string{"NTFY_NO_CACHE"}, Usage: "do not cache message server-side"},
 	&cli.BoolFlag{Name: "no-firebase", Aliases: []string{"no_firebase", "F"}, EnvVars: []string{"NTFY_NO_FIREBASE"}, Usage: "do not forward message to Firebase"},
+	&cli.BoolFlag{Name: "stdin-ndjson", Aliases: []string{"stdin_ndjson"}, EnvVars: []string{"NTFY_STDIN_NDJSON"}, Usage: "publish every line of stdin as JSON message, in order"},
 	&cli.BoolFlag{Name: "quiet", Aliases: []string{"q"}, EnvVars: []string{"NTFY_QUIET"}, Usage: "do not print message"},
 )
 
@@ -51,10 +64,11 @@ var cmdPublish = &cli.Command{
 	UsageText: `ntfy publish [OPTIONS..] TOPIC [MESSAGE...]
 ntfy publish [OPTIONS..] --wait-cmd COMMAND...
 NTFY_TOPIC=.. ntfy publish [OPTIONS..] [MESSAGE...]`,
-	Action:   execPublish,
-	Category: categoryClient,
-	Flags:    flagsPublish,
-	Before:   initLogFunc,
+	Action:       execPublish,
+	Category:     categoryClient,
+	Flags:        flagsPublish,
+	Before:       initLogFunc,
+	BashComplete: completeClientCommand,
 	Description: `Publish a message to a ntfy server.
 
 Examples:
@@ -67,6 +81,9 @@ Examples:
   ntfy pub -e phil@example.com alerts 'App is down!'      # Also send email to phil@example.com
   ntfy pub --click="https://reddit.com" redd 'New msg'    # Opens Reddit when notification is clicked
   ntfy pub --icon="http://some.tld/icon.png" 'Icon!'      # Send notification with custom icon
+  ntfy pub --sound=siren pager 'Database is down'         # Send notification with custom sound
+  ntfy pub --in-reply-to=sPs71M8A2T alerts 'Fixed'        # Reply to a message, e.g. to acknowledge an alert
+  ntfy pub --hostname=db1 alerts 'Disk full'              # Add the host the message originates from
   ntfy pub --attach="http://some.tld/file.zip" files      # Send ZIP archive from URL as attachment
   ntfy pub --file=flower.jpg flowers 'Nice!'              # Send image.jpg as attachment
   ntfy pub -u phil:mypass secret Psst                     # Publish with username/password
@@ -76,6 +93,7 @@ Examples:
   NTFY_TOPIC=mytopic ntfy pub "some message"              # Use NTFY_TOPIC variable as topic 
   cat flower.jpg | ntfy pub --file=- flowers 'Nice!'      # Same as above, send image.jpg as attachment
   ntfy trigger mywebhook                                  # Sending without message, useful for webhooks
+  tail -f events.ndjson | ntfy pub --stdin-ndjson alerts  # Publish every line as JSON message, e.g. {"message":"hi"}
  
 Please also check out the docs on publishing messages. Especially for the --tags and --delay options, 
 it has incredibly useful information: https://ntfy.sh/docs/publish/.
@@ -94,6 +112,11 @@ func execPublish(c *cli.Context) error {
 	delay := c.String("delay")
 	click := c.String("click")
 	icon := c.String("icon")
+	sound := c.String("sound")
+	inReplyTo := c.String("in-reply-to")
+	location := c.String("location")
+	hostname := c.String("hostname")
+	correlationID := c.String("correlation-id")
 	actions := c.String("actions")
 	attach := c.String("attach")
 	markdown := c.Bool("markdown")
@@ -106,6 +129,7 @@ func execPublish(c *cli.Context) error {
 	noFirebase := c.Bool("no-firebase")
 	quiet := c.Bool("quiet")
 	pid := c.Int("wait-pid")
+	stdinNDJSON := c.Bool("stdin-ndjson")
 
 	// Checks
 	if user != "" && token != "" {
@@ -116,6 +140,8 @@ func execPublish(c *cli.Context) error {
 	topic, message, command, err := parseTopicMessageCommand(c)
 	if err != nil {
 		return err
+	} else if stdinNDJSON && (message != "" || file != "" || pid > 0 || len(command) > 0) {
+		return errors.New("cannot set --stdin-ndjson together with a message, --file, --wait-pid or --wait-cmd")
 	}
 	var options []client.PublishOption
 	if title != "" {
@@ -136,6 +162,21 @@ func execPublish(c *cli.Context) error {
 	if icon != "" {
 		options = append(options, client.WithIcon(icon))
 	}
+	if sound != "" {
+		options = append(options, client.WithSound(sound))
+	}
+	if inReplyTo != "" {
+		options = append(options, client.WithInReplyTo(inReplyTo))
+	}
+	if location != "" {
+		options = append(options, client.WithLocation(location))
+	}
+	if hostname != "" {
+		options = append(options, client.WithHostname(hostname))
+	}
+	if correlationID != "" {
+		options = append(options, client.WithCorrelationID(correlationID))
+	}
 	if actions != "" {
 		options = append(options, client.WithActions(strings.ReplaceAll(actions, "\n", " ")))
 	}
@@ -180,6 +221,9 @@ func execPublish(c *cli.Context) error {
 	} else if conf.DefaultUser != "" && conf.DefaultPassword != nil {
 		options = append(options, client.WithBasicAuth(conf.DefaultUser, *conf.DefaultPassword))
 	}
+	if stdinNDJSON {
+		return publishNDJSON(c, client.New(conf), topic, quiet, options...)
+	}
 	if pid > 0 {
 		newMessage, err := waitForProcess(pid)
 		if err != nil {
@@ -228,6 +272,43 @@ func execPublish(c *cli.Context) error {
 	return nil
 }
 
+// publishNDJSON publishes every line of stdin as JSON message (see client.PublishJSON), in the order they are read.
+// Lines that are not valid JSON objects, or that cannot be published, are reported and skipped, so that a single
+// bad line does not stop the stream. The command fails at the end if any of the lines were skipped.
+func publishNDJSON(c *cli.Context, cl *client.Client, topic string, quiet bool, options ...client.PublishOption) error {
+	scanner := bufio.NewScanner(c.App.Reader)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), publishNDJSONLineLimit)
+	var line, failed int
+	for scanner.Scan() {
+		line++
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+		var message map[string]any
+		if err := json.Unmarshal([]byte(raw), &message); err != nil || message == nil {
+			fmt.Fprintf(c.App.ErrWriter, "line %d: not a valid JSON object, skipping\n", line)
+			failed++
+			continue
+		}
+		m, err := cl.PublishJSON(topic, message, options...)
+		if err != nil {
+			fmt.Fprintf(c.App.ErrWriter, "line %d: %s\n", line, err.Error())
+			failed++
+			continue
+		}
+		if !quiet {
+			fmt.Fprintln(c.App.Writer, strings.TrimSpace(m.Raw))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	} else if failed > 0 {
+		return fmt.Errorf("%d of %d line(s) could not be published", failed, line)
+	}
+	return nil
+}
+
 // parseTopicMessageCommand reads the topic and the remaining arguments from the context.
 
 // There are a few cases to consider:
@@ -64,6 +64,8 @@ func TestCLI_Publish_All_The_Things(t *testing.T) {
 		// No --delay, --email
 		"--click", "https://ntfy.sh",
 		"--icon", "https://ntfy.sh/static/img/ntfy.png",
+		"--sound", "siren",
+		"--in-reply-to", "sPs71M8A2T12",
 		"--attach", "https://f-droid.org/F-Droid.apk",
 		"--filename", "fdroid.apk",
 		"--no-cache",
@@ -86,6 +88,8 @@ func TestCLI_Publish_All_The_Things(t *testing.T) {
 	require.Equal(t, int64(0), m.Attachment.Expires)
 	require.Equal(t, "", m.Attachment.Type)
 	require.Equal(t, "https://ntfy.sh/static/img/ntfy.png", m.Icon)
+	require.Equal(t, "siren", m.Sound)
+	require.Equal(t, "sPs71M8A2T12", m.InReplyTo)
 }
 
 func TestCLI_Publish_Wait_PID_And_Cmd(t *testing.T) {
@@ -298,3 +302,44 @@ func TestCLI_Publish_Token_And_UserPass(t *testing.T) {
 	require.Error(t, err)
 	require.Equal(t, "cannot set both --user and --token", err.Error())
 }
+
+func TestCLI_Publish_Stdin_NDJSON(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	app, stdin, stdout, stderr := newTestApp()
+	stdin.WriteString(`{"message":"first"}
+{"message":"second","topic":"othertopic","priority":5}
+
+not json
+{"message":"third","title":"my title"}
+`)
+	err := app.Run([]string{"ntfy", "publish", "--stdin-ndjson", "--title", "default title", topic})
+	require.Equal(t, "1 of 5 line(s) could not be published", err.Error())
+	require.Equal(t, "line 4: not a valid JSON object, skipping\n", stderr.String())
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Equal(t, 3, len(lines))
+	m := toMessage(t, lines[0])
+	require.Equal(t, "mytopic", m.Topic)
+	require.Equal(t, "first", m.Message)
+	require.Equal(t, "default title", m.Title)
+	m = toMessage(t, lines[1])
+	require.Equal(t, "othertopic", m.Topic)
+	require.Equal(t, 5, m.Priority)
+	m = toMessage(t, lines[2])
+	require.Equal(t, "third", m.Message)
+	require.Equal(t, "my title", m.Title)
+
+	app2, _, stdout, _ := newTestApp()
+	require.Nil(t, app2.Run([]string{"ntfy", "subscribe", "--poll", topic}))
+	lines = strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Equal(t, "first", toMessage(t, lines[0]).Message)
+	require.Equal(t, "third", toMessage(t, lines[1]).Message)
+}
+
+func TestCLI_Publish_Stdin_NDJSON_Errors(t *testing.T) {
+	app, _, _, _ := newTestApp()
+	err := app.Run([]string{"ntfy", "publish", "--stdin-ndjson", "mytopic", "a message"})
+	require.Equal(t, "cannot set --stdin-ndjson together with a message, --file, --wait-pid or --wait-cmd", err.Error())
+}
@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	commands = append(commands, cmdRun)
+}
+
+const (
+	runTailLineLimit = 512  // Bytes per captured output line, longer lines are cut
+	runTailSizeLimit = 3072 // Bytes of captured output in the message, to stay below the server's message limit
+)
+
+var flagsRun = append(
+	append([]cli.Flag{}, flagsDefault...),
+	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG"}, Usage: "client config file"},
+	flagNoKeychain,
+	&cli.StringFlag{Name: "topic", Aliases: []string{"t"}, EnvVars: []string{"NTFY_TOPIC"}, Usage: "topic to publish the notification to"},
+	&cli.StringFlag{Name: "title", EnvVars: []string{"NTFY_TITLE"}, Usage: "message title (default: depends on success or failure)"},
+	&cli.StringFlag{Name: "tags", Aliases: []string{"tag", "T"}, EnvVars: []string{"NTFY_TAGS"}, Usage: "comma separated list of additional tags and emojis"},
+	&cli.StringFlag{Name: "success-priority", Aliases: []string{"success_priority"}, Value: "default", Usage: "priority of the message if the command succeeds"},
+	&cli.StringFlag{Name: "failure-priority", Aliases: []string{"failure_priority"}, Value: "high", Usage: "priority of the message if the command fails"},
+	&cli.IntFlag{Name: "tail", Aliases: []string{"n"}, Value: 10, Usage: "number of trailing output lines to include in the message (0 to disable)"},
+	&cli.BoolFlag{Name: "failure-only", Aliases: []string{"failure_only"}, Usage: "only publish a message if the command fails"},
+	&cli.StringFlag{Name: "user", Aliases: []string{"u"}, EnvVars: []string{"NTFY_USER"}, Usage: "username[:password] used to auth against the server"},
+	&cli.StringFlag{Name: "token", Aliases: []string{"k"}, EnvVars: []string{"NTFY_TOKEN"}, Usage: "access token used to auth against the server"},
+	&cli.BoolFlag{Name: "quiet", Aliases: []string{"q"}, EnvVars: []string{"NTFY_QUIET"}, Usage: "do not print the published message"},
+)
+
+var cmdRun = &cli.Command{
+	Name:         "run",
+	Usage:        "Run a command, and publish a message when it finishes",
+	UsageText:    "ntfy run [OPTIONS..] -t TOPIC -- COMMAND [ARGS..]",
+	Action:       execRun,
+	Category:     categoryClient,
+	Flags:        flagsRun,
+	Before:       initLogFunc,
+	BashComplete: completeClientCommand,
+	Description: `Run a command, and publish a success or failure message to a topic when it finishes.
+
+The command's output is passed through to the terminal as usual. When the command exits, a
+message is published with the command, its duration and exit code, as well as the last lines
+of its output (see --tail). Failures are published with high priority, successes with the
+default priority (see --failure-priority and --success-priority).
+
+ntfy run exits with the exit code of the command, so it can be used in scripts and pipelines
+in place of the command itself. Use "--" to separate ntfy's options from the command's.
+
+Examples:
+  ntfy run -t mytopic -- make release                          # Publish when the build is done
+  ntfy run -t backups --failure-only -- restic backup /home    # Only publish if the backup fails
+  ntfy run -t mytopic --tail 0 --title "Sync done" -- rsync -a ./ /mnt/backup
+  NTFY_TOPIC=mytopic ntfy run -- ./long-script.sh             # Topic from environment variable
+
+` + clientCommandDescriptionSuffix,
+}
+
+// runResult is the outcome of a command run via "ntfy run"
+type runResult struct {
+	Command  string
+	Duration time.Duration
+	ExitCode int    // -1 if the command could not be started
+	Err      error  // Set if the command could not be started
+	Output   string // Trailing lines of stdout and stderr
+}
+
+func execRun(c *cli.Context) error {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	topic := c.String("topic")
+	title := c.String("title")
+	tags := c.String("tags")
+	tail := c.Int("tail")
+	user := c.String("user")
+	token := c.String("token")
+	command := c.Args().Slice()
+
+	// Checks
+	if topic == "" {
+		return errors.New("must specify topic, type 'ntfy run --help' for help")
+	} else if len(command) == 0 {
+		return errors.New("must specify command, type 'ntfy run --help' for help")
+	} else if user != "" && token != "" {
+		return errors.New("cannot set both --user and --token")
+	} else if tail < 0 {
+		return errors.New("--tail cannot be negative")
+	}
+
+	// Run command
+	result := runAndCaptureCommand(c, command, tail)
+	if result.ExitCode == 0 && c.Bool("failure-only") {
+		return nil
+	}
+
+	// Publish result
+	var options []client.PublishOption
+	if title == "" {
+		title = runTitle(result)
+	}
+	options = append(options, client.WithTitle(title))
+	if result.ExitCode == 0 {
+		options = append(options, client.WithPriority(c.String("success-priority")), client.WithTagsList(joinTags("white_check_mark", tags)))
+	} else {
+		options = append(options, client.WithPriority(c.String("failure-priority")), client.WithTagsList(joinTags("x", tags)))
+	}
+	if token != "" {
+		options = append(options, client.WithBearerAuth(token))
+	} else if user != "" {
+		var pass string
+		parts := strings.SplitN(user, ":", 2)
+		if len(parts) == 2 {
+			user = parts[0]
+			pass = parts[1]
+		} else {
+			fmt.Fprint(c.App.ErrWriter, "Enter Password: ")
+			p, err := util.ReadPassword(c.App.Reader)
+			if err != nil {
+				return err
+			}
+			pass = string(p)
+			fmt.Fprintf(c.App.ErrWriter, "\r%s\r", strings.Repeat(" ", 20))
+		}
+		options = append(options, client.WithBasicAuth(user, pass))
+	} else if conf.DefaultToken != "" {
+		options = append(options, client.WithBearerAuth(conf.DefaultToken))
+	} else if conf.DefaultUser != "" && conf.DefaultPassword != nil {
+		options = append(options, client.WithBasicAuth(conf.DefaultUser, *conf.DefaultPassword))
+	}
+	cl := client.New(conf)
+	m, err := cl.Publish(topic, runMessage(result), options...)
+	if err != nil {
+		if result.ExitCode == 0 {
+			return err
+		}
+		fmt.Fprintf(c.App.ErrWriter, "publishing failed: %s\n", err.Error())
+	} else if !c.Bool("quiet") {
+		fmt.Fprintln(c.App.ErrWriter, strings.TrimSpace(m.Raw)) // Stdout belongs to the command
+	}
+	if result.Err != nil {
+		return result.Err
+	} else if result.ExitCode != 0 {
+		return cli.Exit("", result.ExitCode) // Exit with the command's exit code
+	}
+	return nil
+}
+
+// runAndCaptureCommand runs the command, passing its output through to the app's writers, and capturing the
+// last tail lines of stdout and stderr (combined, in the order they were written)
+func runAndCaptureCommand(c *cli.Context, command []string, tail int) *runResult {
+	prettyCmd := util.QuoteCommand(command)
+	log.Debug("Running command: %s", prettyCmd)
+	output := newTailWriter(tail)
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = io.MultiWriter(c.App.Writer, output)
+	cmd.Stderr = io.MultiWriter(c.App.ErrWriter, output)
+	start := time.Now()
+	err := cmd.Run()
+	result := &runResult{
+		Command:  prettyCmd,
+		Duration: time.Since(start).Round(time.Millisecond),
+		Output:   output.String(),
+	}
+	if err != nil {
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			result.ExitCode = exitError.ExitCode()
+		} else {
+			result.ExitCode = -1
+			result.Err = fmt.Errorf("command failed: %s, error: %s", prettyCmd, err.Error())
+		}
+	}
+	log.Debug("Command exited after %s (exit code %d): %s", result.Duration, result.ExitCode, prettyCmd)
+	return result
+}
+
+func runTitle(result *runResult) string {
+	if result.Err != nil {
+		return "Command could not be started"
+	} else if result.ExitCode != 0 {
+		return fmt.Sprintf("Command failed (exit code %d)", result.ExitCode)
+	}
+	return "Command succeeded"
+}
+
+func runMessage(result *runResult) string {
+	var message string
+	if result.Err != nil {
+		message = result.Err.Error()
+	} else if result.ExitCode != 0 {
+		message = fmt.Sprintf("Command failed after %s (exit code %d): %s", result.Duration, result.ExitCode, result.Command)
+	} else {
+		message = fmt.Sprintf("Command succeeded after %s: %s", result.Duration, result.Command)
+	}
+	if result.Output != "" {
+		message += "\n\n" + result.Output
+	}
+	return message
+}
+
+func joinTags(tag, tags string) string {
+	if tags == "" {
+		return tag
+	}
+	return tag + "," + tags
+}
+
+// tailWriter is an io.Writer that keeps the last n lines written to it
+type tailWriter struct {
+	lines   []string
+	partial string
+	limit   int
+	mu      sync.Mutex
+}
+
+func newTailWriter(limit int) *tailWriter {
+	return &tailWriter{
+		lines: make([]string, 0),
+		limit: limit,
+	}
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.limit == 0 {
+		return len(p), nil
+	}
+	lines := strings.Split(w.partial+string(p), "\n")
+	w.partial = truncateLine(lines[len(lines)-1])
+	for _, line := range lines[:len(lines)-1] {
+		w.lines = append(w.lines, truncateLine(strings.TrimSuffix(line, "\r")))
+	}
+	if len(w.lines) > w.limit {
+		w.lines = w.lines[len(w.lines)-w.limit:]
+	}
+	return len(p), nil
+}
+
+// String returns the captured lines, including a trailing line without newline. If the lines are
+// longer than runTailSizeLimit in total, the oldest lines are dropped.
+func (w *tailWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	lines := w.lines
+	if w.partial != "" {
+		lines = append(append([]string{}, lines...), w.partial)
+	}
+	if len(lines) > w.limit {
+		lines = lines[len(lines)-w.limit:]
+	}
+	output := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	for len(output) > runTailSizeLimit && len(lines) > 1 {
+		lines = lines[1:]
+		output = strings.Join(lines, "\n")
+	}
+	return output
+}
+
+func truncateLine(line string) string {
+	if len(line) > runTailLineLimit {
+		return line[:runTailLineLimit]
+	}
+	return line
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/test"
+	"strings"
+	"testing"
+)
+
+func TestCLI_Run_Success(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	app, _, stdout, stderr := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "run", "-t", topic, "--tags", "robot", "--", "sh", "-c", "echo line 1; echo line 2"}))
+	require.Equal(t, "line 1\nline 2\n", stdout.String())
+
+	m := toMessage(t, stderr.String())
+	require.Equal(t, "Command succeeded", m.Title)
+	require.Equal(t, 3, m.Priority)
+	require.Equal(t, []string{"white_check_mark", "robot"}, m.Tags)
+	require.Contains(t, m.Message, "Command succeeded after ")
+	require.True(t, strings.HasSuffix(m.Message, `: sh -c "echo line 1; echo line 2"`+"\n\nline 1\nline 2"))
+}
+
+func TestCLI_Run_Failure_ExitCode(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	exitCode := 0
+	oldExiter := cli.OsExiter
+	cli.OsExiter = func(code int) { exitCode = code }
+	defer func() { cli.OsExiter = oldExiter }()
+
+	app, _, _, stderr := newTestApp()
+	err := app.Run([]string{"ntfy", "run", "-t", topic, "--tail", "2", "--", "sh", "-c", "echo a; echo b; sleep 0.2; echo c >&2; exit 3"})
+	require.Equal(t, 3, err.(cli.ExitCoder).ExitCode())
+	require.Equal(t, 3, exitCode)
+
+	m := toMessage(t, strings.TrimPrefix(stderr.String(), "c\n"))
+	require.Equal(t, "Command failed (exit code 3)", m.Title)
+	require.Equal(t, 4, m.Priority)
+	require.Equal(t, []string{"x"}, m.Tags)
+	require.True(t, strings.HasPrefix(m.Message, "Command failed after "))
+	require.True(t, strings.HasSuffix(m.Message, "\n\nb\nc"))
+}
+
+func TestCLI_Run_FailureOnly(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "run", "-t", topic, "--failure-only", "--", "true"}))
+	require.Equal(t, "", stderr.String())
+
+	app2, _, stdout, _ := newTestApp()
+	require.Nil(t, app2.Run([]string{"ntfy", "subscribe", "--poll", topic}))
+	require.Equal(t, "", stdout.String())
+}
+
+func TestCLI_Run_CommandNotFound(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	app, _, _, stderr := newTestApp()
+	err := app.Run([]string{"ntfy", "run", "-t", topic, "--", "this-command-does-not-exist-ntfy"})
+	require.ErrorContains(t, err, "command failed: this-command-does-not-exist-ntfy")
+
+	m := toMessage(t, stderr.String())
+	require.Equal(t, "Command could not be started", m.Title)
+	require.Equal(t, 4, m.Priority)
+}
+
+func TestCLI_Run_MissingTopicOrCommand(t *testing.T) {
+	t.Setenv("NTFY_TOPIC", "")
+	app, _, _, _ := newTestApp()
+	require.ErrorContains(t, app.Run([]string{"ntfy", "run", "--", "true"}), "must specify topic")
+
+	app2, _, _, _ := newTestApp()
+	require.ErrorContains(t, app2.Run([]string{"ntfy", "run", "-t", "mytopic"}), "must specify command")
+}
+
+func TestTailWriter(t *testing.T) {
+	w := newTailWriter(3)
+	fmt.Fprint(w, "line 1\nline 2\r\nline")
+	fmt.Fprint(w, " 3\nline 4\nline 5 without newline")
+	require.Equal(t, "line 3\nline 4\nline 5 without newline", w.String())
+
+	w = newTailWriter(0)
+	fmt.Fprint(w, "line 1\n")
+	require.Equal(t, "", w.String())
+
+	w = newTailWriter(100)
+	for i := 0; i < 20; i++ {
+		fmt.Fprintln(w, strings.Repeat("x", 1000))
+	}
+	require.LessOrEqual(t, len(w.String()), runTailSizeLimit)
+	require.Equal(t, strings.Repeat("x", runTailLineLimit), strings.Split(w.String(), "\n")[0])
+}
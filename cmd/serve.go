@@ -5,22 +5,29 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"github.com/SherClockHolmes/webpush-go"
 	"github.com/stripe/stripe-go/v74"
 	"heckel.io/ntfy/v2/user"
 	"io/fs"
 	"math"
 	"net"
+	"net/http"
 	"net/netip"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"heckel.io/ntfy/v2/log"
 
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
+	"gopkg.in/yaml.v2"
 	"heckel.io/ntfy/v2/server"
 	"heckel.io/ntfy/v2/util"
 )
@@ -33,42 +40,125 @@ const (
 	defaultServerConfigFile = "/etc/ntfy/server.yml"
 )
 
+// Bounds for the configurable request body size limits
+const (
+	maxMessageSizeLimit     = 5 * 1024 * 1024
+	minAccountBodySizeLimit = 4 * 1024
+	maxAccountBodySizeLimit = 1024 * 1024
+)
+
+// Defaults used in development mode (--dev), unless the respective options are set explicitly
+const (
+	devVisitorRequestLimitBurst         = 10000
+	devVisitorRequestLimitReplenish     = time.Millisecond
+	devVisitorSubscriptionLimit         = 1000
+	devVisitorEmailLimitBurst           = 1000
+	devVisitorEmailLimitReplenish       = time.Second
+	devVisitorAccountCreationLimitBurst = 1000
+	devVisitorAuthFailureLimitBurst     = 1000
+	devWebPushEmailAddress              = "dev@example.com"
+)
+
+var (
+	firebaseAndroidChannelRegex = regexp.MustCompile(`^[-_.a-zA-Z0-9]{1,64}$`)
+	tagIconTagRegex             = regexp.MustCompile(`^[-_.a-z0-9]{1,64}$`)
+	tagIconCodepointsRegex      = regexp.MustCompile(`^[Uu]\+[0-9A-Fa-f]{4,6}(?:[-\s]+[Uu]\+[0-9A-Fa-f]{4,6})*$`)
+	tagIconCodepointsSplitRegex = regexp.MustCompile(`[-\s]+`)
+	tagIconImageTypes           = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+	accessControlOriginRegex    = regexp.MustCompile(`^https?://[^/]+$`)
+	forwardRuleTargetRegex      = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+)
+
 var flagsServe = append(
 	append([]cli.Flag{}, flagsDefault...),
 	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG_FILE"}, Value: defaultServerConfigFile, DefaultText: defaultServerConfigFile, Usage: "config file"},
+	&cli.BoolFlag{Name: "dev", EnvVars: []string{"NTFY_DEV"}, Usage: "development mode: keep all state in memory, generate web push keys, relax rate limits and enable debug logging"},
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "base-url", Aliases: []string{"base_url", "B"}, EnvVars: []string{"NTFY_BASE_URL"}, Usage: "externally visible base URL for this host (e.g. https://ntfy.sh)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "listen-http", Aliases: []string{"listen_http", "l"}, EnvVars: []string{"NTFY_LISTEN_HTTP"}, Value: server.DefaultListenHTTP, Usage: "ip:port used as HTTP listen address"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "listen-https", Aliases: []string{"listen_https", "L"}, EnvVars: []string{"NTFY_LISTEN_HTTPS"}, Usage: "ip:port used as HTTPS listen address"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "listen-unix", Aliases: []string{"listen_unix", "U"}, EnvVars: []string{"NTFY_LISTEN_UNIX"}, Usage: "listen on unix socket path"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "listen-unix-mode", Aliases: []string{"listen_unix_mode"}, EnvVars: []string{"NTFY_LISTEN_UNIX_MODE"}, DefaultText: "system default", Usage: "file permissions of unix socket, e.g. 0700"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "listen-reuse-port", Aliases: []string{"listen_reuse_port"}, EnvVars: []string{"NTFY_LISTEN_REUSE_PORT"}, Value: false, Usage: "if set, SO_REUSEPORT is set on listeners, so a new ntfy process can take over while the old one drains"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "key-file", Aliases: []string{"key_file", "K"}, EnvVars: []string{"NTFY_KEY_FILE"}, Usage: "private key file, if listen-https is set"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "cert-file", Aliases: []string{"cert_file", "E"}, EnvVars: []string{"NTFY_CERT_FILE"}, Usage: "certificate file, if listen-https is set"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "firebase-key-file", Aliases: []string{"firebase_key_file", "F"}, EnvVars: []string{"NTFY_FIREBASE_KEY_FILE"}, Usage: "Firebase credentials file; if set additionally publish to FCM topic"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "firebase-workers", Aliases: []string{"firebase_workers"}, EnvVars: []string{"NTFY_FIREBASE_WORKERS"}, Value: server.DefaultFirebaseWorkers, Usage: "number of concurrent workers sending messages to Firebase"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "firebase-queue-size", Aliases: []string{"firebase_queue_size"}, EnvVars: []string{"NTFY_FIREBASE_QUEUE_SIZE"}, Value: server.DefaultFirebaseQueueSize, Usage: "max number of messages waiting to be sent to Firebase; messages are dropped if the queue is full"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "firebase-critical-alert-topics", Aliases: []string{"firebase_critical_alert_topics"}, EnvVars: []string{"NTFY_FIREBASE_CRITICAL_ALERT_TOPICS"}, Usage: "topics (or topic prefixes, e.g. oncall-*) for which urgent messages are sent as iOS critical alerts"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "firebase-android-channels", Aliases: []string{"firebase_android_channels"}, EnvVars: []string{"NTFY_FIREBASE_ANDROID_CHANNELS"}, Usage: "rules to map messages to Android notification channels, as channel[:topic=...;priority=...;tag=...]"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-file", Aliases: []string{"cache_file", "C"}, EnvVars: []string{"NTFY_CACHE_FILE"}, Usage: "cache file used for message caching"}),
 	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "cache-duration", Aliases: []string{"cache_duration", "b"}, EnvVars: []string{"NTFY_CACHE_DURATION"}, Value: server.DefaultCacheDuration, Usage: "buffer messages for this time to allow `since` requests"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "cache-batch-size", Aliases: []string{"cache_batch_size"}, EnvVars: []string{"NTFY_BATCH_SIZE"}, Usage: "max size of messages to batch together when writing to message cache (if zero, writes are synchronous)"}),
 	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "cache-batch-timeout", Aliases: []string{"cache_batch_timeout"}, EnvVars: []string{"NTFY_CACHE_BATCH_TIMEOUT"}, Usage: "timeout for batched async writes to the message cache (if zero, writes are synchronous)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-snapshot-file", Aliases: []string{"cache_snapshot_file"}, EnvVars: []string{"NTFY_CACHE_SNAPSHOT_FILE"}, Usage: "keep the message cache in memory, and periodically write it to this file (cannot be used with cache-file)"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "cache-snapshot-interval", Aliases: []string{"cache_snapshot_interval"}, EnvVars: []string{"NTFY_CACHE_SNAPSHOT_INTERVAL"}, Value: server.DefaultCacheSnapshotInterval, Usage: "interval in which the in-memory message cache is written to the cache-snapshot-file"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-max-size", Aliases: []string{"cache_max_size"}, EnvVars: []string{"NTFY_CACHE_MAX_SIZE"}, Usage: "maximum size of the message cache; the oldest messages are deleted if exceeded (e.g. 1G, if zero, the size is unlimited)"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "cache-max-messages-per-topic", Aliases: []string{"cache_max_messages_per_topic"}, EnvVars: []string{"NTFY_CACHE_MAX_MESSAGES_PER_TOPIC"}, Usage: "maximum number of cached messages per topic; the oldest messages are deleted if exceeded (if zero, the number is unlimited)"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "cache-vacuum-interval", Aliases: []string{"cache_vacuum_interval"}, EnvVars: []string{"NTFY_CACHE_VACUUM_INTERVAL"}, Usage: "interval in which the message cache is vacuumed to reclaim disk space (if zero, the cache is never vacuumed)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-compress-threshold", Aliases: []string{"cache_compress_threshold"}, EnvVars: []string{"NTFY_CACHE_COMPRESS_THRESHOLD"}, Usage: "compress cached messages with zstd if they are at least this size (e.g. 1k, if zero, messages are not compressed)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-startup-queries", Aliases: []string{"cache_startup_queries"}, EnvVars: []string{"NTFY_CACHE_STARTUP_QUERIES"}, Usage: "queries run when the cache database is initialized"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-file", Aliases: []string{"auth_file", "H"}, EnvVars: []string{"NTFY_AUTH_FILE"}, Usage: "auth database file used for access control"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-startup-queries", Aliases: []string{"auth_startup_queries"}, EnvVars: []string{"NTFY_AUTH_STARTUP_QUERIES"}, Usage: "queries run when the auth database is initialized"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-default-access", Aliases: []string{"auth_default_access", "p"}, EnvVars: []string{"NTFY_AUTH_DEFAULT_ACCESS"}, Value: "read-write", Usage: "default permissions if no matching entries in the auth database are found"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "auth-users", Aliases: []string{"auth_users"}, EnvVars: []string{"NTFY_AUTH_USERS"}, Usage: "pre-provisioned users, as username:bcrypt-hash:role[:tier]"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "auth-access", Aliases: []string{"auth_access"}, EnvVars: []string{"NTFY_AUTH_ACCESS"}, Usage: "pre-provisioned access control entries, as username:topic-pattern:permission"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "auth-tokens", Aliases: []string{"auth_tokens"}, EnvVars: []string{"NTFY_AUTH_TOKENS"}, Usage: "pre-provisioned access tokens, as username:token-hash[:label]"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "auth-tiers", Aliases: []string{"auth_tiers"}, EnvVars: []string{"NTFY_AUTH_TIERS"}, Usage: "pre-provisioned tiers, as code[:key=value;key=value;...]"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-replicate-from", Aliases: []string{"auth_replicate_from"}, EnvVars: []string{"NTFY_AUTH_REPLICATE_FROM"}, Usage: "base URL of the primary server to replicate users, access control entries and tokens from (standby mode)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-replicate-token", Aliases: []string{"auth_replicate_token"}, EnvVars: []string{"NTFY_AUTH_REPLICATE_TOKEN"}, Usage: "access token of an admin user on the primary server"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "auth-replicate-interval", Aliases: []string{"auth_replicate_interval"}, EnvVars: []string{"NTFY_AUTH_REPLICATE_INTERVAL"}, Value: server.DefaultAuthReplicateInterval, Usage: "interval in which the primary server is polled for changes"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-provision-dir", Aliases: []string{"auth_provision_dir"}, EnvVars: []string{"NTFY_AUTH_PROVISION_DIR"}, Usage: "directory with additional YAML files defining auth-users, auth-access, auth-tokens and auth-tiers"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "scim-token", Aliases: []string{"scim_token"}, EnvVars: []string{"NTFY_SCIM_TOKEN"}, Usage: "bearer token for the SCIM 2.0 user provisioning API (/scim/v2); SCIM is disabled if not set"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "account-webhook-url", Aliases: []string{"account_webhook_url"}, EnvVars: []string{"NTFY_ACCOUNT_WEBHOOK_URL"}, Usage: "URL(s) to send account lifecycle events (signup, deletion, tier change, reservation) to"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "account-webhook-secret", Aliases: []string{"account_webhook_secret"}, EnvVars: []string{"NTFY_ACCOUNT_WEBHOOK_SECRET"}, Usage: "secret used to sign account webhook payloads (HMAC-SHA256)"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "account-webhook-events", Aliases: []string{"account_webhook_events"}, EnvVars: []string{"NTFY_ACCOUNT_WEBHOOK_EVENTS"}, Usage: "account lifecycle events to send to the webhook URL(s); all events if not set"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "publish-url-secret", Aliases: []string{"publish_url_secret"}, EnvVars: []string{"NTFY_PUBLISH_URL_SECRET"}, Usage: "secret used to sign publish URLs (HMAC-SHA256); signed publish URLs are disabled if not set"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "webhook-signatures", Aliases: []string{"webhook_signatures"}, EnvVars: []string{"NTFY_WEBHOOK_SIGNATURES"}, Usage: "topics that only accept requests signed by a webhook provider (github, stripe, slack), as topic:provider:secret"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "forward-rules", Aliases: []string{"forward_rules"}, EnvVars: []string{"NTFY_FORWARD_RULES"}, Usage: "copy matching messages from one topic to another, as topic:target[;priority=...;tags=...;set-priority=...;add-tags=...]"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "showcase-topics", Aliases: []string{"showcase_topics"}, EnvVars: []string{"NTFY_SHOWCASE_TOPICS"}, Usage: "topics (or topic prefixes, e.g. status-*) whose newest messages can be read by anyone via /<topic>/showcase(.json)"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "showcase-messages-limit", Aliases: []string{"showcase_messages_limit"}, EnvVars: []string{"NTFY_SHOWCASE_MESSAGES_LIMIT"}, Value: server.DefaultShowcaseMessagesLimit, Usage: "number of messages shown on the showcase of a topic"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "message-size-limit", Aliases: []string{"message_size_limit"}, EnvVars: []string{"NTFY_MESSAGE_SIZE_LIMIT"}, DefaultText: "4K", Usage: "max size of a message body (e.g. 4K, 64K); larger bodies are stored as attachments; may be overridden per tier"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "account-body-size-limit", Aliases: []string{"account_body_size_limit"}, EnvVars: []string{"NTFY_ACCOUNT_BODY_SIZE_LIMIT"}, DefaultText: "16K", Usage: "max size of JSON request bodies of the account and other API endpoints (e.g. 16K, 64K)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-cache-dir", Aliases: []string{"attachment_cache_dir"}, EnvVars: []string{"NTFY_ATTACHMENT_CACHE_DIR"}, Usage: "cache directory for attached files"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-total-size-limit", Aliases: []string{"attachment_total_size_limit", "A"}, EnvVars: []string{"NTFY_ATTACHMENT_TOTAL_SIZE_LIMIT"}, DefaultText: "5G", Usage: "limit of the on-disk attachment cache"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-file-size-limit", Aliases: []string{"attachment_file_size_limit", "Y"}, EnvVars: []string{"NTFY_ATTACHMENT_FILE_SIZE_LIMIT"}, DefaultText: "15M", Usage: "per-file attachment size limit (e.g. 300k, 2M, 100M)"}),
 	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "attachment-expiry-duration", Aliases: []string{"attachment_expiry_duration", "X"}, EnvVars: []string{"NTFY_ATTACHMENT_EXPIRY_DURATION"}, Value: server.DefaultAttachmentExpiryDuration, DefaultText: "3h", Usage: "duration after which uploaded attachments will be deleted (e.g. 3h, 20h)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-base-url", Aliases: []string{"attachment_base_url"}, EnvVars: []string{"NTFY_ATTACHMENT_BASE_URL"}, Usage: "separate base URL (ideally on a different domain) for attachment downloads, defaults to base-url"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "attachment-strip-html", Aliases: []string{"attachment_strip_html"}, EnvVars: []string{"NTFY_ATTACHMENT_STRIP_HTML"}, Value: false, Usage: "serve HTML, SVG and XML attachments as plain text"}),
 	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "keepalive-interval", Aliases: []string{"keepalive_interval", "k"}, EnvVars: []string{"NTFY_KEEPALIVE_INTERVAL"}, Value: server.DefaultKeepaliveInterval, Usage: "interval of keepalive messages"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "keepalive-interval-min", Aliases: []string{"keepalive_interval_min"}, EnvVars: []string{"NTFY_KEEPALIVE_INTERVAL_MIN"}, Value: server.DefaultKeepaliveIntervalMin, Usage: "lowest keepalive interval clients may request via the keepalive parameter"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "keepalive-interval-max", Aliases: []string{"keepalive_interval_max"}, EnvVars: []string{"NTFY_KEEPALIVE_INTERVAL_MAX"}, Value: server.DefaultKeepaliveIntervalMax, Usage: "highest keepalive interval clients may request via the keepalive parameter"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "sse-retry-max", Aliases: []string{"sse_retry_max"}, EnvVars: []string{"NTFY_SSE_RETRY_MAX"}, Value: server.DefaultSSERetryMax, Usage: "highest SSE reconnection time clients may request via the retry parameter"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "subscriber-backlog-limit", Aliases: []string{"subscriber_backlog_limit"}, EnvVars: []string{"NTFY_SUBSCRIBER_BACKLOG_LIMIT"}, Value: server.DefaultSubscriberBacklogLimit, Usage: "max number of messages waiting to be written to a subscriber connection before it is considered slow (0 = unlimited)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "subscriber-slow-action", Aliases: []string{"subscriber_slow_action"}, EnvVars: []string{"NTFY_SUBSCRIBER_SLOW_ACTION"}, Value: server.SubscriberSlowActionDrop, Usage: "what to do with slow subscribers: 'drop' closes the connection, 'poll' skips messages and sends a poll request"}),
 	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "manager-interval", Aliases: []string{"manager_interval", "m"}, EnvVars: []string{"NTFY_MANAGER_INTERVAL"}, Value: server.DefaultManagerInterval, Usage: "interval of for message pruning and stats printing"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "shutdown-timeout", Aliases: []string{"shutdown_timeout"}, EnvVars: []string{"NTFY_SHUTDOWN_TIMEOUT"}, Value: server.DefaultShutdownTimeout, Usage: "max. time to drain connections and queues when stopping the server"}),
 	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "disallowed-topics", Aliases: []string{"disallowed_topics"}, EnvVars: []string{"NTFY_DISALLOWED_TOPICS"}, Usage: "topics that are not allowed to be used"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "topic-inactivity-expiry-duration", Aliases: []string{"topic_inactivity_expiry_duration"}, EnvVars: []string{"NTFY_TOPIC_INACTIVITY_EXPIRY_DURATION"}, Usage: "release reservations and purge cached messages of topics that were not used for this long (e.g. 180d); disabled if not set"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "topic-inactivity-warning-duration", Aliases: []string{"topic_inactivity_warning_duration"}, EnvVars: []string{"NTFY_TOPIC_INACTIVITY_WARNING_DURATION"}, DefaultText: "14d", Usage: "warn reservation owners this long before a reservation is released due to inactivity (e.g. 14d)"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "tag-icons", Aliases: []string{"tag_icons"}, EnvVars: []string{"NTFY_TAG_ICONS"}, Usage: "custom icons for message tags, as tag=emoji, tag=U+codepoint or tag=/path/to/icon.png"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "template-dir", Aliases: []string{"template_dir"}, EnvVars: []string{"NTFY_TEMPLATE_DIR"}, Usage: "directory of named message templates (<name>.yml), used via the X-Template header"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "template-inline-anonymous", Aliases: []string{"template_inline_anonymous"}, EnvVars: []string{"NTFY_TEMPLATE_INLINE_ANONYMOUS"}, Value: false, Usage: "allows anonymous publishers to use inline templates (X-Template: yes)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-root", Aliases: []string{"web_root"}, EnvVars: []string{"NTFY_WEB_ROOT"}, Value: "/", Usage: "sets root of the web app (e.g. /, or /app), or disables it (disable)"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-signup", Aliases: []string{"enable_signup"}, EnvVars: []string{"NTFY_ENABLE_SIGNUP"}, Value: false, Usage: "allows users to sign up via the web app, or API"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-login", Aliases: []string{"enable_login"}, EnvVars: []string{"NTFY_ENABLE_LOGIN"}, Value: false, Usage: "allows users to log in via the web app, or API"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-reservations", Aliases: []string{"enable_reservations"}, EnvVars: []string{"NTFY_ENABLE_RESERVATIONS"}, Value: false, Usage: "allows users to reserve topics (if their tier allows it)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "upstream-base-url", Aliases: []string{"upstream_base_url"}, EnvVars: []string{"NTFY_UPSTREAM_BASE_URL"}, Value: "", Usage: "forward poll request to an upstream server, this is needed for iOS push notifications for self-hosted servers"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "upstream-access-token", Aliases: []string{"upstream_access_token"}, EnvVars: []string{"NTFY_UPSTREAM_ACCESS_TOKEN"}, Value: "", Usage: "access token to use for the upstream server; needed only if upstream rate limits are exceeded or upstream server requires auth"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "upstreams", EnvVars: []string{"NTFY_UPSTREAMS"}, Usage: "additional upstream servers to forward poll requests to, as base-url[;topic=...;token=...]"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "push-fallback", Aliases: []string{"push_fallback"}, EnvVars: []string{"NTFY_PUSH_FALLBACK"}, Usage: "push channels (upstream, firebase, webpush) to try one after another until one works (per subscriber type), instead of using all of them; firebase must be listed after upstream"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-addr", Aliases: []string{"smtp_sender_addr"}, EnvVars: []string{"NTFY_SMTP_SENDER_ADDR"}, Usage: "SMTP server address (host:port) for outgoing emails"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-user", Aliases: []string{"smtp_sender_user"}, EnvVars: []string{"NTFY_SMTP_SENDER_USER"}, Usage: "SMTP user (if e-mail sending is enabled)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-pass", Aliases: []string{"smtp_sender_pass"}, EnvVars: []string{"NTFY_SMTP_SENDER_PASS"}, Usage: "SMTP password (if e-mail sending is enabled)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-from", Aliases: []string{"smtp_sender_from"}, EnvVars: []string{"NTFY_SMTP_SENDER_FROM"}, Usage: "SMTP sender address (if e-mail sending is enabled)"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "smtp-sender-batch-window", Aliases: []string{"smtp_sender_batch_window"}, EnvVars: []string{"NTFY_SMTP_SENDER_BATCH_WINDOW"}, Value: 0, Usage: "coalesce e-mails to the same recipient within this window into a summary e-mail (0 = disabled)"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "smtp-sender-batch-max", Aliases: []string{"smtp_sender_batch_max"}, EnvVars: []string{"NTFY_SMTP_SENDER_BATCH_MAX"}, Value: server.DefaultSMTPSenderBatchMax, Usage: "max number of messages listed in a summary e-mail"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-provider", Aliases: []string{"smtp_sender_provider"}, EnvVars: []string{"NTFY_SMTP_SENDER_PROVIDER"}, Value: server.SMTPSenderProviderSMTP, Usage: "send e-mails via SMTP or via the API of an e-mail provider (smtp, sendgrid, mailgun or ses)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-api-key", Aliases: []string{"smtp_sender_api_key"}, EnvVars: []string{"NTFY_SMTP_SENDER_API_KEY"}, Usage: "SendGrid or Mailgun API key, or Amazon SES access key ID"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-api-secret", Aliases: []string{"smtp_sender_api_secret"}, EnvVars: []string{"NTFY_SMTP_SENDER_API_SECRET"}, Usage: "Amazon SES secret access key"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-api-region", Aliases: []string{"smtp_sender_api_region"}, EnvVars: []string{"NTFY_SMTP_SENDER_API_REGION"}, Usage: "Amazon SES region (e.g. us-east-1), or Mailgun region (us or eu)"}),
+	altsrc.NewFloat64Flag(&cli.Float64Flag{Name: "smtp-sender-rate-limit", Aliases: []string{"smtp_sender_rate_limit"}, EnvVars: []string{"NTFY_SMTP_SENDER_RATE_LIMIT"}, Value: 0, Usage: "max number of e-mails sent per second (0 = provider default)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-webhook-key", Aliases: []string{"smtp_sender_webhook_key"}, EnvVars: []string{"NTFY_SMTP_SENDER_WEBHOOK_KEY"}, Usage: "key to verify bounce webhook requests of the e-mail provider"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-listen", Aliases: []string{"smtp_server_listen"}, EnvVars: []string{"NTFY_SMTP_SERVER_LISTEN"}, Usage: "SMTP server address (ip:port) for incoming emails, e.g. :25"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-domain", Aliases: []string{"smtp_server_domain"}, EnvVars: []string{"NTFY_SMTP_SERVER_DOMAIN"}, Usage: "SMTP domain for incoming e-mail, e.g. ntfy.sh"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-addr-prefix", Aliases: []string{"smtp_server_addr_prefix"}, EnvVars: []string{"NTFY_SMTP_SERVER_ADDR_PREFIX"}, Usage: "SMTP email address prefix for topics to prevent spam (e.g. 'ntfy-')"}),
@@ -88,9 +178,25 @@ var flagsServe = append(
 	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "visitor-email-limit-replenish", Aliases: []string{"visitor_email_limit_replenish"}, EnvVars: []string{"NTFY_VISITOR_EMAIL_LIMIT_REPLENISH"}, Value: server.DefaultVisitorEmailLimitReplenish, Usage: "interval at which burst limit is replenished (one per x)"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "visitor-subscriber-rate-limiting", Aliases: []string{"visitor_subscriber_rate_limiting"}, EnvVars: []string{"NTFY_VISITOR_SUBSCRIBER_RATE_LIMITING"}, Value: false, Usage: "enables subscriber-based rate limiting"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "behind-proxy", Aliases: []string{"behind_proxy", "P"}, EnvVars: []string{"NTFY_BEHIND_PROXY"}, Value: false, Usage: "if set, use X-Forwarded-For header to determine visitor IP address (for rate limiting)"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "access-control-allow-origins", Aliases: []string{"access_control_allow_origins"}, EnvVars: []string{"NTFY_ACCESS_CONTROL_ALLOW_ORIGINS"}, Value: cli.NewStringSlice("*"), Usage: "origins allowed to make cross-origin (CORS) requests, e.g. https://example.com; * allows all origins"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "access-control-allow-methods", Aliases: []string{"access_control_allow_methods"}, EnvVars: []string{"NTFY_ACCESS_CONTROL_ALLOW_METHODS"}, Value: cli.NewStringSlice("GET", "PUT", "POST", "PATCH", "DELETE"), Usage: "HTTP methods allowed in cross-origin (CORS) requests"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "access-control-allow-credentials", Aliases: []string{"access_control_allow_credentials"}, EnvVars: []string{"NTFY_ACCESS_CONTROL_ALLOW_CREDENTIALS"}, Value: false, Usage: "allow cross-origin (CORS) requests with cookies; requires access-control-allow-origins to be restricted"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "csrf-protection", Aliases: []string{"csrf_protection"}, EnvVars: []string{"NTFY_CSRF_PROTECTION"}, Value: false, Usage: "require a CSRF token for state-changing requests that carry cookies (e.g. behind an SSO proxy)"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "ip-ban-auth-failures", Aliases: []string{"ip_ban_auth_failures"}, EnvVars: []string{"NTFY_IP_BAN_AUTH_FAILURES"}, Value: 0, Usage: "number of auth failures within ip-ban-window after which an IP address is banned (0 = disabled)"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "ip-ban-rate-limit-violations", Aliases: []string{"ip_ban_rate_limit_violations"}, EnvVars: []string{"NTFY_IP_BAN_RATE_LIMIT_VIOLATIONS"}, Value: 0, Usage: "number of rate-limited requests within ip-ban-window after which an IP address is banned (0 = disabled)"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "ip-ban-window", Aliases: []string{"ip_ban_window"}, EnvVars: []string{"NTFY_IP_BAN_WINDOW"}, Value: server.DefaultIPBanWindow, Usage: "time window in which auth failures and rate limit violations are counted"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "ip-ban-duration", Aliases: []string{"ip_ban_duration"}, EnvVars: []string{"NTFY_IP_BAN_DURATION"}, Value: server.DefaultIPBanDuration, Usage: "duration of automatic IP bans"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-failure-log-file", Aliases: []string{"auth_failure_log_file"}, EnvVars: []string{"NTFY_AUTH_FAILURE_LOG_FILE"}, Value: "", Usage: "file to log authentication failures to, in a format suitable for fail2ban"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "access-log", Aliases: []string{"access_log"}, EnvVars: []string{"NTFY_ACCESS_LOG"}, Value: "", Usage: "if set, HTTP requests are logged to this destination (stdout, syslog or a file name)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "access-log-format", Aliases: []string{"access_log_format"}, EnvVars: []string{"NTFY_ACCESS_LOG_FORMAT"}, Value: server.DefaultAccessLogFormat, Usage: "format of the access log, either \"json\" or \"combined\""}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "access-log-routes", Aliases: []string{"access_log_routes"}, EnvVars: []string{"NTFY_ACCESS_LOG_ROUTES"}, Usage: "routes to include in the access log (publish, subscribe, poll, account, webpush, other), default is all"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "stripe-secret-key", Aliases: []string{"stripe_secret_key"}, EnvVars: []string{"NTFY_STRIPE_SECRET_KEY"}, Value: "", Usage: "key used for the Stripe API communication, this enables payments"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "stripe-webhook-key", Aliases: []string{"stripe_webhook_key"}, EnvVars: []string{"NTFY_STRIPE_WEBHOOK_KEY"}, Value: "", Usage: "key required to validate the authenticity of incoming webhooks from Stripe"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "paddle-api-key", Aliases: []string{"paddle_api_key"}, EnvVars: []string{"NTFY_PADDLE_API_KEY"}, Value: "", Usage: "key used for the Paddle API communication, this enables payments via Paddle"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "paddle-webhook-key", Aliases: []string{"paddle_webhook_key"}, EnvVars: []string{"NTFY_PADDLE_WEBHOOK_KEY"}, Value: "", Usage: "secret key required to validate the authenticity of incoming webhooks from Paddle"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "paddle-environment", Aliases: []string{"paddle_environment"}, EnvVars: []string{"NTFY_PADDLE_ENVIRONMENT"}, Value: server.DefaultPaddleEnvironment, Usage: "Paddle environment, either \"production\" or \"sandbox\""}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "billing-contact", Aliases: []string{"billing_contact"}, EnvVars: []string{"NTFY_BILLING_CONTACT"}, Value: "", Usage: "e-mail or website to display in upgrade dialog (only if payments are enabled)"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "readiness-check-external", Aliases: []string{"readiness_check_external"}, EnvVars: []string{"NTFY_READINESS_CHECK_EXTERNAL"}, Value: false, Usage: "if set, /v1/health/ready also checks if Firebase and the SMTP server are reachable"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-metrics", Aliases: []string{"enable_metrics"}, EnvVars: []string{"NTFY_ENABLE_METRICS"}, Value: false, Usage: "if set, Prometheus metrics are exposed via the /metrics endpoint"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "metrics-listen-http", Aliases: []string{"metrics_listen_http"}, EnvVars: []string{"NTFY_METRICS_LISTEN_HTTP"}, Usage: "ip:port used to expose the metrics endpoint (implicitly enables metrics)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "profile-listen-http", Aliases: []string{"profile_listen_http"}, EnvVars: []string{"NTFY_PROFILE_LISTEN_HTTP"}, Usage: "ip:port used to expose the profiling endpoints (implicitly enables profiling)"}),
@@ -99,6 +205,9 @@ var flagsServe = append(
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-push-file", Aliases: []string{"web_push_file"}, EnvVars: []string{"NTFY_WEB_PUSH_FILE"}, Usage: "file used to store web push subscriptions"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-push-email-address", Aliases: []string{"web_push_email_address"}, EnvVars: []string{"NTFY_WEB_PUSH_EMAIL_ADDRESS"}, Usage: "e-mail address of sender, required to use browser push services"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-push-startup-queries", Aliases: []string{"web_push_startup_queries"}, EnvVars: []string{"NTFY_WEB_PUSH_STARTUP_QUERIES"}, Usage: "queries run when the web push database is initialized"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "web-push-workers", Aliases: []string{"web_push_workers"}, EnvVars: []string{"NTFY_WEB_PUSH_WORKERS"}, Value: server.DefaultWebPushWorkers, Usage: "number of concurrent requests sending messages to browser push services"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-push-keepalive-strategy", Aliases: []string{"web_push_keepalive_strategy"}, EnvVars: []string{"NTFY_WEB_PUSH_KEEPALIVE_STRATEGY"}, Value: server.DefaultWebPushKeepaliveStrategy, Usage: "how to keep inactive web push subscriptions alive: 'warning' (send a warning notification) or 'ping' (send a silent ping)"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "web-push-user-subscription-limit", Aliases: []string{"web_push_user_subscription_limit"}, EnvVars: []string{"NTFY_WEB_PUSH_USER_SUBSCRIPTION_LIMIT"}, Value: server.DefaultWebPushUserSubscriptionLimit, Usage: "max number of web push endpoints (browsers) per user, 0 for no limit"}),
 )
 
 var cmdServe = &cli.Command{
@@ -115,8 +224,9 @@ The command will load the configuration from /etc/ntfy/server.yml. Config option
 be overridden using the command line options.
 
 Examples:
-  ntfy serve                      # Starts server in the foreground (on port 80)
-  ntfy serve --listen-http :8080  # Starts server with alternate port`,
+  ntfy serve                            # Starts server in the foreground (on port 80)
+  ntfy serve --listen-http :8080        # Starts server with alternate port
+  ntfy serve --dev --listen-http :8080  # Starts server in development mode, without touching disk`,
 }
 
 func execServe(c *cli.Context) error {
@@ -131,39 +241,95 @@ func execServe(c *cli.Context) error {
 	listenHTTPS := c.String("listen-https")
 	listenUnix := c.String("listen-unix")
 	listenUnixMode := c.Int("listen-unix-mode")
+	listenReusePort := c.Bool("listen-reuse-port")
 	keyFile := c.String("key-file")
 	certFile := c.String("cert-file")
 	firebaseKeyFile := c.String("firebase-key-file")
+	firebaseWorkers := c.Int("firebase-workers")
+	firebaseQueueSize := c.Int("firebase-queue-size")
+	firebaseCriticalAlertTopics := c.StringSlice("firebase-critical-alert-topics")
+	firebaseAndroidChannelsRaw := c.StringSlice("firebase-android-channels")
 	webPushPrivateKey := c.String("web-push-private-key")
 	webPushPublicKey := c.String("web-push-public-key")
 	webPushFile := c.String("web-push-file")
 	webPushEmailAddress := c.String("web-push-email-address")
 	webPushStartupQueries := c.String("web-push-startup-queries")
+	webPushWorkers := c.Int("web-push-workers")
+	webPushKeepaliveStrategy := c.String("web-push-keepalive-strategy")
+	webPushUserSubscriptionLimit := c.Int("web-push-user-subscription-limit")
 	cacheFile := c.String("cache-file")
 	cacheDuration := c.Duration("cache-duration")
 	cacheStartupQueries := c.String("cache-startup-queries")
 	cacheBatchSize := c.Int("cache-batch-size")
 	cacheBatchTimeout := c.Duration("cache-batch-timeout")
+	cacheSnapshotFile := c.String("cache-snapshot-file")
+	cacheSnapshotInterval := c.Duration("cache-snapshot-interval")
+	cacheMaxSizeStr := c.String("cache-max-size")
+	cacheMaxMessagesPerTopic := c.Int("cache-max-messages-per-topic")
+	cacheVacuumInterval := c.Duration("cache-vacuum-interval")
+	cacheCompressThresholdStr := c.String("cache-compress-threshold")
 	authFile := c.String("auth-file")
 	authStartupQueries := c.String("auth-startup-queries")
 	authDefaultAccess := c.String("auth-default-access")
+	authUsersRaw := c.StringSlice("auth-users")
+	authAccessRaw := c.StringSlice("auth-access")
+	authTokensRaw := c.StringSlice("auth-tokens")
+	authTiersRaw := c.StringSlice("auth-tiers")
+	authProvisionDir := c.String("auth-provision-dir")
+	authReplicateFrom := c.String("auth-replicate-from")
+	authReplicateToken := c.String("auth-replicate-token")
+	authReplicateInterval := c.Duration("auth-replicate-interval")
+	scimToken := c.String("scim-token")
+	accountWebhookURLs := c.StringSlice("account-webhook-url")
+	accountWebhookSecret := c.String("account-webhook-secret")
+	accountWebhookEvents := c.StringSlice("account-webhook-events")
+	publishURLSecret := c.String("publish-url-secret")
+	webhookSignaturesRaw := c.StringSlice("webhook-signatures")
+	forwardRulesRaw := c.StringSlice("forward-rules")
+	showcaseTopics := c.StringSlice("showcase-topics")
+	showcaseMessagesLimit := c.Int("showcase-messages-limit")
+	messageSizeLimitStr := c.String("message-size-limit")
+	accountBodySizeLimitStr := c.String("account-body-size-limit")
 	attachmentCacheDir := c.String("attachment-cache-dir")
 	attachmentTotalSizeLimitStr := c.String("attachment-total-size-limit")
 	attachmentFileSizeLimitStr := c.String("attachment-file-size-limit")
 	attachmentExpiryDuration := c.Duration("attachment-expiry-duration")
+	attachmentBaseURL := c.String("attachment-base-url")
+	attachmentStripHTML := c.Bool("attachment-strip-html")
 	keepaliveInterval := c.Duration("keepalive-interval")
+	keepaliveIntervalMin := c.Duration("keepalive-interval-min")
+	keepaliveIntervalMax := c.Duration("keepalive-interval-max")
+	sseRetryMax := c.Duration("sse-retry-max")
+	subscriberBacklogLimit := c.Int("subscriber-backlog-limit")
+	subscriberSlowAction := c.String("subscriber-slow-action")
 	managerInterval := c.Duration("manager-interval")
+	shutdownTimeout := c.Duration("shutdown-timeout")
 	disallowedTopics := c.StringSlice("disallowed-topics")
+	topicInactivityExpiryDurationStr := c.String("topic-inactivity-expiry-duration")
+	topicInactivityWarningDurationStr := c.String("topic-inactivity-warning-duration")
+	tagIconsRaw := c.StringSlice("tag-icons")
+	templateDir := c.String("template-dir")
+	templateInlineAnonymous := c.Bool("template-inline-anonymous")
 	webRoot := c.String("web-root")
 	enableSignup := c.Bool("enable-signup")
 	enableLogin := c.Bool("enable-login")
 	enableReservations := c.Bool("enable-reservations")
 	upstreamBaseURL := c.String("upstream-base-url")
 	upstreamAccessToken := c.String("upstream-access-token")
+	upstreamsRaw := c.StringSlice("upstreams")
+	pushFallback := c.StringSlice("push-fallback")
 	smtpSenderAddr := c.String("smtp-sender-addr")
 	smtpSenderUser := c.String("smtp-sender-user")
 	smtpSenderPass := c.String("smtp-sender-pass")
 	smtpSenderFrom := c.String("smtp-sender-from")
+	smtpSenderBatchWindow := c.Duration("smtp-sender-batch-window")
+	smtpSenderBatchMax := c.Int("smtp-sender-batch-max")
+	smtpSenderProvider := c.String("smtp-sender-provider")
+	smtpSenderAPIKey := c.String("smtp-sender-api-key")
+	smtpSenderAPISecret := c.String("smtp-sender-api-secret")
+	smtpSenderAPIRegion := c.String("smtp-sender-api-region")
+	smtpSenderRateLimit := c.Float64("smtp-sender-rate-limit")
+	smtpSenderWebhookKey := c.String("smtp-sender-webhook-key")
 	smtpServerListen := c.String("smtp-server-listen")
 	smtpServerDomain := c.String("smtp-server-domain")
 	smtpServerAddrPrefix := c.String("smtp-server-addr-prefix")
@@ -183,32 +349,151 @@ func execServe(c *cli.Context) error {
 	visitorEmailLimitBurst := c.Int("visitor-email-limit-burst")
 	visitorEmailLimitReplenish := c.Duration("visitor-email-limit-replenish")
 	behindProxy := c.Bool("behind-proxy")
+	accessControlAllowOrigins := c.StringSlice("access-control-allow-origins")
+	accessControlAllowMethods := c.StringSlice("access-control-allow-methods")
+	accessControlAllowCredentials := c.Bool("access-control-allow-credentials")
+	csrfProtection := c.Bool("csrf-protection")
+	ipBanAuthFailures := c.Int("ip-ban-auth-failures")
+	ipBanRateLimitViolations := c.Int("ip-ban-rate-limit-violations")
+	ipBanWindow := c.Duration("ip-ban-window")
+	ipBanDuration := c.Duration("ip-ban-duration")
+	authFailureLogFile := c.String("auth-failure-log-file")
+	accessLog := c.String("access-log")
+	accessLogFormat := c.String("access-log-format")
+	accessLogRoutes := c.StringSlice("access-log-routes")
 	stripeSecretKey := c.String("stripe-secret-key")
 	stripeWebhookKey := c.String("stripe-webhook-key")
+	paddleAPIKey := c.String("paddle-api-key")
+	paddleWebhookKey := c.String("paddle-webhook-key")
+	paddleEnvironment := c.String("paddle-environment")
 	billingContact := c.String("billing-contact")
 	metricsListenHTTP := c.String("metrics-listen-http")
 	enableMetrics := c.Bool("enable-metrics") || metricsListenHTTP != ""
+	readinessCheckExternal := c.Bool("readiness-check-external")
 	profileListenHTTP := c.String("profile-listen-http")
+	dev := c.Bool("dev")
+
+	// Development mode: keep the message cache, user database and web push subscriptions in memory,
+	// and relax rate limits, unless they are explicitly set
+	if dev {
+		cacheFile, cacheSnapshotFile = "", ""
+		authFile = devMemoryFilename("auth")
+		webPushFile = devMemoryFilename("webpush")
+		if baseURL == "" {
+			baseURL = devBaseURL(listenHTTP)
+		}
+		if webPushPublicKey == "" && baseURL != "" {
+			privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+			if err != nil {
+				return err
+			}
+			webPushPrivateKey, webPushPublicKey = privateKey, publicKey
+		}
+		if webPushEmailAddress == "" {
+			webPushEmailAddress = devWebPushEmailAddress
+		}
+		if !c.IsSet("visitor-request-limit-burst") {
+			visitorRequestLimitBurst = devVisitorRequestLimitBurst
+		}
+		if !c.IsSet("visitor-request-limit-replenish") {
+			visitorRequestLimitReplenish = devVisitorRequestLimitReplenish
+		}
+		if !c.IsSet("visitor-subscription-limit") {
+			visitorSubscriptionLimit = devVisitorSubscriptionLimit
+		}
+		if !c.IsSet("visitor-email-limit-burst") {
+			visitorEmailLimitBurst = devVisitorEmailLimitBurst
+		}
+		if !c.IsSet("visitor-email-limit-replenish") {
+			visitorEmailLimitReplenish = devVisitorEmailLimitReplenish
+		}
+		if !c.IsSet("log-level") && !c.Bool("trace") {
+			log.SetLevel(log.DebugLevel)
+		}
+		log.Warn("Running in development mode: all state is kept in memory and lost on exit, and rate limits are relaxed. Do not use this in production!")
+	}
 
 	// Check values
 	if firebaseKeyFile != "" && !util.FileExists(firebaseKeyFile) {
 		return errors.New("if set, FCM key file must exist")
+	} else if firebaseWorkers < 1 {
+		return errors.New("firebase-workers must be at least 1")
+	} else if firebaseQueueSize < 1 {
+		return errors.New("firebase-queue-size must be at least 1")
+	} else if showcaseMessagesLimit < 1 || showcaseMessagesLimit > server.ShowcaseMessagesLimitMax {
+		return fmt.Errorf("showcase-messages-limit must be between 1 and %d", server.ShowcaseMessagesLimitMax)
+	} else if len(firebaseCriticalAlertTopics) > 0 && firebaseKeyFile == "" {
+		return errors.New("if firebase-critical-alert-topics is set, firebase-key-file must be set as well")
+	} else if len(firebaseAndroidChannelsRaw) > 0 && firebaseKeyFile == "" {
+		return errors.New("if firebase-android-channels is set, firebase-key-file must be set as well")
+	} else if len(accessControlAllowOrigins) == 0 {
+		return errors.New("access-control-allow-origins must not be empty, use * to allow all origins")
+	} else if origin := invalidAccessControlOrigin(accessControlAllowOrigins); origin != "" {
+		return fmt.Errorf("invalid access-control-allow-origins entry %s, expected format scheme://host[:port], or *", origin)
+	} else if accessControlAllowCredentials && util.Contains(accessControlAllowOrigins, "*") {
+		return errors.New("if access-control-allow-credentials is set, access-control-allow-origins must be restricted, and cannot be *")
+	} else if ipBanAuthFailures < 0 || ipBanRateLimitViolations < 0 {
+		return errors.New("ip-ban-auth-failures and ip-ban-rate-limit-violations cannot be negative")
+	} else if (ipBanAuthFailures > 0 || ipBanRateLimitViolations > 0) && (ipBanWindow <= 0 || ipBanDuration <= 0) {
+		return errors.New("if ip-ban-auth-failures or ip-ban-rate-limit-violations is set, ip-ban-window and ip-ban-duration must be positive")
 	} else if webPushPublicKey != "" && (webPushPrivateKey == "" || webPushFile == "" || webPushEmailAddress == "" || baseURL == "") {
 		return errors.New("if web push is enabled, web-push-private-key, web-push-public-key, web-push-file, web-push-email-address, and base-url should be set. run 'ntfy webpush keys' to generate keys")
+	} else if webPushWorkers < 1 {
+		return errors.New("web-push-workers must be at least 1")
+	} else if !util.Contains([]string{server.WebPushKeepaliveStrategyWarning, server.WebPushKeepaliveStrategyPing}, webPushKeepaliveStrategy) {
+		return errors.New("web-push-keepalive-strategy must be 'warning' or 'ping'")
+	} else if !util.Contains([]string{server.AccessLogFormatJSON, server.AccessLogFormatCombined}, accessLogFormat) {
+		return errors.New("access-log-format must be 'json' or 'combined'")
+	} else if webPushUserSubscriptionLimit < 0 {
+		return errors.New("web-push-user-subscription-limit cannot be negative")
 	} else if keepaliveInterval < 5*time.Second {
 		return errors.New("keepalive interval cannot be lower than five seconds")
+	} else if keepaliveIntervalMin < 5*time.Second {
+		return errors.New("keepalive-interval-min cannot be lower than five seconds")
+	} else if keepaliveIntervalMax < keepaliveIntervalMin {
+		return errors.New("keepalive-interval-max cannot be lower than keepalive-interval-min")
+	} else if sseRetryMax < time.Second {
+		return errors.New("sse-retry-max cannot be lower than one second")
+	} else if subscriberBacklogLimit < 0 {
+		return errors.New("subscriber-backlog-limit cannot be negative")
+	} else if !util.Contains([]string{server.SubscriberSlowActionDrop, server.SubscriberSlowActionPoll}, subscriberSlowAction) {
+		return errors.New("subscriber-slow-action must be 'drop' or 'poll'")
 	} else if managerInterval < 5*time.Second {
 		return errors.New("manager interval cannot be lower than five seconds")
+	} else if shutdownTimeout < 0 {
+		return errors.New("shutdown-timeout cannot be negative")
 	} else if cacheDuration > 0 && cacheDuration < managerInterval {
 		return errors.New("cache duration cannot be lower than manager interval")
+	} else if cacheFile != "" && cacheSnapshotFile != "" {
+		return errors.New("cache-file and cache-snapshot-file cannot be set at the same time")
+	} else if cacheSnapshotFile != "" && cacheSnapshotInterval < time.Second {
+		return errors.New("cache snapshot interval cannot be lower than one second")
+	} else if cacheMaxMessagesPerTopic < 0 {
+		return errors.New("cache max messages per topic cannot be negative")
+	} else if cacheVacuumInterval > 0 && cacheVacuumInterval < managerInterval {
+		return errors.New("cache vacuum interval cannot be lower than manager interval")
 	} else if keyFile != "" && !util.FileExists(keyFile) {
 		return errors.New("if set, key file must exist")
 	} else if certFile != "" && !util.FileExists(certFile) {
 		return errors.New("if set, certificate file must exist")
+	} else if templateDir != "" && !util.FileExists(templateDir) {
+		return errors.New("if set, template-dir must exist")
 	} else if listenHTTPS != "" && (keyFile == "" || certFile == "") {
 		return errors.New("if listen-https is set, both key-file and cert-file must be set")
 	} else if smtpSenderAddr != "" && (baseURL == "" || smtpSenderFrom == "") {
 		return errors.New("if smtp-sender-addr is set, base-url, and smtp-sender-from must also be set")
+	} else if smtpSenderBatchWindow > 0 && smtpSenderBatchMax <= 0 {
+		return errors.New("if smtp-sender-batch-window is set, smtp-sender-batch-max must be positive")
+	} else if !util.Contains([]string{server.SMTPSenderProviderSMTP, server.SMTPSenderProviderSendGrid, server.SMTPSenderProviderMailgun, server.SMTPSenderProviderSES}, smtpSenderProvider) {
+		return errors.New("if set, smtp-sender-provider must be one of smtp, sendgrid, mailgun or ses")
+	} else if smtpSenderProvider != server.SMTPSenderProviderSMTP && (smtpSenderAPIKey == "" || baseURL == "" || smtpSenderFrom == "") {
+		return errors.New("if smtp-sender-provider is sendgrid, mailgun or ses, smtp-sender-api-key, base-url, and smtp-sender-from must also be set")
+	} else if smtpSenderProvider == server.SMTPSenderProviderSES && (smtpSenderAPISecret == "" || smtpSenderAPIRegion == "") {
+		return errors.New("if smtp-sender-provider is ses, smtp-sender-api-secret and smtp-sender-api-region must also be set")
+	} else if smtpSenderProvider == server.SMTPSenderProviderSMTP && smtpSenderWebhookKey != "" {
+		return errors.New("smtp-sender-webhook-key requires smtp-sender-provider to be sendgrid, mailgun or ses")
+	} else if smtpSenderRateLimit < 0 {
+		return errors.New("if set, smtp-sender-rate-limit must not be negative")
 	} else if smtpServerListen != "" && smtpServerDomain == "" {
 		return errors.New("if smtp-server-listen is set, smtp-server-domain must also be set")
 	} else if attachmentCacheDir != "" && baseURL == "" {
@@ -217,6 +502,10 @@ func execServe(c *cli.Context) error {
 		return errors.New("if set, base-url must start with http:// or https://")
 	} else if baseURL != "" && strings.HasSuffix(baseURL, "/") {
 		return errors.New("if set, base-url must not end with a slash (/)")
+	} else if attachmentBaseURL != "" && !strings.HasPrefix(attachmentBaseURL, "http://") && !strings.HasPrefix(attachmentBaseURL, "https://") {
+		return errors.New("if set, attachment-base-url must start with http:// or https://")
+	} else if attachmentBaseURL != "" && strings.HasSuffix(attachmentBaseURL, "/") {
+		return errors.New("if set, attachment-base-url must not end with a slash (/)")
 	} else if upstreamBaseURL != "" && !strings.HasPrefix(upstreamBaseURL, "http://") && !strings.HasPrefix(upstreamBaseURL, "https://") {
 		return errors.New("if set, upstream-base-url must start with http:// or https://")
 	} else if upstreamBaseURL != "" && strings.HasSuffix(upstreamBaseURL, "/") {
@@ -225,12 +514,46 @@ func execServe(c *cli.Context) error {
 		return errors.New("if upstream-base-url is set, base-url must also be set")
 	} else if upstreamBaseURL != "" && baseURL != "" && baseURL == upstreamBaseURL {
 		return errors.New("base-url and upstream-base-url cannot be identical, you'll likely want to set upstream-base-url to https://ntfy.sh, see https://ntfy.sh/docs/config/#ios-instant-notifications")
-	} else if authFile == "" && (enableSignup || enableLogin || enableReservations || stripeSecretKey != "") {
-		return errors.New("cannot set enable-signup, enable-login, enable-reserve-topics, or stripe-secret-key if auth-file is not set")
+	} else if len(upstreamsRaw) > 0 && baseURL == "" {
+		return errors.New("if upstreams is set, base-url must also be set")
+	} else if !util.ContainsAll([]string{server.PushChannelFirebase, server.PushChannelWebPush, server.PushChannelUpstream}, pushFallback) {
+		return errors.New("if set, push-fallback must only contain firebase, webpush and upstream")
+	} else if pushFallbackFirebaseBeforeUpstream(pushFallback) {
+		return errors.New("push-fallback must not list firebase before upstream, since sending to Firebase always succeeds, even if nobody is subscribed, see https://ntfy.sh/docs/config/#push-fallback")
+	} else if authFile == "" && (enableSignup || enableLogin || enableReservations || stripeSecretKey != "" || paddleAPIKey != "") {
+		return errors.New("cannot set enable-signup, enable-login, enable-reserve-topics, stripe-secret-key or paddle-api-key if auth-file is not set")
+	} else if authFile == "" && (len(authUsersRaw) > 0 || len(authAccessRaw) > 0 || len(authTokensRaw) > 0 || len(authTiersRaw) > 0 || authProvisionDir != "") {
+		return errors.New("cannot set auth-users, auth-access, auth-tokens, auth-tiers or auth-provision-dir if auth-file is not set")
+	} else if authFile == "" && authReplicateFrom != "" {
+		return errors.New("cannot set auth-replicate-from if auth-file is not set")
+	} else if authReplicateFrom != "" && (!strings.HasPrefix(authReplicateFrom, "http://") && !strings.HasPrefix(authReplicateFrom, "https://")) {
+		return errors.New("if set, auth-replicate-from must start with http:// or https://")
+	} else if authReplicateFrom != "" && strings.HasSuffix(authReplicateFrom, "/") {
+		return errors.New("if set, auth-replicate-from must not end with a slash (/)")
+	} else if authReplicateFrom != "" && authReplicateToken == "" {
+		return errors.New("if auth-replicate-from is set, auth-replicate-token must also be set")
+	} else if authReplicateFrom != "" && authReplicateInterval <= 0 {
+		return errors.New("if auth-replicate-from is set, auth-replicate-interval must be positive")
+	} else if authFile == "" && scimToken != "" {
+		return errors.New("cannot set scim-token if auth-file is not set")
+	} else if scimToken != "" && len(scimToken) < 16 {
+		return errors.New("if set, scim-token must be at least 16 characters long")
+	} else if authFile == "" && len(accountWebhookURLs) > 0 {
+		return errors.New("cannot set account-webhook-url if auth-file is not set")
+	} else if len(accountWebhookURLs) == 0 && (accountWebhookSecret != "" || len(accountWebhookEvents) > 0) {
+		return errors.New("if account-webhook-secret or account-webhook-events is set, account-webhook-url must also be set")
+	} else if publishURLSecret != "" && (baseURL == "" || len(publishURLSecret) < 16) {
+		return errors.New("if publish-url-secret is set, base-url must also be set, and the secret must be at least 16 characters long")
 	} else if enableSignup && !enableLogin {
 		return errors.New("cannot set enable-signup without also setting enable-login")
 	} else if stripeSecretKey != "" && (stripeWebhookKey == "" || baseURL == "") {
 		return errors.New("if stripe-secret-key is set, stripe-webhook-key and base-url must also be set")
+	} else if paddleAPIKey != "" && (paddleWebhookKey == "" || baseURL == "") {
+		return errors.New("if paddle-api-key is set, paddle-webhook-key and base-url must also be set")
+	} else if stripeSecretKey != "" && paddleAPIKey != "" {
+		return errors.New("cannot set both stripe-secret-key and paddle-api-key, only one payment provider can be used")
+	} else if paddleEnvironment != "production" && paddleEnvironment != "sandbox" {
+		return errors.New("if set, paddle-environment must be either production or sandbox")
 	} else if twilioAccount != "" && (twilioAuthToken == "" || twilioPhoneNumber == "" || twilioVerifyService == "" || baseURL == "" || authFile == "") {
 		return errors.New("if twilio-account is set, twilio-auth-token, twilio-phone-number, twilio-verify-service, base-url, and auth-file must also be set")
 	}
@@ -249,7 +572,35 @@ func execServe(c *cli.Context) error {
 	// Default auth permissions
 	authDefault, err := user.ParsePermission(authDefaultAccess)
 	if err != nil {
-		return errors.New("if set, auth-default-access must start set to 'read-write', 'read-only', 'write-only' or 'deny-all'")
+		return errors.New("if set, auth-default-access must start set to 'read-write', 'read-only', 'write-only', 'anon-read-auth-write' or 'deny-all'")
+	}
+
+	// Provisioned users, access control entries, tokens and tiers
+	if authProvisionDir != "" {
+		dropIn, err := readAuthProvisionDir(authProvisionDir)
+		if err != nil {
+			return err
+		}
+		authUsersRaw = append(authUsersRaw, dropIn.Users...)
+		authAccessRaw = append(authAccessRaw, dropIn.Access...)
+		authTokensRaw = append(authTokensRaw, dropIn.Tokens...)
+		authTiersRaw = append(authTiersRaw, dropIn.Tiers...)
+	}
+	authTiers, err := parseAuthTiers(authTiersRaw)
+	if err != nil {
+		return err
+	}
+	authUsers, err := parseAuthUsers(authUsersRaw)
+	if err != nil {
+		return err
+	}
+	authAccess, err := parseAuthAccess(authAccessRaw)
+	if err != nil {
+		return err
+	}
+	authTokens, err := parseAuthTokens(authTokensRaw)
+	if err != nil {
+		return err
 	}
 
 	// Special case: Unset default
@@ -257,7 +608,39 @@ func execServe(c *cli.Context) error {
 		listenHTTP = ""
 	}
 
+	// Parse Android notification channel rules
+	firebaseAndroidChannels, err := parseFirebaseAndroidChannels(firebaseAndroidChannelsRaw)
+	if err != nil {
+		return err
+	}
+	upstreams, err := parseUpstreams(upstreamsRaw, baseURL)
+	if err != nil {
+		return err
+	}
+	webhookSignatures, err := parseWebhookSignatures(webhookSignaturesRaw)
+	if err != nil {
+		return err
+	}
+	forwardRules, err := parseForwardRules(forwardRulesRaw)
+	if err != nil {
+		return err
+	}
+
+	// Parse custom tag icons
+	tagIcons, err := parseTagIcons(tagIconsRaw)
+	if err != nil {
+		return err
+	}
+
 	// Convert sizes to bytes
+	cacheMaxSize, err := parseSize(cacheMaxSizeStr, 0)
+	if err != nil {
+		return err
+	}
+	cacheCompressThreshold, err := parseSize(cacheCompressThresholdStr, 0)
+	if err != nil {
+		return err
+	}
 	attachmentTotalSizeLimit, err := parseSize(attachmentTotalSizeLimitStr, server.DefaultAttachmentTotalSizeLimit)
 	if err != nil {
 		return err
@@ -266,6 +649,18 @@ func execServe(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	messageSizeLimit, err := parseSize(messageSizeLimitStr, int64(server.DefaultMessageLengthLimit))
+	if err != nil {
+		return err
+	} else if messageSizeLimit < 1 || messageSizeLimit > maxMessageSizeLimit {
+		return errors.New("message-size-limit must be between 1 byte and 5M")
+	}
+	accountBodySizeLimit, err := parseSize(accountBodySizeLimitStr, int64(server.DefaultAccountBodySizeLimit))
+	if err != nil {
+		return err
+	} else if accountBodySizeLimit < minAccountBodySizeLimit || accountBodySizeLimit > maxAccountBodySizeLimit {
+		return errors.New("account-body-size-limit must be between 4K and 1M")
+	}
 	visitorAttachmentTotalSizeLimit, err := parseSize(visitorAttachmentTotalSizeLimitStr, server.DefaultVisitorAttachmentTotalSizeLimit)
 	if err != nil {
 		return err
@@ -277,10 +672,24 @@ func execServe(c *cli.Context) error {
 		return fmt.Errorf("config option visitor-attachment-daily-bandwidth-limit must be lower than %d", math.MaxInt)
 	}
 
+	// Convert durations
+	topicInactivityExpiryDuration, err := parseDuration(topicInactivityExpiryDurationStr, server.DefaultTopicInactivityExpiryDuration)
+	if err != nil {
+		return err
+	}
+	topicInactivityWarningDuration, err := parseDuration(topicInactivityWarningDurationStr, server.DefaultTopicInactivityWarningDuration)
+	if err != nil {
+		return err
+	} else if topicInactivityExpiryDuration > 0 && topicInactivityExpiryDuration < managerInterval {
+		return errors.New("topic inactivity expiry duration cannot be lower than manager interval")
+	} else if topicInactivityExpiryDuration > 0 && topicInactivityWarningDuration >= topicInactivityExpiryDuration {
+		return errors.New("topic inactivity warning duration must be lower than topic inactivity expiry duration")
+	}
+
 	// Resolve hosts
 	visitorRequestLimitExemptIPs := make([]netip.Prefix, 0)
 	for _, host := range visitorRequestLimitExemptHosts {
-		ips, err := parseIPHostPrefix(host)
+		ips, err := util.ParseIPHostPrefix(host)
 		if err != nil {
 			log.Warn("cannot resolve host %s: %s, ignoring visitor request exemption", host, err.Error())
 			continue
@@ -305,31 +714,83 @@ func execServe(c *cli.Context) error {
 	conf.ListenHTTPS = listenHTTPS
 	conf.ListenUnix = listenUnix
 	conf.ListenUnixMode = fs.FileMode(listenUnixMode)
+	conf.ListenReusePort = listenReusePort
 	conf.KeyFile = keyFile
 	conf.CertFile = certFile
 	conf.FirebaseKeyFile = firebaseKeyFile
+	conf.FirebaseWorkers = firebaseWorkers
+	conf.FirebaseQueueSize = firebaseQueueSize
+	conf.FirebaseCriticalAlertTopics = firebaseCriticalAlertTopics
+	conf.FirebaseAndroidChannels = firebaseAndroidChannels
 	conf.CacheFile = cacheFile
 	conf.CacheDuration = cacheDuration
 	conf.CacheStartupQueries = cacheStartupQueries
 	conf.CacheBatchSize = cacheBatchSize
 	conf.CacheBatchTimeout = cacheBatchTimeout
+	conf.CacheSnapshotFile = cacheSnapshotFile
+	conf.CacheSnapshotInterval = cacheSnapshotInterval
+	conf.CacheMaxSize = cacheMaxSize
+	conf.CacheMaxMessagesPerTopic = cacheMaxMessagesPerTopic
+	conf.CacheVacuumInterval = cacheVacuumInterval
+	conf.CacheCompressThreshold = cacheCompressThreshold
 	conf.AuthFile = authFile
 	conf.AuthStartupQueries = authStartupQueries
 	conf.AuthDefault = authDefault
+	conf.AuthUsers = authUsers
+	conf.AuthAccess = authAccess
+	conf.AuthTokens = authTokens
+	conf.AuthTiers = authTiers
+	conf.AuthReplicateFrom = authReplicateFrom
+	conf.AuthReplicateToken = authReplicateToken
+	conf.AuthReplicateInterval = authReplicateInterval
+	conf.SCIMToken = scimToken
+	conf.AccountWebhookURLs = accountWebhookURLs
+	conf.AccountWebhookSecret = accountWebhookSecret
+	conf.AccountWebhookEvents = accountWebhookEvents
+	conf.PublishURLSecret = publishURLSecret
+	conf.WebhookSignatures = webhookSignatures
+	conf.ForwardRules = forwardRules
+	conf.ShowcaseTopics = showcaseTopics
+	conf.ShowcaseMessagesLimit = showcaseMessagesLimit
 	conf.AttachmentCacheDir = attachmentCacheDir
 	conf.AttachmentTotalSizeLimit = attachmentTotalSizeLimit
 	conf.AttachmentFileSizeLimit = attachmentFileSizeLimit
+	conf.MessageLimit = int(messageSizeLimit)
+	conf.AccountBodySizeLimit = int(accountBodySizeLimit)
 	conf.AttachmentExpiryDuration = attachmentExpiryDuration
+	conf.AttachmentBaseURL = attachmentBaseURL
+	conf.AttachmentStripHTML = attachmentStripHTML
 	conf.KeepaliveInterval = keepaliveInterval
+	conf.KeepaliveIntervalMin = keepaliveIntervalMin
+	conf.KeepaliveIntervalMax = keepaliveIntervalMax
+	conf.SSERetryMax = sseRetryMax
+	conf.SubscriberBacklogLimit = subscriberBacklogLimit
+	conf.SubscriberSlowAction = subscriberSlowAction
 	conf.ManagerInterval = managerInterval
+	conf.ShutdownTimeout = shutdownTimeout
 	conf.DisallowedTopics = disallowedTopics
+	conf.TopicInactivityExpiryDuration = topicInactivityExpiryDuration
+	conf.TopicInactivityWarningDuration = topicInactivityWarningDuration
 	conf.WebRoot = webRoot
+	conf.TagIcons = tagIcons
+	conf.TemplateDir = templateDir
+	conf.TemplateInlineAnonymous = templateInlineAnonymous
 	conf.UpstreamBaseURL = upstreamBaseURL
 	conf.UpstreamAccessToken = upstreamAccessToken
+	conf.Upstreams = upstreams
+	conf.PushFallback = pushFallback
 	conf.SMTPSenderAddr = smtpSenderAddr
 	conf.SMTPSenderUser = smtpSenderUser
 	conf.SMTPSenderPass = smtpSenderPass
 	conf.SMTPSenderFrom = smtpSenderFrom
+	conf.SMTPSenderBatchWindow = smtpSenderBatchWindow
+	conf.SMTPSenderBatchMax = smtpSenderBatchMax
+	conf.SMTPSenderProvider = smtpSenderProvider
+	conf.SMTPSenderAPIKey = smtpSenderAPIKey
+	conf.SMTPSenderAPISecret = smtpSenderAPISecret
+	conf.SMTPSenderAPIRegion = smtpSenderAPIRegion
+	conf.SMTPSenderRateLimit = smtpSenderRateLimit
+	conf.SMTPSenderWebhookKey = smtpSenderWebhookKey
 	conf.SMTPServerListen = smtpServerListen
 	conf.SMTPServerDomain = smtpServerDomain
 	conf.SMTPServerAddrPrefix = smtpServerAddrPrefix
@@ -349,8 +810,23 @@ func execServe(c *cli.Context) error {
 	conf.VisitorEmailLimitReplenish = visitorEmailLimitReplenish
 	conf.VisitorSubscriberRateLimiting = visitorSubscriberRateLimiting
 	conf.BehindProxy = behindProxy
+	conf.AccessControlAllowOrigins = accessControlAllowOrigins
+	conf.AccessControlAllowMethods = accessControlAllowMethods
+	conf.AccessControlAllowCredentials = accessControlAllowCredentials
+	conf.CSRFProtection = csrfProtection
+	conf.IPBanAuthFailures = ipBanAuthFailures
+	conf.IPBanRateLimitViolations = ipBanRateLimitViolations
+	conf.IPBanWindow = ipBanWindow
+	conf.IPBanDuration = ipBanDuration
+	conf.AuthFailureLogFile = authFailureLogFile
+	conf.AccessLog = accessLog
+	conf.AccessLogFormat = accessLogFormat
+	conf.AccessLogRoutes = accessLogRoutes
 	conf.StripeSecretKey = stripeSecretKey
 	conf.StripeWebhookKey = stripeWebhookKey
+	conf.PaddleAPIKey = paddleAPIKey
+	conf.PaddleWebhookKey = paddleWebhookKey
+	conf.PaddleEnvironment = paddleEnvironment
 	conf.BillingContact = billingContact
 	conf.EnableSignup = enableSignup
 	conf.EnableLogin = enableLogin
@@ -358,12 +834,20 @@ func execServe(c *cli.Context) error {
 	conf.EnableMetrics = enableMetrics
 	conf.MetricsListenHTTP = metricsListenHTTP
 	conf.ProfileListenHTTP = profileListenHTTP
+	conf.ReadinessCheckExternal = readinessCheckExternal
 	conf.Version = c.App.Version
 	conf.WebPushPrivateKey = webPushPrivateKey
 	conf.WebPushPublicKey = webPushPublicKey
 	conf.WebPushFile = webPushFile
 	conf.WebPushEmailAddress = webPushEmailAddress
 	conf.WebPushStartupQueries = webPushStartupQueries
+	conf.WebPushWorkers = webPushWorkers
+	conf.WebPushKeepaliveStrategy = webPushKeepaliveStrategy
+	conf.WebPushUserSubscriptionLimit = webPushUserSubscriptionLimit
+	if dev {
+		conf.VisitorAccountCreationLimitBurst = devVisitorAccountCreationLimitBurst
+		conf.VisitorAuthFailureLimitBurst = devVisitorAuthFailureLimitBurst
+	}
 
 	// Set up hot-reloading of config
 	go sigHandlerConfigReload(config)
@@ -372,13 +856,41 @@ func execServe(c *cli.Context) error {
 	s, err := server.New(conf)
 	if err != nil {
 		log.Fatal(err.Error())
-	} else if err := s.Run(); err != nil {
+	}
+	stopped := make(chan struct{})
+	go sigHandlerStop(s, stopped) // Drains connections and queues, and writes a final message cache snapshot before exiting
+	if err := s.Run(); errors.Is(err, http.ErrServerClosed) {
+		<-stopped
+	} else if err != nil {
 		log.Fatal(err.Error())
 	}
 	log.Info("Exiting.")
 	return nil
 }
 
+// devMemoryFilename returns a unique SQLite filename for an in-memory database, see server.createMemoryFilename
+func devMemoryFilename(name string) string {
+	return fmt.Sprintf("file:ntfy-dev-%s-%s?mode=memory&cache=shared", name, util.RandomString(10))
+}
+
+// devBaseURL derives a base URL from the HTTP listen address (e.g. :8080 -> http://localhost:8080),
+// or returns an empty string if the server does not listen on HTTP
+func devBaseURL(listenHTTP string) string {
+	if listenHTTP == "" || listenHTTP == "-" {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(listenHTTP)
+	if err != nil {
+		return ""
+	} else if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	if port == "80" {
+		return fmt.Sprintf("http://%s", host)
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(host, port))
+}
+
 func parseSize(s string, defaultValue int64) (v int64, err error) {
 	if s == "" {
 		return defaultValue, nil
@@ -390,6 +902,22 @@ func parseSize(s string, defaultValue int64) (v int64, err error) {
 	return v, nil
 }
 
+func parseDuration(s string, defaultValue time.Duration) (time.Duration, error) {
+	if s == "" {
+		return defaultValue, nil
+	}
+	return util.ParseDuration(s)
+}
+
+func sigHandlerStop(s *server.Server, stopped chan struct{}) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigs
+	log.Info("Stopping server ...")
+	s.Shutdown()
+	close(stopped)
+}
+
 func sigHandlerConfigReload(config string) {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGHUP)
@@ -406,29 +934,429 @@ func sigHandlerConfigReload(config string) {
 	}
 }
 
-func parseIPHostPrefix(host string) (prefixes []netip.Prefix, err error) {
-	// Try parsing as prefix, e.g. 10.0.1.0/24
-	prefix, err := netip.ParsePrefix(host)
-	if err == nil {
-		prefixes = append(prefixes, prefix.Masked())
-		return prefixes, nil
-	}
-	// Not a prefix, parse as host or IP (LookupHost passes through an IP as is)
-	ips, err := net.LookupHost(host)
+// authProvisionFile is the format of a drop-in file in the auth-provision-dir directory
+type authProvisionFile struct {
+	Users  []string `yaml:"auth-users"`
+	Access []string `yaml:"auth-access"`
+	Tokens []string `yaml:"auth-tokens"`
+	Tiers  []string `yaml:"auth-tiers"`
+}
+
+// readAuthProvisionDir reads all *.yml and *.yaml files in the given directory (in lexical order),
+// and merges their auth-users, auth-access, auth-tokens and auth-tiers entries
+func readAuthProvisionDir(dir string) (*authProvisionFile, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot read auth-provision-dir: %s", err.Error())
 	}
-	for _, ipStr := range ips {
-		ip, err := netip.ParseAddr(ipStr)
-		if err == nil {
-			prefix, err := ip.Prefix(ip.BitLen())
+	merged := &authProvisionFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || (!strings.HasSuffix(entry.Name(), ".yml") && !strings.HasSuffix(entry.Name(), ".yaml")) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var file authProvisionFile
+		if err := yaml.UnmarshalStrict(b, &file); err != nil {
+			return nil, fmt.Errorf("cannot parse auth provisioning file %s: %s", entry.Name(), err.Error())
+		}
+		merged.Users = append(merged.Users, file.Users...)
+		merged.Access = append(merged.Access, file.Access...)
+		merged.Tokens = append(merged.Tokens, file.Tokens...)
+		merged.Tiers = append(merged.Tiers, file.Tiers...)
+	}
+	return merged, nil
+}
+
+// parseAuthUsers parses auth-users entries of the form "username:bcrypt-hash:role[:tier]"
+func parseAuthUsers(entries []string) ([]*user.User, error) {
+	users := make([]*user.User, 0)
+	for _, entry := range entries {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 3 || len(parts) > 4 {
+			return nil, fmt.Errorf("invalid auth-users entry %s, expected format username:bcrypt-hash:role[:tier]", entry)
+		}
+		username, hash, role := parts[0], parts[1], user.Role(parts[2])
+		if !user.AllowedUsername(username) {
+			return nil, fmt.Errorf("invalid auth-users entry: username %s not allowed", username)
+		} else if !strings.HasPrefix(hash, "$2") {
+			return nil, fmt.Errorf("invalid auth-users entry for user %s: password must be a bcrypt hash, use 'ntfy user hash' to generate one", username)
+		} else if !user.AllowedRole(role) {
+			return nil, fmt.Errorf("invalid auth-users entry for user %s: role must be either 'user' or 'admin'", username)
+		}
+		u := &user.User{
+			Name: username,
+			Hash: hash,
+			Role: role,
+		}
+		if len(parts) == 4 && parts[3] != "" {
+			if !user.AllowedTier(parts[3]) {
+				return nil, fmt.Errorf("invalid auth-users entry for user %s: tier %s not allowed", username, parts[3])
+			}
+			u.Tier = &user.Tier{Code: parts[3]}
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// parseAuthAccess parses auth-access entries of the form "username:topic-pattern:permission"
+func parseAuthAccess(entries []string) (map[string][]user.Grant, error) {
+	access := make(map[string][]user.Grant)
+	for _, entry := range entries {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid auth-access entry %s, expected format username:topic-pattern:permission", entry)
+		}
+		username, topicPattern := parts[0], parts[1]
+		if username == userEveryone {
+			username = user.Everyone
+		}
+		if username != user.Everyone && !user.AllowedUsername(username) {
+			return nil, fmt.Errorf("invalid auth-access entry: username %s not allowed", username)
+		} else if !user.AllowedTopicPattern(topicPattern) {
+			return nil, fmt.Errorf("invalid auth-access entry for user %s: topic pattern %s not allowed", username, topicPattern)
+		}
+		permission, err := user.ParsePermission(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth-access entry for user %s: %s", username, err.Error())
+		} else if permission.IsAuthWrite() && username != user.Everyone {
+			return nil, fmt.Errorf("invalid auth-access entry for user %s: permission %s can only be granted to everyone", username, parts[2])
+		}
+		access[username] = append(access[username], user.Grant{
+			TopicPattern: topicPattern,
+			Allow:        permission,
+		})
+	}
+	return access, nil
+}
+
+// parseFirebaseAndroidChannels parses firebase-android-channels entries of the form
+// "channel[:topic=...;priority=...;tag=...]", where topic may be a prefix (e.g. "oncall-*"),
+// and priority is the minimum message priority
+func parseFirebaseAndroidChannels(entries []string) ([]*server.FirebaseAndroidChannel, error) {
+	channels := make([]*server.FirebaseAndroidChannel, 0)
+	for _, entry := range entries {
+		channel, options, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if !firebaseAndroidChannelRegex.MatchString(channel) {
+			return nil, fmt.Errorf("invalid firebase-android-channels entry: channel %s not allowed", channel)
+		}
+		rule := &server.FirebaseAndroidChannel{Channel: channel}
+		for _, option := range util.SplitNoEmpty(options, ";") {
+			key, value, ok := strings.Cut(option, "=")
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if !ok || value == "" {
+				return nil, fmt.Errorf("invalid firebase-android-channels entry for channel %s: malformed option %s", channel, option)
+			}
+			switch key {
+			case "topic":
+				rule.Topic = value
+			case "priority":
+				priority, err := util.ParsePriority(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid firebase-android-channels entry for channel %s: invalid priority %s", channel, value)
+				}
+				rule.MinPriority = priority
+			case "tag":
+				rule.Tag = value
+			default:
+				return nil, fmt.Errorf("invalid firebase-android-channels entry for channel %s: unknown option %s", channel, key)
+			}
+		}
+		channels = append(channels, rule)
+	}
+	return channels, nil
+}
+
+// parseUpstreams parses upstreams entries of the form "base-url[;topic=...;token=...]"
+func parseUpstreams(entries []string, baseURL string) ([]*server.Upstream, error) {
+	upstreams := make([]*server.Upstream, 0)
+	for _, entry := range entries {
+		parts := util.SplitNoEmpty(entry, ";")
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("invalid upstreams entry %s, expected format base-url[;topic=...;token=...]", entry)
+		}
+		upstream := &server.Upstream{BaseURL: strings.TrimSpace(parts[0])}
+		if !strings.HasPrefix(upstream.BaseURL, "http://") && !strings.HasPrefix(upstream.BaseURL, "https://") {
+			return nil, fmt.Errorf("invalid upstreams entry: base URL %s must start with http:// or https://", upstream.BaseURL)
+		} else if strings.HasSuffix(upstream.BaseURL, "/") {
+			return nil, fmt.Errorf("invalid upstreams entry: base URL %s must not end with a slash (/)", upstream.BaseURL)
+		} else if upstream.BaseURL == baseURL {
+			return nil, fmt.Errorf("invalid upstreams entry: base URL %s cannot be identical to base-url", upstream.BaseURL)
+		}
+		for _, option := range parts[1:] {
+			key, value, ok := strings.Cut(option, "=")
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if !ok || value == "" {
+				return nil, fmt.Errorf("invalid upstreams entry for %s: malformed option %s", upstream.BaseURL, option)
+			}
+			switch key {
+			case "topic":
+				upstream.Topic = value
+			case "token":
+				upstream.AccessToken = value
+			default:
+				return nil, fmt.Errorf("invalid upstreams entry for %s: unknown option %s", upstream.BaseURL, key)
+			}
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	return upstreams, nil
+}
+
+// parseWebhookSignatures parses webhook-signatures entries of the form "topic:provider:secret", where topic may
+// also be a topic prefix (e.g. "github-*"), and provider is one of "github", "stripe" or "slack"
+func parseWebhookSignatures(entries []string) ([]*server.WebhookSignature, error) {
+	signatures := make([]*server.WebhookSignature, 0)
+	for _, entry := range entries {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid webhook-signatures entry %s, expected format topic:provider:secret", entry)
+		}
+		topic, provider, secret := parts[0], parts[1], parts[2]
+		if !util.Contains([]string{server.WebhookProviderGitHub, server.WebhookProviderStripe, server.WebhookProviderSlack}, provider) {
+			return nil, fmt.Errorf("invalid webhook-signatures entry for %s: unknown provider %s, must be github, stripe or slack", topic, provider)
+		}
+		signatures = append(signatures, &server.WebhookSignature{
+			Topic:    topic,
+			Provider: provider,
+			Secret:   secret,
+		})
+	}
+	return signatures, nil
+}
+
+// parseForwardRules parses forward-rules entries of the form
+// "topic:target[;priority=...;tags=...;set-priority=...;add-tags=...]", where topic may be a prefix
+// (e.g. "service-*"), priority is the minimum message priority, and tags are comma-separated
+func parseForwardRules(entries []string) ([]*server.ForwardRule, error) {
+	rules := make([]*server.ForwardRule, 0)
+	for _, entry := range entries {
+		parts := util.SplitNoEmpty(entry, ";")
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("invalid forward-rules entry %s, expected format topic:target[;priority=...;tags=...]", entry)
+		}
+		topic, target, ok := strings.Cut(strings.TrimSpace(parts[0]), ":")
+		if !ok || topic == "" || target == "" {
+			return nil, fmt.Errorf("invalid forward-rules entry %s, expected format topic:target[;priority=...;tags=...]", entry)
+		} else if !forwardRuleTargetRegex.MatchString(target) {
+			return nil, fmt.Errorf("invalid forward-rules entry for %s: target topic %s not allowed", topic, target)
+		} else if topic == target {
+			return nil, fmt.Errorf("invalid forward-rules entry for %s: target topic cannot be identical to topic", topic)
+		}
+		rule := &server.ForwardRule{Topic: topic, Target: target}
+		for _, option := range parts[1:] {
+			key, value, ok := strings.Cut(option, "=")
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if !ok || value == "" {
+				return nil, fmt.Errorf("invalid forward-rules entry for %s: malformed option %s", topic, option)
+			}
+			switch key {
+			case "priority", "set-priority":
+				priority, err := util.ParsePriority(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid forward-rules entry for %s: invalid priority %s", topic, value)
+				}
+				if key == "priority" {
+					rule.MinPriority = priority
+				} else {
+					rule.SetPriority = priority
+				}
+			case "tags":
+				rule.Tags = util.SplitNoEmpty(value, ",")
+			case "add-tags":
+				rule.AddTags = util.SplitNoEmpty(value, ",")
+			default:
+				return nil, fmt.Errorf("invalid forward-rules entry for %s: unknown option %s", topic, key)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseTagIcons parses tag-icons entries of the form "tag=emoji", "tag=U+codepoint" (multiple code points may be
+// separated by spaces or dashes, e.g. "U+1F468-U+200D-U+1F4BB"), or "tag=/path/to/icon.png". Image files must be
+// PNG, JPEG, GIF or WebP images.
+func parseTagIcons(entries []string) ([]*server.TagIcon, error) {
+	icons := make([]*server.TagIcon, 0)
+	for _, entry := range entries {
+		tag, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		tag, value = strings.TrimSpace(tag), strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid tag-icons entry %s, expected format tag=emoji, tag=U+codepoint or tag=/path/to/icon.png", entry)
+		} else if !tagIconTagRegex.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag-icons entry: tag %s not allowed, must be lowercase and consist only of characters a-z, 0-9, '.', '-' and '_'", tag)
+		}
+		icon := &server.TagIcon{Tag: tag}
+		if tagIconCodepointsRegex.MatchString(value) {
+			var emoji strings.Builder
+			for _, codepoint := range tagIconCodepointsSplitRegex.Split(value, -1) {
+				r, err := strconv.ParseUint(codepoint[2:], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return nil, fmt.Errorf("invalid tag-icons entry for tag %s: invalid code point %s", tag, codepoint)
+				}
+				emoji.WriteRune(rune(r))
+			}
+			icon.Emoji = emoji.String()
+		} else if strings.ContainsAny(value, `/\`) || filepath.Ext(value) != "" {
+			contentType, err := detectFileContentType(value)
 			if err != nil {
-				return nil, fmt.Errorf("%s successfully parsed but unable to make prefix: %s", ip.String(), err.Error())
+				return nil, fmt.Errorf("invalid tag-icons entry for tag %s: %s", tag, err.Error())
+			} else if !util.Contains(tagIconImageTypes, contentType) {
+				return nil, fmt.Errorf("invalid tag-icons entry for tag %s: file %s must be a PNG, JPEG, GIF or WebP image, but is %s", tag, value, contentType)
 			}
-			prefixes = append(prefixes, prefix.Masked())
+			icon.File = value
+		} else if utf8.RuneCountInString(value) <= 16 {
+			icon.Emoji = value
+		} else {
+			return nil, fmt.Errorf("invalid tag-icons entry for tag %s: emoji %s too long", tag, value)
+		}
+		icons = append(icons, icon)
+	}
+	return icons, nil
+}
+
+// invalidAccessControlOrigin returns the first entry of access-control-allow-origins that is neither "*",
+// nor an origin of the form scheme://host[:port], or an empty string if all entries are valid
+func invalidAccessControlOrigin(origins []string) string {
+	for _, origin := range origins {
+		if origin != "*" && !accessControlOriginRegex.MatchString(strings.TrimSuffix(origin, "/")) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// detectFileContentType returns the mime type of the given file, based on its first bytes
+func detectFileContentType(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b := make([]byte, 512)
+	n, err := f.Read(b)
+	if err != nil {
+		return "", err
+	}
+	contentType, _ := util.DetectContentType(b[:n], filename)
+	return contentType, nil
+}
+
+// parseAuthTokens parses auth-tokens entries of the form "username:token-hash[:label]". Plaintext tokens are
+// rejected, so that live credentials never end up in config files, see user.HashToken.
+func parseAuthTokens(entries []string) (map[string][]*user.Token, error) {
+	tokens := make(map[string][]*user.Token)
+	for _, entry := range entries {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid auth-tokens entry for %s, expected format username:token-hash[:label]", parts[0])
 		}
+		username, token := parts[0], parts[1]
+		if !user.AllowedUsername(username) {
+			return nil, fmt.Errorf("invalid auth-tokens entry: username %s not allowed", username)
+		} else if strings.HasPrefix(token, "tk_") {
+			return nil, fmt.Errorf("invalid auth-tokens entry for %s: plaintext tokens are not allowed, use the token hash instead (see 'ntfy token hash')", username)
+		} else if !user.AllowedTokenHash(token) {
+			return nil, fmt.Errorf("invalid auth-tokens entry for %s: token hash must be a hex-encoded SHA-256 hash (see 'ntfy token hash')", username)
+		}
+		var label string
+		if len(parts) == 3 {
+			label = parts[2]
+		}
+		tokens[username] = append(tokens[username], &user.Token{
+			Value: token,
+			Label: label,
+		})
 	}
-	return
+	return tokens, nil
+}
+
+// parseAuthTiers parses auth-tiers entries of the form "code[:key=value;key=value;...]", where the keys are
+// the same as the flags of 'ntfy tier add'. Limits that are not set default to the same values as 'ntfy tier add'.
+func parseAuthTiers(entries []string) ([]*user.Tier, error) {
+	tiers := make([]*user.Tier, 0)
+	for _, entry := range entries {
+		code, options, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if !user.AllowedTier(code) {
+			return nil, fmt.Errorf("invalid auth-tiers entry: tier code %s not allowed", code)
+		}
+		values := map[string]string{
+			"name":                        code,
+			"message-limit":               fmt.Sprintf("%d", defaultMessageLimit),
+			"message-expiry-duration":     defaultMessageExpiryDuration,
+			"message-size-limit":          "0",
+			"email-limit":                 fmt.Sprintf("%d", defaultEmailLimit),
+			"call-limit":                  fmt.Sprintf("%d", defaultCallLimit),
+			"reservation-limit":           fmt.Sprintf("%d", defaultReservationLimit),
+			"attachment-file-size-limit":  defaultAttachmentFileSizeLimit,
+			"attachment-total-size-limit": defaultAttachmentTotalSizeLimit,
+			"attachment-expiry-duration":  defaultAttachmentExpiryDuration,
+			"attachment-bandwidth-limit":  defaultAttachmentBandwidthLimit,
+			"stripe-monthly-price-id":     "",
+			"stripe-yearly-price-id":      "",
+		}
+		for _, option := range util.SplitNoEmpty(options, ";") {
+			key, value, ok := strings.Cut(option, "=")
+			key = strings.TrimSpace(key)
+			if _, exists := values[key]; !ok || !exists {
+				return nil, fmt.Errorf("invalid auth-tiers entry for tier %s: unknown or malformed option %s", code, option)
+			}
+			values[key] = strings.TrimSpace(value)
+		}
+		tier, err := parseAuthTierValues(code, values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth-tiers entry for tier %s: %s", code, err.Error())
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+func parseAuthTierValues(code string, values map[string]string) (*user.Tier, error) {
+	var limits [4]int64
+	for i, key := range []string{"message-limit", "email-limit", "call-limit", "reservation-limit"} {
+		limit, err := strconv.ParseInt(values[key], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", key, values[key])
+		}
+		limits[i] = limit
+	}
+	var sizes [4]int64
+	for i, key := range []string{"attachment-file-size-limit", "attachment-total-size-limit", "attachment-bandwidth-limit", "message-size-limit"} {
+		size, err := util.ParseSize(values[key])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", key, values[key])
+		}
+		sizes[i] = size
+	}
+	var durations [2]time.Duration
+	for i, key := range []string{"message-expiry-duration", "attachment-expiry-duration"} {
+		duration, err := util.ParseDuration(values[key])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", key, values[key])
+		}
+		durations[i] = duration
+	}
+	return &user.Tier{
+		Code:                     code,
+		Name:                     values["name"],
+		MessageLimit:             limits[0],
+		MessageExpiryDuration:    durations[0],
+		MessageSizeLimit:         sizes[3],
+		EmailLimit:               limits[1],
+		CallLimit:                limits[2],
+		ReservationLimit:         limits[3],
+		AttachmentFileSizeLimit:  sizes[0],
+		AttachmentTotalSizeLimit: sizes[1],
+		AttachmentExpiryDuration: durations[1],
+		AttachmentBandwidthLimit: sizes[2],
+		StripeMonthlyPriceID:     values["stripe-monthly-price-id"],
+		StripeYearlyPriceID:      values["stripe-yearly-price-id"],
+	}, nil
 }
 
 func reloadLogLevel(inputSource altsrc.InputSourceContext) error {
@@ -452,3 +1380,16 @@ func reloadLogLevel(inputSource altsrc.InputSourceContext) error {
 	}
 	return nil
 }
+
+// pushFallbackFirebaseBeforeUpstream returns true if firebase is listed before upstream in the push-fallback list.
+// Sending to a Firebase topic always succeeds, so upstream would never be tried.
+func pushFallbackFirebaseBeforeUpstream(pushFallback []string) bool {
+	for _, channel := range pushFallback {
+		if channel == server.PushChannelFirebase {
+			return util.Contains(pushFallback, server.PushChannelUpstream)
+		} else if channel == server.PushChannelUpstream {
+			return false
+		}
+	}
+	return false
+}
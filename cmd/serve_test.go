@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -10,10 +12,10 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/client"
 	"heckel.io/ntfy/v2/test"
+	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 )
 
@@ -68,20 +70,280 @@ func TestCLI_Serve_WebSocket(t *testing.T) {
 	require.Equal(t, "mytopic", m.Topic)
 }
 
-func TestIP_Host_Parsing(t *testing.T) {
-	cases := map[string]string{
-		"1.1.1.1":          "1.1.1.1/32",
-		"fd00::1234":       "fd00::1234/128",
-		"192.168.0.3/24":   "192.168.0.0/24",
-		"10.1.2.3/8":       "10.0.0.0/8",
-		"201:be93::4a6/21": "201:b800::/21",
-	}
-	for q, expectedAnswer := range cases {
-		ips, err := parseIPHostPrefix(q)
+func TestCLI_Serve_Dev(t *testing.T) {
+	port := 10000 + rand.Intn(20000)
+	go func() {
+		configFile := newEmptyFile(t) // Avoid issues with existing server.yml file on system
+		app, _, _, _ := newTestApp()
+		err := app.Run([]string{"ntfy", "serve", "--config=" + configFile, "--dev", "--log-level=info", "--auth-users=phil:$2a$10$HWgCKCuJ8Eh0dfjLZIgMX.Xp8EsQFzMUYyA6udiUdSOpzqRGbZWVW:user", fmt.Sprintf("--listen-http=:%d", port)})
+		require.Nil(t, err)
+	}()
+	test.WaitForPortUp(t, port)
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+	// Message cache is in memory
+	c := client.New(client.NewConfig())
+	_, err := c.Publish(baseURL+"/mytopic", "my message")
+	require.Nil(t, err)
+	messages, err := c.Poll(baseURL + "/mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "my message", messages[0].Message)
+
+	// Rate limits are relaxed
+	for i := 0; i < 100; i++ {
+		_, err := c.Publish(baseURL+"/mytopic", fmt.Sprintf("message %d", i))
 		require.Nil(t, err)
-		assert.Equal(t, 1, len(ips))
-		assert.Equal(t, expectedAnswer, ips[0].String())
 	}
+
+	// User database is in memory, and web push keys are generated
+	req, _ := http.NewRequest("GET", baseURL+"/v1/account", nil)
+	req.Header.Set("Authorization", util.BasicAuth("phil", "phil"))
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	require.Contains(t, string(body), `"username":"phil"`)
+
+	resp, err = http.Get(baseURL + "/config.js")
+	require.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	require.Contains(t, string(body), `"enable_web_push": true`)
+}
+
+func TestDevBaseURL(t *testing.T) {
+	require.Equal(t, "http://localhost", devBaseURL(":80"))
+	require.Equal(t, "http://localhost:8080", devBaseURL(":8080"))
+	require.Equal(t, "http://127.0.0.1:2586", devBaseURL("127.0.0.1:2586"))
+	require.Equal(t, "http://[::1]:8080", devBaseURL("[::1]:8080"))
+	require.Equal(t, "", devBaseURL("-"))
+	require.Equal(t, "", devBaseURL(""))
+}
+
+func TestAuth_Provisioning_Parsing(t *testing.T) {
+	users, err := parseAuthUsers([]string{
+		"phil:$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C:admin",
+		"ben:$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C:user:pro",
+	})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(users))
+	require.Equal(t, "phil", users[0].Name)
+	require.Equal(t, user.RoleAdmin, users[0].Role)
+	require.Nil(t, users[0].Tier)
+	require.Equal(t, "pro", users[1].Tier.Code)
+
+	access, err := parseAuthAccess([]string{"ben:alerts_*:rw", "everyone:announcements:read-only", "*:status:anon-read-auth-write"})
+	require.Nil(t, err)
+	require.Equal(t, []user.Grant{{TopicPattern: "alerts_*", Allow: user.PermissionReadWrite}}, access["ben"])
+	require.Equal(t, 2, len(access[user.Everyone]))
+	require.Equal(t, user.PermissionAnonReadAuthWrite, access[user.Everyone][1].Allow)
+
+	tokens, err := parseAuthTokens([]string{"ben:90714e1a9e5ae760a283bbf13bf47898789c8941eeef34a9f039cdb1bf9bdfcd:CI: deploy"})
+	require.Nil(t, err)
+	require.Equal(t, "90714e1a9e5ae760a283bbf13bf47898789c8941eeef34a9f039cdb1bf9bdfcd", tokens["ben"][0].Value)
+	require.Equal(t, "CI: deploy", tokens["ben"][0].Label)
+
+	tiers, err := parseAuthTiers([]string{"pro:name=Pro;message-limit=10000;attachment-file-size-limit=50M;message-size-limit=16K", "basic"})
+	require.Nil(t, err)
+	require.Equal(t, "Pro", tiers[0].Name)
+	require.Equal(t, int64(10000), tiers[0].MessageLimit)
+	require.Equal(t, int64(50*1024*1024), tiers[0].AttachmentFileSizeLimit)
+	require.Equal(t, int64(16*1024), tiers[0].MessageSizeLimit)
+	require.Equal(t, int64(0), tiers[1].MessageSizeLimit)
+	require.Equal(t, 12*time.Hour, tiers[0].MessageExpiryDuration)
+	require.Equal(t, "basic", tiers[1].Name)
+	require.Equal(t, int64(5000), tiers[1].MessageLimit)
+}
+
+func TestAuth_Provisioning_Parsing_Invalid(t *testing.T) {
+	_, err := parseAuthUsers([]string{"phil:mypass:admin"})
+	require.Error(t, err)
+	_, err = parseAuthUsers([]string{"phil:$2a$10$abc:superuser"})
+	require.Error(t, err)
+	_, err = parseAuthAccess([]string{"ben:alerts:anon-read-auth-write"})
+	require.Error(t, err)
+	_, err = parseAuthAccess([]string{"ben:alerts"})
+	require.Error(t, err)
+	_, err = parseAuthTokens([]string{"ben"})
+	require.Error(t, err)
+	_, err = parseAuthTokens([]string{"ben:tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa:CI"}) // Plaintext
+	require.ErrorContains(t, err, "plaintext tokens are not allowed")
+	require.NotContains(t, err.Error(), "tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	_, err = parseAuthTiers([]string{"pro:unknown-limit=5"})
+	require.Error(t, err)
+	_, err = parseAuthTiers([]string{"pro:message-limit=lots"})
+	require.Error(t, err)
+}
+
+func TestPushFallback_FirebaseBeforeUpstream(t *testing.T) {
+	require.True(t, pushFallbackFirebaseBeforeUpstream([]string{"firebase", "upstream"}))
+	require.True(t, pushFallbackFirebaseBeforeUpstream([]string{"webpush", "firebase", "upstream"}))
+	require.False(t, pushFallbackFirebaseBeforeUpstream([]string{"upstream", "firebase"}))
+	require.False(t, pushFallbackFirebaseBeforeUpstream([]string{"firebase", "webpush"}))
+	require.False(t, pushFallbackFirebaseBeforeUpstream([]string{"webpush", "upstream"}))
+}
+
+func TestFirebaseAndroidChannels_Parsing(t *testing.T) {
+	channels, err := parseFirebaseAndroidChannels([]string{
+		"oncall:topic=oncall-*;priority=high",
+		"fire: tag=fire",
+		"default",
+	})
+	require.Nil(t, err)
+	require.Equal(t, 3, len(channels))
+	require.Equal(t, "oncall", channels[0].Channel)
+	require.Equal(t, "oncall-*", channels[0].Topic)
+	require.Equal(t, 4, channels[0].MinPriority)
+	require.Equal(t, "fire", channels[1].Tag)
+	require.Equal(t, "default", channels[2].Channel)
+	require.Equal(t, "", channels[2].Topic)
+
+	_, err = parseFirebaseAndroidChannels([]string{"oncall:priority=very-high"})
+	require.Error(t, err)
+	_, err = parseFirebaseAndroidChannels([]string{"oncall:sound=loud"})
+	require.Error(t, err)
+	_, err = parseFirebaseAndroidChannels([]string{"oncall:topic"})
+	require.Error(t, err)
+	_, err = parseFirebaseAndroidChannels([]string{"on call"})
+	require.Error(t, err)
+}
+
+func TestUpstreams_Parsing(t *testing.T) {
+	upstreams, err := parseUpstreams([]string{
+		"https://eu.ntfy.example.com;topic=eu-*;token=tk_1234567890",
+		"https://ntfy.sh",
+	}, "https://ntfy.example.com")
+	require.Nil(t, err)
+	require.Equal(t, 2, len(upstreams))
+	require.Equal(t, "https://eu.ntfy.example.com", upstreams[0].BaseURL)
+	require.Equal(t, "eu-*", upstreams[0].Topic)
+	require.Equal(t, "tk_1234567890", upstreams[0].AccessToken)
+	require.Equal(t, "https://ntfy.sh", upstreams[1].BaseURL)
+	require.Equal(t, "", upstreams[1].Topic)
+	require.Equal(t, "", upstreams[1].AccessToken)
+
+	_, err = parseUpstreams([]string{"ntfy.sh"}, "https://ntfy.example.com")
+	require.Error(t, err)
+	_, err = parseUpstreams([]string{"https://ntfy.sh/"}, "https://ntfy.example.com")
+	require.Error(t, err)
+	_, err = parseUpstreams([]string{"https://ntfy.example.com"}, "https://ntfy.example.com")
+	require.Error(t, err)
+	_, err = parseUpstreams([]string{"https://ntfy.sh;region=eu"}, "https://ntfy.example.com")
+	require.Error(t, err)
+	_, err = parseUpstreams([]string{"https://ntfy.sh;token"}, "https://ntfy.example.com")
+	require.Error(t, err)
+}
+
+func TestForwardRules_Parsing(t *testing.T) {
+	rules, err := parseForwardRules([]string{
+		"service-*:pager;priority=urgent;set-priority=high;add-tags=pager,rotating_light",
+		"backups:ops;tags=warning,backup",
+	})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(rules))
+	require.Equal(t, "service-*", rules[0].Topic)
+	require.Equal(t, "pager", rules[0].Target)
+	require.Equal(t, 5, rules[0].MinPriority)
+	require.Equal(t, 4, rules[0].SetPriority)
+	require.Equal(t, []string{"pager", "rotating_light"}, rules[0].AddTags)
+	require.Nil(t, rules[0].Tags)
+	require.Equal(t, "backups", rules[1].Topic)
+	require.Equal(t, "ops", rules[1].Target)
+	require.Equal(t, 0, rules[1].MinPriority)
+	require.Equal(t, []string{"warning", "backup"}, rules[1].Tags)
+
+	_, err = parseForwardRules([]string{"service-*"})
+	require.Error(t, err)
+	_, err = parseForwardRules([]string{"service-*:pager-*"})
+	require.Error(t, err)
+	_, err = parseForwardRules([]string{"pager:pager"})
+	require.Error(t, err)
+	_, err = parseForwardRules([]string{"service-*:pager;priority=extreme"})
+	require.Error(t, err)
+	_, err = parseForwardRules([]string{"service-*:pager;delay=5m"})
+	require.Error(t, err)
+	_, err = parseForwardRules([]string{"service-*:pager;tags"})
+	require.Error(t, err)
+}
+
+func TestWebhookSignatures_Parsing(t *testing.T) {
+	signatures, err := parseWebhookSignatures([]string{
+		"github-*:github:s3cr3t",
+		"payments:stripe:whsec_abc:def",
+	})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(signatures))
+	require.Equal(t, "github-*", signatures[0].Topic)
+	require.Equal(t, "github", signatures[0].Provider)
+	require.Equal(t, "s3cr3t", signatures[0].Secret)
+	require.Equal(t, "whsec_abc:def", signatures[1].Secret)
+
+	_, err = parseWebhookSignatures([]string{"github-*:github"})
+	require.Error(t, err)
+	_, err = parseWebhookSignatures([]string{"github-*:gitlab:s3cr3t"})
+	require.Error(t, err)
+	_, err = parseWebhookSignatures([]string{"github-*:github:"})
+	require.Error(t, err)
+}
+
+func TestTagIcons_Parsing(t *testing.T) {
+	dir := t.TempDir()
+	pngFile := filepath.Join(dir, "k8s.png")
+	textFile := filepath.Join(dir, "k8s.txt")
+	require.Nil(t, os.WriteFile(pngFile, []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), 0600))
+	require.Nil(t, os.WriteFile(textFile, []byte("not an image"), 0600))
+
+	icons, err := parseTagIcons([]string{
+		"jenkins=🤖",
+		"dev = U+1F468-U+200D-U+1F4BB",
+		"k8s=" + pngFile,
+	})
+	require.Nil(t, err)
+	require.Equal(t, 3, len(icons))
+	require.Equal(t, "jenkins", icons[0].Tag)
+	require.Equal(t, "🤖", icons[0].Emoji)
+	require.Equal(t, "dev", icons[1].Tag)
+	require.Equal(t, "👨‍💻", icons[1].Emoji)
+	require.Equal(t, "k8s", icons[2].Tag)
+	require.Equal(t, "", icons[2].Emoji)
+	require.Equal(t, pngFile, icons[2].File)
+
+	_, err = parseTagIcons([]string{"k8s=" + textFile})
+	require.Error(t, err)
+	_, err = parseTagIcons([]string{"k8s=" + filepath.Join(dir, "does-not-exist.png")})
+	require.Error(t, err)
+	_, err = parseTagIcons([]string{"K8s=🤖"})
+	require.Error(t, err)
+	_, err = parseTagIcons([]string{"jenkins"})
+	require.Error(t, err)
+	_, err = parseTagIcons([]string{"jenkins=U+110000"})
+	require.Error(t, err)
+}
+
+func TestAuth_Provisioning_ReadDir(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "10-users.yml"), []byte(`
+auth-users:
+  - "phil:$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C:admin"
+auth-access:
+  - "everyone:announcements:ro"
+`), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "20-tiers.yaml"), []byte(`
+auth-tiers:
+  - "pro:message-limit=10000"
+`), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "README"), []byte("ignored"), 0600))
+
+	dropIn, err := readAuthProvisionDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(dropIn.Users))
+	require.Equal(t, []string{"everyone:announcements:ro"}, dropIn.Access)
+	require.Equal(t, []string{"pro:message-limit=10000"}, dropIn.Tiers)
+	require.Nil(t, dropIn.Tokens)
+
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "30-broken.yml"), []byte("auth-groups: [a]"), 0600))
+	_, err = readAuthProvisionDir(dir)
+	require.Error(t, err)
 }
 
 func newEmptyFile(t *testing.T) string {
@@ -89,3 +351,10 @@ func newEmptyFile(t *testing.T) string {
 	require.Nil(t, os.WriteFile(filename, []byte{}, 0600))
 	return filename
 }
+
+func TestAccessControlOrigins_Invalid(t *testing.T) {
+	require.Equal(t, "", invalidAccessControlOrigin([]string{"*"}))
+	require.Equal(t, "", invalidAccessControlOrigin([]string{"https://ntfy.example.com", "http://localhost:8080/"}))
+	require.Equal(t, "ntfy.example.com", invalidAccessControlOrigin([]string{"https://intranet.example.com", "ntfy.example.com"}))
+	require.Equal(t, "https://example.com/path", invalidAccessControlOrigin([]string{"https://example.com/path"}))
+}
@@ -28,25 +28,32 @@ const (
 var flagsSubscribe = append(
 	append([]cli.Flag{}, flagsDefault...),
 	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, Usage: "client config file"},
+	flagNoKeychain,
 	&cli.StringFlag{Name: "since", Aliases: []string{"s"}, Usage: "return events since `SINCE` (Unix timestamp, or all)"},
 	&cli.StringFlag{Name: "user", Aliases: []string{"u"}, EnvVars: []string{"NTFY_USER"}, Usage: "username[:password] used to auth against the server"},
 	&cli.StringFlag{Name: "token", Aliases: []string{"k"}, EnvVars: []string{"NTFY_TOKEN"}, Usage: "access token used to auth against the server"},
 	&cli.BoolFlag{Name: "from-config", Aliases: []string{"from_config", "C"}, Usage: "read subscriptions from config file (service mode)"},
 	&cli.BoolFlag{Name: "poll", Aliases: []string{"p"}, Usage: "return events and exit, do not listen for new events"},
 	&cli.BoolFlag{Name: "scheduled", Aliases: []string{"sched", "S"}, Usage: "also return scheduled/delayed events"},
+	&cli.BoolFlag{Name: "stdin-json", Aliases: []string{"stdin_json"}, EnvVars: []string{"NTFY_STDIN_JSON"}, Usage: "pass the JSON message to the command's stdin"},
+	&cli.StringFlag{Name: "download-dir", Aliases: []string{"download_dir"}, Usage: "download attachments to this directory"},
+	&cli.StringFlag{Name: "download-types", Aliases: []string{"download_types"}, Usage: "only download attachments of these MIME types (comma separated, e.g. image/*,application/pdf)"},
+	&cli.StringFlag{Name: "download-max-size", Aliases: []string{"download_max_size"}, Usage: "only download attachments up to this size (e.g. 10M)"},
+	&cli.BoolFlag{Name: "notify", Aliases: []string{"n"}, EnvVars: []string{"NTFY_NOTIFY"}, Usage: "show a desktop notification instead of printing the message"},
 )
 
 var cmdSubscribe = &cli.Command{
-	Name:      "subscribe",
-	Aliases:   []string{"sub"},
-	Usage:     "Subscribe to one or more topics on a ntfy server",
-	UsageText: "ntfy subscribe [OPTIONS..] [TOPIC]",
-	Action:    execSubscribe,
-	Category:  categoryClient,
-	Flags:     flagsSubscribe,
-	Before:    initLogFunc,
+	Name:         "subscribe",
+	Aliases:      []string{"sub"},
+	Usage:        "Subscribe to one or more topics on a ntfy server",
+	UsageText:    "ntfy subscribe [OPTIONS..] [TOPIC]",
+	Action:       execSubscribe,
+	Category:     categoryClient,
+	Flags:        flagsSubscribe,
+	Before:       initLogFunc,
+	BashComplete: completeClientCommand,
 	Description: `Subscribe to a topic from a ntfy server, and either print or execute a command for 
-every arriving message. There are 3 modes in which the command can be run:
+every arriving message. There are 4 modes in which the command can be run:
 
 ntfy subscribe TOPIC
   This prints the JSON representation of every incoming message. It is useful when you
@@ -73,10 +80,29 @@ ntfy subscribe TOPIC COMMAND
     $NTFY_PRIORITY  $priority, $prio, $p  Message priority (1=min, 5=max)
     $NTFY_TAGS      $tags, $tag, $ta      Message tags (comma separated list)
     $NTFY_RAW       $raw                  Raw JSON message
+    $NTFY_MESSAGE_JSON                    Raw JSON message (same as $NTFY_RAW)
+
+  If --stdin-json is passed, the JSON message (incl. attachment and actions) is also written
+  to the command's stdin, followed by a newline.
+
+  With --download-dir, attachments are downloaded to the given directory before the message is
+  printed or the command is executed. Use --download-types and --download-max-size to only
+  download some attachments. Subscriptions in the config file can define a "download:" block.
 
   Examples:
     ntfy sub mytopic 'notify-send "$m"'    # Execute command for incoming messages
     ntfy sub topic1 myscript.sh            # Execute script for incoming messages
+    ntfy sub --stdin-json topic1 'jq -r .attachment.url'  # Read the JSON message from stdin
+    ntfy sub --download-dir ~/inbox --download-types 'image/*' photos  # Download images to ~/inbox
+
+ntfy subscribe --notify TOPIC
+  This shows a native desktop notification for every incoming message (via notify-send
+  on Linux, terminal-notifier or osascript on macOS, and toast notifications on Windows),
+  including the click URL, action buttons and image attachments (where supported).
+
+  Examples:
+    ntfy sub --notify mytopic               # Show desktop notifications for ntfy.sh/mytopic
+    ntfy sub --notify --from-config         # Show notifications for all subscriptions without command
 
 ntfy subscribe --from-config
   Service mode (used in ntfy-client.service). This reads the config file and sets up 
@@ -102,12 +128,19 @@ func execSubscribe(c *cli.Context) error {
 	poll := c.Bool("poll")
 	scheduled := c.Bool("scheduled")
 	fromConfig := c.Bool("from-config")
+	notify := c.Bool("notify")
 	topic := c.Args().Get(0)
 	command := c.Args().Get(1)
 
 	// Checks
 	if user != "" && token != "" {
 		return errors.New("cannot set both --user and --token")
+	} else if notify && command != "" {
+		return errors.New("cannot set both a command and --notify")
+	} else if notify {
+		if err := checkDesktopNotifyFunc(); err != nil {
+			return err
+		}
 	}
 
 	if !fromConfig {
@@ -159,32 +192,46 @@ func doPoll(c *cli.Context, cl *client.Client, conf *client.Config, topic, comma
 		if auth := maybeAddAuthHeader(s, conf); auth != nil {
 			options = append(options, auth)
 		}
-		if err := doPollSingle(c, cl, s.Topic, s.Command, options...); err != nil {
+		downloader, err := newAttachmentDownloader(s.Download)
+		if err != nil {
+			return err
+		}
+		if err := doPollSingle(c, cl, subscribeTopic(s), s.Command, downloader, options...); err != nil {
 			return err
 		}
 	}
 	if topic != "" {
-		if err := doPollSingle(c, cl, topic, command, options...); err != nil {
+		downloader, err := newAttachmentDownloader(downloadFromFlags(c))
+		if err != nil {
+			return err
+		}
+		if err := doPollSingle(c, cl, topic, command, downloader, options...); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func doPollSingle(c *cli.Context, cl *client.Client, topic, command string, options ...client.SubscribeOption) error {
+func doPollSingle(c *cli.Context, cl *client.Client, topic, command string, downloader *attachmentDownloader, options ...client.SubscribeOption) error {
 	messages, err := cl.Poll(topic, options...)
 	if err != nil {
 		return err
 	}
 	for _, m := range messages {
+		downloader.maybeDownload(m)
 		printMessageOrRunCommand(c, m, command)
 	}
 	return nil
 }
 
 func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic, command string, options ...client.SubscribeOption) error {
-	cmds := make(map[string]string)    // Subscription ID -> command
-	for _, s := range conf.Subscribe { // May be nil
+	cmds := make(map[string]string)                       // Subscription ID -> command
+	downloaders := make(map[string]*attachmentDownloader) // Subscription ID -> downloader, may be nil
+	for _, s := range conf.Subscribe {                    // May be nil
+		downloader, err := newAttachmentDownloader(s.Download)
+		if err != nil {
+			return err
+		}
 		topicOptions := append(make([]client.SubscribeOption, 0), options...)
 		for filter, value := range s.If {
 			topicOptions = append(topicOptions, client.WithFilter(filter, value))
@@ -194,10 +241,11 @@ func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic,
 			topicOptions = append(topicOptions, auth)
 		}
 
-		subscriptionID, err := cl.Subscribe(s.Topic, topicOptions...)
+		subscriptionID, err := cl.Subscribe(subscribeTopic(s), topicOptions...)
 		if err != nil {
 			return err
 		}
+		downloaders[subscriptionID] = downloader
 		if s.Command != "" {
 			cmds[subscriptionID] = s.Command
 		} else if conf.DefaultCommand != "" {
@@ -207,11 +255,16 @@ func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic,
 		}
 	}
 	if topic != "" {
+		downloader, err := newAttachmentDownloader(downloadFromFlags(c))
+		if err != nil {
+			return err
+		}
 		subscriptionID, err := cl.Subscribe(topic, options...)
 		if err != nil {
 			return err
 		}
 		cmds[subscriptionID] = command
+		downloaders[subscriptionID] = downloader
 	}
 	for m := range cl.Messages {
 		cmd, ok := cmds[m.SubscriptionID]
@@ -219,11 +272,33 @@ func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic,
 			continue
 		}
 		log.Debug("%s Dispatching received message: %s", logMessagePrefix(m), m.Raw)
+		downloaders[m.SubscriptionID].maybeDownload(m)
 		printMessageOrRunCommand(c, m, cmd)
 	}
 	return nil
 }
 
+// subscribeTopic returns the topic of a subscription from the config file. If the subscription has its own host,
+// a short topic name is expanded using that host instead of the default host.
+func subscribeTopic(s client.Subscribe) string {
+	if s.Host != "" && !strings.Contains(s.Topic, "/") {
+		return fmt.Sprintf("%s/%s", strings.TrimSuffix(s.Host, "/"), s.Topic)
+	}
+	return s.Topic
+}
+
+// downloadFromFlags returns the download rules passed via --download-dir and friends, or nil if not set
+func downloadFromFlags(c *cli.Context) *client.Download {
+	if c.String("download-dir") == "" {
+		return nil
+	}
+	return &client.Download{
+		Dir:     c.String("download-dir"),
+		Types:   util.SplitNoEmpty(c.String("download-types"), ","),
+		MaxSize: c.String("download-max-size"),
+	}
+}
+
 func maybeAddAuthHeader(s client.Subscribe, conf *client.Config) client.SubscribeOption {
 	// if an explicit empty token or empty user:pass is given, exit without auth
 	if (s.Token != nil && *s.Token == "") || (s.User != nil && *s.User == "" && s.Password != nil && *s.Password == "") {
@@ -251,6 +326,8 @@ func maybeAddAuthHeader(s client.Subscribe, conf *client.Config) client.Subscrib
 func printMessageOrRunCommand(c *cli.Context, m *client.Message, command string) {
 	if command != "" {
 		runCommand(c, command, m)
+	} else if c.Bool("notify") {
+		notifyDesktop(m)
 	} else {
 		log.Debug("%s Printing raw message", logMessagePrefix(m))
 		fmt.Fprintln(c.App.Writer, m.Raw)
@@ -273,7 +350,11 @@ func runCommandInternal(c *cli.Context, script string, m *client.Message) error
 	defer os.Remove(scriptFile)
 	log.Debug("%s Executing script %s", logMessagePrefix(m), scriptFile)
 	cmd := exec.Command(scriptLauncher[0], append(scriptLauncher[1:], scriptFile)...)
-	cmd.Stdin = c.App.Reader
+	if c.Bool("stdin-json") {
+		cmd.Stdin = strings.NewReader(m.Raw + "\n")
+	} else {
+		cmd.Stdin = c.App.Reader
+	}
 	cmd.Stdout = c.App.Writer
 	cmd.Stderr = c.App.ErrWriter
 	cmd.Env = envVars(m)
@@ -289,7 +370,7 @@ func envVars(m *client.Message) []string {
 	env = append(env, envVar(m.Title, "NTFY_TITLE", "title", "t")...)
 	env = append(env, envVar(fmt.Sprintf("%d", m.Priority), "NTFY_PRIORITY", "priority", "prio", "p")...)
 	env = append(env, envVar(strings.Join(m.Tags, ","), "NTFY_TAGS", "tags", "tag", "ta")...)
-	env = append(env, envVar(m.Raw, "NTFY_RAW", "raw")...)
+	env = append(env, envVar(m.Raw, "NTFY_RAW", "raw", "NTFY_MESSAGE_JSON")...)
 	sort.Strings(env)
 	if log.IsTrace() {
 		log.Trace("%s With environment:\n%s", logMessagePrefix(m), strings.Join(env, "\n"))
@@ -305,7 +386,24 @@ func envVar(value string, vars ...string) []string {
 	return env
 }
 
+// loadConfig loads the client config, and reads the credentials that are stored in the OS keychain
+// (unless --no-keychain is passed)
 func loadConfig(c *cli.Context) (*client.Config, error) {
+	conf, err := loadConfigFile(c)
+	if err != nil {
+		return nil, err
+	}
+	var keychain client.Keychain
+	if !c.Bool("no-keychain") {
+		keychain = newKeychain()
+	}
+	if err := conf.ResolveKeychainSecrets(keychain); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func loadConfigFile(c *cli.Context) (*client.Config, error) {
 	filename := c.String("config")
 	if filename != "" {
 		return client.LoadConfig(filename)
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	downloadTimeout = 5 * time.Minute
+)
+
+// attachmentDownloader downloads the attachments of a subscription to a directory, if they match the
+// configured types and size (see client.Download)
+type attachmentDownloader struct {
+	dir     string
+	types   []string
+	maxSize int64 // 0 means no limit
+}
+
+func newAttachmentDownloader(d *client.Download) (*attachmentDownloader, error) {
+	if d == nil || d.Dir == "" {
+		return nil, nil
+	}
+	if stat, err := os.Stat(d.Dir); err != nil || !stat.IsDir() {
+		return nil, fmt.Errorf("download directory %s does not exist", d.Dir)
+	}
+	var maxSize int64
+	if d.MaxSize != "" {
+		size, err := util.ParseSize(d.MaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid download max size %s: %s", d.MaxSize, err.Error())
+		}
+		maxSize = size
+	}
+	types := make([]string, 0)
+	for _, t := range d.Types {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			types = append(types, t)
+		}
+	}
+	return &attachmentDownloader{
+		dir:     d.Dir,
+		types:   types,
+		maxSize: maxSize,
+	}, nil
+}
+
+// maybeDownload downloads the attachment of the message, if it matches the rules. Failures are logged, since
+// they should not stop the subscription.
+func (d *attachmentDownloader) maybeDownload(m *client.Message) {
+	if d == nil || m.Attachment == nil {
+		return
+	} else if !d.matches(m.Attachment) {
+		log.Debug("%s Not downloading attachment %s, type or size do not match", logMessagePrefix(m), m.Attachment.Name)
+		return
+	}
+	filename, err := d.download(m)
+	if err != nil {
+		log.Warn("%s Downloading attachment failed: %s", logMessagePrefix(m), err.Error())
+		return
+	}
+	log.Info("%s Downloaded attachment to %s", logMessagePrefix(m), filename)
+}
+
+func (d *attachmentDownloader) matches(a *client.Attachment) bool {
+	if d.maxSize > 0 && a.Size > d.maxSize {
+		return false
+	} else if a.Expires > 0 && a.Expires < time.Now().Unix() {
+		return false
+	} else if len(d.types) == 0 {
+		return true
+	}
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(a.Type, ";")[0]))
+	for _, t := range d.types {
+		if t == "*" || t == "*/*" || t == contentType {
+			return true
+		} else if strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// download downloads the attachment to a temporary file in the download directory, and renames it once it is
+// complete, so that other programs watching the directory never see partial files
+func (d *attachmentDownloader) download(m *client.Message) (string, error) {
+	httpClient := &http.Client{Timeout: downloadTimeout}
+	resp, err := httpClient.Get(m.Attachment.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	file, err := os.CreateTemp(d.dir, ".ntfy-download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name()) // No-op after rename
+	var body io.Reader = resp.Body
+	if d.maxSize > 0 {
+		body = io.LimitReader(resp.Body, d.maxSize+1)
+	}
+	n, err := io.Copy(file, body)
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	if err != nil {
+		return "", err
+	} else if d.maxSize > 0 && n > d.maxSize {
+		return "", errors.New("attachment larger than download max size")
+	}
+	filename := d.filename(m)
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// filename returns the target filename of the attachment. If a file with the attachment name already exists,
+// the message ID is appended to the name.
+func (d *attachmentDownloader) filename(m *client.Message) string {
+	name := filepath.Base(filepath.Clean("/" + m.Attachment.Name))
+	if name == "/" || name == "." {
+		name = m.ID
+	} else if strings.HasPrefix(name, ".") {
+		name = m.ID + name // Do not create hidden files
+	}
+	filename := filepath.Join(d.dir, name)
+	if util.FileExists(filename) {
+		ext := filepath.Ext(name)
+		filename = filepath.Join(d.dir, fmt.Sprintf("%s-%s%s", strings.TrimSuffix(name, ext), m.ID, ext))
+	}
+	return filename
+}
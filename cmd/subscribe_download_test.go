@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/test"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCLI_Subscribe_Download_Poll(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("contents of " + r.URL.Path))
+	}))
+	defer ts.Close()
+
+	cl := client.New(client.NewConfig())
+	m1, err := cl.Publish(topic, "first", client.WithAttach(ts.URL+"/report.pdf"))
+	require.Nil(t, err)
+	m2, err := cl.Publish(topic, "second", client.WithAttach(ts.URL+"/report.pdf"))
+	require.Nil(t, err)
+	_, err = cl.Publish(topic, "no attachment")
+	require.Nil(t, err)
+
+	dir := t.TempDir()
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--download-dir", dir, topic}))
+	require.Equal(t, 3, len(strings.Split(strings.TrimSpace(stdout.String()), "\n")))
+
+	b, err := os.ReadFile(filepath.Join(dir, "report.pdf"))
+	require.Nil(t, err)
+	require.Equal(t, "contents of /report.pdf", string(b))
+	require.FileExists(t, filepath.Join(dir, "report-"+m2.ID+".pdf"))
+	require.NoFileExists(t, filepath.Join(dir, "report-"+m1.ID+".pdf"))
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(entries))
+}
+
+func TestCLI_Subscribe_Download_DirNotFound(t *testing.T) {
+	app, _, _, _ := newTestApp()
+	err := app.Run([]string{"ntfy", "subscribe", "--poll", "--download-dir", filepath.Join(t.TempDir(), "nope"), "mytopic"})
+	require.ErrorContains(t, err, "does not exist")
+}
+
+func TestAttachmentDownloader_Matches(t *testing.T) {
+	d, err := newAttachmentDownloader(&client.Download{
+		Dir:     t.TempDir(),
+		Types:   []string{"image/*", " Application/PDF "},
+		MaxSize: "1k",
+	})
+	require.Nil(t, err)
+	require.True(t, d.matches(&client.Attachment{Type: "image/png", Size: 100}))
+	require.True(t, d.matches(&client.Attachment{Type: "application/pdf; charset=utf-8", Size: 1024}))
+	require.False(t, d.matches(&client.Attachment{Type: "image/png", Size: 1025}))
+	require.False(t, d.matches(&client.Attachment{Type: "text/plain", Size: 100}))
+	require.False(t, d.matches(&client.Attachment{Type: "image/png", Size: 100, Expires: time.Now().Add(-time.Minute).Unix()}))
+
+	d, err = newAttachmentDownloader(&client.Download{Dir: t.TempDir()})
+	require.Nil(t, err)
+	require.True(t, d.matches(&client.Attachment{Type: "text/plain", Size: 100000}))
+
+	d, err = newAttachmentDownloader(nil)
+	require.Nil(t, err)
+	require.Nil(t, d)
+	d.maybeDownload(&client.Message{Attachment: &client.Attachment{}}) // Must not panic
+
+	_, err = newAttachmentDownloader(&client.Download{Dir: t.TempDir(), MaxSize: "lots"})
+	require.ErrorContains(t, err, "invalid download max size")
+}
+
+func TestAttachmentDownloader_MaxSizeExceeded(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 2000)))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	d, err := newAttachmentDownloader(&client.Download{Dir: dir, MaxSize: "1k"})
+	require.Nil(t, err)
+	d.maybeDownload(&client.Message{ID: "abc", Attachment: &client.Attachment{Name: "../../big.txt", URL: ts.URL}}) // Size unknown
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(entries)) // No partial or temporary files
+	require.Equal(t, filepath.Join(dir, "big.txt"), d.filename(&client.Message{ID: "abc", Attachment: &client.Attachment{Name: "../../big.txt"}}))
+	require.Equal(t, filepath.Join(dir, "abc.bashrc"), d.filename(&client.Message{ID: "abc", Attachment: &client.Attachment{Name: ".bashrc"}}))
+}
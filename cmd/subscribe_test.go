@@ -415,3 +415,58 @@ subscribe:
 
 	require.Equal(t, message, strings.TrimSpace(stdout.String()))
 }
+
+func TestCLI_Subscribe_Command_Stdin_JSON(t *testing.T) {
+	message := `{"id":"RXIQBFaieLVr","time":124,"expires":1124,"event":"message","topic":"mytopic","message":"triggered","attachment":{"name":"flower.jpg","url":"https://example.com/flower.jpg"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(message))
+	}))
+	defer server.Close()
+	stdinFile := filepath.Join(t.TempDir(), "stdin.json")
+	envFile := filepath.Join(t.TempDir(), "env.json")
+	command := fmt.Sprintf(`cat > %s; echo "$NTFY_MESSAGE_JSON" > %s`, stdinFile, envFile)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("not passed to the command")
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--stdin-json", server.URL + "/mytopic", command}))
+	b, err := os.ReadFile(stdinFile)
+	require.Nil(t, err)
+	require.Equal(t, message+"\n", string(b))
+	b, err = os.ReadFile(envFile)
+	require.Nil(t, err)
+	require.Equal(t, message+"\n", string(b))
+}
+
+func TestCLI_Subscribe_From_Config_Multiple_Hosts(t *testing.T) {
+	message1 := `{"id":"RXIQBFaieLVr","time":124,"expires":1124,"event":"message","topic":"mytopic","message":"from server 1"}`
+	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/mytopic/json", r.URL.Path)
+		require.Equal(t, "Bearer tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2", r.Header.Get("Authorization"))
+		w.Write([]byte(message1))
+	}))
+	defer server1.Close()
+	message2 := `{"id":"Hs2BpDaieLVr","time":125,"expires":1125,"event":"message","topic":"othertopic","message":"from server 2"}`
+	server2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/othertopic/json", r.URL.Path)
+		require.Equal(t, "Basic cGhpbGlwcDpteXBhc3M=", r.Header.Get("Authorization"))
+		w.Write([]byte(message2))
+	}))
+	defer server2.Close()
+
+	filename := filepath.Join(t.TempDir(), "client.yml")
+	require.Nil(t, os.WriteFile(filename, []byte(fmt.Sprintf(`
+default-host: %s
+default-token: tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2
+subscribe:
+  - topic: mytopic
+  - host: %s/
+    topic: othertopic
+    user: philipp
+    password: mypass
+`, server1.URL, server2.URL)), 0600))
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--from-config", "--config=" + filename}))
+	require.Equal(t, message1+"\n"+message2, strings.TrimSpace(stdout.String()))
+}
@@ -9,6 +9,7 @@ import (
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 	"net/netip"
+	"strings"
 	"time"
 )
 
@@ -21,7 +22,7 @@ var flagsToken = append([]cli.Flag{}, flagsUser...)
 var cmdToken = &cli.Command{
 	Name:      "token",
 	Usage:     "Create, list or delete user tokens",
-	UsageText: "ntfy token [list|add|remove] ...",
+	UsageText: "ntfy token [list|add|remove|hash] ...",
 	Flags:     flagsToken,
 	Before:    initConfigFileInputSourceFunc("config", flagsToken, initLogFunc),
 	Category:  categoryServer,
@@ -30,11 +31,12 @@ var cmdToken = &cli.Command{
 			Name:      "add",
 			Aliases:   []string{"a"},
 			Usage:     "Create a new token",
-			UsageText: "ntfy token add [--expires=<duration>] [--label=..] USERNAME",
+			UsageText: "ntfy token add [--expires=<duration>] [--label=..] [--allowed-ip=<cidr>] USERNAME",
 			Action:    execTokenAdd,
 			Flags: []cli.Flag{
 				&cli.StringFlag{Name: "expires", Aliases: []string{"e"}, Value: "", Usage: "token expires after"},
 				&cli.StringFlag{Name: "label", Aliases: []string{"l"}, Value: "", Usage: "token label"},
+				&cli.StringSliceFlag{Name: "allowed-ip", Aliases: []string{"i"}, Usage: "only allow token to be used from this IP range or address (can be repeated)"},
 			},
 			Description: `Create a new user access token.
 
@@ -42,14 +44,18 @@ User access tokens can be used to publish, subscribe, or perform any other user-
 Tokens have full access, and can perform any task a user can do. They are meant to be used to 
 avoid spreading the password to various places.
 
+Tokens can be bound to one or more IP ranges (--allowed-ip). Requests with such a token from any
+other IP address are rejected, so a token that is leaked is useless outside of these networks.
+
 This is a server-only command. It directly reads from user.db as defined in the server config
 file server.yml. The command only works if 'auth-file' is properly defined.
 
 Examples:
-  ntfy token add phil                   # Create token for user phil which never expires
-  ntfy token add --expires=2d phil      # Create token for user phil which expires in 2 days
-  ntfy token add -e "tuesday, 8pm" phil # Create token for user phil which expires next Tuesday
-  ntfy token add -l backups phil        # Create token for user phil with label "backups"`,
+  ntfy token add phil                           # Create token for user phil which never expires
+  ntfy token add --expires=2d phil              # Create token for user phil which expires in 2 days
+  ntfy token add -e "tuesday, 8pm" phil         # Create token for user phil which expires next Tuesday
+  ntfy token add -l backups phil                # Create token for user phil with label "backups"
+  ntfy token add --allowed-ip 10.0.1.0/24 phil  # Create token for user phil, only usable from 10.0.1.0/24`,
 		},
 		{
 			Name:      "remove",
@@ -61,6 +67,23 @@ Examples:
 
 Example:
   ntfy token del phil tk_th2srHVlxrANQHAso5t0HuQ1J1TjN`,
+		},
+		{
+			Name:      "hash",
+			Usage:     "Generates a token and its hash for the auth-tokens config option",
+			UsageText: "ntfy token hash [TOKEN]",
+			Action:    execTokenHash,
+			Description: `Generate a new random access token, and print it along with its hash for use in the
+auth-tokens config option. If a token is passed, only its hash is printed.
+
+Provisioned tokens are configured as hash, so that the token itself never has to be written
+to the server config. Hand out the token to the client, and put the hash into the config:
+  auth-tokens:
+    - "phil:<hash>:Backup script"
+
+Examples:
+  ntfy token hash                                  # Generate a new token and its hash
+  ntfy token hash tk_th2srHVlxrANQHAso5t0HuQ1J1TjN # Print the hash of an existing token`,
 		},
 		{
 			Name:    "list",
@@ -94,6 +117,7 @@ func execTokenAdd(c *cli.Context) error {
 	username := c.Args().Get(0)
 	expiresStr := c.String("expires")
 	label := c.String("label")
+	allowedIPsStr := util.SplitNoEmpty(strings.Join(c.StringSlice("allowed-ip"), ","), ",")
 	if username == "" {
 		return errors.New("username expected, type 'ntfy token add --help' for help")
 	} else if username == userEveryone || username == user.Everyone {
@@ -107,6 +131,10 @@ func execTokenAdd(c *cli.Context) error {
 			return err
 		}
 	}
+	allowedIPs, err := user.ParseAllowedIPs(allowedIPsStr)
+	if err != nil {
+		return err
+	}
 	manager, err := createUserManager(c)
 	if err != nil {
 		return err
@@ -117,14 +145,15 @@ func execTokenAdd(c *cli.Context) error {
 	} else if err != nil {
 		return err
 	}
-	token, err := manager.CreateToken(u.ID, label, expires, netip.IPv4Unspecified())
+	token, err := manager.CreateToken(u.ID, label, expires, netip.IPv4Unspecified(), allowedIPs)
 	if err != nil {
 		return err
 	}
+	allowed := formatTokenAllowedIPs(allowedIPs)
 	if expires.Unix() == 0 {
-		fmt.Fprintf(c.App.ErrWriter, "token %s created for user %s, never expires\n", token.Value, u.Name)
+		fmt.Fprintf(c.App.ErrWriter, "token %s created for user %s, never expires%s\n", token.Value, u.Name, allowed)
 	} else {
-		fmt.Fprintf(c.App.ErrWriter, "token %s created for user %s, expires %v\n", token.Value, u.Name, expires.Format(time.UnixDate))
+		fmt.Fprintf(c.App.ErrWriter, "token %s created for user %s, expires %v%s\n", token.Value, u.Name, expires.Format(time.UnixDate), allowed)
 	}
 	return nil
 }
@@ -200,7 +229,7 @@ func execTokenList(c *cli.Context) error {
 			} else {
 				expires = fmt.Sprintf("expires %s", t.Expires.Format(time.RFC822))
 			}
-			fmt.Fprintf(c.App.ErrWriter, "- %s%s, %s, accessed from %s at %s\n", t.Value, label, expires, t.LastOrigin.String(), t.LastAccess.Format(time.RFC822))
+			fmt.Fprintf(c.App.ErrWriter, "- %s%s, %s%s, accessed from %s at %s\n", t.Value, label, expires, formatTokenAllowedIPs(t.AllowedIPs), t.LastOrigin.String(), t.LastAccess.Format(time.RFC822))
 		}
 	}
 	if usersWithTokens == 0 {
@@ -208,3 +237,27 @@ func execTokenList(c *cli.Context) error {
 	}
 	return nil
 }
+
+// formatTokenAllowedIPs returns a suffix describing the IP ranges a token is bound to, or an empty string
+func formatTokenAllowedIPs(prefixes []netip.Prefix) string {
+	if len(prefixes) == 0 {
+		return ""
+	}
+	allowedIPs := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		allowedIPs[i] = prefix.String()
+	}
+	return fmt.Sprintf(", only allowed from %s", strings.Join(allowedIPs, ", "))
+}
+
+func execTokenHash(c *cli.Context) error {
+	token := c.Args().Get(0)
+	if token == "" {
+		token = user.GenerateToken()
+		fmt.Fprintf(c.App.Writer, "token: %s\n", token)
+		fmt.Fprintf(c.App.Writer, "hash:  %s\n", user.HashToken(token))
+		return nil
+	}
+	fmt.Fprintln(c.App.Writer, user.HashToken(token))
+	return nil
+}
@@ -38,6 +38,27 @@ func TestCLI_Token_AddListRemove(t *testing.T) {
 	require.Equal(t, "no users with tokens\n", stderr.String())
 }
 
+func TestCLI_Token_AddWithAllowedIPs(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, stderr := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+	require.Contains(t, stderr.String(), "user phil added with role user")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "add", "--allowed-ip", "10.0.1.0/24", "--allowed-ip", "1.2.3.4", "phil"))
+	require.Regexp(t, `token tk_.+ created for user phil, never expires, only allowed from 10.0.1.0/24, 1.2.3.4/32`, stderr.String())
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "list", "phil"))
+	require.Regexp(t, `user phil\n- tk_.+, never expires, only allowed from 10.0.1.0/24, 1.2.3.4/32, accessed from 0.0.0.0 at .+`, stderr.String())
+
+	app, _, _, _ = newTestApp()
+	require.EqualError(t, runTokenCommand(app, conf, "add", "--allowed-ip", "not-an-ip", "phil"), "invalid IP address or range not-an-ip")
+}
+
 func runTokenCommand(app *cli.App, conf *server.Config, args ...string) error {
 	userArgs := []string{
 		"ntfy",
@@ -48,3 +69,13 @@ func runTokenCommand(app *cli.App, conf *server.Config, args ...string) error {
 	}
 	return app.Run(append(userArgs, args...))
 }
+
+func TestCLI_Token_Hash(t *testing.T) {
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "token", "hash", "tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}))
+	require.Equal(t, "90714e1a9e5ae760a283bbf13bf47898789c8941eeef34a9f039cdb1bf9bdfcd\n", stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "token", "hash"}))
+	require.Regexp(t, `^token: tk_[a-z0-9]{29}\nhash:  [0-9a-f]{64}\n$`, stdout.String())
+}
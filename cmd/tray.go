@@ -0,0 +1,196 @@
+//go:build !darwin || cgo
+
+package cmd
+
+import (
+	_ "embed" // required by go:embed
+	"fmt"
+	"fyne.io/systray"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/log"
+	"runtime"
+	"strings"
+)
+
+const (
+	trayMessageTitleLength = 60 // Recent messages are cut off after this many characters in the menu
+)
+
+var (
+	//go:embed tray_icon.png
+	trayIconPNG []byte
+
+	//go:embed tray_icon.ico
+	trayIconICO []byte
+)
+
+// trayTopicMenu holds the menu items of a single topic
+type trayTopicMenu struct {
+	item   *systray.MenuItem
+	recent []*systray.MenuItem
+}
+
+func execTray(c *cli.Context) error {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	agent, err := newTrayAgent(conf, !c.Bool("no-notify"))
+	if err != nil {
+		return err
+	} else if agent.notify {
+		if err := checkDesktopNotifyFunc(); err != nil {
+			return err
+		}
+	}
+	var trayErr error
+	systray.Run(func() {
+		if err := onTrayReady(agent); err != nil {
+			trayErr = err
+			systray.Quit()
+		}
+	}, func() {
+		log.Debug("Tray exited")
+	})
+	return trayErr
+}
+
+func onTrayReady(agent *trayAgent) error {
+	if runtime.GOOS == "windows" {
+		systray.SetIcon(trayIconICO)
+	} else {
+		systray.SetIcon(trayIconPNG)
+	}
+	systray.SetTooltip("ntfy")
+	topics := agent.snapshot()
+	menus := make([]*trayTopicMenu, len(topics))
+	for i, topic := range topics {
+		menus[i] = addTrayTopicMenu(agent, i, topic)
+	}
+	systray.AddSeparator()
+	markAllRead := systray.AddMenuItem("Mark all as read", "")
+	quit := systray.AddMenuItem("Quit", "")
+	go func() {
+		for {
+			select {
+			case <-markAllRead.ClickedCh:
+				agent.markRead(-1)
+			case <-quit.ClickedCh:
+				systray.Quit()
+				return
+			}
+		}
+	}()
+	agent.onChange = func() { refreshTrayMenu(agent, menus) }
+	refreshTrayMenu(agent, menus)
+	return agent.start()
+}
+
+func addTrayTopicMenu(agent *trayAgent, index int, topic trayTopic) *trayTopicMenu {
+	menu := &trayTopicMenu{
+		item:   systray.AddMenuItem(topic.Name, topic.Topic),
+		recent: make([]*systray.MenuItem, trayRecentMessages),
+	}
+	for i := range menu.recent {
+		menu.recent[i] = menu.item.AddSubMenuItem("", "")
+		menu.recent[i].Hide()
+		go func(i int) {
+			for range menu.recent[i].ClickedCh {
+				openTrayMessage(agent, index, i)
+			}
+		}(i)
+	}
+	publish := menu.item.AddSubMenuItem("Publish message ...", "")
+	markRead := menu.item.AddSubMenuItem("Mark as read", "")
+	open := menu.item.AddSubMenuItem("Open in browser", "")
+	go func() {
+		for {
+			select {
+			case <-publish.ClickedCh:
+				go publishFromTray(agent, index, topic.Name)
+			case <-markRead.ClickedCh:
+				agent.markRead(index)
+			case <-open.ClickedCh:
+				openURL(topic.Topic)
+				agent.markRead(index)
+			}
+		}
+	}()
+	return menu
+}
+
+func refreshTrayMenu(agent *trayAgent, menus []*trayTopicMenu) {
+	topics := agent.snapshot()
+	unread := agent.unread()
+	if unread > 0 {
+		systray.SetTooltip(fmt.Sprintf("ntfy (%d unread)", unread))
+		if runtime.GOOS == "darwin" {
+			systray.SetTitle(fmt.Sprintf("%d", unread)) // Shown next to the icon in the menu bar
+		}
+	} else {
+		systray.SetTooltip("ntfy")
+		if runtime.GOOS == "darwin" {
+			systray.SetTitle("")
+		}
+	}
+	for i, topic := range topics {
+		if topic.Unread > 0 {
+			menus[i].item.SetTitle(fmt.Sprintf("%s (%d)", topic.Name, topic.Unread))
+		} else {
+			menus[i].item.SetTitle(topic.Name)
+		}
+		for j, item := range menus[i].recent {
+			if j < len(topic.Recent) {
+				item.SetTitle(trayMessageTitle(topic.Recent[j]))
+				item.Show()
+			} else {
+				item.Hide()
+			}
+		}
+	}
+}
+
+// openTrayMessage opens the click URL of a recent message, or the topic if it has none
+func openTrayMessage(agent *trayAgent, index, recentIndex int) {
+	topics := agent.snapshot()
+	if index >= len(topics) || recentIndex >= len(topics[index].Recent) {
+		return
+	}
+	m := topics[index].Recent[recentIndex]
+	if m.Click != "" {
+		openURL(m.Click)
+	} else {
+		openURL(topics[index].Topic)
+	}
+	agent.markRead(index)
+}
+
+func publishFromTray(agent *trayAgent, index int, name string) {
+	cmd, err := promptCommand(fmt.Sprintf("Message to %s:", name))
+	if err != nil {
+		log.Warn("Cannot publish from tray: %s", err.Error())
+		return
+	}
+	output, err := cmd.Output()
+	message := strings.TrimSpace(string(output))
+	if err != nil || message == "" {
+		return // Canceled
+	}
+	if _, err := agent.publish(index, message); err != nil {
+		log.Warn("%s Publishing from tray failed: %s", name, err.Error())
+		notifyDesktop(&client.Message{Topic: name, Title: "Publishing failed", Message: err.Error(), Priority: 4})
+	}
+}
+
+// trayMessageTitle returns a single line summary of the message for the menu
+func trayMessageTitle(m *client.Message) string {
+	title := strings.Join(strings.Fields(m.Message), " ")
+	if m.Title != "" {
+		title = fmt.Sprintf("%s: %s", m.Title, title)
+	}
+	if r := []rune(title); len(r) > trayMessageTitleLength {
+		title = string(r[:trayMessageTitleLength-3]) + "..."
+	}
+	return title
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"sync"
+)
+
+func init() {
+	commands = append(commands, cmdTray)
+}
+
+const (
+	trayRecentMessages = 5 // Number of recent messages shown per topic in the tray menu
+)
+
+var flagsTray = append(
+	append([]cli.Flag{}, flagsDefault...),
+	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, Usage: "client config file"},
+	flagNoKeychain,
+	&cli.BoolFlag{Name: "no-notify", Aliases: []string{"no_notify"}, EnvVars: []string{"NTFY_NO_NOTIFY"}, Usage: "do not show desktop notifications for incoming messages"},
+)
+
+var cmdTray = &cli.Command{
+	Name:      "tray",
+	Usage:     "Show an icon in the system tray for the subscriptions in the config file",
+	UsageText: "ntfy tray [OPTIONS..]",
+	Action:    execTray, // See tray.go
+	Category:  categoryClient,
+	Flags:     flagsTray,
+	Before:    initLogFunc,
+	Description: `Run ntfy as a tray agent, i.e. show an icon in the system tray (or the macOS menu bar),
+and subscribe to all topics in the "subscribe:" block of the config file.
+
+For every incoming message, a desktop notification is shown (see "ntfy subscribe --notify"),
+unless --no-notify is passed. The tray menu shows the number of unread messages and the most
+recent messages per topic, and lets you publish a message to any of the topics.
+
+On Linux, the tray icon requires a desktop environment that supports StatusNotifierItem
+(e.g. KDE, or GNOME with the AppIndicator extension), and publishing requires zenity or kdialog.
+
+Examples:
+  ntfy tray                            # Subscribe to topics in the default config file
+  ntfy tray --config=myclient.yml      # Subscribe to topics in an alternate config file
+
+` + clientCommandDescriptionSuffix,
+}
+
+// trayAgent manages the subscriptions of the tray icon, and keeps track of unread messages. It is independent
+// of the actual tray menu (see tray.go), which calls onChange to refresh itself when messages arrive.
+type trayAgent struct {
+	client   *client.Client
+	topics   []*trayTopic          // In the order of the config file
+	bySubID  map[string]*trayTopic // Subscription ID -> topic
+	notify   bool                  // Show a desktop notification for every message
+	onChange func()
+	mu       sync.Mutex
+}
+
+// trayTopic is a single subscription in the tray menu
+type trayTopic struct {
+	Name    string // Short topic URL, e.g. ntfy.sh/mytopic
+	Topic   string // Full topic URL
+	Unread  int
+	Recent  []*client.Message // Most recent message first
+	filters map[string]string
+	options []client.RequestOption
+}
+
+func newTrayAgent(conf *client.Config, notify bool) (*trayAgent, error) {
+	if len(conf.Subscribe) == 0 {
+		return nil, errors.New("no subscriptions found in config file, add topics to the \"subscribe:\" block")
+	}
+	cl := client.New(conf)
+	agent := &trayAgent{
+		client:   cl,
+		topics:   make([]*trayTopic, 0),
+		bySubID:  make(map[string]*trayTopic),
+		notify:   notify,
+		onChange: func() {},
+	}
+	for _, s := range conf.Subscribe {
+		topicURL, err := cl.ExpandTopicURL(subscribeTopic(s))
+		if err != nil {
+			return nil, err
+		}
+		options := make([]client.RequestOption, 0)
+		if auth := maybeAddAuthHeader(s, conf); auth != nil {
+			options = append(options, auth)
+		}
+		agent.topics = append(agent.topics, &trayTopic{
+			Name:    util.ShortTopicURL(topicURL),
+			Topic:   topicURL,
+			Recent:  make([]*client.Message, 0),
+			filters: s.If,
+			options: options,
+		})
+	}
+	return agent, nil
+}
+
+// start subscribes to all topics, and processes incoming messages in the background
+func (a *trayAgent) start() error {
+	for _, s := range a.topics {
+		options := append(make([]client.SubscribeOption, 0), s.options...)
+		for filter, value := range s.filters {
+			options = append(options, client.WithFilter(filter, value))
+		}
+		subscriptionID, err := a.client.Subscribe(s.Topic, options...)
+		if err != nil {
+			return err
+		}
+		a.mu.Lock()
+		a.bySubID[subscriptionID] = s
+		a.mu.Unlock()
+	}
+	go func() {
+		for m := range a.client.Messages {
+			a.received(m)
+		}
+	}()
+	return nil
+}
+
+// received records the message as unread, and shows a desktop notification (if enabled)
+func (a *trayAgent) received(m *client.Message) {
+	a.mu.Lock()
+	topic, ok := a.bySubID[m.SubscriptionID]
+	if !ok {
+		a.mu.Unlock()
+		return
+	}
+	topic.Unread++
+	topic.Recent = append([]*client.Message{m}, topic.Recent...)
+	if len(topic.Recent) > trayRecentMessages {
+		topic.Recent = topic.Recent[:trayRecentMessages]
+	}
+	a.mu.Unlock()
+	log.Debug("%s Received message in tray", logMessagePrefix(m))
+	if a.notify {
+		notifyDesktop(m)
+	}
+	a.onChange()
+}
+
+// unread returns the total number of unread messages
+func (a *trayAgent) unread() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	total := 0
+	for _, t := range a.topics {
+		total += t.Unread
+	}
+	return total
+}
+
+// markRead marks all messages of the topic with the given index as read, or all topics if index is -1
+func (a *trayAgent) markRead(index int) {
+	a.mu.Lock()
+	for i, t := range a.topics {
+		if index == -1 || index == i {
+			t.Unread = 0
+		}
+	}
+	a.mu.Unlock()
+	a.onChange()
+}
+
+// snapshot returns a copy of the topics, so that the menu can be rendered without holding the lock
+func (a *trayAgent) snapshot() []trayTopic {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	topics := make([]trayTopic, len(a.topics))
+	for i, t := range a.topics {
+		topics[i] = *t
+		topics[i].Recent = append(make([]*client.Message, 0), t.Recent...)
+	}
+	return topics
+}
+
+// publish publishes a message to the topic with the given index, using the credentials of the subscription
+func (a *trayAgent) publish(index int, message string) (*client.Message, error) {
+	if index < 0 || index >= len(a.topics) {
+		return nil, errors.New("invalid topic")
+	}
+	topic := a.topics[index]
+	return a.client.Publish(topic.Topic, message, topic.options...)
+}
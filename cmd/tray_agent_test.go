@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/test"
+	"testing"
+	"time"
+)
+
+func TestTrayAgent_UnreadAndPublish(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+
+	conf := client.NewConfig()
+	conf.DefaultHost = fmt.Sprintf("http://127.0.0.1:%d", port)
+	conf.Subscribe = []client.Subscribe{
+		{Topic: "topic1"},
+		{Topic: "topic2", If: map[string]string{"priority": "high,urgent"}},
+	}
+	agent, err := newTrayAgent(conf, false)
+	require.Nil(t, err)
+	changes := make(chan struct{}, 10)
+	agent.onChange = func() { changes <- struct{}{} }
+	require.Nil(t, agent.start())
+	time.Sleep(300 * time.Millisecond) // Wait for subscriptions to be established
+
+	topics := agent.snapshot()
+	require.Equal(t, 2, len(topics))
+	require.Equal(t, "127.0.0.1:"+fmt.Sprint(port)+"/topic1", topics[0].Name)
+
+	_, err = agent.publish(0, "first")
+	require.Nil(t, err)
+	_, err = agent.publish(0, "second")
+	require.Nil(t, err)
+	_, err = agent.publish(1, "filtered out")
+	require.Nil(t, err)
+	<-changes
+	<-changes
+
+	require.Eventually(t, func() bool { return agent.unread() == 2 }, 5*time.Second, 50*time.Millisecond)
+	topics = agent.snapshot()
+	require.Equal(t, 2, topics[0].Unread)
+	require.Equal(t, "second", topics[0].Recent[0].Message)
+	require.Equal(t, "first", topics[0].Recent[1].Message)
+	require.Equal(t, 0, topics[1].Unread)
+
+	agent.markRead(0)
+	require.Equal(t, 0, agent.unread())
+	require.Equal(t, 2, len(agent.snapshot()[0].Recent))
+
+	_, err = agent.publish(5, "invalid")
+	require.Error(t, err)
+}
+
+func TestTrayAgent_RecentLimit(t *testing.T) {
+	conf := client.NewConfig()
+	conf.Subscribe = []client.Subscribe{{Topic: "mytopic"}}
+	agent, err := newTrayAgent(conf, false)
+	require.Nil(t, err)
+	agent.bySubID["sub1"] = agent.topics[0]
+	for i := 0; i < trayRecentMessages+3; i++ {
+		agent.received(&client.Message{ID: fmt.Sprint(i), SubscriptionID: "sub1", Message: fmt.Sprintf("message %d", i)})
+	}
+	agent.received(&client.Message{ID: "x", SubscriptionID: "unknown"})
+
+	topics := agent.snapshot()
+	require.Equal(t, trayRecentMessages+3, topics[0].Unread)
+	require.Equal(t, trayRecentMessages, len(topics[0].Recent))
+	require.Equal(t, fmt.Sprintf("message %d", trayRecentMessages+2), topics[0].Recent[0].Message)
+}
+
+func TestCLI_Tray_NoSubscriptions(t *testing.T) {
+	app, _, _, _ := newTestApp()
+	err := app.Run([]string{"ntfy", "tray", "--config", "/dev/null"})
+	require.ErrorContains(t, err, "no subscriptions found in config file")
+}
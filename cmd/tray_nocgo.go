@@ -0,0 +1,12 @@
+//go:build darwin && !cgo
+
+package cmd
+
+import (
+	"errors"
+	"github.com/urfave/cli/v2"
+)
+
+func execTray(c *cli.Context) error {
+	return errors.New("ntfy tray is not available in this build, since it requires cgo on macOS (build with CGO_ENABLED=1)")
+}
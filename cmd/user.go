@@ -12,6 +12,7 @@ import (
 
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
+	"golang.org/x/crypto/bcrypt"
 	"heckel.io/ntfy/v2/util"
 )
 
@@ -33,7 +34,7 @@ var flagsUser = append(
 var cmdUser = &cli.Command{
 	Name:      "user",
 	Usage:     "Manage/show users",
-	UsageText: "ntfy user [list|add|remove|change-pass|change-role] ...",
+	UsageText: "ntfy user [list|add|remove|change-pass|change-role|export|import] ...",
 	Flags:     flagsUser,
 	Before:    initConfigFileInputSourceFunc("config", flagsUser, initLogFunc),
 	Category:  categoryServer,
@@ -131,6 +132,65 @@ as messages per day, attachment file sizes, etc.
 Example:
   ntfy user change-tier phil pro   # Change tier to "pro" for user "phil"  
   ntfy user change-tier phil -     # Remove tier from user "phil" entirely 
+`,
+		},
+		{
+			Name:      "hash",
+			Usage:     "Generates a password hash for a user",
+			UsageText: "ntfy user hash\nNTFY_PASSWORD=... ntfy user hash",
+			Action:    execUserHash,
+			Description: `Generate a bcrypt password hash for use in the auth-users config option.
+
+The password will be read from STDIN, and it'll be confirmed by typing it twice. The
+resulting hash can be used to pre-provision users in the server config, without having
+to run 'ntfy user add' on the server.
+
+Example:
+  ntfy user hash
+  NTFY_PASSWORD=... ntfy user hash
+
+You may set the NTFY_PASSWORD environment variable to pass the password. This is useful if
+you are generating hashes via scripts.
+`,
+		},
+		{
+			Name:      "export",
+			Usage:     "Exports all users and groups, including password hashes and tokens, as YAML or JSON",
+			UsageText: "ntfy user export [--format=yaml|json] [FILE]",
+			Action:    execUserExport,
+			Flags:     flagsAuthExport,
+			Description: `Exports all users (name, bcrypt password hash, role, tier, disabled state and access tokens) and
+all groups (name and members) as YAML or JSON.
+
+The export can be used for backups, for reviewing the users of a server, or to replicate users to a
+standby server via 'ntfy user import'. If FILE is not given, the export is written to stdout. Access
+control entries can be exported via 'ntfy access export'.
+
+Be careful with the export file, since it contains the password hashes and tokens of all users. If FILE is given, 
+it is created with restrictive permissions (0600).
+
+Examples:
+  ntfy user export                       # Print all users as YAML
+  ntfy user export users.yml             # Write all users to users.yml
+  ntfy user export --format=json -       # Print all users as JSON
+`,
+		},
+		{
+			Name:      "import",
+			Usage:     "Imports users and groups from a YAML or JSON file created by 'ntfy user export'",
+			UsageText: "ntfy user import [FILE]",
+			Action:    execUserImport,
+			Description: `Imports users and groups from a YAML or JSON file created by 'ntfy user export'.
+
+Users that do not exist yet are created. Existing users are updated to match the file (password hash,
+role, tier and disabled state), and their tokens are added or updated. Groups are created if needed, and
+their members are changed to match the file. Users, tokens and groups that are not in the file are left
+untouched. Tiers must exist before users can be assigned to them. If FILE is not given, the users are
+read from stdin.
+
+Examples:
+  ntfy user import users.yml                       # Import users from users.yml
+  ntfy user export | ssh standby ntfy user import  # Replicate users to a standby server
 `,
 		},
 		{
@@ -165,6 +225,9 @@ Examples:
   ntfy user change-pass phil                   # Change password for user phil
   NTFY_PASSWORD=.. ntfy user change-pass phil  # As above, using env variable to set password (for scripts)
   ntfy user change-role phil admin             # Make user phil an admin 
+  ntfy user hash                               # Generate a password hash for the auth-users config option
+  ntfy user export users.yml                   # Export all users (including password hashes) to users.yml
+  ntfy user import users.yml                   # Import/update users from users.yml
 
 For the 'ntfy user add' and 'ntfy user change-pass' commands, you may set the NTFY_PASSWORD environment
 variable to pass the new password. This is useful if you are creating/updating users via scripts.
@@ -337,6 +400,23 @@ func createUserManager(c *cli.Context) (*user.Manager, error) {
 	return user.NewManager(authFile, authStartupQueries, authDefault, user.DefaultUserPasswordBcryptCost, user.DefaultUserStatsQueueWriterInterval)
 }
 
+func execUserHash(c *cli.Context) error {
+	password := os.Getenv("NTFY_PASSWORD")
+	if password == "" {
+		p, err := readPasswordAndConfirm(c)
+		if err != nil {
+			return err
+		}
+		password = p
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), user.DefaultUserPasswordBcryptCost)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.App.Writer, string(hash))
+	return nil
+}
+
 func readPasswordAndConfirm(c *cli.Context) (string, error) {
 	fmt.Fprint(c.App.ErrWriter, "password: ")
 	password, err := util.ReadPassword(c.App.Reader)
@@ -3,11 +3,13 @@ package cmd
 import (
 	"github.com/stretchr/testify/require"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/bcrypt"
 	"heckel.io/ntfy/v2/server"
 	"heckel.io/ntfy/v2/test"
 	"heckel.io/ntfy/v2/user"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -21,6 +23,14 @@ func TestCLI_User_Add(t *testing.T) {
 	require.Contains(t, stderr.String(), "user phil added with role user")
 }
 
+func TestCLI_User_Hash(t *testing.T) {
+	app, stdin, stdout, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, app.Run([]string{"ntfy", "user", "hash"}))
+	hash := strings.TrimSpace(stdout.String())
+	require.Nil(t, bcrypt.CompareHashAndPassword([]byte(hash), []byte("mypass")))
+}
+
 func TestCLI_User_Add_Exists(t *testing.T) {
 	s, conf, port := newTestServerWithAuth(t)
 	defer test.StopServer(t, s, port)
@@ -0,0 +1,459 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands = append(commands, cmdWatch)
+}
+
+const (
+	watchLineLimit = 64 * 1024 // Lines longer than this are split into multiple lines
+	watchReadSize  = 32 * 1024
+)
+
+var flagsWatch = append(
+	append([]cli.Flag{}, flagsDefault...),
+	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG"}, Usage: "client config file"},
+	flagNoKeychain,
+	&cli.StringFlag{Name: "title", Aliases: []string{"t"}, EnvVars: []string{"NTFY_TITLE"}, Usage: "message title"},
+	&cli.StringFlag{Name: "priority", Aliases: []string{"p"}, EnvVars: []string{"NTFY_PRIORITY"}, Usage: "priority of the messages (1=min, 2=low, 3=default, 4=high, 5=max)"},
+	&cli.StringFlag{Name: "tags", Aliases: []string{"tag", "T"}, EnvVars: []string{"NTFY_TAGS"}, Usage: "comma separated list of tags and emojis"},
+	&cli.StringFlag{Name: "user", Aliases: []string{"u"}, EnvVars: []string{"NTFY_USER"}, Usage: "username[:password] used to auth against the server"},
+	&cli.StringFlag{Name: "token", Aliases: []string{"k"}, EnvVars: []string{"NTFY_TOKEN"}, Usage: "access token used to auth against the server"},
+	&cli.StringSliceFlag{Name: "match", Aliases: []string{"m"}, Usage: "only publish lines (or file names) matching this regex, may be repeated"},
+	&cli.StringSliceFlag{Name: "ignore", Aliases: []string{"i"}, Usage: "do not publish lines (or file names) matching this regex, may be repeated"},
+	&cli.DurationFlag{Name: "batch", Aliases: []string{"b"}, Usage: "collect lines for this long, and publish them as one message (e.g. 10s)"},
+	&cli.IntFlag{Name: "batch-size", Aliases: []string{"batch_size"}, Value: 50, Usage: "max. number of lines per batched message"},
+	&cli.DurationFlag{Name: "interval", Value: time.Second, Usage: "interval in which the file or directory is checked for changes"},
+	&cli.BoolFlag{Name: "from-start", Aliases: []string{"from_start"}, Usage: "publish existing lines (or files) as well, not only new ones"},
+	&cli.BoolFlag{Name: "no-upload", Aliases: []string{"no_upload"}, Usage: "when watching a directory, publish file names instead of uploading new files as attachments"},
+	&cli.BoolFlag{Name: "quiet", Aliases: []string{"q"}, EnvVars: []string{"NTFY_QUIET"}, Usage: "do not print published messages"},
+)
+
+var cmdWatch = &cli.Command{
+	Name:         "watch",
+	Usage:        "Publish new lines of a file, or new files in a directory",
+	UsageText:    "ntfy watch [OPTIONS..] PATH TOPIC",
+	Action:       execWatch,
+	Category:     categoryClient,
+	Flags:        flagsWatch,
+	Before:       initLogFunc,
+	BashComplete: completeClientCommand,
+	Description: `Watch a file or a directory, and publish changes as messages.
+
+If PATH is a file, the file is tailed (like "tail -F"), and every new line is published
+as a message. Rotated and truncated files are picked up automatically. With --batch, lines
+are collected for the given duration, and published together as one message.
+
+If PATH is a directory, every new file in the directory is uploaded as attachment, once it
+is no longer written to. With --no-upload, only the file name is published.
+
+Lines (or file names) can be filtered with --match and --ignore, using regular expressions.
+If --match is given, at least one of the expressions must match; if --ignore is given, none
+of them may match. The path is checked for changes every second (see --interval).
+
+Examples:
+  ntfy watch /var/log/app.log mytopic                      # Publish every new line of app.log
+  ntfy watch -m 'ERROR|FATAL' /var/log/app.log alerts      # Publish only errors
+  ntfy watch -i DEBUG --batch 30s /var/log/app.log logs    # Publish all but debug lines, every 30s
+  ntfy watch -p high /srv/scans scans                      # Upload every new file in /srv/scans
+  ntfy watch --no-upload -m '\.pdf$' /srv/invoices docs    # Publish names of new PDF files
+
+` + clientCommandDescriptionSuffix,
+}
+
+// watchOptions are the options of a watcher, see newWatcher
+type watchOptions struct {
+	Path      string
+	Topic     string
+	Interval  time.Duration
+	Batch     time.Duration
+	BatchSize int
+	Match     []*regexp.Regexp
+	Ignore    []*regexp.Regexp
+	FromStart bool
+	NoUpload  bool
+	Quiet     bool
+	Options   []client.PublishOption
+}
+
+// watcher polls a file or a directory for changes, and publishes new lines or files to a topic
+type watcher struct {
+	opts       *watchOptions
+	client     *client.Client
+	out        io.Writer
+	errOut     io.Writer
+	pending    []string  // Lines (or file names) waiting to be published
+	batchStart time.Time // Time the first pending line was added
+
+	// File mode
+	file    *os.File
+	info    os.FileInfo
+	offset  int64
+	partial string // Incomplete last line, until the newline is written
+
+	// Directory mode
+	seen    map[string]bool  // Files that were published, or existed before
+	growing map[string]int64 // New files, and their size in the last check, published when the size is stable
+}
+
+func execWatch(c *cli.Context) error {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	title := c.String("title")
+	priority := c.String("priority")
+	tags := c.String("tags")
+	user := c.String("user")
+	token := c.String("token")
+	batchSize := c.Int("batch-size")
+	interval := c.Duration("interval")
+
+	// Checks
+	if c.NArg() < 2 {
+		return errors.New("must specify path and topic, type 'ntfy watch --help' for help")
+	} else if user != "" && token != "" {
+		return errors.New("cannot set both --user and --token")
+	} else if batchSize < 1 {
+		return errors.New("--batch-size must be at least 1")
+	} else if interval < 100*time.Millisecond {
+		return errors.New("--interval cannot be lower than 100ms")
+	}
+	match, err := parseWatchRegexes(c.StringSlice("match"))
+	if err != nil {
+		return fmt.Errorf("invalid --match expression: %s", err.Error())
+	}
+	ignore, err := parseWatchRegexes(c.StringSlice("ignore"))
+	if err != nil {
+		return fmt.Errorf("invalid --ignore expression: %s", err.Error())
+	}
+
+	// Publish options
+	var options []client.PublishOption
+	if title != "" {
+		options = append(options, client.WithTitle(title))
+	}
+	if priority != "" {
+		options = append(options, client.WithPriority(priority))
+	}
+	if tags != "" {
+		options = append(options, client.WithTagsList(tags))
+	}
+	if token != "" {
+		options = append(options, client.WithBearerAuth(token))
+	} else if user != "" {
+		var pass string
+		parts := strings.SplitN(user, ":", 2)
+		if len(parts) == 2 {
+			user = parts[0]
+			pass = parts[1]
+		} else {
+			fmt.Fprint(c.App.ErrWriter, "Enter Password: ")
+			p, err := util.ReadPassword(c.App.Reader)
+			if err != nil {
+				return err
+			}
+			pass = string(p)
+			fmt.Fprintf(c.App.ErrWriter, "\r%s\r", strings.Repeat(" ", 20))
+		}
+		options = append(options, client.WithBasicAuth(user, pass))
+	} else if conf.DefaultToken != "" {
+		options = append(options, client.WithBearerAuth(conf.DefaultToken))
+	} else if conf.DefaultUser != "" && conf.DefaultPassword != nil {
+		options = append(options, client.WithBasicAuth(conf.DefaultUser, *conf.DefaultPassword))
+	}
+	w, err := newWatcher(client.New(conf), c.App.Writer, c.App.ErrWriter, &watchOptions{
+		Path:      c.Args().Get(0),
+		Topic:     c.Args().Get(1),
+		Interval:  interval,
+		Batch:     c.Duration("batch"),
+		BatchSize: batchSize,
+		Match:     match,
+		Ignore:    ignore,
+		FromStart: c.Bool("from-start"),
+		NoUpload:  c.Bool("no-upload"),
+		Quiet:     c.Bool("quiet"),
+		Options:   options,
+	})
+	if err != nil {
+		return err
+	}
+	return w.run(c.Context)
+}
+
+func parseWatchRegexes(exprs []string) ([]*regexp.Regexp, error) {
+	regexes := make([]*regexp.Regexp, 0)
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		regexes = append(regexes, re)
+	}
+	return regexes, nil
+}
+
+// newWatcher creates a watcher for the given file or directory. Unless FromStart is set, existing lines and
+// files are skipped, so that only changes after the start of the watcher are published.
+func newWatcher(cl *client.Client, out, errOut io.Writer, opts *watchOptions) (*watcher, error) {
+	info, err := os.Stat(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		opts:    opts,
+		client:  cl,
+		out:     out,
+		errOut:  errOut,
+		pending: make([]string, 0),
+	}
+	if info.IsDir() {
+		w.seen = make(map[string]bool)
+		w.growing = make(map[string]int64)
+		if !opts.FromStart {
+			entries, err := os.ReadDir(opts.Path)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				w.seen[entry.Name()] = true
+			}
+		}
+	} else {
+		if err := w.openFile(); err != nil {
+			return nil, err
+		}
+		if !opts.FromStart {
+			w.offset = w.info.Size()
+		}
+	}
+	return w, nil
+}
+
+// run checks the path for changes every interval, until the context is canceled
+func (w *watcher) run(ctx context.Context) error {
+	defer w.close()
+	log.Debug("Watching %s, publishing to %s", w.opts.Path, w.opts.Topic)
+	for {
+		if err := w.check(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			w.flush()
+			return nil
+		case <-time.After(w.opts.Interval):
+		}
+	}
+}
+
+// check reads new lines (or files), and publishes pending lines if the batch is due
+func (w *watcher) check() error {
+	if w.seen != nil {
+		if err := w.checkDir(); err != nil {
+			return err
+		}
+	} else if err := w.checkFile(); err != nil {
+		return err
+	}
+	if len(w.pending) > 0 && (w.opts.Batch == 0 || time.Since(w.batchStart) >= w.opts.Batch) {
+		w.flush()
+	}
+	return nil
+}
+
+func (w *watcher) openFile() error {
+	file, err := os.Open(w.opts.Path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.info, w.offset, w.partial = file, info, 0, ""
+	return nil
+}
+
+// checkFile reads all lines that were appended to the file since the last check. If the file was rotated (replaced
+// by a new file) or truncated, the remainder of the old file is read, and the new file is read from the start.
+func (w *watcher) checkFile() error {
+	info, err := os.Stat(w.opts.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // Rotated, but the new file does not exist yet
+		}
+		return err
+	}
+	if !os.SameFile(info, w.info) {
+		if err := w.readLines(); err != nil {
+			return err
+		}
+		w.file.Close()
+		log.Debug("File %s was rotated, reading new file", w.opts.Path)
+		if err := w.openFile(); err != nil {
+			return err
+		}
+	} else if info.Size() < w.offset {
+		log.Debug("File %s was truncated, reading from start", w.opts.Path)
+		w.offset, w.partial = 0, ""
+	}
+	return w.readLines()
+}
+
+func (w *watcher) readLines() error {
+	buf := make([]byte, watchReadSize)
+	for {
+		n, err := w.file.ReadAt(buf, w.offset)
+		if n > 0 {
+			w.offset += int64(n)
+			lines := strings.Split(w.partial+string(buf[:n]), "\n")
+			w.partial = lines[len(lines)-1]
+			for _, line := range lines[:len(lines)-1] {
+				w.add(strings.TrimSuffix(line, "\r"))
+			}
+			if len(w.partial) > watchLineLimit {
+				w.add(w.partial)
+				w.partial = ""
+			}
+		}
+		if err == io.EOF || n == 0 {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// checkDir looks for new files in the directory. A new file is published once its size did not change between
+// two checks, so that files are not uploaded while they are still being written.
+func (w *watcher) checkDir() error {
+	entries, err := os.ReadDir(w.opts.Path)
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		exists[name] = true
+		if w.seen[name] || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // File was removed in the meantime
+		}
+		size, ok := w.growing[name]
+		if !ok || size != info.Size() {
+			w.growing[name] = info.Size()
+			continue
+		}
+		delete(w.growing, name)
+		w.seen[name] = true
+		if !w.matches(name) {
+			continue
+		} else if w.opts.NoUpload {
+			w.add(name)
+		} else {
+			w.upload(name)
+		}
+	}
+	for name := range w.seen {
+		if !exists[name] {
+			delete(w.seen, name) // Publish re-created files again
+		}
+	}
+	return nil
+}
+
+// add adds a line (or file name) to the list of pending lines, if it passes the filters
+func (w *watcher) add(line string) {
+	if strings.TrimSpace(line) == "" || (w.seen == nil && !w.matches(line)) {
+		return
+	}
+	if len(w.pending) == 0 {
+		w.batchStart = time.Now()
+	}
+	w.pending = append(w.pending, line)
+	if w.opts.Batch > 0 && len(w.pending) >= w.opts.BatchSize {
+		w.flush()
+	}
+}
+
+func (w *watcher) matches(s string) bool {
+	for _, re := range w.opts.Ignore {
+		if re.MatchString(s) {
+			return false
+		}
+	}
+	if len(w.opts.Match) == 0 {
+		return true
+	}
+	for _, re := range w.opts.Match {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// flush publishes all pending lines, one message per line, or one message per batch (see --batch)
+func (w *watcher) flush() {
+	if w.opts.Batch > 0 && len(w.pending) > 0 {
+		w.publish(strings.Join(w.pending, "\n"))
+	} else {
+		for _, line := range w.pending {
+			w.publish(line)
+		}
+	}
+	w.pending = w.pending[:0]
+}
+
+func (w *watcher) publish(message string) {
+	m, err := w.client.Publish(w.opts.Topic, message, w.opts.Options...)
+	w.printResult(m, err)
+}
+
+func (w *watcher) upload(name string) {
+	file, err := os.Open(filepath.Join(w.opts.Path, name))
+	if err != nil {
+		fmt.Fprintf(w.errOut, "%s: %s\n", name, err.Error())
+		return
+	}
+	defer file.Close()
+	options := append(append([]client.PublishOption{}, w.opts.Options...), client.WithFilename(name))
+	m, err := w.client.PublishReader(w.opts.Topic, file, options...)
+	w.printResult(m, err)
+}
+
+// printResult prints the published message, or the error. Errors do not stop the watcher, so that
+// a temporary server outage does not end a long-running watch.
+func (w *watcher) printResult(m *client.Message, err error) {
+	if err != nil {
+		fmt.Fprintf(w.errOut, "publishing failed: %s\n", err.Error())
+	} else if !w.opts.Quiet {
+		fmt.Fprintln(w.out, strings.TrimSpace(m.Raw))
+	}
+}
+
+func (w *watcher) close() {
+	if w.file != nil {
+		w.file.Close()
+	}
+}
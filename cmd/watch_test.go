@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/test"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCLI_Watch_MissingArgs(t *testing.T) {
+	app, _, _, _ := newTestApp()
+	require.Error(t, app.Run([]string{"ntfy", "watch", "/tmp"}))
+}
+
+func TestCLI_Watch_UserAndToken(t *testing.T) {
+	app, _, _, _ := newTestApp()
+	err := app.Run([]string{"ntfy", "watch", "--user", "phil:mypass", "--token", "tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2", t.TempDir(), "mytopic"})
+	require.Equal(t, "cannot set both --user and --token", err.Error())
+}
+
+func TestCLI_Watch_InvalidRegex(t *testing.T) {
+	app, _, _, _ := newTestApp()
+	err := app.Run([]string{"ntfy", "watch", "--match", "[a-", t.TempDir(), "mytopic"})
+	require.ErrorContains(t, err, "invalid --match expression")
+}
+
+func TestCLI_Watch_PathNotFound(t *testing.T) {
+	app, _, _, _ := newTestApp()
+	require.Error(t, app.Run([]string{"ntfy", "watch", filepath.Join(t.TempDir(), "nope.log"), "mytopic"}))
+}
+
+func TestCLI_Watch_File_MatchIgnore(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	filename := filepath.Join(t.TempDir(), "app.log")
+	require.Nil(t, os.WriteFile(filename, []byte("ERROR old line\n"), 0600))
+
+	w, stdout := newTestWatcher(t, &watchOptions{
+		Path:   filename,
+		Topic:  topic,
+		Match:  []*regexp.Regexp{regexp.MustCompile("ERROR")},
+		Ignore: []*regexp.Regexp{regexp.MustCompile("healthcheck")},
+	})
+	appendToFile(t, filename, "INFO all good\nERROR disk full\nERROR healthcheck failed\nERROR partial")
+	require.Nil(t, w.check())
+	appendToFile(t, filename, " line\n")
+	require.Nil(t, w.check())
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Equal(t, 2, len(lines))
+	require.Equal(t, "ERROR disk full", toMessage(t, lines[0]).Message)
+	require.Equal(t, "ERROR partial line", toMessage(t, lines[1]).Message)
+}
+
+func TestCLI_Watch_File_Batch_Rotate(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	filename := filepath.Join(t.TempDir(), "app.log")
+	require.Nil(t, os.WriteFile(filename, []byte(""), 0600))
+
+	w, stdout := newTestWatcher(t, &watchOptions{
+		Path:      filename,
+		Topic:     topic,
+		Batch:     time.Hour,
+		BatchSize: 3,
+	})
+	appendToFile(t, filename, "line 1\nline 2\nline 3\nline 4\n")
+	require.Nil(t, w.check())
+	require.Nil(t, os.Rename(filename, filename+".1"))
+	require.Nil(t, os.WriteFile(filename, []byte("line 5\n"), 0600))
+	require.Nil(t, w.check())
+	w.flush()
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Equal(t, 2, len(lines))
+	require.Equal(t, "line 1\nline 2\nline 3", toMessage(t, lines[0]).Message)
+	require.Equal(t, "line 4\nline 5", toMessage(t, lines[1]).Message)
+}
+
+func TestCLI_Watch_Dir(t *testing.T) {
+	conf := server.NewConfig()
+	conf.BaseURL = "http://127.0.0.1"
+	s, port := test.StartServerWithConfig(t, conf)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "old.txt"), []byte("old"), 0600))
+
+	w, stdout := newTestWatcher(t, &watchOptions{
+		Path:   dir,
+		Topic:  topic,
+		Ignore: []*regexp.Regexp{regexp.MustCompile(`\.tmp$`)},
+	})
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "scan.txt"), []byte("scanned"), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "scan.tmp"), []byte("temp"), 0600))
+	require.Nil(t, w.check())
+	require.Equal(t, "", stdout.String()) // Size must be stable for one interval
+	require.Nil(t, w.check())
+
+	m := toMessage(t, stdout.String())
+	require.Equal(t, "scan.txt", m.Attachment.Name)
+	require.Equal(t, int64(7), m.Attachment.Size)
+}
+
+func TestCLI_Watch_Run_Canceled(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	filename := filepath.Join(t.TempDir(), "app.log")
+	require.Nil(t, os.WriteFile(filename, []byte(""), 0600))
+
+	w, stdout := newTestWatcher(t, &watchOptions{
+		Path:     filename,
+		Topic:    topic,
+		Interval: 10 * time.Millisecond,
+		Batch:    time.Hour,
+	})
+	appendToFile(t, filename, "pending line\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	require.Nil(t, w.run(ctx))
+	require.Equal(t, "pending line", toMessage(t, stdout.String()).Message) // Flushed on exit
+}
+
+func newTestWatcher(t *testing.T, opts *watchOptions) (*watcher, *bytes.Buffer) {
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 50
+	}
+	if opts.Interval == 0 {
+		opts.Interval = time.Second
+	}
+	var stdout, stderr bytes.Buffer
+	w, err := newWatcher(client.New(client.NewConfig()), &stdout, &stderr, opts)
+	require.Nil(t, err)
+	t.Cleanup(w.close)
+	return w, &stdout
+}
+
+func appendToFile(t *testing.T, filename, s string) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0600)
+	require.Nil(t, err)
+	defer f.Close()
+	_, err = f.WriteString(s)
+	require.Nil(t, err)
+}
@@ -146,9 +146,12 @@ func SetOutput(w io.Writer) {
 	mu.Lock()
 	defer mu.Unlock()
 	output = &peekLogWriter{w}
-	if f, ok := w.(*os.File); ok {
+	switch f := w.(type) {
+	case *os.File:
 		filename = f.Name()
-	} else {
+	case *RotatingFile:
+		filename = f.Name()
+	default:
 		filename = ""
 	}
 	log.SetOutput(output)
@@ -0,0 +1,250 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rotatedFileTimeFormat = "20060102-150405"
+	rotatedFileSuffixGzip = ".gz"
+)
+
+var (
+	rotatedFileSuffixRegex = regexp.MustCompile(`^\.\d{8}-\d{6}(-\d+)?(\.gz)?$`)
+)
+
+// RotatingFile is an io.WriteCloser that writes to a log file, and rotates it if it grows beyond a maximum size,
+// or if a rotation interval boundary is crossed (e.g. every 24h at midnight UTC). Rotated files are renamed to
+// <filename>.<timestamp>, optionally gzip-compressed, and only the newest max. backups are kept.
+//
+// The RotatingFile does not use the logger itself, since it is the logger's output. Errors during rotation
+// are printed to stderr, and writing continues to the current file.
+type RotatingFile struct {
+	filename   string
+	maxSize    int64         // Rotate if the file would grow beyond this size (bytes), 0 to disable
+	interval   time.Duration // Rotate at every interval boundary, 0 to disable
+	maxBackups int           // Number of rotated files to keep, 0 to keep all
+	compress   bool          // Gzip rotated files
+	file       *os.File
+	size       int64
+	period     time.Time // Start of the current rotation interval
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+}
+
+// NewRotatingFile opens (or creates) the given log file for appending, and returns a RotatingFile
+func NewRotatingFile(filename string, maxSize int64, interval time.Duration, maxBackups int, compress bool) (*RotatingFile, error) {
+	f := &RotatingFile{
+		filename:   filename,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: maxBackups,
+		compress:   compress,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Name returns the filename of the current log file
+func (f *RotatingFile) Name() string {
+	return f.filename
+}
+
+// Write writes p to the current log file, rotating it first if necessary
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.shouldRotate(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot rotate log file %s: %s\n", f.filename, err.Error())
+			if f.file == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file, and waits for pending compressions to finish
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.wg.Wait()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = stat.Size()
+	f.period = f.periodStart(time.Now())
+	if f.size > 0 {
+		f.period = f.periodStart(stat.ModTime()) // Rotate existing file if it was last written in a previous period
+	}
+	return nil
+}
+
+func (f *RotatingFile) shouldRotate(n int64) bool {
+	if f.maxSize > 0 && f.size > 0 && f.size+n > f.maxSize {
+		return true
+	}
+	return f.interval > 0 && f.size > 0 && !f.periodStart(time.Now()).Equal(f.period)
+}
+
+func (f *RotatingFile) periodStart(t time.Time) time.Time {
+	if f.interval <= 0 {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(f.interval)
+}
+
+// rotate closes the current file, renames it to a timestamped backup file, and opens a new file. Compression
+// and removal of old backup files happens in the background.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	backup := f.backupFilename(time.Now())
+	if err := os.Rename(f.filename, backup); err != nil {
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		if f.compress {
+			if err := compressFile(backup); err != nil {
+				fmt.Fprintf(os.Stderr, "cannot compress log file %s: %s\n", backup, err.Error())
+			}
+		}
+		if err := f.removeOldBackups(); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot remove old log files: %s\n", err.Error())
+		}
+	}()
+	return nil
+}
+
+// backupFilename returns a filename for a rotated file that does not exist yet, e.g. ntfy.log.20240405-101523
+func (f *RotatingFile) backupFilename(t time.Time) string {
+	base := fmt.Sprintf("%s.%s", f.filename, t.UTC().Format(rotatedFileTimeFormat))
+	backup := base
+	for i := 1; fileExists(backup) || fileExists(backup+rotatedFileSuffixGzip); i++ {
+		backup = fmt.Sprintf("%s-%d", base, i)
+	}
+	return backup
+}
+
+func (f *RotatingFile) removeOldBackups() error {
+	if f.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	if len(backups) <= f.maxBackups {
+		return nil
+	}
+	for _, backup := range backups[:len(backups)-f.maxBackups] {
+		if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// backups returns the list of rotated files, oldest first
+func (f *RotatingFile) backups() ([]string, error) {
+	dir, base := filepath.Split(f.filename)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, base) && rotatedFileSuffixRegex.MatchString(name[len(base):]) {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backupSortKey(backups[i]) < backupSortKey(backups[j])
+	})
+	return backups, nil
+}
+
+// backupSortKey strips the gzip suffix, so that compressed and not-yet-compressed files sort correctly
+func backupSortKey(filename string) string {
+	if filepath.Ext(filename) == rotatedFileSuffixGzip {
+		return filename[:len(filename)-len(rotatedFileSuffixGzip)]
+	}
+	return filename
+}
+
+func compressFile(filename string) error {
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(filename+rotatedFileSuffixGzip, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		gz.Close()
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
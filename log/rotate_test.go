@@ -0,0 +1,131 @@
+package log
+
+import (
+	"compress/gzip"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile_MaxSize(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	f, err := NewRotatingFile(filename, 100, 0, 2, false)
+	require.Nil(t, err)
+
+	line := strings.Repeat("x", 39) + "\n" // 40 bytes, so 2 lines fit in a file
+	for i := 0; i < 9; i++ {
+		_, err := f.Write([]byte(line))
+		require.Nil(t, err)
+	}
+	require.Nil(t, f.Close())
+
+	contents, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, line, string(contents)) // 9 lines = 4 rotated files + 1 line
+
+	backups, err := f.backups()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(backups)) // Oldest two rotated files were removed
+	for _, backup := range backups {
+		require.Regexp(t, `ntfy\.log\.\d{8}-\d{6}(-\d+)?$`, backup)
+		contents, err := os.ReadFile(backup)
+		require.Nil(t, err)
+		require.Equal(t, line+line, string(contents))
+	}
+}
+
+func TestRotatingFile_Compress(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	f, err := NewRotatingFile(filename, 10, 0, 0, true)
+	require.Nil(t, err)
+	_, err = f.Write([]byte("first line\n"))
+	require.Nil(t, err)
+	_, err = f.Write([]byte("second line\n"))
+	require.Nil(t, err)
+	require.Nil(t, f.Close()) // Waits for compression
+
+	contents, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, "second line\n", string(contents))
+
+	backups, err := f.backups()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(backups))
+	require.True(t, strings.HasSuffix(backups[0], ".gz"))
+
+	in, err := os.Open(backups[0])
+	require.Nil(t, err)
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	require.Nil(t, err)
+	uncompressed, err := io.ReadAll(gz)
+	require.Nil(t, err)
+	require.Equal(t, "first line\n", string(uncompressed))
+}
+
+func TestRotatingFile_Interval(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	f, err := NewRotatingFile(filename, 0, time.Hour, 0, false)
+	require.Nil(t, err)
+	_, err = f.Write([]byte("first line\n"))
+	require.Nil(t, err)
+	_, err = f.Write([]byte("second line\n"))
+	require.Nil(t, err)
+
+	f.period = f.period.Add(-time.Hour) // Pretend the file was opened in the previous interval
+	_, err = f.Write([]byte("third line\n"))
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	contents, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, "third line\n", string(contents))
+
+	backups, err := f.backups()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(backups))
+	contents, err = os.ReadFile(backups[0])
+	require.Nil(t, err)
+	require.Equal(t, "first line\nsecond line\n", string(contents))
+}
+
+func TestRotatingFile_ExistingFileFromPreviousInterval(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	require.Nil(t, os.WriteFile(filename, []byte("old line\n"), 0600))
+	yesterday := time.Now().Add(-24 * time.Hour)
+	require.Nil(t, os.Chtimes(filename, yesterday, yesterday))
+
+	f, err := NewRotatingFile(filename, 0, 24*time.Hour, 0, false)
+	require.Nil(t, err)
+	_, err = f.Write([]byte("new line\n"))
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	contents, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, "new line\n", string(contents))
+	backups, err := f.backups()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(backups))
+}
+
+func TestRotatingFile_SetOutput(t *testing.T) {
+	t.Cleanup(resetState)
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	f, err := NewRotatingFile(filename, 1024, 0, 1, false)
+	require.Nil(t, err)
+	defer f.Close()
+
+	SetOutput(f)
+	require.True(t, IsFile())
+	require.Equal(t, filename, File())
+
+	Info("this is a log line")
+	contents, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	require.Contains(t, string(contents), "INFO this is a log line")
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	accessLogCombinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+var (
+	accessLogRoutes = []string{routePublish, routeSubscribe, routePoll, routeAccount, routeWebPush, routeOther}
+)
+
+// accessLog writes one line per HTTP request to a destination (stdout, syslog or a file), separate from the
+// application log. It is meant for traffic analysis and abuse forensics, see Config.AccessLog.
+type accessLog struct {
+	w      io.WriteCloser
+	format string
+	routes map[string]bool // Routes to include; nil means all routes
+	mu     sync.Mutex
+}
+
+// accessLogEntry is a single line in the access log. The JSON field names are stable.
+type accessLogEntry struct {
+	Time         string `json:"time"`
+	IP           string `json:"ip"`
+	User         string `json:"user,omitempty"`
+	Impersonator string `json:"impersonator,omitempty"`
+	Method       string `json:"method"`
+	URI          string `json:"uri"`
+	Proto        string `json:"proto"`
+	Route        string `json:"route"`
+	Status       int    `json:"status"`
+	RequestSize  int64  `json:"request_size"`
+	ResponseSize int64  `json:"response_size"`
+	DurationMs   int64  `json:"duration_ms"`
+	Referer      string `json:"referer,omitempty"`
+	UserAgent    string `json:"user_agent,omitempty"`
+	time         time.Time
+}
+
+func newAccessLog(conf *Config) (*accessLog, error) {
+	if conf.AccessLogFormat != AccessLogFormatJSON && conf.AccessLogFormat != AccessLogFormatCombined {
+		return nil, fmt.Errorf("invalid access log format %s, must be %s or %s", conf.AccessLogFormat, AccessLogFormatJSON, AccessLogFormatCombined)
+	}
+	var routes map[string]bool
+	if len(conf.AccessLogRoutes) > 0 {
+		routes = make(map[string]bool)
+		for _, route := range conf.AccessLogRoutes {
+			if !util.Contains(accessLogRoutes, route) {
+				return nil, fmt.Errorf("invalid access log route %s, must be one of %s", route, strings.Join(accessLogRoutes, ", "))
+			}
+			routes[route] = true
+		}
+	}
+	var w io.WriteCloser
+	var err error
+	switch conf.AccessLog {
+	case AccessLogDestinationStdout:
+		w = nopWriteCloser{os.Stdout}
+	case AccessLogDestinationSyslog:
+		w, err = newAccessLogSyslogWriter()
+	default:
+		w, err = os.OpenFile(conf.AccessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot open access log %s: %w", conf.AccessLog, err)
+	}
+	return &accessLog{
+		w:      w,
+		format: conf.AccessLogFormat,
+		routes: routes,
+	}, nil
+}
+
+// Log writes an access log line for a finished request, unless the route is excluded
+func (l *accessLog) Log(r *http.Request, v *visitor, route, uri string, started time.Time, w *trackingResponseWriter, body *trackingRequestBody) {
+	if l.routes != nil && !l.routes[route] {
+		return
+	}
+	status, size, _, _ := w.stats()
+	entry := &accessLogEntry{
+		Method:       r.Method,
+		URI:          uri,
+		Proto:        r.Proto,
+		Route:        route,
+		Status:       status,
+		RequestSize:  body.size,
+		ResponseSize: size,
+		DurationMs:   time.Since(started).Milliseconds(),
+		Referer:      r.Referer(),
+		UserAgent:    r.UserAgent(),
+		time:         started,
+	}
+	entry.Time = started.UTC().Format(time.RFC3339)
+	if v != nil {
+		entry.IP = v.IP().String()
+		if u := v.User(); u != nil {
+			entry.User = u.Name
+			entry.Impersonator = u.Impersonator
+		}
+	}
+	var line string
+	if l.format == AccessLogFormatCombined {
+		line = entry.combined()
+	} else {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		line = string(b)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := io.WriteString(l.w, line+"\n"); err != nil {
+		log.Tag(tagHTTP).Err(err).Warn("Cannot write to access log")
+	}
+}
+
+// Close closes the underlying writer
+func (l *accessLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Close()
+}
+
+// combined renders the entry in the Apache/nginx combined log format, e.g.
+//
+//	1.2.3.4 - phil [05/Apr/2024:10:15:23 +0000] "PUT /mytopic HTTP/1.1" 200 312 "-" "curl/8.4.0"
+func (e *accessLogEntry) combined() string {
+	responseSize := "-"
+	if e.ResponseSize > 0 {
+		responseSize = fmt.Sprintf("%d", e.ResponseSize)
+	}
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s "%s" "%s"`,
+		orDash(e.IP), orDash(e.User), e.time.Format(accessLogCombinedTimeFormat), e.Method, e.URI, e.Proto,
+		e.Status, responseSize, orDash(e.Referer), orDash(e.UserAgent))
+}
+
+// accessLogURI returns the request URI for the access log. The "auth" and "authorization" query parameters
+// are redacted, since they contain credentials (see readAuthHeader).
+func accessLogURI(r *http.Request) string {
+	u := *r.URL
+	query := u.Query()
+	redacted := false
+	for _, name := range []string{"auth", "authorization"} {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = query.Encode()
+	}
+	return u.RequestURI()
+}
+
+// orDash returns "-" for empty values, and escapes double quotes otherwise (combined log format)
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
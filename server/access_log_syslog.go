@@ -0,0 +1,12 @@
+//go:build !windows
+
+package server
+
+import (
+	"io"
+	"log/syslog"
+)
+
+func newAccessLogSyslogWriter() (io.WriteCloser, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "ntfy")
+}
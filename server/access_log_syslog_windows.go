@@ -0,0 +1,10 @@
+package server
+
+import (
+	"errors"
+	"io"
+)
+
+func newAccessLogSyslogWriter() (io.WriteCloser, error) {
+	return nil, errors.New("syslog is not supported on Windows")
+}
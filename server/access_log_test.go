@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog_JSON(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AccessLog = filepath.Join(t.TempDir(), "access.log")
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	response := request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"User-Agent":    "curl/8.4.0",
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/json?poll=1&auth=c2VjcmV0", "", nil)
+	require.Equal(t, 200, response.Code)
+
+	entries := readAccessLog(t, c.AccessLog)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, "9.9.9.9", entries[0].IP)
+	require.Equal(t, "phil", entries[0].User)
+	require.Equal(t, "PUT", entries[0].Method)
+	require.Equal(t, "/mytopic", entries[0].URI)
+	require.Equal(t, routePublish, entries[0].Route)
+	require.Equal(t, 200, entries[0].Status)
+	require.Equal(t, int64(12), entries[0].RequestSize)
+	require.True(t, entries[0].ResponseSize > 0)
+	require.Equal(t, "curl/8.4.0", entries[0].UserAgent)
+	require.NotEmpty(t, entries[0].Time)
+
+	require.Equal(t, "", entries[1].User)
+	require.Equal(t, routePoll, entries[1].Route)
+	require.Equal(t, 200, entries[1].Status)
+	require.Equal(t, "/mytopic/json?auth=REDACTED&poll=1", entries[1].URI) // Credentials are not logged
+}
+
+func TestAccessLog_Combined(t *testing.T) {
+	c := newTestConfig(t)
+	c.AccessLog = filepath.Join(t.TempDir(), "access.log")
+	c.AccessLogFormat = AccessLogFormatCombined
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+		"User-Agent": `my "special" agent`,
+	})
+	require.Equal(t, 200, response.Code)
+
+	contents, err := os.ReadFile(c.AccessLog)
+	require.Nil(t, err)
+	require.Regexp(t, regexp.MustCompile(`^9\.9\.9\.9 - - \[\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}] "PUT /mytopic HTTP/1\.1" 200 \d+ "-" "my \\"special\\" agent"\n$`), string(contents))
+}
+
+func TestAccessLog_Routes(t *testing.T) {
+	c := newTestConfig(t)
+	c.AccessLog = filepath.Join(t.TempDir(), "access.log")
+	c.AccessLogRoutes = []string{routePublish, routeAccount}
+	s := newTestServer(t, c)
+
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "some message", nil).Code)
+	require.Equal(t, 200, request(t, s, "GET", "/mytopic/json?poll=1", "", nil).Code)
+	require.Equal(t, 200, request(t, s, "GET", "/v1/health", "", nil).Code)
+	require.Equal(t, 200, request(t, s, "GET", "/v1/account", "", nil).Code)
+
+	entries := readAccessLog(t, c.AccessLog)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, routePublish, entries[0].Route)
+	require.Equal(t, routeAccount, entries[1].Route)
+}
+
+func TestAccessLog_InvalidConfig(t *testing.T) {
+	c := newTestConfig(t)
+	c.AccessLog = filepath.Join(t.TempDir(), "access.log")
+	c.AccessLogRoutes = []string{"publish", "invalid"}
+	_, err := New(c)
+	require.ErrorContains(t, err, "invalid access log route invalid")
+
+	c.AccessLogRoutes = nil
+	c.AccessLogFormat = "xml"
+	_, err = New(c)
+	require.ErrorContains(t, err, "invalid access log format xml")
+}
+
+func readAccessLog(t *testing.T, filename string) []*accessLogEntry {
+	contents, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	entries := make([]*accessLogEntry, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		var entry accessLogEntry
+		require.Nil(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, &entry)
+	}
+	return entries
+}
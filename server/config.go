@@ -12,29 +12,67 @@ import (
 const (
 	DefaultListenHTTP                           = ":80"
 	DefaultCacheDuration                        = 12 * time.Hour
+	DefaultCacheSnapshotInterval                = 30 * time.Second
 	DefaultKeepaliveInterval                    = 45 * time.Second // Not too frequently to save battery (Android read timeout used to be 77s!)
+	DefaultKeepaliveIntervalMin                 = 10 * time.Second // Lower bound for the keepalive interval requested by clients
+	DefaultKeepaliveIntervalMax                 = 5 * time.Minute  // Upper bound for the keepalive interval requested by clients
+	DefaultSSERetryMax                          = 5 * time.Minute  // Upper bound for the SSE "retry:" interval requested by clients
 	DefaultManagerInterval                      = time.Minute
+	DefaultSubscriberBacklogLimit               = 100              // Max. number of messages waiting to be written to a subscriber connection
+	DefaultShutdownTimeout                      = 10 * time.Second // Time to drain connections and queues on SIGTERM/SIGINT
 	DefaultDelayedSenderInterval                = 10 * time.Second
+	DefaultAuthReplicateInterval                = 10 * time.Second // Interval in which a standby server polls the primary for user changes
 	DefaultMinDelay                             = 10 * time.Second
 	DefaultMaxDelay                             = 3 * 24 * time.Hour
 	DefaultFirebaseKeepaliveInterval            = 3 * time.Hour    // ~control topic (Android), not too frequently to save battery
 	DefaultFirebasePollInterval                 = 20 * time.Minute // ~poll topic (iOS), max. 2-3 times per hour (see docs)
 	DefaultFirebaseQuotaExceededPenaltyDuration = 10 * time.Minute // Time that over-users are locked out of Firebase if it returns "quota exceeded"
+	DefaultFirebaseWorkers                      = 10               // Number of concurrent Firebase senders
+	DefaultFirebaseQueueSize                    = 10_000           // Max. number of messages waiting to be sent to Firebase
 	DefaultStripePriceCacheDuration             = 3 * time.Hour    // Time to keep Stripe prices cached in memory before a refresh is needed
+	DefaultPaddleEnvironment                    = "production"
+	DefaultShowcaseMessagesLimit                = 20  // Number of messages shown by /<topic>/showcase
+	ShowcaseMessagesLimitMax                    = 100 // Upper bound for the showcase-messages-limit option
 )
 
 // Defines default Web Push settings
 const (
 	DefaultWebPushExpiryWarningDuration = 7 * 24 * time.Hour
 	DefaultWebPushExpiryDuration        = 9 * 24 * time.Hour
+	DefaultWebPushWorkers               = 50 // Number of concurrent web push requests
+	DefaultWebPushKeepaliveStrategy     = WebPushKeepaliveStrategyWarning
+	DefaultWebPushUserSubscriptionLimit = 10 // Max. number of web push endpoints (browsers) per user
+)
+
+// Defines how web push subscriptions that have not been updated in a while are kept alive
+const (
+	WebPushKeepaliveStrategyWarning = "warning" // Send a visible warning notification, and remove subscriptions by age
+	WebPushKeepaliveStrategyPing    = "ping"    // Send a silent ping, and remove subscriptions that do not renew themselves
+)
+
+// Defines the destinations and formats of the access log, see Config.AccessLog
+const (
+	AccessLogDestinationStdout = "stdout"   // Write access log to stdout; any other value (except syslog) is a file name
+	AccessLogDestinationSyslog = "syslog"   // Write access log to the local syslog daemon (not supported on Windows)
+	AccessLogFormatJSON        = "json"     // One JSON object per line
+	AccessLogFormatCombined    = "combined" // Apache/nginx combined log format
+	DefaultAccessLogFormat     = AccessLogFormatJSON
+)
+
+// Defines default settings for releasing inactive topics (disabled by default)
+const (
+	DefaultTopicInactivityExpiryDuration  = time.Duration(0)
+	DefaultTopicInactivityWarningDuration = 14 * 24 * time.Hour
 )
 
 // Defines all global and per-visitor limits
 // - message size limit: the max number of bytes for a message
+// - account body size limit: the max number of bytes for a JSON request body of the account (and other) API endpoints
 // - total topic limit: max number of topics overall
 // - various attachment limits
 const (
-	DefaultMessageLengthLimit       = 4096 // Bytes
+	DefaultMessageLengthLimit       = 4096  // Bytes
+	DefaultAccountBodySizeLimit     = 16384 // Bytes
 	DefaultTotalTopicLimit          = 15000
 	DefaultAttachmentTotalSizeLimit = int64(5 * 1024 * 1024 * 1024) // 5 GB
 	DefaultAttachmentFileSizeLimit  = int64(15 * 1024 * 1024)       // 15 MB
@@ -62,6 +100,38 @@ const (
 	DefaultVisitorAttachmentDailyBandwidthLimit = 500 * 1024 * 1024 // 500 MB
 )
 
+// Defines the e-mail batching defaults, see smtp-sender-batch-window
+const (
+	DefaultSMTPSenderBatchMax = 50
+)
+
+// Defines the outbound e-mail providers, see smtp-sender-provider
+const (
+	SMTPSenderProviderSMTP     = "smtp"
+	SMTPSenderProviderSendGrid = "sendgrid"
+	SMTPSenderProviderMailgun  = "mailgun"
+	SMTPSenderProviderSES      = "ses"
+)
+
+// Defines what happens to subscribers that can't keep up, see subscriber-slow-action
+const (
+	SubscriberSlowActionDrop = "drop" // Close the connection; the client reconnects and catches up using "since"
+	SubscriberSlowActionPoll = "poll" // Skip messages, and send a poll_request once the client caught up
+)
+
+// Defines the push channels, see push-fallback
+const (
+	PushChannelFirebase = "firebase"
+	PushChannelWebPush  = "webpush"
+	PushChannelUpstream = "upstream"
+)
+
+// Defines the automatic IP ban defaults, see ip-ban-auth-failures and ip-ban-rate-limit-violations
+const (
+	DefaultIPBanWindow   = 10 * time.Minute
+	DefaultIPBanDuration = time.Hour
+)
+
 var (
 	// DefaultVisitorStatsResetTime defines the time at which visitor stats are reset (wall clock only)
 	DefaultVisitorStatsResetTime = time.Date(0, 0, 0, 0, 0, 0, 0, time.UTC)
@@ -79,6 +149,8 @@ type Config struct {
 	ListenHTTPS                          string
 	ListenUnix                           string
 	ListenUnixMode                       fs.FileMode
+	ListenReusePort                      bool
+	ReadinessCheckExternal               bool // Check Firebase/SMTP reachability in /v1/health/ready
 	KeyFile                              string
 	CertFile                             string
 	FirebaseKeyFile                      string
@@ -87,29 +159,79 @@ type Config struct {
 	CacheStartupQueries                  string
 	CacheBatchSize                       int
 	CacheBatchTimeout                    time.Duration
+	CacheSnapshotFile                    string
+	CacheSnapshotInterval                time.Duration
+	CacheMaxSize                         int64
+	CacheMaxMessagesPerTopic             int
+	CacheVacuumInterval                  time.Duration
+	CacheCompressThreshold               int64
 	AuthFile                             string
 	AuthStartupQueries                   string
 	AuthDefault                          user.Permission
 	AuthBcryptCost                       int
 	AuthStatsQueueWriterInterval         time.Duration
+	AuthUsers                            []*user.User
+	AuthAccess                           map[string][]user.Grant
+	AuthTokens                           map[string][]*user.Token
+	AuthTiers                            []*user.Tier
+	AuthReplicateFrom                    string // Base URL of the primary server; if set, this server is a standby that replicates the user database
+	AuthReplicateToken                   string // Access token of an admin user on the primary server
+	AuthReplicateInterval                time.Duration
+	SCIMToken                            string // Bearer token for the SCIM 2.0 provisioning API; SCIM is disabled if empty
+	AccountWebhookURLs                   []string
+	AccountWebhookSecret                 string              // Secret used to sign webhook payloads (HMAC-SHA256)
+	AccountWebhookEvents                 []string            // Account lifecycle events to send; all events if empty
+	PublishURLSecret                     string              // Secret used to sign publish URLs (HMAC-SHA256); signed publish URLs are disabled if empty
+	WebhookSignatures                    []*WebhookSignature // Topics that only accept requests signed by an upstream webhook provider (GitHub, Stripe, Slack)
+	ForwardRules                         []*ForwardRule      // Rules to copy matching messages from one topic to another
+	ShowcaseTopics                       []string            // Topics (or prefixes) with a read-only public showcase, see handleTopicShowcase
+	ShowcaseMessagesLimit                int
 	AttachmentCacheDir                   string
 	AttachmentTotalSizeLimit             int64
 	AttachmentFileSizeLimit              int64
 	AttachmentExpiryDuration             time.Duration
+	AttachmentBaseURL                    string // Separate origin for attachment downloads, e.g. https://files.ntfy.sh, defaults to BaseURL
+	AttachmentStripHTML                  bool   // Serve HTML, SVG and XML attachments as text/plain
+	TemplateDir                          string // Directory of named message templates (<name>.yml), see X-Template
+	TemplateInlineAnonymous              bool   // Allow inline templates (X-Template: yes) for anonymous publishers
 	KeepaliveInterval                    time.Duration
+	KeepaliveIntervalMin                 time.Duration // Bounds for the keepalive interval requested by clients, see "keepalive" parameter
+	KeepaliveIntervalMax                 time.Duration
+	SSERetryMax                          time.Duration // Upper bound for the SSE "retry:" interval requested by clients, see "retry" parameter
+	SubscriberBacklogLimit               int           // Max. number of messages waiting to be written to a subscriber connection, unlimited if 0
+	SubscriberSlowAction                 string        // See SubscriberSlowActionDrop and SubscriberSlowActionPoll
 	ManagerInterval                      time.Duration
+	ShutdownTimeout                      time.Duration
 	DisallowedTopics                     []string
+	TopicInactivityExpiryDuration        time.Duration
+	TopicInactivityWarningDuration       time.Duration
 	WebRoot                              string // empty to disable
+	TagIcons                             []*TagIcon
 	DelayedSenderInterval                time.Duration
 	FirebaseKeepaliveInterval            time.Duration
 	FirebasePollInterval                 time.Duration
 	FirebaseQuotaExceededPenaltyDuration time.Duration
+	FirebaseWorkers                      int
+	FirebaseQueueSize                    int
+	FirebaseCriticalAlertTopics          []string
+	FirebaseAndroidChannels              []*FirebaseAndroidChannel
 	UpstreamBaseURL                      string
 	UpstreamAccessToken                  string
+	Upstreams                            []*Upstream // Additional upstream servers, first match wins; UpstreamBaseURL is used if none match
+	PushFallback                         []string    // Push channels to try one after another, until one works; all channels are used if empty
 	SMTPSenderAddr                       string
 	SMTPSenderUser                       string
 	SMTPSenderPass                       string
 	SMTPSenderFrom                       string
+	SMTPSenderBatchWindow                time.Duration // Coalesce e-mails to the same recipient within this window (0 = disabled)
+	SMTPSenderBatchMax                   int           // Max. number of messages listed in a summary e-mail
+	SMTPSenderProvider                   string        // One of the SMTPSenderProvider* constants
+	SMTPSenderAPIKey                     string        // SendGrid or Mailgun API key, or SES access key ID
+	SMTPSenderAPISecret                  string        // SES secret access key
+	SMTPSenderAPIRegion                  string        // SES region (e.g. us-east-1), or Mailgun region (us or eu)
+	SMTPSenderAPIBaseURL                 string        // Override for tests, derived from provider and region if empty
+	SMTPSenderRateLimit                  float64       // Max. e-mails per second (0 = provider default, see emailProviderRateLimits)
+	SMTPSenderWebhookKey                 string        // Verifies bounce webhook requests, see handleEmailWebhook
 	SMTPServerListen                     string
 	SMTPServerDomain                     string
 	SMTPServerAddrPrefix                 string
@@ -122,7 +244,8 @@ type Config struct {
 	MetricsEnable                        bool
 	MetricsListenHTTP                    string
 	ProfileListenHTTP                    string
-	MessageLimit                         int
+	MessageLimit                         int // Max size of a message body (bytes); larger bodies are treated as attachments. May be overridden by the tier, see user.Tier
+	AccountBodySizeLimit                 int // Max size of JSON request bodies of the account (and other) API endpoints (bytes)
 	MinDelay                             time.Duration
 	MaxDelay                             time.Duration
 	TotalTopicLimit                      int
@@ -146,12 +269,26 @@ type Config struct {
 	StripeSecretKey                      string
 	StripeWebhookKey                     string
 	StripePriceCacheDuration             time.Duration
+	PaddleAPIKey                         string
+	PaddleWebhookKey                     string
+	PaddleEnvironment                    string // "production" or "sandbox"
 	BillingContact                       string
 	EnableSignup                         bool // Enable creation of accounts via API and UI
 	EnableLogin                          bool
 	EnableReservations                   bool // Allow users with role "user" to own/reserve topics
 	EnableMetrics                        bool
-	AccessControlAllowOrigin             string // CORS header field to restrict access from web clients
+	AccessControlAllowOrigins            []string
+	AccessControlAllowMethods            []string
+	AccessControlAllowCredentials        bool
+	CSRFProtection                       bool
+	IPBanAuthFailures                    int
+	IPBanRateLimitViolations             int
+	IPBanWindow                          time.Duration
+	IPBanDuration                        time.Duration
+	AuthFailureLogFile                   string
+	AccessLog                            string // Destination (stdout, syslog or file name), empty to disable
+	AccessLogFormat                      string
+	AccessLogRoutes                      []string
 	Version                              string // injected by App
 	WebPushPrivateKey                    string
 	WebPushPublicKey                     string
@@ -160,6 +297,9 @@ type Config struct {
 	WebPushStartupQueries                string
 	WebPushExpiryDuration                time.Duration
 	WebPushExpiryWarningDuration         time.Duration
+	WebPushWorkers                       int
+	WebPushKeepaliveStrategy             string
+	WebPushUserSubscriptionLimit         int
 }
 
 // NewConfig instantiates a default new server config
@@ -179,29 +319,79 @@ func NewConfig() *Config {
 		CacheStartupQueries:                  "",
 		CacheBatchSize:                       0,
 		CacheBatchTimeout:                    0,
+		CacheSnapshotFile:                    "",
+		CacheSnapshotInterval:                DefaultCacheSnapshotInterval,
+		CacheMaxSize:                         0,
+		CacheMaxMessagesPerTopic:             0,
+		CacheVacuumInterval:                  0,
+		CacheCompressThreshold:               0,
 		AuthFile:                             "",
 		AuthStartupQueries:                   "",
 		AuthDefault:                          user.PermissionReadWrite,
 		AuthBcryptCost:                       user.DefaultUserPasswordBcryptCost,
 		AuthStatsQueueWriterInterval:         user.DefaultUserStatsQueueWriterInterval,
+		AuthUsers:                            nil,
+		AuthAccess:                           nil,
+		AuthTokens:                           nil,
+		AuthTiers:                            nil,
+		AuthReplicateFrom:                    "",
+		AuthReplicateToken:                   "",
+		AuthReplicateInterval:                DefaultAuthReplicateInterval,
+		SCIMToken:                            "",
+		AccountWebhookURLs:                   nil,
+		AccountWebhookSecret:                 "",
+		AccountWebhookEvents:                 nil,
+		PublishURLSecret:                     "",
+		WebhookSignatures:                    []*WebhookSignature{},
+		ForwardRules:                         []*ForwardRule{},
+		ShowcaseTopics:                       []string{},
+		ShowcaseMessagesLimit:                DefaultShowcaseMessagesLimit,
 		AttachmentCacheDir:                   "",
 		AttachmentTotalSizeLimit:             DefaultAttachmentTotalSizeLimit,
 		AttachmentFileSizeLimit:              DefaultAttachmentFileSizeLimit,
 		AttachmentExpiryDuration:             DefaultAttachmentExpiryDuration,
+		AttachmentBaseURL:                    "",
+		AttachmentStripHTML:                  false,
+		TemplateDir:                          "",
+		TemplateInlineAnonymous:              false,
 		KeepaliveInterval:                    DefaultKeepaliveInterval,
+		KeepaliveIntervalMin:                 DefaultKeepaliveIntervalMin,
+		KeepaliveIntervalMax:                 DefaultKeepaliveIntervalMax,
+		SSERetryMax:                          DefaultSSERetryMax,
+		SubscriberBacklogLimit:               DefaultSubscriberBacklogLimit,
+		SubscriberSlowAction:                 SubscriberSlowActionDrop,
 		ManagerInterval:                      DefaultManagerInterval,
+		ShutdownTimeout:                      DefaultShutdownTimeout,
 		DisallowedTopics:                     DefaultDisallowedTopics,
+		TopicInactivityExpiryDuration:        DefaultTopicInactivityExpiryDuration,
+		TopicInactivityWarningDuration:       DefaultTopicInactivityWarningDuration,
 		WebRoot:                              "/",
+		TagIcons:                             []*TagIcon{},
 		DelayedSenderInterval:                DefaultDelayedSenderInterval,
 		FirebaseKeepaliveInterval:            DefaultFirebaseKeepaliveInterval,
 		FirebasePollInterval:                 DefaultFirebasePollInterval,
 		FirebaseQuotaExceededPenaltyDuration: DefaultFirebaseQuotaExceededPenaltyDuration,
+		FirebaseWorkers:                      DefaultFirebaseWorkers,
+		FirebaseQueueSize:                    DefaultFirebaseQueueSize,
+		FirebaseCriticalAlertTopics:          []string{},
+		FirebaseAndroidChannels:              []*FirebaseAndroidChannel{},
 		UpstreamBaseURL:                      "",
 		UpstreamAccessToken:                  "",
+		Upstreams:                            []*Upstream{},
+		PushFallback:                         []string{},
 		SMTPSenderAddr:                       "",
 		SMTPSenderUser:                       "",
 		SMTPSenderPass:                       "",
 		SMTPSenderFrom:                       "",
+		SMTPSenderBatchWindow:                0,
+		SMTPSenderBatchMax:                   DefaultSMTPSenderBatchMax,
+		SMTPSenderProvider:                   SMTPSenderProviderSMTP,
+		SMTPSenderAPIKey:                     "",
+		SMTPSenderAPISecret:                  "",
+		SMTPSenderAPIRegion:                  "",
+		SMTPSenderAPIBaseURL:                 "",
+		SMTPSenderRateLimit:                  0,
+		SMTPSenderWebhookKey:                 "",
 		SMTPServerListen:                     "",
 		SMTPServerDomain:                     "",
 		SMTPServerAddrPrefix:                 "",
@@ -212,6 +402,7 @@ func NewConfig() *Config {
 		TwilioVerifyBaseURL:                  "https://verify.twilio.com", // Override for tests
 		TwilioVerifyService:                  "",
 		MessageLimit:                         DefaultMessageLengthLimit,
+		AccountBodySizeLimit:                 DefaultAccountBodySizeLimit,
 		MinDelay:                             DefaultMinDelay,
 		MaxDelay:                             DefaultMaxDelay,
 		TotalTopicLimit:                      DefaultTotalTopicLimit,
@@ -235,11 +426,25 @@ func NewConfig() *Config {
 		StripeSecretKey:                      "",
 		StripeWebhookKey:                     "",
 		StripePriceCacheDuration:             DefaultStripePriceCacheDuration,
+		PaddleAPIKey:                         "",
+		PaddleWebhookKey:                     "",
+		PaddleEnvironment:                    DefaultPaddleEnvironment,
 		BillingContact:                       "",
 		EnableSignup:                         false,
 		EnableLogin:                          false,
 		EnableReservations:                   false,
-		AccessControlAllowOrigin:             "*",
+		AccessControlAllowOrigins:            []string{"*"},
+		AccessControlAllowMethods:            []string{"GET", "PUT", "POST", "PATCH", "DELETE"},
+		AccessControlAllowCredentials:        false,
+		CSRFProtection:                       false,
+		IPBanAuthFailures:                    0,
+		IPBanRateLimitViolations:             0,
+		IPBanWindow:                          DefaultIPBanWindow,
+		IPBanDuration:                        DefaultIPBanDuration,
+		AuthFailureLogFile:                   "",
+		AccessLog:                            "",
+		AccessLogFormat:                      DefaultAccessLogFormat,
+		AccessLogRoutes:                      []string{},
 		Version:                              "",
 		WebPushPrivateKey:                    "",
 		WebPushPublicKey:                     "",
@@ -247,5 +452,8 @@ func NewConfig() *Config {
 		WebPushEmailAddress:                  "",
 		WebPushExpiryDuration:                DefaultWebPushExpiryDuration,
 		WebPushExpiryWarningDuration:         DefaultWebPushExpiryWarningDuration,
+		WebPushWorkers:                       DefaultWebPushWorkers,
+		WebPushKeepaliveStrategy:             DefaultWebPushKeepaliveStrategy,
+		WebPushUserSubscriptionLimit:         DefaultWebPushUserSubscriptionLimit,
 	}
 }
@@ -7,13 +7,36 @@ import (
 	"net/http"
 )
 
-// errHTTP is a generic HTTP error for any non-200 HTTP error
+// errHTTPLimitField is the context field that holds the exceeded limit of an error, see errHTTP.WithLimit
+const errHTTPLimitField = "error_limit"
+
+// errHTTPCatalog is the list of all known errors, in order of declaration, see newErrHTTP and handleErrors
+var errHTTPCatalog = make([]*errHTTP, 0)
+
+// errHTTP is a generic HTTP error for any non-200 HTTP error. The code and slug of an error are stable, so that
+// clients can rely on them to handle specific errors; the message may change.
 type errHTTP struct {
-	Code     int    `json:"code,omitempty"`
-	HTTPCode int    `json:"http"`
-	Message  string `json:"error"`
-	Link     string `json:"link,omitempty"`
-	context  log.Context
+	Code      int    `json:"code,omitempty"`
+	HTTPCode  int    `json:"http"`
+	Slug      string `json:"slug,omitempty"`
+	Message   string `json:"error"`
+	Link      string `json:"link,omitempty"`
+	Retryable bool   `json:"retryable"` // The same request may succeed later (rate limits and server errors)
+	context   log.Context
+}
+
+// newErrHTTP creates a new error and adds it to the error catalog (errHTTPCatalog)
+func newErrHTTP(code, httpCode int, slug, message, link string) *errHTTP {
+	e := &errHTTP{
+		Code:      code,
+		HTTPCode:  httpCode,
+		Slug:      slug,
+		Message:   message,
+		Link:      link,
+		Retryable: httpCode == http.StatusTooManyRequests || httpCode >= http.StatusInternalServerError,
+	}
+	errHTTPCatalog = append(errHTTPCatalog, e)
+	return e
 }
 
 func (e errHTTP) Error() string {
@@ -21,7 +44,10 @@ func (e errHTTP) Error() string {
 }
 
 func (e errHTTP) JSON() string {
-	b, _ := json.Marshal(&e)
+	b, _ := json.Marshal(&struct {
+		errHTTP
+		Limit any `json:"limit,omitempty"` // Exceeded limit, see WithLimit
+	}{e, e.context[errHTTPLimitField]})
 	return string(b)
 }
 
@@ -63,84 +89,160 @@ func (e errHTTP) Fields(context log.Context) *errHTTP {
 	return &c
 }
 
+// WithLimit adds the exceeded limit (e.g. the max. size in bytes) to the error. Unlike other context fields,
+// the limit is also included in the JSON response, so that clients can tell how much is allowed.
+func (e errHTTP) WithLimit(limit int64) *errHTTP {
+	return e.Fields(log.Context{errHTTPLimitField: limit})
+}
+
 func (e errHTTP) clone() errHTTP {
 	context := make(log.Context)
 	for k, v := range e.context {
 		context[k] = v
 	}
 	return errHTTP{
-		Code:     e.Code,
-		HTTPCode: e.HTTPCode,
-		Message:  e.Message,
-		Link:     e.Link,
-		context:  context,
+		Code:      e.Code,
+		HTTPCode:  e.HTTPCode,
+		Slug:      e.Slug,
+		Message:   e.Message,
+		Link:      e.Link,
+		Retryable: e.Retryable,
+		context:   context,
 	}
 }
 
 var (
-	errHTTPBadRequest                                = &errHTTP{40000, http.StatusBadRequest, "invalid request", "", nil}
-	errHTTPBadRequestEmailDisabled                   = &errHTTP{40001, http.StatusBadRequest, "e-mail notifications are not enabled", "https://ntfy.sh/docs/config/#e-mail-notifications", nil}
-	errHTTPBadRequestDelayNoCache                    = &errHTTP{40002, http.StatusBadRequest, "cannot disable cache for delayed message", "", nil}
-	errHTTPBadRequestDelayNoEmail                    = &errHTTP{40003, http.StatusBadRequest, "delayed e-mail notifications are not supported", "", nil}
-	errHTTPBadRequestDelayCannotParse                = &errHTTP{40004, http.StatusBadRequest, "invalid delay parameter: unable to parse delay", "https://ntfy.sh/docs/publish/#scheduled-delivery", nil}
-	errHTTPBadRequestDelayTooSmall                   = &errHTTP{40005, http.StatusBadRequest, "invalid delay parameter: too small, please refer to the docs", "https://ntfy.sh/docs/publish/#scheduled-delivery", nil}
-	errHTTPBadRequestDelayTooLarge                   = &errHTTP{40006, http.StatusBadRequest, "invalid delay parameter: too large, please refer to the docs", "https://ntfy.sh/docs/publish/#scheduled-delivery", nil}
-	errHTTPBadRequestPriorityInvalid                 = &errHTTP{40007, http.StatusBadRequest, "invalid priority parameter", "https://ntfy.sh/docs/publish/#message-priority", nil}
-	errHTTPBadRequestSinceInvalid                    = &errHTTP{40008, http.StatusBadRequest, "invalid since parameter", "https://ntfy.sh/docs/subscribe/api/#fetch-cached-messages", nil}
-	errHTTPBadRequestTopicInvalid                    = &errHTTP{40009, http.StatusBadRequest, "invalid request: topic invalid", "", nil}
-	errHTTPBadRequestTopicDisallowed                 = &errHTTP{40010, http.StatusBadRequest, "invalid request: topic name is not allowed", "", nil}
-	errHTTPBadRequestMessageNotUTF8                  = &errHTTP{40011, http.StatusBadRequest, "invalid message: message must be UTF-8 encoded", "", nil}
-	errHTTPBadRequestAttachmentURLInvalid            = &errHTTP{40013, http.StatusBadRequest, "invalid request: attachment URL is invalid", "https://ntfy.sh/docs/publish/#attachments", nil}
-	errHTTPBadRequestAttachmentsDisallowed           = &errHTTP{40014, http.StatusBadRequest, "invalid request: attachments not allowed", "https://ntfy.sh/docs/config/#attachments", nil}
-	errHTTPBadRequestAttachmentsExpiryBeforeDelivery = &errHTTP{40015, http.StatusBadRequest, "invalid request: attachment expiry before delayed delivery date", "https://ntfy.sh/docs/publish/#scheduled-delivery", nil}
-	errHTTPBadRequestWebSocketsUpgradeHeaderMissing  = &errHTTP{40016, http.StatusBadRequest, "invalid request: client not using the websocket protocol", "https://ntfy.sh/docs/subscribe/api/#websockets", nil}
-	errHTTPBadRequestMessageJSONInvalid              = &errHTTP{40017, http.StatusBadRequest, "invalid request: request body must be message JSON", "https://ntfy.sh/docs/publish/#publish-as-json", nil}
-	errHTTPBadRequestActionsInvalid                  = &errHTTP{40018, http.StatusBadRequest, "invalid request: actions invalid", "https://ntfy.sh/docs/publish/#action-buttons", nil}
-	errHTTPBadRequestMatrixMessageInvalid            = &errHTTP{40019, http.StatusBadRequest, "invalid request: Matrix JSON invalid", "https://ntfy.sh/docs/publish/#matrix-gateway", nil}
-	errHTTPBadRequestIconURLInvalid                  = &errHTTP{40021, http.StatusBadRequest, "invalid request: icon URL is invalid", "https://ntfy.sh/docs/publish/#icons", nil}
-	errHTTPBadRequestSignupNotEnabled                = &errHTTP{40022, http.StatusBadRequest, "invalid request: signup not enabled", "https://ntfy.sh/docs/config", nil}
-	errHTTPBadRequestNoTokenProvided                 = &errHTTP{40023, http.StatusBadRequest, "invalid request: no token provided", "", nil}
-	errHTTPBadRequestJSONInvalid                     = &errHTTP{40024, http.StatusBadRequest, "invalid request: request body must be valid JSON", "", nil}
-	errHTTPBadRequestPermissionInvalid               = &errHTTP{40025, http.StatusBadRequest, "invalid request: incorrect permission string", "", nil}
-	errHTTPBadRequestIncorrectPasswordConfirmation   = &errHTTP{40026, http.StatusBadRequest, "invalid request: password confirmation is not correct", "", nil}
-	errHTTPBadRequestNotAPaidUser                    = &errHTTP{40027, http.StatusBadRequest, "invalid request: not a paid user", "", nil}
-	errHTTPBadRequestBillingRequestInvalid           = &errHTTP{40028, http.StatusBadRequest, "invalid request: not a valid billing request", "", nil}
-	errHTTPBadRequestBillingSubscriptionExists       = &errHTTP{40029, http.StatusBadRequest, "invalid request: billing subscription already exists", "", nil}
-	errHTTPBadRequestTierInvalid                     = &errHTTP{40030, http.StatusBadRequest, "invalid request: tier does not exist", "", nil}
-	errHTTPBadRequestUserNotFound                    = &errHTTP{40031, http.StatusBadRequest, "invalid request: user does not exist", "", nil}
-	errHTTPBadRequestPhoneCallsDisabled              = &errHTTP{40032, http.StatusBadRequest, "invalid request: calling is disabled", "https://ntfy.sh/docs/config/#phone-calls", nil}
-	errHTTPBadRequestPhoneNumberInvalid              = &errHTTP{40033, http.StatusBadRequest, "invalid request: phone number invalid", "https://ntfy.sh/docs/publish/#phone-calls", nil}
-	errHTTPBadRequestPhoneNumberNotVerified          = &errHTTP{40034, http.StatusBadRequest, "invalid request: phone number not verified, or no matching verified numbers found", "https://ntfy.sh/docs/publish/#phone-calls", nil}
-	errHTTPBadRequestAnonymousCallsNotAllowed        = &errHTTP{40035, http.StatusBadRequest, "invalid request: anonymous phone calls are not allowed", "https://ntfy.sh/docs/publish/#phone-calls", nil}
-	errHTTPBadRequestPhoneNumberVerifyChannelInvalid = &errHTTP{40036, http.StatusBadRequest, "invalid request: verification channel must be 'sms' or 'call'", "https://ntfy.sh/docs/publish/#phone-calls", nil}
-	errHTTPBadRequestDelayNoCall                     = &errHTTP{40037, http.StatusBadRequest, "delayed call notifications are not supported", "", nil}
-	errHTTPBadRequestWebPushSubscriptionInvalid      = &errHTTP{40038, http.StatusBadRequest, "invalid request: web push payload malformed", "", nil}
-	errHTTPBadRequestWebPushEndpointUnknown          = &errHTTP{40039, http.StatusBadRequest, "invalid request: web push endpoint unknown", "", nil}
-	errHTTPBadRequestWebPushTopicCountTooHigh        = &errHTTP{40040, http.StatusBadRequest, "invalid request: too many web push topic subscriptions", "", nil}
-	errHTTPNotFound                                  = &errHTTP{40401, http.StatusNotFound, "page not found", "", nil}
-	errHTTPUnauthorized                              = &errHTTP{40101, http.StatusUnauthorized, "unauthorized", "https://ntfy.sh/docs/publish/#authentication", nil}
-	errHTTPForbidden                                 = &errHTTP{40301, http.StatusForbidden, "forbidden", "https://ntfy.sh/docs/publish/#authentication", nil}
-	errHTTPConflictUserExists                        = &errHTTP{40901, http.StatusConflict, "conflict: user already exists", "", nil}
-	errHTTPConflictTopicReserved                     = &errHTTP{40902, http.StatusConflict, "conflict: access control entry for topic or topic pattern already exists", "", nil}
-	errHTTPConflictSubscriptionExists                = &errHTTP{40903, http.StatusConflict, "conflict: topic subscription already exists", "", nil}
-	errHTTPConflictPhoneNumberExists                 = &errHTTP{40904, http.StatusConflict, "conflict: phone number already exists", "", nil}
-	errHTTPGonePhoneVerificationExpired              = &errHTTP{41001, http.StatusGone, "phone number verification expired or does not exist", "", nil}
-	errHTTPEntityTooLargeAttachment                  = &errHTTP{41301, http.StatusRequestEntityTooLarge, "attachment too large, or bandwidth limit reached", "https://ntfy.sh/docs/publish/#limitations", nil}
-	errHTTPEntityTooLargeMatrixRequest               = &errHTTP{41302, http.StatusRequestEntityTooLarge, "Matrix request is larger than the max allowed length", "", nil}
-	errHTTPEntityTooLargeJSONBody                    = &errHTTP{41303, http.StatusRequestEntityTooLarge, "JSON body too large", "", nil}
-	errHTTPTooManyRequestsLimitRequests              = &errHTTP{42901, http.StatusTooManyRequests, "limit reached: too many requests", "https://ntfy.sh/docs/publish/#limitations", nil}
-	errHTTPTooManyRequestsLimitEmails                = &errHTTP{42902, http.StatusTooManyRequests, "limit reached: too many emails", "https://ntfy.sh/docs/publish/#limitations", nil}
-	errHTTPTooManyRequestsLimitSubscriptions         = &errHTTP{42903, http.StatusTooManyRequests, "limit reached: too many active subscriptions", "https://ntfy.sh/docs/publish/#limitations", nil}
-	errHTTPTooManyRequestsLimitTotalTopics           = &errHTTP{42904, http.StatusTooManyRequests, "limit reached: the total number of topics on the server has been reached, please contact the admin", "https://ntfy.sh/docs/publish/#limitations", nil}
-	errHTTPTooManyRequestsLimitAttachmentBandwidth   = &errHTTP{42905, http.StatusTooManyRequests, "limit reached: daily bandwidth reached", "https://ntfy.sh/docs/publish/#limitations", nil}
-	errHTTPTooManyRequestsLimitAccountCreation       = &errHTTP{42906, http.StatusTooManyRequests, "limit reached: too many accounts created", "https://ntfy.sh/docs/publish/#limitations", nil} // FIXME document limit
-	errHTTPTooManyRequestsLimitReservations          = &errHTTP{42907, http.StatusTooManyRequests, "limit reached: too many topic reservations for this user", "", nil}
-	errHTTPTooManyRequestsLimitMessages              = &errHTTP{42908, http.StatusTooManyRequests, "limit reached: daily message quota reached", "https://ntfy.sh/docs/publish/#limitations", nil}
-	errHTTPTooManyRequestsLimitAuthFailure           = &errHTTP{42909, http.StatusTooManyRequests, "limit reached: too many auth failures", "https://ntfy.sh/docs/publish/#limitations", nil} // FIXME document limit
-	errHTTPTooManyRequestsLimitCalls                 = &errHTTP{42910, http.StatusTooManyRequests, "limit reached: daily phone call quota reached", "https://ntfy.sh/docs/publish/#limitations", nil}
-	errHTTPInternalError                             = &errHTTP{50001, http.StatusInternalServerError, "internal server error", "", nil}
-	errHTTPInternalErrorInvalidPath                  = &errHTTP{50002, http.StatusInternalServerError, "internal server error: invalid path", "", nil}
-	errHTTPInternalErrorMissingBaseURL               = &errHTTP{50003, http.StatusInternalServerError, "internal server error: base-url must be be configured for this feature", "https://ntfy.sh/docs/config/", nil}
-	errHTTPInternalErrorWebPushUnableToPublish       = &errHTTP{50004, http.StatusInternalServerError, "internal server error: unable to publish web push message", "", nil}
-	errHTTPInsufficientStorageUnifiedPush            = &errHTTP{50701, http.StatusInsufficientStorage, "cannot publish to UnifiedPush topic without previously active subscriber", "", nil}
+	errHTTPBadRequest                                = newErrHTTP(40000, http.StatusBadRequest, "bad-request", "invalid request", "")
+	errHTTPBadRequestEmailDisabled                   = newErrHTTP(40001, http.StatusBadRequest, "bad-request-email-disabled", "e-mail notifications are not enabled", "https://ntfy.sh/docs/config/#e-mail-notifications")
+	errHTTPBadRequestDelayNoCache                    = newErrHTTP(40002, http.StatusBadRequest, "bad-request-delay-no-cache", "cannot disable cache for delayed message", "")
+	errHTTPBadRequestDelayNoEmail                    = newErrHTTP(40003, http.StatusBadRequest, "bad-request-delay-no-email", "delayed e-mail notifications are not supported", "")
+	errHTTPBadRequestDelayCannotParse                = newErrHTTP(40004, http.StatusBadRequest, "bad-request-delay-cannot-parse", "invalid delay parameter: unable to parse delay", "https://ntfy.sh/docs/publish/#scheduled-delivery")
+	errHTTPBadRequestDelayTooSmall                   = newErrHTTP(40005, http.StatusBadRequest, "bad-request-delay-too-small", "invalid delay parameter: too small, please refer to the docs", "https://ntfy.sh/docs/publish/#scheduled-delivery")
+	errHTTPBadRequestDelayTooLarge                   = newErrHTTP(40006, http.StatusBadRequest, "bad-request-delay-too-large", "invalid delay parameter: too large, please refer to the docs", "https://ntfy.sh/docs/publish/#scheduled-delivery")
+	errHTTPBadRequestPriorityInvalid                 = newErrHTTP(40007, http.StatusBadRequest, "bad-request-priority-invalid", "invalid priority parameter", "https://ntfy.sh/docs/publish/#message-priority")
+	errHTTPBadRequestSinceInvalid                    = newErrHTTP(40008, http.StatusBadRequest, "bad-request-since-invalid", "invalid since parameter", "https://ntfy.sh/docs/subscribe/api/#fetch-cached-messages")
+	errHTTPBadRequestTopicInvalid                    = newErrHTTP(40009, http.StatusBadRequest, "bad-request-topic-invalid", "invalid request: topic invalid", "")
+	errHTTPBadRequestTopicDisallowed                 = newErrHTTP(40010, http.StatusBadRequest, "bad-request-topic-disallowed", "invalid request: topic name is not allowed", "")
+	errHTTPBadRequestMessageNotUTF8                  = newErrHTTP(40011, http.StatusBadRequest, "bad-request-message-not-utf8", "invalid message: message must be UTF-8 encoded", "")
+	errHTTPBadRequestAttachmentURLInvalid            = newErrHTTP(40013, http.StatusBadRequest, "bad-request-attachment-url-invalid", "invalid request: attachment URL is invalid", "https://ntfy.sh/docs/publish/#attachments")
+	errHTTPBadRequestAttachmentsDisallowed           = newErrHTTP(40014, http.StatusBadRequest, "bad-request-attachments-disallowed", "invalid request: attachments not allowed", "https://ntfy.sh/docs/config/#attachments")
+	errHTTPBadRequestAttachmentsExpiryBeforeDelivery = newErrHTTP(40015, http.StatusBadRequest, "bad-request-attachments-expiry-before-delivery", "invalid request: attachment expiry before delayed delivery date", "https://ntfy.sh/docs/publish/#scheduled-delivery")
+	errHTTPBadRequestWebSocketsUpgradeHeaderMissing  = newErrHTTP(40016, http.StatusBadRequest, "bad-request-websocket-upgrade-header-missing", "invalid request: client not using the websocket protocol", "https://ntfy.sh/docs/subscribe/api/#websockets")
+	errHTTPBadRequestMessageJSONInvalid              = newErrHTTP(40017, http.StatusBadRequest, "bad-request-message-json-invalid", "invalid request: request body must be message JSON", "https://ntfy.sh/docs/publish/#publish-as-json")
+	errHTTPBadRequestActionsInvalid                  = newErrHTTP(40018, http.StatusBadRequest, "bad-request-actions-invalid", "invalid request: actions invalid", "https://ntfy.sh/docs/publish/#action-buttons")
+	errHTTPBadRequestMatrixMessageInvalid            = newErrHTTP(40019, http.StatusBadRequest, "bad-request-matrix-message-invalid", "invalid request: Matrix JSON invalid", "https://ntfy.sh/docs/publish/#matrix-gateway")
+	errHTTPBadRequestIconURLInvalid                  = newErrHTTP(40021, http.StatusBadRequest, "bad-request-icon-url-invalid", "invalid request: icon URL is invalid", "https://ntfy.sh/docs/publish/#icons")
+	errHTTPBadRequestSignupNotEnabled                = newErrHTTP(40022, http.StatusBadRequest, "bad-request-signup-not-enabled", "invalid request: signup not enabled", "https://ntfy.sh/docs/config")
+	errHTTPBadRequestNoTokenProvided                 = newErrHTTP(40023, http.StatusBadRequest, "bad-request-no-token-provided", "invalid request: no token provided", "")
+	errHTTPBadRequestJSONInvalid                     = newErrHTTP(40024, http.StatusBadRequest, "bad-request-json-invalid", "invalid request: request body must be valid JSON", "")
+	errHTTPBadRequestPermissionInvalid               = newErrHTTP(40025, http.StatusBadRequest, "bad-request-permission-invalid", "invalid request: incorrect permission string", "")
+	errHTTPBadRequestIncorrectPasswordConfirmation   = newErrHTTP(40026, http.StatusBadRequest, "bad-request-incorrect-password-confirmation", "invalid request: password confirmation is not correct", "")
+	errHTTPBadRequestNotAPaidUser                    = newErrHTTP(40027, http.StatusBadRequest, "bad-request-not-a-paid-user", "invalid request: not a paid user", "")
+	errHTTPBadRequestBillingRequestInvalid           = newErrHTTP(40028, http.StatusBadRequest, "bad-request-billing-request-invalid", "invalid request: not a valid billing request", "")
+	errHTTPBadRequestBillingSubscriptionExists       = newErrHTTP(40029, http.StatusBadRequest, "bad-request-billing-subscription-exists", "invalid request: billing subscription already exists", "")
+	errHTTPBadRequestTierInvalid                     = newErrHTTP(40030, http.StatusBadRequest, "bad-request-tier-invalid", "invalid request: tier does not exist", "")
+	errHTTPBadRequestUserNotFound                    = newErrHTTP(40031, http.StatusBadRequest, "bad-request-user-not-found", "invalid request: user does not exist", "")
+	errHTTPBadRequestPhoneCallsDisabled              = newErrHTTP(40032, http.StatusBadRequest, "bad-request-phone-calls-disabled", "invalid request: calling is disabled", "https://ntfy.sh/docs/config/#phone-calls")
+	errHTTPBadRequestPhoneNumberInvalid              = newErrHTTP(40033, http.StatusBadRequest, "bad-request-phone-number-invalid", "invalid request: phone number invalid", "https://ntfy.sh/docs/publish/#phone-calls")
+	errHTTPBadRequestPhoneNumberNotVerified          = newErrHTTP(40034, http.StatusBadRequest, "bad-request-phone-number-not-verified", "invalid request: phone number not verified, or no matching verified numbers found", "https://ntfy.sh/docs/publish/#phone-calls")
+	errHTTPBadRequestAnonymousCallsNotAllowed        = newErrHTTP(40035, http.StatusBadRequest, "bad-request-anonymous-calls-not-allowed", "invalid request: anonymous phone calls are not allowed", "https://ntfy.sh/docs/publish/#phone-calls")
+	errHTTPBadRequestPhoneNumberVerifyChannelInvalid = newErrHTTP(40036, http.StatusBadRequest, "bad-request-phone-number-verify-channel-invalid", "invalid request: verification channel must be 'sms' or 'call'", "https://ntfy.sh/docs/publish/#phone-calls")
+	errHTTPBadRequestDelayNoCall                     = newErrHTTP(40037, http.StatusBadRequest, "bad-request-delay-no-call", "delayed call notifications are not supported", "")
+	errHTTPBadRequestWebPushSubscriptionInvalid      = newErrHTTP(40038, http.StatusBadRequest, "bad-request-web-push-subscription-invalid", "invalid request: web push payload malformed", "")
+	errHTTPBadRequestWebPushEndpointUnknown          = newErrHTTP(40039, http.StatusBadRequest, "bad-request-web-push-endpoint-unknown", "invalid request: web push endpoint unknown", "")
+	errHTTPBadRequestWebPushTopicCountTooHigh        = newErrHTTP(40040, http.StatusBadRequest, "bad-request-web-push-topic-count-too-high", "invalid request: too many web push topic subscriptions", "")
+	errHTTPBadRequestNoPublishKeyProvided            = newErrHTTP(40041, http.StatusBadRequest, "bad-request-no-publish-key-provided", "invalid request: no publish key provided", "")
+	errHTTPBadRequestSCIMFilterInvalid               = newErrHTTP(40042, http.StatusBadRequest, "bad-request-scim-filter-invalid", "invalid request: unsupported SCIM filter, only 'userName eq \"...\"' is supported", "https://ntfy.sh/docs/config/#scim-provisioning")
+	errHTTPBadRequestSCIMOperationInvalid            = newErrHTTP(40043, http.StatusBadRequest, "bad-request-scim-operation-invalid", "invalid request: unsupported SCIM patch operation", "https://ntfy.sh/docs/config/#scim-provisioning")
+	errHTTPBadRequestGroupNotFound                   = newErrHTTP(40044, http.StatusBadRequest, "bad-request-group-not-found", "invalid request: group does not exist", "https://ntfy.sh/docs/config/#groups")
+	errHTTPBadRequestSoundInvalid                    = newErrHTTP(40045, http.StatusBadRequest, "bad-request-sound-invalid", "invalid request: sound name invalid", "https://ntfy.sh/docs/publish/#notification-sounds")
+	errHTTPBadRequestMatrixPushKeyMissing            = newErrHTTP(40046, http.StatusBadRequest, "bad-request-matrix-push-key-missing", "invalid request: pushkey parameter missing", "https://ntfy.sh/docs/publish/#matrix-gateway")
+	errHTTPBadRequestReportReasonTooLong             = newErrHTTP(40047, http.StatusBadRequest, "bad-request-report-reason-too-long", "invalid request: report reason too long", "https://ntfy.sh/docs/publish/#reporting-abuse")
+	errHTTPBadRequestReportActionInvalid             = newErrHTTP(40048, http.StatusBadRequest, "bad-request-report-action-invalid", "invalid request: report action invalid", "https://ntfy.sh/docs/config/#abuse-reports")
+	errHTTPBadRequestStatsHistoryRangeInvalid        = newErrHTTP(40049, http.StatusBadRequest, "bad-request-stats-history-range-invalid", "invalid request: stats history range invalid", "https://ntfy.sh/docs/config/#usage-history")
+	errHTTPBadRequestPresenceLabelInvalid            = newErrHTTP(40050, http.StatusBadRequest, "bad-request-presence-label-invalid", "invalid request: presence label invalid", "https://ntfy.sh/docs/subscribe/api/#presence")
+	errHTTPBadRequestReactionInvalid                 = newErrHTTP(40051, http.StatusBadRequest, "bad-request-reaction-invalid", "invalid request: reaction must be an emoji or emoji short code", "https://ntfy.sh/docs/publish/#reactions")
+	errHTTPBadRequestInReplyToInvalid                = newErrHTTP(40052, http.StatusBadRequest, "bad-request-in-reply-to-invalid", "invalid request: in-reply-to must be a valid message ID", "https://ntfy.sh/docs/publish/#threads")
+	errHTTPBadRequestTokenAllowedIPsInvalid          = newErrHTTP(40053, http.StatusBadRequest, "bad-request-token-allowed-ips-invalid", "invalid request: allowed IP ranges of token invalid", "https://ntfy.sh/docs/publish/#access-tokens")
+	errHTTPBadRequestEmailUndeliverable              = newErrHTTP(40054, http.StatusBadRequest, "bad-request-email-undeliverable", "invalid request: e-mail address is undeliverable, it bounced or reported e-mails as spam", "https://ntfy.sh/docs/config/#bounce-handling")
+	errHTTPBadRequestLocationInvalid                 = newErrHTTP(40055, http.StatusBadRequest, "bad-request-location-invalid", "invalid request: location must be latitude,longitude, e.g. 52.52,13.405", "https://ntfy.sh/docs/publish/#message-metadata")
+	errHTTPBadRequestHostnameInvalid                 = newErrHTTP(40056, http.StatusBadRequest, "bad-request-hostname-invalid", "invalid request: hostname invalid", "https://ntfy.sh/docs/publish/#message-metadata")
+	errHTTPBadRequestCorrelationIDInvalid            = newErrHTTP(40057, http.StatusBadRequest, "bad-request-correlation-id-invalid", "invalid request: correlation ID invalid", "https://ntfy.sh/docs/publish/#message-metadata")
+	errHTTPBadRequestRoutingInvalid                  = newErrHTTP(40058, http.StatusBadRequest, "bad-request-routing-invalid", "invalid request: routing rules invalid", "https://ntfy.sh/docs/config/#priority-based-routing")
+	errHTTPBadRequestEscalationInvalid               = newErrHTTP(40059, http.StatusBadRequest, "bad-request-escalation-invalid", "invalid request: escalation policy invalid", "https://ntfy.sh/docs/config/#escalation-policies")
+	errHTTPBadRequestScheduleInvalid                 = newErrHTTP(40060, http.StatusBadRequest, "bad-request-schedule-invalid", "invalid request: on-call schedule invalid", "https://ntfy.sh/docs/config/#on-call-schedules")
+	errHTTPBadRequestMaintenanceWindowInvalid        = newErrHTTP(40061, http.StatusBadRequest, "bad-request-maintenance-window-invalid", "invalid request: maintenance window invalid", "https://ntfy.sh/docs/publish/#maintenance-windows")
+	errHTTPBadRequestHeartbeatInvalid                = newErrHTTP(40062, http.StatusBadRequest, "bad-request-heartbeat-invalid", "invalid request: heartbeat invalid", "https://ntfy.sh/docs/publish/#heartbeat-monitoring")
+	errHTTPBadRequestPublishKeyDefaultsInvalid       = newErrHTTP(40063, http.StatusBadRequest, "bad-request-publish-key-defaults-invalid", "invalid request: publish key defaults invalid", "https://ntfy.sh/docs/publish/#publish-keys")
+	errHTTPBadRequestSignedURLInvalid                = newErrHTTP(40064, http.StatusBadRequest, "bad-request-signed-url-invalid", "invalid request: signed publish URL request invalid", "https://ntfy.sh/docs/publish/#signed-publish-urls")
+	errHTTPBadRequestSignedURLsDisabled              = newErrHTTP(40065, http.StatusBadRequest, "bad-request-signed-urls-disabled", "invalid request: signed publish URLs are not enabled", "https://ntfy.sh/docs/config/#signed-publish-urls")
+	errHTTPBadRequestTopicPolicyInvalid              = newErrHTTP(40066, http.StatusBadRequest, "bad-request-topic-policy-invalid", "invalid request: topic policy invalid", "https://ntfy.sh/docs/config/#topic-policies")
+	errHTTPBadRequestTopicPolicyAttachment           = newErrHTTP(40067, http.StatusBadRequest, "bad-request-topic-policy-attachment", "invalid request: attachments are not allowed on this topic", "https://ntfy.sh/docs/config/#topic-policies")
+	errHTTPBadRequestTopicPolicyPriority             = newErrHTTP(40068, http.StatusBadRequest, "bad-request-topic-policy-priority", "invalid request: priority is not allowed on this topic", "https://ntfy.sh/docs/config/#topic-policies")
+	errHTTPBadRequestKeepaliveInvalid                = newErrHTTP(40069, http.StatusBadRequest, "bad-request-keepalive-invalid", "invalid request: keepalive interval invalid, must be a duration (e.g. 30s, 5m)", "https://ntfy.sh/docs/subscribe/api/#keepalive-and-retry-interval")
+	errHTTPBadRequestRetryInvalid                    = newErrHTTP(40070, http.StatusBadRequest, "bad-request-retry-invalid", "invalid request: retry interval invalid, must be a duration (e.g. 10s, 1m)", "https://ntfy.sh/docs/subscribe/api/#keepalive-and-retry-interval")
+	errHTTPBadRequestTemplateNotFound                = newErrHTTP(40071, http.StatusBadRequest, "bad-request-template-not-found", "invalid request: message template not found", "https://ntfy.sh/docs/publish/#message-templates")
+	errHTTPBadRequestTemplateInvalid                 = newErrHTTP(40072, http.StatusBadRequest, "bad-request-template-invalid", "invalid request: message template invalid", "https://ntfy.sh/docs/publish/#message-templates")
+	errHTTPBadRequestTemplateDataInvalid             = newErrHTTP(40073, http.StatusBadRequest, "bad-request-template-data-invalid", "invalid request: body of a templated message must be valid JSON", "https://ntfy.sh/docs/publish/#message-templates")
+	errHTTPBadRequestExportFormatInvalid             = newErrHTTP(40074, http.StatusBadRequest, "bad-request-export-format-invalid", "invalid request: export format must be csv or ndjson", "https://ntfy.sh/docs/subscribe/api/#export-message-history")
+	errHTTPBadRequestAnnotationInvalid               = newErrHTTP(40075, http.StatusBadRequest, "bad-request-annotation-invalid", "invalid request: annotation must be a single line of text, up to 256 characters", "https://ntfy.sh/docs/publish/#annotations")
+	errHTTPBadRequestAttachmentIDInvalid             = newErrHTTP(40076, http.StatusBadRequest, "bad-request-attachment-id-invalid", "invalid request: attachment ID is invalid, or cannot be combined with an attachment URL", "https://ntfy.sh/docs/publish/#upload-attachment-separately")
+	errHTTPBadRequestImpersonationNotAllowed         = newErrHTTP(40077, http.StatusBadRequest, "bad-request-impersonation-not-allowed", "invalid request: only regular users can be impersonated", "https://ntfy.sh/docs/config/#impersonating-users")
+	errHTTPNotFound                                  = newErrHTTP(40401, http.StatusNotFound, "not-found", "page not found", "")
+	errHTTPNotFoundUser                              = newErrHTTP(40402, http.StatusNotFound, "not-found-user", "user not found", "")
+	errHTTPNotFoundMessage                           = newErrHTTP(40403, http.StatusNotFound, "not-found-message", "message not found", "https://ntfy.sh/docs/publish/#delivery-log")
+	errHTTPNotFoundWebPushSubscription               = newErrHTTP(40404, http.StatusNotFound, "not-found-web-push-subscription", "web push subscription not found", "")
+	errHTTPNotFoundAccountDeletion                   = newErrHTTP(40405, http.StatusNotFound, "not-found-account-deletion", "no account deletion scheduled", "https://ntfy.sh/docs/config/#deleting-accounts")
+	errHTTPNotFoundVisitor                           = newErrHTTP(40406, http.StatusNotFound, "not-found-visitor", "visitor not found", "https://ntfy.sh/docs/config/#inspecting-and-resetting-visitors")
+	errHTTPNotFoundSchedule                          = newErrHTTP(40407, http.StatusNotFound, "not-found-schedule", "no on-call schedule defined for topic", "https://ntfy.sh/docs/config/#on-call-schedules")
+	errHTTPNotFoundMaintenanceWindow                 = newErrHTTP(40408, http.StatusNotFound, "not-found-maintenance-window", "maintenance window not found", "https://ntfy.sh/docs/publish/#maintenance-windows")
+	errHTTPNotFoundHeartbeat                         = newErrHTTP(40409, http.StatusNotFound, "not-found-heartbeat", "no heartbeat defined for topic", "https://ntfy.sh/docs/publish/#heartbeat-monitoring")
+	errHTTPNotFoundTemplate                          = newErrHTTP(40410, http.StatusNotFound, "not-found-template", "message template not found", "https://ntfy.sh/docs/publish/#message-templates")
+	errHTTPNotFoundAnnotation                        = newErrHTTP(40411, http.StatusNotFound, "not-found-annotation", "annotation not found", "https://ntfy.sh/docs/publish/#annotations")
+	errHTTPNotFoundAttachmentUpload                  = newErrHTTP(40412, http.StatusNotFound, "not-found-attachment-upload", "uploaded attachment not found, or expired", "https://ntfy.sh/docs/publish/#upload-attachment-separately")
+	errHTTPUnauthorized                              = newErrHTTP(40101, http.StatusUnauthorized, "unauthorized", "unauthorized", "https://ntfy.sh/docs/publish/#authentication")
+	errHTTPForbidden                                 = newErrHTTP(40301, http.StatusForbidden, "forbidden", "forbidden", "https://ntfy.sh/docs/publish/#authentication")
+	errHTTPForbiddenCSRFTokenInvalid                 = newErrHTTP(40302, http.StatusForbidden, "forbidden-csrf-token-invalid", "forbidden: CSRF token missing or invalid", "https://ntfy.sh/docs/config/#cors-and-csrf-protection")
+	errHTTPForbiddenTopicBlocked                     = newErrHTTP(40303, http.StatusForbidden, "forbidden-topic-blocked", "forbidden: topic has been blocked by the server admin", "")
+	errHTTPForbiddenIPBanned                         = newErrHTTP(40304, http.StatusForbidden, "forbidden-ip-banned", "forbidden: IP address has been banned by the server admin", "")
+	errHTTPForbiddenTokenOriginNotAllowed            = newErrHTTP(40305, http.StatusForbidden, "forbidden-token-origin-not-allowed", "forbidden: access token cannot be used from this IP address", "https://ntfy.sh/docs/publish/#access-tokens")
+	errHTTPForbiddenImpersonating                    = newErrHTTP(40306, http.StatusForbidden, "forbidden-impersonating", "forbidden: not allowed while impersonating a user", "https://ntfy.sh/docs/config/#impersonating-users")
+	errHTTPForbiddenSignedURLInvalid                 = newErrHTTP(40307, http.StatusForbidden, "forbidden-signed-url-invalid", "forbidden: signature of publish URL invalid", "https://ntfy.sh/docs/publish/#signed-publish-urls")
+	errHTTPForbiddenSignedURLExpired                 = newErrHTTP(40308, http.StatusForbidden, "forbidden-signed-url-expired", "forbidden: signed publish URL expired", "https://ntfy.sh/docs/publish/#signed-publish-urls")
+	errHTTPForbiddenWebhookSignatureInvalid          = newErrHTTP(40309, http.StatusForbidden, "forbidden-webhook-signature-invalid", "forbidden: webhook signature missing or invalid", "https://ntfy.sh/docs/config/#webhook-signatures")
+	errHTTPForbiddenTemplateInline                   = newErrHTTP(40310, http.StatusForbidden, "forbidden-template-inline", "forbidden: inline templates are only allowed for authenticated users", "https://ntfy.sh/docs/publish/#message-templates")
+	errHTTPConflictUserExists                        = newErrHTTP(40901, http.StatusConflict, "conflict-user-exists", "conflict: user already exists", "")
+	errHTTPConflictTopicReserved                     = newErrHTTP(40902, http.StatusConflict, "conflict-topic-reserved", "conflict: access control entry for topic or topic pattern already exists", "")
+	errHTTPConflictSubscriptionExists                = newErrHTTP(40903, http.StatusConflict, "conflict-subscription-exists", "conflict: topic subscription already exists", "")
+	errHTTPConflictPhoneNumberExists                 = newErrHTTP(40904, http.StatusConflict, "conflict-phone-number-exists", "conflict: phone number already exists", "")
+	errHTTPConflictGroupExists                       = newErrHTTP(40905, http.StatusConflict, "conflict-group-exists", "conflict: group already exists", "")
+	errHTTPGonePhoneVerificationExpired              = newErrHTTP(41001, http.StatusGone, "gone-phone-verification-expired", "phone number verification expired or does not exist", "")
+	errHTTPEntityTooLargeAttachment                  = newErrHTTP(41301, http.StatusRequestEntityTooLarge, "entity-too-large-attachment", "attachment too large, or bandwidth limit reached", "https://ntfy.sh/docs/publish/#limitations")
+	errHTTPEntityTooLargeMatrixRequest               = newErrHTTP(41302, http.StatusRequestEntityTooLarge, "entity-too-large-matrix-request", "Matrix request is larger than the max allowed length", "")
+	errHTTPEntityTooLargeJSONBody                    = newErrHTTP(41303, http.StatusRequestEntityTooLarge, "entity-too-large-json-body", "JSON body too large", "")
+	errHTTPEntityTooLargeAttachmentFileSize          = newErrHTTP(41304, http.StatusRequestEntityTooLarge, "entity-too-large-attachment-file-size", "attachment too large, exceeds the file size limit", "https://ntfy.sh/docs/publish/#limitations")
+	errHTTPEntityTooLargeAttachmentQuota             = newErrHTTP(41305, http.StatusRequestEntityTooLarge, "entity-too-large-attachment-quota", "attachment storage quota exceeded", "https://ntfy.sh/docs/publish/#limitations")
+	errHTTPEntityTooLargeTopicPolicyMessage          = newErrHTTP(41306, http.StatusRequestEntityTooLarge, "entity-too-large-topic-policy-message", "message too large, exceeds the message size limit of this topic", "https://ntfy.sh/docs/config/#topic-policies")
+	errHTTPEntityTooLargeWebhookBody                 = newErrHTTP(41307, http.StatusRequestEntityTooLarge, "entity-too-large-webhook-body", "signed webhook request body too large", "https://ntfy.sh/docs/config/#webhook-signatures")
+	errHTTPTooManyRequestsLimitRequests              = newErrHTTP(42901, http.StatusTooManyRequests, "too-many-requests-limit-requests", "limit reached: too many requests", "https://ntfy.sh/docs/publish/#limitations")
+	errHTTPTooManyRequestsLimitEmails                = newErrHTTP(42902, http.StatusTooManyRequests, "too-many-requests-limit-emails", "limit reached: too many emails", "https://ntfy.sh/docs/publish/#limitations")
+	errHTTPTooManyRequestsLimitSubscriptions         = newErrHTTP(42903, http.StatusTooManyRequests, "too-many-requests-limit-subscriptions", "limit reached: too many active subscriptions", "https://ntfy.sh/docs/publish/#limitations")
+	errHTTPTooManyRequestsLimitTotalTopics           = newErrHTTP(42904, http.StatusTooManyRequests, "too-many-requests-limit-total-topics", "limit reached: the total number of topics on the server has been reached, please contact the admin", "https://ntfy.sh/docs/publish/#limitations")
+	errHTTPTooManyRequestsLimitAttachmentBandwidth   = newErrHTTP(42905, http.StatusTooManyRequests, "too-many-requests-limit-attachment-bandwidth", "limit reached: daily bandwidth reached", "https://ntfy.sh/docs/publish/#limitations")
+	errHTTPTooManyRequestsLimitAccountCreation       = newErrHTTP(42906, http.StatusTooManyRequests, "too-many-requests-limit-account-creation", "limit reached: too many accounts created", "https://ntfy.sh/docs/publish/#limitations") // FIXME document limit
+	errHTTPTooManyRequestsLimitReservations          = newErrHTTP(42907, http.StatusTooManyRequests, "too-many-requests-limit-reservations", "limit reached: too many topic reservations for this user", "")
+	errHTTPTooManyRequestsLimitMessages              = newErrHTTP(42908, http.StatusTooManyRequests, "too-many-requests-limit-messages", "limit reached: daily message quota reached", "https://ntfy.sh/docs/publish/#limitations")
+	errHTTPTooManyRequestsLimitAuthFailure           = newErrHTTP(42909, http.StatusTooManyRequests, "too-many-requests-limit-auth-failure", "limit reached: too many auth failures", "https://ntfy.sh/docs/publish/#limitations") // FIXME document limit
+	errHTTPTooManyRequestsLimitCalls                 = newErrHTTP(42910, http.StatusTooManyRequests, "too-many-requests-limit-calls", "limit reached: daily phone call quota reached", "https://ntfy.sh/docs/publish/#limitations")
+	errHTTPTooManyRequestsLimitPublishKeys           = newErrHTTP(42911, http.StatusTooManyRequests, "too-many-requests-limit-publish-keys", "limit reached: too many publish keys for this topic", "")
+	errHTTPTooManyRequestsLimitWebPushSubscriptions  = newErrHTTP(42912, http.StatusTooManyRequests, "too-many-requests-limit-web-push-subscriptions", "limit reached: too many web push subscriptions for this user", "https://ntfy.sh/docs/config/#web-push")
+	errHTTPTooManyRequestsLimitMaintenanceWindows    = newErrHTTP(42913, http.StatusTooManyRequests, "too-many-requests-limit-maintenance-windows", "limit reached: too many maintenance windows for this topic", "https://ntfy.sh/docs/publish/#maintenance-windows")
+	errHTTPTooManyRequestsLimitTemplates             = newErrHTTP(42914, http.StatusTooManyRequests, "too-many-requests-limit-templates", "limit reached: too many message templates for this user", "https://ntfy.sh/docs/publish/#message-templates")
+	errHTTPTooManyRequestsLimitHeartbeats            = newErrHTTP(42916, http.StatusTooManyRequests, "too-many-requests-limit-heartbeats", "limit reached: too many heartbeats for this user", "https://ntfy.sh/docs/publish/#heartbeat-monitoring")
+	errHTTPTooManyRequestsLimitAnnotations           = newErrHTTP(42915, http.StatusTooManyRequests, "too-many-requests-limit-annotations", "limit reached: too many annotations for this message", "https://ntfy.sh/docs/publish/#annotations")
+	errHTTPInternalError                             = newErrHTTP(50001, http.StatusInternalServerError, "internal-error", "internal server error", "")
+	errHTTPInternalErrorInvalidPath                  = newErrHTTP(50002, http.StatusInternalServerError, "internal-error-invalid-path", "internal server error: invalid path", "")
+	errHTTPInternalErrorMissingBaseURL               = newErrHTTP(50003, http.StatusInternalServerError, "internal-error-missing-base-url", "internal server error: base-url must be be configured for this feature", "https://ntfy.sh/docs/config/")
+	errHTTPInternalErrorWebPushUnableToPublish       = newErrHTTP(50004, http.StatusInternalServerError, "internal-error-web-push-unable-to-publish", "internal server error: unable to publish web push message", "")
+	errHTTPInsufficientStorageUnifiedPush            = newErrHTTP(50701, http.StatusInsufficientStorage, "insufficient-storage-unified-push", "cannot publish to UnifiedPush topic without previously active subscriber", "")
 )
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestErrHTTP_Catalog_Unique(t *testing.T) {
+	slugRegex := regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	codes, slugs := make(map[int]bool), make(map[string]bool)
+	for _, e := range errHTTPCatalog {
+		require.False(t, codes[e.Code], "duplicate error code %d", e.Code)
+		require.False(t, slugs[e.Slug], "duplicate error slug %s", e.Slug)
+		require.Regexp(t, slugRegex, e.Slug)
+		require.Equal(t, e.HTTPCode, e.Code/100, "error code %d does not match HTTP status %d", e.Code, e.HTTPCode)
+		codes[e.Code], slugs[e.Slug] = true, true
+	}
+}
+
+func TestErrHTTP_JSON(t *testing.T) {
+	require.Equal(t, `{"code":42901,"http":429,"slug":"too-many-requests-limit-requests","error":"limit reached: too many requests","link":"https://ntfy.sh/docs/publish/#limitations","retryable":true}`, errHTTPTooManyRequestsLimitRequests.JSON())
+	require.Equal(t, `{"code":41303,"http":413,"slug":"entity-too-large-json-body","error":"JSON body too large; oh no","retryable":false,"limit":100}`, errHTTPEntityTooLargeJSONBody.WithLimit(100).Wrap("oh no").JSON())
+}
+
+func TestServer_Errors(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "GET", "/v1/errors", "", nil)
+	require.Equal(t, 200, response.Code)
+	catalog, err := util.UnmarshalJSON[apiErrorsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, len(errHTTPCatalog), len(catalog.Errors))
+	require.Equal(t, 40000, catalog.Errors[0].Code)
+	require.Equal(t, "bad-request", catalog.Errors[0].Slug)
+	for i := 1; i < len(catalog.Errors); i++ {
+		require.Less(t, catalog.Errors[i-1].Code, catalog.Errors[i].Code)
+	}
+	for _, e := range catalog.Errors {
+		if e.Code == 42901 {
+			require.Equal(t, http.StatusTooManyRequests, e.HTTPCode)
+			require.True(t, e.Retryable)
+		} else if e.Code == 40101 {
+			require.Equal(t, "unauthorized", e.Slug)
+			require.False(t, e.Retryable)
+		}
+	}
+}
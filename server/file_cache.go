@@ -1,6 +1,8 @@
 package server
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"heckel.io/ntfy/v2/log"
@@ -40,36 +42,59 @@ func newFileCache(dir string, totalSizeLimit int64) (*fileCache, error) {
 	}, nil
 }
 
-func (c *fileCache) Write(id string, in io.Reader, limiters ...util.Limiter) (int64, error) {
+// Write writes the attachment with the given ID to the cache, and returns its size and SHA-256 hash (hex-encoded).
+// The hash can be used to deduplicate attachments with identical content, see Link.
+func (c *fileCache) Write(id string, in io.Reader, limiters ...util.Limiter) (size int64, hash string, err error) {
 	if !fileIDRegex.MatchString(id) {
-		return 0, errInvalidFileID
+		return 0, "", errInvalidFileID
 	}
 	log.Tag(tagFileCache).Field("message_id", id).Debug("Writing attachment")
 	file := filepath.Join(c.dir, id)
 	if _, err := os.Stat(file); err == nil {
-		return 0, errFileExists
+		return 0, "", errFileExists
 	}
 	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 	defer f.Close()
 	limiters = append(limiters, util.NewFixedLimiter(c.Remaining()))
 	limitWriter := util.NewLimitWriter(f, limiters...)
-	size, err := io.Copy(limitWriter, in)
+	hasher := sha256.New()
+	size, err = io.Copy(io.MultiWriter(limitWriter, hasher), in)
 	if err != nil {
 		os.Remove(file)
-		return 0, err
+		return 0, "", err
 	}
 	if err := f.Close(); err != nil {
 		os.Remove(file)
-		return 0, err
+		return 0, "", err
 	}
 	c.mu.Lock()
 	c.totalSizeCurrent += size
 	mset(metricAttachmentsTotalSize, c.totalSizeCurrent)
 	c.mu.Unlock()
-	return size, nil
+	return size, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Link replaces the attachment with the given ID with a hard link to the attachment existingID, which must have
+// identical content. This stores the content only once. The file system keeps track of the number of links
+// to the content, and frees the disk space only once all attachments referring to it have been removed.
+func (c *fileCache) Link(id, existingID string) error {
+	if !fileIDRegex.MatchString(id) || !fileIDRegex.MatchString(existingID) {
+		return errInvalidFileID
+	}
+	log.Tag(tagFileCache).Fields(log.Context{"message_id": id, "existing_message_id": existingID}).Debug("Linking attachment to existing attachment")
+	file, existingFile := filepath.Join(c.dir, id), filepath.Join(c.dir, existingID)
+	tmpFile := file + ".link"
+	if err := os.Link(existingFile, tmpFile); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, file); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return c.updateSize()
 }
 
 func (c *fileCache) Remove(ids ...string) error {
@@ -83,6 +108,20 @@ func (c *fileCache) Remove(ids ...string) error {
 			log.Tag(tagFileCache).Field("message_id", id).Err(err).Debug("Error deleting attachment")
 		}
 	}
+	return c.updateSize()
+}
+
+// CheckWritable verifies that the attachment directory exists and that files can be written to it
+func (c *fileCache) CheckWritable() error {
+	f, err := os.CreateTemp(c.dir, ".ready-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func (c *fileCache) updateSize() error {
 	size, err := dirSize(c.dir)
 	if err != nil {
 		return err
@@ -110,18 +149,34 @@ func (c *fileCache) Remaining() int64 {
 	return remaining
 }
 
+// dirSize returns the total size of all files in the given directory. Hard links to the same file
+// (see fileCache.Link) are only counted once.
 func dirSize(dir string) (int64, error) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return 0, err
 	}
 	var size int64
+	filesBySize := make(map[int64][]os.FileInfo) // Hard links have the same size, so only compare those
 	for _, e := range entries {
 		info, err := e.Info()
 		if err != nil {
 			return 0, err
 		}
+		if sameFileSeen(filesBySize[info.Size()], info) {
+			continue
+		}
+		filesBySize[info.Size()] = append(filesBySize[info.Size()], info)
 		size += info.Size()
 	}
 	return size, nil
 }
+
+func sameFileSeen(files []os.FileInfo, info os.FileInfo) bool {
+	for _, f := range files {
+		if os.SameFile(f, info) {
+			return true
+		}
+	}
+	return false
+}
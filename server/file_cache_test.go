@@ -16,9 +16,10 @@ var (
 
 func TestFileCache_Write_Success(t *testing.T) {
 	dir, c := newTestFileCache(t)
-	size, err := c.Write("abcdefghijkl", strings.NewReader("normal file"), util.NewFixedLimiter(999))
+	size, hash, err := c.Write("abcdefghijkl", strings.NewReader("normal file"), util.NewFixedLimiter(999))
 	require.Nil(t, err)
 	require.Equal(t, int64(11), size)
+	require.Equal(t, "87f644d525b412d6162932d06db1bc06aaa0508374badc861e40ad85b0e01412", hash)
 	require.Equal(t, "normal file", readFile(t, dir+"/abcdefghijkl"))
 	require.Equal(t, int64(11), c.Size())
 	require.Equal(t, int64(10229), c.Remaining())
@@ -27,7 +28,7 @@ func TestFileCache_Write_Success(t *testing.T) {
 func TestFileCache_Write_Remove_Success(t *testing.T) {
 	dir, c := newTestFileCache(t) // max = 10k (10240), each = 1k (1024)
 	for i := 0; i < 10; i++ {     // 10x999 = 9990
-		size, err := c.Write(fmt.Sprintf("abcdefghijk%d", i), bytes.NewReader(make([]byte, 999)))
+		size, _, err := c.Write(fmt.Sprintf("abcdefghijk%d", i), bytes.NewReader(make([]byte, 999)))
 		require.Nil(t, err)
 		require.Equal(t, int64(999), size)
 	}
@@ -46,22 +47,50 @@ func TestFileCache_Write_Remove_Success(t *testing.T) {
 func TestFileCache_Write_FailedTotalSizeLimit(t *testing.T) {
 	dir, c := newTestFileCache(t)
 	for i := 0; i < 10; i++ {
-		size, err := c.Write(fmt.Sprintf("abcdefghijk%d", i), bytes.NewReader(oneKilobyteArray))
+		size, _, err := c.Write(fmt.Sprintf("abcdefghijk%d", i), bytes.NewReader(oneKilobyteArray))
 		require.Nil(t, err)
 		require.Equal(t, int64(1024), size)
 	}
-	_, err := c.Write("abcdefghijkX", bytes.NewReader(oneKilobyteArray))
+	_, _, err := c.Write("abcdefghijkX", bytes.NewReader(oneKilobyteArray))
 	require.Equal(t, util.ErrLimitReached, err)
 	require.NoFileExists(t, dir+"/abcdefghijkX")
 }
 
 func TestFileCache_Write_FailedAdditionalLimiter(t *testing.T) {
 	dir, c := newTestFileCache(t)
-	_, err := c.Write("abcdefghijkl", bytes.NewReader(make([]byte, 1001)), util.NewFixedLimiter(1000))
+	_, _, err := c.Write("abcdefghijkl", bytes.NewReader(make([]byte, 1001)), util.NewFixedLimiter(1000))
 	require.Equal(t, util.ErrLimitReached, err)
 	require.NoFileExists(t, dir+"/abcdefghijkl")
 }
 
+func TestFileCache_Link_Success(t *testing.T) {
+	dir, c := newTestFileCache(t)
+	_, hash1, err := c.Write("abcdefghijk1", bytes.NewReader(oneKilobyteArray))
+	require.Nil(t, err)
+	_, hash2, err := c.Write("abcdefghijk2", bytes.NewReader(oneKilobyteArray))
+	require.Nil(t, err)
+	require.Equal(t, hash1, hash2)
+	require.Equal(t, int64(2048), c.Size())
+
+	// Identical content is only counted once
+	require.Nil(t, c.Link("abcdefghijk2", "abcdefghijk1"))
+	require.Equal(t, int64(1024), c.Size())
+	require.Equal(t, oneKilobyteArray, []byte(readFile(t, dir+"/abcdefghijk2")))
+
+	// Links are only counted once when reading the cache directory
+	c2, err := newFileCache(dir, 10*1024)
+	require.Nil(t, err)
+	require.Equal(t, int64(1024), c2.Size())
+
+	// Content is kept until all links are removed
+	require.Nil(t, c.Remove("abcdefghijk1"))
+	require.FileExists(t, dir+"/abcdefghijk2")
+	require.Equal(t, int64(1024), c.Size())
+	require.Nil(t, c.Remove("abcdefghijk2"))
+	require.NoFileExists(t, dir+"/abcdefghijk2")
+	require.Equal(t, int64(0), c.Size())
+}
+
 func newTestFileCache(t *testing.T) (dir string, cache *fileCache) {
 	dir = t.TempDir()
 	cache, err := newFileCache(dir, 10*1024)
@@ -0,0 +1,900 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file implements the subset of GraphQL (https://spec.graphql.org/October2021/) that is needed for the
+// read-only query API (see server_graphql.go): query operations with variables, aliases and nested selections.
+// Fragments, directives, mutations, subscriptions and introspection are not supported.
+
+const (
+	graphQLOperationQuery = "query"
+	graphQLTypeNameField  = "__typename"
+	graphQLMaxDepth       = 10
+)
+
+var (
+	errGraphQLFragmentsNotSupported  = errors.New("fragments are not supported")
+	errGraphQLDirectivesNotSupported = errors.New("directives are not supported")
+)
+
+// graphQLDocument is a parsed GraphQL request document
+type graphQLDocument struct {
+	Operations []*graphQLOperation
+}
+
+type graphQLOperation struct {
+	Type       string // query, mutation or subscription
+	Name       string
+	Variables  []*graphQLVariableDefinition
+	Selections []*graphQLSelection
+}
+
+type graphQLVariableDefinition struct {
+	Name       string
+	Type       string
+	Default    any
+	HasDefault bool
+}
+
+// graphQLSelection is a field in a selection set, e.g. "latest: messages(topics: $topics) { id }"
+type graphQLSelection struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]any
+	Selections []*graphQLSelection
+}
+
+// graphQLVariable is a reference to a variable (e.g. $topics) in an argument value
+type graphQLVariable string
+
+// graphQLObject is a GraphQL object whose fields are computed by resolvers, so that only the requested
+// fields are computed. Values that are not a graphQLObject (e.g. structs) are resolved via their JSON tags.
+type graphQLObject struct {
+	Type   string
+	Fields map[string]*graphQLFieldResolver
+}
+
+type graphQLFieldResolver struct {
+	Args    []string
+	Resolve func(args map[string]any) (any, error)
+}
+
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+type graphQLResponse struct {
+	Data   any             `json:"data,omitempty"`
+	Errors []*graphQLError `json:"errors,omitempty"`
+}
+
+type graphQLError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// graphQLResult is a JSON object that keeps the order of its fields, since GraphQL responses must
+// list the fields in the order in which they were requested
+type graphQLResult struct {
+	keys   []string
+	values map[string]any
+}
+
+func newGraphQLResult() *graphQLResult {
+	return &graphQLResult{
+		keys:   make([]string, 0),
+		values: make(map[string]any),
+	}
+}
+
+func (r *graphQLResult) Set(key string, value any) {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = value
+}
+
+func (r *graphQLResult) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, key := range r.keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(r.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteString(":")
+		buf.Write(v)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// executeGraphQL parses the query, and resolves the selected operation against the given root object
+func executeGraphQL(req *graphQLRequest, root *graphQLObject) *graphQLResponse {
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		return newGraphQLErrorResponse(err)
+	}
+	op, err := doc.Operation(req.OperationName)
+	if err != nil {
+		return newGraphQLErrorResponse(err)
+	} else if op.Type != graphQLOperationQuery {
+		return newGraphQLErrorResponse(fmt.Errorf("%s operations are not supported, only queries are allowed", op.Type))
+	}
+	variables, err := op.coerceVariables(req.Variables)
+	if err != nil {
+		return newGraphQLErrorResponse(err)
+	}
+	e := &graphQLExecutor{variables: variables, errors: make([]*graphQLError, 0)}
+	response := &graphQLResponse{
+		Data: e.resolveSelections(root, op.Selections, make([]any, 0)),
+	}
+	if len(e.errors) > 0 {
+		response.Errors = e.errors
+	}
+	return response
+}
+
+func newGraphQLErrorResponse(err error) *graphQLResponse {
+	return &graphQLResponse{
+		Errors: []*graphQLError{{Message: err.Error()}},
+	}
+}
+
+// Operation returns the operation with the given name, or the only operation if name is empty
+func (d *graphQLDocument) Operation(name string) (*graphQLOperation, error) {
+	if name == "" {
+		if len(d.Operations) != 1 {
+			return nil, errors.New("operationName is required if the document contains more than one operation")
+		}
+		return d.Operations[0], nil
+	}
+	for _, op := range d.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %s", name)
+}
+
+func (o *graphQLOperation) coerceVariables(values map[string]any) (map[string]any, error) {
+	variables := make(map[string]any)
+	for _, def := range o.Variables {
+		if value, ok := values[def.Name]; ok {
+			variables[def.Name] = value
+		} else if def.HasDefault {
+			variables[def.Name] = def.Default
+		} else if strings.HasSuffix(def.Type, "!") {
+			return nil, fmt.Errorf("variable $%s of required type %s was not provided", def.Name, def.Type)
+		}
+	}
+	return variables, nil
+}
+
+type graphQLExecutor struct {
+	variables map[string]any
+	errors    []*graphQLError
+}
+
+func (e *graphQLExecutor) fail(path []any, err error) {
+	e.errors = append(e.errors, &graphQLError{
+		Message: err.Error(),
+		Path:    append(make([]any, 0, len(path)), path...),
+	})
+}
+
+// resolveSelections resolves the selected fields of an object value, which is either a graphQLObject, a struct
+// (fields are looked up via JSON tag), or a map. Errors are collected, and the failed field is set to null.
+func (e *graphQLExecutor) resolveSelections(value any, selections []*graphQLSelection, path []any) *graphQLResult {
+	result := newGraphQLResult()
+	for _, sel := range selections {
+		key := sel.Alias
+		if key == "" {
+			key = sel.Name
+		}
+		fieldPath := append(path, key)
+		if len(fieldPath) > graphQLMaxDepth {
+			e.fail(fieldPath, fmt.Errorf("query exceeds maximum depth of %d", graphQLMaxDepth))
+			result.Set(key, nil)
+			continue
+		}
+		fieldValue, err := e.resolveField(value, sel)
+		if err != nil {
+			e.fail(fieldPath, err)
+			result.Set(key, nil)
+			continue
+		}
+		completed, err := e.complete(fieldValue, sel, fieldPath)
+		if err != nil {
+			e.fail(fieldPath, err)
+			result.Set(key, nil)
+			continue
+		}
+		result.Set(key, completed)
+	}
+	return result
+}
+
+func (e *graphQLExecutor) resolveField(value any, sel *graphQLSelection) (any, error) {
+	if obj, ok := value.(*graphQLObject); ok {
+		if sel.Name == graphQLTypeNameField {
+			return obj.Type, nil
+		}
+		field, ok := obj.Fields[sel.Name]
+		if !ok {
+			return nil, fmt.Errorf("cannot query field %s on type %s", sel.Name, obj.Type)
+		}
+		args, err := e.arguments(sel, field.Args)
+		if err != nil {
+			return nil, err
+		}
+		return field.Resolve(args)
+	}
+	if len(sel.Arguments) > 0 {
+		return nil, fmt.Errorf("field %s does not accept arguments", sel.Name)
+	}
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if sel.Name == graphQLTypeNameField {
+			return openAPISchemaName(v.Type()), nil
+		}
+		field, ok := graphQLStructField(v, sel.Name)
+		if !ok {
+			return nil, fmt.Errorf("cannot query field %s on type %s", sel.Name, openAPISchemaName(v.Type()))
+		}
+		return field.Interface(), nil
+	case reflect.Map:
+		if sel.Name == graphQLTypeNameField {
+			return "Object", nil
+		}
+		field := v.MapIndex(reflect.ValueOf(sel.Name))
+		if !field.IsValid() {
+			return nil, nil
+		}
+		return field.Interface(), nil
+	}
+	return nil, fmt.Errorf("cannot query field %s on a scalar value", sel.Name)
+}
+
+// complete turns a resolved field value into its JSON representation, by resolving the sub-selections
+// of objects, and of each element of lists
+func (e *graphQLExecutor) complete(value any, sel *graphQLSelection, path []any) (any, error) {
+	if value == nil {
+		return nil, nil
+	} else if obj, ok := value.(*graphQLObject); ok {
+		if obj == nil {
+			return nil, nil
+		} else if len(sel.Selections) == 0 {
+			return nil, fmt.Errorf("field %s of type %s must have a selection of subfields", sel.Name, obj.Type)
+		}
+		return e.resolveSelections(obj, sel.Selections, path), nil
+	}
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		} else if !graphQLComposite(v.Type().Elem()) {
+			if len(sel.Selections) > 0 {
+				return nil, fmt.Errorf("field %s is a list of scalars and cannot have a selection of subfields", sel.Name)
+			}
+			return value, nil
+		}
+		items := make([]any, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := e.complete(v.Index(i).Interface(), sel, append(path, i))
+			if err != nil {
+				e.fail(append(path, i), err)
+				continue
+			}
+			items[i] = item
+		}
+		return items, nil
+	case reflect.Struct, reflect.Map:
+		if !graphQLComposite(v.Type()) {
+			break
+		} else if len(sel.Selections) == 0 {
+			return nil, fmt.Errorf("field %s must have a selection of subfields", sel.Name)
+		}
+		return e.resolveSelections(v.Interface(), sel.Selections, path), nil
+	}
+	if len(sel.Selections) > 0 {
+		return nil, fmt.Errorf("field %s is a scalar and cannot have a selection of subfields", sel.Name)
+	}
+	return value, nil
+}
+
+// arguments returns the arguments of the selected field, with variables replaced by their values
+func (e *graphQLExecutor) arguments(sel *graphQLSelection, allowed []string) (map[string]any, error) {
+	args := make(map[string]any)
+	for name, value := range sel.Arguments {
+		found := false
+		for _, a := range allowed {
+			if a == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown argument %s on field %s", name, sel.Name)
+		}
+		resolved, err := e.substitute(value)
+		if err != nil {
+			return nil, err
+		}
+		if resolved != nil {
+			args[name] = resolved
+		}
+	}
+	return args, nil
+}
+
+func (e *graphQLExecutor) substitute(value any) (any, error) {
+	switch v := value.(type) {
+	case graphQLVariable:
+		resolved, ok := e.variables[string(v)]
+		if !ok {
+			return nil, nil // Not provided and no default, same as omitting the argument
+		}
+		return resolved, nil
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			resolved, err := e.substitute(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = resolved
+		}
+		return list, nil
+	case map[string]any:
+		obj := make(map[string]any)
+		for k, item := range v {
+			resolved, err := e.substitute(item)
+			if err != nil {
+				return nil, err
+			}
+			obj[k] = resolved
+		}
+		return obj, nil
+	}
+	return value, nil
+}
+
+// graphQLStructField returns the field of a struct with the given JSON name. Embedded structs are
+// flattened, like encoding/json does.
+func graphQLStructField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		jsonName, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && jsonName == "" {
+			embedded := v.Field(i)
+			for embedded.Kind() == reflect.Pointer {
+				if embedded.IsNil() {
+					break
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if f, ok := graphQLStructField(embedded, name); ok {
+					return f, true
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		} else if jsonName == "" {
+			jsonName = field.Name
+		}
+		if jsonName == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// graphQLComposite returns true if values of the given type are GraphQL objects (or lists of objects),
+// i.e. if they require a selection of subfields
+func graphQLComposite(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		_, isJSONMarshaler := reflect.New(t).Interface().(json.Marshaler)
+		_, isTextMarshaler := reflect.New(t).Interface().(interface{ MarshalText() ([]byte, error) })
+		return !isJSONMarshaler && !isTextMarshaler // e.g. time.Time or netip.Addr are scalars
+	case reflect.Map:
+		return true
+	case reflect.Slice, reflect.Array:
+		return graphQLComposite(t.Elem())
+	case reflect.Interface:
+		return true
+	}
+	return false
+}
+
+// Lexer and parser
+
+const (
+	graphQLTokenEOF = iota
+	graphQLTokenPunctuator
+	graphQLTokenName
+	graphQLTokenInt
+	graphQLTokenFloat
+	graphQLTokenString
+)
+
+type graphQLToken struct {
+	Kind  int
+	Value string
+	Pos   int
+}
+
+type graphQLParser struct {
+	tokens []*graphQLToken
+	pos    int
+}
+
+// parseGraphQL parses a GraphQL request document
+func parseGraphQL(query string) (*graphQLDocument, error) {
+	tokens, err := lexGraphQL(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &graphQLParser{tokens: tokens}
+	doc := &graphQLDocument{Operations: make([]*graphQLOperation, 0)}
+	for p.peek().Kind != graphQLTokenEOF {
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		doc.Operations = append(doc.Operations, op)
+	}
+	if len(doc.Operations) == 0 {
+		return nil, errors.New("document does not contain any operations")
+	}
+	return doc, nil
+}
+
+func (p *graphQLParser) peek() *graphQLToken {
+	return p.tokens[p.pos]
+}
+
+func (p *graphQLParser) next() *graphQLToken {
+	t := p.tokens[p.pos]
+	if t.Kind != graphQLTokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *graphQLParser) peekPunctuator(value string) bool {
+	t := p.peek()
+	return t.Kind == graphQLTokenPunctuator && t.Value == value
+}
+
+func (p *graphQLParser) expectPunctuator(value string) error {
+	t := p.next()
+	if t.Kind != graphQLTokenPunctuator || t.Value != value {
+		return p.unexpected(t, fmt.Sprintf("expected \"%s\"", value))
+	}
+	return nil
+}
+
+func (p *graphQLParser) expectName() (string, error) {
+	t := p.next()
+	if t.Kind != graphQLTokenName {
+		return "", p.unexpected(t, "expected name")
+	}
+	return t.Value, nil
+}
+
+func (p *graphQLParser) unexpected(t *graphQLToken, expected string) error {
+	if t.Kind == graphQLTokenEOF {
+		return fmt.Errorf("syntax error: unexpected end of document, %s", expected)
+	}
+	return fmt.Errorf("syntax error at position %d: unexpected \"%s\", %s", t.Pos, t.Value, expected)
+}
+
+func (p *graphQLParser) parseOperation() (*graphQLOperation, error) {
+	op := &graphQLOperation{Type: graphQLOperationQuery}
+	if !p.peekPunctuator("{") {
+		t := p.next()
+		if t.Kind == graphQLTokenName && t.Value == "fragment" {
+			return nil, errGraphQLFragmentsNotSupported
+		} else if t.Kind != graphQLTokenName || (t.Value != "query" && t.Value != "mutation" && t.Value != "subscription") {
+			return nil, p.unexpected(t, "expected operation")
+		}
+		op.Type = t.Value
+		if p.peek().Kind == graphQLTokenName {
+			op.Name = p.next().Value
+		}
+		if p.peekPunctuator("(") {
+			variables, err := p.parseVariableDefinitions()
+			if err != nil {
+				return nil, err
+			}
+			op.Variables = variables
+		}
+		if p.peekPunctuator("@") {
+			return nil, errGraphQLDirectivesNotSupported
+		}
+	}
+	selections, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.Selections = selections
+	return op, nil
+}
+
+func (p *graphQLParser) parseVariableDefinitions() ([]*graphQLVariableDefinition, error) {
+	if err := p.expectPunctuator("("); err != nil {
+		return nil, err
+	}
+	definitions := make([]*graphQLVariableDefinition, 0)
+	for !p.peekPunctuator(")") {
+		if err := p.expectPunctuator("$"); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunctuator(":"); err != nil {
+			return nil, err
+		}
+		typ, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		def := &graphQLVariableDefinition{Name: name, Type: typ}
+		if p.peekPunctuator("=") {
+			p.next()
+			value, err := p.parseValue(true)
+			if err != nil {
+				return nil, err
+			}
+			def.Default, def.HasDefault = value, true
+		}
+		definitions = append(definitions, def)
+	}
+	p.next() // ")"
+	return definitions, nil
+}
+
+func (p *graphQLParser) parseType() (string, error) {
+	var typ string
+	if p.peekPunctuator("[") {
+		p.next()
+		inner, err := p.parseType()
+		if err != nil {
+			return "", err
+		} else if err := p.expectPunctuator("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.expectName()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if p.peekPunctuator("!") {
+		p.next()
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *graphQLParser) parseSelectionSet() ([]*graphQLSelection, error) {
+	if err := p.expectPunctuator("{"); err != nil {
+		return nil, err
+	}
+	selections := make([]*graphQLSelection, 0)
+	for !p.peekPunctuator("}") {
+		if p.peekPunctuator("...") {
+			return nil, errGraphQLFragmentsNotSupported
+		}
+		sel, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	p.next() // "}"
+	if len(selections) == 0 {
+		return nil, errors.New("syntax error: selection set must not be empty")
+	}
+	return selections, nil
+}
+
+func (p *graphQLParser) parseField() (*graphQLSelection, error) {
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	sel := &graphQLSelection{Name: name, Arguments: make(map[string]any)}
+	if p.peekPunctuator(":") {
+		p.next()
+		if sel.Name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+		sel.Alias = name
+	}
+	if p.peekPunctuator("(") {
+		p.next()
+		for !p.peekPunctuator(")") {
+			argName, err := p.expectName()
+			if err != nil {
+				return nil, err
+			} else if err := p.expectPunctuator(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.parseValue(false)
+			if err != nil {
+				return nil, err
+			}
+			sel.Arguments[argName] = value
+		}
+		p.next() // ")"
+	}
+	if p.peekPunctuator("@") {
+		return nil, errGraphQLDirectivesNotSupported
+	}
+	if p.peekPunctuator("{") {
+		if sel.Selections, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// parseValue parses an argument value. Enum values are returned as strings, integers as int64.
+func (p *graphQLParser) parseValue(constant bool) (any, error) {
+	t := p.next()
+	switch t.Kind {
+	case graphQLTokenInt:
+		return strconv.ParseInt(t.Value, 10, 64)
+	case graphQLTokenFloat:
+		return strconv.ParseFloat(t.Value, 64)
+	case graphQLTokenString:
+		return t.Value, nil
+	case graphQLTokenName:
+		switch t.Value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t.Value, nil
+	case graphQLTokenPunctuator:
+		switch t.Value {
+		case "$":
+			if constant {
+				return nil, p.unexpected(t, "variables are not allowed here")
+			}
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			return graphQLVariable(name), nil
+		case "[":
+			list := make([]any, 0)
+			for !p.peekPunctuator("]") {
+				value, err := p.parseValue(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, value)
+			}
+			p.next() // "]"
+			return list, nil
+		case "{":
+			obj := make(map[string]any)
+			for !p.peekPunctuator("}") {
+				name, err := p.expectName()
+				if err != nil {
+					return nil, err
+				} else if err := p.expectPunctuator(":"); err != nil {
+					return nil, err
+				}
+				value, err := p.parseValue(constant)
+				if err != nil {
+					return nil, err
+				}
+				obj[name] = value
+			}
+			p.next() // "}"
+			return obj, nil
+		}
+	}
+	return nil, p.unexpected(t, "expected value")
+}
+
+// lexGraphQL splits a GraphQL document into tokens. Whitespace, commas and comments are ignored.
+func lexGraphQL(query string) ([]*graphQLToken, error) {
+	tokens := make([]*graphQLToken, 0)
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(query) && query[i] != '\n' && query[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(query[i:], "..."):
+			tokens = append(tokens, &graphQLToken{Kind: graphQLTokenPunctuator, Value: "...", Pos: i})
+			i += 3
+		case strings.IndexByte("!$&()[]{}:=@|", c) != -1:
+			tokens = append(tokens, &graphQLToken{Kind: graphQLTokenPunctuator, Value: string(c), Pos: i})
+			i++
+		case c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z'):
+			start := i
+			for i < len(query) && (query[i] == '_' || (query[i] >= 'A' && query[i] <= 'Z') || (query[i] >= 'a' && query[i] <= 'z') || (query[i] >= '0' && query[i] <= '9')) {
+				i++
+			}
+			tokens = append(tokens, &graphQLToken{Kind: graphQLTokenName, Value: query[start:i], Pos: start})
+		case c == '-' || (c >= '0' && c <= '9'):
+			token, n, err := lexGraphQLNumber(query[i:])
+			if err != nil {
+				return nil, fmt.Errorf("syntax error at position %d: %s", i, err.Error())
+			}
+			token.Pos = i
+			tokens = append(tokens, token)
+			i += n
+		case c == '"':
+			value, n, err := lexGraphQLString(query[i:])
+			if err != nil {
+				return nil, fmt.Errorf("syntax error at position %d: %s", i, err.Error())
+			}
+			tokens = append(tokens, &graphQLToken{Kind: graphQLTokenString, Value: value, Pos: i})
+			i += n
+		case strings.HasPrefix(query[i:], "\uFEFF"): // Byte order mark
+			i += len("\uFEFF")
+		default:
+			r, _ := utf8.DecodeRuneInString(query[i:])
+			return nil, fmt.Errorf("syntax error at position %d: unexpected character %q", i, r)
+		}
+	}
+	return append(tokens, &graphQLToken{Kind: graphQLTokenEOF, Pos: len(query)}), nil
+}
+
+func lexGraphQLNumber(s string) (*graphQLToken, int, error) {
+	i := 0
+	if s[i] == '-' {
+		i++
+	}
+	digits := func() int {
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i - start
+	}
+	if digits() == 0 {
+		return nil, 0, errors.New("invalid number")
+	}
+	kind := graphQLTokenInt
+	if i < len(s) && s[i] == '.' {
+		i++
+		if digits() == 0 {
+			return nil, 0, errors.New("invalid number")
+		}
+		kind = graphQLTokenFloat
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if digits() == 0 {
+			return nil, 0, errors.New("invalid number")
+		}
+		kind = graphQLTokenFloat
+	}
+	return &graphQLToken{Kind: kind, Value: s[:i]}, i, nil
+}
+
+// lexGraphQLString reads a string literal (including block strings) at the beginning of s, and
+// returns its value and the length of the literal
+func lexGraphQLString(s string) (string, int, error) {
+	if strings.HasPrefix(s, `"""`) {
+		end := strings.Index(s[3:], `"""`)
+		for end != -1 && s[3+end-1] == '\\' { // Escaped triple quote
+			next := strings.Index(s[3+end+3:], `"""`)
+			if next == -1 {
+				end = -1
+				break
+			}
+			end += 3 + next
+		}
+		if end == -1 {
+			return "", 0, errors.New("unterminated string")
+		}
+		return strings.TrimSpace(strings.ReplaceAll(s[3:3+end], `\"""`, `"""`)), 3 + end + 3, nil
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\n', '\r':
+			return "", 0, errors.New("unterminated string")
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, errors.New("unterminated string")
+			}
+			i++
+			switch s[i] {
+			case '"', '\\', '/':
+				b.WriteByte(s[i])
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+4 >= len(s) {
+					return "", 0, errors.New("invalid unicode escape sequence")
+				}
+				r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, errors.New("invalid unicode escape sequence")
+				}
+				b.WriteRune(rune(r))
+				i += 4
+			default:
+				return "", 0, fmt.Errorf("invalid escape sequence \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	doc, err := parseGraphQL(`
+		# Recent alerts
+		query Alerts($topics: [String!]!, $limit: Int = 10) {
+			alerts: messages(topics: $topics, limit: $limit, tags: ["warning", "skull"], title: "Disk \"full\"!") {
+				id
+				title
+				attachment { name, size }
+			}
+			account { username }
+		}
+	`)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(doc.Operations))
+	op := doc.Operations[0]
+	require.Equal(t, "query", op.Type)
+	require.Equal(t, "Alerts", op.Name)
+	require.Equal(t, 2, len(op.Variables))
+	require.Equal(t, "topics", op.Variables[0].Name)
+	require.Equal(t, "[String!]!", op.Variables[0].Type)
+	require.False(t, op.Variables[0].HasDefault)
+	require.Equal(t, "Int", op.Variables[1].Type)
+	require.Equal(t, int64(10), op.Variables[1].Default)
+
+	require.Equal(t, 2, len(op.Selections))
+	alerts := op.Selections[0]
+	require.Equal(t, "alerts", alerts.Alias)
+	require.Equal(t, "messages", alerts.Name)
+	require.Equal(t, graphQLVariable("topics"), alerts.Arguments["topics"])
+	require.Equal(t, []any{"warning", "skull"}, alerts.Arguments["tags"])
+	require.Equal(t, `Disk "full"!`, alerts.Arguments["title"])
+	require.Equal(t, 3, len(alerts.Selections))
+	require.Equal(t, "attachment", alerts.Selections[2].Name)
+	require.Equal(t, "size", alerts.Selections[2].Selections[1].Name)
+	require.Equal(t, "account", op.Selections[1].Name)
+}
+
+func TestParseGraphQL_Shorthand(t *testing.T) {
+	doc, err := parseGraphQL(`{ account { username } }`)
+	require.Nil(t, err)
+	require.Equal(t, "query", doc.Operations[0].Type)
+	require.Equal(t, "", doc.Operations[0].Name)
+}
+
+func TestParseGraphQL_Errors(t *testing.T) {
+	_, err := parseGraphQL(`{ account { username }`)
+	require.ErrorContains(t, err, "unexpected end of document")
+
+	_, err = parseGraphQL(`{ account { ...AccountFields } }`)
+	require.Equal(t, errGraphQLFragmentsNotSupported, err)
+
+	_, err = parseGraphQL(`fragment AccountFields on Account { username }`)
+	require.Equal(t, errGraphQLFragmentsNotSupported, err)
+
+	_, err = parseGraphQL(`{ account @include(if: true) { username } }`)
+	require.Equal(t, errGraphQLDirectivesNotSupported, err)
+
+	_, err = parseGraphQL(`{ messages(topics: "unterminated) { id } }`)
+	require.ErrorContains(t, err, "unterminated string")
+
+	_, err = parseGraphQL(`{ account { } }`)
+	require.ErrorContains(t, err, "selection set must not be empty")
+
+	_, err = parseGraphQL(`   `)
+	require.ErrorContains(t, err, "does not contain any operations")
+}
+
+func TestExecuteGraphQL(t *testing.T) {
+	type item struct {
+		Name  string   `json:"name"`
+		Tags  []string `json:"tags,omitempty"`
+		Inner *item    `json:"inner,omitempty"`
+	}
+	root := &graphQLObject{
+		Type: "Query",
+		Fields: map[string]*graphQLFieldResolver{
+			"items": {
+				Args: []string{"prefix"},
+				Resolve: func(args map[string]any) (any, error) {
+					prefix, _ := args["prefix"].(string)
+					return []*item{
+						{Name: prefix + "one", Tags: []string{"a"}},
+						{Name: prefix + "two", Inner: &item{Name: "inner"}},
+					}, nil
+				},
+			},
+		},
+	}
+	response := executeGraphQL(&graphQLRequest{
+		Query:     `query Items($p: String) { items(prefix: $p) { __typename name tags inner { name } } first: items { name } }`,
+		Variables: map[string]any{"p": "x-"},
+	}, root)
+	require.Nil(t, response.Errors)
+	b, err := json.Marshal(response)
+	require.Nil(t, err)
+	require.Equal(t, `{"data":{"items":[{"__typename":"Item","name":"x-one","tags":["a"],"inner":null},`+
+		`{"__typename":"Item","name":"x-two","tags":null,"inner":{"name":"inner"}}],"first":[{"name":"one"},{"name":"two"}]}}`, string(b))
+}
+
+func TestExecuteGraphQL_FieldErrors(t *testing.T) {
+	root := &graphQLObject{
+		Type: "Query",
+		Fields: map[string]*graphQLFieldResolver{
+			"ok": {
+				Resolve: func(_ map[string]any) (any, error) {
+					return "yes", nil
+				},
+			},
+		},
+	}
+	response := executeGraphQL(&graphQLRequest{Query: `{ ok nope other: ok { sub } withArg: ok(x: 1) }`}, root)
+	b, err := json.Marshal(response)
+	require.Nil(t, err)
+	require.Equal(t, `{"data":{"ok":"yes","nope":null,"other":null,"withArg":null},"errors":[`+
+		`{"message":"cannot query field nope on type Query","path":["nope"]},`+
+		`{"message":"field ok is a scalar and cannot have a selection of subfields","path":["other"]},`+
+		`{"message":"unknown argument x on field ok","path":["withArg"]}]}`, string(b))
+
+	response = executeGraphQL(&graphQLRequest{Query: `mutation { ok }`}, root)
+	require.Nil(t, response.Data)
+	require.Equal(t, "mutation operations are not supported, only queries are allowed", response.Errors[0].Message)
+
+	response = executeGraphQL(&graphQLRequest{Query: `query A { ok } query B { ok }`}, root)
+	require.Nil(t, response.Data)
+	require.Contains(t, response.Errors[0].Message, "operationName is required")
+
+	response = executeGraphQL(&graphQLRequest{Query: `query A { ok } query B { ok }`, OperationName: "B"}, root)
+	require.Nil(t, response.Errors)
+
+	response = executeGraphQL(&graphQLRequest{Query: `query ($topic: String!) { ok }`}, root)
+	require.Equal(t, "variable $topic of required type String! was not provided", response.Errors[0].Message)
+}
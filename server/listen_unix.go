@@ -0,0 +1,22 @@
+//go:build darwin || linux || dragonfly || freebsd || netbsd || openbsd
+
+package server
+
+import (
+	"golang.org/x/sys/unix"
+	"syscall"
+)
+
+const listenReusePortSupported = true
+
+// reusePortControl sets SO_REUSEPORT on a listening socket, so that a second ntfy process can bind to the
+// same address while the first one is still running (and draining), see Config.ListenReusePort
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+package server
+
+import (
+	"errors"
+	"syscall"
+)
+
+const listenReusePortSupported = false
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on Windows")
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"heckel.io/ntfy/v2/user"
+	"path"
+	"strings"
+)
+
+// defaultLanguage is the language used for server-generated messages if the user has no language
+// preference, or if there is no translation for the preferred language
+const defaultLanguage = "en"
+
+var (
+	//go:embed locales
+	localesFs    embed.FS
+	translations = mustLoadTranslations()
+)
+
+// translate returns the translation of the given key in the given language, formatted with the given
+// arguments (see fmt.Sprintf). Languages are the ones used by the web app (e.g. "de" or "pt_BR"). If there
+// is no translation for a language, the base language (e.g. "pt" for "pt_BR") is used, then English.
+func translate(lang, key string, args ...any) string {
+	format, ok := translations[lang][key]
+	if !ok {
+		base, _, _ := strings.Cut(strings.ReplaceAll(lang, "-", "_"), "_")
+		if format, ok = translations[base][key]; !ok {
+			format = translations[defaultLanguage][key]
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// userLanguage returns the language preference of the given user, or the default language
+// if the user is nil, or has not chosen a language
+func userLanguage(u *user.User) string {
+	if u == nil || u.Prefs == nil || u.Prefs.Language == nil || *u.Prefs.Language == "" {
+		return defaultLanguage
+	}
+	return *u.Prefs.Language
+}
+
+func mustLoadTranslations() map[string]map[string]string {
+	entries, err := localesFs.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	all := make(map[string]map[string]string)
+	for _, entry := range entries {
+		b, err := localesFs.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var t map[string]string
+		if err := json.Unmarshal(b, &t); err != nil {
+			panic(fmt.Sprintf("invalid translation file %s: %s", entry.Name(), err.Error()))
+		}
+		all[strings.TrimSuffix(entry.Name(), ".json")] = t
+	}
+	return all
+}
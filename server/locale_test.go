@@ -0,0 +1,35 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"strings"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	require.Equal(t, "80% of daily message quota used", translate("en", "quota_warning_messages_title", 80))
+	require.Equal(t, "80% des täglichen Nachrichten-Kontingents verbraucht", translate("de", "quota_warning_messages_title", 80))
+	require.Equal(t, "Priorität: high", translate("de_AT", "email_priority", "high")) // Base language
+	require.Equal(t, "Priorität: high", translate("de-CH", "email_priority", "high"))
+	require.Equal(t, "Priority: high", translate("tlh", "email_priority", "high")) // Falls back to English
+	require.Equal(t, "Notifications will be paused", translate("", "web_push_subscription_expiring_title"))
+}
+
+func TestTranslate_AllLanguagesComplete(t *testing.T) {
+	for lang, t9n := range translations {
+		for key, format := range translations[defaultLanguage] {
+			translated, ok := t9n[key]
+			require.True(t, ok, "language %s is missing key %s", lang, key)
+			require.Equal(t, strings.Count(format, "%")-2*strings.Count(format, "%%"), strings.Count(translated, "%")-2*strings.Count(translated, "%%"), "language %s has wrong number of arguments for key %s", lang, key)
+		}
+	}
+}
+
+func TestUserLanguage(t *testing.T) {
+	require.Equal(t, "en", userLanguage(nil))
+	require.Equal(t, "en", userLanguage(&user.User{}))
+	require.Equal(t, "en", userLanguage(&user.User{Prefs: &user.Prefs{Language: util.String("")}}))
+	require.Equal(t, "fr", userLanguage(&user.User{Prefs: &user.Prefs{Language: util.String("fr")}}))
+}
@@ -13,23 +13,30 @@ import (
 
 // Log tags
 const (
-	tagStartup      = "startup"
-	tagHTTP         = "http"
-	tagPublish      = "publish"
-	tagSubscribe    = "subscribe"
-	tagFirebase     = "firebase"
-	tagSMTP         = "smtp"  // Receive email
-	tagEmail        = "email" // Send email
-	tagTwilio       = "twilio"
-	tagFileCache    = "file_cache"
-	tagMessageCache = "message_cache"
-	tagStripe       = "stripe"
-	tagAccount      = "account"
-	tagManager      = "manager"
-	tagResetter     = "resetter"
-	tagWebsocket    = "websocket"
-	tagMatrix       = "matrix"
-	tagWebPush      = "webpush"
+	tagStartup       = "startup"
+	tagShutdown      = "shutdown"
+	tagHTTP          = "http"
+	tagPublish       = "publish"
+	tagSubscribe     = "subscribe"
+	tagFirebase      = "firebase"
+	tagSMTP          = "smtp"  // Receive email
+	tagEmail         = "email" // Send email
+	tagTwilio        = "twilio"
+	tagFileCache     = "file_cache"
+	tagMessageCache  = "message_cache"
+	tagStripe        = "stripe"
+	tagPaddle        = "paddle"
+	tagAccount       = "account"
+	tagManager       = "manager"
+	tagResetter      = "resetter"
+	tagWebsocket     = "websocket"
+	tagMatrix        = "matrix"
+	tagWebPush       = "webpush"
+	tagSCIM          = "scim"
+	tagWebhook       = "webhook"
+	tagAbuse         = "abuse"
+	tagReplication   = "replication"
+	tagImpersonation = "impersonation"
 )
 
 var (
@@ -1,15 +1,18 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/netip"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3" // SQLite driver
+	"github.com/mattn/go-sqlite3" // SQLite driver
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/util"
 )
@@ -17,9 +20,18 @@ import (
 var (
 	errUnexpectedMessageType = errors.New("unexpected message type")
 	errMessageNotFound       = errors.New("message not found")
+	errReportNotFound        = errors.New("report not found")
+	errMaintenanceNotFound   = errors.New("maintenance window not found")
+	errHeartbeatNotFound     = errors.New("heartbeat not found")
+	errAnnotationNotFound    = errors.New("annotation not found")
+	errUploadNotFound        = errors.New("upload not found")
 	errNoRows                = errors.New("no rows found")
 )
 
+// messageRowOverheadBytes is the estimated size of the numeric columns and the record header of a row in the
+// messages table, see MessagesSize
+const messageRowOverheadBytes = 64
+
 // Messages cache
 const (
 	createMessagesTableQuery = `
@@ -43,11 +55,18 @@ const (
 			attachment_expires INT NOT NULL,
 			attachment_url TEXT NOT NULL,
 			attachment_deleted INT NOT NULL,
+			attachment_hash TEXT NOT NULL,
 			sender TEXT NOT NULL,
 			user TEXT NOT NULL,
 			content_type TEXT NOT NULL,
 			encoding TEXT NOT NULL,
-			published INT NOT NULL
+			sound TEXT NOT NULL,
+			published INT NOT NULL,
+			compression TEXT NOT NULL,
+			in_reply_to TEXT NOT NULL,
+			location TEXT NOT NULL,
+			hostname TEXT NOT NULL,
+			correlation_id TEXT NOT NULL
 		);
 		CREATE INDEX IF NOT EXISTS idx_mid ON messages (mid);
 		CREATE INDEX IF NOT EXISTS idx_time ON messages (time);
@@ -56,73 +75,431 @@ const (
 		CREATE INDEX IF NOT EXISTS idx_sender ON messages (sender);
 		CREATE INDEX IF NOT EXISTS idx_user ON messages (user);
 		CREATE INDEX IF NOT EXISTS idx_attachment_expires ON messages (attachment_expires);
+		CREATE INDEX IF NOT EXISTS idx_attachment_hash ON messages (attachment_hash);
+		CREATE INDEX IF NOT EXISTS idx_in_reply_to ON messages (in_reply_to);
 		CREATE TABLE IF NOT EXISTS stats (
 			key TEXT PRIMARY KEY,
 			value INT
 		);
 		INSERT INTO stats (key, value) VALUES ('messages', 0);
+		CREATE TABLE IF NOT EXISTS reports (
+			id TEXT PRIMARY KEY,
+			time INT NOT NULL,
+			topic TEXT NOT NULL,
+			mid TEXT NOT NULL,
+			title TEXT NOT NULL,
+			message TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			user TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			reporter_ip TEXT NOT NULL,
+			reporter_user TEXT NOT NULL,
+			status TEXT NOT NULL,
+			actions TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status);
+		CREATE TABLE IF NOT EXISTS blocked_topics (
+			topic TEXT PRIMARY KEY,
+			time INT NOT NULL,
+			reason TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS banned_ips (
+			ip TEXT PRIMARY KEY,
+			time INT NOT NULL,
+			expires INT NOT NULL,
+			reason TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS rate_limit_exemptions (
+			kind TEXT NOT NULL,
+			target TEXT NOT NULL,
+			time INT NOT NULL,
+			reason TEXT NOT NULL,
+			PRIMARY KEY (kind, target)
+		);
+		CREATE TABLE IF NOT EXISTS undeliverable_emails (
+			address TEXT PRIMARY KEY,
+			time INT NOT NULL,
+			reason TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS email_recipients (
+			address TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			user TEXT NOT NULL,
+			time INT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS stats_history (
+			time INT PRIMARY KEY,
+			messages INT NOT NULL,
+			bytes INT NOT NULL,
+			subscribers INT NOT NULL,
+			failed INT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			mid TEXT NOT NULL,
+			time INT NOT NULL,
+			channel TEXT NOT NULL,
+			outcome TEXT NOT NULL,
+			count INT NOT NULL,
+			error TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_deliveries_mid ON deliveries (mid);
+		CREATE TABLE IF NOT EXISTS reactions (
+			mid TEXT NOT NULL,
+			emoji TEXT NOT NULL,
+			reactor TEXT NOT NULL,
+			time INT NOT NULL,
+			PRIMARY KEY (mid, emoji, reactor)
+		);
+		CREATE TABLE IF NOT EXISTS escalations (
+			mid TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			step INT NOT NULL,
+			due INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_escalations_due ON escalations (due);
+		CREATE TABLE IF NOT EXISTS maintenance_windows (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			start INT NOT NULL,
+			end INT NOT NULL,
+			recurrence TEXT NOT NULL,
+			action TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			user TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_maintenance_windows_topic ON maintenance_windows (topic);
+		CREATE TABLE IF NOT EXISTS heartbeats (
+			topic TEXT PRIMARY KEY,
+			interval INT NOT NULL,
+			alert_topic TEXT NOT NULL,
+			message TEXT NOT NULL,
+			priority INT NOT NULL,
+			user TEXT NOT NULL,
+			created INT NOT NULL,
+			last INT NOT NULL,
+			due INT NOT NULL,
+			alert_mid TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_heartbeats_due ON heartbeats (due);
+		CREATE TABLE IF NOT EXISTS annotations (
+			id TEXT PRIMARY KEY,
+			mid TEXT NOT NULL,
+			time INT NOT NULL,
+			text TEXT NOT NULL,
+			author TEXT NOT NULL,
+			annotator TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_annotations_mid ON annotations (mid);
+		CREATE TABLE IF NOT EXISTS uploads (
+			id TEXT PRIMARY KEY,
+			user TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			ext TEXT NOT NULL,
+			size INT NOT NULL,
+			hash TEXT NOT NULL,
+			expires INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_uploads_expires ON uploads (expires);
+		CREATE TABLE IF NOT EXISTS attachment_usage (
+			user TEXT PRIMARY KEY,
+			bytes INT NOT NULL
+		);
 		COMMIT;
 	`
 	insertMessageQuery = `
-		INSERT INTO messages (mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_deleted, sender, user, content_type, encoding, published)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO messages (mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_deleted, attachment_hash, sender, user, content_type, encoding, sound, published, compression, in_reply_to, location, hostname, correlation_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	deleteMessageQuery                = `DELETE FROM messages WHERE mid = ?`
+	deleteDeliveriesQuery             = `DELETE FROM deliveries WHERE mid = ?`
+	deleteReactionsQuery              = `DELETE FROM reactions WHERE mid = ?`
+	deleteAnnotationsQuery            = `DELETE FROM annotations WHERE mid = ?`
+	deleteEscalationsQuery            = `DELETE FROM escalations WHERE mid = ?`
 	updateMessagesForTopicExpiryQuery = `UPDATE messages SET expires = ? WHERE topic = ?`
 	selectRowIDFromMessageID          = `SELECT id FROM messages WHERE mid = ?` // Do not include topic, see #336 and TestServer_PollSinceID_MultipleTopics
 	selectMessagesByIDQuery           = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, sound, compression, in_reply_to, location, hostname, correlation_id
 		FROM messages 
 		WHERE mid = ?
 	`
 	selectMessagesSinceTimeQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, sound, compression, in_reply_to, location, hostname, correlation_id
 		FROM messages 
 		WHERE topic = ? AND time >= ? AND published = 1
 		ORDER BY time, id
 	`
+	selectMessagesNewestQuery = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, sound, compression, in_reply_to, location, hostname, correlation_id
+		FROM messages 
+		WHERE topic = ? AND published = 1
+		ORDER BY time DESC, id DESC
+		LIMIT ?
+	`
 	selectMessagesSinceTimeIncludeScheduledQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, sound, compression, in_reply_to, location, hostname, correlation_id
 		FROM messages 
 		WHERE topic = ? AND time >= ?
 		ORDER BY time, id
 	`
 	selectMessagesSinceIDQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, sound, compression, in_reply_to, location, hostname, correlation_id
 		FROM messages 
 		WHERE topic = ? AND id > ? AND published = 1 
 		ORDER BY time, id
 	`
 	selectMessagesSinceIDIncludeScheduledQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, sound, compression, in_reply_to, location, hostname, correlation_id
 		FROM messages 
 		WHERE topic = ? AND (id > ? OR published = 0)
 		ORDER BY time, id
 	`
+	selectMessageLatestQuery = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, sound, compression, in_reply_to, location, hostname, correlation_id
+		FROM messages 
+		WHERE topic = ? AND published = 1
+		ORDER BY time DESC, id DESC
+		LIMIT 1
+	`
 	selectMessagesDueQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, sound, compression, in_reply_to, location, hostname, correlation_id
 		FROM messages 
 		WHERE time <= ? AND published = 0
 		ORDER BY time, id
 	`
+	selectMessagesPendingQuery = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, sound, compression, in_reply_to, location, hostname, correlation_id
+		FROM messages 
+		WHERE time > ? AND published = 0
+		ORDER BY time, id
+	`
+	selectThreadQuery = `
+		WITH RECURSIVE thread (mid) AS (
+			SELECT ?
+			UNION
+			SELECT m.mid FROM messages m JOIN thread t ON m.in_reply_to = t.mid WHERE m.topic = ?
+		)
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, sound, compression, in_reply_to, location, hostname, correlation_id
+		FROM messages
+		WHERE mid IN (SELECT mid FROM thread) AND topic = ? AND published = 1
+		ORDER BY time, id
+	`
 	selectMessagesExpiredQuery      = `SELECT mid FROM messages WHERE expires <= ? AND published = 1`
+	selectMessagesBeforeQuery       = `SELECT mid FROM messages WHERE topic = ? AND time < ?`
+	selectMessageIDsByTopicQuery    = `SELECT mid FROM messages WHERE topic = ?`
 	updateMessagePublishedQuery     = `UPDATE messages SET published = 1 WHERE mid = ?`
 	selectMessagesCountQuery        = `SELECT COUNT(*) FROM messages`
 	selectMessageCountPerTopicQuery = `SELECT topic, COUNT(*) FROM messages GROUP BY topic`
 	selectTopicsQuery               = `SELECT topic FROM messages GROUP BY topic`
+	selectTopicsInactiveQuery       = `SELECT topic FROM messages GROUP BY topic HAVING MAX(time) <= ?`
+
+	updateMessagesExceedingTopicLimitQuery = `
+		UPDATE messages
+		SET expires = ?
+		WHERE expires > ? AND id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY topic ORDER BY time DESC, id DESC) AS n
+				FROM messages
+				WHERE published = 1
+			)
+			WHERE n > ?
+		)
+	`
+	updateOldestMessagesExpiryQuery = `
+		UPDATE messages
+		SET expires = ?
+		WHERE id IN (
+			SELECT id
+			FROM messages
+			WHERE published = 1 AND expires > ?
+			ORDER BY time, id
+			LIMIT ?
+		)
+	`
+	selectMessagesSizeQuery = `
+		SELECT IFNULL(SUM(
+			LENGTH(CAST(mid AS BLOB)) +
+			LENGTH(CAST(topic AS BLOB)) +
+			LENGTH(CAST(message AS BLOB)) +
+			LENGTH(CAST(title AS BLOB)) +
+			LENGTH(CAST(tags AS BLOB)) +
+			LENGTH(CAST(click AS BLOB)) +
+			LENGTH(CAST(icon AS BLOB)) +
+			LENGTH(CAST(actions AS BLOB)) +
+			LENGTH(CAST(attachment_name AS BLOB)) +
+			LENGTH(CAST(attachment_type AS BLOB)) +
+			LENGTH(CAST(attachment_url AS BLOB)) +
+			LENGTH(CAST(attachment_hash AS BLOB)) +
+			LENGTH(CAST(sender AS BLOB)) +
+			LENGTH(CAST(user AS BLOB)) +
+			LENGTH(CAST(content_type AS BLOB)) +
+			LENGTH(CAST(encoding AS BLOB)) +
+			LENGTH(CAST(sound AS BLOB)) +
+			LENGTH(CAST(compression AS BLOB)) +
+			LENGTH(CAST(in_reply_to AS BLOB)) +
+			LENGTH(CAST(location AS BLOB)) +
+			LENGTH(CAST(hostname AS BLOB)) +
+			LENGTH(CAST(correlation_id AS BLOB)) +
+			?
+		), 0)
+		FROM messages
+	`
+	selectCacheSizeQuery = `
+		SELECT p.page_count * s.page_size, f.freelist_count * s.page_size
+		FROM pragma_page_count() p, pragma_freelist_count() f, pragma_page_size() s
+	`
+	vacuumQueries = `
+		PRAGMA wal_checkpoint(TRUNCATE);
+		VACUUM;
+	`
 
 	updateAttachmentDeleted            = `UPDATE messages SET attachment_deleted = 1 WHERE mid = ?`
 	selectAttachmentsExpiredQuery      = `SELECT mid FROM messages WHERE attachment_expires > 0 AND attachment_expires <= ? AND attachment_deleted = 0`
-	selectAttachmentsSizeBySenderQuery = `SELECT IFNULL(SUM(attachment_size), 0) FROM messages WHERE user = '' AND sender = ? AND attachment_expires >= ?`
-	selectAttachmentsSizeByUserIDQuery = `SELECT IFNULL(SUM(attachment_size), 0) FROM messages WHERE user = ? AND attachment_expires >= ?`
+	updateAttachmentsExpiryByUserQuery = `UPDATE messages SET attachment_expires = ? WHERE user = ? AND attachment_expires > ? AND attachment_deleted = 0`
+	selectAttachmentByHashQuery        = `SELECT mid FROM messages WHERE attachment_hash = ? AND attachment_expires > ? AND attachment_deleted = 0 ORDER BY attachment_expires DESC LIMIT 1`
+	selectAttachmentsSizeBySenderQuery = `
+		SELECT IFNULL(SUM(size), 0) FROM (
+			SELECT MAX(size) AS size FROM (
+				SELECT mid AS id, attachment_size AS size, attachment_hash AS hash FROM messages WHERE user = '' AND sender = ? AND attachment_expires >= ?
+				UNION ALL
+				SELECT id, size, hash FROM uploads WHERE user = '' AND sender = ? AND expires >= ?
+			)
+			GROUP BY IIF(hash = '', id, hash)
+		)
+	` // Identical attachments (and uploads referenced by messages) are counted once
+	selectAttachmentUsageByUserIDQuery    = `SELECT IFNULL(MAX(bytes), 0) FROM attachment_usage WHERE user = ?`
+	selectAttachmentHashUsedByUserIDQuery = `
+		SELECT 1 FROM messages WHERE user = ? AND attachment_hash = ? AND attachment_expires >= ?
+		UNION ALL
+		SELECT 1 FROM uploads WHERE user = ? AND hash = ? AND expires >= ?
+		LIMIT 1
+	`
+	upsertAttachmentUsageQuery = `
+		INSERT INTO attachment_usage (user, bytes) VALUES (?, ?)
+		ON CONFLICT (user) DO UPDATE SET bytes = bytes + excluded.bytes
+	`
+	deleteAttachmentUsageByUserIDQuery = `DELETE FROM attachment_usage WHERE user = ?`
+	deleteAttachmentUsageQuery         = `DELETE FROM attachment_usage`
+	insertAttachmentUsageQuery         = `
+		INSERT INTO attachment_usage (user, bytes)
+		SELECT user, SUM(size) FROM (
+			SELECT user, MAX(size) AS size FROM (
+				SELECT mid AS id, user, attachment_size AS size, attachment_hash AS hash FROM messages WHERE user <> '' AND attachment_expires >= ?
+				UNION ALL
+				SELECT id, user, size, hash FROM uploads WHERE user <> '' AND expires >= ?
+			)
+			GROUP BY user, IIF(hash = '', id, hash)
+		)
+		GROUP BY user
+	` // Identical attachments (and uploads referenced by messages) are counted once, like selectAttachmentsSizeBySenderQuery
+
+	insertUploadQuery              = `INSERT INTO uploads (id, user, sender, name, type, ext, size, hash, expires) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	selectUploadQuery              = `SELECT id, user, sender, name, type, ext, size, hash, expires FROM uploads WHERE id = ? AND expires > ?`
+	selectUploadsExpiredQuery      = `SELECT id FROM uploads WHERE expires <= ?`
+	deleteUploadQuery              = `DELETE FROM uploads WHERE id = ?`
+	updateUploadsExpiryByUserQuery = `UPDATE uploads SET expires = ? WHERE user = ? AND expires > ?`
 
 	selectStatsQuery = `SELECT value FROM stats WHERE key = 'messages'`
 	updateStatsQuery = `UPDATE stats SET value = ? WHERE key = 'messages'`
+
+	upsertStatsHistoryQuery = `
+		INSERT INTO stats_history (time, messages, bytes, subscribers, failed)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (time) DO UPDATE SET
+			messages = messages + excluded.messages,
+			bytes = bytes + excluded.bytes,
+			subscribers = MAX(subscribers, excluded.subscribers),
+			failed = failed + excluded.failed
+	`
+	selectStatsHistoryQuery       = `SELECT time, messages, bytes, subscribers, failed FROM stats_history WHERE time >= ? AND time < ? ORDER BY time`
+	deleteStatsHistoryBeforeQuery = `DELETE FROM stats_history WHERE time < ?`
+
+	insertDeliveryQuery   = `INSERT INTO deliveries (mid, time, channel, outcome, count, error) VALUES (?, ?, ?, ?, ?, ?)`
+	selectDeliveriesQuery = `SELECT mid, time, channel, outcome, count, error FROM deliveries WHERE mid = ? ORDER BY time, rowid`
+
+	insertReactionQuery  = `INSERT OR IGNORE INTO reactions (mid, emoji, reactor, time) VALUES (?, ?, ?, ?)`
+	deleteReactionQuery  = `DELETE FROM reactions WHERE mid = ? AND emoji = ? AND reactor = ?`
+	selectReactionsQuery = `SELECT emoji, COUNT(*) FROM reactions WHERE mid = ? GROUP BY emoji`
+
+	insertAnnotationQuery  = `INSERT INTO annotations (id, mid, time, text, author, annotator) VALUES (?, ?, ?, ?, ?, ?)`
+	deleteAnnotationQuery  = `DELETE FROM annotations WHERE mid = ? AND id = ? AND annotator = ?`
+	selectAnnotationsQuery = `SELECT id, time, text, author, annotator FROM annotations WHERE mid = ? ORDER BY time, rowid`
+
+	upsertEscalationQuery      = `INSERT INTO escalations (mid, topic, step, due) VALUES (?, ?, ?, ?) ON CONFLICT (mid) DO UPDATE SET step = excluded.step, due = excluded.due`
+	deleteEscalationQuery      = `DELETE FROM escalations WHERE mid = ?`
+	selectEscalationsDueQuery  = `SELECT mid, topic, step, due FROM escalations WHERE due <= ? ORDER BY due, mid`
+	selectAcknowledgementQuery = `SELECT (SELECT COUNT(*) FROM messages WHERE in_reply_to = ?) + (SELECT COUNT(*) FROM reactions WHERE mid = ?) + (SELECT COUNT(*) FROM annotations WHERE mid = ?)`
+
+	insertMaintenanceWindowQuery         = `INSERT INTO maintenance_windows (id, topic, start, end, recurrence, action, reason, user, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	deleteMaintenanceWindowQuery         = `DELETE FROM maintenance_windows WHERE topic = ? AND id = ?`
+	deleteMaintenanceWindowsExpiredQuery = `DELETE FROM maintenance_windows WHERE recurrence = '' AND end <= ?`
+	selectMaintenanceWindowsByTopicQuery = `SELECT id, topic, start, end, recurrence, action, reason, user, created FROM maintenance_windows WHERE topic = ? ORDER BY start, id`
+
+	upsertHeartbeatQuery = `
+		INSERT INTO heartbeats (topic, interval, alert_topic, message, priority, user, created, last, due, alert_mid)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, '')
+		ON CONFLICT (topic) DO UPDATE SET interval = excluded.interval, alert_topic = excluded.alert_topic, message = excluded.message, priority = excluded.priority, user = excluded.user, due = excluded.due
+	`
+	deleteHeartbeatQuery        = `DELETE FROM heartbeats WHERE topic = ?`
+	selectHeartbeatQuery        = `SELECT topic, interval, alert_topic, message, priority, user, created, last, due, alert_mid FROM heartbeats WHERE topic = ?`
+	selectHeartbeatsDueQuery    = `SELECT topic, interval, alert_topic, message, priority, user, created, last, due, alert_mid FROM heartbeats WHERE due <= ? AND alert_mid = '' ORDER BY due, topic`
+	updateHeartbeatPingQuery    = `UPDATE heartbeats SET last = ?, due = ? + interval, alert_mid = '' WHERE topic = ?`
+	updateHeartbeatAlertedQuery = `UPDATE heartbeats SET alert_mid = ? WHERE topic = ?`
+	selectHeartbeatCountQuery   = `SELECT COUNT(*) FROM heartbeats WHERE user = ?`
+)
+
+// Abuse reports, blocked topics and banned IPs
+const (
+	insertReportQuery = `
+		INSERT INTO reports (id, time, topic, mid, title, message, sender, user, reason, reporter_ip, reporter_user, status, actions)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	selectReportQuery = `
+		SELECT id, time, topic, mid, title, message, sender, user, reason, reporter_ip, reporter_user, status, actions
+		FROM reports
+		WHERE id = ?
+	`
+	selectReportsQuery = `
+		SELECT id, time, topic, mid, title, message, sender, user, reason, reporter_ip, reporter_user, status, actions
+		FROM reports
+		ORDER BY time DESC, id
+	`
+	selectReportsByStatusQuery = `
+		SELECT id, time, topic, mid, title, message, sender, user, reason, reporter_ip, reporter_user, status, actions
+		FROM reports
+		WHERE status = ?
+		ORDER BY time DESC, id
+	`
+	updateReportQuery = `UPDATE reports SET status = ?, actions = ? WHERE id = ?`
+
+	upsertBlockedTopicQuery  = `INSERT INTO blocked_topics (topic, time, reason) VALUES (?, ?, ?) ON CONFLICT (topic) DO UPDATE SET time = excluded.time, reason = excluded.reason`
+	deleteBlockedTopicQuery  = `DELETE FROM blocked_topics WHERE topic = ?`
+	selectBlockedTopicsQuery = `SELECT topic, time, reason FROM blocked_topics ORDER BY topic`
+
+	upsertBannedIPQuery         = `INSERT INTO banned_ips (ip, time, expires, reason) VALUES (?, ?, ?, ?) ON CONFLICT (ip) DO UPDATE SET time = excluded.time, expires = excluded.expires, reason = excluded.reason`
+	deleteBannedIPQuery         = `DELETE FROM banned_ips WHERE ip = ?`
+	deleteBannedIPsExpiredQuery = `DELETE FROM banned_ips WHERE expires > 0 AND expires <= ?`
+	selectBannedIPsQuery        = `SELECT ip, time, expires, reason FROM banned_ips ORDER BY ip`
+
+	upsertRateLimitExemptionQuery  = `INSERT INTO rate_limit_exemptions (kind, target, time, reason) VALUES (?, ?, ?, ?) ON CONFLICT (kind, target) DO UPDATE SET time = excluded.time, reason = excluded.reason`
+	deleteRateLimitExemptionQuery  = `DELETE FROM rate_limit_exemptions WHERE kind = ? AND target = ?`
+	selectRateLimitExemptionsQuery = `SELECT kind, target, time, reason FROM rate_limit_exemptions ORDER BY kind, target`
+
+	upsertUndeliverableEmailQuery  = `INSERT INTO undeliverable_emails (address, time, reason) VALUES (?, ?, ?) ON CONFLICT (address) DO UPDATE SET time = excluded.time, reason = excluded.reason`
+	deleteUndeliverableEmailQuery  = `DELETE FROM undeliverable_emails WHERE address = ?`
+	selectUndeliverableEmailQuery  = `SELECT COUNT(*) FROM undeliverable_emails WHERE address = ?`
+	selectUndeliverableEmailsQuery = `SELECT address, time, reason FROM undeliverable_emails ORDER BY address`
+
+	upsertEmailRecipientQuery = `INSERT INTO email_recipients (address, topic, user, time) VALUES (?, ?, ?, ?) ON CONFLICT (address) DO UPDATE SET topic = excluded.topic, user = excluded.user, time = excluded.time`
+	selectEmailRecipientQuery = `SELECT topic, user FROM email_recipients WHERE address = ?`
 )
 
 // Schema management queries
 const (
-	currentSchemaVersion          = 12
+	currentSchemaVersion          = 30
 	createSchemaVersionTableQuery = `
 		CREATE TABLE IF NOT EXISTS schemaVersion (
 			id INT PRIMARY KEY,
@@ -246,6 +623,213 @@ const (
 	migrate11To12AlterMessagesTableQuery = `
 		ALTER TABLE messages ADD COLUMN content_type TEXT NOT NULL DEFAULT('');
 	`
+
+	// 12 -> 13
+	migrate12To13AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN attachment_hash TEXT NOT NULL DEFAULT('');
+		CREATE INDEX IF NOT EXISTS idx_attachment_hash ON messages (attachment_hash);
+	`
+
+	// 13 -> 14
+	migrate13To14AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN sound TEXT NOT NULL DEFAULT('');
+	`
+
+	// 14 -> 15
+	migrate14To15CreateAbuseTablesQuery = `
+		CREATE TABLE IF NOT EXISTS reports (
+			id TEXT PRIMARY KEY,
+			time INT NOT NULL,
+			topic TEXT NOT NULL,
+			mid TEXT NOT NULL,
+			title TEXT NOT NULL,
+			message TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			user TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			reporter_ip TEXT NOT NULL,
+			reporter_user TEXT NOT NULL,
+			status TEXT NOT NULL,
+			actions TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status);
+		CREATE TABLE IF NOT EXISTS blocked_topics (
+			topic TEXT PRIMARY KEY,
+			time INT NOT NULL,
+			reason TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS banned_ips (
+			ip TEXT PRIMARY KEY,
+			time INT NOT NULL,
+			expires INT NOT NULL,
+			reason TEXT NOT NULL
+		);
+	`
+
+	// 15 -> 16
+	migrate15To16CreateStatsHistoryTableQuery = `
+		CREATE TABLE IF NOT EXISTS stats_history (
+			time INT PRIMARY KEY,
+			messages INT NOT NULL,
+			bytes INT NOT NULL,
+			subscribers INT NOT NULL,
+			failed INT NOT NULL
+		);
+	`
+
+	// 16 -> 17
+	migrate16To17CreateDeliveriesTableQuery = `
+		CREATE TABLE IF NOT EXISTS deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			mid TEXT NOT NULL,
+			time INT NOT NULL,
+			channel TEXT NOT NULL,
+			outcome TEXT NOT NULL,
+			count INT NOT NULL,
+			error TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_deliveries_mid ON deliveries (mid);
+	`
+
+	// 17 -> 18
+	migrate17To18AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN compression TEXT NOT NULL DEFAULT('');
+	`
+
+	// 18 -> 19
+	migrate18To19CreateReactionsTableQuery = `
+		CREATE TABLE IF NOT EXISTS reactions (
+			mid TEXT NOT NULL,
+			emoji TEXT NOT NULL,
+			reactor TEXT NOT NULL,
+			time INT NOT NULL,
+			PRIMARY KEY (mid, emoji, reactor)
+		);
+	`
+
+	// 19 -> 20
+	migrate19To20AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN in_reply_to TEXT NOT NULL DEFAULT('');
+		CREATE INDEX IF NOT EXISTS idx_in_reply_to ON messages (in_reply_to);
+	`
+
+	// 20 -> 21
+	migrate20To21CreateRateLimitExemptionsTableQuery = `
+		CREATE TABLE IF NOT EXISTS rate_limit_exemptions (
+			kind TEXT NOT NULL,
+			target TEXT NOT NULL,
+			time INT NOT NULL,
+			reason TEXT NOT NULL,
+			PRIMARY KEY (kind, target)
+		);
+	`
+
+	// 21 -> 22
+	migrate21To22CreateUndeliverableEmailsTableQuery = `
+		CREATE TABLE IF NOT EXISTS undeliverable_emails (
+			address TEXT PRIMARY KEY,
+			time INT NOT NULL,
+			reason TEXT NOT NULL
+		);
+	`
+
+	// 22 -> 23
+	migrate22To23CreateEmailRecipientsTableQuery = `
+		CREATE TABLE IF NOT EXISTS email_recipients (
+			address TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			user TEXT NOT NULL,
+			time INT NOT NULL
+		);
+	`
+
+	// 23 -> 24
+	migrate23To24AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN location TEXT NOT NULL DEFAULT('');
+		ALTER TABLE messages ADD COLUMN hostname TEXT NOT NULL DEFAULT('');
+		ALTER TABLE messages ADD COLUMN correlation_id TEXT NOT NULL DEFAULT('');
+	`
+
+	// 24 -> 25
+	migrate24To25CreateEscalationsTableQuery = `
+		CREATE TABLE IF NOT EXISTS escalations (
+			mid TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			step INT NOT NULL,
+			due INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_escalations_due ON escalations (due);
+	`
+
+	// 25 -> 26
+	migrate25To26CreateMaintenanceWindowsTableQuery = `
+		CREATE TABLE IF NOT EXISTS maintenance_windows (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			start INT NOT NULL,
+			end INT NOT NULL,
+			recurrence TEXT NOT NULL,
+			action TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			user TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_maintenance_windows_topic ON maintenance_windows (topic);
+	`
+
+	// 26 -> 27
+	migrate26To27CreateHeartbeatsTableQuery = `
+		CREATE TABLE IF NOT EXISTS heartbeats (
+			topic TEXT PRIMARY KEY,
+			interval INT NOT NULL,
+			alert_topic TEXT NOT NULL,
+			message TEXT NOT NULL,
+			priority INT NOT NULL,
+			user TEXT NOT NULL,
+			created INT NOT NULL,
+			last INT NOT NULL,
+			due INT NOT NULL,
+			alert_mid TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_heartbeats_due ON heartbeats (due);
+	`
+
+	// 27 -> 28
+	migrate27To28CreateAnnotationsTableQuery = `
+		CREATE TABLE IF NOT EXISTS annotations (
+			id TEXT PRIMARY KEY,
+			mid TEXT NOT NULL,
+			time INT NOT NULL,
+			text TEXT NOT NULL,
+			author TEXT NOT NULL,
+			annotator TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_annotations_mid ON annotations (mid);
+	`
+
+	// 28 -> 29
+	migrate28To29CreateUploadsTableQuery = `
+		CREATE TABLE IF NOT EXISTS uploads (
+			id TEXT PRIMARY KEY,
+			user TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			ext TEXT NOT NULL,
+			size INT NOT NULL,
+			hash TEXT NOT NULL,
+			expires INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_uploads_expires ON uploads (expires);
+	`
+
+	// 29 -> 30
+	migrate29To30CreateAttachmentUsageTableQuery = `
+		CREATE TABLE IF NOT EXISTS attachment_usage (
+			user TEXT PRIMARY KEY,
+			bytes INT NOT NULL
+		);
+	`
 )
 
 var (
@@ -262,13 +846,35 @@ var (
 		9:  migrateFrom9,
 		10: migrateFrom10,
 		11: migrateFrom11,
+		12: migrateFrom12,
+		13: migrateFrom13,
+		14: migrateFrom14,
+		15: migrateFrom15,
+		16: migrateFrom16,
+		17: migrateFrom17,
+		18: migrateFrom18,
+		19: migrateFrom19,
+		20: migrateFrom20,
+		21: migrateFrom21,
+		22: migrateFrom22,
+		23: migrateFrom23,
+		24: migrateFrom24,
+		25: migrateFrom25,
+		26: migrateFrom26,
+		27: migrateFrom27,
+		28: migrateFrom28,
+		29: migrateFrom29,
 	}
 )
 
 type messageCache struct {
-	db    *sql.DB
-	queue *util.BatchingQueue[*message]
-	nop   bool
+	db                *sql.DB
+	queue             *util.BatchingQueue[*message]
+	nop               bool
+	compressThreshold int64     // If > 0, message payloads of at least this size (bytes) are compressed, see Config.CacheCompressThreshold
+	snapshotFile      string    // If set, the in-memory database is periodically written to this file
+	snapshotClose     chan bool // Stops the snapshot loop
+	snapshotMu        sync.Mutex
 }
 
 // newSqliteCache creates a SQLite file-backed cache
@@ -298,6 +904,31 @@ func newMemCache() (*messageCache, error) {
 	return newSqliteCache(createMemoryFilename(), "", 0, 0, 0, false)
 }
 
+// newSnapshotCache creates an in-memory cache that is restored from the snapshot file on startup (if it
+// exists), and written back to it every snapshotInterval, as well as when the cache is closed. Messages
+// that were added after the last snapshot are lost if the server crashes.
+func newSnapshotCache(snapshotFile string, cacheDuration, snapshotInterval time.Duration) (*messageCache, error) {
+	db, err := sql.Open("sqlite3", createMemoryFilename())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(snapshotFile); err == nil {
+		if err := restoreSnapshot(db, snapshotFile, cacheDuration); err != nil {
+			return nil, fmt.Errorf("cannot restore message cache snapshot %s: %w", snapshotFile, err)
+		}
+	}
+	if err := setupMessagesDB(db, "", cacheDuration); err != nil {
+		return nil, err
+	}
+	cache := &messageCache{
+		db:            db,
+		snapshotFile:  snapshotFile,
+		snapshotClose: make(chan bool),
+	}
+	go cache.runSnapshots(snapshotInterval)
+	return cache, nil
+}
+
 // newNopCache creates an in-memory cache that discards all messages;
 // it is always empty and can be used if caching is entirely disabled
 func newNopCache() (*messageCache, error) {
@@ -350,7 +981,7 @@ func (c *messageCache) addMessages(ms []*message) error {
 		}
 		published := m.Time <= time.Now().Unix()
 		tags := strings.Join(m.Tags, ",")
-		var attachmentName, attachmentType, attachmentURL string
+		var attachmentName, attachmentType, attachmentURL, attachmentHash string
 		var attachmentSize, attachmentExpires, attachmentDeleted int64
 		if m.Attachment != nil {
 			attachmentName = m.Attachment.Name
@@ -358,6 +989,7 @@ func (c *messageCache) addMessages(ms []*message) error {
 			attachmentSize = m.Attachment.Size
 			attachmentExpires = m.Attachment.Expires
 			attachmentURL = m.Attachment.URL
+			attachmentHash = m.Attachment.Hash
 		}
 		var actionsStr string
 		if len(m.Actions) > 0 {
@@ -371,12 +1003,34 @@ func (c *messageCache) addMessages(ms []*message) error {
 		if m.Sender.IsValid() {
 			sender = m.Sender.String()
 		}
+		var locationStr string
+		if m.Location != nil {
+			locationStr = m.Location.String()
+		}
+		var msg any = m.Message
+		var compression string
+		payload := &cachedPayload{
+			Message:        m.Message,
+			Actions:        actionsStr,
+			AttachmentName: attachmentName,
+			AttachmentType: attachmentType,
+			AttachmentURL:  attachmentURL,
+		}
+		if c.compressThreshold > 0 && payload.size() >= c.compressThreshold {
+			compressed, err := payload.compress()
+			if err != nil {
+				return err
+			} else if compressed != nil {
+				msg, compression = compressed, compressionZstd
+				actionsStr, attachmentName, attachmentType, attachmentURL = "", "", "", ""
+			}
+		}
 		_, err := stmt.Exec(
 			m.ID,
 			m.Time,
 			m.Expires,
 			m.Topic,
-			m.Message,
+			msg,
 			m.Title,
 			m.Priority,
 			tags,
@@ -389,11 +1043,18 @@ func (c *messageCache) addMessages(ms []*message) error {
 			attachmentExpires,
 			attachmentURL,
 			attachmentDeleted, // Always zero
+			attachmentHash,
 			sender,
 			m.User,
 			m.ContentType,
 			m.Encoding,
+			m.Sound,
 			published,
+			compression,
+			m.InReplyTo,
+			locationStr,
+			m.Hostname,
+			m.CorrelationID,
 		)
 		if err != nil {
 			return err
@@ -410,27 +1071,62 @@ func (c *messageCache) addMessages(ms []*message) error {
 func (c *messageCache) Messages(topic string, since sinceMarker, scheduled bool) ([]*message, error) {
 	if since.IsNone() {
 		return make([]*message, 0), nil
-	} else if since.IsID() {
-		return c.messagesSinceID(topic, since, scheduled)
 	}
-	return c.messagesSinceTime(topic, since, scheduled)
+	rows, err := c.messagesSince(topic, since, scheduled)
+	if err != nil {
+		return nil, err
+	}
+	return readMessages(rows)
 }
 
-func (c *messageCache) messagesSinceTime(topic string, since sinceMarker, scheduled bool) ([]*message, error) {
-	var rows *sql.Rows
-	var err error
-	if scheduled {
-		rows, err = c.db.Query(selectMessagesSinceTimeIncludeScheduledQuery, topic, since.Time().Unix())
-	} else {
-		rows, err = c.db.Query(selectMessagesSinceTimeQuery, topic, since.Time().Unix())
+// MessagesEach calls fn for each message that Messages would return, in the same order. Unlike Messages, the
+// messages are read from the database one by one, so that large result sets (e.g. exports) are never held in
+// memory. Iteration stops at the first error returned by fn.
+func (c *messageCache) MessagesEach(topic string, since sinceMarker, scheduled bool, fn func(m *message) error) error {
+	if since.IsNone() {
+		return nil
+	}
+	rows, err := c.messagesSince(topic, since, scheduled)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := readMessage(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
 	}
+	return rows.Err()
+}
+
+// MessagesNewest returns the newest published messages of the topic (at most limit), newest first
+func (c *messageCache) MessagesNewest(topic string, limit int) ([]*message, error) {
+	rows, err := c.db.Query(selectMessagesNewestQuery, topic, limit)
 	if err != nil {
 		return nil, err
 	}
 	return readMessages(rows)
 }
 
-func (c *messageCache) messagesSinceID(topic string, since sinceMarker, scheduled bool) ([]*message, error) {
+func (c *messageCache) messagesSince(topic string, since sinceMarker, scheduled bool) (*sql.Rows, error) {
+	if since.IsID() {
+		return c.messagesSinceID(topic, since, scheduled)
+	}
+	return c.messagesSinceTime(topic, since, scheduled)
+}
+
+func (c *messageCache) messagesSinceTime(topic string, since sinceMarker, scheduled bool) (*sql.Rows, error) {
+	if scheduled {
+		return c.db.Query(selectMessagesSinceTimeIncludeScheduledQuery, topic, since.Time().Unix())
+	}
+	return c.db.Query(selectMessagesSinceTimeQuery, topic, since.Time().Unix())
+}
+
+func (c *messageCache) messagesSinceID(topic string, since sinceMarker, scheduled bool) (*sql.Rows, error) {
 	idrows, err := c.db.Query(selectRowIDFromMessageID, since.ID())
 	if err != nil {
 		return nil, err
@@ -444,20 +1140,46 @@ func (c *messageCache) messagesSinceID(topic string, since sinceMarker, schedule
 		return nil, err
 	}
 	idrows.Close()
-	var rows *sql.Rows
 	if scheduled {
-		rows, err = c.db.Query(selectMessagesSinceIDIncludeScheduledQuery, topic, rowID)
-	} else {
-		rows, err = c.db.Query(selectMessagesSinceIDQuery, topic, rowID)
+		return c.db.Query(selectMessagesSinceIDIncludeScheduledQuery, topic, rowID)
 	}
+	return c.db.Query(selectMessagesSinceIDQuery, topic, rowID)
+}
+
+func (c *messageCache) MessagesDue() ([]*message, error) {
+	rows, err := c.db.Query(selectMessagesDueQuery, time.Now().Unix())
 	if err != nil {
 		return nil, err
 	}
 	return readMessages(rows)
 }
 
-func (c *messageCache) MessagesDue() ([]*message, error) {
-	rows, err := c.db.Query(selectMessagesDueQuery, time.Now().Unix())
+// Thread returns the message with the given ID and all (direct and indirect) replies to it in the given topic,
+// see message.InReplyTo. Scheduled messages that have not been published yet are not included.
+func (c *messageCache) Thread(topic, id string) ([]*message, error) {
+	rows, err := c.db.Query(selectThreadQuery, id, topic, topic)
+	if err != nil {
+		return nil, err
+	}
+	return readMessages(rows)
+}
+
+// LatestMessage returns the newest published message of the given topic, or errMessageNotFound if there is none
+func (c *messageCache) LatestMessage(topic string) (*message, error) {
+	rows, err := c.db.Query(selectMessageLatestQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, errMessageNotFound
+	}
+	return readMessage(rows)
+}
+
+// MessagesPending returns all scheduled messages that are not due yet, across all topics, ordered by delivery time
+func (c *messageCache) MessagesPending() ([]*message, error) {
+	rows, err := c.db.Query(selectMessagesPendingQuery, time.Now().Unix())
 	if err != nil {
 		return nil, err
 	}
@@ -485,22 +1207,50 @@ func (c *messageCache) MessagesExpired() ([]string, error) {
 	return ids, nil
 }
 
-func (c *messageCache) Message(id string) (*message, error) {
-	rows, err := c.db.Query(selectMessagesByIDQuery, id)
+// MessagesBefore returns the IDs of all messages of the topic published before the given time, or of all messages
+// of the topic (including scheduled messages) if the time is zero, e.g. to delete them, see PruneCache
+func (c *messageCache) MessagesBefore(topic string, before time.Time) ([]string, error) {
+	var rows *sql.Rows
+	var err error
+	if before.IsZero() {
+		rows, err = c.db.Query(selectMessageIDsByTopicQuery, topic)
+	} else {
+		rows, err = c.db.Query(selectMessagesBeforeQuery, topic, before.Unix())
+	}
 	if err != nil {
 		return nil, err
 	}
-	if !rows.Next() {
-		return nil, errMessageNotFound
-	}
 	defer rows.Close()
-	return readMessage(rows)
-}
-
-func (c *messageCache) MarkPublished(m *message) error {
-	_, err := c.db.Exec(updateMessagePublishedQuery, m.ID)
-	return err
-}
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (c *messageCache) Message(id string) (*message, error) {
+	rows, err := c.db.Query(selectMessagesByIDQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, errMessageNotFound
+	}
+	defer rows.Close()
+	return readMessage(rows)
+}
+
+func (c *messageCache) MarkPublished(m *message) error {
+	_, err := c.db.Exec(updateMessagePublishedQuery, m.ID)
+	return err
+}
 
 func (c *messageCache) MessageCounts() (map[string]int, error) {
 	rows, err := c.db.Query(selectMessageCountPerTopicQuery)
@@ -542,6 +1292,27 @@ func (c *messageCache) Topics() (map[string]*topic, error) {
 	return topics, nil
 }
 
+// TopicsInactive returns the topics for which no message has been published since the given time
+func (c *messageCache) TopicsInactive(since time.Time) ([]string, error) {
+	rows, err := c.db.Query(selectTopicsInactiveQuery, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	topics := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		topics = append(topics, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
 func (c *messageCache) DeleteMessages(ids ...string) error {
 	tx, err := c.db.Begin()
 	if err != nil {
@@ -552,6 +1323,18 @@ func (c *messageCache) DeleteMessages(ids ...string) error {
 		if _, err := tx.Exec(deleteMessageQuery, id); err != nil {
 			return err
 		}
+		if _, err := tx.Exec(deleteDeliveriesQuery, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteReactionsQuery, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteAnnotationsQuery, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteEscalationsQuery, id); err != nil {
+			return err
+		}
 	}
 	return tx.Commit()
 }
@@ -570,6 +1353,91 @@ func (c *messageCache) ExpireMessages(topics ...string) error {
 	return tx.Commit()
 }
 
+// ExpireMessagesExceedingTopicLimit marks all but the newest maxMessages messages of each topic as expired,
+// so that they are deleted (including their attachments) the next time expired messages are pruned
+func (c *messageCache) ExpireMessagesExceedingTopicLimit(maxMessages int) (int64, error) {
+	now := time.Now().Unix()
+	result, err := c.db.Exec(updateMessagesExceedingTopicLimitQuery, now-1, now-1, maxMessages)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ExpireOldestMessages marks the oldest count messages (across all topics) as expired, see
+// ExpireMessagesExceedingTopicLimit
+func (c *messageCache) ExpireOldestMessages(count int64) (int64, error) {
+	now := time.Now().Unix()
+	result, err := c.db.Exec(updateOldestMessagesExpiryQuery, now-1, now-1, count)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MessagesCount returns the total number of messages in the cache
+func (c *messageCache) MessagesCount() (int64, error) {
+	rows, err := c.db.Query(selectMessagesCountQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, errNoRows
+	}
+	var count int64
+	if err := rows.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, rows.Err()
+}
+
+// MessagesSize returns the (approximate) number of bytes used by the messages table, i.e. the size of all
+// columns of all rows, plus a fixed overhead per row for the numeric columns and the record header. Unlike Size,
+// this does not include other tables (e.g. stats or deliveries), indexes, or free pages.
+func (c *messageCache) MessagesSize() (int64, error) {
+	rows, err := c.db.Query(selectMessagesSizeQuery, messageRowOverheadBytes)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, errNoRows
+	}
+	var size int64
+	if err := rows.Scan(&size); err != nil {
+		return 0, err
+	}
+	return size, rows.Err()
+}
+
+// Size returns the size of the cache database in bytes, as well as the number of bytes in it that are
+// unused (free pages). Free pages are reused for new messages, or reclaimed by Vacuum.
+func (c *messageCache) Size() (size int64, free int64, err error) {
+	rows, err := c.db.Query(selectCacheSizeQuery)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, 0, errNoRows
+	}
+	if err := rows.Scan(&size, &free); err != nil {
+		return 0, 0, err
+	}
+	return size, free, rows.Err()
+}
+
+// Vacuum checkpoints the write-ahead log (if WAL mode is enabled), and rebuilds the database to reclaim
+// unused disk space. This may take a while for large databases, and blocks writes while it runs.
+func (c *messageCache) Vacuum() error {
+	if c.nop {
+		return nil
+	}
+	_, err := c.db.Exec(vacuumQueries)
+	return err
+}
+
 func (c *messageCache) AttachmentsExpired() ([]string, error) {
 	rows, err := c.db.Query(selectAttachmentsExpiredQuery, time.Now().Unix())
 	if err != nil {
@@ -590,6 +1458,20 @@ func (c *messageCache) AttachmentsExpired() ([]string, error) {
 	return ids, nil
 }
 
+// ExpireAttachmentsByUser marks all attachments uploaded by the given user as expired. The files are then
+// removed by the next run of the manager, see AttachmentsExpired.
+func (c *messageCache) ExpireAttachmentsByUser(userID string) error {
+	expires := time.Now().Unix() - 1
+	if _, err := c.db.Exec(updateAttachmentsExpiryByUserQuery, expires, userID, expires); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec(updateUploadsExpiryByUserQuery, expires, userID, expires); err != nil {
+		return err
+	}
+	_, err := c.db.Exec(deleteAttachmentUsageByUserIDQuery, userID)
+	return err
+}
+
 func (c *messageCache) MarkAttachmentsDeleted(ids ...string) error {
 	tx, err := c.db.Begin()
 	if err != nil {
@@ -604,22 +1486,157 @@ func (c *messageCache) MarkAttachmentsDeleted(ids ...string) error {
 	return tx.Commit()
 }
 
+// AttachmentByHash returns the ID of a message with a non-expired attachment with the given content hash,
+// or errMessageNotFound if there is none
+func (c *messageCache) AttachmentByHash(hash string) (string, error) {
+	rows, err := c.db.Query(selectAttachmentByHashQuery, hash, time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", errMessageNotFound
+	}
+	var id string
+	if err := rows.Scan(&id); err != nil {
+		return "", err
+	}
+	return id, rows.Err()
+}
+
 func (c *messageCache) AttachmentBytesUsedBySender(sender string) (int64, error) {
-	rows, err := c.db.Query(selectAttachmentsSizeBySenderQuery, sender, time.Now().Unix())
+	now := time.Now().Unix()
+	rows, err := c.db.Query(selectAttachmentsSizeBySenderQuery, sender, now, sender, now)
 	if err != nil {
 		return 0, err
 	}
 	return c.readAttachmentBytesUsed(rows)
 }
 
+// AttachmentBytesUsedByUser returns the stored attachment storage counter of the given user. Unlike for anonymous
+// senders (see AttachmentBytesUsedBySender), the usage is not summed up on every request. It is increased via
+// AddAttachmentUsage when a file is stored, and reset to the actual usage via RecomputeAttachmentUsage.
 func (c *messageCache) AttachmentBytesUsedByUser(userID string) (int64, error) {
-	rows, err := c.db.Query(selectAttachmentsSizeByUserIDQuery, userID, time.Now().Unix())
+	rows, err := c.db.Query(selectAttachmentUsageByUserIDQuery, userID)
 	if err != nil {
 		return 0, err
 	}
 	return c.readAttachmentBytesUsed(rows)
 }
 
+// AddAttachmentUsage increases the attachment storage counter of the given user by the size of a newly stored
+// file. If the user already has a non-expired attachment or upload with the same content hash, the file is
+// not counted again. This must be called before the message or upload referencing the file is added.
+func (c *messageCache) AddAttachmentUsage(userID string, size int64, hash string) error {
+	if c.nop || userID == "" {
+		return nil
+	}
+	if hash != "" {
+		now := time.Now().Unix()
+		rows, err := c.db.Query(selectAttachmentHashUsedByUserIDQuery, userID, hash, now, userID, hash, now)
+		if err != nil {
+			return err
+		}
+		exists := rows.Next()
+		if err := rows.Close(); err != nil {
+			return err
+		} else if exists {
+			return nil
+		}
+	}
+	_, err := c.db.Exec(upsertAttachmentUsageQuery, userID, size)
+	return err
+}
+
+// RecomputeAttachmentUsage resets the attachment storage counters of all users to the total size of their
+// non-expired attachments and uploads. This is called periodically by the manager, so that expired and
+// deleted attachments are no longer counted.
+func (c *messageCache) RecomputeAttachmentUsage() error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := recomputeAttachmentUsage(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func recomputeAttachmentUsage(tx *sql.Tx) error {
+	now := time.Now().Unix()
+	if _, err := tx.Exec(deleteAttachmentUsageQuery); err != nil {
+		return err
+	}
+	_, err := tx.Exec(insertAttachmentUsageQuery, now, now)
+	return err
+}
+
+// AddUpload stores a separately uploaded attachment, see Upload
+func (c *messageCache) AddUpload(u *upload) error {
+	if c.nop {
+		return nil
+	}
+	_, err := c.db.Exec(insertUploadQuery, u.ID, u.User, u.Sender.String(), u.Name, u.Type, u.Ext, u.Size, u.Hash, u.Expires)
+	return err
+}
+
+// Upload returns the non-expired upload with the given ID, or errUploadNotFound if there is none
+func (c *messageCache) Upload(id string) (*upload, error) {
+	rows, err := c.db.Query(selectUploadQuery, id, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, errUploadNotFound
+	}
+	var u upload
+	var sender string
+	if err := rows.Scan(&u.ID, &u.User, &sender, &u.Name, &u.Type, &u.Ext, &u.Size, &u.Hash, &u.Expires); err != nil {
+		return nil, err
+	}
+	u.Sender, _ = netip.ParseAddr(sender) // Empty if unknown
+	return &u, rows.Err()
+}
+
+// UploadsExpired returns the IDs of all expired uploads. The files must be removed from the file cache
+// before the uploads are deleted via DeleteUploads.
+func (c *messageCache) UploadsExpired() ([]string, error) {
+	rows, err := c.db.Query(selectUploadsExpiredQuery, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// DeleteUploads deletes the uploads with the given IDs. Messages referencing the uploads are not affected.
+func (c *messageCache) DeleteUploads(ids ...string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range ids {
+		if _, err := tx.Exec(deleteUploadQuery, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (c *messageCache) readAttachmentBytesUsed(rows *sql.Rows) (int64, error) {
 	defer rows.Close()
 	var size int64
@@ -634,68 +1651,358 @@ func (c *messageCache) readAttachmentBytesUsed(rows *sql.Rows) (int64, error) {
 	return size, nil
 }
 
-func (c *messageCache) processMessageBatches() {
-	if c.queue == nil {
-		return
+// AddReport stores a new abuse report
+func (c *messageCache) AddReport(r *report) error {
+	_, err := c.db.Exec(
+		insertReportQuery,
+		r.ID,
+		r.Time,
+		r.Topic,
+		r.MessageID,
+		r.Title,
+		r.Message,
+		ipString(r.Sender),
+		r.User,
+		r.Reason,
+		ipString(r.ReporterIP),
+		r.ReporterUser,
+		r.Status,
+		strings.Join(r.Actions, ","),
+	)
+	return err
+}
+
+// Report returns the abuse report with the given ID, or errReportNotFound
+func (c *messageCache) Report(id string) (*report, error) {
+	rows, err := c.db.Query(selectReportQuery, id)
+	if err != nil {
+		return nil, err
 	}
-	for messages := range c.queue.Dequeue() {
-		if err := c.addMessages(messages); err != nil {
-			log.Tag(tagMessageCache).Err(err).Error("Cannot write message batch")
-		}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, errReportNotFound
 	}
+	return readReport(rows)
 }
 
-func readMessages(rows *sql.Rows) ([]*message, error) {
+// Reports returns all abuse reports with the given status (newest first), or all reports if status is empty
+func (c *messageCache) Reports(status string) ([]*report, error) {
+	var rows *sql.Rows
+	var err error
+	if status == "" {
+		rows, err = c.db.Query(selectReportsQuery)
+	} else {
+		rows, err = c.db.Query(selectReportsByStatusQuery, status)
+	}
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
-	messages := make([]*message, 0)
+	reports := make([]*report, 0)
 	for rows.Next() {
-		m, err := readMessage(rows)
+		r, err := readReport(rows)
 		if err != nil {
 			return nil, err
 		}
-		messages = append(messages, m)
+		reports = append(reports, r)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return messages, nil
+	return reports, nil
 }
 
-func readMessage(rows *sql.Rows) (*message, error) {
-	var timestamp, expires, attachmentSize, attachmentExpires int64
-	var priority int
-	var id, topic, msg, title, tagsStr, click, icon, actionsStr, attachmentName, attachmentType, attachmentURL, sender, user, contentType, encoding string
-	err := rows.Scan(
-		&id,
-		&timestamp,
-		&expires,
-		&topic,
-		&msg,
-		&title,
-		&priority,
-		&tagsStr,
-		&click,
-		&icon,
-		&actionsStr,
-		&attachmentName,
-		&attachmentType,
-		&attachmentSize,
-		&attachmentExpires,
-		&attachmentURL,
-		&sender,
-		&user,
-		&contentType,
-		&encoding,
-	)
+// UpdateReport updates the status and the list of actions taken of an abuse report
+func (c *messageCache) UpdateReport(id, status string, actions []string) error {
+	_, err := c.db.Exec(updateReportQuery, status, strings.Join(actions, ","), id)
+	return err
+}
+
+// BlockTopic blocks the given topic, or updates the reason if it is already blocked
+func (c *messageCache) BlockTopic(topic, reason string) error {
+	_, err := c.db.Exec(upsertBlockedTopicQuery, topic, time.Now().Unix(), reason)
+	return err
+}
+
+// UnblockTopic removes the block for the given topic
+func (c *messageCache) UnblockTopic(topic string) error {
+	_, err := c.db.Exec(deleteBlockedTopicQuery, topic)
+	return err
+}
+
+// BlockedTopics returns all blocked topics
+func (c *messageCache) BlockedTopics() ([]*blockedTopic, error) {
+	rows, err := c.db.Query(selectBlockedTopicsQuery)
 	if err != nil {
 		return nil, err
 	}
-	var tags []string
-	if tagsStr != "" {
-		tags = strings.Split(tagsStr, ",")
-	}
-	var actions []*action
-	if actionsStr != "" {
+	defer rows.Close()
+	topics := make([]*blockedTopic, 0)
+	for rows.Next() {
+		var t blockedTopic
+		if err := rows.Scan(&t.Topic, &t.Time, &t.Reason); err != nil {
+			return nil, err
+		}
+		topics = append(topics, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+// BanIP bans the given IP address until expires (forever, if expires is zero), or updates an existing ban
+func (c *messageCache) BanIP(ip netip.Addr, expires time.Time, reason string) error {
+	var expiresUnix int64
+	if !expires.IsZero() {
+		expiresUnix = expires.Unix()
+	}
+	_, err := c.db.Exec(upsertBannedIPQuery, ip.String(), time.Now().Unix(), expiresUnix, reason)
+	return err
+}
+
+// UnbanIP removes the ban for the given IP address
+func (c *messageCache) UnbanIP(ip netip.Addr) error {
+	_, err := c.db.Exec(deleteBannedIPQuery, ip.String())
+	return err
+}
+
+// RemoveExpiredIPBans removes all bans that have expired
+func (c *messageCache) RemoveExpiredIPBans() error {
+	_, err := c.db.Exec(deleteBannedIPsExpiredQuery, time.Now().Unix())
+	return err
+}
+
+// BannedIPs returns all IP bans, including expired bans that have not been removed yet
+func (c *messageCache) BannedIPs() ([]*bannedIP, error) {
+	rows, err := c.db.Query(selectBannedIPsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bans := make([]*bannedIP, 0)
+	for rows.Next() {
+		var ip string
+		var b bannedIP
+		if err := rows.Scan(&ip, &b.Time, &b.Expires, &b.Reason); err != nil {
+			return nil, err
+		}
+		b.IP, err = netip.ParseAddr(ip)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, &b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+// AddRateLimitExemption exempts the given host (IP address, prefix or hostname) or user ID from request and
+// message rate limiting, or updates the reason of an existing exemption
+func (c *messageCache) AddRateLimitExemption(kind rateLimitExemptionKind, target, reason string) error {
+	_, err := c.db.Exec(upsertRateLimitExemptionQuery, string(kind), target, time.Now().Unix(), reason)
+	return err
+}
+
+// RemoveRateLimitExemption removes the exemption for the given host or user ID
+func (c *messageCache) RemoveRateLimitExemption(kind rateLimitExemptionKind, target string) error {
+	_, err := c.db.Exec(deleteRateLimitExemptionQuery, string(kind), target)
+	return err
+}
+
+// RateLimitExemptions returns all rate limit exemptions
+func (c *messageCache) RateLimitExemptions() ([]*rateLimitExemption, error) {
+	rows, err := c.db.Query(selectRateLimitExemptionsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	exemptions := make([]*rateLimitExemption, 0)
+	for rows.Next() {
+		var kind string
+		var e rateLimitExemption
+		if err := rows.Scan(&kind, &e.Target, &e.Time, &e.Reason); err != nil {
+			return nil, err
+		}
+		e.Kind = rateLimitExemptionKind(kind)
+		exemptions = append(exemptions, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return exemptions, nil
+}
+
+// MarkEmailUndeliverable marks the given e-mail address as undeliverable, or updates the reason if it already is
+func (c *messageCache) MarkEmailUndeliverable(address, reason string) error {
+	_, err := c.db.Exec(upsertUndeliverableEmailQuery, strings.ToLower(address), time.Now().Unix(), reason)
+	return err
+}
+
+// RemoveUndeliverableEmail allows sending e-mails to the given address again
+func (c *messageCache) RemoveUndeliverableEmail(address string) error {
+	_, err := c.db.Exec(deleteUndeliverableEmailQuery, strings.ToLower(address))
+	return err
+}
+
+// EmailUndeliverable returns true if the given e-mail address was marked as undeliverable
+func (c *messageCache) EmailUndeliverable(address string) (bool, error) {
+	var count int
+	if err := c.db.QueryRow(selectUndeliverableEmailQuery, strings.ToLower(address)).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// UndeliverableEmails returns all e-mail addresses that were marked as undeliverable
+func (c *messageCache) UndeliverableEmails() ([]*undeliverableEmail, error) {
+	rows, err := c.db.Query(selectUndeliverableEmailsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	emails := make([]*undeliverableEmail, 0)
+	for rows.Next() {
+		var e undeliverableEmail
+		if err := rows.Scan(&e.Address, &e.Time, &e.Reason); err != nil {
+			return nil, err
+		}
+		emails = append(emails, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
+
+// UpdateEmailRecipient remembers the topic and user ID of the last message that was sent to the given e-mail
+// address, so that the publisher can be notified if the address bounces, see EmailRecipient
+func (c *messageCache) UpdateEmailRecipient(address, topic, userID string) error {
+	_, err := c.db.Exec(upsertEmailRecipientQuery, strings.ToLower(address), topic, userID, time.Now().Unix())
+	return err
+}
+
+// EmailRecipient returns the topic and user ID of the last message that was sent to the given e-mail address.
+// The user ID is empty for anonymous publishers, and both are empty if nothing was sent to the address.
+func (c *messageCache) EmailRecipient(address string) (topic, userID string, err error) {
+	err = c.db.QueryRow(selectEmailRecipientQuery, strings.ToLower(address)).Scan(&topic, &userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", nil
+	}
+	return topic, userID, err
+}
+
+// Flush synchronously writes all messages that are still waiting in the batching queue to the database.
+// It is a no-op if batching is disabled.
+func (c *messageCache) Flush() error {
+	if c.queue == nil {
+		return nil
+	}
+	messages := c.queue.Flush()
+	if len(messages) == 0 {
+		return nil
+	}
+	return c.addMessages(messages)
+}
+
+func (c *messageCache) processMessageBatches() {
+	if c.queue == nil {
+		return
+	}
+	for messages := range c.queue.Dequeue() {
+		if err := c.addMessages(messages); err != nil {
+			log.Tag(tagMessageCache).Err(err).Error("Cannot write message batch")
+		}
+	}
+}
+
+func readReport(rows *sql.Rows) (*report, error) {
+	var r report
+	var sender, reporterIP, actions string
+	if err := rows.Scan(&r.ID, &r.Time, &r.Topic, &r.MessageID, &r.Title, &r.Message, &sender, &r.User, &r.Reason, &reporterIP, &r.ReporterUser, &r.Status, &actions); err != nil {
+		return nil, err
+	}
+	r.Sender, _ = netip.ParseAddr(sender) // Empty if unknown
+	r.ReporterIP, _ = netip.ParseAddr(reporterIP)
+	r.Actions = util.SplitNoEmpty(actions, ",")
+	return &r, nil
+}
+
+// ipString returns the string representation of the IP address, or an empty string if it is not valid
+func ipString(ip netip.Addr) string {
+	if !ip.IsValid() {
+		return ""
+	}
+	return ip.String()
+}
+
+func readMessages(rows *sql.Rows) ([]*message, error) {
+	defer rows.Close()
+	messages := make([]*message, 0)
+	for rows.Next() {
+		m, err := readMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func readMessage(rows *sql.Rows) (*message, error) {
+	var timestamp, expires, attachmentSize, attachmentExpires int64
+	var priority int
+	var id, topic, msg, title, tagsStr, click, icon, actionsStr, attachmentName, attachmentType, attachmentURL, sender, user, contentType, encoding, sound, compression, inReplyTo, locationStr, hostname, correlationID string
+	err := rows.Scan(
+		&id,
+		&timestamp,
+		&expires,
+		&topic,
+		&msg,
+		&title,
+		&priority,
+		&tagsStr,
+		&click,
+		&icon,
+		&actionsStr,
+		&attachmentName,
+		&attachmentType,
+		&attachmentSize,
+		&attachmentExpires,
+		&attachmentURL,
+		&sender,
+		&user,
+		&contentType,
+		&encoding,
+		&sound,
+		&compression,
+		&inReplyTo,
+		&locationStr,
+		&hostname,
+		&correlationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if compression != "" {
+		payload, err := decompressPayload(compression, []byte(msg))
+		if err != nil {
+			return nil, err
+		}
+		msg, actionsStr = payload.Message, payload.Actions
+		attachmentName, attachmentType, attachmentURL = payload.AttachmentName, payload.AttachmentType, payload.AttachmentURL
+	}
+	var tags []string
+	if tagsStr != "" {
+		tags = strings.Split(tagsStr, ",")
+	}
+	var actions []*action
+	if actionsStr != "" {
 		if err := json.Unmarshal([]byte(actionsStr), &actions); err != nil {
 			return nil, err
 		}
@@ -704,6 +2011,13 @@ func readMessage(rows *sql.Rows) (*message, error) {
 	if err != nil {
 		senderIP = netip.Addr{} // if no IP stored in database, return invalid address
 	}
+	var loc *location
+	if locationStr != "" {
+		loc, err = parseLocation(locationStr)
+		if err != nil {
+			return nil, err
+		}
+	}
 	var att *attachment
 	if attachmentName != "" && attachmentURL != "" {
 		att = &attachment{
@@ -715,48 +2029,495 @@ func readMessage(rows *sql.Rows) (*message, error) {
 		}
 	}
 	return &message{
-		ID:          id,
-		Time:        timestamp,
-		Expires:     expires,
-		Event:       messageEvent,
-		Topic:       topic,
-		Message:     msg,
-		Title:       title,
-		Priority:    priority,
-		Tags:        tags,
-		Click:       click,
-		Icon:        icon,
-		Actions:     actions,
-		Attachment:  att,
-		Sender:      senderIP, // Must parse assuming database must be correct
-		User:        user,
-		ContentType: contentType,
-		Encoding:    encoding,
+		ID:            id,
+		Time:          timestamp,
+		Expires:       expires,
+		Event:         messageEvent,
+		Topic:         topic,
+		Message:       msg,
+		Title:         title,
+		Priority:      priority,
+		Tags:          tags,
+		Click:         click,
+		Icon:          icon,
+		Sound:         sound,
+		Actions:       actions,
+		Attachment:    att,
+		Sender:        senderIP, // Must parse assuming database must be correct
+		User:          user,
+		ContentType:   contentType,
+		Encoding:      encoding,
+		InReplyTo:     inReplyTo,
+		Location:      loc,
+		Hostname:      hostname,
+		CorrelationID: correlationID,
 	}, nil
 }
 
-func (c *messageCache) UpdateStats(messages int64) error {
-	_, err := c.db.Exec(updateStatsQuery, messages)
-	return err
+func (c *messageCache) UpdateStats(messages int64) error {
+	_, err := c.db.Exec(updateStatsQuery, messages)
+	return err
+}
+
+func (c *messageCache) Stats() (messages int64, err error) {
+	rows, err := c.db.Query(selectStatsQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, errNoRows
+	}
+	if err := rows.Scan(&messages); err != nil {
+		return 0, err
+	}
+	return messages, nil
+}
+
+// AddStatsHistory adds the given counters to the hourly stats entry of e.Time. Messages, bytes and failed
+// deliveries are summed up, whereas for subscribers, the maximum is kept.
+func (c *messageCache) AddStatsHistory(e *statsHistoryEntry) error {
+	_, err := c.db.Exec(upsertStatsHistoryQuery, e.Time, e.Messages, e.Bytes, e.Subscribers, e.Failed)
+	return err
+}
+
+// StatsHistory returns the hourly stats entries in the given time range (since inclusive, until exclusive)
+func (c *messageCache) StatsHistory(since, until time.Time) ([]*statsHistoryEntry, error) {
+	rows, err := c.db.Query(selectStatsHistoryQuery, since.Unix(), until.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]*statsHistoryEntry, 0)
+	for rows.Next() {
+		var e statsHistoryEntry
+		if err := rows.Scan(&e.Time, &e.Messages, &e.Bytes, &e.Subscribers, &e.Failed); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// RemoveStatsHistory deletes all hourly stats entries before the given time
+func (c *messageCache) RemoveStatsHistory(before time.Time) error {
+	_, err := c.db.Exec(deleteStatsHistoryBeforeQuery, before.Unix())
+	return err
+}
+
+// AddDelivery records a delivery attempt of a message, see Server.recordDelivery
+func (c *messageCache) AddDelivery(d *delivery) error {
+	if c.nop {
+		return nil
+	}
+	_, err := c.db.Exec(insertDeliveryQuery, d.MessageID, d.Time, d.Channel, d.Outcome, d.Count, d.Error)
+	return err
+}
+
+// Deliveries returns the recorded delivery attempts of the message with the given ID, oldest first
+func (c *messageCache) Deliveries(id string) ([]*delivery, error) {
+	rows, err := c.db.Query(selectDeliveriesQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]*delivery, 0)
+	for rows.Next() {
+		var d delivery
+		if err := rows.Scan(&d.MessageID, &d.Time, &d.Channel, &d.Outcome, &d.Count, &d.Error); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// AddReaction adds a reaction of the given reactor (user ID or IP address) to a message. Reacting with the
+// same emoji twice has no effect.
+func (c *messageCache) AddReaction(id, emoji, reactor string) error {
+	if c.nop {
+		return nil
+	}
+	_, err := c.db.Exec(insertReactionQuery, id, emoji, reactor, time.Now().Unix())
+	return err
+}
+
+// RemoveReaction removes a reaction of the given reactor from a message, see AddReaction
+func (c *messageCache) RemoveReaction(id, emoji, reactor string) error {
+	_, err := c.db.Exec(deleteReactionQuery, id, emoji, reactor)
+	return err
+}
+
+// Reactions returns the number of reactions per emoji of the message with the given ID
+func (c *messageCache) Reactions(id string) (map[string]int, error) {
+	rows, err := c.db.Query(selectReactionsQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reactions := make(map[string]int)
+	for rows.Next() {
+		var emoji string
+		var count int
+		if err := rows.Scan(&emoji, &count); err != nil {
+			return nil, err
+		}
+		reactions[emoji] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reactions, nil
+}
+
+// AddAnnotation adds an annotation to a message
+func (c *messageCache) AddAnnotation(mid string, a *annotation) error {
+	if c.nop {
+		return nil
+	}
+	_, err := c.db.Exec(insertAnnotationQuery, a.ID, mid, a.Time, a.Text, a.Author, a.Annotator)
+	return err
+}
+
+// RemoveAnnotation removes an annotation of the given annotator (user ID or IP address) from a message, or returns
+// errAnnotationNotFound if the message has no such annotation, or if it was added by someone else
+func (c *messageCache) RemoveAnnotation(mid, id, annotator string) error {
+	res, err := c.db.Exec(deleteAnnotationQuery, mid, id, annotator)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if rows == 0 {
+		return errAnnotationNotFound
+	}
+	return nil
+}
+
+// Annotations returns all annotations of the message with the given ID, oldest first
+func (c *messageCache) Annotations(mid string) ([]*annotation, error) {
+	rows, err := c.db.Query(selectAnnotationsQuery, mid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	annotations := make([]*annotation, 0)
+	for rows.Next() {
+		var a annotation
+		if err := rows.Scan(&a.ID, &a.Time, &a.Text, &a.Author, &a.Annotator); err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, &a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return annotations, nil
+}
+
+// AddEscalation schedules the given escalation step of a message, replacing a previously scheduled step
+func (c *messageCache) AddEscalation(e *escalation) error {
+	if c.nop {
+		return nil
+	}
+	_, err := c.db.Exec(upsertEscalationQuery, e.MessageID, e.Topic, e.Step, e.Due)
+	return err
+}
+
+// RemoveEscalation removes the scheduled escalation of a message, see AddEscalation
+func (c *messageCache) RemoveEscalation(id string) error {
+	_, err := c.db.Exec(deleteEscalationQuery, id)
+	return err
+}
+
+// EscalationsDue returns all escalation steps that are due, oldest first
+func (c *messageCache) EscalationsDue() ([]*escalation, error) {
+	rows, err := c.db.Query(selectEscalationsDueQuery, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	escalations := make([]*escalation, 0)
+	for rows.Next() {
+		var e escalation
+		if err := rows.Scan(&e.MessageID, &e.Topic, &e.Step, &e.Due); err != nil {
+			return nil, err
+		}
+		escalations = append(escalations, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return escalations, nil
+}
+
+// AddMaintenanceWindow adds a maintenance window to a topic
+func (c *messageCache) AddMaintenanceWindow(w *maintenanceWindow) error {
+	_, err := c.db.Exec(insertMaintenanceWindowQuery, w.ID, w.Topic, w.Start, w.End, w.Recurrence, w.Action, w.Reason, w.User, w.Created)
+	return err
+}
+
+// RemoveMaintenanceWindow removes the maintenance window with the given ID from a topic, or returns
+// errMaintenanceNotFound if the topic has no such window
+func (c *messageCache) RemoveMaintenanceWindow(topic, id string) error {
+	res, err := c.db.Exec(deleteMaintenanceWindowQuery, topic, id)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if rows == 0 {
+		return errMaintenanceNotFound
+	}
+	return nil
+}
+
+// RemoveExpiredMaintenanceWindows removes all one-off maintenance windows that have ended. Recurring windows
+// never expire, and must be removed explicitly.
+func (c *messageCache) RemoveExpiredMaintenanceWindows() error {
+	_, err := c.db.Exec(deleteMaintenanceWindowsExpiredQuery, time.Now().Unix())
+	return err
+}
+
+// MaintenanceWindows returns all maintenance windows of a topic, including windows that have ended,
+// but have not been removed yet
+func (c *messageCache) MaintenanceWindows(topic string) ([]*maintenanceWindow, error) {
+	rows, err := c.db.Query(selectMaintenanceWindowsByTopicQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	windows := make([]*maintenanceWindow, 0)
+	for rows.Next() {
+		var w maintenanceWindow
+		if err := rows.Scan(&w.ID, &w.Topic, &w.Start, &w.End, &w.Recurrence, &w.Action, &w.Reason, &w.User, &w.Created); err != nil {
+			return nil, err
+		}
+		windows = append(windows, &w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return windows, nil
+}
+
+// UpsertHeartbeat adds a heartbeat to a topic, or updates the existing heartbeat of the topic. The last ping and the
+// alert state of an existing heartbeat are kept.
+func (c *messageCache) UpsertHeartbeat(h *heartbeat) error {
+	_, err := c.db.Exec(upsertHeartbeatQuery, h.Topic, int64(h.Interval.Seconds()), h.AlertTopic, h.Message, h.Priority, h.User, h.Created, h.Due)
+	return err
+}
+
+// RemoveHeartbeat removes the heartbeat of a topic, or returns errHeartbeatNotFound if the topic has none
+func (c *messageCache) RemoveHeartbeat(topic string) error {
+	res, err := c.db.Exec(deleteHeartbeatQuery, topic)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if rows == 0 {
+		return errHeartbeatNotFound
+	}
+	return nil
+}
+
+// Heartbeat returns the heartbeat of a topic, or errHeartbeatNotFound if the topic has none
+func (c *messageCache) Heartbeat(topic string) (*heartbeat, error) {
+	rows, err := c.db.Query(selectHeartbeatQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	heartbeats, err := readHeartbeats(rows)
+	if err != nil {
+		return nil, err
+	} else if len(heartbeats) == 0 {
+		return nil, errHeartbeatNotFound
+	}
+	return heartbeats[0], nil
+}
+
+// HeartbeatCount returns the number of heartbeats created by the given user
+func (c *messageCache) HeartbeatCount(userID string) (int, error) {
+	var count int
+	if err := c.db.QueryRow(selectHeartbeatCountQuery, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// HeartbeatsDue returns all heartbeats that missed their ping, and that have not been alerted yet
+func (c *messageCache) HeartbeatsDue() ([]*heartbeat, error) {
+	rows, err := c.db.Query(selectHeartbeatsDueQuery, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	return readHeartbeats(rows)
+}
+
+// MarkHeartbeatPinged records a ping of the heartbeat of a topic, moves its due time, and resets its alert state
+func (c *messageCache) MarkHeartbeatPinged(topic string, last int64) error {
+	_, err := c.db.Exec(updateHeartbeatPingQuery, last, last, topic)
+	return err
+}
+
+// MarkHeartbeatAlerted records the ID of the alert message that was published when the heartbeat was missed
+func (c *messageCache) MarkHeartbeatAlerted(topic, alertMessageID string) error {
+	_, err := c.db.Exec(updateHeartbeatAlertedQuery, alertMessageID, topic)
+	return err
+}
+
+func readHeartbeats(rows *sql.Rows) ([]*heartbeat, error) {
+	defer rows.Close()
+	heartbeats := make([]*heartbeat, 0)
+	for rows.Next() {
+		var h heartbeat
+		var interval int64
+		if err := rows.Scan(&h.Topic, &interval, &h.AlertTopic, &h.Message, &h.Priority, &h.User, &h.Created, &h.LastPing, &h.Due, &h.AlertMessageID); err != nil {
+			return nil, err
+		}
+		h.Interval = time.Duration(interval) * time.Second
+		heartbeats = append(heartbeats, &h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return heartbeats, nil
+}
+
+// MessageAcknowledged returns true if anyone replied to, reacted to or annotated the message with the given ID
+func (c *messageCache) MessageAcknowledged(id string) (bool, error) {
+	var count int
+	if err := c.db.QueryRow(selectAcknowledgementQuery, id, id, id).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Snapshot writes the in-memory database to the snapshot file. The file is written to a temporary
+// file first and then renamed, so an existing snapshot is never left half-written.
+func (c *messageCache) Snapshot() error {
+	if c.snapshotFile == "" {
+		return nil
+	}
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+	start := time.Now()
+	tmpFile := c.snapshotFile + ".tmp"
+	if err := os.Remove(tmpFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fileDB, err := sql.Open("sqlite3", tmpFile)
+	if err != nil {
+		return err
+	}
+	if err := copyDB(fileDB, c.db); err != nil {
+		fileDB.Close()
+		return err
+	}
+	if err := fileDB.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, c.snapshotFile); err != nil {
+		return err
+	}
+	log.Tag(tagMessageCache).Debug("Wrote message cache snapshot to %s in %v", c.snapshotFile, time.Since(start))
+	return nil
+}
+
+func (c *messageCache) runSnapshots(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Snapshot(); err != nil {
+				log.Tag(tagMessageCache).Err(err).Warn("Cannot write message cache snapshot")
+			}
+		case <-c.snapshotClose:
+			return
+		}
+	}
+}
+
+// Ping checks that the underlying database is reachable and readable
+func (c *messageCache) Ping(ctx context.Context) error {
+	var version int
+	return c.db.QueryRowContext(ctx, selectSchemaVersionQuery).Scan(&version)
 }
 
-func (c *messageCache) Stats() (messages int64, err error) {
-	rows, err := c.db.Query(selectStatsQuery)
+func (c *messageCache) Close() error {
+	if c.snapshotFile != "" {
+		close(c.snapshotClose)
+		if err := c.Snapshot(); err != nil {
+			log.Tag(tagMessageCache).Err(err).Warn("Cannot write message cache snapshot")
+		}
+	}
+	return c.db.Close()
+}
+
+// restoreSnapshot loads the snapshot file into the (empty) database db. The snapshot is migrated to the
+// current schema version before it is copied.
+func restoreSnapshot(db *sql.DB, snapshotFile string, cacheDuration time.Duration) error {
+	fileDB, err := sql.Open("sqlite3", snapshotFile)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	defer rows.Close()
-	if !rows.Next() {
-		return 0, errNoRows
+	defer fileDB.Close()
+	if err := setupMessagesDB(fileDB, "", cacheDuration); err != nil {
+		return err
 	}
-	if err := rows.Scan(&messages); err != nil {
-		return 0, err
+	start := time.Now()
+	if err := copyDB(db, fileDB); err != nil {
+		return err
 	}
-	return messages, nil
+	log.Tag(tagMessageCache).Info("Restored message cache from snapshot %s in %v", snapshotFile, time.Since(start))
+	return nil
 }
 
-func (c *messageCache) Close() error {
-	return c.db.Close()
+// copyDB copies the main database of src to dst, using the SQLite online backup API
+func copyDB(dst, src *sql.DB) error {
+	ctx := context.Background()
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	return dstConn.Raw(func(dstDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			dstSqliteConn, ok := dstDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("unexpected driver connection type")
+			}
+			srcSqliteConn, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("unexpected driver connection type")
+			}
+			backup, err := dstSqliteConn.Backup("main", srcSqliteConn, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
 }
 
 func setupMessagesDB(db *sql.DB, startupQueries string, cacheDuration time.Duration) error {
@@ -970,3 +2731,294 @@ func migrateFrom11(db *sql.DB, _ time.Duration) error {
 	}
 	return tx.Commit()
 }
+
+func migrateFrom12(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 12 to 13")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate12To13AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 13); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom13(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 13 to 14")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate13To14AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 14); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom14(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 14 to 15")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate14To15CreateAbuseTablesQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 15); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom15(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 15 to 16")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate15To16CreateStatsHistoryTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 16); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom16(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 16 to 17")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate16To17CreateDeliveriesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 17); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom17(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 17 to 18")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate17To18AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 18); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom18(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 18 to 19")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate18To19CreateReactionsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 19); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom19(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 19 to 20")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate19To20AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 20); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom20(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 20 to 21")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate20To21CreateRateLimitExemptionsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 21); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom21(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 21 to 22")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate21To22CreateUndeliverableEmailsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 22); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom22(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 22 to 23")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate22To23CreateEmailRecipientsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 23); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom23(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 23 to 24")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate23To24AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 24); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom24(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 24 to 25")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate24To25CreateEscalationsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 25); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom25(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 25 to 26")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate25To26CreateMaintenanceWindowsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 26); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom26(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 26 to 27")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate26To27CreateHeartbeatsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 27); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom27(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 27 to 28")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate27To28CreateAnnotationsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 28); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom28(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 28 to 29")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate28To29CreateUploadsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 29); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom29(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 29 to 30")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate29To30CreateAttachmentUsageTableQuery); err != nil {
+		return err
+	}
+	if err := recomputeAttachmentUsage(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 30); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressionZstd = "zstd"
+)
+
+var (
+	zstdEncoder     *zstd.Encoder
+	zstdDecoder     *zstd.Decoder
+	zstdEncoderOnce sync.Once
+	zstdDecoderOnce sync.Once
+)
+
+// cachedPayload contains the (potentially large) message fields that are compressed together in the message cache,
+// if they exceed Config.CacheCompressThreshold. The compressed payload is stored in the "message" column, and the
+// other columns are left empty. Fields that are used in queries (e.g. attachment_expires) are never compressed.
+type cachedPayload struct {
+	Message        string `json:"message,omitempty"`
+	Actions        string `json:"actions,omitempty"`
+	AttachmentName string `json:"attachment_name,omitempty"`
+	AttachmentType string `json:"attachment_type,omitempty"`
+	AttachmentURL  string `json:"attachment_url,omitempty"`
+}
+
+func (p *cachedPayload) size() int64 {
+	return int64(len(p.Message) + len(p.Actions) + len(p.AttachmentName) + len(p.AttachmentType) + len(p.AttachmentURL))
+}
+
+// compress returns the zstd-compressed JSON representation of the payload, or nil if compressing
+// does not make it any smaller (e.g. if the message is base64-encoded binary data)
+func (p *cachedPayload) compress() ([]byte, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoderOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil) // Cannot fail without options
+	})
+	compressed := zstdEncoder.EncodeAll(b, nil)
+	if int64(len(compressed)) >= p.size() {
+		return nil, nil
+	}
+	return compressed, nil
+}
+
+// decompressPayload reverses cachedPayload.compress
+func decompressPayload(compression string, b []byte) (*cachedPayload, error) {
+	if compression != compressionZstd {
+		return nil, fmt.Errorf("unsupported message compression %s", compression)
+	}
+	zstdDecoderOnce.Do(func() {
+		zstdDecoder, _ = zstd.NewReader(nil) // Cannot fail without options
+	})
+	decompressed, err := zstdDecoder.DecodeAll(b, nil)
+	if err != nil {
+		return nil, err
+	}
+	var p cachedPayload
+	if err := json.Unmarshal(decompressed, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	// CacheBackendSQLite is the URL scheme of the SQLite message cache backend, e.g. sqlite:///var/cache/ntfy/cache.db
+	CacheBackendSQLite = "sqlite"
+
+	// DefaultCacheMigrateBatchSize is the number of messages copied per transaction in MigrateCache
+	DefaultCacheMigrateBatchSize = 1000
+)
+
+// Cache migration queries, see MigrateCache. All columns are copied as is, so that compressed messages,
+// attachment metadata and the published/deleted flags are preserved.
+const (
+	migrateSelectMessagesCountQuery = `SELECT COUNT(*) FROM messages`
+	migrateSelectMessagesQuery      = `
+		SELECT id, mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_deleted, attachment_hash, sender, user, content_type, encoding, sound, published, compression, in_reply_to, location, hostname, correlation_id
+		FROM messages
+		WHERE id > ?
+		ORDER BY id
+		LIMIT ?
+	`
+	migrateInsertMessageQuery = `
+		INSERT INTO messages (mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_deleted, attachment_hash, sender, user, content_type, encoding, sound, published, compression, in_reply_to, location, hostname, correlation_id)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM messages WHERE mid = ?)
+	`
+	migrateMessageColumns = 29 // Excluding id
+)
+
+var (
+	// migrateTables are copied in full after the messages; rows that already exist in the target (by primary key) are skipped
+	migrateTables = []struct {
+		name    string
+		columns string
+	}{
+		{"reports", "id, time, topic, mid, title, message, sender, user, reason, reporter_ip, reporter_user, status, actions"},
+		{"blocked_topics", "topic, time, reason"},
+		{"banned_ips", "ip, time, expires, reason"},
+		{"rate_limit_exemptions", "kind, target, time, reason"},
+		{"undeliverable_emails", "address, time, reason"},
+		{"email_recipients", "address, topic, user, time"},
+		{"stats_history", "time, messages, bytes, subscribers, failed"},
+		{"reactions", "mid, emoji, reactor, time"},
+		{"escalations", "mid, topic, step, due"},
+		{"maintenance_windows", "id, topic, start, end, recurrence, action, reason, user, created"},
+		{"heartbeats", "topic, interval, alert_topic, message, priority, user, created, last, due, alert_mid"},
+		{"annotations", "id, mid, time, text, author, annotator"},
+		{"uploads", "id, user, sender, name, type, ext, size, hash, expires"},
+		{"attachment_usage", "user, bytes"},
+	}
+)
+
+// CacheMigrateProgress is reported by MigrateCache after every batch of messages
+type CacheMigrateProgress struct {
+	Total   int64 // Number of messages in the source cache
+	Copied  int64 // Number of messages copied so far
+	Skipped int64 // Number of messages that already existed in the target cache, e.g. from a previous, interrupted run
+}
+
+// ParseCacheURL parses a message cache URL such as sqlite:///var/cache/ntfy/cache.db, and returns the backend
+// and the location of the cache. A plain filename is treated as a SQLite cache.
+func ParseCacheURL(s string) (backend string, location string, err error) {
+	if !strings.Contains(s, "://") {
+		return CacheBackendSQLite, s, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", err
+	}
+	switch u.Scheme {
+	case CacheBackendSQLite:
+		location = u.Host + u.Path // sqlite://cache.db (relative) or sqlite:///var/cache/ntfy/cache.db (absolute)
+		if location == "" {
+			return "", "", fmt.Errorf("invalid cache URL %s: filename missing", s)
+		}
+		return CacheBackendSQLite, location, nil
+	default:
+		return "", "", fmt.Errorf("unsupported cache backend %s, supported backends: %s", u.Scheme, CacheBackendSQLite)
+	}
+}
+
+// MigrateCache copies all messages (including attachment metadata and reactions), abuse reports, blocked topics,
+// banned IPs and the stats history from one message cache to another. Both caches are upgraded to the current schema version
+// before copying. Messages that already exist in the target cache are skipped, so an interrupted migration
+// can be resumed by running it again. The delivery log is not copied.
+func MigrateCache(from, to string, batchSize int, progress func(p *CacheMigrateProgress)) error {
+	_, fromFile, err := ParseCacheURL(from)
+	if err != nil {
+		return err
+	}
+	_, toFile, err := ParseCacheURL(to)
+	if err != nil {
+		return err
+	}
+	if fromFile == toFile {
+		return fmt.Errorf("source and target cache must be different")
+	} else if !util.FileExists(fromFile) {
+		return fmt.Errorf("source cache %s does not exist", fromFile)
+	} else if batchSize <= 0 {
+		batchSize = DefaultCacheMigrateBatchSize
+	}
+	source, err := newSqliteCache(fromFile, "", DefaultCacheDuration, 0, 0, false)
+	if err != nil {
+		return fmt.Errorf("cannot open source cache %s: %w", fromFile, err)
+	}
+	defer source.Close()
+	target, err := newSqliteCache(toFile, "", DefaultCacheDuration, 0, 0, false)
+	if err != nil {
+		return fmt.Errorf("cannot open target cache %s: %w", toFile, err)
+	}
+	defer target.Close()
+	if err := migrateMessages(source.db, target.db, batchSize, progress); err != nil {
+		return err
+	}
+	for _, table := range migrateTables {
+		if err := migrateTable(source.db, target.db, table.name, table.columns); err != nil {
+			return fmt.Errorf("cannot copy table %s: %w", table.name, err)
+		}
+	}
+	messages, err := source.Stats()
+	if err != nil {
+		return err
+	}
+	targetMessages, err := target.Stats()
+	if err != nil {
+		return err
+	}
+	if targetMessages < messages {
+		return target.UpdateStats(messages)
+	}
+	return nil
+}
+
+func migrateMessages(source, target *sql.DB, batchSize int, progress func(p *CacheMigrateProgress)) error {
+	p := &CacheMigrateProgress{}
+	if err := source.QueryRow(migrateSelectMessagesCountQuery).Scan(&p.Total); err != nil {
+		return err
+	}
+	var lastID int64
+	for {
+		rows, err := source.Query(migrateSelectMessagesQuery, lastID, batchSize)
+		if err != nil {
+			return err
+		}
+		batch := make([][]any, 0, batchSize)
+		for rows.Next() {
+			values := make([]any, migrateMessageColumns)
+			dest := make([]any, migrateMessageColumns+1)
+			dest[0] = &lastID
+			for i := range values {
+				dest[i+1] = &values[i]
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, values)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return err
+		}
+		rows.Close()
+		if len(batch) == 0 {
+			return nil
+		}
+		copied, err := migrateMessagesBatch(target, batch)
+		if err != nil {
+			return err
+		}
+		p.Copied += copied
+		p.Skipped += int64(len(batch)) - copied
+		if progress != nil {
+			progress(p)
+		}
+	}
+}
+
+func migrateMessagesBatch(target *sql.DB, batch [][]any) (copied int64, err error) {
+	tx, err := target.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(migrateInsertMessageQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	for _, values := range batch {
+		result, err := stmt.Exec(append(values, values[0])...) // Last parameter is the "mid" for the NOT EXISTS check
+		if err != nil {
+			return 0, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		copied += rowsAffected
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return copied, nil
+}
+
+func migrateTable(source, target *sql.DB, table, columns string) error {
+	rows, err := source.Query(fmt.Sprintf(`SELECT %s FROM %s`, columns, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tx, err := target.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	numColumns := len(strings.Split(columns, ","))
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", numColumns), ", ")
+	stmt, err := tx.Prepare(fmt.Sprintf(`INSERT OR IGNORE INTO %s (%s) VALUES (%s)`, table, columns, placeholders))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for rows.Next() {
+		values := make([]any, numColumns)
+		dest := make([]any, numColumns)
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
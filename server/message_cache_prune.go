@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+// PruneCache deletes all messages of a topic published before the given time (or all messages of the topic if the
+// time is zero) from a message cache, along with their attachments in the attachment cache directory (if set). This
+// is meant for cleaning up after sensitive data was leaked into a topic. It returns the number of deleted messages.
+// If the server is running, the prune admin API should be used instead, see handleMessagesPrune.
+func PruneCache(cacheURL, attachmentCacheDir, topic string, before time.Time) (int, error) {
+	_, cacheFile, err := ParseCacheURL(cacheURL)
+	if err != nil {
+		return 0, err
+	} else if !util.FileExists(cacheFile) {
+		return 0, fmt.Errorf("cache %s does not exist", cacheFile)
+	} else if !topicRegex.MatchString(topic) {
+		return 0, fmt.Errorf("invalid topic %s", topic)
+	}
+	cache, err := newSqliteCache(cacheFile, "", DefaultCacheDuration, 0, 0, false)
+	if err != nil {
+		return 0, fmt.Errorf("cannot open cache %s: %w", cacheFile, err)
+	}
+	defer cache.Close()
+	var files *fileCache
+	if attachmentCacheDir != "" {
+		files, err = newFileCache(attachmentCacheDir, 0)
+		if err != nil {
+			return 0, fmt.Errorf("cannot open attachment cache directory %s: %w", attachmentCacheDir, err)
+		}
+	}
+	return pruneTopicMessages(cache, files, topic, before)
+}
+
+// pruneTopicMessages deletes the messages of the topic published before the given time (all messages if the time
+// is zero), and their attachments
+func pruneTopicMessages(cache *messageCache, files *fileCache, topic string, before time.Time) (int, error) {
+	ids, err := cache.MessagesBefore(topic, before)
+	if err != nil {
+		return 0, err
+	} else if len(ids) == 0 {
+		return 0, nil
+	}
+	if files != nil {
+		if err := files.Remove(ids...); err != nil {
+			return 0, err
+		}
+	}
+	if err := cache.DeleteMessages(ids...); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	paddleAPIURLProduction      = "https://api.paddle.com"
+	paddleAPIURLSandbox         = "https://sandbox-api.paddle.com"
+	paddleEnvironmentSandbox    = "sandbox"
+	paddleSignatureHeader       = "Paddle-Signature"
+	paddleSignatureMaxAge       = 5 * time.Minute
+	paddleRequestTimeout        = 15 * time.Second
+	paddleResponseBytesLimit    = 1024 * 1024
+	paddlePricesPerPage         = 200
+	paddleActiveSubscriptions   = "active,past_due,trialing,paused"
+	paddleProrationBillingMode  = "prorated_immediately"
+	paddleEffectiveFromNow      = "immediately"
+	paddleEffectiveFromEnd      = "next_billing_period"
+	paddleScheduledChangeCancel = "cancel"
+)
+
+var (
+	errPaddleSignatureInvalid = errors.New("invalid Paddle signature")
+	errPaddleNoCheckoutURL    = errors.New("paddle did not return a checkout URL, make sure a default payment link is set")
+)
+
+// paddleWebhookEvents maps the Paddle subscription webhook events to a paymentWebhookEvent type. A canceled
+// subscription in Paddle has ended; a cancellation at the end of the billing period is a scheduled change.
+var paddleWebhookEvents = map[string]int{
+	"subscription.created":   paymentWebhookEventSubscriptionUpdated,
+	"subscription.updated":   paymentWebhookEventSubscriptionUpdated,
+	"subscription.activated": paymentWebhookEventSubscriptionUpdated,
+	"subscription.trialing":  paymentWebhookEventSubscriptionUpdated,
+	"subscription.past_due":  paymentWebhookEventSubscriptionUpdated,
+	"subscription.paused":    paymentWebhookEventSubscriptionUpdated,
+	"subscription.resumed":   paymentWebhookEventSubscriptionUpdated,
+	"subscription.canceled":  paymentWebhookEventSubscriptionDeleted,
+}
+
+// paddlePaymentProvider implements the paymentProvider interface for Paddle Billing, see
+// https://developer.paddle.com/api-reference/overview.
+//
+// Unlike Stripe, Paddle does not redirect to a success URL with a session ID after the checkout. Instead, the
+// ntfy user ID is attached to the checkout transaction as custom data, which Paddle copies to the subscription.
+// The user is then mapped to the Paddle customer when the subscription.created webhook arrives.
+type paddlePaymentProvider struct {
+	config  *Config
+	client  *http.Client
+	baseURL string
+}
+
+var _ paymentProvider = (*paddlePaymentProvider)(nil)
+
+func newPaddlePaymentProvider(conf *Config) *paddlePaymentProvider {
+	baseURL := paddleAPIURLProduction
+	if conf.PaddleEnvironment == paddleEnvironmentSandbox {
+		baseURL = paddleAPIURLSandbox
+	}
+	return &paddlePaymentProvider{
+		config:  conf,
+		client:  &http.Client{Timeout: paddleRequestTimeout},
+		baseURL: baseURL,
+	}
+}
+
+func (p *paddlePaymentProvider) Name() string {
+	return tagPaddle
+}
+
+func (p *paddlePaymentProvider) ListPrices() (map[string]int64, error) {
+	priceMap := make(map[string]int64)
+	path := fmt.Sprintf("/prices?status=active&per_page=%d", paddlePricesPerPage)
+	for path != "" {
+		var response apiPaddleResponse[[]*apiPaddlePrice]
+		if err := p.do(http.MethodGet, path, nil, &response); err != nil {
+			return nil, err
+		}
+		for _, price := range response.Data {
+			if price.UnitPrice == nil {
+				continue
+			}
+			amount, err := strconv.ParseInt(price.UnitPrice.Amount, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid amount for Paddle price %s: %s", price.ID, price.UnitPrice.Amount)
+			}
+			priceMap[price.ID] = amount
+		}
+		path = ""
+		if response.Meta != nil && response.Meta.Pagination != nil && response.Meta.Pagination.HasMore {
+			path = strings.TrimPrefix(response.Meta.Pagination.Next, p.baseURL)
+		}
+	}
+	return priceMap, nil
+}
+
+// NewCheckout creates a Paddle transaction, and returns its checkout URL. The checkout URL is based on the
+// default payment link, which must be set in the Paddle dashboard (Checkout settings).
+func (p *paddlePaymentProvider) NewCheckout(u *user.User, priceID string) (string, error) {
+	if u.Billing.StripeCustomerID != "" {
+		var subscriptions apiPaddleResponse[[]*apiPaddleSubscription]
+		path := fmt.Sprintf("/subscriptions?customer_id=%s&status=%s", url.QueryEscape(u.Billing.StripeCustomerID), paddleActiveSubscriptions)
+		if err := p.do(http.MethodGet, path, nil, &subscriptions); err != nil {
+			return "", err
+		} else if len(subscriptions.Data) > 0 {
+			return "", errMultipleBillingSubscriptions
+		}
+	}
+	req := &apiPaddleTransactionRequest{
+		Items: []*apiPaddleItem{
+			{
+				PriceID:  priceID,
+				Quantity: 1,
+			},
+		},
+		CustomerID: u.Billing.StripeCustomerID, // A user may have previously deleted their subscription
+		CustomData: &apiPaddleCustomData{
+			UserID:   u.ID,
+			UserName: u.Name,
+		},
+	}
+	var response apiPaddleResponse[*apiPaddleTransaction]
+	if err := p.do(http.MethodPost, "/transactions", req, &response); err != nil {
+		return "", err
+	} else if response.Data == nil || response.Data.Checkout == nil || response.Data.Checkout.URL == "" {
+		return "", errPaddleNoCheckoutURL
+	}
+	return response.Data.Checkout.URL, nil
+}
+
+// CheckoutSuccess is not supported by Paddle, since the checkout does not redirect back to ntfy
+// with a session ID. The subscription is picked up by the webhook instead.
+func (p *paddlePaymentProvider) CheckoutSuccess(_ string) (*paymentSubscription, error) {
+	return nil, errHTTPNotFound
+}
+
+func (p *paddlePaymentProvider) UpdateCustomer(customerID string, u *user.User) error {
+	req := &apiPaddleCustomerUpdateRequest{
+		CustomData: &apiPaddleCustomData{
+			UserID:   u.ID,
+			UserName: u.Name,
+		},
+	}
+	return p.do(http.MethodPatch, "/customers/"+url.PathEscape(customerID), req, nil)
+}
+
+// ChangeSubscription replaces the items of the subscription with the new price, and bills the prorated
+// amount immediately. Setting the scheduled change to null reverts a pending cancellation.
+func (p *paddlePaymentProvider) ChangeSubscription(subscriptionID, priceID string) error {
+	req := &apiPaddleSubscriptionUpdateRequest{
+		Items: []*apiPaddleItem{
+			{
+				PriceID:  priceID,
+				Quantity: 1,
+			},
+		},
+		ProrationBillingMode: paddleProrationBillingMode,
+	}
+	return p.do(http.MethodPatch, "/subscriptions/"+url.PathEscape(subscriptionID), req, nil)
+}
+
+func (p *paddlePaymentProvider) CancelSubscriptionAtPeriodEnd(subscriptionID string) error {
+	req := &apiPaddleSubscriptionCancelRequest{
+		EffectiveFrom: paddleEffectiveFromEnd,
+	}
+	return p.do(http.MethodPost, "/subscriptions/"+url.PathEscape(subscriptionID)+"/cancel", req, nil)
+}
+
+func (p *paddlePaymentProvider) CancelSubscription(subscriptionID string) error {
+	req := &apiPaddleSubscriptionCancelRequest{
+		EffectiveFrom: paddleEffectiveFromNow,
+	}
+	return p.do(http.MethodPost, "/subscriptions/"+url.PathEscape(subscriptionID)+"/cancel", req, nil)
+}
+
+func (p *paddlePaymentProvider) NewPortalSession(customerID string) (string, error) {
+	var response apiPaddleResponse[*apiPaddlePortalSession]
+	if err := p.do(http.MethodPost, "/customers/"+url.PathEscape(customerID)+"/portal-sessions", struct{}{}, &response); err != nil {
+		return "", err
+	} else if response.Data == nil || response.Data.URLs == nil || response.Data.URLs.General == nil {
+		return "", errHTTPBadRequestBillingRequestInvalid.Wrap("no portal URL returned")
+	}
+	return response.Data.URLs.General.Overview, nil
+}
+
+// ConstructWebhookEvent validates the Paddle signature of an incoming webhook, and translates the
+// subscription events into a paymentWebhookEvent.
+func (p *paddlePaymentProvider) ConstructWebhookEvent(header http.Header, payload []byte) (*paymentWebhookEvent, error) {
+	if err := verifyPaddleSignature(header.Get(paddleSignatureHeader), p.config.PaddleWebhookKey, payload, time.Now()); err != nil {
+		log.Tag(tagPaddle).Err(err).Debug("Rejecting webhook request")
+		return nil, errHTTPBadRequestBillingRequestInvalid
+	}
+	var event apiPaddleWebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, errHTTPBadRequestBillingRequestInvalid
+	}
+	eventType, ok := paddleWebhookEvents[event.EventType]
+	if !ok {
+		return &paymentWebhookEvent{
+			Type: paymentWebhookEventUnhandled,
+			Name: event.EventType,
+		}, nil
+	}
+	var sub apiPaddleSubscription
+	if err := json.Unmarshal(event.Data, &sub); err != nil {
+		return nil, errHTTPBadRequestBillingRequestInvalid
+	} else if sub.ID == "" || sub.CustomerID == "" {
+		return nil, errHTTPBadRequestBillingRequestInvalid
+	}
+	subscription := &paymentSubscription{
+		ID:         sub.ID,
+		CustomerID: sub.CustomerID,
+		Status:     sub.Status,
+	}
+	if sub.CustomData != nil {
+		subscription.UserID = sub.CustomData.UserID
+	}
+	if eventType == paymentWebhookEventSubscriptionUpdated {
+		if sub.Status == "" || len(sub.Items) != 1 || sub.Items[0].Price == nil || sub.Items[0].Price.ID == "" || sub.Items[0].Price.BillingCycle == nil {
+			log.Tag(tagPaddle).Field("paddle_request", string(event.Data)).Warn("Unexpected request from Paddle")
+			return nil, errHTTPBadRequestBillingRequestInvalid
+		}
+		subscription.PriceID = sub.Items[0].Price.ID
+		subscription.Interval = sub.Items[0].Price.BillingCycle.Interval
+		if sub.CurrentBillingPeriod != nil {
+			subscription.PaidUntil = sub.CurrentBillingPeriod.EndsAt.Unix()
+		}
+		if sub.ScheduledChange != nil && sub.ScheduledChange.Action == paddleScheduledChangeCancel {
+			subscription.CancelAt = sub.ScheduledChange.EffectiveAt.Unix()
+		}
+	}
+	return &paymentWebhookEvent{
+		Type:         eventType,
+		Name:         event.EventType,
+		Subscription: subscription,
+	}, nil
+}
+
+// do performs a request against the Paddle API, and decodes the response into the given value (if not nil)
+func (p *paddlePaymentProvider) do(method, path string, body any, v any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, p.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.config.PaddleAPIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, paddleResponseBytesLimit))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var response apiPaddleResponse[json.RawMessage]
+		if err := json.Unmarshal(respBody, &response); err == nil && response.Error != nil {
+			return fmt.Errorf("paddle API request failed with HTTP %d: %s (%s)", resp.StatusCode, response.Error.Detail, response.Error.Code)
+		}
+		return fmt.Errorf("paddle API request failed with HTTP %d", resp.StatusCode)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, v)
+}
+
+// verifyPaddleSignature validates the Paddle-Signature header, which has the format "ts=<timestamp>;h1=<signature>",
+// where signature is the hex-encoded HMAC-SHA256 of "<timestamp>:<body>". During secret rotation, there may be
+// multiple h1 values. Requests older than paddleSignatureMaxAge are rejected to prevent replays.
+func verifyPaddleSignature(header, secret string, payload []byte, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "ts":
+			timestamp = value
+		case "h1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return errPaddleSignatureInvalid
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Sub(time.Unix(ts, 0)).Abs() > paddleSignatureMaxAge {
+		return errPaddleSignatureInvalid
+	}
+	expected := signPaddlePayload(secret, timestamp, payload)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return errPaddleSignatureInvalid
+}
+
+func signPaddlePayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + ":"))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testPaddleAPI struct {
+	server   *httptest.Server
+	requests []string
+	bodies   map[string]string
+	mu       sync.Mutex
+}
+
+func newTestPaddleAPI(t *testing.T) *testPaddleAPI {
+	api := &testPaddleAPI{bodies: make(map[string]string)}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer paddle key", r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		request := r.Method + " " + r.URL.RequestURI()
+		api.mu.Lock()
+		api.requests = append(api.requests, request)
+		api.bodies[request] = string(body)
+		api.mu.Unlock()
+		switch request {
+		case "GET /prices?status=active&per_page=200":
+			fmt.Fprintf(w, `{"data":[{"id":"pri_123","unit_price":{"amount":"500"}},{"id":"pri_124","unit_price":{"amount":"5000"}}],"meta":{"pagination":{"next":"%s/prices?after=pri_124","has_more":true}}}`, api.server.URL)
+		case "GET /prices?after=pri_124":
+			fmt.Fprint(w, `{"data":[{"id":"pri_456","unit_price":{"amount":"1000"}}],"meta":{"pagination":{"has_more":false}}}`)
+		case "GET /subscriptions?customer_id=ctm_123&status=active,past_due,trialing,paused":
+			fmt.Fprint(w, `{"data":[]}`)
+		case "POST /transactions":
+			fmt.Fprint(w, `{"data":{"id":"txn_123","checkout":{"url":"https://ntfy.example.com/pay?_ptxn=txn_123"}}}`)
+		case "PATCH /subscriptions/sub_123", "POST /subscriptions/sub_123/cancel":
+			fmt.Fprint(w, `{"data":{"id":"sub_123"}}`)
+		case "POST /customers/ctm_123/portal-sessions":
+			fmt.Fprint(w, `{"data":{"urls":{"general":{"overview":"https://customer-portal.paddle.com/cpl_123"}}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"not_found","detail":"Entity not found"}}`)
+		}
+	}))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (api *testPaddleAPI) Body(request string) string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.bodies[request]
+}
+
+func TestPayments_Paddle_Tiers(t *testing.T) {
+	s, _ := newTestServerWithPaddle(t)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:                 "pro",
+		Name:                 "Pro",
+		StripeMonthlyPriceID: "pri_123",
+		StripeYearlyPriceID:  "pri_456",
+	}))
+
+	rr := request(t, s, "GET", "/config.js", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Contains(t, rr.Body.String(), `"enable_payments": true`)
+
+	rr = request(t, s, "GET", "/v1/tiers", "", nil)
+	require.Equal(t, 200, rr.Code)
+	tiers, err := util.UnmarshalJSON[[]*apiAccountBillingTier](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(*tiers))
+	require.Equal(t, "pro", (*tiers)[1].Code)
+	require.Equal(t, int64(500), (*tiers)[1].Prices.Month)
+	require.Equal(t, int64(1000), (*tiers)[1].Prices.Year)
+}
+
+func TestPayments_Paddle_SubscriptionCreate_Update_Delete(t *testing.T) {
+	s, api := newTestServerWithPaddle(t)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:                 "pro",
+		StripeMonthlyPriceID: "pri_123",
+		StripeYearlyPriceID:  "pri_456",
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+
+	// Create checkout, the user ID is passed along as custom data
+	rr := request(t, s, "POST", "/v1/account/billing/subscription", `{"tier": "pro", "interval": "month"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	redirect, _ := util.UnmarshalJSON[apiAccountBillingSubscriptionCreateResponse](io.NopCloser(rr.Body))
+	require.Equal(t, "https://ntfy.example.com/pay?_ptxn=txn_123", redirect.RedirectURL)
+	require.Equal(t, `{"items":[{"price_id":"pri_123","quantity":1}],"custom_data":{"user_id":"`+u.ID+`","user_name":"phil"}}`, api.Body("POST /transactions"))
+
+	// Change and cancel subscription
+	require.Nil(t, s.userManager.ChangeBilling("phil", &user.Billing{
+		StripeCustomerID:     "ctm_123",
+		StripeSubscriptionID: "sub_123",
+	}))
+	rr = request(t, s, "PUT", "/v1/account/billing/subscription", `{"tier": "pro", "interval": "year"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, `{"items":[{"price_id":"pri_456","quantity":1}],"proration_billing_mode":"prorated_immediately","scheduled_change":null}`, api.Body("PATCH /subscriptions/sub_123"))
+
+	rr = request(t, s, "DELETE", "/v1/account/billing/subscription", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, `{"effective_from":"next_billing_period"}`, api.Body("POST /subscriptions/sub_123/cancel"))
+
+	// Billing portal
+	rr = request(t, s, "POST", "/v1/account/billing/portal", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	portal, _ := util.UnmarshalJSON[apiAccountBillingPortalRedirectResponse](io.NopCloser(rr.Body))
+	require.Equal(t, "https://customer-portal.paddle.com/cpl_123", portal.RedirectURL)
+
+	// Checkout success endpoint is Stripe-only
+	rr = request(t, s, "GET", "/v1/account/billing/subscription/success/SOMETOKEN", "", nil)
+	require.Equal(t, 404, rr.Code)
+}
+
+func TestPayments_Paddle_Webhook_Subscription_Created_And_Canceled(t *testing.T) {
+	s, _ := newTestServerWithPaddle(t)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:                 "pro",
+		StripeMonthlyPriceID: "pri_123",
+		StripeYearlyPriceID:  "pri_456",
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+
+	// Subscription created: user is found via custom data, tier and billing fields are updated
+	payload := fmt.Sprintf(paddleSubscriptionEventJSON, "subscription.created", "active", u.ID, `{"action":"cancel","effective_at":"2024-02-01T00:00:00Z"}`)
+	rr := request(t, s, "POST", "/v1/account/billing/webhook", payload, map[string]string{
+		"Paddle-Signature": testPaddleSignature(payload, time.Now()),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	u, err = s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, "pro", u.Tier.Code)
+	require.Equal(t, "ctm_123", u.Billing.StripeCustomerID)
+	require.Equal(t, "sub_123", u.Billing.StripeSubscriptionID)
+	require.Equal(t, "active", string(u.Billing.StripeSubscriptionStatus))
+	require.Equal(t, "month", string(u.Billing.StripeSubscriptionInterval))
+	require.Equal(t, int64(1706745600), u.Billing.StripeSubscriptionPaidUntil.Unix())
+	require.Equal(t, int64(1706745600), u.Billing.StripeSubscriptionCancelAt.Unix())
+
+	// Subscription canceled: tier is removed
+	payload = fmt.Sprintf(paddleSubscriptionEventJSON, "subscription.canceled", "canceled", u.ID, "null")
+	rr = request(t, s, "POST", "/v1/account/billing/webhook", payload, map[string]string{
+		"Paddle-Signature": testPaddleSignature(payload, time.Now()),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	u, err = s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Nil(t, u.Tier)
+	require.Equal(t, "ctm_123", u.Billing.StripeCustomerID)
+	require.Equal(t, "", u.Billing.StripeSubscriptionID)
+}
+
+func TestPayments_Paddle_Webhook_InvalidSignature(t *testing.T) {
+	s, _ := newTestServerWithPaddle(t)
+	payload := fmt.Sprintf(paddleSubscriptionEventJSON, "subscription.created", "active", "u_123", "null")
+
+	rr := request(t, s, "POST", "/v1/account/billing/webhook", payload, nil)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40028, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "POST", "/v1/account/billing/webhook", payload, map[string]string{
+		"Paddle-Signature": "ts=1700000000;h1=abcdef",
+	})
+	require.Equal(t, 400, rr.Code)
+
+	rr = request(t, s, "POST", "/v1/account/billing/webhook", payload, map[string]string{
+		"Paddle-Signature": testPaddleSignature(payload, time.Now().Add(-time.Hour)), // Too old
+	})
+	require.Equal(t, 400, rr.Code)
+}
+
+func TestPayments_Paddle_Webhook_Unhandled(t *testing.T) {
+	s, _ := newTestServerWithPaddle(t)
+	payload := `{"event_type":"transaction.completed","data":{"id":"txn_123"}}`
+	rr := request(t, s, "POST", "/v1/account/billing/webhook", payload, map[string]string{
+		"Paddle-Signature": testPaddleSignature(payload, time.Now()),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestPayments_Paddle_VerifySignature_Rotation(t *testing.T) {
+	payload := []byte(`{"event_type":"subscription.updated"}`)
+	now := time.Unix(1700000000, 0)
+	header := fmt.Sprintf("ts=1700000000;h1=%s;h1=%s", signPaddlePayload("old secret", "1700000000", payload), signPaddlePayload("new secret", "1700000000", payload))
+	require.Nil(t, verifyPaddleSignature(header, "old secret", payload, now))
+	require.Nil(t, verifyPaddleSignature(header, "new secret", payload, now))
+	require.Equal(t, errPaddleSignatureInvalid, verifyPaddleSignature(header, "other secret", payload, now))
+	require.Equal(t, errPaddleSignatureInvalid, verifyPaddleSignature("h1=abc", "old secret", payload, now))
+}
+
+func newTestServerWithPaddle(t *testing.T) (*Server, *testPaddleAPI) {
+	api := newTestPaddleAPI(t)
+	c := newTestConfigWithAuthFile(t)
+	c.PaddleAPIKey = "paddle key"
+	c.PaddleWebhookKey = "paddle webhook key"
+	s := newTestServer(t, c)
+	paddle := newPaddlePaymentProvider(c)
+	paddle.baseURL = api.server.URL
+	s.payments = paddle
+	return s, api
+}
+
+func testPaddleSignature(payload string, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("ts=%s;h1=%s", timestamp, signPaddlePayload("paddle webhook key", timestamp, []byte(payload)))
+}
+
+const paddleSubscriptionEventJSON = `
+{
+	"event_id": "evt_123",
+	"event_type": "%s",
+	"data": {
+		"id": "sub_123",
+		"status": "%s",
+		"customer_id": "ctm_123",
+		"custom_data": {
+			"user_id": "%s"
+		},
+		"current_billing_period": {
+			"starts_at": "2024-01-01T00:00:00Z",
+			"ends_at": "2024-02-01T00:00:00Z"
+		},
+		"scheduled_change": %s,
+		"items": [
+			{
+				"price": {
+					"id": "pri_123",
+					"billing_cycle": {
+						"interval": "month",
+						"frequency": 1
+					}
+				}
+			}
+		]
+	}
+}`
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stripe/stripe-go/v74"
+	portalsession "github.com/stripe/stripe-go/v74/billingportal/session"
+	"github.com/stripe/stripe-go/v74/checkout/session"
+	"github.com/stripe/stripe-go/v74/customer"
+	"github.com/stripe/stripe-go/v74/price"
+	"github.com/stripe/stripe-go/v74/subscription"
+	"github.com/stripe/stripe-go/v74/webhook"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+)
+
+const (
+	stripeSignatureHeader = "Stripe-Signature"
+)
+
+// stripePaymentProvider implements the paymentProvider interface for Stripe. All calls to the Stripe API
+// go through the stripeAPI interface, so that it can be mocked in tests.
+type stripePaymentProvider struct {
+	config *Config
+	api    stripeAPI
+}
+
+var _ paymentProvider = (*stripePaymentProvider)(nil)
+
+func newStripePaymentProvider(conf *Config, api stripeAPI) *stripePaymentProvider {
+	return &stripePaymentProvider{
+		config: conf,
+		api:    api,
+	}
+}
+
+func (p *stripePaymentProvider) Name() string {
+	return tagStripe
+}
+
+func (p *stripePaymentProvider) ListPrices() (map[string]int64, error) {
+	prices, err := p.api.ListPrices(&stripe.PriceListParams{Active: stripe.Bool(true)})
+	if err != nil {
+		return nil, err
+	}
+	priceMap := make(map[string]int64)
+	for _, p := range prices {
+		priceMap[p.ID] = p.UnitAmount
+	}
+	return priceMap, nil
+}
+
+// NewCheckout creates a Stripe checkout session. If the user is already a Stripe customer (a user may have
+// previously deleted their subscription), the existing customer is re-used.
+func (p *stripePaymentProvider) NewCheckout(u *user.User, priceID string) (string, error) {
+	var stripeCustomerID *string
+	if u.Billing.StripeCustomerID != "" {
+		stripeCustomerID = &u.Billing.StripeCustomerID
+		stripeCustomer, err := p.api.GetCustomer(u.Billing.StripeCustomerID)
+		if err != nil {
+			return "", err
+		} else if stripeCustomer.Subscriptions != nil && len(stripeCustomer.Subscriptions.Data) > 0 {
+			return "", errMultipleBillingSubscriptions
+		}
+	}
+	successURL := p.config.BaseURL + apiAccountBillingSubscriptionCheckoutSuccessTemplate
+	params := &stripe.CheckoutSessionParams{
+		Customer:            stripeCustomerID,
+		ClientReferenceID:   &u.ID,
+		SuccessURL:          &successURL,
+		Mode:                stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		AllowPromotionCodes: stripe.Bool(true),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(priceID),
+				Quantity: stripe.Int64(1),
+			},
+		},
+		AutomaticTax: &stripe.CheckoutSessionAutomaticTaxParams{
+			Enabled: stripe.Bool(true),
+		},
+	}
+	sess, err := p.api.NewCheckoutSession(params)
+	if err != nil {
+		return "", err
+	}
+	return sess.URL, nil
+}
+
+// CheckoutSuccess retrieves the Stripe checkout session and the corresponding subscription. The ntfy user ID
+// is passed to Stripe as the client reference ID when the checkout session is created.
+func (p *stripePaymentProvider) CheckoutSuccess(sessionID string) (*paymentSubscription, error) {
+	sess, err := p.api.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	} else if sess.Customer == nil || sess.Subscription == nil || sess.ClientReferenceID == "" {
+		return nil, errHTTPBadRequestBillingRequestInvalid.Wrap("customer or subscription not found")
+	}
+	sub, err := p.api.GetSubscription(sess.Subscription.ID)
+	if err != nil {
+		return nil, err
+	} else if sub.Items == nil || len(sub.Items.Data) != 1 || sub.Items.Data[0].Price == nil || sub.Items.Data[0].Price.Recurring == nil {
+		return nil, errHTTPBadRequestBillingRequestInvalid.Wrap("more than one line item in existing subscription")
+	}
+	return &paymentSubscription{
+		ID:         sub.ID,
+		CustomerID: sess.Customer.ID,
+		UserID:     sess.ClientReferenceID,
+		PriceID:    sub.Items.Data[0].Price.ID,
+		Status:     string(sub.Status),
+		Interval:   string(sub.Items.Data[0].Price.Recurring.Interval),
+		PaidUntil:  sub.CurrentPeriodEnd,
+		CancelAt:   sub.CancelAt,
+	}, nil
+}
+
+func (p *stripePaymentProvider) UpdateCustomer(customerID string, u *user.User) error {
+	params := &stripe.CustomerParams{
+		Params: stripe.Params{
+			Metadata: map[string]string{
+				"user_id":   u.ID,
+				"user_name": u.Name,
+			},
+		},
+	}
+	_, err := p.api.UpdateCustomer(customerID, params)
+	return err
+}
+
+// ChangeSubscription switches the (only) item of the subscription to a new price, and invoices the
+// prorated amount immediately. A pending cancellation is reverted.
+func (p *stripePaymentProvider) ChangeSubscription(subscriptionID, priceID string) error {
+	sub, err := p.api.GetSubscription(subscriptionID)
+	if err != nil {
+		return err
+	} else if sub.Items == nil || len(sub.Items.Data) != 1 {
+		return errHTTPBadRequestBillingRequestInvalid.Wrap("no items, or more than one item")
+	}
+	params := &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(false),
+		ProrationBehavior: stripe.String(string(stripe.SubscriptionSchedulePhaseProrationBehaviorAlwaysInvoice)),
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				ID:    stripe.String(sub.Items.Data[0].ID),
+				Price: stripe.String(priceID),
+			},
+		},
+	}
+	_, err = p.api.UpdateSubscription(sub.ID, params)
+	return err
+}
+
+func (p *stripePaymentProvider) CancelSubscriptionAtPeriodEnd(subscriptionID string) error {
+	params := &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(true),
+	}
+	_, err := p.api.UpdateSubscription(subscriptionID, params)
+	return err
+}
+
+func (p *stripePaymentProvider) CancelSubscription(subscriptionID string) error {
+	_, err := p.api.CancelSubscription(subscriptionID)
+	return err
+}
+
+func (p *stripePaymentProvider) NewPortalSession(customerID string) (string, error) {
+	params := &stripe.BillingPortalSessionParams{
+		Customer:  stripe.String(customerID),
+		ReturnURL: stripe.String(p.config.BaseURL),
+	}
+	ps, err := p.api.NewPortalSession(params)
+	if err != nil {
+		return "", err
+	}
+	return ps.URL, nil
+}
+
+// ConstructWebhookEvent validates the Stripe signature of an incoming webhook, and translates the
+// customer.subscription.updated and customer.subscription.deleted events into a paymentWebhookEvent.
+func (p *stripePaymentProvider) ConstructWebhookEvent(header http.Header, payload []byte) (*paymentWebhookEvent, error) {
+	stripeSignature := header.Get(stripeSignatureHeader)
+	if stripeSignature == "" {
+		return nil, errHTTPBadRequestBillingRequestInvalid
+	}
+	event, err := p.api.ConstructWebhookEvent(payload, stripeSignature, p.config.StripeWebhookKey)
+	if err != nil {
+		return nil, err
+	} else if event.Data == nil || event.Data.Raw == nil {
+		return nil, errHTTPBadRequestBillingRequestInvalid
+	}
+	switch event.Type {
+	case "customer.subscription.updated":
+		ev, err := util.UnmarshalJSON[apiStripeSubscriptionUpdatedEvent](io.NopCloser(bytes.NewReader(event.Data.Raw)))
+		if err != nil {
+			return nil, err
+		} else if ev.ID == "" || ev.Customer == "" || ev.Status == "" || ev.CurrentPeriodEnd == 0 || ev.Items == nil || len(ev.Items.Data) != 1 || ev.Items.Data[0].Price == nil || ev.Items.Data[0].Price.ID == "" || ev.Items.Data[0].Price.Recurring == nil {
+			log.Tag(tagStripe).Field("stripe_request", fmt.Sprintf("%#v", ev)).Warn("Unexpected request from Stripe")
+			return nil, errHTTPBadRequestBillingRequestInvalid
+		}
+		return &paymentWebhookEvent{
+			Type: paymentWebhookEventSubscriptionUpdated,
+			Name: string(event.Type),
+			Subscription: &paymentSubscription{
+				ID:         ev.ID,
+				CustomerID: ev.Customer,
+				PriceID:    ev.Items.Data[0].Price.ID,
+				Status:     ev.Status,
+				Interval:   ev.Items.Data[0].Price.Recurring.Interval,
+				PaidUntil:  ev.CurrentPeriodEnd,
+				CancelAt:   ev.CancelAt,
+			},
+		}, nil
+	case "customer.subscription.deleted":
+		ev, err := util.UnmarshalJSON[apiStripeSubscriptionDeletedEvent](io.NopCloser(bytes.NewReader(event.Data.Raw)))
+		if err != nil {
+			return nil, err
+		} else if ev.Customer == "" {
+			return nil, errHTTPBadRequestBillingRequestInvalid
+		}
+		return &paymentWebhookEvent{
+			Type: paymentWebhookEventSubscriptionDeleted,
+			Name: string(event.Type),
+			Subscription: &paymentSubscription{
+				ID:         ev.ID,
+				CustomerID: ev.Customer,
+			},
+		}, nil
+	default:
+		return &paymentWebhookEvent{
+			Type: paymentWebhookEventUnhandled,
+			Name: string(event.Type),
+		}, nil
+	}
+}
+
+// stripeAPI is a small interface to facilitate mocking of the Stripe API
+type stripeAPI interface {
+	NewCheckoutSession(params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error)
+	NewPortalSession(params *stripe.BillingPortalSessionParams) (*stripe.BillingPortalSession, error)
+	ListPrices(params *stripe.PriceListParams) ([]*stripe.Price, error)
+	GetCustomer(id string) (*stripe.Customer, error)
+	GetSession(id string) (*stripe.CheckoutSession, error)
+	GetSubscription(id string) (*stripe.Subscription, error)
+	UpdateCustomer(id string, params *stripe.CustomerParams) (*stripe.Customer, error)
+	UpdateSubscription(id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error)
+	CancelSubscription(id string) (*stripe.Subscription, error)
+	ConstructWebhookEvent(payload []byte, header string, secret string) (stripe.Event, error)
+}
+
+// realStripeAPI is a thin shim around the Stripe functions to facilitate mocking
+type realStripeAPI struct{}
+
+var _ stripeAPI = (*realStripeAPI)(nil)
+
+func newStripeAPI() stripeAPI {
+	return &realStripeAPI{}
+}
+
+func (s *realStripeAPI) NewCheckoutSession(params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	return session.New(params)
+}
+
+func (s *realStripeAPI) NewPortalSession(params *stripe.BillingPortalSessionParams) (*stripe.BillingPortalSession, error) {
+	return portalsession.New(params)
+}
+
+func (s *realStripeAPI) ListPrices(params *stripe.PriceListParams) ([]*stripe.Price, error) {
+	prices := make([]*stripe.Price, 0)
+	iter := price.List(params)
+	for iter.Next() {
+		prices = append(prices, iter.Price())
+	}
+	if iter.Err() != nil {
+		return nil, iter.Err()
+	}
+	return prices, nil
+}
+
+func (s *realStripeAPI) GetCustomer(id string) (*stripe.Customer, error) {
+	return customer.Get(id, nil)
+}
+
+func (s *realStripeAPI) GetSession(id string) (*stripe.CheckoutSession, error) {
+	return session.Get(id, nil)
+}
+
+func (s *realStripeAPI) GetSubscription(id string) (*stripe.Subscription, error) {
+	return subscription.Get(id, nil)
+}
+
+func (s *realStripeAPI) UpdateCustomer(id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	return customer.Update(id, params)
+}
+
+func (s *realStripeAPI) UpdateSubscription(id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	return subscription.Update(id, params)
+}
+
+func (s *realStripeAPI) CancelSubscription(id string) (*stripe.Subscription, error) {
+	return subscription.Cancel(id, nil)
+}
+
+func (s *realStripeAPI) ConstructWebhookEvent(payload []byte, header string, secret string) (stripe.Event, error) {
+	return webhook.ConstructEvent(payload, header, secret)
+}
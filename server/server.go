@@ -23,6 +23,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
@@ -46,19 +47,41 @@ type Server struct {
 	smtpServer        *smtp.Server
 	smtpServerBackend *smtpBackend
 	smtpSender        mailer
+	emailBatcher      *emailBatcher  // May be nil, if smtp-sender-batch-window is not set
+	webhookSender     *webhookSender // Might be nil!
+	accessLog         *accessLog     // Might be nil!
 	topics            map[string]*topic
 	visitors          map[string]*visitor // ip:<ip> or user:<user>
 	firebaseClient    *firebaseClient
+	firebaseQueue     *util.PriorityQueue[*firebaseQueueItem]
+	firebaseSending   atomic.Int64 // Number of messages currently being sent by the Firebase workers
+	firebaseStarted   sync.Once
+	matrixStats       *matrixStats
+	statsHistory      *statsHistory
+	banTracker        *banTracker
+	authFailureLogMu  sync.Mutex
+	openAPI           map[string]any
+	openAPIOnce       sync.Once
+	blockedTopics     map[string]bool                     // Topics blocked by an admin, see blockTopic
+	bannedIPs         map[netip.Addr]int64                // IP address -> ban expiry (Unix time, 0 = never), see banIP
+	exemptHosts       []netip.Prefix                      // Hosts exempt from rate limiting, added by an admin at runtime, see loadRateLimitExemptions
+	exemptUsers       map[string]bool                     // User IDs exempt from rate limiting, added by an admin at runtime
 	messages          int64                               // Total number of messages (persisted if messageCache enabled)
 	messagesHistory   []int64                             // Last n values of the messages counter, used to determine rate
 	userManager       *user.Manager                       // Might be nil!
+	replicationPos    int64                               // Position in the primary's user change log, if auth-replicate-from is set
 	messageCache      *messageCache                       // Database that stores the messages
+	cacheVacuumed     time.Time                           // Last time the message cache was vacuumed
 	webPush           *webPushStore                       // Database that stores web push subscriptions
+	webPushClient     *http.Client                        // Shared HTTP client, so connections to push services are reused
+	webPushWorkers    chan struct{}                       // Limits the number of concurrent web push requests
 	fileCache         *fileCache                          // File system based cache that stores attachments
-	stripe            stripeAPI                           // Stripe API, can be replaced with a mock
+	payments          paymentProvider                     // Payment provider (Stripe or Paddle), can be replaced with a mock
 	priceCache        *util.LookupCache[map[string]int64] // Stripe price ID -> price as cents (USD implied!)
 	metricsHandler    http.Handler                        // Handles /metrics if enable-metrics set, and listen-metrics-http not set
 	closeChan         chan bool
+	shutdownChan      chan struct{} // Closed when the server is shutting down, see Shutdown
+	shutdownOnce      sync.Once
 	mu                sync.RWMutex
 }
 
@@ -74,7 +97,16 @@ var (
 	ssePathRegex           = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/sse$`)
 	rawPathRegex           = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/raw$`)
 	wsPathRegex            = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/ws$`)
+	feedPathRegex          = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/feed\.atom$`)
 	authPathRegex          = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/auth$`)
+	presencePathRegex      = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/presence$`)
+	showcasePathRegex      = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/showcase(\.json)?$`)
+	exportPathRegex        = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/export$`)
+	maintenancePathRegex   = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/maintenance$`)
+	maintenanceIDPathRegex = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/maintenance/([-_A-Za-z0-9]{1,64})$`)
+	heartbeatPathRegex     = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/heartbeat$`)
+	heartbeatPingPathRegex = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/heartbeat/ping$`)
+	signPathRegex          = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/sign$`)
 	publishPathRegex       = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/(publish|send|trigger)$`)
 
 	webConfigPath                                        = "/config.js"
@@ -85,30 +117,82 @@ var (
 	matrixPushPath                                       = "/_matrix/push/v1/notify"
 	metricsPath                                          = "/metrics"
 	apiHealthPath                                        = "/v1/health"
+	apiHealthLivePath                                    = "/v1/health/live"
+	apiHealthReadyPath                                   = "/v1/health/ready"
 	apiStatsPath                                         = "/v1/stats"
+	apiStatsHistoryPath                                  = "/v1/stats/history"
+	apiTagsPath                                          = "/v1/tags"
+	apiErrorsPath                                        = "/v1/errors"
+	apiMatrixPushKeyPath                                 = "/v1/matrix/pushkey"
+	apiMatrixStatsPath                                   = "/v1/matrix/stats"
+	apiReportPath                                        = "/v1/report"
+	apiReportsPath                                       = "/v1/reports"
+	apiBlocksPath                                        = "/v1/blocks"
+	apiExemptionsPath                                    = "/v1/exemptions"
+	apiMessagesPrunePath                                 = "/v1/messages/prune"
+	apiEmailWebhookPath                                  = "/v1/email/webhook"
+	apiEmailUndeliverablePath                            = "/v1/email/undeliverable"
+	apiVisitorsPath                                      = "/v1/visitors"
+	apiVisitorsResetPath                                 = "/v1/visitors/reset"
+	apiVisitorsExemptPath                                = "/v1/visitors/exempt"
 	apiWebPushPath                                       = "/v1/webpush"
+	apiWebPushRenewPath                                  = "/v1/webpush/renew"
 	apiTiersPath                                         = "/v1/tiers"
+	apiOpenAPIPath                                       = "/v1/openapi.json"
+	apiPublishValidatePath                               = "/v1/publish/validate"
+	apiAttachmentsPath                                   = "/v1/attachments"
+	apiGraphQLPath                                       = "/v1/graphql"
 	apiUsersPath                                         = "/v1/users"
 	apiUsersAccessPath                                   = "/v1/users/access"
+	apiUsersChangesPath                                  = "/v1/users/changes"
+	apiUsersImpersonatePath                              = "/v1/users/impersonate"
+	apiGroupsPath                                        = "/v1/groups"
+	apiGroupsMembersPath                                 = "/v1/groups/members"
+	apiGroupsAccessPath                                  = "/v1/groups/access"
 	apiAccountPath                                       = "/v1/account"
 	apiAccountTokenPath                                  = "/v1/account/token"
+	apiAccountDeletionPath                               = "/v1/account/deletion"
 	apiAccountPasswordPath                               = "/v1/account/password"
 	apiAccountSettingsPath                               = "/v1/account/settings"
 	apiAccountSubscriptionPath                           = "/v1/account/subscription"
+	apiAccountTemplatePath                               = "/v1/account/template"
 	apiAccountReservationPath                            = "/v1/account/reservation"
+	apiAccountScheduledCalendarPath                      = "/v1/account/scheduled.ics"
 	apiAccountPhonePath                                  = "/v1/account/phone"
 	apiAccountPhoneVerifyPath                            = "/v1/account/phone/verify"
+	apiAccountWebPushPath                                = "/v1/account/webpush"
 	apiAccountBillingPortalPath                          = "/v1/account/billing/portal"
 	apiAccountBillingWebhookPath                         = "/v1/account/billing/webhook"
 	apiAccountBillingSubscriptionPath                    = "/v1/account/billing/subscription"
 	apiAccountBillingSubscriptionCheckoutSuccessTemplate = "/v1/account/billing/subscription/success/{CHECKOUT_SESSION_ID}"
 	apiAccountBillingSubscriptionCheckoutSuccessRegex    = regexp.MustCompile(`/v1/account/billing/subscription/success/(.+)$`)
-	apiAccountReservationSingleRegex                     = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})$`)
+	apiAccountReservationSingleRegex                     = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64}\*?)$`)
+	apiAccountTemplateSingleRegex                        = regexp.MustCompile(`^/v1/account/template/([-_A-Za-z0-9]{1,64})$`)
+	apiAccountReservationPublishKeyRegex                 = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/key$`)
+	apiAccountReservationScheduleRegex                   = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64}\*?)/schedule$`)
+	apiAccountWebPushSingleRegex                         = regexp.MustCompile(`/v1/account/webpush/(wps_[A-Za-z0-9]+)$`)
+	apiReportSingleRegex                                 = regexp.MustCompile(`^/v1/reports/(rp_[A-Za-z0-9]+)$`)
+	apiTopicStatsRegex                                   = regexp.MustCompile(`^/v1/topics/([-_A-Za-z0-9]{1,64})/stats$`)
+	messageDeliveriesPathRegex                           = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/([-_A-Za-z0-9]{1,64})/deliveries$`)
+	messageThreadPathRegex                               = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/([-_A-Za-z0-9]{1,64})/thread$`)
+	messageReactionsPathRegex                            = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/([-_A-Za-z0-9]{1,64})/reactions$`)
+	messageReactionPathRegex                             = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/([-_A-Za-z0-9]{1,64})/reactions/([^/]+)$`)
+	messageAnnotationsPathRegex                          = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/([-_A-Za-z0-9]{1,64})/annotations$`)
+	messageAnnotationPathRegex                           = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/([-_A-Za-z0-9]{1,64})/annotations/([-_A-Za-z0-9]{1,64})$`)
+	scimPathPrefix                                       = "/scim/v2/"
+	scimServiceProviderConfigPath                        = "/scim/v2/ServiceProviderConfig"
+	scimUsersPath                                        = "/scim/v2/Users"
+	scimUserRegex                                        = regexp.MustCompile(`^/scim/v2/Users/([^/]+)$`)
 	staticRegex                                          = regexp.MustCompile(`^/static/.+`)
 	docsRegex                                            = regexp.MustCompile(`^/docs(|/.*)$`)
+	tagIconPathRegex                                     = regexp.MustCompile(`^/v1/tags/([-_.a-z0-9]{1,64})/icon$`)
 	fileRegex                                            = regexp.MustCompile(`^/file/([-_A-Za-z0-9]{1,64})(?:\.[A-Za-z0-9]{1,16})?$`)
 	urlRegex                                             = regexp.MustCompile(`^https?://`)
 	phoneNumberRegex                                     = regexp.MustCompile(`^\+\d{1,100}$`)
+	soundRegex                                           = regexp.MustCompile(`^[-_.A-Za-z0-9]{1,64}$`)
+	hostnameRegex                                        = regexp.MustCompile(`^[A-Za-z0-9][-_.A-Za-z0-9]{0,252}$`)
+	correlationIDRegex                                   = regexp.MustCompile(`^[-_.:/#@A-Za-z0-9]{1,128}$`)
+	presenceLabelRegex                                   = regexp.MustCompile(`^[-_.:@ A-Za-z0-9]{1,64}$`)
 
 	//go:embed site
 	webFs       embed.FS
@@ -128,12 +212,17 @@ const (
 	newMessageBody           = "New message"             // Used in poll requests as generic message
 	defaultAttachmentMessage = "You received a file: %s" // Used if message body is empty, and there is an attachment
 	encodingBase64           = "base64"                  // Used mainly for binary UnifiedPush messages
-	jsonBodyBytesLimit       = 16384                     // Max number of bytes for a JSON request body
+	publishKeyBodyPeekBytes  = 64                        // Bytes to peek to tell JSON and plain bodies apart, see transformPublishKeyBody
 	unifiedPushTopicPrefix   = "up"                      // Temporarily, we rate limit all "up*" topics based on the subscriber
 	unifiedPushTopicLength   = 14                        // Length of UnifiedPush topics, including the "up" part
 	messagesHistoryMax       = 10                        // Number of message count values to keep in memory
 )
 
+const (
+	shutdownDrainCheckInterval = 50 * time.Millisecond // Interval in which the Firebase queue is checked when draining, see Shutdown
+	sseRetryMin                = time.Second           // Lower bound for the SSE "retry:" interval requested by clients
+)
+
 // WebSocket constants
 const (
 	wsWriteWait  = 2 * time.Second
@@ -145,21 +234,33 @@ const (
 // New instantiates a new Server. It creates the cache and adds a Firebase
 // subscriber (if configured).
 func New(conf *Config) (*Server, error) {
-	var mailer mailer
-	if conf.SMTPSenderAddr != "" {
-		mailer = &smtpSender{config: conf}
+	if conf.ListenReusePort && !listenReusePortSupported {
+		return nil, errors.New("listen-reuse-port is not supported on this platform")
+	}
+	mailer, err := newMailer(conf)
+	if err != nil {
+		return nil, err
 	}
-	var stripe stripeAPI
+	var payments paymentProvider
 	if conf.StripeSecretKey != "" {
-		stripe = newStripeAPI()
+		payments = newStripePaymentProvider(conf, newStripeAPI())
+	} else if conf.PaddleAPIKey != "" {
+		payments = newPaddlePaymentProvider(conf)
 	}
 	messageCache, err := createMessageCache(conf)
 	if err != nil {
 		return nil, err
 	}
+	var accessLog *accessLog
+	if conf.AccessLog != "" {
+		accessLog, err = newAccessLog(conf)
+		if err != nil {
+			return nil, err
+		}
+	}
 	var webPush *webPushStore
 	if conf.WebPushPublicKey != "" {
-		webPush, err = newWebPushStore(conf.WebPushFile, conf.WebPushStartupQueries)
+		webPush, err = newWebPushStore(conf.WebPushFile, conf.WebPushStartupQueries, conf.WebPushUserSubscriptionLimit)
 		if err != nil {
 			return nil, err
 		}
@@ -185,6 +286,29 @@ func New(conf *Config) (*Server, error) {
 		if err != nil {
 			return nil, err
 		}
+		provisioning := &user.Provisioning{
+			Tiers:  conf.AuthTiers,
+			Users:  conf.AuthUsers,
+			Access: conf.AuthAccess,
+			Tokens: conf.AuthTokens,
+		}
+		if err := userManager.Provision(provisioning); err != nil {
+			return nil, fmt.Errorf("cannot provision users from config: %w", err)
+		}
+	}
+	var webhookSender *webhookSender
+	if len(conf.AccountWebhookURLs) > 0 {
+		for _, url := range conf.AccountWebhookURLs {
+			if !urlRegex.MatchString(url) {
+				return nil, fmt.Errorf("invalid account webhook URL %s, must start with http:// or https://", url)
+			}
+		}
+		for _, event := range conf.AccountWebhookEvents {
+			if !util.Contains(webhookEvents, event) {
+				return nil, fmt.Errorf("invalid account webhook event %s, must be one of: %s", event, strings.Join(webhookEvents, ", "))
+			}
+		}
+		webhookSender = newWebhookSender(conf)
 	}
 	var firebaseClient *firebaseClient
 	if conf.FirebaseKeyFile != "" {
@@ -199,6 +323,8 @@ func New(conf *Config) (*Server, error) {
 			auther = userManager
 		}
 		firebaseClient = newFirebaseClient(sender, auther)
+		firebaseClient.criticalAlertTopics = conf.FirebaseCriticalAlertTopics
+		firebaseClient.androidChannels = conf.FirebaseAndroidChannels
 	}
 	s := &Server{
 		config:          conf,
@@ -207,24 +333,53 @@ func New(conf *Config) (*Server, error) {
 		fileCache:       fileCache,
 		firebaseClient:  firebaseClient,
 		smtpSender:      mailer,
+		webhookSender:   webhookSender,
+		accessLog:       accessLog,
 		topics:          topics,
 		userManager:     userManager,
 		messages:        messages,
 		messagesHistory: []int64{messages},
 		visitors:        make(map[string]*visitor),
-		stripe:          stripe,
+		payments:        payments,
+		cacheVacuumed:   time.Now(),
+		firebaseQueue:   util.NewPriorityQueue[*firebaseQueueItem](conf.FirebaseQueueSize),
+		webPushClient:   newWebPushHTTPClient(conf.WebPushWorkers),
+		webPushWorkers:  make(chan struct{}, util.Max(conf.WebPushWorkers, 1)),
+		matrixStats:     newMatrixStats(),
+		statsHistory:    newStatsHistory(),
+		banTracker:      newBanTracker(conf.IPBanWindow),
+		closeChan:       make(chan bool),
+		shutdownChan:    make(chan struct{}),
+	}
+	s.priceCache = util.NewLookupCache(s.fetchPrices, conf.StripePriceCacheDuration)
+	if mailer != nil && conf.SMTPSenderBatchWindow > 0 {
+		s.emailBatcher = newEmailBatcher(conf.SMTPSenderBatchWindow, conf.SMTPSenderBatchMax, s.sendEmailBatch)
+	}
+	if err := s.loadBlocks(); err != nil {
+		return nil, err
+	} else if err := s.loadRateLimitExemptions(); err != nil {
+		return nil, err
 	}
-	s.priceCache = util.NewLookupCache(s.fetchStripePrices, conf.StripePriceCacheDuration)
 	return s, nil
 }
 
 func createMessageCache(conf *Config) (*messageCache, error) {
+	var cache *messageCache
+	var err error
 	if conf.CacheDuration == 0 {
-		return newNopCache()
+		cache, err = newNopCache()
 	} else if conf.CacheFile != "" {
-		return newSqliteCache(conf.CacheFile, conf.CacheStartupQueries, conf.CacheDuration, conf.CacheBatchSize, conf.CacheBatchTimeout, false)
+		cache, err = newSqliteCache(conf.CacheFile, conf.CacheStartupQueries, conf.CacheDuration, conf.CacheBatchSize, conf.CacheBatchTimeout, false)
+	} else if conf.CacheSnapshotFile != "" {
+		cache, err = newSnapshotCache(conf.CacheSnapshotFile, conf.CacheDuration, conf.CacheSnapshotInterval)
+	} else {
+		cache, err = newMemCache()
+	}
+	if err != nil {
+		return nil, err
 	}
-	return newMemCache()
+	cache.compressThreshold = conf.CacheCompressThreshold
+	return cache, nil
 }
 
 // Run executes the main server. It listens on HTTP (+ HTTPS, if configured), and starts
@@ -262,13 +417,23 @@ func (s *Server) Run() error {
 	if s.config.ListenHTTP != "" {
 		s.httpServer = &http.Server{Addr: s.config.ListenHTTP, Handler: mux}
 		go func() {
-			errChan <- s.httpServer.ListenAndServe()
+			ln, err := s.listenTCP(s.config.ListenHTTP)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			errChan <- s.httpServer.Serve(ln)
 		}()
 	}
 	if s.config.ListenHTTPS != "" {
 		s.httpsServer = &http.Server{Addr: s.config.ListenHTTPS, Handler: mux}
 		go func() {
-			errChan <- s.httpsServer.ListenAndServeTLS(s.config.CertFile, s.config.KeyFile)
+			ln, err := s.listenTCP(s.config.ListenHTTPS)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			errChan <- s.httpsServer.ServeTLS(ln, s.config.CertFile, s.config.KeyFile)
 		}()
 	}
 	if s.config.ListenUnix != "" {
@@ -282,6 +447,10 @@ func (s *Server) Run() error {
 				errChan <- err
 				return
 			}
+			if s.config.ListenReusePort {
+				// Do not remove the socket file on close, it may already belong to the process taking over
+				s.unixListener.(*net.UnixListener).SetUnlinkOnClose(false)
+			}
 			defer s.unixListener.Close()
 			if s.config.ListenUnixMode > 0 {
 				if err := os.Chmod(s.config.ListenUnix, s.config.ListenUnixMode); err != nil {
@@ -327,6 +496,9 @@ func (s *Server) Run() error {
 	go s.runStatsResetter()
 	go s.runDelayedSender()
 	go s.runFirebaseKeepaliver()
+	if s.userManager != nil && s.config.AuthReplicateFrom != "" {
+		go s.runUserReplicator()
+	}
 
 	return <-errChan
 }
@@ -347,10 +519,98 @@ func (s *Server) Stop() {
 	if s.smtpServer != nil {
 		s.smtpServer.Close()
 	}
+	if s.webhookSender != nil {
+		s.webhookSender.Close()
+	}
+	if s.accessLog != nil {
+		s.accessLog.Close()
+	}
+	s.firebaseQueue.Close()
 	s.closeDatabases()
 	close(s.closeChan)
 }
 
+// Shutdown gracefully stops the server: Subscribers are told to reconnect (see reconnectEvent), the listeners
+// stop accepting new connections, and pending web push and Firebase messages as well as queued message cache
+// writes are flushed, before the server is finally stopped. Draining is limited to Config.ShutdownTimeout.
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+		defer cancel()
+		log.Tag(tagShutdown).Info("Shutting down server, draining connections and queues (timeout %s)", s.config.ShutdownTimeout)
+		close(s.shutdownChan)
+		s.shutdownListeners(ctx)
+		if err := s.drainWebPush(ctx); err != nil {
+			log.Tag(tagShutdown).Err(err).Warn("Not all web push messages were sent before shutting down")
+		}
+		if err := s.drainFirebaseQueue(ctx); err != nil {
+			log.Tag(tagShutdown).Err(err).Warn("Dropping %d queued Firebase message(s)", s.firebaseQueue.Len())
+		}
+		if err := s.messageCache.Flush(); err != nil {
+			log.Tag(tagShutdown).Err(err).Warn("Cannot write queued messages to message cache")
+		}
+		s.flushEmailBatches()
+		s.Stop()
+	})
+}
+
+// shutdownListeners stops accepting new connections and waits for active HTTP(S) requests to finish. Subscribers
+// return as soon as the shutdown channel is closed, so this typically returns quickly.
+func (s *Server) shutdownListeners(ctx context.Context) {
+	s.mu.Lock()
+	httpServer, httpsServer, unixListener := s.httpServer, s.httpsServer, s.unixListener
+	s.mu.Unlock()
+	if unixListener != nil {
+		unixListener.Close()
+	}
+	var wg sync.WaitGroup
+	for _, srv := range []*http.Server{httpServer, httpsServer} {
+		if srv == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Tag(tagShutdown).Err(err).Warn("Not all connections were closed gracefully")
+			}
+		}(srv)
+	}
+	wg.Wait()
+}
+
+// drainWebPush waits for in-flight web push requests to finish by acquiring all web push worker slots
+func (s *Server) drainWebPush(ctx context.Context) error {
+	if s.webPush == nil {
+		return nil
+	}
+	for i := 0; i < cap(s.webPushWorkers); i++ {
+		select {
+		case s.webPushWorkers <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// drainFirebaseQueue waits for the Firebase workers to send all queued messages
+func (s *Server) drainFirebaseQueue(ctx context.Context) error {
+	if s.firebaseClient == nil {
+		return nil
+	}
+	ticker := time.NewTicker(shutdownDrainCheckInterval)
+	defer ticker.Stop()
+	for s.firebaseQueue.Len() > 0 || s.firebaseSending.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
 func (s *Server) closeDatabases() {
 	if s.userManager != nil {
 		s.userManager.Close()
@@ -363,10 +623,34 @@ func (s *Server) closeDatabases() {
 
 // handle is the main entry point for all HTTP requests
 func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	var v *visitor
+	if metricHTTPRequestDurationSeconds != nil || s.accessLog != nil {
+		started, route, uri := time.Now(), requestRoute(r), accessLogURI(r)
+		tw, body := &trackingResponseWriter{ResponseWriter: w}, &trackingRequestBody{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		defer func() {
+			if metricHTTPRequestDurationSeconds != nil {
+				observeHTTPRequest(route, started, tw, body)
+			}
+			if s.accessLog != nil {
+				s.accessLog.Log(r, v, route, uri, started, tw, body)
+			}
+		}()
+		w = tw
+	}
+	s.setCORSHeaders(w, r)
 	v, err := s.maybeAuthenticate(r) // Note: Always returns v, even when error is returned
 	if err != nil {
 		s.handleError(w, r, v, err)
 		return
+	} else if err := s.checkCSRF(r); err != nil {
+		s.handleError(w, r, v, err)
+		return
+	} else if s.ipBanned(v.IP()) && !v.User().IsAdmin() {
+		s.handleError(w, r, v, errHTTPForbiddenIPBanned)
+		return
 	}
 	ev := logvr(v, r)
 	if ev.IsTrace() {
@@ -397,6 +681,9 @@ func (s *Server) handleError(w http.ResponseWriter, r *http.Request, v *visitor,
 	}
 	isRateLimiting := util.Contains(rateLimitingErrorCodes, httpErr.HTTPCode)
 	isNormalError := strings.Contains(err.Error(), "i/o timeout") || util.Contains(normalErrorCodes, httpErr.HTTPCode)
+	if httpErr.HTTPCode == http.StatusTooManyRequests {
+		s.rateLimitViolated(r, v)
+	}
 	ev := logvr(v, r).Err(err)
 	if websocket.IsWebSocketUpgrade(r) {
 		ev.Tag(tagWebsocket).Fields(websocketErrorContext(err))
@@ -412,14 +699,17 @@ func (s *Server) handleError(w http.ResponseWriter, r *http.Request, v *visitor,
 	} else {
 		ev.Info("Connection closed with HTTP %d (ntfy error %d)", httpErr.HTTPCode, httpErr.Code)
 	}
-	if isRateLimiting && s.config.StripeSecretKey != "" {
+	if isRateLimiting && s.payments != nil {
 		u := v.User()
 		if u == nil || u.Tier == nil {
 			httpErr = httpErr.Wrap("increase your limits with a paid plan, see %s", s.config.BaseURL)
 		}
 	}
+	if strings.HasPrefix(r.URL.Path, scimPathPrefix) {
+		s.writeSCIMError(w, httpErr)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
 	w.WriteHeader(httpErr.HTTPCode)
 	io.WriteString(w, httpErr.JSON()+"\n")
 }
@@ -429,8 +719,14 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureWebEnabled(s.handleRoot)(w, r, v)
 	} else if r.Method == http.MethodHead && r.URL.Path == "/" {
 		return s.ensureWebEnabled(s.handleEmpty)(w, r, v)
-	} else if r.Method == http.MethodGet && r.URL.Path == apiHealthPath {
+	} else if r.Method == http.MethodGet && (r.URL.Path == apiHealthPath || r.URL.Path == apiHealthLivePath) {
 		return s.handleHealth(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiHealthReadyPath {
+		return s.handleHealthReady(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiOpenAPIPath {
+		return s.handleOpenAPI(w, r, v)
+	} else if (r.Method == http.MethodGet || r.Method == http.MethodPost) && r.URL.Path == apiGraphQLPath {
+		return s.limitRequests(s.handleGraphQL)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == webConfigPath {
 		return s.ensureWebEnabled(s.handleWebConfig)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == webManifestPath {
@@ -445,12 +741,46 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureAdmin(s.handleAccessAllow)(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersAccessPath {
 		return s.ensureAdmin(s.handleAccessReset)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiUsersChangesPath {
+		return s.ensureAdmin(s.handleUsersChangesGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiUsersImpersonatePath {
+		return s.ensureAdmin(s.handleUsersImpersonate)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiGroupsPath {
+		return s.ensureAdmin(s.handleGroupsGet)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiGroupsPath {
+		return s.ensureAdmin(s.handleGroupsAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiGroupsPath {
+		return s.ensureAdmin(s.handleGroupsDelete)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiGroupsMembersPath {
+		return s.ensureAdmin(s.handleGroupMembersAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiGroupsMembersPath {
+		return s.ensureAdmin(s.handleGroupMembersRemove)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiGroupsAccessPath {
+		return s.ensureAdmin(s.handleGroupAccessAllow)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiGroupsAccessPath {
+		return s.ensureAdmin(s.handleGroupAccessReset)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == scimServiceProviderConfigPath {
+		return s.ensureSCIM(s.handleSCIMServiceProviderConfig)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == scimUsersPath {
+		return s.ensureSCIM(s.handleSCIMUsersGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == scimUsersPath {
+		return s.ensureSCIM(s.handleSCIMUserCreate)(w, r, v)
+	} else if r.Method == http.MethodGet && scimUserRegex.MatchString(r.URL.Path) {
+		return s.ensureSCIM(s.handleSCIMUserGet)(w, r, v)
+	} else if r.Method == http.MethodPut && scimUserRegex.MatchString(r.URL.Path) {
+		return s.ensureSCIM(s.handleSCIMUserReplace)(w, r, v)
+	} else if r.Method == http.MethodPatch && scimUserRegex.MatchString(r.URL.Path) {
+		return s.ensureSCIM(s.handleSCIMUserPatch)(w, r, v)
+	} else if r.Method == http.MethodDelete && scimUserRegex.MatchString(r.URL.Path) {
+		return s.ensureSCIM(s.handleSCIMUserDelete)(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountPath {
 		return s.ensureUserManager(s.handleAccountCreate)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountPath {
 		return s.handleAccountGet(w, r, v) // Allowed by anonymous
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountDelete))(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountDeletionPath {
+		return s.ensureUser(s.withAccountSync(s.handleAccountDeletionCancel))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountPasswordPath {
 		return s.ensureUser(s.handleAccountPasswordChange)(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountTokenPath {
@@ -467,10 +797,26 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureUser(s.withAccountSync(s.handleAccountSubscriptionChange))(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountSubscriptionPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountSubscriptionDelete))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountTemplatePath {
+		return s.ensureUser(s.withAccountSync(s.handleAccountTemplateAdd))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountTemplateSingleRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.withAccountSync(s.handleAccountTemplateDelete))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountReservationPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountReservationAdd))(w, r, v)
 	} else if r.Method == http.MethodDelete && apiAccountReservationSingleRegex.MatchString(r.URL.Path) {
 		return s.ensureUser(s.withAccountSync(s.handleAccountReservationDelete))(w, r, v)
+	} else if r.Method == http.MethodPost && apiAccountReservationPublishKeyRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.withAccountSync(s.handleAccountPublishKeyCreate))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationPublishKeyRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.withAccountSync(s.handleAccountPublishKeyDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationScheduleRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountScheduleGet)(w, r, v)
+	} else if r.Method == http.MethodPut && apiAccountReservationScheduleRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.withAccountSync(s.handleAccountScheduleChange))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationScheduleRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.withAccountSync(s.handleAccountScheduleDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountScheduledCalendarPath {
+		return s.ensureUser(s.handleAccountScheduledCalendar)(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountBillingSubscriptionPath {
 		return s.ensurePaymentsEnabled(s.ensureUser(s.handleAccountBillingSubscriptionCreate))(w, r, v) // Account sync via incoming Stripe webhook
 	} else if r.Method == http.MethodGet && apiAccountBillingSubscriptionCheckoutSuccessRegex.MatchString(r.URL.Path) {
@@ -489,16 +835,70 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureUser(s.ensureCallsEnabled(s.withAccountSync(s.handleAccountPhoneNumberAdd)))(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountPhonePath {
 		return s.ensureUser(s.ensureCallsEnabled(s.withAccountSync(s.handleAccountPhoneNumberDelete)))(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountWebPushPath {
+		return s.ensureUser(s.ensureWebPushEnabled(s.handleAccountWebPushList))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountWebPushSingleRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.ensureWebPushEnabled(s.handleAccountWebPushDelete))(w, r, v)
 	} else if r.Method == http.MethodPost && apiWebPushPath == r.URL.Path {
 		return s.ensureWebPushEnabled(s.limitRequests(s.handleWebPushUpdate))(w, r, v)
 	} else if r.Method == http.MethodDelete && apiWebPushPath == r.URL.Path {
 		return s.ensureWebPushEnabled(s.limitRequests(s.handleWebPushDelete))(w, r, v)
+	} else if r.Method == http.MethodPost && apiWebPushRenewPath == r.URL.Path {
+		return s.ensureWebPushEnabled(s.limitRequests(s.handleWebPushRenew))(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiStatsPath {
 		return s.handleStats(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiStatsHistoryPath {
+		return s.limitRequests(s.handleStatsHistory)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiTagsPath {
+		return s.limitRequests(s.handleTagIcons)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiErrorsPath {
+		return s.limitRequests(s.handleErrors)(w, r, v)
+	} else if r.Method == http.MethodGet && tagIconPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleTagIcon)(w, r, v)
+	} else if r.Method == http.MethodGet && apiTopicStatsRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleTopicStats)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiTiersPath {
 		return s.ensurePaymentsEnabled(s.handleBillingTiersGet)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == matrixPushPath {
 		return s.handleMatrixDiscovery(w)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiReportPath {
+		return s.limitRequests(s.handleReport)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiReportsPath {
+		return s.ensureAdmin(s.handleReportsGet)(w, r, v)
+	} else if r.Method == http.MethodPost && apiReportSingleRegex.MatchString(r.URL.Path) {
+		return s.ensureAdmin(s.handleReportAction)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiBlocksPath {
+		return s.ensureAdmin(s.handleBlocksGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiBlocksPath {
+		return s.ensureAdmin(s.handleBlocksPost)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiBlocksPath {
+		return s.ensureAdmin(s.handleBlocksDelete)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiMessagesPrunePath {
+		return s.ensureAdmin(s.handleMessagesPrune)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiExemptionsPath {
+		return s.ensureAdmin(s.handleRateLimitExemptionsGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiExemptionsPath {
+		return s.ensureAdmin(s.handleRateLimitExemptionsAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiExemptionsPath {
+		return s.ensureAdmin(s.handleRateLimitExemptionsDelete)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiEmailWebhookPath {
+		return s.ensureEmailWebhookEnabled(s.handleEmailWebhook)(w, r, v) // This request comes from the e-mail provider!
+	} else if r.Method == http.MethodGet && r.URL.Path == apiEmailUndeliverablePath {
+		return s.ensureAdmin(s.handleEmailUndeliverableGet)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiEmailUndeliverablePath {
+		return s.ensureAdmin(s.handleEmailUndeliverableDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiVisitorsPath {
+		return s.ensureAdmin(s.handleVisitorsGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiVisitorsResetPath {
+		return s.ensureAdmin(s.handleVisitorsReset)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiVisitorsExemptPath {
+		return s.ensureAdmin(s.handleVisitorsExempt)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiVisitorsExemptPath {
+		return s.ensureAdmin(s.handleVisitorsExemptDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiMatrixPushKeyPath {
+		return s.limitRequests(s.handleMatrixPushKeyStats)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiMatrixStatsPath {
+		return s.ensureAdmin(s.handleMatrixStats)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == metricsPath && s.metricsHandler != nil {
 		return s.handleMetrics(w, r, v)
 	} else if r.Method == http.MethodGet && (staticRegex.MatchString(r.URL.Path) || r.URL.Path == webServiceWorkerPath || r.URL.Path == webRootHTMLPath) {
@@ -510,13 +910,21 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 	} else if r.Method == http.MethodOptions {
 		return s.limitRequests(s.handleOptions)(w, r, v) // Should work even if the web app is not enabled, see #598
 	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == "/" {
-		return s.transformBodyJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish)))(w, r, v)
+		return s.transformPublishKeyBody(s.transformBodyJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.verifyWebhookSignature(s.handlePublish)))))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiPublishValidatePath {
+		return s.handlePublishValidate(w, r, v)
+	} else if r.Method == http.MethodPut && r.URL.Path == apiAttachmentsPath {
+		return s.limitRequests(s.handleAttachmentUpload)(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == matrixPushPath {
-		return s.transformMatrixJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublishMatrix)))(w, r, v)
+		return s.transformMatrixJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.verifyWebhookSignature(s.handlePublishMatrix))))(w, r, v)
 	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && topicPathRegex.MatchString(r.URL.Path) {
-		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish))(w, r, v)
+		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.verifyWebhookSignature(s.handlePublish)))(w, r, v)
+	} else if r.Method == http.MethodGet && publishPathRegex.MatchString(r.URL.Path) && r.URL.Query().Has(signedURLSignatureParam) {
+		return s.limitRequestsWithTopic(s.verifySignedURL(s.verifyWebhookSignature(s.handlePublish)))(w, r, v)
 	} else if r.Method == http.MethodGet && publishPathRegex.MatchString(r.URL.Path) {
-		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish))(w, r, v)
+		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.verifyWebhookSignature(s.handlePublish)))(w, r, v)
+	} else if r.Method == http.MethodPost && signPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicWrite(s.handlePublishURLSign))(w, r, v)
 	} else if r.Method == http.MethodGet && jsonPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeJSON))(w, r, v)
 	} else if r.Method == http.MethodGet && ssePathRegex.MatchString(r.URL.Path) {
@@ -525,8 +933,44 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeRaw))(w, r, v)
 	} else if r.Method == http.MethodGet && wsPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeWS))(w, r, v)
+	} else if r.Method == http.MethodGet && feedPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeFeed))(w, r, v)
+	} else if r.Method == http.MethodGet && exportPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleTopicExport))(w, r, v)
 	} else if r.Method == http.MethodGet && authPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleTopicAuth))(w, r, v)
+	} else if r.Method == http.MethodGet && messageThreadPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleMessageThread))(w, r, v)
+	} else if r.Method == http.MethodGet && messageReactionsPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleMessageReactionsGet))(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodDelete) && messageReactionPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicWrite(s.handleMessageReaction))(w, r, v)
+	} else if r.Method == http.MethodGet && messageAnnotationsPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleMessageAnnotationsGet))(w, r, v)
+	} else if r.Method == http.MethodPost && messageAnnotationsPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicWrite(s.handleMessageAnnotationAdd))(w, r, v)
+	} else if r.Method == http.MethodDelete && messageAnnotationPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicWrite(s.handleMessageAnnotationDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && presencePathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleTopicPresence))(w, r, v)
+	} else if r.Method == http.MethodGet && showcasePathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleTopicShowcase)(w, r, v) // No authorizeTopicRead, see handleTopicShowcase
+	} else if r.Method == http.MethodGet && maintenancePathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleMaintenanceWindowsGet))(w, r, v)
+	} else if r.Method == http.MethodPost && maintenancePathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.ensureUser(s.authorizeTopicWrite(s.handleMaintenanceWindowAdd)))(w, r, v)
+	} else if r.Method == http.MethodDelete && maintenanceIDPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.ensureUser(s.authorizeTopicWrite(s.handleMaintenanceWindowDelete)))(w, r, v)
+	} else if r.Method == http.MethodGet && heartbeatPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleHeartbeatGet))(w, r, v)
+	} else if r.Method == http.MethodPut && heartbeatPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.ensureUser(s.authorizeTopicWrite(s.handleHeartbeatChange)))(w, r, v)
+	} else if r.Method == http.MethodDelete && heartbeatPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.ensureUser(s.authorizeTopicWrite(s.handleHeartbeatDelete)))(w, r, v)
+	} else if (r.Method == http.MethodGet || r.Method == http.MethodPost) && heartbeatPingPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicWrite(s.handleHeartbeatPing))(w, r, v)
+	} else if r.Method == http.MethodGet && messageDeliveriesPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleMessageDeliveries)(w, r, v)
 	} else if r.Method == http.MethodGet && (topicPathRegex.MatchString(r.URL.Path) || externalTopicPathRegex.MatchString(r.URL.Path)) {
 		return s.ensureWebEnabled(s.handleTopic)(w, r, v)
 	}
@@ -542,9 +986,10 @@ func (s *Server) handleTopic(w http.ResponseWriter, r *http.Request, v *visitor)
 	unifiedpush := readBoolParam(r, false, "x-unifiedpush", "unifiedpush", "up") // see PUT/POST too!
 	if unifiedpush {
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
 		_, err := io.WriteString(w, `{"unifiedpush":{"version":1}}`+"\n")
 		return err
+	} else if isPreviewRequest(r) && topicPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleTopicPreview)(w, r, v)
 	}
 	r.URL.Path = webAppIndex
 	return s.handleStatic(w, r, v)
@@ -558,20 +1003,13 @@ func (s *Server) handleTopicAuth(w http.ResponseWriter, _ *http.Request, _ *visi
 	return s.writeJSON(w, newSuccessResponse())
 }
 
-func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
-	response := &apiHealthResponse{
-		Healthy: true,
-	}
-	return s.writeJSON(w, response)
-}
-
-func (s *Server) handleWebConfig(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+func (s *Server) handleWebConfig(w http.ResponseWriter, r *http.Request, _ *visitor) error {
 	response := &apiConfigResponse{
 		BaseURL:            "", // Will translate to window.location.origin
 		AppRoot:            s.config.WebRoot,
 		EnableLogin:        s.config.EnableLogin,
 		EnableSignup:       s.config.EnableSignup,
-		EnablePayments:     s.config.StripeSecretKey != "",
+		EnablePayments:     s.payments != nil,
 		EnableCalls:        s.config.TwilioAccount != "",
 		EnableEmails:       s.config.SMTPSenderFrom != "",
 		EnableReservations: s.config.EnableReservations,
@@ -584,6 +1022,7 @@ func (s *Server) handleWebConfig(w http.ResponseWriter, _ *http.Request, _ *visi
 	if err != nil {
 		return err
 	}
+	s.setCSRFCookie(w, r)
 	w.Header().Set("Content-Type", "text/javascript")
 	_, err = io.WriteString(w, fmt.Sprintf("// Generated server configuration\nvar config = %s;\n", string(b)))
 	return err
@@ -643,6 +1082,19 @@ func (s *Server) handleStats(w http.ResponseWriter, _ *http.Request, _ *visitor)
 	return s.writeJSON(w, response)
 }
 
+// handleErrors returns the catalog of all errors the server may respond with, sorted by code, so that
+// client libraries can map error codes and slugs programmatically
+func (s *Server) handleErrors(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	errs := make([]*errHTTP, len(errHTTPCatalog))
+	copy(errs, errHTTPCatalog)
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Code < errs[j].Code
+	})
+	return s.writeJSON(w, &apiErrorsResponse{
+		Errors: errs,
+	})
+}
+
 // handleFile processes the download of attachment files. The method handles GET and HEAD requests against a file.
 // Before streaming the file to a client, it locates uploader (m.Sender or m.User) in the message cache, so it
 // can associate the download bandwidth with the uploader.
@@ -654,6 +1106,10 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 	if len(matches) != 2 {
 		return errHTTPInternalErrorInvalidPath
 	}
+	if s.config.AttachmentBaseURL != "" && !s.isAttachmentHost(r) {
+		http.Redirect(w, r, s.config.AttachmentBaseURL+r.URL.Path, http.StatusTemporaryRedirect)
+		return nil
+	}
 	messageID := matches[1]
 	file := filepath.Join(s.config.AttachmentCacheDir, messageID)
 	stat, err := os.Stat(file)
@@ -663,8 +1119,9 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 			"error_context": "filesystem",
 		})
 	}
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", stat.Size()))
+	w.Header().Set("Content-Security-Policy", "sandbox") // Never run scripts embedded in uploaded files
+	w.Header().Set("X-Content-Type-Options", "nosniff")
 	if r.Method == http.MethodHead {
 		return nil
 	}
@@ -709,13 +1166,47 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 		return err
 	}
 	defer f.Close()
+	risky := isRiskyAttachmentType(m.Attachment.Type)
 	if m.Attachment.Name != "" {
 		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(m.Attachment.Name))
+	} else if risky {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	if risky && s.config.AttachmentStripHTML {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err = io.Copy(w, f)
+		return err
 	}
 	_, err = io.Copy(util.NewContentTypeWriter(w, r.URL.Path), f)
 	return err
 }
 
+// isAttachmentHost returns true if the request was made against the host of the attachment-base-url
+func (s *Server) isAttachmentHost(r *http.Request) bool {
+	u, err := url.Parse(s.config.AttachmentBaseURL)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// attachmentBaseURL returns the base URL used for attachment download URLs, see attachment-base-url
+func (s *Server) attachmentBaseURL() string {
+	if s.config.AttachmentBaseURL != "" {
+		return s.config.AttachmentBaseURL
+	}
+	return s.config.BaseURL
+}
+
+// isRiskyAttachmentType returns true if browsers may execute scripts embedded in files of the given content type,
+// e.g. HTML or SVG files. These files are always served with "Content-Disposition: attachment".
+func isRiskyAttachmentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml", "text/javascript", "application/javascript":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+xml")
+}
+
 func (s *Server) handleMatrixDiscovery(w http.ResponseWriter) error {
 	if s.config.BaseURL == "" {
 		return errHTTPInternalErrorMissingBaseURL
@@ -723,7 +1214,37 @@ func (s *Server) handleMatrixDiscovery(w http.ResponseWriter) error {
 	return writeMatrixDiscoveryResponse(w)
 }
 
-func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, error) {
+// handleMatrixPushKeyStats returns the delivery stats and recent rejection/failure reasons for a single
+// Matrix push key. If the push key points to a topic on this server, read access to the topic is required.
+func (s *Server) handleMatrixPushKeyStats(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	pushKey := readParam(r, "x-pushkey", "pushkey")
+	if pushKey == "" {
+		return errHTTPBadRequestMatrixPushKeyMissing
+	}
+	if s.userManager != nil && strings.HasPrefix(pushKey, s.config.BaseURL+"/") {
+		if u, err := url.Parse(pushKey); err == nil && topicPathRegex.MatchString(u.Path) {
+			if err := s.userManager.Authorize(v.User(), strings.TrimPrefix(u.Path, "/"), user.PermissionRead); err != nil {
+				return errHTTPForbidden
+			}
+		}
+	}
+	stats := s.matrixStats.PushKey(pushKey)
+	if stats == nil {
+		return errHTTPNotFound
+	}
+	return s.writeJSON(w, stats)
+}
+
+// handleMatrixStats returns the per-app Matrix gateway stats, and the most recent rejection/failure
+// reasons across all push keys (admin only)
+func (s *Server) handleMatrixStats(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	return s.writeJSON(w, s.matrixStats.Stats())
+}
+
+// handlePublishInternal parses the publish request and delivers the message. If dryRun is set, the request is
+// parsed and validated like a regular publish request, but the message is not delivered, no attachment is
+// stored, and the message/email/call limits are not counted against the visitor (see handlePublishValidate).
+func (s *Server) handlePublishInternal(r *http.Request, v *visitor, dryRun bool) (*message, error) {
 	start := time.Now()
 	t, err := fromContext[*topic](r, contextTopic)
 	if err != nil {
@@ -733,7 +1254,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 	if err != nil {
 		return nil, err
 	}
-	body, err := util.Peek(r.Body, s.config.MessageLimit)
+	body, err := util.Peek(r.Body, int(v.Limits().MessageSizeLimit))
 	if err != nil {
 		return nil, err
 	}
@@ -748,34 +1269,72 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 		// the subscription as invalid if any 400-499 code (except 429/408) is returned.
 		// See https://github.com/mastodon/mastodon/blob/730bb3e211a84a2f30e3e2bbeae3f77149824a68/app/workers/web/push_notification_worker.rb#L35-L46
 		return nil, errHTTPInsufficientStorageUnifiedPush.With(t)
-	} else if !util.ContainsIP(s.config.VisitorRequestExemptIPAddrs, v.ip) && !vrate.MessageAllowed() {
+	} else if !dryRun && !s.requestLimitExempt(r, v) && !vrate.MessageAllowed() {
 		return nil, errHTTPTooManyRequestsLimitMessages.With(t)
-	} else if email != "" && !vrate.EmailAllowed() {
+	} else if !dryRun && email != "" && !vrate.EmailAllowed() {
 		return nil, errHTTPTooManyRequestsLimitEmails.With(t)
 	} else if call != "" {
 		var httpErr *errHTTP
 		call, httpErr = s.convertPhoneNumber(v.User(), call)
 		if httpErr != nil {
 			return nil, httpErr.With(t)
-		} else if !vrate.CallAllowed() {
+		} else if !dryRun && !vrate.CallAllowed() {
 			return nil, errHTTPTooManyRequestsLimitCalls.With(t)
 		}
 	}
+	if !dryRun {
+		s.maybeSendDailyQuotaWarnings(vrate, email != "")
+	}
 	if m.PollID != "" {
 		m = newPollRequestMessage(t.ID, m.PollID)
 	}
 	m.Sender = v.IP()
 	m.User = v.MaybeUserID()
+	if m.Sound == "" && m.PollID == "" && s.userManager != nil {
+		sound, err := s.userManager.ReservationSound(t.ID)
+		if err != nil {
+			return nil, err
+		}
+		m.Sound = sound // Default sound of the reserved topic, may be empty
+	}
+	if m.PollID == "" && s.userManager != nil {
+		if err := s.applyPublishKeyDefaults(r, m); err != nil {
+			return nil, err
+		}
+	}
+	policy, err := s.enforceTopicPolicy(m, body, unifiedpush)
+	if err != nil {
+		return nil, err
+	}
 	if cache {
 		m.Expires = time.Unix(m.Time, 0).Add(v.Limits().MessageExpiryDuration).Unix()
 	}
-	if err := s.handlePublishBody(r, v, m, body, unifiedpush); err != nil {
+	if err := s.handlePublishBody(r, v, m, body, unifiedpush, dryRun); err != nil {
 		return nil, err
 	}
+	if policy != nil && policy.MaxMessageSize > 0 && len(m.Message) > policy.MaxMessageSize {
+		return nil, errHTTPEntityTooLargeTopicPolicyMessage.With(m)
+	}
 	if m.Message == "" {
 		m.Message = emptyMessageBody
 	}
+	suppressed, err := s.applyMaintenanceWindows(m)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return m, nil
+	}
 	delayed := m.Time > time.Now().Unix()
+	route, err := s.routeMessage(m)
+	if err != nil {
+		return nil, err
+	}
+	webpush := true
+	if route != nil {
+		firebase = firebase && route.push
+		webpush = route.push
+	}
 	ev := logvrm(v, r, m).
 		Tag(tagPublish).
 		With(t).
@@ -785,31 +1344,33 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 			"message_unifiedpush": unifiedpush,
 			"message_email":       email,
 			"message_call":        call,
+			"message_routed":      route != nil,
+			"message_suppressed":  suppressed,
 		})
 	if ev.IsTrace() {
 		ev.Field("message_body", util.MaybeMarshalJSON(m)).Trace("Received message")
 	} else if ev.IsDebug() {
 		ev.Debug("Received message")
 	}
-	if !delayed {
+	if suppressed && !delayed {
+		logvrm(v, r, m).Tag(tagPublish).Info("Message suppressed by maintenance window, not delivering it")
+	} else if !delayed {
 		if err := t.Publish(v, m); err != nil {
 			return nil, err
 		}
-		if s.firebaseClient != nil && firebase {
-			go s.sendToFirebase(v, m)
-		}
+		subscribers, _ := t.Stats()
+		s.recordDelivery(m, deliveryChannelSubscribers, subscribers, nil)
+		s.publishToPushChannels(v, m, firebase, webpush, !unifiedpush) // UP messages are not sent to upstream
 		if s.smtpSender != nil && email != "" {
 			go s.sendEmail(v, m, email)
 		}
 		if s.config.TwilioAccount != "" && call != "" {
 			go s.callPhone(v, r, m, call)
 		}
-		if s.config.UpstreamBaseURL != "" && !unifiedpush { // UP messages are not sent to upstream
-			go s.forwardPollRequest(v, m)
-		}
-		if s.config.WebPushPublicKey != "" {
-			go s.publishToWebPushEndpoints(v, m)
+		if route != nil {
+			s.sendRoutedMessage(r, m, route, email, call)
 		}
+		s.forwardMessage(v, m)
 	} else {
 		logvrm(v, r, m).Tag(tagPublish).Debug("Message delayed, will process later")
 	}
@@ -818,6 +1379,12 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 		if err := s.messageCache.AddMessage(m); err != nil {
 			return nil, err
 		}
+		if !delayed && !suppressed {
+			s.scheduleEscalation(v, m)
+		}
+	}
+	if !delayed {
+		s.recordHeartbeat(v, m)
 	}
 	u := v.User()
 	if s.userManager != nil && u != nil && u.Tier != nil {
@@ -826,6 +1393,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 	s.mu.Lock()
 	s.messages++
 	s.mu.Unlock()
+	s.statsHistory.MessagePublished(m)
 	if unifiedpush {
 		minc(metricUnifiedPushPublishedSuccess)
 	}
@@ -834,7 +1402,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 }
 
 func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	m, err := s.handlePublishInternal(r, v)
+	m, err := s.handlePublishInternal(r, v, false)
 	if err != nil {
 		minc(metricMessagesPublishedFailure)
 		return err
@@ -844,7 +1412,7 @@ func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request, v *visito
 }
 
 func (s *Server) handlePublishMatrix(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	_, err := s.handlePublishInternal(r, v)
+	_, err := s.handlePublishInternal(r, v, false)
 	if err != nil {
 		minc(metricMessagesPublishedFailure)
 		minc(metricMatrixPublishedFailure)
@@ -858,54 +1426,148 @@ func (s *Server) handlePublishMatrix(w http.ResponseWriter, r *http.Request, v *
 				return err
 			}
 			if time.Since(topic.LastAccess()) > matrixRejectPushKeyForUnifiedPushTopicWithoutRateVisitorAfter {
+				appID, _ := fromContext[string](r, contextMatrixAppID)
+				s.matrixStats.Rejected(pushKey, appID, fmt.Sprintf("UnifiedPush topic %s has had no subscriber for more than %s", topic.ID, matrixRejectPushKeyForUnifiedPushTopicWithoutRateVisitorAfter))
 				return writeMatrixResponse(w, pushKey)
 			}
 		}
 		return err
 	}
+	pushKey, _ := fromContext[string](r, contextMatrixPushKey)
+	appID, _ := fromContext[string](r, contextMatrixAppID)
+	s.matrixStats.Delivered(pushKey, appID)
 	minc(metricMessagesPublishedSuccess)
 	minc(metricMatrixPublishedSuccess)
 	return writeMatrixSuccess(w)
 }
 
-func (s *Server) sendToFirebase(v *visitor, m *message) {
+// enqueueFirebase queues a message to be sent to Firebase by one of the Firebase workers. Messages with a higher
+// priority are sent first, so that urgent messages are not delayed by a burst of messages to a large topic.
+func (s *Server) enqueueFirebase(v *visitor, m *message) {
+	s.firebaseStarted.Do(s.startFirebaseWorkers)
+	item := &firebaseQueueItem{v: v, m: m, queued: time.Now()}
+	if !s.firebaseQueue.Enqueue(item, firebaseQueuePriority(m)) {
+		minc(metricFirebaseQueueDropped)
+		minc(metricFirebasePublishedFailure)
+		s.statsHistory.DeliveryFailed()
+		s.recordDelivery(m, deliveryChannelFirebase, 1, errFirebaseQueueFull)
+		logvm(v, m).Tag(tagFirebase).Warn("Unable to publish to Firebase: queue is full")
+		return
+	}
+	mset(metricFirebaseQueueDepth, s.firebaseQueue.Len())
+}
+
+func (s *Server) startFirebaseWorkers() {
+	log.Tag(tagFirebase).Debug("Starting %d Firebase worker(s)", s.config.FirebaseWorkers)
+	for i := 0; i < s.config.FirebaseWorkers; i++ {
+		go s.runFirebaseWorker()
+	}
+}
+
+func (s *Server) runFirebaseWorker() {
+	for {
+		item, ok := s.firebaseQueue.Dequeue()
+		if !ok {
+			return // Queue closed
+		}
+		s.firebaseSending.Add(1)
+		mset(metricFirebaseQueueDepth, s.firebaseQueue.Len())
+		mset(metricFirebaseQueueLatencyMillis, time.Since(item.queued).Milliseconds())
+		s.sendToFirebase(item.v, item.m)
+		s.firebaseSending.Add(-1)
+	}
+}
+
+func (s *Server) sendToFirebase(v *visitor, m *message) error {
 	logvm(v, m).Tag(tagFirebase).Debug("Publishing to Firebase")
 	if err := s.firebaseClient.Send(v, m); err != nil {
 		minc(metricFirebasePublishedFailure)
+		s.statsHistory.DeliveryFailed()
+		s.recordDelivery(m, deliveryChannelFirebase, 1, err)
 		if err == errFirebaseTemporarilyBanned {
 			logvm(v, m).Tag(tagFirebase).Err(err).Debug("Unable to publish to Firebase: %v", err.Error())
 		} else {
 			logvm(v, m).Tag(tagFirebase).Err(err).Warn("Unable to publish to Firebase: %v", err.Error())
 		}
-		return
+		return err
 	}
 	minc(metricFirebasePublishedSuccess)
+	s.recordDelivery(m, deliveryChannelFirebase, 1, nil)
+	return nil
 }
 
 func (s *Server) sendEmail(v *visitor, m *message, email string) {
+	if s.emailUndeliverable(email) {
+		logvm(v, m).Tag(tagEmail).Field("email", email).Debug("Not sending email to %s, address is undeliverable", email)
+		return
+	} else if s.emailBatcher != nil && !s.emailBatcher.Add(v, m, email) {
+		logvm(v, m).Tag(tagEmail).Field("email", email).Debug("Adding email to %s to batch", email)
+		return
+	}
 	logvm(v, m).Tag(tagEmail).Field("email", email).Debug("Sending email to %s", email)
 	if err := s.smtpSender.Send(v, m, email); err != nil {
 		logvm(v, m).Tag(tagEmail).Field("email", email).Err(err).Warn("Unable to send email to %s: %v", email, err.Error())
 		minc(metricEmailsPublishedFailure)
+		s.statsHistory.DeliveryFailed()
+		s.recordDelivery(m, deliveryChannelEmail, 1, err)
 		return
 	}
 	minc(metricEmailsPublishedSuccess)
+	s.recordDelivery(m, deliveryChannelEmail, 1, nil)
+	s.updateEmailRecipient(v, m, email)
+}
+
+// sendEmailBatch sends the messages collected in a batch window as a single summary e-mail, see emailBatcher
+func (s *Server) sendEmailBatch(v *visitor, batch *emailBatch, email string) {
+	if s.emailUndeliverable(email) {
+		logv(v).Tag(tagEmail).Field("email", email).Debug("Not sending summary email to %s, address is undeliverable", email)
+		return
+	}
+	m := newEmailBatchMessage(userLanguage(v.User()), batch)
+	ev := logvm(v, m).Tag(tagEmail).Fields(log.Context{
+		"email":                email,
+		"email_batch_messages": len(batch.messages),
+		"email_batch_omitted":  batch.omitted,
+	})
+	ev.Debug("Sending summary email with %d message(s) to %s", len(batch.messages)+batch.omitted, email)
+	err := s.smtpSender.Send(v, m, email)
+	if err != nil {
+		ev.Err(err).Warn("Unable to send summary email to %s: %v", email, err.Error())
+		minc(metricEmailsPublishedFailure)
+		s.statsHistory.DeliveryFailed()
+	} else {
+		minc(metricEmailsPublishedSuccess)
+		s.updateEmailRecipient(v, batch.messages[len(batch.messages)-1], email)
+	}
+	for _, bm := range batch.messages {
+		s.recordDelivery(bm, deliveryChannelEmail, 1, err)
+	}
+}
+
+// flushEmailBatches sends all pending summary e-mails, e.g. when shutting down
+func (s *Server) flushEmailBatches() {
+	if s.emailBatcher == nil {
+		return
+	}
+	for email, batch := range s.emailBatcher.Flush() {
+		s.sendEmailBatch(batch.visitor, batch, email)
+	}
 }
 
-func (s *Server) forwardPollRequest(v *visitor, m *message) {
+func (s *Server) forwardPollRequest(v *visitor, m *message, upstream *Upstream) error {
 	topicURL := fmt.Sprintf("%s/%s", s.config.BaseURL, m.Topic)
 	topicHash := fmt.Sprintf("%x", sha256.Sum256([]byte(topicURL)))
-	forwardURL := fmt.Sprintf("%s/%s", s.config.UpstreamBaseURL, topicHash)
+	forwardURL := fmt.Sprintf("%s/%s", upstream.BaseURL, topicHash)
 	logvm(v, m).Debug("Publishing poll request to %s", forwardURL)
 	req, err := http.NewRequest("POST", forwardURL, strings.NewReader(""))
 	if err != nil {
 		logvm(v, m).Err(err).Warn("Unable to publish poll request")
-		return
+		return err
 	}
 	req.Header.Set("User-Agent", "ntfy/"+s.config.Version)
 	req.Header.Set("X-Poll-ID", m.ID)
-	if s.config.UpstreamAccessToken != "" {
-		req.Header.Set("Authorization", util.BearerAuth(s.config.UpstreamAccessToken))
+	if upstream.AccessToken != "" {
+		req.Header.Set("Authorization", util.BearerAuth(upstream.AccessToken))
 	}
 	var httpClient = &http.Client{
 		Timeout: time.Second * 10,
@@ -913,15 +1575,20 @@ func (s *Server) forwardPollRequest(v *visitor, m *message) {
 	response, err := httpClient.Do(req)
 	if err != nil {
 		logvm(v, m).Err(err).Warn("Unable to publish poll request")
-		return
+		s.recordDelivery(m, deliveryChannelUpstream, 1, err)
+		return err
 	} else if response.StatusCode != http.StatusOK {
 		if response.StatusCode == http.StatusTooManyRequests {
-			logvm(v, m).Err(err).Warn("Unable to publish poll request, the upstream server %s responded with HTTP %s; you may solve this by sending fewer daily messages, or by configuring upstream-access-token (assuming you have an account with higher rate limits) ", s.config.UpstreamBaseURL, response.Status)
+			logvm(v, m).Err(err).Warn("Unable to publish poll request, the upstream server %s responded with HTTP %s; you may solve this by sending fewer daily messages, or by configuring an access token for the upstream server (assuming you have an account with higher rate limits) ", upstream.BaseURL, response.Status)
 		} else {
-			logvm(v, m).Err(err).Warn("Unable to publish poll request, the upstream server %s responded with HTTP %s", s.config.UpstreamBaseURL, response.Status)
+			logvm(v, m).Err(err).Warn("Unable to publish poll request, the upstream server %s responded with HTTP %s", upstream.BaseURL, response.Status)
 		}
-		return
+		err := fmt.Errorf("upstream server responded with HTTP %s", response.Status)
+		s.recordDelivery(m, deliveryChannelUpstream, 1, err)
+		return err
 	}
+	s.recordDelivery(m, deliveryChannelUpstream, 1, nil)
+	return nil
 }
 
 func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, firebase bool, email, call string, unifiedpush bool, err *errHTTP) {
@@ -930,11 +1597,20 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 	m.Title = readParam(r, "x-title", "title", "t")
 	m.Click = readParam(r, "x-click", "click")
 	icon := readParam(r, "x-icon", "icon")
+	sound := readParam(r, "x-sound", "sound")
+	inReplyTo := readParam(r, "x-in-reply-to", "in-reply-to")
+	locationStr := readParam(r, "x-location", "location", "loc")
+	hostname := readParam(r, "x-hostname", "hostname")
+	correlationID := readParam(r, "x-correlation-id", "correlation-id", "correlation_id", "correlation")
 	filename := readParam(r, "x-filename", "filename", "file", "f")
 	attach := readParam(r, "x-attach", "attach", "a")
-	if attach != "" || filename != "" {
+	attachmentID := readAttachmentIDParam(r)
+	if attach != "" || filename != "" || attachmentID != "" {
 		m.Attachment = &attachment{}
 	}
+	if attachmentID != "" && (attach != "" || !uploadIDRegex.MatchString(attachmentID)) {
+		return false, false, "", "", false, errHTTPBadRequestAttachmentIDInvalid
+	}
 	if filename != "" {
 		m.Attachment.Name = filename
 	}
@@ -962,9 +1638,47 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 		}
 		m.Icon = icon
 	}
+	if sound != "" {
+		if !soundRegex.MatchString(sound) {
+			return false, false, "", "", false, errHTTPBadRequestSoundInvalid
+		}
+		m.Sound = sound
+	}
+	if inReplyTo != "" {
+		if !validMessageID(inReplyTo) {
+			return false, false, "", "", false, errHTTPBadRequestInReplyToInvalid
+		}
+		m.InReplyTo = inReplyTo
+	}
+	if locationStr != "" {
+		loc, err := parseLocation(locationStr)
+		if err != nil {
+			return false, false, "", "", false, errHTTPBadRequestLocationInvalid
+		}
+		m.Location = loc
+	}
+	if hostname != "" {
+		if !hostnameRegex.MatchString(hostname) {
+			return false, false, "", "", false, errHTTPBadRequestHostnameInvalid
+		}
+		m.Hostname = hostname
+	}
+	if correlationID != "" {
+		if !correlationIDRegex.MatchString(correlationID) {
+			return false, false, "", "", false, errHTTPBadRequestCorrelationIDInvalid
+		}
+		m.CorrelationID = correlationID
+	}
 	email = readParam(r, "x-email", "x-e-mail", "email", "e-mail", "mail", "e")
 	if s.smtpSender == nil && email != "" {
 		return false, false, "", "", false, errHTTPBadRequestEmailDisabled
+	} else if email != "" {
+		undeliverable, err := s.messageCache.EmailUndeliverable(email)
+		if err != nil {
+			return false, false, "", "", false, errHTTPInternalError
+		} else if undeliverable {
+			return false, false, "", "", false, errHTTPBadRequestEmailUndeliverable
+		}
 	}
 	call = readParam(r, "x-call", "call")
 	if call != "" && (s.config.TwilioAccount == "" || s.userManager == nil) {
@@ -1034,27 +1748,37 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 //     If a message is flagged as poll request, the body does not matter and is discarded
 //  2. curl -T somebinarydata.bin "ntfy.sh/mytopic?up=1"
 //     If body is binary, encode as base64, if not do not encode
-//  3. curl -H "Attach: http://example.com/file.jpg" ntfy.sh/mytopic
+//  3. curl -H "Template: grafana" -d '{"title":"..."}' ntfy.sh/mytopic
+//     Body must be JSON data, from which the message is rendered using the template
+//  4. curl -H "Attach: http://example.com/file.jpg" ntfy.sh/mytopic
 //     Body must be a message, because we attached an external URL
-//  4. curl -T short.txt -H "Filename: short.txt" ntfy.sh/mytopic
+//  5. curl -H "Attachment-ID: up_abcdefghi" ntfy.sh/mytopic
+//     Body must be a message, because we referenced a separately uploaded attachment
+//  6. curl -T short.txt -H "Filename: short.txt" ntfy.sh/mytopic
 //     Body must be attachment, because we passed a filename
-//  5. curl -T file.txt ntfy.sh/mytopic
+//  7. curl -T file.txt ntfy.sh/mytopic
 //     If file.txt is <= 4096 (message limit) and valid UTF-8, treat it as a message
-//  6. curl -T file.txt ntfy.sh/mytopic
+//  8. curl -T file.txt ntfy.sh/mytopic
 //     If file.txt is > message limit, treat it as an attachment
-func (s *Server) handlePublishBody(r *http.Request, v *visitor, m *message, body *util.PeekedReadCloser, unifiedpush bool) error {
+//
+// If dryRun is set, attachments are validated, but not written to the file cache.
+func (s *Server) handlePublishBody(r *http.Request, v *visitor, m *message, body *util.PeekedReadCloser, unifiedpush, dryRun bool) error {
 	if m.Event == pollRequestEvent { // Case 1
 		return s.handleBodyDiscard(body)
 	} else if unifiedpush {
 		return s.handleBodyAsMessageAutoDetect(m, body) // Case 2
+	} else if template := readTemplateParam(r); template != "" {
+		return s.handleBodyAsTemplatedMessage(v, m, body, template) // Case 3
 	} else if m.Attachment != nil && m.Attachment.URL != "" {
-		return s.handleBodyAsTextMessage(m, body) // Case 3
+		return s.handleBodyAsTextMessage(m, body) // Case 4
+	} else if attachmentID := readAttachmentIDParam(r); attachmentID != "" {
+		return s.handleBodyWithUploadedAttachment(v, m, body, attachmentID, dryRun) // Case 5
 	} else if m.Attachment != nil && m.Attachment.Name != "" {
-		return s.handleBodyAsAttachment(r, v, m, body) // Case 4
+		return s.handleBodyAsAttachment(r, v, m, body, dryRun) // Case 6
 	} else if !body.LimitReached && utf8.Valid(body.PeekedBytes) {
-		return s.handleBodyAsTextMessage(m, body) // Case 5
+		return s.handleBodyAsTextMessage(m, body) // Case 7
 	}
-	return s.handleBodyAsAttachment(r, v, m, body) // Case 6
+	return s.handleBodyAsAttachment(r, v, m, body, dryRun) // Case 8
 }
 
 func (s *Server) handleBodyDiscard(body *util.PeekedReadCloser) error {
@@ -1086,7 +1810,7 @@ func (s *Server) handleBodyAsTextMessage(m *message, body *util.PeekedReadCloser
 	return nil
 }
 
-func (s *Server) handleBodyAsAttachment(r *http.Request, v *visitor, m *message, body *util.PeekedReadCloser) error {
+func (s *Server) handleBodyAsAttachment(r *http.Request, v *visitor, m *message, body *util.PeekedReadCloser, dryRun bool) error {
 	if s.fileCache == nil || s.config.BaseURL == "" || s.config.AttachmentCacheDir == "" {
 		return errHTTPBadRequestAttachmentsDisallowed.With(m)
 	}
@@ -1102,7 +1826,7 @@ func (s *Server) handleBodyAsAttachment(r *http.Request, v *visitor, m *message,
 	if contentLengthStr != "" { // Early "do-not-trust" check, hard limit see below
 		contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
 		if err == nil && (contentLength > vinfo.Stats.AttachmentTotalSizeRemaining || contentLength > vinfo.Limits.AttachmentFileSizeLimit) {
-			return errHTTPEntityTooLargeAttachment.With(m).Fields(log.Context{
+			return s.attachmentLimitError(v, vinfo, contentLength > vinfo.Limits.AttachmentFileSizeLimit).With(m).Fields(log.Context{
 				"message_content_length":          contentLength,
 				"attachment_total_size_remaining": vinfo.Stats.AttachmentTotalSizeRemaining,
 				"attachment_file_size_limit":      vinfo.Limits.AttachmentFileSizeLimit,
@@ -1115,27 +1839,76 @@ func (s *Server) handleBodyAsAttachment(r *http.Request, v *visitor, m *message,
 	var ext string
 	m.Attachment.Expires = attachmentExpiry
 	m.Attachment.Type, ext = util.DetectContentType(body.PeekedBytes, m.Attachment.Name)
-	m.Attachment.URL = fmt.Sprintf("%s/file/%s%s", s.config.BaseURL, m.ID, ext)
+	m.Attachment.URL = fmt.Sprintf("%s/file/%s%s", s.attachmentBaseURL(), m.ID, ext)
 	if m.Attachment.Name == "" {
 		m.Attachment.Name = fmt.Sprintf("attachment%s", ext)
 	}
 	if m.Message == "" {
 		m.Message = fmt.Sprintf(defaultAttachmentMessage, m.Attachment.Name)
 	}
+	if dryRun {
+		size, err := io.Copy(io.Discard, io.LimitReader(body, vinfo.Limits.AttachmentFileSizeLimit+1))
+		_ = body.Close()
+		if err != nil {
+			return err
+		} else if size > vinfo.Limits.AttachmentFileSizeLimit || size > vinfo.Stats.AttachmentTotalSizeRemaining {
+			return s.attachmentLimitError(v, vinfo, size > vinfo.Limits.AttachmentFileSizeLimit).With(m)
+		}
+		m.Attachment.Size = size
+		return nil
+	}
+	fileSizeLimiter := util.NewFixedLimiter(vinfo.Limits.AttachmentFileSizeLimit)
+	totalSizeLimiter := util.NewFixedLimiter(vinfo.Stats.AttachmentTotalSizeRemaining)
 	limiters := []util.Limiter{
 		v.BandwidthLimiter(),
-		util.NewFixedLimiter(vinfo.Limits.AttachmentFileSizeLimit),
-		util.NewFixedLimiter(vinfo.Stats.AttachmentTotalSizeRemaining),
+		fileSizeLimiter,
+		totalSizeLimiter,
 	}
-	m.Attachment.Size, err = s.fileCache.Write(m.ID, body, limiters...)
+	m.Attachment.Size, m.Attachment.Hash, err = s.fileCache.Write(m.ID, body, limiters...)
 	if err == util.ErrLimitReached {
-		return errHTTPEntityTooLargeAttachment.With(m)
+		if fileSizeLimiter.Rejected() || totalSizeLimiter.Rejected() {
+			return s.attachmentLimitError(v, vinfo, fileSizeLimiter.Rejected()).With(m)
+		}
+		return errHTTPEntityTooLargeAttachment.With(m) // Bandwidth limit, or global attachment cache limit
 	} else if err != nil {
 		return err
 	}
+	if err := s.messageCache.AddAttachmentUsage(v.MaybeUserID(), m.Attachment.Size, m.Attachment.Hash); err != nil {
+		logvm(v, m).Tag(tagPublish).Err(err).Warn("Cannot update attachment usage")
+	}
+	s.deduplicateAttachment(v, m)
+	s.maybeSendQuotaWarning(v, quotaAttachmentTotalSize, vinfo.Stats.AttachmentTotalSize+m.Attachment.Size, vinfo.Limits.AttachmentTotalSizeLimit)
 	return nil
 }
 
+// attachmentLimitError returns the error for an attachment that exceeds either the per-file size limit or the
+// visitor's remaining attachment storage quota. If the quota was exceeded, a quota warning is sent as well.
+func (s *Server) attachmentLimitError(v *visitor, vinfo *visitorInfo, fileSizeLimitExceeded bool) *errHTTP {
+	if fileSizeLimitExceeded {
+		return errHTTPEntityTooLargeAttachmentFileSize.Wrap("max. attachment size is %s", util.FormatSize(vinfo.Limits.AttachmentFileSizeLimit)).WithLimit(vinfo.Limits.AttachmentFileSizeLimit)
+	}
+	s.maybeSendQuotaWarning(v, quotaAttachmentTotalSize, vinfo.Limits.AttachmentTotalSizeLimit, vinfo.Limits.AttachmentTotalSizeLimit)
+	return errHTTPEntityTooLargeAttachmentQuota.Wrap("%s of %s remaining", util.FormatSize(vinfo.Stats.AttachmentTotalSizeRemaining), util.FormatSize(vinfo.Limits.AttachmentTotalSizeLimit)).WithLimit(vinfo.Limits.AttachmentTotalSizeLimit)
+}
+
+// deduplicateAttachment replaces the attachment of the given message with a link to an existing attachment with
+// identical content, if there is one. The content is then only stored once on disk. If linking fails (e.g. because
+// the file system does not support hard links), the attachment is kept as is.
+func (s *Server) deduplicateAttachment(v *visitor, m *message) {
+	existingID, err := s.messageCache.AttachmentByHash(m.Attachment.Hash)
+	if err == errMessageNotFound || existingID == m.ID {
+		return
+	} else if err != nil {
+		logvm(v, m).Tag(tagFileCache).Err(err).Warn("Cannot look up attachments with identical content")
+		return
+	}
+	if err := s.fileCache.Link(m.ID, existingID); err != nil {
+		logvm(v, m).Tag(tagFileCache).Err(err).Warn("Cannot link attachment to existing attachment %s", existingID)
+		return
+	}
+	logvm(v, m).Tag(tagFileCache).Debug("Attachment has identical content as attachment %s, content is stored only once", existingID)
+}
+
 func (s *Server) handleSubscribeJSON(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	encoder := func(msg *message) (string, error) {
 		var buf bytes.Buffer
@@ -1144,21 +1917,28 @@ func (s *Server) handleSubscribeJSON(w http.ResponseWriter, r *http.Request, v *
 		}
 		return buf.String(), nil
 	}
-	return s.handleSubscribeHTTP(w, r, v, "application/x-ndjson", encoder)
+	return s.handleSubscribeHTTP(w, r, v, "application/x-ndjson", subscriberTransportJSON, encoder)
 }
 
 func (s *Server) handleSubscribeSSE(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	retry, err := s.sseRetryInterval(r)
+	if err != nil {
+		return err
+	}
 	encoder := func(msg *message) (string, error) {
 		var buf bytes.Buffer
 		if err := json.NewEncoder(&buf).Encode(&msg); err != nil {
 			return "", err
 		}
+		if msg.Event == openEvent && retry > 0 {
+			return fmt.Sprintf("retry: %d\nevent: %s\ndata: %s\n", retry.Milliseconds(), msg.Event, buf.String()), nil
+		}
 		if msg.Event != messageEvent {
 			return fmt.Sprintf("event: %s\ndata: %s\n", msg.Event, buf.String()), nil // Browser's .onmessage() does not fire on this!
 		}
 		return fmt.Sprintf("data: %s\n", buf.String()), nil
 	}
-	return s.handleSubscribeHTTP(w, r, v, "text/event-stream", encoder)
+	return s.handleSubscribeHTTP(w, r, v, "text/event-stream", subscriberTransportSSE, encoder)
 }
 
 func (s *Server) handleSubscribeRaw(w http.ResponseWriter, r *http.Request, v *visitor) error {
@@ -1168,10 +1948,68 @@ func (s *Server) handleSubscribeRaw(w http.ResponseWriter, r *http.Request, v *v
 		}
 		return "\n", nil // "keepalive" and "open" events just send an empty line
 	}
-	return s.handleSubscribeHTTP(w, r, v, "text/plain", encoder)
+	return s.handleSubscribeHTTP(w, r, v, "text/plain", subscriberTransportRaw, encoder)
+}
+
+// limitSubscriberBacklog wraps the subscriber function of a connection, and keeps track of the number of messages that
+// are waiting to be written to it. Since topic.Publish calls the subscriber function in its own Go routine for every
+// message, a client that stops reading would otherwise pile up blocked Go routines (and messages) during a burst.
+// If more than subscriber-backlog-limit messages are waiting, the subscriber is slow, and subscriber-slow-action applies.
+// When polling, a poll request is sent for each topic that had messages skipped, once the subscriber caught up.
+func (s *Server) limitSubscriberBacklog(v *visitor, r *http.Request, sub subscriber, cancel context.CancelFunc) subscriber {
+	if s.config.SubscriberBacklogLimit <= 0 {
+		return sub
+	}
+	var backlog atomic.Int32
+	var dropped sync.Once
+	var mu sync.Mutex
+	skipped := make(map[string]bool) // Topics with skipped messages, protected by mu
+	return func(v *visitor, msg *message) error {
+		if backlog.Add(1) > int32(s.config.SubscriberBacklogLimit) {
+			backlog.Add(-1)
+			if s.config.SubscriberSlowAction == SubscriberSlowActionPoll {
+				mu.Lock()
+				first := len(skipped) == 0
+				skipped[msg.Topic] = true
+				mu.Unlock()
+				if first {
+					logvr(v, r).Tag(tagSubscribe).Info("Subscriber too slow, skipping messages until it caught up")
+					minc(metricSubscribersSlowDowngraded)
+				}
+				return nil
+			}
+			dropped.Do(func() {
+				logvr(v, r).Tag(tagSubscribe).Info("Subscriber too slow, closing connection")
+				minc(metricSubscribersSlowDropped)
+				cancel()
+			})
+			return nil
+		}
+		madd(metricSubscriberBacklog, 1)
+		err := sub(v, msg)
+		madd(metricSubscriberBacklog, -1)
+		if backlog.Add(-1) == 0 && err == nil {
+			mu.Lock()
+			topics := make([]string, 0, len(skipped))
+			for topic := range skipped {
+				topics = append(topics, topic)
+			}
+			clear(skipped)
+			mu.Unlock()
+			if len(topics) > 0 {
+				logvr(v, r).Tag(tagSubscribe).Debug("Subscriber caught up, sending poll request(s) for %d topic(s)", len(topics))
+			}
+			for _, topic := range topics {
+				if err := sub(v, newPollRequestMessage(topic, "")); err != nil {
+					return err
+				}
+			}
+		}
+		return err
+	}
 }
 
-func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *visitor, contentType string, encoder messageEncoder) error {
+func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *visitor, contentType, transport string, encoder messageEncoder) error {
 	logvr(v, r).Tag(tagSubscribe).Debug("HTTP stream connection opened")
 	defer logvr(v, r).Tag(tagSubscribe).Debug("HTTP stream connection closed")
 	if !v.SubscriptionAllowed() {
@@ -1182,7 +2020,11 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 	if err != nil {
 		return err
 	}
-	poll, since, scheduled, filters, rateTopics, err := parseSubscribeParams(r)
+	poll, since, scheduled, filters, rateTopics, presence, err := parseSubscribeParams(r)
+	if err != nil {
+		return err
+	}
+	keepalive, err := s.keepaliveInterval(r)
 	if err != nil {
 		return err
 	}
@@ -1215,19 +2057,23 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 	if err := s.maybeSetRateVisitors(r, v, topics, rateTopics); err != nil {
 		return err
 	}
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
-	w.Header().Set("Content-Type", contentType+"; charset=utf-8")                    // Android/Volley client needs charset!
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8") // Android/Volley client needs charset!
 	if poll {
 		for _, t := range topics {
-			t.Keepalive()
+			t.Polled(v.Identity())
 		}
 		return s.sendOldMessages(topics, since, scheduled, v, sub)
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	subscriberIDs := make([]int, 0)
+	backlogSub := s.limitSubscriberBacklog(v, r, sub, cancel)
 	for _, t := range topics {
-		subscriberIDs = append(subscriberIDs, t.Subscribe(sub, v.MaybeUserID(), cancel))
+		subscriberID := t.Subscribe(backlogSub, v.MaybeUserID(), transport, cancel)
+		if presence != "" {
+			t.Announce(subscriberID, presence)
+		}
+		subscriberIDs = append(subscriberIDs, subscriberID)
 	}
 	defer func() {
 		for i, subscriberID := range subscriberIDs {
@@ -1246,7 +2092,10 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 			return nil
 		case <-r.Context().Done():
 			return nil
-		case <-time.After(s.config.KeepaliveInterval):
+		case <-s.shutdownChan:
+			logvr(v, r).Tag(tagSubscribe).Trace("Server is shutting down, telling subscriber to reconnect")
+			return sub(v, newReconnectMessage(topicsStr))
+		case <-time.After(keepalive):
 			ev := logvr(v, r).Tag(tagSubscribe)
 			if len(topics) == 1 {
 				ev.With(topics[0]).Trace("Sending keepalive message to %s", topics[0].ID)
@@ -1278,7 +2127,11 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 	if err != nil {
 		return err
 	}
-	poll, since, scheduled, filters, rateTopics, err := parseSubscribeParams(r)
+	poll, since, scheduled, filters, rateTopics, presence, err := parseSubscribeParams(r)
+	if err != nil {
+		return err
+	}
+	keepalive, err := s.keepaliveInterval(r)
 	if err != nil {
 		return err
 	}
@@ -1286,7 +2139,7 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 		ReadBufferSize:  wsBufferSize,
 		WriteBufferSize: wsBufferSize,
 		CheckOrigin: func(r *http.Request) bool {
-			return true // We're open for business!
+			return s.corsOriginAllowed(r) // We're open for business, unless origins are restricted
 		},
 	}
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -1303,7 +2156,7 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 	var wlock sync.Mutex
 	g, gctx := errgroup.WithContext(cancelCtx)
 	g.Go(func() error {
-		pongWait := s.config.KeepaliveInterval + wsPongWait
+		pongWait := keepalive + wsPongWait
 		conn.SetReadLimit(wsReadLimit)
 		if err := conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
 			return err
@@ -1334,6 +2187,21 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 			logvr(v, r).Tag(tagWebsocket).Trace("Sending WebSocket ping")
 			return conn.WriteMessage(websocket.PingMessage, nil)
 		}
+		reconnect := func() error {
+			wlock.Lock()
+			defer wlock.Unlock()
+			if err := conn.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
+				return err
+			}
+			if err := conn.WriteJSON(newReconnectMessage(topicsStr)); err != nil {
+				return err
+			}
+			closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down")
+			if err := conn.WriteMessage(websocket.CloseMessage, closeMessage); err != nil {
+				return err
+			}
+			return &websocket.CloseError{Code: websocket.CloseGoingAway, Text: "server is shutting down"}
+		}
 		for {
 			select {
 			case <-gctx.Done():
@@ -1342,7 +2210,10 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 				logvr(v, r).Tag(tagWebsocket).Trace("Cancel received, closing subscriber connection")
 				conn.Close()
 				return &websocket.CloseError{Code: websocket.CloseNormalClosure, Text: "subscription was canceled"}
-			case <-time.After(s.config.KeepaliveInterval):
+			case <-s.shutdownChan:
+				logvr(v, r).Tag(tagWebsocket).Trace("Server is shutting down, telling subscriber to reconnect")
+				return reconnect()
+			case <-time.After(keepalive):
 				v.Keepalive()
 				for _, t := range topics {
 					t.Keepalive()
@@ -1367,16 +2238,20 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 	if err := s.maybeSetRateVisitors(r, v, topics, rateTopics); err != nil {
 		return err
 	}
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
 	if poll {
 		for _, t := range topics {
-			t.Keepalive()
+			t.Polled(v.Identity())
 		}
 		return s.sendOldMessages(topics, since, scheduled, v, sub)
 	}
 	subscriberIDs := make([]int, 0)
+	backlogSub := s.limitSubscriberBacklog(v, r, sub, cancel)
 	for _, t := range topics {
-		subscriberIDs = append(subscriberIDs, t.Subscribe(sub, v.MaybeUserID(), cancel))
+		subscriberID := t.Subscribe(backlogSub, v.MaybeUserID(), subscriberTransportWS, cancel)
+		if presence != "" {
+			t.Announce(subscriberID, presence)
+		}
+		subscriberIDs = append(subscriberIDs, subscriberID)
 	}
 	defer func() {
 		for i, subscriberID := range subscriberIDs {
@@ -1397,7 +2272,7 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 	return err
 }
 
-func parseSubscribeParams(r *http.Request) (poll bool, since sinceMarker, scheduled bool, filters *queryFilter, rateTopics []string, err error) {
+func parseSubscribeParams(r *http.Request) (poll bool, since sinceMarker, scheduled bool, filters *queryFilter, rateTopics []string, presence string, err error) {
 	poll = readBoolParam(r, false, "x-poll", "poll", "po")
 	scheduled = readBoolParam(r, false, "x-scheduled", "scheduled", "sched")
 	since, err = parseSince(r, poll)
@@ -1409,9 +2284,45 @@ func parseSubscribeParams(r *http.Request) (poll bool, since sinceMarker, schedu
 		return
 	}
 	rateTopics = readCommaSeparatedParam(r, "x-rate-topics", "rate-topics")
+	presence = readParam(r, "x-presence", "presence")
+	if presence != "" && !presenceLabelRegex.MatchString(presence) {
+		err = errHTTPBadRequestPresenceLabelInvalid
+	}
 	return
 }
 
+// keepaliveInterval returns the keepalive interval of a subscriber connection. Clients may request a different
+// interval via the "keepalive" parameter (e.g. 15s behind aggressive proxies, or 5m to save battery). The requested
+// interval is capped to the keepalive-interval-min/keepalive-interval-max range, which always includes the default.
+func (s *Server) keepaliveInterval(r *http.Request) (time.Duration, error) {
+	str := readParam(r, "x-keepalive", "keepalive", "ka")
+	if str == "" {
+		return s.config.KeepaliveInterval, nil
+	}
+	interval, err := util.ParseDuration(str)
+	if err != nil || interval <= 0 {
+		return 0, errHTTPBadRequestKeepaliveInvalid
+	}
+	lower := min(s.config.KeepaliveIntervalMin, s.config.KeepaliveInterval)
+	upper := max(s.config.KeepaliveIntervalMax, s.config.KeepaliveInterval)
+	return min(max(interval, lower), upper), nil
+}
+
+// sseRetryInterval returns the reconnection time that is suggested to SSE clients via the "retry:" field, as
+// requested via the "retry" parameter, capped to the sseRetryMin/sse-retry-max range. If it is zero, no "retry:"
+// field is sent, and the browser's default applies.
+func (s *Server) sseRetryInterval(r *http.Request) (time.Duration, error) {
+	str := readParam(r, "x-retry", "retry")
+	if str == "" {
+		return 0, nil
+	}
+	retry, err := util.ParseDuration(str)
+	if err != nil || retry <= 0 {
+		return 0, errHTTPBadRequestRetryInvalid
+	}
+	return min(max(retry, sseRetryMin), max(s.config.SSERetryMax, sseRetryMin)), nil
+}
+
 // maybeSetRateVisitors sets the rate visitor on a topic (v.SetRateVisitor), indicating that all messages published
 // to that topic will be rate limited against the rate visitor instead of the publishing visitor.
 //
@@ -1505,8 +2416,11 @@ func (s *Server) sendOldMessages(topics []*topic, since sinceMarker, scheduled b
 // Values in the "since=..." parameter can be either a unix timestamp or a duration (e.g. 12h), or
 // "all" for all messages.
 func parseSince(r *http.Request, poll bool) (sinceMarker, error) {
-	since := readParam(r, "x-since", "since", "si")
+	return parseSinceString(readParam(r, "x-since", "since", "si"), poll)
+}
 
+// parseSinceString parses a since value, see parseSince
+func parseSinceString(since string, poll bool) (sinceMarker, error) {
 	// Easy cases (empty, all, none)
 	if since == "" {
 		if poll {
@@ -1530,13 +2444,6 @@ func parseSince(r *http.Request, poll bool) (sinceMarker, error) {
 	return sinceNoMessages, errHTTPBadRequestSinceInvalid
 }
 
-func (s *Server) handleOptions(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
-	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, PATCH, DELETE")
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
-	w.Header().Set("Access-Control-Allow-Headers", "*")                              // CORS, allow auth via JS // FIXME is this terrible?
-	return nil
-}
-
 // topicFromPath returns the topic from a root path (e.g. /mytopic), creating it if it doesn't exist.
 func (s *Server) topicFromPath(path string) (*topic, error) {
 	parts := strings.Split(path, "/")
@@ -1607,16 +2514,30 @@ func (s *Server) topicsFromPattern(pattern string) ([]*topic, error) {
 }
 
 func (s *Server) runSMTPServer() error {
-	s.smtpServerBackend = newMailBackend(s.config, s.handle)
+	s.smtpServerBackend = newMailBackend(s.config, s.handle, s.markEmailsUndeliverable)
 	s.smtpServer = smtp.NewServer(s.smtpServerBackend)
 	s.smtpServer.Addr = s.config.SMTPServerListen
 	s.smtpServer.Domain = s.config.SMTPServerDomain
 	s.smtpServer.ReadTimeout = 10 * time.Second
 	s.smtpServer.WriteTimeout = 10 * time.Second
-	s.smtpServer.MaxMessageBytes = 1024 * 1024 // Must be much larger than message size (headers, multipart, etc.)
+	s.smtpServer.MaxMessageBytes = smtpServerMaxMessageBytes(s.config)
 	s.smtpServer.MaxRecipients = 1
 	s.smtpServer.AllowInsecureAuth = true
-	return s.smtpServer.ListenAndServe()
+	ln, err := s.listenTCP(s.config.SMTPServerListen)
+	if err != nil {
+		return err
+	}
+	return s.smtpServer.Serve(ln)
+}
+
+// listenTCP opens a TCP listener on the given address. If Config.ListenReusePort is set, SO_REUSEPORT is set
+// on the socket, so that a new ntfy process can take over the address while this one is still draining.
+func (s *Server) listenTCP(addr string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if s.config.ListenReusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
 }
 
 func (s *Server) runManager() {
@@ -1696,6 +2617,12 @@ func (s *Server) runDelayedSender() {
 			if err := s.sendDelayedMessages(); err != nil {
 				log.Tag(tagPublish).Err(err).Warn("Error sending delayed messages")
 			}
+			if err := s.escalateMessages(); err != nil {
+				log.Tag(tagPublish).Err(err).Warn("Error escalating messages")
+			}
+			if err := s.checkHeartbeats(); err != nil {
+				log.Tag(tagPublish).Err(err).Warn("Error checking heartbeats")
+			}
 		case <-s.closeChan:
 			return
 		}
@@ -1725,10 +2652,21 @@ func (s *Server) sendDelayedMessages() error {
 }
 
 func (s *Server) sendDelayedMessage(v *visitor, m *message) error {
+	if suppressed, err := s.suppressedByMaintenance(m); err != nil {
+		logvm(v, m).Err(err).Warn("Unable to check maintenance windows of delayed message")
+	} else if suppressed {
+		logvm(v, m).Info("Delayed message suppressed by maintenance window, not delivering it")
+		return s.messageCache.MarkPublished(m)
+	}
 	logvm(v, m).Debug("Sending delayed message")
 	s.mu.RLock()
 	t, ok := s.topics[m.Topic] // If no subscribers, just mark message as published
 	s.mu.RUnlock()
+	subscribers := 0
+	if ok {
+		subscribers, _ = t.Stats()
+	}
+	s.recordDelivery(m, deliveryChannelSubscribers, subscribers, nil)
 	if ok {
 		go func() {
 			// We do not rate-limit messages here, since we've rate limited them in the PUT/POST handler
@@ -1737,18 +2675,18 @@ func (s *Server) sendDelayedMessage(v *visitor, m *message) error {
 			}
 		}()
 	}
-	if s.firebaseClient != nil { // Firebase subscribers may not show up in topics map
-		go s.sendToFirebase(v, m)
-	}
-	if s.config.UpstreamBaseURL != "" {
-		go s.forwardPollRequest(v, m)
-	}
-	if s.config.WebPushPublicKey != "" {
-		go s.publishToWebPushEndpoints(v, m)
+	push := true
+	if route, err := s.routeMessage(m); err != nil {
+		logvm(v, m).Err(err).Warn("Unable to route delayed message")
+	} else if route != nil {
+		push = route.push // Only the push rule applies to delayed messages
 	}
+	s.publishToPushChannels(v, m, push, push, true) // Firebase subscribers may not show up in topics map
 	if err := s.messageCache.MarkPublished(m); err != nil {
 		return err
 	}
+	s.forwardMessage(v, m)
+	s.scheduleEscalation(v, m)
 	return nil
 }
 
@@ -1756,10 +2694,13 @@ func (s *Server) sendDelayedMessage(v *visitor, m *message) error {
 // before passing it on to the next handler. This is meant to be used in combination with handlePublish.
 func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		m, err := readJSONWithLimit[publishMessage](r.Body, s.config.MessageLimit*2, false) // 2x to account for JSON format overhead
+		m, err := readJSONWithLimit[publishMessage](r.Body, int(v.Limits().MessageSizeLimit)*2, false) // 2x to account for JSON format overhead
 		if err != nil {
 			return err
 		}
+		if m.Topic == "" {
+			m.Topic = s.publishKeyTopic(r) // Topic may be omitted when publishing with a publish key
+		}
 		if !topicRegex.MatchString(m.Topic) {
 			return errHTTPBadRequestTopicInvalid
 		}
@@ -1780,6 +2721,9 @@ func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
 		if m.Attach != "" {
 			r.Header.Set("X-Attach", m.Attach)
 		}
+		if m.AttachmentID != "" {
+			r.Header.Set("X-Attachment-ID", m.AttachmentID)
+		}
 		if m.Filename != "" {
 			r.Header.Set("X-Filename", m.Filename)
 		}
@@ -1789,6 +2733,21 @@ func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
 		if m.Icon != "" {
 			r.Header.Set("X-Icon", m.Icon)
 		}
+		if m.Sound != "" {
+			r.Header.Set("X-Sound", m.Sound)
+		}
+		if m.InReplyTo != "" {
+			r.Header.Set("X-In-Reply-To", m.InReplyTo)
+		}
+		if m.Location != nil {
+			r.Header.Set("X-Location", m.Location.String())
+		}
+		if m.Hostname != "" {
+			r.Header.Set("X-Hostname", m.Hostname)
+		}
+		if m.CorrelationID != "" {
+			r.Header.Set("X-Correlation-ID", m.CorrelationID)
+		}
 		if m.Markdown {
 			r.Header.Set("X-Markdown", "yes")
 		}
@@ -1818,12 +2777,16 @@ func (s *Server) transformMatrixJSON(next handleFunc) handleFunc {
 		if err != nil {
 			logvr(v, r).Tag(tagMatrix).Err(err).Debug("Invalid Matrix request")
 			if e, ok := err.(*errMatrixPushkeyRejected); ok {
+				s.matrixStats.Rejected(e.rejectedPushKey, e.appID, e.Error())
 				return writeMatrixResponse(w, e.rejectedPushKey)
 			}
 			return err
 		}
 		if err := next(w, newRequest, v); err != nil {
 			logvr(v, r).Tag(tagMatrix).Err(err).Debug("Error handling Matrix request")
+			pushKey, _ := fromContext[string](newRequest, contextMatrixPushKey)
+			appID, _ := fromContext[string](newRequest, contextMatrixAppID)
+			s.matrixStats.Failed(pushKey, appID, matrixFailureReason(err))
 			return err
 		}
 		return nil
@@ -1840,16 +2803,24 @@ func (s *Server) authorizeTopicRead(next handleFunc) handleFunc {
 
 func (s *Server) autorizeTopic(next handleFunc, perm user.Permission) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		if s.userManager == nil {
-			return next(w, r, v)
-		}
 		topics, _, err := s.topicsFromPath(r.URL.Path)
 		if err != nil {
 			return err
 		}
+		for _, t := range topics {
+			if s.topicBlocked(t.ID) {
+				return errHTTPForbiddenTopicBlocked.With(t)
+			}
+		}
+		if s.userManager == nil {
+			return next(w, r, v)
+		}
 		u := v.User()
 		for _, t := range topics {
 			if err := s.userManager.Authorize(u, t.ID, perm); err != nil {
+				if perm == user.PermissionWrite && s.authorizePublishKey(r, v, t) {
+					continue
+				}
 				logvr(v, r).With(t).Err(err).Debug("Access to topic %s not authorized", t.ID)
 				return errHTTPForbidden.With(t)
 			}
@@ -1858,6 +2829,93 @@ func (s *Server) autorizeTopic(next handleFunc, perm user.Permission) handleFunc
 	}
 }
 
+// transformPublishKeyBody allows publishing a plain (non-JSON) message body to the root path with a publish key.
+// The topic is taken from the key, so that simple devices only need to know the server URL and the key. All other
+// requests are passed on to the next handler. This is meant to be used in combination with transformBodyJSON.
+func (s *Server) transformPublishKeyBody(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.userManager == nil || readPublishKey(r) == "" {
+			return next(w, r, v)
+		}
+		body, err := util.Peek(r.Body, publishKeyBodyPeekBytes)
+		if err != nil {
+			return err
+		}
+		r.Body = body
+		if strings.HasPrefix(strings.TrimSpace(string(body.PeekedBytes)), "{") {
+			return next(w, r, v)
+		}
+		topic := s.publishKeyTopic(r)
+		if topic == "" {
+			s.authFailed(r, v, "", errAuthPublishKeyInvalid)
+			return errHTTPUnauthorized
+		}
+		r.URL.Path = "/" + topic
+		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.verifyWebhookSignature(s.handlePublish)))(w, r, v)
+	}
+}
+
+// publishKeyTopic returns the topic of the publish key the request carries, or an empty string if there is
+// no valid publish key
+func (s *Server) publishKeyTopic(r *http.Request) string {
+	key := readPublishKey(r)
+	if s.userManager == nil || key == "" {
+		return ""
+	}
+	publishKey, err := s.userManager.PublishKey(key)
+	if err != nil {
+		return ""
+	}
+	return publishKey.Topic
+}
+
+// applyPublishKeyDefaults applies the message defaults of the publish key the request carries (if any), see
+// user.PublishKeyDefaults. Attributes set by the publisher take precedence, tags are merged. The defaults are
+// only applied if the key is valid for the message's topic.
+func (s *Server) applyPublishKeyDefaults(r *http.Request, m *message) error {
+	key := readPublishKey(r)
+	if key == "" {
+		return nil
+	}
+	publishKey, err := s.userManager.PublishKey(key)
+	if errors.Is(err, user.ErrUnauthorized) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if publishKey.Topic != m.Topic || publishKey.Defaults == nil {
+		return nil
+	}
+	defaults := publishKey.Defaults
+	if defaults.TitlePrefix != "" && m.Title != "" {
+		m.Title = defaults.TitlePrefix + " " + m.Title
+	} else if defaults.TitlePrefix != "" {
+		m.Title = defaults.TitlePrefix
+	}
+	if m.Priority == 0 {
+		m.Priority = defaults.Priority
+	}
+	for _, tag := range defaults.Tags {
+		if !util.Contains(m.Tags, tag) {
+			m.Tags = append(m.Tags, tag)
+		}
+	}
+	return nil
+}
+
+// authorizePublishKey returns true if the request carries a publish key (see readPublishKey) that allows
+// publishing to the given topic. Invalid keys count as auth failures for the visitor.
+func (s *Server) authorizePublishKey(r *http.Request, v *visitor, t *topic) bool {
+	key := readPublishKey(r)
+	if key == "" {
+		return false
+	} else if err := s.userManager.AuthorizePublishKey(key, t.ID); err != nil {
+		logvr(v, r).With(t).Err(err).Debug("Publish key not valid for topic %s", t.ID)
+		s.authFailed(r, v, "", errAuthPublishKeyInvalid)
+		return false
+	}
+	return true
+}
+
 // maybeAuthenticate reads the "Authorization" header and will try to authenticate the user
 // if it is set.
 //
@@ -1885,15 +2943,29 @@ func (s *Server) maybeAuthenticate(r *http.Request) (*visitor, error) {
 	// If we're trying to auth, check the rate limiter first
 	if !vip.AuthAllowed() {
 		return vip, errHTTPTooManyRequestsLimitAuthFailure // Always return visitor, even when error occurs!
+	} else if readPublishKey(r) != "" {
+		return vip, nil // Publish keys are not tied to a user, they are checked in authorizeTopicWrite
+	} else if strings.HasPrefix(r.URL.Path, scimPathPrefix) {
+		return vip, nil // SCIM requests use their own bearer token, it is checked in ensureSCIM
 	}
 	u, err := s.authenticate(r, header)
 	if err != nil {
-		vip.AuthFailed()
+		username, _, _ := parseBasicAuth(header)
+		s.authFailed(r, vip, username, err)
 		logr(r).Err(err).Debug("Authentication failed")
+		if errors.Is(err, user.ErrTokenOriginNotAllowed) {
+			return vip, errHTTPForbiddenTokenOriginNotAllowed
+		}
 		return vip, errHTTPUnauthorized // Always return visitor, even when error occurs!
 	}
 	// Authentication with user was successful
-	return s.visitor(ip, u), nil
+	v := s.visitor(ip, u)
+	if u.Impersonator != "" {
+		logvr(v, r).
+			Tag(tagImpersonation).
+			Info("Admin %s is impersonating user %s: %s %s", u.Impersonator, u.Name, r.Method, accessLogURI(r))
+	}
+	return v, nil
 }
 
 // authenticate a user based on basic auth username/password (Authorization: Basic ...), or token auth (Authorization: Bearer ...).
@@ -1901,8 +2973,8 @@ func (s *Server) maybeAuthenticate(r *http.Request) (*visitor, error) {
 // support the WebSocket JavaScript class, which does not support passing headers during the initial request. The auth
 // query param is effectively doubly base64 encoded. Its format is base64(Basic base64(user:pass)).
 func (s *Server) authenticate(r *http.Request, header string) (user *user.User, err error) {
-	if strings.HasPrefix(header, "Bearer") {
-		return s.authenticateBearerAuth(r, strings.TrimSpace(strings.TrimPrefix(header, "Bearer")))
+	if scheme, value, _ := strings.Cut(header, " "); strings.EqualFold(scheme, "Bearer") {
+		return s.authenticateBearerAuth(r, strings.TrimSpace(value))
 	}
 	return s.authenticateBasicAuth(r, header)
 }
@@ -1930,6 +3002,31 @@ func supportedAuthHeader(value string) bool {
 	return strings.HasPrefix(value, "basic ") || strings.HasPrefix(value, "bearer ")
 }
 
+// readPublishKey returns the publish key passed in the Authorization header (or ?auth=... query param), or an
+// empty string if there is none. Like access tokens, publish keys can be passed as Bearer token, or as password
+// with an empty username in Basic auth.
+func readPublishKey(r *http.Request) string {
+	header, err := readAuthHeader(r)
+	if err != nil {
+		return ""
+	}
+	var key string
+	scheme, value, _ := strings.Cut(header, " ")
+	if strings.EqualFold(scheme, "Bearer") {
+		key = strings.TrimSpace(value)
+	} else if strings.EqualFold(scheme, "Basic") {
+		username, password, ok := parseBasicAuth(header)
+		if !ok || username != "" {
+			return ""
+		}
+		key = password
+	}
+	if !strings.HasPrefix(key, user.PublishKeyPrefix) {
+		return ""
+	}
+	return key
+}
+
 func (s *Server) authenticateBasicAuth(r *http.Request, value string) (user *user.User, err error) {
 	r.Header.Set("Authorization", value)
 	username, password, ok := r.BasicAuth()
@@ -1942,11 +3039,11 @@ func (s *Server) authenticateBasicAuth(r *http.Request, value string) (user *use
 }
 
 func (s *Server) authenticateBearerAuth(r *http.Request, token string) (*user.User, error) {
-	u, err := s.userManager.AuthenticateToken(token)
+	ip := extractIPAddress(r, s.config.BehindProxy)
+	u, err := s.userManager.AuthenticateToken(token, ip)
 	if err != nil {
 		return nil, err
 	}
-	ip := extractIPAddress(r, s.config.BehindProxy)
 	go s.userManager.EnqueueTokenUpdate(token, &user.TokenUpdate{
 		LastAccess: time.Now(),
 		LastOrigin: ip,
@@ -1968,13 +3065,20 @@ func (s *Server) visitor(ip netip.Addr, user *user.User) *visitor {
 	return v
 }
 
+// requestLimitExempt returns true if the visitor is exempt from request and message rate limiting, either because
+// its IP address is listed in visitor-request-limit-exempt-hosts, because the request was received via the Unix
+// socket, or because an admin exempted the visitor's host or user at runtime (see handleRateLimitExemptionsAdd and
+// handleVisitorsExempt). Only processes on the same host can connect to the socket, so they are considered trusted.
+func (s *Server) requestLimitExempt(r *http.Request, v *visitor) bool {
+	return isUnixSocketRequest(r, s.config.BehindProxy) || util.ContainsIP(s.config.VisitorRequestExemptIPAddrs, v.ip) || s.rateLimitExempt(v) || v.Exempt()
+}
+
 func (s *Server) writeJSON(w http.ResponseWriter, v any) error {
 	return s.writeJSONWithContentType(w, v, "application/json")
 }
 
 func (s *Server) writeJSONWithContentType(w http.ResponseWriter, v any, contentType string) error {
 	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
 	if err := json.NewEncoder(w).Encode(v); err != nil {
 		return err
 	}
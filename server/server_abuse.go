@@ -0,0 +1,372 @@
+package server
+
+import (
+	"fmt"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+const (
+	reportIDPrefix          = "rp_"
+	reportIDLength          = 12
+	reportReasonLengthLimit = 1024
+)
+
+// Status of an abuse report
+const (
+	reportStatusOpen      = "open"
+	reportStatusResolved  = "resolved"
+	reportStatusDismissed = "dismissed"
+)
+
+// Actions an admin can take on an abuse report
+const (
+	reportActionDismiss       = "dismiss"        // Close the report without doing anything
+	reportActionDeleteMessage = "delete_message" // Delete the reported message
+	reportActionBanIP         = "ban_ip"         // Ban the IP address of the publisher of the reported message
+	reportActionBlockTopic    = "block_topic"    // Block the reported topic, and delete all of its messages
+)
+
+// handleReport lets recipients report a topic or a specific message for abuse. Reporters need read
+// access to the topic. If a message ID is given, a snapshot of the message and its publisher is stored.
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiReportRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	} else if len(req.Reason) > reportReasonLengthLimit {
+		return errHTTPBadRequestReportReasonTooLong
+	}
+	if s.userManager != nil {
+		if err := s.userManager.Authorize(v.User(), req.Topic, user.PermissionRead); err != nil {
+			return errHTTPForbidden
+		}
+	}
+	rep := &report{
+		ID:           util.RandomStringPrefix(reportIDPrefix, reportIDLength),
+		Time:         time.Now().Unix(),
+		Topic:        req.Topic,
+		Reason:       req.Reason,
+		ReporterIP:   v.IP(),
+		ReporterUser: v.MaybeUserID(),
+		Status:       reportStatusOpen,
+		Actions:      make([]string, 0),
+	}
+	if req.MessageID != "" {
+		m, err := s.messageCache.Message(req.MessageID)
+		if err == errMessageNotFound || (err == nil && m.Topic != req.Topic) {
+			return errHTTPNotFound
+		} else if err != nil {
+			return err
+		}
+		rep.MessageID = m.ID
+		rep.Title = m.Title
+		rep.Message = m.Message
+		rep.Sender = m.Sender
+		rep.User = m.User
+	}
+	if err := s.messageCache.AddReport(rep); err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAbuse).
+		Fields(log.Context{
+			"report_id":         rep.ID,
+			"report_topic":      rep.Topic,
+			"report_message_id": rep.MessageID,
+		}).
+		Info("Abuse report %s received for topic %s", rep.ID, rep.Topic)
+	return s.writeJSON(w, &apiReportResponse{ID: rep.ID})
+}
+
+// handleReportsGet returns all abuse reports, optionally filtered by status (admin only)
+func (s *Server) handleReportsGet(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	reports, err := s.messageCache.Reports(readQueryParam(r, "status"))
+	if err != nil {
+		return err
+	}
+	response := make([]*apiReport, len(reports))
+	for i, rep := range reports {
+		response[i] = s.toAPIReport(rep)
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleReportAction lets an admin act on an abuse report, see reportAction* constants
+func (s *Server) handleReportAction(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiReportActionRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	rep, err := s.messageCache.Report(apiReportSingleRegex.FindStringSubmatch(r.URL.Path)[1])
+	if err == errReportNotFound {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	reason := fmt.Sprintf("abuse report %s", rep.ID)
+	status := reportStatusResolved
+	switch req.Action {
+	case reportActionDismiss:
+		status = reportStatusDismissed
+	case reportActionDeleteMessage:
+		if rep.MessageID == "" {
+			return errHTTPBadRequestReportActionInvalid.Wrap("report does not refer to a message")
+		} else if err := s.messageCache.DeleteMessages(rep.MessageID); err != nil {
+			return err
+		}
+	case reportActionBanIP:
+		if !rep.Sender.IsValid() {
+			return errHTTPBadRequestReportActionInvalid.Wrap("IP address of the publisher is unknown")
+		}
+		var expires time.Time
+		if req.Duration != "" {
+			duration, err := util.ParseDuration(req.Duration)
+			if err != nil {
+				return errHTTPBadRequestReportActionInvalid.Wrap("invalid duration %s", req.Duration)
+			}
+			expires = time.Now().Add(duration)
+		}
+		if err := s.banIP(rep.Sender, expires, reason); err != nil {
+			return err
+		}
+	case reportActionBlockTopic:
+		if err := s.blockTopic(rep.Topic, reason); err != nil {
+			return err
+		}
+	default:
+		return errHTTPBadRequestReportActionInvalid
+	}
+	actions := append(rep.Actions, req.Action)
+	if err := s.messageCache.UpdateReport(rep.ID, status, actions); err != nil {
+		return err
+	}
+	rep.Status, rep.Actions = status, actions
+	logvr(v, r).
+		Tag(tagAbuse).
+		Fields(log.Context{
+			"report_id":     rep.ID,
+			"report_action": req.Action,
+		}).
+		Info("Admin took action %s on abuse report %s", req.Action, rep.ID)
+	return s.writeJSON(w, s.toAPIReport(rep))
+}
+
+// handleBlocksGet returns all blocked topics and banned IP addresses (admin only)
+func (s *Server) handleBlocksGet(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	topics, err := s.messageCache.BlockedTopics()
+	if err != nil {
+		return err
+	}
+	bans, err := s.messageCache.BannedIPs()
+	if err != nil {
+		return err
+	}
+	response := &apiBlocksResponse{
+		Topics: make([]*apiBlockedTopic, 0),
+		IPs:    make([]*apiBannedIP, 0),
+	}
+	for _, t := range topics {
+		response.Topics = append(response.Topics, &apiBlockedTopic{
+			Topic:  t.Topic,
+			Time:   t.Time,
+			Reason: t.Reason,
+		})
+	}
+	for _, b := range bans {
+		if b.Expires > 0 && b.Expires <= time.Now().Unix() {
+			continue
+		}
+		response.IPs = append(response.IPs, &apiBannedIP{
+			IP:      b.IP.String(),
+			Time:    b.Time,
+			Expires: b.Expires,
+			Reason:  b.Reason,
+		})
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleBlocksDelete unblocks a topic, or lifts the ban of an IP address (admin only)
+func (s *Server) handleBlocksDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiBlocksDeleteRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	if req.Topic != "" {
+		if err := s.unblockTopic(req.Topic); err != nil {
+			return err
+		}
+		logvr(v, r).Tag(tagAbuse).Info("Admin unblocked topic %s", req.Topic)
+	} else if req.IP != "" {
+		ip, err := netip.ParseAddr(req.IP)
+		if err != nil {
+			return errHTTPBadRequest.Wrap("invalid IP address %s", req.IP)
+		} else if err := s.unbanIP(ip); err != nil {
+			return err
+		}
+		logvr(v, r).Tag(tagAbuse).Info("Admin lifted ban for IP address %s", ip.String())
+	} else {
+		return errHTTPBadRequest.Wrap("topic or ip must be set")
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) toAPIReport(rep *report) *apiReport {
+	response := &apiReport{
+		ID:        rep.ID,
+		Time:      rep.Time,
+		Topic:     rep.Topic,
+		MessageID: rep.MessageID,
+		Title:     rep.Title,
+		Message:   rep.Message,
+		Reason:    rep.Reason,
+		Status:    rep.Status,
+		Actions:   rep.Actions,
+	}
+	if rep.Sender.IsValid() {
+		response.Sender = rep.Sender.String()
+	}
+	if rep.ReporterIP.IsValid() {
+		response.ReporterIP = rep.ReporterIP.String()
+	}
+	response.User = s.usernameByID(rep.User)
+	response.ReporterUser = s.usernameByID(rep.ReporterUser)
+	return response
+}
+
+// usernameByID returns the name of the user with the given ID, or the ID itself if the user
+// does not exist (anymore). It returns an empty string if the ID is empty.
+func (s *Server) usernameByID(userID string) string {
+	if userID == "" || s.userManager == nil {
+		return userID
+	}
+	u, err := s.userManager.UserByID(userID)
+	if err != nil {
+		return userID
+	}
+	return u.Name
+}
+
+// loadBlocks loads the blocked topics and banned IP addresses from the database into memory
+func (s *Server) loadBlocks() error {
+	topics, err := s.messageCache.BlockedTopics()
+	if err != nil {
+		return err
+	}
+	bans, err := s.messageCache.BannedIPs()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blockedTopics = make(map[string]bool)
+	for _, t := range topics {
+		s.blockedTopics[t.Topic] = true
+	}
+	s.bannedIPs = make(map[netip.Addr]int64)
+	for _, b := range bans {
+		s.bannedIPs[b.IP] = b.Expires
+	}
+	return nil
+}
+
+// blockTopic blocks a topic, and deletes all of its messages. Nobody can publish or subscribe
+// to a blocked topic until it is unblocked.
+func (s *Server) blockTopic(topic, reason string) error {
+	if err := s.messageCache.BlockTopic(topic, reason); err != nil {
+		return err
+	} else if err := s.messageCache.ExpireMessages(topic); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blockedTopics[topic] = true
+	return nil
+}
+
+func (s *Server) unblockTopic(topic string) error {
+	if err := s.messageCache.UnblockTopic(topic); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blockedTopics, topic)
+	return nil
+}
+
+// topicBlocked returns true if the given topic has been blocked by an admin
+func (s *Server) topicBlocked(topic string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.blockedTopics[topic]
+}
+
+// banIP bans an IP address until expires, or forever if expires is zero. Banned IP addresses
+// cannot use the server at all, unless they authenticate as an admin.
+func (s *Server) banIP(ip netip.Addr, expires time.Time, reason string) error {
+	if err := s.messageCache.BanIP(ip, expires, reason); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if expires.IsZero() {
+		s.bannedIPs[ip] = 0
+	} else {
+		s.bannedIPs[ip] = expires.Unix()
+	}
+	return nil
+}
+
+func (s *Server) unbanIP(ip netip.Addr) error {
+	if err := s.messageCache.UnbanIP(ip); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.bannedIPs, ip)
+	return nil
+}
+
+// ipBanned returns true if the given IP address is currently banned
+func (s *Server) ipBanned(ip netip.Addr) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	expires, ok := s.bannedIPs[ip]
+	return ok && (expires == 0 || expires > time.Now().Unix())
+}
+
+// handleMessagesPrune deletes all messages of a topic published before the given time, and their attachments,
+// e.g. to clean up after sensitive data was leaked into a topic (admin only). See also "ntfy cache prune".
+func (s *Server) handleMessagesPrune(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiMessagesPruneRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	var before time.Time // All messages
+	if req.Before != "" {
+		before, err = util.ParsePastTime(req.Before, time.Now())
+		if err != nil {
+			return errHTTPBadRequest.Wrap("invalid time %s", req.Before)
+		}
+	}
+	deleted, err := pruneTopicMessages(s.messageCache, s.fileCache, req.Topic, before)
+	if err != nil {
+		return err
+	}
+	if before.IsZero() {
+		logvr(v, r).Tag(tagAbuse).Info("Admin deleted all %d message(s) of topic %s", deleted, req.Topic)
+	} else {
+		logvr(v, r).Tag(tagAbuse).Info("Admin deleted %d message(s) of topic %s published before %s", deleted, req.Topic, before.Format(time.RFC3339))
+	}
+	return s.writeJSON(w, &apiMessagesPruneResponse{
+		Deleted: deleted,
+	})
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServer_Report_AndDeleteMessage(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	response := request(t, s, "PUT", "/mytopic", "buy cheap stuff", nil, func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4"
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Report message
+	response = request(t, s, "POST", "/v1/report", `{"topic":"mytopic","message_id":"`+m.ID+`","reason":"spam"}`, nil)
+	require.Equal(t, 200, response.Code)
+	rep, err := util.UnmarshalJSON[apiReportResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(rep.ID, "rp_"))
+
+	// Non-admins cannot list reports
+	response = request(t, s, "GET", "/v1/reports", "", nil)
+	require.Equal(t, 401, response.Code)
+
+	// Admin sees report, including the publisher
+	response = request(t, s, "GET", "/v1/reports?status=open", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	reports, err := util.UnmarshalJSON[[]*apiReport](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*reports))
+	require.Equal(t, rep.ID, (*reports)[0].ID)
+	require.Equal(t, "mytopic", (*reports)[0].Topic)
+	require.Equal(t, m.ID, (*reports)[0].MessageID)
+	require.Equal(t, "buy cheap stuff", (*reports)[0].Message)
+	require.Equal(t, "1.2.3.4", (*reports)[0].Sender)
+	require.Equal(t, "spam", (*reports)[0].Reason)
+	require.Equal(t, "9.9.9.9", (*reports)[0].ReporterIP)
+
+	// Delete the message
+	response = request(t, s, "POST", "/v1/reports/"+rep.ID, `{"action":"delete_message"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	updated, err := util.UnmarshalJSON[apiReport](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, reportStatusResolved, updated.Status)
+	require.Equal(t, []string{reportActionDeleteMessage}, updated.Actions)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, "", response.Body.String())
+
+	// No more open reports
+	response = request(t, s, "GET", "/v1/reports?status=open", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, "[]\n", response.Body.String())
+}
+
+func TestServer_Report_Invalid(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	response := request(t, s, "POST", "/v1/report", `{"topic":"not a topic!"}`, nil)
+	require.Equal(t, 40009, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/v1/report", `{"topic":"mytopic","reason":"`+strings.Repeat("x", 1025)+`"}`, nil)
+	require.Equal(t, 40047, toHTTPError(t, response.Body.String()).Code)
+
+	// No read access to topic
+	response = request(t, s, "POST", "/v1/report", `{"topic":"mytopic"}`, nil)
+	require.Equal(t, 403, response.Code)
+
+	// Unknown message
+	response = request(t, s, "POST", "/v1/report", `{"topic":"mytopic","message_id":"doesnotexist"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, response.Code)
+
+	// Invalid action, and message actions on topic reports
+	response = request(t, s, "POST", "/v1/report", `{"topic":"mytopic"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	rep, err := util.UnmarshalJSON[apiReportResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+
+	response = request(t, s, "POST", "/v1/reports/"+rep.ID, `{"action":"explode"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40048, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/v1/reports/"+rep.ID, `{"action":"ban_ip"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40048, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/v1/reports/rp_doesnotexist", `{"action":"dismiss"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, response.Code)
+
+	response = request(t, s, "POST", "/v1/reports/"+rep.ID, `{"action":"dismiss"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	updated, err := util.UnmarshalJSON[apiReport](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, reportStatusDismissed, updated.Status)
+}
+
+func TestServer_Report_BanIP(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	response := request(t, s, "PUT", "/mytopic", "spam spam spam", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "POST", "/v1/report", `{"topic":"mytopic","message_id":"`+m.ID+`"}`, nil)
+	require.Equal(t, 200, response.Code)
+	rep, err := util.UnmarshalJSON[apiReportResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+
+	response = request(t, s, "POST", "/v1/reports/"+rep.ID, `{"action":"ban_ip","duration":"1h"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Banned IP cannot do anything anymore, unless authenticated as admin
+	response = request(t, s, "PUT", "/mytopic", "more spam", nil)
+	require.Equal(t, 40304, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/v1/blocks", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	blocks, err := util.UnmarshalJSON[apiBlocksResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(blocks.IPs))
+	require.Equal(t, "9.9.9.9", blocks.IPs[0].IP)
+	require.Greater(t, blocks.IPs[0].Expires, blocks.IPs[0].Time)
+	require.Equal(t, "abuse report "+rep.ID, blocks.IPs[0].Reason)
+
+	// Ban survives a restart
+	require.Nil(t, s.loadBlocks())
+	require.True(t, s.ipBanned(netip.MustParseAddr("9.9.9.9")))
+
+	// Lift ban
+	response = request(t, s, "DELETE", "/v1/blocks", `{"ip":"9.9.9.9"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "PUT", "/mytopic", "sorry", nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_Report_BlockTopic(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	response := request(t, s, "PUT", "/badtopic", "illegal content", nil)
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "POST", "/v1/report", `{"topic":"badtopic","reason":"illegal"}`, nil)
+	require.Equal(t, 200, response.Code)
+	rep, err := util.UnmarshalJSON[apiReportResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+
+	response = request(t, s, "POST", "/v1/reports/"+rep.ID, `{"action":"block_topic"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Nobody can publish or subscribe anymore
+	response = request(t, s, "PUT", "/badtopic", "more", nil)
+	require.Equal(t, 40303, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "GET", "/badtopic/json?poll=1", "", nil)
+	require.Equal(t, 40303, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "GET", "/badtopic,othertopic/json?poll=1", "", nil)
+	require.Equal(t, 40303, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/v1/blocks", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	blocks, err := util.UnmarshalJSON[apiBlocksResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(blocks.Topics))
+	require.Equal(t, "badtopic", blocks.Topics[0].Topic)
+
+	// Unblock, messages are gone after the next manager run
+	s.execManager()
+	response = request(t, s, "DELETE", "/v1/blocks", `{"topic":"badtopic"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/badtopic/json?poll=1", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "", response.Body.String())
+}
+
+func TestServer_BlockTopic_WithoutAuth(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	require.Nil(t, s.blockTopic("badtopic", "manual"))
+
+	response := request(t, s, "PUT", "/badtopic", "more", nil)
+	require.Equal(t, 40303, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/goodtopic", "hi", nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_MessagesPrune(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+
+	response := request(t, s, "PUT", "/leaked", "my password is hunter2", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/leaked", "this is my secret key", map[string]string{
+		"Filename": "key.pem",
+	})
+	require.Equal(t, 200, response.Code)
+	attachment := filepath.Join(c.AttachmentCacheDir, toMessage(t, response.Body.String()).ID)
+	require.FileExists(t, attachment)
+	response = request(t, s, "PUT", "/othertopic", "unrelated", nil)
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "POST", "/v1/messages/prune", `{"topic":"leaked"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "POST", "/v1/messages/prune", `{"topic":"not/valid"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40009, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/v1/messages/prune", `{"topic":"leaked","before":"last summer"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+
+	// Nothing was published before 2020
+	response = request(t, s, "POST", "/v1/messages/prune", `{"topic":"leaked","before":"2020-01-01"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	prune, err := util.UnmarshalJSON[apiMessagesPruneResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 0, prune.Deleted)
+
+	// Delete all messages of the topic, and the attachment
+	response = request(t, s, "POST", "/v1/messages/prune", `{"topic":"leaked"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	prune, err = util.UnmarshalJSON[apiMessagesPruneResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, prune.Deleted)
+	require.NoFileExists(t, attachment)
+
+	response = request(t, s, "GET", "/leaked/json?poll=1", "", nil)
+	require.Empty(t, toMessages(t, response.Body.String()))
+	response = request(t, s, "GET", "/othertopic/json?poll=1", "", nil)
+	require.Equal(t, 1, len(toMessages(t, response.Body.String())))
+}
@@ -12,8 +12,13 @@ import (
 )
 
 const (
-	syncTopicAccountSyncEvent = "sync"
-	tokenExpiryDuration       = 72 * time.Hour // Extend tokens by this much
+	syncTopicAccountSyncEvent        = "sync"
+	tokenExpiryDuration              = 72 * time.Hour // Extend tokens by this much
+	impersonationTokenExpiryDuration = time.Hour      // Impersonation tokens cannot be extended
+	accountDeletionGracePeriod       = 14 * 24 * time.Hour
+	publishKeyMaxTitlePrefixLength   = 64
+	publishKeyMaxTags                = 10
+	publishKeyMaxTagLength           = 64
 )
 
 func (s *Server) handleAccountCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
@@ -28,7 +33,7 @@ func (s *Server) handleAccountCreate(w http.ResponseWriter, r *http.Request, v *
 			return errHTTPTooManyRequestsLimitAccountCreation
 		}
 	}
-	newAccount, err := readJSONWithLimit[apiAccountCreateRequest](r.Body, jsonBodyBytesLimit, false)
+	newAccount, err := readJSONWithLimit[apiAccountCreateRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	}
@@ -40,6 +45,7 @@ func (s *Server) handleAccountCreate(w http.ResponseWriter, r *http.Request, v *
 		return err
 	}
 	v.AccountCreated()
+	s.sendAccountWebhookEvent(webhookEventAccountCreated, newAccount.Username)
 	return s.writeJSON(w, newSuccessResponse())
 }
 
@@ -49,6 +55,15 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 		return err
 	}
 	logvr(v, r).Tag(tagAccount).Fields(visitorExtendedInfoContext(info)).Debug("Retrieving account stats")
+	response, err := s.accountResponse(v, info)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, response)
+}
+
+// accountResponse returns the account details, limits and stats of the given visitor, see handleAccountGet
+func (s *Server) accountResponse(v *visitor, info *visitorInfo) (*apiAccountResponse, error) {
 	limits, stats := info.Limits, info.Stats
 	response := &apiAccountResponse{
 		Limits: &apiAccountLimits{
@@ -62,6 +77,7 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 			AttachmentFileSize:       limits.AttachmentFileSizeLimit,
 			AttachmentExpiryDuration: int64(limits.AttachmentExpiryDuration.Seconds()),
 			AttachmentBandwidth:      limits.AttachmentBandwidthLimit,
+			MessageSize:              limits.MessageSizeLimit,
 		},
 		Stats: &apiAccountStats{
 			Messages:                     stats.Messages,
@@ -76,11 +92,17 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 			AttachmentTotalSizeRemaining: stats.AttachmentTotalSizeRemaining,
 		},
 	}
+	if len(s.config.TagIcons) > 0 {
+		response.TagIcons = s.tagIcons()
+	}
 	u := v.User()
 	if u != nil {
 		response.Username = u.Name
 		response.Role = string(u.Role)
 		response.SyncTopic = u.SyncTopic
+		if !u.DeletionScheduled.IsZero() {
+			response.DeletionScheduled = u.DeletionScheduled.Unix()
+		}
 		if u.Prefs != nil {
 			if u.Prefs.Language != nil {
 				response.Language = *u.Prefs.Language
@@ -91,6 +113,12 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 			if u.Prefs.Subscriptions != nil {
 				response.Subscriptions = u.Prefs.Subscriptions
 			}
+			if u.Prefs.QuotaWarning != nil {
+				response.QuotaWarning = u.Prefs.QuotaWarning
+			}
+			if u.Prefs.Templates != nil {
+				response.Templates = u.Prefs.Templates
+			}
 		}
 		if u.Tier != nil {
 			response.Tier = &apiAccountTier{
@@ -108,24 +136,46 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 				CancelAt:     u.Billing.StripeSubscriptionCancelAt.Unix(),
 			}
 		}
+		groups, err := s.userManager.UserGroups(u.Name)
+		if err != nil {
+			return nil, err
+		} else if len(groups) > 0 {
+			response.Groups = groups
+		}
 		if s.config.EnableReservations {
 			reservations, err := s.userManager.Reservations(u.Name)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if len(reservations) > 0 {
+				publishKeys, err := s.userManager.PublishKeys(u.Name)
+				if err != nil {
+					return nil, err
+				}
 				response.Reservations = make([]*apiAccountReservation, 0)
 				for _, r := range reservations {
-					response.Reservations = append(response.Reservations, &apiAccountReservation{
-						Topic:    r.Topic,
-						Everyone: r.Everyone.String(),
-					})
+					reservation := &apiAccountReservation{
+						Topic:      r.Topic,
+						Everyone:   r.Everyone.String(),
+						Group:      r.Group,
+						Sound:      r.Sound,
+						Routing:    toAPIRoutingRules(r.Routing),
+						Escalation: toAPIEscalation(r.Escalation),
+						Schedule:   toAPISchedule(r.Schedule),
+						Policy:     toAPITopicPolicy(r.Policy),
+					}
+					for _, k := range publishKeys {
+						if k.Topic == r.Topic {
+							reservation.PublishKeys = append(reservation.PublishKeys, toAPIPublishKey(k))
+						}
+					}
+					response.Reservations = append(response.Reservations, reservation)
 				}
 			}
 		}
 		tokens, err := s.userManager.Tokens(u.ID)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if len(tokens) > 0 {
 			response.Tokens = make([]*apiAccountTokenResponse, 0)
@@ -135,18 +185,20 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 					lastOrigin = t.LastOrigin.String()
 				}
 				response.Tokens = append(response.Tokens, &apiAccountTokenResponse{
-					Token:      t.Value,
-					Label:      t.Label,
-					LastAccess: t.LastAccess.Unix(),
-					LastOrigin: lastOrigin,
-					Expires:    t.Expires.Unix(),
+					Token:        t.Value,
+					Label:        t.Label,
+					LastAccess:   t.LastAccess.Unix(),
+					LastOrigin:   lastOrigin,
+					Expires:      t.Expires.Unix(),
+					AllowedIPs:   formatTokenAllowedIPs(t.AllowedIPs),
+					Impersonator: t.Impersonator,
 				})
 			}
 		}
 		if s.config.TwilioAccount != "" {
 			phoneNumbers, err := s.userManager.PhoneNumbers(u.ID)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if len(phoneNumbers) > 0 {
 				response.PhoneNumbers = phoneNumbers
@@ -156,11 +208,11 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 		response.Username = user.Everyone
 		response.Role = string(user.RoleAnonymous)
 	}
-	return s.writeJSON(w, response)
+	return response, nil
 }
 
 func (s *Server) handleAccountDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	req, err := readJSONWithLimit[apiAccountDeleteRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiAccountDeleteRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	} else if req.Password == "" {
@@ -170,29 +222,76 @@ func (s *Server) handleAccountDelete(w http.ResponseWriter, r *http.Request, v *
 	if _, err := s.userManager.Authenticate(u.Name, req.Password); err != nil {
 		return errHTTPBadRequestIncorrectPasswordConfirmation
 	}
-	if s.webPush != nil && u.ID != "" {
+	if !u.DeletionScheduled.IsZero() {
+		return s.writeJSON(w, newSuccessResponse()) // Already scheduled, keep the original date
+	}
+	deletionScheduled := time.Now().Add(accountDeletionGracePeriod)
+	logvr(v, r).Tag(tagAccount).Info("Scheduling deletion of user %s for %s", u.Name, deletionScheduled.Format(time.RFC3339))
+	if err := s.userManager.ScheduleUserDeletion(u.ID, deletionScheduled); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAccountDeletionCancel(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	if u.DeletionScheduled.IsZero() {
+		return errHTTPNotFoundAccountDeletion
+	}
+	logvr(v, r).Tag(tagAccount).Info("Canceling scheduled deletion of user %s", u.Name)
+	if err := s.userManager.CancelUserDeletion(u.ID); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// removeUser permanently deletes a user whose scheduled deletion is due (see handleAccountDelete), including
+// their tokens, reservations (and the messages in the reserved topics), web push subscriptions and attachments.
+// If the user has a billing subscription, it is canceled.
+func (s *Server) removeUser(u *user.User) error {
+	ev := log.Tag(tagAccount).Fields(log.Context{"user_id": u.ID, "user_name": u.Name})
+	if s.webPush != nil {
 		if err := s.webPush.RemoveSubscriptionsByUserID(u.ID); err != nil {
-			logvr(v, r).Err(err).Warn("Error removing web push subscriptions for %s", u.Name)
+			ev.Err(err).Warn("Error removing web push subscriptions for %s", u.Name)
+		}
+	}
+	if u.Billing.StripeSubscriptionID != "" && s.payments != nil {
+		ev.Tag(s.payments.Name()).Info("Canceling billing subscription for user %s", u.Name)
+		if err := s.payments.CancelSubscription(u.Billing.StripeSubscriptionID); err != nil {
+			return err
 		}
 	}
-	if u.Billing.StripeSubscriptionID != "" {
-		logvr(v, r).Tag(tagStripe).Info("Canceling billing subscription for user %s", u.Name)
-		if _, err := s.stripe.CancelSubscription(u.Billing.StripeSubscriptionID); err != nil {
+	reservations, err := s.userManager.Reservations(u.Name)
+	if err != nil {
+		return err
+	} else if len(reservations) > 0 {
+		topics := make([]string, len(reservations))
+		for i, reservation := range reservations {
+			topics[i] = reservation.Topic
+		}
+		if err := s.userManager.RemoveReservations(u.Name, topics...); err != nil {
+			return err
+		}
+		if err := s.expireReservationMessages(topics...); err != nil {
 			return err
 		}
 	}
-	if err := s.maybeRemoveMessagesAndExcessReservations(r, v, u, 0); err != nil {
+	if err := s.messageCache.ExpireAttachmentsByUser(u.ID); err != nil {
 		return err
 	}
-	logvr(v, r).Tag(tagAccount).Info("Marking user %s as deleted", u.Name)
-	if err := s.userManager.MarkUserRemoved(u); err != nil {
+	if err := s.killUserSubscriber(u, "*"); err != nil {
 		return err
 	}
-	return s.writeJSON(w, newSuccessResponse())
+	ev.Info("Deleting user %s, scheduled deletion is due", u.Name)
+	if err := s.userManager.RemoveUser(u.Name); err != nil {
+		return err
+	}
+	s.sendWebhookEvent(newWebhookEvent(webhookEventAccountDeleted, u))
+	return nil
 }
 
 func (s *Server) handleAccountPasswordChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	req, err := readJSONWithLimit[apiAccountPasswordChangeRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiAccountPasswordChangeRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	} else if req.Password == "" || req.NewPassword == "" {
@@ -210,7 +309,10 @@ func (s *Server) handleAccountPasswordChange(w http.ResponseWriter, r *http.Requ
 }
 
 func (s *Server) handleAccountTokenCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	req, err := readJSONWithLimit[apiAccountTokenIssueRequest](r.Body, jsonBodyBytesLimit, true) // Allow empty body!
+	if v.User().Impersonator != "" {
+		return errHTTPForbiddenImpersonating
+	}
+	req, err := readJSONWithLimit[apiAccountTokenIssueRequest](r.Body, s.config.AccountBodySizeLimit, true) // Allow empty body!
 	if err != nil {
 		return err
 	}
@@ -222,15 +324,20 @@ func (s *Server) handleAccountTokenCreate(w http.ResponseWriter, r *http.Request
 	if req.Expires != nil {
 		expires = time.Unix(*req.Expires, 0)
 	}
+	allowedIPs, err := user.ParseAllowedIPs(req.AllowedIPs)
+	if err != nil {
+		return errHTTPBadRequestTokenAllowedIPsInvalid.Wrap("%s", err.Error())
+	}
 	u := v.User()
 	logvr(v, r).
 		Tag(tagAccount).
 		Fields(log.Context{
-			"token_label":   label,
-			"token_expires": expires,
+			"token_label":       label,
+			"token_expires":     expires,
+			"token_allowed_ips": req.AllowedIPs,
 		}).
 		Debug("Creating token for user %s", u.Name)
-	token, err := s.userManager.CreateToken(u.ID, label, expires, v.IP())
+	token, err := s.userManager.CreateToken(u.ID, label, expires, v.IP(), allowedIPs)
 	if err != nil {
 		return err
 	}
@@ -240,13 +347,17 @@ func (s *Server) handleAccountTokenCreate(w http.ResponseWriter, r *http.Request
 		LastAccess: token.LastAccess.Unix(),
 		LastOrigin: token.LastOrigin.String(),
 		Expires:    token.Expires.Unix(),
+		AllowedIPs: formatTokenAllowedIPs(token.AllowedIPs),
 	}
 	return s.writeJSON(w, response)
 }
 
 func (s *Server) handleAccountTokenUpdate(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	u := v.User()
-	req, err := readJSONWithLimit[apiAccountTokenUpdateRequest](r.Body, jsonBodyBytesLimit, true) // Allow empty body!
+	if u.Impersonator != "" {
+		return errHTTPForbiddenImpersonating
+	}
+	req, err := readJSONWithLimit[apiAccountTokenUpdateRequest](r.Body, s.config.AccountBodySizeLimit, true) // Allow empty body!
 	if err != nil {
 		return err
 	} else if req.Token == "" {
@@ -278,10 +389,23 @@ func (s *Server) handleAccountTokenUpdate(w http.ResponseWriter, r *http.Request
 		LastAccess: token.LastAccess.Unix(),
 		LastOrigin: token.LastOrigin.String(),
 		Expires:    token.Expires.Unix(),
+		AllowedIPs: formatTokenAllowedIPs(token.AllowedIPs),
 	}
 	return s.writeJSON(w, response)
 }
 
+// formatTokenAllowedIPs converts the IP ranges a token is bound to into strings, see user.Token.AllowedIPs
+func formatTokenAllowedIPs(prefixes []netip.Prefix) []string {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	values := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		values[i] = prefix.String()
+	}
+	return values
+}
+
 func (s *Server) handleAccountTokenDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	u := v.User()
 	token := readParam(r, "X-Token", "Token") // DELETEs cannot have a body, and we don't want it in the path
@@ -302,7 +426,7 @@ func (s *Server) handleAccountTokenDelete(w http.ResponseWriter, r *http.Request
 }
 
 func (s *Server) handleAccountSettingsChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	newPrefs, err := readJSONWithLimit[user.Prefs](r.Body, jsonBodyBytesLimit, false)
+	newPrefs, err := readJSONWithLimit[user.Prefs](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	}
@@ -328,6 +452,11 @@ func (s *Server) handleAccountSettingsChange(w http.ResponseWriter, r *http.Requ
 			prefs.Notification.MinPriority = newPrefs.Notification.MinPriority
 		}
 	}
+	if newPrefs.QuotaWarning != nil {
+		if err := s.changeQuotaWarningPrefs(u, prefs, newPrefs.QuotaWarning); err != nil {
+			return err
+		}
+	}
 	logvr(v, r).Tag(tagAccount).Debug("Changing account settings for user %s", u.Name)
 	if err := s.userManager.ChangeSettings(u.ID, prefs); err != nil {
 		return err
@@ -336,7 +465,7 @@ func (s *Server) handleAccountSettingsChange(w http.ResponseWriter, r *http.Requ
 }
 
 func (s *Server) handleAccountSubscriptionAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	newSubscription, err := readJSONWithLimit[user.Subscription](r.Body, jsonBodyBytesLimit, false)
+	newSubscription, err := readJSONWithLimit[user.Subscription](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	}
@@ -359,7 +488,7 @@ func (s *Server) handleAccountSubscriptionAdd(w http.ResponseWriter, r *http.Req
 }
 
 func (s *Server) handleAccountSubscriptionChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	updatedSubscription, err := readJSONWithLimit[user.Subscription](r.Body, jsonBodyBytesLimit, false)
+	updatedSubscription, err := readJSONWithLimit[user.Subscription](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	}
@@ -372,6 +501,7 @@ func (s *Server) handleAccountSubscriptionChange(w http.ResponseWriter, r *http.
 	for _, sub := range prefs.Subscriptions {
 		if sub.BaseURL == updatedSubscription.BaseURL && sub.Topic == updatedSubscription.Topic {
 			sub.DisplayName = updatedSubscription.DisplayName
+			sub.Sound = updatedSubscription.Sound
 			subscription = sub
 			break
 		}
@@ -417,27 +547,50 @@ func (s *Server) handleAccountSubscriptionDelete(w http.ResponseWriter, r *http.
 // it is already reserved by someone else.
 func (s *Server) handleAccountReservationAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	u := v.User()
-	req, err := readJSONWithLimit[apiAccountReservationRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiAccountReservationRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	}
-	if !topicRegex.MatchString(req.Topic) {
+	if !topicRegex.MatchString(req.Topic) && !user.AllowedTopicPrefix(req.Topic) {
 		return errHTTPBadRequestTopicInvalid
 	}
 	everyone, err := user.ParsePermission(req.Everyone)
 	if err != nil {
 		return errHTTPBadRequestPermissionInvalid
 	}
+	if req.Sound != "" && !soundRegex.MatchString(req.Sound) {
+		return errHTTPBadRequestSoundInvalid
+	}
+	routing, err := s.validateRoutingRules(u, req.Routing)
+	if err != nil {
+		return err
+	}
+	escalation, err := s.validateEscalation(u, req.Topic, req.Escalation)
+	if err != nil {
+		return err
+	}
+	policy, err := s.validateTopicPolicy(req.Policy)
+	if err != nil {
+		return err
+	}
+	if req.Group != "" {
+		groups, err := s.userManager.UserGroups(u.Name)
+		if err != nil {
+			return err
+		} else if !util.Contains(groups, req.Group) {
+			return errHTTPBadRequestGroupNotFound // Users can only share reservations with their own groups
+		}
+	}
 	// Check if we are allowed to reserve this topic
+	hasReservation, err := s.userManager.HasReservation(u.Name, req.Topic)
+	if err != nil {
+		return err
+	}
 	if u.IsUser() && u.Tier == nil {
 		return errHTTPUnauthorized
 	} else if err := s.userManager.AllowReservation(u.Name, req.Topic); err != nil {
 		return errHTTPConflictTopicReserved
 	} else if u.IsUser() {
-		hasReservation, err := s.userManager.HasReservation(u.Name, req.Topic)
-		if err != nil {
-			return err
-		}
 		if !hasReservation {
 			reservations, err := s.userManager.ReservationsCount(u.Name)
 			if err != nil {
@@ -451,19 +604,49 @@ func (s *Server) handleAccountReservationAdd(w http.ResponseWriter, r *http.Requ
 	logvr(v, r).
 		Tag(tagAccount).
 		Fields(log.Context{
-			"topic":    req.Topic,
-			"everyone": everyone.String(),
+			"topic":      req.Topic,
+			"everyone":   everyone.String(),
+			"group":      req.Group,
+			"sound":      req.Sound,
+			"routing":    len(routing),
+			"escalation": escalation != nil,
+			"policy":     policy != nil,
 		}).
 		Debug("Adding topic reservation")
 	if err := s.userManager.AddReservation(u.Name, req.Topic, everyone); err != nil {
 		return err
 	}
+	if err := s.userManager.ShareReservation(u.Name, req.Topic, req.Group); err != nil {
+		return err
+	}
+	if err := s.userManager.ChangeReservationSound(u.Name, req.Topic, req.Sound); err != nil {
+		return err
+	}
+	if err := s.userManager.ChangeReservationRouting(u.Name, req.Topic, routing); err != nil {
+		return err
+	}
+	if err := s.userManager.ChangeReservationEscalation(u.Name, req.Topic, escalation); err != nil {
+		return err
+	}
+	if err := s.userManager.ChangeReservationPolicy(u.Name, req.Topic, policy); err != nil {
+		return err
+	}
+	if !hasReservation {
+		ev := newWebhookEvent(webhookEventReservationCreated, u)
+		ev.Reservation = &webhookReservation{
+			Topic:    req.Topic,
+			Everyone: everyone.String(),
+		}
+		s.sendWebhookEvent(ev)
+	}
 	// Kill existing subscribers
-	t, err := s.topicFromID(req.Topic)
+	topics, err := s.topicsForReservation(req.Topic)
 	if err != nil {
 		return err
 	}
-	t.CancelSubscribersExceptUser(u.ID)
+	for _, t := range topics {
+		t.CancelSubscribersExceptUser(u.ID)
+	}
 	return s.writeJSON(w, newSuccessResponse())
 }
 
@@ -474,7 +657,7 @@ func (s *Server) handleAccountReservationDelete(w http.ResponseWriter, r *http.R
 		return errHTTPInternalErrorInvalidPath
 	}
 	topic := matches[1]
-	if !topicRegex.MatchString(topic) {
+	if !topicRegex.MatchString(topic) && !user.AllowedTopicPrefix(topic) {
 		return errHTTPBadRequestTopicInvalid
 	}
 	u := v.User()
@@ -496,7 +679,7 @@ func (s *Server) handleAccountReservationDelete(w http.ResponseWriter, r *http.R
 		return err
 	}
 	if deleteMessages {
-		if err := s.messageCache.ExpireMessages(topic); err != nil {
+		if err := s.expireReservationMessages(topic); err != nil {
 			return err
 		}
 		s.pruneMessages()
@@ -504,6 +687,115 @@ func (s *Server) handleAccountReservationDelete(w http.ResponseWriter, r *http.R
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+// handleAccountPublishKeyCreate creates a publish key for a topic reserved by the logged-in user. The key allows
+// publishing to this topic only, without authenticating as the user (e.g. for sensors or third-party services).
+func (s *Server) handleAccountPublishKeyCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.reservedTopicFromPublishKeyPath(r, v)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiAccountPublishKeyRequest](r.Body, s.config.AccountBodySizeLimit, true) // Allow empty body!
+	if err != nil {
+		return err
+	}
+	defaults, err := validatePublishKeyDefaults(req)
+	if err != nil {
+		return err
+	}
+	u := v.User()
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"topic":                topic,
+			"publish_key_label":    req.Label,
+			"publish_key_defaults": defaults != nil,
+		}).
+		Debug("Creating publish key for topic %s", topic)
+	key, err := s.userManager.CreatePublishKey(u.Name, topic, req.Label, defaults)
+	if err == user.ErrTooManyPublishKeys {
+		return errHTTPTooManyRequestsLimitPublishKeys
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, toAPIPublishKey(key))
+}
+
+// handleAccountPublishKeyDelete deletes a publish key for a topic reserved by the logged-in user
+func (s *Server) handleAccountPublishKeyDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.reservedTopicFromPublishKeyPath(r, v)
+	if err != nil {
+		return err
+	}
+	key := readParam(r, "X-Publish-Key", "Publish-Key") // DELETEs cannot have a body, and we don't want it in the path
+	if key == "" {
+		return errHTTPBadRequestNoPublishKeyProvided
+	}
+	if err := s.userManager.RemovePublishKey(v.User().Name, topic, key); err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Deleted publish key for topic %s", topic)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// validatePublishKeyDefaults checks the message defaults of a publish key request, and returns nil if there are none
+func validatePublishKeyDefaults(req *apiAccountPublishKeyRequest) (*user.PublishKeyDefaults, error) {
+	if req.TitlePrefix == "" && req.Priority == 0 && len(req.Tags) == 0 {
+		return nil, nil
+	} else if len(req.TitlePrefix) > publishKeyMaxTitlePrefixLength || req.Priority < 0 || req.Priority > 5 || len(req.Tags) > publishKeyMaxTags {
+		return nil, errHTTPBadRequestPublishKeyDefaultsInvalid
+	}
+	tags := make([]string, 0, len(req.Tags))
+	for _, tag := range req.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || strings.Contains(tag, ",") || len(tag) > publishKeyMaxTagLength {
+			return nil, errHTTPBadRequestPublishKeyDefaultsInvalid
+		}
+		tags = append(tags, tag)
+	}
+	return &user.PublishKeyDefaults{
+		TitlePrefix: strings.TrimSpace(req.TitlePrefix),
+		Priority:    req.Priority,
+		Tags:        tags,
+	}, nil
+}
+
+func toAPIPublishKey(key *user.PublishKey) *apiAccountPublishKey {
+	k := &apiAccountPublishKey{
+		Key:     key.Value,
+		Label:   key.Label,
+		Created: key.Created.Unix(),
+	}
+	if key.Defaults != nil {
+		k.TitlePrefix = key.Defaults.TitlePrefix
+		k.Priority = key.Defaults.Priority
+		k.Tags = key.Defaults.Tags
+	}
+	return k
+}
+
+// reservedTopicFromPublishKeyPath extracts the topic from the publish key path, and checks that the
+// logged-in user owns the reservation for it
+func (s *Server) reservedTopicFromPublishKeyPath(r *http.Request, v *visitor) (string, error) {
+	matches := apiAccountReservationPublishKeyRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return "", errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	if !topicRegex.MatchString(topic) {
+		return "", errHTTPBadRequestTopicInvalid
+	}
+	authorized, err := s.userManager.HasReservation(v.User().Name, topic)
+	if err != nil {
+		return "", err
+	} else if !authorized {
+		return "", errHTTPUnauthorized
+	}
+	return topic, nil
+}
+
 // maybeRemoveMessagesAndExcessReservations deletes topic reservations for the given user (if too many for tier),
 // and marks associated messages for the topics as deleted. This also eventually deletes attachments.
 // The process relies on the manager to perform the actual deletions (see runManager).
@@ -523,16 +815,67 @@ func (s *Server) maybeRemoveMessagesAndExcessReservations(r *http.Request, v *vi
 	if err := s.userManager.RemoveReservations(u.Name, topics...); err != nil {
 		return err
 	}
-	if err := s.messageCache.ExpireMessages(topics...); err != nil {
+	if err := s.expireReservationMessages(topics...); err != nil {
 		return err
 	}
 	go s.pruneMessages()
 	return nil
 }
 
+// topicsForReservation returns the topic for the given reservation. For prefix reservations (e.g. "myteam-*"),
+// all topics currently held in memory that match the prefix are returned.
+func (s *Server) topicsForReservation(reservation string) ([]*topic, error) {
+	prefix, isPrefix := strings.CutSuffix(reservation, "*")
+	if !isPrefix {
+		t, err := s.topicFromID(reservation)
+		if err != nil {
+			return nil, err
+		}
+		return []*topic{t}, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	topics := make([]*topic, 0)
+	for id, t := range s.topics {
+		if strings.HasPrefix(id, prefix) {
+			topics = append(topics, t)
+		}
+	}
+	return topics, nil
+}
+
+// expireReservationMessages marks all cached messages of the given reserved topics as expired, so that
+// they are deleted by the manager. For prefix reservations, messages of all matching topics are expired.
+func (s *Server) expireReservationMessages(reservations ...string) error {
+	topics := make([]string, 0)
+	var prefixes []string
+	for _, reservation := range reservations {
+		if prefix, isPrefix := strings.CutSuffix(reservation, "*"); isPrefix {
+			prefixes = append(prefixes, prefix)
+		} else {
+			topics = append(topics, reservation)
+		}
+	}
+	if len(prefixes) > 0 {
+		cachedTopics, err := s.messageCache.Topics()
+		if err != nil {
+			return err
+		}
+		for id := range cachedTopics {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(id, prefix) {
+					topics = append(topics, id)
+					break
+				}
+			}
+		}
+	}
+	return s.messageCache.ExpireMessages(topics...)
+}
+
 func (s *Server) handleAccountPhoneNumberVerify(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	u := v.User()
-	req, err := readJSONWithLimit[apiAccountPhoneNumberVerifyRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiAccountPhoneNumberVerifyRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	} else if !phoneNumberRegex.MatchString(req.Number) {
@@ -563,7 +906,7 @@ func (s *Server) handleAccountPhoneNumberVerify(w http.ResponseWriter, r *http.R
 
 func (s *Server) handleAccountPhoneNumberAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	u := v.User()
-	req, err := readJSONWithLimit[apiAccountPhoneNumberAddRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiAccountPhoneNumberAddRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	}
@@ -582,7 +925,7 @@ func (s *Server) handleAccountPhoneNumberAdd(w http.ResponseWriter, r *http.Requ
 
 func (s *Server) handleAccountPhoneNumberDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	u := v.User()
-	req, err := readJSONWithLimit[apiAccountPhoneNumberAddRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiAccountPhoneNumberAddRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	}
@@ -626,3 +969,40 @@ func (s *Server) publishSyncEvent(v *visitor) error {
 	}
 	return nil
 }
+
+// sendAccountWebhookEvent looks up the user with the given username, and sends an account lifecycle
+// event for it to the configured webhook URLs. This is used after creating a user, since user.Manager.AddUser
+// does not return the user.
+func (s *Server) sendAccountWebhookEvent(event, username string) {
+	if s.webhookSender == nil {
+		return
+	}
+	u, err := s.userManager.User(username)
+	if err != nil {
+		log.Tag(tagWebhook).Err(err).Warn("Cannot send webhook event %s, user %s not found", event, username)
+		return
+	}
+	s.sendWebhookEvent(newWebhookEvent(event, u))
+}
+
+// sendWebhookEvent sends an account lifecycle event to the configured webhook URLs, if any
+func (s *Server) sendWebhookEvent(ev *webhookEvent) {
+	if s.webhookSender == nil {
+		return
+	}
+	s.webhookSender.Send(ev)
+}
+
+// sendTierChangedWebhookEvent sends an account.tier_changed event for the given user. The user's tier
+// must not have been updated yet, since it is used as the old tier. A nil newTier means the tier was removed.
+func (s *Server) sendTierChangedWebhookEvent(u *user.User, newTier *user.Tier) {
+	ev := newWebhookEvent(webhookEventAccountTierChanged, u)
+	ev.Tier = &webhookTierChange{}
+	if u.Tier != nil {
+		ev.Tier.Old = u.Tier.Code
+	}
+	if newTier != nil {
+		ev.Tier.New = newTier.Code
+	}
+	s.sendWebhookEvent(ev)
+}
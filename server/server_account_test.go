@@ -7,6 +7,7 @@ import (
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 	"io"
+	"net/http"
 	"net/netip"
 	"path/filepath"
 	"strings"
@@ -176,7 +177,7 @@ func TestAccount_ChangeSettings(t *testing.T) {
 
 	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
 	u, _ := s.userManager.User("phil")
-	token, _ := s.userManager.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified())
+	token, _ := s.userManager.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
 
 	rr := request(t, s, "PATCH", "/v1/account/settings", `{"notification": {"sound": "juntos"},"ignored": true}`, map[string]string{
 		"Authorization": util.BasicAuth("phil", "phil"),
@@ -341,6 +342,54 @@ func TestAccount_ExtendToken_NoTokenProvided(t *testing.T) {
 	require.Equal(t, 40023, toHTTPError(t, rr.Body.String()).Code)
 }
 
+func TestAccount_CreateToken_AllowedIPs(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+
+	// Invalid range
+	rr := request(t, s, "POST", "/v1/account/token", `{"allowed_ips":["10.0.1.0/33"]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40053, toHTTPError(t, rr.Body.String()).Code)
+
+	// Create token bound to IP range
+	rr = request(t, s, "POST", "/v1/account/token", `{"label":"sensor","allowed_ips":["10.0.1.0/24","1.2.3.4"]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	token, err := util.UnmarshalJSON[apiAccountTokenResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.1.0/24", "1.2.3.4/32"}, token.AllowedIPs)
+
+	// Token cannot be used from other IPs (request() uses 9.9.9.9)
+	rr = request(t, s, "PUT", "/mytopic", "from elsewhere", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40305, toHTTPError(t, rr.Body.String()).Code)
+
+	// Token can be used from allowed IPs
+	rr = request(t, s, "PUT", "/mytopic", "from the office", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	}, func(r *http.Request) {
+		r.RemoteAddr = "10.0.1.17:1234"
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Ranges are returned in the account
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(account.Tokens))
+	require.Equal(t, []string{"10.0.1.0/24", "1.2.3.4/32"}, account.Tokens[0].AllowedIPs)
+}
+
 func TestAccount_DeleteToken(t *testing.T) {
 	s := newTestServer(t, newTestConfigWithAuthFile(t))
 	defer s.closeDatabases()
@@ -399,15 +448,145 @@ func TestAccount_Delete_Success(t *testing.T) {
 	})
 	require.Equal(t, 200, rr.Code)
 
-	// Account was marked deleted
+	// Deletion was scheduled, but account can still be used during the grace period
 	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
 		"Authorization": util.BasicAuth("phil", "mypass"),
 	})
-	require.Equal(t, 401, rr.Code)
+	require.Equal(t, 200, rr.Code)
+	account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.True(t, account.DeletionScheduled > time.Now().Add(accountDeletionGracePeriod-time.Minute).Unix())
 
 	// Cannot re-create account, since still exists
 	rr = request(t, s, "POST", "/v1/account", `{"username":"phil", "password":"mypass"}`, nil)
 	require.Equal(t, 409, rr.Code)
+
+	// Account is deleted once the grace period is over
+	forceAccountDeletion(t, s, "phil")
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Account can be re-created after it was deleted
+	rr = request(t, s, "POST", "/v1/account", `{"username":"phil", "password":"mypass"}`, nil)
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestAccount_Delete_Cancel(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "mypass", user.RoleUser))
+
+	// Nothing to cancel
+	rr := request(t, s, "DELETE", "/v1/account/deletion", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 404, rr.Code)
+	require.Equal(t, 40405, toHTTPError(t, rr.Body.String()).Code)
+
+	// Schedule deletion twice, the original date is kept
+	rr = request(t, s, "DELETE", "/v1/account", `{"password":"mypass"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	deletionScheduled := u.DeletionScheduled
+	require.False(t, deletionScheduled.IsZero())
+
+	rr = request(t, s, "DELETE", "/v1/account", `{"password":"mypass"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	u, err = s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, deletionScheduled, u.DeletionScheduled)
+
+	// Cancel deletion
+	rr = request(t, s, "DELETE", "/v1/account/deletion", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, int64(0), account.DeletionScheduled)
+
+	// Manager does not delete the user
+	s.pruneUsers()
+	_, err = s.userManager.User("phil")
+	require.Nil(t, err)
+}
+
+func TestAccount_Delete_RemovesReservationsAndAttachments(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionReadWrite
+	s := newTestServer(t, conf)
+
+	require.Nil(t, s.userManager.AddUser("phil", "mypass", user.RoleUser))
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:                     "pro",
+		MessageLimit:             20,
+		MessageExpiryDuration:    time.Hour,
+		ReservationLimit:         2,
+		AttachmentTotalSizeLimit: 10000,
+		AttachmentFileSizeLimit:  10000,
+		AttachmentExpiryDuration: time.Hour,
+		AttachmentBandwidthLimit: 10000,
+	}))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+
+	rr := request(t, s, "POST", "/v1/account/reservation", `{"topic": "mytopic", "everyone":"deny-all"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/mytopic?f=attach.txt", `Howdy`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	m1 := toMessage(t, rr.Body.String())
+	rr = request(t, s, "POST", "/othertopic?f=attach.txt", `Howdy`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	m2 := toMessage(t, rr.Body.String())
+	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m1.ID))
+	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m2.ID))
+
+	// Nothing is removed during the grace period
+	rr = request(t, s, "DELETE", "/v1/account", `{"password":"mypass"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	s.execManager()
+	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m2.ID))
+	owner, err := s.userManager.ReservationOwner("mytopic")
+	require.Nil(t, err)
+	require.NotEmpty(t, owner)
+
+	// Reservations, messages in reserved topics and all attachments are removed
+	forceAccountDeletion(t, s, "phil")
+	s.execManager()
+	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, m1.ID))
+	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, m2.ID))
+	owner, err = s.userManager.ReservationOwner("mytopic")
+	require.Nil(t, err)
+	require.Empty(t, owner)
+	ms, err := s.messageCache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(ms))
+}
+
+// forceAccountDeletion makes the scheduled deletion of the user due, and runs the manager step that deletes it
+func forceAccountDeletion(t *testing.T, s *Server, username string) {
+	u, err := s.userManager.User(username)
+	require.Nil(t, err)
+	require.False(t, u.DeletionScheduled.IsZero())
+	require.Nil(t, s.userManager.ScheduleUserDeletion(u.ID, time.Now().Add(-time.Second)))
+	s.pruneUsers()
 }
 
 func TestAccount_Delete_Not_Allowed(t *testing.T) {
@@ -497,6 +676,50 @@ func TestAccount_Reservation_AddAdminSuccess(t *testing.T) {
 	require.Equal(t, 0, len(reservations))
 }
 
+func TestAccount_Reservation_AnonReadAuthWrite(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+
+	rr := request(t, s, "POST", "/v1/account/reservation", `{"topic":"status","everyone":"anon-read-auth-write"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Anonymous users can read, but not write
+	rr = request(t, s, "PUT", "/status", "anonymous", nil)
+	require.Equal(t, 403, rr.Code)
+
+	// Authenticated users can write
+	rr = request(t, s, "PUT", "/status", "from ben", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/status/json?poll=1", "", nil)
+	require.Equal(t, 200, rr.Code)
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "from ben", messages[0].Message)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, "anon-read-auth-write", account.Reservations[0].Everyone)
+}
+
 func TestAccount_Reservation_AddRemoveUserWithTierSuccess(t *testing.T) {
 	conf := newTestConfigWithAuthFile(t)
 	conf.EnableSignup = true
@@ -617,6 +840,381 @@ func TestAccount_Reservation_PublishByAnonymousFails(t *testing.T) {
 	require.Equal(t, 403, rr.Code)
 }
 
+func TestAccount_Reservation_Prefix(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionReadWrite
+	conf.EnableSignup = true
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	// Create users, one with tier
+	rr := request(t, s, "POST", "/v1/account", `{"username":"phil", "password":"mypass"}`, nil)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/v1/account", `{"username":"ben", "password":"mypass"}`, nil)
+	require.Equal(t, 200, rr.Code)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		ReservationLimit: 1,
+	}))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.ChangeTier("ben", "pro"))
+
+	// Invalid prefixes are rejected
+	for _, invalid := range []string{"a*", "abcde*"} {
+		rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "`+invalid+`", "everyone":"deny-all"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "mypass"),
+		})
+		require.Equal(t, 400, rr.Code)
+		require.Equal(t, 40009, toHTTPError(t, rr.Body.String()).Code)
+	}
+
+	// Reserve a prefix, counted as a single reservation
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "myteam-*", "everyone":"read-only"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, "myteam-*", account.Reservations[0].Topic)
+	require.Equal(t, "read-only", account.Reservations[0].Everyone)
+
+	// Matching topics inherit the ACLs: owner can publish, others can only read
+	rr = request(t, s, "PUT", "/myteam-prod", "hi from prod", map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/myteam-staging", "hi from staging", map[string]string{
+		"Authorization": util.BasicAuth("ben", "mypass"),
+	})
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "PUT", "/myteam-staging", "hi from staging", nil)
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "GET", "/myteam-prod/json?poll=1", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "hi from prod", toMessage(t, rr.Body.String()).Message)
+
+	// Others cannot reserve matching topics or overlapping prefixes
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "myteam-prod", "everyone":"deny-all"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "mypass"),
+	})
+	require.Equal(t, 409, rr.Code)
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "myteam*", "everyone":"deny-all"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "mypass"),
+	})
+	require.Equal(t, 409, rr.Code)
+
+	// Delete prefix reservation, including messages
+	rr = request(t, s, "DELETE", "/v1/account/reservation/myteam-*", "", map[string]string{
+		"Authorization":     util.BasicAuth("phil", "mypass"),
+		"X-Delete-Messages": "true",
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/myteam-prod/json?poll=1", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "", rr.Body.String())
+	rr = request(t, s, "PUT", "/myteam-staging", "hi from staging", map[string]string{
+		"Authorization": util.BasicAuth("ben", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestAccount_Reservation_ShareWithGroup(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("emma", "emma", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.AddGroup("team"))
+	require.Nil(t, s.userManager.AddGroup("other"))
+	require.Nil(t, s.userManager.AddGroupMembers("team", "phil", "ben"))
+
+	// Cannot share with a group the user is not a member of
+	rr := request(t, s, "POST", "/v1/account/reservation", `{"topic": "builds", "everyone":"deny-all", "group": "other"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40044, toHTTPError(t, rr.Body.String()).Code)
+
+	// Share reservation with group
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "builds", "everyone":"deny-all", "group": "team"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, []string{"team"}, account.Groups)
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, "team", account.Reservations[0].Group)
+
+	// Group members can publish, others cannot
+	rr = request(t, s, "PUT", "/builds", "build finished", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/builds", "build finished", map[string]string{
+		"Authorization": util.BasicAuth("emma", "emma"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Un-share by updating the reservation without a group
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "builds", "everyone":"deny-all"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/builds", "build finished", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+}
+
+func TestAccount_Reservation_Sound(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+
+	// Invalid sound name
+	rr := request(t, s, "POST", "/v1/account/reservation", `{"topic": "pager", "everyone":"deny-all", "sound": "a b"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40045, toHTTPError(t, rr.Body.String()).Code)
+
+	// Reserve topic with default sound
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "pager", "everyone":"deny-all", "sound": "siren"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, "siren", account.Reservations[0].Sound)
+
+	// Messages without a sound use the default, others keep their own sound
+	rr = request(t, s, "PUT", "/pager", "server down", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "siren", toMessage(t, rr.Body.String()).Sound)
+
+	rr = request(t, s, "PUT", "/pager", "just a test", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-Sound":       "quiet",
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "quiet", toMessage(t, rr.Body.String()).Sound)
+
+	// Removing the default sound
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "pager", "everyone":"deny-all"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/pager", "server down", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "", toMessage(t, rr.Body.String()).Sound)
+}
+
+func TestAccount_Reservation_Routing(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableSignup = true
+	conf.EnableLogin = true
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+	sender := newTestFirebaseSender(10)
+	s.firebaseClient = newFirebaseClient(sender, &testAuther{Allow: true})
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		EmailLimit:       10,
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+
+	// E-mails are disabled
+	routing := `[{"priorities":[1,2],"push":false},{"priorities":[4,5],"push":true,"email":"phil@example.com"}]`
+	rr := request(t, s, "POST", "/v1/account/reservation", `{"topic": "alerts", "everyone":"deny-all", "routing": `+routing+`}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40001, toHTTPError(t, rr.Body.String()).Code)
+
+	// Invalid and duplicate priorities
+	for _, invalid := range []string{`[{"priorities":[6],"push":true}]`, `[{"priorities":[],"push":true}]`, `[{"priorities":[1],"push":true},{"priorities":[1,2],"push":false}]`} {
+		rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "alerts", "everyone":"deny-all", "routing": `+invalid+`}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code)
+		require.Equal(t, 40058, toHTTPError(t, rr.Body.String()).Code)
+	}
+
+	// Reserve topic with routing rules
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "alerts", "everyone":"write-only", "routing": `+routing+`}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, 2, len(account.Reservations[0].Routing))
+	require.Equal(t, []int{4, 5}, account.Reservations[0].Routing[1].Priorities)
+	require.Equal(t, "phil@example.com", account.Reservations[0].Routing[1].Email)
+
+	// Low priority messages are only cached, default priority messages are delivered as usual,
+	// high priority messages are also sent by e-mail (charged to the owner, not the anonymous publisher)
+	for _, priority := range []string{"low", "default", "high"} {
+		rr = request(t, s, "PUT", "/alerts", priority+" message", map[string]string{
+			"Priority": priority,
+		})
+		require.Equal(t, 200, rr.Code)
+	}
+	waitFor(t, func() bool {
+		return mailer.Count() == 1 && len(sender.Messages()) == 2
+	})
+	require.Equal(t, "high message", mailer.Messages()[0].Message)
+	pushed := []string{sender.Messages()[0].Data["message"], sender.Messages()[1].Data["message"]}
+	require.ElementsMatch(t, []string{"default message", "high message"}, pushed) // Firebase queue is ordered by priority
+
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, int64(1), s.visitor(netip.IPv4Unspecified(), phil).Stats().Emails)
+
+	rr = request(t, s, "GET", "/alerts/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, 3, len(toMessages(t, rr.Body.String())))
+}
+
+func TestAccount_Reservation_Policy(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+
+	// Invalid policies
+	for _, invalid := range []string{
+		`{"max_message_size": -1}`,
+		`{"max_message_size": 5000}`, // Exceeds message limit
+		`{"priorities": [0]}`,
+		`{"priorities": [4, 4]}`,
+	} {
+		rr := request(t, s, "POST", "/v1/account/reservation", `{"topic": "builds-*", "everyone":"write-only", "policy": `+invalid+`}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code, invalid)
+		require.Equal(t, 40066, toHTTPError(t, rr.Body.String()).Code)
+	}
+
+	// Reserve topic prefix with policy
+	rr := request(t, s, "POST", "/v1/account/reservation", `{"topic": "builds-*", "everyone":"write-only", "policy": {"max_message_size": 20, "no_attachments": true, "priorities": [2, 3]}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, 20, account.Reservations[0].Policy.MaxMessageSize)
+	require.True(t, account.Reservations[0].Policy.NoAttachments)
+	require.Equal(t, []int{2, 3}, account.Reservations[0].Policy.Priorities)
+
+	// Messages within the policy are published
+	rr = request(t, s, "PUT", "/builds-main", "build passed", nil)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/builds-main?priority=low", "build flaky", nil)
+	require.Equal(t, 200, rr.Code)
+
+	// Messages violating the policy are rejected
+	rr = request(t, s, "PUT", "/builds-main", "build failed, see the logs for details", nil)
+	require.Equal(t, 413, rr.Code)
+	require.Equal(t, 41306, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/builds-main/publish?message=build+failed,+see+the+logs+for+details", "", nil)
+	require.Equal(t, 413, rr.Code)
+	require.Equal(t, 41306, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/builds-main", "build failed", map[string]string{
+		"Priority": "urgent",
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40068, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/builds-main", "build log", map[string]string{
+		"Attach": "https://example.com/build.log",
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40067, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/builds-main", string([]byte{0xff, 0xfe, 0x00}), nil)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40067, toHTTPError(t, rr.Body.String()).Code)
+
+	// Unreserved topics are not affected
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "other", user.PermissionReadWrite))
+	rr = request(t, s, "PUT", "/other", "build failed, see the logs for details", map[string]string{
+		"Priority": "urgent",
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Updating the reservation without a policy removes it
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "builds-*", "everyone":"write-only"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/builds-main", "build failed, see the logs for details", map[string]string{
+		"Priority": "urgent",
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
 func TestAccount_Reservation_Delete_Messages_And_Attachments(t *testing.T) {
 	t.Parallel()
 	conf := newTestConfigWithAuthFile(t)
@@ -769,3 +1367,185 @@ func TestAccount_Reservation_Delete_Messages_And_Attachments(t *testing.T) {
 	account, _ = util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
 	require.Equal(t, int64(2), account.Stats.Messages) // Is not reset!
 }*/
+
+func TestAccount_Reservation_PublishKey(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.AddReservation("phil", "sensors", user.PermissionDenyAll))
+
+	// Only the reservation owner can create keys
+	rr := request(t, s, "POST", "/v1/account/reservation/unreserved/key", `{"label":"nope"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	rr = request(t, s, "POST", "/v1/account/reservation/sensors/key", `{"label":"garage"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	key, _ := util.UnmarshalJSON[apiAccountPublishKey](io.NopCloser(rr.Body))
+	require.True(t, strings.HasPrefix(key.Key, "pk_"))
+	require.Equal(t, "garage", key.Label)
+
+	// Publish with key (Bearer and Basic), but only to that one topic
+	rr = request(t, s, "PUT", "/sensors", "bearer", map[string]string{
+		"Authorization": util.BearerAuth(key.Key),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/sensors", "basic", map[string]string{
+		"Authorization": util.BasicAuth("", key.Key),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/othertopic", "nope", map[string]string{
+		"Authorization": util.BearerAuth(key.Key),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// The auth scheme is case-insensitive
+	rr = request(t, s, "PUT", "/sensors", "lowercase bearer", map[string]string{
+		"Authorization": "bearer " + key.Key,
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/sensors", "lowercase basic", map[string]string{
+		"Authorization": strings.Replace(util.BasicAuth("", key.Key), "Basic", "basic", 1),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Keys of disabled users are rejected
+	require.Nil(t, s.userManager.ChangeDisabled("phil", true))
+	rr = request(t, s, "PUT", "/sensors", "disabled", map[string]string{
+		"Authorization": util.BearerAuth(key.Key),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Nil(t, s.userManager.ChangeDisabled("phil", false))
+
+	// Keys do not allow reading
+	rr = request(t, s, "GET", "/sensors/json?poll=1", "", map[string]string{
+		"Authorization": util.BearerAuth(key.Key),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Keys are listed in the account
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, 1, len(account.Reservations[0].PublishKeys))
+	require.Equal(t, key.Key, account.Reservations[0].PublishKeys[0].Key)
+
+	// Delete key
+	rr = request(t, s, "DELETE", "/v1/account/reservation/sensors/key", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-Publish-Key": key.Key,
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/sensors", "deleted", map[string]string{
+		"Authorization": util.BearerAuth(key.Key),
+	})
+	require.Equal(t, 403, rr.Code)
+}
+
+func TestAccount_Reservation_PublishKey_Defaults(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.AddReservation("phil", "sensors", user.PermissionDenyAll))
+
+	// Invalid defaults
+	for _, invalid := range []string{
+		`{"priority":6}`,
+		`{"tags":[""]}`,
+		`{"tags":["a,b"]}`,
+		`{"title_prefix":"` + strings.Repeat("x", 65) + `"}`,
+	} {
+		rr := request(t, s, "POST", "/v1/account/reservation/sensors/key", invalid, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code, invalid)
+		require.Equal(t, 40063, toHTTPError(t, rr.Body.String()).Code)
+	}
+
+	rr := request(t, s, "POST", "/v1/account/reservation/sensors/key", `{"label":"garage","title_prefix":"[Garage]","priority":4,"tags":["door"]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	key, _ := util.UnmarshalJSON[apiAccountPublishKey](io.NopCloser(rr.Body))
+	require.Equal(t, "[Garage]", key.TitlePrefix)
+	require.Equal(t, 4, key.Priority)
+	require.Equal(t, []string{"door"}, key.Tags)
+
+	// Plain message gets the defaults
+	rr = request(t, s, "PUT", "/sensors", "Door opened", map[string]string{
+		"Authorization": util.BearerAuth(key.Key),
+	})
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	require.Equal(t, "[Garage]", m.Title)
+	require.Equal(t, 4, m.Priority)
+	require.Equal(t, []string{"door"}, m.Tags)
+
+	// Publisher attributes take precedence, tags are merged
+	rr = request(t, s, "PUT", "/sensors", "Door closed", map[string]string{
+		"Authorization": util.BearerAuth(key.Key),
+		"Title":         "Closed",
+		"Priority":      "low",
+		"Tags":          "lock,door",
+	})
+	require.Equal(t, 200, rr.Code)
+	m = toMessage(t, rr.Body.String())
+	require.Equal(t, "[Garage] Closed", m.Title)
+	require.Equal(t, 2, m.Priority)
+	require.Equal(t, []string{"lock", "door"}, m.Tags)
+
+	// Plain body to the root path is published to the key's topic
+	rr = request(t, s, "POST", "/", "Motion detected", map[string]string{
+		"Authorization": util.BearerAuth(key.Key),
+	})
+	require.Equal(t, 200, rr.Code)
+	m = toMessage(t, rr.Body.String())
+	require.Equal(t, "sensors", m.Topic)
+	require.Equal(t, "Motion detected", m.Message)
+	require.Equal(t, "[Garage]", m.Title)
+
+	// JSON body without topic, too
+	rr = request(t, s, "POST", "/", `{"message":"Light on","title":"Light"}`, map[string]string{
+		"Authorization": util.BearerAuth(key.Key),
+	})
+	require.Equal(t, 200, rr.Code)
+	m = toMessage(t, rr.Body.String())
+	require.Equal(t, "sensors", m.Topic)
+	require.Equal(t, "[Garage] Light", m.Title)
+
+	// Invalid key
+	rr = request(t, s, "POST", "/", "Nope", map[string]string{
+		"Authorization": util.BearerAuth("pk_doesnotexist00000000000000000"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Keys with defaults are listed in the account
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, "[Garage]", account.Reservations[0].PublishKeys[0].TitlePrefix)
+	require.Equal(t, []string{"door"}, account.Reservations[0].PublishKeys[0].Tags)
+}
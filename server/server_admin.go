@@ -1,8 +1,10 @@
 package server
 
 import (
+	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/user"
 	"net/http"
+	"time"
 )
 
 func (s *Server) handleUsersGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
@@ -38,7 +40,7 @@ func (s *Server) handleUsersGet(w http.ResponseWriter, r *http.Request, v *visit
 }
 
 func (s *Server) handleUsersAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	req, err := readJSONWithLimit[apiUserAddRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiUserAddRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	} else if !user.AllowedUsername(req.Username) || req.Password == "" {
@@ -62,16 +64,22 @@ func (s *Server) handleUsersAdd(w http.ResponseWriter, r *http.Request, v *visit
 	if err := s.userManager.AddUser(req.Username, req.Password, user.RoleUser); err != nil {
 		return err
 	}
+	s.sendAccountWebhookEvent(webhookEventAccountCreated, req.Username)
 	if tier != nil {
+		u, err := s.userManager.User(req.Username)
+		if err != nil {
+			return err
+		}
 		if err := s.userManager.ChangeTier(req.Username, req.Tier); err != nil {
 			return err
 		}
+		s.sendTierChangedWebhookEvent(u, tier)
 	}
 	return s.writeJSON(w, newSuccessResponse())
 }
 
 func (s *Server) handleUsersDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	req, err := readJSONWithLimit[apiUserDeleteRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiUserDeleteRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	}
@@ -89,11 +97,53 @@ func (s *Server) handleUsersDelete(w http.ResponseWriter, r *http.Request, v *vi
 	if err := s.killUserSubscriber(u, "*"); err != nil { // FIXME super inefficient
 		return err
 	}
+	s.sendWebhookEvent(newWebhookEvent(webhookEventAccountDeleted, u))
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+// handleUsersImpersonate issues a short-lived token for a regular user to the calling admin, so that the admin
+// can act as that user, e.g. to debug their subscriptions or reservations. All requests made with the token
+// are logged with the "impersonation" tag.
+func (s *Server) handleUsersImpersonate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUserImpersonateRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	u, err := s.userManager.User(req.Username)
+	if err == user.ErrUserNotFound {
+		return errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return err
+	} else if !u.IsUser() {
+		return errHTTPBadRequestImpersonationNotAllowed
+	}
+	admin := v.User()
+	expires := time.Now().Add(impersonationTokenExpiryDuration)
+	token, err := s.userManager.CreateImpersonationToken(u.ID, admin.Name, expires, v.IP())
+	if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagImpersonation).
+		Fields(log.Context{
+			"impersonated_user_id":   u.ID,
+			"impersonated_user_name": u.Name,
+			"token_expires":          expires,
+		}).
+		Info("Admin %s started impersonating user %s", admin.Name, u.Name)
+	response := &apiAccountTokenResponse{
+		Token:        token.Value,
+		Label:        token.Label,
+		LastAccess:   token.LastAccess.Unix(),
+		LastOrigin:   token.LastOrigin.String(),
+		Expires:      token.Expires.Unix(),
+		Impersonator: token.Impersonator,
+	}
+	return s.writeJSON(w, response)
+}
+
 func (s *Server) handleAccessAllow(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	req, err := readJSONWithLimit[apiAccessAllowRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiAccessAllowRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	}
@@ -114,7 +164,7 @@ func (s *Server) handleAccessAllow(w http.ResponseWriter, r *http.Request, v *vi
 }
 
 func (s *Server) handleAccessReset(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	req, err := readJSONWithLimit[apiAccessResetRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiAccessResetRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil {
 		return err
 	}
@@ -141,3 +191,159 @@ func (s *Server) killUserSubscriber(u *user.User, topicPattern string) error {
 	}
 	return nil
 }
+
+func (s *Server) handleGroupsGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	groups, err := s.userManager.Groups()
+	if err != nil {
+		return err
+	}
+	groupsResponse := make([]*apiGroupResponse, len(groups))
+	for i, g := range groups {
+		groupGrants := make([]*apiUserGrantResponse, len(g.Grants))
+		for i, grant := range g.Grants {
+			groupGrants[i] = &apiUserGrantResponse{
+				Topic:      grant.TopicPattern,
+				Permission: grant.Allow.String(),
+			}
+		}
+		groupsResponse[i] = &apiGroupResponse{
+			Name:    g.Name,
+			Members: g.Members,
+			Grants:  groupGrants,
+		}
+	}
+	return s.writeJSON(w, groupsResponse)
+}
+
+func (s *Server) handleGroupsAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	} else if !user.AllowedGroup(req.Name) {
+		return errHTTPBadRequest.Wrap("group name invalid")
+	}
+	if err := s.userManager.AddGroup(req.Name); err == user.ErrGroupExists {
+		return errHTTPConflictGroupExists
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupsDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	group, err := s.userManager.Group(req.Name)
+	if err == user.ErrGroupNotFound {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := s.userManager.RemoveGroup(req.Name); err != nil {
+		return err
+	}
+	if err := s.killGroupSubscribers(group, group.Members...); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupMembersAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupMembersRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	} else if len(req.Usernames) == 0 {
+		return errHTTPBadRequest.Wrap("usernames missing")
+	}
+	if err := s.userManager.AddGroupMembers(req.Name, req.Usernames...); err == user.ErrGroupNotFound {
+		return errHTTPBadRequestGroupNotFound
+	} else if err == user.ErrUserNotFound {
+		return errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupMembersRemove(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupMembersRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	} else if len(req.Usernames) == 0 {
+		return errHTTPBadRequest.Wrap("usernames missing")
+	}
+	group, err := s.userManager.Group(req.Name)
+	if err == user.ErrGroupNotFound {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := s.userManager.RemoveGroupMembers(req.Name, req.Usernames...); err != nil {
+		return err
+	}
+	if err := s.killGroupSubscribers(group, req.Usernames...); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupAccessAllow(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupAccessAllowRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	permission, err := user.ParsePermission(req.Permission)
+	if err != nil || permission.IsAuthWrite() {
+		return errHTTPBadRequestPermissionInvalid
+	}
+	if err := s.userManager.AllowGroupAccess(req.Name, req.Topic, permission); err == user.ErrGroupNotFound {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupAccessReset(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupAccessResetRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	group, err := s.userManager.Group(req.Name)
+	if err == user.ErrGroupNotFound {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := s.userManager.ResetGroupAccess(req.Name, req.Topic); err != nil {
+		return err
+	}
+	if req.Topic != "" {
+		group.Grants = []user.Grant{{TopicPattern: req.Topic}}
+	}
+	if err := s.killGroupSubscribers(group, group.Members...); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// killGroupSubscribers cancels the subscriptions of the given group members to all topics matching the
+// group's access control entries, so that clients have to re-authorize with the changed permissions
+func (s *Server) killGroupSubscribers(group *user.Group, usernames ...string) error {
+	for _, username := range usernames {
+		u, err := s.userManager.User(username)
+		if err == user.ErrUserNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, grant := range group.Grants {
+			if err := s.killUserSubscriber(u, grant.TopicPattern); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
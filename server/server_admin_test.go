@@ -4,6 +4,8 @@ import (
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	"io"
+	"path/filepath"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -90,6 +92,95 @@ func TestUser_AddRemove_Failures(t *testing.T) {
 	require.Equal(t, 200, rr.Code)
 }
 
+func TestUser_Impersonate(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AccessLog = filepath.Join(t.TempDir(), "access.log")
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "bens-topic", user.PermissionReadWrite))
+
+	// Impersonate ben
+	rr := request(t, s, "POST", "/v1/users/impersonate", `{"username": "ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	token, err := util.UnmarshalJSON[apiAccountTokenResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, "phil", token.Impersonator)
+	require.True(t, token.Expires <= time.Now().Add(impersonationTokenExpiryDuration).Unix())
+
+	// Act as ben
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, "ben", account.Username)
+	require.Equal(t, "user", account.Role)
+	require.Equal(t, 1, len(account.Tokens))
+	require.Equal(t, "phil", account.Tokens[0].Impersonator) // Ben can see that he was impersonated
+
+	rr = request(t, s, "PUT", "/bens-topic", "test", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Impersonation tokens cannot be used to create or extend tokens
+	rr = request(t, s, "POST", "/v1/account/token", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40306, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PATCH", "/v1/account/token", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40306, toHTTPError(t, rr.Body.String()).Code)
+
+	// Impersonated requests are tagged in the access log
+	entries := readAccessLog(t, c.AccessLog)
+	require.Equal(t, 5, len(entries))
+	require.Equal(t, "phil", entries[0].User)
+	require.Equal(t, "", entries[0].Impersonator)
+	for _, entry := range entries[1:] {
+		require.Equal(t, "ben", entry.User)
+		require.Equal(t, "phil", entry.Impersonator)
+	}
+}
+
+func TestUser_Impersonate_Failures(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("emma", "emma", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+
+	// Regular users cannot impersonate
+	rr := request(t, s, "POST", "/v1/users/impersonate", `{"username": "phil"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Admins cannot be impersonated
+	rr = request(t, s, "POST", "/v1/users/impersonate", `{"username": "emma"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40077, toHTTPError(t, rr.Body.String()).Code)
+
+	// User does not exist
+	rr = request(t, s, "POST", "/v1/users/impersonate", `{"username": "nobody"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40031, toHTTPError(t, rr.Body.String()).Code)
+}
+
 func TestAccess_AllowReset(t *testing.T) {
 	c := newTestConfigWithAuthFile(t)
 	c.AuthDefault = user.PermissionDenyAll
@@ -179,3 +270,100 @@ func TestAccess_AllowReset_KillConnection(t *testing.T) {
 		return timeTaken.Load() >= 500
 	})
 }
+
+func TestGroups_AddMembersAccess(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("emma", "emma", user.RoleUser))
+
+	// Create group, add members and grant access
+	rr := request(t, s, "PUT", "/v1/groups", `{"name": "ops"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/groups", `{"name": "ops"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 409, rr.Code)
+	require.Equal(t, 40905, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/v1/groups/members", `{"name": "ops", "usernames": ["ben", "emma"]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/groups/members", `{"name": "ops", "usernames": ["nobody"]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40031, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/v1/groups/access", `{"name": "ops", "topic": "alerts*", "permission": "rw"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/groups/access", `{"name": "dev", "topic": "alerts*", "permission": "rw"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40044, toHTTPError(t, rr.Body.String()).Code)
+
+	// List groups
+	rr = request(t, s, "GET", "/v1/groups", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	groups, err := util.UnmarshalJSON[[]*apiGroupResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*groups))
+	require.Equal(t, "ops", (*groups)[0].Name)
+	require.Equal(t, []string{"ben", "emma"}, (*groups)[0].Members)
+	require.Equal(t, "alerts*", (*groups)[0].Grants[0].Topic)
+	require.Equal(t, "read-write", (*groups)[0].Grants[0].Permission)
+
+	// Members can publish
+	rr = request(t, s, "PUT", "/alerts-db", "disk full", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Removed members cannot
+	rr = request(t, s, "DELETE", "/v1/groups/members", `{"name": "ops", "usernames": ["ben"]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/alerts-db", "disk full", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Reset access, delete group
+	rr = request(t, s, "DELETE", "/v1/groups/access", `{"name": "ops", "topic": "alerts*"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/alerts-db", "disk full", map[string]string{
+		"Authorization": util.BasicAuth("emma", "emma"),
+	})
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/groups", `{"name": "ops"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	groupsList, err := s.userManager.Groups()
+	require.Nil(t, err)
+	require.Equal(t, 0, len(groupsList))
+}
+
+func TestGroups_NonAdminAttempt(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	rr := request(t, s, "PUT", "/v1/groups", `{"name": "ops"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"errors"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	annotationIDPrefix       = "an_"
+	annotationIDLength       = 12
+	annotationMaxLength      = 256                     // Max length of an annotation in characters
+	annotationMaxBytes       = annotationMaxLength * 4 // Max length of the request body, see annotationMaxLength
+	annotationMaxAnnotations = 20                      // Per message
+)
+
+// handleMessageAnnotationAdd attaches a short annotation (the request body, e.g. "false positive" or a ticket link)
+// to a message, and publishes all annotations of the message to all subscribers of the topic as an "annotations"
+// event. This allows subscribers to triage alerts together, without a separate chat tool.
+func (s *Server) handleMessageAnnotationAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := messageAnnotationsPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topicID, id := matches[1], matches[2]
+	body, err := io.ReadAll(io.LimitReader(r.Body, annotationMaxBytes+1))
+	if err != nil {
+		return err
+	}
+	text := strings.TrimSpace(string(body))
+	if !validAnnotation(text) {
+		return errHTTPBadRequestAnnotationInvalid
+	}
+	m, err := s.cachedMessage(topicID, id)
+	if err != nil {
+		return err
+	}
+	existing, err := s.messageCache.Annotations(m.ID)
+	if err != nil {
+		return err
+	} else if len(existing) >= annotationMaxAnnotations {
+		return errHTTPTooManyRequestsLimitAnnotations
+	}
+	a := &annotation{
+		ID:        util.RandomStringPrefix(annotationIDPrefix, annotationIDLength),
+		Time:      time.Now().Unix(),
+		Text:      text,
+		Annotator: v.Identity(),
+	}
+	if u := v.User(); u != nil {
+		a.Author = u.Name
+	}
+	logvr(v, r).
+		Tag(tagPublish).
+		Fields(log.Context{
+			"message_id":    m.ID,
+			"annotation_id": a.ID,
+		}).
+		Debug("Adding annotation to message %s", m.ID)
+	if err := s.messageCache.AddAnnotation(m.ID, a); err != nil {
+		return err
+	}
+	return s.publishAnnotations(w, v, m)
+}
+
+// handleMessageAnnotationDelete removes an annotation from a message. Visitors can only remove their own annotations.
+func (s *Server) handleMessageAnnotationDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := messageAnnotationPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 4 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	m, err := s.cachedMessage(matches[1], matches[2])
+	if err != nil {
+		return err
+	}
+	if err := s.messageCache.RemoveAnnotation(m.ID, matches[3], v.Identity()); errors.Is(err, errAnnotationNotFound) {
+		return errHTTPNotFoundAnnotation
+	} else if err != nil {
+		return err
+	}
+	return s.publishAnnotations(w, v, m)
+}
+
+// handleMessageAnnotationsGet returns all annotations of a message, oldest first
+func (s *Server) handleMessageAnnotationsGet(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	matches := messageAnnotationsPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	m, err := s.cachedMessage(matches[1], matches[2])
+	if err != nil {
+		return err
+	}
+	items, err := s.messageCache.Annotations(m.ID)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiMessageAnnotationsResponse{
+		ID:          m.ID,
+		Topic:       m.Topic,
+		Annotations: items,
+	})
+}
+
+// publishAnnotations sends all annotations of the message to the subscribers of the topic, and writes them
+// to the response
+func (s *Server) publishAnnotations(w http.ResponseWriter, v *visitor, m *message) error {
+	items, err := s.messageCache.Annotations(m.ID)
+	if err != nil {
+		return err
+	}
+	t, err := s.topicFromID(m.Topic)
+	if err != nil {
+		return err
+	}
+	if err := t.Publish(v, newAnnotationsMessage(m.Topic, m.ID, items)); err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiMessageAnnotationsResponse{
+		ID:          m.ID,
+		Topic:       m.Topic,
+		Annotations: items,
+	})
+}
+
+// validAnnotation returns true if the given string is a single line of text of up to annotationMaxLength characters
+func validAnnotation(s string) bool {
+	if s == "" || !utf8.ValidString(s) || utf8.RuneCountInString(s) > annotationMaxLength {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
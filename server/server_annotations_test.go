@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_MessageAnnotations(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/alerts", "CPU high on db1", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	subscribeResponse := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/alerts/json", subscribeResponse)
+
+	response = request(t, s, "POST", "/alerts/"+m.ID+"/annotations", "  false positive, backup job  \n", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", "/alerts/"+m.ID+"/annotations", "https://tickets.example.com/INC-42", nil, func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4"
+	})
+	require.Equal(t, 200, response.Code)
+	annotations, err := util.UnmarshalJSON[apiMessageAnnotationsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, m.ID, annotations.ID)
+	require.Equal(t, "alerts", annotations.Topic)
+	require.Equal(t, 2, len(annotations.Annotations))
+	require.Equal(t, "false positive, backup job", annotations.Annotations[0].Text)
+	require.Equal(t, "", annotations.Annotations[0].Author)
+	require.Equal(t, "https://tickets.example.com/INC-42", annotations.Annotations[1].Text)
+	require.NotContains(t, response.Body.String(), "1.2.3.4") // Never return the annotator
+
+	// Only the author can remove an annotation
+	response = request(t, s, "DELETE", "/alerts/"+m.ID+"/annotations/"+annotations.Annotations[1].ID, "", nil)
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40411, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "DELETE", "/alerts/"+m.ID+"/annotations/"+annotations.Annotations[0].ID, "", nil)
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/alerts/"+m.ID+"/annotations", "", nil)
+	require.Equal(t, 200, response.Code)
+	annotations, err = util.UnmarshalJSON[apiMessageAnnotationsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(annotations.Annotations))
+	require.Equal(t, "https://tickets.example.com/INC-42", annotations.Annotations[0].Text)
+
+	// Annotations count as acknowledgement, e.g. for escalations
+	acknowledged, err := s.messageCache.MessageAcknowledged(m.ID)
+	require.Nil(t, err)
+	require.True(t, acknowledged)
+
+	// Subscribers receive all annotations as "annotations" events; events are sent asynchronously,
+	// so they may arrive in any order
+	subscribeCancel()
+	events := make([]*message, 0)
+	for _, line := range strings.Split(strings.TrimSpace(subscribeResponse.Body.String()), "\n") {
+		var event message
+		require.Nil(t, json.Unmarshal([]byte(line), &event))
+		if event.Event == annotationsEvent {
+			events = append(events, &event)
+		}
+	}
+	require.Equal(t, 3, len(events))
+	require.Equal(t, "alerts", events[0].Topic)
+	require.Equal(t, m.ID, events[0].Annotations.MessageID)
+}
+
+func TestServer_MessageAnnotations_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/alerts", "CPU high on db1", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	for _, text := range []string{"", "   ", "two\nlines", strings.Repeat("a", annotationMaxLength+1)} {
+		response = request(t, s, "POST", "/alerts/"+m.ID+"/annotations", text, nil)
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40075, toHTTPError(t, response.Body.String()).Code)
+	}
+	response = request(t, s, "POST", "/alerts/abcdefghijkl/annotations", "ack", nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "POST", "/othertopic/"+m.ID+"/annotations", "ack", nil)
+	require.Equal(t, 404, response.Code)
+
+	for i := 0; i < annotationMaxAnnotations; i++ {
+		response = request(t, s, "POST", "/alerts/"+m.ID+"/annotations", "ack", nil)
+		require.Equal(t, 200, response.Code)
+	}
+	response = request(t, s, "POST", "/alerts/"+m.ID+"/annotations", "one too many", nil)
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42915, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_MessageAnnotations_AccessControl(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "alerts", user.PermissionRead))
+
+	response := request(t, s, "PUT", "/alerts", "CPU high on db1", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Annotating requires write access, listing annotations requires read access
+	response = request(t, s, "POST", "/alerts/"+m.ID+"/annotations", "ack", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "POST", "/alerts/"+m.ID+"/annotations", "ack", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/alerts/"+m.ID+"/annotations", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	annotations, err := util.UnmarshalJSON[apiMessageAnnotationsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(annotations.Annotations))
+	require.Equal(t, "phil", annotations.Annotations[0].Author)
+	response = request(t, s, "GET", "/alerts/"+m.ID+"/annotations", "", nil)
+	require.Equal(t, 403, response.Code)
+}
+
+func TestValidAnnotation(t *testing.T) {
+	require.True(t, validAnnotation("false positive"))
+	require.True(t, validAnnotation("https://tickets.example.com/INC-42"))
+	require.True(t, validAnnotation("👀 looking into it"))
+	require.True(t, validAnnotation(strings.Repeat("日", annotationMaxLength)))
+	require.False(t, validAnnotation(""))
+	require.False(t, validAnnotation("tab\tseparated"))
+	require.False(t, validAnnotation(strings.Repeat("a", annotationMaxLength+1)))
+	require.False(t, validAnnotation("\xff"))
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kinds of misbehavior that are counted towards an automatic IP ban, see banTracker
+const (
+	banReasonAuthFailures        = "auth_failures"
+	banReasonRateLimitViolations = "rate_limit_violations"
+)
+
+// Reasons for auth failures other than failed user authentication, see authFailed
+var (
+	errAuthPublishKeyInvalid       = errors.New("invalid publish key")
+	errAuthSCIMTokenInvalid        = errors.New("invalid SCIM token")
+	errAuthSignedURLInvalid        = errors.New("invalid publish URL signature")
+	errAuthWebhookSignatureInvalid = errors.New("invalid webhook signature")
+)
+
+// banTracker counts auth failures and rate limit violations per IP address within a sliding
+// window (ip-ban-window), so that IP addresses can be banned automatically if they exceed the
+// configured thresholds (ip-ban-auth-failures, ip-ban-rate-limit-violations). This is the
+// equivalent of fail2ban's "maxretry" and "findtime", but built into the server.
+type banTracker struct {
+	window time.Duration
+	events map[string]map[netip.Addr][]time.Time // reason -> IP address -> event times
+	mu     sync.Mutex
+}
+
+func newBanTracker(window time.Duration) *banTracker {
+	return &banTracker{
+		window: window,
+		events: make(map[string]map[netip.Addr][]time.Time),
+	}
+}
+
+// Add records an event for the given IP address, and returns the number of events
+// of this kind within the window (including this one)
+func (t *banTracker) Add(reason string, ip netip.Addr) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if _, ok := t.events[reason]; !ok {
+		t.events[reason] = make(map[netip.Addr][]time.Time)
+	}
+	times := append(t.recent(t.events[reason][ip], now), now)
+	t.events[reason][ip] = times
+	return len(times)
+}
+
+// Reset removes all events for the given IP address, e.g. after it was banned
+func (t *banTracker) Reset(ip netip.Addr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, ips := range t.events {
+		delete(ips, ip)
+	}
+}
+
+// Prune removes all events that are outside the window
+func (t *banTracker) Prune() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for _, ips := range t.events {
+		for ip, times := range ips {
+			if times = t.recent(times, now); len(times) > 0 {
+				ips[ip] = times
+			} else {
+				delete(ips, ip)
+			}
+		}
+	}
+}
+
+func (t *banTracker) recent(times []time.Time, now time.Time) []time.Time {
+	for i, e := range times {
+		if now.Sub(e) < t.window {
+			return times[i:]
+		}
+	}
+	return nil
+}
+
+// authFailed is called whenever authentication fails, be it a password, access token, publish key, SCIM token,
+// signed URL or webhook signature. It counts the failure towards the visitor's auth rate limit, writes it to the
+// auth failure log (auth-failure-log-file), and bans the IP address if it exceeds the ip-ban-auth-failures threshold.
+func (s *Server) authFailed(r *http.Request, v *visitor, username string, err error) {
+	v.AuthFailed()
+	s.writeAuthFailureLog(r, v.IP(), username, err)
+	s.maybeBanIP(r, v, banReasonAuthFailures, s.config.IPBanAuthFailures)
+}
+
+// rateLimitViolated is called whenever a request is rejected due to rate limiting. It bans the IP address
+// if it exceeds the ip-ban-rate-limit-violations threshold.
+func (s *Server) rateLimitViolated(r *http.Request, v *visitor) {
+	s.maybeBanIP(r, v, banReasonRateLimitViolations, s.config.IPBanRateLimitViolations)
+}
+
+func (s *Server) maybeBanIP(r *http.Request, v *visitor, reason string, threshold int) {
+	if threshold <= 0 || v.User().IsAdmin() || s.requestLimitExempt(r, v) {
+		return
+	}
+	ip := v.IP()
+	if count := s.banTracker.Add(reason, ip); count < threshold {
+		return
+	}
+	s.banTracker.Reset(ip)
+	expires := time.Now().Add(s.config.IPBanDuration)
+	if err := s.banIP(ip, expires, fmt.Sprintf("%s (%d within %s)", reason, threshold, s.config.IPBanWindow.String())); err != nil {
+		logvr(v, r).Tag(tagAbuse).Err(err).Warn("Cannot ban IP address %s", ip.String())
+		return
+	}
+	logvr(v, r).
+		Tag(tagAbuse).
+		Fields(log.Context{
+			"ban_reason":  reason,
+			"ban_expires": expires.Unix(),
+		}).
+		Info("Banned IP address %s until %s due to %s", ip.String(), expires.Format(time.RFC3339), reason)
+}
+
+// writeAuthFailureLog appends a line to the auth failure log file, if configured. The format is stable and
+// meant to be parsed by tools like fail2ban, e.g.:
+//
+//	2024-04-05T10:15:23Z ntfy auth failure: ip=1.2.3.4 user="phil" method=PUT path="/mytopic" reason="unauthorized"
+//
+// The file is opened for every write, so that it can be rotated (e.g. via logrotate) without restarting the server.
+func (s *Server) writeAuthFailureLog(r *http.Request, ip netip.Addr, username string, err error) {
+	if s.config.AuthFailureLogFile == "" {
+		return
+	}
+	reason := "unauthorized"
+	if err != nil {
+		reason = err.Error()
+	}
+	line := fmt.Sprintf("%s ntfy auth failure: ip=%s user=%s method=%s path=%s reason=%s\n",
+		time.Now().UTC().Format(time.RFC3339), ip.String(), strconv.Quote(username), r.Method, strconv.Quote(r.URL.Path), strconv.Quote(reason))
+	s.authFailureLogMu.Lock()
+	defer s.authFailureLogMu.Unlock()
+	f, err := os.OpenFile(s.config.AuthFailureLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log.Tag(tagAbuse).Err(err).Warn("Cannot open auth failure log file %s", s.config.AuthFailureLogFile)
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line); err != nil {
+		log.Tag(tagAbuse).Err(err).Warn("Cannot write to auth failure log file %s", s.config.AuthFailureLogFile)
+	}
+}
+
+// handleBlocksPost blocks a topic, or bans an IP address (admin only)
+func (s *Server) handleBlocksPost(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiBlocksRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = "manual"
+	}
+	if req.Topic != "" {
+		if !topicRegex.MatchString(req.Topic) {
+			return errHTTPBadRequestTopicInvalid
+		} else if err := s.blockTopic(req.Topic, reason); err != nil {
+			return err
+		}
+		logvr(v, r).Tag(tagAbuse).Info("Admin blocked topic %s", req.Topic)
+	} else if req.IP != "" {
+		ip, err := netip.ParseAddr(req.IP)
+		if err != nil {
+			return errHTTPBadRequest.Wrap("invalid IP address %s", req.IP)
+		}
+		var expires time.Time
+		if req.Duration != "" {
+			duration, err := util.ParseDuration(req.Duration)
+			if err != nil {
+				return errHTTPBadRequest.Wrap("invalid duration %s", req.Duration)
+			}
+			expires = time.Now().Add(duration)
+		}
+		if err := s.banIP(ip, expires, reason); err != nil {
+			return err
+		}
+		logvr(v, r).Tag(tagAbuse).Info("Admin banned IP address %s", ip.String())
+	} else {
+		return errHTTPBadRequest.Wrap("topic or ip must be set")
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBanTracker(t *testing.T) {
+	tracker := newBanTracker(100 * time.Millisecond)
+	ip1, ip2 := netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("1.2.3.5")
+	require.Equal(t, 1, tracker.Add(banReasonAuthFailures, ip1))
+	require.Equal(t, 2, tracker.Add(banReasonAuthFailures, ip1))
+	require.Equal(t, 1, tracker.Add(banReasonRateLimitViolations, ip1))
+	require.Equal(t, 1, tracker.Add(banReasonAuthFailures, ip2))
+
+	tracker.Reset(ip1)
+	require.Equal(t, 1, tracker.Add(banReasonAuthFailures, ip1))
+
+	time.Sleep(150 * time.Millisecond)
+	require.Equal(t, 1, tracker.Add(banReasonAuthFailures, ip1)) // Old events are outside the window
+	tracker.Prune()
+	require.Equal(t, 1, len(tracker.events[banReasonAuthFailures]))
+	require.Equal(t, 0, len(tracker.events[banReasonRateLimitViolations]))
+}
+
+func TestServer_IPBan_AuthFailures(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.IPBanAuthFailures = 3
+	c.IPBanDuration = time.Hour
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+			"Authorization": util.BasicAuth("phil", "wrong"),
+		})
+		require.Equal(t, 401, response.Code)
+	}
+	response := request(t, s, "PUT", "/mytopic", "hi", nil)
+	require.Equal(t, 40304, toHTTPError(t, response.Body.String()).Code)
+
+	// Admins can still use the server, and see the ban
+	response = request(t, s, "GET", "/v1/blocks", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	blocks, err := util.UnmarshalJSON[apiBlocksResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(blocks.IPs))
+	require.Equal(t, "9.9.9.9", blocks.IPs[0].IP)
+	require.Equal(t, "auth_failures (3 within 10m0s)", blocks.IPs[0].Reason)
+	require.InDelta(t, time.Now().Add(time.Hour).Unix(), blocks.IPs[0].Expires, 5)
+
+	// Other IP addresses are not affected
+	response = request(t, s, "PUT", "/mytopic", "hi", nil, func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4"
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_IPBan_RateLimitViolations(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 2
+	c.IPBanRateLimitViolations = 2
+	s := newTestServer(t, c)
+
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "1", nil).Code)
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "2", nil).Code)
+	require.Equal(t, 429, request(t, s, "PUT", "/mytopic", "3", nil).Code)
+	require.False(t, s.ipBanned(netip.MustParseAddr("9.9.9.9")))
+	require.Equal(t, 429, request(t, s, "PUT", "/mytopic", "4", nil).Code)
+	require.True(t, s.ipBanned(netip.MustParseAddr("9.9.9.9")))
+
+	response := request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, 40304, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_IPBan_ExemptHosts(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 1
+	c.IPBanRateLimitViolations = 1
+	c.VisitorRequestExemptIPAddrs = []netip.Prefix{netip.MustParsePrefix("9.9.9.0/24")}
+	s := newTestServer(t, c)
+
+	for i := 0; i < 5; i++ {
+		require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+	}
+	require.False(t, s.ipBanned(netip.MustParseAddr("9.9.9.9")))
+}
+
+func TestServer_IPBan_Expired(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	require.Nil(t, s.banIP(netip.MustParseAddr("9.9.9.9"), time.Now().Add(-time.Second), "test"))
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+
+	s.execManager()
+	bans, err := s.messageCache.BannedIPs()
+	require.Nil(t, err)
+	require.Empty(t, bans)
+}
+
+func TestServer_AuthFailureLog(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthFailureLogFile = filepath.Join(t.TempDir(), "auth-failures.log")
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+
+	response := request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("phil", "wrong"),
+	})
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BearerAuth("tk_invalid"),
+	}, func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4"
+	})
+	require.Equal(t, 401, response.Code)
+
+	// Successful logins are not logged
+	response = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	b, err := os.ReadFile(c.AuthFailureLogFile)
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Equal(t, 2, len(lines))
+	require.Regexp(t, `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z ntfy auth failure: ip=9\.9\.9\.9 user="phil" method=PUT path="/mytopic" reason=".+"$`, lines[0])
+	require.Regexp(t, `^\S+ ntfy auth failure: ip=1\.2\.3\.4 user="" method=GET path="/mytopic/json" reason=".+"$`, lines[1])
+	require.NotContains(t, string(b), "wrong")
+}
+
+func TestServer_AuthFailureLog_PublishKeyAndSCIMToken(t *testing.T) {
+	c := newTestConfigWithSCIM(t)
+	c.AuthDefault = user.PermissionDenyAll
+	c.AuthFailureLogFile = filepath.Join(t.TempDir(), "auth-failures.log")
+	c.IPBanAuthFailures = 3
+	c.IPBanDuration = time.Hour
+	s := newTestServer(t, c)
+
+	// Invalid publish keys, as Bearer token, and with a plain body to the root path
+	response := request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BearerAuth("pk_doesnotexist"),
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "POST", "/", "hi", map[string]string{
+		"Authorization": util.BearerAuth("pk_doesnotexist"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	// Invalid SCIM token
+	response = request(t, s, "GET", "/scim/v2/Users", "", map[string]string{
+		"Authorization": util.BearerAuth("wrong-token"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	b, err := os.ReadFile(c.AuthFailureLogFile)
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Equal(t, 3, len(lines))
+	require.Contains(t, lines[0], `path="/mytopic" reason="invalid publish key"`)
+	require.Contains(t, lines[1], `path="/" reason="invalid publish key"`)
+	require.Contains(t, lines[2], `path="/scim/v2/Users" reason="invalid SCIM token"`)
+
+	// Three failures lead to a ban
+	response = request(t, s, "GET", "/v1/health", "", nil)
+	require.Equal(t, 40304, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Blocks_Post(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+
+	response := request(t, s, "POST", "/v1/blocks", `{"ip":"1.2.3.4"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	response = request(t, s, "POST", "/v1/blocks", `{"ip":"1.2.3.4","duration":"2h","reason":"spammer"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", "/v1/blocks", `{"topic":"badtopic"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "POST", "/v1/blocks", `{"ip":"not-an-ip"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+	response = request(t, s, "POST", "/v1/blocks", `{"ip":"1.2.3.4","duration":"forever"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+	response = request(t, s, "POST", "/v1/blocks", `{}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+
+	response = request(t, s, "PUT", "/mytopic", "hi", nil, func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4"
+	})
+	require.Equal(t, 40304, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/badtopic", "hi", nil)
+	require.Equal(t, 40303, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/v1/blocks", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	blocks, err := util.UnmarshalJSON[apiBlocksResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(blocks.IPs))
+	require.Equal(t, "spammer", blocks.IPs[0].Reason)
+	require.Equal(t, 1, len(blocks.Topics))
+	require.Equal(t, "manual", blocks.Topics[0].Reason)
+}
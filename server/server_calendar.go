@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"heckel.io/ntfy/v2/user"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	calendarContentType     = "text/calendar; charset=utf-8"
+	calendarTimeFormat      = "20060102T150405Z"
+	calendarLineLength      = 75 // Max line length in octets, excluding the line break, see RFC 5545, section 3.1
+	calendarRefreshInterval = "PT15M"
+)
+
+// handleAccountScheduledCalendar returns the pending scheduled (delayed) messages on the topics reserved by
+// the user as iCalendar feed (RFC 5545), so that upcoming reminders can be shown in a calendar app. Since most
+// calendar apps cannot send an Authorization header, the feed is typically subscribed to with the ?auth= param.
+func (s *Server) handleAccountScheduledCalendar(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	reservations, err := s.userManager.Reservations(u.Name)
+	if err != nil {
+		return err
+	}
+	pending, err := s.messageCache.MessagesPending()
+	if err != nil {
+		return err
+	}
+	messages := make([]*message, 0)
+	for _, m := range pending {
+		if calendarTopicReserved(reservations, m.Topic) {
+			messages = append(messages, m)
+		}
+	}
+	logvr(v, r).Tag(tagAccount).Debug("Serving calendar with %d scheduled message(s)", len(messages))
+	w.Header().Set("Content-Type", calendarContentType)
+	_, err = io.WriteString(w, s.scheduledCalendar(s.feedBaseURL(r), messages))
+	return err
+}
+
+func (s *Server) scheduledCalendar(baseURL string, messages []*message) string {
+	host := baseURL
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	now := time.Now().UTC().Format(calendarTimeFormat)
+	var c calendarWriter
+	c.Property("BEGIN", "VCALENDAR")
+	c.Property("VERSION", "2.0")
+	c.Property("PRODID", fmt.Sprintf("-//ntfy//%s//EN", strings.TrimSpace("ntfy "+s.config.Version)))
+	c.Property("CALSCALE", "GREGORIAN")
+	c.Property("X-WR-CALNAME", calendarEscape("ntfy scheduled messages"))
+	c.Property("REFRESH-INTERVAL;VALUE=DURATION", calendarRefreshInterval)
+	c.Property("X-PUBLISHED-TTL", calendarRefreshInterval)
+	for _, m := range messages {
+		start := time.Unix(m.Time, 0).UTC().Format(calendarTimeFormat)
+		c.Property("BEGIN", "VEVENT")
+		c.Property("UID", calendarEscape(fmt.Sprintf("%s@%s", m.ID, host)))
+		c.Property("DTSTAMP", now)
+		c.Property("DTSTART", start)
+		c.Property("DTEND", start)
+		c.Property("SUMMARY", calendarEscape(atomEntryTitle(m)))
+		c.Property("DESCRIPTION", calendarEscape(m.Message))
+		if len(m.Tags) > 0 {
+			tags := make([]string, len(m.Tags))
+			for i, tag := range m.Tags {
+				tags[i] = calendarEscape(tag)
+			}
+			c.Property("CATEGORIES", strings.Join(tags, ","))
+		}
+		c.Property("URL", fmt.Sprintf("%s/%s", baseURL, m.Topic))
+		c.Property("END", "VEVENT")
+	}
+	c.Property("END", "VCALENDAR")
+	return c.String()
+}
+
+// calendarTopicReserved returns true if the topic matches one of the reservations, which
+// may contain wildcards (e.g. "alerts*")
+func calendarTopicReserved(reservations []user.Reservation, topic string) bool {
+	for _, r := range reservations {
+		if !strings.Contains(r.Topic, "*") {
+			if r.Topic == topic {
+				return true
+			}
+			continue
+		}
+		pattern := "^" + strings.ReplaceAll(regexp.QuoteMeta(r.Topic), `\*`, ".*") + "$"
+		if matched, _ := regexp.MatchString(pattern, topic); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// calendarWriter writes iCalendar content lines, folded to calendarLineLength octets
+type calendarWriter struct {
+	b strings.Builder
+}
+
+func (c *calendarWriter) Property(name, value string) {
+	line := name + ":" + value
+	limit := calendarLineLength
+	for len(line) > limit {
+		n := limit
+		for n > 0 && !utf8.RuneStart(line[n]) { // Do not split multi-byte characters
+			n--
+		}
+		c.b.WriteString(line[:n])
+		c.b.WriteString("\r\n ")
+		line = line[n:]
+		limit = calendarLineLength - 1 // Continuation lines start with a space
+	}
+	c.b.WriteString(line)
+	c.b.WriteString("\r\n")
+}
+
+func (c *calendarWriter) String() string {
+	return c.b.String()
+}
+
+// calendarEscape escapes a TEXT value, see RFC 5545, section 3.3.11
+func calendarEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_AccountScheduledCalendar(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.EnableReservations = true
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddReservation("phil", "reminders", user.PermissionDenyAll))
+	require.Nil(t, s.userManager.AddReservation("phil", "backup-*", user.PermissionDenyAll))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "other", user.PermissionReadWrite))
+
+	auth := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+	publish := func(topic, message string, headers map[string]string) {
+		for k, v := range auth {
+			headers[k] = v
+		}
+		rr := request(t, s, "PUT", "/"+topic, message, headers)
+		require.Equal(t, 200, rr.Code)
+	}
+	publish("reminders", "Take out the trash, and the recycling; today", map[string]string{"Delay": "2h", "Title": "Trash day", "Tags": "wastebasket"})
+	publish("backup-nas", "Nightly backup\nof the NAS", map[string]string{"Delay": "1h"})
+	publish("reminders", "Not scheduled", map[string]string{})
+	publish("other", "Not reserved", map[string]string{"Delay": "1h"})
+
+	rr := request(t, s, "GET", "/v1/account/scheduled.ics", "", auth)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "text/calendar; charset=utf-8", rr.Header().Get("Content-Type"))
+	ics := rr.Body.String()
+	require.True(t, strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	require.True(t, strings.HasSuffix(ics, "END:VEVENT\r\nEND:VCALENDAR\r\n"))
+	require.Equal(t, 2, strings.Count(ics, "BEGIN:VEVENT"))
+	require.NotContains(t, ics, "Not scheduled")
+	require.NotContains(t, ics, "Not reserved")
+
+	// Ordered by delivery time
+	backup := strings.Index(ics, "SUMMARY:Nightly backup\r\n")
+	trash := strings.Index(ics, "SUMMARY:Trash day\r\n")
+	require.True(t, backup > 0 && trash > backup)
+	require.Contains(t, ics, "DESCRIPTION:Nightly backup\\nof the NAS\r\n")
+	require.Contains(t, ics, "DESCRIPTION:Take out the trash\\, and the recycling\\; today\r\n")
+	require.Contains(t, ics, "CATEGORIES:wastebasket\r\n")
+	require.Contains(t, ics, "URL:http://127.0.0.1:12345/reminders\r\n")
+	require.Contains(t, ics, fmt.Sprintf("DTSTART:%s", time.Now().Add(2 * time.Hour).UTC().Format(calendarTimeFormat)[:11]))
+
+	// Calendar apps cannot send headers, so the auth query param must work
+	authParam := base64.RawURLEncoding.EncodeToString([]byte(util.BasicAuth("phil", "phil")))
+	rr = request(t, s, "GET", "/v1/account/scheduled.ics?auth="+authParam, "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, 2, strings.Count(rr.Body.String(), "BEGIN:VEVENT"))
+
+	rr = request(t, s, "GET", "/v1/account/scheduled.ics", "", nil)
+	require.Equal(t, 401, rr.Code)
+}
+
+func TestCalendarWriter_Folding(t *testing.T) {
+	var c calendarWriter
+	c.Property("DESCRIPTION", strings.Repeat("a", 70)+"äöü"+strings.Repeat("b", 80))
+	lines := strings.Split(strings.TrimSuffix(c.String(), "\r\n"), "\r\n")
+	require.Equal(t, 3, len(lines))
+	for i, line := range lines {
+		require.LessOrEqual(t, len(line), calendarLineLength)
+		if i > 0 {
+			require.True(t, strings.HasPrefix(line, " "))
+		}
+	}
+	unfolded := strings.ReplaceAll(strings.TrimSuffix(c.String(), "\r\n"), "\r\n ", "")
+	require.Equal(t, "DESCRIPTION:"+strings.Repeat("a", 70)+"äöü"+strings.Repeat("b", 80), unfolded)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/subtle"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// csrfCookieName is the name of the cookie that holds the CSRF token. The cookie is readable from
+	// JavaScript, so that the web app can send the token back in the csrfHeaderName header (double-submit cookie).
+	csrfCookieName = "ntfy-csrf"
+
+	// csrfHeaderName is the name of the header that must carry the CSRF token, if CSRF protection is enabled
+	csrfHeaderName = "X-CSRF-Token"
+
+	// csrfTokenLength is the length of the randomly generated CSRF token
+	csrfTokenLength = 32
+)
+
+// setCORSHeaders sets the CORS response headers, depending on the request's Origin header and the configured
+// allowed origins (access-control-allow-origins). If all origins are allowed ("*"), and credentials are not
+// allowed, the wildcard is returned. Otherwise, the request's origin is reflected if it is allowed.
+func (s *Server) setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if util.Contains(s.config.AccessControlAllowOrigins, "*") && !s.config.AccessControlAllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Add("Vary", "Origin")
+	if origin == "" || !s.corsOriginAllowed(r) {
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if s.config.AccessControlAllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// corsOriginAllowed returns true if requests from the request's origin (e.g. https://example.com) are allowed.
+// Requests without an Origin header (e.g. from curl or other non-browser clients) and same-origin requests (e.g.
+// from the web app) are always allowed, even if they are not in the list of allowed origins.
+func (s *Server) corsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || util.Contains(s.config.AccessControlAllowOrigins, "*") || s.sameOrigin(r, origin) {
+		return true
+	}
+	for _, allowed := range s.config.AccessControlAllowOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin returns true if the given origin is the server itself, i.e. if its host matches the Host header
+// of the request, or if it matches the configured base-url
+func (s *Server) sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	} else if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return s.config.BaseURL != "" && strings.EqualFold(strings.TrimSuffix(s.config.BaseURL, "/"), origin)
+}
+
+// handleOptions responds to CORS preflight requests
+func (s *Server) handleOptions(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(s.config.AccessControlAllowMethods, ", "))
+	if s.config.AccessControlAllowCredentials && r.Header.Get("Access-Control-Request-Headers") != "" {
+		// The wildcard is not honored for requests with credentials, so we have to be explicit
+		w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+	} else {
+		w.Header().Set("Access-Control-Allow-Headers", "*") // CORS, allow auth via JS // FIXME is this terrible?
+	}
+	return nil
+}
+
+// checkCSRF verifies the CSRF token of state-changing requests (anything but GET, HEAD and OPTIONS), if CSRF
+// protection is enabled (csrf-protection). This protects users whose browser automatically attaches cookies to
+// requests (e.g. if the server is behind an SSO proxy) from cross-site publishing. Only requests that carry cookies,
+// and no explicit credentials (Authorization header, or ?auth= param) are checked, since all other requests cannot be
+// forged by a third-party site. The token is handed out by setCSRFCookie, and must be sent in the X-CSRF-Token header.
+func (s *Server) checkCSRF(r *http.Request) error {
+	if !s.config.CSRFProtection {
+		return nil
+	} else if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return nil
+	} else if len(r.Cookies()) == 0 {
+		return nil
+	} else if header, _ := readAuthHeader(r); header != "" {
+		return nil
+	}
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil || cookie.Value == "" {
+		return errHTTPForbiddenCSRFTokenInvalid
+	}
+	token := r.Header.Get(csrfHeaderName)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) != 1 {
+		return errHTTPForbiddenCSRFTokenInvalid
+	}
+	return nil
+}
+
+// setCSRFCookie sets the CSRF token cookie (see checkCSRF), if CSRF protection is enabled and the cookie
+// is not set yet. It is called when serving the web app config, so the web app always has a valid token.
+func (s *Server) setCSRFCookie(w http.ResponseWriter, r *http.Request) {
+	if !s.config.CSRFProtection {
+		return
+	} else if cookie, err := r.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    util.RandomString(csrfTokenLength),
+		Path:     "/",
+		Secure:   strings.HasPrefix(s.config.BaseURL, "https://"),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
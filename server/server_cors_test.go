@@ -0,0 +1,169 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"testing"
+)
+
+func TestServer_CORS_AllowAllOrigins(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "OPTIONS", "/mytopic", "", map[string]string{
+		"Origin": "https://example.com",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "*", response.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "GET, PUT, POST, PATCH, DELETE", response.Header().Get("Access-Control-Allow-Methods"))
+	require.Equal(t, "*", response.Header().Get("Access-Control-Allow-Headers"))
+	require.Equal(t, "", response.Header().Get("Access-Control-Allow-Credentials"))
+
+	response = request(t, s, "PUT", "/mytopic", "hi there", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "*", response.Header().Get("Access-Control-Allow-Origin"))
+
+	response = request(t, s, "PUT", "/mytopic?priority=invalid", "hi there", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, "*", response.Header().Get("Access-Control-Allow-Origin")) // Errors as well
+}
+
+func TestServer_CORS_RestrictedOrigins(t *testing.T) {
+	c := newTestConfig(t)
+	c.AccessControlAllowOrigins = []string{"https://ntfy.example.com", "https://intranet.example.com/"}
+	c.AccessControlAllowMethods = []string{"GET", "POST"}
+	c.AccessControlAllowCredentials = true
+	s := newTestServer(t, c)
+
+	response := request(t, s, "OPTIONS", "/mytopic", "", map[string]string{
+		"Origin":                         "https://intranet.example.com",
+		"Access-Control-Request-Headers": "Authorization, X-Title",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "https://intranet.example.com", response.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "true", response.Header().Get("Access-Control-Allow-Credentials"))
+	require.Equal(t, "GET, POST", response.Header().Get("Access-Control-Allow-Methods"))
+	require.Equal(t, "Authorization, X-Title", response.Header().Get("Access-Control-Allow-Headers"))
+	require.Equal(t, "Origin", response.Header().Get("Vary"))
+
+	response = request(t, s, "POST", "/mytopic", "hi there", map[string]string{
+		"Origin": "https://evil.example.com",
+	})
+	require.Equal(t, "", response.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "", response.Header().Get("Access-Control-Allow-Credentials"))
+
+	response = request(t, s, "POST", "/mytopic", "hi there", nil) // Non-browser clients are unaffected
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "", response.Header().Get("Access-Control-Allow-Origin"))
+
+	for origin, allowed := range map[string]bool{
+		"":                         true,
+		"https://ntfy.example.com": true,
+		"https://evil.example.com": false,
+	} {
+		r, _ := http.NewRequest("GET", "https://other.example.com/mytopic/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		require.Equal(t, allowed, s.corsOriginAllowed(r), origin)
+	}
+}
+
+func TestServer_CORS_RestrictedOrigins_SameOrigin(t *testing.T) {
+	c := newTestConfig(t)
+	c.BaseURL = "https://ntfy.example.com"
+	c.AccessControlAllowOrigins = []string{"https://intranet.example.com"}
+	s := newTestServer(t, c)
+
+	// The server's own origin (the web app) is always allowed, via the Host header or the base-url
+	for _, test := range []struct {
+		host    string
+		origin  string
+		allowed bool
+	}{
+		{"ntfy.example.com", "https://ntfy.example.com", true},
+		{"10.0.0.1:8080", "http://10.0.0.1:8080", true},
+		{"10.0.0.1:8080", "https://ntfy.example.com", true}, // Behind a proxy
+		{"ntfy.example.com", "https://evil.example.com", false},
+		{"ntfy.example.com", "https://ntfy.example.com.evil.com", false},
+	} {
+		r, _ := http.NewRequest("GET", "/mytopic/ws", nil)
+		r.Host = test.host
+		r.Header.Set("Origin", test.origin)
+		require.Equal(t, test.allowed, s.corsOriginAllowed(r), test.origin)
+	}
+
+	response := request(t, s, "POST", "/mytopic", "hi there", map[string]string{
+		"Origin": "https://ntfy.example.com",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "https://ntfy.example.com", response.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestServer_CSRF_Protection(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.CSRFProtection = true
+	c.AuthDefault = user.PermissionReadWrite
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+
+	// Web app config hands out the CSRF cookie
+	response := request(t, s, "GET", "/config.js", "", nil)
+	require.Equal(t, 200, response.Code)
+	cookies := response.Result().Cookies()
+	require.Equal(t, 1, len(cookies))
+	require.Equal(t, "ntfy-csrf", cookies[0].Name)
+	token := cookies[0].Value
+	require.Equal(t, 32, len(token))
+
+	// Cookie is not re-issued if it is already set
+	response = request(t, s, "GET", "/config.js", "", map[string]string{
+		"Cookie": "ntfy-csrf=" + token,
+	})
+	require.Equal(t, 0, len(response.Result().Cookies()))
+
+	// Requests without cookies (e.g. curl), or with explicit credentials are not affected
+	response = request(t, s, "PUT", "/mytopic", "no cookies", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "with auth", map[string]string{
+		"Cookie":        "_oauth2_proxy=abc",
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Read requests with cookies are not affected
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Cookie": "_oauth2_proxy=abc",
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Cookie-authenticated requests need the token
+	response = request(t, s, "PUT", "/mytopic", "no token", map[string]string{
+		"Cookie": "_oauth2_proxy=abc",
+	})
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40302, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "wrong token", map[string]string{
+		"Cookie":       "_oauth2_proxy=abc; ntfy-csrf=" + token,
+		"X-CSRF-Token": "wrong",
+	})
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "PUT", "/mytopic", "correct token", map[string]string{
+		"Cookie":       "_oauth2_proxy=abc; ntfy-csrf=" + token,
+		"X-CSRF-Token": token,
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_CSRF_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "GET", "/config.js", "", nil)
+	require.Equal(t, 0, len(response.Result().Cookies()))
+	response = request(t, s, "PUT", "/mytopic", "no token", map[string]string{
+		"Cookie": "_oauth2_proxy=abc",
+	})
+	require.Equal(t, 200, response.Code)
+}
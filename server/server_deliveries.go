@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"heckel.io/ntfy/v2/log"
+	"net/http"
+	"time"
+)
+
+const (
+	deliveryChannelSubscribers = "subscribers" // JSON/SSE/raw/WebSocket subscribers connected to the server
+	deliveryChannelWebPush     = "webpush"
+	deliveryChannelFirebase    = "firebase"
+	deliveryChannelUpstream    = "upstream"
+	deliveryChannelEmail       = "email"
+	deliveryChannelCall        = "call"
+
+	deliveryOutcomeDelivered = "delivered"
+	deliveryOutcomeFailed    = "failed"
+
+	deliveryErrorMaxLength = 256
+)
+
+// recordDelivery records a delivery attempt of a message, so that the publisher can query it via
+// GET /<topic>/<id>/deliveries. Deliveries are only recorded for messages that are cached, since they
+// are looked up (and deleted) together with the message.
+func (s *Server) recordDelivery(m *message, channel string, count int, err error) {
+	if m.Expires == 0 || m.Event != messageEvent {
+		return
+	}
+	d := &delivery{
+		MessageID: m.ID,
+		Time:      time.Now().Unix(),
+		Channel:   channel,
+		Outcome:   deliveryOutcomeDelivered,
+		Count:     count,
+	}
+	if err != nil {
+		d.Outcome = deliveryOutcomeFailed
+		d.Error = err.Error()
+		if len(d.Error) > deliveryErrorMaxLength {
+			d.Error = d.Error[:deliveryErrorMaxLength]
+		}
+	}
+	if err := s.messageCache.AddDelivery(d); err != nil {
+		log.With(m).Err(err).Warn("Cannot record delivery via %s", channel)
+	}
+}
+
+// handleMessageDeliveries returns the recorded delivery attempts of a message. Only the publisher of the message
+// (the same user, or the same IP address for anonymous messages) and admins are allowed to see them.
+func (s *Server) handleMessageDeliveries(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := messageDeliveriesPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic, id := matches[1], matches[2]
+	m, err := s.messageCache.Message(id)
+	if errors.Is(err, errMessageNotFound) || (err == nil && m.Topic != topic) {
+		return errHTTPNotFoundMessage
+	} else if err != nil {
+		return err
+	} else if !s.publishedBy(v, m) {
+		return errHTTPForbidden
+	}
+	deliveries, err := s.messageCache.Deliveries(id)
+	if err != nil {
+		return err
+	}
+	response := &apiMessageDeliveriesResponse{
+		ID:         m.ID,
+		Topic:      m.Topic,
+		Time:       m.Time,
+		Deliveries: make([]*apiMessageDelivery, 0),
+	}
+	for _, d := range deliveries {
+		response.Deliveries = append(response.Deliveries, &apiMessageDelivery{
+			Time:    d.Time,
+			Channel: d.Channel,
+			Outcome: d.Outcome,
+			Count:   d.Count,
+			Error:   d.Error,
+		})
+	}
+	return s.writeJSON(w, response)
+}
+
+// publishedBy returns true if the visitor published the message, or is an admin
+func (s *Server) publishedBy(v *visitor, m *message) bool {
+	u := v.User()
+	if u.IsAdmin() {
+		return true
+	} else if m.User != "" {
+		return u != nil && u.ID == m.User
+	}
+	return u == nil && m.Sender.IsValid() && m.Sender == v.IP()
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_MessageDeliveries(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	s.smtpSender = &testMailer{}
+
+	response := request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+		"Email": "test@example.com",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	time.Sleep(100 * time.Millisecond) // Email is sent asynchronously
+
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/deliveries", "", nil)
+	require.Equal(t, 200, response.Code)
+	deliveries, err := util.UnmarshalJSON[apiMessageDeliveriesResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, m.ID, deliveries.ID)
+	require.Equal(t, "mytopic", deliveries.Topic)
+	require.Equal(t, 2, len(deliveries.Deliveries))
+	require.Equal(t, deliveryChannelSubscribers, deliveries.Deliveries[0].Channel)
+	require.Equal(t, deliveryOutcomeDelivered, deliveries.Deliveries[0].Outcome)
+	require.Equal(t, 0, deliveries.Deliveries[0].Count)
+	require.Equal(t, deliveryChannelEmail, deliveries.Deliveries[1].Channel)
+	require.Equal(t, deliveryOutcomeDelivered, deliveries.Deliveries[1].Outcome)
+	require.Equal(t, 1, deliveries.Deliveries[1].Count)
+
+	// Other IP addresses are not allowed to see the deliveries
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/deliveries", "", nil, func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4"
+	})
+	require.Equal(t, 403, response.Code)
+
+	// Unknown message, or message in another topic
+	response = request(t, s, "GET", "/mytopic/abcdefghijkl/deliveries", "", nil)
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40403, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "GET", "/othertopic/"+m.ID+"/deliveries", "", nil)
+	require.Equal(t, 404, response.Code)
+
+	// Deliveries are deleted with the message
+	require.Nil(t, s.messageCache.DeleteMessages(m.ID))
+	deliveryList, err := s.messageCache.Deliveries(m.ID)
+	require.Nil(t, err)
+	require.Empty(t, deliveryList)
+}
+
+func TestServer_MessageDeliveries_Subscribers(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	subscribeResponse := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/json", subscribeResponse)
+	response := request(t, s, "PUT", "/mytopic", "some message", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	subscribeCancel()
+
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/deliveries", "", nil)
+	require.Equal(t, 200, response.Code)
+	deliveries, err := util.UnmarshalJSON[apiMessageDeliveriesResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(deliveries.Deliveries))
+	require.Equal(t, 1, deliveries.Deliveries[0].Count)
+}
+
+func TestServer_MessageDeliveries_User(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AddUser("emma", "emma", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("emma", "mytopic", user.PermissionReadWrite))
+
+	response := request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Publisher and admin are allowed
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/deliveries", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/deliveries", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Other users and anonymous visitors (even with the same IP) are not
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/deliveries", "", map[string]string{
+		"Authorization": util.BasicAuth("emma", "emma"),
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/deliveries", "", nil)
+	require.Equal(t, 403, response.Code)
+}
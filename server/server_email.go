@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+// handleEmailWebhook receives bounce and spam complaint events from the configured e-mail provider, and marks the
+// affected addresses as undeliverable. Publishing with an undeliverable address is rejected, so that ntfy does not
+// hurt its sender reputation by repeatedly sending to addresses that do not exist or do not want the e-mails.
+func (s *Server) handleEmailWebhook(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	body, err := util.Peek(r.Body, emailWebhookRequestBytesLimit)
+	if err != nil {
+		return err
+	} else if body.LimitReached {
+		return errHTTPEntityTooLargeJSONBody.WithLimit(emailWebhookRequestBytesLimit)
+	}
+	event, err := parseEmailWebhook(s.config, r, body.PeekedBytes, time.Now())
+	if errors.Is(err, errEmailWebhookSignatureInvalid) {
+		return errHTTPUnauthorized
+	} else if err != nil {
+		return errHTTPBadRequest.Wrap("%s", err.Error())
+	}
+	if event.SubscribeURL != "" {
+		if err := s.confirmEmailWebhookSubscription(event.SubscribeURL); err != nil {
+			return err
+		}
+		logvr(v, r).Tag(tagEmail).Info("Confirmed SNS subscription for bounce webhook")
+		return s.writeJSON(w, newSuccessResponse())
+	}
+	if err := s.markEmailsUndeliverable(event.Bounces); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// markEmailsUndeliverable marks the bounced addresses as undeliverable. If an address was not marked before,
+// the publisher of the last e-mail to the address is notified with a server-generated message to its topic.
+// It is called for bounce webhooks, and for bounces received by the SMTP server, see smtpSession.Data.
+func (s *Server) markEmailsUndeliverable(bounces []*emailBounce) error {
+	for _, bounce := range bounces {
+		undeliverable, err := s.messageCache.EmailUndeliverable(bounce.Address)
+		if err != nil {
+			return err
+		} else if err := s.messageCache.MarkEmailUndeliverable(bounce.Address, bounce.Reason); err != nil {
+			return err
+		}
+		log.
+			Tag(tagEmail).
+			Field("email", bounce.Address).
+			Info("Marking email address %s as undeliverable: %s", bounce.Address, bounce.Reason)
+		if !undeliverable {
+			if err := s.notifyEmailUndeliverable(bounce); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// notifyEmailUndeliverable publishes a warning to the topic of the last e-mail that was sent to the bounced
+// address, so that the publisher learns that their e-mails are no longer delivered, see UpdateEmailRecipient
+func (s *Server) notifyEmailUndeliverable(bounce *emailBounce) error {
+	topic, userID, err := s.messageCache.EmailRecipient(bounce.Address)
+	if err != nil {
+		return err
+	} else if topic == "" {
+		return nil // Nothing was sent to the address, or it was a topic-less e-mail (e.g. quota warning)
+	}
+	var u *user.User
+	if userID != "" && s.userManager != nil {
+		u, err = s.userManager.UserByID(userID)
+		if err != nil && !errors.Is(err, user.ErrUserNotFound) {
+			return err
+		}
+	}
+	v := s.visitor(netip.IPv4Unspecified(), u)
+	lang := userLanguage(u)
+	m := newDefaultMessage(topic, translate(lang, "email_undeliverable_body", bounce.Address, bounce.Reason))
+	m.Title = translate(lang, "email_undeliverable_title", bounce.Address)
+	m.Tags = []string{"warning"}
+	m.Priority = 4
+	go s.publishServerMessage(v, m)
+	return nil
+}
+
+// emailUndeliverable returns true if the address was marked as undeliverable. This is checked when publishing, and
+// again right before sending, since the address may have bounced in the meantime (e.g. for batched e-mails).
+func (s *Server) emailUndeliverable(email string) bool {
+	undeliverable, err := s.messageCache.EmailUndeliverable(email)
+	if err != nil {
+		log.Tag(tagEmail).Err(err).Warn("Cannot check if email address %s is undeliverable", email)
+		return false
+	}
+	return undeliverable
+}
+
+// updateEmailRecipient remembers the topic and user of the message sent to the address, see notifyEmailUndeliverable
+func (s *Server) updateEmailRecipient(v *visitor, m *message, email string) {
+	if m.Topic == "" {
+		return
+	}
+	if err := s.messageCache.UpdateEmailRecipient(email, m.Topic, v.MaybeUserID()); err != nil {
+		logvm(v, m).Tag(tagEmail).Err(err).Warn("Cannot update email recipient %s", email)
+	}
+}
+
+// confirmEmailWebhookSubscription confirms an Amazon SNS subscription by fetching the subscribe URL. The URL
+// was validated to point to Amazon SNS in parseSESWebhook.
+func (s *Server) confirmEmailWebhookSubscription(subscribeURL string) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(subscribeURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errHTTPBadRequest.Wrap("confirming SNS subscription failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// handleEmailUndeliverableGet lists all e-mail addresses that were marked as undeliverable (admin only)
+func (s *Server) handleEmailUndeliverableGet(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	emails, err := s.messageCache.UndeliverableEmails()
+	if err != nil {
+		return err
+	}
+	response := make([]*apiUndeliverableEmail, 0, len(emails))
+	for _, e := range emails {
+		response = append(response, &apiUndeliverableEmail{
+			Email:  e.Address,
+			Time:   e.Time,
+			Reason: e.Reason,
+		})
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleEmailUndeliverableDelete allows sending e-mails to an address again, e.g. after a user fixed a typo in
+// their mailbox name, or a temporary problem was reported as permanent bounce (admin only)
+func (s *Server) handleEmailUndeliverableDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUndeliverableEmailRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	} else if req.Email == "" {
+		return errHTTPBadRequest.Wrap("email must be set")
+	}
+	if err := s.messageCache.RemoveUndeliverableEmail(req.Email); err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagEmail).Info("Admin marked email address %s as deliverable again", req.Email)
+	return s.writeJSON(w, newSuccessResponse())
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestServer_EmailUndeliverable_NotifiesPublisher(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	response := request(t, s, "PATCH", "/v1/account/settings", `{"language": "de"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "PUT", "/alerts", "disk full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Email":         "nobody@example.com",
+	})
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return mailer.Count() == 1
+	})
+
+	// Bounce marks the address, and notifies the publisher in their language
+	require.Nil(t, s.markEmailsUndeliverable([]*emailBounce{{Address: "nobody@example.com", Reason: "bounce: 5.1.1"}}))
+	waitFor(t, func() bool {
+		return len(toMessages(t, request(t, s, "GET", "/alerts/json?poll=1", "", nil).Body.String())) == 2
+	})
+	messages := toMessages(t, request(t, s, "GET", "/alerts/json?poll=1", "", nil).Body.String())
+	require.Equal(t, "E-Mails an nobody@example.com werden nicht mehr zugestellt", messages[1].Title)
+	require.True(t, strings.Contains(messages[1].Message, "(bounce: 5.1.1)"))
+	require.Equal(t, 4, messages[1].Priority)
+
+	// Repeated bounces do not notify again
+	require.Nil(t, s.markEmailsUndeliverable([]*emailBounce{{Address: "nobody@example.com", Reason: "bounce: 5.1.1"}}))
+
+	// No more e-mails are sent, even if the message was accepted before the bounce
+	s.sendEmail(s.visitor(netip.IPv4Unspecified(), nil), newDefaultMessage("alerts", "still full"), "nobody@example.com")
+	require.Equal(t, 1, mailer.Count())
+	response = request(t, s, "PUT", "/alerts", "disk full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Email":         "nobody@example.com",
+	})
+	require.Equal(t, 40054, toHTTPError(t, response.Body.String()).Code)
+	require.Equal(t, 2, len(toMessages(t, request(t, s, "GET", "/alerts/json?poll=1", "", nil).Body.String())))
+}
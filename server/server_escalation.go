@@ -0,0 +1,255 @@
+package server
+
+import (
+	"errors"
+	"net/netip"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	escalationDefaultPriority = 4 // Escalate high and urgent messages by default
+	escalationMaxSteps        = 10
+	escalationMinDelay        = time.Minute
+	escalationTag             = "rotating_light"
+)
+
+// scheduleEscalation schedules the first escalation step for a message published to a reserved topic with an
+// escalation policy (see user.Escalation), if the message's priority is high enough. Replies are never escalated,
+// since they are typically acknowledgements themselves. Errors are logged, but not returned, since the message has
+// already been published.
+func (s *Server) scheduleEscalation(v *visitor, m *message) {
+	if s.userManager == nil || m.Event != messageEvent || m.PollID != "" || m.InReplyTo != "" {
+		return
+	}
+	policy, _, err := s.userManager.ReservationEscalation(m.Topic)
+	if err != nil {
+		logvm(v, m).Tag(tagPublish).Err(err).Warn("Cannot read escalation policy")
+		return
+	} else if policy == nil || len(policy.Steps) == 0 || messagePriority(m) < policy.Priority {
+		return
+	}
+	e := &escalation{
+		MessageID: m.ID,
+		Topic:     m.Topic,
+		Step:      0,
+		Due:       time.Now().Add(policy.Steps[0].Delay).Unix(),
+	}
+	if err := s.messageCache.AddEscalation(e); err != nil {
+		logvm(v, m).Tag(tagPublish).Err(err).Warn("Cannot schedule escalation")
+		return
+	}
+	logvm(v, m).Tag(tagPublish).Debug("Scheduled escalation of message in %s, unless acknowledged", policy.Steps[0].Delay)
+}
+
+// escalateMessages executes all escalation steps that are due, unless the message was acknowledged (replied to,
+// reacted to or annotated) in the meantime. After each step, the next step of the policy is scheduled, until the chain ends.
+func (s *Server) escalateMessages() error {
+	if s.userManager == nil {
+		return nil
+	}
+	escalations, err := s.messageCache.EscalationsDue()
+	if err != nil {
+		return err
+	}
+	for _, e := range escalations {
+		if err := s.escalateMessage(e); err != nil {
+			log.Tag(tagPublish).Field("message_id", e.MessageID).Err(err).Warn("Error escalating message")
+			if err := s.messageCache.RemoveEscalation(e.MessageID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) escalateMessage(e *escalation) error {
+	m, err := s.messageCache.Message(e.MessageID)
+	if errors.Is(err, errMessageNotFound) {
+		return s.messageCache.RemoveEscalation(e.MessageID) // Message expired or was deleted
+	} else if err != nil {
+		return err
+	}
+	acknowledged, err := s.messageCache.MessageAcknowledged(m.ID)
+	if err != nil {
+		return err
+	} else if acknowledged {
+		log.Tag(tagPublish).With(m).Debug("Message was acknowledged, stopping escalation")
+		return s.messageCache.RemoveEscalation(m.ID)
+	}
+	policy, ownerUserID, err := s.userManager.ReservationEscalation(m.Topic)
+	if err != nil {
+		return err
+	} else if policy == nil || e.Step >= len(policy.Steps) {
+		return s.messageCache.RemoveEscalation(m.ID) // Policy was removed or changed
+	}
+	owner, err := s.userManager.UserByID(ownerUserID)
+	if err != nil {
+		return err
+	}
+	v := s.visitor(netip.IPv4Unspecified(), owner)
+	step := policy.Steps[e.Step]
+	logvm(v, m).
+		Tag(tagPublish).
+		Fields(log.Context{
+			"escalation_step":    e.Step + 1,
+			"escalation_topic":   step.Topic,
+			"escalation_email":   step.Email,
+			"escalation_call":    step.Call,
+			"escalation_on_call": step.OnCall,
+		}).
+		Info("Escalating unacknowledged message")
+	if step.Topic != "" {
+		if err := s.publishEscalation(v, m, step.Topic); err != nil {
+			logvm(v, m).Tag(tagPublish).Err(err).Warn("Cannot publish escalation to topic %s", step.Topic)
+		}
+	}
+	s.notifyEscalationContact(v, m, step.Email, step.Call)
+	if step.OnCall {
+		if member, err := s.onCallMember(m.Topic); err != nil {
+			logvm(v, m).Tag(tagPublish).Err(err).Warn("Cannot determine on-call member")
+		} else if member == nil {
+			logvm(v, m).Tag(tagPublish).Info("Not notifying on-call member, topic has no on-call schedule")
+		} else {
+			logvm(v, m).Tag(tagPublish).Field("escalation_on_call_member", member.Name).Debug("Notifying on-call member %s", member.Name)
+			s.notifyEscalationContact(v, m, member.Email, member.Call)
+		}
+	}
+	if e.Step+1 >= len(policy.Steps) {
+		return s.messageCache.RemoveEscalation(m.ID)
+	}
+	return s.messageCache.AddEscalation(&escalation{
+		MessageID: m.ID,
+		Topic:     m.Topic,
+		Step:      e.Step + 1,
+		Due:       time.Now().Add(policy.Steps[e.Step+1].Delay).Unix(),
+	})
+}
+
+// notifyEscalationContact sends the message to the given e-mail address, and calls the given phone number (each
+// if not empty), on behalf of the owner of the reservation
+func (s *Server) notifyEscalationContact(v *visitor, m *message, email, call string) {
+	if s.smtpSender != nil && email != "" {
+		if v.EmailAllowed() {
+			go s.sendEmail(v, m, email)
+		} else {
+			logvm(v, m).Tag(tagEmail).Field("email", email).Info("Not sending escalation email, owner of the reservation exceeded the email limit")
+		}
+	}
+	if s.config.TwilioAccount != "" && call != "" {
+		if v.CallAllowed() {
+			go s.callPhone(v, nil, m, call)
+		} else {
+			logvm(v, m).Tag(tagTwilio).Field("twilio_to", call).Info("Not making escalation call, owner of the reservation exceeded the call limit")
+		}
+	}
+}
+
+// publishEscalation republishes a copy of the message to the given topic, on behalf of the owner of the reservation.
+// The copy is not escalated itself, which prevents escalation loops between topics.
+func (s *Server) publishEscalation(v *visitor, m *message, topicID string) error {
+	if !v.MessageAllowed() {
+		return errHTTPTooManyRequestsLimitMessages
+	}
+	em := newDefaultMessage(topicID, m.Message)
+	em.Title = m.Title
+	em.Priority = m.Priority
+	em.Tags = append([]string{escalationTag}, m.Tags...)
+	em.Click = m.Click
+	em.Sender = v.IP()
+	em.User = v.MaybeUserID()
+	s.publishServerMessage(v, em)
+	return nil
+}
+
+// validateEscalation checks the escalation policy of a reservation request, and converts it to the policy stored
+// in the user database. The owner must be allowed to publish to escalation topics, e-mails and calls must be enabled,
+// and phone numbers must be verified by the user.
+func (s *Server) validateEscalation(u *user.User, topic string, escalation *apiEscalation) (*user.Escalation, error) {
+	if escalation == nil || len(escalation.Steps) == 0 {
+		return nil, nil
+	} else if len(escalation.Steps) > escalationMaxSteps {
+		return nil, errHTTPBadRequestEscalationInvalid
+	}
+	priority := escalation.Priority
+	if priority == 0 {
+		priority = escalationDefaultPriority
+	} else if priority < 1 || priority > 5 {
+		return nil, errHTTPBadRequestEscalationInvalid
+	}
+	policy := &user.Escalation{
+		Priority: priority,
+		Steps:    make([]*user.EscalationStep, 0, len(escalation.Steps)),
+	}
+	for _, step := range escalation.Steps {
+		if step == nil || (step.Topic == "" && step.Email == "" && step.Call == "" && !step.OnCall) {
+			return nil, errHTTPBadRequestEscalationInvalid
+		}
+		delay, err := util.ParseDuration(step.Delay)
+		if err != nil || delay < escalationMinDelay {
+			return nil, errHTTPBadRequestEscalationInvalid
+		}
+		if step.Topic != "" {
+			if !topicRegex.MatchString(step.Topic) || step.Topic == topic {
+				return nil, errHTTPBadRequestEscalationInvalid
+			} else if err := s.userManager.Authorize(u, step.Topic, user.PermissionWrite); err != nil {
+				return nil, errHTTPForbidden
+			}
+		}
+		if step.Email != "" && s.smtpSender == nil {
+			return nil, errHTTPBadRequestEmailDisabled
+		}
+		call := step.Call
+		if call != "" {
+			if s.config.TwilioAccount == "" {
+				return nil, errHTTPBadRequestPhoneCallsDisabled
+			} else if !isBoolValue(call) && !phoneNumberRegex.MatchString(call) {
+				return nil, errHTTPBadRequestPhoneNumberInvalid
+			}
+			var httpErr *errHTTP
+			call, httpErr = s.convertPhoneNumber(u, call)
+			if httpErr != nil {
+				return nil, httpErr
+			}
+		}
+		policy.Steps = append(policy.Steps, &user.EscalationStep{
+			Delay:  delay,
+			Topic:  step.Topic,
+			Email:  step.Email,
+			Call:   call,
+			OnCall: step.OnCall,
+		})
+	}
+	return policy, nil
+}
+
+func toAPIEscalation(policy *user.Escalation) *apiEscalation {
+	if policy == nil {
+		return nil
+	}
+	steps := make([]*apiEscalationStep, 0, len(policy.Steps))
+	for _, step := range policy.Steps {
+		steps = append(steps, &apiEscalationStep{
+			Delay:  step.Delay.String(),
+			Topic:  step.Topic,
+			Email:  step.Email,
+			Call:   step.Call,
+			OnCall: step.OnCall,
+		})
+	}
+	return &apiEscalation{
+		Priority: policy.Priority,
+		Steps:    steps,
+	}
+}
+
+// messagePriority returns the priority of the message, treating an unset priority as the default priority
+func messagePriority(m *message) int {
+	if m.Priority == 0 {
+		return 3
+	}
+	return m.Priority
+}
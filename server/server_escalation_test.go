@@ -0,0 +1,132 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"testing"
+)
+
+func TestServer_Escalation(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		EmailLimit:       10,
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+
+	// Publishing to the escalation topic is not allowed
+	escalation := `{"priority":4,"steps":[{"delay":"5m","topic":"alerts-oncall"},{"delay":"10m","email":"boss@example.com"}]}`
+	rr := request(t, s, "POST", "/v1/account/reservation", `{"topic": "alerts", "everyone":"write-only", "escalation": `+escalation+`}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Invalid policies
+	for _, invalid := range []string{
+		`{"steps":[{"delay":"5m"}]}`,
+		`{"steps":[{"delay":"10s","email":"boss@example.com"}]}`,
+		`{"priority":6,"steps":[{"delay":"5m","email":"boss@example.com"}]}`,
+		`{"steps":[{"delay":"5m","topic":"alerts"}]}`,
+	} {
+		rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "alerts", "everyone":"write-only", "escalation": `+invalid+`}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code)
+		require.Equal(t, 40059, toHTTPError(t, rr.Body.String()).Code)
+	}
+
+	// Reserve topic with escalation policy
+	require.Nil(t, s.userManager.AllowAccess("phil", "alerts-oncall", user.PermissionReadWrite))
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "alerts", "everyone":"write-only", "escalation": `+escalation+`}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, 4, account.Reservations[0].Escalation.Priority)
+	require.Equal(t, "5m0s", account.Reservations[0].Escalation.Steps[0].Delay)
+	require.Equal(t, "boss@example.com", account.Reservations[0].Escalation.Steps[1].Email)
+
+	// Only high priority messages that are not replies are escalated
+	rr = request(t, s, "PUT", "/alerts", "disk almost full", map[string]string{"Priority": "low"})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/alerts", "server down", map[string]string{"Priority": "urgent", "Tags": "skull"})
+	require.Equal(t, 200, rr.Code)
+	down := toMessage(t, rr.Body.String())
+	rr = request(t, s, "PUT", "/alerts", "database down", map[string]string{"Priority": "high"})
+	require.Equal(t, 200, rr.Code)
+	database := toMessage(t, rr.Body.String())
+	rr = request(t, s, "PUT", "/alerts", "on it", map[string]string{"Priority": "high", "In-Reply-To": database.ID})
+	require.Equal(t, 200, rr.Code)
+
+	// Nothing is due yet
+	require.Nil(t, s.escalateMessages())
+	rr = request(t, s, "GET", "/alerts-oncall/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 0, len(toMessages(t, rr.Body.String())))
+
+	// First step republishes the unacknowledged message; the acknowledged one is not escalated
+	_, err := s.messageCache.db.Exec(`UPDATE escalations SET due = 0`)
+	require.Nil(t, err)
+	require.Nil(t, s.escalateMessages())
+	rr = request(t, s, "GET", "/alerts-oncall/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "server down", messages[0].Message)
+	require.Equal(t, 5, messages[0].Priority)
+	require.Equal(t, []string{"rotating_light", "skull"}, messages[0].Tags)
+	require.Equal(t, 0, mailer.Count())
+
+	// Second step sends an e-mail
+	_, err = s.messageCache.db.Exec(`UPDATE escalations SET due = 0`)
+	require.Nil(t, err)
+	require.Nil(t, s.escalateMessages())
+	waitFor(t, func() bool {
+		return mailer.Count() == 1
+	})
+	require.Equal(t, "server down", mailer.Messages()[0].Message)
+
+	// Chain has ended
+	escalations, err := s.messageCache.EscalationsDue()
+	require.Nil(t, err)
+	require.Empty(t, escalations)
+
+	// Reactions acknowledge a message, too
+	rr = request(t, s, "PUT", "/alerts", "server down again", map[string]string{"Priority": "urgent"})
+	require.Equal(t, 200, rr.Code)
+	again := toMessage(t, rr.Body.String())
+	require.NotEqual(t, down.ID, again.ID)
+	rr = request(t, s, "PUT", "/alerts/"+again.ID+"/reactions/eyes", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	_, err = s.messageCache.db.Exec(`UPDATE escalations SET due = 0`)
+	require.Nil(t, err)
+	require.Nil(t, s.escalateMessages())
+	escalations, err = s.messageCache.EscalationsDue()
+	require.Nil(t, err)
+	require.Empty(t, escalations)
+	rr = request(t, s, "GET", "/alerts-oncall/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 1, len(toMessages(t, rr.Body.String())))
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"net/netip"
+)
+
+// handleRateLimitExemptionsGet lists all hosts and users that are exempt from request and message rate limiting,
+// including the hosts defined in visitor-request-limit-exempt-hosts (admin only)
+func (s *Server) handleRateLimitExemptionsGet(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	exemptions, err := s.messageCache.RateLimitExemptions()
+	if err != nil {
+		return err
+	}
+	response := make([]*apiRateLimitExemption, 0)
+	for _, prefix := range s.config.VisitorRequestExemptIPAddrs {
+		response = append(response, &apiRateLimitExemption{
+			Host:   prefix.String(),
+			Config: true,
+		})
+	}
+	for _, e := range exemptions {
+		exemption := &apiRateLimitExemption{
+			Time:   e.Time,
+			Reason: e.Reason,
+		}
+		if e.Kind == rateLimitExemptionKindUser {
+			exemption.User = s.usernameByID(e.Target)
+		} else {
+			exemption.Host = e.Target
+		}
+		response = append(response, exemption)
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleRateLimitExemptionsAdd exempts a host or a user from request and message rate limiting, and from
+// automatic IP bans (admin only). Exemptions are persisted, and take effect immediately.
+func (s *Server) handleRateLimitExemptionsAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiRateLimitExemptionRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	kind, target, err := s.rateLimitExemptionTarget(req)
+	if err != nil {
+		return err
+	}
+	if kind == rateLimitExemptionKindHost {
+		if _, err := util.ParseIPHostPrefix(target); err != nil {
+			return errHTTPBadRequest.Wrap("invalid host %s", target)
+		}
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = "manual"
+	}
+	if err := s.messageCache.AddRateLimitExemption(kind, target, reason); err != nil {
+		return err
+	} else if err := s.loadRateLimitExemptions(); err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAbuse).Info("Admin exempted %s %s from rate limiting", kind, target)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleRateLimitExemptionsDelete removes a host or user exemption that was added via the API (admin only)
+func (s *Server) handleRateLimitExemptionsDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiRateLimitExemptionRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	kind, target, err := s.rateLimitExemptionTarget(req)
+	if err != nil {
+		return err
+	}
+	if err := s.messageCache.RemoveRateLimitExemption(kind, target); err != nil {
+		return err
+	} else if err := s.loadRateLimitExemptions(); err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAbuse).Info("Admin removed rate limit exemption for %s %s", kind, target)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// rateLimitExemptionTarget returns the kind and target of the exemption referenced in the request. Users are
+// referenced by name in the API, but stored by ID, so that exemptions survive a user being renamed.
+func (s *Server) rateLimitExemptionTarget(req *apiRateLimitExemptionRequest) (rateLimitExemptionKind, string, error) {
+	if req.Host != "" && req.User != "" {
+		return "", "", errHTTPBadRequest.Wrap("only one of host or user can be set")
+	} else if req.Host != "" {
+		return rateLimitExemptionKindHost, req.Host, nil
+	} else if req.User != "" {
+		if s.userManager == nil {
+			return "", "", errHTTPBadRequest.Wrap("user exemptions require auth to be enabled")
+		}
+		u, err := s.userManager.User(req.User)
+		if errors.Is(err, user.ErrUserNotFound) {
+			return "", "", errHTTPNotFoundUser
+		} else if err != nil {
+			return "", "", err
+		}
+		return rateLimitExemptionKindUser, u.ID, nil
+	}
+	return "", "", errHTTPBadRequest.Wrap("host or user must be set")
+}
+
+// loadRateLimitExemptions loads the rate limit exemptions from the database into memory. Hostnames are resolved
+// when the exemptions are loaded, i.e. when the server starts, and whenever an exemption is added or removed.
+func (s *Server) loadRateLimitExemptions() error {
+	exemptions, err := s.messageCache.RateLimitExemptions()
+	if err != nil {
+		return err
+	}
+	hosts := make([]netip.Prefix, 0)
+	users := make(map[string]bool)
+	for _, e := range exemptions {
+		switch e.Kind {
+		case rateLimitExemptionKindHost:
+			prefixes, err := util.ParseIPHostPrefix(e.Target)
+			if err != nil {
+				log.Tag(tagAbuse).Err(err).Warn("Cannot resolve host %s, ignoring rate limit exemption", e.Target)
+				continue
+			}
+			hosts = append(hosts, prefixes...)
+		case rateLimitExemptionKindUser:
+			users[e.Target] = true
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exemptHosts = hosts
+	s.exemptUsers = users
+	return nil
+}
+
+// rateLimitExempt returns true if the visitor's IP address or user was exempted from rate limiting via the API
+func (s *Server) rateLimitExempt(v *visitor) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if util.ContainsIP(s.exemptHosts, v.IP()) {
+		return true
+	}
+	userID := v.MaybeUserID()
+	return userID != "" && s.exemptUsers[userID]
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestServer_RateLimitExemptions_Host(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.VisitorRequestLimitBurst = 2
+	c.VisitorRequestLimitReplenish = time.Hour
+	c.VisitorRequestExemptIPAddrs = []netip.Prefix{netip.MustParsePrefix("1.2.3.0/24")}
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	admin := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+
+	for i := 0; i < 2; i++ {
+		require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+	}
+	require.Equal(t, 429, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+
+	// Exempt host, and it is no longer rate limited
+	response := request(t, s, "POST", "/v1/exemptions", `{"host":"9.9.9.0/24","reason":"monitoring"}`, admin)
+	require.Equal(t, 200, response.Code)
+	for i := 0; i < 5; i++ {
+		require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+	}
+
+	// List includes hosts from the config
+	response = request(t, s, "GET", "/v1/exemptions", "", admin)
+	require.Equal(t, 200, response.Code)
+	exemptions, err := util.UnmarshalJSON[[]*apiRateLimitExemption](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(*exemptions))
+	require.Equal(t, "1.2.3.0/24", (*exemptions)[0].Host)
+	require.True(t, (*exemptions)[0].Config)
+	require.Equal(t, "9.9.9.0/24", (*exemptions)[1].Host)
+	require.Equal(t, "monitoring", (*exemptions)[1].Reason)
+	require.False(t, (*exemptions)[1].Config)
+
+	// Exemption is persisted
+	require.Nil(t, s.loadRateLimitExemptions())
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+
+	// Remove exemption
+	response = request(t, s, "DELETE", "/v1/exemptions", `{"host":"9.9.9.0/24"}`, admin)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, 429, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+}
+
+func TestServer_RateLimitExemptions_User(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.VisitorRequestLimitBurst = 2
+	c.VisitorRequestLimitReplenish = time.Hour
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "mytopic", user.PermissionReadWrite))
+	admin := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+	ben := map[string]string{"Authorization": util.BasicAuth("ben", "ben")}
+	otherIP := func(r *http.Request) {
+		r.RemoteAddr = "5.6.7.8"
+	}
+
+	response := request(t, s, "POST", "/v1/exemptions", `{"user":"ben"}`, admin, otherIP)
+	require.Equal(t, 200, response.Code)
+	for i := 0; i < 5; i++ {
+		require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi", ben).Code)
+	}
+
+	// Anonymous visitors from the same IP address are still rate limited
+	for i := 0; i < 2; i++ {
+		require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+	}
+	require.Equal(t, 429, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+
+	response = request(t, s, "GET", "/v1/exemptions", "", admin, otherIP)
+	exemptions, err := util.UnmarshalJSON[[]*apiRateLimitExemption](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*exemptions))
+	require.Equal(t, "ben", (*exemptions)[0].User)
+	require.Equal(t, "manual", (*exemptions)[0].Reason)
+
+	response = request(t, s, "DELETE", "/v1/exemptions", `{"user":"ben"}`, admin, otherIP)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, 429, request(t, s, "PUT", "/mytopic", "hi", ben).Code)
+}
+
+func TestServer_RateLimitExemptions_Failures(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	admin := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+
+	response := request(t, s, "POST", "/v1/exemptions", `{"host":"1.2.3.4"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "POST", "/v1/exemptions", `{}`, admin)
+	require.Equal(t, 400, response.Code)
+	response = request(t, s, "POST", "/v1/exemptions", `{"host":"1.2.3.4","user":"ben"}`, admin)
+	require.Equal(t, 400, response.Code)
+	response = request(t, s, "POST", "/v1/exemptions", `{"host":"not a host!"}`, admin)
+	require.Equal(t, 400, response.Code)
+	response = request(t, s, "POST", "/v1/exemptions", `{"user":"does-not-exist"}`, admin)
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40402, toHTTPError(t, response.Body.String()).Code)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+)
+
+// exportCSVHeader is the header row of a CSV export, see exportCSVRecord
+var exportCSVHeader = []string{
+	"id", "time", "expires", "event", "topic", "title", "message", "priority", "tags", "click", "icon", "sound",
+	"actions", "attachment_name", "attachment_type", "attachment_size", "attachment_expires", "attachment_url",
+	"poll_id", "content_type", "encoding", "in_reply_to", "location", "hostname", "correlation_id",
+}
+
+// handleTopicExport returns the entire cached history of a topic as CSV or NDJSON file, so that it can be archived
+// or analyzed without access to the message cache database. Like when polling, the "since", "scheduled" and filter
+// parameters can be used to limit the export. Messages are oldest first.
+func (s *Server) handleTopicExport(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := exportPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	format := strings.ToLower(readParam(r, "x-format", "format"))
+	if format == "" {
+		format = exportFormatNDJSON
+	} else if format != exportFormatCSV && format != exportFormatNDJSON {
+		return errHTTPBadRequestExportFormatInvalid
+	}
+	since, err := parseSince(r, true)
+	if err != nil {
+		return err
+	}
+	filters, err := parseQueryFilters(r)
+	if err != nil {
+		return err
+	}
+	scheduled := readBoolParam(r, false, "x-scheduled", "scheduled", "sched")
+	logvr(v, r).Tag(tagSubscribe).Debug("Exporting messages of topic %s as %s", topic, format)
+	filename := fmt.Sprintf("ntfy-%s-%s.%s", topic, time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if format == exportFormatCSV {
+		return s.writeExportCSV(w, topic, since, scheduled, filters)
+	}
+	return s.writeExportNDJSON(w, topic, since, scheduled, filters)
+}
+
+// writeExportNDJSON streams the messages from the message cache to the response, one JSON object per line
+func (s *Server) writeExportNDJSON(w http.ResponseWriter, topic string, since sinceMarker, scheduled bool, filters *queryFilter) error {
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	encoder := json.NewEncoder(w)
+	return s.messageCache.MessagesEach(topic, since, scheduled, func(m *message) error {
+		if !filters.Pass(m) {
+			return nil
+		}
+		return encoder.Encode(m)
+	})
+}
+
+// writeExportCSV streams the messages from the message cache to the response as CSV, see exportCSVRecord
+func (s *Server) writeExportCSV(w http.ResponseWriter, topic string, since sinceMarker, scheduled bool, filters *queryFilter) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writer := csv.NewWriter(w)
+	if err := writer.Write(exportCSVHeader); err != nil {
+		return err
+	}
+	err := s.messageCache.MessagesEach(topic, since, scheduled, func(m *message) error {
+		if !filters.Pass(m) {
+			return nil
+		}
+		record, err := exportCSVRecord(m)
+		if err != nil {
+			return err
+		}
+		return writer.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// exportCSVRecord converts a message to a CSV row, matching exportCSVHeader. Tags are comma-separated,
+// and actions are JSON-encoded.
+func exportCSVRecord(m *message) ([]string, error) {
+	var actions, location string
+	if len(m.Actions) > 0 {
+		b, err := json.Marshal(m.Actions)
+		if err != nil {
+			return nil, err
+		}
+		actions = string(b)
+	}
+	if m.Location != nil {
+		location = m.Location.String()
+	}
+	var attachmentName, attachmentType, attachmentSize, attachmentExpires, attachmentURL string
+	if m.Attachment != nil {
+		attachmentName = m.Attachment.Name
+		attachmentType = m.Attachment.Type
+		attachmentSize = strconv.FormatInt(m.Attachment.Size, 10)
+		attachmentExpires = strconv.FormatInt(m.Attachment.Expires, 10)
+		attachmentURL = m.Attachment.URL
+	}
+	return []string{
+		m.ID,
+		strconv.FormatInt(m.Time, 10),
+		strconv.FormatInt(m.Expires, 10),
+		m.Event,
+		m.Topic,
+		m.Title,
+		m.Message,
+		strconv.Itoa(messagePriority(m)),
+		strings.Join(m.Tags, ","),
+		m.Click,
+		m.Icon,
+		m.Sound,
+		actions,
+		attachmentName,
+		attachmentType,
+		attachmentSize,
+		attachmentExpires,
+		attachmentURL,
+		m.PollID,
+		m.ContentType,
+		m.Encoding,
+		m.InReplyTo,
+		location,
+		m.Hostname,
+		m.CorrelationID,
+	}, nil
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/csv"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"strings"
+	"testing"
+)
+
+func TestServer_Export_NDJSON(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	rr := request(t, s, "POST", "/mytopic", "first", map[string]string{"Tags": "a,b"})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/mytopic", "second", map[string]string{"Priority": "high", "Location": "52.52,13.405"})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/othertopic", "other", nil)
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/mytopic/export", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "application/x-ndjson; charset=utf-8", rr.Header().Get("Content-Type"))
+	require.Regexp(t, `^attachment; filename="ntfy-mytopic-\d{8}-\d{6}\.ndjson"$`, rr.Header().Get("Content-Disposition"))
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "first", messages[0].Message)
+	require.Equal(t, []string{"a", "b"}, messages[0].Tags)
+	require.Equal(t, "second", messages[1].Message)
+	require.Equal(t, 4, messages[1].Priority)
+	require.Equal(t, 13.405, messages[1].Location.Longitude)
+
+	// Filters and since work like polling
+	rr = request(t, s, "GET", "/mytopic/export?priority=high&since="+messages[0].ID, "", nil)
+	require.Equal(t, 200, rr.Code)
+	filtered := toMessages(t, rr.Body.String())
+	require.Equal(t, 1, len(filtered))
+	require.Equal(t, "second", filtered[0].Message)
+}
+
+func TestServer_Export_CSV(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	rr := request(t, s, "POST", "/mytopic", "multi\nline, \"quoted\"", map[string]string{
+		"Title":   "A title",
+		"Tags":    "warning,backup",
+		"Actions": "view, Open, https://example.com",
+	})
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+
+	rr = request(t, s, "GET", "/mytopic/export?format=csv", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	require.Contains(t, rr.Header().Get("Content-Disposition"), ".csv")
+	records, err := csv.NewReader(strings.NewReader(rr.Body.String())).ReadAll()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(records))
+	require.Equal(t, exportCSVHeader, records[0])
+	row := make(map[string]string)
+	for i, column := range records[0] {
+		row[column] = records[1][i]
+	}
+	require.Equal(t, m.ID, row["id"])
+	require.Equal(t, "message", row["event"])
+	require.Equal(t, "mytopic", row["topic"])
+	require.Equal(t, "A title", row["title"])
+	require.Equal(t, "multi\nline, \"quoted\"", row["message"])
+	require.Equal(t, "3", row["priority"])
+	require.Equal(t, "warning,backup", row["tags"])
+	require.Contains(t, row["actions"], `"url":"https://example.com"`)
+	require.Equal(t, "", row["attachment_name"])
+}
+
+func TestServer_Export_InvalidFormat(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	rr := request(t, s, "GET", "/mytopic/export?format=xml", "", nil)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40074, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestServer_Export_AccessControl(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	rr := request(t, s, "POST", "/mytopic", "secret", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/mytopic/export", "", nil)
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "GET", "/mytopic/export", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, 1, len(toMessages(t, rr.Body.String())))
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// feedEntriesLimit is the maximum number of entries in an Atom feed. Only the newest messages are included.
+	feedEntriesLimit = 100
+
+	// feedEntryTitleLength is the maximum length of an entry title, if it is derived from the message body
+	feedEntryTitleLength = 80
+
+	atomNamespace   = "http://www.w3.org/2005/Atom"
+	atomContentType = "application/atom+xml; charset=utf-8"
+)
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"feed"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Links   []*atomLink  `xml:"link"`
+	Author  *atomAuthor  `xml:"author"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID         string          `xml:"id"`
+	Title      string          `xml:"title"`
+	Updated    string          `xml:"updated"`
+	Published  string          `xml:"published"`
+	Links      []*atomLink     `xml:"link,omitempty"`
+	Categories []*atomCategory `xml:"category,omitempty"`
+	Content    *atomContent    `xml:"content"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// handleSubscribeFeed returns the cached messages of one or more topics as Atom feed, so that they can be consumed
+// by feed readers and dashboards that cannot hold a streaming connection. The "since" and filter parameters work
+// like they do for polling, but only the newest feedEntriesLimit messages are included.
+func (s *Server) handleSubscribeFeed(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topics, topicsStr, err := s.topicsFromPath(r.URL.Path)
+	if err != nil {
+		return err
+	}
+	since, err := parseSince(r, true)
+	if err != nil {
+		return err
+	}
+	filters, err := parseQueryFilters(r)
+	if err != nil {
+		return err
+	}
+	messages := make([]*message, 0)
+	if !since.IsNone() {
+		for _, t := range topics {
+			topicMessages, err := s.messageCache.Messages(t.ID, since, false)
+			if err != nil {
+				return err
+			}
+			for i := len(topicMessages) - 1; i >= 0; i-- { // Newest first, also for messages with the same timestamp
+				if m := topicMessages[i]; m.Event == messageEvent && filters.Pass(m) {
+					messages = append(messages, m)
+				}
+			}
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Time > messages[j].Time
+	})
+	if len(messages) > feedEntriesLimit {
+		messages = messages[:feedEntriesLimit]
+	}
+	logvr(v, r).Tag(tagSubscribe).Debug("Serving Atom feed with %d message(s)", len(messages))
+	feed := s.atomFeed(r, topicsStr, messages)
+	w.Header().Set("Content-Type", atomContentType)
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(feed)
+}
+
+func (s *Server) atomFeed(r *http.Request, topicsStr string, messages []*message) *atomFeed {
+	baseURL := s.feedBaseURL(r)
+	topicURL := fmt.Sprintf("%s/%s", baseURL, topicsStr)
+	updated := time.Now()
+	if len(messages) > 0 {
+		updated = time.Unix(messages[0].Time, 0)
+	}
+	feed := &atomFeed{
+		XMLNS:   atomNamespace,
+		ID:      topicURL,
+		Title:   fmt.Sprintf("ntfy: %s", strings.ReplaceAll(topicsStr, ",", ", ")),
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []*atomLink{
+			{Rel: "self", Href: topicURL + "/feed.atom", Type: "application/atom+xml"},
+			{Rel: "alternate", Href: topicURL, Type: "text/html"},
+		},
+		Author:  &atomAuthor{Name: "ntfy"},
+		Entries: make([]*atomEntry, 0, len(messages)),
+	}
+	for _, m := range messages {
+		feed.Entries = append(feed.Entries, atomEntryFromMessage(baseURL, m))
+	}
+	return feed
+}
+
+func atomEntryFromMessage(baseURL string, m *message) *atomEntry {
+	published := time.Unix(m.Time, 0).UTC().Format(time.RFC3339)
+	entry := &atomEntry{
+		ID:         fmt.Sprintf("%s/%s#%s", baseURL, m.Topic, m.ID),
+		Title:      atomEntryTitle(m),
+		Updated:    published,
+		Published:  published,
+		Links:      make([]*atomLink, 0),
+		Categories: make([]*atomCategory, 0),
+		Content:    &atomContent{Type: "text", Value: m.Message},
+	}
+	if m.Click != "" {
+		entry.Links = append(entry.Links, &atomLink{Rel: "alternate", Href: m.Click})
+	}
+	if m.Attachment != nil && m.Attachment.URL != "" {
+		entry.Links = append(entry.Links, &atomLink{
+			Rel:    "enclosure",
+			Href:   m.Attachment.URL,
+			Type:   m.Attachment.Type,
+			Length: m.Attachment.Size,
+		})
+	}
+	for _, tag := range m.Tags {
+		entry.Categories = append(entry.Categories, &atomCategory{Term: tag})
+	}
+	return entry
+}
+
+// atomEntryTitle returns the message title, or the first line of the message body if there is no title
+func atomEntryTitle(m *message) string {
+	if m.Title != "" {
+		return m.Title
+	}
+	title, _, _ := strings.Cut(strings.TrimSpace(m.Message), "\n")
+	if runes := []rune(title); len(runes) > feedEntryTitleLength {
+		title = string(runes[:feedEntryTitleLength-3]) + "..."
+	}
+	if title == "" {
+		return m.Topic
+	}
+	return title
+}
+
+// feedBaseURL returns the configured base-url, or if it is not set, the URL derived from the request
+func (s *Server) feedBaseURL(r *http.Request) string {
+	if s.config.BaseURL != "" {
+		return s.config.BaseURL
+	} else if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/xml"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"strings"
+	"testing"
+)
+
+func TestServer_Feed(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "first message", map[string]string{
+		"Title": "Backup done",
+		"Tags":  "white_check_mark,backup",
+		"Click": "https://example.com/backups",
+	})
+	require.Equal(t, 200, response.Code)
+	m1 := toMessage(t, response.Body.String())
+	response = request(t, s, "PUT", "/mytopic", "second message\nwith two lines", nil)
+	require.Equal(t, 200, response.Code)
+	m2 := toMessage(t, response.Body.String())
+
+	response = request(t, s, "GET", "/mytopic/feed.atom", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "application/atom+xml; charset=utf-8", response.Header().Get("Content-Type"))
+	require.True(t, strings.HasPrefix(response.Body.String(), `<?xml version="1.0" encoding="UTF-8"?>`))
+
+	feed := toAtomFeed(t, response.Body.String())
+	require.Equal(t, "http://127.0.0.1:12345/mytopic", feed.ID)
+	require.Equal(t, "ntfy: mytopic", feed.Title)
+	require.Equal(t, "http://127.0.0.1:12345/mytopic/feed.atom", feed.Links[0].Href)
+	require.Equal(t, 2, len(feed.Entries))
+
+	// Newest first
+	require.Equal(t, "http://127.0.0.1:12345/mytopic#"+m2.ID, feed.Entries[0].ID)
+	require.Equal(t, "second message", feed.Entries[0].Title)
+	require.Equal(t, "second message\nwith two lines", feed.Entries[0].Content.Value)
+	require.Empty(t, feed.Entries[0].Links)
+
+	require.Equal(t, "http://127.0.0.1:12345/mytopic#"+m1.ID, feed.Entries[1].ID)
+	require.Equal(t, "Backup done", feed.Entries[1].Title)
+	require.Equal(t, "first message", feed.Entries[1].Content.Value)
+	require.Equal(t, "alternate", feed.Entries[1].Links[0].Rel)
+	require.Equal(t, "https://example.com/backups", feed.Entries[1].Links[0].Href)
+	require.Equal(t, 2, len(feed.Entries[1].Categories))
+	require.Equal(t, "backup", feed.Entries[1].Categories[1].Term)
+
+	// Filters work like they do for polling
+	response = request(t, s, "GET", "/mytopic/feed.atom?tags=backup", "", nil)
+	feed = toAtomFeed(t, response.Body.String())
+	require.Equal(t, 1, len(feed.Entries))
+	require.Equal(t, "Backup done", feed.Entries[0].Title)
+}
+
+func TestServer_Feed_MultipleTopicsAndLimit(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 200
+	s := newTestServer(t, c)
+	for i := 0; i < feedEntriesLimit+5; i++ {
+		require.Equal(t, 200, request(t, s, "PUT", "/topic1", "message", nil).Code)
+	}
+	require.Equal(t, 200, request(t, s, "PUT", "/topic2", "other message", nil).Code)
+
+	response := request(t, s, "GET", "/topic1,topic2/feed.atom", "", nil)
+	require.Equal(t, 200, response.Code)
+	feed := toAtomFeed(t, response.Body.String())
+	require.Equal(t, "ntfy: topic1, topic2", feed.Title)
+	require.Equal(t, feedEntriesLimit, len(feed.Entries))
+
+	response = request(t, s, "GET", "/topic2/feed.atom?since=none", "", nil)
+	feed = toAtomFeed(t, response.Body.String())
+	require.Empty(t, feed.Entries)
+}
+
+func TestServer_Feed_Auth(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	response := request(t, s, "PUT", "/private", "secret", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/private/feed.atom", "", nil)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/private/feed.atom", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Contains(t, response.Body.String(), "<content type=\"text\">secret</content>")
+}
+
+func TestAtomEntryTitle(t *testing.T) {
+	require.Equal(t, "My title", atomEntryTitle(&message{Title: "My title", Message: "body"}))
+	require.Equal(t, "first line", atomEntryTitle(&message{Message: "  first line\nsecond line"}))
+	require.Equal(t, strings.Repeat("x", 77)+"...", atomEntryTitle(&message{Message: strings.Repeat("x", 100)}))
+	require.Equal(t, "mytopic", atomEntryTitle(&message{Topic: "mytopic", Message: ""}))
+}
+
+func toAtomFeed(t *testing.T, s string) *atomFeed {
+	var feed atomFeed
+	require.Nil(t, xml.NewDecoder(strings.NewReader(s)).Decode(&feed))
+	return &feed
+}
@@ -11,23 +11,51 @@ import (
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 	"strings"
+	"time"
 )
 
 const (
-	fcmMessageLimit         = 4000
-	fcmApnsBodyMessageLimit = 100
+	fcmMessageLimit              = 4000
+	fcmApnsBodyMessageLimit      = 100
+	fcmApnsCriticalAlertPriority = 5 // Only urgent messages are sent as critical alerts
+	fcmApnsCriticalAlertSound    = "default"
+	fcmApnsCriticalAlertVolume   = 1.0
 )
 
 var (
 	errFirebaseQuotaExceeded     = errors.New("quota exceeded for Firebase messages to topic")
 	errFirebaseTemporarilyBanned = errors.New("visitor temporarily banned from using Firebase")
+	errFirebaseQueueFull         = errors.New("Firebase queue is full")
 )
 
 // firebaseClient is a generic client that formats and sends messages to Firebase.
 // The actual Firebase implementation is implemented in firebaseSenderImpl, to make it testable.
 type firebaseClient struct {
-	sender firebaseSender
-	auther user.Auther
+	sender              firebaseSender
+	auther              user.Auther
+	criticalAlertTopics []string                  // Topics (or topic prefixes, e.g. "oncall-*") that opted in to iOS critical alerts
+	androidChannels     []*FirebaseAndroidChannel // Rules to map messages to Android notification channels, first match wins
+}
+
+// FirebaseAndroidChannel maps messages to an Android notification channel. The channel ID is passed to the
+// Android app in the "android_channel" field of the FCM message. All conditions that are set must match.
+type FirebaseAndroidChannel struct {
+	Channel     string // Notification channel ID
+	Topic       string // Topic name or prefix (e.g. "oncall-*"), empty matches all topics
+	MinPriority int    // Minimum message priority (1-5), zero matches all priorities
+	Tag         string // Tag the message must have, empty matches all messages
+}
+
+// Matches returns true if the message matches all conditions of the rule
+func (c *FirebaseAndroidChannel) Matches(m *message) bool {
+	if c.Topic != "" && !topicMatches(c.Topic, m.Topic) {
+		return false
+	} else if c.MinPriority > 0 && firebaseQueuePriority(m) < c.MinPriority {
+		return false
+	} else if c.Tag != "" && !util.Contains(m.Tags, c.Tag) {
+		return false
+	}
+	return true
 }
 
 func newFirebaseClient(sender firebaseSender, auther user.Auther) *firebaseClient {
@@ -45,6 +73,12 @@ func (c *firebaseClient) Send(v *visitor, m *message) error {
 	if err != nil {
 		return err
 	}
+	if m.Priority == fcmApnsCriticalAlertPriority && c.criticalAlertAllowed(m.Topic) {
+		maybeMarkAPNSCriticalAlert(fbm)
+	}
+	if channel := c.androidChannel(m); channel != "" && fbm.Data["event"] == messageEvent {
+		fbm.Data["android_channel"] = channel
+	}
 	ev := logvm(v, m).Tag(tagFirebase)
 	if ev.IsTrace() {
 		ev.Field("firebase_message", util.MaybeMarshalJSON(fbm)).Trace("Firebase message")
@@ -60,6 +94,51 @@ func (c *firebaseClient) Send(v *visitor, m *message) error {
 	return err
 }
 
+// criticalAlertAllowed returns true if the topic opted in to iOS critical alerts, see Config.FirebaseCriticalAlertTopics
+func (c *firebaseClient) criticalAlertAllowed(topic string) bool {
+	for _, t := range c.criticalAlertTopics {
+		if topicMatches(t, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// androidChannel returns the Android notification channel of the first matching rule, see Config.FirebaseAndroidChannels
+func (c *firebaseClient) androidChannel(m *message) string {
+	for _, rule := range c.androidChannels {
+		if rule.Matches(m) {
+			return rule.Channel
+		}
+	}
+	return ""
+}
+
+// topicMatches returns true if the topic is equal to the pattern, or if the pattern is a prefix ending
+// with "*" (e.g. "oncall-*") and the topic starts with the prefix
+func topicMatches(pattern, topic string) bool {
+	if prefix, isPrefix := strings.CutSuffix(pattern, "*"); isPrefix {
+		return strings.HasPrefix(topic, prefix)
+	}
+	return pattern == topic
+}
+
+// firebaseQueueItem is a message waiting in the Firebase queue, see Server.enqueueFirebase
+type firebaseQueueItem struct {
+	v      *visitor
+	m      *message
+	queued time.Time
+}
+
+// firebaseQueuePriority returns the queue priority of a message, which is the message priority (1-5),
+// or the default priority if it is not set
+func firebaseQueuePriority(m *message) int {
+	if m.Priority == 0 {
+		return 3
+	}
+	return m.Priority
+}
+
 // firebaseSender is an interface that represents a client that can send to Firebase Cloud Messaging.
 // In tests, this can be implemented with a mock.
 type firebaseSender interface {
@@ -113,7 +192,7 @@ func (c *firebaseSenderImpl) Send(m *messaging.Message) error {
 // Poll request messages ("poll_request"):
 //   - Normal messages are turned into poll request messages if anonymous users are not allowed to read the message.
 //     On Android, this will trigger the app to poll the topic and thereby displaying new messages.
-//   - If UpstreamBaseURL is set, messages are forwarded as poll requests to an upstream server and then forwarded
+//   - If UpstreamBaseURL or Upstreams is set, messages are forwarded as poll requests to an upstream server and then forwarded
 //     to Firebase here. This is mainly for iOS to support self-hosted servers.
 func toFirebaseMessage(m *message, auther user.Auther) (*messaging.Message, error) {
 	var data map[string]string // Mostly matches https://ntfy.sh/docs/subscribe/api/#json-message-format
@@ -164,6 +243,21 @@ func toFirebaseMessage(m *message, auther user.Auther) (*messaging.Message, erro
 				}
 				data["actions"] = string(actions)
 			}
+			if m.Sound != "" {
+				data["sound"] = m.Sound
+			}
+			if m.InReplyTo != "" {
+				data["in_reply_to"] = m.InReplyTo
+			}
+			if m.Location != nil {
+				data["location"] = m.Location.String()
+			}
+			if m.Hostname != "" {
+				data["hostname"] = m.Hostname
+			}
+			if m.CorrelationID != "" {
+				data["correlation_id"] = m.CorrelationID
+			}
 			if m.Attachment != nil {
 				data["attachment_name"] = m.Attachment.Name
 				data["attachment_type"] = m.Attachment.Type
@@ -230,6 +324,7 @@ func createAPNSAlertConfig(m *message, data map[string]string) *messaging.APNSCo
 			CustomData: apnsData,
 			Aps: &messaging.Aps{
 				MutableContent: true,
+				Sound:          m.Sound,
 				Alert: &messaging.ApsAlert{
 					Title: m.Title,
 					Body:  maybeTruncateAPNSBodyMessage(m.Message),
@@ -239,6 +334,37 @@ func createAPNSAlertConfig(m *message, data map[string]string) *messaging.APNSCo
 	}
 }
 
+// maybeMarkAPNSCriticalAlert turns an iOS alert notification into a critical alert, which plays a sound and breaks
+// through silent mode and Do Not Disturb. Critical alerts are only shown if the iOS app has the critical alerts
+// entitlement, and the user allowed them; otherwise iOS shows a regular notification. Background messages (e.g. poll
+// requests) are not changed. A custom sound of the message is played as the critical sound.
+//
+// See https://developer.apple.com/documentation/usernotifications/unnotificationinterruptionlevel/critical
+func maybeMarkAPNSCriticalAlert(m *messaging.Message) {
+	if m.APNS == nil || m.APNS.Payload == nil || m.APNS.Payload.Aps == nil || m.APNS.Payload.Aps.Alert == nil {
+		return
+	}
+	aps := m.APNS.Payload.Aps
+	sound := fcmApnsCriticalAlertSound
+	if aps.Sound != "" {
+		sound = aps.Sound
+		aps.Sound = "" // APNs does not allow both a sound and a critical sound
+	}
+	aps.CriticalSound = &messaging.CriticalSound{
+		Critical: true,
+		Name:     sound,
+		Volume:   fcmApnsCriticalAlertVolume,
+	}
+	if aps.CustomData == nil {
+		aps.CustomData = make(map[string]any)
+	}
+	aps.CustomData["interruption-level"] = "critical"
+	if m.APNS.Headers == nil {
+		m.APNS.Headers = make(map[string]string)
+	}
+	m.APNS.Headers["apns-priority"] = "10"
+}
+
 // createAPNSBackgroundConfig creates an APNS config for a silent background message (only relevant for iOS). Apple only
 // allows us to send 2-3 of these notifications per hour, and delivery not guaranteed. We use this only for the ~poll
 // topic, which triggers the iOS app to poll all topics for changes.
@@ -343,3 +343,101 @@ func TestToFirebaseSender_Abuse(t *testing.T) {
 	require.Equal(t, errFirebaseTemporarilyBanned, client.Send(visitor, &message{Topic: "mytopic"}))
 	require.Equal(t, 0, len(sender.Messages()))
 }
+
+func TestToFirebaseSender_CriticalAlert(t *testing.T) {
+	sender := newTestFirebaseSender(10)
+	client := newFirebaseClient(sender, &testAuther{Allow: true})
+	client.criticalAlertTopics = []string{"oncall-*", "alerts"}
+	visitor := newVisitor(newTestConfig(t), newMemTestCache(t), nil, netip.MustParseAddr("1.2.3.4"), nil)
+
+	// Urgent messages to opted-in topics are critical alerts
+	for _, topic := range []string{"oncall-phil", "alerts"} {
+		m := newDefaultMessage(topic, "server is down")
+		m.Priority = 5
+		require.Nil(t, client.Send(visitor, m))
+	}
+	for _, fbm := range sender.Messages() {
+		aps := fbm.APNS.Payload.Aps
+		require.NotNil(t, aps.CriticalSound)
+		require.True(t, aps.CriticalSound.Critical)
+		require.Equal(t, "default", aps.CriticalSound.Name)
+		require.Equal(t, 1.0, aps.CriticalSound.Volume)
+		require.Equal(t, "critical", aps.CustomData["interruption-level"])
+		require.Equal(t, "10", fbm.APNS.Headers["apns-priority"])
+		require.Equal(t, "server is down", aps.Alert.Body)
+	}
+	b, err := json.Marshal(sender.Messages()[0].APNS.Payload)
+	require.Nil(t, err)
+	require.Contains(t, string(b), `"sound":{"critical":1,"name":"default","volume":1}`)
+
+	// Non-urgent messages, and messages to other topics are regular alerts
+	m := newDefaultMessage("alerts", "disk almost full")
+	m.Priority = 4
+	require.Nil(t, client.Send(visitor, m))
+	m = newDefaultMessage("alerts-other", "server is down")
+	m.Priority = 5
+	require.Nil(t, client.Send(visitor, m))
+	for _, fbm := range sender.Messages()[2:] {
+		require.Nil(t, fbm.APNS.Payload.Aps.CriticalSound)
+		require.Nil(t, fbm.APNS.Payload.Aps.CustomData)
+		require.Nil(t, fbm.APNS.Headers)
+	}
+}
+
+func TestToFirebaseSender_Sound(t *testing.T) {
+	sender := newTestFirebaseSender(10)
+	client := newFirebaseClient(sender, &testAuther{Allow: true})
+	client.criticalAlertTopics = []string{"pager"}
+	visitor := newVisitor(newTestConfig(t), newMemTestCache(t), nil, netip.MustParseAddr("1.2.3.4"), nil)
+
+	m := newDefaultMessage("backups", "backup done")
+	m.Sound = "soft-beep"
+	require.Nil(t, client.Send(visitor, m))
+	m = newDefaultMessage("pager", "server down")
+	m.Priority = 5
+	m.Sound = "siren.caf"
+	require.Nil(t, client.Send(visitor, m))
+	m = newDefaultMessage("backups", "no sound")
+	require.Nil(t, client.Send(visitor, m))
+
+	messages := sender.Messages()
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, "soft-beep", messages[0].Data["sound"])
+	require.Equal(t, "soft-beep", messages[0].APNS.Payload.Aps.Sound)
+	require.Nil(t, messages[0].APNS.Payload.Aps.CriticalSound)
+
+	// Critical alerts play the custom sound as critical sound
+	require.Equal(t, "siren.caf", messages[1].Data["sound"])
+	require.Equal(t, "", messages[1].APNS.Payload.Aps.Sound)
+	require.Equal(t, "siren.caf", messages[1].APNS.Payload.Aps.CriticalSound.Name)
+
+	_, ok := messages[2].Data["sound"]
+	require.False(t, ok)
+	require.Equal(t, "", messages[2].APNS.Payload.Aps.Sound)
+}
+
+func TestToFirebaseSender_AndroidChannels(t *testing.T) {
+	sender := newTestFirebaseSender(10)
+	client := newFirebaseClient(sender, &testAuther{Allow: true})
+	client.androidChannels = []*FirebaseAndroidChannel{
+		{Channel: "oncall", Topic: "oncall-*", MinPriority: 4},
+		{Channel: "fire", Tag: "fire"},
+	}
+	visitor := newVisitor(newTestConfig(t), newMemTestCache(t), nil, netip.MustParseAddr("1.2.3.4"), nil)
+
+	m := newDefaultMessage("oncall-phil", "server is down")
+	m.Priority = 5
+	require.Nil(t, client.Send(visitor, m))
+	m = newDefaultMessage("oncall-phil", "fyi") // Default priority
+	m.Tags = []string{"fire"}
+	require.Nil(t, client.Send(visitor, m))
+	m = newDefaultMessage("mytopic", "nothing special")
+	require.Nil(t, client.Send(visitor, m))
+	require.Nil(t, client.Send(visitor, newKeepaliveMessage(firebaseControlTopic)))
+
+	messages := sender.Messages()
+	require.Equal(t, "oncall", messages[0].Data["android_channel"])
+	require.Equal(t, "fire", messages[1].Data["android_channel"])
+	require.NotContains(t, messages[2].Data, "android_channel")
+	require.NotContains(t, messages[3].Data, "android_channel")
+}
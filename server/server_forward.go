@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"heckel.io/ntfy/v2/util"
+	"strings"
+)
+
+// ForwardRule copies messages published to a topic (or topic prefix, e.g. "service-*") to another topic, if they
+// match the minimum priority and tags of the rule, see Config.ForwardRules. The priority of the copy may be changed,
+// and tags may be added to it.
+type ForwardRule struct {
+	Topic       string   // Topic name or prefix (e.g. "service-*")
+	Target      string   // Topic that matching messages are copied to
+	MinPriority int      // Minimum message priority, 0 matches all messages
+	Tags        []string // Tags the message must have (all of them), empty matches all messages
+	SetPriority int      // Priority of the copy, 0 keeps the priority of the message
+	AddTags     []string // Tags added to the copy
+}
+
+// Matches returns true if the message should be copied to the target topic
+func (r *ForwardRule) Matches(m *message) bool {
+	if !topicMatches(r.Topic, m.Topic) || r.Target == m.Topic {
+		return false
+	} else if r.MinPriority > 0 && messagePriority(m) < r.MinPriority {
+		return false
+	}
+	for _, tag := range r.Tags {
+		if !util.Contains(m.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// forwardMessage copies the message to the target topics of all matching forward rules. Each target topic receives
+// at most one copy. Copies are never forwarded again, so rules cannot create loops.
+func (s *Server) forwardMessage(v *visitor, m *message) {
+	if len(s.config.ForwardRules) == 0 || m.Event != messageEvent || m.PollID != "" {
+		return
+	}
+	forwarded := make(map[string]bool)
+	for _, rule := range s.config.ForwardRules {
+		if forwarded[rule.Target] || !rule.Matches(m) {
+			continue
+		}
+		forwarded[rule.Target] = true
+		if err := s.publishForwardedMessage(v, m, rule); err != nil {
+			logvm(v, m).Tag(tagPublish).Err(err).Warn("Unable to forward message to topic %s", rule.Target)
+			continue
+		}
+		logvm(v, m).Tag(tagPublish).Debug("Forwarded message to topic %s", rule.Target)
+	}
+}
+
+// publishForwardedMessage publishes a copy of the message to the target topic of the rule. The copy is published
+// like a server message (see publishServerMessage): it does not count towards the publisher's quota again, and it is
+// subject to the policy, maintenance windows and routing rules of the target topic.
+func (s *Server) publishForwardedMessage(v *visitor, m *message, rule *ForwardRule) error {
+	fm := newDefaultMessage(rule.Target, m.Message)
+	fm.Title = m.Title
+	fm.Priority = m.Priority
+	if rule.SetPriority > 0 {
+		fm.Priority = rule.SetPriority
+	}
+	fm.Tags = append([]string{}, m.Tags...)
+	for _, tag := range rule.AddTags {
+		if !util.Contains(fm.Tags, tag) {
+			fm.Tags = append(fm.Tags, tag)
+		}
+	}
+	fm.Click = m.Click
+	fm.Icon = m.Icon
+	fm.Sound = m.Sound
+	fm.Actions = m.Actions
+	fm.ContentType = m.ContentType
+	fm.Encoding = m.Encoding
+	fm.Location = m.Location
+	fm.Hostname = m.Hostname
+	fm.CorrelationID = m.CorrelationID
+	fm.Sender = v.IP()
+	fm.User = v.MaybeUserID()
+	if m.Attachment != nil {
+		a := *m.Attachment
+		fm.Attachment = &a
+	}
+	policy, err := s.enforceTopicPolicy(fm, nil, false)
+	if err != nil {
+		return err
+	} else if policy != nil && policy.MaxMessageSize > 0 && len(fm.Message) > policy.MaxMessageSize {
+		return errHTTPEntityTooLargeTopicPolicyMessage.With(fm)
+	}
+	if err := s.linkForwardedAttachment(m, fm); err != nil {
+		return err
+	}
+	s.publishServerMessage(v, fm)
+	return nil
+}
+
+// linkForwardedAttachment links the attachment of the copy to the attachment of the original message, if the
+// attachment is stored on this server. The copy then has its own attachment URL, and the content is still only
+// stored once. External attachments are kept as they are.
+func (s *Server) linkForwardedAttachment(m, fm *message) error {
+	if fm.Attachment == nil || s.fileCache == nil {
+		return nil
+	}
+	prefix := fmt.Sprintf("%s/file/%s", s.attachmentBaseURL(), m.ID)
+	if !strings.HasPrefix(fm.Attachment.URL, prefix) {
+		return nil
+	}
+	if err := s.fileCache.Link(fm.ID, m.ID); err != nil {
+		return err
+	}
+	fm.Attachment.URL = fmt.Sprintf("%s/file/%s%s", s.attachmentBaseURL(), fm.ID, strings.TrimPrefix(fm.Attachment.URL, prefix))
+	return nil
+}
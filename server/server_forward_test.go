@@ -0,0 +1,150 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServer_Forward_PriorityAndTags(t *testing.T) {
+	c := newTestConfig(t)
+	c.ForwardRules = []*ForwardRule{
+		{Topic: "service-*", Target: "pager", MinPriority: 5, SetPriority: 4, AddTags: []string{"pager", "warning"}},
+		{Topic: "backups", Target: "ops", Tags: []string{"backup", "failed"}},
+	}
+	s := newTestServer(t, c)
+
+	rr := request(t, s, "POST", "/service-db", "disk full", map[string]string{
+		"Title":    "db1",
+		"Priority": "urgent",
+		"Tags":     "warning,db",
+		"Click":    "https://example.com/db1",
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/service-db", "backup done", map[string]string{"Priority": "high"})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/service-web", "site down", map[string]string{"Priority": "5"})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/pager/json?poll=1", "", nil)
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "pager", messages[0].Topic)
+	require.Equal(t, "disk full", messages[0].Message)
+	require.Equal(t, "db1", messages[0].Title)
+	require.Equal(t, 4, messages[0].Priority)
+	require.Equal(t, []string{"warning", "db", "pager"}, messages[0].Tags)
+	require.Equal(t, "https://example.com/db1", messages[0].Click)
+	require.Equal(t, "site down", messages[1].Message)
+
+	// Originals are unchanged
+	rr = request(t, s, "GET", "/service-db/json?poll=1", "", nil)
+	messages = toMessages(t, rr.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, 5, messages[0].Priority)
+	require.Equal(t, []string{"warning", "db"}, messages[0].Tags)
+
+	// All tags must match
+	rr = request(t, s, "POST", "/backups", "backup ok", map[string]string{"Tags": "backup"})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/backups", "backup failed", map[string]string{"Tags": "failed,backup"})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/ops/json?poll=1", "", nil)
+	messages = toMessages(t, rr.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "backup failed", messages[0].Message)
+}
+
+func TestServer_Forward_NoLoopsOrDuplicates(t *testing.T) {
+	c := newTestConfig(t)
+	c.ForwardRules = []*ForwardRule{
+		{Topic: "*", Target: "all"},
+		{Topic: "a", Target: "b"},
+		{Topic: "b", Target: "a"},
+		{Topic: "a", Target: "all"},
+	}
+	s := newTestServer(t, c)
+
+	rr := request(t, s, "POST", "/a", "hi there", nil)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/all", "to all", nil)
+	require.Equal(t, 200, rr.Code)
+
+	for topic, count := range map[string]int{"a": 1, "b": 1, "all": 2} {
+		rr = request(t, s, "GET", "/"+topic+"/json?poll=1", "", nil)
+		require.Equal(t, count, len(toMessages(t, rr.Body.String())), topic)
+	}
+}
+
+func TestServer_Forward_DelayedMessage(t *testing.T) {
+	c := newTestConfig(t)
+	c.ForwardRules = []*ForwardRule{{Topic: "mytopic", Target: "copy"}}
+	s := newTestServer(t, c)
+
+	rr := request(t, s, "PUT", "/mytopic", "a message", map[string]string{"In": "1h"})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/copy/json?poll=1", "", nil)
+	require.Equal(t, 0, len(toMessages(t, rr.Body.String())))
+
+	_, err := s.messageCache.db.Exec(`UPDATE messages SET time=?`, time.Now().Add(-10*time.Second).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendDelayedMessages())
+
+	rr = request(t, s, "GET", "/copy/json?poll=1", "", nil)
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "a message", messages[0].Message)
+}
+
+func TestServer_Forward_AttachmentQuotaAndMaintenance(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.VisitorMessageDailyLimit = 2
+	c.ForwardRules = []*ForwardRule{
+		{Topic: "alerts", Target: "pager"},
+	}
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	// Attachment is linked under the ID of the copy
+	rr := request(t, s, "PUT", "/alerts?f=log.txt", "this is an attachment", nil)
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	rr = request(t, s, "GET", "/pager/json?poll=1", "", nil)
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 1, len(messages))
+	fm := messages[0]
+	require.NotEqual(t, m.ID, fm.ID)
+	require.Equal(t, "log.txt", fm.Attachment.Name)
+	require.Equal(t, c.BaseURL+"/file/"+fm.ID+".txt", fm.Attachment.URL)
+	require.FileExists(t, filepath.Join(c.AttachmentCacheDir, fm.ID))
+	rr = request(t, s, "GET", "/file/"+fm.ID+".txt", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "this is an attachment", rr.Body.String())
+
+	// Removing the original attachment does not affect the copy
+	require.Nil(t, s.fileCache.Remove(m.ID))
+	_, err := os.Stat(filepath.Join(c.AttachmentCacheDir, fm.ID))
+	require.Nil(t, err)
+
+	// Maintenance windows of the target topic apply to copies
+	rr = request(t, s, "POST", "/pager/maintenance", `{"duration":"1h","action":"downgrade"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/alerts", "during maintenance", map[string]string{"Priority": "5"})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/pager/json?poll=1", "", nil)
+	messages = toMessages(t, rr.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "during maintenance", messages[1].Message)
+	require.Equal(t, maintenanceDowngradePriority, messages[1].Priority)
+	require.Contains(t, messages[1].Tags, maintenanceTag)
+
+	// Both copies were published, since they do not count towards the publisher's message limit
+	rr = request(t, s, "PUT", "/alerts", "over the limit", nil)
+	require.Equal(t, 429, rr.Code)
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"fmt"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// graphQLMessagesLimitDefault is the number of messages returned by the messages fields if no limit is passed
+	graphQLMessagesLimitDefault = 100
+
+	// graphQLMessagesLimitMax is the maximum number of messages that can be requested via the limit argument
+	graphQLMessagesLimitMax = 1000
+)
+
+var graphQLMessagesArgs = []string{"since", "scheduled", "limit", "id", "message", "title", "priority", "tags"}
+
+// handleGraphQL serves the read-only GraphQL API. Queries can be sent via POST (JSON body with query, variables
+// and operationName), or via GET (query, variables and operationName query params). Like for any GraphQL API,
+// errors are returned in the errors field of the response, and not as HTTP error.
+//
+// The schema is:
+//
+//	type Query {
+//	  messages(topics: [String!]!, since: String, scheduled: Boolean, limit: Int, id: String, message: String,
+//	           title: String, priority: [Int], tags: [String]): [Message!]!
+//	  topics(names: [String!]!): [Topic!]!
+//	  account: Account!
+//	}
+//	type Topic {
+//	  name: String!
+//	  subscribers: Int!
+//	  messages(since: String, scheduled: Boolean, limit: Int, ...): [Message!]!
+//	}
+//
+// Message and Account have the same fields as the JSON message format and GET /v1/account.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	var req *graphQLRequest
+	if r.Method == http.MethodGet {
+		req = &graphQLRequest{
+			Query:         readQueryParam(r, "query"),
+			OperationName: readQueryParam(r, "operationName"),
+		}
+		if variables := readQueryParam(r, "variables"); variables != "" {
+			vars, err := util.UnmarshalJSON[map[string]any](io.NopCloser(strings.NewReader(variables)))
+			if err != nil {
+				return errHTTPBadRequestJSONInvalid
+			}
+			req.Variables = *vars
+		}
+	} else {
+		var err error
+		req, err = readJSONWithLimit[graphQLRequest](r.Body, s.config.AccountBodySizeLimit, false)
+		if err != nil {
+			return err
+		}
+	}
+	if req.Query == "" {
+		return errHTTPBadRequest.Wrap("query must be set")
+	}
+	response := executeGraphQL(req, s.graphQLQuery(v))
+	logvr(v, r).Tag(tagSubscribe).Debug("Executed GraphQL query with %d error(s)", len(response.Errors))
+	return s.writeJSON(w, response)
+}
+
+// graphQLQuery returns the root query object of the GraphQL API for the given visitor
+func (s *Server) graphQLQuery(v *visitor) *graphQLObject {
+	return &graphQLObject{
+		Type: "Query",
+		Fields: map[string]*graphQLFieldResolver{
+			"messages": {
+				Args: append([]string{"topics"}, graphQLMessagesArgs...),
+				Resolve: func(args map[string]any) (any, error) {
+					topics, err := graphQLStringListArg(args, "topics")
+					if err != nil {
+						return nil, err
+					} else if len(topics) == 0 {
+						return nil, fmt.Errorf("argument topics must not be empty")
+					}
+					return s.graphQLMessages(v, topics, args)
+				},
+			},
+			"topics": {
+				Args: []string{"names"},
+				Resolve: func(args map[string]any) (any, error) {
+					names, err := graphQLStringListArg(args, "names")
+					if err != nil {
+						return nil, err
+					}
+					topics := make([]*graphQLObject, 0)
+					for _, name := range names {
+						if err := s.graphQLAuthorizeTopic(v, name); err != nil {
+							return nil, err
+						}
+						topics = append(topics, s.graphQLTopic(v, name))
+					}
+					return topics, nil
+				},
+			},
+			"account": {
+				Resolve: func(_ map[string]any) (any, error) {
+					info, err := v.Info()
+					if err != nil {
+						return nil, err
+					}
+					return s.accountResponse(v, info)
+				},
+			},
+		},
+	}
+}
+
+func (s *Server) graphQLTopic(v *visitor, name string) *graphQLObject {
+	return &graphQLObject{
+		Type: "Topic",
+		Fields: map[string]*graphQLFieldResolver{
+			"name": {
+				Resolve: func(_ map[string]any) (any, error) {
+					return name, nil
+				},
+			},
+			"subscribers": {
+				Resolve: func(_ map[string]any) (any, error) {
+					s.mu.RLock()
+					t, ok := s.topics[name]
+					s.mu.RUnlock()
+					if !ok {
+						return 0, nil
+					}
+					subscribers, _ := t.Stats()
+					return subscribers, nil
+				},
+			},
+			"messages": {
+				Args: graphQLMessagesArgs,
+				Resolve: func(args map[string]any) (any, error) {
+					return s.graphQLMessages(v, []string{name}, args)
+				},
+			},
+		},
+	}
+}
+
+// graphQLMessages returns the cached messages of the given topics, filtered by the arguments (see
+// graphQLMessagesArgs). Messages are sorted by time, and only the latest messages are returned if
+// there are more than the limit.
+func (s *Server) graphQLMessages(v *visitor, topics []string, args map[string]any) ([]*message, error) {
+	sinceStr, err := graphQLStringArg(args, "since")
+	if err != nil {
+		return nil, err
+	}
+	since, err := parseSinceString(sinceStr, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid since argument: %s", err.Error())
+	}
+	scheduled, err := graphQLBoolArg(args, "scheduled")
+	if err != nil {
+		return nil, err
+	}
+	limit, err := graphQLIntArg(args, "limit", graphQLMessagesLimitDefault)
+	if err != nil {
+		return nil, err
+	} else if limit < 0 || limit > graphQLMessagesLimitMax {
+		return nil, fmt.Errorf("argument limit must be between 0 and %d", graphQLMessagesLimitMax)
+	}
+	filters, err := graphQLQueryFilter(args)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*message, 0)
+	for _, topic := range topics {
+		if err := s.graphQLAuthorizeTopic(v, topic); err != nil {
+			return nil, err
+		}
+		topicMessages, err := s.messageCache.Messages(topic, since, scheduled)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range topicMessages {
+			if m.Event == messageEvent && filters.Pass(m) {
+				messages = append(messages, m)
+			}
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Time < messages[j].Time
+	})
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return messages, nil
+}
+
+// graphQLAuthorizeTopic checks if the visitor is allowed to read the given topic, like authorizeTopicRead
+func (s *Server) graphQLAuthorizeTopic(v *visitor, topic string) error {
+	if !topicRegex.MatchString(topic) {
+		return errHTTPBadRequestTopicInvalid
+	} else if s.topicBlocked(topic) {
+		return errHTTPForbiddenTopicBlocked
+	} else if s.userManager == nil {
+		return nil
+	} else if err := s.userManager.Authorize(v.User(), topic, user.PermissionRead); err != nil {
+		return fmt.Errorf("access to topic %s not allowed", topic)
+	}
+	return nil
+}
+
+func graphQLQueryFilter(args map[string]any) (*queryFilter, error) {
+	id, err := graphQLStringArg(args, "id")
+	if err != nil {
+		return nil, err
+	}
+	msg, err := graphQLStringArg(args, "message")
+	if err != nil {
+		return nil, err
+	}
+	title, err := graphQLStringArg(args, "title")
+	if err != nil {
+		return nil, err
+	}
+	tags, err := graphQLStringListArg(args, "tags")
+	if err != nil {
+		return nil, err
+	}
+	priorities, err := graphQLStringListArg(args, "priority")
+	if err != nil {
+		return nil, err
+	}
+	filter := &queryFilter{
+		ID:       id,
+		Message:  msg,
+		Title:    title,
+		Tags:     tags,
+		Priority: make([]int, 0),
+	}
+	for _, p := range priorities {
+		priority, err := util.ParsePriority(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority %s", p)
+		}
+		filter.Priority = append(filter.Priority, priority)
+	}
+	return filter, nil
+}
+
+func graphQLStringArg(args map[string]any, name string) (string, error) {
+	value, ok := args[name]
+	if !ok {
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %s must be a string", name)
+	}
+	return s, nil
+}
+
+func graphQLBoolArg(args map[string]any, name string) (bool, error) {
+	value, ok := args[name]
+	if !ok {
+		return false, nil
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("argument %s must be a boolean", name)
+	}
+	return b, nil
+}
+
+// graphQLIntArg returns the value of an integer argument. Integers in variables are float64, since they
+// are decoded from JSON.
+func graphQLIntArg(args map[string]any, name string, defaultValue int) (int, error) {
+	value, ok := args[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	switch i := value.(type) {
+	case int64:
+		return int(i), nil
+	case float64:
+		if i == float64(int(i)) {
+			return int(i), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %s must be an integer", name)
+}
+
+// graphQLStringListArg returns the value of a list argument as strings. Numbers are converted to strings,
+// and a single value is treated as a list with one element, as defined by the GraphQL input coercion rules.
+func graphQLStringListArg(args map[string]any, name string) ([]string, error) {
+	value, ok := args[name]
+	if !ok {
+		return make([]string, 0), nil
+	}
+	list, ok := value.([]any)
+	if !ok {
+		list = []any{value}
+	}
+	values := make([]string, 0)
+	for _, item := range list {
+		switch v := item.(type) {
+		case string:
+			values = append(values, v)
+		case int64, float64:
+			values = append(values, fmt.Sprint(v))
+		default:
+			return nil, fmt.Errorf("argument %s must be a list of strings", name)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"net/url"
+	"testing"
+)
+
+type testGraphQLResponse struct {
+	Data struct {
+		Messages []*message `json:"messages"`
+		Latest   []*message `json:"latest"`
+		Topics   []struct {
+			Name        string     `json:"name"`
+			Subscribers int        `json:"subscribers"`
+			Messages    []*message `json:"messages"`
+		} `json:"topics"`
+		Account *apiAccountResponse `json:"account"`
+	} `json:"data"`
+	Errors []*graphQLError `json:"errors"`
+}
+
+func toGraphQLResponse(t *testing.T, s string) *testGraphQLResponse {
+	var response testGraphQLResponse
+	require.Nil(t, json.Unmarshal([]byte(s), &response))
+	return &response
+}
+
+func TestServer_GraphQL_Messages(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	request(t, s, "PUT", "/alerts", "disk full", map[string]string{"Tags": "warning", "Priority": "high"})
+	request(t, s, "PUT", "/backups", "backup done", nil)
+	request(t, s, "PUT", "/alerts", "cpu hot", map[string]string{"Tags": "warning,fire", "Title": "CPU"})
+	request(t, s, "PUT", "/other", "not requested", nil)
+
+	body := `{"query":"query ($topics: [String!]!) { messages(topics: $topics) { topic message tags } latest: messages(topics: $topics, limit: 1, tags: [\"warning\"]) { message title } }","variables":{"topics":["alerts","backups"]}}`
+	rr := request(t, s, "POST", "/v1/graphql", body, nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	response := toGraphQLResponse(t, rr.Body.String())
+	require.Nil(t, response.Errors)
+	require.Equal(t, 3, len(response.Data.Messages))
+	require.Equal(t, "disk full", response.Data.Messages[0].Message)
+	require.Equal(t, "alerts", response.Data.Messages[0].Topic)
+	require.Equal(t, []string{"warning"}, response.Data.Messages[0].Tags)
+	require.Equal(t, "", response.Data.Messages[0].ID) // Not requested
+	require.ElementsMatch(t, []string{"backup done", "cpu hot"}, []string{response.Data.Messages[1].Message, response.Data.Messages[2].Message})
+	require.Equal(t, 1, len(response.Data.Latest))
+	require.Equal(t, "cpu hot", response.Data.Latest[0].Message)
+	require.Equal(t, "CPU", response.Data.Latest[0].Title)
+
+	// Only the requested fields are returned, in the requested order
+	require.Contains(t, rr.Body.String(), `{"topic":"alerts","message":"disk full","tags":["warning"]}`)
+}
+
+func TestServer_GraphQL_Get(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	request(t, s, "PUT", "/mytopic", "hi there", map[string]string{"Priority": "5"})
+	request(t, s, "PUT", "/mytopic", "low prio", map[string]string{"Priority": "1"})
+
+	query := url.QueryEscape(`{ topics(names: "mytopic") { name subscribers messages(priority: ["urgent"]) { message priority } } }`)
+	rr := request(t, s, "GET", "/v1/graphql?query="+query, "", nil)
+	require.Equal(t, 200, rr.Code)
+	response := toGraphQLResponse(t, rr.Body.String())
+	require.Nil(t, response.Errors)
+	require.Equal(t, 1, len(response.Data.Topics))
+	require.Equal(t, "mytopic", response.Data.Topics[0].Name)
+	require.Equal(t, 0, response.Data.Topics[0].Subscribers)
+	require.Equal(t, 1, len(response.Data.Topics[0].Messages))
+	require.Equal(t, "hi there", response.Data.Topics[0].Messages[0].Message)
+	require.Equal(t, 5, response.Data.Topics[0].Messages[0].Priority)
+}
+
+func TestServer_GraphQL_AccessControl(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+	request(t, s, "PUT", "/mytopic", "secret", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+
+	body := `{"query":"{ messages(topics: [\"mytopic\"]) { message } account { username role } }"}`
+	rr := request(t, s, "POST", "/v1/graphql", body, nil)
+	require.Equal(t, 200, rr.Code)
+	response := toGraphQLResponse(t, rr.Body.String())
+	require.Nil(t, response.Data.Messages)
+	require.Equal(t, "*", response.Data.Account.Username)
+	require.Equal(t, 1, len(response.Errors))
+	require.Equal(t, "access to topic mytopic not allowed", response.Errors[0].Message)
+	require.Equal(t, []any{"messages"}, response.Errors[0].Path)
+
+	rr = request(t, s, "POST", "/v1/graphql", body, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	response = toGraphQLResponse(t, rr.Body.String())
+	require.Nil(t, response.Errors)
+	require.Equal(t, "secret", response.Data.Messages[0].Message)
+	require.Equal(t, "phil", response.Data.Account.Username)
+	require.Equal(t, "user", response.Data.Account.Role)
+}
+
+func TestServer_GraphQL_Errors(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	request(t, s, "PUT", "/mytopic", "hi", nil)
+
+	rr := request(t, s, "POST", "/v1/graphql", `{"query":"mutation { account { username } }"}`, nil)
+	require.Equal(t, 200, rr.Code)
+	response := toGraphQLResponse(t, rr.Body.String())
+	require.Equal(t, "mutation operations are not supported, only queries are allowed", response.Errors[0].Message)
+	require.NotContains(t, rr.Body.String(), `"data"`)
+
+	rr = request(t, s, "POST", "/v1/graphql", `{"query":"{ messages(topics: [\"mytopic\"], limit: 5000) { id } }"}`, nil)
+	response = toGraphQLResponse(t, rr.Body.String())
+	require.Equal(t, "argument limit must be between 0 and 1000", response.Errors[0].Message)
+
+	rr = request(t, s, "POST", "/v1/graphql", `{"query":"{ messages(topics: [\"mytopic\"]) { sender } }"}`, nil)
+	response = toGraphQLResponse(t, rr.Body.String())
+	require.Equal(t, "cannot query field sender on type Message", response.Errors[0].Message)
+	require.Equal(t, []any{"messages", float64(0), "sender"}, response.Errors[0].Path)
+
+	rr = request(t, s, "POST", "/v1/graphql", `{"query":""}`, nil)
+	require.Equal(t, 400, rr.Code)
+
+	rr = request(t, s, "POST", "/v1/graphql", `not json`, nil)
+	require.Equal(t, 400, rr.Code)
+}
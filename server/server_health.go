@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	readinessCheckTimeout      = 5 * time.Second
+	readinessFirebaseCheckAddr = "fcm.googleapis.com:443"
+	readinessCheckCache        = "cache"
+	readinessCheckUsers        = "users"
+	readinessCheckWebPush      = "webpush"
+	readinessCheckAttachments  = "attachments"
+	readinessCheckFirebase     = "firebase"
+	readinessCheckSMTP         = "smtp"
+	readinessCheckShuttingDown = "shutdown"
+)
+
+var errServerShuttingDown = errors.New("server is shutting down")
+
+// handleHealth is the liveness probe: If the server can answer HTTP requests, it is alive. It is available
+// as /v1/health (for backwards compatibility) and /v1/health/live.
+func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	response := &apiHealthResponse{
+		Healthy: true,
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleHealthReady is the readiness probe: It checks all dependencies the server needs to serve requests
+// (databases, attachment directory and, if enabled, Firebase and SMTP reachability), and responds with
+// 503 Service Unavailable if any of them fails.
+func (s *Server) handleHealthReady(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+	defer cancel()
+	checks := s.readinessChecks()
+	response := &apiHealthResponse{
+		Healthy: true,
+		Checks:  make(map[string]*apiHealthCheck),
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+			result := &apiHealthCheck{Healthy: true}
+			if err := check(ctx); err != nil {
+				result.Healthy = false
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			response.Checks[name] = result
+			if !result.Healthy {
+				response.Healthy = false
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	if !response.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return s.writeJSON(w, response)
+}
+
+// readinessChecks returns the dependency checks for the readiness probe, keyed by name. Only
+// dependencies that are configured are checked.
+func (s *Server) readinessChecks() map[string]func(ctx context.Context) error {
+	checks := map[string]func(ctx context.Context) error{
+		readinessCheckShuttingDown: func(ctx context.Context) error {
+			select {
+			case <-s.shutdownChan:
+				return errServerShuttingDown
+			default:
+				return nil
+			}
+		},
+		readinessCheckCache: s.messageCache.Ping,
+	}
+	if s.userManager != nil {
+		checks[readinessCheckUsers] = s.userManager.Ping
+	}
+	if s.webPush != nil {
+		checks[readinessCheckWebPush] = s.webPush.Ping
+	}
+	if s.fileCache != nil {
+		checks[readinessCheckAttachments] = func(ctx context.Context) error {
+			return s.fileCache.CheckWritable()
+		}
+	}
+	if s.config.ReadinessCheckExternal {
+		if s.firebaseClient != nil {
+			checks[readinessCheckFirebase] = func(ctx context.Context) error {
+				return checkReachable(ctx, readinessFirebaseCheckAddr)
+			}
+		}
+		if s.config.SMTPSenderAddr != "" {
+			checks[readinessCheckSMTP] = func(ctx context.Context) error {
+				return checkReachable(ctx, s.config.SMTPSenderAddr)
+			}
+		}
+	}
+	return checks
+}
+
+// checkReachable checks if a TCP connection can be established to the given address
+func checkReachable(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestServer_Health_Live(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	for _, path := range []string{"/v1/health", "/v1/health/live"} {
+		response := request(t, s, "GET", path, "", nil)
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, `{"healthy":true}`+"\n", response.Body.String())
+	}
+}
+
+func TestServer_Health_Ready(t *testing.T) {
+	s := newTestServer(t, configureAuth(t, newTestConfigWithWebPush(t)))
+	response := request(t, s, "GET", "/v1/health/ready", "", nil)
+	require.Equal(t, 200, response.Code)
+	health, err := util.UnmarshalJSON[apiHealthResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.True(t, health.Healthy)
+	require.Equal(t, 5, len(health.Checks))
+	for _, name := range []string{"cache", "users", "webpush", "attachments", "shutdown"} {
+		require.True(t, health.Checks[name].Healthy, name)
+		require.Empty(t, health.Checks[name].Error)
+	}
+	require.Nil(t, health.Checks["smtp"]) // Not checked by default
+}
+
+func TestServer_Health_Ready_AttachmentDirFailure(t *testing.T) {
+	c := newTestConfig(t)
+	s := newTestServer(t, c)
+	require.Nil(t, os.RemoveAll(c.AttachmentCacheDir))
+
+	response := request(t, s, "GET", "/v1/health/ready", "", nil)
+	require.Equal(t, 503, response.Code)
+	health, err := util.UnmarshalJSON[apiHealthResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.False(t, health.Healthy)
+	require.True(t, health.Checks["cache"].Healthy)
+	require.False(t, health.Checks["attachments"].Healthy)
+	require.Contains(t, health.Checks["attachments"].Error, "no such file or directory")
+	require.Nil(t, health.Checks["users"]) // Not configured
+
+	// Liveness is not affected
+	response = request(t, s, "GET", "/v1/health/live", "", nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_Health_Ready_ShuttingDown(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	s.Shutdown()
+
+	response := request(t, s, "GET", "/v1/health/ready", "", nil)
+	require.Equal(t, 503, response.Code)
+	health, err := util.UnmarshalJSON[apiHealthResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.False(t, health.Healthy)
+	require.False(t, health.Checks["shutdown"].Healthy)
+	require.Equal(t, "server is shutting down", health.Checks["shutdown"].Error)
+}
+
+func TestServer_Health_Ready_External(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	c := newTestConfig(t)
+	c.SMTPSenderAddr = ln.Addr().String()
+	c.ReadinessCheckExternal = true
+	s := newTestServer(t, c)
+
+	response := request(t, s, "GET", "/v1/health/ready", "", nil)
+	require.Equal(t, 200, response.Code)
+	health, err := util.UnmarshalJSON[apiHealthResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.True(t, health.Checks["smtp"].Healthy)
+
+	// SMTP server goes away
+	ln.Close()
+	response = request(t, s, "GET", "/v1/health/ready", "", nil)
+	require.Equal(t, 503, response.Code)
+	health, err = util.UnmarshalJSON[apiHealthResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.False(t, health.Healthy)
+	require.False(t, health.Checks["smtp"].Healthy)
+	require.Contains(t, health.Checks["smtp"].Error, "connection refused")
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	heartbeatDefaultPriority = 4 // High, so that alerts are escalated by default escalation policies
+	heartbeatMinInterval     = time.Minute
+	heartbeatMaxInterval     = 31 * 24 * time.Hour
+	heartbeatAlertTag        = "broken_heart"
+	heartbeatRecoveryTag     = "green_heart"
+	heartbeatStatusNew       = "new"
+	heartbeatStatusUp        = "up"
+	heartbeatStatusDown      = "down"
+	heartbeatMaxCount        = 50 // Per user
+)
+
+// handleHeartbeatGet returns the heartbeat of a topic, including its current status
+func (s *Server) handleHeartbeatGet(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	topic, err := topicFromHeartbeatPath(r)
+	if err != nil {
+		return err
+	}
+	h, err := s.messageCache.Heartbeat(topic)
+	if errors.Is(err, errHeartbeatNotFound) {
+		return errHTTPNotFoundHeartbeat
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, toAPIHeartbeat(h))
+}
+
+// handleHeartbeatChange adds a heartbeat to a topic, or changes the existing heartbeat. The next ping is expected
+// within the interval from now.
+func (s *Server) handleHeartbeatChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := topicFromHeartbeatPath(r)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiHeartbeat](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	existing, err := s.messageCache.Heartbeat(topic)
+	if errors.Is(err, errHeartbeatNotFound) {
+		count, err := s.messageCache.HeartbeatCount(v.MaybeUserID())
+		if err != nil {
+			return err
+		} else if count >= heartbeatMaxCount {
+			return errHTTPTooManyRequestsLimitHeartbeats
+		}
+	} else if err != nil {
+		return err
+	} else if !canChangeHeartbeat(v, existing) {
+		return errHTTPForbidden
+	}
+	h, err := s.validateHeartbeat(v.User(), topic, req)
+	if err != nil {
+		return err
+	}
+	h.User = v.MaybeUserID()
+	logvr(v, r).
+		Tag(tagPublish).
+		Fields(log.Context{
+			"topic":                 topic,
+			"heartbeat_interval":    h.Interval.String(),
+			"heartbeat_alert_topic": h.AlertTopic,
+		}).
+		Info("Changing heartbeat of topic %s", topic)
+	if err := s.messageCache.UpsertHeartbeat(h); err != nil {
+		return err
+	}
+	h, err = s.messageCache.Heartbeat(topic)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, toAPIHeartbeat(h))
+}
+
+// handleHeartbeatDelete removes the heartbeat of a topic, so that no more alerts are published for it
+func (s *Server) handleHeartbeatDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := topicFromHeartbeatPath(r)
+	if err != nil {
+		return err
+	}
+	h, err := s.messageCache.Heartbeat(topic)
+	if errors.Is(err, errHeartbeatNotFound) {
+		return errHTTPNotFoundHeartbeat
+	} else if err != nil {
+		return err
+	} else if !canChangeHeartbeat(v, h) {
+		return errHTTPForbidden
+	}
+	if err := s.messageCache.RemoveHeartbeat(topic); errors.Is(err, errHeartbeatNotFound) {
+		return errHTTPNotFoundHeartbeat
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagPublish).Field("topic", topic).Info("Removed heartbeat of topic %s", topic)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleHeartbeatPing records a ping of the heartbeat of a topic without publishing a message, e.g. from a cron job
+func (s *Server) handleHeartbeatPing(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := topicFromHeartbeatPath(r)
+	if err != nil {
+		return err
+	}
+	h, err := s.messageCache.Heartbeat(topic)
+	if errors.Is(err, errHeartbeatNotFound) {
+		return errHTTPNotFoundHeartbeat
+	} else if err != nil {
+		return err
+	}
+	if err := s.pingHeartbeat(v, h); err != nil {
+		return err
+	}
+	h, err = s.messageCache.Heartbeat(topic)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, toAPIHeartbeat(h))
+}
+
+// recordHeartbeat records a ping of the heartbeat of the message's topic, if the topic has a heartbeat. Every message
+// published to the topic counts as a ping. Errors are logged, but not returned, since the message has already been published.
+func (s *Server) recordHeartbeat(v *visitor, m *message) {
+	if m.Event != messageEvent || m.PollID != "" {
+		return
+	}
+	h, err := s.messageCache.Heartbeat(m.Topic)
+	if errors.Is(err, errHeartbeatNotFound) {
+		return
+	} else if err != nil {
+		logvm(v, m).Tag(tagPublish).Err(err).Warn("Cannot read heartbeat")
+		return
+	}
+	if err := s.pingHeartbeat(v, h); err != nil {
+		logvm(v, m).Tag(tagPublish).Err(err).Warn("Cannot record heartbeat")
+	}
+}
+
+// pingHeartbeat records a ping of the given heartbeat. If the heartbeat was missed before, a recovery message is
+// published to the alert topic, as a reply to the alert message. The reply also acknowledges the alert, which stops
+// its escalation.
+func (s *Server) pingHeartbeat(v *visitor, h *heartbeat) error {
+	now := time.Now().Unix()
+	if err := s.messageCache.MarkHeartbeatPinged(h.Topic, now); err != nil {
+		return err
+	}
+	if h.AlertMessageID == "" {
+		return nil
+	}
+	owner, err := s.heartbeatVisitor(h)
+	if err != nil {
+		return err
+	}
+	lang := userLanguage(owner.User())
+	down := time.Duration(now-h.Due) * time.Second
+	m := newDefaultMessage(h.AlertTopic, translate(lang, "heartbeat_recovered_body", h.Topic, down))
+	m.Title = translate(lang, "heartbeat_recovered_title", h.Topic)
+	m.Tags = []string{heartbeatRecoveryTag}
+	m.InReplyTo = h.AlertMessageID
+	m.Sender = owner.IP()
+	m.User = owner.MaybeUserID()
+	logv(v).
+		Tag(tagPublish).
+		Fields(log.Context{
+			"topic":                   h.Topic,
+			"heartbeat_alert_topic":   h.AlertTopic,
+			"heartbeat_alert_id":      h.AlertMessageID,
+			"heartbeat_down_duration": down.String(),
+		}).
+		Info("Heartbeat of topic %s recovered", h.Topic)
+	s.publishServerMessage(owner, m)
+	return nil
+}
+
+// checkHeartbeats publishes an alert to the alert topic of every heartbeat that missed its ping. Each heartbeat is only
+// alerted once, until it recovers. If the alert topic is reserved with an escalation policy, the alert is escalated.
+func (s *Server) checkHeartbeats() error {
+	heartbeats, err := s.messageCache.HeartbeatsDue()
+	if err != nil {
+		return err
+	}
+	for _, h := range heartbeats {
+		if err := s.alertHeartbeat(h); err != nil {
+			log.Tag(tagPublish).Field("topic", h.Topic).Err(err).Warn("Error alerting missed heartbeat")
+		}
+	}
+	return nil
+}
+
+func (s *Server) alertHeartbeat(h *heartbeat) error {
+	v, err := s.heartbeatVisitor(h)
+	if err != nil {
+		return err
+	}
+	lang := userLanguage(v.User())
+	body := h.Message
+	if body == "" {
+		body = translate(lang, "heartbeat_missed_body", h.Topic, h.Interval)
+	}
+	m := newDefaultMessage(h.AlertTopic, body)
+	m.Title = translate(lang, "heartbeat_missed_title", h.Topic)
+	m.Priority = h.Priority
+	m.Tags = []string{heartbeatAlertTag}
+	m.Sender = v.IP()
+	m.User = v.MaybeUserID()
+	logvm(v, m).
+		Tag(tagPublish).
+		Fields(log.Context{
+			"topic":                 h.Topic,
+			"heartbeat_interval":    h.Interval.String(),
+			"heartbeat_last_ping":   h.LastPing,
+			"heartbeat_alert_topic": h.AlertTopic,
+		}).
+		Info("Missed heartbeat of topic %s, publishing alert", h.Topic)
+	if err := s.messageCache.MarkHeartbeatAlerted(h.Topic, m.ID); err != nil {
+		return err
+	}
+	s.publishServerMessage(v, m)
+	s.scheduleEscalation(v, m)
+	return nil
+}
+
+// heartbeatVisitor returns the visitor on whose behalf heartbeat alerts are published, i.e. the user who created the
+// heartbeat, or an anonymous visitor
+func (s *Server) heartbeatVisitor(h *heartbeat) (*visitor, error) {
+	var u *user.User
+	if s.userManager != nil && h.User != "" {
+		var err error
+		u, err = s.userManager.UserByID(h.User)
+		if err != nil && !errors.Is(err, user.ErrUserNotFound) {
+			return nil, err
+		}
+	}
+	return s.visitor(netip.IPv4Unspecified(), u), nil
+}
+
+// validateHeartbeat checks a heartbeat request, and converts it to the heartbeat stored in the message cache. The user
+// must be allowed to publish to the alert topic, which defaults to the topic itself.
+// canChangeHeartbeat returns true if the visitor may change or remove the given heartbeat, i.e. if
+// the visitor's user created it, or if the user is an admin
+func canChangeHeartbeat(v *visitor, h *heartbeat) bool {
+	u := v.User()
+	return u != nil && (u.IsAdmin() || u.ID == h.User)
+}
+
+func (s *Server) validateHeartbeat(u *user.User, topic string, req *apiHeartbeat) (*heartbeat, error) {
+	interval, err := util.ParseDuration(req.Interval)
+	if err != nil || interval < heartbeatMinInterval || interval > heartbeatMaxInterval {
+		return nil, errHTTPBadRequestHeartbeatInvalid
+	}
+	alertTopic := req.AlertTopic
+	if alertTopic == "" {
+		alertTopic = topic
+	} else if !topicRegex.MatchString(alertTopic) {
+		return nil, errHTTPBadRequestHeartbeatInvalid
+	} else if s.userManager != nil {
+		if err := s.userManager.Authorize(u, alertTopic, user.PermissionWrite); err != nil {
+			return nil, errHTTPForbidden
+		}
+	}
+	priority := req.Priority
+	if priority == 0 {
+		priority = heartbeatDefaultPriority
+	} else if priority < 1 || priority > 5 {
+		return nil, errHTTPBadRequestHeartbeatInvalid
+	}
+	if len(req.Message) > s.config.MessageLimit {
+		return nil, errHTTPBadRequestHeartbeatInvalid
+	}
+	now := time.Now()
+	return &heartbeat{
+		Topic:      topic,
+		Interval:   interval,
+		AlertTopic: alertTopic,
+		Message:    req.Message,
+		Priority:   priority,
+		Created:    now.Unix(),
+		Due:        now.Add(interval).Unix(),
+	}, nil
+}
+
+func topicFromHeartbeatPath(r *http.Request) (string, error) {
+	matches := heartbeatPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		matches = heartbeatPingPathRegex.FindStringSubmatch(r.URL.Path)
+	}
+	if len(matches) != 2 {
+		return "", errHTTPInternalErrorInvalidPath
+	}
+	return matches[1], nil
+}
+
+func toAPIHeartbeat(h *heartbeat) *apiHeartbeat {
+	status := heartbeatStatusUp
+	if h.AlertMessageID != "" {
+		status = heartbeatStatusDown
+	} else if h.LastPing == 0 {
+		status = heartbeatStatusNew
+	}
+	return &apiHeartbeat{
+		Interval:   h.Interval.String(),
+		AlertTopic: h.AlertTopic,
+		Message:    h.Message,
+		Priority:   h.Priority,
+		LastPing:   h.LastPing,
+		Due:        h.Due,
+		Status:     status,
+	}
+}
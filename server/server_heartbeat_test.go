@@ -0,0 +1,257 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestServer_Heartbeat_CRUD(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	auth := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+
+	rr := request(t, s, "GET", "/backups/heartbeat", "", nil)
+	require.Equal(t, 404, rr.Code)
+	require.Equal(t, 40409, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "POST", "/backups/heartbeat/ping", "", nil)
+	require.Equal(t, 404, rr.Code)
+
+	// Invalid heartbeats
+	for _, invalid := range []string{
+		`{}`,
+		`{"interval":"10s"}`,
+		`{"interval":"60d"}`,
+		`{"interval":"1h","priority":6}`,
+		`{"interval":"1h","alert_topic":"not/valid"}`,
+	} {
+		rr = request(t, s, "PUT", "/backups/heartbeat", invalid, auth)
+		require.Equal(t, 400, rr.Code, invalid)
+		require.Equal(t, 40062, toHTTPError(t, rr.Body.String()).Code)
+	}
+
+	// Add heartbeat
+	rr = request(t, s, "PUT", "/backups/heartbeat", `{"interval":"1d","alert_topic":"alerts"}`, auth)
+	require.Equal(t, 200, rr.Code)
+	h, _ := util.UnmarshalJSON[apiHeartbeat](io.NopCloser(rr.Body))
+	require.Equal(t, "24h0m0s", h.Interval)
+	require.Equal(t, "alerts", h.AlertTopic)
+	require.Equal(t, 4, h.Priority)
+	require.Equal(t, "new", h.Status)
+	require.Equal(t, int64(0), h.LastPing)
+
+	// Ping without publishing a message
+	rr = request(t, s, "GET", "/backups/heartbeat/ping", "", nil)
+	require.Equal(t, 200, rr.Code)
+	h, _ = util.UnmarshalJSON[apiHeartbeat](io.NopCloser(rr.Body))
+	require.Equal(t, "up", h.Status)
+	require.InDelta(t, time.Now().Unix(), h.LastPing, 2)
+	require.InDelta(t, time.Now().Add(24*time.Hour).Unix(), h.Due, 2)
+	rr = request(t, s, "GET", "/backups/json?poll=1", "", nil)
+	require.Empty(t, toMessages(t, rr.Body.String()))
+
+	// Delete heartbeat
+	rr = request(t, s, "DELETE", "/backups/heartbeat", "", auth)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/backups/heartbeat", "", nil)
+	require.Equal(t, 404, rr.Code)
+	rr = request(t, s, "DELETE", "/backups/heartbeat", "", auth)
+	require.Equal(t, 404, rr.Code)
+}
+
+func TestServer_Heartbeat_AlertAndRecover(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+
+	rr := request(t, s, "PUT", "/backups/heartbeat", `{"interval":"5m","alert_topic":"alerts","priority":5}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Not due yet
+	require.Nil(t, s.checkHeartbeats())
+	rr = request(t, s, "GET", "/alerts/json?poll=1", "", nil)
+	require.Empty(t, toMessages(t, rr.Body.String()))
+
+	// Missed ping publishes an alert, only once
+	_, err := s.messageCache.db.Exec(`UPDATE heartbeats SET due = 0`)
+	require.Nil(t, err)
+	require.Nil(t, s.checkHeartbeats())
+	require.Nil(t, s.checkHeartbeats())
+	rr = request(t, s, "GET", "/alerts/json?poll=1", "", nil)
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 1, len(messages))
+	alert := messages[0]
+	require.Equal(t, "Missed heartbeat on backups", alert.Title)
+	require.Equal(t, "No heartbeat was received on topic backups in the last 5m0s.", alert.Message)
+	require.Equal(t, 5, alert.Priority)
+	require.Equal(t, []string{"broken_heart"}, alert.Tags)
+
+	rr = request(t, s, "GET", "/backups/heartbeat", "", nil)
+	h, _ := util.UnmarshalJSON[apiHeartbeat](io.NopCloser(rr.Body))
+	require.Equal(t, "down", h.Status)
+
+	// Any message counts as a ping, and publishes a recovery message as a reply to the alert
+	rr = request(t, s, "PUT", "/backups", "backup done", nil)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/alerts/json?poll=1", "", nil)
+	messages = toMessages(t, rr.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "Heartbeat on backups is back", messages[1].Title)
+	require.Equal(t, []string{"green_heart"}, messages[1].Tags)
+	require.Equal(t, alert.ID, messages[1].InReplyTo)
+
+	rr = request(t, s, "GET", "/backups/heartbeat", "", nil)
+	h, _ = util.UnmarshalJSON[apiHeartbeat](io.NopCloser(rr.Body))
+	require.Equal(t, "up", h.Status)
+	require.InDelta(t, time.Now().Add(5*time.Minute).Unix(), h.Due, 2)
+}
+
+func TestServer_Heartbeat_AlertEscalated(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		EmailLimit:       10,
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	rr := request(t, s, "POST", "/v1/account/reservation", `{"topic":"alerts","everyone":"deny-all","escalation":{"steps":[{"delay":"5m","email":"boss@example.com"}]}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Other users cannot send alerts to the reserved topic
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "backups", user.PermissionReadWrite))
+	rr = request(t, s, "PUT", "/backups/heartbeat", `{"interval":"5m","alert_topic":"alerts"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Alert is escalated, unless acknowledged
+	rr = request(t, s, "PUT", "/backups/heartbeat", `{"interval":"5m","alert_topic":"alerts"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	_, err := s.messageCache.db.Exec(`UPDATE heartbeats SET due = 0`)
+	require.Nil(t, err)
+	require.Nil(t, s.checkHeartbeats())
+	_, err = s.messageCache.db.Exec(`UPDATE escalations SET due = 0`)
+	require.Nil(t, err)
+	require.Nil(t, s.escalateMessages())
+	waitFor(t, func() bool {
+		return mailer.Count() == 1
+	})
+	require.Equal(t, "Missed heartbeat on backups", mailer.Messages()[0].Title)
+	require.Equal(t, []string{"boss@example.com"}, mailer.Recipients())
+}
+
+func TestServer_Heartbeat_Owner(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("admin", "admin", user.RoleAdmin))
+
+	// Anonymous users cannot create heartbeats
+	rr := request(t, s, "PUT", "/backups/heartbeat", `{"interval":"1h"}`, nil)
+	require.Equal(t, 401, rr.Code)
+
+	rr = request(t, s, "PUT", "/backups/heartbeat", `{"interval":"1h"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Only the creator (or an admin) can change or remove the heartbeat
+	rr = request(t, s, "PUT", "/backups/heartbeat", `{"interval":"2h"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "DELETE", "/backups/heartbeat", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "DELETE", "/backups/heartbeat", "", nil)
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "GET", "/backups/heartbeat", "", nil)
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "DELETE", "/backups/heartbeat", "", map[string]string{
+		"Authorization": util.BasicAuth("admin", "admin"),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestServer_Heartbeat_TooMany(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+
+	for i := 0; i < heartbeatMaxCount; i++ {
+		rr := request(t, s, "PUT", fmt.Sprintf("/backups%d/heartbeat", i), `{"interval":"1h"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+	}
+	rr := request(t, s, "PUT", "/backups/heartbeat", `{"interval":"1h"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 429, rr.Code)
+	require.Equal(t, 42916, toHTTPError(t, rr.Body.String()).Code)
+
+	// Changing an existing heartbeat is still allowed
+	rr = request(t, s, "PUT", "/backups0/heartbeat", `{"interval":"2h"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestMessageCache_Heartbeats(t *testing.T) {
+	c := newSqliteTestCache(t)
+	now := time.Now().Unix()
+	require.Nil(t, c.UpsertHeartbeat(&heartbeat{Topic: "backups", Interval: time.Hour, AlertTopic: "alerts", Priority: 4, Created: now, Due: now - 10}))
+	require.Nil(t, c.UpsertHeartbeat(&heartbeat{Topic: "cron", Interval: time.Hour, AlertTopic: "cron", Priority: 4, Created: now, Due: now + 3600}))
+
+	heartbeats, err := c.HeartbeatsDue()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(heartbeats))
+	require.Equal(t, "backups", heartbeats[0].Topic)
+	require.Equal(t, time.Hour, heartbeats[0].Interval)
+
+	// Alerted heartbeats are not due again, until pinged
+	require.Nil(t, c.MarkHeartbeatAlerted("backups", "alert1"))
+	heartbeats, err = c.HeartbeatsDue()
+	require.Nil(t, err)
+	require.Empty(t, heartbeats)
+
+	// Changing a heartbeat keeps its alert state
+	require.Nil(t, c.UpsertHeartbeat(&heartbeat{Topic: "backups", Interval: 2 * time.Hour, AlertTopic: "alerts", Priority: 5, Created: now, Due: now + 7200}))
+	h, err := c.Heartbeat("backups")
+	require.Nil(t, err)
+	require.Equal(t, "alert1", h.AlertMessageID)
+	require.Equal(t, 5, h.Priority)
+
+	require.Nil(t, c.MarkHeartbeatPinged("backups", now))
+	h, err = c.Heartbeat("backups")
+	require.Nil(t, err)
+	require.Equal(t, "", h.AlertMessageID)
+	require.Equal(t, now, h.LastPing)
+	require.Equal(t, now+7200, h.Due)
+
+	require.Nil(t, c.RemoveHeartbeat("backups"))
+	_, err = c.Heartbeat("backups")
+	require.Equal(t, errHeartbeatNotFound, err)
+	require.Equal(t, errHeartbeatNotFound, c.RemoveHeartbeat("backups"))
+}
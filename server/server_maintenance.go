@@ -0,0 +1,253 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	maintenanceIDPrefix          = "mw_"
+	maintenanceIDLength          = 12
+	maintenanceMaxWindows        = 20 // Per topic
+	maintenanceMaxReasonLength   = 256
+	maintenanceTag               = "maintenance"
+	maintenanceDowngradePriority = 2
+	maintenanceRecurrenceDaily   = "daily"
+	maintenanceRecurrenceWeekly  = "weekly"
+	maintenanceActionSuppress    = "suppress"
+	maintenanceActionDowngrade   = "downgrade"
+	maintenanceActionTag         = "tag"
+)
+
+var (
+	maintenanceRecurrences = []string{"", maintenanceRecurrenceDaily, maintenanceRecurrenceWeekly}
+	maintenanceActions     = []string{maintenanceActionSuppress, maintenanceActionDowngrade, maintenanceActionTag}
+)
+
+// handleMaintenanceWindowsGet lists all maintenance windows of a topic, including windows that are not active right now
+func (s *Server) handleMaintenanceWindowsGet(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	matches := maintenancePathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	windows, err := s.messageCache.MaintenanceWindows(topic)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	response := &apiMaintenanceWindowsResponse{
+		Topic:   topic,
+		Windows: make([]*apiMaintenanceWindow, 0, len(windows)),
+	}
+	for _, mw := range windows {
+		response.Windows = append(response.Windows, toAPIMaintenanceWindow(mw, now))
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleMaintenanceWindowAdd adds a one-off or recurring maintenance window to a topic. It is meant to be called
+// from deployment pipelines, e.g. right before a deployment starts. Only the owner of the topic reservation (or an
+// admin) can add maintenance windows, since they suppress or downgrade messages for all subscribers.
+func (s *Server) handleMaintenanceWindowAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := maintenancePathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	if owner, err := s.ownsTopic(v, topic); err != nil {
+		return err
+	} else if !owner {
+		return errHTTPForbidden
+	}
+	req, err := readJSONWithLimit[apiMaintenanceWindow](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	mw, err := validateMaintenanceWindow(topic, req)
+	if err != nil {
+		return err
+	}
+	windows, err := s.messageCache.MaintenanceWindows(topic)
+	if err != nil {
+		return err
+	} else if len(windows) >= maintenanceMaxWindows {
+		return errHTTPTooManyRequestsLimitMaintenanceWindows
+	}
+	mw.User = v.MaybeUserID()
+	logvr(v, r).
+		Tag(tagPublish).
+		Fields(log.Context{
+			"topic":                  topic,
+			"maintenance_id":         mw.ID,
+			"maintenance_start":      mw.Start,
+			"maintenance_end":        mw.End,
+			"maintenance_recurrence": mw.Recurrence,
+			"maintenance_action":     mw.Action,
+			"maintenance_reason":     mw.Reason,
+		}).
+		Info("Adding maintenance window to topic %s", topic)
+	if err := s.messageCache.AddMaintenanceWindow(mw); err != nil {
+		return err
+	}
+	return s.writeJSON(w, toAPIMaintenanceWindow(mw, time.Now()))
+}
+
+// handleMaintenanceWindowDelete removes a maintenance window from a topic, e.g. when a deployment finished early. Like
+// adding a window, this requires owning the topic reservation (or being an admin).
+func (s *Server) handleMaintenanceWindowDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := maintenanceIDPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic, id := matches[1], matches[2]
+	if owner, err := s.ownsTopic(v, topic); err != nil {
+		return err
+	} else if !owner {
+		return errHTTPForbidden
+	}
+	if err := s.messageCache.RemoveMaintenanceWindow(topic, id); errors.Is(err, errMaintenanceNotFound) {
+		return errHTTPNotFoundMaintenanceWindow
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagPublish).
+		Fields(log.Context{
+			"topic":          topic,
+			"maintenance_id": id,
+		}).
+		Info("Removed maintenance window from topic %s", topic)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// applyMaintenanceWindows applies the maintenance windows of the message's topic that are active at the time of the
+// message: the message is tagged "maintenance", and its priority is lowered if a window downgrades messages. It returns
+// true if a window suppresses messages, in which case the message is cached, but not delivered to anyone.
+func (s *Server) applyMaintenanceWindows(m *message) (suppress bool, err error) {
+	if m.Event != messageEvent || m.PollID != "" {
+		return false, nil
+	}
+	windows, err := s.activeMaintenanceWindows(m)
+	if err != nil || len(windows) == 0 {
+		return false, err
+	}
+	if !util.Contains(m.Tags, maintenanceTag) {
+		m.Tags = append(m.Tags, maintenanceTag)
+	}
+	for _, mw := range windows {
+		switch mw.Action {
+		case maintenanceActionSuppress:
+			suppress = true
+		case maintenanceActionDowngrade:
+			if messagePriority(m) > maintenanceDowngradePriority {
+				m.Priority = maintenanceDowngradePriority
+			}
+		}
+	}
+	return suppress, nil
+}
+
+// suppressedByMaintenance returns true if a maintenance window that suppresses messages is active at the time of the
+// message. It is used for scheduled messages, since windows may have been added after the message was published.
+func (s *Server) suppressedByMaintenance(m *message) (bool, error) {
+	windows, err := s.activeMaintenanceWindows(m)
+	if err != nil {
+		return false, err
+	}
+	for _, mw := range windows {
+		if mw.Action == maintenanceActionSuppress {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Server) activeMaintenanceWindows(m *message) ([]*maintenanceWindow, error) {
+	windows, err := s.messageCache.MaintenanceWindows(m.Topic)
+	if err != nil {
+		return nil, err
+	}
+	t := time.Unix(m.Time, 0)
+	active := make([]*maintenanceWindow, 0)
+	for _, mw := range windows {
+		if mw.Active(t) {
+			active = append(active, mw)
+		}
+	}
+	return active, nil
+}
+
+// validateMaintenanceWindow checks a maintenance window request, and converts it to the window stored in the message
+// cache. Either the end or the duration of the window must be set. Recurring windows must be shorter than their period.
+func validateMaintenanceWindow(topic string, req *apiMaintenanceWindow) (*maintenanceWindow, error) {
+	now := time.Now().Unix()
+	start := req.Start
+	if start == 0 {
+		start = now
+	} else if start < 0 {
+		return nil, errHTTPBadRequestMaintenanceWindowInvalid
+	}
+	end := req.End
+	if (end == 0) == (req.Duration == "") {
+		return nil, errHTTPBadRequestMaintenanceWindowInvalid
+	} else if req.Duration != "" {
+		duration, err := util.ParseDuration(req.Duration)
+		if err != nil {
+			return nil, errHTTPBadRequestMaintenanceWindowInvalid
+		}
+		end = start + int64(duration.Seconds())
+	}
+	if end <= start {
+		return nil, errHTTPBadRequestMaintenanceWindowInvalid
+	}
+	action := req.Action
+	if action == "" {
+		action = maintenanceActionSuppress
+	}
+	if !util.Contains(maintenanceActions, action) || !util.Contains(maintenanceRecurrences, req.Recurrence) {
+		return nil, errHTTPBadRequestMaintenanceWindowInvalid
+	} else if len(req.Reason) > maintenanceMaxReasonLength {
+		return nil, errHTTPBadRequestMaintenanceWindowInvalid
+	}
+	switch req.Recurrence {
+	case "":
+		if end <= now {
+			return nil, errHTTPBadRequestMaintenanceWindowInvalid // Already over
+		}
+	case maintenanceRecurrenceDaily:
+		if end-start >= int64((24 * time.Hour).Seconds()) {
+			return nil, errHTTPBadRequestMaintenanceWindowInvalid
+		}
+	case maintenanceRecurrenceWeekly:
+		if end-start >= int64((7 * 24 * time.Hour).Seconds()) {
+			return nil, errHTTPBadRequestMaintenanceWindowInvalid
+		}
+	}
+	return &maintenanceWindow{
+		ID:         util.RandomStringPrefix(maintenanceIDPrefix, maintenanceIDLength),
+		Topic:      topic,
+		Start:      start,
+		End:        end,
+		Recurrence: req.Recurrence,
+		Action:     action,
+		Reason:     req.Reason,
+		Created:    now,
+	}, nil
+}
+
+func toAPIMaintenanceWindow(mw *maintenanceWindow, now time.Time) *apiMaintenanceWindow {
+	return &apiMaintenanceWindow{
+		ID:         mw.ID,
+		Start:      mw.Start,
+		End:        mw.End,
+		Recurrence: mw.Recurrence,
+		Action:     mw.Action,
+		Reason:     mw.Reason,
+		Active:     mw.Active(now),
+	}
+}
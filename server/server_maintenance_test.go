@@ -0,0 +1,259 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_Maintenance_CRUD(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	philAuth := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+
+	// Invalid windows
+	for _, invalid := range []string{
+		`{}`,
+		`{"duration":"1h","end":1700000000}`,
+		`{"duration":"1h","action":"ignore"}`,
+		`{"duration":"1h","recurrence":"monthly"}`,
+		`{"duration":"24h","recurrence":"daily"}`,
+		`{"start":1000,"end":2000}`,
+		`{"start":2000,"end":1000,"recurrence":"weekly"}`,
+	} {
+		rr := request(t, s, "POST", "/mytopic/maintenance", invalid, philAuth)
+		require.Equal(t, 400, rr.Code, invalid)
+		require.Equal(t, 40061, toHTTPError(t, rr.Body.String()).Code)
+	}
+
+	// Add windows
+	rr := request(t, s, "POST", "/mytopic/maintenance", `{"duration":"1h","reason":"Deploying v2.3.0"}`, philAuth)
+	require.Equal(t, 200, rr.Code)
+	deploy, _ := util.UnmarshalJSON[apiMaintenanceWindow](io.NopCloser(rr.Body))
+	require.NotEmpty(t, deploy.ID)
+	require.Equal(t, deploy.Start+3600, deploy.End)
+	require.Equal(t, "suppress", deploy.Action)
+	require.True(t, deploy.Active)
+
+	start := time.Now().Add(time.Hour).Unix()
+	rr = request(t, s, "POST", "/mytopic/maintenance", fmt.Sprintf(`{"start":%d,"duration":"30m","recurrence":"daily","action":"tag"}`, start), philAuth)
+	require.Equal(t, 200, rr.Code)
+	backup, _ := util.UnmarshalJSON[apiMaintenanceWindow](io.NopCloser(rr.Body))
+	require.Equal(t, "daily", backup.Recurrence)
+	require.False(t, backup.Active)
+
+	rr = request(t, s, "GET", "/mytopic/maintenance", "", nil)
+	require.Equal(t, 200, rr.Code)
+	windows, _ := util.UnmarshalJSON[apiMaintenanceWindowsResponse](io.NopCloser(rr.Body))
+	require.Equal(t, "mytopic", windows.Topic)
+	require.Equal(t, 2, len(windows.Windows))
+	require.Equal(t, deploy.ID, windows.Windows[0].ID)
+	require.Equal(t, "Deploying v2.3.0", windows.Windows[0].Reason)
+
+	// Windows of other topics are separate
+	rr = request(t, s, "DELETE", "/othertopic/maintenance/"+deploy.ID, "", philAuth)
+	require.Equal(t, 404, rr.Code)
+	require.Equal(t, 40408, toHTTPError(t, rr.Body.String()).Code)
+
+	// Delete window
+	rr = request(t, s, "DELETE", "/mytopic/maintenance/"+deploy.ID, "", philAuth)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/mytopic/maintenance", "", nil)
+	windows, _ = util.UnmarshalJSON[apiMaintenanceWindowsResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(windows.Windows))
+	require.Equal(t, backup.ID, windows.Windows[0].ID)
+}
+
+func TestServer_Maintenance_Limit(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	philAuth := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+	for i := 0; i < maintenanceMaxWindows; i++ {
+		rr := request(t, s, "POST", "/mytopic/maintenance", `{"duration":"1h"}`, philAuth)
+		require.Equal(t, 200, rr.Code)
+	}
+	rr := request(t, s, "POST", "/mytopic/maintenance", `{"duration":"1h"}`, philAuth)
+	require.Equal(t, 429, rr.Code)
+	require.Equal(t, 42913, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestServer_Maintenance_Publish(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	philAuth := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+
+	// Suppressed messages are cached, but not delivered
+	rr := request(t, s, "POST", "/mytopic/maintenance", `{"duration":"1h"}`, philAuth)
+	require.Equal(t, 200, rr.Code)
+	suppress, _ := util.UnmarshalJSON[apiMaintenanceWindow](io.NopCloser(rr.Body))
+
+	subscribeRR := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/json", subscribeRR)
+	rr = request(t, s, "PUT", "/mytopic", "deploying", map[string]string{
+		"Priority": "urgent",
+		"Email":    "phil@example.com",
+	})
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	require.Equal(t, []string{"maintenance"}, m.Tags)
+	require.Equal(t, 5, m.Priority)
+	subscribeCancel()
+	require.Equal(t, 1, len(toMessages(t, subscribeRR.Body.String()))) // Only the "open" event
+	require.Equal(t, 0, mailer.Count())
+
+	rr = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "deploying", messages[0].Message)
+
+	// Downgraded messages are delivered with lower priority
+	rr = request(t, s, "DELETE", "/mytopic/maintenance/"+suppress.ID, "", philAuth)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/mytopic/maintenance", `{"duration":"1h","action":"downgrade"}`, philAuth)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/mytopic", "still deploying", map[string]string{
+		"Priority": "high",
+		"Tags":     "rocket",
+	})
+	require.Equal(t, 200, rr.Code)
+	m = toMessage(t, rr.Body.String())
+	require.Equal(t, []string{"rocket", "maintenance"}, m.Tags)
+	require.Equal(t, 2, m.Priority)
+
+	// Other topics are not affected
+	rr = request(t, s, "PUT", "/othertopic", "all good", map[string]string{"Priority": "high"})
+	require.Equal(t, 200, rr.Code)
+	m = toMessage(t, rr.Body.String())
+	require.Empty(t, m.Tags)
+	require.Equal(t, 4, m.Priority)
+}
+
+func TestServer_Maintenance_DelayedMessageSuppressed(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	philAuth := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+
+	rr := request(t, s, "PUT", "/mytopic", "scheduled", map[string]string{"In": "30m"})
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	require.Empty(t, m.Tags)
+
+	// Window is added after the message was scheduled
+	rr = request(t, s, "POST", "/mytopic/maintenance", fmt.Sprintf(`{"start":%d,"duration":"1h"}`, time.Now().Add(-time.Minute).Unix()), philAuth)
+	require.Equal(t, 200, rr.Code)
+
+	subscribeRR := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/json", subscribeRR)
+	m.Time = time.Now().Unix()
+	require.Nil(t, s.sendDelayedMessage(s.visitor(m.Sender, nil), m))
+	subscribeCancel()
+	require.Equal(t, 1, len(toMessages(t, subscribeRR.Body.String()))) // Only the "open" event
+
+	// Message is marked as published, and is not sent again
+	messages, err := s.messageCache.MessagesDue()
+	require.Nil(t, err)
+	require.Empty(t, messages)
+}
+
+func TestServer_Maintenance_NotOwner(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	rr := request(t, s, "POST", "/v1/account/reservation", `{"topic":"mytopic","everyone":"read-write"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Anonymous users and users who can write to the topic, but do not own it, cannot add windows
+	rr = request(t, s, "POST", "/mytopic/maintenance", `{"duration":"1h"}`, nil)
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "POST", "/mytopic/maintenance", `{"duration":"1h"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "POST", "/othertopic/maintenance", `{"duration":"1h"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// The owner can add and remove windows, others cannot remove them
+	rr = request(t, s, "POST", "/mytopic/maintenance", `{"duration":"1h"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	mw, _ := util.UnmarshalJSON[apiMaintenanceWindow](io.NopCloser(rr.Body))
+	rr = request(t, s, "DELETE", "/mytopic/maintenance/"+mw.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "DELETE", "/mytopic/maintenance/"+mw.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestMaintenanceWindow_Active(t *testing.T) {
+	start := time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)
+	once := &maintenanceWindow{Start: start.Unix(), End: start.Add(time.Hour).Unix()}
+	require.False(t, once.Active(start.Add(-time.Second)))
+	require.True(t, once.Active(start))
+	require.True(t, once.Active(start.Add(59*time.Minute)))
+	require.False(t, once.Active(start.Add(time.Hour)))
+	require.False(t, once.Active(start.Add(24*time.Hour)))
+
+	daily := &maintenanceWindow{Start: start.Unix(), End: start.Add(time.Hour).Unix(), Recurrence: "daily"}
+	require.False(t, daily.Active(start.Add(-time.Minute)))
+	require.True(t, daily.Active(start.Add(30*time.Minute)))
+	require.False(t, daily.Active(start.Add(2*time.Hour)))
+	require.True(t, daily.Active(start.Add(5*24*time.Hour+30*time.Minute)))
+
+	weekly := &maintenanceWindow{Start: start.Unix(), End: start.Add(time.Hour).Unix(), Recurrence: "weekly"}
+	require.False(t, weekly.Active(start.Add(24*time.Hour+30*time.Minute)))
+	require.True(t, weekly.Active(start.Add(14*24*time.Hour+30*time.Minute)))
+}
+
+func TestMessageCache_MaintenanceWindows(t *testing.T) {
+	c := newSqliteTestCache(t)
+	now := time.Now().Unix()
+	require.Nil(t, c.AddMaintenanceWindow(&maintenanceWindow{ID: "mw_1", Topic: "mytopic", Start: now - 7200, End: now - 3600, Action: "suppress"}))
+	require.Nil(t, c.AddMaintenanceWindow(&maintenanceWindow{ID: "mw_2", Topic: "mytopic", Start: now - 7200, End: now - 3600, Recurrence: "daily", Action: "tag"}))
+	require.Nil(t, c.AddMaintenanceWindow(&maintenanceWindow{ID: "mw_3", Topic: "mytopic", Start: now, End: now + 3600, Action: "downgrade"}))
+
+	// Only expired one-off windows are removed
+	require.Nil(t, c.RemoveExpiredMaintenanceWindows())
+	windows, err := c.MaintenanceWindows("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 2, len(windows))
+	require.Equal(t, "mw_2", windows[0].ID)
+	require.Equal(t, "mw_3", windows[1].ID)
+
+	require.Equal(t, errMaintenanceNotFound, c.RemoveMaintenanceWindow("othertopic", "mw_2"))
+	require.Nil(t, c.RemoveMaintenanceWindow("mytopic", "mw_2"))
+	windows, err = c.MaintenanceWindows("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(windows))
+}
@@ -2,8 +2,11 @@ package server
 
 import (
 	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	"net/netip"
 	"strings"
+	"time"
 )
 
 func (s *Server) execManager() {
@@ -13,9 +16,17 @@ func (s *Server) execManager() {
 	// Prune all the things
 	s.pruneVisitors()
 	s.pruneTokens()
+	s.pruneUsers()
 	s.pruneAttachments()
+	s.pruneUploads()
+	s.pruneCacheLimits()
 	s.pruneMessages()
+	s.recomputeAttachmentUsage()
+	s.pruneInactiveTopics()
+	s.pruneBannedIPs()
+	s.pruneMaintenanceWindows()
 	s.pruneAndNotifyWebPushSubscriptions()
+	s.vacuumCache()
 
 	// Message count per topic
 	var messagesCached int
@@ -27,6 +38,10 @@ func (s *Server) execManager() {
 	for _, count := range messageCounts {
 		messagesCached += count
 	}
+	cacheSize, cacheFree, err := s.messageCache.Size()
+	if err != nil {
+		log.Tag(tagManager).Err(err).Warn("Cannot get message cache size")
+	}
 
 	// Remove subscriptions without subscribers
 	var emptyTopics, subscribers int
@@ -80,6 +95,7 @@ func (s *Server) execManager() {
 
 	// Update stats
 	s.updateAndWriteStats(messagesCount)
+	s.writeStatsHistory(subscribers)
 
 	// Log stats
 	log.
@@ -87,6 +103,8 @@ func (s *Server) execManager() {
 		Fields(log.Context{
 			"messages_published":      messagesCount,
 			"messages_cached":         messagesCached,
+			"cache_size":              cacheSize,
+			"cache_free":              cacheFree,
 			"topics_active":           topicsCount,
 			"subscribers":             subscribers,
 			"visitors":                visitorsCount,
@@ -100,6 +118,8 @@ func (s *Server) execManager() {
 		}).
 		Info("Server stats")
 	mset(metricMessagesCached, messagesCached)
+	mset(metricCacheSizeBytes, cacheSize)
+	mset(metricCacheFreeBytes, cacheFree)
 	mset(metricVisitors, visitorsCount)
 	mset(metricUsers, usersCount)
 	mset(metricSubscribers, subscribers)
@@ -136,11 +156,32 @@ func (s *Server) pruneTokens() {
 				if err := s.userManager.RemoveDeletedUsers(); err != nil {
 					log.Tag(tagManager).Err(err).Warn("Error deleting soft-deleted users")
 				}
+				if err := s.userManager.RemoveOldChanges(); err != nil {
+					log.Tag(tagManager).Err(err).Warn("Error pruning user change log")
+				}
 			}).
 			Debug("Removed expired tokens and users")
 	}
 }
 
+// pruneUsers deletes users whose scheduled deletion is due, see handleAccountDelete. Their attachments and
+// messages are removed by pruneAttachments and pruneMessages afterwards.
+func (s *Server) pruneUsers() {
+	if s.userManager == nil {
+		return
+	}
+	users, err := s.userManager.UsersDeletionDue()
+	if err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error retrieving users scheduled for deletion")
+		return
+	}
+	for _, u := range users {
+		if err := s.removeUser(u); err != nil {
+			log.Tag(tagManager).Err(err).Warn("Error deleting user %s", u.Name)
+		}
+	}
+}
+
 func (s *Server) pruneAttachments() {
 	if s.fileCache == nil {
 		return
@@ -168,6 +209,35 @@ func (s *Server) pruneAttachments() {
 		Debug("Deleted expired attachments")
 }
 
+// pruneUploads deletes expired uploads, see handleAttachmentUpload. Messages referencing an upload have their
+// own (hard-linked) copy of the file, so they are not affected.
+func (s *Server) pruneUploads() {
+	if s.fileCache == nil {
+		return
+	}
+	log.
+		Tag(tagManager).
+		Timing(func() {
+			ids, err := s.messageCache.UploadsExpired()
+			if err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error retrieving expired uploads")
+			} else if len(ids) > 0 {
+				if log.Tag(tagManager).IsDebug() {
+					log.Tag(tagManager).Debug("Deleting uploads %s", strings.Join(ids, ", "))
+				}
+				if err := s.fileCache.Remove(ids...); err != nil {
+					log.Tag(tagManager).Err(err).Warn("Error deleting uploads")
+				}
+				if err := s.messageCache.DeleteUploads(ids...); err != nil {
+					log.Tag(tagManager).Err(err).Warn("Error deleting uploads from cache")
+				}
+			} else {
+				log.Tag(tagManager).Debug("No expired uploads to delete")
+			}
+		}).
+		Debug("Deleted expired uploads")
+}
+
 func (s *Server) pruneMessages() {
 	log.
 		Tag(tagManager).
@@ -190,3 +260,319 @@ func (s *Server) pruneMessages() {
 		}).
 		Debug("Pruned messages")
 }
+
+// recomputeAttachmentUsage resets the stored per-user attachment storage counters to the actual usage, so that
+// expired and deleted attachments no longer count towards the quota, see messageCache.AddAttachmentUsage
+func (s *Server) recomputeAttachmentUsage() {
+	log.
+		Tag(tagManager).
+		Timing(func() {
+			if err := s.messageCache.RecomputeAttachmentUsage(); err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error recomputing attachment usage")
+			}
+		}).
+		Debug("Recomputed attachment usage")
+}
+
+// pruneCacheLimits marks messages as expired if the message cache exceeds the configured number of messages per
+// topic, or the configured maximum size. The oldest messages are expired first. Expired messages (and their
+// attachments) are then deleted by pruneMessages.
+func (s *Server) pruneBannedIPs() {
+	s.banTracker.Prune()
+	if err := s.messageCache.RemoveExpiredIPBans(); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error removing expired IP bans")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	for ip, expires := range s.bannedIPs {
+		if expires > 0 && expires <= now {
+			delete(s.bannedIPs, ip)
+		}
+	}
+}
+
+func (s *Server) pruneMaintenanceWindows() {
+	if err := s.messageCache.RemoveExpiredMaintenanceWindows(); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error removing expired maintenance windows")
+	}
+}
+
+func (s *Server) pruneCacheLimits() {
+	if s.config.CacheMaxMessagesPerTopic == 0 && s.config.CacheMaxSize == 0 {
+		return
+	}
+	log.
+		Tag(tagManager).
+		Timing(func() {
+			if err := s.pruneCacheLimitsInternal(); err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error enforcing message cache limits")
+			}
+		}).
+		Debug("Enforced message cache limits")
+}
+
+func (s *Server) pruneCacheLimitsInternal() error {
+	if s.config.CacheMaxMessagesPerTopic > 0 {
+		expired, err := s.messageCache.ExpireMessagesExceedingTopicLimit(s.config.CacheMaxMessagesPerTopic)
+		if err != nil {
+			return err
+		} else if expired > 0 {
+			log.Tag(tagManager).Debug("Expiring %d message(s) exceeding the per-topic limit of %d message(s)", expired, s.config.CacheMaxMessagesPerTopic)
+		}
+	}
+	if s.config.CacheMaxSize > 0 {
+		used, err := s.messageCache.MessagesSize()
+		if err != nil {
+			return err
+		}
+		if used <= s.config.CacheMaxSize {
+			return nil
+		}
+		count, err := s.messageCache.MessagesCount()
+		if err != nil {
+			return err
+		} else if count == 0 {
+			return nil
+		}
+		// Estimate the number of messages to expire based on the average message size. If this is not
+		// enough, more messages are expired in the next manager run.
+		averageSize := util.Max(used/count, 1)
+		expire := (used - s.config.CacheMaxSize + averageSize - 1) / averageSize
+		expired, err := s.messageCache.ExpireOldestMessages(expire)
+		if err != nil {
+			return err
+		}
+		log.Tag(tagManager).Debug("Message cache size %s exceeds limit of %s, expiring %d oldest message(s)", util.FormatSize(used), util.FormatSize(s.config.CacheMaxSize), expired)
+	}
+	return nil
+}
+
+// vacuumCache checkpoints and vacuums the message cache to reclaim disk space, if the cache vacuum interval has passed
+func (s *Server) vacuumCache() {
+	if s.config.CacheVacuumInterval == 0 || time.Since(s.cacheVacuumed) < s.config.CacheVacuumInterval {
+		return
+	}
+	s.cacheVacuumed = time.Now()
+	log.
+		Tag(tagManager).
+		Timing(func() {
+			if err := s.messageCache.Vacuum(); err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error vacuuming message cache")
+			}
+		}).
+		Debug("Vacuumed message cache")
+}
+
+// pruneInactiveTopics releases reservations of topics that have not been published to or subscribed to for
+// the configured topic inactivity duration, and purges their cached messages and web push subscriptions. Owners
+// are warned before their reservation is released.
+func (s *Server) pruneInactiveTopics() {
+	if s.config.TopicInactivityExpiryDuration == 0 {
+		return
+	}
+	log.
+		Tag(tagManager).
+		Timing(func() {
+			if err := s.pruneInactiveTopicsInternal(); err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error pruning inactive topics")
+			}
+		}).
+		Debug("Pruned inactive topics")
+}
+
+func (s *Server) pruneInactiveTopicsInternal() error {
+	// Topics are only held in memory if they were recently published to, or if they have subscribers,
+	// so they are considered active (see topicExpungeAfter)
+	s.mu.RLock()
+	activeTopics := make(map[string]bool, len(s.topics))
+	activeTopicIDs := make([]string, 0, len(s.topics))
+	for id := range s.topics {
+		activeTopics[id] = true
+		activeTopicIDs = append(activeTopicIDs, id)
+	}
+	s.mu.RUnlock()
+	now := time.Now()
+	inactiveSince := now.Add(-s.config.TopicInactivityExpiryDuration)
+	// Web push subscribers are not connected, so their topics are not held in memory. Topics with a web push
+	// subscription that was recently renewed by the browser are considered active as well.
+	if s.webPush != nil {
+		webPushTopics, err := s.webPush.TopicsActive(inactiveSince)
+		if err != nil {
+			return err
+		}
+		for _, id := range webPushTopics {
+			if !activeTopics[id] {
+				activeTopics[id] = true
+				activeTopicIDs = append(activeTopicIDs, id)
+			}
+		}
+	}
+	if s.userManager != nil {
+		if err := s.userManager.MarkReservationsActive(activeTopicIDs, now); err != nil {
+			return err
+		}
+		if err := s.releaseInactiveReservations(now, inactiveSince); err != nil {
+			return err
+		}
+	}
+	if err := s.purgeInactiveWebPushTopics(activeTopics, inactiveSince); err != nil {
+		return err
+	}
+	inactiveTopics, err := s.messageCache.TopicsInactive(inactiveSince)
+	if err != nil {
+		return err
+	}
+	expireTopics := make([]string, 0)
+	for _, t := range inactiveTopics {
+		if !activeTopics[t] {
+			expireTopics = append(expireTopics, t)
+		}
+	}
+	if len(expireTopics) > 0 {
+		log.Tag(tagManager).Debug("Purging cached messages of %d inactive topic(s)", len(expireTopics))
+		return s.messageCache.ExpireMessages(expireTopics...)
+	}
+	return nil
+}
+
+// purgeInactiveWebPushTopics removes inactive topics from all web push subscriptions. Reserved topics are skipped,
+// since their subscriptions are removed when the reservation is released, after the owner was warned (which is
+// also delivered via web push), see releaseReservation.
+func (s *Server) purgeInactiveWebPushTopics(activeTopics map[string]bool, inactiveSince time.Time) error {
+	if s.webPush == nil {
+		return nil
+	}
+	inactiveTopics, err := s.webPush.TopicsInactive(inactiveSince)
+	if err != nil {
+		return err
+	}
+	for _, t := range inactiveTopics {
+		if activeTopics[t] {
+			continue
+		} else if s.userManager != nil {
+			owner, err := s.userManager.ReservationOwner(t)
+			if err != nil {
+				return err
+			} else if owner != "" {
+				continue
+			}
+		}
+		log.Tag(tagManager).Field("topic", t).Debug("Removing web push subscriptions of inactive topic %s", t)
+		if err := s.webPush.RemoveSubscriptionsByTopic(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseInactiveReservations warns the owners of reservations that are about to be released, and releases
+// reservations that have been inactive for too long. A reservation is only released after the owner has been
+// warned, and the warning duration has passed.
+func (s *Server) releaseInactiveReservations(now, inactiveSince time.Time) error {
+	warnDuration := s.config.TopicInactivityWarningDuration
+	reservations, err := s.userManager.InactiveReservations(inactiveSince.Add(warnDuration))
+	if err != nil {
+		return err
+	}
+	for _, r := range reservations {
+		ev := log.Tag(tagManager).Fields(log.Context{
+			"topic":                   r.Topic,
+			"user_id":                 r.UserID,
+			"user_name":               r.Username,
+			"reservation_last_active": util.FormatTime(r.LastActive),
+		})
+		if warnDuration > 0 && r.WarnedAt.IsZero() {
+			ev.Info("Warning user %s that reservation for topic %s will be released due to inactivity", r.Username, r.Topic)
+			s.sendInactiveReservationWarning(r, now.Add(warnDuration))
+			if err := s.userManager.MarkReservationInactivityWarned(r.Topic, now); err != nil {
+				return err
+			}
+		} else if r.LastActive.Before(inactiveSince) && r.WarnedAt.Before(now.Add(-warnDuration)) {
+			ev.Info("Releasing reservation for topic %s owned by user %s due to inactivity", r.Topic, r.Username)
+			if err := s.releaseReservation(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) releaseReservation(r *user.InactiveReservation) error {
+	if err := s.userManager.RemoveReservations(r.Username, r.Topic); err != nil {
+		return err
+	}
+	if err := s.expireReservationMessages(r.Topic); err != nil {
+		return err
+	}
+	if s.webPush != nil {
+		if prefix, isPrefix := strings.CutSuffix(r.Topic, "*"); isPrefix {
+			return s.webPush.RemoveSubscriptionsByTopicPrefix(prefix)
+		}
+		return s.webPush.RemoveSubscriptionsByTopic(r.Topic)
+	}
+	return nil
+}
+
+// sendInactiveReservationWarning publishes a warning to the reserved topic. The message is only added to the cache
+// and forwarded to Firebase and web push, since live subscribers would mean the topic is not inactive. Publishing
+// it via topic.Publish would also count as activity.
+//
+// Prefix reservations (e.g. "myteam-*") have no topic that the owner is known to subscribe to, so the warning is sent
+// to the topic and/or e-mail address the owner configured for account warnings (see QuotaWarningPrefs) instead. If
+// the owner did not configure either, no warning is sent.
+func (s *Server) sendInactiveReservationWarning(r *user.InactiveReservation, releaseAt time.Time) {
+	u, err := s.userManager.UserByID(r.UserID)
+	if err != nil {
+		log.Tag(tagManager).Err(err).Warn("Cannot send inactivity warning for topic %s, user %s not found", r.Topic, r.Username)
+		return
+	}
+	v := s.visitor(netip.IPv4Unspecified(), u)
+	lang := userLanguage(u)
+	prefix, isPrefix := strings.CutSuffix(r.Topic, "*")
+	if !isPrefix {
+		m := newInactiveReservationWarningMessage(r.Topic, translate(lang, "reservation_inactive_body", r.LastActive.Format(time.DateOnly), releaseAt.Format(time.DateOnly)), u)
+		s.sendInactiveReservationWarningToTopic(v, m)
+		return
+	} else if !quotaWarningsEnabled(u) {
+		log.Tag(tagManager).Info("Not warning user %s about inactive reservation %s, no account warning topic or e-mail address set", r.Username, r.Topic)
+		return
+	}
+	prefs := u.Prefs.QuotaWarning
+	body := translate(lang, "reservation_inactive_prefix_body", r.Topic, r.LastActive.Format(time.DateOnly), releaseAt.Format(time.DateOnly))
+	if prefs.Topic != nil {
+		m := newInactiveReservationWarningMessage(*prefs.Topic, body, u)
+		if strings.HasPrefix(*prefs.Topic, prefix) {
+			s.sendInactiveReservationWarningToTopic(v, m) // Topic is part of the inactive reservation
+		} else {
+			go s.publishServerMessage(v, m)
+		}
+	}
+	if prefs.Email != nil && s.smtpSender != nil {
+		topic := ""
+		if prefs.Topic != nil {
+			topic = *prefs.Topic
+		}
+		go s.sendEmail(v, newInactiveReservationWarningMessage(topic, body, u), *prefs.Email)
+	}
+}
+
+// sendInactiveReservationWarningToTopic adds the warning to the cache and forwards it to Firebase and web push,
+// without publishing it to the topic, see sendInactiveReservationWarning
+func (s *Server) sendInactiveReservationWarningToTopic(v *visitor, m *message) {
+	m.Expires = time.Unix(m.Time, 0).Add(v.Limits().MessageExpiryDuration).Unix()
+	if err := s.messageCache.AddMessage(m); err != nil {
+		logvm(v, m).Tag(tagManager).Err(err).Warn("Cannot add inactivity warning to cache")
+	}
+	s.publishToPushChannels(v, m, true, true, false)
+}
+
+func newInactiveReservationWarningMessage(topic, body string, u *user.User) *message {
+	m := newDefaultMessage(topic, body)
+	m.Title = translate(userLanguage(u), "reservation_inactive_title")
+	m.Tags = []string{"warning"}
+	m.Priority = 4
+	m.User = u.ID
+	return m
+}
@@ -1,8 +1,16 @@
 package server
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestServer_Manager_Prune_Messages_Without_Attachments_DoesNotPanic(t *testing.T) {
@@ -26,3 +34,251 @@ func TestServer_Manager_Prune_Messages_Without_Attachments_DoesNotPanic(t *testi
 	_, err := s.messageCache.Message(m.ID)
 	require.Equal(t, errMessageNotFound, err)
 }
+
+func TestServer_Manager_InactiveReservations_WarnAndRelease(t *testing.T) {
+	var received atomic.Int32
+	pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer pushService.Close()
+
+	c := newTestConfigWithWebPush(t)
+	c.AuthFile = newTestConfigWithAuthFile(t).AuthFile
+	c.AuthDefault = user.PermissionReadWrite
+	c.TopicInactivityExpiryDuration = 30 * 24 * time.Hour
+	c.TopicInactivityWarningDuration = 7 * 24 * time.Hour
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+	require.Nil(t, s.userManager.AddReservation("phil", "activetopic", user.PermissionDenyAll))
+	addSubscription(t, s, pushService.URL+"/push-receive", "mytopic")
+
+	// Recently reserved topics are not touched
+	require.Nil(t, s.pruneInactiveTopicsInternal())
+	requireReservationCount(t, s, "phil", 2)
+
+	// Topic has been inactive for 25 days: owner is warned, but the reservation is not released yet
+	_, err := s.webPush.db.Exec("UPDATE subscription SET updated_at = ?", time.Now().Add(-31*24*time.Hour).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.userManager.MarkReservationsActive([]string{"mytopic", "activetopic"}, time.Now().Add(-25*24*time.Hour)))
+	rr := request(t, s, "PUT", "/activetopic", "still in use", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Nil(t, s.pruneInactiveTopicsInternal())
+	requireReservationCount(t, s, "phil", 2)
+
+	messages, err := s.messageCache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "Topic reservation will be released", messages[0].Title)
+	waitFor(t, func() bool {
+		return received.Load() == 1
+	})
+
+	reservations, err := s.userManager.InactiveReservations(time.Now().Add(-time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reservations)) // "activetopic" was marked active by the publish
+	require.Equal(t, "mytopic", reservations[0].Topic)
+	require.False(t, reservations[0].WarnedAt.IsZero())
+
+	// Warning is not sent again
+	require.Nil(t, s.pruneInactiveTopicsInternal())
+	messages, err = s.messageCache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+
+	// Topic has been inactive for 31 days, and the owner was warned 8 days ago: reservation is released
+	require.Nil(t, s.userManager.MarkReservationsActive([]string{"mytopic"}, time.Now().Add(-31*24*time.Hour)))
+	require.Nil(t, s.userManager.MarkReservationInactivityWarned("mytopic", time.Now().Add(-8*24*time.Hour)))
+	require.Nil(t, s.pruneInactiveTopicsInternal())
+
+	reservations, err = s.userManager.InactiveReservations(time.Now().Add(-time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, 0, len(reservations))
+	requireReservationCount(t, s, "phil", 1)
+	requireSubscriptionCount(t, s, "mytopic", 0)
+
+	s.execManager() // Deletes expired messages
+	messages, err = s.messageCache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(messages))
+}
+
+func TestServer_Manager_InactiveReservations_WarnPrefix(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.SMTPSenderAddr = "127.0.0.1:25"
+	c.SMTPSenderFrom = "ntfy@ntfy.sh"
+	c.TopicInactivityExpiryDuration = 30 * 24 * time.Hour
+	c.TopicInactivityWarningDuration = 7 * 24 * time.Hour
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddReservation("phil", "myteam-*", user.PermissionDenyAll))
+	require.Nil(t, s.userManager.AddReservation("ben", "bens-team-*", user.PermissionDenyAll))
+	rr := request(t, s, "PATCH", "/v1/account/settings", `{"quota_warning": {"topic": "phil-alerts", "email": "phil@example.com"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Warning for the prefix reservation is sent to the owner's account warning topic and e-mail address,
+	// and not to a topic named like the prefix
+	require.Nil(t, s.userManager.MarkReservationsActive([]string{"myteam-prod", "bens-team-prod"}, time.Now().Add(-25*24*time.Hour)))
+	require.Nil(t, s.pruneInactiveTopicsInternal())
+	waitFor(t, func() bool {
+		return mailer.Count() == 1
+	})
+	require.Equal(t, []string{"phil@example.com"}, mailer.Recipients())
+	require.Equal(t, "Topic reservation will be released", mailer.Messages()[0].Title)
+	require.Contains(t, mailer.Messages()[0].Message, "myteam-*")
+	waitFor(t, func() bool {
+		messages, err := s.messageCache.Messages("phil-alerts", sinceAllMessages, false)
+		require.Nil(t, err)
+		return len(messages) == 1
+	})
+	for _, topic := range []string{"myteam-", "bens-team-"} {
+		messages, err := s.messageCache.Messages(topic, sinceAllMessages, false)
+		require.Nil(t, err)
+		require.Empty(t, messages)
+	}
+
+	// Owners without account warning settings are not warned, but their reservations are released all the same
+	reservations, err := s.userManager.InactiveReservations(time.Now().Add(-time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(reservations))
+	for _, r := range reservations {
+		require.False(t, r.WarnedAt.IsZero())
+	}
+}
+
+func TestServer_Manager_InactiveTopics_PurgeCache(t *testing.T) {
+	c := newTestConfig(t)
+	c.TopicInactivityExpiryDuration = 30 * 24 * time.Hour
+	s := newTestServer(t, c)
+
+	old := newDefaultMessage("oldtopic", "old message")
+	old.Time = time.Now().Add(-40 * 24 * time.Hour).Unix()
+	old.Expires = time.Now().Add(time.Hour).Unix()
+	require.Nil(t, s.messageCache.AddMessage(old))
+	recent := newDefaultMessage("recenttopic", "recent message")
+	recent.Expires = time.Now().Add(time.Hour).Unix()
+	require.Nil(t, s.messageCache.AddMessage(recent))
+
+	require.Nil(t, s.pruneInactiveTopicsInternal())
+	s.pruneMessages()
+
+	counts, err := s.messageCache.MessageCounts()
+	require.Nil(t, err)
+	require.Equal(t, 0, counts["oldtopic"])
+	require.Equal(t, 1, counts["recenttopic"])
+}
+
+func TestServer_Manager_InactiveTopics_WebPush(t *testing.T) {
+	c := newTestConfigWithWebPush(t)
+	c.TopicInactivityExpiryDuration = 30 * 24 * time.Hour
+	s := newTestServer(t, c)
+
+	addSubscription(t, s, testWebPushEndpoint, "stale-topic")
+	addSubscription(t, s, testWebPushEndpoint+"-fresh", "fresh-topic")
+	_, err := s.webPush.db.Exec("UPDATE subscription SET updated_at = ? WHERE endpoint = ?", time.Now().Add(-40*24*time.Hour).Unix(), testWebPushEndpoint)
+	require.Nil(t, err)
+	for _, topic := range []string{"stale-topic", "fresh-topic"} {
+		m := newDefaultMessage(topic, "old message")
+		m.Time = time.Now().Add(-40 * 24 * time.Hour).Unix()
+		m.Expires = time.Now().Add(time.Hour).Unix()
+		require.Nil(t, s.messageCache.AddMessage(m))
+	}
+
+	// Recently renewed web push subscriptions keep the topic active
+	require.Nil(t, s.pruneInactiveTopicsInternal())
+	s.pruneMessages()
+	requireSubscriptionCount(t, s, "stale-topic", 0)
+	requireSubscriptionCount(t, s, "fresh-topic", 1)
+	counts, err := s.messageCache.MessageCounts()
+	require.Nil(t, err)
+	require.Equal(t, 0, counts["stale-topic"])
+	require.Equal(t, 1, counts["fresh-topic"])
+}
+
+func TestServer_Manager_CacheMaxMessagesPerTopic(t *testing.T) {
+	c := newTestConfig(t)
+	c.CacheMaxMessagesPerTopic = 2
+	s := newTestServer(t, c)
+
+	for i := 0; i < 4; i++ {
+		rr := request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), nil)
+		require.Equal(t, 200, rr.Code)
+	}
+	rr := request(t, s, "PUT", "/anothertopic", "another message", nil)
+	require.Equal(t, 200, rr.Code)
+
+	s.pruneCacheLimits()
+	s.pruneMessages()
+
+	messages, err := s.messageCache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "message 2", messages[0].Message)
+	require.Equal(t, "message 3", messages[1].Message)
+
+	counts, err := s.messageCache.MessageCounts()
+	require.Nil(t, err)
+	require.Equal(t, 1, counts["anothertopic"])
+}
+
+func TestServer_Manager_CacheMaxSize(t *testing.T) {
+	c := newTestConfig(t)
+	c.CacheMaxSize = 200 * 1024
+	c.CacheVacuumInterval = time.Hour
+	s := newTestServer(t, c)
+
+	for i := 0; i < 500; i++ {
+		m := newDefaultMessage("mytopic", strings.Repeat("x", 1000))
+		m.Time = time.Now().Unix() - int64(500-i)
+		m.Expires = time.Now().Add(time.Hour).Unix()
+		require.Nil(t, s.messageCache.AddMessage(m))
+	}
+	used, err := s.messageCache.MessagesSize()
+	require.Nil(t, err)
+	require.Greater(t, used, c.CacheMaxSize)
+	size, _, err := s.messageCache.Size()
+	require.Nil(t, err)
+
+	// The number of messages to expire is estimated, so it may take more than one run
+	for i := 0; i < 5 && used > c.CacheMaxSize; i++ {
+		s.pruneCacheLimits()
+		s.pruneMessages()
+		used, err = s.messageCache.MessagesSize()
+		require.Nil(t, err)
+	}
+	require.LessOrEqual(t, used, c.CacheMaxSize)
+	count, err := s.messageCache.MessagesCount()
+	require.Nil(t, err)
+	require.Greater(t, count, int64(0))
+	require.Less(t, count, int64(500))
+
+	// Vacuum only runs once the interval has passed
+	s.vacuumCache()
+	size2, _, err := s.messageCache.Size()
+	require.Nil(t, err)
+	require.Equal(t, size, size2)
+
+	s.cacheVacuumed = time.Now().Add(-2 * time.Hour)
+	s.vacuumCache()
+	size2, free, err := s.messageCache.Size()
+	require.Nil(t, err)
+	require.Less(t, size2, size)
+	require.Equal(t, int64(0), free)
+}
+
+func requireReservationCount(t *testing.T, s *Server, username string, expected int64) {
+	count, err := s.userManager.ReservationsCount(username)
+	require.Nil(t, err)
+	require.Equal(t, expected, count)
+}
@@ -7,7 +7,9 @@ import (
 	"heckel.io/ntfy/v2/util"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -61,6 +63,7 @@ import (
 type matrixRequest struct {
 	Notification *struct {
 		Devices []*struct {
+			AppID   string `json:"app_id"`
 			PushKey string `json:"pushkey"`
 		} `json:"devices"`
 	} `json:"notification"`
@@ -78,6 +81,22 @@ const (
 	// the topic. Rejecting the push key will instruct the Matrix server to invalidate the pushkey and stop sending
 	// messages to it. This must be longer than topicExpungeAfter. See https://spec.matrix.org/v1.6/push-gateway-api/
 	matrixRejectPushKeyForUnifiedPushTopicWithoutRateVisitorAfter = 12 * time.Hour
+
+	// matrixStatsPushKeysMax is the max number of push keys for which delivery stats are kept. If the limit
+	// is reached, the push key that was least recently seen is forgotten.
+	matrixStatsPushKeysMax = 10000
+
+	// matrixStatsPushKeyFailuresMax is the max number of recent rejection/failure reasons kept per push key
+	matrixStatsPushKeyFailuresMax = 10
+
+	// matrixStatsFailuresMax is the max number of recent rejection/failure reasons kept across all push keys
+	matrixStatsFailuresMax = 100
+)
+
+// Outcomes of a Matrix gateway request, as recorded in matrixStats
+const (
+	matrixOutcomeRejected = "rejected"
+	matrixOutcomeFailed   = "failed"
 )
 
 // errMatrixPushkeyRejected represents an error when handing Matrix gateway messages
@@ -86,6 +105,7 @@ const (
 // push key again, until the user repairs it.
 type errMatrixPushkeyRejected struct {
 	rejectedPushKey   string
+	appID             string
 	configuredBaseURL string
 }
 
@@ -123,9 +143,9 @@ func newRequestFromMatrixJSON(r *http.Request, baseURL string, messageLimit int)
 	} else if m.Notification == nil || len(m.Notification.Devices) == 0 || m.Notification.Devices[0].PushKey == "" {
 		return nil, errHTTPBadRequestMatrixMessageInvalid
 	}
-	pushKey := m.Notification.Devices[0].PushKey // We ignore other devices for now, see discussion in #316
+	pushKey, appID := m.Notification.Devices[0].PushKey, m.Notification.Devices[0].AppID // We ignore other devices for now, see discussion in #316
 	if !strings.HasPrefix(pushKey, baseURL+"/") {
-		return nil, &errMatrixPushkeyRejected{rejectedPushKey: pushKey, configuredBaseURL: baseURL}
+		return nil, &errMatrixPushkeyRejected{rejectedPushKey: pushKey, appID: appID, configuredBaseURL: baseURL}
 	}
 	newRequest, err := http.NewRequest(http.MethodPost, pushKey, io.NopCloser(bytes.NewReader(body.PeekedBytes)))
 	if err != nil {
@@ -137,6 +157,7 @@ func newRequestFromMatrixJSON(r *http.Request, baseURL string, messageLimit int)
 	}
 	newRequest = withContext(newRequest, map[contextKey]any{
 		contextMatrixPushKey: pushKey,
+		contextMatrixAppID:   appID,
 	})
 	return newRequest, nil
 }
@@ -170,3 +191,179 @@ func writeMatrixResponse(w http.ResponseWriter, rejectedPushKey string) error {
 	}
 	return nil
 }
+
+// matrixFailureReason returns a human-readable reason for a failed Matrix gateway request, as it is
+// shown in the matrixStats failure log
+func matrixFailureReason(err error) string {
+	if e, ok := err.(*errHTTP); ok {
+		return fmt.Sprintf("HTTP %d: %s (error code %d)", e.HTTPCode, e.Message, e.Code)
+	}
+	return err.Error()
+}
+
+// matrixStats keeps in-memory delivery counters for the Matrix gateway, per push key and per app (the "app_id"
+// of the Matrix device), as well as a log of recent rejection and failure reasons. It is used to debug
+// UnifiedPush-for-Matrix setups, see handleMatrixPushKeyStats and handleMatrixStats.
+//
+// Outcomes are counted as follows:
+//   - delivered: the message was published to the topic
+//   - rejected: the push key was returned in the "rejected" list, which makes the homeserver drop the push key
+//   - failed: the homeserver received an HTTP error (e.g. rate limited, or not authorized), and will retry
+type matrixStats struct {
+	pushKeys map[string]*matrixPushKeyStats
+	apps     map[string]*matrixAppStats
+	failures []*apiMatrixFailure // Most recent last
+	mu       sync.Mutex
+}
+
+type matrixPushKeyStats struct {
+	appID         string
+	delivered     int64
+	rejected      int64
+	failed        int64
+	lastSeen      time.Time
+	lastDelivered time.Time
+	failures      []*apiMatrixFailure // Most recent last
+}
+
+type matrixAppStats struct {
+	delivered int64
+	rejected  int64
+	failed    int64
+}
+
+func newMatrixStats() *matrixStats {
+	return &matrixStats{
+		pushKeys: make(map[string]*matrixPushKeyStats),
+		apps:     make(map[string]*matrixAppStats),
+		failures: make([]*apiMatrixFailure, 0),
+	}
+}
+
+// Delivered records a successful delivery for the given push key
+func (s *matrixStats) Delivered(pushKey, appID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, a := s.statsFor(pushKey, appID)
+	p.delivered++
+	p.lastDelivered = p.lastSeen
+	a.delivered++
+}
+
+// Rejected records that the given push key was rejected, i.e. returned in the "rejected" list
+func (s *matrixStats) Rejected(pushKey, appID, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, a := s.statsFor(pushKey, appID)
+	p.rejected++
+	a.rejected++
+	s.addFailure(p, pushKey, appID, matrixOutcomeRejected, reason)
+}
+
+// Failed records that the homeserver received an HTTP error for the given push key
+func (s *matrixStats) Failed(pushKey, appID, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, a := s.statsFor(pushKey, appID)
+	p.failed++
+	a.failed++
+	s.addFailure(p, pushKey, appID, matrixOutcomeFailed, reason)
+}
+
+// PushKey returns the stats for the given push key, or nil if the push key has not been seen
+func (s *matrixStats) PushKey(pushKey string) *apiMatrixPushKeyResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pushKeys[pushKey]
+	if !ok {
+		return nil
+	}
+	response := &apiMatrixPushKeyResponse{
+		PushKey:   pushKey,
+		AppID:     p.appID,
+		Delivered: p.delivered,
+		Rejected:  p.rejected,
+		Failed:    p.failed,
+		LastSeen:  p.lastSeen.Unix(),
+		Failures:  append(make([]*apiMatrixFailure, 0), p.failures...),
+	}
+	if !p.lastDelivered.IsZero() {
+		response.LastDelivered = p.lastDelivered.Unix()
+	}
+	return response
+}
+
+// Stats returns the per-app counters and the most recent rejection/failure reasons across all push keys
+func (s *matrixStats) Stats() *apiMatrixStatsResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	apps := make([]*apiMatrixAppStats, 0, len(s.apps))
+	for appID, a := range s.apps {
+		apps = append(apps, &apiMatrixAppStats{
+			AppID:     appID,
+			Delivered: a.delivered,
+			Rejected:  a.rejected,
+			Failed:    a.failed,
+		})
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].AppID < apps[j].AppID
+	})
+	return &apiMatrixStatsResponse{
+		PushKeys: len(s.pushKeys),
+		Apps:     apps,
+		Failures: append(make([]*apiMatrixFailure, 0), s.failures...),
+	}
+}
+
+func (s *matrixStats) statsFor(pushKey, appID string) (*matrixPushKeyStats, *matrixAppStats) {
+	p, ok := s.pushKeys[pushKey]
+	if !ok {
+		if len(s.pushKeys) >= matrixStatsPushKeysMax {
+			s.forgetLeastRecentlySeen()
+		}
+		p = &matrixPushKeyStats{
+			failures: make([]*apiMatrixFailure, 0),
+		}
+		s.pushKeys[pushKey] = p
+	}
+	p.lastSeen = time.Now()
+	if appID != "" {
+		p.appID = appID
+	}
+	a, ok := s.apps[p.appID]
+	if !ok {
+		a = &matrixAppStats{}
+		s.apps[p.appID] = a
+	}
+	return p, a
+}
+
+func (s *matrixStats) addFailure(p *matrixPushKeyStats, pushKey, appID, outcome, reason string) {
+	failure := &apiMatrixFailure{
+		Time:    p.lastSeen.Unix(),
+		PushKey: pushKey,
+		AppID:   appID,
+		Outcome: outcome,
+		Reason:  reason,
+	}
+	p.failures = append(p.failures, failure)
+	if len(p.failures) > matrixStatsPushKeyFailuresMax {
+		p.failures = p.failures[len(p.failures)-matrixStatsPushKeyFailuresMax:]
+	}
+	s.failures = append(s.failures, failure)
+	if len(s.failures) > matrixStatsFailuresMax {
+		s.failures = s.failures[len(s.failures)-matrixStatsFailuresMax:]
+	}
+}
+
+func (s *matrixStats) forgetLeastRecentlySeen() {
+	var oldestPushKey string
+	var oldest time.Time
+	for pushKey, p := range s.pushKeys {
+		if oldestPushKey == "" || p.lastSeen.Before(oldest) {
+			oldestPushKey, oldest = pushKey, p.lastSeen
+		}
+	}
+	delete(s.pushKeys, oldestPushKey)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -80,3 +81,52 @@ func TestMatrix_WriteMatrixSuccess(t *testing.T) {
 	require.Equal(t, 200, w.Result().StatusCode)
 	require.Equal(t, `{"rejected":[]}`+"\n", w.Body.String())
 }
+
+func TestMatrix_Stats(t *testing.T) {
+	s := newMatrixStats()
+	s.Delivered("https://ntfy.sh/upABC?up=1", "im.vector.app.android")
+	s.Delivered("https://ntfy.sh/upABC?up=1", "im.vector.app.android")
+	s.Failed("https://ntfy.sh/upABC?up=1", "im.vector.app.android", "HTTP 429: limit reached")
+	s.Rejected("https://example.com/upDEF?up=1", "", "push key must be prefixed with base URL")
+
+	p := s.PushKey("https://ntfy.sh/upABC?up=1")
+	require.Equal(t, "im.vector.app.android", p.AppID)
+	require.Equal(t, int64(2), p.Delivered)
+	require.Equal(t, int64(0), p.Rejected)
+	require.Equal(t, int64(1), p.Failed)
+	require.True(t, p.LastDelivered > 0)
+	require.Equal(t, 1, len(p.Failures))
+	require.Equal(t, "failed", p.Failures[0].Outcome)
+	require.Equal(t, "HTTP 429: limit reached", p.Failures[0].Reason)
+	require.Nil(t, s.PushKey("https://ntfy.sh/doesnotexist"))
+
+	stats := s.Stats()
+	require.Equal(t, 2, stats.PushKeys)
+	require.Equal(t, 2, len(stats.Apps))
+	require.Equal(t, "", stats.Apps[0].AppID)
+	require.Equal(t, int64(1), stats.Apps[0].Rejected)
+	require.Equal(t, "im.vector.app.android", stats.Apps[1].AppID)
+	require.Equal(t, int64(2), stats.Apps[1].Delivered)
+	require.Equal(t, int64(1), stats.Apps[1].Failed)
+	require.Equal(t, 2, len(stats.Failures))
+	require.Equal(t, "rejected", stats.Failures[1].Outcome)
+}
+
+func TestMatrix_Stats_Limits(t *testing.T) {
+	s := newMatrixStats()
+	for i := 0; i < matrixStatsFailuresMax+5; i++ {
+		s.Failed("https://ntfy.sh/upABC?up=1", "", fmt.Sprintf("failure %d", i))
+	}
+	p := s.PushKey("https://ntfy.sh/upABC?up=1")
+	require.Equal(t, int64(matrixStatsFailuresMax+5), p.Failed)
+	require.Equal(t, matrixStatsPushKeyFailuresMax, len(p.Failures))
+	require.Equal(t, fmt.Sprintf("failure %d", matrixStatsFailuresMax+4), p.Failures[matrixStatsPushKeyFailuresMax-1].Reason)
+	require.Equal(t, matrixStatsFailuresMax, len(s.Stats().Failures))
+
+	for i := 0; i < matrixStatsPushKeysMax; i++ {
+		s.Delivered(fmt.Sprintf("https://ntfy.sh/up%d?up=1", i), "")
+	}
+	require.Equal(t, matrixStatsPushKeysMax, s.Stats().PushKeys)
+	require.Nil(t, s.PushKey("https://ntfy.sh/upABC?up=1")) // Least recently seen
+	require.NotNil(t, s.PushKey("https://ntfy.sh/up0?up=1"))
+}
@@ -1,31 +1,47 @@
 package server
 
 import (
+	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
+	"time"
 )
 
 var (
 	metricMessagesPublishedSuccess     prometheus.Counter
 	metricMessagesPublishedFailure     prometheus.Counter
 	metricMessagesCached               prometheus.Gauge
+	metricCacheSizeBytes               prometheus.Gauge
+	metricCacheFreeBytes               prometheus.Gauge
 	metricMessagePublishDurationMillis prometheus.Gauge
 	metricFirebasePublishedSuccess     prometheus.Counter
 	metricFirebasePublishedFailure     prometheus.Counter
+	metricFirebaseQueueDropped         prometheus.Counter
+	metricFirebaseQueueDepth           prometheus.Gauge
+	metricFirebaseQueueLatencyMillis   prometheus.Gauge
 	metricEmailsPublishedSuccess       prometheus.Counter
 	metricEmailsPublishedFailure       prometheus.Counter
 	metricEmailsReceivedSuccess        prometheus.Counter
 	metricEmailsReceivedFailure        prometheus.Counter
 	metricCallsMadeSuccess             prometheus.Counter
 	metricCallsMadeFailure             prometheus.Counter
+	metricWebPushPublishedSuccess      prometheus.Counter
+	metricWebPushPublishedFailure      prometheus.Counter
+	metricWebPushSendDurationMillis    prometheus.Gauge
 	metricUnifiedPushPublishedSuccess  prometheus.Counter
 	metricMatrixPublishedSuccess       prometheus.Counter
 	metricMatrixPublishedFailure       prometheus.Counter
 	metricAttachmentsTotalSize         prometheus.Gauge
 	metricVisitors                     prometheus.Gauge
 	metricSubscribers                  prometheus.Gauge
+	metricSubscriberBacklog            prometheus.Gauge
+	metricSubscribersSlowDropped       prometheus.Counter
+	metricSubscribersSlowDowngraded    prometheus.Counter
 	metricTopics                       prometheus.Gauge
 	metricUsers                        prometheus.Gauge
 	metricHTTPRequests                 *prometheus.CounterVec
+	metricHTTPRequestDurationSeconds   *prometheus.HistogramVec
+	metricHTTPRequestSizeBytes         *prometheus.HistogramVec
+	metricHTTPResponseSizeBytes        *prometheus.HistogramVec
 )
 
 func initMetrics() {
@@ -38,6 +54,12 @@ func initMetrics() {
 	metricMessagesCached = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "ntfy_messages_cached_total",
 	})
+	metricCacheSizeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ntfy_cache_size_bytes",
+	})
+	metricCacheFreeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ntfy_cache_free_bytes",
+	})
 	metricMessagePublishDurationMillis = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "ntfy_message_publish_duration_ms",
 	})
@@ -47,6 +69,15 @@ func initMetrics() {
 	metricFirebasePublishedFailure = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_firebase_published_failure",
 	})
+	metricFirebaseQueueDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_firebase_queue_dropped",
+	})
+	metricFirebaseQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ntfy_firebase_queue_depth",
+	})
+	metricFirebaseQueueLatencyMillis = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ntfy_firebase_queue_latency_ms",
+	})
 	metricEmailsPublishedSuccess = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_emails_sent_success",
 	})
@@ -65,6 +96,15 @@ func initMetrics() {
 	metricCallsMadeFailure = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_calls_made_failure",
 	})
+	metricWebPushPublishedSuccess = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_webpush_published_success",
+	})
+	metricWebPushPublishedFailure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_webpush_published_failure",
+	})
+	metricWebPushSendDurationMillis = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ntfy_webpush_send_duration_ms",
+	})
 	metricUnifiedPushPublishedSuccess = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_unifiedpush_published_success",
 	})
@@ -86,25 +126,54 @@ func initMetrics() {
 	metricSubscribers = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "ntfy_subscribers_total",
 	})
+	metricSubscriberBacklog = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ntfy_subscriber_backlog",
+	})
+	metricSubscribersSlowDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_subscribers_slow_dropped",
+	})
+	metricSubscribersSlowDowngraded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_subscribers_slow_downgraded",
+	})
 	metricTopics = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "ntfy_topics_total",
 	})
 	metricHTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "ntfy_http_requests_total",
 	}, []string{"http_code", "ntfy_code", "http_method"})
+	metricHTTPRequestDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ntfy_http_request_duration_seconds",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "status_class"})
+	metricHTTPRequestSizeBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ntfy_http_request_size_bytes",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10), // 64 bytes to 16 MB
+	}, []string{"route", "status_class"})
+	metricHTTPResponseSizeBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ntfy_http_response_size_bytes",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10), // 64 bytes to 16 MB
+	}, []string{"route", "status_class"})
 	prometheus.MustRegister(
 		metricMessagesPublishedSuccess,
 		metricMessagesPublishedFailure,
 		metricMessagesCached,
+		metricCacheSizeBytes,
+		metricCacheFreeBytes,
 		metricMessagePublishDurationMillis,
 		metricFirebasePublishedSuccess,
 		metricFirebasePublishedFailure,
+		metricFirebaseQueueDropped,
+		metricFirebaseQueueDepth,
+		metricFirebaseQueueLatencyMillis,
 		metricEmailsPublishedSuccess,
 		metricEmailsPublishedFailure,
 		metricEmailsReceivedSuccess,
 		metricEmailsReceivedFailure,
 		metricCallsMadeSuccess,
 		metricCallsMadeFailure,
+		metricWebPushPublishedSuccess,
+		metricWebPushPublishedFailure,
+		metricWebPushSendDurationMillis,
 		metricUnifiedPushPublishedSuccess,
 		metricMatrixPublishedSuccess,
 		metricMatrixPublishedFailure,
@@ -112,8 +181,14 @@ func initMetrics() {
 		metricVisitors,
 		metricUsers,
 		metricSubscribers,
+		metricSubscriberBacklog,
+		metricSubscribersSlowDropped,
+		metricSubscribersSlowDowngraded,
 		metricTopics,
 		metricHTTPRequests,
+		metricHTTPRequestDurationSeconds,
+		metricHTTPRequestSizeBytes,
+		metricHTTPResponseSizeBytes,
 	)
 }
 
@@ -130,3 +205,27 @@ func mset[T int | int64 | float64](gauge prometheus.Gauge, value T) {
 		gauge.Set(float64(value))
 	}
 }
+
+// madd adds the given value to a prometheus.Gauge if it is non-nil
+func madd(gauge prometheus.Gauge, value float64) {
+	if gauge != nil {
+		gauge.Add(value)
+	}
+}
+
+// observeHTTPRequest records the duration, request size and response size of a finished request. For
+// subscriptions, the duration is measured until the first byte was written (i.e. the open message was
+// sent, or the WebSocket connection was upgraded), since the connection itself is long-lived.
+func observeHTTPRequest(route string, started time.Time, w *trackingResponseWriter, body *trackingRequestBody) {
+	status, size, firstByte, hijacked := w.stats()
+	statusClass := fmt.Sprintf("%dxx", status/100)
+	duration := time.Since(started)
+	if route == routeSubscribe && !firstByte.IsZero() {
+		duration = firstByte.Sub(started)
+	}
+	metricHTTPRequestDurationSeconds.WithLabelValues(route, statusClass).Observe(duration.Seconds())
+	metricHTTPRequestSizeBytes.WithLabelValues(route, statusClass).Observe(float64(body.size))
+	if !hijacked {
+		metricHTTPResponseSizeBytes.WithLabelValues(route, statusClass).Observe(float64(size))
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+var initTestMetricsOnce sync.Once
+
+func TestServer_Metrics_HTTPRequestHistograms(t *testing.T) {
+	initTestMetricsOnce.Do(initMetrics)
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "some message", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/v1/account", "", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/v1/account/webpush", "", nil) // Web push not enabled
+	require.Equal(t, 404, response.Code)
+
+	subscribeRR := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/json", subscribeRR)
+	subscribeCancel()
+	require.Contains(t, subscribeRR.Body.String(), `"event":"open"`)
+
+	metricsRR := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(metricsRR, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := metricsRR.Body.String()
+	for _, route := range []string{"publish", "poll", "account", "subscribe"} {
+		require.Contains(t, metrics, `ntfy_http_request_duration_seconds_count{route="`+route+`",status_class="2xx"}`)
+		require.Contains(t, metrics, `ntfy_http_request_size_bytes_count{route="`+route+`",status_class="2xx"}`)
+		require.Contains(t, metrics, `ntfy_http_response_size_bytes_count{route="`+route+`",status_class="2xx"}`)
+	}
+	require.Contains(t, metrics, `ntfy_http_request_duration_seconds_count{route="webpush",status_class="4xx"}`)
+}
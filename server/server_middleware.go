@@ -1,9 +1,9 @@
 package server
 
 import (
+	"crypto/subtle"
 	"net/http"
-
-	"heckel.io/ntfy/v2/util"
+	"strings"
 )
 
 type contextKey int
@@ -12,11 +12,12 @@ const (
 	contextRateVisitor contextKey = iota + 2586
 	contextTopic
 	contextMatrixPushKey
+	contextMatrixAppID
 )
 
 func (s *Server) limitRequests(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		if util.ContainsIP(s.config.VisitorRequestExemptIPAddrs, v.ip) {
+		if s.requestLimitExempt(r, v) {
 			return next(w, r, v)
 		} else if !v.RequestAllowed() {
 			return errHTTPTooManyRequestsLimitRequests
@@ -40,7 +41,7 @@ func (s *Server) limitRequestsWithTopic(next handleFunc) handleFunc {
 			contextRateVisitor: vrate,
 			contextTopic:       t,
 		})
-		if util.ContainsIP(s.config.VisitorRequestExemptIPAddrs, v.ip) {
+		if s.requestLimitExempt(r, v) {
 			return next(w, r, v)
 		} else if !vrate.RequestAllowed() {
 			return errHTTPTooManyRequestsLimitRequests
@@ -94,6 +95,25 @@ func (s *Server) ensureAdmin(next handleFunc) handleFunc {
 	})
 }
 
+func (s *Server) ensureSCIM(next handleFunc) handleFunc {
+	return s.ensureUserManager(func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.config.SCIMToken == "" {
+			return errHTTPNotFound
+		}
+		header := strings.TrimSpace(r.Header.Get("Authorization"))
+		if !strings.HasPrefix(strings.ToLower(header), "bearer ") {
+			s.authFailed(r, v, "", errAuthSCIMTokenInvalid)
+			return errHTTPUnauthorized
+		}
+		token := strings.TrimSpace(header[len("bearer "):])
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.SCIMToken)) != 1 {
+			s.authFailed(r, v, "", errAuthSCIMTokenInvalid)
+			return errHTTPUnauthorized
+		}
+		return next(w, r, v)
+	})
+}
+
 func (s *Server) ensureCallsEnabled(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
 		if s.config.TwilioAccount == "" || s.userManager == nil {
@@ -105,7 +125,16 @@ func (s *Server) ensureCallsEnabled(next handleFunc) handleFunc {
 
 func (s *Server) ensurePaymentsEnabled(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		if s.config.StripeSecretKey == "" || s.stripe == nil {
+		if s.payments == nil {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	}
+}
+
+func (s *Server) ensureEmailWebhookEnabled(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.smtpSender == nil || s.config.SMTPSenderWebhookKey == "" {
 			return errHTTPNotFound
 		}
 		return next(w, r, v)
@@ -0,0 +1,468 @@
+package server
+
+import (
+	"heckel.io/ntfy/v2/user"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// openAPIVersion is the version of the OpenAPI specification that is generated by openAPISpec
+const openAPIVersion = "3.0.3"
+
+// Authentication requirements of an API operation, see openAPIOperation
+const (
+	openAPIAuthNone     = iota // No authentication possible
+	openAPIAuthOptional        // Anonymous access may be allowed, depending on the access control settings
+	openAPIAuthUser            // Requires a user (basic auth or access token)
+	openAPIAuthAdmin           // Requires an admin user
+	openAPIAuthSCIM            // Requires the SCIM bearer token (scim-token)
+)
+
+// openAPIOperation describes a single API endpoint. The list of all endpoints (openAPIOperations) is the source
+// of the OpenAPI specification served at /v1/openapi.json. Request and response schemas are derived from the Go
+// types via reflection, so they cannot get out of sync with the handlers. When adding an endpoint to handleInternal,
+// it must be added here as well (see TestOpenAPI_AllPathsDocumented).
+type openAPIOperation struct {
+	Method       string
+	Path         string // OpenAPI path template, e.g. /{topic}/json
+	Tag          string
+	Summary      string
+	Auth         int
+	Params       []*openAPIParam
+	Request      any    // Value of the request body type, or nil if there is no body
+	RequestType  string // Content type of the request body, defaults to application/json
+	Response     any    // Value of the response body type, or nil if there is no (JSON) body
+	ResponseType string // Content type of the response, defaults to application/json
+}
+
+type openAPIParam struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Schema      any    `json:"schema"`
+}
+
+var (
+	openAPIParamsTopic = []*openAPIParam{
+		openAPIPathParam("topic", "Topic name"),
+	}
+	openAPIParamsTopics = []*openAPIParam{
+		openAPIPathParam("topics", "Topic name, or comma-separated list of topic names"),
+		openAPIQueryParam("poll", "Return cached messages and close the connection", "boolean"),
+		openAPIQueryParam("since", "Return cached messages since a Unix timestamp, a duration (e.g. 10m), a message ID, \"all\" or \"none\"", "string"),
+		openAPIQueryParam("scheduled", "Include scheduled/delayed messages", "boolean"),
+		openAPIQueryParam("id", "Only return messages with this ID", "string"),
+		openAPIQueryParam("message", "Only return messages with this message body", "string"),
+		openAPIQueryParam("title", "Only return messages with this title", "string"),
+		openAPIQueryParam("priority", "Only return messages with one of these priorities (comma-separated)", "string"),
+		openAPIQueryParam("tags", "Only return messages that have all of these tags (comma-separated)", "string"),
+		openAPIQueryParam("presence", "Announce the subscriber with this label, see /{topic}/presence", "string"),
+		openAPIQueryParam("keepalive", "Interval of keepalive messages (e.g. 15s, 5m), capped by the server", "string"),
+		openAPIQueryParam("retry", "SSE only: Reconnection time suggested to the client via the retry field (e.g. 10s), capped by the server", "string"),
+	}
+	openAPIParamsExport = []*openAPIParam{
+		openAPIPathParam("topic", "Topic name"),
+		openAPIQueryParam("format", "Export format, csv or ndjson (default)", "string"),
+		openAPIQueryParam("since", "Only export messages since a Unix timestamp, a duration (e.g. 24h), or a message ID", "string"),
+		openAPIQueryParam("scheduled", "Include scheduled/delayed messages", "boolean"),
+		openAPIQueryParam("priority", "Only export messages with one of these priorities (comma-separated)", "string"),
+		openAPIQueryParam("tags", "Only export messages that have all of these tags (comma-separated)", "string"),
+	}
+	openAPIParamsMessage = []*openAPIParam{
+		openAPIPathParam("topic", "Topic name"),
+		openAPIPathParam("id", "Message ID"),
+	}
+	openAPIParamsAnnotation = []*openAPIParam{
+		openAPIPathParam("topic", "Topic name"),
+		openAPIPathParam("id", "Message ID"),
+		openAPIPathParam("annotation", "Annotation ID"),
+	}
+	openAPIParamsReaction = []*openAPIParam{
+		openAPIPathParam("topic", "Topic name"),
+		openAPIPathParam("id", "Message ID"),
+		openAPIPathParam("emoji", "Emoji (e.g. 👍) or emoji short code (e.g. +1)"),
+	}
+	openAPIParamsPublish = []*openAPIParam{
+		openAPIPathParam("topic", "Topic name"),
+		openAPIHeaderParam("X-Title", "Message title", "string"),
+		openAPIHeaderParam("X-Priority", "Message priority, 1-5 or min, low, default, high, urgent/max", "string"),
+		openAPIHeaderParam("X-Tags", "Comma-separated list of tags and emojis", "string"),
+		openAPIHeaderParam("X-Delay", "Delivery time, as Unix timestamp, duration (e.g. 30m) or natural language (e.g. tomorrow, 10am)", "string"),
+		openAPIHeaderParam("X-Click", "URL to open when the notification is clicked", "string"),
+		openAPIHeaderParam("X-Icon", "URL of the notification icon", "string"),
+		openAPIHeaderParam("X-Actions", "Action buttons, as JSON array or short format", "string"),
+		openAPIHeaderParam("X-Template", "Name of a stored message template, or \"yes\" to use X-Title and X-Message as templates; the body is the JSON data", "string"),
+		openAPIHeaderParam("X-Attach", "URL of an external attachment", "string"),
+		openAPIHeaderParam("X-Attachment-ID", "ID of a separately uploaded attachment, see PUT /v1/attachments", "string"),
+		openAPIHeaderParam("X-Filename", "File name of the attachment", "string"),
+		openAPIHeaderParam("X-Email", "E-mail address to forward the message to", "string"),
+		openAPIHeaderParam("X-Call", "Phone number to call, or \"yes\" to call the first verified number", "string"),
+		openAPIHeaderParam("X-Markdown", "Render the message as Markdown", "boolean"),
+		openAPIHeaderParam("X-Sound", "Name of the notification sound", "string"),
+		openAPIHeaderParam("X-In-Reply-To", "ID of the message this message is a reply to", "string"),
+		openAPIHeaderParam("X-Location", "Geo location the message refers to, as latitude,longitude (e.g. 52.52,13.405)", "string"),
+		openAPIHeaderParam("X-Hostname", "Host the message originates from", "string"),
+		openAPIHeaderParam("X-Correlation-ID", "ID that relates the message to other messages, e.g. an incident ID", "string"),
+		openAPIHeaderParam("X-Cache", "Set to \"no\" to not cache the message", "string"),
+		openAPIHeaderParam("X-Firebase", "Set to \"no\" to not forward the message to Firebase", "string"),
+		openAPIHeaderParam("X-UnifiedPush", "Set to \"1\" for UnifiedPush messages", "string"),
+	}
+	openAPIParamsGroup = []*openAPIParam{
+		openAPIQueryParam("name", "Group name", "string"),
+	}
+	openAPIParamsUsersChanges = []*openAPIParam{
+		openAPIQueryParam("since", "Position in the user change log, as returned by the previous call (0 = all users)", "integer"),
+	}
+)
+
+// openAPIOperations lists all API endpoints that are documented in the OpenAPI specification
+var openAPIOperations = []*openAPIOperation{
+	// Publish
+	{Method: http.MethodPut, Path: "/{topic}", Tag: "publish", Summary: "Publish a message, or upload a file as attachment", Auth: openAPIAuthOptional, Params: openAPIParamsPublish, Request: "", RequestType: "text/plain", Response: &message{}},
+	{Method: http.MethodPost, Path: "/{topic}", Tag: "publish", Summary: "Publish a message, or upload a file as attachment", Auth: openAPIAuthOptional, Params: openAPIParamsPublish, Request: "", RequestType: "text/plain", Response: &message{}},
+	{Method: http.MethodGet, Path: "/{topic}/publish", Tag: "publish", Summary: "Publish a message via GET (aliases: /{topic}/send, /{topic}/trigger)", Auth: openAPIAuthOptional, Params: append(openAPIParamsPublish, openAPIQueryParam("message", "Message body", "string"), openAPIQueryParam("expires", "Expiry of a signed publish URL (Unix timestamp)", "integer"), openAPIQueryParam("sig", "Signature of a signed publish URL", "string")), Response: &message{}},
+	{Method: http.MethodPost, Path: "/{topic}/sign", Tag: "publish", Summary: "Create a signed URL that publishes a fixed message via GET", Auth: openAPIAuthOptional, Params: openAPIParamsTopic, Request: &apiPublishURLRequest{}, Response: &apiPublishURLResponse{}},
+	{Method: http.MethodPost, Path: "/", Tag: "publish", Summary: "Publish a message as JSON", Auth: openAPIAuthOptional, Request: &publishMessage{}, Response: &message{}},
+	{Method: http.MethodPost, Path: apiPublishValidatePath, Tag: "publish", Summary: "Validate a message without publishing it (JSON like POST /, or headers with X-Topic)", Auth: openAPIAuthOptional, Params: []*openAPIParam{openAPIHeaderParam("X-Topic", "Topic name, if the message is passed via headers instead of JSON", "string")}, Request: &publishMessage{}, Response: &apiPublishValidateResponse{}},
+	{Method: http.MethodPut, Path: apiAttachmentsPath, Tag: "publish", Summary: "Upload an attachment without publishing a message (request body is the file)", Auth: openAPIAuthOptional, Params: []*openAPIParam{openAPIHeaderParam("X-Filename", "File name of the attachment", "string")}, Request: "", RequestType: "application/octet-stream", Response: &apiAttachmentUploadResponse{}},
+	{Method: http.MethodGet, Path: "/{topic}/{id}/deliveries", Tag: "publish", Summary: "Delivery attempts of a message (publisher only)", Auth: openAPIAuthOptional, Params: []*openAPIParam{openAPIPathParam("topic", "Topic name"), openAPIPathParam("id", "Message ID")}, Response: &apiMessageDeliveriesResponse{}},
+	{Method: http.MethodGet, Path: "/{topic}/{id}/reactions", Tag: "publish", Summary: "Reactions to a message", Auth: openAPIAuthOptional, Params: openAPIParamsMessage, Response: &apiMessageReactionsResponse{}},
+	{Method: http.MethodPut, Path: "/{topic}/{id}/reactions/{emoji}", Tag: "publish", Summary: "React to a message", Auth: openAPIAuthOptional, Params: openAPIParamsReaction, Response: &apiMessageReactionsResponse{}},
+	{Method: http.MethodDelete, Path: "/{topic}/{id}/reactions/{emoji}", Tag: "publish", Summary: "Remove a reaction to a message", Auth: openAPIAuthOptional, Params: openAPIParamsReaction, Response: &apiMessageReactionsResponse{}},
+	{Method: http.MethodGet, Path: "/{topic}/{id}/annotations", Tag: "publish", Summary: "Annotations of a message", Auth: openAPIAuthOptional, Params: openAPIParamsMessage, Response: &apiMessageAnnotationsResponse{}},
+	{Method: http.MethodPost, Path: "/{topic}/{id}/annotations", Tag: "publish", Summary: "Annotate a message (request body is the annotation text)", Auth: openAPIAuthOptional, Params: openAPIParamsMessage, Request: "", RequestType: "text/plain", Response: &apiMessageAnnotationsResponse{}},
+	{Method: http.MethodDelete, Path: "/{topic}/{id}/annotations/{annotation}", Tag: "publish", Summary: "Remove your annotation of a message", Auth: openAPIAuthOptional, Params: openAPIParamsAnnotation, Response: &apiMessageAnnotationsResponse{}},
+	{Method: http.MethodGet, Path: "/{topic}/maintenance", Tag: "publish", Summary: "Maintenance windows of a topic", Auth: openAPIAuthOptional, Params: openAPIParamsTopic, Response: &apiMaintenanceWindowsResponse{}},
+	{Method: http.MethodPost, Path: "/{topic}/maintenance", Tag: "publish", Summary: "Add a maintenance window that suppresses, downgrades or tags messages of a topic", Auth: openAPIAuthOptional, Params: openAPIParamsTopic, Request: &apiMaintenanceWindow{}, Response: &apiMaintenanceWindow{}},
+	{Method: http.MethodDelete, Path: "/{topic}/maintenance/{id}", Tag: "publish", Summary: "Remove a maintenance window", Auth: openAPIAuthOptional, Params: []*openAPIParam{openAPIPathParam("topic", "Topic name"), openAPIPathParam("id", "Maintenance window ID")}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: "/{topic}/heartbeat", Tag: "publish", Summary: "Heartbeat of a topic, including its status", Auth: openAPIAuthOptional, Params: openAPIParamsTopic, Response: &apiHeartbeat{}},
+	{Method: http.MethodPut, Path: "/{topic}/heartbeat", Tag: "publish", Summary: "Require a ping every interval, and publish an alert if none arrives", Auth: openAPIAuthOptional, Params: openAPIParamsTopic, Request: &apiHeartbeat{}, Response: &apiHeartbeat{}},
+	{Method: http.MethodDelete, Path: "/{topic}/heartbeat", Tag: "publish", Summary: "Remove the heartbeat of a topic", Auth: openAPIAuthOptional, Params: openAPIParamsTopic, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: "/{topic}/heartbeat/ping", Tag: "publish", Summary: "Ping the heartbeat of a topic without publishing a message", Auth: openAPIAuthOptional, Params: openAPIParamsTopic, Response: &apiHeartbeat{}},
+	{Method: http.MethodPost, Path: "/{topic}/heartbeat/ping", Tag: "publish", Summary: "Ping the heartbeat of a topic without publishing a message", Auth: openAPIAuthOptional, Params: openAPIParamsTopic, Response: &apiHeartbeat{}},
+	{Method: http.MethodGet, Path: "/v1/topics/{topic}/stats", Tag: "publish", Summary: "Subscriber counts and activity of a topic (owner or admin only)", Auth: openAPIAuthUser, Params: openAPIParamsTopic, Response: &apiTopicStatsResponse{}},
+	{Method: http.MethodPost, Path: matrixPushPath, Tag: "publish", Summary: "Matrix Push Gateway, forwards Matrix push notifications to the topic in the pushkey", Auth: openAPIAuthNone, Request: map[string]any{}},
+
+	// Subscribe
+	{Method: http.MethodGet, Path: "/{topics}/json", Tag: "subscribe", Summary: "Subscribe as JSON stream (one message per line)", Auth: openAPIAuthOptional, Params: openAPIParamsTopics, Response: &message{}, ResponseType: "application/x-ndjson"},
+	{Method: http.MethodGet, Path: "/{topics}/sse", Tag: "subscribe", Summary: "Subscribe as Server-Sent Events stream", Auth: openAPIAuthOptional, Params: openAPIParamsTopics, Response: "", ResponseType: "text/event-stream"},
+	{Method: http.MethodGet, Path: "/{topics}/raw", Tag: "subscribe", Summary: "Subscribe as raw stream (message body only, one per line)", Auth: openAPIAuthOptional, Params: openAPIParamsTopics, Response: "", ResponseType: "text/plain"},
+	{Method: http.MethodGet, Path: "/{topics}/ws", Tag: "subscribe", Summary: "Subscribe via WebSocket", Auth: openAPIAuthOptional, Params: openAPIParamsTopics},
+	{Method: http.MethodGet, Path: "/{topics}/feed.atom", Tag: "subscribe", Summary: "Cached messages as Atom feed", Auth: openAPIAuthOptional, Params: openAPIParamsTopics, Response: "", ResponseType: "application/atom+xml"},
+	{Method: http.MethodGet, Path: "/{topic}/export", Tag: "subscribe", Summary: "Export the cached messages of a topic as CSV or NDJSON file", Auth: openAPIAuthOptional, Params: openAPIParamsExport, Response: "", ResponseType: "application/x-ndjson"},
+	{Method: http.MethodGet, Path: "/{topics}/auth", Tag: "subscribe", Summary: "Check read access to the topics", Auth: openAPIAuthOptional, Params: openAPIParamsTopics[:1], Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: "/{topic}/showcase.json", Tag: "subscribe", Summary: "Newest messages of a showcase topic, without read access", Auth: openAPIAuthNone, Params: openAPIParamsTopic, Response: &apiShowcaseResponse{}},
+	{Method: http.MethodGet, Path: "/{topic}/showcase", Tag: "subscribe", Summary: "Newest messages of a showcase topic as HTML page, without read access", Auth: openAPIAuthNone, Params: openAPIParamsTopic, Response: "", ResponseType: "text/html"},
+	{Method: http.MethodGet, Path: "/{topic}/presence", Tag: "subscribe", Summary: "Connected subscribers that announced themselves", Auth: openAPIAuthOptional, Params: openAPIParamsTopic, Response: &apiTopicPresenceResponse{}},
+	{Method: http.MethodGet, Path: "/{topic}/{id}/thread", Tag: "subscribe", Summary: "Messages of the thread a message belongs to", Auth: openAPIAuthOptional, Params: openAPIParamsMessage, Response: &apiMessageThreadResponse{}},
+	{Method: http.MethodGet, Path: "/file/{id}", Tag: "subscribe", Summary: "Download an attachment", Auth: openAPIAuthNone, Params: []*openAPIParam{openAPIPathParam("id", "Message ID, optionally with file extension")}, Response: "", ResponseType: "application/octet-stream"},
+
+	// Server
+	{Method: http.MethodGet, Path: apiHealthPath, Tag: "server", Summary: "Health check (same as liveness probe)", Response: &apiHealthResponse{}},
+	{Method: http.MethodGet, Path: apiHealthLivePath, Tag: "server", Summary: "Liveness probe", Response: &apiHealthResponse{}},
+	{Method: http.MethodGet, Path: apiHealthReadyPath, Tag: "server", Summary: "Readiness probe, checks databases, attachment directory and (optionally) Firebase/SMTP reachability; 503 if not ready", Response: &apiHealthResponse{}},
+	{Method: http.MethodGet, Path: apiStatsPath, Tag: "server", Summary: "Server stats", Response: &apiStatsResponse{}},
+	{Method: http.MethodGet, Path: apiStatsHistoryPath, Tag: "server", Summary: "Hourly usage history", Params: []*openAPIParam{openAPIQueryParam("since", "Unix timestamp, or duration relative to now (e.g. 7d)", "string"), openAPIQueryParam("until", "Unix timestamp, or duration relative to now", "string")}, Response: &apiStatsHistoryResponse{}},
+	{Method: http.MethodGet, Path: apiTagsPath, Tag: "server", Summary: "Custom tag icons", Response: &apiTagIconsResponse{}},
+	{Method: http.MethodGet, Path: apiErrorsPath, Tag: "server", Summary: "Catalog of all error codes, with slug, HTTP status, docs link and retryable flag", Response: &apiErrorsResponse{}},
+	{Method: http.MethodGet, Path: "/v1/tags/{tag}/icon", Tag: "server", Summary: "Image of a custom tag icon", Params: []*openAPIParam{openAPIPathParam("tag", "Tag name")}, Response: "", ResponseType: "image/*"},
+	{Method: http.MethodGet, Path: apiTiersPath, Tag: "server", Summary: "Available tiers (if payments are enabled)", Response: []*apiAccountBillingTier{}},
+	{Method: http.MethodGet, Path: apiGraphQLPath, Tag: "server", Summary: "Read-only GraphQL query API over cached messages, topics and account data", Auth: openAPIAuthOptional, Params: []*openAPIParam{openAPIRequiredQueryParam("query", "GraphQL query"), openAPIQueryParam("variables", "Variables, as JSON object", "string"), openAPIQueryParam("operationName", "Name of the operation to execute", "string")}, Response: &graphQLResponse{}},
+	{Method: http.MethodPost, Path: apiGraphQLPath, Tag: "server", Summary: "Read-only GraphQL query API over cached messages, topics and account data", Auth: openAPIAuthOptional, Request: &graphQLRequest{}, Response: &graphQLResponse{}},
+	{Method: http.MethodGet, Path: apiOpenAPIPath, Tag: "server", Summary: "This OpenAPI specification", Response: map[string]any{}},
+	{Method: http.MethodGet, Path: apiMatrixPushKeyPath, Tag: "server", Summary: "Delivery stats of a Matrix push key", Auth: openAPIAuthOptional, Params: []*openAPIParam{openAPIRequiredQueryParam("pushkey", "Matrix push key (URL)")}, Response: &apiMatrixPushKeyResponse{}},
+	{Method: http.MethodPost, Path: apiReportPath, Tag: "server", Summary: "Report a message or topic for abuse", Auth: openAPIAuthOptional, Request: &apiReportRequest{}, Response: &apiReportResponse{}},
+	{Method: http.MethodPost, Path: apiWebPushPath, Tag: "server", Summary: "Add or update a web push subscription", Auth: openAPIAuthOptional, Request: &apiWebPushUpdateSubscriptionRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiWebPushPath, Tag: "server", Summary: "Delete a web push subscription", Auth: openAPIAuthOptional, Request: &apiWebPushUpdateSubscriptionRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPost, Path: apiWebPushRenewPath, Tag: "server", Summary: "Renew a web push subscription (answer to a silent ping)", Auth: openAPIAuthOptional, Request: &apiWebPushRenewSubscriptionRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPost, Path: apiEmailWebhookPath, Tag: "server", Summary: "Bounce webhook for the e-mail provider", Auth: openAPIAuthNone, Params: []*openAPIParam{openAPIQueryParam("key", "Webhook key, only for Amazon SES", "string")}, Request: map[string]any{}, Response: &apiSuccessResponse{}},
+
+	// Account
+	{Method: http.MethodPost, Path: apiAccountPath, Tag: "account", Summary: "Create an account (sign up)", Auth: openAPIAuthNone, Request: &apiAccountCreateRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: apiAccountPath, Tag: "account", Summary: "Account details, limits and stats", Auth: openAPIAuthOptional, Response: &apiAccountResponse{}},
+	{Method: http.MethodDelete, Path: apiAccountPath, Tag: "account", Summary: "Schedule the deletion of the account (after a grace period)", Auth: openAPIAuthUser, Request: &apiAccountDeleteRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiAccountDeletionPath, Tag: "account", Summary: "Cancel the scheduled deletion of the account", Auth: openAPIAuthUser, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPost, Path: apiAccountPasswordPath, Tag: "account", Summary: "Change the password", Auth: openAPIAuthUser, Request: &apiAccountPasswordChangeRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPost, Path: apiAccountTokenPath, Tag: "account", Summary: "Create an access token", Auth: openAPIAuthUser, Request: &apiAccountTokenIssueRequest{}, Response: &apiAccountTokenResponse{}},
+	{Method: http.MethodPatch, Path: apiAccountTokenPath, Tag: "account", Summary: "Update or extend an access token", Auth: openAPIAuthUser, Request: &apiAccountTokenUpdateRequest{}, Response: &apiAccountTokenResponse{}},
+	{Method: http.MethodDelete, Path: apiAccountTokenPath, Tag: "account", Summary: "Delete an access token (the one used, or the one in the X-Token header)", Auth: openAPIAuthUser, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPatch, Path: apiAccountSettingsPath, Tag: "account", Summary: "Update the account settings", Auth: openAPIAuthUser, Request: &user.Prefs{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPost, Path: apiAccountSubscriptionPath, Tag: "account", Summary: "Add a synced subscription", Auth: openAPIAuthUser, Request: &user.Subscription{}, Response: &user.Subscription{}},
+	{Method: http.MethodPatch, Path: apiAccountSubscriptionPath, Tag: "account", Summary: "Update a synced subscription", Auth: openAPIAuthUser, Request: &user.Subscription{}, Response: &user.Subscription{}},
+	{Method: http.MethodPost, Path: apiAccountTemplatePath, Tag: "account", Summary: "Add or replace a message template", Auth: openAPIAuthUser, Request: &user.Template{}, Response: &user.Template{}},
+	{Method: http.MethodDelete, Path: apiAccountTemplatePath + "/{name}", Tag: "account", Summary: "Delete a message template", Auth: openAPIAuthUser, Params: []*openAPIParam{openAPIPathParam("name", "Template name")}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiAccountSubscriptionPath, Tag: "account", Summary: "Delete a synced subscription (identified by the X-BaseURL and X-Topic headers)", Auth: openAPIAuthUser, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPost, Path: apiAccountReservationPath, Tag: "account", Summary: "Reserve a topic", Auth: openAPIAuthUser, Request: &apiAccountReservationRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: "/v1/account/reservation/{topic}", Tag: "account", Summary: "Delete a topic reservation", Auth: openAPIAuthUser, Params: openAPIParamsTopic, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPost, Path: "/v1/account/reservation/{topic}/key", Tag: "account", Summary: "Create a publish key for a reserved topic", Auth: openAPIAuthUser, Params: openAPIParamsTopic, Request: &apiAccountPublishKeyRequest{}, Response: &apiAccountPublishKey{}},
+	{Method: http.MethodDelete, Path: "/v1/account/reservation/{topic}/key", Tag: "account", Summary: "Revoke the publish key of a reserved topic", Auth: openAPIAuthUser, Params: openAPIParamsTopic, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: "/v1/account/reservation/{topic}/schedule", Tag: "account", Summary: "Get the on-call schedule of a reserved topic", Auth: openAPIAuthUser, Params: openAPIParamsTopic, Response: &apiSchedule{}},
+	{Method: http.MethodPut, Path: "/v1/account/reservation/{topic}/schedule", Tag: "account", Summary: "Create or replace the on-call schedule of a reserved topic", Auth: openAPIAuthUser, Params: openAPIParamsTopic, Request: &apiSchedule{}, Response: &apiSchedule{}},
+	{Method: http.MethodDelete, Path: "/v1/account/reservation/{topic}/schedule", Tag: "account", Summary: "Delete the on-call schedule of a reserved topic", Auth: openAPIAuthUser, Params: openAPIParamsTopic, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: apiAccountScheduledCalendarPath, Tag: "account", Summary: "Pending scheduled messages on reserved topics, as iCalendar feed", Auth: openAPIAuthUser, Response: "", ResponseType: "text/calendar"},
+	{Method: http.MethodPut, Path: apiAccountPhoneVerifyPath, Tag: "account", Summary: "Send a verification code to a phone number", Auth: openAPIAuthUser, Request: &apiAccountPhoneNumberVerifyRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPut, Path: apiAccountPhonePath, Tag: "account", Summary: "Add a verified phone number", Auth: openAPIAuthUser, Request: &apiAccountPhoneNumberAddRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiAccountPhonePath, Tag: "account", Summary: "Delete a phone number", Auth: openAPIAuthUser, Request: &apiAccountPhoneNumberAddRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: apiAccountWebPushPath, Tag: "account", Summary: "List the web push subscriptions (browsers) of the user", Auth: openAPIAuthUser, Response: &apiAccountWebPushSubscriptionsResponse{}},
+	{Method: http.MethodDelete, Path: "/v1/account/webpush/{id}", Tag: "account", Summary: "Delete a web push subscription of the user", Auth: openAPIAuthUser, Params: []*openAPIParam{openAPIPathParam("id", "Web push subscription ID")}, Response: &apiSuccessResponse{}},
+
+	// Billing
+	{Method: http.MethodPost, Path: apiAccountBillingSubscriptionPath, Tag: "billing", Summary: "Create a paid subscription (checkout)", Auth: openAPIAuthUser, Request: &apiAccountBillingSubscriptionChangeRequest{}, Response: &apiAccountBillingSubscriptionCreateResponse{}},
+	{Method: http.MethodPut, Path: apiAccountBillingSubscriptionPath, Tag: "billing", Summary: "Change the tier of the paid subscription", Auth: openAPIAuthUser, Request: &apiAccountBillingSubscriptionChangeRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiAccountBillingSubscriptionPath, Tag: "billing", Summary: "Cancel the paid subscription", Auth: openAPIAuthUser, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: apiAccountBillingSubscriptionCheckoutSuccessTemplate, Tag: "billing", Summary: "Redirect target after a successful checkout", Auth: openAPIAuthNone, Params: []*openAPIParam{openAPIPathParam("CHECKOUT_SESSION_ID", "Checkout session ID")}},
+	{Method: http.MethodPost, Path: apiAccountBillingPortalPath, Tag: "billing", Summary: "Create a billing portal session", Auth: openAPIAuthUser, Response: &apiAccountBillingPortalRedirectResponse{}},
+	{Method: http.MethodPost, Path: apiAccountBillingWebhookPath, Tag: "billing", Summary: "Webhook for the payment provider", Auth: openAPIAuthNone, Request: map[string]any{}, Response: &apiSuccessResponse{}},
+
+	// Admin
+	{Method: http.MethodGet, Path: apiUsersPath, Tag: "admin", Summary: "List all users", Auth: openAPIAuthAdmin, Response: []*apiUserResponse{}},
+	{Method: http.MethodPut, Path: apiUsersPath, Tag: "admin", Summary: "Add a user", Auth: openAPIAuthAdmin, Request: &apiUserAddRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiUsersPath, Tag: "admin", Summary: "Delete a user", Auth: openAPIAuthAdmin, Request: &apiUserDeleteRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPut, Path: apiUsersAccessPath, Tag: "admin", Summary: "Grant a user access to a topic (also POST)", Auth: openAPIAuthAdmin, Request: &apiAccessAllowRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiUsersAccessPath, Tag: "admin", Summary: "Reset the access of a user", Auth: openAPIAuthAdmin, Request: &apiAccessResetRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: apiUsersChangesPath, Tag: "admin", Summary: "List users changed since a position in the change log (used for standby replication)", Auth: openAPIAuthAdmin, Params: openAPIParamsUsersChanges, Response: &user.Changes{}},
+	{Method: http.MethodPost, Path: apiUsersImpersonatePath, Tag: "admin", Summary: "Issue a short-lived token to act as a regular user", Auth: openAPIAuthAdmin, Request: &apiUserImpersonateRequest{}, Response: &apiAccountTokenResponse{}},
+	{Method: http.MethodGet, Path: apiGroupsPath, Tag: "admin", Summary: "List all groups", Auth: openAPIAuthAdmin, Response: []*apiGroupResponse{}},
+	{Method: http.MethodPut, Path: apiGroupsPath, Tag: "admin", Summary: "Add a group (also POST)", Auth: openAPIAuthAdmin, Request: &apiGroupRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiGroupsPath, Tag: "admin", Summary: "Delete a group", Auth: openAPIAuthAdmin, Params: openAPIParamsGroup, Request: &apiGroupRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPut, Path: apiGroupsMembersPath, Tag: "admin", Summary: "Add users to a group (also POST)", Auth: openAPIAuthAdmin, Request: &apiGroupMembersRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiGroupsMembersPath, Tag: "admin", Summary: "Remove users from a group", Auth: openAPIAuthAdmin, Request: &apiGroupMembersRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPut, Path: apiGroupsAccessPath, Tag: "admin", Summary: "Grant a group access to a topic (also POST)", Auth: openAPIAuthAdmin, Request: &apiGroupAccessAllowRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiGroupsAccessPath, Tag: "admin", Summary: "Reset the access of a group", Auth: openAPIAuthAdmin, Request: &apiGroupAccessResetRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: apiReportsPath, Tag: "admin", Summary: "List abuse reports", Auth: openAPIAuthAdmin, Params: []*openAPIParam{openAPIQueryParam("status", "Filter by status (open, resolved, dismissed)", "string")}, Response: []*apiReport{}},
+	{Method: http.MethodPost, Path: "/v1/reports/{id}", Tag: "admin", Summary: "Act on an abuse report", Auth: openAPIAuthAdmin, Params: []*openAPIParam{openAPIPathParam("id", "Report ID")}, Request: &apiReportActionRequest{}, Response: &apiReport{}},
+	{Method: http.MethodGet, Path: apiBlocksPath, Tag: "admin", Summary: "List blocked topics and banned IP addresses", Auth: openAPIAuthAdmin, Response: &apiBlocksResponse{}},
+	{Method: http.MethodPost, Path: apiBlocksPath, Tag: "admin", Summary: "Block a topic, or ban an IP address", Auth: openAPIAuthAdmin, Request: &apiBlocksRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiBlocksPath, Tag: "admin", Summary: "Unblock a topic, or lift the ban of an IP address", Auth: openAPIAuthAdmin, Request: &apiBlocksDeleteRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPost, Path: apiMessagesPrunePath, Tag: "admin", Summary: "Delete the messages of a topic published before a given time, and their attachments", Auth: openAPIAuthAdmin, Request: &apiMessagesPruneRequest{}, Response: &apiMessagesPruneResponse{}},
+	{Method: http.MethodGet, Path: apiExemptionsPath, Tag: "admin", Summary: "List hosts and users exempt from rate limiting", Auth: openAPIAuthAdmin, Response: []*apiRateLimitExemption{}},
+	{Method: http.MethodPost, Path: apiExemptionsPath, Tag: "admin", Summary: "Exempt a host or user from rate limiting", Auth: openAPIAuthAdmin, Request: &apiRateLimitExemptionRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiExemptionsPath, Tag: "admin", Summary: "Remove the rate limit exemption of a host or user", Auth: openAPIAuthAdmin, Request: &apiRateLimitExemptionRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: apiEmailUndeliverablePath, Tag: "admin", Summary: "List e-mail addresses marked as undeliverable", Auth: openAPIAuthAdmin, Response: []*apiUndeliverableEmail{}},
+	{Method: http.MethodDelete, Path: apiEmailUndeliverablePath, Tag: "admin", Summary: "Allow sending e-mails to an undeliverable address again", Auth: openAPIAuthAdmin, Request: &apiUndeliverableEmailRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: apiVisitorsPath, Tag: "admin", Summary: "List current visitors and their rate limits", Auth: openAPIAuthAdmin, Response: []*apiVisitor{}},
+	{Method: http.MethodPost, Path: apiVisitorsResetPath, Tag: "admin", Summary: "Reset the rate limits of a visitor", Auth: openAPIAuthAdmin, Request: &apiVisitorRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodPost, Path: apiVisitorsExemptPath, Tag: "admin", Summary: "Temporarily exempt a visitor from rate limiting", Auth: openAPIAuthAdmin, Request: &apiVisitorRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodDelete, Path: apiVisitorsExemptPath, Tag: "admin", Summary: "Remove the rate limit exemption of a visitor", Auth: openAPIAuthAdmin, Request: &apiVisitorRequest{}, Response: &apiSuccessResponse{}},
+	{Method: http.MethodGet, Path: apiMatrixStatsPath, Tag: "admin", Summary: "Matrix gateway stats", Auth: openAPIAuthAdmin, Response: &apiMatrixStatsResponse{}},
+
+	// SCIM
+	{Method: http.MethodGet, Path: scimServiceProviderConfigPath, Tag: "scim", Summary: "SCIM service provider config", Auth: openAPIAuthSCIM, Response: &scimServiceProviderConfigResponse{}, ResponseType: scimContentType},
+	{Method: http.MethodGet, Path: scimUsersPath, Tag: "scim", Summary: "List users", Auth: openAPIAuthSCIM, Params: []*openAPIParam{openAPIQueryParam("filter", "SCIM filter, e.g. userName eq \"phil\"", "string")}, Response: &scimListResponse{}, ResponseType: scimContentType},
+	{Method: http.MethodPost, Path: scimUsersPath, Tag: "scim", Summary: "Create a user", Auth: openAPIAuthSCIM, Request: &scimUserRequest{}, RequestType: scimContentType, Response: &scimUserResponse{}, ResponseType: scimContentType},
+	{Method: http.MethodGet, Path: "/scim/v2/Users/{id}", Tag: "scim", Summary: "Get a user", Auth: openAPIAuthSCIM, Params: []*openAPIParam{openAPIPathParam("id", "User ID")}, Response: &scimUserResponse{}, ResponseType: scimContentType},
+	{Method: http.MethodPut, Path: "/scim/v2/Users/{id}", Tag: "scim", Summary: "Replace a user", Auth: openAPIAuthSCIM, Params: []*openAPIParam{openAPIPathParam("id", "User ID")}, Request: &scimUserRequest{}, RequestType: scimContentType, Response: &scimUserResponse{}, ResponseType: scimContentType},
+	{Method: http.MethodPatch, Path: "/scim/v2/Users/{id}", Tag: "scim", Summary: "Update a user", Auth: openAPIAuthSCIM, Params: []*openAPIParam{openAPIPathParam("id", "User ID")}, Request: &scimPatchRequest{}, RequestType: scimContentType, Response: &scimUserResponse{}, ResponseType: scimContentType},
+	{Method: http.MethodDelete, Path: "/scim/v2/Users/{id}", Tag: "scim", Summary: "Delete a user", Auth: openAPIAuthSCIM, Params: []*openAPIParam{openAPIPathParam("id", "User ID")}},
+}
+
+// handleOpenAPI serves the OpenAPI specification of the ntfy API
+func (s *Server) handleOpenAPI(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	s.openAPIOnce.Do(func() {
+		s.openAPI = openAPISpec(s.config.BaseURL, s.config.Version, openAPIOperations)
+	})
+	return s.writeJSON(w, s.openAPI)
+}
+
+// openAPISpec generates the OpenAPI specification for the given operations
+func openAPISpec(baseURL, version string, operations []*openAPIOperation) map[string]any {
+	if version == "" {
+		version = "dev"
+	}
+	gen := &openAPIGenerator{schemas: make(map[string]any)}
+	paths := make(map[string]map[string]any)
+	for _, op := range operations {
+		if _, ok := paths[op.Path]; !ok {
+			paths[op.Path] = make(map[string]any)
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = gen.operation(op)
+	}
+	spec := map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":       "ntfy",
+			"description": "Send push notifications to your phone or desktop via PUT/POST. See https://docs.ntfy.sh for details.",
+			"version":     version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": gen.schemas,
+			"securitySchemes": map[string]any{
+				"basicAuth":  map[string]any{"type": "http", "scheme": "basic"},
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "description": "Access token (tk_...) or publish key (pk_...)"},
+			},
+		},
+	}
+	if baseURL != "" {
+		spec["servers"] = []map[string]any{{"url": baseURL}}
+	}
+	return spec
+}
+
+type openAPIGenerator struct {
+	schemas map[string]any // Component schemas, by name
+}
+
+func (g *openAPIGenerator) operation(op *openAPIOperation) map[string]any {
+	operation := map[string]any{
+		"summary": op.Summary,
+		"tags":    []string{op.Tag},
+	}
+	if len(op.Params) > 0 {
+		operation["parameters"] = op.Params
+	}
+	if op.Request != nil {
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				openAPIContentType(op.RequestType): map[string]any{"schema": g.schema(reflect.TypeOf(op.Request))},
+			},
+		}
+	}
+	success := map[string]any{"description": "Success"}
+	if op.Response != nil {
+		success["content"] = map[string]any{
+			openAPIContentType(op.ResponseType): map[string]any{"schema": g.schema(reflect.TypeOf(op.Response))},
+		}
+	}
+	operation["responses"] = map[string]any{
+		"200": success,
+		"default": map[string]any{
+			"description": "Error",
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(&errHTTP{}))},
+			},
+		},
+	}
+	switch op.Auth {
+	case openAPIAuthOptional:
+		operation["security"] = []map[string][]string{{}, {"basicAuth": {}}, {"bearerAuth": {}}}
+	case openAPIAuthUser, openAPIAuthAdmin:
+		operation["security"] = []map[string][]string{{"basicAuth": {}}, {"bearerAuth": {}}}
+	case openAPIAuthSCIM:
+		operation["security"] = []map[string][]string{{"bearerAuth": {}}}
+	}
+	if op.Auth == openAPIAuthAdmin {
+		operation["description"] = "Requires an admin user."
+	}
+	return operation
+}
+
+// schema returns the JSON schema for the given Go type. Named struct types are added to the component schemas,
+// and referenced via $ref. Property names are taken from the json struct tags, like encoding/json does.
+func (g *openAPIGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(netip.Addr{}):
+		return map[string]any{"type": "string", "format": "ip"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := openAPISchemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = map[string]any{} // Placeholder to break recursion
+			g.schemas[name] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{} // Any type, e.g. interface{}
+}
+
+func (g *openAPIGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	g.addProperties(t, properties)
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+func (g *openAPIGenerator) addProperties(t reflect.Type, properties map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				g.addProperties(embedded, properties)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		} else if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+	}
+}
+
+// openAPISchemaName returns the component name of a type, e.g. "AccountResponse" for apiAccountResponse
+func openAPISchemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "api")
+	if i := strings.Index(name, "["); i != -1 {
+		name = name[:i] // Generic types, e.g. apiPaddleResponse[...]
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func openAPIContentType(contentType string) string {
+	if contentType == "" {
+		return "application/json"
+	}
+	return contentType
+}
+
+func openAPIPathParam(name, description string) *openAPIParam {
+	return &openAPIParam{Name: name, In: "path", Description: description, Required: true, Schema: map[string]string{"type": "string"}}
+}
+
+func openAPIQueryParam(name, description, typ string) *openAPIParam {
+	return &openAPIParam{Name: name, In: "query", Description: description, Schema: map[string]string{"type": typ}}
+}
+
+func openAPIRequiredQueryParam(name, description string) *openAPIParam {
+	return &openAPIParam{Name: name, In: "query", Description: description, Required: true, Schema: map[string]string{"type": "string"}}
+}
+
+func openAPIHeaderParam(name, description, typ string) *openAPIParam {
+	return &openAPIParam{Name: name, In: "header", Description: description, Schema: map[string]string{"type": typ}}
+}
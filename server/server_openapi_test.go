@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"heckel.io/ntfy/v2/user"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestServer_OpenAPI(t *testing.T) {
+	c := newTestConfig(t)
+	c.Version = "1.2.3"
+	s := newTestServer(t, c)
+
+	rr := request(t, s, "GET", "/v1/openapi.json", "", nil)
+	require.Equal(t, 200, rr.Code)
+
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	require.Nil(t, json.NewDecoder(rr.Body).Decode(&spec))
+	require.Equal(t, "3.0.3", spec.OpenAPI)
+	require.Equal(t, "1.2.3", spec.Info.Version)
+	require.Equal(t, "http://127.0.0.1:12345", spec.Servers[0].URL)
+
+	// Operations
+	require.Contains(t, spec.Paths["/{topic}"], "put")
+	require.Contains(t, spec.Paths["/{topics}/json"], "get")
+	require.Contains(t, spec.Paths["/v1/account"], "delete")
+	require.Contains(t, spec.Paths["/v1/users"], "put")
+	require.Contains(t, spec.Paths["/v1/reports/{id}"], "post")
+
+	// Schemas, derived from the Go types
+	account := spec.Components.Schemas["AccountResponse"]
+	require.Equal(t, "string", account.Properties["username"]["type"])
+	require.Equal(t, "#/components/schemas/AccountLimits", account.Properties["limits"]["$ref"])
+	require.Equal(t, "array", account.Properties["tokens"]["type"])
+
+	msg := spec.Components.Schemas["Message"]
+	require.Equal(t, "integer", msg.Properties["time"]["type"])
+	require.Contains(t, msg.Properties, "attachment")
+	require.NotContains(t, msg.Properties, "sender") // json:"-"
+	require.NotContains(t, msg.Properties, "Sender")
+
+	errSchema := spec.Components.Schemas["ErrHTTP"]
+	require.Contains(t, errSchema.Properties, "code")
+	require.Contains(t, errSchema.Properties, "error")
+}
+
+func TestServer_OpenAPI_NoAuthForPublicEndpoints(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+
+	rr := request(t, s, "GET", "/v1/openapi.json", "", nil)
+	require.Equal(t, 200, rr.Code)
+}
+
+// TestOpenAPI_AllPathsDocumented makes sure that every API path declared in server.go is described
+// in openAPIOperations, so that the specification is kept in sync when new endpoints are added
+func TestOpenAPI_AllPathsDocumented(t *testing.T) {
+	documented := make(map[string]bool)
+	for _, op := range openAPIOperations {
+		documented[op.Path] = true
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "server.go", nil, 0)
+	require.Nil(t, err)
+	count := 0
+	ast.Inspect(f, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok || len(spec.Names) != 1 || len(spec.Values) != 1 {
+			return true
+		}
+		name := spec.Names[0].Name
+		lit, ok := spec.Values[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+		isAPIPath := strings.HasPrefix(name, "api") && (strings.HasSuffix(name, "Path") || strings.HasSuffix(name, "Template"))
+		isSCIMPath := strings.HasPrefix(name, "scim") && strings.HasSuffix(name, "Path") && name != "scimPathPrefix"
+		if isAPIPath || isSCIMPath || name == "matrixPushPath" {
+			path, err := strconv.Unquote(lit.Value)
+			require.Nil(t, err)
+			require.True(t, documented[path], "path %s (%s) is not documented in openAPIOperations", path, name)
+			count++
+		}
+		return true
+	})
+	require.Greater(t, count, 30)
+}
@@ -30,7 +30,7 @@ func TestPayments_Tiers(t *testing.T) {
 	c.VisitorAttachmentTotalSizeLimit = 222
 	c.AttachmentExpiryDuration = 123 * time.Second
 	s := newTestServer(t, c)
-	s.stripe = stripeMock
+	s.payments = newStripePaymentProvider(s.config, stripeMock)
 
 	// Define how the mock should react
 	stripeMock.
@@ -135,7 +135,7 @@ func TestPayments_SubscriptionCreate_NotAStripeCustomer_Success(t *testing.T) {
 	c.StripeSecretKey = "secret key"
 	c.StripeWebhookKey = "webhook key"
 	s := newTestServer(t, c)
-	s.stripe = stripeMock
+	s.payments = newStripePaymentProvider(s.config, stripeMock)
 
 	// Define how the mock should react
 	stripeMock.
@@ -168,7 +168,7 @@ func TestPayments_SubscriptionCreate_StripeCustomer_Success(t *testing.T) {
 	c.StripeSecretKey = "secret key"
 	c.StripeWebhookKey = "webhook key"
 	s := newTestServer(t, c)
-	s.stripe = stripeMock
+	s.payments = newStripePaymentProvider(s.config, stripeMock)
 
 	// Define how the mock should react
 	stripeMock.
@@ -213,7 +213,7 @@ func TestPayments_AccountDelete_Cancels_Subscription(t *testing.T) {
 	c.StripeSecretKey = "secret key"
 	c.StripeWebhookKey = "webhook key"
 	s := newTestServer(t, c)
-	s.stripe = stripeMock
+	s.payments = newStripePaymentProvider(s.config, stripeMock)
 
 	// Define how the mock should react
 	stripeMock.
@@ -242,6 +242,7 @@ func TestPayments_AccountDelete_Cancels_Subscription(t *testing.T) {
 		"Authorization": util.BasicAuth("phil", "phil"),
 	})
 	require.Equal(t, 200, rr.Code)
+	forceAccountDeletion(t, s, "phil") // Subscription is only canceled after the grace period
 
 	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
 		"Authorization": util.BasicAuth("phil", "mypass"),
@@ -269,7 +270,7 @@ func TestPayments_Checkout_Success_And_Increase_Rate_Limits_Reset_Visitor(t *tes
 	c.CacheBatchSize = 500
 	c.CacheBatchTimeout = time.Second
 	s := newTestServer(t, c)
-	s.stripe = stripeMock
+	s.payments = newStripePaymentProvider(s.config, stripeMock)
 
 	// Create a user with a Stripe subscription and 3 reservations
 	require.Nil(t, s.userManager.AddTier(&user.Tier{
@@ -429,7 +430,7 @@ func TestPayments_Webhook_Subscription_Updated_Downgrade_From_PastDue_To_Active(
 	c.StripeSecretKey = "secret key"
 	c.StripeWebhookKey = "webhook key"
 	s := newTestServer(t, c)
-	s.stripe = stripeMock
+	s.payments = newStripePaymentProvider(s.config, stripeMock)
 
 	// Define how the mock should react
 	stripeMock.
@@ -556,7 +557,7 @@ func TestPayments_Webhook_Subscription_Deleted(t *testing.T) {
 	c.StripeSecretKey = "secret key"
 	c.StripeWebhookKey = "webhook key"
 	s := newTestServer(t, c)
-	s.stripe = stripeMock
+	s.payments = newStripePaymentProvider(s.config, stripeMock)
 
 	// Define how the mock should react
 	stripeMock.
@@ -616,7 +617,7 @@ func TestPayments_Subscription_Update_Different_Tier(t *testing.T) {
 	c.StripeSecretKey = "secret key"
 	c.StripeWebhookKey = "webhook key"
 	s := newTestServer(t, c)
-	s.stripe = stripeMock
+	s.payments = newStripePaymentProvider(s.config, stripeMock)
 
 	// Define how the mock should react
 	stripeMock.
@@ -680,7 +681,7 @@ func TestPayments_Subscription_Delete_At_Period_End(t *testing.T) {
 	c.StripeSecretKey = "secret key"
 	c.StripeWebhookKey = "webhook key"
 	s := newTestServer(t, c)
-	s.stripe = stripeMock
+	s.payments = newStripePaymentProvider(s.config, stripeMock)
 
 	// Define how the mock should react
 	stripeMock.
@@ -711,7 +712,7 @@ func TestPayments_CreatePortalSession(t *testing.T) {
 	c.StripeSecretKey = "secret key"
 	c.StripeWebhookKey = "webhook key"
 	s := newTestServer(t, c)
-	s.stripe = stripeMock
+	s.payments = newStripePaymentProvider(s.config, stripeMock)
 
 	// Define how the mock should react
 	stripeMock.
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handleTopicPresence lists all clients that are currently subscribed to a topic, and that announced themselves
+// with a presence label when subscribing (?presence=<label>). Clients that did not announce themselves are not listed.
+func (s *Server) handleTopicPresence(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	matches := presencePathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topicID := matches[1]
+	response := &apiTopicPresenceResponse{
+		Topic:   topicID,
+		Clients: make([]*apiTopicPresenceClient, 0),
+	}
+	s.mu.RLock()
+	t, exists := s.topics[topicID]
+	s.mu.RUnlock()
+	if exists {
+		for _, sub := range t.Presence() {
+			response.Clients = append(response.Clients, &apiTopicPresenceClient{
+				Label:     sub.presence,
+				Since:     sub.since.Unix(),
+				Transport: sub.transport,
+			})
+		}
+	}
+	return s.writeJSON(w, response)
+}
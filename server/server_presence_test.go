@@ -0,0 +1,74 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_TopicPresence(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	// Subscribers without a presence label are not listed
+	anonymousCancel := subscribe(t, s, "/mytopic/json", httptest.NewRecorder())
+	defer anonymousCancel()
+	laptopCancel := subscribe(t, s, "/mytopic,othertopic/sse?presence=oncall-laptop", httptest.NewRecorder())
+	phoneCancel := subscribe(t, s, "/mytopic/json?presence=phil@phone", httptest.NewRecorder())
+	defer phoneCancel()
+
+	response := request(t, s, "GET", "/mytopic/presence", "", nil)
+	require.Equal(t, 200, response.Code)
+	presence, err := util.UnmarshalJSON[apiTopicPresenceResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "mytopic", presence.Topic)
+	require.Equal(t, 2, len(presence.Clients))
+	require.Equal(t, "oncall-laptop", presence.Clients[0].Label)
+	require.Equal(t, subscriberTransportSSE, presence.Clients[0].Transport)
+	require.True(t, presence.Clients[0].Since > 0)
+	require.Equal(t, "phil@phone", presence.Clients[1].Label)
+
+	response = request(t, s, "GET", "/othertopic/presence", "", nil)
+	require.Equal(t, 200, response.Code)
+	presence, err = util.UnmarshalJSON[apiTopicPresenceResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(presence.Clients))
+
+	// Disconnected clients disappear from the list
+	laptopCancel()
+	response = request(t, s, "GET", "/othertopic/presence", "", nil)
+	require.Equal(t, 200, response.Code)
+	presence, err = util.UnmarshalJSON[apiTopicPresenceResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 0, len(presence.Clients))
+
+	// Unknown topic
+	response = request(t, s, "GET", "/unknowntopic/presence", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, `{"topic":"unknowntopic","clients":[]}`+"\n", response.Body.String())
+}
+
+func TestServer_TopicPresence_LabelInvalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "GET", "/mytopic/json?poll=1&presence=%3Cscript%3E", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40050, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_TopicPresence_AccessControl(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionRead))
+
+	response := request(t, s, "GET", "/mytopic/presence", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/presence", "", nil)
+	require.Equal(t, 403, response.Code)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"heckel.io/ntfy/v2/user"
+)
+
+const (
+	// previewDescriptionLength is the maximum length of the OpenGraph description, if it is derived from a message
+	previewDescriptionLength = 200
+
+	previewDefaultImage       = "/static/images/pwa-512x512.png"
+	previewDefaultDescription = "Subscribe to this topic to receive push notifications via ntfy"
+)
+
+var (
+	// previewUserAgentRegex matches the user agents of chat apps and social networks that fetch a page to render
+	// a link preview. Only these are served the preview page; browsers still get the web app.
+	previewUserAgentRegex = regexp.MustCompile(`(?i)(facebookexternalhit|facebot|twitterbot|slackbot|discordbot|telegrambot|whatsapp|linkedinbot|mattermost|skypeuripreview|synapse|mastodon|redditbot|embedly|iframely)`)
+
+	previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="robots" content="noindex, nofollow">
+  <title>{{.Title}}</title>
+  <meta name="description" content="{{.Description}}">
+  <meta property="og:type" content="website">
+  <meta property="og:site_name" content="ntfy">
+  <meta property="og:title" content="{{.Title}}">
+  <meta property="og:description" content="{{.Description}}">
+  <meta property="og:url" content="{{.URL}}">
+  <meta property="og:image" content="{{.Image}}">
+  <meta name="twitter:card" content="summary">
+  <meta name="twitter:title" content="{{.Title}}">
+  <meta name="twitter:description" content="{{.Description}}">
+  <meta name="twitter:image" content="{{.Image}}">
+</head>
+<body>
+  <h1>{{.Title}}</h1>
+  <p>{{.Description}}</p>
+  <p><a href="{{.URL}}">Open in ntfy</a></p>
+</body>
+</html>
+`))
+)
+
+type previewPage struct {
+	Title       string
+	Description string
+	URL         string
+	Image       string
+}
+
+// isPreviewRequest returns true if the request was made by a link preview crawler, see previewUserAgentRegex
+func isPreviewRequest(r *http.Request) bool {
+	return previewUserAgentRegex.MatchString(r.Header.Get("User-Agent"))
+}
+
+// handleTopicPreview renders a minimal HTML page with OpenGraph and Twitter card metadata for a topic, so that
+// links to topics unfurl meaningfully in chat apps. The latest message is only included if the topic can be
+// read by anonymous users, since the preview is rendered for whoever shares (or crawls) the link.
+func (s *Server) handleTopicPreview(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	topic := strings.TrimPrefix(r.URL.Path, "/")
+	baseURL := s.feedBaseURL(r)
+	page := &previewPage{
+		Title:       topic,
+		Description: previewDefaultDescription,
+		URL:         baseURL + "/" + topic,
+		Image:       baseURL + previewDefaultImage,
+	}
+	if s.topicReadableByEveryone(topic) {
+		m, err := s.messageCache.LatestMessage(topic)
+		if err != nil && !errors.Is(err, errMessageNotFound) {
+			return err
+		} else if m != nil {
+			if description := previewDescription(m); description != "" {
+				page.Description = description
+			}
+			if m.Icon != "" {
+				page.Image = m.Icon
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return previewTemplate.Execute(w, page)
+}
+
+// topicReadableByEveryone returns true if anonymous users are allowed to read the topic
+func (s *Server) topicReadableByEveryone(topic string) bool {
+	if s.topicBlocked(topic) {
+		return false
+	} else if s.userManager == nil {
+		return true
+	}
+	return s.userManager.Authorize(nil, topic, user.PermissionRead) == nil
+}
+
+// previewDescription returns the title and body of a message as a single line, shortened to previewDescriptionLength
+func previewDescription(m *message) string {
+	var description string
+	if m.Encoding == "" {
+		description = strings.Join(strings.Fields(m.Message), " ")
+	}
+	if m.Title != "" && description != "" {
+		description = m.Title + ": " + description
+	} else if m.Title != "" {
+		description = m.Title
+	}
+	if runes := []rune(description); len(runes) > previewDescriptionLength {
+		description = string(runes[:previewDescriptionLength-3]) + "..."
+	}
+	return description
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"strings"
+	"testing"
+)
+
+const testPreviewUserAgent = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+
+func TestServer_TopicPreview(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	// No messages yet
+	response := request(t, s, "GET", "/mytopic", "", map[string]string{
+		"User-Agent": testPreviewUserAgent,
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "text/html; charset=utf-8", response.Header().Get("Content-Type"))
+	body := response.Body.String()
+	require.Contains(t, body, `<meta property="og:title" content="mytopic">`)
+	require.Contains(t, body, `<meta property="og:description" content="Subscribe to this topic to receive push notifications via ntfy">`)
+	require.Contains(t, body, `<meta property="og:url" content="http://127.0.0.1:12345/mytopic">`)
+	require.Contains(t, body, `<meta property="og:image" content="http://127.0.0.1:12345/static/images/pwa-512x512.png">`)
+	require.Contains(t, body, `<meta name="twitter:card" content="summary">`)
+
+	// Latest message is shown, and escaped
+	response = request(t, s, "PUT", "/mytopic", "first message", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "Disk <b>full</b>\non server", map[string]string{
+		"Title": "Alert",
+		"Icon":  "https://example.com/icon.png",
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic", "", map[string]string{
+		"User-Agent": "facebookexternalhit/1.1",
+	})
+	require.Equal(t, 200, response.Code)
+	body = response.Body.String()
+	require.Contains(t, body, `<meta property="og:description" content="Alert: Disk &lt;b&gt;full&lt;/b&gt; on server">`)
+	require.Contains(t, body, `<meta property="og:image" content="https://example.com/icon.png">`)
+	require.NotContains(t, body, "first message")
+}
+
+func TestServer_TopicPreview_Browser(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "some message", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic", "", map[string]string{
+		"User-Agent": "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0",
+	})
+	require.Equal(t, 200, response.Code)
+	require.NotContains(t, response.Body.String(), "og:title")
+}
+
+func TestServer_TopicPreview_ProtectedTopic(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "secret", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "secret", user.PermissionDenyAll))
+
+	response := request(t, s, "PUT", "/secret", "top secret message", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Topic name is shown, message is not
+	response = request(t, s, "GET", "/secret", "", map[string]string{
+		"User-Agent": testPreviewUserAgent,
+	})
+	require.Equal(t, 200, response.Code)
+	body := response.Body.String()
+	require.Contains(t, body, `<meta property="og:title" content="secret">`)
+	require.NotContains(t, body, "top secret message")
+}
+
+func TestServer_PreviewDescription(t *testing.T) {
+	require.Equal(t, "some message", previewDescription(&message{Message: "  some\n message "}))
+	require.Equal(t, "Title only", previewDescription(&message{Title: "Title only", Message: "aGk=", Encoding: "base64"}))
+	require.Equal(t, "", previewDescription(&message{}))
+	description := previewDescription(&message{Message: strings.Repeat("ü", 300)})
+	require.Equal(t, previewDescriptionLength, len([]rune(description)))
+	require.True(t, strings.HasSuffix(description, "..."))
+}
@@ -0,0 +1,81 @@
+package server
+
+// Defines the types of subscribers that the push channels reach, see publishToPushFallback
+const (
+	pushSubscribersApps     = "apps"     // Android and iOS apps, via Firebase or the upstream server
+	pushSubscribersBrowsers = "browsers" // Web app, via web push
+)
+
+var pushChannelSubscribers = map[string]string{
+	PushChannelFirebase: pushSubscribersApps,
+	PushChannelUpstream: pushSubscribersApps,
+	PushChannelWebPush:  pushSubscribersBrowsers,
+}
+
+// publishToPushChannels sends the message to the push channels that deliver it to subscribers that are not
+// connected to the server: Firebase, web push, and the upstream server (poll request). The flags define which
+// channels may be used for this message.
+//
+// By default, the message is sent to all channels at the same time. If push-fallback is set, the configured channels
+// are tried one after another (in the background), until one of them works. This is decided separately for each type
+// of subscriber, see publishToPushFallback. Each attempt is recorded in the delivery log of the message, so publishers
+// can see which channel worked.
+func (s *Server) publishToPushChannels(v *visitor, m *message, firebase, webpush, upstream bool) {
+	if len(s.config.PushFallback) > 0 {
+		go s.publishToPushFallback(v, m, pushSubscribersApps, firebase, webpush, upstream)
+		go s.publishToPushFallback(v, m, pushSubscribersBrowsers, firebase, webpush, upstream)
+		return
+	}
+	if s.firebaseClient != nil && firebase {
+		s.enqueueFirebase(v, m)
+	}
+	if u := s.upstream(m.Topic); u != nil && upstream {
+		go s.forwardPollRequest(v, m, u)
+	}
+	if s.config.WebPushPublicKey != "" && webpush {
+		go s.publishToWebPushEndpoints(v, m)
+	}
+}
+
+// publishToPushFallback tries the push channels that reach the given type of subscribers in the order defined by
+// push-fallback, until one of them works. Channels that are not available (or not allowed for this message) are skipped.
+//
+// The ladder is walked per subscriber type, since a channel that worked only reached its own subscribers: web push
+// delivering the message to a browser says nothing about the iOS app subscribers that rely on the upstream server.
+// Firebase messages are sent directly, and not via the Firebase queue, since the outcome is needed to decide whether
+// to try the next channel. Sending to a Firebase topic succeeds even if nobody is subscribed, which is why firebase
+// must not be followed by another app channel in push-fallback (see serve command).
+func (s *Server) publishToPushFallback(v *visitor, m *message, subscribers string, firebase, webpush, upstream bool) {
+	for _, channel := range s.config.PushFallback {
+		if pushChannelSubscribers[channel] != subscribers {
+			continue
+		}
+		var err error
+		switch channel {
+		case PushChannelFirebase:
+			if s.firebaseClient == nil || !firebase {
+				continue
+			}
+			err = s.sendToFirebase(v, m)
+		case PushChannelWebPush:
+			if s.config.WebPushPublicKey == "" || !webpush {
+				continue
+			}
+			err = s.publishToWebPushEndpoints(v, m)
+		case PushChannelUpstream:
+			u := s.upstream(m.Topic)
+			if u == nil || !upstream {
+				continue
+			}
+			err = s.forwardPollRequest(v, m, u)
+		default:
+			continue
+		}
+		if err == nil {
+			logvm(v, m).Field("push_channel", channel).Debug("Message delivered to %s via push channel %s", subscribers, channel)
+			return
+		}
+		logvm(v, m).Field("push_channel", channel).Err(err).Debug("Unable to deliver message to %s via push channel %s, trying next channel", subscribers, channel)
+	}
+	logvm(v, m).Debug("Message not delivered to %s via any push channel", subscribers)
+}
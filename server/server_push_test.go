@@ -0,0 +1,124 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestServer_PushFallback_WebPushThenUpstream(t *testing.T) {
+	var webPushReceived, upstreamReceived atomic.Int32
+	pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		webPushReceived.Add(1)
+	}))
+	defer pushService.Close()
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamReceived.Add(1)
+	}))
+	defer upstreamServer.Close()
+
+	c := newTestConfigWithWebPush(t)
+	c.BaseURL = "http://myserver.internal"
+	c.UpstreamBaseURL = upstreamServer.URL
+	c.PushFallback = []string{PushChannelWebPush, PushChannelUpstream}
+	s := newTestServer(t, c)
+	addSubscription(t, s, pushService.URL+"/push-receive", "with-webpush")
+
+	// Web push only reaches browsers, so the message is also forwarded upstream for the apps
+	rr := request(t, s, "PUT", "/with-webpush", "via web push", nil)
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	waitFor(t, func() bool {
+		deliveries, err := s.messageCache.Deliveries(m.ID)
+		require.Nil(t, err)
+		return len(deliveries) == 3 // Subscribers, web push and upstream
+	})
+	deliveries, _ := s.messageCache.Deliveries(m.ID)
+	channels := make(map[string]string)
+	for _, d := range deliveries {
+		channels[d.Channel] = d.Outcome
+	}
+	require.Equal(t, deliveryOutcomeDelivered, channels[deliveryChannelWebPush])
+	require.Equal(t, deliveryOutcomeDelivered, channels[deliveryChannelUpstream])
+	require.Equal(t, int32(1), webPushReceived.Load())
+	require.Equal(t, int32(1), upstreamReceived.Load())
+
+	// No web push subscriptions, so the poll request is only forwarded upstream
+	rr = request(t, s, "PUT", "/without-webpush", "via upstream", nil)
+	require.Equal(t, 200, rr.Code)
+	m = toMessage(t, rr.Body.String())
+	waitFor(t, func() bool {
+		deliveries, err := s.messageCache.Deliveries(m.ID)
+		require.Nil(t, err)
+		return len(deliveries) == 2 // Subscribers and upstream
+	})
+	deliveries, _ = s.messageCache.Deliveries(m.ID)
+	require.Equal(t, deliveryChannelUpstream, deliveries[1].Channel)
+	require.Equal(t, deliveryOutcomeDelivered, deliveries[1].Outcome)
+	require.Equal(t, int32(1), webPushReceived.Load())
+	require.Equal(t, int32(2), upstreamReceived.Load())
+}
+
+func TestServer_PushFallback_UpstreamFailed(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstreamServer.Close()
+
+	c := newTestConfig(t)
+	c.BaseURL = "http://myserver.internal"
+	c.UpstreamBaseURL = upstreamServer.URL
+	c.PushFallback = []string{PushChannelUpstream, PushChannelFirebase}
+	s := newTestServer(t, c)
+
+	// Failed upstream delivery is recorded, Firebase is not configured and skipped
+	rr := request(t, s, "PUT", "/mytopic", "hi", nil)
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	waitFor(t, func() bool {
+		deliveries, err := s.messageCache.Deliveries(m.ID)
+		require.Nil(t, err)
+		return len(deliveries) == 2
+	})
+	deliveries, _ := s.messageCache.Deliveries(m.ID)
+	require.Equal(t, deliveryChannelUpstream, deliveries[1].Channel)
+	require.Equal(t, deliveryOutcomeFailed, deliveries[1].Outcome)
+	require.Equal(t, "upstream server responded with HTTP 429 Too Many Requests", deliveries[1].Error)
+}
+
+func TestServer_PushFallback_UpstreamThenFirebase(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstreamServer.Close()
+
+	c := newTestConfig(t)
+	c.BaseURL = "http://myserver.internal"
+	c.UpstreamBaseURL = upstreamServer.URL
+	c.PushFallback = []string{PushChannelUpstream, PushChannelFirebase}
+	s := newTestServer(t, c)
+	sender := newTestFirebaseSender(10)
+	s.firebaseClient = newFirebaseClient(sender, &testAuther{Allow: true})
+
+	// Upstream failed, so the message is sent to Firebase instead
+	rr := request(t, s, "PUT", "/mytopic", "hi", nil)
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	waitFor(t, func() bool {
+		deliveries, err := s.messageCache.Deliveries(m.ID)
+		require.Nil(t, err)
+		return len(deliveries) == 3 // Subscribers, upstream and Firebase
+	})
+	deliveries, _ := s.messageCache.Deliveries(m.ID)
+	require.Equal(t, deliveryChannelUpstream, deliveries[1].Channel)
+	require.Equal(t, deliveryOutcomeFailed, deliveries[1].Outcome)
+	require.Equal(t, deliveryChannelFirebase, deliveries[2].Channel)
+	require.Equal(t, deliveryOutcomeDelivered, deliveries[2].Outcome)
+	require.Equal(t, 1, len(sender.Messages()))
+	require.Equal(t, "mytopic", sender.Messages()[0].Topic)
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"net/mail"
+	"time"
+)
+
+// Quotas for which warnings are sent, see visitor.QuotaWarningThreshold
+const (
+	quotaMessages            = "messages"
+	quotaEmails              = "emails"
+	quotaAttachmentTotalSize = "attachment_total_size"
+)
+
+const (
+	quotaWarningTag            = "warning"
+	quotaWarningPriorityNormal = 3
+	quotaWarningPriorityHigh   = 4
+)
+
+var (
+	// quotaWarningThresholds are the usage percentages at which a quota warning is sent
+	quotaWarningThresholds = []int{80, 100}
+)
+
+// changeQuotaWarningPrefs validates and applies the quota warning settings. An empty topic or email
+// removes the respective setting. Users can only send quota warnings to topics they can publish to.
+func (s *Server) changeQuotaWarningPrefs(u *user.User, prefs *user.Prefs, newPrefs *user.QuotaWarningPrefs) error {
+	quotaWarning := &user.QuotaWarningPrefs{}
+	if prefs.QuotaWarning != nil {
+		*quotaWarning = *prefs.QuotaWarning
+	}
+	if newPrefs.Topic != nil {
+		if *newPrefs.Topic == "" {
+			quotaWarning.Topic = nil
+		} else if !topicRegex.MatchString(*newPrefs.Topic) {
+			return errHTTPBadRequestTopicInvalid
+		} else if util.Contains(s.config.DisallowedTopics, *newPrefs.Topic) {
+			return errHTTPBadRequestTopicDisallowed
+		} else if err := s.userManager.Authorize(u, *newPrefs.Topic, user.PermissionWrite); err != nil {
+			return errHTTPForbidden
+		} else {
+			quotaWarning.Topic = newPrefs.Topic
+		}
+	}
+	if newPrefs.Email != nil {
+		if *newPrefs.Email == "" {
+			quotaWarning.Email = nil
+		} else if s.smtpSender == nil {
+			return errHTTPBadRequestEmailDisabled
+		} else if _, err := mail.ParseAddress(*newPrefs.Email); err != nil {
+			return errHTTPBadRequest.Wrap("invalid e-mail address")
+		} else {
+			quotaWarning.Email = newPrefs.Email
+		}
+	}
+	if quotaWarning.Topic == nil && quotaWarning.Email == nil {
+		prefs.QuotaWarning = nil
+	} else {
+		prefs.QuotaWarning = quotaWarning
+	}
+	return nil
+}
+
+// maybeSendDailyQuotaWarnings checks the visitor's daily message (and email) usage, and sends a
+// quota warning if a warning threshold was crossed
+func (s *Server) maybeSendDailyQuotaWarnings(v *visitor, emails bool) {
+	if !quotaWarningsEnabled(v.User()) {
+		return
+	}
+	stats, limits := v.Stats(), v.Limits()
+	s.maybeSendQuotaWarning(v, quotaMessages, stats.Messages, limits.MessageLimit)
+	if emails {
+		s.maybeSendQuotaWarning(v, quotaEmails, stats.Emails, limits.EmailLimit)
+	}
+}
+
+// maybeSendQuotaWarning sends a quota warning to the user's quota warning topic and/or email address,
+// if the usage of the given quota crossed a warning threshold (see quotaWarningThresholds)
+func (s *Server) maybeSendQuotaWarning(v *visitor, quota string, used, limit int64) {
+	u := v.User()
+	if !quotaWarningsEnabled(u) {
+		return
+	}
+	threshold := v.QuotaWarningThreshold(quota, used, limit)
+	if threshold == 0 {
+		return
+	}
+	prefs := u.Prefs.QuotaWarning
+	logv(v).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"quota":           quota,
+			"quota_used":      used,
+			"quota_limit":     limit,
+			"quota_threshold": threshold,
+		}).
+		Debug("User %s crossed %d%% of %s quota, sending quota warning", u.Name, threshold, quota)
+	if prefs.Topic != nil {
+		m := s.newQuotaWarningMessage(userLanguage(u), *prefs.Topic, quota, threshold, used, limit)
+		go s.publishServerMessage(v, m)
+	}
+	if prefs.Email != nil && s.smtpSender != nil {
+		topic := ""
+		if prefs.Topic != nil {
+			topic = *prefs.Topic
+		}
+		m := s.newQuotaWarningMessage(userLanguage(u), topic, quota, threshold, used, limit)
+		go s.sendEmail(v, m, *prefs.Email)
+	}
+}
+
+// publishServerMessage publishes a server-generated message, e.g. a quota warning, to its topic. Since the
+// message was not published by the user, it does not count towards the user's quota. Like any other message, it
+// is subject to the maintenance windows and routing rules of the topic.
+func (s *Server) publishServerMessage(v *visitor, m *message) {
+	t, err := s.topicFromID(m.Topic)
+	if err != nil {
+		logvm(v, m).Err(err).Warn("Cannot publish server message")
+		return
+	}
+	suppressed, err := s.applyMaintenanceWindows(m)
+	if err != nil {
+		logvm(v, m).Err(err).Warn("Cannot publish server message")
+		return
+	}
+	route, err := s.routeMessage(m)
+	if err != nil {
+		logvm(v, m).Err(err).Warn("Cannot publish server message")
+		return
+	}
+	if suppressed {
+		logvm(v, m).Tag(tagPublish).Info("Server message suppressed by maintenance window, not delivering it")
+	} else {
+		if err := t.Publish(v, m); err != nil {
+			logvm(v, m).Err(err).Warn("Cannot publish server message")
+			return
+		}
+		push := route == nil || route.push
+		s.publishToPushChannels(v, m, push, push, false)
+		if route != nil {
+			s.sendRoutedMessage(nil, m, route, "", "")
+		}
+	}
+	if s.config.CacheDuration > 0 {
+		m.Expires = time.Unix(m.Time, 0).Add(v.Limits().MessageExpiryDuration).Unix()
+		if err := s.messageCache.AddMessage(m); err != nil {
+			logvm(v, m).Err(err).Warn("Cannot add server message to cache")
+		}
+	}
+}
+
+// newQuotaWarningMessage creates the quota warning message in the given language, see translate
+func (s *Server) newQuotaWarningMessage(lang, topic, quota string, threshold int, used, limit int64) *message {
+	var title, body string
+	resetIn := time.Until(util.NextOccurrenceUTC(s.config.VisitorStatsResetTime, time.Now())).Round(time.Minute)
+	switch quota {
+	case quotaMessages, quotaEmails:
+		title = translate(lang, "quota_warning_"+quota+"_title", threshold)
+		body = translate(lang, "quota_warning_"+quota+"_body", used, limit, resetIn)
+	case quotaAttachmentTotalSize:
+		title = translate(lang, "quota_warning_"+quota+"_title", threshold)
+		body = translate(lang, "quota_warning_"+quota+"_body", util.FormatSize(used), util.FormatSize(limit))
+	}
+	m := newDefaultMessage(topic, body)
+	m.Title = title
+	m.Tags = []string{quotaWarningTag}
+	m.Priority = quotaWarningPriorityNormal
+	if threshold >= 100 {
+		m.Priority = quotaWarningPriorityHigh
+	}
+	return m
+}
+
+func quotaWarningsEnabled(u *user.User) bool {
+	return u != nil && u.Prefs != nil && u.Prefs.QuotaWarning != nil
+}
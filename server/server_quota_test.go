@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_QuotaWarning_Messages(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:         "test",
+		MessageLimit: 10,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "test"))
+
+	rr := request(t, s, "PATCH", "/v1/account/settings", `{"quota_warning": {"topic": "alerts"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, util.String("alerts"), account.QuotaWarning.Topic)
+	require.Nil(t, account.QuotaWarning.Email)
+
+	// Publish 7 messages, no warning yet
+	for i := 0; i < 7; i++ {
+		rr = request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+	}
+	time.Sleep(200 * time.Millisecond)
+	rr = request(t, s, "GET", "/alerts/json?poll=1", "", nil)
+	require.Equal(t, "", rr.Body.String())
+
+	// 8th message crosses 80%
+	rr = request(t, s, "PUT", "/mytopic", "message 8", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	waitFor(t, func() bool {
+		rr = request(t, s, "GET", "/alerts/json?poll=1", "", nil)
+		return rr.Body.String() != ""
+	})
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "80% of daily message quota used", messages[0].Title)
+	require.Contains(t, messages[0].Message, "You have published 8 of 10 messages today")
+	require.Equal(t, []string{"warning"}, messages[0].Tags)
+	require.Equal(t, 3, messages[0].Priority)
+
+	// 10th message reaches 100%, 11th message is rejected without another warning
+	for i := 9; i <= 11; i++ {
+		rr = request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+	}
+	require.Equal(t, 429, rr.Code)
+	require.Equal(t, 42908, toHTTPError(t, rr.Body.String()).Code)
+	waitFor(t, func() bool {
+		rr = request(t, s, "GET", "/alerts/json?poll=1", "", nil)
+		return len(toMessages(t, rr.Body.String())) == 2
+	})
+	messages = toMessages(t, rr.Body.String())
+	require.Equal(t, "100% of daily message quota used", messages[1].Title)
+	require.Contains(t, messages[1].Message, "You have published 10 of 10 messages today")
+	require.Equal(t, 4, messages[1].Priority)
+}
+
+func TestServer_QuotaWarning_Messages_Translated(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:         "test",
+		MessageLimit: 5,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "test"))
+
+	rr := request(t, s, "PATCH", "/v1/account/settings", `{"language": "de", "quota_warning": {"topic": "alerts"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	for i := 0; i < 4; i++ {
+		rr = request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+	}
+	waitFor(t, func() bool {
+		rr = request(t, s, "GET", "/alerts/json?poll=1", "", nil)
+		return rr.Body.String() != ""
+	})
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "80% des täglichen Nachrichten-Kontingents verbraucht", messages[0].Title)
+	require.Contains(t, messages[0].Message, "Du hast heute 4 von 5 Nachrichten veröffentlicht")
+}
+
+func TestServer_QuotaWarning_Email(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.SMTPSenderAddr = "127.0.0.1:25"
+	c.SMTPSenderFrom = "ntfy@ntfy.sh"
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:         "test",
+		MessageLimit: 100,
+		EmailLimit:   5,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "test"))
+
+	rr := request(t, s, "PATCH", "/v1/account/settings", `{"quota_warning": {"email": "phil@example.com"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// 4 emails (80%) trigger a warning, 5 emails (100%) trigger another one
+	for i := 0; i < 5; i++ {
+		rr = request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+			"E-Mail":        "someone@example.com",
+		})
+		require.Equal(t, 200, rr.Code)
+	}
+	waitFor(t, func() bool {
+		return mailer.Count() == 7 // 5 emails + 2 warnings
+	})
+}
+
+func TestServer_QuotaWarning_ChangeSettings_Invalid(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionReadWrite
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "not-mine", user.PermissionRead))
+
+	rr := request(t, s, "PATCH", "/v1/account/settings", `{"quota_warning": {"topic": "invalid topic"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40009, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "PATCH", "/v1/account/settings", `{"quota_warning": {"topic": "not-mine"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	rr = request(t, s, "PATCH", "/v1/account/settings", `{"quota_warning": {"email": "phil@example.com"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40001, toHTTPError(t, rr.Body.String()).Code)
+
+	// Set and clear again
+	rr = request(t, s, "PATCH", "/v1/account/settings", `{"quota_warning": {"topic": "alerts"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PATCH", "/v1/account/settings", `{"quota_warning": {"topic": ""}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.False(t, strings.Contains(rr.Body.String(), "quota_warning"))
+}
+
+func TestVisitor_QuotaWarningThreshold(t *testing.T) {
+	v := newVisitor(newTestConfig(t), newMemTestCache(t), nil, netip.MustParseAddr("1.2.3.4"), nil)
+	require.Equal(t, 0, v.QuotaWarningThreshold(quotaMessages, 7, 10))
+	require.Equal(t, 80, v.QuotaWarningThreshold(quotaMessages, 8, 10))
+	require.Equal(t, 0, v.QuotaWarningThreshold(quotaMessages, 9, 10))
+	require.Equal(t, 100, v.QuotaWarningThreshold(quotaMessages, 10, 10))
+	require.Equal(t, 0, v.QuotaWarningThreshold(quotaMessages, 10, 10))
+	require.Equal(t, 80, v.QuotaWarningThreshold(quotaEmails, 4, 5))   // Independent quota
+	require.Equal(t, 0, v.QuotaWarningThreshold(quotaMessages, 0, 10)) // Reset
+	require.Equal(t, 100, v.QuotaWarningThreshold(quotaMessages, 10, 10))
+	require.Equal(t, 0, v.QuotaWarningThreshold(quotaMessages, 1, 0)) // No limit
+}
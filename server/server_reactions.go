@@ -0,0 +1,112 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	reactionMaxLength = 32 // Max length of a reaction in bytes, enough for emojis with skin tone and ZWJ sequences
+	reactionMaxRunes  = 8
+)
+
+var (
+	reactionShortCodeRegex = regexp.MustCompile(`^[-_+a-z0-9]{1,32}$`) // e.g. +1, white_check_mark, eyes
+)
+
+// handleMessageReaction adds (PUT) or removes (DELETE) a reaction of the visitor to a message, and publishes the
+// aggregated reactions to all subscribers of the topic as a "reactions" event. Each user (or IP address, for
+// anonymous visitors) can react with each emoji only once.
+func (s *Server) handleMessageReaction(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := messageReactionPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 4 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topicID, id, emoji := matches[1], matches[2], matches[3]
+	if !validReaction(emoji) {
+		return errHTTPBadRequestReactionInvalid
+	}
+	m, err := s.cachedMessage(topicID, id)
+	if err != nil {
+		return err
+	}
+	if r.Method == http.MethodDelete {
+		err = s.messageCache.RemoveReaction(m.ID, emoji, v.Identity())
+	} else {
+		err = s.messageCache.AddReaction(m.ID, emoji, v.Identity())
+	}
+	if err != nil {
+		return err
+	}
+	counts, err := s.messageCache.Reactions(m.ID)
+	if err != nil {
+		return err
+	}
+	t, err := s.topicFromID(topicID)
+	if err != nil {
+		return err
+	}
+	if err := t.Publish(v, newReactionsMessage(topicID, m.ID, counts)); err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiMessageReactionsResponse{
+		ID:        m.ID,
+		Topic:     m.Topic,
+		Reactions: counts,
+	})
+}
+
+// handleMessageReactionsGet returns the aggregated reactions to a message
+func (s *Server) handleMessageReactionsGet(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	matches := messageReactionsPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	m, err := s.cachedMessage(matches[1], matches[2])
+	if err != nil {
+		return err
+	}
+	counts, err := s.messageCache.Reactions(m.ID)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiMessageReactionsResponse{
+		ID:        m.ID,
+		Topic:     m.Topic,
+		Reactions: counts,
+	})
+}
+
+// cachedMessage returns the cached message with the given ID, or errHTTPNotFoundMessage if it does
+// not exist, or if it belongs to a different topic
+func (s *Server) cachedMessage(topic, id string) (*message, error) {
+	m, err := s.messageCache.Message(id)
+	if errors.Is(err, errMessageNotFound) || (err == nil && m.Topic != topic) {
+		return nil, errHTTPNotFoundMessage
+	} else if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// validReaction returns true if the given string is an emoji short code (e.g. +1 or eyes), or a short string of
+// non-ASCII characters that contains at least one symbol (e.g. 👍 or 👍🏽). Emojis are not validated against the
+// Unicode emoji list.
+func validReaction(s string) bool {
+	if s == "" || len(s) > reactionMaxLength || !utf8.ValidString(s) {
+		return false
+	} else if reactionShortCodeRegex.MatchString(s) {
+		return true
+	}
+	symbol := false
+	for _, r := range s {
+		if r < utf8.RuneSelf || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+		symbol = symbol || unicode.IsSymbol(r)
+	}
+	return symbol && utf8.RuneCountInString(s) <= reactionMaxRunes
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_MessageReactions(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "disk full", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	subscribeResponse := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/json", subscribeResponse)
+
+	response = request(t, s, "PUT", "/mytopic/"+m.ID+"/reactions/👍", "", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic/"+m.ID+"/reactions/👍", "", nil, func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4"
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic/"+m.ID+"/reactions/eyes", "", nil)
+	require.Equal(t, 200, response.Code)
+	reactions, err := util.UnmarshalJSON[apiMessageReactionsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, m.ID, reactions.ID)
+	require.Equal(t, map[string]int{"👍": 2, "eyes": 1}, reactions.Reactions)
+
+	response = request(t, s, "DELETE", "/mytopic/"+m.ID+"/reactions/eyes", "", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/reactions", "", nil)
+	require.Equal(t, 200, response.Code)
+	reactions, err = util.UnmarshalJSON[apiMessageReactionsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, map[string]int{"👍": 2}, reactions.Reactions)
+
+	// Subscribers receive the aggregated reactions as "reactions" events; events are sent asynchronously,
+	// so they may arrive in any order
+	subscribeCancel()
+	events := make([]*message, 0)
+	for _, line := range strings.Split(strings.TrimSpace(subscribeResponse.Body.String()), "\n") {
+		var event message
+		require.Nil(t, json.Unmarshal([]byte(line), &event))
+		if event.Event == reactionsEvent {
+			events = append(events, &event)
+		}
+	}
+	require.Equal(t, 4, len(events))
+	require.Equal(t, "mytopic", events[0].Topic)
+	require.Equal(t, m.ID, events[0].Reactions.MessageID)
+}
+
+func TestServer_MessageReactions_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", "disk full", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "PUT", "/mytopic/"+m.ID+"/reactions/not%20an%20emoji", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40051, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/mytopic/abcdefghijkl/reactions/eyes", "", nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "PUT", "/othertopic/"+m.ID+"/reactions/eyes", "", nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "GET", "/othertopic/"+m.ID+"/reactions", "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_MessageReactions_AccessControl(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionRead))
+
+	response := request(t, s, "PUT", "/mytopic", "disk full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Reacting requires write access, listing reactions requires read access
+	response = request(t, s, "PUT", "/mytopic/"+m.ID+"/reactions/+1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "PUT", "/mytopic/"+m.ID+"/reactions/+1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/reactions", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, `{"id":"`+m.ID+`","topic":"mytopic","reactions":{"+1":1}}`+"\n", response.Body.String())
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/reactions", "", nil)
+	require.Equal(t, 403, response.Code)
+}
+
+func TestValidReaction(t *testing.T) {
+	require.True(t, validReaction("👍"))
+	require.True(t, validReaction("👍🏽"))
+	require.True(t, validReaction("👨‍👩‍👧"))
+	require.True(t, validReaction("+1"))
+	require.True(t, validReaction("white_check_mark"))
+	require.False(t, validReaction(""))
+	require.False(t, validReaction("Hello"))
+	require.False(t, validReaction("👍 👍"))
+	require.False(t, validReaction(strings.Repeat("👍", 9)))
+	require.False(t, validReaction("​")) // Zero-width space
+	require.False(t, validReaction("日本"))
+}
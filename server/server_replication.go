@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	replicationRequestTimeout = 30 * time.Second
+)
+
+// handleUsersChangesGet returns all users, groups and tiers that were changed after the given position in the user
+// change log, including the password hashes, access control entries, tokens and publish keys of the users. It is
+// polled by standby servers, see runUserReplicator.
+func (s *Server) handleUsersChangesGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	var since int64
+	if sinceStr := readQueryParam(r, "since"); sinceStr != "" {
+		var err error
+		since, err = strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil || since < 0 {
+			return errHTTPBadRequestSinceInvalid
+		}
+	}
+	changes, err := s.userManager.Changes(since)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, changes)
+}
+
+// runUserReplicator polls the primary server (auth-replicate-from) for changes to users, access control entries,
+// tokens, publish keys, groups and tiers, and applies them to the local user database. On startup, a full sync
+// is performed.
+func (s *Server) runUserReplicator() {
+	log.Tag(tagReplication).Info("Replicating users from primary server %s every %s", s.config.AuthReplicateFrom, s.config.AuthReplicateInterval)
+	for {
+		if err := s.replicateUsers(); err != nil {
+			log.Tag(tagReplication).Err(err).Warn("Error replicating users from primary server %s", s.config.AuthReplicateFrom)
+		}
+		select {
+		case <-time.After(s.config.AuthReplicateInterval):
+		case <-s.closeChan:
+			return
+		}
+	}
+}
+
+func (s *Server) replicateUsers() error {
+	changesURL := fmt.Sprintf("%s%s?since=%d", s.config.AuthReplicateFrom, apiUsersChangesPath, s.replicationPos)
+	req, err := http.NewRequest(http.MethodGet, changesURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "ntfy/"+s.config.Version)
+	req.Header.Set("Authorization", util.BearerAuth(s.config.AuthReplicateToken))
+	httpClient := &http.Client{
+		Timeout: replicationRequestTimeout,
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary server responded with HTTP %s", resp.Status)
+	}
+	var changes user.Changes
+	if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+		return fmt.Errorf("invalid response from primary server: %w", err)
+	}
+	if changes.Full || len(changes.Users) > 0 || len(changes.Groups) > 0 || len(changes.Tiers) > 0 {
+		if err := s.userManager.ApplyChanges(&changes); err != nil {
+			return err
+		}
+		log.
+			Tag(tagReplication).
+			Fields(log.Context{
+				"replication_position": changes.Position,
+				"replication_full":     changes.Full,
+				"replication_users":    len(changes.Users),
+				"replication_groups":   len(changes.Groups),
+				"replication_tiers":    len(changes.Tiers),
+			}).
+			Debug("Applied changes to %d user(s), %d group(s) and %d tier(s) from primary server", len(changes.Users), len(changes.Groups), len(changes.Tiers))
+	}
+	s.replicationPos = changes.Position
+	return nil
+}
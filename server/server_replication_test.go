@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestServer_UsersChanges(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionRead))
+
+	// Full sync
+	rr := request(t, s, "GET", "/v1/users/changes", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	changes, err := util.UnmarshalJSON[user.Changes](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.True(t, changes.Full)
+	require.Equal(t, 3, len(changes.Users))
+
+	// Nothing changed since then
+	rr = request(t, s, "GET", fmt.Sprintf("/v1/users/changes?since=%d", changes.Position), "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	unchanged, err := util.UnmarshalJSON[user.Changes](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.False(t, unchanged.Full)
+	require.Equal(t, 0, len(unchanged.Users))
+
+	// Failures
+	rr = request(t, s, "GET", "/v1/users/changes?since=abc", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	rr = request(t, s, "GET", "/v1/users/changes", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "GET", "/v1/users/changes", "", nil)
+	require.Equal(t, 401, rr.Code)
+}
+
+func TestServer_ReplicateUsers(t *testing.T) {
+	primary := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer primary.closeDatabases()
+	primaryServer := httptest.NewServer(http.HandlerFunc(primary.handle))
+	defer primaryServer.Close()
+
+	require.Nil(t, primary.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, primary.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, primary.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	require.Nil(t, primary.userManager.AddTier(&user.Tier{Code: "pro", Name: "Pro", MessageLimit: 1000}))
+	require.Nil(t, primary.userManager.ChangeTier("ben", "pro"))
+	require.Nil(t, primary.userManager.AddGroup("ops"))
+	require.Nil(t, primary.userManager.AddGroupMembers("ops", "ben"))
+	require.Nil(t, primary.userManager.AllowGroupAccess("ops", "alerts", user.PermissionReadWrite))
+	phil, err := primary.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := primary.userManager.CreateToken(phil.ID, "replication", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.AuthReplicateFrom = primaryServer.URL
+	conf.AuthReplicateToken = token.Value
+	standby := newTestServer(t, conf)
+	defer standby.closeDatabases()
+
+	// Full sync
+	require.Nil(t, standby.replicateUsers())
+	require.True(t, standby.replicationPos > 0)
+	rr := request(t, standby, "PUT", "/mytopic", "hi from the standby", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, standby, "PUT", "/alerts", "hi via group", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, standby, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+	})
+	require.Equal(t, 200, rr.Code)
+	ben, err := standby.userManager.User("ben")
+	require.Nil(t, err)
+	require.Equal(t, "pro", ben.Tier.Code)
+	require.Equal(t, int64(1000), ben.Tier.MessageLimit)
+
+	// Incremental sync
+	require.Nil(t, primary.userManager.ResetAccess("ben", "mytopic"))
+	require.Nil(t, primary.userManager.AddUser("emma", "emma", user.RoleUser))
+	require.Nil(t, standby.replicateUsers())
+	rr = request(t, standby, "PUT", "/mytopic", "hi again", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+	_, err = standby.userManager.Authenticate("emma", "emma")
+	require.Nil(t, err)
+
+	// Invalid token
+	standby.config.AuthReplicateToken = "tk_doesnotexistdoesnotexist12345"
+	require.ErrorContains(t, standby.replicateUsers(), "HTTP 401")
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"net/http"
+	"net/netip"
+
+	"heckel.io/ntfy/v2/user"
+)
+
+// messageRoute defines the delivery channels of a message published to a reserved topic, as defined by the
+// routing rules of the reservation (see user.RoutingRule). E-mails and calls are charged to the owner of the reservation.
+type messageRoute struct {
+	push  bool
+	email string
+	call  string
+	owner *visitor
+}
+
+// routeMessage evaluates the routing rules of the reservation covering the message's topic. It returns nil if the
+// topic is not reserved, or if no rule matches the priority of the message, in which case the message is delivered
+// as usual.
+func (s *Server) routeMessage(m *message) (*messageRoute, error) {
+	if s.userManager == nil || m.Event != messageEvent || m.PollID != "" {
+		return nil, nil
+	}
+	rules, ownerUserID, err := s.userManager.ReservationRouting(m.Topic)
+	if err != nil {
+		return nil, err
+	} else if len(rules) == 0 {
+		return nil, nil
+	}
+	priority := messagePriority(m)
+	for _, rule := range rules {
+		for _, p := range rule.Priorities {
+			if p != priority {
+				continue
+			}
+			owner, err := s.userManager.UserByID(ownerUserID)
+			if err != nil {
+				return nil, err
+			}
+			return &messageRoute{
+				push:  rule.Push,
+				email: rule.Email,
+				call:  rule.Call,
+				owner: s.visitor(netip.IPv4Unspecified(), owner),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// validateRoutingRules checks the routing rules of a reservation request, and converts them to the rules stored
+// in the user database. Each priority may only be routed once, e-mails and calls must be enabled, and phone numbers
+// must be verified by the user.
+func (s *Server) validateRoutingRules(u *user.User, rules []*apiRoutingRule) ([]*user.RoutingRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	priorities := make(map[int]bool)
+	routing := make([]*user.RoutingRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule == nil || len(rule.Priorities) == 0 {
+			return nil, errHTTPBadRequestRoutingInvalid
+		}
+		for _, p := range rule.Priorities {
+			if p < 1 || p > 5 || priorities[p] {
+				return nil, errHTTPBadRequestRoutingInvalid
+			}
+			priorities[p] = true
+		}
+		if rule.Email != "" && s.smtpSender == nil {
+			return nil, errHTTPBadRequestEmailDisabled
+		}
+		call := rule.Call
+		if call != "" {
+			if s.config.TwilioAccount == "" {
+				return nil, errHTTPBadRequestPhoneCallsDisabled
+			} else if !isBoolValue(call) && !phoneNumberRegex.MatchString(call) {
+				return nil, errHTTPBadRequestPhoneNumberInvalid
+			}
+			var httpErr *errHTTP
+			call, httpErr = s.convertPhoneNumber(u, call)
+			if httpErr != nil {
+				return nil, httpErr
+			}
+		}
+		routing = append(routing, &user.RoutingRule{
+			Priorities: rule.Priorities,
+			Push:       rule.Push,
+			Email:      rule.Email,
+			Call:       call,
+		})
+	}
+	return routing, nil
+}
+
+func toAPIRoutingRules(rules []*user.RoutingRule) []*apiRoutingRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	apiRules := make([]*apiRoutingRule, 0, len(rules))
+	for _, rule := range rules {
+		apiRules = append(apiRules, &apiRoutingRule{
+			Priorities: rule.Priorities,
+			Push:       rule.Push,
+			Email:      rule.Email,
+			Call:       rule.Call,
+		})
+	}
+	return apiRules
+}
+
+// sendRoutedMessage sends the e-mail and makes the phone call defined by the route, unless the publisher already
+// requested the same e-mail or call. E-mails and calls count against the limits of the owner of the reservation.
+// The request may be nil, e.g. for server messages.
+func (s *Server) sendRoutedMessage(r *http.Request, m *message, route *messageRoute, email, call string) {
+	logEvent := logvm(route.owner, m)
+	if r != nil {
+		logEvent = logvrm(route.owner, r, m)
+	}
+	if s.smtpSender != nil && route.email != "" && route.email != email {
+		if route.owner.EmailAllowed() {
+			go s.sendEmail(route.owner, m, route.email)
+		} else {
+			logEvent.Tag(tagEmail).Field("email", route.email).Info("Not sending routed email, owner of the reservation exceeded the email limit")
+		}
+	}
+	if s.config.TwilioAccount != "" && route.call != "" && route.call != call {
+		if route.owner.CallAllowed() {
+			go s.callPhone(route.owner, r, m, route.call)
+		} else {
+			logEvent.Tag(tagTwilio).Field("twilio_to", route.call).Info("Not making routed call, owner of the reservation exceeded the call limit")
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	scheduleMaxMembers = 20
+	scheduleMinShift   = time.Hour
+)
+
+func (s *Server) handleAccountScheduleGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.reservedTopicFromSchedulePath(r, v)
+	if err != nil {
+		return err
+	}
+	schedule, _, err := s.userManager.ReservationSchedule(topic)
+	if err != nil {
+		return err
+	} else if schedule == nil {
+		return errHTTPNotFoundSchedule
+	}
+	return s.writeJSON(w, toAPISchedule(schedule))
+}
+
+func (s *Server) handleAccountScheduleChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.reservedTopicFromSchedulePath(r, v)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiSchedule](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	u := v.User()
+	schedule, err := s.validateSchedule(u, req)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"topic":            topic,
+			"schedule_shift":   schedule.Shift.String(),
+			"schedule_members": len(schedule.Members),
+		}).
+		Debug("Changing on-call schedule for topic %s", topic)
+	if err := s.userManager.ChangeReservationSchedule(u.Name, topic, schedule); err != nil {
+		return err
+	}
+	return s.writeJSON(w, toAPISchedule(schedule))
+}
+
+func (s *Server) handleAccountScheduleDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.reservedTopicFromSchedulePath(r, v)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.ChangeReservationSchedule(v.User().Name, topic, nil); err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Deleted on-call schedule for topic %s", topic)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// reservedTopicFromSchedulePath extracts the topic from the schedule path, and checks that the
+// logged-in user owns the reservation for it. Prefix reservations (e.g. "myteam-*") are allowed.
+func (s *Server) reservedTopicFromSchedulePath(r *http.Request, v *visitor) (string, error) {
+	matches := apiAccountReservationScheduleRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return "", errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	if !topicRegex.MatchString(topic) && !user.AllowedTopicPrefix(topic) {
+		return "", errHTTPBadRequestTopicInvalid
+	}
+	authorized, err := s.userManager.HasReservation(v.User().Name, topic)
+	if err != nil {
+		return "", err
+	} else if !authorized {
+		return "", errHTTPUnauthorized
+	}
+	return topic, nil
+}
+
+// onCallMember returns the member of the on-call schedule of the given topic's reservation who is currently
+// on call, or nil if the topic has no schedule
+func (s *Server) onCallMember(topic string) (*user.ScheduleMember, error) {
+	schedule, _, err := s.userManager.ReservationSchedule(topic)
+	if err != nil {
+		return nil, err
+	} else if schedule == nil {
+		return nil, nil
+	}
+	return schedule.OnCall(time.Now()), nil
+}
+
+// validateSchedule checks an on-call schedule request, and converts it to the schedule stored in the user database.
+// E-mails and calls must be enabled, and phone numbers must be verified, either by the member (if the member is
+// a user), or by the owner of the reservation.
+func (s *Server) validateSchedule(u *user.User, schedule *apiSchedule) (*user.Schedule, error) {
+	if len(schedule.Members) == 0 || len(schedule.Members) > scheduleMaxMembers {
+		return nil, errHTTPBadRequestScheduleInvalid
+	}
+	shift, err := util.ParseDuration(schedule.Shift)
+	if err != nil || shift < scheduleMinShift {
+		return nil, errHTTPBadRequestScheduleInvalid
+	}
+	start := schedule.Start
+	if start == 0 {
+		start = time.Now().Unix()
+	}
+	members := make([]*user.ScheduleMember, 0, len(schedule.Members))
+	for _, member := range schedule.Members {
+		if member == nil || member.Name == "" {
+			return nil, errHTTPBadRequestScheduleInvalid
+		}
+		caller := u
+		if member.User != "" {
+			caller, err = s.userManager.User(member.User)
+			if err != nil {
+				return nil, errHTTPBadRequestScheduleInvalid
+			}
+		}
+		if member.Email != "" && s.smtpSender == nil {
+			return nil, errHTTPBadRequestEmailDisabled
+		}
+		call := member.Call
+		if call != "" {
+			if s.config.TwilioAccount == "" {
+				return nil, errHTTPBadRequestPhoneCallsDisabled
+			} else if !isBoolValue(call) && !phoneNumberRegex.MatchString(call) {
+				return nil, errHTTPBadRequestPhoneNumberInvalid
+			}
+			var httpErr *errHTTP
+			call, httpErr = s.convertPhoneNumber(caller, call)
+			if httpErr != nil {
+				return nil, httpErr
+			}
+		}
+		members = append(members, &user.ScheduleMember{
+			Name:  member.Name,
+			User:  member.User,
+			Email: member.Email,
+			Call:  call,
+		})
+	}
+	return &user.Schedule{
+		Start:   start,
+		Shift:   shift,
+		Members: members,
+	}, nil
+}
+
+func toAPISchedule(schedule *user.Schedule) *apiSchedule {
+	if schedule == nil {
+		return nil
+	}
+	members := make([]*apiScheduleMember, 0, len(schedule.Members))
+	for _, member := range schedule.Members {
+		members = append(members, &apiScheduleMember{
+			Name:  member.Name,
+			User:  member.User,
+			Email: member.Email,
+			Call:  member.Call,
+		})
+	}
+	var onCall string
+	if member := schedule.OnCall(time.Now()); member != nil {
+		onCall = member.Name
+	}
+	return &apiSchedule{
+		Start:   schedule.Start,
+		Shift:   schedule.Shift.String(),
+		Members: members,
+		OnCall:  onCall,
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestAccount_Schedule_CRUD(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+	s.smtpSender = &testMailer{}
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.AddReservation("phil", "alerts-*", user.PermissionDenyAll))
+
+	// No schedule yet, and only the owner of the reservation can access it
+	rr := request(t, s, "GET", "/v1/account/reservation/alerts-*/schedule", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, rr.Code)
+	require.Equal(t, 40407, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/v1/account/reservation/alerts-*/schedule", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Invalid schedules
+	for _, invalid := range []string{
+		`{"shift":"1d","members":[]}`,
+		`{"shift":"10m","members":[{"name":"Phil"}]}`,
+		`{"shift":"1d","members":[{"email":"phil@example.com"}]}`,
+		`{"shift":"1d","members":[{"name":"Kate","user":"kate"}]}`,
+	} {
+		rr = request(t, s, "PUT", "/v1/account/reservation/alerts-*/schedule", invalid, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code)
+		require.Equal(t, 40060, toHTTPError(t, rr.Body.String()).Code)
+	}
+
+	// Phone calls are not enabled
+	rr = request(t, s, "PUT", "/v1/account/reservation/alerts-*/schedule", `{"shift":"1d","members":[{"name":"Phil","call":"yes"}]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40032, toHTTPError(t, rr.Body.String()).Code)
+
+	// Create schedule; the second member is on call today
+	start := time.Now().Add(-36 * time.Hour).Unix()
+	body := fmt.Sprintf(`{"start":%d,"shift":"1d","members":[{"name":"Phil","email":"phil@example.com"},{"name":"Ben","user":"ben","email":"ben@example.com"}]}`, start)
+	rr = request(t, s, "PUT", "/v1/account/reservation/alerts-*/schedule", body, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	schedule, _ := util.UnmarshalJSON[apiSchedule](io.NopCloser(rr.Body))
+	require.Equal(t, start, schedule.Start)
+	require.Equal(t, "24h0m0s", schedule.Shift)
+	require.Equal(t, "Ben", schedule.OnCall)
+
+	rr = request(t, s, "GET", "/v1/account/reservation/alerts-*/schedule", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	schedule, _ = util.UnmarshalJSON[apiSchedule](io.NopCloser(rr.Body))
+	require.Equal(t, 2, len(schedule.Members))
+	require.Equal(t, "ben", schedule.Members[1].User)
+	require.Equal(t, "Ben", schedule.OnCall)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, "Ben", account.Reservations[0].Schedule.OnCall)
+
+	// Delete schedule
+	rr = request(t, s, "DELETE", "/v1/account/reservation/alerts-*/schedule", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/v1/account/reservation/alerts-*/schedule", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, rr.Code)
+}
+
+func TestServer_Escalation_OnCall(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		EmailLimit:       10,
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	rr := request(t, s, "POST", "/v1/account/reservation", `{"topic":"alerts","everyone":"write-only","escalation":{"steps":[{"delay":"5m","on_call":true}]}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/account/reservation/alerts/schedule", `{"shift":"7d","members":[{"name":"Ben","email":"ben@example.com"},{"name":"Phil","email":"phil@example.com"}]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Unacknowledged message is sent to the member who is on call
+	rr = request(t, s, "PUT", "/alerts", "server down", map[string]string{"Priority": "urgent"})
+	require.Equal(t, 200, rr.Code)
+	_, err := s.messageCache.db.Exec(`UPDATE escalations SET due = 0`)
+	require.Nil(t, err)
+	require.Nil(t, s.escalateMessages())
+	waitFor(t, func() bool {
+		return mailer.Count() == 1
+	})
+	require.Equal(t, "server down", mailer.Messages()[0].Message)
+	require.Equal(t, []string{"ben@example.com"}, mailer.Recipients())
+}
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"encoding/json"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	scimPasswordLength = 32 // Length of the random password for users created without a password
+)
+
+var (
+	scimFilterUserNameRegex = regexp.MustCompile(`(?i)^userName\s+eq\s+"([^"]+)"$`)
+)
+
+func (s *Server) handleSCIMServiceProviderConfig(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	response := &scimServiceProviderConfigResponse{
+		Schemas:        []string{scimSchemaServiceProviderConfig},
+		Patch:          &scimSupported{Supported: true},
+		Bulk:           &scimSupported{Supported: false},
+		Filter:         &scimFilterSupported{Supported: true, MaxResults: 0},
+		ChangePassword: &scimSupported{Supported: true},
+		Sort:           &scimSupported{Supported: false},
+		ETag:           &scimSupported{Supported: false},
+		AuthenticationSchemes: []*scimAuthenticationScheme{
+			{
+				Type:        "oauthbearertoken",
+				Name:        "OAuth Bearer Token",
+				Description: "Authentication via the static bearer token defined in scim-token",
+			},
+		},
+	}
+	return s.writeSCIMJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleSCIMUsersGet(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	var users []*user.User
+	filter := strings.TrimSpace(r.URL.Query().Get("filter"))
+	if filter != "" {
+		matches := scimFilterUserNameRegex.FindStringSubmatch(filter)
+		if len(matches) != 2 {
+			return errHTTPBadRequestSCIMFilterInvalid
+		}
+		u, err := s.userManager.User(matches[1])
+		if err != nil && err != user.ErrUserNotFound {
+			return err
+		} else if u != nil && scimManagedUser(u) {
+			users = append(users, u)
+		}
+	} else {
+		allUsers, err := s.userManager.Users()
+		if err != nil {
+			return err
+		}
+		for _, u := range allUsers {
+			if scimManagedUser(u) {
+				users = append(users, u)
+			}
+		}
+	}
+	startIndex, err := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 0 {
+		count = len(users)
+	}
+	page := make([]*scimUserResponse, 0)
+	for i := startIndex - 1; i < len(users) && len(page) < count; i++ {
+		page = append(page, s.newSCIMUserResponse(users[i]))
+	}
+	response := &scimListResponse{
+		Schemas:      []string{scimSchemaListResponse},
+		TotalResults: len(users),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+	return s.writeSCIMJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleSCIMUserGet(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	u, err := s.scimUserFromPath(r)
+	if err != nil {
+		return err
+	}
+	return s.writeSCIMJSON(w, http.StatusOK, s.newSCIMUserResponse(u))
+}
+
+func (s *Server) handleSCIMUserCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[scimUserRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	} else if !user.AllowedUsername(req.UserName) {
+		return errHTTPBadRequest.Wrap("userName invalid")
+	}
+	if u, err := s.userManager.User(req.UserName); err != nil && err != user.ErrUserNotFound {
+		return err
+	} else if u != nil {
+		return errHTTPConflictUserExists
+	}
+	password := req.Password
+	if password == "" {
+		password = util.RandomString(scimPasswordLength) // User cannot log in until the password is set
+	}
+	logvr(v, r).Tag(tagSCIM).Info("Creating user %s via SCIM", req.UserName)
+	if err := s.userManager.AddUser(req.UserName, password, user.RoleUser); err == user.ErrUserExists {
+		return errHTTPConflictUserExists
+	} else if err != nil {
+		return err
+	}
+	u, err := s.userManager.User(req.UserName)
+	if err != nil {
+		return err
+	}
+	s.sendWebhookEvent(newWebhookEvent(webhookEventAccountCreated, u))
+	if req.Active != nil && !*req.Active {
+		if err := s.scimChangeActive(r, v, u, false); err != nil {
+			return err
+		}
+		u.Disabled = true
+	}
+	return s.writeSCIMJSON(w, http.StatusCreated, s.newSCIMUserResponse(u))
+}
+
+func (s *Server) handleSCIMUserReplace(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, err := s.scimUserFromPath(r)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[scimUserRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	} else if req.UserName != "" && req.UserName != u.Name {
+		return errHTTPBadRequest.Wrap("renaming users is not supported")
+	}
+	if req.Password != "" {
+		logvr(v, r).Tag(tagSCIM).Info("Changing password for user %s via SCIM", u.Name)
+		if err := s.userManager.ChangePassword(u.Name, req.Password); err != nil {
+			return err
+		}
+	}
+	active := req.Active == nil || *req.Active // Omitted "active" attribute means active, see RFC 7644, section 3.5.1
+	if err := s.scimChangeActive(r, v, u, active); err != nil {
+		return err
+	}
+	u.Disabled = !active
+	return s.writeSCIMJSON(w, http.StatusOK, s.newSCIMUserResponse(u))
+}
+
+func (s *Server) handleSCIMUserPatch(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, err := s.scimUserFromPath(r)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[scimPatchRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	for _, op := range req.Operations {
+		if !strings.EqualFold(op.Op, "replace") && !strings.EqualFold(op.Op, "add") {
+			return errHTTPBadRequestSCIMOperationInvalid
+		}
+		attrs, err := scimPatchAttributes(op)
+		if err != nil {
+			return err
+		}
+		for attr, value := range attrs {
+			switch strings.ToLower(attr) {
+			case "active":
+				active, err := parseSCIMBool(value)
+				if err != nil {
+					return err
+				}
+				if err := s.scimChangeActive(r, v, u, active); err != nil {
+					return err
+				}
+				u.Disabled = !active
+			case "password":
+				var password string
+				if err := json.Unmarshal(value, &password); err != nil || password == "" {
+					return errHTTPBadRequestSCIMOperationInvalid
+				}
+				logvr(v, r).Tag(tagSCIM).Info("Changing password for user %s via SCIM", u.Name)
+				if err := s.userManager.ChangePassword(u.Name, password); err != nil {
+					return err
+				}
+			default:
+				logvr(v, r).Tag(tagSCIM).Debug("Ignoring unsupported SCIM attribute %s for user %s", attr, u.Name)
+			}
+		}
+	}
+	return s.writeSCIMJSON(w, http.StatusOK, s.newSCIMUserResponse(u))
+}
+
+func (s *Server) handleSCIMUserDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, err := s.scimUserFromPath(r)
+	if err != nil {
+		return err
+	}
+	if s.webPush != nil {
+		if err := s.webPush.RemoveSubscriptionsByUserID(u.ID); err != nil {
+			logvr(v, r).Err(err).Warn("Error removing web push subscriptions for %s", u.Name)
+		}
+	}
+	if u.Billing.StripeSubscriptionID != "" && s.payments != nil {
+		logvr(v, r).Tag(s.payments.Name()).Info("Canceling billing subscription for user %s", u.Name)
+		if err := s.payments.CancelSubscription(u.Billing.StripeSubscriptionID); err != nil {
+			return err
+		}
+	}
+	if err := s.maybeRemoveMessagesAndExcessReservations(r, v, u, 0); err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagSCIM).Info("Marking user %s as deleted via SCIM", u.Name)
+	if err := s.userManager.MarkUserRemoved(u); err != nil {
+		return err
+	}
+	s.sendWebhookEvent(newWebhookEvent(webhookEventAccountDeleted, u))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// scimChangeActive enables or disables the given user. When a user is disabled, its access tokens
+// are deleted (see user.Manager), and its web push subscriptions are removed.
+func (s *Server) scimChangeActive(r *http.Request, v *visitor, u *user.User, active bool) error {
+	if u.Disabled == !active {
+		return nil
+	}
+	if active {
+		logvr(v, r).Tag(tagSCIM).Info("Activating user %s via SCIM", u.Name)
+	} else {
+		logvr(v, r).Tag(tagSCIM).Info("Deactivating user %s via SCIM", u.Name)
+	}
+	if err := s.userManager.ChangeDisabled(u.Name, !active); err != nil {
+		return err
+	}
+	if !active && s.webPush != nil {
+		if err := s.webPush.RemoveSubscriptionsByUserID(u.ID); err != nil {
+			logvr(v, r).Err(err).Warn("Error removing web push subscriptions for %s", u.Name)
+		}
+	}
+	return nil
+}
+
+func (s *Server) scimUserFromPath(r *http.Request) (*user.User, error) {
+	matches := scimUserRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return nil, errHTTPInternalErrorInvalidPath
+	}
+	u, err := s.userManager.UserByID(matches[1])
+	if err == user.ErrUserNotFound || (err == nil && !scimManagedUser(u)) {
+		return nil, errHTTPNotFoundUser
+	} else if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *Server) newSCIMUserResponse(u *user.User) *scimUserResponse {
+	return &scimUserResponse{
+		Schemas:  []string{scimSchemaUser},
+		ID:       u.ID,
+		UserName: u.Name,
+		Active:   !u.Disabled,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Location:     s.config.BaseURL + scimUsersPath + "/" + u.ID,
+		},
+	}
+}
+
+func (s *Server) writeSCIMJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// writeSCIMError writes the error in the SCIM error format (see RFC 7644, section 3.12), so that identity providers
+// can display it. The ntfy error code is not part of the format, but it is logged, see handleError.
+func (s *Server) writeSCIMError(w http.ResponseWriter, httpErr *errHTTP) {
+	response := &scimErrorResponse{
+		Schemas:  []string{scimSchemaError},
+		Status:   strconv.Itoa(httpErr.HTTPCode),
+		SCIMType: scimErrorType(httpErr),
+		Detail:   httpErr.Message,
+	}
+	_ = s.writeSCIMJSON(w, httpErr.HTTPCode, response)
+}
+
+// scimErrorType returns the SCIM error type of the error (e.g. "uniqueness"), or an empty string if the error
+// has no matching type
+func scimErrorType(httpErr *errHTTP) string {
+	switch httpErr.Code {
+	case errHTTPConflictUserExists.Code:
+		return "uniqueness"
+	case errHTTPBadRequestSCIMFilterInvalid.Code:
+		return "invalidFilter"
+	case errHTTPBadRequestSCIMOperationInvalid.Code, errHTTPBadRequest.Code:
+		return "invalidValue"
+	}
+	return ""
+}
+
+// scimManagedUser returns true if the user can be managed via SCIM. Only regular users are exposed: the everyone
+// user (*) and users that are marked as deleted do not exist for the identity provider, and admins are managed by
+// the server admin only, so that a leaked SCIM token cannot be used to lock out (or take over) an admin account.
+func scimManagedUser(u *user.User) bool {
+	return u.Role == user.RoleUser && !u.Deleted
+}
+
+// scimPatchAttributes returns the attributes modified by a SCIM patch operation. Identity providers
+// send operations either with a "path" and a plain value (Okta), or without a path and an object
+// value (Microsoft Entra ID).
+func scimPatchAttributes(op *scimPatchOperation) (map[string]json.RawMessage, error) {
+	if op.Path != "" {
+		return map[string]json.RawMessage{op.Path: op.Value}, nil
+	}
+	var attrs map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &attrs); err != nil {
+		return nil, errHTTPBadRequestSCIMOperationInvalid
+	}
+	return attrs, nil
+}
+
+// parseSCIMBool parses a boolean SCIM value. Some identity providers send booleans as
+// strings ("True", "False"), so both are accepted.
+func parseSCIMBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, errHTTPBadRequestSCIMOperationInvalid
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, errHTTPBadRequestSCIMOperationInvalid
+	}
+	return b, nil
+}
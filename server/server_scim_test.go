@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const testSCIMToken = "scim-secret-token-1234"
+
+func TestSCIM_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	rr := request(t, s, "GET", "/scim/v2/Users", "", map[string]string{
+		"Authorization": util.BearerAuth(testSCIMToken),
+	})
+	require.Equal(t, 404, rr.Code)
+}
+
+func TestSCIM_Unauthorized(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithSCIM(t))
+	rr := request(t, s, "GET", "/scim/v2/Users", "", nil)
+	require.Equal(t, 401, rr.Code)
+
+	rr = request(t, s, "GET", "/scim/v2/Users", "", map[string]string{
+		"Authorization": util.BearerAuth("wrong-token"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Regular user credentials are not accepted
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	rr = request(t, s, "GET", "/scim/v2/Users", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+}
+
+func TestSCIM_User_Lifecycle(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithSCIM(t))
+	headers := map[string]string{"Authorization": util.BearerAuth(testSCIMToken)}
+
+	// Create user
+	rr := request(t, s, "POST", "/scim/v2/Users", `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"ben","password":"ben","active":true}`, headers)
+	require.Equal(t, 201, rr.Code)
+	require.Equal(t, "application/scim+json", rr.Header().Get("Content-Type"))
+	created, _ := util.UnmarshalJSON[scimUserResponse](io.NopCloser(rr.Body))
+	require.Equal(t, "ben", created.UserName)
+	require.True(t, created.Active)
+	require.NotEmpty(t, created.ID)
+	require.Equal(t, "http://127.0.0.1:12345/scim/v2/Users/"+created.ID, created.Meta.Location)
+
+	// Create again fails
+	rr = request(t, s, "POST", "/scim/v2/Users", `{"userName":"ben"}`, headers)
+	require.Equal(t, 409, rr.Code)
+	require.Equal(t, "application/scim+json", rr.Header().Get("Content-Type"))
+	require.Equal(t, "uniqueness", toSCIMError(t, rr.Body.String()).SCIMType)
+
+	// Get and list
+	rr = request(t, s, "GET", "/scim/v2/Users/"+created.ID, "", headers)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", `/scim/v2/Users?filter=userName%20eq%20%22ben%22`, "", headers)
+	require.Equal(t, 200, rr.Code)
+	list, _ := util.UnmarshalJSON[scimListResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, list.TotalResults)
+	require.Equal(t, created.ID, list.Resources[0].ID)
+	rr = request(t, s, "GET", `/scim/v2/Users?filter=userName%20eq%20%22nobody%22`, "", headers)
+	list, _ = util.UnmarshalJSON[scimListResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 0, list.TotalResults)
+	rr = request(t, s, "GET", `/scim/v2/Users?filter=emails%20co%20%22example%22`, "", headers)
+	require.Equal(t, 400, rr.Code)
+	scimErr := toSCIMError(t, rr.Body.String())
+	require.Equal(t, []string{"urn:ietf:params:scim:api:messages:2.0:Error"}, scimErr.Schemas)
+	require.Equal(t, "400", scimErr.Status)
+	require.Equal(t, "invalidFilter", scimErr.SCIMType)
+	require.Equal(t, errHTTPBadRequestSCIMFilterInvalid.Message, scimErr.Detail)
+
+	// Log in, then deactivate user (Entra ID style); tokens are gone and login fails
+	rr = request(t, s, "POST", "/v1/account/token", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	token, _ := util.UnmarshalJSON[apiAccountTokenResponse](io.NopCloser(rr.Body))
+
+	rr = request(t, s, "PATCH", "/scim/v2/Users/"+created.ID, `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"Replace","value":{"active":"False"}}]}`, headers)
+	require.Equal(t, 200, rr.Code)
+	patched, _ := util.UnmarshalJSON[scimUserResponse](io.NopCloser(rr.Body))
+	require.False(t, patched.Active)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Reactivate user (Okta style)
+	rr = request(t, s, "PATCH", "/scim/v2/Users/"+created.ID, `{"Operations":[{"op":"replace","path":"active","value":true}]}`, headers)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Replace user, changing the password
+	rr = request(t, s, "PUT", "/scim/v2/Users/"+created.ID, `{"userName":"ben","password":"newpass","active":true}`, headers)
+	require.Equal(t, 200, rr.Code)
+	_, err := s.userManager.Authenticate("ben", "newpass")
+	require.Nil(t, err)
+	rr = request(t, s, "PUT", "/scim/v2/Users/"+created.ID, `{"userName":"renamed"}`, headers)
+	require.Equal(t, 400, rr.Code)
+
+	// Unsupported operation
+	rr = request(t, s, "PATCH", "/scim/v2/Users/"+created.ID, `{"Operations":[{"op":"remove","path":"active"}]}`, headers)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, "invalidValue", toSCIMError(t, rr.Body.String()).SCIMType)
+
+	// Delete user
+	rr = request(t, s, "DELETE", "/scim/v2/Users/"+created.ID, "", headers)
+	require.Equal(t, 204, rr.Code)
+	rr = request(t, s, "GET", "/scim/v2/Users/"+created.ID, "", headers)
+	require.Equal(t, 404, rr.Code)
+	require.Equal(t, "404", toSCIMError(t, rr.Body.String()).Status)
+	_, err = s.userManager.Authenticate("ben", "newpass")
+	require.Equal(t, user.ErrUnauthenticated, err)
+}
+
+func TestSCIM_User_List_Pagination(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithSCIM(t))
+	headers := map[string]string{"Authorization": util.BearerAuth(testSCIMToken)}
+	for i := 0; i < 5; i++ {
+		require.Nil(t, s.userManager.AddUser(fmt.Sprintf("user%d", i), "pass", user.RoleUser))
+	}
+
+	rr := request(t, s, "GET", "/scim/v2/Users?startIndex=2&count=2", "", headers)
+	require.Equal(t, 200, rr.Code)
+	list, _ := util.UnmarshalJSON[scimListResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 5, list.TotalResults) // Everyone user (*) is not included
+	require.Equal(t, 2, list.StartIndex)
+	require.Equal(t, 2, list.ItemsPerPage)
+	require.Equal(t, "user1", list.Resources[0].UserName)
+	require.Equal(t, "user2", list.Resources[1].UserName)
+}
+
+func TestSCIM_User_AdminsNotManaged(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithSCIM(t))
+	headers := map[string]string{"Authorization": util.BearerAuth(testSCIMToken)}
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	admin, err := s.userManager.User("phil")
+	require.Nil(t, err)
+
+	// Admins are not listed, and cannot be read, changed or deleted
+	rr := request(t, s, "GET", "/scim/v2/Users", "", headers)
+	require.Equal(t, 200, rr.Code)
+	list, _ := util.UnmarshalJSON[scimListResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, list.TotalResults)
+	require.Equal(t, "ben", list.Resources[0].UserName)
+	rr = request(t, s, "GET", `/scim/v2/Users?filter=userName%20eq%20%22phil%22`, "", headers)
+	list, _ = util.UnmarshalJSON[scimListResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 0, list.TotalResults)
+	rr = request(t, s, "GET", "/scim/v2/Users/"+admin.ID, "", headers)
+	require.Equal(t, 404, rr.Code)
+	rr = request(t, s, "PATCH", "/scim/v2/Users/"+admin.ID, `{"Operations":[{"op":"replace","path":"active","value":false}]}`, headers)
+	require.Equal(t, 404, rr.Code)
+	rr = request(t, s, "PUT", "/scim/v2/Users/"+admin.ID, `{"userName":"phil","password":"hijacked"}`, headers)
+	require.Equal(t, 404, rr.Code)
+	rr = request(t, s, "DELETE", "/scim/v2/Users/"+admin.ID, "", headers)
+	require.Equal(t, 404, rr.Code)
+
+	// Admin is unchanged
+	u, err := s.userManager.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	require.False(t, u.Disabled)
+}
+
+func TestSCIM_User_Deactivate_RemovesWebPushSubscriptions(t *testing.T) {
+	conf := configureAuth(t, newTestConfigWithWebPush(t))
+	conf.SCIMToken = testSCIMToken
+	s := newTestServer(t, conf)
+	headers := map[string]string{"Authorization": util.BearerAuth(testSCIMToken)}
+
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	u, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	require.Nil(t, s.webPush.UpsertSubscription(testWebPushEndpoint, "kSC3T8aN1JCQxxPdrFLrZg", "BMKKbxdUU_xLS7G1Wh5AN8PvWOjCzkCuKZYb8apcqYrDxjOF_2piggBnoJLQYx9IeSD70fNuwawI3e9Y8m3S3PE", u.ID, netip.MustParseAddr("1.2.3.4"), "", []string{"test-topic"}))
+	requireSubscriptionCount(t, s, "test-topic", 1)
+
+	rr := request(t, s, "PATCH", "/scim/v2/Users/"+u.ID, `{"Operations":[{"op":"replace","path":"active","value":false}]}`, headers)
+	require.Equal(t, 200, rr.Code)
+	requireSubscriptionCount(t, s, "test-topic", 0)
+}
+
+func TestSCIM_ServiceProviderConfig(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithSCIM(t))
+	rr := request(t, s, "GET", "/scim/v2/ServiceProviderConfig", "", map[string]string{
+		"Authorization": util.BearerAuth(testSCIMToken),
+	})
+	require.Equal(t, 200, rr.Code)
+	config, _ := util.UnmarshalJSON[scimServiceProviderConfigResponse](io.NopCloser(rr.Body))
+	require.True(t, config.Patch.Supported)
+	require.False(t, config.Bulk.Supported)
+}
+
+func newTestConfigWithSCIM(t *testing.T) *Config {
+	conf := newTestConfigWithAuthFile(t)
+	conf.SCIMToken = testSCIMToken
+	return conf
+}
+
+func toSCIMError(t *testing.T, s string) *scimErrorResponse {
+	var e scimErrorResponse
+	require.Nil(t, json.NewDecoder(strings.NewReader(s)).Decode(&e))
+	return &e
+}
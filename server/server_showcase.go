@@ -0,0 +1,135 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+var (
+	showcaseTemplate = template.Must(template.New("showcase").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="robots" content="noindex, nofollow">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.Topic}}</title>
+  <style>
+    body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; margin: 0; padding: 12px; color: #222; background: #fff; }
+    h1 { font-size: 1.2em; margin: 0 0 12px 0; }
+    ul { list-style: none; margin: 0; padding: 0; }
+    li { border-left: 4px solid #338574; padding: 6px 10px; margin-bottom: 10px; }
+    li.p4 { border-color: #e8a33d; }
+    li.p5 { border-color: #c0392b; }
+    time, .tags { color: #666; font-size: 0.85em; }
+    .title { font-weight: bold; }
+    .message { white-space: pre-wrap; word-wrap: break-word; }
+  </style>
+</head>
+<body>
+  <h1>{{.Topic}}</h1>
+  {{- if .Messages}}
+  <ul>
+  {{- range .Messages}}
+    <li class="p{{.Priority}}">
+      <time datetime="{{.Date.Format "2006-01-02T15:04:05Z07:00"}}">{{.Date.Format "2006-01-02 15:04 MST"}}</time>
+      {{- if .Title}}
+      <div class="title">{{.Title}}</div>
+      {{- end}}
+      <div class="message">{{.Message}}</div>
+      {{- if .Tags}}
+      <div class="tags">{{range $i, $tag := .Tags}}{{if $i}}, {{end}}{{$tag}}{{end}}</div>
+      {{- end}}
+    </li>
+  {{- end}}
+  </ul>
+  {{- else}}
+  <p>No messages</p>
+  {{- end}}
+</body>
+</html>
+`))
+)
+
+type showcasePage struct {
+	Topic    string
+	Messages []*showcasePageMessage
+}
+
+type showcasePageMessage struct {
+	*apiShowcaseMessage
+	Date time.Time
+}
+
+// handleTopicShowcase returns the newest messages of a showcase topic (see Config.ShowcaseTopics) as JSON
+// (/<topic>/showcase.json) or as a simple HTML page (/<topic>/showcase), so they can be embedded in status pages.
+// The showcase is read-only and does not require read access to the topic. It only includes the newest
+// Config.ShowcaseMessagesLimit messages, and only some message fields, e.g. actions are never included.
+func (s *Server) handleTopicShowcase(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	matches := showcasePathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic, format := matches[1], matches[2]
+	if !s.showcaseTopic(topic) {
+		return errHTTPNotFound
+	}
+	messages, err := s.showcaseMessages(topic)
+	if err != nil {
+		return err
+	}
+	if format == ".json" {
+		return s.writeJSON(w, &apiShowcaseResponse{
+			Topic:    topic,
+			Messages: messages,
+		})
+	}
+	page := &showcasePage{
+		Topic:    topic,
+		Messages: make([]*showcasePageMessage, 0, len(messages)),
+	}
+	for _, m := range messages {
+		page.Messages = append(page.Messages, &showcasePageMessage{
+			apiShowcaseMessage: m,
+			Date:               time.Unix(m.Time, 0).UTC(),
+		})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return showcaseTemplate.Execute(w, page)
+}
+
+// showcaseTopic returns true if the topic matches one of the showcase topics, and is not blocked
+func (s *Server) showcaseTopic(topic string) bool {
+	if s.topicBlocked(topic) {
+		return false
+	}
+	for _, pattern := range s.config.ShowcaseTopics {
+		if topicMatches(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// showcaseMessages returns the newest messages of the topic, newest first
+func (s *Server) showcaseMessages(topic string) ([]*apiShowcaseMessage, error) {
+	messages, err := s.messageCache.MessagesNewest(topic, s.config.ShowcaseMessagesLimit)
+	if err != nil {
+		return nil, err
+	}
+	showcase := make([]*apiShowcaseMessage, 0, len(messages))
+	for _, m := range messages {
+		sm := &apiShowcaseMessage{
+			ID:       m.ID,
+			Time:     m.Time,
+			Title:    m.Title,
+			Priority: messagePriority(m),
+			Tags:     m.Tags,
+		}
+		if m.Encoding == "" {
+			sm.Message = m.Message // Binary messages are not shown
+		}
+		showcase = append(showcase, sm)
+	}
+	return showcase, nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"testing"
+)
+
+func TestServer_Showcase_JSON(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	c.ShowcaseTopics = []string{"status-*"}
+	c.ShowcaseMessagesLimit = 2
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	headers := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+
+	for i := 1; i <= 3; i++ {
+		rr := request(t, s, "POST", "/status-web", fmt.Sprintf("message %d", i), map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+			"Title":         "Web",
+			"Tags":          "green_circle",
+			"Actions":       "http, Restart, https://example.com/restart, headers.Authorization=Bearer secret",
+		})
+		require.Equal(t, 200, rr.Code)
+	}
+
+	// Newest messages, without actions, readable by anyone
+	rr := request(t, s, "GET", "/status-web/showcase.json", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.NotContains(t, rr.Body.String(), "secret")
+	var response apiShowcaseResponse
+	require.Nil(t, json.NewDecoder(rr.Body).Decode(&response))
+	require.Equal(t, "status-web", response.Topic)
+	require.Equal(t, 2, len(response.Messages))
+	require.Equal(t, "message 3", response.Messages[0].Message)
+	require.Equal(t, "Web", response.Messages[0].Title)
+	require.Equal(t, 3, response.Messages[0].Priority)
+	require.Equal(t, []string{"green_circle"}, response.Messages[0].Tags)
+	require.Equal(t, "message 2", response.Messages[1].Message)
+
+	// No streaming or polling without read access
+	rr = request(t, s, "GET", "/status-web/json?poll=1", "", nil)
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "GET", "/status-web/json?poll=1", "", headers)
+	require.Equal(t, 200, rr.Code)
+
+	// Other topics have no showcase
+	rr = request(t, s, "GET", "/mytopic/showcase.json", "", headers)
+	require.Equal(t, 404, rr.Code)
+}
+
+func TestServer_Showcase_HTML(t *testing.T) {
+	c := newTestConfig(t)
+	c.ShowcaseTopics = []string{"status"}
+	s := newTestServer(t, c)
+
+	rr := request(t, s, "GET", "/status/showcase", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Contains(t, rr.Body.String(), "No messages")
+
+	rr = request(t, s, "POST", "/status", "<script>alert(1)</script>", map[string]string{
+		"Title":    "Database",
+		"Priority": "urgent",
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/status/showcase", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+	body := rr.Body.String()
+	require.Contains(t, body, `<li class="p5">`)
+	require.Contains(t, body, `<div class="title">Database</div>`)
+	require.Contains(t, body, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	require.NotContains(t, body, "<script>")
+}
+
+func TestServer_Showcase_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	rr := request(t, s, "GET", "/status/showcase", "", nil)
+	require.Equal(t, 404, rr.Code)
+	rr = request(t, s, "GET", "/status/showcase.json", "", nil)
+	require.Equal(t, 404, rr.Code)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	signedURLDefaultExpiry  = 24 * time.Hour
+	signedURLMaxExpiry      = 366 * 24 * time.Hour
+	signedURLExpiresParam   = "expires"
+	signedURLSignatureParam = "sig"
+)
+
+// handlePublishURLSign creates a signed publish URL for a fixed message. The URL can be fetched with a simple GET
+// request (e.g. from a QR code or a legacy system) to publish the message until it expires, without exposing a
+// reusable token. The caller must be allowed to publish to the topic.
+func (s *Server) handlePublishURLSign(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if s.config.PublishURLSecret == "" {
+		return errHTTPBadRequestSignedURLsDisabled
+	}
+	matches := signPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	req, err := readJSONWithLimit[apiPublishURLRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	query, expires, err := s.validatePublishURLRequest(req)
+	if err != nil {
+		return err
+	}
+	query.Set(signedURLSignatureParam, signPublishURL(s.config.PublishURLSecret, topic, query))
+	logvr(v, r).
+		Tag(tagPublish).
+		Fields(log.Context{
+			"topic":              topic,
+			"signed_url_expires": expires,
+		}).
+		Info("Creating signed publish URL for topic %s", topic)
+	return s.writeJSON(w, &apiPublishURLResponse{
+		URL:     fmt.Sprintf("%s/%s/publish?%s", s.config.BaseURL, topic, query.Encode()),
+		Expires: expires,
+	})
+}
+
+// verifySignedURL checks the signature and expiry of a signed publish URL, instead of the visitor's permissions
+// (see authorizeTopicWrite). Since all query parameters are signed, request headers are ignored, so that the
+// message cannot be changed by whoever has the URL.
+func (s *Server) verifySignedURL(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.config.PublishURLSecret == "" {
+			return errHTTPBadRequestSignedURLsDisabled
+		}
+		topics, topic, err := s.topicsFromPath(r.URL.Path)
+		if err != nil {
+			return err
+		} else if len(topics) != 1 {
+			return errHTTPBadRequestTopicInvalid
+		} else if s.topicBlocked(topic) {
+			return errHTTPForbiddenTopicBlocked.With(topics[0])
+		}
+		query := r.URL.Query()
+		signature := query.Get(signedURLSignatureParam)
+		query.Del(signedURLSignatureParam)
+		expected := signPublishURL(s.config.PublishURLSecret, topic, query)
+		if !hmac.Equal([]byte(signature), []byte(expected)) {
+			logvr(v, r).With(topics[0]).Debug("Signature of publish URL for topic %s invalid", topic)
+			s.authFailed(r, v, "", errAuthSignedURLInvalid)
+			return errHTTPForbiddenSignedURLInvalid.With(topics[0])
+		}
+		expires, err := strconv.ParseInt(query.Get(signedURLExpiresParam), 10, 64)
+		if err != nil || time.Now().Unix() > expires {
+			return errHTTPForbiddenSignedURLExpired.With(topics[0])
+		}
+		r.Header = http.Header{
+			"User-Agent": r.Header.Values("User-Agent"),
+		}
+		return next(w, r, v)
+	}
+}
+
+// validatePublishURLRequest checks a signed publish URL request, and returns the query parameters to be signed,
+// as well as the expiry of the URL
+func (s *Server) validatePublishURLRequest(req *apiPublishURLRequest) (url.Values, int64, error) {
+	expiry := signedURLDefaultExpiry
+	if req.Expires != "" {
+		var err error
+		expiry, err = util.ParseDuration(req.Expires)
+		if err != nil || expiry <= 0 || expiry > signedURLMaxExpiry {
+			return nil, 0, errHTTPBadRequestSignedURLInvalid
+		}
+	}
+	if len(req.Message) > s.config.MessageLimit || req.Priority < 0 || req.Priority > 5 {
+		return nil, 0, errHTTPBadRequestSignedURLInvalid
+	}
+	expires := time.Now().Add(expiry).Unix()
+	query := url.Values{}
+	query.Set(signedURLExpiresParam, strconv.FormatInt(expires, 10))
+	if req.Message != "" {
+		query.Set("message", req.Message)
+	}
+	if req.Title != "" {
+		query.Set("title", req.Title)
+	}
+	if req.Priority > 0 {
+		query.Set("priority", strconv.Itoa(req.Priority))
+	}
+	if len(req.Tags) > 0 {
+		query.Set("tags", strings.Join(req.Tags, ","))
+	}
+	return query, expires, nil
+}
+
+// signPublishURL returns the hex-encoded HMAC-SHA256 of "<topic>?<query>", where query is the URL-encoded query
+// string (sorted by key) without the signature itself
+func signPublishURL(secret, topic string, query url.Values) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(topic + "?" + query.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
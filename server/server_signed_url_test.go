@@ -0,0 +1,90 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_SignedURL(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.BaseURL = "https://ntfy.example.com"
+	conf.PublishURLSecret = "0123456789abcdefghij"
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "doorbell", user.PermissionReadWrite))
+
+	// Signing requires write access
+	rr := request(t, s, "POST", "/doorbell/sign", `{"message":"Ding dong"}`, nil)
+	require.Equal(t, 403, rr.Code)
+
+	// Invalid requests
+	for _, invalid := range []string{
+		`{"message":"Ding dong","expires":"400d"}`,
+		`{"message":"Ding dong","expires":"-1h"}`,
+		`{"message":"Ding dong","priority":6}`,
+	} {
+		rr = request(t, s, "POST", "/doorbell/sign", invalid, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code, invalid)
+		require.Equal(t, 40064, toHTTPError(t, rr.Body.String()).Code)
+	}
+
+	rr = request(t, s, "POST", "/doorbell/sign", `{"message":"Ding dong","title":"Front door","priority":4,"tags":["bell"],"expires":"1h"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	signed, _ := util.UnmarshalJSON[apiPublishURLResponse](io.NopCloser(rr.Body))
+	require.True(t, strings.HasPrefix(signed.URL, "https://ntfy.example.com/doorbell/publish?"))
+	require.InDelta(t, time.Now().Add(time.Hour).Unix(), signed.Expires, 2)
+
+	// Anyone with the URL can publish the message, headers are ignored
+	signedPath := strings.TrimPrefix(signed.URL, "https://ntfy.example.com")
+	rr = request(t, s, "GET", signedPath, "", map[string]string{
+		"Title": "Changed",
+	})
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	require.Equal(t, "doorbell", m.Topic)
+	require.Equal(t, "Ding dong", m.Message)
+	require.Equal(t, "Front door", m.Title)
+	require.Equal(t, 4, m.Priority)
+	require.Equal(t, []string{"bell"}, m.Tags)
+
+	// Changed parameters or topic invalidate the signature
+	u, _ := url.Parse(signedPath)
+	query := u.Query()
+	query.Set("message", "Changed")
+	rr = request(t, s, "GET", "/doorbell/publish?"+query.Encode(), "", nil)
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40307, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", strings.Replace(signedPath, "/doorbell/", "/otherbell/", 1), "", nil)
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40307, toHTTPError(t, rr.Body.String()).Code)
+
+	// Expired URL
+	query = url.Values{}
+	query.Set("message", "Ding dong")
+	query.Set("expires", "1000")
+	query.Set("sig", signPublishURL(conf.PublishURLSecret, "doorbell", query))
+	rr = request(t, s, "GET", "/doorbell/trigger?"+query.Encode(), "", nil)
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40308, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestServer_SignedURL_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	rr := request(t, s, "POST", "/doorbell/sign", `{"message":"Ding dong"}`, nil)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40065, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/doorbell/publish?message=hi&expires=9999999999&sig=abc", "", nil)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40065, toHTTPError(t, rr.Body.String()).Code)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	statsHistoryInterval        = time.Hour           // Length of one data point in the stats history
+	statsHistoryRetention       = 90 * 24 * time.Hour // Data points older than this are deleted
+	statsHistoryDefaultDuration = 24 * time.Hour      // Range returned by /v1/stats/history if "since" is not set
+)
+
+// statsHistory collects the usage counters (messages, bytes, subscribers, failed deliveries) in memory, until
+// they are written to the hourly stats table by the manager (see Flush). This allows the web app to render usage
+// graphs via the /v1/stats/history endpoint without an external Prometheus stack.
+type statsHistory struct {
+	messages    int64
+	bytes       int64
+	subscribers int64
+	failed      int64
+	mu          sync.Mutex
+}
+
+func newStatsHistory() *statsHistory {
+	return &statsHistory{}
+}
+
+// MessagePublished counts a published message, and its size (message body and attachment)
+func (h *statsHistory) MessagePublished(m *message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages++
+	h.bytes += int64(len(m.Message))
+	if m.Attachment != nil {
+		h.bytes += m.Attachment.Size
+	}
+}
+
+// DeliveryFailed counts a failed delivery, e.g. to Firebase, web push, e-mail or a phone call
+func (h *statsHistory) DeliveryFailed() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failed++
+}
+
+// Subscribers records the current number of subscribers; only the maximum is kept
+func (h *statsHistory) Subscribers(subscribers int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers = max(h.subscribers, int64(subscribers))
+}
+
+// Flush returns the counters collected since the last flush as a stats entry for the current hour,
+// and resets them
+func (h *statsHistory) Flush() *statsHistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := &statsHistoryEntry{
+		Time:        time.Now().Truncate(statsHistoryInterval).Unix(),
+		Messages:    h.messages,
+		Bytes:       h.bytes,
+		Subscribers: h.subscribers,
+		Failed:      h.failed,
+	}
+	h.messages, h.bytes, h.subscribers, h.failed = 0, 0, 0, 0
+	return e
+}
+
+// handleStatsHistory returns the hourly usage stats in the requested range. The range can be set with the "since"
+// and "until" query parameters, either as Unix timestamp, or as duration relative to now (e.g. "7d"). Hours without
+// data are returned as zero, so that the points can be rendered as a graph directly.
+func (s *Server) handleStatsHistory(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	now := time.Now()
+	since, err := parseStatsHistoryTime(readQueryParam(r, "since"), now.Add(-statsHistoryDefaultDuration), now)
+	if err != nil {
+		return errHTTPBadRequestStatsHistoryRangeInvalid
+	}
+	until, err := parseStatsHistoryTime(readQueryParam(r, "until"), now, now)
+	if err != nil {
+		return errHTTPBadRequestStatsHistoryRangeInvalid
+	}
+	since = since.Truncate(statsHistoryInterval)
+	if oldest := now.Add(-statsHistoryRetention).Truncate(statsHistoryInterval); since.Before(oldest) {
+		since = oldest
+	}
+	if until.After(now) {
+		until = now
+	}
+	if !since.Before(until) {
+		return errHTTPBadRequestStatsHistoryRangeInvalid
+	}
+	entries, err := s.messageCache.StatsHistory(since, until)
+	if err != nil {
+		return err
+	}
+	byTime := make(map[int64]*statsHistoryEntry)
+	for _, e := range entries {
+		byTime[e.Time] = e
+	}
+	points := make([]*apiStatsHistoryPoint, 0)
+	for t := since; t.Before(until); t = t.Add(statsHistoryInterval) {
+		point := &apiStatsHistoryPoint{Time: t.Unix()}
+		if e, ok := byTime[t.Unix()]; ok {
+			point.Messages, point.Bytes, point.Subscribers, point.Failed = e.Messages, e.Bytes, e.Subscribers, e.Failed
+		}
+		points = append(points, point)
+	}
+	return s.writeJSON(w, &apiStatsHistoryResponse{
+		Interval: int64(statsHistoryInterval.Seconds()),
+		Points:   points,
+	})
+}
+
+// writeStatsHistory writes the counters collected since the last run to the stats table,
+// and deletes entries that are older than the retention period
+func (s *Server) writeStatsHistory(subscribers int) {
+	s.statsHistory.Subscribers(subscribers)
+	if err := s.messageCache.AddStatsHistory(s.statsHistory.Flush()); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Cannot write stats history")
+	} else if err := s.messageCache.RemoveStatsHistory(time.Now().Add(-statsHistoryRetention)); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Cannot prune stats history")
+	}
+}
+
+// parseStatsHistoryTime parses a Unix timestamp, or a duration relative to now (e.g. "24h" or "7d")
+func parseStatsHistoryTime(s string, def, now time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	} else if timestamp, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(timestamp, 0), nil
+	}
+	d, err := util.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(-d), nil
+}
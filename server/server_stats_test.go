@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStatsHistory_Flush(t *testing.T) {
+	h := newStatsHistory()
+	h.MessagePublished(&message{Message: "hello"})
+	h.MessagePublished(&message{Message: "hi", Attachment: &attachment{Size: 1000}})
+	h.DeliveryFailed()
+	h.Subscribers(7)
+	h.Subscribers(3)
+
+	e := h.Flush()
+	require.Equal(t, time.Now().Truncate(time.Hour).Unix(), e.Time)
+	require.Equal(t, int64(2), e.Messages)
+	require.Equal(t, int64(1007), e.Bytes)
+	require.Equal(t, int64(7), e.Subscribers)
+	require.Equal(t, int64(1), e.Failed)
+
+	e = h.Flush()
+	require.Equal(t, int64(0), e.Messages)
+	require.Equal(t, int64(0), e.Bytes)
+	require.Equal(t, int64(0), e.Subscribers)
+	require.Equal(t, int64(0), e.Failed)
+}
+
+func TestServer_StatsHistory(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hello", nil).Code)
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+	s.execManager()
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hey", nil).Code)
+	s.execManager()
+
+	// Add an older entry directly
+	hour := time.Now().Truncate(time.Hour)
+	require.Nil(t, s.messageCache.AddStatsHistory(&statsHistoryEntry{Time: hour.Add(-3 * time.Hour).Unix(), Messages: 10, Bytes: 100}))
+
+	response := request(t, s, "GET", "/v1/stats/history?since=6h", "", nil)
+	require.Equal(t, 200, response.Code)
+	history, err := util.UnmarshalJSON[apiStatsHistoryResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, int64(3600), history.Interval)
+	require.Equal(t, 7, len(history.Points)) // 6 full hours, plus the current hour
+	for i, p := range history.Points {
+		require.Equal(t, hour.Add(time.Duration(i-6)*time.Hour).Unix(), p.Time)
+	}
+	require.Equal(t, int64(10), history.Points[3].Messages)
+	require.Equal(t, int64(100), history.Points[3].Bytes)
+	require.Equal(t, int64(0), history.Points[4].Messages) // Gaps are filled
+	require.Equal(t, int64(3), history.Points[6].Messages)
+	require.Equal(t, int64(10), history.Points[6].Bytes)
+
+	// Absolute range
+	response = request(t, s, "GET", "/v1/stats/history?since="+fmt.Sprint(hour.Add(-3*time.Hour).Unix())+"&until="+fmt.Sprint(hour.Add(-2*time.Hour).Unix()), "", nil)
+	require.Equal(t, 200, response.Code)
+	history, err = util.UnmarshalJSON[apiStatsHistoryResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(history.Points))
+	require.Equal(t, int64(10), history.Points[0].Messages)
+}
+
+func TestServer_StatsHistory_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "GET", "/v1/stats/history?since=yesterday", "", nil)
+	require.Equal(t, 40049, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/v1/stats/history?since=1h&until=2h", "", nil)
+	require.Equal(t, 40049, toHTTPError(t, response.Body.String()).Code)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"os"
+)
+
+// TagIcon maps a message tag to a custom icon, so that org-specific tags (e.g. "jenkins" or "k8s") can be
+// rendered with a real icon by the clients. An icon is either an emoji (Emoji), or an image file (File) that is
+// served by the server, see handleTagIcon.
+type TagIcon struct {
+	Tag   string
+	Emoji string
+	File  string
+}
+
+// handleTagIcons returns the list of custom tag icons registered by the operator
+func (s *Server) handleTagIcons(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	return s.writeJSON(w, &apiTagIconsResponse{
+		Icons: s.tagIcons(),
+	})
+}
+
+// handleTagIcon serves the image file of a custom tag icon
+func (s *Server) handleTagIcon(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	tag := tagIconPathRegex.FindStringSubmatch(r.URL.Path)[1]
+	var icon *TagIcon
+	for _, i := range s.config.TagIcons {
+		if i.Tag == tag && i.File != "" {
+			icon = i
+			break
+		}
+	}
+	if icon == nil {
+		return errHTTPNotFound
+	}
+	f, err := os.Open(icon.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "", stat.ModTime(), f)
+	return nil
+}
+
+// tagIcons returns the custom tag icons as they are passed to clients, i.e. with the URL of
+// the image file instead of the path
+func (s *Server) tagIcons() []*apiTagIcon {
+	icons := make([]*apiTagIcon, 0)
+	for _, i := range s.config.TagIcons {
+		icon := &apiTagIcon{
+			Tag:   i.Tag,
+			Emoji: i.Emoji,
+		}
+		if i.File != "" {
+			icon.URL = s.config.BaseURL + "/v1/tags/" + i.Tag + "/icon"
+		}
+		icons = append(icons, icon)
+	}
+	return icons
+}
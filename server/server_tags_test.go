@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServer_TagIcons(t *testing.T) {
+	iconFile := filepath.Join(t.TempDir(), "k8s.png")
+	iconBytes := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	require.Nil(t, os.WriteFile(iconFile, iconBytes, 0600))
+
+	c := newTestConfig(t)
+	c.TagIcons = []*TagIcon{
+		{Tag: "jenkins", Emoji: "🤖"},
+		{Tag: "k8s", File: iconFile},
+	}
+	s := newTestServer(t, c)
+
+	rr := request(t, s, "GET", "/v1/tags", "", nil)
+	require.Equal(t, 200, rr.Code)
+	response, err := util.UnmarshalJSON[apiTagIconsResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(response.Icons))
+	require.Equal(t, "jenkins", response.Icons[0].Tag)
+	require.Equal(t, "🤖", response.Icons[0].Emoji)
+	require.Equal(t, "", response.Icons[0].URL)
+	require.Equal(t, "k8s", response.Icons[1].Tag)
+	require.Equal(t, "http://127.0.0.1:12345/v1/tags/k8s/icon", response.Icons[1].URL)
+
+	rr = request(t, s, "GET", "/v1/tags/k8s/icon", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+	require.Equal(t, iconBytes, rr.Body.Bytes())
+
+	rr = request(t, s, "GET", "/v1/tags/jenkins/icon", "", nil)
+	require.Equal(t, 404, rr.Code)
+	rr = request(t, s, "GET", "/v1/tags/unknown/icon", "", nil)
+	require.Equal(t, 404, rr.Code)
+
+	// Tag icons are included in the account sync
+	rr = request(t, s, "GET", "/v1/account", "", nil)
+	require.Equal(t, 200, rr.Code)
+	account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(account.TagIcons))
+	require.Equal(t, "k8s", account.TagIcons[1].Tag)
+}
+
+func TestServer_TagIcons_None(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	rr := request(t, s, "GET", "/v1/tags", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, `{"icons":[]}`+"\n", rr.Body.String())
+
+	rr = request(t, s, "GET", "/v1/account", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.NotContains(t, rr.Body.String(), "tag_icons")
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"gopkg.in/yaml.v2"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// Message templates render the title, message and action buttons of a message from the JSON body of a publish
+// request (e.g. the webhook payload sent by Grafana), using Go's text/template syntax. A template is either passed
+// inline (X-Template: yes, with X-Title and X-Message as templates), or stored server-side under a name
+// (X-Template: <name>): by the operator as YAML file in template-dir, or by a user via POST /v1/account/template.
+
+const (
+	templateMaxCount      = 50                     // Max. number of templates a user can store
+	templateMaxSteps      = 10000                  // Max. number of range iterations and template calls when rendering a template
+	templateRenderTimeout = 500 * time.Millisecond // Max. time to render a template
+	templateStepFunc      = "ntfyTemplateStep"     // Function that is called on each step, see guardTemplate
+)
+
+var (
+	templateNameRegex = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+
+	errTemplateTooManySteps = errors.New("template too expensive, too many iterations")
+	errTemplateTimeout      = errors.New("template too expensive, rendering timed out")
+)
+
+// templateFile is the format of an operator template in template-dir, e.g. /etc/ntfy/templates/grafana.yml
+type templateFile struct {
+	Title   string `yaml:"title"`
+	Message string `yaml:"message"`
+	Actions string `yaml:"actions"`
+}
+
+// readTemplateParam returns the template name of a publish request (or "yes" for inline templates), or an
+// empty string if the message is not templated
+func readTemplateParam(r *http.Request) string {
+	name := readParam(r, "x-template", "template", "tpl")
+	if isBoolValue(strings.ToLower(name)) && !toBool(strings.ToLower(name)) {
+		return ""
+	}
+	return name
+}
+
+// handleBodyAsTemplatedMessage parses the body as JSON, and renders title, message and actions of the message
+// from the given template, see handlePublishBody
+func (s *Server) handleBodyAsTemplatedMessage(v *visitor, m *message, body *util.PeekedReadCloser, name string) error {
+	limit := v.Limits().MessageSizeLimit
+	if body.LimitReached {
+		return errHTTPEntityTooLargeJSONBody.WithLimit(limit).With(m)
+	}
+	var data any
+	if err := json.Unmarshal(body.PeekedBytes, &data); err != nil {
+		return errHTTPBadRequestTemplateDataInvalid.With(m)
+	}
+	tpl, err := s.messageTemplate(v, m, name)
+	if err != nil {
+		return err
+	}
+	if tpl.Title != "" {
+		if m.Title, err = renderTemplate(tpl.Title, data, limit); err != nil {
+			return errHTTPBadRequestTemplateInvalid.Wrap("title: %s", err.Error()).With(m)
+		}
+	}
+	if tpl.Message != "" {
+		message, err := renderTemplate(tpl.Message, data, limit)
+		if err != nil {
+			return errHTTPBadRequestTemplateInvalid.Wrap("message: %s", err.Error()).With(m)
+		}
+		m.Message = strings.TrimSpace(message)
+	}
+	if tpl.Actions != "" {
+		actions, err := renderTemplate(tpl.Actions, data, limit)
+		if err != nil {
+			return errHTTPBadRequestTemplateInvalid.Wrap("actions: %s", err.Error()).With(m)
+		}
+		if m.Actions, err = parseActions(actions); err != nil {
+			return errHTTPBadRequestActionsInvalid.Wrap(err.Error()).With(m)
+		}
+	}
+	return nil
+}
+
+// messageTemplate returns the template with the given name. For inline templates, title and message of the request
+// are the templates. Otherwise, the templates of the user take precedence over the ones in template-dir.
+func (s *Server) messageTemplate(v *visitor, m *message, name string) (*user.Template, error) {
+	if toBool(strings.ToLower(name)) {
+		if v.User() == nil && !s.config.TemplateInlineAnonymous {
+			return nil, errHTTPForbiddenTemplateInline.With(m)
+		}
+		return &user.Template{Title: m.Title, Message: m.Message}, nil
+	} else if !templateNameRegex.MatchString(name) {
+		return nil, errHTTPBadRequestTemplateNotFound.With(m)
+	}
+	if u := v.User(); u != nil && u.Prefs != nil {
+		for _, t := range u.Prefs.Templates {
+			if t.Name == name {
+				return t, nil
+			}
+		}
+	}
+	if s.config.TemplateDir != "" {
+		for _, ext := range []string{".yml", ".yaml"} {
+			b, err := os.ReadFile(filepath.Join(s.config.TemplateDir, name+ext))
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			var file templateFile
+			if err := yaml.UnmarshalStrict(b, &file); err != nil {
+				return nil, errHTTPBadRequestTemplateInvalid.Wrap("cannot parse template file: %s", err.Error()).With(m)
+			}
+			return &user.Template{Name: name, Title: file.Title, Message: file.Message, Actions: file.Actions}, nil
+		}
+	}
+	return nil, errHTTPBadRequestTemplateNotFound.With(m)
+}
+
+// renderTemplate executes the given text template with data. Since templates may be written by anyone who can
+// publish, rendering fails if the output is larger than limit bytes, if it takes more than templateMaxSteps range
+// iterations and template calls, or if it takes longer than templateRenderTimeout.
+func renderTemplate(text string, data any, limit int64) (string, error) {
+	guard := &templateGuard{deadline: time.Now().Add(templateRenderTimeout)}
+	tpl, err := template.New("").Funcs(template.FuncMap{templateStepFunc: guard.step}).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tpl.Templates() {
+		guardTemplate(t.Tree)
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(util.NewLimitWriter(&buf, util.NewFixedLimiter(limit)), data); err != nil {
+		if errors.Is(err, util.ErrLimitReached) {
+			return "", errors.New("rendered template too large")
+		} else if errors.Is(err, errTemplateTooManySteps) {
+			return "", errTemplateTooManySteps
+		} else if errors.Is(err, errTemplateTimeout) {
+			return "", errTemplateTimeout
+		}
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// templateGuard limits the cost of rendering a template, see guardTemplate
+type templateGuard struct {
+	deadline time.Time
+	steps    int
+}
+
+// step is called at the beginning of each range iteration and each template call. It fails the execution
+// if the template has taken too many steps, or if the deadline has passed.
+func (g *templateGuard) step() (string, error) {
+	g.steps++
+	if g.steps > templateMaxSteps {
+		return "", errTemplateTooManySteps
+	} else if time.Now().After(g.deadline) {
+		return "", errTemplateTimeout
+	}
+	return "", nil
+}
+
+// guardTemplate inserts a call to the templateStepFunc function at the beginning of the template, and of the body
+// of every range loop within it. Since text/template cannot be interrupted, this is the only way to bound loops that
+// do not write any output (e.g. nested ranges over a large JSON body), as well as recursive templates.
+func guardTemplate(tree *parse.Tree) {
+	if tree == nil || tree.Root == nil {
+		return
+	}
+	guardTemplateLoops(tree, tree.Root)
+	tree.Root.Nodes = append([]parse.Node{templateStepNode(tree, tree.Root.Position())}, tree.Root.Nodes...)
+}
+
+func guardTemplateLoops(tree *parse.Tree, list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.RangeNode:
+			guardTemplateLoops(tree, n.List)
+			guardTemplateLoops(tree, n.ElseList)
+			n.List.Nodes = append([]parse.Node{templateStepNode(tree, n.List.Position())}, n.List.Nodes...)
+		case *parse.IfNode:
+			guardTemplateLoops(tree, n.List)
+			guardTemplateLoops(tree, n.ElseList)
+		case *parse.WithNode:
+			guardTemplateLoops(tree, n.List)
+			guardTemplateLoops(tree, n.ElseList)
+		}
+	}
+}
+
+// templateStepNode returns the action {{ntfyTemplateStep}}, which prints nothing
+func templateStepNode(tree *parse.Tree, pos parse.Pos) parse.Node {
+	ident := parse.NewIdentifier(templateStepFunc).SetTree(tree).SetPos(pos)
+	return &parse.ActionNode{
+		NodeType: parse.NodeAction,
+		Pos:      pos,
+		Pipe: &parse.PipeNode{
+			NodeType: parse.NodePipe,
+			Pos:      pos,
+			Cmds: []*parse.CommandNode{
+				{NodeType: parse.NodeCommand, Pos: pos, Args: []parse.Node{ident}},
+			},
+		},
+	}
+}
+
+// validateTemplate checks that a template has a valid name, and that its title, message and actions can be parsed
+func validateTemplate(t *user.Template) *errHTTP {
+	if !templateNameRegex.MatchString(t.Name) || toBool(strings.ToLower(t.Name)) {
+		return errHTTPBadRequestTemplateInvalid.Wrap("name must be 1-64 characters (letters, numbers, - and _), and not a boolean value")
+	} else if t.Title == "" && t.Message == "" && t.Actions == "" {
+		return errHTTPBadRequestTemplateInvalid.Wrap("title, message or actions must be set")
+	}
+	for _, text := range []string{t.Title, t.Message, t.Actions} {
+		if _, err := template.New("").Parse(text); err != nil {
+			return errHTTPBadRequestTemplateInvalid.Wrap(err.Error())
+		}
+	}
+	return nil
+}
+
+// handleAccountTemplateAdd stores a message template for the logged-in user, replacing an existing template
+// with the same name
+func (s *Server) handleAccountTemplateAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	newTemplate, err := readJSONWithLimit[user.Template](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	if err := validateTemplate(newTemplate); err != nil {
+		return err
+	}
+	u := v.User()
+	prefs := u.Prefs
+	if prefs == nil {
+		prefs = &user.Prefs{}
+	}
+	replaced := false
+	for i, t := range prefs.Templates {
+		if t.Name == newTemplate.Name {
+			prefs.Templates[i] = newTemplate
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		if len(prefs.Templates) >= templateMaxCount {
+			return errHTTPTooManyRequestsLimitTemplates
+		}
+		prefs.Templates = append(prefs.Templates, newTemplate)
+	}
+	logvr(v, r).Tag(tagAccount).With(newTemplate).Debug("Adding template for user %s", u.Name)
+	if err := s.userManager.ChangeSettings(u.ID, prefs); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newTemplate)
+}
+
+// handleAccountTemplateDelete removes a message template of the logged-in user
+func (s *Server) handleAccountTemplateDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	name := apiAccountTemplateSingleRegex.FindStringSubmatch(r.URL.Path)[1]
+	u := v.User()
+	prefs := u.Prefs
+	if prefs == nil || prefs.Templates == nil {
+		return errHTTPNotFoundTemplate
+	}
+	newTemplates := make([]*user.Template, 0)
+	for _, t := range prefs.Templates {
+		if t.Name == name {
+			logvr(v, r).Tag(tagAccount).With(t).Debug("Removing template for user %s", u.Name)
+		} else {
+			newTemplates = append(newTemplates, t)
+		}
+	}
+	if len(newTemplates) == len(prefs.Templates) {
+		return errHTTPNotFoundTemplate
+	}
+	prefs.Templates = newTemplates
+	if err := s.userManager.ChangeSettings(u.ID, prefs); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
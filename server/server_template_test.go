@@ -0,0 +1,258 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServer_Template_Inline(t *testing.T) {
+	c := newTestConfig(t)
+	c.TemplateInlineAnonymous = true
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/mytopic", `{"host":"db1","free":"2G","mount":"/var"}`, map[string]string{
+		"Template": "yes",
+		"Title":    "Disk almost full on {{.host}}",
+		"Message":  "Only {{.free}} left on {{.mount}}",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "Disk almost full on db1", m.Title)
+	require.Equal(t, "Only 2G left on /var", m.Message)
+
+	// Template disabled, body is the message
+	response = request(t, s, "POST", "/mytopic", `{"host":"db1"}`, map[string]string{
+		"Template": "no",
+		"Title":    "{{.host}}",
+	})
+	require.Equal(t, 200, response.Code)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, "{{.host}}", m.Title)
+	require.Equal(t, `{"host":"db1"}`, m.Message)
+}
+
+func TestServer_Template_Dir(t *testing.T) {
+	c := newTestConfig(t)
+	c.TemplateDir = t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(c.TemplateDir, "grafana-disk.yml"), []byte(`
+title: "{{.title}}"
+message: |
+  {{range .alerts}}{{.labels.instance}}: {{.annotations.summary}}
+  {{end}}
+actions: "view, Open dashboard, {{.externalURL}}"
+`), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(c.TemplateDir, "broken.yaml"), []byte(`message: "{{.unclosed"`), 0600))
+	s := newTestServer(t, c)
+
+	body := `{"title":"[FIRING:2] Disk full","externalURL":"https://grafana.example.com","alerts":[{"labels":{"instance":"db1"},"annotations":{"summary":"95% used"}},{"labels":{"instance":"db2"},"annotations":{"summary":"97% used"}}]}`
+	response := request(t, s, "POST", "/alerts?tpl=grafana-disk", body, map[string]string{
+		"Priority": "high",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "[FIRING:2] Disk full", m.Title)
+	require.Equal(t, "db1: 95% used\ndb2: 97% used", m.Message)
+	require.Equal(t, 4, m.Priority)
+	require.Equal(t, 1, len(m.Actions))
+	require.Equal(t, "view", m.Actions[0].Action)
+	require.Equal(t, "Open dashboard", m.Actions[0].Label)
+	require.Equal(t, "https://grafana.example.com", m.Actions[0].URL)
+
+	// Template not found, or not a valid name
+	for _, name := range []string{"does-not-exist", "../grafana-disk"} {
+		response = request(t, s, "POST", "/alerts", body, map[string]string{
+			"Template": name,
+		})
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40071, toHTTPError(t, response.Body.String()).Code)
+	}
+
+	// Body is not JSON
+	response = request(t, s, "POST", "/alerts", "this is not JSON", map[string]string{
+		"Template": "grafana-disk",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40073, toHTTPError(t, response.Body.String()).Code)
+
+	// Template cannot be parsed
+	response = request(t, s, "POST", "/alerts", body, map[string]string{
+		"Template": "broken",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40072, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Template_Inline_AnonymousNotAllowed(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	// Anonymous publishers cannot use inline templates by default
+	response := request(t, s, "POST", "/mytopic", `{"host":"db1"}`, map[string]string{
+		"Template": "yes",
+		"Title":    "{{.host}}",
+	})
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40310, toHTTPError(t, response.Body.String()).Code)
+
+	// Authenticated users can
+	response = request(t, s, "POST", "/mytopic", `{"host":"db1"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Template":      "yes",
+		"Title":         "{{.host}}",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "db1", toMessage(t, response.Body.String()).Title)
+}
+
+func TestServer_Template_TooExpensive(t *testing.T) {
+	c := newTestConfig(t)
+	c.TemplateInlineAnonymous = true
+	s := newTestServer(t, c)
+
+	// Nested loops that do not write any output
+	n := make([]string, 200)
+	for i := range n {
+		n[i] = fmt.Sprintf("%d", i)
+	}
+	body := fmt.Sprintf(`{"n":[%s]}`, strings.Join(n, ","))
+	response := request(t, s, "POST", "/mytopic", body, map[string]string{
+		"Template": "yes",
+		"Message":  "{{range .n}}{{range $.n}}{{range $.n}}{{range $.n}}{{end}}{{end}}{{end}}{{end}}done",
+	})
+	require.Equal(t, 400, response.Code)
+	err := toHTTPError(t, response.Body.String())
+	require.Equal(t, 40072, err.Code)
+	require.Contains(t, err.Message, "too many iterations")
+
+	// Recursive template
+	response = request(t, s, "POST", "/mytopic", `{}`, map[string]string{
+		"Template": "yes",
+		"Message":  `{{define "a"}}{{template "a" .}}{{template "a" .}}{{end}}{{template "a" .}}`,
+	})
+	require.Equal(t, 400, response.Code)
+	err = toHTTPError(t, response.Body.String())
+	require.Equal(t, 40072, err.Code)
+	require.Contains(t, err.Message, "too many iterations")
+
+	// Loops within limits still work
+	response = request(t, s, "POST", "/mytopic", `{"n":[1,2,3]}`, map[string]string{
+		"Template": "yes",
+		"Message":  "{{range .n}}{{if gt . 1.0}}{{range $.n}}{{.}}{{end}}{{end}}{{end}}",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "123123", toMessage(t, response.Body.String()).Message)
+}
+
+func TestServer_Template_TooLarge(t *testing.T) {
+	c := newTestConfig(t)
+	c.TemplateInlineAnonymous = true
+	s := newTestServer(t, c)
+
+	// Rendered message exceeds the message size limit
+	response := request(t, s, "POST", "/mytopic", `{"n":[1,2,3,4,5,6,7,8,9,10]}`, map[string]string{
+		"Template": "yes",
+		"Message":  "{{range .n}}{{range $.n}}{{range $.n}}{{range $.n}}xxxxxxxxxxxxxxxxx{{end}}{{end}}{{end}}{{end}}",
+	})
+	require.Equal(t, 400, response.Code)
+	err := toHTTPError(t, response.Body.String())
+	require.Equal(t, 40072, err.Code)
+	require.Contains(t, err.Message, "rendered template too large")
+}
+
+func TestAccount_Template_AddUseDelete(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.TemplateDir = t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(c.TemplateDir, "disk.yml"), []byte(`message: "server template: {{.host}}"`), 0600))
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "mytopic", user.PermissionReadWrite))
+	auth := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+
+	// Invalid templates
+	for _, body := range []string{
+		`{"name":"disk"}`,
+		`{"name":"yes","message":"{{.host}}"}`,
+		`{"name":"no spaces","message":"{{.host}}"}`,
+		`{"name":"disk","message":"{{.host"}`,
+	} {
+		response := request(t, s, "POST", "/v1/account/template", body, auth)
+		require.Equal(t, 400, response.Code, body)
+		require.Equal(t, 40072, toHTTPError(t, response.Body.String()).Code)
+	}
+
+	// Add and replace
+	response := request(t, s, "POST", "/v1/account/template", `{"name":"disk","title":"{{.host}}","message":"user template"}`, auth)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", "/v1/account/template", `{"name":"disk","title":"Disk full on {{.host}}","message":"{{.free}} left"}`, auth)
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/v1/account", "", auth)
+	require.Equal(t, 200, response.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(response.Body))
+	require.Equal(t, 1, len(account.Templates))
+	require.Equal(t, "disk", account.Templates[0].Name)
+	require.Equal(t, "Disk full on {{.host}}", account.Templates[0].Title)
+
+	// User template takes precedence over the server template
+	response = request(t, s, "PUT", "/mytopic", `{"host":"db1","free":"2G"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Template":      "disk",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "Disk full on db1", m.Title)
+	require.Equal(t, "2G left", m.Message)
+
+	// Anonymous users only see server templates
+	response = request(t, s, "PUT", "/mytopic", `{"host":"db1","free":"2G"}`, map[string]string{
+		"Template": "disk",
+	})
+	require.Equal(t, 200, response.Code)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, "", m.Title)
+	require.Equal(t, "server template: db1", m.Message)
+
+	// Delete
+	response = request(t, s, "DELETE", "/v1/account/template/disk", "", auth)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "DELETE", "/v1/account/template/disk", "", auth)
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40410, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", `{"host":"db1","free":"2G"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Template":      "disk",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "server template: db1", toMessage(t, response.Body.String()).Message)
+}
+
+func TestAccount_Template_Limit(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	auth := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+	for i := 0; i < templateMaxCount; i++ {
+		response := request(t, s, "POST", "/v1/account/template", fmt.Sprintf(`{"name":"t%d","message":"{{.host}}"}`, i), auth)
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "POST", "/v1/account/template", `{"name":"one-too-many","message":"{{.host}}"}`, auth)
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42914, toHTTPError(t, response.Body.String()).Code)
+
+	// Replacing an existing template is still allowed
+	response = request(t, s, "POST", "/v1/account/template", `{"name":"t0","message":"{{.free}}"}`, auth)
+	require.Equal(t, 200, response.Code)
+}
@@ -10,9 +10,11 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"heckel.io/ntfy/v2/user"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime/debug"
@@ -84,6 +86,45 @@ func TestServer_PublishWithFirebase(t *testing.T) {
 	require.Equal(t, "my first message", sender.Messages()[0].APNS.Payload.CustomData["message"])
 }
 
+func TestServer_PublishWithFirebase_PriorityQueue(t *testing.T) {
+	sender := newTestFirebaseSender(10)
+	c := newTestConfig(t)
+	c.FirebaseWorkers = 1
+	c.FirebaseQueueSize = 3
+	s := newTestServer(t, c)
+	s.firebaseClient = newFirebaseClient(sender, &testAuther{Allow: true})
+
+	// Block the only worker in the sender, by holding the sender's lock
+	sender.mu.Lock()
+	response := request(t, s, "PUT", "/mytopic", "first", nil)
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return s.firebaseQueue.Len() == 0
+	})
+
+	// Fill the queue; the last message is dropped
+	for _, body := range []string{"bulk 1", "bulk 2", "urgent", "dropped"} {
+		headers := map[string]string{"Priority": "1"}
+		if body == "urgent" {
+			headers["Priority"] = "5"
+		}
+		response = request(t, s, "PUT", "/mytopic", body, headers)
+		require.Equal(t, 200, response.Code)
+	}
+	require.Equal(t, 3, s.firebaseQueue.Len())
+	sender.mu.Unlock()
+
+	// Urgent message preempts bulk messages
+	waitFor(t, func() bool {
+		return len(sender.Messages()) == 4
+	})
+	messages := sender.Messages()
+	require.Equal(t, "first", messages[0].Data["message"])
+	require.Equal(t, "urgent", messages[1].Data["message"])
+	require.Equal(t, "bulk 1", messages[2].Data["message"])
+	require.Equal(t, "bulk 2", messages[3].Data["message"])
+}
+
 func TestServer_PublishWithFirebase_WithoutUsers_AndWithoutPanic(t *testing.T) {
 	// This tests issue #641, which used to panic before the fix
 
@@ -149,6 +190,74 @@ func TestServer_SubscribeOpenAndKeepalive(t *testing.T) {
 	require.Nil(t, messages[1].Tags)
 }
 
+func TestServer_SubscribeKeepaliveParam(t *testing.T) {
+	t.Parallel()
+	c := newTestConfig(t)
+	c.KeepaliveInterval = 10 * time.Second
+	c.KeepaliveIntervalMin = 500 * time.Millisecond
+	s := newTestServer(t, c)
+
+	// Requested interval is used; values below keepalive-interval-min are capped
+	for _, tt := range []struct {
+		url        string
+		keepalives int
+	}{
+		{"/mytopic/json?keepalive=1s", 1},
+		{"/mytopic/json?ka=100ms", 2},
+	} {
+		rr := httptest.NewRecorder()
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, "GET", tt.url, nil)
+		require.Nil(t, err)
+		doneChan := make(chan bool)
+		go func() {
+			s.handle(rr, req)
+			doneChan <- true
+		}()
+		time.Sleep(1300 * time.Millisecond)
+		cancel()
+		<-doneChan
+
+		messages := toMessages(t, rr.Body.String())
+		require.Equal(t, 1+tt.keepalives, len(messages), tt.url)
+		require.Equal(t, openEvent, messages[0].Event)
+		require.Equal(t, keepaliveEvent, messages[1].Event)
+	}
+
+	response := request(t, s, "GET", "/mytopic/json?keepalive=often", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40069, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_SubscribeSSERetryParam(t *testing.T) {
+	t.Parallel()
+	c := newTestConfig(t)
+	c.SSERetryMax = time.Minute
+	s := newTestServer(t, c)
+
+	for _, tt := range []struct {
+		url   string
+		retry string
+	}{
+		{"/mytopic/sse?retry=10s", "retry: 10000\nevent: open\n"},
+		{"/mytopic/sse?retry=1h", "retry: 60000\nevent: open\n"},
+		{"/mytopic/sse?retry=50ms", "retry: 1000\nevent: open\n"},
+	} {
+		rr := httptest.NewRecorder()
+		subscribe(t, s, tt.url, rr)()
+		require.True(t, strings.HasPrefix(rr.Body.String(), tt.retry), rr.Body.String())
+	}
+
+	// No retry field unless requested
+	rr := httptest.NewRecorder()
+	subscribe(t, s, "/mytopic/sse", rr)()
+	require.True(t, strings.HasPrefix(rr.Body.String(), "event: open\n"))
+
+	response := request(t, s, "GET", "/mytopic/sse?retry=-1s", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40070, toHTTPError(t, response.Body.String()).Code)
+}
+
 func TestServer_PublishAndSubscribe(t *testing.T) {
 	t.Parallel()
 	s := newTestServer(t, newTestConfig(t))
@@ -298,6 +407,73 @@ func TestServer_PublishLargeMessage(t *testing.T) {
 	require.Equal(t, 400, response.Code)
 }
 
+func TestServer_PublishLargeMessage_TierMessageSizeLimit(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AttachmentCacheDir = "" // Disable attachments
+	s := newTestServer(t, c)
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     100,
+		MessageSizeLimit: 8192,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+
+	// Anonymous visitors are limited by the global message size limit
+	body := strings.Repeat("x", 6000)
+	response := request(t, s, "PUT", "/mytopic", body, nil)
+	require.Equal(t, 400, response.Code)
+
+	// The tier allows larger messages
+	response = request(t, s, "PUT", "/mytopic", body, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, body, msg.Message)
+	require.Nil(t, msg.Attachment)
+
+	// ... but not larger than the tier limit
+	response = request(t, s, "PUT", "/mytopic", strings.Repeat("x", 9000), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+
+	// The limit is reported in the account API
+	response = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(response.Body))
+	require.Equal(t, int64(8192), account.Limits.MessageSize)
+}
+
+func TestServer_AccountBodySizeLimit(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AccountBodySizeLimit = 4096
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+
+	body := fmt.Sprintf(`{"language": "%s"}`, strings.Repeat("x", 5000))
+	response := request(t, s, "PATCH", "/v1/account/settings", body, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 413, response.Code)
+	err, _ := util.UnmarshalJSON[struct {
+		Code  int   `json:"code"`
+		Limit int64 `json:"limit"`
+	}](io.NopCloser(response.Body))
+	require.Equal(t, 41303, err.Code)
+	require.Equal(t, int64(4096), err.Limit)
+
+	response = request(t, s, "PATCH", "/v1/account/settings", `{"language": "de"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+}
+
 func TestServer_PublishPriority(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
 
@@ -804,6 +980,40 @@ func TestServer_Auth_Success_User(t *testing.T) {
 	require.Equal(t, 200, response.Code)
 }
 
+func TestServer_Auth_Success_ProvisionedUser(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("ben"), bcrypt.MinCost)
+	require.Nil(t, err)
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	c.AuthUsers = []*user.User{{Name: "ben", Hash: string(hash), Role: user.RoleUser}}
+	c.AuthAccess = map[string][]user.Grant{"ben": {{TopicPattern: "mytopic", Allow: user.PermissionReadWrite}}}
+	c.AuthTokens = map[string][]*user.Token{"ben": {{Value: user.HashToken("tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), Label: "CI"}}}
+	s := newTestServer(t, c)
+
+	response := request(t, s, "GET", "/mytopic/auth", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "PUT", "/mytopic", "from CI", map[string]string{
+		"Authorization": util.BearerAuth("tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "PUT", "/othertopic", "from CI", map[string]string{
+		"Authorization": util.BearerAuth("tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+	})
+	require.Equal(t, 403, response.Code)
+
+	// Auth scheme is case-insensitive
+	for _, scheme := range []string{"bearer", "BEARER"} {
+		response = request(t, s, "PUT", "/mytopic", "from CI", map[string]string{
+			"Authorization": scheme + " tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		})
+		require.Equal(t, 200, response.Code, scheme)
+	}
+}
+
 func TestServer_Auth_Success_User_MultipleTopics(t *testing.T) {
 	c := newTestConfigWithAuthFile(t)
 	c.AuthDefault = user.PermissionDenyAll
@@ -1127,14 +1337,18 @@ func TestServer_DailyMessageQuotaFromDatabase(t *testing.T) {
 }
 
 type testMailer struct {
-	count int
-	mu    sync.Mutex
+	count    int
+	messages []*message
+	to       []string
+	mu       sync.Mutex
 }
 
 func (t *testMailer) Send(v *visitor, m *message, to string) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.count++
+	t.messages = append(t.messages, m)
+	t.to = append(t.to, to)
 	return nil
 }
 
@@ -1148,6 +1362,18 @@ func (t *testMailer) Count() int {
 	return t.count
 }
 
+func (t *testMailer) Messages() []*message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*message{}, t.messages...)
+}
+
+func (t *testMailer) Recipients() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.to...)
+}
+
 func TestServer_PublishTooRequests_Defaults(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
 	for i := 0; i < 60; i++ {
@@ -1181,6 +1407,40 @@ func TestServer_PublishTooRequests_Defaults_ExemptHosts_MessageDailyLimit(t *tes
 	}
 }
 
+func TestServer_PublishTooRequests_UnixSocket_Exempt(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 3
+	c.VisitorMessageDailyLimit = 4
+	s := newTestServer(t, c)
+	for i := 0; i < 8; i++ { // > 3 and > 4
+		response := request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), nil, func(r *http.Request) {
+			r.RemoteAddr = "@" // Unix socket
+		})
+		require.Equal(t, 200, response.Code)
+	}
+}
+
+func TestServer_PublishTooRequests_UnixSocket_BehindProxy_NotExempt(t *testing.T) {
+	c := newTestConfig(t)
+	c.BehindProxy = true
+	c.VisitorRequestLimitBurst = 3
+	s := newTestServer(t, c)
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
+			"X-Forwarded-For": "1.2.3.4",
+		}, func(r *http.Request) {
+			r.RemoteAddr = "@"
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "PUT", "/mytopic", "message", map[string]string{
+		"X-Forwarded-For": "1.2.3.4",
+	}, func(r *http.Request) {
+		r.RemoteAddr = "@"
+	})
+	require.Equal(t, 429, response.Code)
+}
+
 func TestServer_PublishTooRequests_ShortReplenish(t *testing.T) {
 	t.Parallel()
 	c := newTestConfig(t)
@@ -1300,7 +1560,7 @@ func TestServer_PublishAndExpungeTopicAfter16Hours(t *testing.T) {
 	require.NotNil(t, s.topics["mytopic"])
 
 	// Fudge with last access, but subscribe, and see that it won't get pruned (because of subscriber)
-	subID := s.topics["mytopic"].Subscribe(subFn, "", func() {})
+	subID := s.topics["mytopic"].Subscribe(subFn, "", subscriberTransportJSON, func() {})
 	s.topics["mytopic"].mu.Lock()
 	s.topics["mytopic"].lastAccess = time.Now().Add(-17 * time.Hour)
 	s.topics["mytopic"].mu.Unlock()
@@ -1511,6 +1771,76 @@ func TestServer_MatrixGateway_Push_Failure_EverythingIsWrong(t *testing.T) {
 	require.Equal(t, 40019, toHTTPError(t, response.Body.String()).Code)
 }
 
+func TestServer_MatrixGateway_Stats(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionReadWrite
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "private", user.PermissionDenyAll))
+
+	notification := `{"notification":{"devices":[{"app_id":"im.vector.app.android","pushkey":"http://127.0.0.1:12345/mytopic?up=1"}]}}`
+	response := request(t, s, "POST", "/_matrix/push/v1/notify", notification, nil)
+	require.Equal(t, `{"rejected":[]}`+"\n", response.Body.String())
+	notification = `{"notification":{"devices":[{"app_id":"im.vector.app.android","pushkey":"http://wrong-base-url.com/mytopic?up=1"}]}}`
+	response = request(t, s, "POST", "/_matrix/push/v1/notify", notification, nil)
+	require.Equal(t, `{"rejected":["http://wrong-base-url.com/mytopic?up=1"]}`+"\n", response.Body.String())
+	notification = `{"notification":{"devices":[{"pushkey":"http://127.0.0.1:12345/private?up=1"}]}}`
+	response = request(t, s, "POST", "/_matrix/push/v1/notify", notification, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/v1/matrix/pushkey?pushkey="+url.QueryEscape("http://127.0.0.1:12345/mytopic?up=1"), "", nil)
+	require.Equal(t, 200, response.Code)
+	stats, _ := util.UnmarshalJSON[apiMatrixPushKeyResponse](io.NopCloser(response.Body))
+	require.Equal(t, "im.vector.app.android", stats.AppID)
+	require.Equal(t, int64(1), stats.Delivered)
+	require.Equal(t, 0, len(stats.Failures))
+
+	response = request(t, s, "GET", "/v1/matrix/pushkey?pushkey="+url.QueryEscape("http://wrong-base-url.com/mytopic?up=1"), "", nil)
+	require.Equal(t, 200, response.Code)
+	stats, _ = util.UnmarshalJSON[apiMatrixPushKeyResponse](io.NopCloser(response.Body))
+	require.Equal(t, int64(1), stats.Rejected)
+	require.Equal(t, "rejected", stats.Failures[0].Outcome)
+	require.Contains(t, stats.Failures[0].Reason, "push key must be prefixed with base URL")
+
+	response = request(t, s, "GET", "/v1/matrix/pushkey?pushkey="+url.QueryEscape("http://127.0.0.1:12345/private?up=1"), "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/v1/matrix/pushkey?pushkey="+url.QueryEscape("http://127.0.0.1:12345/private?up=1"), "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	stats, _ = util.UnmarshalJSON[apiMatrixPushKeyResponse](io.NopCloser(response.Body))
+	require.Equal(t, int64(1), stats.Failed)
+	require.Equal(t, "failed", stats.Failures[0].Outcome)
+	require.Equal(t, "HTTP 403: forbidden (error code 40301)", stats.Failures[0].Reason)
+
+	response = request(t, s, "GET", "/v1/matrix/pushkey?pushkey="+url.QueryEscape("http://127.0.0.1:12345/unknown?up=1"), "", nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "GET", "/v1/matrix/pushkey", "", nil)
+	require.Equal(t, 40046, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/v1/matrix/stats", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "GET", "/v1/matrix/stats", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	matrixStats, _ := util.UnmarshalJSON[apiMatrixStatsResponse](io.NopCloser(response.Body))
+	require.Equal(t, 3, matrixStats.PushKeys)
+	require.Equal(t, 2, len(matrixStats.Apps))
+	require.Equal(t, "im.vector.app.android", matrixStats.Apps[1].AppID)
+	require.Equal(t, int64(1), matrixStats.Apps[1].Delivered)
+	require.Equal(t, int64(1), matrixStats.Apps[1].Rejected)
+	require.Equal(t, 2, len(matrixStats.Failures))
+}
+
 func TestServer_MatrixGateway_Push_Failure_Unconfigured(t *testing.T) {
 	c := newTestConfig(t)
 	c.BaseURL = ""
@@ -1610,6 +1940,105 @@ func TestServer_PublishAsJSON_Markdown(t *testing.T) {
 	require.Equal(t, "text/markdown", m.ContentType)
 }
 
+func TestServer_PublishSound(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", "backup done", map[string]string{
+		"X-Sound": "soft-beep",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "soft-beep", toMessage(t, response.Body.String()).Sound)
+
+	response = request(t, s, "PUT", "/", `{"topic":"mytopic","message":"server down","sound":"siren.caf"}`, nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "siren.caf", toMessage(t, response.Body.String()).Sound)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "soft-beep", messages[0].Sound)
+	require.Equal(t, "siren.caf", messages[1].Sound)
+
+	response = request(t, s, "PUT", "/mytopic?sound=../../etc/passwd", "invalid", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40045, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishInReplyTo(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", "disk full", nil)
+	require.Equal(t, 200, response.Code)
+	alert := toMessage(t, response.Body.String())
+
+	response = request(t, s, "PUT", "/mytopic", "looking at it", map[string]string{
+		"X-In-Reply-To": alert.ID,
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, alert.ID, toMessage(t, response.Body.String()).InReplyTo)
+
+	response = request(t, s, "PUT", "/", `{"topic":"mytopic","message":"fixed","in_reply_to":"`+alert.ID+`"}`, nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, alert.ID, toMessage(t, response.Body.String()).InReplyTo)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, "", messages[0].InReplyTo)
+	require.Equal(t, alert.ID, messages[1].InReplyTo)
+	require.Equal(t, alert.ID, messages[2].InReplyTo)
+
+	response = request(t, s, "PUT", "/mytopic?in-reply-to=../etc", "invalid", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40052, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishMetadata(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/fleet", "truck arrived", map[string]string{
+		"X-Location":       "52.52, 13.405",
+		"X-Hostname":       "truck-17",
+		"X-Correlation-ID": "TRIP-2024-0815",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, &location{Latitude: 52.52, Longitude: 13.405}, m.Location)
+	require.Equal(t, "truck-17", m.Hostname)
+	require.Equal(t, "TRIP-2024-0815", m.CorrelationID)
+	require.Contains(t, response.Body.String(), `"location":{"latitude":52.52,"longitude":13.405}`)
+
+	response = request(t, s, "PUT", "/", `{"topic":"fleet","message":"truck left","location":{"latitude":-33.8688,"longitude":0},"hostname":"truck-17","correlation_id":"TRIP-2024-0815"}`, nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, &location{Latitude: -33.8688, Longitude: 0}, toMessage(t, response.Body.String()).Location)
+
+	response = request(t, s, "PUT", "/fleet?loc=0,0", "no hostname", nil)
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/fleet/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, &location{Latitude: 52.52, Longitude: 13.405}, messages[0].Location)
+	require.Equal(t, "truck-17", messages[0].Hostname)
+	require.Equal(t, "TRIP-2024-0815", messages[0].CorrelationID)
+	require.Equal(t, &location{Latitude: -33.8688, Longitude: 0}, messages[1].Location)
+	require.Equal(t, "TRIP-2024-0815", messages[1].CorrelationID)
+	require.Equal(t, &location{Latitude: 0, Longitude: 0}, messages[2].Location)
+	require.Equal(t, "", messages[2].Hostname)
+
+	response = request(t, s, "PUT", "/fleet", "plain", nil)
+	require.Equal(t, 200, response.Code)
+	require.NotContains(t, response.Body.String(), "location")
+	require.NotContains(t, response.Body.String(), "hostname")
+	require.NotContains(t, response.Body.String(), "correlation_id")
+
+	for _, invalid := range []string{"91,0", "0,181", "52.52", "north,east"} {
+		response = request(t, s, "PUT", "/fleet", "invalid", map[string]string{"Location": invalid})
+		require.Equal(t, 40055, toHTTPError(t, response.Body.String()).Code)
+	}
+	response = request(t, s, "PUT", "/fleet?hostname=-bad%20host", "invalid", nil)
+	require.Equal(t, 40056, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/fleet", "invalid", map[string]string{"Correlation-ID": "has spaces"})
+	require.Equal(t, 40057, toHTTPError(t, response.Body.String()).Code)
+}
+
 func TestServer_PublishAsJSON_RateLimit_MessageDailyLimit(t *testing.T) {
 	// Publishing as JSON follows a different path. This ensures that rate
 	// limiting works for this endpoint as well
@@ -1781,6 +2210,79 @@ func TestServer_PublishAttachmentShortWithFilename(t *testing.T) {
 	require.Equal(t, int64(21), size)
 }
 
+func TestServer_PublishAttachmentSecurityHeaders(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	content := `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`
+	response := request(t, s, "PUT", "/mytopic?f=evil.svg", content, nil)
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, "image/svg+xml", msg.Attachment.Type)
+
+	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+	response = request(t, s, "GET", path, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "sandbox", response.Header().Get("Content-Security-Policy"))
+	require.Equal(t, "nosniff", response.Header().Get("X-Content-Type-Options"))
+	require.Equal(t, `attachment; filename="evil.svg"`, response.Header().Get("Content-Disposition"))
+	require.Equal(t, "image/svg+xml", response.Header().Get("Content-Type"))
+	require.Equal(t, content, response.Body.String())
+
+	// HEAD
+	response = request(t, s, "HEAD", path, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "sandbox", response.Header().Get("Content-Security-Policy"))
+	require.Equal(t, "nosniff", response.Header().Get("X-Content-Type-Options"))
+}
+
+func TestServer_PublishAttachmentStripHTML(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentStripHTML = true
+	s := newTestServer(t, c)
+	content := `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`
+	response := request(t, s, "PUT", "/mytopic?f=evil.svg", content, nil)
+	msg := toMessage(t, response.Body.String())
+
+	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+	response = request(t, s, "GET", path, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "text/plain; charset=utf-8", response.Header().Get("Content-Type"))
+	require.Equal(t, `attachment; filename="evil.svg"`, response.Header().Get("Content-Disposition"))
+	require.Equal(t, content, response.Body.String())
+}
+
+func TestServer_PublishAttachmentWithAttachmentBaseURL(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentBaseURL = "https://files.example.com"
+	s := newTestServer(t, c)
+	response := request(t, s, "PUT", "/mytopic?f=myfile.txt", "this is an ATTACHMENT", nil)
+	msg := toMessage(t, response.Body.String())
+	require.True(t, strings.HasPrefix(msg.Attachment.URL, "https://files.example.com/file/"))
+	path := strings.TrimPrefix(msg.Attachment.URL, "https://files.example.com")
+
+	// Downloads from the main host are redirected
+	response = request(t, s, "GET", path, "", nil, func(r *http.Request) {
+		r.Host = "127.0.0.1:12345"
+	})
+	require.Equal(t, 307, response.Code)
+	require.Equal(t, msg.Attachment.URL, response.Header().Get("Location"))
+
+	// Downloads from the attachment host are served
+	response = request(t, s, "GET", path, "", nil, func(r *http.Request) {
+		r.Host = "files.example.com"
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "this is an ATTACHMENT", response.Body.String())
+}
+
+func TestIsRiskyAttachmentType(t *testing.T) {
+	require.True(t, isRiskyAttachmentType("text/html; charset=utf-8"))
+	require.True(t, isRiskyAttachmentType("image/svg+xml"))
+	require.True(t, isRiskyAttachmentType("application/rss+xml"))
+	require.True(t, isRiskyAttachmentType("Application/XHTML+XML"))
+	require.False(t, isRiskyAttachmentType("text/plain; charset=utf-8"))
+	require.False(t, isRiskyAttachmentType("image/png"))
+	require.False(t, isRiskyAttachmentType(""))
+}
+
 func TestServer_PublishAttachmentExternalWithoutFilename(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
 	response := request(t, s, "PUT", "/mytopic", "", map[string]string{
@@ -1835,7 +2337,7 @@ func TestServer_PublishAttachmentTooLargeContentLength(t *testing.T) {
 	err := toHTTPError(t, response.Body.String())
 	require.Equal(t, 413, response.Code)
 	require.Equal(t, 413, err.HTTPCode)
-	require.Equal(t, 41301, err.Code)
+	require.Equal(t, 41304, err.Code)
 }
 
 func TestServer_PublishAttachmentTooLargeBodyAttachmentFileSizeLimit(t *testing.T) {
@@ -1847,7 +2349,8 @@ func TestServer_PublishAttachmentTooLargeBodyAttachmentFileSizeLimit(t *testing.
 	err := toHTTPError(t, response.Body.String())
 	require.Equal(t, 413, response.Code)
 	require.Equal(t, 413, err.HTTPCode)
-	require.Equal(t, 41301, err.Code)
+	require.Equal(t, 41304, err.Code)
+	require.Equal(t, "attachment too large, exceeds the file size limit; max. attachment size is 4.9 KB", err.Message)
 }
 
 func TestServer_PublishAttachmentExpiryBeforeDelivery(t *testing.T) {
@@ -1879,7 +2382,20 @@ func TestServer_PublishAttachmentTooLargeBodyVisitorAttachmentTotalSizeLimit(t *
 	err := toHTTPError(t, response.Body.String())
 	require.Equal(t, 413, response.Code)
 	require.Equal(t, 413, err.HTTPCode)
-	require.Equal(t, 41301, err.Code)
+	require.Equal(t, 41305, err.Code)
+	require.Equal(t, "attachment storage quota exceeded; 4.9 KB of 9.8 KB remaining", err.Message)
+}
+
+func TestServer_PublishAttachmentTooLargeContentLengthVisitorAttachmentTotalSizeLimit(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorAttachmentTotalSizeLimit = 10000
+	s := newTestServer(t, c)
+	response := request(t, s, "PUT", "/mytopic", util.RandomString(5000), map[string]string{
+		"Content-Length": "10001", // < AttachmentFileSizeLimit, > VisitorAttachmentTotalSizeLimit
+	})
+	err := toHTTPError(t, response.Body.String())
+	require.Equal(t, 413, response.Code)
+	require.Equal(t, 41305, err.Code)
 }
 
 func TestServer_PublishAttachmentAndExpire(t *testing.T) {
@@ -2025,18 +2541,18 @@ func TestServer_PublishAttachmentWithTierBasedLimits(t *testing.T) {
 	// Publish large file as anonymous
 	response = request(t, s, "PUT", "/mytopic", largeFile, nil)
 	require.Equal(t, 413, response.Code)
-	require.Equal(t, 41301, toHTTPError(t, response.Body.String()).Code)
+	require.Equal(t, 41304, toHTTPError(t, response.Body.String()).Code)
 
 	// Publish too large file as phil
 	response = request(t, s, "PUT", "/mytopic", largeFile+" a few more bytes", map[string]string{
 		"Authorization": util.BasicAuth("phil", "phil"),
 	})
 	require.Equal(t, 413, response.Code)
-	require.Equal(t, 41301, toHTTPError(t, response.Body.String()).Code)
+	require.Equal(t, 41304, toHTTPError(t, response.Body.String()).Code)
 
-	// Publish large file as phil (4x)
+	// Publish large file as phil (4x, different content, because identical attachments are only counted once)
 	for i := 0; i < 4; i++ {
-		response = request(t, s, "PUT", "/mytopic", largeFile, map[string]string{
+		response = request(t, s, "PUT", "/mytopic", util.RandomString(50_000), map[string]string{
 			"Authorization": util.BasicAuth("phil", "phil"),
 		})
 		require.Equal(t, 200, response.Code)
@@ -2048,7 +2564,46 @@ func TestServer_PublishAttachmentWithTierBasedLimits(t *testing.T) {
 		"Authorization": util.BasicAuth("phil", "phil"),
 	})
 	require.Equal(t, 413, response.Code)
-	require.Equal(t, 41301, toHTTPError(t, response.Body.String()).Code)
+	require.Equal(t, 41305, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishAttachmentDeduplicated(t *testing.T) {
+	content := util.RandomString(5000) // > 4096
+	c := newTestConfig(t)
+	c.VisitorAttachmentTotalSizeLimit = 12_000
+	s := newTestServer(t, c)
+
+	// Publish identical attachment 3x, content is only stored once
+	ids := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "PUT", fmt.Sprintf("/mytopic%d", i), content, nil)
+		require.Equal(t, 200, response.Code)
+		msg := toMessage(t, response.Body.String())
+		require.Equal(t, int64(5000), msg.Attachment.Size)
+		ids = append(ids, msg.ID)
+	}
+	require.Equal(t, int64(5000), s.fileCache.Size())
+
+	// Visitor quota only counts it once
+	response := request(t, s, "GET", "/v1/account", "", nil)
+	require.Equal(t, 200, response.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(response.Body))
+	require.Equal(t, int64(5000), account.Stats.AttachmentTotalSize)
+
+	// Different attachment is counted
+	response = request(t, s, "PUT", "/mytopic", util.RandomString(5000), nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, int64(10000), s.fileCache.Size())
+
+	// All attachments can be downloaded, even after the first one is deleted
+	require.Nil(t, s.fileCache.Remove(ids[0]))
+	require.Nil(t, s.messageCache.MarkAttachmentsDeleted(ids[0]))
+	for _, id := range ids[1:] {
+		response = request(t, s, "GET", "/file/"+id, "", nil)
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, content, response.Body.String())
+	}
+	require.Equal(t, int64(10000), s.fileCache.Size())
 }
 
 func TestServer_PublishAttachmentBandwidthLimit(t *testing.T) {
@@ -2143,6 +2698,59 @@ func TestServer_PublishAttachmentAccountStats(t *testing.T) {
 	require.Equal(t, int64(1), account.Stats.Messages)
 }
 
+func TestServer_PublishAttachmentUserAccountStats(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:                     "test",
+		MessageLimit:             10,
+		MessageExpiryDuration:    time.Hour,
+		AttachmentFileSizeLimit:  10_000,
+		AttachmentTotalSizeLimit: 15_000,
+		AttachmentExpiryDuration: time.Hour,
+		AttachmentBandwidthLimit: 100_000,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "test"))
+	headers := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+
+	// Attachment and upload are counted towards the stored per-user usage, identical content only once
+	content := util.RandomString(6000)
+	response := request(t, s, "PUT", "/mytopic", content, headers)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/v1/attachments", content, headers)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/v1/attachments", util.RandomString(5000), headers)
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/v1/account", "", headers)
+	require.Equal(t, 200, response.Code)
+	account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, int64(15_000), account.Limits.AttachmentTotalSize)
+	require.Equal(t, int64(11_000), account.Stats.AttachmentTotalSize)
+	require.Equal(t, int64(4_000), account.Stats.AttachmentTotalSizeRemaining)
+
+	// Quota is enforced based on the stored counter
+	response = request(t, s, "PUT", "/mytopic", util.RandomString(5000), headers)
+	require.Equal(t, 413, response.Code)
+	require.Equal(t, 41305, toHTTPError(t, response.Body.String()).Code)
+
+	// Expired uploads no longer count after the counter is recomputed
+	_, err = s.messageCache.db.Exec("UPDATE uploads SET expires = 1")
+	require.Nil(t, err)
+	s.pruneUploads()
+	s.recomputeAttachmentUsage()
+	response = request(t, s, "GET", "/v1/account", "", headers)
+	require.Equal(t, 200, response.Code)
+	account, err = util.UnmarshalJSON[apiAccountResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, int64(6_000), account.Stats.AttachmentTotalSize)
+	require.Equal(t, int64(9_000), account.Stats.AttachmentTotalSizeRemaining)
+}
+
 func TestServer_Visitor_XForwardedFor_None(t *testing.T) {
 	c := newTestConfig(t)
 	c.BehindProxy = true
@@ -2687,6 +3295,158 @@ func TestServer_UpstreamBaseURL_DoNotForwardUnifiedPush(t *testing.T) {
 	time.Sleep(500 * time.Millisecond)
 }
 
+func TestServer_Upstreams_Routing(t *testing.T) {
+	var euPollID, defaultPollID atomic.Pointer[string]
+	euServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer tk_eu", r.Header.Get("Authorization"))
+		euPollID.Store(util.String(r.Header.Get("X-Poll-ID")))
+	}))
+	defer euServer.Close()
+	defaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "", r.Header.Get("Authorization"))
+		defaultPollID.Store(util.String(r.Header.Get("X-Poll-ID")))
+	}))
+	defer defaultServer.Close()
+
+	c := newTestConfigWithAuthFile(t)
+	c.BaseURL = "http://myserver.internal"
+	c.Upstreams = []*Upstream{
+		{BaseURL: euServer.URL, AccessToken: "tk_eu", Topic: "eu-*"},
+	}
+	c.UpstreamBaseURL = defaultServer.URL
+	s := newTestServer(t, c)
+
+	// Matching topic is forwarded to the EU upstream server
+	response := request(t, s, "PUT", "/eu-alerts", `hi there`, nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	waitFor(t, func() bool {
+		pID := euPollID.Load()
+		return pID != nil && *pID == m.ID
+	})
+
+	// Other topics are forwarded to upstream-base-url
+	response = request(t, s, "PUT", "/us-alerts", `hi there`, nil)
+	require.Equal(t, 200, response.Code)
+	m = toMessage(t, response.Body.String())
+	waitFor(t, func() bool {
+		pID := defaultPollID.Load()
+		return pID != nil && *pID == m.ID
+	})
+	require.NotEqual(t, m.ID, *euPollID.Load())
+}
+
+func TestServer_Upstreams_NoMatch(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("Messages to non-matching topics should not be forwarded")
+	}))
+	defer upstreamServer.Close()
+
+	c := newTestConfigWithAuthFile(t)
+	c.BaseURL = "http://myserver.internal"
+	c.Upstreams = []*Upstream{
+		{BaseURL: upstreamServer.URL, Topic: "eu-*"},
+	}
+	s := newTestServer(t, c)
+	require.Nil(t, s.upstream("us-alerts"))
+
+	response := request(t, s, "PUT", "/us-alerts", `hi there`, nil)
+	require.Equal(t, 200, response.Code)
+
+	// Forwarding is done asynchronously, so wait a bit.
+	time.Sleep(500 * time.Millisecond)
+}
+
+func TestServer_Shutdown_SubscribersReconnect(t *testing.T) {
+	c := newTestConfig(t)
+	c.ShutdownTimeout = 2 * time.Second
+	s := newTestServer(t, c)
+
+	subscribeRR := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic,othertopic/json", subscribeRR)
+
+	start := time.Now()
+	s.Shutdown()
+	require.True(t, time.Since(start) < c.ShutdownTimeout)
+	subscribeCancel()
+
+	messages := toMessages(t, subscribeRR.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, openEvent, messages[0].Event)
+	require.Equal(t, reconnectEvent, messages[1].Event)
+	require.Equal(t, "mytopic,othertopic", messages[1].Topic)
+
+	s.Shutdown() // Calling it twice is fine
+}
+
+func TestServer_Shutdown_FlushMessageCache(t *testing.T) {
+	c := newTestConfig(t)
+	c.CacheBatchSize = 10
+	c.CacheBatchTimeout = time.Hour // Never written unless flushed
+	s := newTestServer(t, c)
+
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), nil)
+		require.Equal(t, 200, response.Code)
+	}
+	s.Shutdown()
+
+	cache, err := newSqliteCache(c.CacheFile, "", time.Hour, 0, 0, false)
+	require.Nil(t, err)
+	defer cache.Close()
+	messages, err := cache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, "message 0", messages[0].Message)
+	require.Equal(t, "message 2", messages[2].Message)
+}
+
+func TestServer_ListenReusePort_Handoff(t *testing.T) {
+	listenHTTP := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	startServer := func() *Server {
+		c := newTestConfig(t)
+		c.ListenHTTP = listenHTTP
+		c.ListenReusePort = true
+		s := newTestServer(t, c)
+		go s.Run()
+		return s
+	}
+	healthy := func() bool {
+		resp, err := http.Get("http://" + listenHTTP + "/v1/health")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}
+
+	// Old process is running
+	oldServer := startServer()
+	waitFor(t, healthy)
+
+	// New process binds to the same address, old process drains and exits
+	newServer := startServer()
+	time.Sleep(200 * time.Millisecond)
+	oldServer.Shutdown()
+	require.True(t, healthy())
+	newServer.Shutdown()
+	require.False(t, healthy())
+}
+
+func TestServer_ListenReusePort_Disabled(t *testing.T) {
+	c := newTestConfig(t)
+	c.ListenHTTP = fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	s1 := newTestServer(t, c)
+	go s1.Run()
+	defer s1.Shutdown()
+	time.Sleep(200 * time.Millisecond)
+
+	c2 := newTestConfig(t)
+	c2.ListenHTTP = c.ListenHTTP
+	s2 := newTestServer(t, c2)
+	require.ErrorContains(t, s2.Run(), "address already in use")
+}
+
 func newTestConfig(t *testing.T) *Config {
 	conf := NewConfig()
 	conf.BaseURL = "http://127.0.0.1:12345"
@@ -2763,6 +3523,13 @@ func subscribe(t *testing.T, s *Server, url string, rr *httptest.ResponseRecorde
 	return cancelAndWaitForDone
 }
 
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
 func toMessages(t *testing.T, s string) []*message {
 	messages := make([]*message, 0)
 	scanner := bufio.NewScanner(strings.NewReader(s))
@@ -2806,3 +3573,111 @@ func waitForWithMaxWait(t *testing.T, maxWait time.Duration, f func() bool) {
 	}
 	t.Fatalf("Function f did not succeed after %v: %v", maxWait, string(debug.Stack()))
 }
+
+func TestServer_SubscriberBacklog_Drop(t *testing.T) {
+	c := newTestConfig(t)
+	c.SubscriberBacklogLimit = 2
+	s := newTestServer(t, c)
+
+	var canceled atomic.Bool
+	release := make(chan struct{})
+	sub := s.limitSubscriberBacklog(s.visitor(netip.IPv4Unspecified(), nil), httptest.NewRequest("GET", "/mytopic/json", nil), func(v *visitor, msg *message) error {
+		<-release // Client does not read
+		return nil
+	}, func() {
+		canceled.Store(true)
+	})
+	for i := 0; i < 2; i++ {
+		go sub(nil, newDefaultMessage("mytopic", "blocked"))
+	}
+	time.Sleep(100 * time.Millisecond)
+	require.False(t, canceled.Load())
+	require.Nil(t, sub(nil, newDefaultMessage("mytopic", "too much")))
+	require.True(t, canceled.Load())
+	close(release)
+}
+
+func TestServer_SubscriberBacklog_Poll(t *testing.T) {
+	c := newTestConfig(t)
+	c.SubscriberBacklogLimit = 2
+	c.SubscriberSlowAction = SubscriberSlowActionPoll
+	s := newTestServer(t, c)
+
+	var mu sync.Mutex
+	received := make([]*message, 0)
+	release := make(chan struct{})
+	sub := s.limitSubscriberBacklog(s.visitor(netip.IPv4Unspecified(), nil), httptest.NewRequest("GET", "/mytopic/json", nil), func(v *visitor, msg *message) error {
+		if msg.Event == messageEvent {
+			<-release // Client is slow
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, msg)
+		return nil
+	}, func() {
+		t.Fatal("connection must not be closed")
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.Nil(t, sub(nil, newDefaultMessage("mytopic", "slow")))
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	require.Nil(t, sub(nil, newDefaultMessage("mytopic", "skipped")))
+	close(release)
+	wg.Wait()
+
+	// Skipped message is not delivered, but a poll request is sent once the client caught up
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 3, len(received))
+	require.Equal(t, "slow", received[0].Message)
+	require.Equal(t, "slow", received[1].Message)
+	require.Equal(t, pollRequestEvent, received[2].Event)
+	require.Equal(t, "mytopic", received[2].Topic)
+}
+
+func TestServer_SubscriberBacklog_Poll_MultipleTopics(t *testing.T) {
+	c := newTestConfig(t)
+	c.SubscriberBacklogLimit = 1
+	c.SubscriberSlowAction = SubscriberSlowActionPoll
+	s := newTestServer(t, c)
+
+	var mu sync.Mutex
+	received := make([]*message, 0)
+	release := make(chan struct{})
+	sub := s.limitSubscriberBacklog(s.visitor(netip.IPv4Unspecified(), nil), httptest.NewRequest("GET", "/mytopic,othertopic,thirdtopic/json", nil), func(v *visitor, msg *message) error {
+		if msg.Event == messageEvent {
+			<-release // Client is slow
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, msg)
+		return nil
+	}, func() {
+		t.Fatal("connection must not be closed")
+	})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		require.Nil(t, sub(nil, newDefaultMessage("mytopic", "slow")))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	require.Nil(t, sub(nil, newDefaultMessage("mytopic", "skipped")))
+	require.Nil(t, sub(nil, newDefaultMessage("othertopic", "skipped")))
+	close(release)
+	wg.Wait()
+
+	// One poll request per topic with skipped messages, none for topics without skipped messages
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 3, len(received))
+	require.Equal(t, "slow", received[0].Message)
+	require.ElementsMatch(t, []string{"mytopic", "othertopic"}, []string{received[1].Topic, received[2].Topic})
+	require.Equal(t, pollRequestEvent, received[1].Event)
+	require.Equal(t, pollRequestEvent, received[2].Event)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+)
+
+const (
+	threadMaxDepth = 100 // Max number of parent messages that are followed to find the first message of a thread
+)
+
+// handleMessageThread returns all messages of the thread the given message belongs to, oldest first. The thread
+// starts at the first message (following the in_reply_to references of the message, as long as the parent messages
+// are still cached), and includes all direct and indirect replies to it in the same topic.
+func (s *Server) handleMessageThread(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	matches := messageThreadPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	root, err := s.cachedMessage(topic, matches[2])
+	if err != nil {
+		return err
+	}
+	for i := 0; i < threadMaxDepth && root.InReplyTo != ""; i++ {
+		parent, err := s.messageCache.Message(root.InReplyTo)
+		if errors.Is(err, errMessageNotFound) || (err == nil && parent.Topic != topic) {
+			break // Parent message expired, or belongs to a different topic
+		} else if err != nil {
+			return err
+		}
+		root = parent
+	}
+	messages, err := s.messageCache.Thread(topic, root.ID)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiMessageThreadResponse{
+		ID:       root.ID,
+		Topic:    topic,
+		Messages: messages,
+	})
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"testing"
+)
+
+func TestServer_MessageThread(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	publish := func(topic, body, inReplyTo string) *message {
+		response := request(t, s, "PUT", "/"+topic, body, map[string]string{
+			"X-In-Reply-To": inReplyTo,
+		})
+		require.Equal(t, 200, response.Code)
+		return toMessage(t, response.Body.String())
+	}
+	alert := publish("alerts", "disk full", "")
+	ack := publish("alerts", "looking at it", alert.ID)
+	fixed := publish("alerts", "fixed, deleted old backups", ack.ID)
+	publish("alerts", "unrelated", "")
+	publish("othertopic", "reply in other topic", alert.ID)
+
+	// All messages of a thread are returned, no matter which message is requested
+	for _, id := range []string{alert.ID, ack.ID, fixed.ID} {
+		response := request(t, s, "GET", "/alerts/"+id+"/thread", "", nil)
+		require.Equal(t, 200, response.Code)
+		thread, err := util.UnmarshalJSON[apiMessageThreadResponse](io.NopCloser(response.Body))
+		require.Nil(t, err)
+		require.Equal(t, alert.ID, thread.ID)
+		require.Equal(t, "alerts", thread.Topic)
+		require.Equal(t, 3, len(thread.Messages))
+		require.Equal(t, "disk full", thread.Messages[0].Message)
+		require.Equal(t, "looking at it", thread.Messages[1].Message)
+		require.Equal(t, ack.ID, thread.Messages[2].InReplyTo)
+	}
+
+	// If the first message expired, the thread starts at the oldest cached message
+	require.Nil(t, s.messageCache.DeleteMessages(alert.ID))
+	response := request(t, s, "GET", "/alerts/"+fixed.ID+"/thread", "", nil)
+	require.Equal(t, 200, response.Code)
+	thread, err := util.UnmarshalJSON[apiMessageThreadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, ack.ID, thread.ID)
+	require.Equal(t, 2, len(thread.Messages))
+
+	// Unknown message, or message in another topic
+	response = request(t, s, "GET", "/alerts/"+alert.ID+"/thread", "", nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "GET", "/othertopic/"+ack.ID+"/thread", "", nil)
+	require.Equal(t, 404, response.Code)
+}
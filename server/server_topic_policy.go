@@ -0,0 +1,72 @@
+package server
+
+import (
+	"unicode/utf8"
+
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// enforceTopicPolicy checks the message against the policy of the reservation covering its topic (see user.TopicPolicy),
+// before the body is read. Since the message text is not known yet, the message size limit must be checked by the
+// caller after the body was read; the policy is returned for that purpose. If the topic has no policy, nil is returned.
+// The body may be nil if the message was not published via HTTP, e.g. for forwarded messages.
+func (s *Server) enforceTopicPolicy(m *message, body *util.PeekedReadCloser, unifiedpush bool) (*user.TopicPolicy, error) {
+	if s.userManager == nil || m.PollID != "" {
+		return nil, nil
+	}
+	policy, err := s.userManager.ReservationPolicy(m.Topic)
+	if err != nil {
+		return nil, err
+	} else if policy == nil {
+		return nil, nil
+	}
+	if len(policy.Priorities) > 0 && !util.Contains(policy.Priorities, messagePriority(m)) {
+		return nil, errHTTPBadRequestTopicPolicyPriority.With(m)
+	}
+	if policy.NoAttachments {
+		// Mirrors the cases in handlePublishBody in which the body is stored as an attachment
+		bodyIsAttachment := body != nil && !unifiedpush && (body.LimitReached || !utf8.Valid(body.PeekedBytes))
+		if m.Attachment != nil || bodyIsAttachment {
+			return nil, errHTTPBadRequestTopicPolicyAttachment.With(m)
+		}
+	}
+	return policy, nil
+}
+
+// validateTopicPolicy checks the topic policy of a reservation request, and converts it to the policy stored
+// in the user database. The message size limit cannot exceed the server-wide message limit.
+func (s *Server) validateTopicPolicy(policy *apiTopicPolicy) (*user.TopicPolicy, error) {
+	if policy == nil {
+		return nil, nil
+	} else if policy.MaxMessageSize < 0 || policy.MaxMessageSize > s.config.MessageLimit {
+		return nil, errHTTPBadRequestTopicPolicyInvalid
+	}
+	priorities := make([]int, 0, len(policy.Priorities))
+	for _, p := range policy.Priorities {
+		if p < 1 || p > 5 || util.Contains(priorities, p) {
+			return nil, errHTTPBadRequestTopicPolicyInvalid
+		}
+		priorities = append(priorities, p)
+	}
+	topicPolicy := &user.TopicPolicy{
+		MaxMessageSize: policy.MaxMessageSize,
+		NoAttachments:  policy.NoAttachments,
+		Priorities:     priorities,
+	}
+	if topicPolicy.IsZero() {
+		return nil, nil
+	}
+	return topicPolicy, nil
+}
+
+func toAPITopicPolicy(policy *user.TopicPolicy) *apiTopicPolicy {
+	if policy.IsZero() {
+		return nil
+	}
+	return &apiTopicPolicy{
+		MaxMessageSize: policy.MaxMessageSize,
+		NoAttachments:  policy.NoAttachments,
+		Priorities:     policy.Priorities,
+	}
+}
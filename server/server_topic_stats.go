@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handleTopicStats returns the number of active subscribers of a topic per transport, the message rate and the time
+// of the last activity, so publishers can tell whether anyone is listening. Only the owner of the topic (the user that
+// reserved it) and admins are allowed to see the stats of a topic.
+func (s *Server) handleTopicStats(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := apiTopicStatsRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topicID := matches[1]
+	if owner, err := s.ownsTopic(v, topicID); err != nil {
+		return err
+	} else if !owner {
+		return errHTTPForbidden
+	}
+	response := &apiTopicStatsResponse{
+		Topic:       topicID,
+		Subscribers: &apiTopicStatsSubscribers{},
+	}
+	s.mu.RLock()
+	t, exists := s.topics[topicID]
+	s.mu.RUnlock()
+	if exists {
+		stats := t.DetailedStats()
+		response.Subscribers.JSON = stats.Subscribers[subscriberTransportJSON]
+		response.Subscribers.SSE = stats.Subscribers[subscriberTransportSSE]
+		response.Subscribers.Raw = stats.Subscribers[subscriberTransportRaw]
+		response.Subscribers.WebSocket = stats.Subscribers[subscriberTransportWS]
+		response.Subscribers.Firebase = stats.Pollers
+		response.MessagesLastHour = stats.Messages
+		response.MessageRate = float64(stats.Messages) / topicRateBuckets
+		if !stats.LastMessage.IsZero() {
+			response.LastMessage = stats.LastMessage.Unix()
+		}
+		response.LastAccess = stats.LastAccess.Unix()
+	}
+	if s.webPush != nil {
+		subscriptions, err := s.webPush.SubscriptionsForTopic(topicID)
+		if err != nil {
+			return err
+		}
+		response.Subscribers.WebPush = len(subscriptions)
+	}
+	response.Subscribers.Total = response.Subscribers.JSON + response.Subscribers.SSE + response.Subscribers.Raw +
+		response.Subscribers.WebSocket + response.Subscribers.WebPush + response.Subscribers.Firebase
+	return s.writeJSON(w, response)
+}
+
+// ownsTopic returns true if the visitor is an admin, or if the topic is reserved by the visitor's user
+func (s *Server) ownsTopic(v *visitor, topic string) (bool, error) {
+	u := v.User()
+	if u == nil {
+		return false, nil
+	} else if u.IsAdmin() {
+		return true, nil
+	}
+	ownerUserID, err := s.userManager.ReservationOwner(topic)
+	if err != nil {
+		return false, err
+	}
+	return ownerUserID == u.ID, nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_TopicStats(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionReadWrite))
+
+	subscribeResponse := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/sse", subscribeResponse)
+	defer subscribeCancel()
+	response := request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, 200, response.Code)
+	for i := 0; i < 3; i++ {
+		response = request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, response.Code)
+	}
+
+	response = request(t, s, "GET", "/v1/topics/mytopic/stats", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	stats, err := util.UnmarshalJSON[apiTopicStatsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "mytopic", stats.Topic)
+	require.Equal(t, 2, stats.Subscribers.Total)
+	require.Equal(t, 1, stats.Subscribers.SSE)
+	require.Equal(t, 0, stats.Subscribers.JSON)
+	require.Equal(t, 1, stats.Subscribers.Firebase)
+	require.Equal(t, int64(3), stats.MessagesLastHour)
+	require.Equal(t, 0.05, stats.MessageRate)
+	require.True(t, stats.LastMessage > 0)
+	require.True(t, stats.LastAccess >= stats.LastMessage)
+}
+
+func TestServer_TopicStats_AccessControl(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("emma", "emma", user.RoleUser))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionDenyAll))
+
+	// Owner and admin
+	response := request(t, s, "GET", "/v1/topics/mytopic/stats", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/v1/topics/mytopic/stats", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	stats, err := util.UnmarshalJSON[apiTopicStatsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 0, stats.Subscribers.Total)
+	require.Equal(t, int64(0), stats.LastMessage)
+
+	// Other users, unreserved topics and anonymous visitors
+	response = request(t, s, "GET", "/v1/topics/mytopic/stats", "", map[string]string{
+		"Authorization": util.BasicAuth("emma", "emma"),
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "GET", "/v1/topics/othertopic/stats", "", map[string]string{
+		"Authorization": util.BasicAuth("emma", "emma"),
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "GET", "/v1/topics/mytopic/stats", "", nil)
+	require.Equal(t, 401, response.Code)
+}
+
+func TestServer_TopicStats_NoUserManager(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "GET", "/v1/topics/mytopic/stats", "", nil)
+	require.Equal(t, 404, response.Code)
+}
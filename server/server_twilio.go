@@ -59,26 +59,33 @@ func (s *Server) convertPhoneNumber(u *user.User, phoneNumber string) (string, *
 }
 
 // callPhone calls the Twilio API to make a phone call to the given phone number, using the given message.
-// Failures will be logged, but not returned to the caller.
+// Failures will be logged, but not returned to the caller. The request may be nil, e.g. for escalations.
 func (s *Server) callPhone(v *visitor, r *http.Request, m *message, to string) {
 	u, sender := v.User(), m.Sender.String()
 	if u != nil {
 		sender = u.Name
 	}
+	logEvent := logvm(v, m)
+	if r != nil {
+		logEvent = logvrm(v, r, m)
+	}
 	body := fmt.Sprintf(twilioCallFormat, xmlEscapeText(m.Topic), xmlEscapeText(m.Message), xmlEscapeText(sender))
 	data := url.Values{}
 	data.Set("From", s.config.TwilioPhoneNumber)
 	data.Set("To", to)
 	data.Set("Twiml", body)
-	ev := logvrm(v, r, m).Tag(tagTwilio).Field("twilio_to", to).FieldIf("twilio_body", body, log.TraceLevel).Debug("Sending Twilio request")
+	ev := logEvent.Tag(tagTwilio).Field("twilio_to", to).FieldIf("twilio_body", body, log.TraceLevel).Debug("Sending Twilio request")
 	response, err := s.callPhoneInternal(data)
 	if err != nil {
 		ev.Field("twilio_response", response).Err(err).Warn("Error sending Twilio request")
 		minc(metricCallsMadeFailure)
+		s.statsHistory.DeliveryFailed()
+		s.recordDelivery(m, deliveryChannelCall, 1, err)
 		return
 	}
 	ev.FieldIf("twilio_response", response, log.TraceLevel).Debug("Received successful Twilio response")
 	minc(metricCallsMadeSuccess)
+	s.recordDelivery(m, deliveryChannelCall, 1, nil)
 }
 
 func (s *Server) callPhoneInternal(data url.Values) (string, error) {
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	uploadIDPrefix = "up_"
+	uploadIDLength = messageIDLength // Uploads are stored in the file cache, see fileIDRegex
+)
+
+var (
+	uploadIDRegex = regexp.MustCompile(fmt.Sprintf(`^%s[A-Za-z0-9]{%d}$`, uploadIDPrefix, uploadIDLength-len(uploadIDPrefix)))
+)
+
+// handleAttachmentUpload stores the request body as attachment in the file cache, without publishing a message.
+// The returned ID can then be referenced by one or more messages (on any topic) via the "Attachment-ID" header,
+// see handleBodyWithUploadedAttachment. This takes large uploads out of the publish request, and allows sending
+// the same file to multiple topics while storing it only once.
+//
+// Uploads count towards the visitor's attachment quota, and expire like regular attachments. They cannot be
+// downloaded directly, only via the attachment URL of a message referencing them.
+func (s *Server) handleAttachmentUpload(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if s.fileCache == nil || s.config.BaseURL == "" || s.config.AttachmentCacheDir == "" {
+		return errHTTPBadRequestAttachmentsDisallowed
+	}
+	vinfo, err := v.Info()
+	if err != nil {
+		return err
+	}
+	contentLengthStr := r.Header.Get("Content-Length")
+	if contentLengthStr != "" { // Early "do-not-trust" check, hard limit see below
+		contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
+		if err == nil && (contentLength > vinfo.Stats.AttachmentTotalSizeRemaining || contentLength > vinfo.Limits.AttachmentFileSizeLimit) {
+			return s.attachmentLimitError(v, vinfo, contentLength > vinfo.Limits.AttachmentFileSizeLimit).Fields(log.Context{
+				"message_content_length":          contentLength,
+				"attachment_total_size_remaining": vinfo.Stats.AttachmentTotalSizeRemaining,
+				"attachment_file_size_limit":      vinfo.Limits.AttachmentFileSizeLimit,
+			})
+		}
+	}
+	body, err := util.Peek(r.Body, int(vinfo.Limits.MessageSizeLimit))
+	if err != nil {
+		return err
+	}
+	u := &upload{
+		ID:      util.RandomStringPrefix(uploadIDPrefix, uploadIDLength),
+		User:    v.MaybeUserID(),
+		Sender:  v.IP(),
+		Name:    readParam(r, "x-filename", "filename", "file", "f"),
+		Expires: time.Now().Add(vinfo.Limits.AttachmentExpiryDuration).Unix(),
+	}
+	u.Type, u.Ext = util.DetectContentType(body.PeekedBytes, u.Name)
+	if u.Name == "" {
+		u.Name = fmt.Sprintf("attachment%s", u.Ext)
+	}
+	fileSizeLimiter := util.NewFixedLimiter(vinfo.Limits.AttachmentFileSizeLimit)
+	totalSizeLimiter := util.NewFixedLimiter(vinfo.Stats.AttachmentTotalSizeRemaining)
+	limiters := []util.Limiter{
+		v.BandwidthLimiter(),
+		fileSizeLimiter,
+		totalSizeLimiter,
+	}
+	u.Size, u.Hash, err = s.fileCache.Write(u.ID, body, limiters...)
+	if err == util.ErrLimitReached {
+		if fileSizeLimiter.Rejected() || totalSizeLimiter.Rejected() {
+			return s.attachmentLimitError(v, vinfo, fileSizeLimiter.Rejected())
+		}
+		return errHTTPEntityTooLargeAttachment // Bandwidth limit, or global attachment cache limit
+	} else if err != nil {
+		return err
+	}
+	if existingID, err := s.messageCache.AttachmentByHash(u.Hash); err == nil {
+		if err := s.fileCache.Link(u.ID, existingID); err != nil {
+			logvr(v, r).Tag(tagFileCache).Err(err).Warn("Cannot link upload %s to existing attachment %s", u.ID, existingID)
+		}
+	}
+	if err := s.messageCache.AddAttachmentUsage(u.User, u.Size, u.Hash); err != nil {
+		logvr(v, r).Tag(tagPublish).Err(err).Warn("Cannot update attachment usage")
+	}
+	if err := s.messageCache.AddUpload(u); err != nil {
+		_ = s.fileCache.Remove(u.ID)
+		return err
+	}
+	logvr(v, r).
+		Tag(tagPublish).
+		Fields(log.Context{
+			"upload_id":   u.ID,
+			"upload_size": u.Size,
+		}).
+		Debug("Received attachment upload %s", u.ID)
+	s.maybeSendQuotaWarning(v, quotaAttachmentTotalSize, vinfo.Stats.AttachmentTotalSize+u.Size, vinfo.Limits.AttachmentTotalSizeLimit)
+	return s.writeJSON(w, &apiAttachmentUploadResponse{
+		ID:      u.ID,
+		Name:    u.Name,
+		Type:    u.Type,
+		Size:    u.Size,
+		Expires: u.Expires,
+	})
+}
+
+// handleBodyWithUploadedAttachment attaches a previously uploaded file to the message (see handleAttachmentUpload),
+// and treats the body as the message. The file is hard-linked in the file cache, so the attachment of the message
+// lives on even if the upload expires, and the content is only stored (and counted towards the quota) once.
+//
+// Uploads can only be referenced by the visitor who uploaded them.
+func (s *Server) handleBodyWithUploadedAttachment(v *visitor, m *message, body *util.PeekedReadCloser, id string, dryRun bool) error {
+	if s.fileCache == nil || s.config.BaseURL == "" || s.config.AttachmentCacheDir == "" {
+		return errHTTPBadRequestAttachmentsDisallowed.With(m)
+	}
+	u, err := s.messageCache.Upload(id)
+	if errors.Is(err, errUploadNotFound) {
+		return errHTTPNotFoundAttachmentUpload.With(m)
+	} else if err != nil {
+		return err
+	} else if u.User != v.MaybeUserID() || (u.User == "" && u.Sender != v.IP()) {
+		return errHTTPNotFoundAttachmentUpload.With(m) // Do not reveal that the upload exists
+	}
+	vinfo, err := v.Info()
+	if err != nil {
+		return err
+	}
+	attachmentExpiry := time.Now().Add(vinfo.Limits.AttachmentExpiryDuration).Unix()
+	if m.Time > attachmentExpiry {
+		return errHTTPBadRequestAttachmentsExpiryBeforeDelivery.With(m)
+	}
+	if m.Attachment.Name == "" {
+		m.Attachment.Name = u.Name
+	}
+	m.Attachment.Type = u.Type
+	m.Attachment.Size = u.Size
+	m.Attachment.Hash = u.Hash
+	m.Attachment.Expires = attachmentExpiry
+	m.Attachment.URL = fmt.Sprintf("%s/file/%s%s", s.attachmentBaseURL(), m.ID, u.Ext)
+	if !dryRun {
+		if err := s.fileCache.Link(m.ID, u.ID); err != nil {
+			return err
+		}
+		logvm(v, m).Tag(tagFileCache).Debug("Attaching upload %s to message", u.ID)
+	}
+	return s.handleBodyAsTextMessage(m, body)
+}
+
+// readAttachmentIDParam returns the ID of a separately uploaded attachment, see handleAttachmentUpload
+func readAttachmentIDParam(r *http.Request) string {
+	return readParam(r, "x-attachment-id", "attachment-id")
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_AttachmentUpload(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	content := util.RandomString(5000)
+
+	response := request(t, s, "PUT", "/v1/attachments", content, map[string]string{
+		"Filename": "build.log",
+	})
+	require.Equal(t, 200, response.Code)
+	upload, err := util.UnmarshalJSON[apiAttachmentUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Regexp(t, `^up_[A-Za-z0-9]{9}$`, upload.ID)
+	require.Equal(t, "build.log", upload.Name)
+	require.Equal(t, "text/plain; charset=utf-8", upload.Type)
+	require.Equal(t, int64(5000), upload.Size)
+	require.GreaterOrEqual(t, upload.Expires, time.Now().Add(179*time.Minute).Unix())
+	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, upload.ID))
+
+	// Uploads cannot be downloaded directly
+	response = request(t, s, "GET", "/file/"+upload.ID, "", nil)
+	require.Equal(t, 404, response.Code)
+
+	// Reference the upload from multiple messages, via header, query parameter and JSON
+	response = request(t, s, "POST", "/builds", "Build #42 failed", map[string]string{
+		"Attachment-ID": upload.ID,
+	})
+	require.Equal(t, 200, response.Code)
+	m1 := toMessage(t, response.Body.String())
+	require.Equal(t, "Build #42 failed", m1.Message)
+	require.Equal(t, "build.log", m1.Attachment.Name)
+	require.Equal(t, "text/plain; charset=utf-8", m1.Attachment.Type)
+	require.Equal(t, int64(5000), m1.Attachment.Size)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m1.ID+".txt", m1.Attachment.URL)
+
+	response = request(t, s, "PUT", "/alerts?attachment-id="+upload.ID+"&filename=failure.txt", "", nil)
+	require.Equal(t, 200, response.Code)
+	m2 := toMessage(t, response.Body.String())
+	require.Equal(t, "You received a file: failure.txt", m2.Message)
+	require.Equal(t, "failure.txt", m2.Attachment.Name)
+
+	response = request(t, s, "POST", "/", fmt.Sprintf(`{"topic":"ops","message":"See log","attachment_id":"%s"}`, upload.ID), nil)
+	require.Equal(t, 200, response.Code)
+	m3 := toMessage(t, response.Body.String())
+	require.Equal(t, "ops", m3.Topic)
+	require.Equal(t, int64(5000), m3.Attachment.Size)
+
+	for _, m := range []*message{m1, m2, m3} {
+		response = request(t, s, "GET", strings.TrimPrefix(m.Attachment.URL, "http://127.0.0.1:12345"), "", nil)
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, content, response.Body.String())
+	}
+
+	// Content is counted towards the quota only once
+	size, err := s.messageCache.AttachmentBytesUsedBySender("9.9.9.9") // See request()
+	require.Nil(t, err)
+	require.Equal(t, int64(5000), size)
+}
+
+func TestServer_AttachmentUpload_Invalid(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentFileSizeLimit = 1000
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/v1/attachments", util.RandomString(1001), nil)
+	require.Equal(t, 413, response.Code)
+	require.Equal(t, 41304, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/v1/attachments", "small file", nil)
+	require.Equal(t, 200, response.Code)
+	upload, err := util.UnmarshalJSON[apiAttachmentUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "attachment.txt", upload.Name)
+
+	// Invalid, unknown, or combined with an external URL
+	response = request(t, s, "POST", "/mytopic", "hi", map[string]string{"Attachment-ID": "../../etc/passwd"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40076, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/mytopic", "hi", map[string]string{
+		"Attachment-ID": upload.ID,
+		"Attach":        "https://example.com/file.jpg",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40076, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/mytopic", "hi", map[string]string{"Attachment-ID": "up_abcdefghi"})
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40412, toHTTPError(t, response.Body.String()).Code)
+
+	// Only the uploader can reference an upload
+	response = request(t, s, "POST", "/mytopic", "hi", map[string]string{"Attachment-ID": upload.ID}, func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4"
+	})
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40412, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_AttachmentUpload_Expired(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/v1/attachments", "some log", nil)
+	require.Equal(t, 200, response.Code)
+	upload, err := util.UnmarshalJSON[apiAttachmentUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	response = request(t, s, "POST", "/mytopic", "", map[string]string{"Attachment-ID": upload.ID})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Expired uploads are removed, but messages keep their attachment
+	_, err = s.messageCache.db.Exec("UPDATE uploads SET expires = 1")
+	require.Nil(t, err)
+	s.pruneUploads()
+	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, upload.ID))
+	contents, err := os.ReadFile(filepath.Join(s.config.AttachmentCacheDir, m.ID))
+	require.Nil(t, err)
+	require.Equal(t, "some log", string(contents))
+
+	response = request(t, s, "POST", "/mytopic", "", map[string]string{"Attachment-ID": upload.ID})
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40412, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_AttachmentUpload_Disallowed(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentCacheDir = ""
+	s := newTestServer(t, c)
+	response := request(t, s, "PUT", "/v1/attachments", "some log", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40014, toHTTPError(t, response.Body.String()).Code)
+}
@@ -0,0 +1,31 @@
+package server
+
+// Upstream is an upstream server that poll requests are forwarded to, so that iOS devices are notified about
+// new messages, see Config.Upstreams. If Topic is set, only messages to matching topics are forwarded.
+type Upstream struct {
+	BaseURL     string // Base URL of the upstream server, e.g. https://ntfy.sh
+	AccessToken string // Access token for the upstream server, may be empty
+	Topic       string // Topic name or prefix (e.g. "eu-*"), empty matches all topics
+}
+
+// Matches returns true if poll requests for the given topic should be forwarded to this upstream server
+func (u *Upstream) Matches(topic string) bool {
+	return u.Topic == "" || topicMatches(u.Topic, topic)
+}
+
+// upstream returns the first matching upstream server for the given topic from Config.Upstreams, falling back
+// to Config.UpstreamBaseURL, or nil if poll requests for this topic should not be forwarded
+func (s *Server) upstream(topic string) *Upstream {
+	for _, u := range s.config.Upstreams {
+		if u.Matches(topic) {
+			return u
+		}
+	}
+	if s.config.UpstreamBaseURL != "" {
+		return &Upstream{
+			BaseURL:     s.config.UpstreamBaseURL,
+			AccessToken: s.config.UpstreamAccessToken,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handlePublishValidate parses and validates a publish request without delivering the message, so that
+// notification templates can be checked (e.g. in CI pipelines) before they are used. The request is either a
+// JSON message (like POST /), or a regular publish request with headers/query params, where the topic is passed
+// via the X-Topic header or ?topic= query param. The request runs through the same parsing and access control
+// checks as a real publish request. Validation errors are returned in the response body, and not as HTTP error.
+func (s *Server) handlePublishValidate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	var validated *message
+	next := s.limitRequestsWithTopic(s.authorizeTopicWrite(func(_ http.ResponseWriter, r *http.Request, v *visitor) error {
+		m, err := s.handlePublishInternal(r, v, true)
+		if err != nil {
+			return err
+		}
+		validated = m
+		return nil
+	}))
+	if topic := readParam(r, "x-topic", "topic"); topic != "" {
+		if !topicRegex.MatchString(topic) {
+			return s.writeJSON(w, newPublishValidateErrorResponse(errHTTPBadRequestTopicInvalid))
+		}
+		r.URL.Path = "/" + topic
+	} else {
+		next = s.transformBodyJSON(next)
+	}
+	if err := next(w, r, v); err != nil {
+		httpErr, ok := err.(*errHTTP)
+		if !ok || httpErr.HTTPCode == http.StatusTooManyRequests || httpErr.HTTPCode >= 500 {
+			return err // Rate limits and server errors are not validation errors
+		}
+		logvr(v, r).Tag(tagPublish).Err(httpErr).Debug("Publish request validation failed")
+		return s.writeJSON(w, newPublishValidateErrorResponse(httpErr))
+	}
+	return s.writeJSON(w, &apiPublishValidateResponse{
+		Valid:   true,
+		Message: validated,
+	})
+}
+
+func newPublishValidateErrorResponse(err *errHTTP) *apiPublishValidateResponse {
+	return &apiPublishValidateResponse{
+		Valid:  false,
+		Errors: []*errHTTP{err},
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestServer_PublishValidate_JSON(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	body := `{"topic":"mytopic","message":"Deploy done","title":"CI","tags":["rocket"],"priority":4,"delay":"30min"}`
+	rr := request(t, s, "POST", "/v1/publish/validate", body, nil)
+	require.Equal(t, 200, rr.Code)
+
+	response, err := util.UnmarshalJSON[apiPublishValidateResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.True(t, response.Valid)
+	require.Nil(t, response.Errors)
+	require.Equal(t, "mytopic", response.Message.Topic)
+	require.Equal(t, "Deploy done", response.Message.Message)
+	require.Equal(t, "CI", response.Message.Title)
+	require.Equal(t, []string{"rocket"}, response.Message.Tags)
+	require.Equal(t, 4, response.Message.Priority)
+	require.Greater(t, response.Message.Time, time.Now().Unix()+29*60)
+
+	// Nothing was published or scheduled
+	messages, err := s.messageCache.Messages("mytopic", sinceAllMessages, true)
+	require.Nil(t, err)
+	require.Empty(t, messages)
+}
+
+func TestServer_PublishValidate_Headers(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	rr := request(t, s, "POST", "/v1/publish/validate", "**Build failed**", map[string]string{
+		"X-Topic":    "mytopic",
+		"X-Priority": "urgent",
+		"X-Markdown": "yes",
+		"X-Actions":  "view, Open build, https://ci.example.com/123",
+	})
+	require.Equal(t, 200, rr.Code)
+
+	response, err := util.UnmarshalJSON[apiPublishValidateResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.True(t, response.Valid)
+	require.Equal(t, "**Build failed**", response.Message.Message)
+	require.Equal(t, 5, response.Message.Priority)
+	require.Equal(t, "text/markdown", response.Message.ContentType)
+	require.Equal(t, 1, len(response.Message.Actions))
+	require.Equal(t, "https://ci.example.com/123", response.Message.Actions[0].URL)
+
+	messages, err := s.messageCache.Messages("mytopic", sinceAllMessages, true)
+	require.Nil(t, err)
+	require.Empty(t, messages)
+}
+
+func TestServer_PublishValidate_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	rr := request(t, s, "POST", "/v1/publish/validate", `{"topic":"mytopic","priority":9}`, nil)
+	require.Equal(t, 200, rr.Code)
+	response, err := util.UnmarshalJSON[apiPublishValidateResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.False(t, response.Valid)
+	require.Nil(t, response.Message)
+	require.Equal(t, 1, len(response.Errors))
+	require.Equal(t, errHTTPBadRequestPriorityInvalid.Code, response.Errors[0].Code)
+
+	rr = request(t, s, "POST", "/v1/publish/validate", `{"topic":"my topic"}`, nil)
+	response, err = util.UnmarshalJSON[apiPublishValidateResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.False(t, response.Valid)
+	require.Equal(t, errHTTPBadRequestTopicInvalid.Code, response.Errors[0].Code)
+
+	rr = request(t, s, "POST", "/v1/publish/validate", `not json`, nil)
+	response, err = util.UnmarshalJSON[apiPublishValidateResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.False(t, response.Valid)
+	require.Equal(t, errHTTPBadRequestJSONInvalid.Code, response.Errors[0].Code)
+
+	rr = request(t, s, "POST", "/v1/publish/validate", "hi", map[string]string{
+		"X-Topic": "mytopic",
+		"X-Delay": "in a bit",
+	})
+	response, err = util.UnmarshalJSON[apiPublishValidateResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.False(t, response.Valid)
+	require.Equal(t, errHTTPBadRequestDelayCannotParse.Code, response.Errors[0].Code)
+}
+
+func TestServer_PublishValidate_AccessControl(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+
+	rr := request(t, s, "POST", "/v1/publish/validate", `{"topic":"mytopic","message":"hi"}`, nil)
+	require.Equal(t, 200, rr.Code)
+	response, err := util.UnmarshalJSON[apiPublishValidateResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.False(t, response.Valid)
+	require.Equal(t, errHTTPForbidden.Code, response.Errors[0].Code)
+
+	rr = request(t, s, "POST", "/v1/publish/validate", `{"topic":"mytopic","message":"hi"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	response, err = util.UnmarshalJSON[apiPublishValidateResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.True(t, response.Valid)
+	require.Equal(t, "hi", response.Message.Message)
+}
+
+func TestServer_PublishValidate_Attachment(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentFileSizeLimit = 6000
+	s := newTestServer(t, c)
+
+	content := util.RandomString(5000)
+	rr := request(t, s, "PUT", "/v1/publish/validate", content, map[string]string{
+		"X-Topic":    "mytopic",
+		"X-Filename": "build.log",
+	})
+	require.Equal(t, 404, rr.Code) // Only POST is supported
+
+	rr = request(t, s, "POST", "/v1/publish/validate", content, map[string]string{
+		"X-Topic":    "mytopic",
+		"X-Filename": "build.log",
+	})
+	response, err := util.UnmarshalJSON[apiPublishValidateResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.True(t, response.Valid)
+	require.Equal(t, "build.log", response.Message.Attachment.Name)
+	require.Equal(t, int64(5000), response.Message.Attachment.Size)
+	entries, err := os.ReadDir(s.config.AttachmentCacheDir)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+
+	rr = request(t, s, "POST", "/v1/publish/validate", util.RandomString(7000), map[string]string{
+		"X-Topic":    "mytopic",
+		"X-Filename": "build.log",
+	})
+	response, err = util.UnmarshalJSON[apiPublishValidateResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.False(t, response.Valid)
+	require.Equal(t, errHTTPEntityTooLargeAttachmentFileSize.Code, response.Errors[0].Code)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"heckel.io/ntfy/v2/util"
+	"net/http"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// handleVisitorsGet lists all visitors currently held in memory, along with the state of their rate limiters,
+// active subscriptions and IP bans (admin only)
+func (s *Server) handleVisitorsGet(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	s.mu.RLock()
+	visitors := make([]*visitor, 0, len(s.visitors))
+	for _, v := range s.visitors {
+		visitors = append(visitors, v)
+	}
+	bannedIPs := make(map[netip.Addr]int64, len(s.bannedIPs))
+	for ip, expires := range s.bannedIPs {
+		bannedIPs[ip] = expires
+	}
+	s.mu.RUnlock()
+	now := time.Now()
+	response := make([]*apiVisitor, 0, len(visitors))
+	for _, v := range visitors {
+		status := v.Status()
+		entry := &apiVisitor{
+			ID:                       status.ID,
+			IP:                       status.IP.String(),
+			Seen:                     status.Seen.Unix(),
+			RequestsLimit:            status.RequestLimitBurst,
+			RequestsRemaining:        util.MinMax(int(status.RequestTokens), 0, status.RequestLimitBurst),
+			Messages:                 status.Info.Stats.Messages,
+			MessagesRemaining:        status.Info.Stats.MessagesRemaining,
+			Emails:                   status.Info.Stats.Emails,
+			EmailsRemaining:          status.Info.Stats.EmailsRemaining,
+			Subscriptions:            status.Subscriptions,
+			SubscriptionsLimit:       status.SubscriptionLimit,
+			AttachmentBandwidth:      status.AttachmentBandwidth,
+			AttachmentBandwidthLimit: status.Info.Limits.AttachmentBandwidthLimit,
+		}
+		if status.User != nil {
+			entry.User = status.User.Name
+			if status.User.Tier != nil {
+				entry.Tier = status.User.Tier.Code
+			}
+		}
+		if expires, ok := bannedIPs[status.IP]; ok && (expires == 0 || expires > now.Unix()) {
+			entry.Banned = true
+			entry.BanExpires = expires
+		}
+		if status.ExemptUntil.After(now) {
+			entry.ExemptUntil = status.ExemptUntil.Unix()
+		}
+		response = append(response, entry)
+	}
+	sort.Slice(response, func(i, j int) bool {
+		return response[i].ID < response[j].ID
+	})
+	return s.writeJSON(w, response)
+}
+
+// handleVisitorsReset refills the request bucket of a visitor and resets its message, e-mail and bandwidth
+// counters, as well as the auto-ban counters of its IP address (admin only). IP bans are not lifted, see
+// handleBlocksDelete for that.
+func (s *Server) handleVisitorsReset(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiVisitorRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	target, err := s.visitorFromRequest(req)
+	if err != nil {
+		return err
+	}
+	target.ResetLimiters()
+	s.banTracker.Reset(target.IP())
+	logvr(v, r).Tag(tagAbuse).Info("Admin reset rate limits of visitor %s", req.ID)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleVisitorsExempt temporarily exempts a visitor from request and message rate limiting, and from
+// automatic IP bans (admin only)
+func (s *Server) handleVisitorsExempt(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiVisitorRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	} else if req.Duration == "" {
+		return errHTTPBadRequest.Wrap("duration must be set")
+	}
+	duration, err := util.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 {
+		return errHTTPBadRequest.Wrap("invalid duration %s", req.Duration)
+	}
+	target, err := s.visitorFromRequest(req)
+	if err != nil {
+		return err
+	}
+	until := time.Now().Add(duration)
+	target.SetExempt(until)
+	logvr(v, r).Tag(tagAbuse).Info("Admin exempted visitor %s from rate limiting until %s", req.ID, until.Format(time.RFC3339))
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleVisitorsExemptDelete removes a visitor's temporary exemption from rate limiting (admin only)
+func (s *Server) handleVisitorsExemptDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiVisitorRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil {
+		return err
+	}
+	target, err := s.visitorFromRequest(req)
+	if err != nil {
+		return err
+	}
+	target.SetExempt(time.Time{})
+	logvr(v, r).Tag(tagAbuse).Info("Admin removed rate limit exemption of visitor %s", req.ID)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// visitorFromRequest looks up the visitor referenced by the request, either by its visitor ID (as returned by
+// handleVisitorsGet), or by its IP address. The request's ID is normalized for logging.
+func (s *Server) visitorFromRequest(req *apiVisitorRequest) (*visitor, error) {
+	if req.ID == "" && req.IP != "" {
+		ip, err := netip.ParseAddr(req.IP)
+		if err != nil {
+			return nil, errHTTPBadRequest.Wrap("invalid IP address %s", req.IP)
+		}
+		req.ID = visitorID(ip, nil)
+	} else if req.ID == "" {
+		return nil, errHTTPBadRequest.Wrap("id or ip must be set")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.visitors[req.ID]
+	if !ok {
+		return nil, errHTTPNotFoundVisitor
+	}
+	return v, nil
+}
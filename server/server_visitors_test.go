@@ -0,0 +1,140 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestServer_Visitors_ListAndReset(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.VisitorRequestLimitBurst = 3
+	c.VisitorRequestLimitReplenish = time.Hour
+	c.IPBanRateLimitViolations = 10
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	admin := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+	adminIP := func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4"
+	}
+
+	// Exhaust the request limit
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "PUT", "/mytopic", "hi", nil)
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "PUT", "/mytopic", "hi", nil)
+	require.Equal(t, 429, response.Code)
+
+	// Admin sees the visitor
+	response = request(t, s, "GET", "/v1/visitors", "", admin, adminIP)
+	require.Equal(t, 200, response.Code)
+	visitors, err := util.UnmarshalJSON[[]*apiVisitor](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	var found *apiVisitor
+	for _, v := range *visitors {
+		if v.ID == "ip:9.9.9.9" {
+			found = v
+		}
+	}
+	require.NotNil(t, found)
+	require.Equal(t, "9.9.9.9", found.IP)
+	require.Equal(t, 3, found.RequestsLimit)
+	require.Equal(t, 0, found.RequestsRemaining)
+	require.Equal(t, int64(3), found.Messages)
+	require.False(t, found.Banned)
+
+	// Reset the visitor, and it can publish again
+	response = request(t, s, "POST", "/v1/visitors/reset", `{"ip":"9.9.9.9"}`, admin, adminIP)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "hi again", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, int64(1), s.visitors["ip:9.9.9.9"].Stats().Messages)
+}
+
+func TestServer_Visitors_Exempt(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.VisitorRequestLimitBurst = 2
+	c.VisitorRequestLimitReplenish = time.Hour
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	admin := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+	adminIP := func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4"
+	}
+
+	for i := 0; i < 2; i++ {
+		require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+	}
+	require.Equal(t, 429, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+
+	// Exempt visitor, and it is no longer rate limited
+	response := request(t, s, "POST", "/v1/visitors/exempt", `{"id":"ip:9.9.9.9","duration":"1h"}`, admin, adminIP)
+	require.Equal(t, 200, response.Code)
+	for i := 0; i < 5; i++ {
+		require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+	}
+	response = request(t, s, "GET", "/v1/visitors", "", admin, adminIP)
+	visitors, err := util.UnmarshalJSON[[]*apiVisitor](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	for _, v := range *visitors {
+		if v.ID == "ip:9.9.9.9" {
+			require.Greater(t, v.ExemptUntil, time.Now().Add(59*time.Minute).Unix())
+		} else {
+			require.Zero(t, v.ExemptUntil)
+		}
+	}
+
+	// Remove exemption again
+	response = request(t, s, "DELETE", "/v1/visitors/exempt", `{"ip":"9.9.9.9"}`, admin, adminIP)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, 429, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+}
+
+func TestServer_Visitors_ShowsBan(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi", nil).Code)
+	require.Nil(t, s.banIP(netip.MustParseAddr("9.9.9.9"), time.Time{}, "manual"))
+
+	response := request(t, s, "GET", "/v1/visitors", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	visitors, err := util.UnmarshalJSON[[]*apiVisitor](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*visitors))
+	require.True(t, (*visitors)[0].Banned)
+	require.Zero(t, (*visitors)[0].BanExpires)
+}
+
+func TestServer_Visitors_Failures(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	admin := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+
+	// Not an admin
+	response := request(t, s, "GET", "/v1/visitors", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	// Unknown visitor, invalid requests
+	response = request(t, s, "POST", "/v1/visitors/reset", `{"ip":"5.6.7.8"}`, admin)
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40406, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/v1/visitors/reset", `{}`, admin)
+	require.Equal(t, 400, response.Code)
+	response = request(t, s, "POST", "/v1/visitors/reset", `{"ip":"not-an-ip"}`, admin)
+	require.Equal(t, 400, response.Code)
+	response = request(t, s, "POST", "/v1/visitors/exempt", `{"ip":"9.9.9.9"}`, admin)
+	require.Equal(t, 400, response.Code)
+	response = request(t, s, "POST", "/v1/visitors/exempt", `{"ip":"9.9.9.9","duration":"forever"}`, admin)
+	require.Equal(t, 400, response.Code)
+}
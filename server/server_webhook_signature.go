@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook providers whose request signatures can be verified, see Config.WebhookSignatures
+const (
+	WebhookProviderGitHub = "github"
+	WebhookProviderStripe = "stripe"
+	WebhookProviderSlack  = "slack"
+)
+
+const (
+	webhookSignatureBodyBytesLimit = 1024 * 1024     // Max number of bytes of a signed request body
+	webhookSignatureTolerance      = 5 * time.Minute // Max age of signed Stripe and Slack requests, to prevent replay attacks
+)
+
+// webhookQueryParams are the query parameters that are kept for requests with a valid signature. The signature only
+// covers the body, so all other query parameters and headers are removed: anyone who knows the webhook URL could
+// otherwise make the server send e-mails, make phone calls, attach files, or raise the priority of the message.
+var webhookQueryParams = []string{"x-template", "template", "tpl", "x-title", "title", "t", "x-tags", "tags", "tag", "ta", "x-markdown", "markdown", "md"}
+
+var (
+	errWebhookSignatureMismatch = errors.New("signature missing or mismatch")
+	errWebhookTimestampInvalid  = errors.New("timestamp missing or invalid")
+	errWebhookTimestampExpired  = errors.New("timestamp outside of tolerance")
+	errWebhookProviderUnknown   = errors.New("unknown webhook provider")
+)
+
+// WebhookSignature defines the upstream webhook provider and the signing secret for a topic (or topic prefix,
+// e.g. "github-*"). If a topic matches, publish requests must carry a valid signature of the provider, see
+// Config.WebhookSignatures.
+type WebhookSignature struct {
+	Topic    string // Topic name or prefix (e.g. "github-*")
+	Provider string // Webhook provider, e.g. "github", see WebhookProviderGitHub and friends
+	Secret   string // Signing secret of the webhook, as configured with the provider
+}
+
+// Matches returns true if requests to the given topic must be signed
+func (w *WebhookSignature) Matches(topic string) bool {
+	return topicMatches(w.Topic, topic)
+}
+
+// verifyWebhookSignature rejects publish requests to topics that require a signature of an upstream webhook provider
+// (see Config.WebhookSignatures) unless the request carries a valid signature of the entire request body. This
+// ensures that only the provider can publish to the topic, even if the topic name (i.e. the webhook URL) leaks.
+// This check is in addition to the regular access control checks.
+//
+// Since the signature does not cover the URL and the headers, they are stripped from signed requests, except for
+// the query parameters that only affect the presentation of the message, see webhookQueryParams.
+func (s *Server) verifyWebhookSignature(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if len(s.config.WebhookSignatures) == 0 {
+			return next(w, r, v)
+		}
+		t, err := fromContext[*topic](r, contextTopic)
+		if err != nil {
+			return err
+		}
+		signature := s.webhookSignature(t.ID)
+		if signature == nil {
+			return next(w, r, v)
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, webhookSignatureBodyBytesLimit+1))
+		if err != nil {
+			return err
+		} else if len(body) > webhookSignatureBodyBytesLimit {
+			return errHTTPEntityTooLargeWebhookBody.With(t)
+		}
+		if err := verifyWebhookRequest(signature, r.Header, body, time.Now()); err != nil {
+			logvr(v, r).
+				With(t).
+				Field("webhook_provider", signature.Provider).
+				Err(err).
+				Debug("Webhook signature for topic %s invalid", t.ID)
+			s.authFailed(r, v, "", errAuthWebhookSignatureInvalid)
+			return errHTTPForbiddenWebhookSignatureInvalid.With(t)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.Header = http.Header{
+			"User-Agent":   r.Header.Values("User-Agent"),
+			"Content-Type": r.Header.Values("Content-Type"),
+		}
+		r.URL.RawQuery = webhookQuery(r.URL.Query()).Encode()
+		return next(w, r, v)
+	}
+}
+
+// webhookQuery returns the query parameters of a signed request that are kept, see webhookQueryParams
+func webhookQuery(query url.Values) url.Values {
+	kept := url.Values{}
+	for _, name := range webhookQueryParams {
+		if query.Has(name) {
+			kept[name] = query[name]
+		}
+	}
+	return kept
+}
+
+// webhookSignature returns the first matching webhook signature definition for the given topic, or nil if
+// requests to the topic do not need to be signed
+func (s *Server) webhookSignature(topic string) *WebhookSignature {
+	for _, signature := range s.config.WebhookSignatures {
+		if signature.Matches(topic) {
+			return signature
+		}
+	}
+	return nil
+}
+
+// verifyWebhookRequest checks the signature of a webhook request body, as defined by the provider:
+//   - GitHub: "X-Hub-Signature-256: sha256=<hmac>", with hmac = HMAC-SHA256(secret, body)
+//   - Stripe: "Stripe-Signature: t=<timestamp>,v1=<hmac>[,v1=...]", with hmac = HMAC-SHA256(secret, "<timestamp>.<body>")
+//   - Slack: "X-Slack-Signature: v0=<hmac>" and "X-Slack-Request-Timestamp: <timestamp>", with
+//     hmac = HMAC-SHA256(secret, "v0:<timestamp>:<body>")
+//
+// All HMACs are hex-encoded. Stripe and Slack requests older than webhookSignatureTolerance are rejected.
+func verifyWebhookRequest(signature *WebhookSignature, header http.Header, body []byte, now time.Time) error {
+	switch signature.Provider {
+	case WebhookProviderGitHub:
+		sig, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok || !webhookHMACEqual(signature.Secret, body, sig) {
+			return errWebhookSignatureMismatch
+		}
+		return nil
+	case WebhookProviderStripe:
+		var timestamp string
+		var sigs []string
+		for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch key {
+			case "t":
+				timestamp = value
+			case "v1":
+				sigs = append(sigs, value)
+			}
+		}
+		if err := checkWebhookTimestamp(timestamp, now); err != nil {
+			return err
+		}
+		payload := append([]byte(timestamp+"."), body...)
+		for _, sig := range sigs {
+			if webhookHMACEqual(signature.Secret, payload, sig) {
+				return nil
+			}
+		}
+		return errWebhookSignatureMismatch
+	case WebhookProviderSlack:
+		timestamp := header.Get("X-Slack-Request-Timestamp")
+		if err := checkWebhookTimestamp(timestamp, now); err != nil {
+			return err
+		}
+		sig, ok := strings.CutPrefix(header.Get("X-Slack-Signature"), "v0=")
+		if !ok || !webhookHMACEqual(signature.Secret, append([]byte("v0:"+timestamp+":"), body...), sig) {
+			return errWebhookSignatureMismatch
+		}
+		return nil
+	}
+	return errWebhookProviderUnknown
+}
+
+func checkWebhookTimestamp(timestamp string, now time.Time) error {
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errWebhookTimestampInvalid
+	} else if now.Sub(time.Unix(t, 0)).Abs() > webhookSignatureTolerance {
+		return errWebhookTimestampExpired
+	}
+	return nil
+}
+
+func webhookHMACEqual(secret string, payload []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
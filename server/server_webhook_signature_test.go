@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_WebhookSignature_GitHub(t *testing.T) {
+	c := newTestConfig(t)
+	c.WebhookSignatures = []*WebhookSignature{
+		{Topic: "github-*", Provider: WebhookProviderGitHub, Secret: "s3cr3t"},
+	}
+	s := newTestServer(t, c)
+	body := `{"action":"opened","number":1}`
+
+	// Valid signature
+	rr := request(t, s, "POST", "/github-ntfy", body, map[string]string{
+		"X-Hub-Signature-256": "sha256=" + testWebhookHMAC("s3cr3t", body),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, body, toMessage(t, rr.Body.String()).Message)
+
+	// Forged, missing or wrong signatures are rejected, also for GET requests
+	for _, headers := range []map[string]string{
+		{"X-Hub-Signature-256": "sha256=" + testWebhookHMAC("wrong", body)},
+		{"X-Hub-Signature-256": testWebhookHMAC("s3cr3t", body)},
+		{},
+	} {
+		rr = request(t, s, "POST", "/github-ntfy", body, headers)
+		require.Equal(t, 403, rr.Code)
+		require.Equal(t, 40309, toHTTPError(t, rr.Body.String()).Code)
+	}
+	rr = request(t, s, "GET", "/github-ntfy/publish?message=forged", "", nil)
+	require.Equal(t, 403, rr.Code)
+
+	// Headers and query parameters that control delivery are stripped, since they are not signed
+	rr = request(t, s, "POST", "/github-ntfy?title=GitHub&tags=octopus&priority=5&email=phil@example.com&attach=https://example.com/evil.apk", body, map[string]string{
+		"X-Hub-Signature-256": "sha256=" + testWebhookHMAC("s3cr3t", body),
+		"X-Priority":          "5",
+		"X-Click":             "https://example.com/phishing",
+		"X-Call":              "+12223334444",
+		"X-Delay":             "1h",
+	})
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	require.Equal(t, body, m.Message)
+	require.Equal(t, "GitHub", m.Title)
+	require.Equal(t, []string{"octopus"}, m.Tags)
+	require.Equal(t, 0, m.Priority)
+	require.Equal(t, "", m.Click)
+	require.Nil(t, m.Attachment)
+	require.True(t, m.Time <= time.Now().Unix())
+
+	// Other topics do not need a signature
+	rr = request(t, s, "POST", "/mytopic", body, nil)
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestServer_WebhookSignature_Stripe_Slack(t *testing.T) {
+	c := newTestConfig(t)
+	c.WebhookSignatures = []*WebhookSignature{
+		{Topic: "payments", Provider: WebhookProviderStripe, Secret: "whsec_123"},
+		{Topic: "slack", Provider: WebhookProviderSlack, Secret: "8f742231b10e8888abcd99yyyzzz85a5"},
+	}
+	s := newTestServer(t, c)
+	body := `{"type":"invoice.paid"}`
+	now := fmt.Sprintf("%d", time.Now().Unix())
+	old := fmt.Sprintf("%d", time.Now().Add(-10*time.Minute).Unix())
+
+	// Stripe: any of the v1 signatures may match
+	rr := request(t, s, "POST", "/payments", body, map[string]string{
+		"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s,v1=%s", now, testWebhookHMAC("old", now+"."+body), testWebhookHMAC("whsec_123", now+"."+body)),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/payments", body, map[string]string{
+		"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s", old, testWebhookHMAC("whsec_123", old+"."+body)),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Slack
+	rr = request(t, s, "POST", "/slack", body, map[string]string{
+		"X-Slack-Request-Timestamp": now,
+		"X-Slack-Signature":         "v0=" + testWebhookHMAC("8f742231b10e8888abcd99yyyzzz85a5", "v0:"+now+":"+body),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/slack", body, map[string]string{
+		"X-Slack-Request-Timestamp": old,
+		"X-Slack-Signature":         "v0=" + testWebhookHMAC("8f742231b10e8888abcd99yyyzzz85a5", "v0:"+old+":"+body),
+	})
+	require.Equal(t, 403, rr.Code)
+}
+
+func TestServer_WebhookSignature_BodyTooLarge(t *testing.T) {
+	c := newTestConfig(t)
+	c.WebhookSignatures = []*WebhookSignature{
+		{Topic: "github", Provider: WebhookProviderGitHub, Secret: "s3cr3t"},
+	}
+	s := newTestServer(t, c)
+	rr := request(t, s, "POST", "/github", strings.Repeat("x", webhookSignatureBodyBytesLimit+1), nil)
+	require.Equal(t, 413, rr.Code)
+	require.Equal(t, 41307, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestVerifyWebhookRequest_Errors(t *testing.T) {
+	now := time.Now()
+	header := http.Header{}
+	header.Set("Stripe-Signature", "v1=abc")
+	require.Equal(t, errWebhookTimestampInvalid, verifyWebhookRequest(&WebhookSignature{Provider: WebhookProviderStripe}, header, nil, now))
+	require.Equal(t, errWebhookProviderUnknown, verifyWebhookRequest(&WebhookSignature{Provider: "gitlab"}, header, nil, now))
+}
+
+func testWebhookHMAC(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
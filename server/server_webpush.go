@@ -1,19 +1,28 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/SherClockHolmes/webpush-go"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
 )
 
 const (
-	webPushTopicSubscribeLimit = 50
+	webPushTopicSubscribeLimit   = 50
+	webPushMarkdownExcerptLength = 512 // Web push payloads are limited to ~4 KB, and the message is included as well
+	webPushSendTimeout           = 30 * time.Second
+	webPushIdleConnTimeout       = 90 * time.Second
 )
 
 var (
@@ -38,7 +47,7 @@ func init() {
 }
 
 func (s *Server) handleWebPushUpdate(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	req, err := readJSONWithLimit[apiWebPushUpdateSubscriptionRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiWebPushUpdateSubscriptionRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil || req.Endpoint == "" || req.P256dh == "" || req.Auth == "" {
 		return errHTTPBadRequestWebPushSubscriptionInvalid
 	} else if !webPushAllowedEndpointsRegex.MatchString(req.Endpoint) {
@@ -59,14 +68,16 @@ func (s *Server) handleWebPushUpdate(w http.ResponseWriter, r *http.Request, v *
 			}
 		}
 	}
-	if err := s.webPush.UpsertSubscription(req.Endpoint, req.Auth, req.P256dh, v.MaybeUserID(), v.IP(), req.Topics); err != nil {
+	if err := s.webPush.UpsertSubscription(req.Endpoint, req.Auth, req.P256dh, v.MaybeUserID(), v.IP(), r.UserAgent(), req.Topics); errors.Is(err, errWebPushTooManyUserEndpoints) {
+		return errHTTPTooManyRequestsLimitWebPushSubscriptions
+	} else if err != nil {
 		return err
 	}
 	return s.writeJSON(w, newSuccessResponse())
 }
 
 func (s *Server) handleWebPushDelete(w http.ResponseWriter, r *http.Request, _ *visitor) error {
-	req, err := readJSONWithLimit[apiWebPushUpdateSubscriptionRequest](r.Body, jsonBodyBytesLimit, false)
+	req, err := readJSONWithLimit[apiWebPushUpdateSubscriptionRequest](r.Body, s.config.AccountBodySizeLimit, false)
 	if err != nil || req.Endpoint == "" {
 		return errHTTPBadRequestWebPushSubscriptionInvalid
 	}
@@ -76,23 +87,126 @@ func (s *Server) handleWebPushDelete(w http.ResponseWriter, r *http.Request, _ *
 	return s.writeJSON(w, newSuccessResponse())
 }
 
-func (s *Server) publishToWebPushEndpoints(v *visitor, m *message) {
+// handleAccountWebPushList lists the web push subscriptions (one per browser) of the logged-in user
+func (s *Server) handleAccountWebPushList(w http.ResponseWriter, _ *http.Request, v *visitor) error {
+	subscriptions, err := s.webPush.SubscriptionsForUser(v.User().ID)
+	if err != nil {
+		return err
+	}
+	response := &apiAccountWebPushSubscriptionsResponse{
+		Subscriptions: make([]*apiAccountWebPushSubscription, 0),
+		Limit:         s.config.WebPushUserSubscriptionLimit,
+	}
+	for _, subscription := range subscriptions {
+		response.Subscriptions = append(response.Subscriptions, &apiAccountWebPushSubscription{
+			ID:          subscription.ID,
+			UserAgent:   subscription.UserAgent,
+			Topics:      subscription.Topics,
+			Created:     subscription.Created,
+			Updated:     subscription.Updated,
+			LastSuccess: subscription.LastSuccess,
+		})
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleAccountWebPushDelete deletes a single web push subscription of the logged-in user, e.g. of a browser
+// the user no longer uses
+func (s *Server) handleAccountWebPushDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := apiAccountWebPushSingleRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	id := matches[1]
+	if err := s.webPush.RemoveSubscriptionByIDForUser(id, v.User().ID); errors.Is(err, errWebPushNoRows) {
+		return errHTTPNotFoundWebPushSubscription
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagWebPush).
+		Field("web_push_subscription_id", id).
+		Debug("Deleted web push subscription %s", id)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleWebPushRenew renews a web push subscription. It is called by the service worker in response to a
+// silent ping (see web-push-keepalive-strategy), to show that the subscription is still alive.
+func (s *Server) handleWebPushRenew(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	req, err := readJSONWithLimit[apiWebPushRenewSubscriptionRequest](r.Body, s.config.AccountBodySizeLimit, false)
+	if err != nil || req.Endpoint == "" {
+		return errHTTPBadRequestWebPushSubscriptionInvalid
+	}
+	if err := s.webPush.RenewSubscription(req.Endpoint); errors.Is(err, errWebPushNoRows) {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// publishToWebPushEndpoints sends the message to all web push subscriptions of its topic. It returns an error
+// if the message was not delivered to any subscription, see publishToPushChannels.
+func (s *Server) publishToWebPushEndpoints(v *visitor, m *message) error {
 	subscriptions, err := s.webPush.SubscriptionsForTopic(m.Topic)
 	if err != nil {
 		logvm(v, m).Err(err).With(v, m).Warn("Unable to publish web push messages")
-		return
+		return err
 	}
 	log.Tag(tagWebPush).With(v, m).Debug("Publishing web push message to %d subscribers", len(subscriptions))
 	payload, err := json.Marshal(newWebPushPayload(fmt.Sprintf("%s/%s", s.config.BaseURL, m.Topic), m))
 	if err != nil {
 		log.Tag(tagWebPush).Err(err).With(v, m).Warn("Unable to marshal expiring payload")
-		return
+		return err
 	}
+	// Subscriptions are sent to in parallel, but the number of concurrent requests is limited across all
+	// messages (web-push-workers), so that a burst of messages to large topics does not overwhelm the server
+	var delivered, failed int
+	var lastErr error
+	succeeded := make([]*webPushSubscription, 0)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
 	for _, subscription := range subscriptions {
-		if err := s.sendWebPushNotification(subscription, payload, v, m); err != nil {
-			log.Tag(tagWebPush).Err(err).With(v, m, subscription).Warn("Unable to publish web push message")
+		s.webPushWorkers <- struct{}{}
+		wg.Add(1)
+		go func(subscription *webPushSubscription) {
+			defer func() {
+				<-s.webPushWorkers
+				wg.Done()
+			}()
+			err := s.sendWebPushNotification(subscription, payload, v, m)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Tag(tagWebPush).Err(err).With(v, m, subscription).Warn("Unable to publish web push message")
+				minc(metricWebPushPublishedFailure)
+				s.statsHistory.DeliveryFailed()
+				failed, lastErr = failed+1, err
+			} else {
+				minc(metricWebPushPublishedSuccess)
+				succeeded = append(succeeded, subscription)
+				delivered++
+			}
+		}(subscription)
+	}
+	wg.Wait()
+	if len(succeeded) > 0 {
+		if err := s.webPush.MarkSuccess(succeeded); err != nil {
+			log.Tag(tagWebPush).Err(err).With(v, m).Warn("Unable to update web push subscriptions")
 		}
 	}
+	if delivered > 0 {
+		s.recordDelivery(m, deliveryChannelWebPush, delivered, nil)
+	}
+	if failed > 0 {
+		s.recordDelivery(m, deliveryChannelWebPush, failed, lastErr)
+	}
+	if delivered > 0 {
+		return nil
+	} else if lastErr != nil {
+		return lastErr
+	}
+	return errWebPushNoSubscriptions
 }
 
 func (s *Server) pruneAndNotifyWebPushSubscriptions() {
@@ -107,6 +221,9 @@ func (s *Server) pruneAndNotifyWebPushSubscriptions() {
 }
 
 func (s *Server) pruneAndNotifyWebPushSubscriptionsInternal() error {
+	if s.config.WebPushKeepaliveStrategy == WebPushKeepaliveStrategyPing {
+		return s.pruneAndPingWebPushSubscriptions()
+	}
 	// Expire old subscriptions
 	if err := s.webPush.RemoveExpiredSubscriptions(s.config.WebPushExpiryDuration); err != nil {
 		return err
@@ -118,12 +235,18 @@ func (s *Server) pruneAndNotifyWebPushSubscriptionsInternal() error {
 	} else if len(subscriptions) == 0 {
 		return nil
 	}
-	payload, err := json.Marshal(newWebPushSubscriptionExpiringPayload())
-	if err != nil {
-		return err
-	}
+	payloads := make(map[string][]byte) // Language -> payload
 	warningSent := make([]*webPushSubscription, 0)
 	for _, subscription := range subscriptions {
+		lang := s.webPushSubscriptionLanguage(subscription)
+		payload, ok := payloads[lang]
+		if !ok {
+			payload, err = json.Marshal(newWebPushSubscriptionExpiringPayload(lang))
+			if err != nil {
+				return err
+			}
+			payloads[lang] = payload
+		}
 		if err := s.sendWebPushNotification(subscription, payload); err != nil {
 			log.Tag(tagWebPush).Err(err).With(subscription).Warn("Unable to publish expiry imminent warning")
 			continue
@@ -137,6 +260,54 @@ func (s *Server) pruneAndNotifyWebPushSubscriptionsInternal() error {
 	return nil
 }
 
+// pruneAndPingWebPushSubscriptions sends a silent ping to subscriptions that have not been updated in a while, and
+// removes the subscriptions that did not answer the ping within the remaining time until they would have expired.
+// Unlike the expiry warning, the ping does not show a notification; the service worker answers it by calling the
+// renew endpoint (see handleWebPushRenew), which resets the subscription's age.
+func (s *Server) pruneAndPingWebPushSubscriptions() error {
+	// Remove subscriptions that did not answer the ping
+	if err := s.webPush.RemoveUnresponsiveSubscriptions(s.config.WebPushExpiryDuration - s.config.WebPushExpiryWarningDuration); err != nil {
+		return err
+	}
+	// Ping subscriptions that have not been updated in a while; the ping time is stored as the warning time
+	subscriptions, err := s.webPush.SubscriptionsExpiring(s.config.WebPushExpiryWarningDuration)
+	if err != nil {
+		return err
+	} else if len(subscriptions) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(newWebPushSubscriptionPingPayload())
+	if err != nil {
+		return err
+	}
+	pinged := make([]*webPushSubscription, 0)
+	for _, subscription := range subscriptions {
+		if err := s.sendWebPushNotification(subscription, payload); err != nil {
+			log.Tag(tagWebPush).Err(err).With(subscription).Warn("Unable to ping web push subscription")
+			continue
+		}
+		pinged = append(pinged, subscription)
+	}
+	if err := s.webPush.MarkExpiryWarningSent(pinged); err != nil {
+		return err
+	}
+	log.Tag(tagWebPush).Debug("Removed unresponsive subscriptions and pinged %d subscription(s)", len(pinged))
+	return nil
+}
+
+// webPushSubscriptionLanguage returns the language preference of the user the subscription belongs to,
+// or an empty string if the subscription is anonymous, or the user has not chosen a language
+func (s *Server) webPushSubscriptionLanguage(subscription *webPushSubscription) string {
+	if s.userManager == nil || subscription.UserID == "" {
+		return ""
+	}
+	u, err := s.userManager.UserByID(subscription.UserID)
+	if err != nil || u.Prefs == nil || u.Prefs.Language == nil {
+		return ""
+	}
+	return *u.Prefs.Language
+}
+
 func (s *Server) sendWebPushNotification(sub *webPushSubscription, message []byte, contexters ...log.Contexter) error {
 	log.Tag(tagWebPush).With(sub).With(contexters...).Debug("Sending web push message")
 	payload := &webpush.Subscription{
@@ -146,7 +317,9 @@ func (s *Server) sendWebPushNotification(sub *webPushSubscription, message []byt
 			P256dh: sub.P256dh,
 		},
 	}
-	resp, err := webpush.SendNotification(message, payload, &webpush.Options{
+	start := time.Now()
+	resp, err := webpush.SendNotification(bytes.Clone(message), payload, &webpush.Options{ // Clone, since the message is padded in place
+		HTTPClient:      s.webPushClient,
 		Subscriber:      s.config.WebPushEmailAddress,
 		VAPIDPublicKey:  s.config.WebPushPublicKey,
 		VAPIDPrivateKey: s.config.WebPushPrivateKey,
@@ -160,6 +333,11 @@ func (s *Server) sendWebPushNotification(sub *webPushSubscription, message []byt
 		}
 		return err
 	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body) // Drain body, so that the connection can be reused
+		resp.Body.Close()
+	}()
+	mset(metricWebPushSendDurationMillis, time.Since(start).Milliseconds())
 	if (resp.StatusCode < 200 || resp.StatusCode > 299) && resp.StatusCode != 429 {
 		log.Tag(tagWebPush).With(sub).With(contexters...).Field("response_code", resp.StatusCode).Debug("Unable to publish web push message, unexpected response")
 		if err := s.webPush.RemoveSubscriptionsByEndpoint(sub.Endpoint); err != nil {
@@ -169,3 +347,18 @@ func (s *Server) sendWebPushNotification(sub *webPushSubscription, message []byt
 	}
 	return nil
 }
+
+// newWebPushHTTPClient creates the HTTP client used to send web push messages. Since all subscriptions of a
+// browser vendor share the same push service (e.g. fcm.googleapis.com), connections are kept alive and reused
+// (via HTTP/2, if the push service supports it), instead of opening a new connection for every message.
+func newWebPushHTTPClient(workers int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConns = 0 // No limit
+	transport.MaxIdleConnsPerHost = util.Max(workers, 1)
+	transport.IdleConnTimeout = webPushIdleConnTimeout
+	return &http.Client{
+		Transport: transport,
+		Timeout:   webPushSendTimeout,
+	}
+}
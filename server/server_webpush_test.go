@@ -49,7 +49,7 @@ func TestServer_WebPush_TopicAdd_InvalidEndpoint(t *testing.T) {
 
 	response := request(t, s, "POST", "/v1/webpush", payloadForTopics(t, []string{"test-topic"}, "https://ddos-target.example.com/webpush"), nil)
 	require.Equal(t, 400, response.Code)
-	require.Equal(t, `{"code":40039,"http":400,"error":"invalid request: web push endpoint unknown"}`+"\n", response.Body.String())
+	require.Equal(t, `{"code":40039,"http":400,"slug":"bad-request-web-push-endpoint-unknown","error":"invalid request: web push endpoint unknown","retryable":false}`+"\n", response.Body.String())
 }
 
 func TestServer_WebPush_TopicAdd_TooManyTopics(t *testing.T) {
@@ -62,7 +62,7 @@ func TestServer_WebPush_TopicAdd_TooManyTopics(t *testing.T) {
 
 	response := request(t, s, "POST", "/v1/webpush", payloadForTopics(t, topicList, testWebPushEndpoint), nil)
 	require.Equal(t, 400, response.Code)
-	require.Equal(t, `{"code":40040,"http":400,"error":"invalid request: too many web push topic subscriptions"}`+"\n", response.Body.String())
+	require.Equal(t, `{"code":40040,"http":400,"slug":"bad-request-web-push-topic-count-too-high","error":"invalid request: too many web push topic subscriptions","retryable":false}`+"\n", response.Body.String())
 }
 
 func TestServer_WebPush_TopicUnsubscribe(t *testing.T) {
@@ -141,10 +141,75 @@ func TestServer_WebPush_DeleteAccountUnsubscribe(t *testing.T) {
 	request(t, s, "DELETE", "/v1/account", `{"password":"ben"}`, map[string]string{
 		"Authorization": util.BasicAuth("ben", "ben"),
 	})
+	forceAccountDeletion(t, s, "ben")
 	// should've been deleted with the account
 	requireSubscriptionCount(t, s, "test-topic", 0)
 }
 
+func TestServer_WebPush_AccountSubscriptions(t *testing.T) {
+	config := configureAuth(t, newTestConfigWithWebPush(t))
+	config.WebPushUserSubscriptionLimit = 2
+	s := newTestServer(t, config)
+
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+
+	for i, userAgent := range []string{"Firefox", "Chrome"} {
+		response := request(t, s, "POST", "/v1/webpush", payloadForTopics(t, []string{"test-topic"}, fmt.Sprintf("%s%d", testWebPushEndpoint, i)), map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+			"User-Agent":    userAgent,
+		})
+		require.Equal(t, 200, response.Code)
+	}
+
+	// Limit reached
+	response := request(t, s, "POST", "/v1/webpush", payloadForTopics(t, []string{"test-topic"}, testWebPushEndpoint+"2"), map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42912, toHTTPError(t, response.Body.String()).Code)
+
+	// List subscriptions
+	response = request(t, s, "GET", "/v1/account/webpush", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	subscriptions, err := util.UnmarshalJSON[apiAccountWebPushSubscriptionsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, subscriptions.Limit)
+	require.Len(t, subscriptions.Subscriptions, 2)
+	userAgents := []string{subscriptions.Subscriptions[0].UserAgent, subscriptions.Subscriptions[1].UserAgent}
+	require.ElementsMatch(t, []string{"Firefox", "Chrome"}, userAgents)
+	require.Equal(t, []string{"test-topic"}, subscriptions.Subscriptions[0].Topics)
+
+	// Other users cannot delete the subscription, and do not see it
+	id := subscriptions.Subscriptions[0].ID
+	response = request(t, s, "DELETE", "/v1/account/webpush/"+id, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "GET", "/v1/account/webpush", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, `{"subscriptions":[],"limit":2}`+"\n", response.Body.String())
+
+	// Delete subscription, then another one can be added
+	response = request(t, s, "DELETE", "/v1/account/webpush/"+id, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	requireSubscriptionCount(t, s, "test-topic", 1)
+	response = request(t, s, "POST", "/v1/webpush", payloadForTopics(t, []string{"test-topic"}, testWebPushEndpoint+"2"), map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Anonymous users have no account
+	response = request(t, s, "GET", "/v1/account/webpush", "", nil)
+	require.Equal(t, 401, response.Code)
+}
+
 func TestServer_WebPush_Publish(t *testing.T) {
 	s := newTestServer(t, newTestConfigWithWebPush(t))
 
@@ -167,6 +232,49 @@ func TestServer_WebPush_Publish(t *testing.T) {
 	})
 }
 
+func TestServer_WebPush_Publish_Workers(t *testing.T) {
+	c := newTestConfigWithWebPush(t)
+	c.WebPushWorkers = 3
+	s := newTestServer(t, c)
+
+	var received, active, maxActive atomic.Int32
+	pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		current := active.Add(1)
+		for {
+			max := maxActive.Load()
+			if current <= max || maxActive.CompareAndSwap(max, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		active.Add(-1)
+		received.Add(1)
+	}))
+	defer pushService.Close()
+
+	for i := 0; i < 20; i++ {
+		ip := netip.AddrFrom4([4]byte{1, 2, 3, byte(i)}) // Max. 10 subscriptions per IP
+		require.Nil(t, s.webPush.UpsertSubscription(fmt.Sprintf("%s/push-receive/%d", pushService.URL, i), "kSC3T8aN1JCQxxPdrFLrZg", "BMKKbxdUU_xLS7G1Wh5AN8PvWOjCzkCuKZYb8apcqYrDxjOF_2piggBnoJLQYx9IeSD70fNuwawI3e9Y8m3S3PE", "", ip, "", []string{"test-topic"}))
+	}
+	response := request(t, s, "POST", "/test-topic", "web push test", nil)
+	m := toMessage(t, response.Body.String())
+
+	waitFor(t, func() bool {
+		deliveries, err := s.messageCache.Deliveries(m.ID)
+		require.Nil(t, err)
+		return len(deliveries) == 2 // subscribers + webpush
+	})
+	require.Equal(t, int32(20), received.Load())
+	require.LessOrEqual(t, maxActive.Load(), int32(3))
+	require.Greater(t, maxActive.Load(), int32(1))
+	deliveries, err := s.messageCache.Deliveries(m.ID)
+	require.Nil(t, err)
+	require.Equal(t, deliveryChannelWebPush, deliveries[1].Channel)
+	require.Equal(t, 20, deliveries[1].Count)
+}
+
 func TestServer_WebPush_Publish_RemoveOnError(t *testing.T) {
 	s := newTestServer(t, newTestConfigWithWebPush(t))
 
@@ -233,6 +341,56 @@ func TestServer_WebPush_Expiry(t *testing.T) {
 	})
 }
 
+func TestServer_WebPush_Expiry_Ping(t *testing.T) {
+	c := newTestConfigWithWebPush(t)
+	c.WebPushKeepaliveStrategy = WebPushKeepaliveStrategyPing
+	s := newTestServer(t, c)
+
+	var received atomic.Int32
+	pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		received.Add(1)
+	}))
+	defer pushService.Close()
+
+	addSubscription(t, s, pushService.URL+"/push-receive", "test-topic")
+	addSubscription(t, s, pushService.URL+"/push-receive-unresponsive", "test-topic")
+	requireSubscriptionCount(t, s, "test-topic", 2)
+
+	// Subscriptions are not removed by age alone, but are pinged
+	_, err := s.webPush.db.Exec("UPDATE subscription SET updated_at = ?", time.Now().Add(-30*24*time.Hour).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.pruneAndNotifyWebPushSubscriptionsInternal())
+	require.Equal(t, int32(2), received.Load())
+	requireSubscriptionCount(t, s, "test-topic", 2)
+
+	// Only the first subscription answers the ping
+	response := request(t, s, "POST", "/v1/webpush/renew", fmt.Sprintf(`{"endpoint":"%s/push-receive"}`, pushService.URL), nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, `{"success":true}`+"\n", response.Body.String())
+
+	// Unresponsive subscription is removed after the remaining time until expiry (2 days)
+	_, err = s.webPush.db.Exec("UPDATE subscription SET warned_at = ? WHERE warned_at > 0", time.Now().Add(-3*24*time.Hour).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.pruneAndNotifyWebPushSubscriptionsInternal())
+	subs, err := s.webPush.SubscriptionsForTopic("test-topic")
+	require.Nil(t, err)
+	require.Len(t, subs, 1)
+	require.Equal(t, pushService.URL+"/push-receive", subs[0].Endpoint)
+	require.Equal(t, int32(2), received.Load()) // Renewed subscription is not pinged again
+}
+
+func TestServer_WebPush_Renew_NotFound(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithWebPush(t))
+
+	response := request(t, s, "POST", "/v1/webpush/renew", fmt.Sprintf(`{"endpoint":"%s"}`, testWebPushEndpoint), nil)
+	require.Equal(t, 404, response.Code)
+
+	response = request(t, s, "POST", "/v1/webpush/renew", `{}`, nil)
+	require.Equal(t, 400, response.Code)
+}
+
 func payloadForTopics(t *testing.T, topics []string, endpoint string) string {
 	topicsJSON, err := json.Marshal(topics)
 	require.Nil(t, err)
@@ -246,7 +404,7 @@ func payloadForTopics(t *testing.T, topics []string, endpoint string) string {
 }
 
 func addSubscription(t *testing.T, s *Server, endpoint string, topics ...string) {
-	require.Nil(t, s.webPush.UpsertSubscription(endpoint, "kSC3T8aN1JCQxxPdrFLrZg", "BMKKbxdUU_xLS7G1Wh5AN8PvWOjCzkCuKZYb8apcqYrDxjOF_2piggBnoJLQYx9IeSD70fNuwawI3e9Y8m3S3PE", "u_123", netip.MustParseAddr("1.2.3.4"), topics)) // Test auth and p256dh
+	require.Nil(t, s.webPush.UpsertSubscription(endpoint, "kSC3T8aN1JCQxxPdrFLrZg", "BMKKbxdUU_xLS7G1Wh5AN8PvWOjCzkCuKZYb8apcqYrDxjOF_2piggBnoJLQYx9IeSD70fNuwawI3e9Y8m3S3PE", "u_123", netip.MustParseAddr("1.2.3.4"), "", topics)) // Test auth and p256dh
 }
 
 func requireSubscriptionCount(t *testing.T, s *Server, topic string, expectedLength int) {
@@ -254,3 +412,30 @@ func requireSubscriptionCount(t *testing.T, s *Server, topic string, expectedLen
 	require.Nil(t, err)
 	require.Len(t, subs, expectedLength)
 }
+
+func TestServer_WebPush_Payload_Markdown(t *testing.T) {
+	m := newDefaultMessage("test-topic", "Look ma, **bold text**")
+	payload := newWebPushPayload("https://ntfy.sh/test-topic", m)
+	require.Equal(t, "", payload.MessageHTML)
+
+	m.ContentType = "text/markdown"
+	payload = newWebPushPayload("https://ntfy.sh/test-topic", m)
+	require.Equal(t, "<p>Look ma, <strong>bold text</strong></p>", payload.MessageHTML)
+	require.Equal(t, "Look ma, **bold text**", payload.Message.Message)
+
+	m.Message = strings.Repeat("**bold** ", 100)
+	payload = newWebPushPayload("https://ntfy.sh/test-topic", m)
+	require.True(t, strings.HasSuffix(payload.MessageHTML, "…</p>"))
+	require.Less(t, len(payload.MessageHTML), 3*webPushMarkdownExcerptLength)
+}
+
+func TestServer_WebPush_ExpiringPayload_Translated(t *testing.T) {
+	payload := newWebPushSubscriptionExpiringPayload("")
+	require.Equal(t, "subscription_expiring", payload.Event)
+	require.Equal(t, "", payload.Title)
+	require.Equal(t, "", payload.Body)
+
+	payload = newWebPushSubscriptionExpiringPayload("es")
+	require.Equal(t, "Las notificaciones se pausarán", payload.Title)
+	require.Equal(t, "Abre ntfy para seguir recibiendo notificaciones", payload.Body)
+}
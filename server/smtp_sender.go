@@ -1,9 +1,12 @@
 package server
 
 import (
+	"context"
 	_ "embed" // required by go:embed
 	"encoding/json"
 	"fmt"
+	"html"
+	"math"
 	"mime"
 	"net"
 	"net/smtp"
@@ -11,6 +14,7 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/time/rate"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/util"
 )
@@ -20,11 +24,42 @@ type mailer interface {
 	Counts() (total int64, success int64, failure int64)
 }
 
+// newMailer creates the mailer for the configured smtp-sender-provider, or returns nil if sending e-mails is
+// not enabled. If a rate limit is configured (or the provider has a default one), sending blocks until the
+// next e-mail is allowed, so that e-mails are delayed rather than rejected by the provider.
+func newMailer(conf *Config) (mailer, error) {
+	var m mailer
+	switch conf.SMTPSenderProvider {
+	case "", SMTPSenderProviderSMTP:
+		if conf.SMTPSenderAddr == "" {
+			return nil, nil
+		}
+		m = &smtpSender{config: conf}
+	case SMTPSenderProviderSendGrid, SMTPSenderProviderMailgun, SMTPSenderProviderSES:
+		sender, err := newAPISender(conf)
+		if err != nil {
+			return nil, err
+		}
+		m = sender
+	default:
+		return nil, fmt.Errorf("invalid smtp-sender-provider %s", conf.SMTPSenderProvider)
+	}
+	limit := conf.SMTPSenderRateLimit
+	if limit <= 0 {
+		limit = emailProviderRateLimits[conf.SMTPSenderProvider]
+	}
+	if limit > 0 {
+		return &rateLimitedMailer{
+			mailer:  m,
+			limiter: rate.NewLimiter(rate.Limit(limit), int(math.Max(1, limit))),
+		}, nil
+	}
+	return m, nil
+}
+
 type smtpSender struct {
-	config  *Config
-	success int64
-	failure int64
-	mu      sync.Mutex
+	config *Config
+	mailerStats
 }
 
 func (s *smtpSender) Send(v *visitor, m *message, to string) error {
@@ -33,7 +68,7 @@ func (s *smtpSender) Send(v *visitor, m *message, to string) error {
 		if err != nil {
 			return err
 		}
-		message, err := formatMail(s.config.BaseURL, v.ip.String(), s.config.SMTPSenderFrom, to, m)
+		message, err := formatMail(s.config.BaseURL, v.ip.String(), s.config.SMTPSenderFrom, to, userLanguage(v.User()), m)
 		if err != nil {
 			return err
 		}
@@ -57,13 +92,20 @@ func (s *smtpSender) Send(v *visitor, m *message, to string) error {
 	})
 }
 
-func (s *smtpSender) Counts() (total int64, success int64, failure int64) {
+// mailerStats counts successfully sent and failed e-mails, and is embedded in all mailer implementations
+type mailerStats struct {
+	success int64
+	failure int64
+	mu      sync.Mutex
+}
+
+func (s *mailerStats) Counts() (total int64, success int64, failure int64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	return s.success + s.failure, s.success, s.failure
 }
 
-func (s *smtpSender) withCount(v *visitor, m *message, fn func() error) error {
+func (s *mailerStats) withCount(v *visitor, m *message, fn func() error) error {
 	err := fn()
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -76,7 +118,72 @@ func (s *smtpSender) withCount(v *visitor, m *message, fn func() error) error {
 	return err
 }
 
-func formatMail(baseURL, senderIP, from, to string, m *message) (string, error) {
+// rateLimitedMailer wraps a mailer and waits before sending each e-mail, see smtp-sender-rate-limit
+type rateLimitedMailer struct {
+	mailer
+	limiter *rate.Limiter
+}
+
+func (r *rateLimitedMailer) Send(v *visitor, m *message, to string) error {
+	if err := r.limiter.Wait(context.Background()); err != nil {
+		return err
+	}
+	return r.mailer.Send(v, m, to)
+}
+
+// mailContent is the formatted content of an e-mail, independent of how it is sent. The SMTP sender and the
+// raw MIME API providers use formatMail to build a full e-mail from it, others (SendGrid) pass on the parts as is.
+type mailContent struct {
+	FromName string // Shown as sender name, e.g. "ntfy.sh/alerts"
+	Subject  string // Not MIME-encoded
+	Text     string // Plain text body, including the footer
+	HTML     string // HTML body including the footer, only set for Markdown messages
+}
+
+// formatMail formats the message as e-mail. The trailer (tags, priority) and the footer are translated
+// to the given language, see translate.
+func formatMail(baseURL, senderIP, from, to, lang string, m *message) (string, error) {
+	content, err := formatMailContent(baseURL, senderIP, lang, m)
+	if err != nil {
+		return "", err
+	}
+	body := `From: "{fromName}" <{from}>
+To: {to}
+Subject: {subject}
+Content-Type: text/plain; charset="utf-8"
+
+{message}`
+	if content.HTML != "" {
+		// Markdown messages are sent as plain text and as rendered HTML, and the mail client picks one
+		body = `From: "{fromName}" <{from}>
+To: {to}
+Subject: {subject}
+MIME-Version: 1.0
+Content-Type: multipart/alternative; boundary="{boundary}"
+
+--{boundary}
+Content-Type: text/plain; charset="utf-8"
+
+{message}
+--{boundary}
+Content-Type: text/html; charset="utf-8"
+
+{htmlMessage}
+--{boundary}--`
+	}
+	return strings.NewReplacer(
+		"{fromName}", content.FromName,
+		"{from}", from,
+		"{to}", to,
+		"{subject}", mime.BEncoding.Encode("utf-8", content.Subject),
+		"{boundary}", "ntfy-"+m.ID,
+		"{message}", content.Text,
+		"{htmlMessage}", content.HTML,
+	).Replace(body), nil
+}
+
+// formatMailContent formats subject and body of the e-mail for the given message, see formatMail
+func formatMailContent(baseURL, senderIP, lang string, m *message) (*mailContent, error) {
 	topicURL := baseURL + "/" + m.Topic
 	subject := m.Title
 	if subject == "" {
@@ -88,47 +195,46 @@ func formatMail(baseURL, senderIP, from, to string, m *message) (string, error)
 	if len(m.Tags) > 0 {
 		emojis, tags, err := toEmojis(m.Tags)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		if len(emojis) > 0 {
 			subject = strings.Join(emojis, " ") + " " + subject
 		}
 		if len(tags) > 0 {
-			trailer = "Tags: " + strings.Join(tags, ", ")
+			trailer = translate(lang, "email_tags", strings.Join(tags, ", "))
 		}
 	}
 	if m.Priority != 0 && m.Priority != 3 {
 		priority, err := util.PriorityString(m.Priority)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		if trailer != "" {
 			trailer += "\n"
 		}
-		trailer += fmt.Sprintf("Priority: %s", priority)
+		trailer += translate(lang, "email_priority", priority)
+	}
+	htmlMessage := ""
+	if isMarkdown(m) {
+		htmlMessage = renderMarkdown(m.Message)
+		if trailer != "" {
+			htmlMessage += "\n<p>" + strings.ReplaceAll(html.EscapeString(trailer), "\n", "<br>\n") + "</p>"
+		}
 	}
 	if trailer != "" {
 		message += "\n\n" + trailer
 	}
-	subject = mime.BEncoding.Encode("utf-8", subject)
-	body := `From: "{shortTopicURL}" <{from}>
-To: {to}
-Subject: {subject}
-Content-Type: text/plain; charset="utf-8"
-
-{message}
-
---
-This message was sent by {ip} at {time} via {topicURL}`
-	body = strings.ReplaceAll(body, "{from}", from)
-	body = strings.ReplaceAll(body, "{to}", to)
-	body = strings.ReplaceAll(body, "{subject}", subject)
-	body = strings.ReplaceAll(body, "{message}", message)
-	body = strings.ReplaceAll(body, "{topicURL}", topicURL)
-	body = strings.ReplaceAll(body, "{shortTopicURL}", util.ShortTopicURL(topicURL))
-	body = strings.ReplaceAll(body, "{time}", time.Unix(m.Time, 0).UTC().Format(time.RFC1123))
-	body = strings.ReplaceAll(body, "{ip}", senderIP)
-	return body, nil
+	sent := time.Unix(m.Time, 0).UTC().Format(time.RFC1123)
+	content := &mailContent{
+		FromName: util.ShortTopicURL(topicURL),
+		Subject:  subject,
+		Text:     message + "\n\n--\n" + translate(lang, "email_footer", senderIP, sent, topicURL),
+	}
+	if htmlMessage != "" {
+		htmlFooter := translate(lang, "email_footer", senderIP, sent, fmt.Sprintf(`<a href="%s">%s</a>`, topicURL, topicURL))
+		content.HTML = htmlMessage + "\n<p>--<br>\n" + htmlFooter + "</p>"
+	}
+	return content, nil
 }
 
 var (
@@ -0,0 +1,478 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	sendGridDefaultBaseURL        = "https://api.sendgrid.com"
+	sendGridSignatureHeader       = "X-Twilio-Email-Event-Webhook-Signature"
+	sendGridTimestampHeader       = "X-Twilio-Email-Event-Webhook-Timestamp"
+	mailgunDefaultBaseURL         = "https://api.mailgun.net"
+	mailgunEUBaseURL              = "https://api.eu.mailgun.net"
+	sesBaseURLFormat              = "https://email.%s.amazonaws.com"
+	sesSendPath                   = "/v2/email/outbound-emails"
+	sesService                    = "ses"
+	emailAPIResponseBytesLimit    = 4096
+	emailWebhookSignatureMaxAge   = 5 * time.Minute
+	emailWebhookRequestBytesLimit = 1024 * 1024 // SendGrid posts events in batches
+)
+
+var (
+	// emailProviderRateLimits are the default max. e-mails per second of each provider, if smtp-sender-rate-limit
+	// is not set. They match the default sending quotas of the providers; raw SMTP is not limited.
+	emailProviderRateLimits = map[string]float64{
+		SMTPSenderProviderSendGrid: 10,
+		SMTPSenderProviderMailgun:  10,
+		SMTPSenderProviderSES:      14,
+	}
+
+	// snsSubscribeURLRegex matches the URLs that Amazon SNS sends to confirm a webhook subscription
+	snsSubscribeURLRegex = regexp.MustCompile(`^https://sns\.[-a-z0-9]+\.amazonaws\.com(\.cn)?/`)
+
+	errEmailWebhookSignatureInvalid = errors.New("invalid e-mail webhook signature")
+)
+
+// apiSender is a mailer that sends e-mails via the HTTP API of SendGrid, Mailgun or Amazon SES,
+// instead of via SMTP, see smtp-sender-provider
+type apiSender struct {
+	config  *Config
+	baseURL string
+	client  *http.Client
+	mailerStats
+}
+
+func newAPISender(conf *Config) (*apiSender, error) {
+	if conf.SMTPSenderAPIKey == "" {
+		return nil, fmt.Errorf("smtp-sender-api-key must be set for provider %s", conf.SMTPSenderProvider)
+	}
+	baseURL := conf.SMTPSenderAPIBaseURL
+	switch conf.SMTPSenderProvider {
+	case SMTPSenderProviderSendGrid:
+		if baseURL == "" {
+			baseURL = sendGridDefaultBaseURL
+		}
+	case SMTPSenderProviderMailgun:
+		if baseURL == "" && conf.SMTPSenderAPIRegion == "eu" {
+			baseURL = mailgunEUBaseURL
+		} else if baseURL == "" {
+			baseURL = mailgunDefaultBaseURL
+		}
+	case SMTPSenderProviderSES:
+		if conf.SMTPSenderAPISecret == "" || conf.SMTPSenderAPIRegion == "" {
+			return nil, errors.New("smtp-sender-api-secret and smtp-sender-api-region must be set for provider ses")
+		} else if baseURL == "" {
+			baseURL = fmt.Sprintf(sesBaseURLFormat, conf.SMTPSenderAPIRegion)
+		}
+	}
+	return &apiSender{
+		config:  conf,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (s *apiSender) Send(v *visitor, m *message, to string) error {
+	return s.withCount(v, m, func() error {
+		ev := logvm(v, m).
+			Tag(tagEmail).
+			Fields(log.Context{
+				"email_via": s.config.SMTPSenderProvider,
+				"email_to":  to,
+			})
+		ev.Debug("Sending email via %s API", s.config.SMTPSenderProvider)
+		lang := userLanguage(v.User())
+		if s.config.SMTPSenderProvider == SMTPSenderProviderSendGrid {
+			content, err := formatMailContent(s.config.BaseURL, v.ip.String(), lang, m)
+			if err != nil {
+				return err
+			}
+			return s.sendSendGrid(to, content)
+		}
+		raw, err := formatMail(s.config.BaseURL, v.ip.String(), s.config.SMTPSenderFrom, to, lang, m)
+		if err != nil {
+			return err
+		}
+		if s.config.SMTPSenderProvider == SMTPSenderProviderMailgun {
+			return s.sendMailgun(to, raw)
+		}
+		return s.sendSES(to, raw, time.Now())
+	})
+}
+
+// sendSendGrid sends the e-mail via the SendGrid v3 Mail Send API. SendGrid does not accept raw MIME messages,
+// so subject and bodies are passed separately.
+func (s *apiSender) sendSendGrid(to string, content *mailContent) error {
+	contents := []*sendGridContent{{Type: "text/plain", Value: content.Text}}
+	if content.HTML != "" {
+		contents = append(contents, &sendGridContent{Type: "text/html", Value: content.HTML})
+	}
+	body, err := json.Marshal(&sendGridMail{
+		Personalizations: []*sendGridPersonalization{{To: []*sendGridAddress{{Email: to}}}},
+		From:             &sendGridAddress{Email: s.config.SMTPSenderFrom, Name: content.FromName},
+		Subject:          content.Subject,
+		Content:          contents,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.config.SMTPSenderAPIKey)
+	req.Header.Set("Content-Type", "application/json")
+	return s.do(req)
+}
+
+// sendMailgun sends the raw e-mail via the Mailgun API. The sending domain is the domain of smtp-sender-from.
+func (s *apiSender) sendMailgun(to, raw string) error {
+	_, domain, ok := strings.Cut(s.config.SMTPSenderFrom, "@")
+	if !ok {
+		return fmt.Errorf("cannot determine Mailgun domain from sender address %s", s.config.SMTPSenderFrom)
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("to", to); err != nil {
+		return err
+	}
+	part, err := form.CreateFormFile("message", "message.mime")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write([]byte(raw)); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v3/%s/messages.mime", s.baseURL, domain), &body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("api", s.config.SMTPSenderAPIKey)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return s.do(req)
+}
+
+// sendSES sends the raw e-mail via the Amazon SES v2 API. Requests are signed with AWS Signature Version 4.
+func (s *apiSender) sendSES(to, raw string, now time.Time) error {
+	body, err := json.Marshal(&sesSendEmailRequest{
+		Destination: &sesDestination{ToAddresses: []string{to}},
+		Content:     &sesContent{Raw: &sesRawMessage{Data: []byte(raw)}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+sesSendPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signAWSRequest(req, body, s.config.SMTPSenderAPIKey, s.config.SMTPSenderAPISecret, s.config.SMTPSenderAPIRegion, sesService, now)
+	return s.do(req)
+}
+
+func (s *apiSender) do(req *http.Request) error {
+	req.Header.Set("User-Agent", "ntfy/"+s.config.Version)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, emailAPIResponseBytesLimit))
+		return fmt.Errorf("%s API returned %s: %s", s.config.SMTPSenderProvider, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// signAWSRequest adds the AWS Signature Version 4 headers to the request, see
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_aws-signing.html
+func signAWSRequest(req *http.Request, body []byte, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"content-type:" + req.Header.Get("Content-Type"),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// emailWebhookEvent is the result of parsing a bounce webhook request of the configured provider
+type emailWebhookEvent struct {
+	Bounces      []*emailBounce
+	SubscribeURL string // Amazon SNS only: the subscription must be confirmed by fetching this URL
+}
+
+// emailBounce is an e-mail address that permanently bounced, or whose owner marked an e-mail as spam
+type emailBounce struct {
+	Address string
+	Reason  string
+}
+
+// parseEmailWebhook verifies and parses a bounce webhook request of the configured provider. SendGrid requests are
+// signed with ECDSA (smtp-sender-webhook-key is the public key), Mailgun requests with HMAC-SHA256 (it is the
+// signing key). Amazon SNS signatures require fetching certificates, so for SES the key must be passed as "key"
+// query parameter in the webhook URL instead.
+func parseEmailWebhook(conf *Config, r *http.Request, body []byte, now time.Time) (*emailWebhookEvent, error) {
+	switch conf.SMTPSenderProvider {
+	case SMTPSenderProviderSendGrid:
+		return parseSendGridWebhook(conf.SMTPSenderWebhookKey, r.Header, body, now)
+	case SMTPSenderProviderMailgun:
+		return parseMailgunWebhook(conf.SMTPSenderWebhookKey, body, now)
+	case SMTPSenderProviderSES:
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("key")), []byte(conf.SMTPSenderWebhookKey)) != 1 {
+			return nil, errEmailWebhookSignatureInvalid
+		}
+		return parseSESWebhook(body)
+	}
+	return nil, fmt.Errorf("provider %s does not support bounce webhooks", conf.SMTPSenderProvider)
+}
+
+func parseSendGridWebhook(publicKey string, header http.Header, body []byte, now time.Time) (*emailWebhookEvent, error) {
+	timestamp := header.Get(sendGridTimestampHeader)
+	if err := verifyWebhookTimestamp(timestamp, now); err != nil {
+		return nil, err
+	}
+	keyBytes, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("smtp-sender-webhook-key is not an ECDSA public key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(header.Get(sendGridSignatureHeader))
+	if err != nil {
+		return nil, errEmailWebhookSignatureInvalid
+	}
+	hash := sha256.Sum256(append([]byte(timestamp), body...))
+	if !ecdsa.VerifyASN1(ecdsaKey, hash[:], signature) {
+		return nil, errEmailWebhookSignatureInvalid
+	}
+	var events []*sendGridEvent
+	if err := json.Unmarshal(body, &events); err != nil {
+		return nil, err
+	}
+	bounces := make([]*emailBounce, 0)
+	for _, e := range events {
+		switch {
+		case e.Event == "bounce" && e.Type != "blocked": // Blocked bounces are temporary
+			bounces = append(bounces, &emailBounce{Address: e.Email, Reason: "bounce: " + e.Reason})
+		case e.Event == "dropped":
+			bounces = append(bounces, &emailBounce{Address: e.Email, Reason: "dropped: " + e.Reason})
+		case e.Event == "spamreport":
+			bounces = append(bounces, &emailBounce{Address: e.Email, Reason: "spam report"})
+		}
+	}
+	return &emailWebhookEvent{Bounces: bounces}, nil
+}
+
+func parseMailgunWebhook(signingKey string, body []byte, now time.Time) (*emailWebhookEvent, error) {
+	var webhook mailgunWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, err
+	} else if webhook.Signature == nil || webhook.EventData == nil {
+		return nil, errEmailWebhookSignatureInvalid
+	} else if err := verifyWebhookTimestamp(webhook.Signature.Timestamp, now); err != nil {
+		return nil, err
+	}
+	expected := hex.EncodeToString(hmacSHA256([]byte(signingKey), webhook.Signature.Timestamp+webhook.Signature.Token))
+	if !hmac.Equal([]byte(expected), []byte(webhook.Signature.Signature)) {
+		return nil, errEmailWebhookSignatureInvalid
+	}
+	bounces := make([]*emailBounce, 0)
+	e := webhook.EventData
+	if e.Event == "failed" && e.Severity == "permanent" {
+		reason := "bounce"
+		if e.DeliveryStatus != nil && e.DeliveryStatus.Message != "" {
+			reason += ": " + e.DeliveryStatus.Message
+		}
+		bounces = append(bounces, &emailBounce{Address: e.Recipient, Reason: reason})
+	} else if e.Event == "complained" {
+		bounces = append(bounces, &emailBounce{Address: e.Recipient, Reason: "spam report"})
+	}
+	return &emailWebhookEvent{Bounces: bounces}, nil
+}
+
+func parseSESWebhook(body []byte) (*emailWebhookEvent, error) {
+	var notification snsNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, err
+	}
+	if notification.Type == "SubscriptionConfirmation" {
+		if !snsSubscribeURLRegex.MatchString(notification.SubscribeURL) {
+			return nil, fmt.Errorf("invalid SNS subscribe URL %s", notification.SubscribeURL)
+		}
+		return &emailWebhookEvent{SubscribeURL: notification.SubscribeURL}, nil
+	}
+	bounces := make([]*emailBounce, 0)
+	if notification.Type != "Notification" {
+		return &emailWebhookEvent{Bounces: bounces}, nil
+	}
+	var ses sesNotification
+	if err := json.Unmarshal([]byte(notification.Message), &ses); err != nil {
+		return nil, err
+	}
+	if ses.NotificationType == "Bounce" && ses.Bounce != nil && ses.Bounce.BounceType == "Permanent" {
+		for _, recipient := range ses.Bounce.BouncedRecipients {
+			reason := "bounce"
+			if recipient.DiagnosticCode != "" {
+				reason += ": " + recipient.DiagnosticCode
+			}
+			bounces = append(bounces, &emailBounce{Address: recipient.EmailAddress, Reason: reason})
+		}
+	} else if ses.NotificationType == "Complaint" && ses.Complaint != nil {
+		for _, recipient := range ses.Complaint.ComplainedRecipients {
+			bounces = append(bounces, &emailBounce{Address: recipient.EmailAddress, Reason: "spam report"})
+		}
+	}
+	return &emailWebhookEvent{Bounces: bounces}, nil
+}
+
+// verifyWebhookTimestamp rejects requests older than emailWebhookSignatureMaxAge to prevent replays
+func verifyWebhookTimestamp(timestamp string, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Sub(time.Unix(ts, 0)).Abs() > emailWebhookSignatureMaxAge {
+		return errEmailWebhookSignatureInvalid
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+type sendGridMail struct {
+	Personalizations []*sendGridPersonalization `json:"personalizations"`
+	From             *sendGridAddress           `json:"from"`
+	Subject          string                     `json:"subject"`
+	Content          []*sendGridContent         `json:"content"`
+}
+
+type sendGridPersonalization struct {
+	To []*sendGridAddress `json:"to"`
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridEvent struct {
+	Email  string `json:"email"`
+	Event  string `json:"event"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type mailgunWebhook struct {
+	Signature *struct {
+		Timestamp string `json:"timestamp"`
+		Token     string `json:"token"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+	EventData *struct {
+		Event          string `json:"event"`
+		Severity       string `json:"severity"`
+		Recipient      string `json:"recipient"`
+		DeliveryStatus *struct {
+			Message string `json:"message"`
+		} `json:"delivery-status"`
+	} `json:"event-data"`
+}
+
+type sesSendEmailRequest struct {
+	Destination *sesDestination `json:"Destination"`
+	Content     *sesContent     `json:"Content"`
+}
+
+type sesDestination struct {
+	ToAddresses []string `json:"ToAddresses"`
+}
+
+type sesContent struct {
+	Raw *sesRawMessage `json:"Raw"`
+}
+
+type sesRawMessage struct {
+	Data []byte `json:"Data"` // Base64-encoded by encoding/json
+}
+
+type snsNotification struct {
+	Type         string `json:"Type"`
+	Message      string `json:"Message"`
+	SubscribeURL string `json:"SubscribeURL"`
+}
+
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	Bounce           *struct {
+		BounceType        string `json:"bounceType"`
+		BouncedRecipients []*struct {
+			EmailAddress   string `json:"emailAddress"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint *struct {
+		ComplainedRecipients []*struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAPISender_SendGrid(t *testing.T) {
+	var called atomic.Bool
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v3/mail/send", r.URL.Path)
+		require.Equal(t, "Bearer SG.1234", r.Header.Get("Authorization"))
+		var mail sendGridMail
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&mail))
+		require.Equal(t, "phil@example.com", mail.Personalizations[0].To[0].Email)
+		require.Equal(t, "ntfy@ntfy.sh", mail.From.Email)
+		require.Equal(t, "ntfy.sh/alerts", mail.From.Name)
+		require.Equal(t, "A simple message", mail.Subject)
+		require.Equal(t, 1, len(mail.Content))
+		require.Equal(t, "text/plain", mail.Content[0].Type)
+		require.Equal(t, "A simple message\n\n--\nThis message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://ntfy.sh/alerts", mail.Content[0].Value)
+		called.Store(true)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer api.Close()
+
+	c := newTestConfig(t)
+	c.BaseURL = "https://ntfy.sh"
+	c.SMTPSenderProvider = SMTPSenderProviderSendGrid
+	c.SMTPSenderAPIKey = "SG.1234"
+	c.SMTPSenderAPIBaseURL = api.URL
+	c.SMTPSenderFrom = "ntfy@ntfy.sh"
+	sender, err := newAPISender(c)
+	require.Nil(t, err)
+	require.Nil(t, sender.Send(newTestAPISenderVisitor(t, c), newTestAPISenderMessage(), "phil@example.com"))
+	require.True(t, called.Load())
+	total, success, failure := sender.Counts()
+	require.Equal(t, []int64{1, 1, 0}, []int64{total, success, failure})
+}
+
+func TestAPISender_Mailgun(t *testing.T) {
+	var called atomic.Bool
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v3/mg.ntfy.sh/messages.mime", r.URL.Path)
+		username, password, ok := r.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "api", username)
+		require.Equal(t, "key-1234", password)
+		require.Nil(t, r.ParseMultipartForm(1024*1024))
+		require.Equal(t, "phil@example.com", r.FormValue("to"))
+		file, _, err := r.FormFile("message")
+		require.Nil(t, err)
+		raw, err := io.ReadAll(file)
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(string(raw), "From: \"ntfy.sh/alerts\" <ntfy@mg.ntfy.sh>\nTo: phil@example.com\nSubject: A simple message\n"))
+		called.Store(true)
+	}))
+	defer api.Close()
+
+	c := newTestConfig(t)
+	c.BaseURL = "https://ntfy.sh"
+	c.SMTPSenderProvider = SMTPSenderProviderMailgun
+	c.SMTPSenderAPIKey = "key-1234"
+	c.SMTPSenderAPIBaseURL = api.URL
+	c.SMTPSenderFrom = "ntfy@mg.ntfy.sh"
+	sender, err := newAPISender(c)
+	require.Nil(t, err)
+	require.Nil(t, sender.Send(newTestAPISenderVisitor(t, c), newTestAPISenderMessage(), "phil@example.com"))
+	require.True(t, called.Load())
+}
+
+func TestAPISender_SES(t *testing.T) {
+	var called atomic.Bool
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v2/email/outbound-emails", r.URL.Path)
+		require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID1234/"))
+		require.Contains(t, r.Header.Get("Authorization"), "/us-east-2/ses/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature=")
+		var req sesSendEmailRequest
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, []string{"phil@example.com"}, req.Destination.ToAddresses)
+		require.True(t, strings.HasPrefix(string(req.Content.Raw.Data), "From: \"ntfy.sh/alerts\" <ntfy@ntfy.sh>\n"))
+		called.Store(true)
+	}))
+	defer api.Close()
+
+	c := newTestConfig(t)
+	c.BaseURL = "https://ntfy.sh"
+	c.SMTPSenderProvider = SMTPSenderProviderSES
+	c.SMTPSenderAPIKey = "AKID1234"
+	c.SMTPSenderAPISecret = "secret"
+	c.SMTPSenderAPIRegion = "us-east-2"
+	c.SMTPSenderAPIBaseURL = api.URL
+	c.SMTPSenderFrom = "ntfy@ntfy.sh"
+	sender, err := newAPISender(c)
+	require.Nil(t, err)
+	require.Nil(t, sender.Send(newTestAPISenderVisitor(t, c), newTestAPISenderMessage(), "phil@example.com"))
+	require.True(t, called.Load())
+}
+
+func TestAPISender_Failure(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"errors":[{"message":"invalid API key"}]}`))
+	}))
+	defer api.Close()
+
+	c := newTestConfig(t)
+	c.SMTPSenderProvider = SMTPSenderProviderSendGrid
+	c.SMTPSenderAPIKey = "SG.invalid"
+	c.SMTPSenderAPIBaseURL = api.URL
+	sender, err := newAPISender(c)
+	require.Nil(t, err)
+	err = sender.Send(newTestAPISenderVisitor(t, c), newTestAPISenderMessage(), "phil@example.com")
+	require.Equal(t, `sendgrid API returned 401 Unauthorized: {"errors":[{"message":"invalid API key"}]}`, err.Error())
+	total, success, failure := sender.Counts()
+	require.Equal(t, []int64{1, 0, 1}, []int64{total, success, failure})
+}
+
+func TestNewMailer(t *testing.T) {
+	c := newTestConfig(t)
+	m, err := newMailer(c)
+	require.Nil(t, err)
+	require.Nil(t, m) // Not enabled
+
+	c.SMTPSenderAddr = "localhost:25"
+	m, err = newMailer(c)
+	require.Nil(t, err)
+	require.IsType(t, &smtpSender{}, m) // Not rate limited by default
+
+	c.SMTPSenderProvider = SMTPSenderProviderSES
+	c.SMTPSenderAPIKey = "AKID1234"
+	_, err = newMailer(c)
+	require.Error(t, err) // Secret and region missing
+
+	c.SMTPSenderAPISecret = "secret"
+	c.SMTPSenderAPIRegion = "us-east-2"
+	m, err = newMailer(c)
+	require.Nil(t, err)
+	require.Equal(t, 14.0, float64(m.(*rateLimitedMailer).limiter.Limit()))
+	require.Equal(t, "https://email.us-east-2.amazonaws.com", m.(*rateLimitedMailer).mailer.(*apiSender).baseURL)
+
+	c.SMTPSenderRateLimit = 2
+	m, err = newMailer(c)
+	require.Nil(t, err)
+	require.Equal(t, 2.0, float64(m.(*rateLimitedMailer).limiter.Limit()))
+
+	c.SMTPSenderProvider = "carrier-pigeon"
+	_, err = newMailer(c)
+	require.Error(t, err)
+}
+
+func TestServer_EmailWebhook_SendGrid(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.Nil(t, err)
+
+	c := newTestConfigWithAuthFile(t)
+	c.SMTPSenderProvider = SMTPSenderProviderSendGrid
+	c.SMTPSenderAPIKey = "SG.1234"
+	c.SMTPSenderFrom = "ntfy@ntfy.sh"
+	c.SMTPSenderWebhookKey = base64.StdEncoding.EncodeToString(publicKey)
+	s := newTestServer(t, c)
+
+	body := `[
+		{"email":"bounced@example.com","event":"bounce","type":"bounce","reason":"550 5.1.1 user unknown"},
+		{"email":"blocked@example.com","event":"bounce","type":"blocked","reason":"temporarily blocked"},
+		{"email":"spam@example.com","event":"spamreport"},
+		{"email":"fine@example.com","event":"delivered"}
+	]`
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	hash := sha256.Sum256([]byte(timestamp + body))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	require.Nil(t, err)
+
+	// Invalid signature
+	response := request(t, s, "POST", "/v1/email/webhook", body, map[string]string{
+		sendGridTimestampHeader: timestamp,
+		sendGridSignatureHeader: base64.StdEncoding.EncodeToString([]byte("invalid")),
+	})
+	require.Equal(t, 401, response.Code)
+
+	// Valid signature
+	response = request(t, s, "POST", "/v1/email/webhook", body, map[string]string{
+		sendGridTimestampHeader: timestamp,
+		sendGridSignatureHeader: base64.StdEncoding.EncodeToString(signature),
+	})
+	require.Equal(t, 200, response.Code)
+	emails, err := s.messageCache.UndeliverableEmails()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(emails))
+	require.Equal(t, "bounced@example.com", emails[0].Address)
+	require.Equal(t, "bounce: 550 5.1.1 user unknown", emails[0].Reason)
+	require.Equal(t, "spam@example.com", emails[1].Address)
+	require.Equal(t, "spam report", emails[1].Reason)
+
+	// Publishing to undeliverable address is rejected, others are fine
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{"Email": "Bounced@example.com"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40054, toHTTPError(t, response.Body.String()).Code)
+	s.smtpSender = &testMailer{}
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{"Email": "blocked@example.com"})
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_EmailWebhook_Mailgun(t *testing.T) {
+	c := newTestConfig(t)
+	c.SMTPSenderProvider = SMTPSenderProviderMailgun
+	c.SMTPSenderAPIKey = "key-1234"
+	c.SMTPSenderFrom = "ntfy@mg.ntfy.sh"
+	c.SMTPSenderWebhookKey = "signing-key"
+	s := newTestServer(t, c)
+
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	signature := hex.EncodeToString(hmacSHA256([]byte("signing-key"), timestamp+"token123"))
+	body := fmt.Sprintf(`{"signature":{"timestamp":"%s","token":"token123","signature":"%s"},"event-data":{"event":"failed","severity":"permanent","recipient":"bounced@example.com","delivery-status":{"message":"No such user"}}}`, timestamp, signature)
+	response := request(t, s, "POST", "/v1/email/webhook", body, nil)
+	require.Equal(t, 200, response.Code)
+	undeliverable, err := s.messageCache.EmailUndeliverable("bounced@example.com")
+	require.Nil(t, err)
+	require.True(t, undeliverable)
+
+	// Temporary failures are ignored
+	body = fmt.Sprintf(`{"signature":{"timestamp":"%s","token":"token123","signature":"%s"},"event-data":{"event":"failed","severity":"temporary","recipient":"full@example.com"}}`, timestamp, signature)
+	response = request(t, s, "POST", "/v1/email/webhook", body, nil)
+	require.Equal(t, 200, response.Code)
+	undeliverable, err = s.messageCache.EmailUndeliverable("full@example.com")
+	require.Nil(t, err)
+	require.False(t, undeliverable)
+
+	// Replayed request
+	oldTimestamp := fmt.Sprintf("%d", time.Now().Add(-time.Hour).Unix())
+	oldSignature := hex.EncodeToString(hmacSHA256([]byte("signing-key"), oldTimestamp+"token123"))
+	body = fmt.Sprintf(`{"signature":{"timestamp":"%s","token":"token123","signature":"%s"},"event-data":{"event":"complained","recipient":"spam@example.com"}}`, oldTimestamp, oldSignature)
+	response = request(t, s, "POST", "/v1/email/webhook", body, nil)
+	require.Equal(t, 401, response.Code)
+}
+
+func TestServer_EmailWebhook_SES_AndAdmin(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.SMTPSenderProvider = SMTPSenderProviderSES
+	c.SMTPSenderAPIKey = "AKID1234"
+	c.SMTPSenderAPISecret = "secret"
+	c.SMTPSenderAPIRegion = "us-east-2"
+	c.SMTPSenderFrom = "ntfy@ntfy.sh"
+	c.SMTPSenderWebhookKey = "webhook-secret"
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	admin := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+
+	message, err := json.Marshal(map[string]any{
+		"notificationType": "Bounce",
+		"bounce": map[string]any{
+			"bounceType": "Permanent",
+			"bouncedRecipients": []map[string]string{
+				{"emailAddress": "bounced@example.com", "diagnosticCode": "smtp; 550 5.1.1 user unknown"},
+			},
+		},
+	})
+	require.Nil(t, err)
+	body, err := json.Marshal(&snsNotification{Type: "Notification", Message: string(message)})
+	require.Nil(t, err)
+	response := request(t, s, "POST", "/v1/email/webhook?key=wrong", string(body), nil)
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "POST", "/v1/email/webhook?key=webhook-secret", string(body), nil)
+	require.Equal(t, 200, response.Code)
+
+	// Subscribe URL must point to SNS
+	response = request(t, s, "POST", "/v1/email/webhook?key=webhook-secret", `{"Type":"SubscriptionConfirmation","SubscribeURL":"https://evil.example.com/confirm"}`, nil)
+	require.Equal(t, 400, response.Code)
+
+	// Admin lists and removes undeliverable addresses
+	response = request(t, s, "GET", "/v1/email/undeliverable", "", admin)
+	require.Equal(t, 200, response.Code)
+	emails, err := util.UnmarshalJSON[[]*apiUndeliverableEmail](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*emails))
+	require.Equal(t, "bounced@example.com", (*emails)[0].Email)
+	require.Equal(t, "bounce: smtp; 550 5.1.1 user unknown", (*emails)[0].Reason)
+
+	response = request(t, s, "DELETE", "/v1/email/undeliverable", `{"email":"bounced@example.com"}`, admin)
+	require.Equal(t, 200, response.Code)
+	undeliverable, err := s.messageCache.EmailUndeliverable("bounced@example.com")
+	require.Nil(t, err)
+	require.False(t, undeliverable)
+
+	response = request(t, s, "GET", "/v1/email/undeliverable", "", nil)
+	require.Equal(t, 401, response.Code)
+}
+
+func TestServer_EmailWebhook_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "POST", "/v1/email/webhook", "[]", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func newTestAPISenderVisitor(t *testing.T, c *Config) *visitor {
+	return newVisitor(c, newMemTestCache(t), nil, netip.MustParseAddr("1.2.3.4"), nil)
+}
+
+func newTestAPISenderMessage() *message {
+	return &message{
+		ID:      "abc",
+		Time:    1640382204,
+		Event:   "message",
+		Topic:   "alerts",
+		Message: "A simple message",
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// emailBatcher coalesces e-mails to the same recipient, see smtp-sender-batch-window. The first e-mail to a recipient
+// is sent right away and opens a batch window. All e-mails to that recipient within the window are collected, and
+// sent as a single summary e-mail once the window closes. If the summary was sent, a new window is opened, so a
+// recipient receives at most one e-mail per window, no matter how many messages are published.
+type emailBatcher struct {
+	window  time.Duration
+	max     int                                        // Max. number of messages listed in a summary e-mail
+	send    func(v *visitor, b *emailBatch, to string) // Called when a window closes with pending messages
+	batches map[string]*emailBatch                     // Recipient -> batch
+	mu      sync.Mutex
+}
+
+// emailBatch holds the messages collected for one recipient within a batch window
+type emailBatch struct {
+	visitor  *visitor   // Visitor of the most recent message, used for logging and the e-mail footer
+	messages []*message // Up to emailBatcher.max messages
+	omitted  int        // Number of messages exceeding emailBatcher.max
+	timer    *time.Timer
+}
+
+func newEmailBatcher(window time.Duration, max int, send func(v *visitor, b *emailBatch, to string)) *emailBatcher {
+	return &emailBatcher{
+		window:  window,
+		max:     max,
+		send:    send,
+		batches: make(map[string]*emailBatch),
+	}
+}
+
+// Add returns true if the e-mail can be sent right away, because there is no open batch window for the recipient.
+// Otherwise, the message is added to the recipient's batch, and false is returned.
+func (b *emailBatcher) Add(v *visitor, m *message, to string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch, ok := b.batches[to]
+	if !ok {
+		b.batches[to] = b.newBatchNoLock(to)
+		return true
+	}
+	batch.visitor = v
+	if len(batch.messages) < b.max {
+		batch.messages = append(batch.messages, m)
+	} else {
+		batch.omitted++
+	}
+	return false
+}
+
+// Flush stops all batch windows, and returns the pending batches by recipient, e.g. to send them before shutting down
+func (b *emailBatcher) Flush() map[string]*emailBatch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pending := make(map[string]*emailBatch)
+	for to, batch := range b.batches {
+		batch.timer.Stop()
+		if len(batch.messages) > 0 {
+			pending[to] = batch
+		}
+	}
+	b.batches = make(map[string]*emailBatch)
+	return pending
+}
+
+func (b *emailBatcher) newBatchNoLock(to string) *emailBatch {
+	return &emailBatch{
+		messages: make([]*message, 0),
+		timer:    time.AfterFunc(b.window, func() { b.windowClosed(to) }),
+	}
+}
+
+// windowClosed sends the recipient's pending messages as a summary e-mail. If there were any, a new window is
+// opened, otherwise the recipient's next e-mail is sent right away again.
+func (b *emailBatcher) windowClosed(to string) {
+	b.mu.Lock()
+	batch, ok := b.batches[to]
+	if !ok {
+		b.mu.Unlock()
+		return // Flushed in the meantime
+	} else if len(batch.messages) == 0 {
+		delete(b.batches, to)
+		b.mu.Unlock()
+		return
+	}
+	b.batches[to] = b.newBatchNoLock(to)
+	b.mu.Unlock()
+	b.send(batch.visitor, batch, to)
+}
+
+// newEmailBatchMessage creates the summary message for a batch of e-mails. It lists all messages (time, topic,
+// title and body) in the order they were published, and is then formatted like any other e-mail, see formatMail.
+func newEmailBatchMessage(lang string, batch *emailBatch) *message {
+	topic := batch.messages[0].Topic
+	entries := make([]string, 0, len(batch.messages))
+	for _, m := range batch.messages {
+		if m.Topic != topic {
+			topic = "" // Messages from different topics, the footer links to the server instead
+		}
+		entry := fmt.Sprintf("%s, %s", time.Unix(m.Time, 0).UTC().Format("15:04:05 MST"), m.Topic)
+		if m.Title != "" {
+			entry += "\n" + m.Title
+		}
+		if m.Message != "" {
+			entry += "\n" + m.Message
+		}
+		entries = append(entries, entry)
+	}
+	if batch.omitted > 0 {
+		entries = append(entries, translate(lang, "email_batch_omitted", batch.omitted))
+	}
+	m := newDefaultMessage(topic, strings.Join(entries, "\n\n"))
+	m.Title = translate(lang, "email_batch_title", len(batch.messages)+batch.omitted)
+	return m
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEmailBatcher(t *testing.T) {
+	var mu sync.Mutex
+	sent := make([]*emailBatch, 0)
+	b := newEmailBatcher(200*time.Millisecond, 2, func(v *visitor, batch *emailBatch, to string) {
+		mu.Lock()
+		defer mu.Unlock()
+		require.Equal(t, "phil@example.com", to)
+		sent = append(sent, batch)
+	})
+	sentCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent)
+	}
+
+	// First e-mail is sent right away, others are batched
+	require.True(t, b.Add(nil, newDefaultMessage("mytopic", "1"), "phil@example.com"))
+	require.False(t, b.Add(nil, newDefaultMessage("mytopic", "2"), "phil@example.com"))
+	require.False(t, b.Add(nil, newDefaultMessage("mytopic", "3"), "phil@example.com"))
+	require.False(t, b.Add(nil, newDefaultMessage("mytopic", "4"), "phil@example.com"))
+	require.True(t, b.Add(nil, newDefaultMessage("mytopic", "other"), "ben@example.com"))
+
+	waitFor(t, func() bool {
+		return sentCount() == 1
+	})
+	require.Equal(t, 2, len(sent[0].messages))
+	require.Equal(t, "2", sent[0].messages[0].Message)
+	require.Equal(t, "3", sent[0].messages[1].Message)
+	require.Equal(t, 1, sent[0].omitted)
+
+	// Summary opened a new window, so the next e-mail is batched again
+	require.False(t, b.Add(nil, newDefaultMessage("mytopic", "5"), "phil@example.com"))
+	waitFor(t, func() bool {
+		return sentCount() == 2
+	})
+	require.Equal(t, "5", sent[1].messages[0].Message)
+
+	// Quiet window closes without a summary, and the next e-mail is sent right away
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, 2, sentCount())
+	require.True(t, b.Add(nil, newDefaultMessage("mytopic", "6"), "phil@example.com"))
+}
+
+func TestEmailBatcher_Flush(t *testing.T) {
+	b := newEmailBatcher(time.Hour, 10, func(v *visitor, batch *emailBatch, to string) {
+		t.Fatal("must not be called")
+	})
+	require.True(t, b.Add(nil, newDefaultMessage("mytopic", "1"), "phil@example.com"))
+	require.False(t, b.Add(nil, newDefaultMessage("mytopic", "2"), "phil@example.com"))
+	require.True(t, b.Add(nil, newDefaultMessage("mytopic", "3"), "ben@example.com"))
+	pending := b.Flush()
+	require.Equal(t, 1, len(pending))
+	require.Equal(t, "2", pending["phil@example.com"].messages[0].Message)
+	require.True(t, b.Add(nil, newDefaultMessage("mytopic", "4"), "phil@example.com"))
+}
+
+func TestNewEmailBatchMessage(t *testing.T) {
+	m1 := newDefaultMessage("alerts", "Disk full")
+	m1.Time = 1640382204
+	m1.Title = "server1"
+	m2 := newDefaultMessage("backups", "Backup done")
+	m2.Time = 1640382264
+	m := newEmailBatchMessage("en", &emailBatch{messages: []*message{m1, m2}, omitted: 3})
+	require.Equal(t, "", m.Topic)
+	require.Equal(t, "5 new notifications", m.Title)
+	require.Equal(t, "21:43:24 UTC, alerts\nserver1\nDisk full\n\n21:44:24 UTC, backups\nBackup done\n\n... and 3 more messages, not shown here", m.Message)
+
+	m = newEmailBatchMessage("de", &emailBatch{messages: []*message{m1}})
+	require.Equal(t, "alerts", m.Topic)
+	require.Equal(t, "1 neue Benachrichtigungen", m.Title)
+}
+
+func TestServer_PublishWithEmail_Batched(t *testing.T) {
+	c := newTestConfig(t)
+	c.SMTPSenderBatchWindow = 300 * time.Millisecond
+	c.SMTPSenderBatchMax = 10
+	s := newTestServer(t, c)
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+	s.emailBatcher = newEmailBatcher(c.SMTPSenderBatchWindow, c.SMTPSenderBatchMax, s.sendEmailBatch)
+
+	response := request(t, s, "PUT", "/mytopic", "first", map[string]string{"Email": "phil@example.com"})
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return mailer.Count() == 1
+	})
+	for i := 0; i < 5; i++ {
+		response = request(t, s, "PUT", "/mytopic", "flapping", map[string]string{"Email": "phil@example.com"})
+		require.Equal(t, 200, response.Code)
+	}
+	m := toMessage(t, response.Body.String())
+	waitFor(t, func() bool {
+		return mailer.Count() == 2
+	})
+	messages := mailer.Messages()
+	require.Equal(t, "first", messages[0].Message)
+	require.Equal(t, "5 new notifications", messages[1].Title)
+	require.Equal(t, "mytopic", messages[1].Topic)
+
+	// Each batched message has a delivery record
+	waitFor(t, func() bool {
+		deliveries, err := s.messageCache.Deliveries(m.ID)
+		return err == nil && len(deliveries) == 2 // Subscribers and email
+	})
+}
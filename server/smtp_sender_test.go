@@ -6,7 +6,7 @@ import (
 )
 
 func TestFormatMail_Basic(t *testing.T) {
-	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", &message{
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", "en", &message{
 		ID:      "abc",
 		Time:    1640382204,
 		Event:   "message",
@@ -26,7 +26,7 @@ This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://nt
 }
 
 func TestFormatMail_JustEmojis(t *testing.T) {
-	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", &message{
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", "en", &message{
 		ID:      "abc",
 		Time:    1640382204,
 		Event:   "message",
@@ -47,7 +47,7 @@ This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://nt
 }
 
 func TestFormatMail_JustOtherTags(t *testing.T) {
-	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", &message{
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", "en", &message{
 		ID:      "abc",
 		Time:    1640382204,
 		Event:   "message",
@@ -70,7 +70,7 @@ This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://nt
 }
 
 func TestFormatMail_JustPriority(t *testing.T) {
-	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", &message{
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", "en", &message{
 		ID:       "abc",
 		Time:     1640382204,
 		Event:    "message",
@@ -93,7 +93,7 @@ This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://nt
 }
 
 func TestFormatMail_UTF8Subject(t *testing.T) {
-	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", &message{
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", "en", &message{
 		ID:      "abc",
 		Time:    1640382204,
 		Event:   "message",
@@ -114,7 +114,7 @@ This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://nt
 }
 
 func TestFormatMail_WithAllTheThings(t *testing.T) {
-	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", &message{
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", "en", &message{
 		ID:       "abc",
 		Time:     1640382204,
 		Event:    "message",
@@ -139,3 +139,69 @@ Priority: max
 This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://ntfy.sh/alerts`
 	require.Equal(t, expected, actual)
 }
+
+func TestFormatMail_Markdown(t *testing.T) {
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", "en", &message{
+		ID:          "abc",
+		Time:        1640382204,
+		Event:       "message",
+		Topic:       "alerts",
+		Priority:    4,
+		Title:       "Deployment",
+		Message:     "Look ma, **bold text**, *italics*\n\n<script>alert('hi')</script>",
+		ContentType: "text/markdown",
+	})
+	expected := `From: "ntfy.sh/alerts" <ntfy@ntfy.sh>
+To: phil@example.com
+Subject: Deployment
+MIME-Version: 1.0
+Content-Type: multipart/alternative; boundary="ntfy-abc"
+
+--ntfy-abc
+Content-Type: text/plain; charset="utf-8"
+
+Look ma, **bold text**, *italics*
+
+<script>alert('hi')</script>
+
+Priority: high
+
+--
+This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://ntfy.sh/alerts
+--ntfy-abc
+Content-Type: text/html; charset="utf-8"
+
+<p>Look ma, <strong>bold text</strong>, <em>italics</em></p>
+
+<p></p>
+<p>Priority: high</p>
+<p>--<br>
+This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via <a href="https://ntfy.sh/alerts">https://ntfy.sh/alerts</a></p>
+--ntfy-abc--`
+	require.Equal(t, expected, actual)
+}
+
+func TestFormatMail_Translated(t *testing.T) {
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", "de", &message{
+		ID:       "abc",
+		Time:     1640382204,
+		Event:    "message",
+		Topic:    "alerts",
+		Priority: 5,
+		Tags:     []string{"tag123"},
+		Message:  "Eine Nachricht",
+	})
+	expected := `From: "ntfy.sh/alerts" <ntfy@ntfy.sh>
+To: phil@example.com
+Subject: Eine Nachricht
+Content-Type: text/plain; charset="utf-8"
+
+Eine Nachricht
+
+Tags: tag123
+Priorität: max
+
+--
+Diese Nachricht wurde von 1.2.3.4 am Fri, 24 Dec 2021 21:43:24 UTC über https://ntfy.sh/alerts gesendet`
+	require.Equal(t, expected, actual)
+}
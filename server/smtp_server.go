@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/emersion/go-smtp"
 	"github.com/microcosm-cc/bluemonday"
+	"heckel.io/ntfy/v2/util"
 	"io"
 	"mime"
 	"mime/multipart"
@@ -16,6 +17,7 @@ import (
 	"net/http/httptest"
 	"net/mail"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -35,13 +37,36 @@ var (
 )
 
 const (
-	maxMultipartDepth = 2
+	maxMultipartDepth   = 2
+	smtpMaxMessageBytes = 1024 * 1024 // Must be much larger than message size (headers, multipart, etc.)
 )
 
+// smtpServerMaxMessageBytes returns the max. size of an incoming mail. If attachments are enabled, it leaves room
+// for a base64-encoded attachment of up to attachment-file-size-limit (base64 adds a third to the size).
+func smtpServerMaxMessageBytes(conf *Config) int {
+	if conf.AttachmentCacheDir == "" {
+		return smtpMaxMessageBytes
+	}
+	return smtpMaxMessageBytes + int(conf.AttachmentFileSizeLimit*4/3)
+}
+
+// mailParts are the text parts (by content type) and the first attachment of an incoming mail
+type mailParts struct {
+	text       map[string]string
+	attachment *mailAttachment
+}
+
+// mailAttachment is a file attached to an incoming mail, see readMultipartMailBodyParts
+type mailAttachment struct {
+	name string
+	data []byte
+}
+
 // smtpBackend implements SMTP server methods.
 type smtpBackend struct {
 	config  *Config
 	handler func(http.ResponseWriter, *http.Request)
+	bounces func(bounces []*emailBounce) error // Called for bounces of outgoing e-mails, see smtpSession.handleBounce
 	success int64
 	failure int64
 	mu      sync.Mutex
@@ -50,10 +75,11 @@ type smtpBackend struct {
 var _ smtp.Backend = (*smtpBackend)(nil)
 var _ smtp.Session = (*smtpSession)(nil)
 
-func newMailBackend(conf *Config, handler func(http.ResponseWriter, *http.Request)) *smtpBackend {
+func newMailBackend(conf *Config, handler func(http.ResponseWriter, *http.Request), bounces func([]*emailBounce) error) *smtpBackend {
 	return &smtpBackend{
 		config:  conf,
 		handler: handler,
+		bounces: bounces,
 	}
 }
 
@@ -70,11 +96,14 @@ func (b *smtpBackend) Counts() (total int64, success int64, failure int64) {
 
 // smtpSession is returned after EHLO.
 type smtpSession struct {
-	backend *smtpBackend
-	conn    *smtp.Conn
-	topic   string
-	token   string
-	mu      sync.Mutex
+	backend  *smtpBackend
+	conn     *smtp.Conn
+	topic    string
+	token    string
+	priority int      // From address extension, e.g. ntfy-mytopic+urgent@ntfy.sh
+	tags     []string // From address extension, e.g. ntfy-mytopic+tags=backup@ntfy.sh
+	bounce   bool     // Mail is addressed to smtp-sender-from, i.e. it is likely a bounce of an outgoing e-mail
+	mu       sync.Mutex
 }
 
 func (s *smtpSession) AuthPlain(username, _ string) error {
@@ -90,7 +119,6 @@ func (s *smtpSession) Mail(from string, opts *smtp.MailOptions) error {
 func (s *smtpSession) Rcpt(to string) error {
 	logem(s.conn).Field("smtp_rcpt_to", to).Debug("RCPT TO: %s", to)
 	return s.withFailCount(func() error {
-		token := ""
 		conf := s.backend.config
 		addressList, err := mail.ParseAddressList(to)
 		if err != nil {
@@ -99,7 +127,12 @@ func (s *smtpSession) Rcpt(to string) error {
 			return errTooManyRecipients
 		}
 		to = addressList[0].Address
-		if !strings.HasSuffix(to, "@"+conf.SMTPServerDomain) {
+		if conf.SMTPSenderFrom != "" && strings.EqualFold(to, conf.SMTPSenderFrom) {
+			s.mu.Lock()
+			s.bounce = true
+			s.mu.Unlock()
+			return nil
+		} else if !strings.HasSuffix(to, "@"+conf.SMTPServerDomain) {
 			return errInvalidDomain
 		}
 		// Remove @ntfy.sh from end of email
@@ -111,11 +144,12 @@ func (s *smtpSession) Rcpt(to string) error {
 			// remove ntfy- from beginning of email
 			to = strings.TrimPrefix(to, conf.SMTPServerAddrPrefix)
 		}
-		// If email contains token, split topic and token
-		if strings.Contains(to, "+") {
-			parts := strings.Split(to, "+")
-			to = parts[0]
-			token = parts[1]
+		// If email contains address extensions, split topic and token, priority and tags
+		parts := strings.Split(to, "+")
+		to = parts[0]
+		token, priority, tags, err := parseMailAddressExtensions(parts[1:])
+		if err != nil {
+			return err
 		}
 		if !topicRegex.MatchString(to) {
 			return errInvalidTopic
@@ -123,6 +157,8 @@ func (s *smtpSession) Rcpt(to string) error {
 		s.mu.Lock()
 		s.topic = to
 		s.token = token
+		s.priority = priority
+		s.tags = tags
 		s.mu.Unlock()
 		return nil
 	})
@@ -145,29 +181,14 @@ func (s *smtpSession) Data(r io.Reader) error {
 		if err != nil {
 			return err
 		}
-		body, err := readMailBody(msg.Body, msg.Header)
-		if err != nil {
-			return err
-		}
-		body = strings.TrimSpace(body)
-		if len(body) > conf.MessageLimit {
-			body = body[:conf.MessageLimit]
-		}
-		m := newDefaultMessage(s.topic, body)
-		subject := strings.TrimSpace(msg.Header.Get("Subject"))
-		if subject != "" {
-			dec := mime.WordDecoder{}
-			subject, err := dec.DecodeHeader(subject)
-			if err != nil {
+		s.mu.Lock()
+		bounce := s.bounce
+		s.mu.Unlock()
+		if bounce {
+			if err := s.handleBounce(msg); err != nil {
 				return err
 			}
-			m.Title = subject
-		}
-		if m.Title != "" && m.Message == "" {
-			m.Message = m.Title // Flip them, this makes more sense
-			m.Title = ""
-		}
-		if err := s.publishMessage(m); err != nil {
+		} else if err := s.publishMail(conf, msg); err != nil {
 			return err
 		}
 		s.backend.mu.Lock()
@@ -178,7 +199,54 @@ func (s *smtpSession) Data(r io.Reader) error {
 	})
 }
 
-func (s *smtpSession) publishMessage(m *message) error {
+func (s *smtpSession) publishMail(conf *Config, msg *mail.Message) error {
+	var attachmentLimit int64
+	if conf.AttachmentCacheDir != "" {
+		attachmentLimit = conf.AttachmentFileSizeLimit
+	}
+	body, attachment, err := readMailBody(msg.Body, msg.Header, attachmentLimit)
+	if err != nil {
+		return err
+	}
+	body = strings.TrimSpace(body)
+	if len(body) > conf.MessageLimit {
+		body = body[:conf.MessageLimit]
+	}
+	m := newDefaultMessage(s.topic, body)
+	subject := strings.TrimSpace(msg.Header.Get("Subject"))
+	if subject != "" {
+		dec := mime.WordDecoder{}
+		subject, err := dec.DecodeHeader(subject)
+		if err != nil {
+			return err
+		}
+		m.Title = subject
+	}
+	if m.Title != "" && m.Message == "" && attachment == nil {
+		m.Message = m.Title // Flip them, this makes more sense
+		m.Title = ""
+	}
+	return s.publishMessage(m, attachment)
+}
+
+// handleBounce reads the delivery status notification (RFC 3464) of an outgoing e-mail, and marks the recipients
+// that permanently failed as undeliverable. Other mails to the sender address (e.g. auto-replies) are ignored.
+func (s *smtpSession) handleBounce(msg *mail.Message) error {
+	bounces, err := readDeliveryStatusBounces(msg, s.backend.config.SMTPSenderFrom)
+	if err != nil {
+		return err
+	} else if len(bounces) == 0 {
+		logem(s.conn).Debug("Ignoring mail to sender address, not a bounce of an outgoing e-mail")
+		return nil
+	} else if s.backend.bounces == nil {
+		return nil
+	}
+	return s.backend.bounces(bounces)
+}
+
+// publishMessage publishes the message via the HTTP handler. If the mail had an attachment, the attachment is
+// sent as request body, and the message text is passed in the Message header, as a regular publisher would.
+func (s *smtpSession) publishMessage(m *message, attachment *mailAttachment) error {
 	// Extract remote address (for rate limiting)
 	remoteAddr, _, err := net.SplitHostPort(s.conn.Conn().RemoteAddr().String())
 	if err != nil {
@@ -186,16 +254,32 @@ func (s *smtpSession) publishMessage(m *message) error {
 	}
 	// Call HTTP handler with fake HTTP request
 	url := fmt.Sprintf("%s/%s", s.backend.config.BaseURL, m.Topic)
-	req, err := http.NewRequest("POST", url, strings.NewReader(m.Message))
+	var body io.Reader = strings.NewReader(m.Message)
+	if attachment != nil {
+		body = bytes.NewReader(attachment.data)
+	}
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return err
+	}
 	req.RequestURI = "/" + m.Topic // just for the logs
 	req.RemoteAddr = remoteAddr    // rate limiting!!
 	req.Header.Set("X-Forwarded-For", remoteAddr)
-	if err != nil {
-		return err
+	if attachment != nil {
+		req.Header.Set("Filename", attachment.name)
+		if m.Message != "" {
+			req.Header.Set("Message", strings.ReplaceAll(m.Message, "\n", "\\n"))
+		}
 	}
 	if m.Title != "" {
 		req.Header.Set("Title", m.Title)
 	}
+	if s.priority > 0 {
+		req.Header.Set("Priority", strconv.Itoa(s.priority))
+	}
+	if len(s.tags) > 0 {
+		req.Header.Set("Tags", strings.Join(s.tags, ","))
+	}
 	if s.token != "" {
 		req.Header.Add("Authorization", "Bearer "+s.token)
 	}
@@ -210,9 +294,48 @@ func (s *smtpSession) publishMessage(m *message) error {
 func (s *smtpSession) Reset() {
 	s.mu.Lock()
 	s.topic = ""
+	s.token = ""
+	s.priority = 0
+	s.tags = nil
+	s.bounce = false
 	s.mu.Unlock()
 }
 
+// parseMailAddressExtensions parses the "+"-separated extensions of a recipient address, e.g. "urgent", "tags=backup"
+// and "tk_..." in ntfy-mytopic+urgent+tags=backup+tk_...@ntfy.sh. Since e-mail-only systems cannot set headers, this
+// allows setting the priority and tags of the published message. Plain words are priorities (e.g. "urgent" or "4"),
+// or the access token otherwise. Tags can be repeated (tags=a+tags=b), since commas are not allowed in addresses.
+func parseMailAddressExtensions(extensions []string) (token string, priority int, tags []string, err error) {
+	for _, extension := range extensions {
+		key, value, ok := strings.Cut(extension, "=")
+		if !ok {
+			if p, err := util.ParsePriority(extension); err == nil && p > 0 {
+				priority = p
+			} else if token == "" && extension != "" {
+				token = extension
+			} else {
+				return "", 0, nil, errInvalidAddress
+			}
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "priority", "prio", "p":
+			priority, err = util.ParsePriority(value)
+			if err != nil || priority == 0 {
+				return "", 0, nil, errInvalidAddress
+			}
+		case "tags", "tag", "ta":
+			if value == "" {
+				return "", 0, nil, errInvalidAddress
+			}
+			tags = append(tags, value)
+		default:
+			return "", 0, nil, errInvalidAddress
+		}
+	}
+	return token, priority, tags, nil
+}
+
 func (s *smtpSession) Logout() error {
 	return nil
 }
@@ -231,36 +354,119 @@ func (s *smtpSession) withFailCount(fn func() error) error {
 	return err
 }
 
-func readMailBody(body io.Reader, header mail.Header) (string, error) {
+// readDeliveryStatusBounces returns the permanently failed recipients of a delivery status notification (a
+// "multipart/report" mail with report type "delivery-status", see RFC 3464). To make it harder to mark arbitrary
+// addresses as undeliverable, the returned headers of the original e-mail must show that it was sent by ntfy.
+func readDeliveryStatusBounces(msg *mail.Message, from string) ([]*emailBounce, error) {
+	contentType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || contentType != "multipart/report" || params["report-type"] != "delivery-status" {
+		return nil, nil
+	}
+	var bounces []*emailBounce
+	var sentByUs bool
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		partContentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partContentType {
+		case "message/delivery-status", "message/global-delivery-status":
+			status, err := io.ReadAll(part) // Protected by MaxMessageBytes
+			if err != nil {
+				return nil, err
+			}
+			bounces = parseDeliveryStatus(string(status))
+		case "message/rfc822", "text/rfc822-headers", "message/global", "message/global-headers":
+			original, err := mail.ReadMessage(io.MultiReader(part, strings.NewReader("\r\n\r\n")))
+			if err != nil {
+				continue
+			}
+			originalFrom, err := mail.ParseAddress(original.Header.Get("From"))
+			sentByUs = err == nil && strings.EqualFold(originalFrom.Address, from)
+		}
+	}
+	if !sentByUs {
+		return nil, nil
+	}
+	return bounces, nil
+}
+
+// parseDeliveryStatus parses the per-recipient fields of a delivery status, and returns the recipients with the
+// action "failed" and a permanent (5.x.x) status. Delayed deliveries and temporary failures are ignored.
+func parseDeliveryStatus(status string) []*emailBounce {
+	bounces := make([]*emailBounce, 0)
+	status = strings.ReplaceAll(status, "\r\n", "\n")
+	for _, block := range strings.Split(status, "\n\n") {
+		fields := make(map[string]string)
+		var lastKey string
+		for _, line := range strings.Split(block, "\n") {
+			if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && lastKey != "" {
+				fields[lastKey] += " " + strings.TrimSpace(line) // Folded header line
+				continue
+			}
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			lastKey = strings.ToLower(strings.TrimSpace(key))
+			fields[lastKey] = strings.TrimSpace(value)
+		}
+		_, address, ok := strings.Cut(fields["final-recipient"], ";")
+		if !ok || !strings.EqualFold(fields["action"], "failed") || !strings.HasPrefix(fields["status"], "5") {
+			continue
+		}
+		reason := "bounce: " + fields["status"]
+		if fields["diagnostic-code"] != "" {
+			reason = "bounce: " + fields["diagnostic-code"]
+		}
+		bounces = append(bounces, &emailBounce{
+			Address: strings.Trim(strings.TrimSpace(address), "<>"),
+			Reason:  reason,
+		})
+	}
+	return bounces
+}
+
+// readMailBody returns the text of the mail, preferring plain text over HTML, and its first attachment (if any, and
+// if attachmentLimit > 0). Attachments larger than attachmentLimit are ignored.
+func readMailBody(body io.Reader, header mail.Header, attachmentLimit int64) (string, *mailAttachment, error) {
 	if header.Get("Content-Type") == "" {
-		return readPlainTextMailBody(body, header.Get("Content-Transfer-Encoding"))
+		text, err := readPlainTextMailBody(body, header.Get("Content-Transfer-Encoding"))
+		return text, nil, err
 	}
 	contentType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	canonicalContentType := strings.ToLower(contentType)
 	if canonicalContentType == "text/plain" || canonicalContentType == "text/html" {
-		return readTextMailBody(body, canonicalContentType, header.Get("Content-Transfer-Encoding"))
+		text, err := readTextMailBody(body, canonicalContentType, header.Get("Content-Transfer-Encoding"))
+		return text, nil, err
 	} else if strings.HasPrefix(canonicalContentType, "multipart/") {
-		return readMultipartMailBody(body, params)
+		return readMultipartMailBody(body, params, attachmentLimit)
 	}
-	return "", errUnsupportedContentType
+	return "", nil, errUnsupportedContentType
 }
 
-func readMultipartMailBody(body io.Reader, params map[string]string) (string, error) {
-	parts := make(map[string]string)
-	if err := readMultipartMailBodyParts(body, params, 0, parts); err != nil && err != io.EOF {
-		return "", err
-	} else if s, ok := parts["text/plain"]; ok {
-		return s, nil
-	} else if s, ok := parts["text/html"]; ok {
-		return s, nil
+func readMultipartMailBody(body io.Reader, params map[string]string, attachmentLimit int64) (string, *mailAttachment, error) {
+	parts := &mailParts{text: make(map[string]string)}
+	if err := readMultipartMailBodyParts(body, params, 0, attachmentLimit, parts); err != nil && err != io.EOF {
+		return "", nil, err
+	} else if s, ok := parts.text["text/plain"]; ok {
+		return s, parts.attachment, nil
+	} else if s, ok := parts.text["text/html"]; ok {
+		return s, parts.attachment, nil
+	} else if parts.attachment != nil {
+		return "", parts.attachment, nil
 	}
-	return "", io.EOF
+	return "", nil, io.EOF
 }
 
-func readMultipartMailBodyParts(body io.Reader, params map[string]string, depth int, parts map[string]string) error {
+func readMultipartMailBodyParts(body io.Reader, params map[string]string, depth int, attachmentLimit int64, parts *mailParts) error {
 	if depth >= maxMultipartDepth {
 		return errMultipartNestedTooDeep
 	}
@@ -275,21 +481,62 @@ func readMultipartMailBodyParts(body io.Reader, params map[string]string, depth
 			return err
 		}
 		canonicalPartContentType := strings.ToLower(partContentType)
-		if canonicalPartContentType == "text/plain" || canonicalPartContentType == "text/html" {
+		filename := mailPartFilename(part, partParams)
+		if (canonicalPartContentType == "text/plain" || canonicalPartContentType == "text/html") && !isMailPartAttachment(part) {
 			s, err := readTextMailBody(part, canonicalPartContentType, part.Header.Get("Content-Transfer-Encoding"))
 			if err != nil {
 				return err
 			}
-			parts[canonicalPartContentType] = s
+			parts.text[canonicalPartContentType] = s
 		} else if strings.HasPrefix(strings.ToLower(partContentType), "multipart/") {
-			if err := readMultipartMailBodyParts(part, partParams, depth+1, parts); err != nil {
+			if err := readMultipartMailBodyParts(part, partParams, depth+1, attachmentLimit, parts); err != nil {
 				return err
 			}
+		} else if filename != "" && attachmentLimit > 0 && parts.attachment == nil {
+			attachment, err := readMailAttachment(part, filename, attachmentLimit)
+			if err != nil {
+				return err
+			}
+			parts.attachment = attachment // May be nil if too large
 		}
 		// Continue with next part
 	}
 }
 
+// readMailAttachment reads and decodes the attachment, or returns nil if it is larger than the limit
+func readMailAttachment(part *multipart.Part, filename string, limit int64) (*mailAttachment, error) {
+	reader := decodeMailPart(part, part.Header.Get("Content-Transfer-Encoding"))
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(data)) > limit {
+		return nil, nil
+	}
+	return &mailAttachment{
+		name: filename,
+		data: data,
+	}, nil
+}
+
+// mailPartFilename returns the file name of the part from the Content-Disposition header, or from the
+// Content-Type "name" parameter (used by some older mail clients and IP cameras)
+func mailPartFilename(part *multipart.Part, contentTypeParams map[string]string) string {
+	if filename := part.FileName(); filename != "" {
+		return filename
+	}
+	dec := mime.WordDecoder{}
+	name, err := dec.DecodeHeader(contentTypeParams["name"])
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+func isMailPartAttachment(part *multipart.Part) bool {
+	disposition, _, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	return err == nil && strings.ToLower(disposition) == "attachment"
+}
+
 func readTextMailBody(reader io.Reader, contentType, transferEncoding string) (string, error) {
 	if contentType == "text/plain" {
 		return readPlainTextMailBody(reader, transferEncoding)
@@ -300,18 +547,22 @@ func readTextMailBody(reader io.Reader, contentType, transferEncoding string) (s
 }
 
 func readPlainTextMailBody(reader io.Reader, transferEncoding string) (string, error) {
-	if strings.ToLower(transferEncoding) == "base64" {
-		reader = base64.NewDecoder(base64.StdEncoding, reader)
-	} else if strings.ToLower(transferEncoding) == "quoted-printable" {
-		reader = quotedprintable.NewReader(reader)
-	}
-	body, err := io.ReadAll(reader)
+	body, err := io.ReadAll(decodeMailPart(reader, transferEncoding))
 	if err != nil {
 		return "", err
 	}
 	return string(body), nil
 }
 
+func decodeMailPart(reader io.Reader, transferEncoding string) io.Reader {
+	if strings.ToLower(transferEncoding) == "base64" {
+		return base64.NewDecoder(base64.StdEncoding, reader)
+	} else if strings.ToLower(transferEncoding) == "quoted-printable" {
+		return quotedprintable.NewReader(reader)
+	}
+	return reader
+}
+
 func readHTMLMailBody(reader io.Reader, transferEncoding string) (string, error) {
 	body, err := readPlainTextMailBody(reader, transferEncoding)
 	if err != nil {
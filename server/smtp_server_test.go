@@ -1365,6 +1365,127 @@ Congratulations! You have successfully set up the email notification on Synology
 	writeAndReadUntilLine(t, email, c, scanner, "250 2.0.0 OK: queued")
 }
 
+func TestSmtpBackend_Attachment(t *testing.T) {
+	email := `EHLO example.com
+MAIL FROM: camera@example.com
+RCPT TO: ntfy-camera@ntfy.sh
+DATA
+Subject: Motion detected
+From: Camera <camera@example.com>
+To: ntfy-camera@ntfy.sh
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="CAMBOUNDARY"
+
+--CAMBOUNDARY
+Content-Type: text/plain; charset="UTF-8"
+
+Motion detected on camera 1
+at the front door
+
+--CAMBOUNDARY
+Content-Type: image/jpeg; name="snapshot.jpg"
+Content-Disposition: attachment; filename="snapshot.jpg"
+Content-Transfer-Encoding: base64
+
+/9j/4AAQSkZJRgABAQ==
+
+--CAMBOUNDARY
+Content-Type: image/jpeg; name="second.jpg"
+Content-Transfer-Encoding: base64
+
+/9j/4AAQ
+
+--CAMBOUNDARY--
+.
+`
+	s, c, _, scanner := newTestSMTPServer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/camera", r.URL.Path)
+		require.Equal(t, "Motion detected", r.Header.Get("Title"))
+		require.Equal(t, "snapshot.jpg", r.Header.Get("Filename"))
+		require.Equal(t, `Motion detected on camera 1\nat the front door`, r.Header.Get("Message"))
+		require.Equal(t, "\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01", readAll(t, r.Body))
+	})
+	defer s.Close()
+	defer c.Close()
+	writeAndReadUntilLine(t, email, c, scanner, "250 2.0.0 OK: queued")
+}
+
+func TestSmtpBackend_Attachment_TextAttachmentNoBody(t *testing.T) {
+	email := `EHLO example.com
+MAIL FROM: reports@example.com
+RCPT TO: ntfy-reports@ntfy.sh
+DATA
+Subject: Daily report
+From: Reports <reports@example.com>
+To: ntfy-reports@ntfy.sh
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="REPORT"
+
+--REPORT
+Content-Type: text/csv; charset="UTF-8"
+Content-Disposition: attachment; filename="report.csv"
+
+host,status
+web1,ok
+
+--REPORT--
+.
+`
+	s, c, _, scanner := newTestSMTPServer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/reports", r.URL.Path)
+		require.Equal(t, "Daily report", r.Header.Get("Title")) // Not flipped, the attachment is the body
+		require.Equal(t, "report.csv", r.Header.Get("Filename"))
+		require.Equal(t, "", r.Header.Get("Message"))
+		require.Equal(t, "host,status\nweb1,ok\n", readAll(t, r.Body))
+	})
+	defer s.Close()
+	defer c.Close()
+	writeAndReadUntilLine(t, email, c, scanner, "250 2.0.0 OK: queued")
+}
+
+func TestSmtpBackend_Attachment_TooLargeOrDisabled(t *testing.T) {
+	email := `EHLO example.com
+MAIL FROM: camera@example.com
+RCPT TO: ntfy-camera@ntfy.sh
+DATA
+Subject: Motion detected
+From: Camera <camera@example.com>
+To: ntfy-camera@ntfy.sh
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="CAMBOUNDARY"
+
+--CAMBOUNDARY
+Content-Type: text/plain; charset="UTF-8"
+
+Motion detected
+
+--CAMBOUNDARY
+Content-Type: image/jpeg
+Content-Disposition: attachment; filename="snapshot.jpg"
+Content-Transfer-Encoding: base64
+
+/9j/4AAQSkZJRgABAQ==
+
+--CAMBOUNDARY--
+.
+`
+	for _, disabled := range []bool{true, false} {
+		s, c, conf, scanner := newTestSMTPServer(t, func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "", r.Header.Get("Filename"))
+			require.Equal(t, "Motion detected", r.Header.Get("Title"))
+			require.Equal(t, "Motion detected", readAll(t, r.Body))
+		})
+		if disabled {
+			conf.AttachmentCacheDir = ""
+		} else {
+			conf.AttachmentFileSizeLimit = 5
+		}
+		writeAndReadUntilLine(t, email, c, scanner, "250 2.0.0 OK: queued")
+		c.Close()
+		s.Close()
+	}
+}
+
 func TestSmtpBackend_PlaintextWithToken(t *testing.T) {
 	email := `EHLO example.com
 MAIL FROM: phil@example.com
@@ -1388,12 +1509,78 @@ what's up
 
 type smtpHandlerFunc func(http.ResponseWriter, *http.Request)
 
+func TestSmtpBackend_AddressExtensions(t *testing.T) {
+	email := `EHLO example.com
+MAIL FROM: backup@example.com
+RCPT TO: ntfy-mytopic+urgent+tags=backup+tags=warning+tk_KLORUqSqvNRLpY11DfkHVbHu9NGG2@ntfy.sh
+DATA
+Subject: Backup failed
+
+Backup of /home failed
+.
+`
+	s, c, _, scanner := newTestSMTPServer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/mytopic", r.URL.Path)
+		require.Equal(t, "Backup failed", r.Header.Get("Title"))
+		require.Equal(t, "5", r.Header.Get("Priority"))
+		require.Equal(t, "backup,warning", r.Header.Get("Tags"))
+		require.Equal(t, "Bearer tk_KLORUqSqvNRLpY11DfkHVbHu9NGG2", r.Header.Get("Authorization"))
+		require.Equal(t, "Backup of /home failed", readAll(t, r.Body))
+	})
+	defer s.Close()
+	defer c.Close()
+	writeAndReadUntilLine(t, email, c, scanner, "250 2.0.0 OK: queued")
+}
+
+func TestSmtpBackend_AddressExtensions_Invalid(t *testing.T) {
+	email := `EHLO example.com
+MAIL FROM: backup@example.com
+RCPT TO: ntfy-mytopic+priority=nope@ntfy.sh
+DATA
+Subject: Backup failed
+
+Backup of /home failed
+.
+`
+	s, c, _, scanner := newTestSMTPServer(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("This should not be called")
+	})
+	defer s.Close()
+	defer c.Close()
+	writeAndReadUntilLine(t, email, c, scanner, "451 4.0.0 invalid address")
+}
+
+func TestParseMailAddressExtensions(t *testing.T) {
+	token, priority, tags, err := parseMailAddressExtensions([]string{"tk_abc"})
+	require.Nil(t, err)
+	require.Equal(t, "tk_abc", token)
+	require.Equal(t, 0, priority)
+	require.Nil(t, tags)
+
+	token, priority, tags, err = parseMailAddressExtensions([]string{"Tags=backup", "high", "tag=disk"})
+	require.Nil(t, err)
+	require.Equal(t, "", token)
+	require.Equal(t, 4, priority)
+	require.Equal(t, []string{"backup", "disk"}, tags)
+
+	_, priority, _, err = parseMailAddressExtensions([]string{"p=2"})
+	require.Nil(t, err)
+	require.Equal(t, 2, priority)
+
+	_, _, _, err = parseMailAddressExtensions([]string{"tk_abc", "tk_def"})
+	require.Equal(t, errInvalidAddress, err)
+	_, _, _, err = parseMailAddressExtensions([]string{"color=red"})
+	require.Equal(t, errInvalidAddress, err)
+	_, _, _, err = parseMailAddressExtensions([]string{"tags="})
+	require.Equal(t, errInvalidAddress, err)
+}
+
 func newTestSMTPServer(t *testing.T, handler smtpHandlerFunc) (s *smtp.Server, c net.Conn, conf *Config, scanner *bufio.Scanner) {
 	conf = newTestConfig(t)
 	conf.SMTPServerListen = ":25"
 	conf.SMTPServerDomain = "ntfy.sh"
 	conf.SMTPServerAddrPrefix = "ntfy-"
-	backend := newMailBackend(conf, handler)
+	backend := newMailBackend(conf, handler, nil)
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatal(err)
@@ -1439,3 +1626,99 @@ func readUntilLine(t *testing.T, conn net.Conn, scanner *bufio.Scanner, expected
 	}
 	t.Fatalf("Expected line '%s' not found in output:\n%s", expectedLine, output)
 }
+
+func TestSmtpBackend_Bounce(t *testing.T) {
+	email := `EHLO example.com
+MAIL FROM: <>
+RCPT TO: ntfy@ntfy.sh
+DATA
+From: Mail Delivery System <MAILER-DAEMON@mx.example.com>
+To: ntfy@ntfy.sh
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="B0UNC3"
+
+--B0UNC3
+Content-Type: text/plain
+
+I'm sorry to have to inform you that your message could not be delivered.
+
+--B0UNC3
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.com
+
+Final-Recipient: rfc822; nobody@example.com
+Original-Recipient: rfc822;nobody@example.com
+Action: failed
+Status: 5.1.1
+Diagnostic-Code: smtp; 550 5.1.1 <nobody@example.com>: Recipient address
+    rejected: User unknown
+
+Final-Recipient: rfc822; slow@example.com
+Action: delayed
+Status: 4.4.1
+
+--B0UNC3
+Content-Type: text/rfc822-headers
+
+From: "ntfy.sh/alerts" <ntfy@ntfy.sh>
+To: nobody@example.com
+Subject: Disk full
+
+--B0UNC3--
+.
+`
+	s, c, conf, scanner := newTestSMTPServer(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("bounce must not be published")
+	})
+	defer s.Close()
+	defer c.Close()
+	conf.SMTPSenderFrom = "ntfy@ntfy.sh"
+	var bounces []*emailBounce
+	s.Backend.(*smtpBackend).bounces = func(b []*emailBounce) error {
+		bounces = b
+		return nil
+	}
+	writeAndReadUntilLine(t, email, c, scanner, "250 2.0.0 OK: queued")
+	require.Equal(t, 1, len(bounces))
+	require.Equal(t, "nobody@example.com", bounces[0].Address)
+	require.Equal(t, "bounce: smtp; 550 5.1.1 <nobody@example.com>: Recipient address rejected: User unknown", bounces[0].Reason)
+}
+
+func TestSmtpBackend_Bounce_NotSentByUs(t *testing.T) {
+	email := `EHLO example.com
+MAIL FROM: <>
+RCPT TO: ntfy@ntfy.sh
+DATA
+Subject: Undelivered Mail Returned to Sender
+Content-Type: multipart/report; report-type=delivery-status; boundary="B0UNC3"
+
+--B0UNC3
+Content-Type: message/delivery-status
+
+Final-Recipient: rfc822; victim@example.com
+Action: failed
+Status: 5.1.1
+
+--B0UNC3
+Content-Type: text/rfc822-headers
+
+From: someone@example.com
+To: victim@example.com
+
+--B0UNC3--
+.
+`
+	s, c, conf, scanner := newTestSMTPServer(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("bounce must not be published")
+	})
+	defer s.Close()
+	defer c.Close()
+	conf.SMTPSenderFrom = "ntfy@ntfy.sh"
+	s.Backend.(*smtpBackend).bounces = func(b []*emailBounce) error {
+		t.Fatal("forged bounce must be ignored")
+		return nil
+	}
+	writeAndReadUntilLine(t, email, c, scanner, "250 2.0.0 OK: queued")
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,6 +15,24 @@ const (
 	// This must be larger than matrixRejectPushKeyForUnifiedPushTopicWithoutRateVisitorAfter to give
 	// time for more requests to come in, so that we can send a {"rejected":["<pushkey>"]} response back.
 	topicExpungeAfter = 16 * time.Hour
+
+	// topicRateBuckets is the number of one-minute buckets used to calculate the message rate of a topic (see Stats)
+	topicRateBuckets = 60
+
+	// topicPollersWindow defines how long a polling client is counted as a subscriber of a topic. Clients that
+	// receive messages via Firebase (Android) or APNs (iOS) are not connected to the server, but they poll regularly.
+	topicPollersWindow = 24 * time.Hour
+
+	// topicPollersMax limits the number of polling clients that are remembered per topic
+	topicPollersMax = 1000
+)
+
+// Transports of topic subscribers, see topicStats
+const (
+	subscriberTransportJSON = "json"
+	subscriberTransportSSE  = "sse"
+	subscriberTransportRaw  = "raw"
+	subscriberTransportWS   = "ws"
 )
 
 // topic represents a channel to which subscribers can subscribe, and publishers
@@ -23,15 +42,36 @@ type topic struct {
 	subscribers map[int]*topicSubscriber
 	rateVisitor *visitor
 	lastAccess  time.Time
+	lastMessage time.Time
+	messages    [topicRateBuckets]topicRateBucket
+	pollers     map[string]time.Time // Visitor ID -> last poll
 	mu          sync.RWMutex
 }
 
 type topicSubscriber struct {
 	userID     string // User ID associated with this subscription, may be empty
+	transport  string // See subscriberTransportJSON, ...
+	presence   string // Label the subscriber announced itself with, may be empty, see Announce
+	since      time.Time
 	subscriber subscriber
 	cancel     func()
 }
 
+// topicRateBucket counts the messages published to a topic in one minute
+type topicRateBucket struct {
+	minute int64 // Unix time in minutes
+	count  int64
+}
+
+// topicStats is a snapshot of the subscribers and activity of a topic, see Stats
+type topicStats struct {
+	Subscribers map[string]int // Transport -> number of subscribers
+	Pollers     int            // Number of distinct clients that polled in the last topicPollersWindow
+	Messages    int64          // Number of messages published in the last topicRateBuckets minutes
+	LastMessage time.Time
+	LastAccess  time.Time
+}
+
 // subscriber is a function that is called for every new message on a topic
 type subscriber func(v *visitor, msg *message) error
 
@@ -40,12 +80,13 @@ func newTopic(id string) *topic {
 	return &topic{
 		ID:          id,
 		subscribers: make(map[int]*topicSubscriber),
+		pollers:     make(map[string]time.Time),
 		lastAccess:  time.Now(),
 	}
 }
 
 // Subscribe subscribes to this topic
-func (t *topic) Subscribe(s subscriber, userID string, cancel func()) (subscriberID int) {
+func (t *topic) Subscribe(s subscriber, userID, transport string, cancel func()) (subscriberID int) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	for i := 0; i < 5; i++ { // Best effort retry
@@ -57,6 +98,8 @@ func (t *topic) Subscribe(s subscriber, userID string, cancel func()) (subscribe
 	}
 	t.subscribers[subscriberID] = &topicSubscriber{
 		userID:     userID, // May be empty
+		transport:  transport,
+		since:      time.Now(),
 		subscriber: s,
 		cancel:     cancel,
 	}
@@ -64,6 +107,38 @@ func (t *topic) Subscribe(s subscriber, userID string, cancel func()) (subscribe
 	return subscriberID
 }
 
+// Announce sets the presence label of the given subscriber, so that it is listed in Presence
+func (t *topic) Announce(subscriberID int, label string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.subscribers[subscriberID]; ok {
+		s.presence = label
+	}
+}
+
+// Presence returns all connected subscribers that announced themselves (see Announce), ordered by connection time
+func (t *topic) Presence() []*topicSubscriber {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	subscribers := make([]*topicSubscriber, 0)
+	for _, s := range t.subscribers {
+		if s.presence != "" {
+			subscribers = append(subscribers, &topicSubscriber{
+				presence:  s.presence,
+				transport: s.transport,
+				since:     s.since,
+			})
+		}
+	}
+	sort.Slice(subscribers, func(i, j int) bool {
+		if subscribers[i].since.Equal(subscribers[j].since) {
+			return subscribers[i].presence < subscribers[j].presence
+		}
+		return subscribers[i].since.Before(subscribers[j].since)
+	})
+	return subscribers
+}
+
 func (t *topic) Stale() bool {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -104,6 +179,9 @@ func (t *topic) Unsubscribe(id int) {
 
 // Publish asynchronously publishes to all subscribers
 func (t *topic) Publish(v *visitor, m *message) error {
+	if m.Event == messageEvent {
+		t.countMessage()
+	}
 	go func() {
 		// We want to lock the topic as short as possible, so we make a shallow copy of the
 		// subscribers map here. Actually sending out the messages then doesn't have to lock.
@@ -134,6 +212,39 @@ func (t *topic) Stats() (int, time.Time) {
 	return len(t.subscribers), t.lastAccess
 }
 
+// DetailedStats returns the number of subscribers per transport, the number of polling clients, the number of
+// messages published in the last hour, as well as the time of the last message and last access to this topic
+func (t *topic) DetailedStats() *topicStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	stats := &topicStats{
+		Subscribers: map[string]int{
+			subscriberTransportJSON: 0,
+			subscriberTransportSSE:  0,
+			subscriberTransportRaw:  0,
+			subscriberTransportWS:   0,
+		},
+		LastMessage: t.lastMessage,
+		LastAccess:  t.lastAccess,
+	}
+	for _, s := range t.subscribers {
+		stats.Subscribers[s.transport]++
+	}
+	pollersSince := time.Now().Add(-topicPollersWindow)
+	for _, lastPoll := range t.pollers {
+		if lastPoll.After(pollersSince) {
+			stats.Pollers++
+		}
+	}
+	minute := time.Now().Unix() / 60
+	for _, bucket := range t.messages {
+		if minute-bucket.minute < topicRateBuckets {
+			stats.Messages += bucket.count
+		}
+	}
+	return stats
+}
+
 // Keepalive sets the last access time and ensures that Stale does not return true
 func (t *topic) Keepalive() {
 	t.mu.Lock()
@@ -141,6 +252,40 @@ func (t *topic) Keepalive() {
 	t.lastAccess = time.Now()
 }
 
+// Polled remembers that the visitor with the given ID polled this topic, and sets the last access time (see Keepalive).
+// Polling clients are counted as subscribers in DetailedStats for topicPollersWindow.
+func (t *topic) Polled(visitorID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.lastAccess = now
+	if _, exists := t.pollers[visitorID]; !exists && len(t.pollers) >= topicPollersMax {
+		pollersSince := now.Add(-topicPollersWindow)
+		for id, lastPoll := range t.pollers {
+			if lastPoll.Before(pollersSince) {
+				delete(t.pollers, id)
+			}
+		}
+		if len(t.pollers) >= topicPollersMax {
+			return
+		}
+	}
+	t.pollers[visitorID] = now
+}
+
+func (t *topic) countMessage() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	minute := now.Unix() / 60
+	bucket := &t.messages[minute%topicRateBuckets]
+	if bucket.minute != minute {
+		bucket.minute, bucket.count = minute, 0
+	}
+	bucket.count++
+	t.lastMessage = now
+}
+
 // CancelSubscribersExceptUser calls the cancel function for all subscribers, forcing
 func (t *topic) CancelSubscribersExceptUser(exceptUserID string) {
 	t.mu.Lock()
@@ -199,6 +344,9 @@ func (t *topic) subscribersCopy() map[int]*topicSubscriber {
 	for k, sub := range t.subscribers {
 		subscribers[k] = &topicSubscriber{
 			userID:     sub.userID,
+			transport:  sub.transport,
+			presence:   sub.presence,
+			since:      sub.since,
 			subscriber: sub.subscriber,
 			cancel:     sub.cancel,
 		}
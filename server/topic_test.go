@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"math/rand"
 	"sync/atomic"
 	"testing"
@@ -24,8 +25,8 @@ func TestTopic_CancelSubscribersExceptUser(t *testing.T) {
 		canceled2.Store(true)
 	}
 	to := newTopic("mytopic")
-	to.Subscribe(subFn, "", cancelFn1)
-	to.Subscribe(subFn, "u_phil", cancelFn2)
+	to.Subscribe(subFn, "", subscriberTransportJSON, cancelFn1)
+	to.Subscribe(subFn, "u_phil", subscriberTransportJSON, cancelFn2)
 
 	to.CancelSubscribersExceptUser("u_phil")
 	require.True(t, canceled1.Load())
@@ -47,8 +48,8 @@ func TestTopic_CancelSubscribersUser(t *testing.T) {
 		canceled2.Store(true)
 	}
 	to := newTopic("mytopic")
-	to.Subscribe(subFn, "u_another", cancelFn1)
-	to.Subscribe(subFn, "u_phil", cancelFn2)
+	to.Subscribe(subFn, "u_another", subscriberTransportJSON, cancelFn1)
+	to.Subscribe(subFn, "u_phil", subscriberTransportJSON, cancelFn2)
 
 	to.CancelSubscriberUser("u_phil")
 	require.False(t, canceled1.Load())
@@ -84,9 +85,45 @@ func TestTopic_Subscribe_DuplicateID(t *testing.T) {
 
 	//lint:ignore SA1019 Force rand.Int to generate the same id once more
 	rand.Seed(1)
-	id := to.Subscribe(subFn, "b", func() {})
+	id := to.Subscribe(subFn, "b", subscriberTransportJSON, func() {})
 	res := to.subscribers[id]
 
 	require.NotEqual(t, id, a)
 	require.Equal(t, "b", res.userID, "b")
 }
+
+func TestTopic_DetailedStats(t *testing.T) {
+	t.Parallel()
+	to := newTopic("mytopic")
+	subFn := func(v *visitor, msg *message) error {
+		return nil
+	}
+	to.Subscribe(subFn, "", subscriberTransportJSON, func() {})
+	to.Subscribe(subFn, "", subscriberTransportWS, func() {})
+	to.Subscribe(subFn, "", subscriberTransportWS, func() {})
+	to.Polled("ip:1.2.3.4")
+	to.Polled("ip:1.2.3.4")
+	to.Polled("user:u_phil")
+	to.pollers["ip:5.6.7.8"] = time.Now().Add(-25 * time.Hour) // Outside of the window
+	to.countMessage()
+	to.countMessage()
+
+	stats := to.DetailedStats()
+	require.Equal(t, 1, stats.Subscribers[subscriberTransportJSON])
+	require.Equal(t, 0, stats.Subscribers[subscriberTransportSSE])
+	require.Equal(t, 2, stats.Subscribers[subscriberTransportWS])
+	require.Equal(t, 2, stats.Pollers)
+	require.Equal(t, int64(2), stats.Messages)
+	require.False(t, stats.LastMessage.IsZero())
+}
+
+func TestTopic_Polled_Max(t *testing.T) {
+	t.Parallel()
+	to := newTopic("mytopic")
+	for i := 0; i < topicPollersMax; i++ {
+		to.pollers[fmt.Sprintf("ip:%d", i)] = time.Now().Add(-25 * time.Hour)
+	}
+	to.Polled("user:u_phil")
+	require.Equal(t, 1, len(to.pollers))
+	require.Equal(t, 1, to.DetailedStats().Pollers)
+}
@@ -1,8 +1,11 @@
 package server
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/netip"
+	"strconv"
+	"strings"
 	"time"
 
 	"heckel.io/ntfy/v2/log"
@@ -17,6 +20,9 @@ const (
 	keepaliveEvent   = "keepalive"
 	messageEvent     = "message"
 	pollRequestEvent = "poll_request"
+	reconnectEvent   = "reconnect"
+	reactionsEvent   = "reactions"
+	annotationsEvent = "annotations"
 )
 
 const (
@@ -25,24 +31,63 @@ const (
 
 // message represents a message published to a topic
 type message struct {
-	ID          string      `json:"id"`                // Random message ID
-	Time        int64       `json:"time"`              // Unix time in seconds
-	Expires     int64       `json:"expires,omitempty"` // Unix time in seconds (not required for open/keepalive)
-	Event       string      `json:"event"`             // One of the above
-	Topic       string      `json:"topic"`
-	Title       string      `json:"title,omitempty"`
-	Message     string      `json:"message,omitempty"`
-	Priority    int         `json:"priority,omitempty"`
-	Tags        []string    `json:"tags,omitempty"`
-	Click       string      `json:"click,omitempty"`
-	Icon        string      `json:"icon,omitempty"`
-	Actions     []*action   `json:"actions,omitempty"`
-	Attachment  *attachment `json:"attachment,omitempty"`
-	PollID      string      `json:"poll_id,omitempty"`
-	ContentType string      `json:"content_type,omitempty"` // text/plain by default (if empty), or text/markdown
-	Encoding    string      `json:"encoding,omitempty"`     // empty for raw UTF-8, or "base64" for encoded bytes
-	Sender      netip.Addr  `json:"-"`                      // IP address of uploader, used for rate limiting
-	User        string      `json:"-"`                      // UserID of the uploader, used to associated attachments
+	ID            string       `json:"id"`                // Random message ID
+	Time          int64        `json:"time"`              // Unix time in seconds
+	Expires       int64        `json:"expires,omitempty"` // Unix time in seconds (not required for open/keepalive)
+	Event         string       `json:"event"`             // One of the above
+	Topic         string       `json:"topic"`
+	Title         string       `json:"title,omitempty"`
+	Message       string       `json:"message,omitempty"`
+	Priority      int          `json:"priority,omitempty"`
+	Tags          []string     `json:"tags,omitempty"`
+	Click         string       `json:"click,omitempty"`
+	Icon          string       `json:"icon,omitempty"`
+	Sound         string       `json:"sound,omitempty"` // Name of the notification sound, e.g. "chime"
+	Actions       []*action    `json:"actions,omitempty"`
+	Attachment    *attachment  `json:"attachment,omitempty"`
+	PollID        string       `json:"poll_id,omitempty"`
+	ContentType   string       `json:"content_type,omitempty"`   // text/plain by default (if empty), or text/markdown
+	Encoding      string       `json:"encoding,omitempty"`       // empty for raw UTF-8, or "base64" for encoded bytes
+	InReplyTo     string       `json:"in_reply_to,omitempty"`    // ID of the message this message is a reply to, see handleMessageThread
+	Location      *location    `json:"location,omitempty"`       // Geo location the message refers to, e.g. of a tracked vehicle
+	Hostname      string       `json:"hostname,omitempty"`       // Host the message originates from, e.g. for CMDB integrations
+	CorrelationID string       `json:"correlation_id,omitempty"` // ID that relates the message to others, e.g. an incident or request ID
+	Reactions     *reactions   `json:"reactions,omitempty"`      // Only set in "reactions" events
+	Annotations   *annotations `json:"annotations,omitempty"`    // Only set in "annotations" events
+	Sender        netip.Addr   `json:"-"`                        // IP address of uploader, used for rate limiting
+	User          string       `json:"-"`                        // UserID of the uploader, used to associated attachments
+}
+
+// location is the geo location of a message, see parseLocation
+type location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// String returns the location in the "latitude,longitude" format, as used in the X-Location header
+func (l *location) String() string {
+	return strconv.FormatFloat(l.Latitude, 'f', -1, 64) + "," + strconv.FormatFloat(l.Longitude, 'f', -1, 64)
+}
+
+// reactions are the aggregated reactions to a message, as sent in "reactions" events
+type reactions struct {
+	MessageID string         `json:"message_id"`
+	Counts    map[string]int `json:"counts"` // Emoji -> number of reactions
+}
+
+// annotation is a short note that a subscriber attached to a message, e.g. "false positive" or a ticket link
+type annotation struct {
+	ID        string `json:"id"`
+	Time      int64  `json:"time"`
+	Text      string `json:"text"`
+	Author    string `json:"author,omitempty"` // Username of the author, empty for anonymous users
+	Annotator string `json:"-"`                // Identity of the author (user ID or IP address), see visitor.Identity
+}
+
+// annotations are all annotations of a message, as sent in "annotations" events
+type annotations struct {
+	MessageID string        `json:"message_id"`
+	Items     []*annotation `json:"items"`
 }
 
 func (m *message) Context() log.Context {
@@ -62,12 +107,156 @@ func (m *message) Context() log.Context {
 	return fields
 }
 
+// report is an abuse report for a topic, or a specific message in a topic. If the report refers to a message,
+// a snapshot of the message (and its publisher) is stored, so that it can be reviewed after the message expired.
+type report struct {
+	ID           string
+	Time         int64
+	Topic        string
+	MessageID    string
+	Title        string
+	Message      string
+	Sender       netip.Addr // IP address of the publisher, if known
+	User         string     // User ID of the publisher, if any
+	Reason       string
+	ReporterIP   netip.Addr
+	ReporterUser string   // User ID of the reporter, if any
+	Status       string   // One of the reportStatus* constants
+	Actions      []string // Actions taken, see reportAction* constants
+}
+
+// blockedTopic is a topic that an admin has blocked, e.g. as a result of an abuse report
+type blockedTopic struct {
+	Topic  string
+	Time   int64
+	Reason string
+}
+
+// bannedIP is an IP address that an admin has banned, e.g. as a result of an abuse report
+type bannedIP struct {
+	IP      netip.Addr
+	Time    int64
+	Expires int64 // Unix time in seconds, or 0 if the ban does not expire
+	Reason  string
+}
+
+// rateLimitExemptionKind defines whether a rate limit exemption applies to a host or to a user
+type rateLimitExemptionKind string
+
+const (
+	rateLimitExemptionKindHost = rateLimitExemptionKind("host")
+	rateLimitExemptionKindUser = rateLimitExemptionKind("user")
+)
+
+type rateLimitExemption struct {
+	Kind   rateLimitExemptionKind
+	Target string // IP address, prefix or hostname (host), or user ID (user)
+	Time   int64
+	Reason string
+}
+
+// undeliverableEmail is an e-mail address that bounced or reported an e-mail as spam, see handleEmailWebhook
+type undeliverableEmail struct {
+	Address string
+	Time    int64
+	Reason  string
+}
+
+// statsHistoryEntry holds the usage counters of one hour, see statsHistory
+type statsHistoryEntry struct {
+	Time        int64 // Start of the hour, Unix time in seconds
+	Messages    int64
+	Bytes       int64
+	Subscribers int64 // Maximum number of subscribers seen in this hour
+	Failed      int64 // Failed deliveries (Firebase, web push, e-mail, phone calls)
+}
+
+// delivery is a single delivery attempt of a message via one channel, see Server.recordDelivery
+type delivery struct {
+	MessageID string
+	Time      int64
+	Channel   string // One of the deliveryChannel* constants
+	Outcome   string // deliveryOutcomeDelivered or deliveryOutcomeFailed
+	Count     int    // Number of recipients, e.g. subscribers or web push endpoints
+	Error     string // Error message, if the delivery failed
+}
+
+// escalation is the next pending escalation step of a message, see Server.escalateMessages
+type escalation struct {
+	MessageID string
+	Topic     string
+	Step      int   // Index of the next step in the escalation policy of the topic's reservation
+	Due       int64 // Unix time in seconds
+}
+
+// maintenanceWindow is a time window during which messages published to a topic are suppressed, downgraded or tagged.
+// One-off windows end at End; recurring windows repeat daily or weekly, with the same duration as the first window.
+type maintenanceWindow struct {
+	ID         string
+	Topic      string
+	Start      int64  // Unix time in seconds
+	End        int64  // Unix time in seconds, end of the first window if recurring
+	Recurrence string // "", "daily" or "weekly"
+	Action     string // "suppress", "downgrade" or "tag"
+	Reason     string
+	User       string // User ID of the creator, may be empty
+	Created    int64
+}
+
+// Active returns true if the maintenance window covers the given time
+func (w *maintenanceWindow) Active(t time.Time) bool {
+	now := t.Unix()
+	if now < w.Start {
+		return false
+	}
+	var period int64
+	switch w.Recurrence {
+	case maintenanceRecurrenceDaily:
+		period = 24 * 60 * 60
+	case maintenanceRecurrenceWeekly:
+		period = 7 * 24 * 60 * 60
+	default:
+		return now < w.End
+	}
+	return (now-w.Start)%period < w.End-w.Start
+}
+
+// heartbeat defines that a topic must receive a ping (any message, or a call to the ping endpoint) at least once per
+// interval. If no ping arrives in time, the server publishes an alert to the alert topic.
+type heartbeat struct {
+	Topic          string
+	Interval       time.Duration
+	AlertTopic     string
+	Message        string // Alert message, may be empty
+	Priority       int    // Priority of the alert message
+	User           string // User ID of the creator, may be empty
+	Created        int64
+	LastPing       int64  // Unix time in seconds, or 0 if never pinged
+	Due            int64  // Unix time in seconds, at which the next ping is expected
+	AlertMessageID string // ID of the alert message, if the heartbeat was missed and has not recovered yet
+}
+
 type attachment struct {
 	Name    string `json:"name"`
 	Type    string `json:"type,omitempty"`
 	Size    int64  `json:"size,omitempty"`
 	Expires int64  `json:"expires,omitempty"`
 	URL     string `json:"url"`
+	Hash    string `json:"-"` // SHA-256 of the content, used to deduplicate attachments, see fileCache.Link
+}
+
+// upload is an attachment that was uploaded separately via PUT /v1/attachments, and that can be referenced
+// by one or more messages via the "Attachment-ID" header, see handleAttachmentUpload
+type upload struct {
+	ID      string
+	User    string     // User ID of the uploader, empty for anonymous users
+	Sender  netip.Addr // IP address of the uploader
+	Name    string
+	Type    string
+	Ext     string // File extension, used for the attachment URL of messages referencing the upload
+	Size    int64
+	Hash    string
+	Expires int64
 }
 
 type action struct {
@@ -92,20 +281,26 @@ func newAction() *action {
 
 // publishMessage is used as input when publishing as JSON
 type publishMessage struct {
-	Topic    string   `json:"topic"`
-	Title    string   `json:"title"`
-	Message  string   `json:"message"`
-	Priority int      `json:"priority"`
-	Tags     []string `json:"tags"`
-	Click    string   `json:"click"`
-	Icon     string   `json:"icon"`
-	Actions  []action `json:"actions"`
-	Attach   string   `json:"attach"`
-	Markdown bool     `json:"markdown"`
-	Filename string   `json:"filename"`
-	Email    string   `json:"email"`
-	Call     string   `json:"call"`
-	Delay    string   `json:"delay"`
+	Topic         string    `json:"topic"`
+	Title         string    `json:"title"`
+	Message       string    `json:"message"`
+	Priority      int       `json:"priority"`
+	Tags          []string  `json:"tags"`
+	Click         string    `json:"click"`
+	Icon          string    `json:"icon"`
+	Sound         string    `json:"sound"`
+	Actions       []action  `json:"actions"`
+	Attach        string    `json:"attach"`
+	AttachmentID  string    `json:"attachment_id"`
+	Markdown      bool      `json:"markdown"`
+	Filename      string    `json:"filename"`
+	Email         string    `json:"email"`
+	Call          string    `json:"call"`
+	Delay         string    `json:"delay"`
+	InReplyTo     string    `json:"in_reply_to"`
+	Location      *location `json:"location"`
+	Hostname      string    `json:"hostname"`
+	CorrelationID string    `json:"correlation_id"`
 }
 
 // messageEncoder is a function that knows how to encode a message
@@ -132,11 +327,37 @@ func newKeepaliveMessage(topic string) *message {
 	return newMessage(keepaliveEvent, topic, "")
 }
 
+// newReconnectMessage is a convenience method to create a reconnect message, sent when the server is shutting down
+func newReconnectMessage(topic string) *message {
+	return newMessage(reconnectEvent, topic, "")
+}
+
 // newDefaultMessage is a convenience method to create a notification message
 func newDefaultMessage(topic, msg string) *message {
 	return newMessage(messageEvent, topic, msg)
 }
 
+// newReactionsMessage is a convenience method to create a reactions message, sent when the reactions to a message change
+func newReactionsMessage(topic, messageID string, counts map[string]int) *message {
+	m := newMessage(reactionsEvent, topic, "")
+	m.Reactions = &reactions{
+		MessageID: messageID,
+		Counts:    counts,
+	}
+	return m
+}
+
+// newAnnotationsMessage is a convenience method to create an annotations message, sent when the annotations of a
+// message change
+func newAnnotationsMessage(topic, messageID string, items []*annotation) *message {
+	m := newMessage(annotationsEvent, topic, "")
+	m.Annotations = &annotations{
+		MessageID: messageID,
+		Items:     items,
+	}
+	return m
+}
+
 // newPollRequestMessage is a convenience method to create a poll request message
 func newPollRequestMessage(topic, pollID string) *message {
 	m := newMessage(pollRequestEvent, topic, newMessageBody)
@@ -144,6 +365,26 @@ func newPollRequestMessage(topic, pollID string) *message {
 	return m
 }
 
+// parseLocation parses a location in the "latitude,longitude" format, e.g. "52.52,13.405"
+func parseLocation(s string) (*location, error) {
+	latitudeStr, longitudeStr, ok := strings.Cut(s, ",")
+	if !ok {
+		return nil, errHTTPBadRequestLocationInvalid
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(latitudeStr), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return nil, errHTTPBadRequestLocationInvalid
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(longitudeStr), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return nil, errHTTPBadRequestLocationInvalid
+	}
+	return &location{
+		Latitude:  latitude,
+		Longitude: longitude,
+	}, nil
+}
+
 func validMessageID(s string) bool {
 	return util.ValidRandomString(s, messageIDLength)
 }
@@ -240,7 +481,13 @@ func (q *queryFilter) Pass(msg *message) bool {
 }
 
 type apiHealthResponse struct {
-	Healthy bool `json:"healthy"`
+	Healthy bool                       `json:"healthy"`
+	Checks  map[string]*apiHealthCheck `json:"checks,omitempty"` // Only set for readiness checks
+}
+
+type apiHealthCheck struct {
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
 }
 
 type apiStatsResponse struct {
@@ -248,6 +495,263 @@ type apiStatsResponse struct {
 	MessagesRate float64 `json:"messages_rate"` // Average number of messages per second
 }
 
+type apiStatsHistoryResponse struct {
+	Interval int64                   `json:"interval"` // Length of each data point in seconds
+	Points   []*apiStatsHistoryPoint `json:"points"`
+}
+
+type apiStatsHistoryPoint struct {
+	Time        int64 `json:"time"`
+	Messages    int64 `json:"messages"`
+	Bytes       int64 `json:"bytes"`
+	Subscribers int64 `json:"subscribers"`
+	Failed      int64 `json:"failed"`
+}
+
+type apiMessageDeliveriesResponse struct {
+	ID         string                `json:"id"`
+	Topic      string                `json:"topic"`
+	Time       int64                 `json:"time"`
+	Deliveries []*apiMessageDelivery `json:"deliveries"`
+}
+
+type apiMessageDelivery struct {
+	Time    int64  `json:"time"`
+	Channel string `json:"channel"`
+	Outcome string `json:"outcome"`
+	Count   int    `json:"count"`
+	Error   string `json:"error,omitempty"`
+}
+
+type apiTopicStatsResponse struct {
+	Topic            string                    `json:"topic"`
+	Subscribers      *apiTopicStatsSubscribers `json:"subscribers"`
+	MessagesLastHour int64                     `json:"messages_last_hour"`
+	MessageRate      float64                   `json:"message_rate"` // Messages per minute, averaged over the last hour
+	LastMessage      int64                     `json:"last_message,omitempty"`
+	LastAccess       int64                     `json:"last_access,omitempty"`
+}
+
+type apiTopicStatsSubscribers struct {
+	Total     int `json:"total"`
+	JSON      int `json:"json"`
+	SSE       int `json:"sse"`
+	Raw       int `json:"raw"`
+	WebSocket int `json:"ws"`
+	WebPush   int `json:"webpush"`
+	Firebase  int `json:"firebase"` // Estimated from the number of polling clients, see topic.Polled
+}
+
+type apiMessageThreadResponse struct {
+	ID       string     `json:"id"` // ID of the first message of the thread
+	Topic    string     `json:"topic"`
+	Messages []*message `json:"messages"`
+}
+
+type apiMessageReactionsResponse struct {
+	ID        string         `json:"id"`
+	Topic     string         `json:"topic"`
+	Reactions map[string]int `json:"reactions"` // Emoji -> number of reactions
+}
+
+type apiAttachmentUploadResponse struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Size    int64  `json:"size"`
+	Expires int64  `json:"expires"`
+}
+
+type apiMessageAnnotationsResponse struct {
+	ID          string        `json:"id"`
+	Topic       string        `json:"topic"`
+	Annotations []*annotation `json:"annotations"` // Oldest first
+}
+
+type apiTopicPresenceResponse struct {
+	Topic   string                    `json:"topic"`
+	Clients []*apiTopicPresenceClient `json:"clients"`
+}
+
+type apiTopicPresenceClient struct {
+	Label     string `json:"label"`
+	Since     int64  `json:"since"`
+	Transport string `json:"transport"`
+}
+
+type apiShowcaseResponse struct {
+	Topic    string                `json:"topic"`
+	Messages []*apiShowcaseMessage `json:"messages"` // Newest first
+}
+
+type apiShowcaseMessage struct {
+	ID       string   `json:"id"`
+	Time     int64    `json:"time"`
+	Title    string   `json:"title,omitempty"`
+	Message  string   `json:"message"`
+	Priority int      `json:"priority"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+type apiMatrixFailure struct {
+	Time    int64  `json:"time"`
+	PushKey string `json:"pushkey"`
+	AppID   string `json:"app_id,omitempty"`
+	Outcome string `json:"outcome"` // "rejected" or "failed"
+	Reason  string `json:"reason"`
+}
+
+type apiMatrixPushKeyResponse struct {
+	PushKey       string              `json:"pushkey"`
+	AppID         string              `json:"app_id,omitempty"`
+	Delivered     int64               `json:"delivered"`
+	Rejected      int64               `json:"rejected"`
+	Failed        int64               `json:"failed"`
+	LastSeen      int64               `json:"last_seen"`
+	LastDelivered int64               `json:"last_delivered,omitempty"`
+	Failures      []*apiMatrixFailure `json:"failures"`
+}
+
+type apiMatrixAppStats struct {
+	AppID     string `json:"app_id"`
+	Delivered int64  `json:"delivered"`
+	Rejected  int64  `json:"rejected"`
+	Failed    int64  `json:"failed"`
+}
+
+type apiMatrixStatsResponse struct {
+	PushKeys int                  `json:"pushkeys"` // Number of push keys tracked
+	Apps     []*apiMatrixAppStats `json:"apps"`
+	Failures []*apiMatrixFailure  `json:"failures"`
+}
+
+type apiPublishValidateResponse struct {
+	Valid   bool       `json:"valid"`
+	Message *message   `json:"message,omitempty"`
+	Errors  []*errHTTP `json:"errors,omitempty"`
+}
+
+type apiReportRequest struct {
+	Topic     string `json:"topic"`
+	MessageID string `json:"message_id,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+type apiReportResponse struct {
+	ID string `json:"id"`
+}
+
+type apiReport struct {
+	ID           string   `json:"id"`
+	Time         int64    `json:"time"`
+	Topic        string   `json:"topic"`
+	MessageID    string   `json:"message_id,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	Message      string   `json:"message,omitempty"`
+	Sender       string   `json:"sender,omitempty"` // IP address of the publisher
+	User         string   `json:"user,omitempty"`   // Username of the publisher
+	Reason       string   `json:"reason"`
+	ReporterIP   string   `json:"reporter_ip"`
+	ReporterUser string   `json:"reporter_user,omitempty"`
+	Status       string   `json:"status"`
+	Actions      []string `json:"actions,omitempty"`
+}
+
+type apiReportActionRequest struct {
+	Action   string `json:"action"`
+	Duration string `json:"duration,omitempty"` // Only for "ban_ip", e.g. "24h" or "7d"; forever if empty
+}
+
+type apiBlockedTopic struct {
+	Topic  string `json:"topic"`
+	Time   int64  `json:"time"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type apiBannedIP struct {
+	IP      string `json:"ip"`
+	Time    int64  `json:"time"`
+	Expires int64  `json:"expires,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type apiBlocksResponse struct {
+	Topics []*apiBlockedTopic `json:"topics"`
+	IPs    []*apiBannedIP     `json:"ips"`
+}
+
+type apiBlocksRequest struct {
+	Topic    string `json:"topic,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Duration string `json:"duration,omitempty"` // Ban duration (IP addresses only), e.g. "24h"; banned forever if empty
+	Reason   string `json:"reason,omitempty"`
+}
+
+type apiBlocksDeleteRequest struct {
+	Topic string `json:"topic,omitempty"`
+	IP    string `json:"ip,omitempty"`
+}
+
+type apiMessagesPruneRequest struct {
+	Topic  string `json:"topic"`
+	Before string `json:"before,omitempty"` // e.g. "2024-01-01", "7d" or a unix timestamp; all messages if empty
+}
+
+type apiMessagesPruneResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+type apiRateLimitExemption struct {
+	Host   string `json:"host,omitempty"`
+	User   string `json:"user,omitempty"`
+	Time   int64  `json:"time,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Config bool   `json:"config,omitempty"` // Defined in server.yml (visitor-request-limit-exempt-hosts), cannot be removed via the API
+}
+
+type apiRateLimitExemptionRequest struct {
+	Host   string `json:"host,omitempty"` // IP address, prefix (e.g. 10.0.1.0/24) or hostname
+	User   string `json:"user,omitempty"` // Username
+	Reason string `json:"reason,omitempty"`
+}
+
+type apiUndeliverableEmail struct {
+	Email  string `json:"email"`
+	Time   int64  `json:"time"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type apiUndeliverableEmailRequest struct {
+	Email string `json:"email"`
+}
+
+type apiVisitor struct {
+	ID                       string `json:"id"`
+	IP                       string `json:"ip"`
+	User                     string `json:"user,omitempty"`
+	Tier                     string `json:"tier,omitempty"`
+	Seen                     int64  `json:"seen"`
+	RequestsLimit            int    `json:"requests_limit"`
+	RequestsRemaining        int    `json:"requests_remaining"`
+	Messages                 int64  `json:"messages"`
+	MessagesRemaining        int64  `json:"messages_remaining"`
+	Emails                   int64  `json:"emails"`
+	EmailsRemaining          int64  `json:"emails_remaining"`
+	Subscriptions            int64  `json:"subscriptions"`
+	SubscriptionsLimit       int64  `json:"subscriptions_limit"`
+	AttachmentBandwidth      int64  `json:"attachment_bandwidth"`
+	AttachmentBandwidthLimit int64  `json:"attachment_bandwidth_limit"`
+	Banned                   bool   `json:"banned,omitempty"`
+	BanExpires               int64  `json:"ban_expires,omitempty"`
+	ExemptUntil              int64  `json:"exempt_until,omitempty"`
+}
+
+type apiVisitorRequest struct {
+	ID       string `json:"id,omitempty"`       // Visitor ID, e.g. "ip:1.2.3.4" or "user:u_abc..."
+	IP       string `json:"ip,omitempty"`       // Shortcut for ID "ip:<ip>"
+	Duration string `json:"duration,omitempty"` // Exemption duration, e.g. "1h" (exempt only)
+}
+
 type apiUserAddRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -271,6 +775,10 @@ type apiUserDeleteRequest struct {
 	Username string `json:"username"`
 }
 
+type apiUserImpersonateRequest struct {
+	Username string `json:"username"`
+}
+
 type apiAccessAllowRequest struct {
 	Username   string `json:"username"`
 	Topic      string `json:"topic"` // This may be a pattern
@@ -282,6 +790,32 @@ type apiAccessResetRequest struct {
 	Topic    string `json:"topic"`
 }
 
+type apiGroupRequest struct {
+	Name string `json:"name"`
+}
+
+type apiGroupResponse struct {
+	Name    string                  `json:"name"`
+	Members []string                `json:"members"`
+	Grants  []*apiUserGrantResponse `json:"grants,omitempty"`
+}
+
+type apiGroupMembersRequest struct {
+	Name      string   `json:"name"`
+	Usernames []string `json:"usernames"`
+}
+
+type apiGroupAccessAllowRequest struct {
+	Name       string `json:"name"`
+	Topic      string `json:"topic"` // This may be a pattern
+	Permission string `json:"permission"`
+}
+
+type apiGroupAccessResetRequest struct {
+	Name  string `json:"name"`
+	Topic string `json:"topic"`
+}
+
 type apiAccountCreateRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -297,8 +831,9 @@ type apiAccountDeleteRequest struct {
 }
 
 type apiAccountTokenIssueRequest struct {
-	Label   *string `json:"label"`
-	Expires *int64  `json:"expires"` // Unix timestamp
+	Label      *string  `json:"label"`
+	Expires    *int64   `json:"expires"`     // Unix timestamp
+	AllowedIPs []string `json:"allowed_ips"` // IP ranges (e.g. 10.0.1.0/24) or addresses the token is restricted to
 }
 
 type apiAccountTokenUpdateRequest struct {
@@ -308,11 +843,13 @@ type apiAccountTokenUpdateRequest struct {
 }
 
 type apiAccountTokenResponse struct {
-	Token      string `json:"token"`
-	Label      string `json:"label,omitempty"`
-	LastAccess int64  `json:"last_access,omitempty"`
-	LastOrigin string `json:"last_origin,omitempty"`
-	Expires    int64  `json:"expires,omitempty"` // Unix timestamp
+	Token        string   `json:"token"`
+	Label        string   `json:"label,omitempty"`
+	LastAccess   int64    `json:"last_access,omitempty"`
+	LastOrigin   string   `json:"last_origin,omitempty"`
+	Expires      int64    `json:"expires,omitempty"` // Unix timestamp
+	AllowedIPs   []string `json:"allowed_ips,omitempty"`
+	Impersonator string   `json:"impersonator,omitempty"`
 }
 
 type apiAccountPhoneNumberVerifyRequest struct {
@@ -341,6 +878,7 @@ type apiAccountLimits struct {
 	AttachmentFileSize       int64  `json:"attachment_file_size"`
 	AttachmentExpiryDuration int64  `json:"attachment_expiry_duration"`
 	AttachmentBandwidth      int64  `json:"attachment_bandwidth"`
+	MessageSize              int64  `json:"message_size"`
 }
 
 type apiAccountStats struct {
@@ -357,8 +895,110 @@ type apiAccountStats struct {
 }
 
 type apiAccountReservation struct {
-	Topic    string `json:"topic"`
-	Everyone string `json:"everyone"`
+	Topic       string                  `json:"topic"`
+	Everyone    string                  `json:"everyone"`
+	Group       string                  `json:"group,omitempty"`
+	Sound       string                  `json:"sound,omitempty"`
+	Routing     []*apiRoutingRule       `json:"routing,omitempty"`
+	Escalation  *apiEscalation          `json:"escalation,omitempty"`
+	Schedule    *apiSchedule            `json:"schedule,omitempty"`
+	Policy      *apiTopicPolicy         `json:"policy,omitempty"`
+	PublishKeys []*apiAccountPublishKey `json:"publish_keys,omitempty"`
+}
+
+type apiRoutingRule struct {
+	Priorities []int  `json:"priorities"`
+	Push       bool   `json:"push"`
+	Email      string `json:"email,omitempty"`
+	Call       string `json:"call,omitempty"`
+}
+
+type apiTopicPolicy struct {
+	MaxMessageSize int   `json:"max_message_size,omitempty"` // Bytes
+	NoAttachments  bool  `json:"no_attachments,omitempty"`
+	Priorities     []int `json:"priorities,omitempty"` // Allowed priorities (1-5), all if empty
+}
+
+type apiEscalation struct {
+	Priority int                  `json:"priority,omitempty"`
+	Steps    []*apiEscalationStep `json:"steps"`
+}
+
+type apiEscalationStep struct {
+	Delay  string `json:"delay"` // Duration, e.g. "5m" or "1h"
+	Topic  string `json:"topic,omitempty"`
+	Email  string `json:"email,omitempty"`
+	Call   string `json:"call,omitempty"`
+	OnCall bool   `json:"on_call,omitempty"` // Contact the member of the on-call schedule who is currently on call
+}
+
+type apiSchedule struct {
+	Start   int64                `json:"start,omitempty"` // Unix timestamp, defaults to now
+	Shift   string               `json:"shift"`           // Duration, e.g. "1d" or "168h"
+	Members []*apiScheduleMember `json:"members"`
+	OnCall  string               `json:"on_call,omitempty"` // Name of the member currently on call (response only)
+}
+
+type apiScheduleMember struct {
+	Name  string `json:"name"`
+	User  string `json:"user,omitempty"`
+	Email string `json:"email,omitempty"`
+	Call  string `json:"call,omitempty"`
+}
+
+type apiMaintenanceWindow struct {
+	ID         string `json:"id,omitempty"`       // Response only
+	Start      int64  `json:"start,omitempty"`    // Unix timestamp, defaults to now
+	End        int64  `json:"end,omitempty"`      // Unix timestamp, alternatively use duration
+	Duration   string `json:"duration,omitempty"` // Duration, e.g. "30m" or "2h" (request only)
+	Recurrence string `json:"recurrence,omitempty"`
+	Action     string `json:"action,omitempty"` // Defaults to "suppress"
+	Reason     string `json:"reason,omitempty"`
+	Active     bool   `json:"active,omitempty"` // Response only
+}
+
+type apiMaintenanceWindowsResponse struct {
+	Topic   string                  `json:"topic"`
+	Windows []*apiMaintenanceWindow `json:"windows"`
+}
+
+type apiHeartbeat struct {
+	Interval   string `json:"interval"` // Duration, e.g. "5m" or "1d"
+	AlertTopic string `json:"alert_topic,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Priority   int    `json:"priority,omitempty"`
+	LastPing   int64  `json:"last_ping,omitempty"` // Response only
+	Due        int64  `json:"due,omitempty"`       // Response only
+	Status     string `json:"status,omitempty"`    // Response only: "new", "up" or "down"
+}
+
+type apiPublishURLRequest struct {
+	Message  string   `json:"message"`
+	Title    string   `json:"title,omitempty"`
+	Priority int      `json:"priority,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Expires  string   `json:"expires,omitempty"` // Duration, e.g. "24h" or "30d"
+}
+
+type apiPublishURLResponse struct {
+	URL     string `json:"url"`
+	Expires int64  `json:"expires"` // Unix timestamp
+}
+
+type apiAccountPublishKey struct {
+	Key         string   `json:"key"`
+	Label       string   `json:"label,omitempty"`
+	Created     int64    `json:"created"` // Unix timestamp
+	TitlePrefix string   `json:"title_prefix,omitempty"`
+	Priority    int      `json:"priority,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+type apiAccountPublishKeyRequest struct {
+	Label       string   `json:"label"`
+	TitlePrefix string   `json:"title_prefix,omitempty"` // Defaults applied to all messages published with the key
+	Priority    int      `json:"priority,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
 }
 
 type apiAccountBilling struct {
@@ -371,24 +1011,48 @@ type apiAccountBilling struct {
 }
 
 type apiAccountResponse struct {
-	Username      string                     `json:"username"`
-	Role          string                     `json:"role,omitempty"`
-	SyncTopic     string                     `json:"sync_topic,omitempty"`
-	Language      string                     `json:"language,omitempty"`
-	Notification  *user.NotificationPrefs    `json:"notification,omitempty"`
-	Subscriptions []*user.Subscription       `json:"subscriptions,omitempty"`
-	Reservations  []*apiAccountReservation   `json:"reservations,omitempty"`
-	Tokens        []*apiAccountTokenResponse `json:"tokens,omitempty"`
-	PhoneNumbers  []string                   `json:"phone_numbers,omitempty"`
-	Tier          *apiAccountTier            `json:"tier,omitempty"`
-	Limits        *apiAccountLimits          `json:"limits,omitempty"`
-	Stats         *apiAccountStats           `json:"stats,omitempty"`
-	Billing       *apiAccountBilling         `json:"billing,omitempty"`
+	Username          string                     `json:"username"`
+	Role              string                     `json:"role,omitempty"`
+	SyncTopic         string                     `json:"sync_topic,omitempty"`
+	Language          string                     `json:"language,omitempty"`
+	Notification      *user.NotificationPrefs    `json:"notification,omitempty"`
+	Subscriptions     []*user.Subscription       `json:"subscriptions,omitempty"`
+	QuotaWarning      *user.QuotaWarningPrefs    `json:"quota_warning,omitempty"`
+	Templates         []*user.Template           `json:"templates,omitempty"`
+	Reservations      []*apiAccountReservation   `json:"reservations,omitempty"`
+	Groups            []string                   `json:"groups,omitempty"`
+	Tokens            []*apiAccountTokenResponse `json:"tokens,omitempty"`
+	PhoneNumbers      []string                   `json:"phone_numbers,omitempty"`
+	Tier              *apiAccountTier            `json:"tier,omitempty"`
+	Limits            *apiAccountLimits          `json:"limits,omitempty"`
+	Stats             *apiAccountStats           `json:"stats,omitempty"`
+	Billing           *apiAccountBilling         `json:"billing,omitempty"`
+	TagIcons          []*apiTagIcon              `json:"tag_icons,omitempty"`
+	DeletionScheduled int64                      `json:"deletion_scheduled,omitempty"` // Unix timestamp, see handleAccountDelete
 }
 
 type apiAccountReservationRequest struct {
-	Topic    string `json:"topic"`
-	Everyone string `json:"everyone"`
+	Topic      string            `json:"topic"`
+	Everyone   string            `json:"everyone"`
+	Group      string            `json:"group"`      // Group to share the reservation with, may be empty
+	Sound      string            `json:"sound"`      // Default notification sound for the topic, may be empty
+	Routing    []*apiRoutingRule `json:"routing"`    // Delivery channels by message priority, may be empty
+	Escalation *apiEscalation    `json:"escalation"` // Escalation policy for unacknowledged messages, may be nil
+	Policy     *apiTopicPolicy   `json:"policy"`     // Restrictions for published messages, may be nil
+}
+
+type apiTagIcon struct {
+	Tag   string `json:"tag"`
+	Emoji string `json:"emoji,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+type apiTagIconsResponse struct {
+	Icons []*apiTagIcon `json:"icons"`
+}
+
+type apiErrorsResponse struct {
+	Errors []*errHTTP `json:"errors"`
 }
 
 type apiConfigResponse struct {
@@ -468,6 +1132,99 @@ type apiStripeSubscriptionDeletedEvent struct {
 	Customer string `json:"customer"`
 }
 
+type apiPaddleResponse[T any] struct {
+	Data  T `json:"data"`
+	Error *struct {
+		Code   string `json:"code"`
+		Detail string `json:"detail"`
+	} `json:"error"`
+	Meta *struct {
+		Pagination *struct {
+			Next    string `json:"next"`
+			HasMore bool   `json:"has_more"`
+		} `json:"pagination"`
+	} `json:"meta"`
+}
+
+type apiPaddlePrice struct {
+	ID        string `json:"id"`
+	UnitPrice *struct {
+		Amount string `json:"amount"` // In cents, e.g. "500"
+	} `json:"unit_price"`
+}
+
+type apiPaddleItem struct {
+	PriceID  string `json:"price_id"`
+	Quantity int    `json:"quantity"`
+}
+
+type apiPaddleCustomData struct {
+	UserID   string `json:"user_id"`
+	UserName string `json:"user_name,omitempty"`
+}
+
+type apiPaddleTransactionRequest struct {
+	Items      []*apiPaddleItem     `json:"items"`
+	CustomerID string               `json:"customer_id,omitempty"`
+	CustomData *apiPaddleCustomData `json:"custom_data"`
+}
+
+type apiPaddleTransaction struct {
+	ID       string `json:"id"`
+	Checkout *struct {
+		URL string `json:"url"`
+	} `json:"checkout"`
+}
+
+type apiPaddleCustomerUpdateRequest struct {
+	CustomData *apiPaddleCustomData `json:"custom_data"`
+}
+
+type apiPaddleSubscriptionUpdateRequest struct {
+	Items                []*apiPaddleItem `json:"items"`
+	ProrationBillingMode string           `json:"proration_billing_mode"`
+	ScheduledChange      *struct{}        `json:"scheduled_change"` // Always null, removes a scheduled cancellation
+}
+
+type apiPaddleSubscriptionCancelRequest struct {
+	EffectiveFrom string `json:"effective_from"`
+}
+
+type apiPaddlePortalSession struct {
+	URLs *struct {
+		General *struct {
+			Overview string `json:"overview"`
+		} `json:"general"`
+	} `json:"urls"`
+}
+
+type apiPaddleWebhookEvent struct {
+	EventType string          `json:"event_type"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type apiPaddleSubscription struct {
+	ID                   string               `json:"id"`
+	Status               string               `json:"status"`
+	CustomerID           string               `json:"customer_id"`
+	CustomData           *apiPaddleCustomData `json:"custom_data"`
+	CurrentBillingPeriod *struct {
+		EndsAt time.Time `json:"ends_at"`
+	} `json:"current_billing_period"`
+	ScheduledChange *struct {
+		Action      string    `json:"action"`
+		EffectiveAt time.Time `json:"effective_at"`
+	} `json:"scheduled_change"`
+	Items []*struct {
+		Price *struct {
+			ID           string `json:"id"`
+			BillingCycle *struct {
+				Interval string `json:"interval"`
+			} `json:"billing_cycle"`
+		} `json:"price"`
+	} `json:"items"`
+}
+
 type apiWebPushUpdateSubscriptionRequest struct {
 	Endpoint string   `json:"endpoint"`
 	Auth     string   `json:"auth"`
@@ -475,34 +1232,75 @@ type apiWebPushUpdateSubscriptionRequest struct {
 	Topics   []string `json:"topics"`
 }
 
+type apiAccountWebPushSubscription struct {
+	ID          string   `json:"id"`
+	UserAgent   string   `json:"user_agent"`
+	Topics      []string `json:"topics"`
+	Created     int64    `json:"created"`
+	Updated     int64    `json:"updated"`
+	LastSuccess int64    `json:"last_success,omitempty"`
+}
+
+type apiAccountWebPushSubscriptionsResponse struct {
+	Subscriptions []*apiAccountWebPushSubscription `json:"subscriptions"`
+	Limit         int                              `json:"limit,omitempty"`
+}
+
+type apiWebPushRenewSubscriptionRequest struct {
+	Endpoint string `json:"endpoint"`
+}
+
 // List of possible Web Push events (see sw.js)
 const (
 	webPushMessageEvent  = "message"
 	webPushExpiringEvent = "subscription_expiring"
+	webPushPingEvent     = "subscription_ping"
 )
 
 type webPushPayload struct {
 	Event          string   `json:"event"`
 	SubscriptionID string   `json:"subscription_id"`
 	Message        *message `json:"message"`
+	MessageHTML    string   `json:"message_html,omitempty"` // Rendered excerpt of Markdown messages
 }
 
 func newWebPushPayload(subscriptionID string, message *message) *webPushPayload {
-	return &webPushPayload{
+	payload := &webPushPayload{
 		Event:          webPushMessageEvent,
 		SubscriptionID: subscriptionID,
 		Message:        message,
 	}
+	if isMarkdown(message) {
+		payload.MessageHTML = renderMarkdownExcerpt(message.Message, webPushMarkdownExcerptLength)
+	}
+	return payload
 }
 
 type webPushControlMessagePayload struct {
 	Event string `json:"event"`
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
 }
 
-func newWebPushSubscriptionExpiringPayload() *webPushControlMessagePayload {
-	return &webPushControlMessagePayload{
+// newWebPushSubscriptionExpiringPayload creates the expiry warning payload. If a language is passed, the
+// notification text is included in that language. Otherwise, the service worker uses the browser's language.
+func newWebPushSubscriptionExpiringPayload(lang string) *webPushControlMessagePayload {
+	payload := &webPushControlMessagePayload{
 		Event: webPushExpiringEvent,
 	}
+	if lang != "" {
+		payload.Title = translate(lang, "web_push_subscription_expiring_title")
+		payload.Body = translate(lang, "web_push_subscription_expiring_body")
+	}
+	return payload
+}
+
+// newWebPushSubscriptionPingPayload creates the silent ping payload, which the service worker answers
+// by renewing the subscription (see handleWebPushRenew)
+func newWebPushSubscriptionPingPayload() *webPushControlMessagePayload {
+	return &webPushControlMessagePayload{
+		Event: webPushPingEvent,
+	}
 }
 
 type webPushSubscription struct {
@@ -513,6 +1311,16 @@ type webPushSubscription struct {
 	UserID   string
 }
 
+// webPushUserSubscription is a web push subscription as listed in the user's account, see SubscriptionsForUser
+type webPushUserSubscription struct {
+	ID          string
+	UserAgent   string
+	Topics      []string
+	Created     int64
+	Updated     int64
+	LastSuccess int64
+}
+
 func (w *webPushSubscription) Context() log.Context {
 	return map[string]any{
 		"web_push_subscription_id":       w.ID,
@@ -539,3 +1347,123 @@ type webManifestIcon struct {
 	Sizes string `json:"sizes"`
 	Type  string `json:"type"`
 }
+
+// webhookEvent is the payload of an account lifecycle webhook, see webhookSender
+type webhookEvent struct {
+	ID          string              `json:"id"`
+	Event       string              `json:"event"`
+	Time        int64               `json:"time"`
+	User        *webhookUser        `json:"user"`
+	Tier        *webhookTierChange  `json:"tier,omitempty"`
+	Reservation *webhookReservation `json:"reservation,omitempty"`
+}
+
+func (e *webhookEvent) Context() log.Context {
+	return log.Context{
+		"webhook_event_id":   e.ID,
+		"webhook_event_type": e.Event,
+		"user_id":            e.User.ID,
+		"user_name":          e.User.Username,
+	}
+}
+
+type webhookUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type webhookTierChange struct {
+	Old string `json:"old,omitempty"` // Tier code, empty if the user had no tier
+	New string `json:"new,omitempty"` // Tier code, empty if the tier was removed
+}
+
+type webhookReservation struct {
+	Topic    string `json:"topic"`
+	Everyone string `json:"everyone"`
+}
+
+const (
+	scimSchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimSchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimSchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimSchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimSchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimContentType                 = "application/scim+json"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc7643#section-4.1
+type scimUserRequest struct {
+	Schemas  []string `json:"schemas"`
+	UserName string   `json:"userName"`
+	Password string   `json:"password,omitempty"`
+	Active   *bool    `json:"active,omitempty"`
+}
+
+type scimUserResponse struct {
+	Schemas  []string  `json:"schemas"`
+	ID       string    `json:"id"`
+	UserName string    `json:"userName"`
+	Active   bool      `json:"active"`
+	Meta     *scimMeta `json:"meta"`
+}
+
+type scimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+}
+
+// https://datatracker.ietf.org/doc/html/rfc7644#section-3.4.2
+type scimListResponse struct {
+	Schemas      []string            `json:"schemas"`
+	TotalResults int                 `json:"totalResults"`
+	StartIndex   int                 `json:"startIndex"`
+	ItemsPerPage int                 `json:"itemsPerPage"`
+	Resources    []*scimUserResponse `json:"Resources"`
+}
+
+// https://datatracker.ietf.org/doc/html/rfc7644#section-3.5.2
+type scimPatchRequest struct {
+	Schemas    []string              `json:"schemas"`
+	Operations []*scimPatchOperation `json:"Operations"`
+}
+
+type scimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// https://datatracker.ietf.org/doc/html/rfc7644#section-3.12
+type scimErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// https://datatracker.ietf.org/doc/html/rfc7643#section-5
+type scimServiceProviderConfigResponse struct {
+	Schemas               []string                    `json:"schemas"`
+	Patch                 *scimSupported              `json:"patch"`
+	Bulk                  *scimSupported              `json:"bulk"`
+	Filter                *scimFilterSupported        `json:"filter"`
+	ChangePassword        *scimSupported              `json:"changePassword"`
+	Sort                  *scimSupported              `json:"sort"`
+	ETag                  *scimSupported              `json:"etag"`
+	AuthenticationSchemes []*scimAuthenticationScheme `json:"authenticationSchemes"`
+}
+
+type scimSupported struct {
+	Supported bool `json:"supported"`
+}
+
+type scimFilterSupported struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type scimAuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
@@ -1,20 +1,44 @@
 package server
 
 import (
+	"bufio"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday/v2"
 	"heckel.io/ntfy/v2/util"
 	"io"
 	"mime"
+	"net"
 	"net/http"
 	"net/netip"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	unixSocketRemoteAddr = "@" // RemoteAddr is @ when the Unix socket is used
+)
+
+// Route labels of requests, used for metrics and the access log, see requestRoute
+const (
+	routePublish   = "publish"
+	routeSubscribe = "subscribe"
+	routePoll      = "poll"
+	routeAccount   = "account"
+	routeWebPush   = "webpush"
+	routeOther     = "other"
 )
 
 var (
 	mimeDecoder               mime.WordDecoder
 	priorityHeaderIgnoreRegex = regexp.MustCompile(`^u=\d,\s*(i|\d)$|^u=\d$`)
+	markdownPolicy            = bluemonday.UGCPolicy()
 )
 
 func readBoolParam(r *http.Request, defaultValue bool, names ...string) bool {
@@ -81,7 +105,7 @@ func extractIPAddress(r *http.Request, behindProxy bool) netip.Addr {
 		ip, err = netip.ParseAddr(remoteAddr)
 		if err != nil {
 			ip = netip.IPv4Unspecified()
-			if remoteAddr != "@" || !behindProxy { // RemoteAddr is @ when unix socket is used
+			if remoteAddr != unixSocketRemoteAddr {
 				logr(r).Err(err).Warn("unable to parse IP (%s), new visitor with unspecified IP (0.0.0.0) created", remoteAddr)
 			}
 		}
@@ -102,12 +126,38 @@ func extractIPAddress(r *http.Request, behindProxy bool) netip.Addr {
 	return ip
 }
 
+// isUnixSocketRequest returns true if the request was received via the Unix socket listener (see listen-unix),
+// and was not forwarded on behalf of another client by a reverse proxy (X-Forwarded-For header).
+func isUnixSocketRequest(r *http.Request, behindProxy bool) bool {
+	if r.RemoteAddr != unixSocketRemoteAddr {
+		return false
+	}
+	return !behindProxy || strings.TrimSpace(r.Header.Get("X-Forwarded-For")) == ""
+}
+
+// parseBasicAuth parses the value of a "Basic ..." Authorization header, see http.Request.BasicAuth
+func parseBasicAuth(header string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	username, password, ok = strings.Cut(string(b), ":")
+	if !ok {
+		return "", "", false
+	}
+	return username, password, true
+}
+
 func readJSONWithLimit[T any](r io.ReadCloser, limit int, allowEmpty bool) (*T, error) {
 	obj, err := util.UnmarshalJSONWithLimit[T](r, limit, allowEmpty)
 	if err == util.ErrUnmarshalJSON {
 		return nil, errHTTPBadRequestJSONInvalid
 	} else if err == util.ErrTooLargeJSON {
-		return nil, errHTTPEntityTooLargeJSONBody
+		return nil, errHTTPEntityTooLargeJSONBody.WithLimit(int64(limit))
 	} else if err != nil {
 		return nil, err
 	}
@@ -153,3 +203,133 @@ func maybeIgnoreSpecialHeader(name, value string) string {
 	}
 	return value
 }
+
+// isMarkdown returns true if the message body is formatted as Markdown
+func isMarkdown(m *message) bool {
+	return m.ContentType == "text/markdown"
+}
+
+// renderMarkdown converts the given Markdown text to HTML. The HTML is sanitized, so that it is safe
+// to be displayed by mail clients and browsers, e.g. scripts and event handlers are removed.
+func renderMarkdown(markdown string) string {
+	markdown = strings.ReplaceAll(markdown, "\r\n", "\n")
+	unsafe := blackfriday.Run([]byte(markdown), blackfriday.WithExtensions(blackfriday.CommonExtensions))
+	return strings.TrimSpace(string(markdownPolicy.SanitizeBytes(unsafe)))
+}
+
+// renderMarkdownExcerpt converts the first maxLength bytes of the given Markdown text to HTML (see renderMarkdown).
+// Longer texts are cut off at the last line break or space, so that words and most Markdown elements stay intact.
+func renderMarkdownExcerpt(markdown string, maxLength int) string {
+	if len(markdown) > maxLength {
+		cut := markdown[:maxLength]
+		if i := strings.LastIndexAny(cut, "\n "); i > 0 {
+			cut = cut[:i]
+		}
+		for !utf8.ValidString(cut) {
+			cut = cut[:len(cut)-1]
+		}
+		markdown = strings.TrimSpace(cut) + " …"
+	}
+	return renderMarkdown(markdown)
+}
+
+// requestRoute returns the route label of a request, as used in the HTTP request histograms and the access log. The set of labels is
+// fixed (and small), so that the cardinality of the histograms does not depend on the topics in use.
+func requestRoute(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case (r.Method == http.MethodPut || r.Method == http.MethodPost) && (path == "/" || path == matrixPushPath || topicPathRegex.MatchString(path)):
+		return routePublish
+	case r.Method == http.MethodGet && publishPathRegex.MatchString(path):
+		return routePublish
+	case r.Method == http.MethodGet && (jsonPathRegex.MatchString(path) || ssePathRegex.MatchString(path) || rawPathRegex.MatchString(path) || wsPathRegex.MatchString(path)):
+		if readBoolParam(r, false, "x-poll", "poll", "po") {
+			return routePoll
+		}
+		return routeSubscribe
+	case strings.HasPrefix(path, apiWebPushPath) || strings.HasPrefix(path, apiAccountWebPushPath):
+		return routeWebPush
+	case path == apiAccountPath || strings.HasPrefix(path, apiAccountPath+"/"):
+		return routeAccount
+	default:
+		return routeOther
+	}
+}
+
+// trackingResponseWriter wraps a http.ResponseWriter to record the status code, the response size and the
+// time of the first written byte, see Server.handle. Writes may happen from other goroutines (e.g. subscriptions), hence the mutex.
+type trackingResponseWriter struct {
+	http.ResponseWriter
+	status    int
+	size      int64
+	firstByte time.Time
+	hijacked  bool
+	mu        sync.Mutex
+}
+
+func (w *trackingResponseWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	if w.status == 0 {
+		w.status = code
+		w.firstByte = time.Now()
+	}
+	w.mu.Unlock()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *trackingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.mu.Lock()
+	if w.status == 0 {
+		w.status = http.StatusOK
+		w.firstByte = time.Now()
+	}
+	w.size += int64(n)
+	w.mu.Unlock()
+	return n, err
+}
+
+func (w *trackingResponseWriter) Flush() {
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (w *trackingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	w.mu.Lock()
+	w.status = http.StatusSwitchingProtocols
+	w.firstByte = time.Now()
+	w.hijacked = true
+	w.mu.Unlock()
+	return hj.Hijack()
+}
+
+func (w *trackingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *trackingResponseWriter) stats() (status int, size int64, firstByte time.Time, hijacked bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status = w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return status, w.size, w.firstByte, w.hijacked
+}
+
+// trackingRequestBody wraps a request body to count the bytes read from it
+type trackingRequestBody struct {
+	io.ReadCloser
+	size int64
+}
+
+func (b *trackingRequestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	return n, err
+}
@@ -88,3 +88,46 @@ func TestMaybeDecodeHeaders(t *testing.T) {
 	r.Header.Set("X-Priority", "5") // ntfy priority header
 	require.Equal(t, "5", readHeaderParam(r, "x-priority", "priority", "p"))
 }
+
+func TestRenderMarkdown(t *testing.T) {
+	require.Equal(t, "<p>Look ma, <strong>bold text</strong>, <em>italics</em></p>", renderMarkdown("Look ma, **bold text**, *italics*"))
+	require.Equal(t, `<p><a href="https://ntfy.sh" rel="nofollow">some tool</a></p>`, renderMarkdown("[some tool](https://ntfy.sh)"))
+	require.Equal(t, "<p>Hi </p>", renderMarkdown("Hi <script>alert(1)</script>"))
+	require.NotContains(t, renderMarkdown("[click](javascript:alert(1))"), "javascript")
+}
+
+func TestRenderMarkdownExcerpt(t *testing.T) {
+	require.Equal(t, "<p><strong>short</strong></p>", renderMarkdownExcerpt("**short**", 100))
+	require.Equal(t, "<p>one <strong>two</strong> …</p>", renderMarkdownExcerpt("one **two** three four", 14))
+	require.Equal(t, "<p>äöü …</p>", renderMarkdownExcerpt("äöüäöü", 7))
+}
+
+func TestRequestRoute(t *testing.T) {
+	tests := []struct {
+		method, url, route string
+	}{
+		{"PUT", "/mytopic", routePublish},
+		{"POST", "/mytopic", routePublish},
+		{"POST", "/", routePublish},
+		{"GET", "/mytopic/publish?message=hi", routePublish},
+		{"POST", "/_matrix/push/v1/notify", routePublish},
+		{"GET", "/mytopic/json", routeSubscribe},
+		{"GET", "/mytopic,othertopic/sse", routeSubscribe},
+		{"GET", "/mytopic/ws", routeSubscribe},
+		{"GET", "/mytopic/json?poll=1", routePoll},
+		{"GET", "/mytopic/raw?po=1", routePoll},
+		{"GET", "/v1/account", routeAccount},
+		{"POST", "/v1/account/token", routeAccount},
+		{"GET", "/v1/account/webpush", routeWebPush},
+		{"POST", "/v1/webpush", routeWebPush},
+		{"POST", "/v1/webpush/renew", routeWebPush},
+		{"GET", "/v1/accounting", routeOther},
+		{"GET", "/mytopic", routeOther},
+		{"GET", "/v1/health", routeOther},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest(test.method, test.url, nil)
+		require.Nil(t, err)
+		require.Equal(t, test.route, requestRoute(r), "%s %s", test.method, test.url)
+	}
+}
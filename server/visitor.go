@@ -65,11 +65,28 @@ type visitor struct {
 	bandwidthLimiter    *util.RateLimiter  // Limiter for attachment bandwidth downloads
 	accountLimiter      *rate.Limiter      // Rate limiter for account creation, may be nil
 	authLimiter         *rate.Limiter      // Limiter for incorrect login attempts, may be nil
+	quotaWarnings       map[string]int     // Last quota warning threshold (in percent) per quota, see QuotaWarningThreshold
+	exemptUntil         time.Time          // Temporary exemption from request and message rate limiting, set by an admin
 	firebase            time.Time          // Next allowed Firebase message
 	seen                time.Time          // Last seen time of this visitor (needed for removal of stale visitors)
 	mu                  sync.RWMutex
 }
 
+// visitorStatus is a point-in-time snapshot of a visitor's rate limiters, see visitor.Status
+type visitorStatus struct {
+	ID                  string
+	IP                  netip.Addr
+	User                *user.User
+	Seen                time.Time
+	ExemptUntil         time.Time
+	RequestLimitBurst   int
+	RequestTokens       float64
+	Subscriptions       int64
+	SubscriptionLimit   int64
+	AttachmentBandwidth int64
+	Info                *visitorInfo
+}
+
 type visitorInfo struct {
 	Limits *visitorLimits
 	Stats  *visitorStats
@@ -90,6 +107,7 @@ type visitorLimits struct {
 	AttachmentFileSizeLimit  int64
 	AttachmentExpiryDuration time.Duration
 	AttachmentBandwidthLimit int64
+	MessageSizeLimit         int64
 }
 
 type visitorStats struct {
@@ -127,6 +145,7 @@ func newVisitor(conf *Config, messageCache *messageCache, userManager *user.Mana
 		userManager:         userManager, // May be nil
 		ip:                  ip,
 		user:                user,
+		quotaWarnings:       make(map[string]int),
 		firebase:            time.Unix(0, 0),
 		seen:                time.Now(),
 		subscriptionLimiter: util.NewFixedLimiter(int64(conf.VisitorSubscriptionLimit)),
@@ -177,6 +196,9 @@ func (v *visitor) contextNoLock() log.Context {
 	if v.user != nil {
 		fields["user_id"] = v.user.ID
 		fields["user_name"] = v.user.Name
+		if v.user.Impersonator != "" {
+			fields["user_impersonator"] = v.user.Impersonator
+		}
 		if v.user.Tier != nil {
 			for field, value := range v.user.Tier.Context() {
 				fields[field] = value
@@ -289,6 +311,26 @@ func (v *visitor) BandwidthAllowed(bytes int64) bool {
 	return v.bandwidthLimiter.AllowN(bytes)
 }
 
+// QuotaWarningThreshold records the current usage of the given quota, and returns the warning threshold (in percent)
+// if a new threshold was crossed since the last call, or 0 otherwise. If the usage drops (e.g. because the daily
+// stats were reset), the thresholds can be crossed again.
+func (v *visitor) QuotaWarningThreshold(quota string, used, limit int64) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	threshold := 0
+	for _, t := range quotaWarningThresholds {
+		if limit > 0 && used*100 >= limit*int64(t) {
+			threshold = t
+		}
+	}
+	previous := v.quotaWarnings[quota]
+	v.quotaWarnings[quota] = threshold
+	if threshold > previous {
+		return threshold
+	}
+	return 0
+}
+
 func (v *visitor) RemoveSubscription() {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
@@ -301,6 +343,50 @@ func (v *visitor) Keepalive() {
 	v.seen = time.Now()
 }
 
+// Exempt returns true if an admin temporarily exempted the visitor from request and message rate limiting
+func (v *visitor) Exempt() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return time.Now().Before(v.exemptUntil)
+}
+
+// SetExempt exempts the visitor from request and message rate limiting until the given time. A zero time
+// removes the exemption.
+func (v *visitor) SetExempt(until time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.exemptUntil = until
+}
+
+// ResetLimiters refills the request bucket and resets the message, e-mail, call and bandwidth counters of the
+// visitor, as if it had just been created. Active subscriptions are not affected.
+func (v *visitor) ResetLimiters() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.quotaWarnings = make(map[string]int)
+	v.resetLimitersNoLock(0, 0, 0, true)
+}
+
+// Status returns a snapshot of the visitor's rate limiters, e.g. to display them to an admin
+func (v *visitor) Status() *visitorStatus {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	info := v.infoLightNoLock()
+	return &visitorStatus{
+		ID:                  visitorID(v.ip, v.user),
+		IP:                  v.ip,
+		User:                v.user,
+		Seen:                v.seen,
+		ExemptUntil:         v.exemptUntil,
+		RequestLimitBurst:   info.Limits.RequestLimitBurst,
+		RequestTokens:       v.requestLimiter.Tokens(),
+		Subscriptions:       v.subscriptionLimiter.Value(),
+		SubscriptionLimit:   int64(v.config.VisitorSubscriptionLimit),
+		AttachmentBandwidth: v.bandwidthLimiter.Value(),
+		Info:                info,
+	}
+}
+
 func (v *visitor) BandwidthLimiter() util.Limiter {
 	v.mu.RLock() // limiters could be replaced!
 	defer v.mu.RUnlock()
@@ -378,6 +464,16 @@ func (v *visitor) MaybeUserID() string {
 	return ""
 }
 
+// Identity returns "user:<user ID>" for authenticated visitors, or "ip:<IP address>" for anonymous visitors. Unlike
+// visitorID, users without a tier are identified by their user ID, so that users behind the same IP address are told
+// apart, e.g. when counting polling clients (see topic.Polled) or reactions to a message.
+func (v *visitor) Identity() string {
+	if userID := v.MaybeUserID(); userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + v.IP().String()
+}
+
 func (v *visitor) resetLimitersNoLock(messages, emails, calls int64, enqueueUpdate bool) {
 	limits := v.limitsNoLock()
 	v.requestLimiter = rate.NewLimiter(limits.RequestLimitReplenish, limits.RequestLimitBurst)
@@ -416,6 +512,10 @@ func (v *visitor) limitsNoLock() *visitorLimits {
 }
 
 func tierBasedVisitorLimits(conf *Config, tier *user.Tier) *visitorLimits {
+	messageSizeLimit := int64(conf.MessageLimit)
+	if tier.MessageSizeLimit > 0 {
+		messageSizeLimit = tier.MessageSizeLimit
+	}
 	return &visitorLimits{
 		Basis:                    visitorLimitBasisTier,
 		RequestLimitBurst:        util.MinMax(int(float64(tier.MessageLimit)*visitorMessageToRequestLimitBurstRate), conf.VisitorRequestLimitBurst, visitorMessageToRequestLimitBurstMax),
@@ -431,6 +531,7 @@ func tierBasedVisitorLimits(conf *Config, tier *user.Tier) *visitorLimits {
 		AttachmentFileSizeLimit:  tier.AttachmentFileSizeLimit,
 		AttachmentExpiryDuration: tier.AttachmentExpiryDuration,
 		AttachmentBandwidthLimit: tier.AttachmentBandwidthLimit,
+		MessageSizeLimit:         messageSizeLimit,
 	}
 }
 
@@ -454,6 +555,7 @@ func configBasedVisitorLimits(conf *Config) *visitorLimits {
 		AttachmentFileSizeLimit:  conf.AttachmentFileSizeLimit,
 		AttachmentExpiryDuration: conf.AttachmentExpiryDuration,
 		AttachmentBandwidthLimit: conf.VisitorAttachmentDailyBandwidthLimit,
+		MessageSizeLimit:         int64(conf.MessageLimit),
 	}
 }
 
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Account lifecycle events, see webhookEvent
+const (
+	webhookEventAccountCreated     = "account.created"
+	webhookEventAccountDeleted     = "account.deleted"
+	webhookEventAccountTierChanged = "account.tier_changed"
+	webhookEventReservationCreated = "reservation.created"
+)
+
+const (
+	webhookEventIDPrefix     = "ev_"
+	webhookEventIDLength     = 16
+	webhookSignatureHeader   = "X-Ntfy-Signature"
+	webhookEventHeader       = "X-Ntfy-Event"
+	webhookRequestTimeout    = 10 * time.Second
+	webhookUserAgent         = "ntfy-webhook"
+	webhookSignatureVersion1 = "v1"
+)
+
+var (
+	// webhookEvents is the list of all supported account lifecycle events
+	webhookEvents = []string{
+		webhookEventAccountCreated,
+		webhookEventAccountDeleted,
+		webhookEventAccountTierChanged,
+		webhookEventReservationCreated,
+	}
+
+	// webhookRetryDelays defines how long to wait before re-attempting a failed webhook delivery
+	webhookRetryDelays = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute}
+)
+
+// webhookSender delivers account lifecycle events to the configured webhook URLs. Events are
+// delivered asynchronously, and failed deliveries (network errors, HTTP 429 and 5xx) are retried.
+type webhookSender struct {
+	config      *Config
+	client      *http.Client
+	retryDelays []time.Duration
+	closeChan   chan bool
+}
+
+func newWebhookSender(conf *Config) *webhookSender {
+	return &webhookSender{
+		config:      conf,
+		client:      &http.Client{Timeout: webhookRequestTimeout},
+		retryDelays: webhookRetryDelays,
+		closeChan:   make(chan bool),
+	}
+}
+
+// Send asynchronously delivers the event to all webhook URLs, if the event type is enabled
+func (w *webhookSender) Send(ev *webhookEvent) {
+	if len(w.config.AccountWebhookEvents) > 0 && !util.Contains(w.config.AccountWebhookEvents, ev.Event) {
+		return
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Tag(tagWebhook).Err(err).Warn("Cannot marshal webhook event %s", ev.Event)
+		return
+	}
+	for _, url := range w.config.AccountWebhookURLs {
+		go w.deliver(url, ev, body)
+	}
+}
+
+// Close stops all pending retries
+func (w *webhookSender) Close() {
+	close(w.closeChan)
+}
+
+func (w *webhookSender) deliver(url string, ev *webhookEvent, body []byte) {
+	logger := log.Tag(tagWebhook).Fields(ev.Context()).Field("webhook_url", url)
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(url, ev, body)
+		if err == nil {
+			logger.Debug("Delivered webhook event %s", ev.Event)
+			return
+		} else if !retry || attempt >= len(w.retryDelays) {
+			logger.Err(err).Warn("Failed to deliver webhook event %s after %d attempt(s), giving up", ev.Event, attempt+1)
+			return
+		}
+		logger.Err(err).Debug("Failed to deliver webhook event %s, retrying in %s", ev.Event, w.retryDelays[attempt])
+		select {
+		case <-time.After(w.retryDelays[attempt]):
+		case <-w.closeChan:
+			logger.Info("Server shutting down, dropping webhook event %s", ev.Event)
+			return
+		}
+	}
+}
+
+// post sends the event to the given URL once. It returns an error if the delivery failed, and
+// whether it makes sense to retry the delivery.
+func (w *webhookSender) post(url string, ev *webhookEvent, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set(webhookEventHeader, ev.Event)
+	if w.config.AccountWebhookSecret != "" {
+		req.Header.Set(webhookSignatureHeader, signWebhookPayload(w.config.AccountWebhookSecret, time.Now().Unix(), body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return true, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// signWebhookPayload returns the value for the signature header, in the format "t=<timestamp>,v1=<signature>",
+// where signature is the hex-encoded HMAC-SHA256 of "<timestamp>.<body>". Including the timestamp allows
+// receivers to reject replayed requests.
+func signWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.", timestamp)))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,%s=%s", timestamp, webhookSignatureVersion1, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func newWebhookEvent(event string, u *user.User) *webhookEvent {
+	return &webhookEvent{
+		ID:    util.RandomStringPrefix(webhookEventIDPrefix, webhookEventIDLength),
+		Event: event,
+		Time:  time.Now().Unix(),
+		User: &webhookUser{
+			ID:       u.ID,
+			Username: u.Name,
+		},
+	}
+}
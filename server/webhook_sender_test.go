@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+type testWebhookReceiver struct {
+	server   *httptest.Server
+	events   []*webhookEvent
+	headers  []http.Header
+	bodies   [][]byte
+	failures int32 // Number of requests to fail with HTTP 503 before succeeding
+	requests int32
+	mu       sync.Mutex
+}
+
+func newTestWebhookReceiver(t *testing.T) *testWebhookReceiver {
+	rcv := &testWebhookReceiver{}
+	rcv.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&rcv.requests, 1) <= atomic.LoadInt32(&rcv.failures) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		var ev webhookEvent
+		require.Nil(t, json.Unmarshal(body, &ev))
+		rcv.mu.Lock()
+		rcv.events = append(rcv.events, &ev)
+		rcv.headers = append(rcv.headers, r.Header.Clone())
+		rcv.bodies = append(rcv.bodies, body)
+		rcv.mu.Unlock()
+	}))
+	t.Cleanup(rcv.server.Close)
+	return rcv
+}
+
+func (rcv *testWebhookReceiver) Events() []*webhookEvent {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]*webhookEvent{}, rcv.events...)
+}
+
+func (rcv *testWebhookReceiver) waitForEvents(t *testing.T, count int) []*webhookEvent {
+	waitFor(t, func() bool {
+		return len(rcv.Events()) >= count
+	})
+	return rcv.Events()
+}
+
+func TestWebhookSender_Signature(t *testing.T) {
+	rcv := newTestWebhookReceiver(t)
+	conf := newTestConfig(t)
+	conf.AccountWebhookURLs = []string{rcv.server.URL}
+	conf.AccountWebhookSecret = "my-secret"
+	sender := newWebhookSender(conf)
+	defer sender.Close()
+
+	sender.Send(newWebhookEvent(webhookEventAccountCreated, &user.User{ID: "u_123", Name: "phil"}))
+	events := rcv.waitForEvents(t, 1)
+	require.Equal(t, webhookEventAccountCreated, events[0].Event)
+	require.Equal(t, "phil", events[0].User.Username)
+	require.True(t, strings.HasPrefix(events[0].ID, "ev_"))
+
+	rcv.mu.Lock()
+	header, body := rcv.headers[0], rcv.bodies[0]
+	rcv.mu.Unlock()
+	require.Equal(t, webhookEventAccountCreated, header.Get("X-Ntfy-Event"))
+	signature := header.Get("X-Ntfy-Signature")
+	timestampStr, _, _ := strings.Cut(strings.TrimPrefix(signature, "t="), ",")
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	require.Nil(t, err)
+	require.Equal(t, signWebhookPayload("my-secret", timestamp, body), signature)
+}
+
+func TestWebhookSender_Retry(t *testing.T) {
+	rcv := newTestWebhookReceiver(t)
+	rcv.failures = 2
+	conf := newTestConfig(t)
+	conf.AccountWebhookURLs = []string{rcv.server.URL}
+	sender := newWebhookSender(conf)
+	sender.retryDelays = []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond}
+	defer sender.Close()
+
+	sender.Send(newWebhookEvent(webhookEventAccountDeleted, &user.User{ID: "u_123", Name: "phil"}))
+	events := rcv.waitForEvents(t, 1)
+	require.Equal(t, webhookEventAccountDeleted, events[0].Event)
+	require.Equal(t, int32(3), atomic.LoadInt32(&rcv.requests))
+	require.Empty(t, rcv.headers[0].Get("X-Ntfy-Signature")) // No secret set
+}
+
+func TestWebhookSender_EventFilter(t *testing.T) {
+	rcv := newTestWebhookReceiver(t)
+	conf := newTestConfig(t)
+	conf.AccountWebhookURLs = []string{rcv.server.URL}
+	conf.AccountWebhookEvents = []string{webhookEventAccountDeleted}
+	sender := newWebhookSender(conf)
+	defer sender.Close()
+
+	sender.Send(newWebhookEvent(webhookEventAccountCreated, &user.User{ID: "u_123", Name: "phil"}))
+	sender.Send(newWebhookEvent(webhookEventAccountDeleted, &user.User{ID: "u_123", Name: "phil"}))
+	events := rcv.waitForEvents(t, 1)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 1, len(rcv.Events()))
+	require.Equal(t, webhookEventAccountDeleted, events[0].Event)
+}
+
+func TestServer_AccountWebhook_Signup_Reservation_Delete(t *testing.T) {
+	rcv := newTestWebhookReceiver(t)
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableSignup = true
+	conf.EnableReservations = true
+	conf.AccountWebhookURLs = []string{rcv.server.URL}
+	s := newTestServer(t, conf)
+
+	rr := request(t, s, "POST", "/v1/account", `{"username":"phil", "password":"mypass"}`, nil)
+	require.Equal(t, 200, rr.Code)
+	events := rcv.waitForEvents(t, 1)
+	require.Equal(t, webhookEventAccountCreated, events[0].Event)
+	require.Equal(t, "phil", events[0].User.Username)
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "pro", ReservationLimit: 2}))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic":"mytopic","everyone":"deny-all"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	events = rcv.waitForEvents(t, 2)
+	require.Equal(t, webhookEventReservationCreated, events[1].Event)
+	require.Equal(t, "mytopic", events[1].Reservation.Topic)
+	require.Equal(t, "deny-all", events[1].Reservation.Everyone)
+
+	// Updating an existing reservation does not send an event
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic":"mytopic","everyone":"read-only"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "DELETE", "/v1/account", `{"password":"mypass"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	forceAccountDeletion(t, s, "phil")
+	events = rcv.waitForEvents(t, 3)
+	require.Equal(t, webhookEventAccountDeleted, events[2].Event)
+	require.Equal(t, 3, len(events))
+}
+
+func TestServer_AccountWebhook_InvalidConfig(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AccountWebhookURLs = []string{"ftp://example.com"}
+	_, err := New(conf)
+	require.Error(t, err)
+
+	conf.AccountWebhookURLs = []string{"https://example.com"}
+	conf.AccountWebhookEvents = []string{"account.renamed"}
+	_, err = New(conf)
+	require.Error(t, err)
+}
+
+func TestServer_AccountWebhook_TierChanged(t *testing.T) {
+	rcv := newTestWebhookReceiver(t)
+	conf := newTestConfigWithAuthFile(t)
+	conf.AccountWebhookURLs = []string{rcv.server.URL}
+	s := newTestServer(t, conf)
+
+	s.sendTierChangedWebhookEvent(&user.User{ID: "u_123", Name: "phil", Tier: &user.Tier{Code: "basic"}}, &user.Tier{Code: "pro"})
+	events := rcv.waitForEvents(t, 1)
+	require.Equal(t, webhookEventAccountTierChanged, events[0].Event)
+	require.Equal(t, "basic", events[0].Tier.Old)
+	require.Equal(t, "pro", events[0].Tier.New)
+}
+
+func TestServer_AccountWebhook_TierChanged_AdminAddUser(t *testing.T) {
+	rcv := newTestWebhookReceiver(t)
+	conf := newTestConfigWithAuthFile(t)
+	conf.AccountWebhookURLs = []string{rcv.server.URL}
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "pro"}))
+
+	rr := request(t, s, "PUT", "/v1/users", `{"username": "ben", "password":"ben", "tier": "pro"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	events := rcv.waitForEvents(t, 2)
+	require.Equal(t, 2, len(events))
+	for _, ev := range events {
+		require.Equal(t, "ben", ev.User.Username)
+		if ev.Event == webhookEventAccountTierChanged {
+			require.Equal(t, "", ev.Tier.Old)
+			require.Equal(t, "pro", ev.Tier.New)
+		} else {
+			require.Equal(t, webhookEventAccountCreated, ev.Event)
+		}
+	}
+}
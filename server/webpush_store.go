@@ -1,10 +1,14 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/util"
 	"net/netip"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
@@ -19,7 +23,9 @@ const (
 var (
 	errWebPushNoRows               = errors.New("no rows found")
 	errWebPushTooManySubscriptions = errors.New("too many subscriptions")
+	errWebPushTooManyUserEndpoints = errors.New("too many subscriptions for this user")
 	errWebPushUserIDCannotBeEmpty  = errors.New("user ID cannot be empty")
+	errWebPushNoSubscriptions      = errors.New("no web push subscriptions for topic")
 )
 
 const (
@@ -32,11 +38,15 @@ const (
 			key_p256dh TEXT NOT NULL,
 			user_id TEXT NOT NULL,		
 			subscriber_ip TEXT NOT NULL,
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at INT NOT NULL DEFAULT 0,
 			updated_at INT NOT NULL,
-			warned_at INT NOT NULL DEFAULT 0
+			warned_at INT NOT NULL DEFAULT 0,
+			last_success_at INT NOT NULL DEFAULT 0
 		);
 		CREATE UNIQUE INDEX IF NOT EXISTS idx_endpoint ON subscription (endpoint);
 		CREATE INDEX IF NOT EXISTS idx_subscriber_ip ON subscription (subscriber_ip);
+		CREATE INDEX IF NOT EXISTS idx_user_id ON subscription (user_id);
 		CREATE TABLE IF NOT EXISTS subscription_topic (
 			subscription_id TEXT NOT NULL,
 			topic TEXT NOT NULL,
@@ -56,6 +66,7 @@ const (
 
 	selectWebPushSubscriptionIDByEndpoint        = `SELECT id FROM subscription WHERE endpoint = ?`
 	selectWebPushSubscriptionCountBySubscriberIP = `SELECT COUNT(*) FROM subscription WHERE subscriber_ip = ?`
+	selectWebPushSubscriptionCountByUserID       = `SELECT COUNT(*) FROM subscription WHERE user_id = ?`
 	selectWebPushSubscriptionsForTopicQuery      = `
 		SELECT id, endpoint, key_auth, key_p256dh, user_id
 		FROM subscription_topic st
@@ -63,38 +74,84 @@ const (
 		WHERE st.topic = ?
 		ORDER BY endpoint
 	`
+	selectWebPushSubscriptionsForUserQuery = `
+		SELECT s.id, s.user_agent, s.created_at, s.updated_at, s.last_success_at, IFNULL(GROUP_CONCAT(st.topic), '')
+		FROM subscription s
+		LEFT JOIN subscription_topic st ON s.id = st.subscription_id
+		WHERE s.user_id = ?
+		GROUP BY s.id
+		ORDER BY s.created_at, s.id
+	`
 	selectWebPushSubscriptionsExpiringSoonQuery = `
 		SELECT id, endpoint, key_auth, key_p256dh, user_id 
 		FROM subscription 
 		WHERE warned_at = 0 AND updated_at <= ?
 	`
+	selectWebPushTopicsActiveQuery = `
+		SELECT DISTINCT st.topic
+		FROM subscription_topic st
+		JOIN subscription s ON s.id = st.subscription_id
+		WHERE s.updated_at >= ?
+	`
+	selectWebPushTopicsInactiveQuery = `
+		SELECT st.topic
+		FROM subscription_topic st
+		JOIN subscription s ON s.id = st.subscription_id
+		GROUP BY st.topic
+		HAVING MAX(s.updated_at) < ?
+	`
 	insertWebPushSubscriptionQuery = `
-		INSERT INTO subscription (id, endpoint, key_auth, key_p256dh, user_id, subscriber_ip, updated_at, warned_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO subscription (id, endpoint, key_auth, key_p256dh, user_id, subscriber_ip, user_agent, created_at, updated_at, warned_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT (endpoint) 
-		DO UPDATE SET key_auth = excluded.key_auth, key_p256dh = excluded.key_p256dh, user_id = excluded.user_id, subscriber_ip = excluded.subscriber_ip, updated_at = excluded.updated_at, warned_at = excluded.warned_at
+		DO UPDATE SET key_auth = excluded.key_auth, key_p256dh = excluded.key_p256dh, user_id = excluded.user_id, subscriber_ip = excluded.subscriber_ip, user_agent = excluded.user_agent, updated_at = excluded.updated_at, warned_at = excluded.warned_at
 	`
 	updateWebPushSubscriptionWarningSentQuery = `UPDATE subscription SET warned_at = ? WHERE id = ?`
+	updateWebPushSubscriptionRenewQuery       = `UPDATE subscription SET updated_at = ?, warned_at = 0 WHERE endpoint = ?`
+	updateWebPushSubscriptionSuccessQuery     = `UPDATE subscription SET last_success_at = ? WHERE id = ?`
 	deleteWebPushSubscriptionByEndpointQuery  = `DELETE FROM subscription WHERE endpoint = ?`
+	deleteWebPushSubscriptionByIDAndUserQuery = `DELETE FROM subscription WHERE id = ? AND user_id = ?`
 	deleteWebPushSubscriptionByUserIDQuery    = `DELETE FROM subscription WHERE user_id = ?`
-	deleteWebPushSubscriptionByAgeQuery       = `DELETE FROM subscription WHERE updated_at <= ?` // Full table scan!
+	deleteWebPushSubscriptionByAgeQuery       = `DELETE FROM subscription WHERE updated_at <= ?`                  // Full table scan!
+	deleteWebPushSubscriptionByWarningQuery   = `DELETE FROM subscription WHERE warned_at > 0 AND warned_at <= ?` // Full table scan!
 
 	insertWebPushSubscriptionTopicQuery    = `INSERT INTO subscription_topic (subscription_id, topic) VALUES (?, ?)`
 	deleteWebPushSubscriptionTopicAllQuery = `DELETE FROM subscription_topic WHERE subscription_id = ?`
+	deleteWebPushSubscriptionTopicQuery    = `DELETE FROM subscription_topic WHERE topic = ?`
+	deleteWebPushSubscriptionTopicPrefix   = `DELETE FROM subscription_topic WHERE SUBSTR(topic, 1, LENGTH(?)) = ?`
 )
 
 // Schema management queries
 const (
-	currentWebPushSchemaVersion     = 1
+	currentWebPushSchemaVersion     = 2
 	insertWebPushSchemaVersion      = `INSERT INTO schemaVersion VALUES (1, ?)`
+	updateWebPushSchemaVersion      = `UPDATE schemaVersion SET version = ? WHERE id = 1`
 	selectWebPushSchemaVersionQuery = `SELECT version FROM schemaVersion WHERE id = 1`
 )
 
+// 1 -> 2
+const (
+	webPushMigrate1To2AlterSubscriptionTableQuery = `
+		ALTER TABLE subscription ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+		ALTER TABLE subscription ADD COLUMN created_at INT NOT NULL DEFAULT 0;
+		ALTER TABLE subscription ADD COLUMN last_success_at INT NOT NULL DEFAULT 0;
+		UPDATE subscription SET created_at = updated_at;
+		CREATE INDEX IF NOT EXISTS idx_user_id ON subscription (user_id);
+	`
+)
+
+var (
+	webPushMigrations = map[int]func(db *sql.DB) error{
+		1: webPushMigrateFrom1,
+	}
+)
+
 type webPushStore struct {
-	db *sql.DB
+	db                    *sql.DB
+	userSubscriptionLimit int // Max. number of endpoints per user, 0 = no limit
 }
 
-func newWebPushStore(filename, startupQueries string) (*webPushStore, error) {
+func newWebPushStore(filename, startupQueries string, userSubscriptionLimit int) (*webPushStore, error) {
 	db, err := sql.Open("sqlite3", filename)
 	if err != nil {
 		return nil, err
@@ -106,7 +163,8 @@ func newWebPushStore(filename, startupQueries string) (*webPushStore, error) {
 		return nil, err
 	}
 	return &webPushStore{
-		db: db,
+		db:                    db,
+		userSubscriptionLimit: userSubscriptionLimit,
 	}, nil
 }
 
@@ -116,7 +174,31 @@ func setupWebPushDB(db *sql.DB) error {
 	if err != nil {
 		return setupNewWebPushDB(db)
 	}
-	return rows.Close()
+	defer rows.Close()
+	schemaVersion := 0
+	if !rows.Next() {
+		return errors.New("cannot determine schema version: web push database may be corrupt")
+	}
+	if err := rows.Scan(&schemaVersion); err != nil {
+		return err
+	}
+	rows.Close()
+
+	// Do migrations
+	if schemaVersion == currentWebPushSchemaVersion {
+		return nil
+	} else if schemaVersion > currentWebPushSchemaVersion {
+		return fmt.Errorf("unexpected schema version: version %d is higher than current version %d", schemaVersion, currentWebPushSchemaVersion)
+	}
+	for i := schemaVersion; i < currentWebPushSchemaVersion; i++ {
+		fn, ok := webPushMigrations[i]
+		if !ok {
+			return fmt.Errorf("cannot find migration step from schema version %d to %d", i, i+1)
+		} else if err := fn(db); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func setupNewWebPushDB(db *sql.DB) error {
@@ -141,7 +223,7 @@ func runWebPushStartupQueries(db *sql.DB, startupQueries string) error {
 
 // UpsertSubscription adds or updates Web Push subscriptions for the given topics and user ID. It always first deletes all
 // existing entries for a given endpoint.
-func (c *webPushStore) UpsertSubscription(endpoint string, auth, p256dh, userID string, subscriberIP netip.Addr, topics []string) error {
+func (c *webPushStore) UpsertSubscription(endpoint string, auth, p256dh, userID string, subscriberIP netip.Addr, userAgent string, topics []string) error {
 	tx, err := c.db.Begin()
 	if err != nil {
 		return err
@@ -163,6 +245,13 @@ func (c *webPushStore) UpsertSubscription(endpoint string, auth, p256dh, userID
 	if err := rowsCount.Close(); err != nil {
 		return err
 	}
+	// Read number of subscriptions for user
+	var userSubscriptionCount int
+	if userID != "" && c.userSubscriptionLimit > 0 {
+		if err := tx.QueryRow(selectWebPushSubscriptionCountByUserID, userID).Scan(&userSubscriptionCount); err != nil {
+			return err
+		}
+	}
 	// Read existing subscription ID for endpoint (or create new ID)
 	rows, err := tx.Query(selectWebPushSubscriptionIDByEndpoint, endpoint)
 	if err != nil {
@@ -177,6 +266,8 @@ func (c *webPushStore) UpsertSubscription(endpoint string, auth, p256dh, userID
 	} else {
 		if subscriptionCount >= subscriptionEndpointLimitPerSubscriberIP {
 			return errWebPushTooManySubscriptions
+		} else if userID != "" && c.userSubscriptionLimit > 0 && userSubscriptionCount >= c.userSubscriptionLimit {
+			return errWebPushTooManyUserEndpoints
 		}
 		subscriptionID = util.RandomStringPrefix(subscriptionIDPrefix, subscriptionIDLength)
 	}
@@ -185,7 +276,7 @@ func (c *webPushStore) UpsertSubscription(endpoint string, auth, p256dh, userID
 	}
 	// Insert or update subscription
 	updatedAt, warnedAt := time.Now().Unix(), 0
-	if _, err = tx.Exec(insertWebPushSubscriptionQuery, subscriptionID, endpoint, auth, p256dh, userID, subscriberIP.String(), updatedAt, warnedAt); err != nil {
+	if _, err = tx.Exec(insertWebPushSubscriptionQuery, subscriptionID, endpoint, auth, p256dh, userID, subscriberIP.String(), userAgent, updatedAt, updatedAt, warnedAt); err != nil {
 		return err
 	}
 	// Replace all subscription topics
@@ -220,6 +311,83 @@ func (c *webPushStore) SubscriptionsExpiring(warnAfter time.Duration) ([]*webPus
 	return c.subscriptionsFromRows(rows)
 }
 
+// TopicsActive returns all topics that have at least one subscription that was updated (i.e. renewed by the
+// browser) since the given time
+func (c *webPushStore) TopicsActive(since time.Time) ([]string, error) {
+	return c.topicsFromQuery(selectWebPushTopicsActiveQuery, since.Unix())
+}
+
+// TopicsInactive returns all topics whose subscriptions have not been updated since the given time
+func (c *webPushStore) TopicsInactive(since time.Time) ([]string, error) {
+	return c.topicsFromQuery(selectWebPushTopicsInactiveQuery, since.Unix())
+}
+
+func (c *webPushStore) topicsFromQuery(query string, args ...any) ([]string, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	topics := make([]string, 0)
+	for rows.Next() {
+		var topic string
+		if err := rows.Scan(&topic); err != nil {
+			return nil, err
+		}
+		topics = append(topics, topic)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+// SubscriptionsForUser returns all subscriptions of the given user, including the topics they are subscribed to
+func (c *webPushStore) SubscriptionsForUser(userID string) ([]*webPushUserSubscription, error) {
+	rows, err := c.db.Query(selectWebPushSubscriptionsForUserQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	subscriptions := make([]*webPushUserSubscription, 0)
+	for rows.Next() {
+		var id, userAgent, topics string
+		var createdAt, updatedAt, lastSuccessAt int64
+		if err := rows.Scan(&id, &userAgent, &createdAt, &updatedAt, &lastSuccessAt, &topics); err != nil {
+			return nil, err
+		}
+		subscription := &webPushUserSubscription{
+			ID:          id,
+			UserAgent:   userAgent,
+			Created:     createdAt,
+			Updated:     updatedAt,
+			LastSuccess: lastSuccessAt,
+			Topics:      make([]string, 0),
+		}
+		if topics != "" {
+			subscription.Topics = strings.Split(topics, ",")
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// MarkSuccess records the time of the last successful delivery for the given subscriptions
+func (c *webPushStore) MarkSuccess(subscriptions []*webPushSubscription) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	for _, subscription := range subscriptions {
+		if _, err := tx.Exec(updateWebPushSubscriptionSuccessQuery, now, subscription.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // MarkExpiryWarningSent marks the given subscriptions as having received a warning about expiring soon
 func (c *webPushStore) MarkExpiryWarningSent(subscriptions []*webPushSubscription) error {
 	tx, err := c.db.Begin()
@@ -235,6 +403,22 @@ func (c *webPushStore) MarkExpiryWarningSent(subscriptions []*webPushSubscriptio
 	return tx.Commit()
 }
 
+// RenewSubscription marks the subscription for the given endpoint as updated, and resets the expiry warning (or ping).
+// It returns errWebPushNoRows if the subscription does not exist.
+func (c *webPushStore) RenewSubscription(endpoint string) error {
+	result, err := c.db.Exec(updateWebPushSubscriptionRenewQuery, time.Now().Unix(), endpoint)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return errWebPushNoRows
+	}
+	return nil
+}
+
 func (c *webPushStore) subscriptionsFromRows(rows *sql.Rows) ([]*webPushSubscription, error) {
 	subscriptions := make([]*webPushSubscription, 0)
 	for rows.Next() {
@@ -259,6 +443,25 @@ func (c *webPushStore) RemoveSubscriptionsByEndpoint(endpoint string) error {
 	return err
 }
 
+// RemoveSubscriptionByIDForUser removes the subscription with the given ID, if it belongs to the given user.
+// It returns errWebPushNoRows if no such subscription exists.
+func (c *webPushStore) RemoveSubscriptionByIDForUser(id, userID string) error {
+	if userID == "" {
+		return errWebPushUserIDCannotBeEmpty
+	}
+	result, err := c.db.Exec(deleteWebPushSubscriptionByIDAndUserQuery, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return errWebPushNoRows
+	}
+	return nil
+}
+
 // RemoveSubscriptionsByUserID removes all subscriptions for the given user ID
 func (c *webPushStore) RemoveSubscriptionsByUserID(userID string) error {
 	if userID == "" {
@@ -268,13 +471,56 @@ func (c *webPushStore) RemoveSubscriptionsByUserID(userID string) error {
 	return err
 }
 
+// RemoveSubscriptionsByTopic removes the given topic from all subscriptions. Subscriptions themselves
+// are not removed, even if they have no topics left; they will expire eventually.
+func (c *webPushStore) RemoveSubscriptionsByTopic(topic string) error {
+	_, err := c.db.Exec(deleteWebPushSubscriptionTopicQuery, topic)
+	return err
+}
+
+// RemoveSubscriptionsByTopicPrefix removes all topics starting with the given prefix from all subscriptions,
+// see RemoveSubscriptionsByTopic
+func (c *webPushStore) RemoveSubscriptionsByTopicPrefix(prefix string) error {
+	_, err := c.db.Exec(deleteWebPushSubscriptionTopicPrefix, prefix, prefix)
+	return err
+}
+
 // RemoveExpiredSubscriptions removes all subscriptions that have not been updated for a given time period
 func (c *webPushStore) RemoveExpiredSubscriptions(expireAfter time.Duration) error {
 	_, err := c.db.Exec(deleteWebPushSubscriptionByAgeQuery, time.Now().Add(-expireAfter).Unix())
 	return err
 }
 
+// RemoveUnresponsiveSubscriptions removes all subscriptions that were sent an expiry warning (or ping) more than
+// the given time period ago, and have not been renewed since
+func (c *webPushStore) RemoveUnresponsiveSubscriptions(timeout time.Duration) error {
+	_, err := c.db.Exec(deleteWebPushSubscriptionByWarningQuery, time.Now().Add(-timeout).Unix())
+	return err
+}
+
 // Close closes the underlying database connection
+// Ping checks that the underlying database is reachable and readable
+func (c *webPushStore) Ping(ctx context.Context) error {
+	var version int
+	return c.db.QueryRowContext(ctx, selectWebPushSchemaVersionQuery).Scan(&version)
+}
+
 func (c *webPushStore) Close() error {
 	return c.db.Close()
 }
+
+func webPushMigrateFrom1(db *sql.DB) error {
+	log.Tag(tagWebPush).Info("Migrating web push database schema: from 1 to 2")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(webPushMigrate1To2AlterSubscriptionTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateWebPushSchemaVersion, 2); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
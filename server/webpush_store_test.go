@@ -1,6 +1,7 @@
 package server
 
 import (
+	"database/sql"
 	"fmt"
 	"github.com/stretchr/testify/require"
 	"net/netip"
@@ -13,7 +14,7 @@ func TestWebPushStore_UpsertSubscription_SubscriptionsForTopic(t *testing.T) {
 	webPush := newTestWebPushStore(t)
 	defer webPush.Close()
 
-	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "", []string{"test-topic", "mytopic"}))
 
 	subs, err := webPush.SubscriptionsForTopic("test-topic")
 	require.Nil(t, err)
@@ -36,17 +37,17 @@ func TestWebPushStore_UpsertSubscription_SubscriberIPLimitReached(t *testing.T)
 	// Insert 10 subscriptions with the same IP address
 	for i := 0; i < 10; i++ {
 		endpoint := fmt.Sprintf(testWebPushEndpoint+"%d", i)
-		require.Nil(t, webPush.UpsertSubscription(endpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}))
+		require.Nil(t, webPush.UpsertSubscription(endpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "", []string{"test-topic", "mytopic"}))
 	}
 
 	// Another one for the same endpoint should be fine
-	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "", []string{"test-topic", "mytopic"}))
 
 	// But with a different endpoint it should fail
-	require.Equal(t, errWebPushTooManySubscriptions, webPush.UpsertSubscription(testWebPushEndpoint+"11", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}))
+	require.Equal(t, errWebPushTooManySubscriptions, webPush.UpsertSubscription(testWebPushEndpoint+"11", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "", []string{"test-topic", "mytopic"}))
 
 	// But with a different IP address it should be fine again
-	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"99", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("9.9.9.9"), []string{"test-topic", "mytopic"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"99", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("9.9.9.9"), "", []string{"test-topic", "mytopic"}))
 }
 
 func TestWebPushStore_UpsertSubscription_UpdateTopics(t *testing.T) {
@@ -54,8 +55,8 @@ func TestWebPushStore_UpsertSubscription_UpdateTopics(t *testing.T) {
 	defer webPush.Close()
 
 	// Insert subscription with two topics, and another with one topic
-	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
-	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"1", "auth-key", "p256dh-key", "", netip.MustParseAddr("9.9.9.9"), []string{"topic1"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "", []string{"topic1", "topic2"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"1", "auth-key", "p256dh-key", "", netip.MustParseAddr("9.9.9.9"), "", []string{"topic1"}))
 
 	subs, err := webPush.SubscriptionsForTopic("topic1")
 	require.Nil(t, err)
@@ -69,7 +70,7 @@ func TestWebPushStore_UpsertSubscription_UpdateTopics(t *testing.T) {
 	require.Equal(t, testWebPushEndpoint+"0", subs[0].Endpoint)
 
 	// Update the first subscription to have only one topic
-	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "", []string{"topic1"}))
 
 	subs, err = webPush.SubscriptionsForTopic("topic1")
 	require.Nil(t, err)
@@ -86,7 +87,7 @@ func TestWebPushStore_RemoveSubscriptionsByEndpoint(t *testing.T) {
 	defer webPush.Close()
 
 	// Insert subscription with two topics
-	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "", []string{"topic1", "topic2"}))
 	subs, err := webPush.SubscriptionsForTopic("topic1")
 	require.Nil(t, err)
 	require.Len(t, subs, 1)
@@ -103,7 +104,7 @@ func TestWebPushStore_RemoveSubscriptionsByUserID(t *testing.T) {
 	defer webPush.Close()
 
 	// Insert subscription with two topics
-	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "", []string{"topic1", "topic2"}))
 	subs, err := webPush.SubscriptionsForTopic("topic1")
 	require.Nil(t, err)
 	require.Len(t, subs, 1)
@@ -126,7 +127,7 @@ func TestWebPushStore_MarkExpiryWarningSent(t *testing.T) {
 	defer webPush.Close()
 
 	// Insert subscription with two topics
-	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "", []string{"topic1", "topic2"}))
 	subs, err := webPush.SubscriptionsForTopic("topic1")
 	require.Nil(t, err)
 	require.Len(t, subs, 1)
@@ -150,7 +151,7 @@ func TestWebPushStore_SubscriptionsExpiring(t *testing.T) {
 	defer webPush.Close()
 
 	// Insert subscription with two topics
-	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "", []string{"topic1", "topic2"}))
 	subs, err := webPush.SubscriptionsForTopic("topic1")
 	require.Nil(t, err)
 	require.Len(t, subs, 1)
@@ -174,7 +175,7 @@ func TestWebPushStore_RemoveExpiredSubscriptions(t *testing.T) {
 	defer webPush.Close()
 
 	// Insert subscription with two topics
-	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "", []string{"topic1", "topic2"}))
 	subs, err := webPush.SubscriptionsForTopic("topic1")
 	require.Nil(t, err)
 	require.Len(t, subs, 1)
@@ -192,8 +193,107 @@ func TestWebPushStore_RemoveExpiredSubscriptions(t *testing.T) {
 	require.Len(t, subs, 0)
 }
 
+func TestWebPushStore_UpsertSubscription_UserLimitReached(t *testing.T) {
+	webPush, err := newWebPushStore(filepath.Join(t.TempDir(), "webpush.db"), "", 2)
+	require.Nil(t, err)
+	defer webPush.Close()
+
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "Firefox", []string{"topic1", "topic2"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"1", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("9.9.9.9"), "Chrome", []string{}))
+
+	// Updating an existing endpoint is fine, and other users or anonymous subscriptions are not affected
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"1", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("9.9.9.9"), "Chrome", []string{"topic1"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"2", "auth-key", "p256dh-key", "u_5678", netip.MustParseAddr("1.2.3.4"), "Edge", []string{"topic1"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"3", "auth-key", "p256dh-key", "", netip.MustParseAddr("1.2.3.4"), "Safari", []string{"topic1"}))
+	require.Equal(t, errWebPushTooManyUserEndpoints, webPush.UpsertSubscription(testWebPushEndpoint+"4", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "Firefox", []string{"topic1"}))
+}
+
+func TestWebPushStore_SubscriptionsForUser_RemoveSubscriptionByIDForUser(t *testing.T) {
+	webPush := newTestWebPushStore(t)
+	defer webPush.Close()
+
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "Firefox", []string{"topic1", "topic2"}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"1", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), "Chrome", []string{}))
+	require.Nil(t, webPush.UpsertSubscription(testWebPushEndpoint+"2", "auth-key", "p256dh-key", "u_5678", netip.MustParseAddr("1.2.3.4"), "Edge", []string{"topic1"}))
+
+	// Mark first subscription as successfully delivered
+	subs, err := webPush.SubscriptionsForTopic("topic2")
+	require.Nil(t, err)
+	require.Nil(t, webPush.MarkSuccess(subs))
+
+	userSubs, err := webPush.SubscriptionsForUser("u_1234")
+	require.Nil(t, err)
+	require.Len(t, userSubs, 2)
+	firefox, chrome := userSubs[0], userSubs[1]
+	if firefox.UserAgent != "Firefox" {
+		firefox, chrome = chrome, firefox
+	}
+	require.Equal(t, subs[0].ID, firefox.ID)
+	require.ElementsMatch(t, []string{"topic1", "topic2"}, firefox.Topics)
+	require.Greater(t, firefox.Created, int64(0))
+	require.Equal(t, firefox.Created, firefox.Updated)
+	require.Greater(t, firefox.LastSuccess, int64(0))
+	require.Equal(t, "Chrome", chrome.UserAgent)
+	require.Equal(t, []string{}, chrome.Topics)
+	require.Equal(t, int64(0), chrome.LastSuccess)
+
+	// Subscriptions of other users cannot be removed
+	require.Equal(t, errWebPushNoRows, webPush.RemoveSubscriptionByIDForUser(firefox.ID, "u_5678"))
+	require.Nil(t, webPush.RemoveSubscriptionByIDForUser(firefox.ID, "u_1234"))
+	userSubs, err = webPush.SubscriptionsForUser("u_1234")
+	require.Nil(t, err)
+	require.Len(t, userSubs, 1)
+	require.Equal(t, chrome.ID, userSubs[0].ID)
+}
+
+func TestWebPushStore_MigrateFrom1(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "webpush.db")
+	db, err := sql.Open("sqlite3", filename)
+	require.Nil(t, err)
+	_, err = db.Exec(`
+		CREATE TABLE subscription (
+			id TEXT PRIMARY KEY,
+			endpoint TEXT NOT NULL,
+			key_auth TEXT NOT NULL,
+			key_p256dh TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			subscriber_ip TEXT NOT NULL,
+			updated_at INT NOT NULL,
+			warned_at INT NOT NULL DEFAULT 0
+		);
+		CREATE UNIQUE INDEX idx_endpoint ON subscription (endpoint);
+		CREATE TABLE subscription_topic (
+			subscription_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			PRIMARY KEY (subscription_id, topic)
+		);
+		CREATE TABLE schemaVersion (id INT PRIMARY KEY, version INT NOT NULL);
+		INSERT INTO schemaVersion VALUES (1, 1);
+		INSERT INTO subscription VALUES ('wps_1234567890', 'https://updates.push.services.mozilla.com/wpush/v1/abc', 'auth', 'p256dh', 'u_1234', '1.2.3.4', 1700000000, 0);
+		INSERT INTO subscription_topic VALUES ('wps_1234567890', 'mytopic');
+	`)
+	require.Nil(t, err)
+	require.Nil(t, db.Close())
+
+	webPush, err := newWebPushStore(filename, "", 0)
+	require.Nil(t, err)
+	defer webPush.Close()
+
+	userSubs, err := webPush.SubscriptionsForUser("u_1234")
+	require.Nil(t, err)
+	require.Len(t, userSubs, 1)
+	require.Equal(t, "wps_1234567890", userSubs[0].ID)
+	require.Equal(t, "", userSubs[0].UserAgent)
+	require.Equal(t, int64(1700000000), userSubs[0].Created)
+	require.Equal(t, []string{"mytopic"}, userSubs[0].Topics)
+
+	var version int
+	require.Nil(t, webPush.db.QueryRow(selectWebPushSchemaVersionQuery).Scan(&version))
+	require.Equal(t, currentWebPushSchemaVersion, version)
+}
+
 func newTestWebPushStore(t *testing.T) *webPushStore {
-	webPush, err := newWebPushStore(filepath.Join(t.TempDir(), "webpush.db"), "")
+	webPush, err := newWebPushStore(filepath.Join(t.TempDir(), "webpush.db"), "", 0)
 	require.Nil(t, err)
 	return webPush
 }
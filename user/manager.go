@@ -2,7 +2,10 @@
 package user
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,15 +23,23 @@ import (
 const (
 	tierIDPrefix                    = "ti_"
 	tierIDLength                    = 8
+	groupIDPrefix                   = "gr_"
+	groupIDLength                   = 12
 	syncTopicPrefix                 = "st_"
 	syncTopicLength                 = 16
 	userIDPrefix                    = "u_"
 	userIDLength                    = 12
 	userAuthIntentionalSlowDownHash = "$2a$10$YFCQvqQDwIIwnJM1xkAYOeih0dg17UVGanaTStnrSzC8NCWxcLDwy" // Cost should match DefaultUserPasswordBcryptCost
 	userHardDeleteAfterDuration     = 7 * 24 * time.Hour
+	userChangeRetention             = 7 * 24 * time.Hour // Standby servers that are further behind do a full sync
+	changeKindUser                  = "user"             // Change log entry for a user, its access control entries, tokens and publish keys
+	changeKindGroup                 = "group"            // Change log entry for a group, its members and access control entries
+	changeKindTier                  = "tier"             // Change log entry for a tier
 	tokenPrefix                     = "tk_"
 	tokenLength                     = 32
 	tokenMaxCount                   = 20 // Only keep this many tokens in the table per user
+	publishKeyLength                = 32
+	publishKeyMaxCount              = 20 // Maximum number of publish keys per reserved topic
 	tag                             = "user_manager"
 )
 
@@ -39,9 +50,10 @@ const (
 )
 
 var (
-	errNoTokenProvided    = errors.New("no token provided")
-	errTopicOwnedByOthers = errors.New("topic owned by others")
-	errNoRows             = errors.New("no rows found")
+	errNoTokenProvided        = errors.New("no token provided")
+	errNoImpersonatorProvided = errors.New("no impersonator provided")
+	errTopicOwnedByOthers     = errors.New("topic owned by others")
+	errNoRows                 = errors.New("no rows found")
 )
 
 // Manager-related queries
@@ -61,8 +73,10 @@ const (
 			attachment_total_size_limit INT NOT NULL,
 			attachment_expiry_duration INT NOT NULL,
 			attachment_bandwidth_limit INT NOT NULL,
+			message_size_limit INT NOT NULL DEFAULT (0),
 			stripe_monthly_price_id TEXT,
-			stripe_yearly_price_id TEXT
+			stripe_yearly_price_id TEXT,
+			provisioned INT NOT NULL DEFAULT (0)
 		);
 		CREATE UNIQUE INDEX idx_tier_code ON tier (code);
 		CREATE UNIQUE INDEX idx_tier_stripe_monthly_price_id ON tier (stripe_monthly_price_id);
@@ -86,6 +100,9 @@ const (
 			stripe_subscription_cancel_at INT,
 			created INT NOT NULL,
 			deleted INT,
+			disabled INT NOT NULL DEFAULT (0),
+			provisioned INT NOT NULL DEFAULT (0),
+			deletion_scheduled INT,
 		    FOREIGN KEY (tier_id) REFERENCES tier (id)
 		);
 		CREATE UNIQUE INDEX idx_user ON user (user);
@@ -96,7 +113,16 @@ const (
 			topic TEXT NOT NULL,
 			read INT NOT NULL,
 			write INT NOT NULL,
+			auth_write INT NOT NULL DEFAULT (0),
 			owner_user_id INT,
+			provisioned INT NOT NULL DEFAULT (0),
+			last_active INT NOT NULL DEFAULT (0),
+			inactivity_warned_at INT NOT NULL DEFAULT (0),
+			sound TEXT NOT NULL DEFAULT (''),
+			routing TEXT NOT NULL DEFAULT (''),
+			escalation TEXT NOT NULL DEFAULT (''),
+			schedule TEXT NOT NULL DEFAULT (''),
+			policy TEXT NOT NULL DEFAULT (''),
 			PRIMARY KEY (user_id, topic),
 			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE,
 		    FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
@@ -108,6 +134,9 @@ const (
 			last_access INT NOT NULL,
 			last_origin TEXT NOT NULL,
 			expires INT NOT NULL,
+			provisioned INT NOT NULL DEFAULT (0),
+			allowed_ips TEXT NOT NULL DEFAULT (''),
+			impersonator TEXT NOT NULL DEFAULT (''),
 			PRIMARY KEY (user_id, token),
 			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
 		);
@@ -117,6 +146,136 @@ const (
 			PRIMARY KEY (user_id, phone_number),
 			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
 		);
+		CREATE TABLE IF NOT EXISTS user_publish_key (
+			user_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			publish_key TEXT NOT NULL,
+			label TEXT NOT NULL,
+			created INT NOT NULL,
+			title_prefix TEXT NOT NULL DEFAULT (''),
+			priority INT NOT NULL DEFAULT (0),
+			tags TEXT NOT NULL DEFAULT (''),
+			PRIMARY KEY (publish_key),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX idx_user_publish_key_user_id_topic ON user_publish_key (user_id, topic);
+		CREATE TABLE IF NOT EXISTS user_group (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE UNIQUE INDEX idx_user_group_name ON user_group (name);
+		CREATE TABLE IF NOT EXISTS user_group_member (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_group_access (
+			group_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			owner_user_id TEXT,
+			PRIMARY KEY (group_id, topic),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_change (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL DEFAULT ('user'),
+			user TEXT NOT NULL,
+			time INT NOT NULL
+		);
+		CREATE TRIGGER user_change_insert AFTER INSERT ON user
+		BEGIN
+			INSERT INTO user_change (user, time) VALUES (NEW.user, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_change_update AFTER UPDATE OF user, pass, role, tier_id, sync_topic, deleted, disabled, provisioned ON user
+		BEGIN
+			INSERT INTO user_change (user, time) VALUES (NEW.user, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_change_delete AFTER DELETE ON user
+		BEGIN
+			INSERT INTO user_change (user, time) VALUES (OLD.user, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_access_change_insert AFTER INSERT ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_access_change_update AFTER UPDATE OF read, write, auth_write, owner_user_id, sound, routing, escalation, schedule, policy, provisioned ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_access_change_delete AFTER DELETE ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = OLD.user_id;
+		END;
+		CREATE TRIGGER user_token_change_insert AFTER INSERT ON user_token
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_token_change_update AFTER UPDATE OF label, expires, provisioned ON user_token
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_token_change_delete AFTER DELETE ON user_token
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = OLD.user_id;
+		END;
+		CREATE TRIGGER user_publish_key_change_insert AFTER INSERT ON user_publish_key
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_publish_key_change_update AFTER UPDATE OF topic, label, title_prefix, priority, tags ON user_publish_key
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_publish_key_change_delete AFTER DELETE ON user_publish_key
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = OLD.user_id;
+		END;
+		CREATE TRIGGER user_group_change_insert AFTER INSERT ON user_group
+		BEGIN
+			INSERT INTO user_change (kind, user, time) VALUES ('group', NEW.name, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_group_change_delete AFTER DELETE ON user_group
+		BEGIN
+			INSERT INTO user_change (kind, user, time) VALUES ('group', OLD.name, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_group_member_change_insert AFTER INSERT ON user_group_member
+		BEGIN
+			INSERT INTO user_change (kind, user, time) SELECT 'group', name, UNIXEPOCH() FROM user_group WHERE id = NEW.group_id;
+		END;
+		CREATE TRIGGER user_group_member_change_delete AFTER DELETE ON user_group_member
+		BEGIN
+			INSERT INTO user_change (kind, user, time) SELECT 'group', name, UNIXEPOCH() FROM user_group WHERE id = OLD.group_id;
+		END;
+		CREATE TRIGGER user_group_access_change_insert AFTER INSERT ON user_group_access
+		BEGIN
+			INSERT INTO user_change (kind, user, time) SELECT 'group', name, UNIXEPOCH() FROM user_group WHERE id = NEW.group_id;
+		END;
+		CREATE TRIGGER user_group_access_change_update AFTER UPDATE OF read, write, owner_user_id ON user_group_access
+		BEGIN
+			INSERT INTO user_change (kind, user, time) SELECT 'group', name, UNIXEPOCH() FROM user_group WHERE id = NEW.group_id;
+		END;
+		CREATE TRIGGER user_group_access_change_delete AFTER DELETE ON user_group_access
+		BEGIN
+			INSERT INTO user_change (kind, user, time) SELECT 'group', name, UNIXEPOCH() FROM user_group WHERE id = OLD.group_id;
+		END;
+		CREATE TRIGGER tier_change_insert AFTER INSERT ON tier
+		BEGIN
+			INSERT INTO user_change (kind, user, time) VALUES ('tier', NEW.code, UNIXEPOCH());
+		END;
+		CREATE TRIGGER tier_change_update AFTER UPDATE ON tier
+		BEGIN
+			INSERT INTO user_change (kind, user, time) VALUES ('tier', NEW.code, UNIXEPOCH());
+		END;
+		CREATE TRIGGER tier_change_delete AFTER DELETE ON tier
+		BEGIN
+			INSERT INTO user_change (kind, user, time) VALUES ('tier', OLD.code, UNIXEPOCH());
+		END;
 		CREATE TABLE IF NOT EXISTS schemaVersion (
 			id INT PRIMARY KEY,
 			version INT NOT NULL
@@ -132,36 +291,45 @@ const (
 	`
 
 	selectUserByIDQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, u.disabled, u.deletion_scheduled, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.message_size_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM user u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE u.id = ?
 	`
 	selectUserByNameQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, u.disabled, u.deletion_scheduled, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.message_size_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM user u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE user = ?
 	`
 	selectUserByTokenQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, u.disabled, u.deletion_scheduled, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.message_size_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM user u
 		JOIN user_token tk on u.id = tk.user_id
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE tk.token = ? AND (tk.expires = 0 OR tk.expires >= ?)
 	`
 	selectUserByStripeCustomerIDQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, u.disabled, u.deletion_scheduled, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.message_size_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM user u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE u.stripe_customer_id = ?
 	`
 	selectTopicPermsQuery = `
-		SELECT read, write
-		FROM user_access a
-		JOIN user u ON u.id = a.user_id
-		WHERE (u.user = ? OR u.user = ?) AND ? LIKE a.topic ESCAPE '\'
-		ORDER BY u.user DESC, LENGTH(a.topic) DESC, a.write DESC
+		SELECT read, write, auth_write
+		FROM (
+			SELECT IIF(u.user = ?, 1, 3) AS priority, a.topic, a.read, a.write, a.auth_write
+			FROM user_access a
+			JOIN user u ON u.id = a.user_id
+			WHERE (u.user = ? OR u.user = ?) AND ? LIKE a.topic ESCAPE '\'
+			UNION ALL
+			SELECT 2 AS priority, ga.topic, ga.read, ga.write, 0 AS auth_write
+			FROM user_group_access ga
+			JOIN user_group_member m ON m.group_id = ga.group_id
+			JOIN user u ON u.id = m.user_id
+			WHERE u.user = ? AND ? LIKE ga.topic ESCAPE '\'
+		)
+		ORDER BY priority, LENGTH(topic) DESC, write DESC
 	`
 
 	insertUserQuery = `
@@ -185,30 +353,35 @@ const (
 	updateUserStatsQuery         = `UPDATE user SET stats_messages = ?, stats_emails = ?, stats_calls = ? WHERE id = ?`
 	updateUserStatsResetAllQuery = `UPDATE user SET stats_messages = 0, stats_emails = 0, stats_calls = 0`
 	updateUserDeletedQuery       = `UPDATE user SET deleted = ? WHERE id = ?`
+	updateUserDisabledQuery      = `UPDATE user SET disabled = ? WHERE user = ?`
+	updateUserDeletionQuery      = `UPDATE user SET deletion_scheduled = ? WHERE id = ?`
+	selectUsersDeletionDueQuery  = `SELECT user FROM user WHERE deletion_scheduled <= ? AND deleted IS NULL ORDER BY deletion_scheduled`
 	deleteUsersMarkedQuery       = `DELETE FROM user WHERE deleted < ?`
 	deleteUserQuery              = `DELETE FROM user WHERE user = ?`
 
 	upsertUserAccessQuery = `
-		INSERT INTO user_access (user_id, topic, read, write, owner_user_id)
-		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, (SELECT IIF(?='',NULL,(SELECT id FROM user WHERE user=?))))
+		INSERT INTO user_access (user_id, topic, read, write, auth_write, owner_user_id, last_active)
+		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, ?, (SELECT IIF(?='',NULL,(SELECT id FROM user WHERE user=?))), UNIXEPOCH())
 		ON CONFLICT (user_id, topic)
-		DO UPDATE SET read=excluded.read, write=excluded.write, owner_user_id=excluded.owner_user_id
+		DO UPDATE SET read=excluded.read, write=excluded.write, auth_write=excluded.auth_write, owner_user_id=excluded.owner_user_id, last_active=excluded.last_active, inactivity_warned_at=0
 	`
 	selectUserAllAccessQuery = `
-		SELECT user_id, topic, read, write
+		SELECT user_id, topic, read, write, auth_write
 		FROM user_access
 		ORDER BY LENGTH(topic) DESC, write DESC, read DESC, topic
 	`
 	selectUserAccessQuery = `
-		SELECT topic, read, write
+		SELECT topic, read, write, auth_write
 		FROM user_access
 		WHERE user_id = (SELECT id FROM user WHERE user = ?)
 		ORDER BY LENGTH(topic) DESC, write DESC, read DESC, topic
 	`
 	selectUserReservationsQuery = `
-		SELECT a_user.topic, a_user.read, a_user.write, a_everyone.read AS everyone_read, a_everyone.write AS everyone_write
+		SELECT a_user.topic, a_user.read, a_user.write, a_everyone.read AS everyone_read, a_everyone.write AS everyone_write, a_everyone.auth_write AS everyone_auth_write, g.name, a_user.sound, a_user.routing, a_user.escalation, a_user.schedule, a_user.policy
 		FROM user_access a_user
 		LEFT JOIN  user_access a_everyone ON a_user.topic = a_everyone.topic AND a_everyone.user_id = (SELECT id FROM user WHERE user = ?)
+		LEFT JOIN user_group_access ga ON ga.topic = a_user.topic AND ga.owner_user_id = a_user.owner_user_id
+		LEFT JOIN user_group g ON g.id = ga.group_id
 		WHERE a_user.user_id = a_user.owner_user_id
 		  AND a_user.owner_user_id = (SELECT id FROM user WHERE user = ?)
 		ORDER BY a_user.topic
@@ -222,9 +395,103 @@ const (
 	selectUserReservationsOwnerQuery = `
 		SELECT owner_user_id
 		FROM user_access
-		WHERE topic = ?
+		WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
+		  AND user_id = owner_user_id
+		ORDER BY LENGTH(topic) DESC
+		LIMIT 1
+	`
+	selectReservationSoundQuery = `
+		SELECT sound
+		FROM user_access
+		WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
+		  AND user_id = owner_user_id
+		ORDER BY LENGTH(topic) DESC
+		LIMIT 1
+	`
+	selectReservationRoutingQuery = `
+		SELECT routing, owner_user_id
+		FROM user_access
+		WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
+		  AND user_id = owner_user_id
+		ORDER BY LENGTH(topic) DESC
+		LIMIT 1
+	`
+	selectReservationEscalationQuery = `
+		SELECT escalation, owner_user_id
+		FROM user_access
+		WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
+		  AND user_id = owner_user_id
+		ORDER BY LENGTH(topic) DESC
+		LIMIT 1
+	`
+	selectReservationScheduleQuery = `
+		SELECT schedule, owner_user_id
+		FROM user_access
+		WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
+		  AND user_id = owner_user_id
+		ORDER BY LENGTH(topic) DESC
+		LIMIT 1
+	`
+	selectReservationPolicyQuery = `
+		SELECT policy
+		FROM user_access
+		WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
 		  AND user_id = owner_user_id
+		ORDER BY LENGTH(topic) DESC
+		LIMIT 1
+	`
+	updateReservationPolicyQuery = `
+		UPDATE user_access
+		SET policy = ?
+		WHERE user_id = (SELECT id FROM user WHERE user = ?)
+		  AND owner_user_id = user_id
+		  AND topic = ?
+	`
+	updateReservationScheduleQuery = `
+		UPDATE user_access
+		SET schedule = ?
+		WHERE user_id = (SELECT id FROM user WHERE user = ?)
+		  AND owner_user_id = user_id
+		  AND topic = ?
+	`
+	updateReservationEscalationQuery = `
+		UPDATE user_access
+		SET escalation = ?
+		WHERE user_id = (SELECT id FROM user WHERE user = ?)
+		  AND owner_user_id = user_id
+		  AND topic = ?
+	`
+	updateReservationRoutingQuery = `
+		UPDATE user_access
+		SET routing = ?
+		WHERE user_id = (SELECT id FROM user WHERE user = ?)
+		  AND owner_user_id = user_id
+		  AND topic = ?
 	`
+	updateReservationSoundQuery = `
+		UPDATE user_access
+		SET sound = ?
+		WHERE user_id = (SELECT id FROM user WHERE user = ?)
+		  AND owner_user_id = user_id
+		  AND topic = ?
+	`
+	selectInactiveReservationsQuery = `
+		SELECT a.topic, u.id, u.user, a.last_active, a.inactivity_warned_at
+		FROM user_access a
+		JOIN user u ON u.id = a.user_id
+		WHERE a.user_id = a.owner_user_id
+		  AND a.provisioned = 0
+		  AND a.last_active <= ?
+		ORDER BY a.last_active
+	`
+	updateReservationsLastActiveQuery = `
+		UPDATE user_access
+		SET last_active = ?, inactivity_warned_at = 0
+		WHERE owner_user_id IS NOT NULL
+		  AND EXISTS (SELECT 1 FROM json_each(?) WHERE value LIKE topic ESCAPE '\')
+	`
+	updateReservationInactivityWarnedQuery = `UPDATE user_access SET inactivity_warned_at = ? WHERE owner_user_id IS NOT NULL AND topic = ?`
+
 	selectUserHasReservationQuery = `
 		SELECT COUNT(*)
 		FROM user_access
@@ -235,7 +502,7 @@ const (
 	selectOtherAccessCountQuery = `
 		SELECT COUNT(*)
 		FROM user_access
-		WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
+		WHERE (topic = ? OR ? LIKE topic ESCAPE '\' OR REPLACE(topic, '\_', '_') LIKE ? ESCAPE '\')
 		  AND (owner_user_id IS NULL OR owner_user_id != (SELECT id FROM user WHERE user = ?))
 	`
 	deleteAllAccessQuery  = `DELETE FROM user_access`
@@ -250,52 +517,132 @@ const (
 	   	  AND topic = ?
   	`
 
-	selectTokenCountQuery      = `SELECT COUNT(*) FROM user_token WHERE user_id = ?`
-	selectTokensQuery          = `SELECT token, label, last_access, last_origin, expires FROM user_token WHERE user_id = ?`
-	selectTokenQuery           = `SELECT token, label, last_access, last_origin, expires FROM user_token WHERE user_id = ? AND token = ?`
-	insertTokenQuery           = `INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires) VALUES (?, ?, ?, ?, ?, ?)`
-	updateTokenExpiryQuery     = `UPDATE user_token SET expires = ? WHERE user_id = ? AND token = ?`
-	updateTokenLabelQuery      = `UPDATE user_token SET label = ? WHERE user_id = ? AND token = ?`
-	updateTokenLastAccessQuery = `UPDATE user_token SET last_access = ?, last_origin = ? WHERE token = ?`
-	deleteTokenQuery           = `DELETE FROM user_token WHERE user_id = ? AND token = ?`
-	deleteAllTokenQuery        = `DELETE FROM user_token WHERE user_id = ?`
-	deleteExpiredTokensQuery   = `DELETE FROM user_token WHERE expires > 0 AND expires < ?`
-	deleteExcessTokensQuery    = `
+	insertGroupQuery        = `INSERT INTO user_group (id, name, created) VALUES (?, ?, ?)`
+	deleteGroupQuery        = `DELETE FROM user_group WHERE name = ?`
+	selectGroupIDQuery      = `SELECT id FROM user_group WHERE name = ?`
+	selectGroupsQuery       = `SELECT id, name FROM user_group ORDER BY name`
+	selectGroupMembersQuery = `
+		SELECT u.user
+		FROM user_group_member m
+		JOIN user u ON u.id = m.user_id
+		WHERE m.group_id = ?
+		ORDER BY u.user
+	`
+	selectUserGroupsQuery = `
+		SELECT g.name
+		FROM user_group g
+		JOIN user_group_member m ON m.group_id = g.id
+		WHERE m.user_id = (SELECT id FROM user WHERE user = ?)
+		ORDER BY g.name
+	`
+	insertGroupMemberQuery = `
+		INSERT INTO user_group_member (group_id, user_id)
+		VALUES (?, (SELECT id FROM user WHERE user = ?))
+		ON CONFLICT (group_id, user_id) DO NOTHING
+	`
+	deleteGroupMemberQuery = `DELETE FROM user_group_member WHERE group_id = ? AND user_id = (SELECT id FROM user WHERE user = ?)`
+	selectGroupAccessQuery = `
+		SELECT topic, read, write
+		FROM user_group_access
+		WHERE group_id = ? AND owner_user_id IS NULL
+		ORDER BY LENGTH(topic) DESC, write DESC, read DESC, topic
+	`
+	upsertGroupAccessQuery = `
+		INSERT INTO user_group_access (group_id, topic, read, write, owner_user_id)
+		VALUES (?, ?, ?, ?, (SELECT IIF(?='',NULL,(SELECT id FROM user WHERE user=?))))
+		ON CONFLICT (group_id, topic)
+		DO UPDATE SET read=excluded.read, write=excluded.write, owner_user_id=excluded.owner_user_id
+	`
+	deleteGroupAccessQuery      = `DELETE FROM user_group_access WHERE group_id = ? AND owner_user_id IS NULL`
+	deleteGroupTopicAccessQuery = `DELETE FROM user_group_access WHERE group_id = ? AND owner_user_id IS NULL AND topic = ?`
+	deleteOwnedGroupAccessQuery = `
+		DELETE FROM user_group_access
+		WHERE owner_user_id = (SELECT id FROM user WHERE user = ?)
+		  AND topic = ?
+	`
+	deleteAllOwnedGroupAccessQuery       = `DELETE FROM user_group_access WHERE owner_user_id = (SELECT id FROM user WHERE user = ?)`
+	deleteAllReservationGroupAccessQuery = `DELETE FROM user_group_access WHERE owner_user_id IS NOT NULL`
+
+	selectPublishKeyCountQuery = `
+		SELECT COUNT(*)
+		FROM user_publish_key
+		WHERE user_id = (SELECT id FROM user WHERE user = ?) AND topic = ?
+	`
+	selectPublishKeysQuery = `
+		SELECT topic, publish_key, label, created, title_prefix, priority, tags
+		FROM user_publish_key
+		WHERE user_id = (SELECT id FROM user WHERE user = ?)
+		ORDER BY topic, created
+	`
+	selectPublishKeyQuery = `
+		SELECT k.topic, k.publish_key, k.label, k.created, k.title_prefix, k.priority, k.tags
+		FROM user_publish_key k
+		JOIN user_access a ON a.user_id = k.user_id AND a.owner_user_id = k.user_id AND a.topic = k.topic
+		JOIN user u ON u.id = k.user_id
+		WHERE k.publish_key = ? AND u.disabled = 0
+	`
+	insertPublishKeyQuery = `
+		INSERT INTO user_publish_key (user_id, topic, publish_key, label, created, title_prefix, priority, tags)
+		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, ?, ?, ?, ?)
+	`
+	deletePublishKeyQuery       = `DELETE FROM user_publish_key WHERE user_id = (SELECT id FROM user WHERE user = ?) AND topic = ? AND publish_key = ?`
+	deleteTopicPublishKeysQuery = `DELETE FROM user_publish_key WHERE user_id = (SELECT id FROM user WHERE user = ?) AND topic = ?`
+
+	selectTokenCountQuery         = `SELECT COUNT(*) FROM user_token WHERE user_id = ? AND impersonator = ''`
+	selectTokensQuery             = `SELECT token, label, last_access, last_origin, expires, allowed_ips, impersonator FROM user_token WHERE user_id = ?`
+	selectTokenQuery              = `SELECT token, label, last_access, last_origin, expires, allowed_ips, impersonator FROM user_token WHERE user_id = ? AND token = ?`
+	selectTokenRestrictionsQuery  = `SELECT allowed_ips, impersonator FROM user_token WHERE token = ?`
+	insertTokenQuery              = `INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires, allowed_ips, impersonator) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	updateTokenExpiryQuery        = `UPDATE user_token SET expires = ? WHERE user_id = ? AND token = ?`
+	updateTokenLabelQuery         = `UPDATE user_token SET label = ? WHERE user_id = ? AND token = ?`
+	updateTokenLastAccessQuery    = `UPDATE user_token SET last_access = ?, last_origin = ? WHERE token = ?`
+	deleteTokenQuery              = `DELETE FROM user_token WHERE user_id = ? AND token = ?`
+	deleteAllTokenQuery           = `DELETE FROM user_token WHERE user_id = ?`
+	deleteAllTokenByUsernameQuery = `DELETE FROM user_token WHERE user_id = (SELECT id FROM user WHERE user = ?)`
+	deleteExpiredTokensQuery      = `DELETE FROM user_token WHERE expires > 0 AND expires < ?`
+	deleteExcessTokensQuery       = `
 		DELETE FROM user_token
 		WHERE user_id = ?
+		  AND impersonator = ''
 		  AND (user_id, token) NOT IN (
 			SELECT user_id, token
 			FROM user_token
-			WHERE user_id = ?
+			WHERE user_id = ? AND impersonator = ''
 			ORDER BY expires DESC
 			LIMIT ?
 		)
 	`
+	upsertImportedTokenQuery = `
+		INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires, allowed_ips, impersonator)
+		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, ?, ?, ?, '')
+		ON CONFLICT (user_id, token)
+		DO UPDATE SET label = excluded.label, expires = excluded.expires, allowed_ips = excluded.allowed_ips
+	`
 
 	selectPhoneNumbersQuery = `SELECT phone_number FROM user_phone WHERE user_id = ?`
 	insertPhoneNumberQuery  = `INSERT INTO user_phone (user_id, phone_number) VALUES (?, ?)`
 	deletePhoneNumberQuery  = `DELETE FROM user_phone WHERE user_id = ? AND phone_number = ?`
 
 	insertTierQuery = `
-		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, message_size_limit, stripe_monthly_price_id, stripe_yearly_price_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	updateTierQuery = `
 		UPDATE tier
-		SET name = ?, messages_limit = ?, messages_expiry_duration = ?, emails_limit = ?, calls_limit = ?, reservations_limit = ?, attachment_file_size_limit = ?, attachment_total_size_limit = ?, attachment_expiry_duration = ?, attachment_bandwidth_limit = ?, stripe_monthly_price_id = ?, stripe_yearly_price_id = ?
+		SET name = ?, messages_limit = ?, messages_expiry_duration = ?, emails_limit = ?, calls_limit = ?, reservations_limit = ?, attachment_file_size_limit = ?, attachment_total_size_limit = ?, attachment_expiry_duration = ?, attachment_bandwidth_limit = ?, message_size_limit = ?, stripe_monthly_price_id = ?, stripe_yearly_price_id = ?
 		WHERE code = ?
 	`
 	selectTiersQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, message_size_limit, stripe_monthly_price_id, stripe_yearly_price_id
 		FROM tier
 	`
 	selectTierByCodeQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, message_size_limit, stripe_monthly_price_id, stripe_yearly_price_id
 		FROM tier
 		WHERE code = ?
 	`
 	selectTierByPriceIDQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, message_size_limit, stripe_monthly_price_id, stripe_yearly_price_id
 		FROM tier
 		WHERE (stripe_monthly_price_id = ? OR stripe_yearly_price_id = ?)
 	`
@@ -303,16 +650,126 @@ const (
 	deleteUserTierQuery = `UPDATE user SET tier_id = null WHERE user = ?`
 	deleteTierQuery     = `DELETE FROM tier WHERE code = ?`
 
+	selectProvisionedUsernamesQuery = `SELECT user FROM user WHERE provisioned = 1`
+	selectProvisionedTierCodesQuery = `SELECT code FROM tier WHERE provisioned = 1`
+	selectProvisionedTokensQuery    = `SELECT user_id, token FROM user_token WHERE provisioned = 1`
+	upsertProvisionedUserQuery      = `
+		INSERT INTO user (id, user, pass, role, sync_topic, created, provisioned)
+		VALUES (?, ?, ?, ?, ?, ?, 1)
+		ON CONFLICT (user)
+		DO UPDATE SET pass = excluded.pass, role = excluded.role, deleted = NULL, provisioned = 1
+	`
+	upsertProvisionedUserAccessQuery = `
+		INSERT INTO user_access (user_id, topic, read, write, auth_write, owner_user_id, provisioned)
+		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, ?, NULL, 1)
+		ON CONFLICT (user_id, topic)
+		DO UPDATE SET read=excluded.read, write=excluded.write, auth_write=excluded.auth_write, owner_user_id=NULL, provisioned=1
+	`
+	upsertProvisionedTokenQuery = `
+		INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires, provisioned)
+		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, ?, 0, 1)
+		ON CONFLICT (user_id, token)
+		DO UPDATE SET label = excluded.label, expires = 0, provisioned = 1
+	`
+	updateTierProvisionedQuery       = `UPDATE tier SET provisioned = 1 WHERE code = ?`
+	deleteProvisionedUserAccessQuery = `DELETE FROM user_access WHERE provisioned = 1`
+	deleteUserTierByTierCodeQuery    = `UPDATE user SET tier_id = null WHERE tier_id = (SELECT id FROM tier WHERE code = ?)`
+	deleteTokenFromOtherUsersQuery   = `DELETE FROM user_token WHERE token = ? AND user_id != (SELECT id FROM user WHERE user = ?)`
+
 	updateBillingQuery = `
 		UPDATE user
 		SET stripe_customer_id = ?, stripe_subscription_id = ?, stripe_subscription_status = ?, stripe_subscription_interval = ?, stripe_subscription_paid_until = ?, stripe_subscription_cancel_at = ?
 		WHERE user = ?
 	`
+
+	selectChangePositionQuery   = `SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'user_change'), 0)`
+	selectChangeFirstIDQuery    = `SELECT COALESCE(MIN(id), 0) FROM user_change`
+	selectChangedUsernamesQuery = `SELECT DISTINCT user FROM user_change WHERE kind = ? AND id > ? AND id <= ?`
+	selectAllUsernamesQuery     = `SELECT user FROM user`
+	selectAllGroupNamesQuery    = `SELECT name FROM user_group`
+	selectAllTierCodesQuery     = `SELECT code FROM tier`
+	selectUserChangeQuery       = `
+		SELECT u.id, u.pass, u.role, u.sync_topic, u.deleted, u.disabled, u.provisioned, COALESCE(t.code, '')
+		FROM user u
+		LEFT JOIN tier t on t.id = u.tier_id
+		WHERE u.user = ?
+	`
+	selectUserAccessChangesQuery = `
+		SELECT a.topic, a.read, a.write, a.auth_write, COALESCE(o.user, ''), a.sound, a.routing, a.escalation, a.schedule, a.policy, a.provisioned
+		FROM user_access a
+		LEFT JOIN user o ON o.id = a.owner_user_id
+		WHERE a.user_id = (SELECT id FROM user WHERE user = ?)
+		ORDER BY a.topic
+	`
+	selectUserTokenChangesQuery = `
+		SELECT token, label, expires, provisioned, allowed_ips, impersonator
+		FROM user_token
+		WHERE user_id = (SELECT id FROM user WHERE user = ?)
+		ORDER BY token
+	`
+	selectUserPublishKeyChangesQuery = `
+		SELECT topic, publish_key, label, created, title_prefix, priority, tags
+		FROM user_publish_key
+		WHERE user_id = (SELECT id FROM user WHERE user = ?)
+		ORDER BY publish_key
+	`
+	selectGroupChangeQuery        = `SELECT id FROM user_group WHERE name = ?`
+	selectGroupAccessChangesQuery = `
+		SELECT a.topic, a.read, a.write, COALESCE(o.user, '')
+		FROM user_group_access a
+		LEFT JOIN user o ON o.id = a.owner_user_id
+		WHERE a.group_id = ?
+		ORDER BY a.topic
+	`
+	selectTierChangeQuery = `
+		SELECT id, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, message_size_limit, COALESCE(stripe_monthly_price_id, ''), COALESCE(stripe_yearly_price_id, ''), provisioned
+		FROM tier
+		WHERE code = ?
+	`
+	upsertReplicatedUserQuery = `
+		INSERT INTO user (id, user, pass, role, sync_topic, created, disabled, provisioned)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user)
+		DO UPDATE SET pass = excluded.pass, role = excluded.role, sync_topic = excluded.sync_topic, deleted = NULL, disabled = excluded.disabled, provisioned = excluded.provisioned
+	`
+	insertReplicatedUserAccessQuery = `
+		INSERT INTO user_access (user_id, topic, read, write, auth_write, owner_user_id, sound, routing, escalation, schedule, policy, provisioned, last_active)
+		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, ?, (SELECT id FROM user WHERE user = ?), ?, ?, ?, ?, ?, ?, UNIXEPOCH())
+	`
+	insertReplicatedTokenQuery = `
+		INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires, provisioned, allowed_ips, impersonator)
+		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, '', ?, ?, ?, ?)
+	`
+	insertReplicatedPublishKeyQuery = `
+		INSERT INTO user_publish_key (user_id, topic, publish_key, label, created, title_prefix, priority, tags)
+		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, ?, ?, ?, ?)
+	`
+	deleteAllPublishKeysByUsernameQuery = `DELETE FROM user_publish_key WHERE user_id = (SELECT id FROM user WHERE user = ?)`
+	upsertReplicatedGroupQuery          = `
+		INSERT INTO user_group (id, name, created)
+		VALUES (?, ?, UNIXEPOCH())
+		ON CONFLICT (name) DO NOTHING
+	`
+	deleteAllGroupMembersQuery       = `DELETE FROM user_group_member WHERE group_id = ?`
+	deleteAllGroupAccessQuery        = `DELETE FROM user_group_access WHERE group_id = ?`
+	insertReplicatedGroupAccessQuery = `
+		INSERT INTO user_group_access (group_id, topic, read, write, owner_user_id)
+		VALUES (?, ?, ?, ?, (SELECT id FROM user WHERE user = ?))
+	`
+	upsertReplicatedTierQuery = `
+		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, message_size_limit, stripe_monthly_price_id, stripe_yearly_price_id, provisioned)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (code)
+		DO UPDATE SET name = excluded.name, messages_limit = excluded.messages_limit, messages_expiry_duration = excluded.messages_expiry_duration, emails_limit = excluded.emails_limit, calls_limit = excluded.calls_limit, reservations_limit = excluded.reservations_limit, attachment_file_size_limit = excluded.attachment_file_size_limit, attachment_total_size_limit = excluded.attachment_total_size_limit, attachment_expiry_duration = excluded.attachment_expiry_duration, attachment_bandwidth_limit = excluded.attachment_bandwidth_limit, message_size_limit = excluded.message_size_limit, stripe_monthly_price_id = excluded.stripe_monthly_price_id, stripe_yearly_price_id = excluded.stripe_yearly_price_id, provisioned = excluded.provisioned
+	`
+	selectTierIDQuery           = `SELECT id FROM tier WHERE code = ?`
+	deleteUserOwnAccessQuery    = `DELETE FROM user_access WHERE user_id = (SELECT id FROM user WHERE user = ?)`
+	deleteChangesOlderThanQuery = `DELETE FROM user_change WHERE time < ?`
 )
 
 // Schema management queries
 const (
-	currentSchemaVersion     = 5
+	currentSchemaVersion     = 23
 	insertSchemaVersion      = `INSERT INTO schemaVersion VALUES (1, ?)`
 	updateSchemaVersion      = `UPDATE schemaVersion SET version = ? WHERE id = 1`
 	selectSchemaVersionQuery = `SELECT version FROM schemaVersion WHERE id = 1`
@@ -427,14 +884,276 @@ const (
 	migrate4To5UpdateQueries = `
 		UPDATE user_access SET topic = REPLACE(topic, '_', '\_');
 	`
+
+	// 5 -> 6
+	migrate5To6UpdateQueries = `
+		ALTER TABLE user_access ADD COLUMN auth_write INT NOT NULL DEFAULT (0);
+	`
+
+	// 6 -> 7
+	migrate6To7UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_publish_key (
+			user_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			publish_key TEXT NOT NULL,
+			label TEXT NOT NULL,
+			created INT NOT NULL,
+			PRIMARY KEY (publish_key),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX idx_user_publish_key_user_id_topic ON user_publish_key (user_id, topic);
+	`
+
+	// 7 -> 8
+	migrate7To8UpdateQueries = `
+		ALTER TABLE tier ADD COLUMN provisioned INT NOT NULL DEFAULT (0);
+		ALTER TABLE user ADD COLUMN provisioned INT NOT NULL DEFAULT (0);
+		ALTER TABLE user_access ADD COLUMN provisioned INT NOT NULL DEFAULT (0);
+		ALTER TABLE user_token ADD COLUMN provisioned INT NOT NULL DEFAULT (0);
+	`
+
+	// 8 -> 9
+	migrate8To9UpdateQueries = `
+		ALTER TABLE user ADD COLUMN disabled INT NOT NULL DEFAULT (0);
+	`
+
+	// 9 -> 10
+	migrate9To10UpdateQueries = `
+		ALTER TABLE user_access ADD COLUMN last_active INT NOT NULL DEFAULT (0);
+		ALTER TABLE user_access ADD COLUMN inactivity_warned_at INT NOT NULL DEFAULT (0);
+		UPDATE user_access SET last_active = UNIXEPOCH();
+	`
+
+	// 10 -> 11
+	migrate10To11UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_group (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE UNIQUE INDEX idx_user_group_name ON user_group (name);
+		CREATE TABLE IF NOT EXISTS user_group_member (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_group_access (
+			group_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			owner_user_id TEXT,
+			PRIMARY KEY (group_id, topic),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+	`
+
+	// 11 -> 12
+	migrate11To12UpdateQueries = `
+		ALTER TABLE user_access ADD COLUMN sound TEXT NOT NULL DEFAULT ('');
+	`
+
+	// 12 -> 13
+	migrate12To13UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_change (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user TEXT NOT NULL,
+			time INT NOT NULL
+		);
+		CREATE TRIGGER user_change_insert AFTER INSERT ON user
+		BEGIN
+			INSERT INTO user_change (user, time) VALUES (NEW.user, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_change_update AFTER UPDATE OF user, pass, role, tier_id, sync_topic, deleted, disabled, provisioned ON user
+		BEGIN
+			INSERT INTO user_change (user, time) VALUES (NEW.user, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_change_delete AFTER DELETE ON user
+		BEGIN
+			INSERT INTO user_change (user, time) VALUES (OLD.user, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_access_change_insert AFTER INSERT ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_access_change_update AFTER UPDATE OF read, write, auth_write, owner_user_id, sound, provisioned ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_access_change_delete AFTER DELETE ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = OLD.user_id;
+		END;
+		CREATE TRIGGER user_token_change_insert AFTER INSERT ON user_token
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_token_change_update AFTER UPDATE OF label, expires, provisioned ON user_token
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_token_change_delete AFTER DELETE ON user_token
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = OLD.user_id;
+		END;
+		INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user;
+	`
+
+	// 13 -> 14
+	migrate13To14UpdateQueries = `
+		ALTER TABLE user_token ADD COLUMN allowed_ips TEXT NOT NULL DEFAULT ('');
+	`
+
+	// 14 -> 15
+	migrate14To15UpdateQueries = `
+		ALTER TABLE user_token ADD COLUMN impersonator TEXT NOT NULL DEFAULT ('');
+	`
+
+	// 15 -> 16
+	migrate15To16UpdateQueries = `
+		ALTER TABLE user ADD COLUMN deletion_scheduled INT;
+	`
+
+	// 16 -> 17
+	migrate16To17UpdateQueries = `
+		ALTER TABLE user_access ADD COLUMN routing TEXT NOT NULL DEFAULT ('');
+		DROP TRIGGER IF EXISTS user_access_change_update;
+		CREATE TRIGGER user_access_change_update AFTER UPDATE OF read, write, auth_write, owner_user_id, sound, routing, provisioned ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+	`
+
+	// 17 -> 18
+	migrate17To18UpdateQueries = `
+		ALTER TABLE user_access ADD COLUMN escalation TEXT NOT NULL DEFAULT ('');
+		DROP TRIGGER IF EXISTS user_access_change_update;
+		CREATE TRIGGER user_access_change_update AFTER UPDATE OF read, write, auth_write, owner_user_id, sound, routing, escalation, provisioned ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+	`
+
+	// 18 -> 19
+	migrate18To19UpdateQueries = `
+		ALTER TABLE user_access ADD COLUMN schedule TEXT NOT NULL DEFAULT ('');
+		DROP TRIGGER IF EXISTS user_access_change_update;
+		CREATE TRIGGER user_access_change_update AFTER UPDATE OF read, write, auth_write, owner_user_id, sound, routing, escalation, schedule, provisioned ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+	`
+
+	// 19 -> 20
+	migrate19To20UpdateQueries = `
+		ALTER TABLE user_publish_key ADD COLUMN title_prefix TEXT NOT NULL DEFAULT ('');
+		ALTER TABLE user_publish_key ADD COLUMN priority INT NOT NULL DEFAULT (0);
+		ALTER TABLE user_publish_key ADD COLUMN tags TEXT NOT NULL DEFAULT ('');
+	`
+
+	// 20 -> 21
+	migrate20To21UpdateQueries = `
+		ALTER TABLE user_access ADD COLUMN policy TEXT NOT NULL DEFAULT ('');
+		DROP TRIGGER IF EXISTS user_access_change_update;
+		CREATE TRIGGER user_access_change_update AFTER UPDATE OF read, write, auth_write, owner_user_id, sound, routing, escalation, schedule, policy, provisioned ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+	`
+
+	// 21 -> 22
+	migrate21To22UpdateQueries = `
+		ALTER TABLE tier ADD COLUMN message_size_limit INT NOT NULL DEFAULT (0);
+	`
+
+	// 22 -> 23
+	migrate22To23UpdateQueries = `
+		ALTER TABLE user_change ADD COLUMN kind TEXT NOT NULL DEFAULT ('user');
+		CREATE TRIGGER user_publish_key_change_insert AFTER INSERT ON user_publish_key
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_publish_key_change_update AFTER UPDATE OF topic, label, title_prefix, priority, tags ON user_publish_key
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_publish_key_change_delete AFTER DELETE ON user_publish_key
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = OLD.user_id;
+		END;
+		CREATE TRIGGER user_group_change_insert AFTER INSERT ON user_group
+		BEGIN
+			INSERT INTO user_change (kind, user, time) VALUES ('group', NEW.name, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_group_change_delete AFTER DELETE ON user_group
+		BEGIN
+			INSERT INTO user_change (kind, user, time) VALUES ('group', OLD.name, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_group_member_change_insert AFTER INSERT ON user_group_member
+		BEGIN
+			INSERT INTO user_change (kind, user, time) SELECT 'group', name, UNIXEPOCH() FROM user_group WHERE id = NEW.group_id;
+		END;
+		CREATE TRIGGER user_group_member_change_delete AFTER DELETE ON user_group_member
+		BEGIN
+			INSERT INTO user_change (kind, user, time) SELECT 'group', name, UNIXEPOCH() FROM user_group WHERE id = OLD.group_id;
+		END;
+		CREATE TRIGGER user_group_access_change_insert AFTER INSERT ON user_group_access
+		BEGIN
+			INSERT INTO user_change (kind, user, time) SELECT 'group', name, UNIXEPOCH() FROM user_group WHERE id = NEW.group_id;
+		END;
+		CREATE TRIGGER user_group_access_change_update AFTER UPDATE OF read, write, owner_user_id ON user_group_access
+		BEGIN
+			INSERT INTO user_change (kind, user, time) SELECT 'group', name, UNIXEPOCH() FROM user_group WHERE id = NEW.group_id;
+		END;
+		CREATE TRIGGER user_group_access_change_delete AFTER DELETE ON user_group_access
+		BEGIN
+			INSERT INTO user_change (kind, user, time) SELECT 'group', name, UNIXEPOCH() FROM user_group WHERE id = OLD.group_id;
+		END;
+		CREATE TRIGGER tier_change_insert AFTER INSERT ON tier
+		BEGIN
+			INSERT INTO user_change (kind, user, time) VALUES ('tier', NEW.code, UNIXEPOCH());
+		END;
+		CREATE TRIGGER tier_change_update AFTER UPDATE ON tier
+		BEGIN
+			INSERT INTO user_change (kind, user, time) VALUES ('tier', NEW.code, UNIXEPOCH());
+		END;
+		CREATE TRIGGER tier_change_delete AFTER DELETE ON tier
+		BEGIN
+			INSERT INTO user_change (kind, user, time) VALUES ('tier', OLD.code, UNIXEPOCH());
+		END;
+		INSERT INTO user_change (user, time) SELECT DISTINCT u.user, UNIXEPOCH() FROM user u JOIN user_publish_key k ON k.user_id = u.id;
+		INSERT INTO user_change (kind, user, time) SELECT 'group', name, UNIXEPOCH() FROM user_group;
+		INSERT INTO user_change (kind, user, time) SELECT 'tier', code, UNIXEPOCH() FROM tier;
+	`
 )
 
 var (
 	migrations = map[int]func(db *sql.DB) error{
-		1: migrateFrom1,
-		2: migrateFrom2,
-		3: migrateFrom3,
-		4: migrateFrom4,
+		1:  migrateFrom1,
+		2:  migrateFrom2,
+		3:  migrateFrom3,
+		4:  migrateFrom4,
+		5:  migrateFrom5,
+		6:  migrateFrom6,
+		7:  migrateFrom7,
+		8:  migrateFrom8,
+		9:  migrateFrom9,
+		10: migrateFrom10,
+		11: migrateFrom11,
+		12: migrateFrom12,
+		13: migrateFrom13,
+		14: migrateFrom14,
+		15: migrateFrom15,
+		16: migrateFrom16,
+		17: migrateFrom17,
+		18: migrateFrom18,
+		19: migrateFrom19,
+		20: migrateFrom20,
+		21: migrateFrom21,
+		22: migrateFrom22,
 	}
 )
 
@@ -490,6 +1209,10 @@ func (a *Manager) Authenticate(username, password string) (*User, error) {
 		log.Tag(tag).Field("user_name", username).Trace("Authentication of user failed (2): user marked deleted")
 		bcrypt.CompareHashAndPassword([]byte(userAuthIntentionalSlowDownHash), []byte("intentional slow-down to avoid timing attacks"))
 		return nil, ErrUnauthenticated
+	} else if user.Disabled {
+		log.Tag(tag).Field("user_name", username).Trace("Authentication of user failed (2): user disabled")
+		bcrypt.CompareHashAndPassword([]byte(userAuthIntentionalSlowDownHash), []byte("intentional slow-down to avoid timing attacks"))
+		return nil, ErrUnauthenticated
 	} else if err := bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)); err != nil {
 		log.Tag(tag).Field("user_name", username).Err(err).Trace("Authentication of user failed (3)")
 		return nil, ErrUnauthenticated
@@ -498,32 +1221,88 @@ func (a *Manager) Authenticate(username, password string) (*User, error) {
 }
 
 // AuthenticateToken checks if the token exists and returns the associated User if it does.
-// The method sets the User.Token value to the token that was used for authentication.
-func (a *Manager) AuthenticateToken(token string) (*User, error) {
+// The method sets the User.Token value to the token that was used for authentication, and User.Impersonator
+// if it is an impersonation token. If the token is bound to IP ranges (see Token.AllowedIPs),
+// ErrTokenOriginNotAllowed is returned if origin is not in any of them.
+func (a *Manager) AuthenticateToken(token string, origin netip.Addr) (*User, error) {
 	if len(token) != tokenLength {
 		return nil, ErrUnauthenticated
 	}
 	user, err := a.userByToken(token)
+	if errors.Is(err, ErrUserNotFound) {
+		token = HashToken(token) // Provisioned tokens are only stored as hash, see provisionTokens
+		user, err = a.userByToken(token)
+	}
 	if err != nil {
 		log.Tag(tag).Field("token", token).Err(err).Trace("Authentication of token failed")
 		return nil, ErrUnauthenticated
+	} else if user.Disabled {
+		log.Tag(tag).Field("token", token).Trace("Authentication of token failed: user disabled")
+		return nil, ErrUnauthenticated
+	}
+	allowedIPs, impersonator, err := a.tokenRestrictions(token)
+	if err != nil {
+		return nil, err
+	} else if len(allowedIPs) > 0 && !util.ContainsIP(allowedIPs, origin) {
+		log.Tag(tag).Field("token", token).Trace("Authentication of token failed: origin %s not allowed", origin.String())
+		return nil, ErrTokenOriginNotAllowed
 	}
 	user.Token = token
+	user.Impersonator = impersonator
 	return user, nil
 }
 
+func (a *Manager) tokenRestrictions(token string) (allowedIPs []netip.Prefix, impersonator string, err error) {
+	var allowedIPsStr string
+	if err := a.db.QueryRow(selectTokenRestrictionsQuery, token).Scan(&allowedIPsStr, &impersonator); err != nil {
+		return nil, "", err
+	}
+	allowedIPs, err = parseAllowedIPs(allowedIPsStr)
+	if err != nil {
+		return nil, "", err
+	}
+	return allowedIPs, impersonator, nil
+}
+
 // CreateToken generates a random token for the given user and returns it. The token expires
-// after a fixed duration unless ChangeToken is called. This function also prunes tokens for the
-// given user, if there are too many of them.
-func (a *Manager) CreateToken(userID, label string, expires time.Time, origin netip.Addr) (*Token, error) {
-	token := util.RandomLowerStringPrefix(tokenPrefix, tokenLength) // Lowercase only to support "<topic>+<token>@<domain>" email addresses
+// after a fixed duration unless ChangeToken is called. If allowedIPs is not empty, the token can only be
+// used from these IP ranges. This function also prunes tokens for the given user, if there are too many of them.
+func (a *Manager) CreateToken(userID, label string, expires time.Time, origin netip.Addr, allowedIPs []netip.Prefix) (*Token, error) {
+	return a.createToken(userID, label, expires, origin, allowedIPs, "")
+}
+
+// CreateImpersonationToken generates a token for the given user, which is used by the admin with the
+// username impersonator to act as that user. Requests made with the token are authenticated as the user,
+// but User.Impersonator is set, so that they can be told apart (e.g. in the logs).
+func (a *Manager) CreateImpersonationToken(userID, impersonator string, expires time.Time, origin netip.Addr) (*Token, error) {
+	if impersonator == "" {
+		return nil, errNoImpersonatorProvided
+	}
+	return a.createToken(userID, fmt.Sprintf("Impersonated by %s", impersonator), expires, origin, nil, impersonator)
+}
+
+// GenerateToken returns a new random access token, without storing it
+func GenerateToken() string {
+	return util.RandomLowerStringPrefix(tokenPrefix, tokenLength) // Lowercase only to support "<topic>+<token>@<domain>" email addresses
+}
+
+// HashToken returns the hex-encoded SHA-256 hash of the given access token. Provisioned tokens are configured
+// and stored as hash (see Provisioning), so that the plaintext token never has to be written to a config file.
+// Since tokens are long random strings, a fast hash is sufficient.
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (a *Manager) createToken(userID, label string, expires time.Time, origin netip.Addr, allowedIPs []netip.Prefix, impersonator string) (*Token, error) {
+	token := GenerateToken()
 	tx, err := a.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 	access := time.Now()
-	if _, err := tx.Exec(insertTokenQuery, userID, token, label, access.Unix(), origin.String(), expires.Unix()); err != nil {
+	if _, err := tx.Exec(insertTokenQuery, userID, token, label, access.Unix(), origin.String(), expires.Unix(), formatAllowedIPs(allowedIPs), impersonator); err != nil {
 		return nil, err
 	}
 	rows, err := tx.Query(selectTokenCountQuery, userID)
@@ -540,7 +1319,8 @@ func (a *Manager) CreateToken(userID, label string, expires time.Time, origin ne
 	}
 	if tokenCount >= tokenMaxCount {
 		// This pruning logic is done in two queries for efficiency. The SELECT above is a lookup
-		// on two indices, whereas the query below is a full table scan.
+		// on two indices, whereas the query below is a full table scan. Impersonation tokens are neither
+		// counted nor pruned, so that an admin impersonating a user cannot delete the user's own tokens.
 		if _, err := tx.Exec(deleteExcessTokensQuery, userID, userID, tokenMaxCount); err != nil {
 			return nil, err
 		}
@@ -549,14 +1329,40 @@ func (a *Manager) CreateToken(userID, label string, expires time.Time, origin ne
 		return nil, err
 	}
 	return &Token{
-		Value:      token,
-		Label:      label,
-		LastAccess: access,
-		LastOrigin: origin,
-		Expires:    expires,
+		Value:        token,
+		Label:        label,
+		LastAccess:   access,
+		LastOrigin:   origin,
+		Expires:      expires,
+		AllowedIPs:   allowedIPs,
+		Impersonator: impersonator,
 	}, nil
 }
 
+// ImportToken adds or updates a token with a known value (e.g. from an export of another server) for the
+// given user. Only the label, expiry date and allowed IPs are taken from the token. If the token belongs to
+// another user, it is moved to this user.
+func (a *Manager) ImportToken(username string, token *Token) error {
+	if !AllowedUsername(username) || !strings.HasPrefix(token.Value, tokenPrefix) || len(token.Value) != tokenLength {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(deleteTokenFromOtherUsersQuery, token.Value, username); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(upsertImportedTokenQuery, username, token.Value, token.Label, time.Now().Unix(), netip.IPv4Unspecified().String(), token.Expires.Unix(), formatAllowedIPs(token.AllowedIPs)); err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintNotNull {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
 // Tokens returns all existing tokens for the user with the given user ID
 func (a *Manager) Tokens(userID string) ([]*Token, error) {
 	rows, err := a.db.Query(selectTokensQuery, userID)
@@ -588,12 +1394,12 @@ func (a *Manager) Token(userID, token string) (*Token, error) {
 }
 
 func (a *Manager) readToken(rows *sql.Rows) (*Token, error) {
-	var token, label, lastOrigin string
+	var token, label, lastOrigin, allowedIPs, impersonator string
 	var lastAccess, expires int64
 	if !rows.Next() {
 		return nil, ErrTokenNotFound
 	}
-	if err := rows.Scan(&token, &label, &lastAccess, &lastOrigin, &expires); err != nil {
+	if err := rows.Scan(&token, &label, &lastAccess, &lastOrigin, &expires, &allowedIPs, &impersonator); err != nil {
 		return nil, err
 	} else if err := rows.Err(); err != nil {
 		return nil, err
@@ -602,12 +1408,18 @@ func (a *Manager) readToken(rows *sql.Rows) (*Token, error) {
 	if err != nil {
 		lastOriginIP = netip.IPv4Unspecified()
 	}
+	allowedPrefixes, err := parseAllowedIPs(allowedIPs)
+	if err != nil {
+		return nil, err
+	}
 	return &Token{
-		Value:      token,
-		Label:      label,
-		LastAccess: time.Unix(lastAccess, 0),
-		LastOrigin: lastOriginIP,
-		Expires:    time.Unix(expires, 0),
+		Value:        token,
+		Label:        label,
+		LastAccess:   time.Unix(lastAccess, 0),
+		LastOrigin:   lastOriginIP,
+		Expires:      time.Unix(expires, 0),
+		AllowedIPs:   allowedPrefixes,
+		Impersonator: impersonator,
 	}, nil
 }
 
@@ -656,33 +1468,169 @@ func (a *Manager) RemoveExpiredTokens() error {
 	return nil
 }
 
-// PhoneNumbers returns all phone numbers for the user with the given user ID
-func (a *Manager) PhoneNumbers(userID string) ([]string, error) {
-	rows, err := a.db.Query(selectPhoneNumbersQuery, userID)
+// CreatePublishKey generates a random publish key for the given topic, which must be reserved by the given user.
+// A publish key allows publishing to this one topic only, without having to authenticate as the user. The key
+// is only valid as long as the user owns the reservation. The optional defaults are applied to all messages
+// published with the key, see PublishKeyDefaults.
+func (a *Manager) CreatePublishKey(username, topic, label string, defaults *PublishKeyDefaults) (*PublishKey, error) {
+	if !AllowedUsername(username) || !AllowedTopic(topic) {
+		return nil, ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(selectPublishKeyCountQuery, username, escapeUnderscore(topic))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	phoneNumbers := make([]string, 0)
-	for {
-		phoneNumber, err := a.readPhoneNumber(rows)
-		if err == ErrPhoneNumberNotFound {
-			break
-		} else if err != nil {
+	if !rows.Next() {
+		return nil, errNoRows
+	}
+	var count int
+	if err := rows.Scan(&count); err != nil {
+		return nil, err
+	} else if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if count >= publishKeyMaxCount {
+		return nil, ErrTooManyPublishKeys
+	}
+	key := util.RandomLowerStringPrefix(PublishKeyPrefix, publishKeyLength)
+	created := time.Now()
+	var titlePrefix, tags string
+	var priority int
+	if defaults != nil {
+		titlePrefix, priority, tags = defaults.TitlePrefix, defaults.Priority, strings.Join(defaults.Tags, ",")
+	}
+	if _, err := tx.Exec(insertPublishKeyQuery, username, escapeUnderscore(topic), key, label, created.Unix(), titlePrefix, priority, tags); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &PublishKey{
+		Value:    key,
+		Topic:    topic,
+		Label:    label,
+		Created:  time.Unix(created.Unix(), 0),
+		Defaults: defaults,
+	}, nil
+}
+
+// PublishKeys returns all publish keys for all topics reserved by the given user
+func (a *Manager) PublishKeys(username string) ([]*PublishKey, error) {
+	rows, err := a.db.Query(selectPublishKeysQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]*PublishKey, 0)
+	for rows.Next() {
+		key, err := readPublishKey(rows)
+		if err != nil {
 			return nil, err
 		}
-		phoneNumbers = append(phoneNumbers, phoneNumber)
+		keys = append(keys, key)
 	}
-	return phoneNumbers, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
 }
 
-func (a *Manager) readPhoneNumber(rows *sql.Rows) (string, error) {
-	var phoneNumber string
+// PublishKey returns the given publish key, or ErrUnauthorized if the key does not exist, if the user that
+// created it no longer owns the topic reservation, or if that user is disabled
+func (a *Manager) PublishKey(key string) (*PublishKey, error) {
+	rows, err := a.db.Query(selectPublishKeyQuery, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 	if !rows.Next() {
-		return "", ErrPhoneNumberNotFound
+		return nil, ErrUnauthorized
 	}
-	if err := rows.Scan(&phoneNumber); err != nil {
-		return "", err
+	return readPublishKey(rows)
+}
+
+func readPublishKey(rows *sql.Rows) (*PublishKey, error) {
+	var topic, key, label, titlePrefix, tags string
+	var created int64
+	var priority int
+	if err := rows.Scan(&topic, &key, &label, &created, &titlePrefix, &priority, &tags); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var defaults *PublishKeyDefaults
+	if titlePrefix != "" || priority != 0 || tags != "" {
+		defaults = &PublishKeyDefaults{
+			TitlePrefix: titlePrefix,
+			Priority:    priority,
+			Tags:        util.SplitNoEmpty(tags, ","),
+		}
+	}
+	return &PublishKey{
+		Value:    key,
+		Topic:    unescapeUnderscore(topic),
+		Label:    label,
+		Created:  time.Unix(created, 0),
+		Defaults: defaults,
+	}, nil
+}
+
+// RemovePublishKey deletes the given publish key for the given user and topic
+func (a *Manager) RemovePublishKey(username, topic, key string) error {
+	if key == "" {
+		return errNoTokenProvided
+	}
+	if _, err := a.db.Exec(deletePublishKeyQuery, username, escapeUnderscore(topic), key); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AuthorizePublishKey returns nil if the given publish key allows publishing to the given topic. The key is
+// only valid if the user that created it still owns the topic reservation.
+func (a *Manager) AuthorizePublishKey(key, topic string) error {
+	publishKey, err := a.PublishKey(key)
+	if err != nil {
+		return err
+	} else if publishKey.Topic != topic {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// PhoneNumbers returns all phone numbers for the user with the given user ID
+func (a *Manager) PhoneNumbers(userID string) ([]string, error) {
+	rows, err := a.db.Query(selectPhoneNumbersQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	phoneNumbers := make([]string, 0)
+	for {
+		phoneNumber, err := a.readPhoneNumber(rows)
+		if err == ErrPhoneNumberNotFound {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		phoneNumbers = append(phoneNumbers, phoneNumber)
+	}
+	return phoneNumbers, nil
+}
+
+func (a *Manager) readPhoneNumber(rows *sql.Rows) (string, error) {
+	var phoneNumber string
+	if !rows.Next() {
+		return "", ErrPhoneNumberNotFound
+	}
+	if err := rows.Scan(&phoneNumber); err != nil {
+		return "", err
 	} else if err := rows.Err(); err != nil {
 		return "", err
 	}
@@ -834,31 +1782,34 @@ func (a *Manager) Authorize(user *User, topic string, perm Permission) error {
 		username = user.Name
 	}
 	// Select the read/write permissions for this user/topic combo.
-	// - The query may return two rows (one for everyone, and one for the user), but prioritizes the user.
+	// - The query may return multiple rows (for everyone, the user, and the user's groups), but prioritizes the
+	//   user's own entries over group entries, and group entries over entries for everyone.
 	// - Furthermore, the query prioritizes more specific permissions (longer!) over more generic ones, e.g. "test*" > "*"
 	// - It also prioritizes write permissions over read permissions
-	rows, err := a.db.Query(selectTopicPermsQuery, Everyone, username, topic)
+	rows, err := a.db.Query(selectTopicPermsQuery, username, Everyone, username, topic, username, topic)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 	if !rows.Next() {
-		return a.resolvePerms(a.defaultAccess, perm)
+		return a.resolvePerms(user, a.defaultAccess, perm)
 	}
-	var read, write bool
-	if err := rows.Scan(&read, &write); err != nil {
+	var read, write, authWrite bool
+	if err := rows.Scan(&read, &write, &authWrite); err != nil {
 		return err
 	} else if err := rows.Err(); err != nil {
 		return err
 	}
-	return a.resolvePerms(NewPermission(read, write), perm)
+	return a.resolvePerms(user, newPermissionWithAuthWrite(read, write, authWrite), perm)
 }
 
-func (a *Manager) resolvePerms(base, perm Permission) error {
+func (a *Manager) resolvePerms(user *User, base, perm Permission) error {
 	if perm == PermissionRead && base.IsRead() {
 		return nil
 	} else if perm == PermissionWrite && base.IsWrite() {
 		return nil
+	} else if perm == PermissionWrite && base.IsAuthWrite() && user != nil {
+		return nil
 	}
 	return ErrUnauthorized
 }
@@ -872,9 +1823,24 @@ func (a *Manager) AddUser(username, password string, role Role) error {
 	if err != nil {
 		return err
 	}
+	return a.addUser(username, hash, role)
+}
+
+// AddUserWithHash adds a user with the given username, bcrypt password hash and role, e.g. when
+// importing users that were exported from another server
+func (a *Manager) AddUserWithHash(username, hash string, role Role) error {
+	if !AllowedUsername(username) || !AllowedRole(role) {
+		return ErrInvalidArgument
+	} else if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return ErrInvalidArgument
+	}
+	return a.addUser(username, []byte(hash), role)
+}
+
+func (a *Manager) addUser(username string, hash []byte, role Role) error {
 	userID := util.RandomStringPrefix(userIDPrefix, userIDLength)
 	syncTopic, now := util.RandomStringPrefix(syncTopicPrefix, syncTopicLength), time.Now().Unix()
-	if _, err = a.db.Exec(insertUserQuery, userID, username, hash, role, syncTopic, now); err != nil {
+	if _, err := a.db.Exec(insertUserQuery, userID, username, hash, role, syncTopic, now); err != nil {
 		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
 			return ErrUserExists
 		}
@@ -910,6 +1876,9 @@ func (a *Manager) MarkUserRemoved(user *User) error {
 	if _, err := tx.Exec(deleteUserAccessQuery, user.Name, user.Name); err != nil {
 		return err
 	}
+	if _, err := tx.Exec(deleteAllOwnedGroupAccessQuery, user.Name); err != nil {
+		return err
+	}
 	if _, err := tx.Exec(deleteAllTokenQuery, user.ID); err != nil {
 		return err
 	}
@@ -919,6 +1888,53 @@ func (a *Manager) MarkUserRemoved(user *User) error {
 	return tx.Commit()
 }
 
+// ScheduleUserDeletion marks the user for deletion at the given time. Until then, the user can still log in,
+// and the deletion can be canceled with CancelUserDeletion. Users that are due are returned by UsersDeletionDue.
+func (a *Manager) ScheduleUserDeletion(userID string, at time.Time) error {
+	if _, err := a.db.Exec(updateUserDeletionQuery, at.Unix(), userID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CancelUserDeletion cancels a deletion scheduled via ScheduleUserDeletion
+func (a *Manager) CancelUserDeletion(userID string) error {
+	if _, err := a.db.Exec(updateUserDeletionQuery, nil, userID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UsersDeletionDue returns all users whose scheduled deletion (see ScheduleUserDeletion) is due
+func (a *Manager) UsersDeletionDue() ([]*User, error) {
+	rows, err := a.db.Query(selectUsersDeletionDueQuery, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	usernames := make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	users := make([]*User, 0)
+	for _, username := range usernames {
+		user, err := a.User(username)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
 // Users returns a list of users. It always also returns the Everyone user ("*").
 func (a *Manager) Users() ([]*User, error) {
 	rows, err := a.db.Query(selectUsernamesQuery)
@@ -1006,11 +2022,12 @@ func (a *Manager) readUser(rows *sql.Rows) (*User, error) {
 	var id, username, hash, role, prefs, syncTopic string
 	var stripeCustomerID, stripeSubscriptionID, stripeSubscriptionStatus, stripeSubscriptionInterval, stripeMonthlyPriceID, stripeYearlyPriceID, tierID, tierCode, tierName sql.NullString
 	var messages, emails, calls int64
-	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit, stripeSubscriptionPaidUntil, stripeSubscriptionCancelAt, deleted sql.NullInt64
+	var disabled bool
+	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit, messageSizeLimit, stripeSubscriptionPaidUntil, stripeSubscriptionCancelAt, deleted, deletionScheduled sql.NullInt64
 	if !rows.Next() {
 		return nil, ErrUserNotFound
 	}
-	if err := rows.Scan(&id, &username, &hash, &role, &prefs, &syncTopic, &messages, &emails, &calls, &stripeCustomerID, &stripeSubscriptionID, &stripeSubscriptionStatus, &stripeSubscriptionInterval, &stripeSubscriptionPaidUntil, &stripeSubscriptionCancelAt, &deleted, &tierID, &tierCode, &tierName, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID); err != nil {
+	if err := rows.Scan(&id, &username, &hash, &role, &prefs, &syncTopic, &messages, &emails, &calls, &stripeCustomerID, &stripeSubscriptionID, &stripeSubscriptionStatus, &stripeSubscriptionInterval, &stripeSubscriptionPaidUntil, &stripeSubscriptionCancelAt, &deleted, &disabled, &deletionScheduled, &tierID, &tierCode, &tierName, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &messageSizeLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID); err != nil {
 		return nil, err
 	} else if err := rows.Err(); err != nil {
 		return nil, err
@@ -1035,7 +2052,11 @@ func (a *Manager) readUser(rows *sql.Rows) (*User, error) {
 			StripeSubscriptionPaidUntil: time.Unix(stripeSubscriptionPaidUntil.Int64, 0),                  // May be zero
 			StripeSubscriptionCancelAt:  time.Unix(stripeSubscriptionCancelAt.Int64, 0),                   // May be zero
 		},
-		Deleted: deleted.Valid,
+		Deleted:  deleted.Valid,
+		Disabled: disabled,
+	}
+	if deletionScheduled.Valid {
+		user.DeletionScheduled = time.Unix(deletionScheduled.Int64, 0)
 	}
 	if err := json.Unmarshal([]byte(prefs), user.Prefs); err != nil {
 		return nil, err
@@ -1055,6 +2076,7 @@ func (a *Manager) readUser(rows *sql.Rows) (*User, error) {
 			AttachmentTotalSizeLimit: attachmentTotalSizeLimit.Int64,
 			AttachmentExpiryDuration: time.Duration(attachmentExpiryDuration.Int64) * time.Second,
 			AttachmentBandwidthLimit: attachmentBandwidthLimit.Int64,
+			MessageSizeLimit:         messageSizeLimit.Int64,
 			StripeMonthlyPriceID:     stripeMonthlyPriceID.String, // May be empty
 			StripeYearlyPriceID:      stripeYearlyPriceID.String,  // May be empty
 		}
@@ -1072,8 +2094,8 @@ func (a *Manager) AllGrants() (map[string][]Grant, error) {
 	grants := make(map[string][]Grant, 0)
 	for rows.Next() {
 		var userID, topic string
-		var read, write bool
-		if err := rows.Scan(&userID, &topic, &read, &write); err != nil {
+		var read, write, authWrite bool
+		if err := rows.Scan(&userID, &topic, &read, &write, &authWrite); err != nil {
 			return nil, err
 		} else if err := rows.Err(); err != nil {
 			return nil, err
@@ -1083,7 +2105,7 @@ func (a *Manager) AllGrants() (map[string][]Grant, error) {
 		}
 		grants[userID] = append(grants[userID], Grant{
 			TopicPattern: fromSQLWildcard(topic),
-			Allow:        NewPermission(read, write),
+			Allow:        newPermissionWithAuthWrite(read, write, authWrite),
 		})
 	}
 	return grants, nil
@@ -1099,15 +2121,15 @@ func (a *Manager) Grants(username string) ([]Grant, error) {
 	grants := make([]Grant, 0)
 	for rows.Next() {
 		var topic string
-		var read, write bool
-		if err := rows.Scan(&topic, &read, &write); err != nil {
+		var read, write, authWrite bool
+		if err := rows.Scan(&topic, &read, &write, &authWrite); err != nil {
 			return nil, err
 		} else if err := rows.Err(); err != nil {
 			return nil, err
 		}
 		grants = append(grants, Grant{
 			TopicPattern: fromSQLWildcard(topic),
-			Allow:        NewPermission(read, write),
+			Allow:        newPermissionWithAuthWrite(read, write, authWrite),
 		})
 	}
 	return grants, nil
@@ -1124,16 +2146,39 @@ func (a *Manager) Reservations(username string) ([]Reservation, error) {
 	for rows.Next() {
 		var topic string
 		var ownerRead, ownerWrite bool
-		var everyoneRead, everyoneWrite sql.NullBool
-		if err := rows.Scan(&topic, &ownerRead, &ownerWrite, &everyoneRead, &everyoneWrite); err != nil {
+		var everyoneRead, everyoneWrite, everyoneAuthWrite sql.NullBool
+		var group, sound, routing, escalation, schedule, topicPolicy sql.NullString
+		if err := rows.Scan(&topic, &ownerRead, &ownerWrite, &everyoneRead, &everyoneWrite, &everyoneAuthWrite, &group, &sound, &routing, &escalation, &schedule, &topicPolicy); err != nil {
 			return nil, err
 		} else if err := rows.Err(); err != nil {
 			return nil, err
 		}
+		rules, err := parseRoutingRules(routing.String)
+		if err != nil {
+			return nil, err
+		}
+		policy, err := parseEscalation(escalation.String)
+		if err != nil {
+			return nil, err
+		}
+		rotation, err := parseSchedule(schedule.String)
+		if err != nil {
+			return nil, err
+		}
+		restrictions, err := parseTopicPolicy(topicPolicy.String)
+		if err != nil {
+			return nil, err
+		}
 		reservations = append(reservations, Reservation{
-			Topic:    unescapeUnderscore(topic),
-			Owner:    NewPermission(ownerRead, ownerWrite),
-			Everyone: NewPermission(everyoneRead.Bool, everyoneWrite.Bool), // false if null
+			Topic:      fromSQLWildcard(topic),
+			Owner:      NewPermission(ownerRead, ownerWrite),
+			Everyone:   newPermissionWithAuthWrite(everyoneRead.Bool, everyoneWrite.Bool, everyoneAuthWrite.Bool), // false if null
+			Group:      group.String,
+			Sound:      sound.String,
+			Routing:    rules,
+			Escalation: policy,
+			Schedule:   rotation,
+			Policy:     restrictions,
 		})
 	}
 	return reservations, nil
@@ -1141,7 +2186,7 @@ func (a *Manager) Reservations(username string) ([]Reservation, error) {
 
 // HasReservation returns true if the given topic access is owned by the user
 func (a *Manager) HasReservation(username, topic string) (bool, error) {
-	rows, err := a.db.Query(selectUserHasReservationQuery, username, escapeUnderscore(topic))
+	rows, err := a.db.Query(selectUserHasReservationQuery, username, toSQLWildcard(topic))
 	if err != nil {
 		return false, err
 	}
@@ -1174,9 +2219,9 @@ func (a *Manager) ReservationsCount(username string) (int64, error) {
 }
 
 // ReservationOwner returns user ID of the user that owns this topic, or an
-// empty string if it's not owned by anyone
+// empty string if it's not owned by anyone. Topics may be owned via a prefix reservation.
 func (a *Manager) ReservationOwner(topic string) (string, error) {
-	rows, err := a.db.Query(selectUserReservationsOwnerQuery, escapeUnderscore(topic))
+	rows, err := a.db.Query(selectUserReservationsOwnerQuery, escapeUnderscore(topic), topic)
 	if err != nil {
 		return "", err
 	}
@@ -1191,6 +2236,164 @@ func (a *Manager) ReservationOwner(topic string) (string, error) {
 	return ownerUserID, nil
 }
 
+// ReservationSound returns the default notification sound of the reservation that covers the given topic,
+// or an empty string if the topic is not reserved or no sound is set. Prefix reservations are matched, too.
+func (a *Manager) ReservationSound(topic string) (string, error) {
+	rows, err := a.db.Query(selectReservationSoundQuery, escapeUnderscore(topic), topic)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", nil
+	}
+	var sound string
+	if err := rows.Scan(&sound); err != nil {
+		return "", err
+	}
+	return sound, nil
+}
+
+// ReservationRouting returns the routing rules of the reservation that covers the given topic, and the ID of the
+// user owning the reservation. If the topic is not reserved or no rules are defined, no rules are returned.
+// Prefix reservations are matched, too.
+func (a *Manager) ReservationRouting(topic string) (rules []*RoutingRule, ownerUserID string, err error) {
+	rows, err := a.db.Query(selectReservationRoutingQuery, escapeUnderscore(topic), topic)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, "", nil
+	}
+	var routing string
+	if err := rows.Scan(&routing, &ownerUserID); err != nil {
+		return nil, "", err
+	}
+	rules, err = parseRoutingRules(routing)
+	if err != nil {
+		return nil, "", err
+	}
+	return rules, ownerUserID, nil
+}
+
+// ReservationEscalation returns the escalation policy of the reservation that covers the given topic, and the ID of
+// the user owning the reservation. If the topic is not reserved or has no escalation policy, nil is returned.
+// Prefix reservations are matched, too.
+func (a *Manager) ReservationEscalation(topic string) (policy *Escalation, ownerUserID string, err error) {
+	rows, err := a.db.Query(selectReservationEscalationQuery, escapeUnderscore(topic), topic)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, "", nil
+	}
+	var escalation string
+	if err := rows.Scan(&escalation, &ownerUserID); err != nil {
+		return nil, "", err
+	}
+	policy, err = parseEscalation(escalation)
+	if err != nil {
+		return nil, "", err
+	}
+	return policy, ownerUserID, nil
+}
+
+// ReservationSchedule returns the on-call schedule of the reservation that covers the given topic, and the ID of
+// the user owning the reservation. If the topic is not reserved or has no schedule, nil is returned.
+// Prefix reservations are matched, too.
+func (a *Manager) ReservationSchedule(topic string) (schedule *Schedule, ownerUserID string, err error) {
+	rows, err := a.db.Query(selectReservationScheduleQuery, escapeUnderscore(topic), topic)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, "", nil
+	}
+	var value string
+	if err := rows.Scan(&value, &ownerUserID); err != nil {
+		return nil, "", err
+	}
+	schedule, err = parseSchedule(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return schedule, ownerUserID, nil
+}
+
+// ReservationPolicy returns the topic policy of the reservation that covers the given topic. If the topic is not
+// reserved or has no policy, nil is returned. Prefix reservations are matched, too.
+func (a *Manager) ReservationPolicy(topic string) (*TopicPolicy, error) {
+	rows, err := a.db.Query(selectReservationPolicyQuery, escapeUnderscore(topic), topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	var value string
+	if err := rows.Scan(&value); err != nil {
+		return nil, err
+	}
+	return parseTopicPolicy(value)
+}
+
+// InactiveReservations returns all reservations that have not seen any activity since the given time,
+// oldest first. Provisioned reservations never become inactive.
+func (a *Manager) InactiveReservations(inactiveSince time.Time) ([]*InactiveReservation, error) {
+	rows, err := a.db.Query(selectInactiveReservationsQuery, inactiveSince.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reservations := make([]*InactiveReservation, 0)
+	for rows.Next() {
+		var topic, userID, username string
+		var lastActive, warnedAt int64
+		if err := rows.Scan(&topic, &userID, &username, &lastActive, &warnedAt); err != nil {
+			return nil, err
+		}
+		reservation := &InactiveReservation{
+			Topic:      fromSQLWildcard(topic),
+			UserID:     userID,
+			Username:   username,
+			LastActive: time.Unix(lastActive, 0),
+		}
+		if warnedAt > 0 {
+			reservation.WarnedAt = time.Unix(warnedAt, 0)
+		}
+		reservations = append(reservations, reservation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// MarkReservationsActive sets the last activity time of the reservations for the given topics (including
+// prefix reservations matching the topics), and resets the inactivity warning. Topics that are not reserved are ignored.
+func (a *Manager) MarkReservationsActive(topics []string, lastActive time.Time) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	topicsJSON, err := json.Marshal(topics) // Topics are matched against reservations with LIKE, see query
+	if err != nil {
+		return err
+	}
+	_, err = a.db.Exec(updateReservationsLastActiveQuery, lastActive.Unix(), string(topicsJSON))
+	return err
+}
+
+// MarkReservationInactivityWarned records when the owner of the reserved topic was warned that the
+// reservation will be released, so that the owner is only notified once
+func (a *Manager) MarkReservationInactivityWarned(topic string, warnedAt time.Time) error {
+	_, err := a.db.Exec(updateReservationInactivityWarnedQuery, warnedAt.Unix(), toSQLWildcard(topic))
+	return err
+}
+
 // ChangePassword changes a user's password
 func (a *Manager) ChangePassword(username, password string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), a.bcryptCost)
@@ -1203,6 +2406,17 @@ func (a *Manager) ChangePassword(username, password string) error {
 	return nil
 }
 
+// ChangePasswordHash changes a user's password to the given bcrypt password hash
+func (a *Manager) ChangePasswordHash(username, hash string) error {
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return ErrInvalidArgument
+	}
+	if _, err := a.db.Exec(updateUserPassQuery, []byte(hash), username); err != nil {
+		return err
+	}
+	return nil
+}
+
 // ChangeRole changes a user's role. When a role is changed from RoleUser to RoleAdmin,
 // all existing access control entries (Grant) are removed, since they are no longer needed.
 func (a *Manager) ChangeRole(username string, role Role) error {
@@ -1268,10 +2482,10 @@ func (a *Manager) checkReservationsLimit(username string, reservationsLimit int6
 // AllowReservation tests if a user may create an access control entry for the given topic.
 // If there are any ACL entries that are not owned by the user, an error is returned.
 func (a *Manager) AllowReservation(username string, topic string) error {
-	if (!AllowedUsername(username) && username != Everyone) || !AllowedTopic(topic) {
+	if (!AllowedUsername(username) && username != Everyone) || !AllowedReservation(topic) {
 		return ErrInvalidArgument
 	}
-	rows, err := a.db.Query(selectOtherAccessCountQuery, escapeUnderscore(topic), escapeUnderscore(topic), username)
+	rows, err := a.db.Query(selectOtherAccessCountQuery, toSQLWildcard(topic), topic, toSQLWildcard(topic), username)
 	if err != nil {
 		return err
 	}
@@ -1299,7 +2513,7 @@ func (a *Manager) AllowAccess(username string, topicPattern string, permission P
 		return ErrInvalidArgument
 	}
 	owner := ""
-	if _, err := a.db.Exec(upsertUserAccessQuery, username, toSQLWildcard(topicPattern), permission.IsRead(), permission.IsWrite(), owner, owner); err != nil {
+	if _, err := a.db.Exec(upsertUserAccessQuery, username, toSQLWildcard(topicPattern), permission.IsRead(), permission.IsWrite(), permission.IsAuthWrite(), owner, owner); err != nil {
 		return err
 	}
 	return nil
@@ -1313,22 +2527,38 @@ func (a *Manager) ResetAccess(username string, topicPattern string) error {
 	} else if !AllowedTopicPattern(topicPattern) && topicPattern != "" {
 		return ErrInvalidArgument
 	}
-	if username == "" && topicPattern == "" {
-		_, err := a.db.Exec(deleteAllAccessQuery, username)
+	tx, err := a.db.Begin()
+	if err != nil {
 		return err
+	}
+	defer tx.Rollback()
+	if username == "" && topicPattern == "" {
+		if _, err := tx.Exec(deleteAllAccessQuery); err != nil {
+			return err
+		} else if _, err := tx.Exec(deleteAllReservationGroupAccessQuery); err != nil {
+			return err
+		}
 	} else if topicPattern == "" {
-		_, err := a.db.Exec(deleteUserAccessQuery, username, username)
-		return err
+		if _, err := tx.Exec(deleteUserAccessQuery, username, username); err != nil {
+			return err
+		} else if _, err := tx.Exec(deleteAllOwnedGroupAccessQuery, username); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.Exec(deleteTopicAccessQuery, username, username, toSQLWildcard(topicPattern)); err != nil {
+			return err
+		} else if _, err := tx.Exec(deleteOwnedGroupAccessQuery, username, toSQLWildcard(topicPattern)); err != nil {
+			return err
+		}
 	}
-	_, err := a.db.Exec(deleteTopicAccessQuery, username, username, toSQLWildcard(topicPattern))
-	return err
+	return tx.Commit()
 }
 
 // AddReservation creates two access control entries for the given topic: one with full read/write access for the
 // given user, and one for Everyone with the permission passed as everyone. The user also owns the entries, and
-// can modify or delete them.
+// can modify or delete them. The topic may be a topic prefix (e.g. "myteam-*"), see AllowedTopicPrefix.
 func (a *Manager) AddReservation(username string, topic string, everyone Permission) error {
-	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(topic) {
+	if !AllowedUsername(username) || username == Everyone || !AllowedReservation(topic) {
 		return ErrInvalidArgument
 	}
 	tx, err := a.db.Begin()
@@ -1336,10 +2566,10 @@ func (a *Manager) AddReservation(username string, topic string, everyone Permiss
 		return err
 	}
 	defer tx.Rollback()
-	if _, err := tx.Exec(upsertUserAccessQuery, username, escapeUnderscore(topic), true, true, username, username); err != nil {
+	if _, err := tx.Exec(upsertUserAccessQuery, username, toSQLWildcard(topic), true, true, false, username, username); err != nil {
 		return err
 	}
-	if _, err := tx.Exec(upsertUserAccessQuery, Everyone, escapeUnderscore(topic), everyone.IsRead(), everyone.IsWrite(), username, username); err != nil {
+	if _, err := tx.Exec(upsertUserAccessQuery, Everyone, toSQLWildcard(topic), everyone.IsRead(), everyone.IsWrite(), everyone.IsAuthWrite(), username, username); err != nil {
 		return err
 	}
 	return tx.Commit()
@@ -1352,7 +2582,7 @@ func (a *Manager) RemoveReservations(username string, topics ...string) error {
 		return ErrInvalidArgument
 	}
 	for _, topic := range topics {
-		if !AllowedTopic(topic) {
+		if !AllowedReservation(topic) {
 			return ErrInvalidArgument
 		}
 	}
@@ -1362,47 +2592,422 @@ func (a *Manager) RemoveReservations(username string, topics ...string) error {
 	}
 	defer tx.Rollback()
 	for _, topic := range topics {
-		if _, err := tx.Exec(deleteTopicAccessQuery, username, username, escapeUnderscore(topic)); err != nil {
+		if _, err := tx.Exec(deleteTopicAccessQuery, username, username, toSQLWildcard(topic)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteTopicAccessQuery, Everyone, Everyone, toSQLWildcard(topic)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteTopicPublishKeysQuery, username, escapeUnderscore(topic)); err != nil {
 			return err
 		}
-		if _, err := tx.Exec(deleteTopicAccessQuery, Everyone, Everyone, escapeUnderscore(topic)); err != nil {
+		if _, err := tx.Exec(deleteOwnedGroupAccessQuery, username, toSQLWildcard(topic)); err != nil {
 			return err
 		}
 	}
 	return tx.Commit()
 }
 
-// DefaultAccess returns the default read/write access if no access control entry matches
-func (a *Manager) DefaultAccess() Permission {
-	return a.defaultAccess
-}
-
-// AddTier creates a new tier in the database
-func (a *Manager) AddTier(tier *Tier) error {
-	if tier.ID == "" {
-		tier.ID = util.RandomStringPrefix(tierIDPrefix, tierIDLength)
+// ShareReservation grants read-write access to a topic reserved by the given user to all members of the
+// given group. The entry is owned by the user, and is removed along with the reservation. Only one group can
+// be associated with a reservation; an empty group removes the association.
+func (a *Manager) ShareReservation(username, topic, group string) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedReservation(topic) {
+		return ErrInvalidArgument
+	} else if group != "" && !AllowedGroup(group) {
+		return ErrInvalidArgument
+	}
+	var groupID string
+	if group != "" {
+		var err error
+		if groupID, err = a.groupID(group); err != nil {
+			return err
+		}
 	}
-	if _, err := a.db.Exec(insertTierQuery, tier.ID, tier.Code, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID)); err != nil {
+	tx, err := a.db.Begin()
+	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+	if _, err := tx.Exec(deleteOwnedGroupAccessQuery, username, toSQLWildcard(topic)); err != nil {
+		return err
+	}
+	if group != "" {
+		if _, err := tx.Exec(upsertGroupAccessQuery, groupID, toSQLWildcard(topic), true, true, username, username); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-// UpdateTier updates a tier's properties in the database
-func (a *Manager) UpdateTier(tier *Tier) error {
-	if _, err := a.db.Exec(updateTierQuery, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID), tier.Code); err != nil {
+// ChangeReservationSound sets the default notification sound for a topic reserved by the given user. The sound
+// is used for all messages published to the topic that do not specify a sound. An empty sound removes the default.
+func (a *Manager) ChangeReservationSound(username, topic, sound string) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedReservation(topic) {
+		return ErrInvalidArgument
+	}
+	if _, err := a.db.Exec(updateReservationSoundQuery, sound, username, toSQLWildcard(topic)); err != nil {
 		return err
 	}
 	return nil
 }
 
-// RemoveTier deletes the tier with the given code
-func (a *Manager) RemoveTier(code string) error {
-	if !AllowedTier(code) {
+// ChangeReservationRouting sets the routing rules for a topic reserved by the given user, see RoutingRule.
+// The rules must have been validated by the caller (e.g. phone numbers must be verified). No rules remove the routing.
+func (a *Manager) ChangeReservationRouting(username, topic string, rules []*RoutingRule) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedReservation(topic) {
 		return ErrInvalidArgument
 	}
-	// This fails if any user has this tier
-	if _, err := a.db.Exec(deleteTierQuery, code); err != nil {
+	var routing string
+	if len(rules) > 0 {
+		b, err := json.Marshal(rules)
+		if err != nil {
+			return err
+		}
+		routing = string(b)
+	}
+	if _, err := a.db.Exec(updateReservationRoutingQuery, routing, username, toSQLWildcard(topic)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ChangeReservationEscalation sets the escalation policy for a topic reserved by the given user, see Escalation.
+// The policy must have been validated by the caller. A nil policy removes the escalation.
+func (a *Manager) ChangeReservationEscalation(username, topic string, policy *Escalation) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedReservation(topic) {
+		return ErrInvalidArgument
+	}
+	var escalation string
+	if policy != nil && len(policy.Steps) > 0 {
+		b, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+		escalation = string(b)
+	}
+	if _, err := a.db.Exec(updateReservationEscalationQuery, escalation, username, toSQLWildcard(topic)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ChangeReservationSchedule sets the on-call schedule for a topic reserved by the given user, see Schedule.
+// The schedule must have been validated by the caller. A nil schedule removes it.
+func (a *Manager) ChangeReservationSchedule(username, topic string, schedule *Schedule) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedReservation(topic) {
+		return ErrInvalidArgument
+	}
+	var value string
+	if schedule != nil && len(schedule.Members) > 0 {
+		b, err := json.Marshal(schedule)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+	if _, err := a.db.Exec(updateReservationScheduleQuery, value, username, toSQLWildcard(topic)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ChangeReservationPolicy sets the topic policy for a topic reserved by the given user, see TopicPolicy.
+// The policy must have been validated by the caller. A nil or empty policy removes it.
+func (a *Manager) ChangeReservationPolicy(username, topic string, policy *TopicPolicy) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedReservation(topic) {
+		return ErrInvalidArgument
+	}
+	var value string
+	if !policy.IsZero() {
+		b, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+	if _, err := a.db.Exec(updateReservationPolicyQuery, value, username, toSQLWildcard(topic)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AddGroup creates a new, empty group with the given name
+func (a *Manager) AddGroup(name string) error {
+	if !AllowedGroup(name) {
+		return ErrInvalidArgument
+	}
+	groupID := util.RandomStringPrefix(groupIDPrefix, groupIDLength)
+	if _, err := a.db.Exec(insertGroupQuery, groupID, name, time.Now().Unix()); err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return ErrGroupExists
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveGroup deletes the group with the given name. Memberships and access control entries
+// of the group are deleted via foreign keys.
+func (a *Manager) RemoveGroup(name string) error {
+	if !AllowedGroup(name) {
+		return ErrInvalidArgument
+	}
+	result, err := a.db.Exec(deleteGroupQuery, name)
+	if err != nil {
+		return err
+	} else if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrGroupNotFound
+	}
+	return nil
+}
+
+// Groups returns a list of all groups, including their members and access control entries
+func (a *Manager) Groups() ([]*Group, error) {
+	rows, err := a.db.Query(selectGroupsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := make([]*Group, 0)
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		groups = append(groups, &Group{ID: id, Name: name})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for _, group := range groups {
+		if err := a.readGroupDetails(group); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// Group returns the group with the given name, including its members and access control entries
+func (a *Manager) Group(name string) (*Group, error) {
+	groupID, err := a.groupID(name)
+	if err != nil {
+		return nil, err
+	}
+	group := &Group{ID: groupID, Name: name}
+	if err := a.readGroupDetails(group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// UserGroups returns the names of all groups the given user is a member of
+func (a *Manager) UserGroups(username string) ([]string, error) {
+	rows, err := a.db.Query(selectUserGroupsQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := make([]string, 0)
+	for rows.Next() {
+		var group string
+		if err := rows.Scan(&group); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// AddGroupMembers adds the given users to the group. Users that are already members are ignored.
+func (a *Manager) AddGroupMembers(name string, usernames ...string) error {
+	return a.changeGroupMembers(name, insertGroupMemberQuery, usernames...)
+}
+
+// RemoveGroupMembers removes the given users from the group. Users that are not members are ignored.
+func (a *Manager) RemoveGroupMembers(name string, usernames ...string) error {
+	return a.changeGroupMembers(name, deleteGroupMemberQuery, usernames...)
+}
+
+func (a *Manager) changeGroupMembers(name, query string, usernames ...string) error {
+	if !AllowedGroup(name) || len(usernames) == 0 {
+		return ErrInvalidArgument
+	}
+	for _, username := range usernames {
+		if !AllowedUsername(username) {
+			return ErrInvalidArgument
+		}
+	}
+	groupID, err := a.groupID(name)
+	if err != nil {
+		return err
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, username := range usernames {
+		if _, err := tx.Exec(query, groupID, username); err != nil {
+			if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintNotNull {
+				return ErrUserNotFound
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AllowGroupAccess adds or updates an entry in the access control list for a group. The entry applies to
+// all members of the group. The parameter topicPattern may include wildcards (*).
+func (a *Manager) AllowGroupAccess(name string, topicPattern string, permission Permission) error {
+	if !AllowedGroup(name) || !AllowedTopicPattern(topicPattern) || permission.IsAuthWrite() {
+		return ErrInvalidArgument
+	}
+	groupID, err := a.groupID(name)
+	if err != nil {
+		return err
+	}
+	owner := ""
+	if _, err := a.db.Exec(upsertGroupAccessQuery, groupID, toSQLWildcard(topicPattern), permission.IsRead(), permission.IsWrite(), owner, owner); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResetGroupAccess removes an access control list entry for a specific group/topic, or (if topic is empty)
+// all entries of the group. Reservations shared with the group are not affected.
+func (a *Manager) ResetGroupAccess(name string, topicPattern string) error {
+	if !AllowedGroup(name) || (!AllowedTopicPattern(topicPattern) && topicPattern != "") {
+		return ErrInvalidArgument
+	}
+	groupID, err := a.groupID(name)
+	if err != nil {
+		return err
+	}
+	if topicPattern == "" {
+		_, err = a.db.Exec(deleteGroupAccessQuery, groupID)
+		return err
+	}
+	_, err = a.db.Exec(deleteGroupTopicAccessQuery, groupID, toSQLWildcard(topicPattern))
+	return err
+}
+
+func (a *Manager) readGroupDetails(group *Group) error {
+	rows, err := a.db.Query(selectGroupMembersQuery, group.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	group.Members = make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return err
+		}
+		group.Members = append(group.Members, username)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	rows, err = a.db.Query(selectGroupAccessQuery, group.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	group.Grants = make([]Grant, 0)
+	for rows.Next() {
+		var topic string
+		var read, write bool
+		if err := rows.Scan(&topic, &read, &write); err != nil {
+			return err
+		}
+		group.Grants = append(group.Grants, Grant{
+			TopicPattern: fromSQLWildcard(topic),
+			Allow:        NewPermission(read, write),
+		})
+	}
+	return rows.Err()
+}
+
+func (a *Manager) groupID(name string) (string, error) {
+	rows, err := a.db.Query(selectGroupIDQuery, name)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", ErrGroupNotFound
+	}
+	var groupID string
+	if err := rows.Scan(&groupID); err != nil {
+		return "", err
+	}
+	return groupID, rows.Err()
+}
+
+// ChangeDisabled disables or re-enables the given user. Disabled users cannot authenticate. When a user
+// is disabled, all of its access tokens are deleted as well, so existing sessions are terminated.
+func (a *Manager) ChangeDisabled(username string, disabled bool) error {
+	if !AllowedUsername(username) {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(updateUserDisabledQuery, disabled, username)
+	if err != nil {
+		return err
+	} else if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrUserNotFound
+	}
+	if disabled {
+		if _, err := tx.Exec(deleteAllTokenByUsernameQuery, username); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DefaultAccess returns the default read/write access if no access control entry matches
+func (a *Manager) DefaultAccess() Permission {
+	return a.defaultAccess
+}
+
+// AddTier creates a new tier in the database
+func (a *Manager) AddTier(tier *Tier) error {
+	if tier.ID == "" {
+		tier.ID = util.RandomStringPrefix(tierIDPrefix, tierIDLength)
+	}
+	if _, err := a.db.Exec(insertTierQuery, tier.ID, tier.Code, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, tier.MessageSizeLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateTier updates a tier's properties in the database
+func (a *Manager) UpdateTier(tier *Tier) error {
+	if _, err := a.db.Exec(updateTierQuery, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, tier.MessageSizeLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID), tier.Code); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveTier deletes the tier with the given code
+func (a *Manager) RemoveTier(code string) error {
+	if !AllowedTier(code) {
+		return ErrInvalidArgument
+	}
+	// This fails if any user has this tier
+	if _, err := a.db.Exec(deleteTierQuery, code); err != nil {
 		return err
 	}
 	return nil
@@ -1459,32 +3064,699 @@ func (a *Manager) TierByStripePrice(priceID string) (*Tier, error) {
 func (a *Manager) readTier(rows *sql.Rows) (*Tier, error) {
 	var id, code, name string
 	var stripeMonthlyPriceID, stripeYearlyPriceID sql.NullString
-	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit sql.NullInt64
+	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit, messageSizeLimit sql.NullInt64
 	if !rows.Next() {
 		return nil, ErrTierNotFound
 	}
-	if err := rows.Scan(&id, &code, &name, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID); err != nil {
+	if err := rows.Scan(&id, &code, &name, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &messageSizeLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID); err != nil {
 		return nil, err
 	} else if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	// When changed, note readUser() as well
-	return &Tier{
-		ID:                       id,
-		Code:                     code,
-		Name:                     name,
-		MessageLimit:             messagesLimit.Int64,
-		MessageExpiryDuration:    time.Duration(messagesExpiryDuration.Int64) * time.Second,
-		EmailLimit:               emailsLimit.Int64,
-		CallLimit:                callsLimit.Int64,
-		ReservationLimit:         reservationsLimit.Int64,
-		AttachmentFileSizeLimit:  attachmentFileSizeLimit.Int64,
-		AttachmentTotalSizeLimit: attachmentTotalSizeLimit.Int64,
-		AttachmentExpiryDuration: time.Duration(attachmentExpiryDuration.Int64) * time.Second,
-		AttachmentBandwidthLimit: attachmentBandwidthLimit.Int64,
-		StripeMonthlyPriceID:     stripeMonthlyPriceID.String, // May be empty
-		StripeYearlyPriceID:      stripeYearlyPriceID.String,  // May be empty
-	}, nil
+	// When changed, note readUser() as well
+	return &Tier{
+		ID:                       id,
+		Code:                     code,
+		Name:                     name,
+		MessageLimit:             messagesLimit.Int64,
+		MessageExpiryDuration:    time.Duration(messagesExpiryDuration.Int64) * time.Second,
+		EmailLimit:               emailsLimit.Int64,
+		CallLimit:                callsLimit.Int64,
+		ReservationLimit:         reservationsLimit.Int64,
+		AttachmentFileSizeLimit:  attachmentFileSizeLimit.Int64,
+		AttachmentTotalSizeLimit: attachmentTotalSizeLimit.Int64,
+		AttachmentExpiryDuration: time.Duration(attachmentExpiryDuration.Int64) * time.Second,
+		AttachmentBandwidthLimit: attachmentBandwidthLimit.Int64,
+		MessageSizeLimit:         messageSizeLimit.Int64,
+		StripeMonthlyPriceID:     stripeMonthlyPriceID.String, // May be empty
+		StripeYearlyPriceID:      stripeYearlyPriceID.String,  // May be empty
+	}, nil
+}
+
+// Provision reconciles the database with the given provisioning config: Provisioned tiers, users, access
+// control entries and tokens are created or updated, and previously provisioned entries that are no longer
+// part of the config are removed. Entries created via the CLI or the web app are left untouched, unless they
+// are overridden by the config, in which case they become provisioned.
+func (a *Manager) Provision(p *Provisioning) error {
+	if err := a.validateProvisioning(p); err != nil {
+		return err
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := a.provisionTiers(tx, p.Tiers); err != nil {
+		return err
+	}
+	if err := a.provisionUsers(tx, p.Users); err != nil {
+		return err
+	}
+	if err := a.removeStaleProvisionedTiers(tx, p.Tiers); err != nil {
+		return err
+	}
+	if err := a.provisionAccess(tx, p.Access); err != nil {
+		return err
+	}
+	if err := a.provisionTokens(tx, p.Tokens); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (a *Manager) validateProvisioning(p *Provisioning) error {
+	tierCodes := make(map[string]bool)
+	for _, tier := range p.Tiers {
+		if !AllowedTier(tier.Code) || tierCodes[tier.Code] {
+			return fmt.Errorf("%w: invalid or duplicate tier %s", ErrInvalidArgument, tier.Code)
+		}
+		tierCodes[tier.Code] = true
+	}
+	usernames := make(map[string]bool)
+	for _, u := range p.Users {
+		if !AllowedUsername(u.Name) || !AllowedRole(u.Role) || usernames[u.Name] {
+			return fmt.Errorf("%w: invalid or duplicate user %s", ErrInvalidArgument, u.Name)
+		} else if _, err := bcrypt.Cost([]byte(u.Hash)); err != nil {
+			return fmt.Errorf("%w: password hash for user %s is not a valid bcrypt hash", ErrInvalidArgument, u.Name)
+		} else if u.Tier != nil && !tierCodes[u.Tier.Code] {
+			if _, err := a.Tier(u.Tier.Code); err != nil {
+				return fmt.Errorf("%w: tier %s for user %s does not exist", ErrInvalidArgument, u.Tier.Code, u.Name)
+			}
+		}
+		usernames[u.Name] = true
+	}
+	for username, grants := range p.Access {
+		if username != Everyone && !usernames[username] {
+			return fmt.Errorf("%w: access entries for user %s, but user is not provisioned", ErrInvalidArgument, username)
+		}
+		for _, grant := range grants {
+			if !AllowedTopicPattern(grant.TopicPattern) {
+				return fmt.Errorf("%w: invalid topic pattern %s for user %s", ErrInvalidArgument, grant.TopicPattern, username)
+			}
+		}
+	}
+	tokens := make(map[string]bool)
+	for username, userTokens := range p.Tokens {
+		if !usernames[username] {
+			return fmt.Errorf("%w: tokens for user %s, but user is not provisioned", ErrInvalidArgument, username)
+		}
+		for _, token := range userTokens {
+			if strings.HasPrefix(token.Value, tokenPrefix) {
+				return fmt.Errorf("%w: plaintext token for user %s, tokens must be provided as SHA-256 hash, see 'ntfy token hash'", ErrInvalidArgument, username)
+			} else if !AllowedTokenHash(token.Value) || tokens[token.Value] {
+				return fmt.Errorf("%w: invalid or duplicate token hash for user %s, token hashes must be 64 hex characters", ErrInvalidArgument, username)
+			}
+			tokens[token.Value] = true
+		}
+	}
+	return nil
+}
+
+func (a *Manager) provisionTiers(tx *sql.Tx, tiers []*Tier) error {
+	for _, tier := range tiers {
+		result, err := tx.Exec(updateTierQuery, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, tier.MessageSizeLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID), tier.Code)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rows == 0 {
+			tierID := util.RandomStringPrefix(tierIDPrefix, tierIDLength)
+			if _, err := tx.Exec(insertTierQuery, tierID, tier.Code, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, tier.MessageSizeLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(updateTierProvisionedQuery, tier.Code); err != nil {
+			return err
+		}
+		log.Tag(tag).Debug("Provisioned tier %s", tier.Code)
+	}
+	return nil
+}
+
+func (a *Manager) removeStaleProvisionedTiers(tx *sql.Tx, tiers []*Tier) error {
+	configured := make(map[string]bool)
+	for _, tier := range tiers {
+		configured[tier.Code] = true
+	}
+	codes, err := selectStrings(tx, selectProvisionedTierCodesQuery)
+	if err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if configured[code] {
+			continue
+		}
+		log.Tag(tag).Info("Removing tier %s, since it is no longer provisioned", code)
+		if _, err := tx.Exec(deleteUserTierByTierCodeQuery, code); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteTierQuery, code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Manager) provisionUsers(tx *sql.Tx, users []*User) error {
+	configured := make(map[string]bool)
+	for _, u := range users {
+		userID := util.RandomStringPrefix(userIDPrefix, userIDLength)
+		syncTopic, now := util.RandomStringPrefix(syncTopicPrefix, syncTopicLength), time.Now().Unix()
+		if _, err := tx.Exec(upsertProvisionedUserQuery, userID, u.Name, u.Hash, u.Role, syncTopic, now); err != nil {
+			return err
+		}
+		if u.Tier != nil {
+			if _, err := tx.Exec(updateUserTierQuery, u.Tier.Code, u.Name); err != nil {
+				return err
+			}
+		} else if _, err := tx.Exec(deleteUserTierQuery, u.Name); err != nil {
+			return err
+		}
+		configured[u.Name] = true
+		log.Tag(tag).Debug("Provisioned user %s", u.Name)
+	}
+	usernames, err := selectStrings(tx, selectProvisionedUsernamesQuery)
+	if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		if configured[username] {
+			continue
+		}
+		log.Tag(tag).Info("Removing user %s, since it is no longer provisioned", username)
+		// Rows in user_access, user_token, etc. are deleted via foreign keys
+		if _, err := tx.Exec(deleteUserQuery, username); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Manager) provisionAccess(tx *sql.Tx, access map[string][]Grant) error {
+	if _, err := tx.Exec(deleteProvisionedUserAccessQuery); err != nil {
+		return err
+	}
+	for username, grants := range access {
+		for _, grant := range grants {
+			if _, err := tx.Exec(upsertProvisionedUserAccessQuery, username, toSQLWildcard(grant.TopicPattern), grant.Allow.IsRead(), grant.Allow.IsWrite(), grant.Allow.IsAuthWrite()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Manager) provisionTokens(tx *sql.Tx, tokens map[string][]*Token) error {
+	configured := make(map[string]bool)
+	for username, userTokens := range tokens {
+		for _, token := range userTokens {
+			if _, err := tx.Exec(deleteTokenFromOtherUsersQuery, token.Value, username); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(upsertProvisionedTokenQuery, username, token.Value, token.Label, time.Now().Unix(), netip.IPv4Unspecified().String()); err != nil {
+				return err
+			}
+			configured[token.Value] = true
+		}
+	}
+	rows, err := tx.Query(selectProvisionedTokensQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	stale := make([][2]string, 0)
+	for rows.Next() {
+		var userID, token string
+		if err := rows.Scan(&userID, &token); err != nil {
+			return err
+		} else if !configured[token] {
+			stale = append(stale, [2]string{userID, token})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	for _, t := range stale {
+		if _, err := tx.Exec(deleteTokenQuery, t[0], t[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func selectStrings(tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make([]string, 0)
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Changes returns the current state of all users, groups and tiers that were added, changed or removed after the
+// change with the given position, including the access control entries, tokens and publish keys of the users, and
+// the members and access control entries of the groups. If since is zero, or if the changes after it are no longer
+// available (see RemoveOldChanges), everything is returned and Changes.Full is set. The returned Changes.Position
+// can be passed to the next call.
+func (a *Manager) Changes(since int64) (*Changes, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var position, firstID int64
+	if err := tx.QueryRow(selectChangePositionQuery).Scan(&position); err != nil {
+		return nil, err
+	} else if err := tx.QueryRow(selectChangeFirstIDQuery).Scan(&firstID); err != nil {
+		return nil, err
+	}
+	changes := &Changes{
+		Position: position,
+		Full:     since <= 0 || since > position || (since < position && (firstID == 0 || since+1 < firstID)),
+		Users:    make([]*UserChange, 0),
+		Groups:   make([]*GroupChange, 0),
+		Tiers:    make([]*TierChange, 0),
+	}
+	changed := func(kind, allQuery string) ([]string, error) {
+		if changes.Full {
+			return selectStrings(tx, allQuery)
+		}
+		return selectStrings(tx, selectChangedUsernamesQuery, kind, since, position)
+	}
+	tierCodes, err := changed(changeKindTier, selectAllTierCodesQuery)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range tierCodes {
+		change, err := a.tierChange(tx, code)
+		if err != nil {
+			return nil, err
+		}
+		changes.Tiers = append(changes.Tiers, change)
+	}
+	usernames, err := changed(changeKindUser, selectAllUsernamesQuery)
+	if err != nil {
+		return nil, err
+	}
+	for _, username := range usernames {
+		change, err := a.userChange(tx, username)
+		if err != nil {
+			return nil, err
+		}
+		changes.Users = append(changes.Users, change)
+	}
+	groupNames, err := changed(changeKindGroup, selectAllGroupNamesQuery)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range groupNames {
+		change, err := a.groupChange(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		changes.Groups = append(changes.Groups, change)
+	}
+	return changes, nil
+}
+
+func (a *Manager) userChange(tx *sql.Tx, username string) (*UserChange, error) {
+	var id, hash, role, syncTopic, tier string
+	var deleted sql.NullInt64
+	var disabled, provisioned bool
+	err := tx.QueryRow(selectUserChangeQuery, username).Scan(&id, &hash, &role, &syncTopic, &deleted, &disabled, &provisioned, &tier)
+	if errors.Is(err, sql.ErrNoRows) || deleted.Valid {
+		return &UserChange{Name: username, Deleted: true}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	change := &UserChange{
+		Name:        username,
+		ID:          id,
+		Hash:        hash,
+		Role:        Role(role),
+		Tier:        tier,
+		SyncTopic:   syncTopic,
+		Disabled:    disabled,
+		Provisioned: provisioned,
+		Access:      make([]*UserAccessChange, 0),
+		Tokens:      make([]*UserTokenChange, 0),
+		PublishKeys: make([]*UserPublishKeyChange, 0),
+	}
+	rows, err := tx.Query(selectUserAccessChangesQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var access UserAccessChange
+		if err := rows.Scan(&access.Topic, &access.Read, &access.Write, &access.AuthWrite, &access.Owner, &access.Sound, &access.Routing, &access.Escalation, &access.Schedule, &access.Policy, &access.Provisioned); err != nil {
+			return nil, err
+		}
+		change.Access = append(change.Access, &access)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	rows, err = tx.Query(selectUserTokenChangesQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var token UserTokenChange
+		if err := rows.Scan(&token.Token, &token.Label, &token.Expires, &token.Provisioned, &token.AllowedIPs, &token.Impersonator); err != nil {
+			return nil, err
+		}
+		change.Tokens = append(change.Tokens, &token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	rows, err = tx.Query(selectUserPublishKeyChangesQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key UserPublishKeyChange
+		if err := rows.Scan(&key.Topic, &key.Key, &key.Label, &key.Created, &key.TitlePrefix, &key.Priority, &key.Tags); err != nil {
+			return nil, err
+		}
+		change.PublishKeys = append(change.PublishKeys, &key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+func (a *Manager) groupChange(tx *sql.Tx, name string) (*GroupChange, error) {
+	var id string
+	err := tx.QueryRow(selectGroupChangeQuery, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &GroupChange{Name: name, Deleted: true}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	change := &GroupChange{
+		Name:   name,
+		ID:     id,
+		Access: make([]*GroupAccessChange, 0),
+	}
+	change.Members, err = selectStrings(tx, selectGroupMembersQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(selectGroupAccessChangesQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var access GroupAccessChange
+		if err := rows.Scan(&access.Topic, &access.Read, &access.Write, &access.Owner); err != nil {
+			return nil, err
+		}
+		change.Access = append(change.Access, &access)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+func (a *Manager) tierChange(tx *sql.Tx, code string) (*TierChange, error) {
+	change := &TierChange{Code: code}
+	err := tx.QueryRow(selectTierChangeQuery, code).Scan(&change.ID, &change.Name, &change.MessageLimit, &change.MessageExpiryDuration, &change.EmailLimit, &change.CallLimit, &change.ReservationLimit, &change.AttachmentFileSizeLimit, &change.AttachmentTotalSizeLimit, &change.AttachmentExpiryDuration, &change.AttachmentBandwidthLimit, &change.MessageSizeLimit, &change.StripeMonthlyPriceID, &change.StripeYearlyPriceID, &change.Provisioned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &TierChange{Code: code, Deleted: true}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// ApplyChanges applies changes returned by Changes (usually on another server) to this user database in a
+// single transaction. Tiers, users and groups are created, updated or removed, and the access control entries,
+// tokens and publish keys of users, as well as the members and access control entries of groups, are replaced.
+// If Changes.Full is set, all tiers, users and groups that are not included are removed.
+func (a *Manager) ApplyChanges(changes *Changes) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if changes.Full {
+		if err := a.removeUsersNotIn(tx, changes.Users); err != nil {
+			return err
+		} else if err := a.removeGroupsNotIn(tx, changes.Groups); err != nil {
+			return err
+		}
+	}
+	// Tiers must be created before users are assigned to them, and can only be removed
+	// once no user is assigned to them anymore
+	for _, change := range changes.Tiers {
+		if err := a.applyTierChange(tx, change); err != nil {
+			return fmt.Errorf("cannot apply changes to tier %s: %w", change.Code, err)
+		}
+	}
+	// Users must be created before access control entries are inserted, since
+	// entries may refer to other users as owners (reservations)
+	for _, change := range changes.Users {
+		if err := a.applyUserChange(tx, change); err != nil {
+			return fmt.Errorf("cannot apply changes to user %s: %w", change.Name, err)
+		}
+	}
+	for _, change := range changes.Users {
+		if change.Deleted {
+			continue
+		}
+		if err := a.applyUserAccessAndTokenChanges(tx, change); err != nil {
+			return fmt.Errorf("cannot apply changes to user %s: %w", change.Name, err)
+		}
+	}
+	for _, change := range changes.Groups {
+		if err := a.applyGroupChange(tx, change); err != nil {
+			return fmt.Errorf("cannot apply changes to group %s: %w", change.Name, err)
+		}
+	}
+	if changes.Full {
+		if err := a.removeTiersNotIn(tx, changes.Tiers); err != nil {
+			return err
+		}
+	}
+	for _, change := range changes.Tiers {
+		if !change.Deleted {
+			continue
+		} else if err := removeTier(tx, change.Code); err != nil {
+			return fmt.Errorf("cannot apply changes to tier %s: %w", change.Code, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (a *Manager) removeUsersNotIn(tx *sql.Tx, changes []*UserChange) error {
+	usernames, err := selectStrings(tx, selectAllUsernamesQuery)
+	if err != nil {
+		return err
+	}
+	included := make(map[string]bool)
+	for _, change := range changes {
+		included[change.Name] = true
+	}
+	for _, username := range usernames {
+		if username == Everyone || included[username] {
+			continue
+		}
+		if _, err := tx.Exec(deleteUserQuery, username); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Manager) removeGroupsNotIn(tx *sql.Tx, changes []*GroupChange) error {
+	names, err := selectStrings(tx, selectAllGroupNamesQuery)
+	if err != nil {
+		return err
+	}
+	included := make(map[string]bool)
+	for _, change := range changes {
+		included[change.Name] = true
+	}
+	for _, name := range names {
+		if included[name] {
+			continue
+		}
+		if _, err := tx.Exec(deleteGroupQuery, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Manager) removeTiersNotIn(tx *sql.Tx, changes []*TierChange) error {
+	codes, err := selectStrings(tx, selectAllTierCodesQuery)
+	if err != nil {
+		return err
+	}
+	included := make(map[string]bool)
+	for _, change := range changes {
+		included[change.Code] = true
+	}
+	for _, code := range codes {
+		if included[code] {
+			continue
+		}
+		if err := removeTier(tx, code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Manager) applyUserChange(tx *sql.Tx, change *UserChange) error {
+	if change.Name == Everyone {
+		return nil // Only access control entries are replicated for the everyone user
+	} else if !AllowedUsername(change.Name) {
+		return ErrInvalidArgument
+	} else if change.Deleted {
+		_, err := tx.Exec(deleteUserQuery, change.Name)
+		return err
+	} else if !AllowedRole(change.Role) || change.ID == "" {
+		return ErrInvalidArgument
+	}
+	if _, err := tx.Exec(upsertReplicatedUserQuery, change.ID, change.Name, []byte(change.Hash), change.Role, change.SyncTopic, time.Now().Unix(), change.Disabled, change.Provisioned); err != nil {
+		return err
+	}
+	if change.Tier == "" {
+		_, err := tx.Exec(deleteUserTierQuery, change.Name)
+		return err
+	}
+	var tierID string
+	if err := tx.QueryRow(selectTierIDQuery, change.Tier).Scan(&tierID); errors.Is(err, sql.ErrNoRows) {
+		log.Tag(tag).Field("user_name", change.Name).Warn("Tier %s does not exist, not assigning tier to user", change.Tier)
+		_, err := tx.Exec(deleteUserTierQuery, change.Name)
+		return err
+	} else if err != nil {
+		return err
+	}
+	_, err := tx.Exec(updateUserTierQuery, change.Tier, change.Name)
+	return err
+}
+
+func (a *Manager) applyUserAccessAndTokenChanges(tx *sql.Tx, change *UserChange) error {
+	if _, err := tx.Exec(deleteUserOwnAccessQuery, change.Name); err != nil {
+		return err
+	}
+	for _, access := range change.Access {
+		if _, err := tx.Exec(insertReplicatedUserAccessQuery, change.Name, access.Topic, access.Read, access.Write, access.AuthWrite, access.Owner, access.Sound, access.Routing, access.Escalation, access.Schedule, access.Policy, access.Provisioned); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(deleteAllTokenByUsernameQuery, change.Name); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, token := range change.Tokens {
+		if _, err := tx.Exec(insertReplicatedTokenQuery, change.Name, token.Token, token.Label, now, token.Expires, token.Provisioned, token.AllowedIPs, token.Impersonator); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(deleteAllPublishKeysByUsernameQuery, change.Name); err != nil {
+		return err
+	}
+	for _, key := range change.PublishKeys {
+		if _, err := tx.Exec(insertReplicatedPublishKeyQuery, change.Name, key.Topic, key.Key, key.Label, key.Created, key.TitlePrefix, key.Priority, key.Tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Manager) applyGroupChange(tx *sql.Tx, change *GroupChange) error {
+	if !AllowedGroup(change.Name) {
+		return ErrInvalidArgument
+	} else if change.Deleted {
+		_, err := tx.Exec(deleteGroupQuery, change.Name)
+		return err
+	} else if change.ID == "" {
+		return ErrInvalidArgument
+	}
+	if _, err := tx.Exec(upsertReplicatedGroupQuery, change.ID, change.Name); err != nil {
+		return err
+	}
+	var groupID string
+	if err := tx.QueryRow(selectGroupIDQuery, change.Name).Scan(&groupID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(deleteAllGroupMembersQuery, groupID); err != nil {
+		return err
+	}
+	for _, username := range change.Members {
+		if _, err := tx.Exec(insertGroupMemberQuery, groupID, username); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(deleteAllGroupAccessQuery, groupID); err != nil {
+		return err
+	}
+	for _, access := range change.Access {
+		if _, err := tx.Exec(insertReplicatedGroupAccessQuery, groupID, access.Topic, access.Read, access.Write, access.Owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Manager) applyTierChange(tx *sql.Tx, change *TierChange) error {
+	if !AllowedTier(change.Code) {
+		return ErrInvalidArgument
+	} else if change.Deleted {
+		return nil // Removed after users have been updated, see ApplyChanges
+	} else if change.ID == "" {
+		return ErrInvalidArgument
+	}
+	_, err := tx.Exec(upsertReplicatedTierQuery, change.ID, change.Code, change.Name, change.MessageLimit, change.MessageExpiryDuration, change.EmailLimit, change.CallLimit, change.ReservationLimit, change.AttachmentFileSizeLimit, change.AttachmentTotalSizeLimit, change.AttachmentExpiryDuration, change.AttachmentBandwidthLimit, change.MessageSizeLimit, nullString(change.StripeMonthlyPriceID), nullString(change.StripeYearlyPriceID), change.Provisioned)
+	return err
+}
+
+// removeTier removes the tier with the given code, and unassigns it from all users that still have it
+func removeTier(tx *sql.Tx, code string) error {
+	if _, err := tx.Exec(deleteUserTierByTierCodeQuery, code); err != nil {
+		return err
+	}
+	_, err := tx.Exec(deleteTierQuery, code)
+	return err
+}
+
+// RemoveOldChanges deletes changes that are older than the retention period from the change log, see Changes
+func (a *Manager) RemoveOldChanges() error {
+	if _, err := a.db.Exec(deleteChangesOlderThanQuery, time.Now().Add(-userChangeRetention).Unix()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Ping checks that the underlying database is reachable and readable
+func (a *Manager) Ping(ctx context.Context) error {
+	var version int
+	return a.db.QueryRowContext(ctx, selectSchemaVersionQuery).Scan(&version)
 }
 
 // Close closes the underlying database
@@ -1504,6 +3776,50 @@ func fromSQLWildcard(s string) string {
 	return strings.ReplaceAll(unescapeUnderscore(s), "%", "*")
 }
 
+func parseRoutingRules(routing string) ([]*RoutingRule, error) {
+	if routing == "" {
+		return nil, nil
+	}
+	var rules []*RoutingRule
+	if err := json.Unmarshal([]byte(routing), &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func parseEscalation(escalation string) (*Escalation, error) {
+	if escalation == "" {
+		return nil, nil
+	}
+	var policy Escalation
+	if err := json.Unmarshal([]byte(escalation), &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func parseSchedule(value string) (*Schedule, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var schedule Schedule
+	if err := json.Unmarshal([]byte(value), &schedule); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func parseTopicPolicy(value string) (*TopicPolicy, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var policy TopicPolicy
+	if err := json.Unmarshal([]byte(value), &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
 func escapeUnderscore(s string) string {
 	return strings.ReplaceAll(s, "_", "\\_")
 }
@@ -1663,6 +3979,182 @@ func migrateFrom4(db *sql.DB) error {
 	return tx.Commit()
 }
 
+func migrateFrom5(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 5 to 6")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate5To6UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 6); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom6(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 6 to 7")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate6To7UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 7); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom7(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 7 to 8")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate7To8UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 8); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom8(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 8 to 9")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate8To9UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 9); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom9(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 9 to 10")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate9To10UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 10); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom10(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 10 to 11")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate10To11UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 11); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom11(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 11 to 12")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate11To12UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 12); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom12(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 12 to 13")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate12To13UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 13); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom13(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 13 to 14")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate13To14UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 14); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom14(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 14 to 15")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate14To15UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 15); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom15(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 15 to 16")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate15To16UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 16); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func nullString(s string) sql.NullString {
 	if s == "" {
 		return sql.NullString{}
@@ -1676,3 +4168,115 @@ func nullInt64(v int64) sql.NullInt64 {
 	}
 	return sql.NullInt64{Int64: v, Valid: true}
 }
+
+func migrateFrom16(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 16 to 17")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate16To17UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 17); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom17(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 17 to 18")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate17To18UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 18); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom18(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 18 to 19")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate18To19UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 19); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom19(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 19 to 20")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate19To20UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 20); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom20(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 20 to 21")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate20To21UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 21); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom21(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 21 to 22")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate21To22UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 22); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom22(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 22 to 23")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate22To23UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 23); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
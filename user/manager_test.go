@@ -1,6 +1,7 @@
 package user
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/stretchr/testify/require"
@@ -194,13 +195,13 @@ func TestManager_MarkUserRemoved_RemoveDeletedUsers(t *testing.T) {
 	require.Nil(t, err)
 	require.False(t, u.Deleted)
 
-	token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified())
+	token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified(), nil)
 	require.Nil(t, err)
 
 	u, err = a.Authenticate("user", "pass")
 	require.Nil(t, err)
 
-	_, err = a.AuthenticateToken(token.Value)
+	_, err = a.AuthenticateToken(token.Value, netip.IPv4Unspecified())
 	require.Nil(t, err)
 
 	reservations, err := a.Reservations("user")
@@ -213,7 +214,7 @@ func TestManager_MarkUserRemoved_RemoveDeletedUsers(t *testing.T) {
 	_, err = a.Authenticate("user", "pass")
 	require.Equal(t, ErrUnauthenticated, err)
 
-	_, err = a.AuthenticateToken(token.Value)
+	_, err = a.AuthenticateToken(token.Value, netip.IPv4Unspecified())
 	require.Equal(t, ErrUnauthenticated, err)
 
 	reservations, err = a.Reservations("user")
@@ -241,7 +242,7 @@ func TestManager_CreateToken_Only_Lower(t *testing.T) {
 	u, err := a.User("user")
 	require.Nil(t, err)
 
-	token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified())
+	token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified(), nil)
 	require.Nil(t, err)
 	require.Equal(t, token.Value, strings.ToLower(token.Value))
 }
@@ -351,6 +352,28 @@ func TestManager_ChangePassword(t *testing.T) {
 	require.Nil(t, err)
 }
 
+func TestManager_AddUserWithHash_ChangePasswordHash(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	hash, err := bcrypt.GenerateFromPassword([]byte("phil"), bcrypt.MinCost)
+	require.Nil(t, err)
+	require.Nil(t, a.AddUserWithHash("phil", string(hash), RoleUser))
+	require.Equal(t, ErrUserExists, a.AddUserWithHash("phil", string(hash), RoleUser))
+	require.Equal(t, ErrInvalidArgument, a.AddUserWithHash("ben", "not a hash", RoleUser))
+
+	u, err := a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	require.Equal(t, string(hash), u.Hash)
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte("newpass"), bcrypt.MinCost)
+	require.Nil(t, err)
+	require.Nil(t, a.ChangePasswordHash("phil", string(newHash)))
+	require.Equal(t, ErrInvalidArgument, a.ChangePasswordHash("phil", "not a hash"))
+	_, err = a.Authenticate("phil", "phil")
+	require.Equal(t, ErrUnauthenticated, err)
+	_, err = a.Authenticate("phil", "newpass")
+	require.Nil(t, err)
+}
+
 func TestManager_ChangeRole(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
@@ -376,6 +399,42 @@ func TestManager_ChangeRole(t *testing.T) {
 	require.Equal(t, 0, len(benGrants))
 }
 
+func TestManager_AnonReadAuthWrite_Reservation(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddReservation("ben", "status", PermissionAnonReadAuthWrite))
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+
+	require.Nil(t, a.Authorize(nil, "status", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "status", PermissionWrite))
+	require.Nil(t, a.Authorize(phil, "status", PermissionRead))
+	require.Nil(t, a.Authorize(phil, "status", PermissionWrite))
+	require.Nil(t, a.Authorize(ben, "status", PermissionWrite))
+
+	reservations, err := a.Reservations("ben")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reservations))
+	require.Equal(t, PermissionAnonReadAuthWrite, reservations[0].Everyone)
+	require.Equal(t, "anon-read-auth-write", reservations[0].Everyone.String())
+}
+
+func TestManager_AnonReadAuthWrite_DefaultAccess(t *testing.T) {
+	a := newTestManager(t, PermissionAnonReadAuthWrite)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+	require.Nil(t, a.AllowAccess(Everyone, "private", PermissionDenyAll))
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+
+	require.Nil(t, a.Authorize(nil, "mytopic", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopic", PermissionWrite))
+	require.Nil(t, a.Authorize(phil, "mytopic", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(phil, "private", PermissionWrite))
+}
+
 func TestManager_Reservations(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
@@ -446,6 +505,425 @@ func TestManager_Reservations(t *testing.T) {
 	require.Equal(t, int64(0), count)
 }
 
+func TestManager_Reservations_Prefix(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AllowReservation("ben", "my_team-*"))
+	require.Nil(t, a.AddReservation("ben", "my_team-*", PermissionRead))
+	require.Nil(t, a.AddReservation("ben", "other-topic", PermissionDenyAll))
+
+	// Counts as one reservation
+	count, err := a.ReservationsCount("ben")
+	require.Nil(t, err)
+	require.Equal(t, int64(2), count)
+	reservations, err := a.Reservations("ben")
+	require.Nil(t, err)
+	require.Equal(t, "my_team-*", reservations[0].Topic)
+	require.Equal(t, PermissionRead, reservations[0].Everyone)
+
+	b, err := a.HasReservation("ben", "my_team-*")
+	require.Nil(t, err)
+	require.True(t, b)
+	b, err = a.HasReservation("ben", "my_team-prod")
+	require.Nil(t, err)
+	require.False(t, b) // Not a reservation itself, but owned through the prefix
+
+	// Matching topics are owned by ben, and inherit the ACLs
+	owner, err := a.ReservationOwner("my_team-prod")
+	require.Nil(t, err)
+	require.NotEmpty(t, owner)
+	owner, err = a.ReservationOwner("myXteam-prod") // _ is not a wildcard
+	require.Nil(t, err)
+	require.Empty(t, owner)
+
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	require.Nil(t, a.Authorize(ben, "my_team-prod", PermissionRead))
+	require.Nil(t, a.Authorize(ben, "my_team-prod", PermissionWrite))
+	require.Nil(t, a.Authorize(phil, "my_team-prod", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(phil, "my_team-prod", PermissionWrite))
+	require.Nil(t, a.Authorize(nil, "my_team-staging", PermissionRead))
+
+	// Others cannot reserve matching topics or overlapping prefixes
+	require.Equal(t, errTopicOwnedByOthers, a.AllowReservation("phil", "my_team-prod"))
+	require.Equal(t, errTopicOwnedByOthers, a.AllowReservation("phil", "my_team-prod-*"))
+	require.Equal(t, errTopicOwnedByOthers, a.AllowReservation("phil", "my_tea*"))
+	require.Equal(t, errTopicOwnedByOthers, a.AllowReservation("phil", "other-*"))
+	require.Nil(t, a.AllowReservation("phil", "my_teams"))
+	require.Nil(t, a.AllowReservation("phil", "another-*"))
+
+	// Prefixes must be long enough and end with a wildcard
+	require.Equal(t, ErrInvalidArgument, a.AllowReservation("phil", "abcde*"))
+	require.Equal(t, ErrInvalidArgument, a.AllowReservation("phil", "abc*def"))
+	require.Equal(t, ErrInvalidArgument, a.AddReservation("phil", "*", PermissionDenyAll))
+
+	require.Equal(t, ErrInvalidArgument, a.RemoveReservations("phil", "abc*"))
+
+	// Activity on a matching topic marks the prefix reservation as active
+	require.Nil(t, a.MarkReservationsActive([]string{"my_team-prod"}, time.Now().Add(time.Hour)))
+	inactive, err := a.InactiveReservations(time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(inactive))
+	require.Equal(t, "other-topic", inactive[0].Topic)
+
+	require.Nil(t, a.RemoveReservations("ben", "my_team-*"))
+	owner, err = a.ReservationOwner("my_team-prod")
+	require.Nil(t, err)
+	require.Empty(t, owner)
+	require.Nil(t, a.AllowReservation("phil", "my_team-prod"))
+}
+
+func TestManager_ReservationSound(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddReservation("ben", "backups-*", PermissionDenyAll))
+	require.Nil(t, a.AddReservation("ben", "pager", PermissionDenyAll))
+	require.Nil(t, a.ChangeReservationSound("ben", "backups-*", "soft-beep"))
+	require.Nil(t, a.ChangeReservationSound("ben", "pager", "siren.caf"))
+
+	reservations, err := a.Reservations("ben")
+	require.Nil(t, err)
+	require.Equal(t, 2, len(reservations))
+	require.Equal(t, "backups-*", reservations[0].Topic)
+	require.Equal(t, "soft-beep", reservations[0].Sound)
+	require.Equal(t, "pager", reservations[1].Topic)
+	require.Equal(t, "siren.caf", reservations[1].Sound)
+
+	sound, err := a.ReservationSound("backups-nightly")
+	require.Nil(t, err)
+	require.Equal(t, "soft-beep", sound)
+	sound, err = a.ReservationSound("pager")
+	require.Nil(t, err)
+	require.Equal(t, "siren.caf", sound)
+	sound, err = a.ReservationSound("unreserved")
+	require.Nil(t, err)
+	require.Equal(t, "", sound)
+
+	// Only the owner can change the sound, and an empty sound removes it
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+	require.Nil(t, a.ChangeReservationSound("phil", "pager", "quiet"))
+	require.Nil(t, a.ChangeReservationSound("ben", "backups-*", ""))
+	sound, err = a.ReservationSound("pager")
+	require.Nil(t, err)
+	require.Equal(t, "siren.caf", sound)
+	sound, err = a.ReservationSound("backups-nightly")
+	require.Nil(t, err)
+	require.Equal(t, "", sound)
+}
+
+func TestManager_ReservationRouting(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddReservation("ben", "alerts-*", PermissionDenyAll))
+	require.Nil(t, a.ChangeReservationRouting("ben", "alerts-*", []*RoutingRule{
+		{Priorities: []int{1, 2}, Push: false},
+		{Priorities: []int{4}, Push: true, Email: "ben@example.com"},
+		{Priorities: []int{5}, Push: true, Email: "ben@example.com", Call: "+12223334444"},
+	}))
+
+	reservations, err := a.Reservations("ben")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reservations))
+	require.Equal(t, 3, len(reservations[0].Routing))
+	require.Equal(t, []int{4}, reservations[0].Routing[1].Priorities)
+	require.Equal(t, "ben@example.com", reservations[0].Routing[1].Email)
+
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+	rules, owner, err := a.ReservationRouting("alerts-db")
+	require.Nil(t, err)
+	require.Equal(t, ben.ID, owner)
+	require.Equal(t, 3, len(rules))
+	require.False(t, rules[0].Push)
+	require.Equal(t, "+12223334444", rules[2].Call)
+
+	rules, owner, err = a.ReservationRouting("unreserved")
+	require.Nil(t, err)
+	require.Empty(t, owner)
+	require.Nil(t, rules)
+
+	// Only the owner can change the routing, and no rules remove it
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+	require.Nil(t, a.ChangeReservationRouting("phil", "alerts-*", nil))
+	rules, _, err = a.ReservationRouting("alerts-db")
+	require.Nil(t, err)
+	require.Equal(t, 3, len(rules))
+	require.Nil(t, a.ChangeReservationRouting("ben", "alerts-*", nil))
+	rules, _, err = a.ReservationRouting("alerts-db")
+	require.Nil(t, err)
+	require.Nil(t, rules)
+}
+
+func TestManager_ReservationEscalation(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddReservation("ben", "alerts-*", PermissionDenyAll))
+	require.Nil(t, a.ChangeReservationEscalation("ben", "alerts-*", &Escalation{
+		Priority: 4,
+		Steps: []*EscalationStep{
+			{Delay: 5 * time.Minute, Topic: "alerts-secondary"},
+			{Delay: 10 * time.Minute, Email: "ben@example.com", Call: "+12223334444"},
+		},
+	}))
+
+	reservations, err := a.Reservations("ben")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reservations))
+	require.Equal(t, 4, reservations[0].Escalation.Priority)
+	require.Equal(t, 2, len(reservations[0].Escalation.Steps))
+
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+	policy, owner, err := a.ReservationEscalation("alerts-db")
+	require.Nil(t, err)
+	require.Equal(t, ben.ID, owner)
+	require.Equal(t, 5*time.Minute, policy.Steps[0].Delay)
+	require.Equal(t, "alerts-secondary", policy.Steps[0].Topic)
+	require.Equal(t, "+12223334444", policy.Steps[1].Call)
+
+	policy, owner, err = a.ReservationEscalation("unreserved")
+	require.Nil(t, err)
+	require.Empty(t, owner)
+	require.Nil(t, policy)
+
+	// Only the owner can change the policy, and a nil policy removes it
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+	require.Nil(t, a.ChangeReservationEscalation("phil", "alerts-*", nil))
+	policy, _, err = a.ReservationEscalation("alerts-db")
+	require.Nil(t, err)
+	require.NotNil(t, policy)
+	require.Nil(t, a.ChangeReservationEscalation("ben", "alerts-*", nil))
+	policy, _, err = a.ReservationEscalation("alerts-db")
+	require.Nil(t, err)
+	require.Nil(t, policy)
+}
+
+func TestManager_ReservationSchedule(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddReservation("ben", "alerts-*", PermissionDenyAll))
+	require.Nil(t, a.ChangeReservationSchedule("ben", "alerts-*", &Schedule{
+		Start: 1700000000,
+		Shift: 7 * 24 * time.Hour,
+		Members: []*ScheduleMember{
+			{Name: "Ben", User: "ben", Email: "ben@example.com", Call: "+12223334444"},
+			{Name: "Phil", Email: "phil@example.com"},
+		},
+	}))
+
+	reservations, err := a.Reservations("ben")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reservations))
+	require.Equal(t, 2, len(reservations[0].Schedule.Members))
+
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+	schedule, owner, err := a.ReservationSchedule("alerts-db")
+	require.Nil(t, err)
+	require.Equal(t, ben.ID, owner)
+	require.Equal(t, int64(1700000000), schedule.Start)
+	require.Equal(t, "+12223334444", schedule.Members[0].Call)
+
+	// Only the owner can change the schedule, and a nil schedule removes it
+	require.Nil(t, a.ChangeReservationSchedule("ben", "unreserved", nil))
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+	require.Nil(t, a.ChangeReservationSchedule("phil", "alerts-*", nil))
+	schedule, _, err = a.ReservationSchedule("alerts-db")
+	require.Nil(t, err)
+	require.NotNil(t, schedule)
+	require.Nil(t, a.ChangeReservationSchedule("ben", "alerts-*", nil))
+	schedule, _, err = a.ReservationSchedule("alerts-db")
+	require.Nil(t, err)
+	require.Nil(t, schedule)
+}
+
+func TestManager_ReservationPolicy(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddReservation("ben", "builds-*", PermissionReadWrite))
+	require.Nil(t, a.ChangeReservationPolicy("ben", "builds-*", &TopicPolicy{
+		MaxMessageSize: 512,
+		NoAttachments:  true,
+		Priorities:     []int{1, 2, 3},
+	}))
+
+	reservations, err := a.Reservations("ben")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reservations))
+	require.Equal(t, 512, reservations[0].Policy.MaxMessageSize)
+
+	policy, err := a.ReservationPolicy("builds-main")
+	require.Nil(t, err)
+	require.True(t, policy.NoAttachments)
+	require.Equal(t, []int{1, 2, 3}, policy.Priorities)
+	policy, err = a.ReservationPolicy("unreserved")
+	require.Nil(t, err)
+	require.Nil(t, policy)
+
+	// An empty policy removes it
+	require.Nil(t, a.ChangeReservationPolicy("ben", "builds-*", &TopicPolicy{}))
+	policy, err = a.ReservationPolicy("builds-main")
+	require.Nil(t, err)
+	require.Nil(t, policy)
+}
+
+func TestSchedule_OnCall(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	schedule := &Schedule{
+		Start:   start.Unix(),
+		Shift:   24 * time.Hour,
+		Members: []*ScheduleMember{{Name: "ben"}, {Name: "phil"}, {Name: "kate"}},
+	}
+	require.Equal(t, "ben", schedule.OnCall(start).Name)
+	require.Equal(t, "ben", schedule.OnCall(start.Add(23*time.Hour)).Name)
+	require.Equal(t, "phil", schedule.OnCall(start.Add(24*time.Hour)).Name)
+	require.Equal(t, "kate", schedule.OnCall(start.Add(50*time.Hour)).Name)
+	require.Equal(t, "ben", schedule.OnCall(start.Add(72*time.Hour)).Name)
+	require.Equal(t, "kate", schedule.OnCall(start.Add(-time.Hour)).Name)
+	require.Equal(t, "kate", schedule.OnCall(start.Add(-24*time.Hour)).Name)
+	require.Equal(t, "phil", schedule.OnCall(start.Add(-25*time.Hour)).Name)
+	require.Nil(t, (&Schedule{Shift: time.Hour}).OnCall(start))
+}
+
+func TestManager_InactiveReservations(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddReservation("ben", "mytopic_", PermissionDenyAll))
+	require.Nil(t, a.AddReservation("ben", "othertopic", PermissionRead))
+	require.Nil(t, a.AllowAccess("ben", "not-reserved", PermissionReadWrite))
+
+	// Nothing is inactive right after reserving
+	reservations, err := a.InactiveReservations(time.Now().Add(-time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, 0, len(reservations))
+
+	// Mark one topic as active a while ago, and one in the future
+	require.Nil(t, a.MarkReservationsActive([]string{"mytopic_"}, time.Now().Add(-48*time.Hour)))
+	require.Nil(t, a.MarkReservationsActive([]string{"othertopic", "not-reserved"}, time.Now().Add(time.Hour)))
+	reservations, err = a.InactiveReservations(time.Now().Add(-24 * time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reservations))
+	require.Equal(t, "mytopic_", reservations[0].Topic)
+	require.Equal(t, "ben", reservations[0].Username)
+	require.True(t, reservations[0].WarnedAt.IsZero())
+
+	// Warn, then mark active again, which resets the warning
+	require.Nil(t, a.MarkReservationInactivityWarned("mytopic_", time.Now()))
+	reservations, err = a.InactiveReservations(time.Now().Add(-24 * time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reservations))
+	require.False(t, reservations[0].WarnedAt.IsZero())
+
+	require.Nil(t, a.MarkReservationsActive([]string{"mytopic_"}, time.Now()))
+	reservations, err = a.InactiveReservations(time.Now().Add(-24 * time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, 0, len(reservations))
+	reservations, err = a.InactiveReservations(time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reservations))
+	require.True(t, reservations[0].WarnedAt.IsZero())
+}
+
+func TestManager_Groups(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+	require.Nil(t, a.AddUser("john", "john", RoleUser))
+
+	require.Nil(t, a.AddGroup("ops"))
+	require.Equal(t, ErrGroupExists, a.AddGroup("ops"))
+	require.Equal(t, ErrInvalidArgument, a.AddGroup("not valid"))
+	require.Nil(t, a.AddGroupMembers("ops", "ben", "phil", "ben"))
+	require.Equal(t, ErrUserNotFound, a.AddGroupMembers("ops", "nobody"))
+	require.Equal(t, ErrGroupNotFound, a.AddGroupMembers("dev", "ben"))
+	require.Nil(t, a.AllowGroupAccess("ops", "alerts*", PermissionReadWrite))
+	require.Nil(t, a.AllowGroupAccess("ops", "status", PermissionRead))
+	require.Equal(t, ErrInvalidArgument, a.AllowGroupAccess("ops", "status", PermissionAnonReadAuthWrite))
+
+	group, err := a.Group("ops")
+	require.Nil(t, err)
+	require.Equal(t, "ops", group.Name)
+	require.Equal(t, []string{"ben", "phil"}, group.Members)
+	require.Equal(t, 2, len(group.Grants))
+	require.Equal(t, "alerts*", group.Grants[0].TopicPattern)
+	require.Equal(t, PermissionReadWrite, group.Grants[0].Allow)
+	require.Equal(t, "status", group.Grants[1].TopicPattern)
+	require.Equal(t, PermissionRead, group.Grants[1].Allow)
+
+	groups, err := a.UserGroups("ben")
+	require.Nil(t, err)
+	require.Equal(t, []string{"ops"}, groups)
+
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+	john, err := a.User("john")
+	require.Nil(t, err)
+	require.Nil(t, a.Authorize(ben, "alerts-db", PermissionWrite))
+	require.Nil(t, a.Authorize(ben, "status", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "status", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(john, "alerts-db", PermissionRead))
+
+	// User-specific entries take precedence over group entries, group entries over everyone entries
+	require.Nil(t, a.AllowAccess("ben", "alerts-db", PermissionDenyAll))
+	require.Nil(t, a.AllowAccess(Everyone, "status", PermissionDenyAll))
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "alerts-db", PermissionRead))
+	require.Nil(t, a.Authorize(ben, "alerts-web", PermissionRead))
+	require.Nil(t, a.Authorize(ben, "status", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "status", PermissionRead))
+
+	// Remove member, reset access, remove group
+	require.Nil(t, a.RemoveGroupMembers("ops", "ben"))
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "alerts-web", PermissionRead))
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	require.Nil(t, a.ResetGroupAccess("ops", "alerts*"))
+	require.Equal(t, ErrUnauthorized, a.Authorize(phil, "alerts-web", PermissionRead))
+	require.Nil(t, a.Authorize(phil, "status", PermissionRead))
+	require.Nil(t, a.RemoveGroup("ops"))
+	require.Equal(t, ErrUnauthorized, a.Authorize(phil, "status", PermissionRead))
+	require.Equal(t, ErrGroupNotFound, a.RemoveGroup("ops"))
+	groups, err = a.UserGroups("phil")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(groups))
+}
+
+func TestManager_Groups_ShareReservation(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+	require.Nil(t, a.AddGroup("team"))
+	require.Nil(t, a.AddGroupMembers("team", "ben", "phil"))
+
+	require.Nil(t, a.AddReservation("ben", "myteam-*", PermissionDenyAll))
+	require.Nil(t, a.ShareReservation("ben", "myteam-*", "team"))
+	require.Equal(t, ErrGroupNotFound, a.ShareReservation("ben", "myteam-*", "other"))
+
+	reservations, err := a.Reservations("ben")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reservations))
+	require.Equal(t, "team", reservations[0].Group)
+
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	require.Nil(t, a.Authorize(phil, "myteam-builds", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "myteam-builds", PermissionRead))
+
+	// Shared reservations are not listed as group grants
+	group, err := a.Group("team")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(group.Grants))
+
+	// Removing the reservation removes the group access as well
+	require.Nil(t, a.RemoveReservations("ben", "myteam-*"))
+	require.Equal(t, ErrUnauthorized, a.Authorize(phil, "myteam-builds", PermissionWrite))
+	reservations, err = a.Reservations("ben")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(reservations))
+}
+
 func TestManager_ChangeRoleFromTierUserToAdmin(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 	require.Nil(t, a.AddTier(&Tier{
@@ -505,6 +983,103 @@ func TestManager_ChangeRoleFromTierUserToAdmin(t *testing.T) {
 	require.Equal(t, 0, len(everyoneGrants))
 }
 
+func TestManager_PublishKeys(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddReservation("ben", "sensor_1", PermissionDenyAll))
+	require.Nil(t, a.AddReservation("ben", "other", PermissionDenyAll))
+
+	key, err := a.CreatePublishKey("ben", "sensor_1", "garage sensor", nil)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(key.Value, PublishKeyPrefix))
+	require.Equal(t, "sensor_1", key.Topic)
+	require.Equal(t, "garage sensor", key.Label)
+
+	require.Nil(t, a.AuthorizePublishKey(key.Value, "sensor_1"))
+	require.Equal(t, ErrUnauthorized, a.AuthorizePublishKey(key.Value, "sensorX1"))
+	require.Equal(t, ErrUnauthorized, a.AuthorizePublishKey(key.Value, "other"))
+	require.Equal(t, ErrUnauthorized, a.AuthorizePublishKey("pk_doesnotexist", "sensor_1"))
+
+	keys, err := a.PublishKeys("ben")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(keys))
+	require.Equal(t, key.Value, keys[0].Value)
+	require.Equal(t, "sensor_1", keys[0].Topic)
+	require.Equal(t, key.Created, keys[0].Created)
+
+	// Disabling the user invalidates the key, until the user is enabled again
+	require.Nil(t, a.ChangeDisabled("ben", true))
+	require.Equal(t, ErrUnauthorized, a.AuthorizePublishKey(key.Value, "sensor_1"))
+	require.Nil(t, a.ChangeDisabled("ben", false))
+	require.Nil(t, a.AuthorizePublishKey(key.Value, "sensor_1"))
+
+	// Removing the reservation invalidates the key
+	require.Nil(t, a.RemoveReservations("ben", "sensor_1"))
+	require.Equal(t, ErrUnauthorized, a.AuthorizePublishKey(key.Value, "sensor_1"))
+	keys, err = a.PublishKeys("ben")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(keys))
+}
+
+func TestManager_PublishKeys_Defaults(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddReservation("ben", "garage", PermissionDenyAll))
+
+	key, err := a.CreatePublishKey("ben", "garage", "door sensor", &PublishKeyDefaults{
+		TitlePrefix: "[Garage]",
+		Priority:    4,
+		Tags:        []string{"door", "house"},
+	})
+	require.Nil(t, err)
+	require.Equal(t, 4, key.Defaults.Priority)
+
+	publishKey, err := a.PublishKey(key.Value)
+	require.Nil(t, err)
+	require.Equal(t, "garage", publishKey.Topic)
+	require.Equal(t, "[Garage]", publishKey.Defaults.TitlePrefix)
+	require.Equal(t, 4, publishKey.Defaults.Priority)
+	require.Equal(t, []string{"door", "house"}, publishKey.Defaults.Tags)
+
+	// Keys without defaults
+	plain, err := a.CreatePublishKey("ben", "garage", "", nil)
+	require.Nil(t, err)
+	keys, err := a.PublishKeys("ben")
+	require.Nil(t, err)
+	require.Equal(t, 2, len(keys))
+	for _, k := range keys {
+		if k.Value == plain.Value {
+			require.Nil(t, k.Defaults)
+		} else {
+			require.Equal(t, []string{"door", "house"}, k.Defaults.Tags)
+		}
+	}
+	_, err = a.PublishKey("pk_doesnotexist")
+	require.Equal(t, ErrUnauthorized, err)
+}
+
+func TestManager_PublishKeys_RemoveAndLimit(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+	keys := make([]*PublishKey, 0)
+	for i := 0; i < publishKeyMaxCount; i++ {
+		key, err := a.CreatePublishKey("ben", "mytopic", "", nil)
+		require.Nil(t, err)
+		keys = append(keys, key)
+	}
+	_, err := a.CreatePublishKey("ben", "mytopic", "", nil)
+	require.Equal(t, ErrTooManyPublishKeys, err)
+
+	require.Nil(t, a.RemovePublishKey("ben", "mytopic", keys[0].Value))
+	require.Equal(t, ErrUnauthorized, a.AuthorizePublishKey(keys[0].Value, "mytopic"))
+	require.Nil(t, a.AuthorizePublishKey(keys[1].Value, "mytopic"))
+
+	_, err = a.CreatePublishKey("ben", "mytopic", "", nil)
+	require.Nil(t, err)
+}
+
 func TestManager_Token_Valid(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
@@ -513,13 +1088,13 @@ func TestManager_Token_Valid(t *testing.T) {
 	require.Nil(t, err)
 
 	// Create token for user
-	token, err := a.CreateToken(u.ID, "some label", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	token, err := a.CreateToken(u.ID, "some label", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
 	require.Nil(t, err)
 	require.NotEmpty(t, token.Value)
 	require.Equal(t, "some label", token.Label)
 	require.True(t, time.Now().Add(71*time.Hour).Unix() < token.Expires.Unix())
 
-	u2, err := a.AuthenticateToken(token.Value)
+	u2, err := a.AuthenticateToken(token.Value, netip.IPv4Unspecified())
 	require.Nil(t, err)
 	require.Equal(t, u.Name, u2.Name)
 	require.Equal(t, token.Value, u2.Token)
@@ -540,7 +1115,7 @@ func TestManager_Token_Valid(t *testing.T) {
 
 	// Remove token and auth again
 	require.Nil(t, a.RemoveToken(u2.ID, u2.Token))
-	u3, err := a.AuthenticateToken(token.Value)
+	u3, err := a.AuthenticateToken(token.Value, netip.IPv4Unspecified())
 	require.Equal(t, ErrUnauthenticated, err)
 	require.Nil(t, u3)
 
@@ -549,20 +1124,173 @@ func TestManager_Token_Valid(t *testing.T) {
 	require.Equal(t, 0, len(tokens))
 }
 
-func TestManager_Token_Invalid(t *testing.T) {
+func TestManager_Token_AllowedIPs(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
 
-	u, err := a.AuthenticateToken(strings.Repeat("x", 32)) // 32 == token length
-	require.Nil(t, u)
-	require.Equal(t, ErrUnauthenticated, err)
+	u, err := a.User("ben")
+	require.Nil(t, err)
 
-	u, err = a.AuthenticateToken("not long enough anyway")
-	require.Nil(t, u)
-	require.Equal(t, ErrUnauthenticated, err)
-}
+	allowedIPs, err := ParseAllowedIPs([]string{"10.0.1.0/24", "1.2.3.4"})
+	require.Nil(t, err)
+	token, err := a.CreateToken(u.ID, "sensor", time.Unix(0, 0), netip.IPv4Unspecified(), allowedIPs)
+	require.Nil(t, err)
+	require.Equal(t, allowedIPs, token.AllowedIPs)
 
-func TestManager_Token_NotFound(t *testing.T) {
+	// Allowed origins
+	u2, err := a.AuthenticateToken(token.Value, netip.MustParseAddr("10.0.1.17"))
+	require.Nil(t, err)
+	require.Equal(t, "ben", u2.Name)
+	_, err = a.AuthenticateToken(token.Value, netip.MustParseAddr("1.2.3.4"))
+	require.Nil(t, err)
+
+	// Other origins
+	_, err = a.AuthenticateToken(token.Value, netip.MustParseAddr("10.0.2.1"))
+	require.Equal(t, ErrTokenOriginNotAllowed, err)
+	_, err = a.AuthenticateToken(token.Value, netip.MustParseAddr("1.2.3.5"))
+	require.Equal(t, ErrTokenOriginNotAllowed, err)
+
+	// Ranges are returned when listing tokens
+	token2, err := a.Token(u.ID, token.Value)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.1.0/24", token2.AllowedIPs[0].String())
+	require.Equal(t, "1.2.3.4/32", token2.AllowedIPs[1].String())
+	tokens, err := a.Tokens(u.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tokens))
+	require.Equal(t, 2, len(tokens[0].AllowedIPs))
+}
+
+func TestManager_Token_Impersonation(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleAdmin))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+
+	// Impersonation token authenticates as the user, with the impersonator set
+	token, err := a.CreateImpersonationToken(ben.ID, "phil", time.Now().Add(time.Hour), netip.IPv4Unspecified())
+	require.Nil(t, err)
+	require.Equal(t, "phil", token.Impersonator)
+	require.Equal(t, "Impersonated by phil", token.Label)
+	u, err := a.AuthenticateToken(token.Value, netip.IPv4Unspecified())
+	require.Nil(t, err)
+	require.Equal(t, "ben", u.Name)
+	require.Equal(t, RoleUser, u.Role)
+	require.Equal(t, "phil", u.Impersonator)
+
+	// Regular tokens are not affected
+	token2, err := a.CreateToken(ben.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+	u, err = a.AuthenticateToken(token2.Value, netip.IPv4Unspecified())
+	require.Nil(t, err)
+	require.Equal(t, "", u.Impersonator)
+
+	// Impersonator is returned when listing tokens
+	token3, err := a.Token(ben.ID, token.Value)
+	require.Nil(t, err)
+	require.Equal(t, "phil", token3.Impersonator)
+
+	_, err = a.CreateImpersonationToken(ben.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified())
+	require.Equal(t, errNoImpersonatorProvided, err)
+}
+
+func TestManager_Token_Impersonation_DoesNotPruneUserTokens(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+
+	// User has the maximum number of tokens
+	for i := 0; i < tokenMaxCount; i++ {
+		_, err := a.CreateToken(ben.ID, "", time.Now().Add(time.Duration(i+1)*time.Hour), netip.IPv4Unspecified(), nil)
+		require.Nil(t, err)
+	}
+
+	// Impersonation tokens do not prune the user's own tokens, and do not count towards the limit
+	for i := 0; i < 3; i++ {
+		_, err := a.CreateImpersonationToken(ben.ID, "phil", time.Now().Add(24*time.Hour), netip.IPv4Unspecified())
+		require.Nil(t, err)
+	}
+	tokens, err := a.Tokens(ben.ID)
+	require.Nil(t, err)
+	require.Equal(t, tokenMaxCount+3, len(tokens))
+
+	// Regular tokens are still pruned, but impersonation tokens are kept
+	_, err = a.CreateToken(ben.ID, "", time.Now().Add(48*time.Hour), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+	tokens, err = a.Tokens(ben.ID)
+	require.Nil(t, err)
+	require.Equal(t, tokenMaxCount+3, len(tokens))
+	impersonationTokens := 0
+	for _, token := range tokens {
+		if token.Impersonator != "" {
+			impersonationTokens++
+		}
+	}
+	require.Equal(t, 3, impersonationTokens)
+}
+
+func TestManager_ParseAllowedIPs(t *testing.T) {
+	prefixes, err := ParseAllowedIPs([]string{" 10.0.0.0/8", "192.168.1.1", "fd00::/8", "::1"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.0/8", "192.168.1.1/32", "fd00::/8", "::1/128"}, []string{prefixes[0].String(), prefixes[1].String(), prefixes[2].String(), prefixes[3].String()})
+
+	_, err = ParseAllowedIPs([]string{"not-an-ip"})
+	require.Error(t, err)
+	_, err = ParseAllowedIPs([]string{"10.0.0.0/33"})
+	require.Error(t, err)
+}
+
+func TestManager_ScheduleUserDeletion(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	require.True(t, phil.DeletionScheduled.IsZero())
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+
+	// Scheduled users can still log in
+	require.Nil(t, a.ScheduleUserDeletion(phil.ID, time.Now().Add(-time.Minute)))
+	require.Nil(t, a.ScheduleUserDeletion(ben.ID, time.Now().Add(time.Hour)))
+	phil, err = a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	require.False(t, phil.DeletionScheduled.IsZero())
+
+	// Only due users are returned
+	users, err := a.UsersDeletionDue()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(users))
+	require.Equal(t, "phil", users[0].Name)
+
+	// Canceled
+	require.Nil(t, a.CancelUserDeletion(phil.ID))
+	phil, err = a.User("phil")
+	require.Nil(t, err)
+	require.True(t, phil.DeletionScheduled.IsZero())
+	users, err = a.UsersDeletionDue()
+	require.Nil(t, err)
+	require.Equal(t, 0, len(users))
+}
+
+func TestManager_Token_Invalid(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+
+	u, err := a.AuthenticateToken(strings.Repeat("x", 32), netip.IPv4Unspecified()) // 32 == token length
+	require.Nil(t, u)
+	require.Equal(t, ErrUnauthenticated, err)
+
+	u, err = a.AuthenticateToken("not long enough anyway", netip.IPv4Unspecified())
+	require.Nil(t, u)
+	require.Equal(t, ErrUnauthenticated, err)
+}
+
+func TestManager_Token_NotFound(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 	_, err := a.Token("u_bla", "notfound")
 	require.Equal(t, ErrTokenNotFound, err)
@@ -576,22 +1304,22 @@ func TestManager_Token_Expire(t *testing.T) {
 	require.Nil(t, err)
 
 	// Create tokens for user
-	token1, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	token1, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
 	require.Nil(t, err)
 	require.NotEmpty(t, token1.Value)
 	require.True(t, time.Now().Add(71*time.Hour).Unix() < token1.Expires.Unix())
 
-	token2, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	token2, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
 	require.Nil(t, err)
 	require.NotEmpty(t, token2.Value)
 	require.NotEqual(t, token1.Value, token2.Value)
 	require.True(t, time.Now().Add(71*time.Hour).Unix() < token2.Expires.Unix())
 
 	// See that tokens work
-	_, err = a.AuthenticateToken(token1.Value)
+	_, err = a.AuthenticateToken(token1.Value, netip.IPv4Unspecified())
 	require.Nil(t, err)
 
-	_, err = a.AuthenticateToken(token2.Value)
+	_, err = a.AuthenticateToken(token2.Value, netip.IPv4Unspecified())
 	require.Nil(t, err)
 
 	// Modify token expiration in database
@@ -599,7 +1327,7 @@ func TestManager_Token_Expire(t *testing.T) {
 	require.Nil(t, err)
 
 	// Now token1 shouldn't work anymore
-	_, err = a.AuthenticateToken(token1.Value)
+	_, err = a.AuthenticateToken(token1.Value, netip.IPv4Unspecified())
 	require.Equal(t, ErrUnauthenticated, err)
 
 	result, err := a.db.Query("SELECT * from user_token WHERE token = ?", token1.Value)
@@ -628,11 +1356,11 @@ func TestManager_Token_Extend(t *testing.T) {
 	require.Equal(t, errNoTokenProvided, err)
 
 	// Create token for user
-	token, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	token, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
 	require.Nil(t, err)
 	require.NotEmpty(t, token.Value)
 
-	userWithToken, err := a.AuthenticateToken(token.Value)
+	userWithToken, err := a.AuthenticateToken(token.Value, netip.IPv4Unspecified())
 	require.Nil(t, err)
 
 	extendedToken, err := a.ChangeToken(userWithToken.ID, userWithToken.Token, util.String("changed label"), util.Time(time.Now().Add(100*time.Hour)))
@@ -643,6 +1371,36 @@ func TestManager_Token_Extend(t *testing.T) {
 	require.True(t, time.Now().Add(99*time.Hour).Unix() < extendedToken.Expires.Unix())
 }
 
+func TestManager_Token_Import(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+
+	token := &Token{
+		Value:      "tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		Label:      "backup",
+		Expires:    time.Unix(0, 0),
+		AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.0.1.0/24")},
+	}
+	require.Nil(t, a.ImportToken("ben", token))
+	u, err := a.AuthenticateToken(token.Value, netip.MustParseAddr("10.0.1.2"))
+	require.Nil(t, err)
+	require.Equal(t, "ben", u.Name)
+
+	// Importing again updates the token, and moves it to the other user
+	token.Label = "changed"
+	require.Nil(t, a.ImportToken("phil", token))
+	u, err = a.AuthenticateToken(token.Value, netip.MustParseAddr("10.0.1.2"))
+	require.Nil(t, err)
+	require.Equal(t, "phil", u.Name)
+	imported, err := a.Token(u.ID, token.Value)
+	require.Nil(t, err)
+	require.Equal(t, "changed", imported.Label)
+
+	require.Equal(t, ErrInvalidArgument, a.ImportToken("ben", &Token{Value: "tk_tooshort"}))
+	require.Equal(t, ErrUserNotFound, a.ImportToken("nobody", token))
+}
+
 func TestManager_Token_MaxCount_AutoDelete(t *testing.T) {
 	// Tests that tokens are automatically deleted when the maximum number of tokens is reached
 
@@ -658,12 +1416,12 @@ func TestManager_Token_MaxCount_AutoDelete(t *testing.T) {
 
 	// Create 2 tokens for phil
 	philTokens := make([]string, 0)
-	token, err := a.CreateToken(phil.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	token, err := a.CreateToken(phil.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
 	require.Nil(t, err)
 	require.NotEmpty(t, token.Value)
 	philTokens = append(philTokens, token.Value)
 
-	token, err = a.CreateToken(phil.ID, "", time.Unix(0, 0), netip.IPv4Unspecified())
+	token, err = a.CreateToken(phil.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
 	require.Nil(t, err)
 	require.NotEmpty(t, token.Value)
 	philTokens = append(philTokens, token.Value)
@@ -672,7 +1430,7 @@ func TestManager_Token_MaxCount_AutoDelete(t *testing.T) {
 	baseTime := time.Now().Add(24 * time.Hour)
 	benTokens := make([]string, 0)
 	for i := 0; i < 22; i++ { //
-		token, err := a.CreateToken(ben.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+		token, err := a.CreateToken(ben.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
 		require.Nil(t, err)
 		require.NotEmpty(t, token.Value)
 		benTokens = append(benTokens, token.Value)
@@ -683,15 +1441,15 @@ func TestManager_Token_MaxCount_AutoDelete(t *testing.T) {
 	}
 
 	// Ben: The first 2 tokens should have been wiped and should not work anymore!
-	_, err = a.AuthenticateToken(benTokens[0])
+	_, err = a.AuthenticateToken(benTokens[0], netip.IPv4Unspecified())
 	require.Equal(t, ErrUnauthenticated, err)
 
-	_, err = a.AuthenticateToken(benTokens[1])
+	_, err = a.AuthenticateToken(benTokens[1], netip.IPv4Unspecified())
 	require.Equal(t, ErrUnauthenticated, err)
 
 	// Ben: The other tokens should still work
 	for i := 2; i < 22; i++ {
-		userWithToken, err := a.AuthenticateToken(benTokens[i])
+		userWithToken, err := a.AuthenticateToken(benTokens[i], netip.IPv4Unspecified())
 		require.Nil(t, err, "token[%d]=%s failed", i, benTokens[i])
 		require.Equal(t, "ben", userWithToken.Name)
 		require.Equal(t, benTokens[i], userWithToken.Token)
@@ -699,7 +1457,7 @@ func TestManager_Token_MaxCount_AutoDelete(t *testing.T) {
 
 	// Phil: All tokens should still work
 	for i := 0; i < 2; i++ {
-		userWithToken, err := a.AuthenticateToken(philTokens[i])
+		userWithToken, err := a.AuthenticateToken(philTokens[i], netip.IPv4Unspecified())
 		require.Nil(t, err, "token[%d]=%s failed", i, philTokens[i])
 		require.Equal(t, "phil", userWithToken.Name)
 		require.Equal(t, philTokens[i], userWithToken.Token)
@@ -771,7 +1529,7 @@ func TestManager_EnqueueTokenUpdate(t *testing.T) {
 	u, err := a.User("ben")
 	require.Nil(t, err)
 
-	token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified())
+	token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified(), nil)
 	require.Nil(t, err)
 
 	// Queue token update
@@ -1310,6 +2068,576 @@ func TestMigrationFrom4(t *testing.T) {
 	require.Nil(t, a.Authorize(nil, "up", PermissionRead)) // % matches 0 or more characters
 }
 
+func TestManager_ChangeDisabled(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	u, err := a.User("ben")
+	require.Nil(t, err)
+	require.False(t, u.Disabled)
+	token, err := a.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+
+	require.Nil(t, a.ChangeDisabled("ben", true))
+	u, err = a.User("ben")
+	require.Nil(t, err)
+	require.True(t, u.Disabled)
+	_, err = a.Authenticate("ben", "ben")
+	require.Equal(t, ErrUnauthenticated, err)
+	_, err = a.AuthenticateToken(token.Value, netip.IPv4Unspecified())
+	require.Equal(t, ErrUnauthenticated, err)
+	tokens, err := a.Tokens(u.ID)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(tokens))
+
+	require.Nil(t, a.ChangeDisabled("ben", false))
+	u, err = a.Authenticate("ben", "ben")
+	require.Nil(t, err)
+	require.False(t, u.Disabled)
+
+	require.Equal(t, ErrUserNotFound, a.ChangeDisabled("does-not-exist", true))
+}
+
+func TestManager_Provision(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("manual", "manual", RoleUser))
+	require.Nil(t, a.AllowAccess("manual", "manual-topic", PermissionReadWrite))
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("phil"), bcrypt.MinCost)
+	require.Nil(t, err)
+	p := &Provisioning{
+		Tiers: []*Tier{
+			{Code: "pro", Name: "Pro", MessageLimit: 1000, MessageExpiryDuration: time.Hour, ReservationLimit: 2},
+		},
+		Users: []*User{
+			{Name: "phil", Hash: string(hash), Role: RoleAdmin},
+			{Name: "ben", Hash: string(hash), Role: RoleUser, Tier: &Tier{Code: "pro"}},
+		},
+		Access: map[string][]Grant{
+			"ben":    {{TopicPattern: "ben_*", Allow: PermissionReadWrite}},
+			Everyone: {{TopicPattern: "announcements", Allow: PermissionRead}},
+		},
+		Tokens: map[string][]*Token{
+			"ben": {{Value: HashToken("tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), Label: "CI"}},
+		},
+	}
+	require.Nil(t, a.Provision(p))
+	require.Nil(t, a.Provision(p)) // Idempotent
+
+	phil, err := a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	require.Equal(t, RoleAdmin, phil.Role)
+
+	ben, err := a.AuthenticateToken("tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa", netip.IPv4Unspecified())
+	require.Nil(t, err)
+	require.Equal(t, "ben", ben.Name)
+	require.Equal(t, "pro", ben.Tier.Code)
+	require.Equal(t, int64(1000), ben.Tier.MessageLimit)
+	require.Nil(t, a.Authorize(ben, "ben_stuff", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "other", PermissionWrite))
+	require.Nil(t, a.Authorize(nil, "announcements", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "announcements", PermissionWrite))
+
+	tokens, err := a.Tokens(ben.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tokens))
+	require.Equal(t, "CI", tokens[0].Label)
+	require.Equal(t, HashToken("tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), tokens[0].Value) // Plaintext token is never stored
+	require.Equal(t, int64(0), tokens[0].Expires.Unix())
+	_, err = a.AuthenticateToken(HashToken("tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), netip.IPv4Unspecified())
+	require.Equal(t, ErrUnauthenticated, err) // The hash itself is not a valid token
+
+	// Remove ben, tier, token and everyone grant from config; manually created entries are untouched
+	p = &Provisioning{
+		Users: []*User{
+			{Name: "phil", Hash: string(hash), Role: RoleUser},
+		},
+		Access: map[string][]Grant{
+			"phil": {{TopicPattern: "phil", Allow: PermissionRead}},
+		},
+	}
+	require.Nil(t, a.Provision(p))
+
+	_, err = a.User("ben")
+	require.Equal(t, ErrUserNotFound, err)
+	_, err = a.Tier("pro")
+	require.Equal(t, ErrTierNotFound, err)
+	_, err = a.AuthenticateToken("tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa", netip.IPv4Unspecified())
+	require.Equal(t, ErrUnauthenticated, err)
+	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "announcements", PermissionRead))
+
+	phil, err = a.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, RoleUser, phil.Role)
+	require.Nil(t, a.Authorize(phil, "phil", PermissionRead))
+
+	manual, err := a.User("manual")
+	require.Nil(t, err)
+	require.Nil(t, a.Authorize(manual, "manual-topic", PermissionWrite))
+}
+
+func TestManager_Provision_Invalid(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	hash, err := bcrypt.GenerateFromPassword([]byte("phil"), bcrypt.MinCost)
+	require.Nil(t, err)
+
+	require.ErrorIs(t, a.Provision(&Provisioning{
+		Users: []*User{{Name: "phil", Hash: "not-a-hash", Role: RoleUser}},
+	}), ErrInvalidArgument)
+	require.ErrorIs(t, a.Provision(&Provisioning{
+		Users: []*User{{Name: "phil", Hash: string(hash), Role: RoleUser, Tier: &Tier{Code: "does-not-exist"}}},
+	}), ErrInvalidArgument)
+	require.ErrorIs(t, a.Provision(&Provisioning{
+		Users:  []*User{{Name: "phil", Hash: string(hash), Role: RoleUser}},
+		Tokens: map[string][]*Token{"phil": {{Value: "tk_tooshort"}}},
+	}), ErrInvalidArgument)
+	require.ErrorIs(t, a.Provision(&Provisioning{
+		Users:  []*User{{Name: "phil", Hash: string(hash), Role: RoleUser}},
+		Tokens: map[string][]*Token{"phil": {{Value: "tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}}}, // Plaintext
+	}), ErrInvalidArgument)
+	require.ErrorIs(t, a.Provision(&Provisioning{
+		Access: map[string][]Grant{"ben": {{TopicPattern: "ben", Allow: PermissionRead}}},
+	}), ErrInvalidArgument)
+
+	// Nothing was written
+	_, err = a.User("phil")
+	require.Equal(t, ErrUserNotFound, err)
+}
+
+func TestManager_Changes_ApplyChanges(t *testing.T) {
+	primary := newTestManager(t, PermissionDenyAll)
+	standby := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, primary.AddTier(&Tier{Code: "pro", Name: "Pro"}))
+	require.Nil(t, primary.AddUser("phil", "phil", RoleAdmin))
+	require.Nil(t, primary.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, primary.ChangeTier("ben", "pro"))
+	require.Nil(t, primary.AllowAccess("ben", "ben_*", PermissionReadWrite))
+	require.Nil(t, primary.AllowAccess(Everyone, "announcements", PermissionRead))
+	require.Nil(t, primary.AddReservation("ben", "mytopic", PermissionRead))
+	ben, err := primary.User("ben")
+	require.Nil(t, err)
+	token, err := primary.CreateToken(ben.ID, "my token", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+	sensorToken, err := primary.CreateToken(ben.ID, "sensor", time.Unix(0, 0), netip.IPv4Unspecified(), []netip.Prefix{netip.MustParsePrefix("10.0.1.0/24")})
+	require.Nil(t, err)
+	require.Nil(t, standby.AddUser("stale", "stale", RoleUser)) // Removed by full sync
+
+	// Full sync
+	changes, err := primary.Changes(0)
+	require.Nil(t, err)
+	require.True(t, changes.Full)
+	require.Equal(t, 3, len(changes.Users))
+	require.Nil(t, standby.ApplyChanges(changes))
+
+	_, err = standby.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	u, err := standby.Authenticate("ben", "ben")
+	require.Nil(t, err)
+	require.Equal(t, ben.ID, u.ID)
+	require.Equal(t, "pro", u.Tier.Code)
+	require.Nil(t, standby.Authorize(u, "ben_stuff", PermissionWrite))
+	require.Nil(t, standby.Authorize(nil, "announcements", PermissionRead))
+	require.Nil(t, standby.Authorize(nil, "mytopic", PermissionRead))
+	owner, err := standby.ReservationOwner("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, ben.ID, owner)
+	u, err = standby.AuthenticateToken(token.Value, netip.IPv4Unspecified())
+	require.Nil(t, err)
+	require.Equal(t, "ben", u.Name)
+	_, err = standby.AuthenticateToken(sensorToken.Value, netip.MustParseAddr("10.0.1.2"))
+	require.Nil(t, err)
+	_, err = standby.AuthenticateToken(sensorToken.Value, netip.MustParseAddr("10.0.2.2"))
+	require.Equal(t, ErrTokenOriginNotAllowed, err)
+	_, err = standby.User("stale")
+	require.Equal(t, ErrUserNotFound, err)
+
+	// Nothing changed
+	unchanged, err := primary.Changes(changes.Position)
+	require.Nil(t, err)
+	require.False(t, unchanged.Full)
+	require.Equal(t, changes.Position, unchanged.Position)
+	require.Equal(t, 0, len(unchanged.Users))
+
+	// Incremental sync: only changed users are included
+	require.Nil(t, primary.ChangePassword("ben", "newpass"))
+	require.Nil(t, primary.ResetAccess("ben", "ben_*"))
+	require.Nil(t, primary.RemoveToken(ben.ID, token.Value))
+	require.Nil(t, primary.RemoveUser("phil"))
+	primary.EnqueueTokenUpdate(token.Value, &TokenUpdate{LastAccess: time.Now()}) // Does not cause a change
+	require.Nil(t, primary.writeTokenUpdateQueue())
+
+	incremental, err := primary.Changes(changes.Position)
+	require.Nil(t, err)
+	require.False(t, incremental.Full)
+	require.Equal(t, 2, len(incremental.Users))
+	require.Nil(t, standby.ApplyChanges(incremental))
+
+	_, err = standby.User("phil")
+	require.Equal(t, ErrUserNotFound, err)
+	_, err = standby.Authenticate("ben", "ben")
+	require.Equal(t, ErrUnauthenticated, err)
+	u, err = standby.Authenticate("ben", "newpass")
+	require.Nil(t, err)
+	require.Equal(t, ErrUnauthorized, standby.Authorize(u, "ben_stuff", PermissionWrite))
+	require.Nil(t, standby.Authorize(nil, "announcements", PermissionRead))
+	_, err = standby.AuthenticateToken(token.Value, netip.IPv4Unspecified())
+	require.Equal(t, ErrUnauthenticated, err)
+}
+
+func TestManager_Changes_ApplyChanges_GroupsPublishKeysTiers(t *testing.T) {
+	primary := newTestManager(t, PermissionDenyAll)
+	standby := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, primary.AddTier(&Tier{Code: "pro", Name: "Pro", MessageLimit: 1000, MessageExpiryDuration: time.Hour, AttachmentTotalSizeLimit: 5000, StripeMonthlyPriceID: "price_123"}))
+	require.Nil(t, primary.AddUser("ben", "ben", RoleUser))
+	require.Nil(t, primary.AddUser("emma", "emma", RoleUser))
+	require.Nil(t, primary.ChangeTier("ben", "pro"))
+	require.Nil(t, primary.AddReservation("ben", "sensors", PermissionDenyAll))
+	key, err := primary.CreatePublishKey("ben", "sensors", "sensor", &PublishKeyDefaults{Priority: 4, Tags: []string{"thermometer"}})
+	require.Nil(t, err)
+	require.Nil(t, primary.AddGroup("ops"))
+	require.Nil(t, primary.AddGroupMembers("ops", "ben", "emma"))
+	require.Nil(t, primary.AllowGroupAccess("ops", "alerts", PermissionReadWrite))
+	require.Nil(t, primary.ShareReservation("ben", "sensors", "ops"))
+	require.Nil(t, standby.AddTier(&Tier{Code: "stale", Name: "Stale"})) // Removed by full sync
+	require.Nil(t, standby.AddGroup("stale"))                            // Removed by full sync
+
+	// Full sync
+	changes, err := primary.Changes(0)
+	require.Nil(t, err)
+	require.True(t, changes.Full)
+	require.Equal(t, 1, len(changes.Groups))
+	require.Equal(t, 1, len(changes.Tiers))
+	require.Nil(t, standby.ApplyChanges(changes))
+
+	tier, err := standby.Tier("pro")
+	require.Nil(t, err)
+	require.Equal(t, "Pro", tier.Name)
+	require.Equal(t, int64(1000), tier.MessageLimit)
+	require.Equal(t, time.Hour, tier.MessageExpiryDuration)
+	require.Equal(t, int64(5000), tier.AttachmentTotalSizeLimit)
+	require.Equal(t, "price_123", tier.StripeMonthlyPriceID)
+	u, err := standby.User("ben")
+	require.Nil(t, err)
+	require.Equal(t, "pro", u.Tier.Code)
+	_, err = standby.Tier("stale")
+	require.Equal(t, ErrTierNotFound, err)
+
+	publishKey, err := standby.PublishKey(key.Value)
+	require.Nil(t, err)
+	require.Equal(t, "sensors", publishKey.Topic)
+	require.Equal(t, "sensor", publishKey.Label)
+	require.Equal(t, 4, publishKey.Defaults.Priority)
+	require.Equal(t, []string{"thermometer"}, publishKey.Defaults.Tags)
+	require.Nil(t, standby.AuthorizePublishKey(key.Value, "sensors"))
+
+	group, err := standby.Group("ops")
+	require.Nil(t, err)
+	require.Equal(t, []string{"ben", "emma"}, group.Members)
+	require.Equal(t, []Grant{{TopicPattern: "alerts", Allow: PermissionReadWrite}}, group.Grants)
+	emma, err := standby.User("emma")
+	require.Nil(t, err)
+	require.Nil(t, standby.Authorize(emma, "alerts", PermissionWrite))
+	require.Nil(t, standby.Authorize(emma, "sensors", PermissionRead)) // Shared reservation
+	_, err = standby.Group("stale")
+	require.Equal(t, ErrGroupNotFound, err)
+
+	// Incremental sync
+	tier.Name = "Professional"
+	tier.MessageLimit = 2000
+	require.Nil(t, primary.UpdateTier(tier))
+	require.Nil(t, primary.AddTier(&Tier{Code: "business", Name: "Business"}))
+	require.Nil(t, primary.RemoveGroupMembers("ops", "emma"))
+	require.Nil(t, primary.RemovePublishKey("ben", "sensors", key.Value))
+	require.Nil(t, primary.AddGroup("dev"))
+
+	incremental, err := primary.Changes(changes.Position)
+	require.Nil(t, err)
+	require.False(t, incremental.Full)
+	require.Equal(t, 1, len(incremental.Users))
+	require.Equal(t, 2, len(incremental.Groups))
+	require.Equal(t, 2, len(incremental.Tiers))
+	require.Nil(t, standby.ApplyChanges(incremental))
+
+	tier, err = standby.Tier("pro")
+	require.Nil(t, err)
+	require.Equal(t, "Professional", tier.Name)
+	require.Equal(t, int64(2000), tier.MessageLimit)
+	_, err = standby.Tier("business")
+	require.Nil(t, err)
+	_, err = standby.PublishKey(key.Value)
+	require.Equal(t, ErrUnauthorized, err)
+	group, err = standby.Group("ops")
+	require.Nil(t, err)
+	require.Equal(t, []string{"ben"}, group.Members)
+	require.Equal(t, ErrUnauthorized, standby.Authorize(emma, "alerts", PermissionWrite))
+	_, err = standby.Group("dev")
+	require.Nil(t, err)
+
+	// Removed tiers and groups
+	require.Nil(t, primary.ResetTier("ben"))
+	require.Nil(t, primary.RemoveTier("pro"))
+	require.Nil(t, primary.RemoveGroup("ops"))
+	incremental, err = primary.Changes(incremental.Position)
+	require.Nil(t, err)
+	require.Nil(t, standby.ApplyChanges(incremental))
+	_, err = standby.Tier("pro")
+	require.Equal(t, ErrTierNotFound, err)
+	u, err = standby.User("ben")
+	require.Nil(t, err)
+	require.Nil(t, u.Tier)
+	_, err = standby.Group("ops")
+	require.Equal(t, ErrGroupNotFound, err)
+}
+
+func TestManager_Changes_FullAfterRemoveOldChanges(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+	changes, err := a.Changes(0)
+	require.Nil(t, err)
+	require.True(t, changes.Full)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+
+	// Changes since the last position are gone, so a full sync is required
+	_, err = a.db.Exec(`UPDATE user_change SET time = ?`, time.Now().Add(-2*userChangeRetention).Unix())
+	require.Nil(t, err)
+	require.Nil(t, a.RemoveOldChanges())
+	full, err := a.Changes(changes.Position)
+	require.Nil(t, err)
+	require.True(t, full.Full)
+	require.Equal(t, changes.Position+1, full.Position)
+	require.Equal(t, 3, len(full.Users)) // Everyone, phil and ben
+
+	// Position is kept even though the change log is empty
+	unchanged, err := a.Changes(full.Position)
+	require.Nil(t, err)
+	require.False(t, unchanged.Full)
+	require.Equal(t, 0, len(unchanged.Users))
+
+	// Position from another database
+	full, err = a.Changes(full.Position + 100)
+	require.Nil(t, err)
+	require.True(t, full.Full)
+}
+
+func TestMigrationFrom12(t *testing.T) {
+	filename := createTestSchema(t, 12, `
+		INSERT INTO user (id, user, pass, role, sync_topic, created) VALUES ('u_phil', 'phil', '$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C', 'user', 'phil-sync', UNIXEPOCH());
+	`)
+
+	// Existing users are in the change log after the migration
+	a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+	checkSchemaVersion(t, a.db)
+	changes, err := a.Changes(1)
+	require.Nil(t, err)
+	require.False(t, changes.Full)
+	require.Equal(t, int64(2), changes.Position)
+	require.Equal(t, 1, len(changes.Users))
+	require.Equal(t, "phil", changes.Users[0].Name)
+}
+
+func TestMigrationFrom13(t *testing.T) {
+	filename := createTestSchema(t, 13, `
+		INSERT INTO user (id, user, pass, role, sync_topic, created) VALUES ('u_phil', 'phil', '$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C', 'user', 'phil-sync', UNIXEPOCH());
+		INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires) VALUES ('u_phil', 'tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa', '', UNIXEPOCH(), '', 0);
+	`)
+
+	// Existing tokens are not bound to any IP range after the migration
+	a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+	checkSchemaVersion(t, a.db)
+	u, err := a.AuthenticateToken("tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa", netip.MustParseAddr("9.9.9.9"))
+	require.Nil(t, err)
+	require.Equal(t, "phil", u.Name)
+	tokens, err := a.Tokens(u.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tokens))
+	require.Nil(t, tokens[0].AllowedIPs)
+}
+
+func TestMigrationFrom14(t *testing.T) {
+	filename := createTestSchema(t, 14, `
+		INSERT INTO user (id, user, pass, role, sync_topic, created) VALUES ('u_phil', 'phil', '$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C', 'user', 'phil-sync', UNIXEPOCH());
+		INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires) VALUES ('u_phil', 'tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa', '', UNIXEPOCH(), '', 0);
+	`)
+
+	// Existing tokens are not impersonation tokens after the migration
+	a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+	checkSchemaVersion(t, a.db)
+	u, err := a.AuthenticateToken("tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa", netip.IPv4Unspecified())
+	require.Nil(t, err)
+	require.Equal(t, "phil", u.Name)
+	require.Equal(t, "", u.Impersonator)
+}
+
+func TestMigrationFrom15(t *testing.T) {
+	filename := createTestSchema(t, 15, `
+		INSERT INTO user (id, user, pass, role, sync_topic, created) VALUES ('u_phil', 'phil', '$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C', 'user', 'phil-sync', UNIXEPOCH());
+	`)
+
+	// Existing users are not scheduled for deletion after the migration
+	a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+	checkSchemaVersion(t, a.db)
+	u, err := a.User("phil")
+	require.Nil(t, err)
+	require.True(t, u.DeletionScheduled.IsZero())
+}
+
+func TestMigrationFrom16(t *testing.T) {
+	filename := createTestSchema(t, 16, `
+		INSERT INTO user (id, user, pass, role, sync_topic, created) VALUES ('u_phil', 'phil', '$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C', 'user', 'phil-sync', UNIXEPOCH());
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_phil', 'alerts', 1, 1, 'u_phil');
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_everyone', 'alerts', 0, 0, 'u_phil');
+	`)
+
+	// Existing reservations have no routing rules after the migration, and routing changes are in the change log
+	a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+	checkSchemaVersion(t, a.db)
+	rules, _, err := a.ReservationRouting("alerts")
+	require.Nil(t, err)
+	require.Nil(t, rules)
+	before, err := a.Changes(0)
+	require.Nil(t, err)
+	require.Nil(t, a.ChangeReservationRouting("phil", "alerts", []*RoutingRule{{Priorities: []int{5}, Push: true}}))
+	after, err := a.Changes(0)
+	require.Nil(t, err)
+	require.Greater(t, after.Position, before.Position)
+}
+
+func TestMigrationFrom17(t *testing.T) {
+	filename := createTestSchema(t, 17, `
+		INSERT INTO user (id, user, pass, role, sync_topic, created) VALUES ('u_phil', 'phil', '$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C', 'user', 'phil-sync', UNIXEPOCH());
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_phil', 'alerts', 1, 1, 'u_phil');
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_everyone', 'alerts', 0, 0, 'u_phil');
+	`)
+
+	// Existing reservations have no escalation policy after the migration, and escalation changes are in the change log
+	a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+	checkSchemaVersion(t, a.db)
+	policy, _, err := a.ReservationEscalation("alerts")
+	require.Nil(t, err)
+	require.Nil(t, policy)
+	before, err := a.Changes(0)
+	require.Nil(t, err)
+	require.Nil(t, a.ChangeReservationEscalation("phil", "alerts", &Escalation{Priority: 4, Steps: []*EscalationStep{{Delay: time.Minute, Topic: "alerts-backup"}}}))
+	after, err := a.Changes(0)
+	require.Nil(t, err)
+	require.Greater(t, after.Position, before.Position)
+}
+
+func TestMigrationFrom18(t *testing.T) {
+	filename := createTestSchema(t, 18, `
+		INSERT INTO user (id, user, pass, role, sync_topic, created) VALUES ('u_phil', 'phil', '$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C', 'user', 'phil-sync', UNIXEPOCH());
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_phil', 'alerts', 1, 1, 'u_phil');
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_everyone', 'alerts', 0, 0, 'u_phil');
+	`)
+
+	// Existing reservations have no schedule after the migration, and schedule changes are in the change log
+	a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+	checkSchemaVersion(t, a.db)
+	schedule, _, err := a.ReservationSchedule("alerts")
+	require.Nil(t, err)
+	require.Nil(t, schedule)
+	before, err := a.Changes(0)
+	require.Nil(t, err)
+	require.Nil(t, a.ChangeReservationSchedule("phil", "alerts", &Schedule{Shift: time.Hour, Members: []*ScheduleMember{{Name: "phil"}}}))
+	after, err := a.Changes(0)
+	require.Nil(t, err)
+	require.Greater(t, after.Position, before.Position)
+}
+
+func TestMigrationFrom19(t *testing.T) {
+	filename := createTestSchema(t, 19, `
+		INSERT INTO user (id, user, pass, role, sync_topic, created) VALUES ('u_phil', 'phil', '$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C', 'user', 'phil-sync', UNIXEPOCH());
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_phil', 'sensors', 1, 1, 'u_phil');
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_everyone', 'sensors', 0, 0, 'u_phil');
+		INSERT INTO user_publish_key (user_id, topic, publish_key, label, created) VALUES ('u_phil', 'sensors', 'pk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa', 'sensor', UNIXEPOCH());
+	`)
+
+	// Existing publish keys have no defaults after the migration
+	a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+	checkSchemaVersion(t, a.db)
+	publishKey, err := a.PublishKey("pk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	require.Nil(t, err)
+	require.Equal(t, "sensors", publishKey.Topic)
+	require.Nil(t, publishKey.Defaults)
+}
+
+func TestMigrationFrom20(t *testing.T) {
+	filename := createTestSchema(t, 20, `
+		INSERT INTO user (id, user, pass, role, sync_topic, created) VALUES ('u_phil', 'phil', '$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C', 'user', 'phil-sync', UNIXEPOCH());
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_phil', 'alerts', 1, 1, 'u_phil');
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_everyone', 'alerts', 0, 0, 'u_phil');
+	`)
+
+	// Existing reservations have no policy after the migration, and policy changes are in the change log
+	a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+	checkSchemaVersion(t, a.db)
+	policy, err := a.ReservationPolicy("alerts")
+	require.Nil(t, err)
+	require.Nil(t, policy)
+	before, err := a.Changes(0)
+	require.Nil(t, err)
+	require.Nil(t, a.ChangeReservationPolicy("phil", "alerts", &TopicPolicy{NoAttachments: true}))
+	after, err := a.Changes(0)
+	require.Nil(t, err)
+	require.Greater(t, after.Position, before.Position)
+}
+
+func TestMigrationFrom21(t *testing.T) {
+	filename := createTestSchema(t, 21, `
+		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit) VALUES ('ti_pro', 'pro', 'Pro', 1000, 0, 0, 0, 0, 0, 0, 0, 0);
+	`)
+
+	// Existing tiers use the server default message size limit after the migration
+	a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+	checkSchemaVersion(t, a.db)
+	tier, err := a.Tier("pro")
+	require.Nil(t, err)
+	require.Equal(t, int64(0), tier.MessageSizeLimit)
+	tier.MessageSizeLimit = 65536
+	require.Nil(t, a.UpdateTier(tier))
+	tier, err = a.Tier("pro")
+	require.Nil(t, err)
+	require.Equal(t, int64(65536), tier.MessageSizeLimit)
+}
+
+func TestMigrationFrom22(t *testing.T) {
+	filename := createTestSchema(t, 22, `
+		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit) VALUES ('ti_pro', 'pro', 'Pro', 1000, 0, 0, 0, 0, 0, 0, 0, 0);
+		INSERT INTO user (id, user, pass, role, sync_topic, created) VALUES ('u_ben', 'ben', '$2a$10$EEp6gBheOsqEFsXlo523E.gBVoeg1ytphXiEvTPlNzkenBlHZBPQy', 'user', 'ben-sync', UNIXEPOCH());
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_ben', 'sensors', 1, 1, 'u_ben');
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id) VALUES ('u_everyone', 'sensors', 0, 0, 'u_ben');
+		INSERT INTO user_publish_key (user_id, topic, publish_key, label, created) VALUES ('u_ben', 'sensors', 'pk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa', 'sensor', UNIXEPOCH());
+		INSERT INTO user_group (id, name, created) VALUES ('gr_ops', 'ops', UNIXEPOCH());
+		INSERT INTO user_group_member (group_id, user_id) VALUES ('gr_ops', 'u_ben');
+	`)
+
+	// Existing groups, tiers and users with publish keys are in the change log after the migration
+	a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+	checkSchemaVersion(t, a.db)
+	full, err := a.Changes(0)
+	require.Nil(t, err)
+	changes, err := a.Changes(full.Position - 3)
+	require.Nil(t, err)
+	require.False(t, changes.Full)
+	require.Equal(t, 1, len(changes.Users))
+	require.Equal(t, "ben", changes.Users[0].Name)
+	require.Equal(t, 1, len(changes.Users[0].PublishKeys))
+	require.Equal(t, 1, len(changes.Groups))
+	require.Equal(t, "ops", changes.Groups[0].Name)
+	require.Equal(t, []string{"ben"}, changes.Groups[0].Members)
+	require.Equal(t, 1, len(changes.Tiers))
+	require.Equal(t, "pro", changes.Tiers[0].Code)
+
+	// Changes to groups and tiers are in the change log
+	require.Nil(t, a.AllowGroupAccess("ops", "alerts", PermissionRead))
+	require.Nil(t, a.UpdateTier(&Tier{Code: "pro", Name: "Pro", MessageLimit: 2000}))
+	changes, err = a.Changes(full.Position)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(changes.Users))
+	require.Equal(t, 1, len(changes.Groups))
+	require.Equal(t, 1, len(changes.Tiers))
+	require.Equal(t, int64(2000), changes.Tiers[0].MessageLimit)
+}
+
 func checkSchemaVersion(t *testing.T, db *sql.DB) {
 	rows, err := db.Query(`SELECT version FROM schemaVersion`)
 	require.Nil(t, err)
@@ -1321,6 +2649,13 @@ func checkSchemaVersion(t *testing.T, db *sql.DB) {
 	require.Nil(t, rows.Close())
 }
 
+func TestManager_Ping(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.Ping(context.Background()))
+	require.Nil(t, a.Close())
+	require.Error(t, a.Ping(context.Background()))
+}
+
 func newTestManager(t *testing.T, defaultAccess Permission) *Manager {
 	return newTestManagerFromFile(t, filepath.Join(t.TempDir(), "user.db"), "", defaultAccess, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
 }
@@ -1330,3 +2665,242 @@ func newTestManagerFromFile(t *testing.T, filename, startupQueries string, defau
 	require.Nil(t, err)
 	return a
 }
+
+// testSchemaVersion12 is the "version 12" schema, see createTestSchema
+const testSchemaVersion12 = `
+		CREATE TABLE IF NOT EXISTS tier (
+			id TEXT PRIMARY KEY,
+			code TEXT NOT NULL,
+			name TEXT NOT NULL,
+			messages_limit INT NOT NULL,
+			messages_expiry_duration INT NOT NULL,
+			emails_limit INT NOT NULL,
+			calls_limit INT NOT NULL,
+			reservations_limit INT NOT NULL,
+			attachment_file_size_limit INT NOT NULL,
+			attachment_total_size_limit INT NOT NULL,
+			attachment_expiry_duration INT NOT NULL,
+			attachment_bandwidth_limit INT NOT NULL,
+			stripe_monthly_price_id TEXT,
+			stripe_yearly_price_id TEXT,
+			provisioned INT NOT NULL DEFAULT (0)
+		);
+		CREATE UNIQUE INDEX idx_tier_code ON tier (code);
+		CREATE UNIQUE INDEX idx_tier_stripe_monthly_price_id ON tier (stripe_monthly_price_id);
+		CREATE UNIQUE INDEX idx_tier_stripe_yearly_price_id ON tier (stripe_yearly_price_id);
+		CREATE TABLE IF NOT EXISTS user (
+		    id TEXT PRIMARY KEY,
+			tier_id TEXT,
+			user TEXT NOT NULL,
+			pass TEXT NOT NULL,
+			role TEXT CHECK (role IN ('anonymous', 'admin', 'user')) NOT NULL,
+			prefs JSON NOT NULL DEFAULT '{}',
+			sync_topic TEXT NOT NULL,
+			stats_messages INT NOT NULL DEFAULT (0),
+			stats_emails INT NOT NULL DEFAULT (0),
+			stats_calls INT NOT NULL DEFAULT (0),
+			stripe_customer_id TEXT,
+			stripe_subscription_id TEXT,
+			stripe_subscription_status TEXT,
+			stripe_subscription_interval TEXT,
+			stripe_subscription_paid_until INT,
+			stripe_subscription_cancel_at INT,
+			created INT NOT NULL,
+			deleted INT,
+			disabled INT NOT NULL DEFAULT (0),
+			provisioned INT NOT NULL DEFAULT (0),
+		    FOREIGN KEY (tier_id) REFERENCES tier (id)
+		);
+		CREATE UNIQUE INDEX idx_user ON user (user);
+		CREATE UNIQUE INDEX idx_user_stripe_customer_id ON user (stripe_customer_id);
+		CREATE UNIQUE INDEX idx_user_stripe_subscription_id ON user (stripe_subscription_id);
+		CREATE TABLE IF NOT EXISTS user_access (
+			user_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			auth_write INT NOT NULL DEFAULT (0),
+			owner_user_id INT,
+			provisioned INT NOT NULL DEFAULT (0),
+			last_active INT NOT NULL DEFAULT (0),
+			inactivity_warned_at INT NOT NULL DEFAULT (0),
+			sound TEXT NOT NULL DEFAULT (''),
+			PRIMARY KEY (user_id, topic),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE,
+		    FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_token (
+			user_id TEXT NOT NULL,
+			token TEXT NOT NULL,
+			label TEXT NOT NULL,
+			last_access INT NOT NULL,
+			last_origin TEXT NOT NULL,
+			expires INT NOT NULL,
+			provisioned INT NOT NULL DEFAULT (0),
+			PRIMARY KEY (user_id, token),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_phone (
+			user_id TEXT NOT NULL,
+			phone_number TEXT NOT NULL,
+			PRIMARY KEY (user_id, phone_number),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_publish_key (
+			user_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			publish_key TEXT NOT NULL,
+			label TEXT NOT NULL,
+			created INT NOT NULL,
+			PRIMARY KEY (publish_key),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX idx_user_publish_key_user_id_topic ON user_publish_key (user_id, topic);
+		CREATE TABLE IF NOT EXISTS user_group (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE UNIQUE INDEX idx_user_group_name ON user_group (name);
+		CREATE TABLE IF NOT EXISTS user_group_member (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_group_access (
+			group_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			owner_user_id TEXT,
+			PRIMARY KEY (group_id, topic),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS schemaVersion (
+			id INT PRIMARY KEY,
+			version INT NOT NULL
+		);
+		INSERT INTO user (id, user, pass, role, sync_topic, created)
+		VALUES ('u_everyone', '*', '', 'anonymous', '', UNIXEPOCH());
+		INSERT INTO schemaVersion (id, version) VALUES (1, 12);
+`
+
+// testSchemaUpgrades are the schema changes of all versions after 12, exactly as they were released. They are
+// deliberately not taken from the migrations, so that the old schemas do not change when a migration is added.
+var testSchemaUpgrades = map[int]string{
+	13: `
+		CREATE TABLE IF NOT EXISTS user_change (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user TEXT NOT NULL,
+			time INT NOT NULL
+		);
+		CREATE TRIGGER user_change_insert AFTER INSERT ON user
+		BEGIN
+			INSERT INTO user_change (user, time) VALUES (NEW.user, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_change_update AFTER UPDATE OF user, pass, role, tier_id, sync_topic, deleted, disabled, provisioned ON user
+		BEGIN
+			INSERT INTO user_change (user, time) VALUES (NEW.user, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_change_delete AFTER DELETE ON user
+		BEGIN
+			INSERT INTO user_change (user, time) VALUES (OLD.user, UNIXEPOCH());
+		END;
+		CREATE TRIGGER user_access_change_insert AFTER INSERT ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_access_change_update AFTER UPDATE OF read, write, auth_write, owner_user_id, sound, provisioned ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_access_change_delete AFTER DELETE ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = OLD.user_id;
+		END;
+		CREATE TRIGGER user_token_change_insert AFTER INSERT ON user_token
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_token_change_update AFTER UPDATE OF label, expires, provisioned ON user_token
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+		CREATE TRIGGER user_token_change_delete AFTER DELETE ON user_token
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = OLD.user_id;
+		END;
+	`,
+	14: `
+		ALTER TABLE user_token ADD COLUMN allowed_ips TEXT NOT NULL DEFAULT ('');
+	`,
+	15: `
+		ALTER TABLE user_token ADD COLUMN impersonator TEXT NOT NULL DEFAULT ('');
+	`,
+	16: `
+		ALTER TABLE user ADD COLUMN deletion_scheduled INT;
+	`,
+	17: `
+		ALTER TABLE user_access ADD COLUMN routing TEXT NOT NULL DEFAULT ('');
+		DROP TRIGGER IF EXISTS user_access_change_update;
+		CREATE TRIGGER user_access_change_update AFTER UPDATE OF read, write, auth_write, owner_user_id, sound, routing, provisioned ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+	`,
+	18: `
+		ALTER TABLE user_access ADD COLUMN escalation TEXT NOT NULL DEFAULT ('');
+		DROP TRIGGER IF EXISTS user_access_change_update;
+		CREATE TRIGGER user_access_change_update AFTER UPDATE OF read, write, auth_write, owner_user_id, sound, routing, escalation, provisioned ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+	`,
+	19: `
+		ALTER TABLE user_access ADD COLUMN schedule TEXT NOT NULL DEFAULT ('');
+		DROP TRIGGER IF EXISTS user_access_change_update;
+		CREATE TRIGGER user_access_change_update AFTER UPDATE OF read, write, auth_write, owner_user_id, sound, routing, escalation, schedule, provisioned ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+	`,
+	20: `
+		ALTER TABLE user_publish_key ADD COLUMN title_prefix TEXT NOT NULL DEFAULT ('');
+		ALTER TABLE user_publish_key ADD COLUMN priority INT NOT NULL DEFAULT (0);
+		ALTER TABLE user_publish_key ADD COLUMN tags TEXT NOT NULL DEFAULT ('');
+	`,
+	21: `
+		ALTER TABLE user_access ADD COLUMN policy TEXT NOT NULL DEFAULT ('');
+		DROP TRIGGER IF EXISTS user_access_change_update;
+		CREATE TRIGGER user_access_change_update AFTER UPDATE OF read, write, auth_write, owner_user_id, sound, routing, escalation, schedule, policy, provisioned ON user_access
+		BEGIN
+			INSERT INTO user_change (user, time) SELECT user, UNIXEPOCH() FROM user WHERE id = NEW.user_id;
+		END;
+	`,
+	22: `
+		ALTER TABLE tier ADD COLUMN message_size_limit INT NOT NULL DEFAULT (0);
+	`,
+}
+
+// createTestSchema creates a user database with the schema of the given version (12 or later) and the given rows,
+// and returns its filename. It is used to test the migrations from that version.
+func createTestSchema(t *testing.T, version int, rows string) string {
+	filename := filepath.Join(t.TempDir(), "user.db")
+	db, err := sql.Open("sqlite3", filename)
+	require.Nil(t, err)
+	_, err = db.Exec(testSchemaVersion12)
+	require.Nil(t, err)
+	for v := 13; v <= version; v++ {
+		_, err = db.Exec(testSchemaUpgrades[v])
+		require.Nil(t, err)
+		_, err = db.Exec(`UPDATE schemaVersion SET version = ?`, v)
+		require.Nil(t, err)
+	}
+	_, err = db.Exec(rows)
+	require.Nil(t, err)
+	require.Nil(t, db.Close())
+	return filename
+}
@@ -2,6 +2,7 @@ package user
 
 import (
 	"errors"
+	"fmt"
 	"github.com/stripe/stripe-go/v74"
 	"heckel.io/ntfy/v2/log"
 	"net/netip"
@@ -12,17 +13,20 @@ import (
 
 // User is a struct that represents a user
 type User struct {
-	ID        string
-	Name      string
-	Hash      string // password hash (bcrypt)
-	Token     string // Only set if token was used to log in
-	Role      Role
-	Prefs     *Prefs
-	Tier      *Tier
-	Stats     *Stats
-	Billing   *Billing
-	SyncTopic string
-	Deleted   bool
+	ID                string
+	Name              string
+	Hash              string // password hash (bcrypt)
+	Token             string // Only set if token was used to log in
+	Role              Role
+	Prefs             *Prefs
+	Tier              *Tier
+	Stats             *Stats
+	Billing           *Billing
+	SyncTopic         string
+	Deleted           bool
+	Disabled          bool      // Disabled users cannot authenticate, e.g. if deactivated via SCIM
+	Impersonator      string    // Only set if an impersonation token was used to log in, name of the admin
+	DeletionScheduled time.Time // Zero if no deletion is scheduled, see Manager.ScheduleUserDeletion
 }
 
 // TierID returns the ID of the User.Tier, or an empty string if the user has no tier,
@@ -58,11 +62,13 @@ type Auther interface {
 
 // Token represents a user token, including expiry date
 type Token struct {
-	Value      string
-	Label      string
-	LastAccess time.Time
-	LastOrigin netip.Addr
-	Expires    time.Time
+	Value        string
+	Label        string
+	LastAccess   time.Time
+	LastOrigin   netip.Addr
+	Expires      time.Time
+	AllowedIPs   []netip.Prefix // If not empty, the token can only be used from these IP ranges
+	Impersonator string         // If not empty, the token was issued to this admin to act as the user
 }
 
 // TokenUpdate holds information about the last access time and origin IP address of a token
@@ -71,11 +77,35 @@ type TokenUpdate struct {
 	LastOrigin netip.Addr
 }
 
+// PublishKeyPrefix is the prefix of all publish keys, used to distinguish them from user access tokens
+const PublishKeyPrefix = "pk_"
+
+// PublishKey represents a per-topic publish key, which allows publishing to a single reserved topic
+// without having to authenticate as the owner of the reservation
+type PublishKey struct {
+	Value    string
+	Topic    string
+	Label    string
+	Created  time.Time
+	Defaults *PublishKeyDefaults // May be nil
+}
+
+// PublishKeyDefaults are message attributes that are applied to all messages published with a publish key, so
+// that devices that can only send a plain message body still produce well-formed notifications. Attributes that
+// are set by the publisher take precedence, except for tags, which are merged.
+type PublishKeyDefaults struct {
+	TitlePrefix string   // Prepended to the title, or used as title if the message has none
+	Priority    int      // Used if the message has no priority, 0 means none
+	Tags        []string // Added to the tags of the message
+}
+
 // Prefs represents a user's configuration settings
 type Prefs struct {
 	Language      *string            `json:"language,omitempty"`
 	Notification  *NotificationPrefs `json:"notification,omitempty"`
 	Subscriptions []*Subscription    `json:"subscriptions,omitempty"`
+	QuotaWarning  *QuotaWarningPrefs `json:"quota_warning,omitempty"`
+	Templates     []*Template        `json:"templates,omitempty"`
 }
 
 // Tier represents a user's account type, including its account limits
@@ -92,6 +122,7 @@ type Tier struct {
 	AttachmentTotalSizeLimit int64         // Total file size for all files of this user (bytes)
 	AttachmentExpiryDuration time.Duration // Duration after which attachments will be deleted
 	AttachmentBandwidthLimit int64         // Daily bandwidth limit for the user
+	MessageSizeLimit         int64         // Max size of a message body (bytes), 0 to use the server default
 	StripeMonthlyPriceID     string        // Monthly price ID for paid tiers (price_...)
 	StripeYearlyPriceID      string        // Yearly price ID for paid tiers (price_...)
 }
@@ -106,11 +137,28 @@ func (t *Tier) Context() log.Context {
 	}
 }
 
+// Template is a named message template stored by a user. Title, message and actions are Go templates that are
+// rendered with the JSON body of a publish request (X-Template: <name>).
+type Template struct {
+	Name    string `json:"name"`
+	Title   string `json:"title,omitempty"`
+	Message string `json:"message,omitempty"`
+	Actions string `json:"actions,omitempty"` // Action buttons, in the X-Actions format (JSON or simple format)
+}
+
+// Context returns fields for the log
+func (t *Template) Context() log.Context {
+	return log.Context{
+		"template_name": t.Name,
+	}
+}
+
 // Subscription represents a user's topic subscription
 type Subscription struct {
 	BaseURL     string  `json:"base_url"`
 	Topic       string  `json:"topic"`
 	DisplayName *string `json:"display_name"`
+	Sound       *string `json:"sound,omitempty"`
 }
 
 // Context returns fields for the log
@@ -128,6 +176,13 @@ type NotificationPrefs struct {
 	DeleteAfter *int    `json:"delete_after,omitempty"`
 }
 
+// QuotaWarningPrefs defines where to send a notification when a user is about to reach
+// their daily message or email quota, or their attachment storage quota
+type QuotaWarningPrefs struct {
+	Topic *string `json:"topic,omitempty"`
+	Email *string `json:"email,omitempty"`
+}
+
 // Stats is a struct holding daily user statistics
 type Stats struct {
 	Messages int64
@@ -151,11 +206,115 @@ type Grant struct {
 	Allow        Permission
 }
 
-// Reservation is a struct that represents the ownership over a topic by a user
+// Provisioning is the desired state of tiers, users, access control entries and tokens as defined in
+// the server config. It is applied to the database via Manager.Provision.
+type Provisioning struct {
+	Tiers  []*Tier             // Tiers to create or update
+	Users  []*User             // Users to create or update; Name, Hash (bcrypt), Role and optionally Tier.Code must be set
+	Access map[string][]Grant  // Username (or Everyone) -> grants
+	Tokens map[string][]*Token // Username -> tokens; Value (the token hash, see HashToken) and optionally Label must be set
+}
+
+// Reservation is a struct that represents the ownership over a topic by a user. The topic may
+// also be a topic prefix (e.g. "myteam-*"), in which case all matching topics are owned by the user.
 type Reservation struct {
-	Topic    string
-	Owner    Permission
-	Everyone Permission
+	Topic      string
+	Owner      Permission
+	Everyone   Permission
+	Group      string         // Group the reservation is shared with (read-write), may be empty
+	Sound      string         // Default notification sound for messages without a sound, may be empty
+	Routing    []*RoutingRule // Delivery channels by message priority, may be empty
+	Escalation *Escalation    // Escalation policy for unacknowledged messages, may be nil
+	Schedule   *Schedule      // On-call schedule, may be nil
+	Policy     *TopicPolicy   // Restrictions for messages published to the topic, may be nil
+}
+
+// RoutingRule defines the delivery channels for messages of the given priorities that are published to a
+// reserved topic. Messages with a priority not covered by any rule are delivered as usual.
+type RoutingRule struct {
+	Priorities []int  `json:"priorities"`      // Message priorities (1-5) the rule applies to
+	Push       bool   `json:"push"`            // Forward to Firebase and Web Push; if false, messages are only cached and sent to connected subscribers
+	Email      string `json:"email,omitempty"` // E-mail address to forward messages to, may be empty
+	Call       string `json:"call,omitempty"`  // Phone number to call, must be verified by the owner of the reservation; may be empty
+}
+
+// Escalation defines how messages published to a reserved topic are escalated if nobody acknowledges them (by replying
+// to or reacting to the message). The steps are executed one after another, each after its delay has passed.
+type Escalation struct {
+	Priority int               `json:"priority"` // Minimum priority (1-5) of messages to escalate
+	Steps    []*EscalationStep `json:"steps"`
+}
+
+// EscalationStep is a single step of an Escalation. Each step republishes the message to another topic, sends it
+// by e-mail, and/or calls a phone number.
+type EscalationStep struct {
+	Delay  time.Duration `json:"delay"`             // Time to wait for an acknowledgement, counted from the previous step
+	Topic  string        `json:"topic,omitempty"`   // Topic to republish the message to, may be empty
+	Email  string        `json:"email,omitempty"`   // E-mail address to send the message to, may be empty
+	Call   string        `json:"call,omitempty"`    // Phone number to call, must be verified by the owner of the reservation; may be empty
+	OnCall bool          `json:"on_call,omitempty"` // Send an e-mail to and call the member of the Schedule who is currently on call
+}
+
+// Schedule is a simple on-call rotation of a reserved topic: starting at Start, each member is on call for the
+// duration of Shift, in the order of Members, after which the rotation starts over.
+type Schedule struct {
+	Start   int64             `json:"start"` // Unix timestamp of the start of the first shift
+	Shift   time.Duration     `json:"shift"`
+	Members []*ScheduleMember `json:"members"`
+}
+
+// ScheduleMember is a member of a Schedule, along with their contact details
+type ScheduleMember struct {
+	Name  string `json:"name"`
+	User  string `json:"user,omitempty"`  // Username of the member, may be empty
+	Email string `json:"email,omitempty"` // E-mail address, may be empty
+	Call  string `json:"call,omitempty"`  // Verified phone number, may be empty
+}
+
+// OnCall returns the member who is on call at the given time, or nil if the schedule has no members
+func (s *Schedule) OnCall(now time.Time) *ScheduleMember {
+	if len(s.Members) == 0 || s.Shift <= 0 {
+		return nil
+	}
+	elapsed := now.Sub(time.Unix(s.Start, 0))
+	shifts := int64(elapsed / s.Shift)
+	if elapsed < 0 && elapsed%s.Shift != 0 {
+		shifts-- // Round towards negative infinity for times before the start
+	}
+	n := int64(len(s.Members))
+	return s.Members[((shifts%n)+n)%n]
+}
+
+// TopicPolicy restricts the messages that can be published to a reserved topic, so that owners of shared topics
+// can enforce some hygiene on their publishers. Zero values mean "no restriction".
+type TopicPolicy struct {
+	MaxMessageSize int   `json:"max_message_size,omitempty"` // Maximum message size in bytes, must not exceed the server's message limit
+	NoAttachments  bool  `json:"no_attachments,omitempty"`   // Reject messages with attachments (uploads and external URLs)
+	Priorities     []int `json:"priorities,omitempty"`       // Allowed message priorities (1-5); if empty, all priorities are allowed
+}
+
+// IsZero returns true if the policy does not restrict anything
+func (p *TopicPolicy) IsZero() bool {
+	return p == nil || (p.MaxMessageSize == 0 && !p.NoAttachments && len(p.Priorities) == 0)
+}
+
+// Group is a named set of users. Access control entries granted to a group apply to all of its members,
+// unless a member has a user-specific entry for the same topic.
+type Group struct {
+	ID      string
+	Name    string
+	Members []string // Usernames
+	Grants  []Grant  // Group-wide access control entries; does not include reservations shared with the group
+}
+
+// InactiveReservation represents a reserved topic that has not seen any activity (publishes
+// or subscribers) since LastActive
+type InactiveReservation struct {
+	Topic      string
+	UserID     string
+	Username   string
+	LastActive time.Time
+	WarnedAt   time.Time // Time the owner was notified that the reservation will be released; zero if not yet warned
 }
 
 // Permission represents a read or write permission to a topic
@@ -169,6 +328,13 @@ const (
 	PermissionReadWrite // 3!
 )
 
+// permissionAuthWrite is a flag that grants write access to authenticated users only
+const permissionAuthWrite Permission = 4
+
+// PermissionAnonReadAuthWrite allows everyone to read, but only authenticated users to write. This is only
+// meaningful for the Everyone user (and as default access), since all other users are authenticated anyway.
+const PermissionAnonReadAuthWrite = PermissionRead | permissionAuthWrite
+
 // NewPermission is a helper to create a Permission based on read/write bool values
 func NewPermission(read, write bool) Permission {
 	p := uint8(0)
@@ -181,6 +347,15 @@ func NewPermission(read, write bool) Permission {
 	return Permission(p)
 }
 
+// newPermissionWithAuthWrite is a helper to create a Permission based on read/write/auth-write bool values
+func newPermissionWithAuthWrite(read, write, authWrite bool) Permission {
+	p := NewPermission(read, write)
+	if authWrite {
+		p |= permissionAuthWrite
+	}
+	return p
+}
+
 // ParsePermission parses the string representation and returns a Permission
 func ParsePermission(s string) (Permission, error) {
 	switch strings.ToLower(s) {
@@ -190,6 +365,8 @@ func ParsePermission(s string) (Permission, error) {
 		return NewPermission(true, false), nil
 	case "write-only", "write", "wo":
 		return NewPermission(false, true), nil
+	case "anon-read-auth-write", "public-read":
+		return PermissionAnonReadAuthWrite, nil
 	case "deny-all", "deny", "none":
 		return NewPermission(false, false), nil
 	default:
@@ -207,6 +384,11 @@ func (p Permission) IsWrite() bool {
 	return p&PermissionWrite != 0
 }
 
+// IsAuthWrite returns true if writable for authenticated users only
+func (p Permission) IsAuthWrite() bool {
+	return p&permissionAuthWrite != 0
+}
+
 // IsReadWrite returns true if readable and writable
 func (p Permission) IsReadWrite() bool {
 	return p.IsRead() && p.IsWrite()
@@ -216,6 +398,8 @@ func (p Permission) IsReadWrite() bool {
 func (p Permission) String() string {
 	if p.IsReadWrite() {
 		return "read-write"
+	} else if p.IsRead() && p.IsAuthWrite() {
+		return "anon-read-auth-write"
 	} else if p.IsRead() {
 		return "read-only"
 	} else if p.IsWrite() {
@@ -241,10 +425,13 @@ const (
 )
 
 var (
-	allowedUsernameRegex     = regexp.MustCompile(`^[-_.@a-zA-Z0-9]+$`)     // Does not include Everyone (*)
-	allowedTopicRegex        = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)  // No '*'
-	allowedTopicPatternRegex = regexp.MustCompile(`^[-_*A-Za-z0-9]{1,64}$`) // Adds '*' for wildcards!
+	allowedUsernameRegex     = regexp.MustCompile(`^[-_.@a-zA-Z0-9]+$`)      // Does not include Everyone (*)
+	allowedTopicRegex        = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)   // No '*'
+	allowedTopicPatternRegex = regexp.MustCompile(`^[-_*A-Za-z0-9]{1,64}$`)  // Adds '*' for wildcards!
+	allowedTopicPrefixRegex  = regexp.MustCompile(`^[-_A-Za-z0-9]{6,63}\*$`) // Prefix reservations, e.g. "myteam-*"
 	allowedTierRegex         = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+	allowedGroupRegex        = regexp.MustCompile(`^[-_.a-zA-Z0-9]{1,64}$`)
+	allowedTokenHashRegex    = regexp.MustCompile(`^[0-9a-f]{64}$`) // Hex-encoded SHA-256, see HashToken
 )
 
 // AllowedRole returns true if the given role can be used for new users
@@ -267,21 +454,183 @@ func AllowedTopicPattern(topic string) bool {
 	return allowedTopicPatternRegex.MatchString(topic)
 }
 
+// AllowedTopicPrefix returns true if the given topic prefix can be reserved. A prefix must end with a
+// single wildcard character (*), and must be at least six characters long (e.g. "myteam-*"). Short prefixes
+// (e.g. "abc*") would let a single user take over a large share of all possible topic names.
+func AllowedTopicPrefix(topic string) bool {
+	return allowedTopicPrefixRegex.MatchString(topic)
+}
+
+// AllowedReservation returns true if the given topic or topic prefix can be reserved
+func AllowedReservation(topic string) bool {
+	return AllowedTopic(topic) || AllowedTopicPrefix(topic)
+}
+
+// AllowedTokenHash returns true if the given string is a valid token hash, see HashToken
+func AllowedTokenHash(hash string) bool {
+	return allowedTokenHashRegex.MatchString(hash)
+}
+
 // AllowedTier returns true if the given tier name is valid
 func AllowedTier(tier string) bool {
 	return allowedTierRegex.MatchString(tier)
 }
 
+// AllowedGroup returns true if the given group name is valid
+func AllowedGroup(group string) bool {
+	return allowedGroupRegex.MatchString(group)
+}
+
+// ParseAllowedIPs parses a list of IP ranges (e.g. 10.0.1.0/24) or single IP addresses, as used in Token.AllowedIPs
+func ParseAllowedIPs(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if prefix, err := netip.ParsePrefix(value); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		ip, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or range %s", value)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// parseAllowedIPs parses the comma-separated list of IP ranges stored in the user_token table
+func parseAllowedIPs(s string) ([]netip.Prefix, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return ParseAllowedIPs(strings.Split(s, ","))
+}
+
+// formatAllowedIPs formats IP ranges as a comma-separated list, as stored in the user_token table
+func formatAllowedIPs(prefixes []netip.Prefix) string {
+	values := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		values[i] = prefix.String()
+	}
+	return strings.Join(values, ",")
+}
+
+// Changes is a set of changed users, groups and tiers, as returned by Manager.Changes, and applied to another user
+// database by Manager.ApplyChanges. It is used to replicate users (including their access control entries, tokens
+// and publish keys), groups and tiers to a standby server.
+type Changes struct {
+	Position int64          `json:"position"`       // ID of the last change included in this set
+	Full     bool           `json:"full,omitempty"` // If true, Users, Groups and Tiers contain everything, and everything else was removed
+	Users    []*UserChange  `json:"users"`
+	Groups   []*GroupChange `json:"groups,omitempty"`
+	Tiers    []*TierChange  `json:"tiers,omitempty"`
+}
+
+// UserChange is the current state of a user that was added, changed or removed
+type UserChange struct {
+	Name        string                  `json:"name"`
+	Deleted     bool                    `json:"deleted,omitempty"` // If true, all other fields are empty
+	ID          string                  `json:"id,omitempty"`
+	Hash        string                  `json:"hash,omitempty"` // password hash (bcrypt)
+	Role        Role                    `json:"role,omitempty"`
+	Tier        string                  `json:"tier,omitempty"` // Tier code
+	SyncTopic   string                  `json:"sync_topic,omitempty"`
+	Disabled    bool                    `json:"disabled,omitempty"`
+	Provisioned bool                    `json:"provisioned,omitempty"`
+	Access      []*UserAccessChange     `json:"access,omitempty"`
+	Tokens      []*UserTokenChange      `json:"tokens,omitempty"`
+	PublishKeys []*UserPublishKeyChange `json:"publish_keys,omitempty"`
+}
+
+// UserAccessChange is an access control entry of a UserChange
+type UserAccessChange struct {
+	Topic       string `json:"topic"` // Topic pattern as stored in the database, i.e. with escaped underscores
+	Read        bool   `json:"read,omitempty"`
+	Write       bool   `json:"write,omitempty"`
+	AuthWrite   bool   `json:"auth_write,omitempty"`
+	Owner       string `json:"owner,omitempty"` // Username of the owner, if this entry belongs to a reservation
+	Sound       string `json:"sound,omitempty"`
+	Routing     string `json:"routing,omitempty"`    // Routing rules as stored in the database (JSON), see RoutingRule
+	Escalation  string `json:"escalation,omitempty"` // Escalation policy as stored in the database (JSON), see Escalation
+	Schedule    string `json:"schedule,omitempty"`   // On-call schedule as stored in the database (JSON), see Schedule
+	Policy      string `json:"policy,omitempty"`     // Topic policy as stored in the database (JSON), see TopicPolicy
+	Provisioned bool   `json:"provisioned,omitempty"`
+}
+
+// UserTokenChange is an access token of a UserChange
+type UserTokenChange struct {
+	Token        string `json:"token"`
+	Label        string `json:"label,omitempty"`
+	Expires      int64  `json:"expires,omitempty"` // Unix timestamp, 0 means never
+	Provisioned  bool   `json:"provisioned,omitempty"`
+	AllowedIPs   string `json:"allowed_ips,omitempty"` // Comma-separated list of IP ranges, as stored in the database
+	Impersonator string `json:"impersonator,omitempty"`
+}
+
+// UserPublishKeyChange is a publish key of a UserChange, see PublishKey
+type UserPublishKeyChange struct {
+	Topic       string `json:"topic"`
+	Key         string `json:"key"`
+	Label       string `json:"label,omitempty"`
+	Created     int64  `json:"created"`
+	TitlePrefix string `json:"title_prefix,omitempty"`
+	Priority    int    `json:"priority,omitempty"`
+	Tags        string `json:"tags,omitempty"` // Comma-separated list of tags, as stored in the database
+}
+
+// GroupChange is the current state of a group that was added, changed or removed
+type GroupChange struct {
+	Name    string               `json:"name"`
+	Deleted bool                 `json:"deleted,omitempty"` // If true, all other fields are empty
+	ID      string               `json:"id,omitempty"`
+	Members []string             `json:"members,omitempty"` // Usernames
+	Access  []*GroupAccessChange `json:"access,omitempty"`
+}
+
+// GroupAccessChange is an access control entry of a GroupChange
+type GroupAccessChange struct {
+	Topic string `json:"topic"` // Topic pattern as stored in the database, i.e. with escaped underscores
+	Read  bool   `json:"read,omitempty"`
+	Write bool   `json:"write,omitempty"`
+	Owner string `json:"owner,omitempty"` // Username of the owner, if this entry is a reservation shared with the group
+}
+
+// TierChange is the current state of a tier that was added, changed or removed, see Tier
+type TierChange struct {
+	Code                     string `json:"code"`
+	Deleted                  bool   `json:"deleted,omitempty"` // If true, all other fields are empty
+	ID                       string `json:"id,omitempty"`
+	Name                     string `json:"name,omitempty"`
+	MessageLimit             int64  `json:"message_limit,omitempty"`
+	MessageExpiryDuration    int64  `json:"message_expiry_duration,omitempty"` // Seconds
+	EmailLimit               int64  `json:"email_limit,omitempty"`
+	CallLimit                int64  `json:"call_limit,omitempty"`
+	ReservationLimit         int64  `json:"reservation_limit,omitempty"`
+	AttachmentFileSizeLimit  int64  `json:"attachment_file_size_limit,omitempty"`
+	AttachmentTotalSizeLimit int64  `json:"attachment_total_size_limit,omitempty"`
+	AttachmentExpiryDuration int64  `json:"attachment_expiry_duration,omitempty"` // Seconds
+	AttachmentBandwidthLimit int64  `json:"attachment_bandwidth_limit,omitempty"`
+	MessageSizeLimit         int64  `json:"message_size_limit,omitempty"`
+	StripeMonthlyPriceID     string `json:"stripe_monthly_price_id,omitempty"`
+	StripeYearlyPriceID      string `json:"stripe_yearly_price_id,omitempty"`
+	Provisioned              bool   `json:"provisioned,omitempty"`
+}
+
 // Error constants used by the package
 var (
-	ErrUnauthenticated     = errors.New("unauthenticated")
-	ErrUnauthorized        = errors.New("unauthorized")
-	ErrInvalidArgument     = errors.New("invalid argument")
-	ErrUserNotFound        = errors.New("user not found")
-	ErrUserExists          = errors.New("user already exists")
-	ErrTierNotFound        = errors.New("tier not found")
-	ErrTokenNotFound       = errors.New("token not found")
-	ErrPhoneNumberNotFound = errors.New("phone number not found")
-	ErrTooManyReservations = errors.New("new tier has lower reservation limit")
-	ErrPhoneNumberExists   = errors.New("phone number already exists")
+	ErrUnauthenticated       = errors.New("unauthenticated")
+	ErrUnauthorized          = errors.New("unauthorized")
+	ErrInvalidArgument       = errors.New("invalid argument")
+	ErrUserNotFound          = errors.New("user not found")
+	ErrUserExists            = errors.New("user already exists")
+	ErrTierNotFound          = errors.New("tier not found")
+	ErrTokenNotFound         = errors.New("token not found")
+	ErrTokenOriginNotAllowed = errors.New("token not allowed from this IP address")
+	ErrPhoneNumberNotFound   = errors.New("phone number not found")
+	ErrTooManyReservations   = errors.New("new tier has lower reservation limit")
+	ErrPhoneNumberExists     = errors.New("phone number already exists")
+	ErrTooManyPublishKeys    = errors.New("too many publish keys for topic")
+	ErrGroupNotFound         = errors.New("group not found")
+	ErrGroupExists           = errors.New("group already exists")
 )
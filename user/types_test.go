@@ -40,6 +40,14 @@ func TestParsePermission(t *testing.T) {
 	p, err = ParsePermission("deny-all")
 	require.Nil(t, err)
 	require.Equal(t, PermissionDenyAll, p)
+
+	p, err = ParsePermission("public-read")
+	require.Nil(t, err)
+	require.Equal(t, PermissionAnonReadAuthWrite, p)
+	require.True(t, p.IsRead())
+	require.False(t, p.IsWrite())
+	require.True(t, p.IsAuthWrite())
+	require.Equal(t, "anon-read-auth-write", p.String())
 }
 
 func TestAllowedTier(t *testing.T) {
@@ -29,9 +29,10 @@ type Limiter interface {
 // FixedLimiter is a helper that allows adding values up to a well-defined limit. Once the limit is reached
 // ErrLimitReached will be returned. FixedLimiter may be used by multiple goroutines.
 type FixedLimiter struct {
-	value int64
-	limit int64
-	mu    sync.Mutex
+	value    int64
+	limit    int64
+	rejected bool
+	mu       sync.Mutex
 }
 
 var _ Limiter = (*FixedLimiter)(nil)
@@ -61,6 +62,7 @@ func (l *FixedLimiter) AllowN(n int64) bool {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if l.value+n > l.limit {
+		l.rejected = true
 		return false
 	}
 	l.value += n
@@ -74,11 +76,20 @@ func (l *FixedLimiter) Value() int64 {
 	return l.value
 }
 
+// Rejected returns true if the limiter denied a value since it was created or last reset. This is useful to
+// tell which of multiple limiters passed to a LimitWriter caused ErrLimitReached.
+func (l *FixedLimiter) Rejected() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rejected
+}
+
 // Reset sets the limiter's value back to zero
 func (l *FixedLimiter) Reset() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.value = 0
+	l.rejected = false
 }
 
 // RateLimiter is a Limiter that wraps a rate.Limiter, allowing a floating time-based limit.
@@ -147,3 +147,19 @@ func TestLimitWriter_WriteTwoDifferentLimiters_Wait_FixedLimiterFail(t *testing.
 	_, err = lw.Write(make([]byte, 8)) // <<< FixedLimiter fails
 	require.Equal(t, ErrLimitReached, err)
 }
+
+func TestLimitWriter_WriteTwoFixedLimiters_Rejected(t *testing.T) {
+	var buf bytes.Buffer
+	l1 := NewFixedLimiter(20)
+	l2 := NewFixedLimiter(10) // <<< This fails below
+	lw := NewLimitWriter(&buf, l1, l2)
+	_, err := lw.Write(make([]byte, 8))
+	require.Nil(t, err)
+	_, err = lw.Write(make([]byte, 8))
+	require.Equal(t, ErrLimitReached, err)
+	require.False(t, l1.Rejected())
+	require.True(t, l2.Rejected())
+	require.Equal(t, int64(8), l1.Value())
+	l2.Reset()
+	require.False(t, l2.Rejected())
+}
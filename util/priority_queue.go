@@ -0,0 +1,130 @@
+package util
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// PriorityQueue is a bounded queue that dequeues the elements with the highest priority first.
+// Elements with the same priority are dequeued in the order in which they were enqueued. Dequeue
+// blocks until an element is available, so the queue can be used to feed a pool of workers.
+//
+// Example:
+//
+//	q := NewPriorityQueue[string](100)
+//	go func() {
+//	  for {
+//	    s, ok := q.Dequeue()
+//	    if !ok {
+//	      return // Queue closed
+//	    }
+//	    fmt.Println(s)
+//	  }
+//	}()
+//	q.Enqueue("bulk", 1)
+//	q.Enqueue("urgent", 5)
+type PriorityQueue[T any] struct {
+	maxSize int
+	items   priorityQueueItems[T]
+	seq     uint64
+	closed  bool
+	cond    *sync.Cond
+	mu      sync.Mutex
+}
+
+// NewPriorityQueue creates a new PriorityQueue that holds at most maxSize elements
+func NewPriorityQueue[T any](maxSize int) *PriorityQueue[T] {
+	q := &PriorityQueue[T]{
+		maxSize: maxSize,
+		items:   make(priorityQueueItems[T], 0),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Enqueue adds an element with the given priority to the queue. It returns false if the queue
+// is full or closed, in which case the element is not added.
+func (q *PriorityQueue[T]) Enqueue(element T, priority int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || len(q.items) >= q.maxSize {
+		return false
+	}
+	heap.Push(&q.items, &priorityQueueItem[T]{
+		element:  element,
+		priority: priority,
+		seq:      q.seq,
+	})
+	q.seq++
+	q.cond.Signal()
+	return true
+}
+
+// Dequeue removes and returns the element with the highest priority. It blocks until an element
+// is available. If the queue is closed, it returns false.
+func (q *PriorityQueue[T]) Dequeue() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		var zero T
+		return zero, false
+	}
+	item := heap.Pop(&q.items).(*priorityQueueItem[T])
+	return item.element, true
+}
+
+// Len returns the number of elements in the queue
+func (q *PriorityQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// Close closes the queue, discarding all queued elements, and unblocks all waiting Dequeue calls
+func (q *PriorityQueue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.items = nil
+	q.cond.Broadcast()
+}
+
+type priorityQueueItem[T any] struct {
+	element  T
+	priority int
+	seq      uint64
+}
+
+// priorityQueueItems implements heap.Interface
+type priorityQueueItems[T any] []*priorityQueueItem[T]
+
+func (p priorityQueueItems[T]) Len() int {
+	return len(p)
+}
+
+func (p priorityQueueItems[T]) Less(i, j int) bool {
+	if p[i].priority == p[j].priority {
+		return p[i].seq < p[j].seq
+	}
+	return p[i].priority > p[j].priority
+}
+
+func (p priorityQueueItems[T]) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
+
+func (p *priorityQueueItems[T]) Push(x any) {
+	*p = append(*p, x.(*priorityQueueItem[T]))
+}
+
+func (p *priorityQueueItems[T]) Pop() any {
+	old := *p
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*p = old[:n-1]
+	return item
+}
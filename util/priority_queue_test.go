@@ -0,0 +1,62 @@
+package util_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityQueue_Order(t *testing.T) {
+	q := util.NewPriorityQueue[string](10)
+	require.True(t, q.Enqueue("bulk 1", 1))
+	require.True(t, q.Enqueue("default 1", 3))
+	require.True(t, q.Enqueue("bulk 2", 1))
+	require.True(t, q.Enqueue("urgent", 5))
+	require.True(t, q.Enqueue("default 2", 3))
+	require.Equal(t, 5, q.Len())
+
+	expected := []string{"urgent", "default 1", "default 2", "bulk 1", "bulk 2"}
+	for _, e := range expected {
+		s, ok := q.Dequeue()
+		require.True(t, ok)
+		require.Equal(t, e, s)
+	}
+	require.Equal(t, 0, q.Len())
+}
+
+func TestPriorityQueue_Full(t *testing.T) {
+	q := util.NewPriorityQueue[int](2)
+	require.True(t, q.Enqueue(1, 1))
+	require.True(t, q.Enqueue(2, 1))
+	require.False(t, q.Enqueue(3, 5))
+	require.Equal(t, 2, q.Len())
+}
+
+func TestPriorityQueue_DequeueBlocksAndClose(t *testing.T) {
+	q := util.NewPriorityQueue[int](10)
+	var wg sync.WaitGroup
+	results := make(chan int, 10)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n, ok := q.Dequeue()
+				if !ok {
+					return
+				}
+				results <- n
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	require.True(t, q.Enqueue(1, 1))
+	require.True(t, q.Enqueue(2, 1))
+	require.Equal(t, 1+2, <-results+<-results)
+
+	q.Close()
+	wg.Wait() // All workers return
+	require.False(t, q.Enqueue(3, 1))
+}
@@ -54,6 +54,22 @@ func ParseFutureTime(s string, now time.Time) (time.Time, error) {
 	return time.Time{}, errUnparsableTime
 }
 
+// ParsePastTime parses a date/time string in the past to a time.Time. It supports unix timestamps,
+// durations (e.g. "7d" means 7 days ago), dates (e.g. "2024-01-01", midnight UTC) and RFC3339 times
+func ParsePastTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(t, 0).UTC(), nil
+	} else if d, err := ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	} else if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	} else if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errUnparsableTime
+}
+
 // ParseDuration is like time.ParseDuration, except that it also understands days (d), which
 // translates to 24 hours, e.g. "2d" or "20h".
 func ParseDuration(s string) (time.Duration, error) {
@@ -92,3 +92,25 @@ func TestParseDuration(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, time.Duration(0), d)
 }
+
+func TestParsePastTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	d, err := ParsePastTime("2024-01-01", now)
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), d)
+
+	d, err = ParsePastTime("7d", now)
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC), d)
+
+	d, err = ParsePastTime("1704067200", now)
+	require.Nil(t, err)
+	require.Equal(t, int64(1704067200), d.Unix())
+
+	d, err = ParsePastTime("2024-02-01T10:00:00+01:00", now)
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC), d.UTC())
+
+	_, err = ParsePastTime("last tuesday", now)
+	require.Equal(t, errUnparsableTime, err)
+}
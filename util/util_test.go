@@ -266,3 +266,19 @@ func TestMaybeMarshalJSON(t *testing.T) {
 	require.Equal(t, `"`+strings.Repeat("x", 4999), MaybeMarshalJSON(strings.Repeat("x", 6000)))
 
 }
+
+func TestParseIPHostPrefix(t *testing.T) {
+	cases := map[string]string{
+		"1.1.1.1":          "1.1.1.1/32",
+		"fd00::1234":       "fd00::1234/128",
+		"192.168.0.3/24":   "192.168.0.0/24",
+		"10.1.2.3/8":       "10.0.0.0/8",
+		"201:be93::4a6/21": "201:b800::/21",
+	}
+	for q, expectedAnswer := range cases {
+		ips, err := ParseIPHostPrefix(q)
+		require.Nil(t, err)
+		require.Equal(t, 1, len(ips))
+		require.Equal(t, expectedAnswer, ips[0].String())
+	}
+}
@@ -68,14 +68,31 @@ const handlePushMessage = async (data) => {
 const handlePushSubscriptionExpiring = async (data) => {
   const t = await initI18n();
 
-  await self.registration.showNotification(t("web_push_subscription_expiring_title"), {
-    body: t("web_push_subscription_expiring_body"),
+  // The server includes the text if the user chose a language in the account settings
+  await self.registration.showNotification(data.title ?? t("web_push_subscription_expiring_title"), {
+    body: data.body ?? t("web_push_subscription_expiring_body"),
     icon,
     data,
     badge,
   });
 };
 
+/**
+ * Handle a silent ping (if the server uses the "ping" keepalive strategy), by renewing the subscription.
+ * Unlike the expiry warning, no notification is shown. If the subscription is not renewed, the server removes it.
+ */
+const handlePushSubscriptionPing = async () => {
+  const subscription = await self.registration.pushManager.getSubscription();
+  if (!subscription) {
+    return;
+  }
+  const response = await fetch(new URL("/v1/webpush/renew", self.location.origin), {
+    method: "POST",
+    body: JSON.stringify({ endpoint: subscription.endpoint }),
+  });
+  console.log("[ServiceWorker] Renewed web push subscription", { status: response.status });
+};
+
 /**
  * Handle unknown push message. We can't ignore the push, since
  * permission can be revoked by the browser.
@@ -100,6 +117,8 @@ const handlePush = async (data) => {
     await handlePushMessage(data);
   } else if (data.event === "subscription_expiring") {
     await handlePushSubscriptionExpiring(data);
+  } else if (data.event === "subscription_ping") {
+    await handlePushSubscriptionPing();
   } else {
     await handlePushUnknown(data);
   }
@@ -15,6 +15,7 @@ import {
   tiersUrl,
   withBasicAuth,
   withBearerAuth,
+  withCsrfToken,
 } from "./utils";
 import session from "./Session";
 import subscriptionManager from "./SubscriptionManager";
@@ -72,6 +73,7 @@ class AccountApi {
     console.log(`[AccountApi] Creating user account ${url}`);
     await fetchOrThrow(url, {
       method: "POST",
+      headers: withCsrfToken({}),
       body,
     });
   }
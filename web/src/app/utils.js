@@ -70,11 +70,24 @@ export const basicAuth = (username, password) => `Basic ${encodeBase64(`${userna
 
 export const withBearerAuth = (headers, token) => ({ ...headers, Authorization: bearerAuth(token) });
 
+// Adds the CSRF token header, if the server handed out a CSRF token cookie (see "csrf-protection" server option).
+// Requests with an Authorization header do not need it, but anonymous requests (e.g. publishing, or signup) do.
+export const withCsrfToken = (headers) => {
+  const token = document.cookie
+    .split("; ")
+    .find((cookie) => cookie.startsWith("ntfy-csrf="))
+    ?.substring("ntfy-csrf=".length);
+  if (token) {
+    return { ...headers, "X-CSRF-Token": token };
+  }
+  return headers;
+};
+
 export const maybeWithBearerAuth = (headers, token) => {
   if (token) {
     return withBearerAuth(headers, token);
   }
-  return headers;
+  return withCsrfToken(headers);
 };
 
 export const withBasicAuth = (headers, username, password) => ({ ...headers, Authorization: basicAuth(username, password) });
@@ -86,7 +99,7 @@ export const maybeWithAuth = (headers, user) => {
   if (user?.token) {
     return withBearerAuth(headers, user.token);
   }
-  return headers;
+  return withCsrfToken(headers);
 };
 
 export const maybeActionErrors = (notification) => {